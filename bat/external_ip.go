@@ -72,7 +72,7 @@ func UnmapExternalIP(ctx context.Context, tenant, address string) error {
 // environment variables are not set; CIAO_ADMIN_CLIENT_CERT_FILE,
 // CIAO_CONTROLLER.
 func DeleteExternalIPPool(ctx context.Context, tenant, name string) error {
-	args := []string{"delete", "pool", name}
+	args := []string{"delete", "pool", "--yes", name}
 	_, err := RunCIAOCmdAsAdmin(ctx, tenant, args)
 	return err
 }
@@ -105,7 +105,7 @@ func GetTenantConfig(ctx context.Context, ID string) (TenantConfig, error) {
 // DeleteTenant will delete the given tenant.
 // It calls ciao-cli tenant delete.
 func DeleteTenant(ctx context.Context, ID string) error {
-	args := []string{"delete", "tenant", ID}
+	args := []string{"delete", "tenant", "--yes", ID}
 	_, err := RunCIAOCmdAsAdmin(ctx, "", args)
 
 	return err
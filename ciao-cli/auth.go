@@ -0,0 +1,140 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+)
+
+var authCommand = &command{
+	SubCommands: map[string]subCommand{
+		"lockouts": new(authLockoutsCommand),
+		"unlock":   new(authUnlockCommand),
+	},
+}
+
+type authLockoutsCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *authLockoutsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] auth lockouts [flags]
+
+List the client addresses and accounts which recently failed to
+authenticate, and until when they are locked out
+
+The lockouts flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.AuthLockout{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+
+	os.Exit(2)
+}
+
+func (cmd *authLockoutsCommand) parseArgs(args []string) []string {
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *authLockoutsCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Listing authentication lockouts is only available for privileged users")
+	}
+
+	lockouts, err := c.ListAuthLockouts()
+	if err != nil {
+		return errors.Wrap(err, "Error listing authentication lockouts")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("auth-lockouts", cmd.template, lockouts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "ID\tFailures\tLast failure\tLocked until\n")
+	for _, l := range lockouts {
+		lockedUntil := "-"
+		if l.LockedUntil.After(l.LastFailure) {
+			lockedUntil = l.LockedUntil.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", l.ID, l.Failures,
+			l.LastFailure.Format("2006-01-02 15:04:05"), lockedUntil)
+	}
+	w.Flush()
+
+	return nil
+}
+
+type authUnlockCommand struct {
+	Flag flag.FlagSet
+	ID   string
+}
+
+func (cmd *authUnlockCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] auth unlock [flags]
+
+Lift the lockout of a client address or account and forget its failed
+authentications
+
+The unlock flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *authUnlockCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.ID, "id", "", "ID of the lockout, e.g. source:192.168.0.10 or account:bob")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *authUnlockCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Clearing authentication lockouts is only available for privileged users")
+	}
+
+	if cmd.ID == "" {
+		return errors.New("Missing required -id parameter")
+	}
+
+	err := c.ClearAuthLockout(cmd.ID)
+	if err != nil {
+		return errors.Wrap(err, "Error clearing authentication lockout")
+	}
+
+	fmt.Printf("Cleared lockout %s\n", cmd.ID)
+
+	return nil
+}
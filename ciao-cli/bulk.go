@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// instanceSelection holds the flags selecting the instances a bulk
+// operation applies to, and how many of them are handled at a time.
+type instanceSelection struct {
+	instance string
+	tags     string
+	parallel int
+}
+
+func (s *instanceSelection) addFlags(f *flag.FlagSet) {
+	f.StringVar(&s.instance, "instance", "", "Instance UUID, more can be given as arguments")
+	f.StringVar(&s.tags, "tags", "", "Comma separated tags selecting the instances having all of them")
+	f.IntVar(&s.parallel, "parallel", 1, "Number of instances handled concurrently")
+}
+
+func (s *instanceSelection) empty(args []string) bool {
+	return s.instance == "" && s.tags == "" && len(args) == 0
+}
+
+// instances returns the UUIDs of the instances given by -instance and as
+// arguments, followed by those of the instances of the tenant having all
+// the tags given by -tags.
+func (s *instanceSelection) instances(args []string) ([]string, error) {
+	var IDs []string
+	seen := make(map[string]bool)
+
+	add := func(ID string) {
+		if !seen[ID] {
+			seen[ID] = true
+			IDs = append(IDs, ID)
+		}
+	}
+
+	if s.instance != "" {
+		add(s.instance)
+	}
+	for _, ID := range args {
+		add(ID)
+	}
+
+	tags := splitList(s.tags)
+	if len(tags) == 0 {
+		return IDs, nil
+	}
+
+	servers, err := c.ListInstancesByWorkload(c.TenantID, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing instances")
+	}
+
+	var selected []string
+	for _, server := range servers.Servers {
+		if hasTags(server.Tags, tags) {
+			selected = append(selected, server.ID)
+		}
+	}
+	sort.Strings(selected)
+
+	for _, ID := range selected {
+		add(ID)
+	}
+
+	if len(IDs) == 0 {
+		return nil, fmt.Errorf("No instances have tags %s", strings.Join(tags, ", "))
+	}
+
+	return IDs, nil
+}
+
+func hasTags(tags []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// applyParallel calls op for each of the instances, at most parallel at a
+// time. All the instances are handled whatever the failures, which are
+// reported together in the error returned.
+func applyParallel(IDs []string, parallel int, op func(string) error) error {
+	if parallel < 1 {
+		return errors.New("-parallel must be at least 1")
+	}
+
+	errs := make([]error, len(IDs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, ID := range IDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ID string) {
+			defer wg.Done()
+			errs[i] = op(ID)
+			<-sem
+		}(i, ID)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("\t%s: %v", IDs[i], err))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if len(IDs) == 1 {
+		return errs[0]
+	}
+
+	return fmt.Errorf("%d of %d instances failed:\n%s", len(failed), len(IDs), strings.Join(failed, "\n"))
+}
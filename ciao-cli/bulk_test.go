@@ -0,0 +1,326 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/client"
+)
+
+func TestApplyParallel(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name     string
+		IDs      []string
+		parallel int
+		failing  map[string]bool
+		err      string
+	}{
+		{
+			name:     "no instances",
+			IDs:      nil,
+			parallel: 1,
+		},
+		{
+			name:     "all succeed",
+			IDs:      []string{"a", "b", "c"},
+			parallel: 2,
+		},
+		{
+			name:     "single failure",
+			IDs:      []string{"a"},
+			parallel: 1,
+			failing:  map[string]bool{"a": true},
+			err:      "failed",
+		},
+		{
+			name:     "partial failure",
+			IDs:      []string{"a", "b", "c", "d"},
+			parallel: 4,
+			failing:  map[string]bool{"b": true, "d": true},
+			err:      "2 of 4 instances failed:\n\tb: failed\n\td: failed",
+		},
+		{
+			name:     "all fail",
+			IDs:      []string{"a", "b"},
+			parallel: 1,
+			failing:  map[string]bool{"a": true, "b": true},
+			err:      "2 of 2 instances failed:\n\ta: failed\n\tb: failed",
+		},
+		{
+			name:     "parallel too small",
+			IDs:      []string{"a"},
+			parallel: 0,
+			err:      "-parallel must be at least 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			called := make(map[string]int)
+
+			err := applyParallel(test.IDs, test.parallel, func(ID string) error {
+				lock.Lock()
+				called[ID]++
+				lock.Unlock()
+
+				if test.failing[ID] {
+					return errFailed
+				}
+				return nil
+			})
+
+			if test.err == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.parallel < 1 {
+				if len(called) != 0 {
+					t.Errorf("Expected no instances handled, got %v", called)
+				}
+				return
+			}
+
+			for _, ID := range test.IDs {
+				if called[ID] != 1 {
+					t.Errorf("Expected %s handled once, got %d", ID, called[ID])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyParallelBound(t *testing.T) {
+	IDs := []string{"a", "b", "c", "d", "e", "f", "g"}
+
+	for _, parallel := range []int{1, 3, len(IDs), len(IDs) + 2} {
+		t.Run(fmt.Sprintf("parallel %d", parallel), func(t *testing.T) {
+			expected := parallel
+			if expected > len(IDs) {
+				expected = len(IDs)
+			}
+
+			var running, maxRunning int32
+			release := make(chan struct{})
+			errCh := make(chan error)
+
+			go func() {
+				errCh <- applyParallel(IDs, parallel, func(ID string) error {
+					r := atomic.AddInt32(&running, 1)
+					defer atomic.AddInt32(&running, -1)
+
+					for {
+						m := atomic.LoadInt32(&maxRunning)
+						if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+							break
+						}
+					}
+
+					<-release
+					return nil
+				})
+			}()
+
+			// Hold the instances until as many as allowed are running,
+			// giving any extra ones the time to start too.
+			deadline := time.Now().Add(10 * time.Second)
+			for atomic.LoadInt32(&running) < int32(expected) {
+				if time.Now().After(deadline) {
+					close(release)
+					t.Fatalf("Expected %d concurrent instances, got %d",
+						expected, atomic.LoadInt32(&running))
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+
+			if err := <-errCh; err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if maxRunning != int32(expected) {
+				t.Errorf("Expected at most %d concurrent instances, got %d", expected, maxRunning)
+			}
+		})
+	}
+}
+
+func TestInstanceSelectionFlags(t *testing.T) {
+	var s instanceSelection
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	s.addFlags(fs)
+
+	if err := fs.Parse(nil); err != nil {
+		t.Fatalf("Unable to parse flags: %v", err)
+	}
+	if s.parallel != 1 {
+		t.Errorf("Expected default parallel 1, got %d", s.parallel)
+	}
+	if !s.empty(fs.Args()) {
+		t.Errorf("Expected empty selection by default")
+	}
+
+	err := fs.Parse([]string{"-instance", "a", "-tags", "web,db", "-parallel", "4", "b"})
+	if err != nil {
+		t.Fatalf("Unable to parse flags: %v", err)
+	}
+	expected := instanceSelection{instance: "a", tags: "web,db", parallel: 4}
+	if s != expected {
+		t.Errorf("Expected selection %+v, got %+v", expected, s)
+	}
+	if !reflect.DeepEqual(fs.Args(), []string{"b"}) {
+		t.Errorf("Unexpected arguments %v", fs.Args())
+	}
+}
+
+func TestInstanceSelectionEmpty(t *testing.T) {
+	tests := []struct {
+		s     instanceSelection
+		args  []string
+		empty bool
+	}{
+		{instanceSelection{}, nil, true},
+		{instanceSelection{parallel: 4}, nil, true},
+		{instanceSelection{instance: "a"}, nil, false},
+		{instanceSelection{tags: "web"}, nil, false},
+		{instanceSelection{}, []string{"a"}, false},
+	}
+
+	for _, test := range tests {
+		if empty := test.s.empty(test.args); empty != test.empty {
+			t.Errorf("Expected empty %v for %+v %v, got %v", test.empty, test.s, test.args, empty)
+		}
+	}
+}
+
+func TestInstanceSelectionInstances(t *testing.T) {
+	servers := api.Servers{
+		Servers: []api.ServerDetails{
+			{ID: "web-2", Tags: []string{"web", "prod"}},
+			{ID: "db-1", Tags: []string{"db", "prod"}},
+			{ID: "web-1", Tags: []string{"prod", "web"}},
+			{ID: "web-3", Tags: []string{"web"}},
+			{ID: "untagged"},
+		},
+	}
+
+	var listed int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&listed, 1)
+		if r.URL.Path != "/tenant/instances/detail" {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", api.InstancesV1)
+		_ = json.NewEncoder(w).Encode(servers)
+	}))
+	defer ts.Close()
+
+	oldClient := c
+	defer func() { c = oldClient }()
+	c = client.Client{ControllerURL: ts.URL, TenantID: "tenant"}
+
+	tests := []struct {
+		name   string
+		s      instanceSelection
+		args   []string
+		IDs    []string
+		err    bool
+		listed bool
+	}{
+		{
+			name: "nothing selected",
+		},
+		{
+			name: "instance first",
+			s:    instanceSelection{instance: "b"},
+			args: []string{"a", "b", "c", "a"},
+			IDs:  []string{"b", "a", "c"},
+		},
+		{
+			name:   "tags sorted",
+			s:      instanceSelection{tags: "web, prod"},
+			IDs:    []string{"web-1", "web-2"},
+			listed: true,
+		},
+		{
+			name:   "tags after arguments",
+			s:      instanceSelection{instance: "web-2", tags: "prod"},
+			args:   []string{"x"},
+			IDs:    []string{"web-2", "x", "db-1", "web-1"},
+			listed: true,
+		},
+		{
+			name:   "no instance tagged",
+			s:      instanceSelection{tags: "cache"},
+			err:    true,
+			listed: true,
+		},
+		{
+			name:   "arguments without tagged instances",
+			s:      instanceSelection{tags: "cache"},
+			args:   []string{"a"},
+			IDs:    []string{"a"},
+			listed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&listed, 0)
+
+			IDs, err := test.s.instances(test.args)
+			if test.err {
+				if err == nil {
+					t.Fatalf("Expected error, got instances %v", IDs)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(IDs, test.IDs) {
+				t.Errorf("Expected instances %v, got %v", test.IDs, IDs)
+			}
+
+			if (atomic.LoadInt32(&listed) != 0) != test.listed {
+				t.Errorf("Expected instances listed %v, got %d requests", test.listed, atomic.LoadInt32(&listed))
+			}
+		})
+	}
+
+	ts.Close()
+	if _, err := (&instanceSelection{tags: "web"}).instances(nil); err == nil {
+		t.Errorf("Expected error when instances cannot be listed")
+	}
+}
@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+)
+
+var configCommand = &command{
+	SubCommands: map[string]subCommand{
+		"show":   new(configShowCommand),
+		"update": new(configUpdateCommand),
+	},
+}
+
+type configShowCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *configShowCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] config show [flags]
+
+Show the settings of the cluster configuration
+
+The show flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a 
+
+%s`,
+		tfortools.GenerateUsageUndecorated(types.ConfigResponse{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+
+	os.Exit(2)
+}
+
+func (cmd *configShowCommand) parseArgs(args []string) []string {
+	cmd.Flag.Usage = func() { cmd.usage() }
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *configShowCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Showing the configuration is only available for privileged users")
+	}
+
+	config, err := c.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "Error getting configuration")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("config-show", cmd.template, config)
+	}
+
+	for _, s := range config.Settings {
+		fmt.Printf("%s: %s (default %s)\n", s.Key, s.Value, s.Schema.Default)
+		fmt.Printf("\t%s\n", s.Schema.Description)
+	}
+
+	return nil
+}
+
+type configUpdateCommand struct {
+	Flag  flag.FlagSet
+	key   string
+	value string
+	reset bool
+}
+
+func (cmd *configUpdateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] config update [flags]
+
+Changes a setting of the cluster configuration
+
+The update flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *configUpdateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.key, "key", "", "Name of the setting")
+	cmd.Flag.StringVar(&cmd.value, "value", "", "New value of the setting")
+	cmd.Flag.BoolVar(&cmd.reset, "reset", false, "Restore the default value of the setting")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *configUpdateCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Updating the configuration is only available for privileged users")
+	}
+
+	if cmd.key == "" {
+		errorf("Missing required -key parameter")
+		cmd.usage()
+	}
+
+	if cmd.value == "" && !cmd.reset {
+		errorf("Missing required -value or -reset parameter")
+		cmd.usage()
+	}
+
+	value := cmd.value
+	if cmd.reset {
+		value = ""
+	}
+
+	err := c.UpdateConfigSetting(cmd.key, value)
+	if err != nil {
+		return errors.Wrap(err, "Error updating configuration")
+	}
+
+	fmt.Printf("Updated setting %s\n", cmd.key)
+
+	return nil
+}
@@ -0,0 +1,105 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+)
+
+var datastoreCommand = &command{
+	SubCommands: map[string]subCommand{
+		"verify": new(datastoreVerifyCommand),
+	},
+}
+
+type datastoreVerifyCommand struct {
+	Flag     flag.FlagSet
+	repair   bool
+	template string
+}
+
+func (cmd *datastoreVerifyCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] datastore verify [flags]
+
+Check the consistency of the controller datastore: cached objects missing
+from the persistent store or the reverse, instances of deleted tenants,
+storage attachments of deleted instances or volumes and external IPs
+mapped from deleted pools
+
+The verify flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated(types.DatastoreVerifyResponse{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+
+	os.Exit(2)
+}
+
+func (cmd *datastoreVerifyCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.repair, "repair", false, "Repair the inconsistencies found, taking the persistent store as the reference")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *datastoreVerifyCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Verifying the datastore is only available for privileged users")
+	}
+
+	res, err := c.VerifyDatastore(cmd.repair)
+	if err != nil {
+		return errors.Wrap(err, "Error verifying datastore")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("datastore-verify", cmd.template, res)
+	}
+
+	if len(res.Inconsistencies) == 0 {
+		fmt.Println("No inconsistencies found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Resource\tID\tKind\tReason\tRepaired\n")
+	for _, i := range res.Inconsistencies {
+		repaired := "no"
+		if i.Repaired {
+			repaired = "yes"
+		} else if i.Error != "" {
+			repaired = i.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", i.Resource, i.ID, i.Kind, i.Reason, repaired)
+	}
+	w.Flush()
+
+	return nil
+}
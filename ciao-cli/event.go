@@ -20,6 +20,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/intel/tfortools"
@@ -37,6 +38,13 @@ type eventListCommand struct {
 	Flag     flag.FlagSet
 	all      bool
 	tenant   string
+	severity string
+	category string
+	node     string
+	since    string
+	until    string
+	limit    int
+	marker   string
 	template string
 }
 
@@ -57,7 +65,14 @@ The list flags are:
 func (cmd *eventListCommand) parseArgs(args []string) []string {
 	cmd.Flag.BoolVar(&cmd.all, "all", false, "List events for all tenants in a cluster")
 	cmd.Flag.StringVar(&cmd.tenant, "tenant-id", "", "Tenant ID")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.StringVar(&cmd.severity, "severity", "", "Minimum severity of the events (debug, info, warning, error, critical)")
+	cmd.Flag.StringVar(&cmd.category, "category", "", "Category of the events (instance, network, storage, node, auth)")
+	cmd.Flag.StringVar(&cmd.node, "node-id", "", "List only the events of a node")
+	cmd.Flag.StringVar(&cmd.since, "since", "", "List only the events logged at or after a RFC 3339 time")
+	cmd.Flag.StringVar(&cmd.until, "until", "", "List only the events logged before a RFC 3339 time")
+	cmd.Flag.IntVar(&cmd.limit, "limit", 0, "Maximum number of events listed, 0 for all")
+	cmd.Flag.StringVar(&cmd.marker, "marker", "", "List the events following this marker, printed by a previous limited list")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -78,46 +93,116 @@ func (cmd *eventListCommand) run(args []string) error {
 		tenantID = ""
 	}
 
-	events, err := c.ListEvents(tenantID)
+	severity := types.EventSeverity(cmd.severity)
+	if severity != "" && severity.Level() == -1 {
+		errorf("Invalid -severity %s", cmd.severity)
+		cmd.usage()
+	}
+
+	category := types.EventCategory(cmd.category)
+	if category != "" && !category.Valid() {
+		errorf("Invalid -category %s", cmd.category)
+		cmd.usage()
+	}
+
+	filter := types.EventFilter{
+		NodeID:   cmd.node,
+		Severity: severity,
+		Category: category,
+	}
+
+	var err error
+	filter.Since, err = parseEventTime("-since", cmd.since)
+	if err != nil {
+		return err
+	}
+
+	filter.Until, err = parseEventTime("-until", cmd.until)
+	if err != nil {
+		return err
+	}
+
+	events, err := c.ListEventsPage(tenantID, filter, cmd.marker, cmd.limit)
 	if err != nil {
 		return errors.Wrap(err, "Error listing events")
 	}
 
+	if events.NextMarker != "" {
+		fmt.Fprintf(os.Stderr, "More events follow, use -marker %s\n", events.NextMarker)
+	}
+
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "event-list", cmd.template,
-			&events.Events, nil)
+		return outputToTemplate("event-list", cmd.template, &events.Events)
 	}
 
 	fmt.Printf("%d Ciao event(s):\n", len(events.Events))
 	for i, event := range events.Events {
-		fmt.Printf("\t[%d] %v: %s:%s (Tenant %s)\n", i+1, event.Timestamp, event.EventType, event.Message, event.TenantID)
+		fmt.Printf("\t[%d] %v: %s:%s:%s (Tenant %s)\n", i+1, event.Timestamp, event.Severity,
+			event.Category, event.Message, event.TenantID)
+		if event.Reason != "" {
+			fmt.Printf("\t\tReason %s (Instance %s)\n", event.Reason, event.InstanceID)
+		}
 	}
 	return nil
 }
 
+// parseEventTime parses the optional RFC 3339 time given to a flag.
+func parseEventTime(name string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "Invalid %s time", name)
+	}
+
+	return t, nil
+}
+
 type eventDeleteCommand struct {
-	Flag flag.FlagSet
+	Flag   flag.FlagSet
+	before string
 }
 
 func (cmd *eventDeleteCommand) usage(...string) {
-	fmt.Fprintf(os.Stderr, `Usage: ciao-cli [options] event delete
+	fmt.Fprintf(os.Stderr, `Usage: ciao-cli [options] event delete [flags]
+
+Deletes all events, or the events logged before a time
+
+The delete flags are:
 
-Deletes all events
 `)
+	cmd.Flag.PrintDefaults()
 	os.Exit(2)
 }
 
 func (cmd *eventDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.before, "before", "", "Delete only the events logged before a RFC 3339 time")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
 func (cmd *eventDeleteCommand) run(args []string) error {
-	err := c.DeleteEvents()
+	before, err := parseEventTime("-before", cmd.before)
+	if err != nil {
+		return err
+	}
+
+	if before.IsZero() {
+		err = c.DeleteEvents()
+	} else {
+		err = c.DeleteEventsBefore(before)
+	}
 	if err != nil {
 		return errors.Wrap(err, "Error deleting events")
 	}
-	fmt.Printf("Deleted all event logs\n")
+
+	if before.IsZero() {
+		fmt.Printf("Deleted all event logs\n")
+	} else {
+		fmt.Printf("Deleted event logs before %v\n", before)
+	}
 	return nil
 }
@@ -40,6 +40,7 @@ type externalIPMapCommand struct {
 	Flag       flag.FlagSet
 	instanceID string
 	poolName   string
+	address    string
 }
 
 func (cmd *externalIPMapCommand) usage(...string) {
@@ -57,6 +58,7 @@ The map flags are:
 func (cmd *externalIPMapCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.instanceID, "instance", "", "ID of the instance to map IP to.")
 	cmd.Flag.StringVar(&cmd.poolName, "pool", "", "Name of the pool to map from.")
+	cmd.Flag.StringVar(&cmd.address, "address", "", "Specific external IP to map.")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -68,7 +70,7 @@ func (cmd *externalIPMapCommand) run(args []string) error {
 		cmd.usage()
 	}
 
-	err := c.MapExternalIP(cmd.poolName, cmd.instanceID)
+	err := c.MapExternalIPAddress(cmd.poolName, cmd.address, cmd.instanceID)
 	if err != nil {
 		return errors.Wrap(err, "Error mapping external IP")
 	}
@@ -97,7 +99,7 @@ The list flags are:
 }
 
 func (cmd *externalIPListCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -110,8 +112,7 @@ func (cmd *externalIPListCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "external-ip-list", cmd.template,
-			&IPs, nil)
+		return outputToTemplate("external-ip-list", cmd.template, &IPs)
 	}
 
 	w := new(tabwriter.Writer)
@@ -148,14 +149,15 @@ func (cmd *externalIPListCommand) run(args []string) error {
 }
 
 type externalIPUnMapCommand struct {
-	address string
-	Flag    flag.FlagSet
+	address    string
+	instanceID string
+	Flag       flag.FlagSet
 }
 
 func (cmd *externalIPUnMapCommand) usage(...string) {
 	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] external-ip unmap [flags]
 
-Unmap a given external IP.
+Unmap a given external IP, or all the external IPs of an instance.
 
 The unmap flags are:
 
@@ -166,17 +168,29 @@ The unmap flags are:
 
 func (cmd *externalIPUnMapCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.address, "address", "", "External IP to unmap.")
+	cmd.Flag.StringVar(&cmd.instanceID, "instance", "", "ID of the instance to unmap all external IPs from.")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
 func (cmd *externalIPUnMapCommand) run(args []string) error {
-	if cmd.address == "" {
-		errorf("Missing required -address parameter")
+	if (cmd.address == "") == (cmd.instanceID == "") {
+		errorf("Exactly one of -address or -instance must be given")
 		cmd.usage()
 	}
 
+	if cmd.instanceID != "" {
+		err := c.UnmapInstanceExternalIPs(cmd.instanceID)
+		if err != nil {
+			return errors.Wrap(err, "Error unmapping external IPs")
+		}
+
+		fmt.Printf("Requested unmap of external IPs of: %s\n", cmd.instanceID)
+
+		return nil
+	}
+
 	err := c.UnmapExternalIP(cmd.address)
 	if err != nil {
 		return errors.Wrap(err, "Error unmapping external IP")
@@ -193,6 +207,7 @@ var poolCommand = &command{
 		"list":   new(poolListCommand),
 		"show":   new(poolShowCommand),
 		"delete": new(poolDeleteCommand),
+		"drain":  new(poolDrainCommand),
 		"add":    new(poolAddCommand),
 		"remove": new(poolRemoveCommand),
 	},
@@ -259,7 +274,7 @@ The list flags are:
 }
 
 func (cmd *poolListCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -275,8 +290,7 @@ func (cmd *poolListCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "pool-list", cmd.template,
-			&pools.Pools, nil)
+		return outputToTemplate("pool-list", cmd.template, &pools.Pools)
 	}
 
 	w := new(tabwriter.Writer)
@@ -301,6 +315,10 @@ func (cmd *poolListCommand) run(args []string) error {
 		}
 
 		fmt.Fprintf(w, "\n")
+
+		for _, sub := range pool.Subnets {
+			fmt.Fprintf(w, "\t  %s\t%d\t%d\n", sub.CIDR, sub.Used+sub.Free, sub.Free)
+		}
 	}
 
 	w.Flush()
@@ -329,7 +347,7 @@ The show flags are:
 
 func (cmd *poolShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of pool")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -343,6 +361,11 @@ func dumpPool(pool types.Pool) {
 
 	for _, sub := range pool.Subnets {
 		fmt.Printf("\tSubnet: %s\n", sub.CIDR)
+		fmt.Printf("\t\tUsed IPs: %d\n", sub.Used)
+		fmt.Printf("\t\tFree IPs: %d\n", sub.Free)
+		for _, ip := range sub.MappedIPs {
+			fmt.Printf("\t\tMapped IP: %s\n", ip)
+		}
 	}
 
 	for _, ip := range pool.IPs {
@@ -364,8 +387,7 @@ func (cmd *poolShowCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "pool-show", cmd.template,
-			&pool, nil)
+		return outputToTemplate("pool-show", cmd.template, &pool)
 	}
 
 	dumpPool(pool)
@@ -376,12 +398,14 @@ func (cmd *poolShowCommand) run(args []string) error {
 type poolDeleteCommand struct {
 	Flag flag.FlagSet
 	name string
+	yes  bool
 }
 
 func (cmd *poolDeleteCommand) usage(...string) {
 	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] pool delete [flags]
 
 Delete an unused ciao external IP pool.
+What is deleted is shown and must be confirmed, unless -yes is given.
 
 The delete flags are:
 
@@ -392,6 +416,7 @@ The delete flags are:
 
 func (cmd *poolDeleteCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of pool")
+	cmd.Flag.BoolVar(&cmd.yes, "yes", false, "Delete the pool without asking for confirmation")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -403,7 +428,16 @@ func (cmd *poolDeleteCommand) run(args []string) error {
 		cmd.usage()
 	}
 
-	err := c.DeleteExternalIPPool(cmd.name)
+	plan, err := c.PlanExternalIPPoolDeletion(cmd.name)
+	if err != nil {
+		return errors.Wrap(err, "Error planning external IP pool deletion")
+	}
+
+	if !confirmDryRun(plan, cmd.yes) {
+		return nil
+	}
+
+	err = c.DeleteExternalIPPool(cmd.name, plan.Token)
 	if err != nil {
 		return errors.Wrap(err, "Error deleting external IP pool")
 	}
@@ -413,16 +447,70 @@ func (cmd *poolDeleteCommand) run(args []string) error {
 	return nil
 }
 
+type poolDrainCommand struct {
+	Flag flag.FlagSet
+	name string
+	yes  bool
+}
+
+func (cmd *poolDrainCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] pool drain [flags]
+
+Unmap all the mapped external IPs of a ciao external IP pool.
+What is unmapped is shown and must be confirmed, unless -yes is given.
+
+The drain flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *poolDrainCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of pool")
+	cmd.Flag.BoolVar(&cmd.yes, "yes", false, "Drain the pool without asking for confirmation")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *poolDrainCommand) run(args []string) error {
+	if cmd.name == "" {
+		errorf("Missing required -name parameter")
+		cmd.usage()
+	}
+
+	plan, err := c.PlanExternalIPPoolDrain(cmd.name)
+	if err != nil {
+		return errors.Wrap(err, "Error planning external IP pool drain")
+	}
+
+	if !confirmDryRun(plan, cmd.yes) {
+		return nil
+	}
+
+	err = c.DrainExternalIPPool(cmd.name, plan.Token)
+	if err != nil {
+		return errors.Wrap(err, "Error draining external IP pool")
+	}
+
+	fmt.Printf("Requested drain of pool: %s\n", cmd.name)
+
+	return nil
+}
+
 type poolAddCommand struct {
-	Flag   flag.FlagSet
-	name   string
-	subnet string
+	Flag    flag.FlagSet
+	name    string
+	subnet  string
+	ipRange string
 }
 
 func (cmd *poolAddCommand) usage(...string) {
 	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] pool add [flags] [ip1 ip2...]
 
-Add external IPs to a pool.
+Add external IPs to a pool, either as a subnet, as a range of addresses or
+as a list of addresses.
 
 The add flags are:
 
@@ -434,6 +522,7 @@ The add flags are:
 func (cmd *poolAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of pool")
 	cmd.Flag.StringVar(&cmd.subnet, "subnet", "", "Subnet in CIDR format")
+	cmd.Flag.StringVar(&cmd.ipRange, "ip-range", "", "Range of IPs, e.g., 192.0.2.10-192.0.2.50")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -460,6 +549,11 @@ func (cmd *poolAddCommand) run(args []string) error {
 		if err != nil {
 			return errors.Wrap(err, "Error adding external IP subnet")
 		}
+	} else if cmd.ipRange != "" {
+		err := c.AddExternalIPRange(cmd.name, cmd.ipRange)
+		if err != nil {
+			return errors.Wrap(err, "Error adding external IP range")
+		}
 	} else if len(args) < 1 {
 		errorf("Missing any addresses to add")
 		cmd.usage()
@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+)
+
+var faultsCommand = &command{
+	SubCommands: map[string]subCommand{
+		"show":   new(faultsShowCommand),
+		"update": new(faultsUpdateCommand),
+	},
+}
+
+type faultsShowCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *faultsShowCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] faults show [flags]
+
+Show the faults injected by a controller started with -fault_injection
+
+The show flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a 
+
+%s`,
+		tfortools.GenerateUsageUndecorated(types.FaultConfig{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+
+	os.Exit(2)
+}
+
+func (cmd *faultsShowCommand) parseArgs(args []string) []string {
+	cmd.Flag.Usage = func() { cmd.usage() }
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *faultsShowCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Showing faults is only available for privileged users")
+	}
+
+	faults, err := c.GetFaults()
+	if err != nil {
+		return errors.Wrap(err, "Error getting faults")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("faults-show", cmd.template, faults)
+	}
+
+	fmt.Printf("Dropped frames: %d%%\n", faults.FrameDropPercent)
+	fmt.Printf("Datastore write delay: %dms\n", faults.WriteDelayMS)
+	fmt.Printf("Failed launches: %d%%\n", faults.LaunchFailurePercent)
+
+	return nil
+}
+
+type faultsUpdateCommand struct {
+	Flag   flag.FlagSet
+	faults types.FaultConfig
+}
+
+func (cmd *faultsUpdateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] faults update [flags]
+
+Replaces the faults injected by a controller started with -fault_injection.
+Faults not set are disabled.
+
+The update flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *faultsUpdateCommand) parseArgs(args []string) []string {
+	cmd.Flag.IntVar(&cmd.faults.FrameDropPercent, "drop-frames", 0, "Percentage of SSNTP frames dropped")
+	cmd.Flag.IntVar(&cmd.faults.WriteDelayMS, "write-delay", 0, "Delay of the datastore writes, in milliseconds")
+	cmd.Flag.IntVar(&cmd.faults.LaunchFailurePercent, "fail-launches", 0, "Percentage of instance launches failed")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *faultsUpdateCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Updating faults is only available for privileged users")
+	}
+
+	err := c.UpdateFaults(cmd.faults)
+	if err != nil {
+		return errors.Wrap(err, "Error updating faults")
+	}
+
+	fmt.Printf("Update faults succeeded\n")
+
+	return nil
+}
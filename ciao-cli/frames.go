@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var framesCommand = &command{
+	SubCommands: map[string]subCommand{
+		"replay": new(framesReplayCommand),
+	},
+}
+
+type framesReplayCommand struct {
+	Flag flag.FlagSet
+	file string
+}
+
+func (cmd *framesReplayCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] frames replay [flags]
+
+Replays the SSNTP frames captured by a controller started with
+-frame_capture against a controller started with -simulate
+
+The replay flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *framesReplayCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.file, "file", "", "Frame capture file")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *framesReplayCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Replaying frames is only available for privileged users")
+	}
+
+	if cmd.file == "" {
+		errorf("Missing required -file parameter")
+		cmd.usage()
+	}
+
+	f, err := os.Open(cmd.file)
+	if err != nil {
+		return errors.Wrap(err, "Error opening frame capture")
+	}
+	defer func() { _ = f.Close() }()
+
+	res, err := c.ReplayFrames(f)
+	if err != nil {
+		return errors.Wrap(err, "Error replaying frames")
+	}
+
+	fmt.Printf("Replayed %d frames, skipped %d\n", res.Replayed, res.Skipped)
+
+	return nil
+}
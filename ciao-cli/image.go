@@ -19,9 +19,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
+	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/intel/tfortools"
@@ -30,10 +33,12 @@ import (
 
 var imageCommand = &command{
 	SubCommands: map[string]subCommand{
-		"add":    new(imageAddCommand),
-		"show":   new(imageShowCommand),
-		"list":   new(imageListCommand),
-		"delete": new(imageDeleteCommand),
+		"add":     new(imageAddCommand),
+		"show":    new(imageShowCommand),
+		"list":    new(imageListCommand),
+		"delete":  new(imageDeleteCommand),
+		"export":  new(imageExportCommand),
+		"exports": new(imageExportsCommand),
 	},
 }
 
@@ -44,6 +49,8 @@ type imageAddCommand struct {
 	file       string
 	template   string
 	visibility string
+	signer     string
+	signature  string
 }
 
 func (cmd *imageAddCommand) usage(...string) {
@@ -63,9 +70,11 @@ func (cmd *imageAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Image Name")
 	cmd.Flag.StringVar(&cmd.id, "id", "", "Image UUID")
 	cmd.Flag.StringVar(&cmd.file, "file", "", "Image file to upload")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.StringVar(&cmd.visibility, "visibility", string(types.Private),
 		"Image visibility (internal,public,private)")
+	cmd.Flag.StringVar(&cmd.signer, "signer", "", "Name of the trusted key which signed the image")
+	cmd.Flag.StringVar(&cmd.signature, "signature", "", "File containing the signature of the image, as output by openssl dgst -sha256 -sign")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -85,6 +94,19 @@ func (cmd *imageAddCommand) run(args []string) error {
 		return errors.New("Missing required -file parameter")
 	}
 
+	if (cmd.signer == "") != (cmd.signature == "") {
+		return errors.New("The -signer and -signature parameters must be used together")
+	}
+
+	var signature []byte
+	if cmd.signature != "" {
+		var err error
+		signature, err = ioutil.ReadFile(cmd.signature)
+		if err != nil {
+			return errors.Wrap(err, "Error reading signature")
+		}
+	}
+
 	f, err := os.Open(cmd.file)
 	if err != nil {
 		fatalf("Could not open %s [%s]\n", cmd.file, err)
@@ -101,7 +123,7 @@ func (cmd *imageAddCommand) run(args []string) error {
 		}
 	}
 
-	id, err := c.CreateImage(cmd.name, imageVisibility, cmd.id, f)
+	id, err := c.CreateSignedImage(cmd.name, imageVisibility, cmd.id, cmd.signer, signature, f)
 	if err != nil {
 		return errors.Wrap(err, "Error creating image")
 	}
@@ -112,7 +134,7 @@ func (cmd *imageAddCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "image-add", cmd.template, image, nil)
+		return outputToTemplate("image-add", cmd.template, image)
 	}
 
 	fmt.Printf("Created image:\n")
@@ -137,7 +159,7 @@ Show images
 }
 
 func (cmd *imageShowCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.StringVar(&cmd.image, "image", "", "Image UUID")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
@@ -155,7 +177,7 @@ func (cmd *imageShowCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "image-show", cmd.template, i, nil)
+		return outputToTemplate("image-show", cmd.template, i)
 	}
 
 	dumpImage(&i)
@@ -188,7 +210,7 @@ to the template to determine the total number of images.
 }
 
 func (cmd *imageListCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -260,6 +282,126 @@ func (cmd *imageDeleteCommand) run(args []string) error {
 	return nil
 }
 
+type imageExportCommand struct {
+	Flag     flag.FlagSet
+	image    string
+	key      string
+	wait     bool
+	template string
+}
+
+func (cmd *imageExportCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] image export [flags]
+
+Exports an image to the object storage of the cluster
+
+The export flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", tfortools.GenerateUsageDecorated("f", types.ImageExportStatus{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *imageExportCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.image, "image", "", "Image UUID")
+	cmd.Flag.StringVar(&cmd.key, "key", "", "Key of the exported object, defaults to <image UUID>.raw")
+	cmd.Flag.BoolVar(&cmd.wait, "wait", false, "Wait for the export to complete")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *imageExportCommand) run(args []string) error {
+	if cmd.image == "" {
+		return errors.New("Missing required -image parameter")
+	}
+
+	export, err := c.ExportImage(cmd.image, cmd.key)
+	if err != nil {
+		return errors.Wrap(err, "Error exporting image")
+	}
+
+	for cmd.wait && export.State == types.ImageExportUploading {
+		time.Sleep(2 * time.Second)
+
+		export, err = c.GetImageExport(export.ID)
+		if err != nil {
+			return errors.Wrap(err, "Error getting image export")
+		}
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("image-export", cmd.template, export)
+	}
+
+	switch export.State {
+	case types.ImageExportUploading:
+		fmt.Printf("Exporting image %s to %s [export %s]\n", cmd.image, export.URL, export.ID)
+	case types.ImageExportDone:
+		fmt.Printf("Exported image %s to %s\n", cmd.image, export.URL)
+	default:
+		return fmt.Errorf("Export of image %s failed: %s", cmd.image, export.Error)
+	}
+
+	return nil
+}
+
+type imageExportsCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *imageExportsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] image exports [flags]
+
+List the recent image exports
+
+The exports flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s
+`, tfortools.GenerateUsageUndecorated([]types.ImageExportStatus{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *imageExportsCommand) parseArgs(args []string) []string {
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *imageExportsCommand) run(args []string) error {
+	exports, err := c.ListImageExports()
+	if err != nil {
+		return errors.Wrap(err, "Error listing image exports")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("image-exports", cmd.template, exports)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "ID\tImage\tState\tUploaded\tURL\n")
+	for _, e := range exports {
+		state := string(e.State)
+		if e.Error != "" {
+			state = fmt.Sprintf("%s (%s)", e.State, e.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\n", e.ID, e.ImageID, state, e.Uploaded, e.Size, e.URL)
+	}
+	w.Flush()
+
+	return nil
+}
+
 func dumpImage(i *types.Image) {
 	fmt.Printf("\tName\t\t[%s]\n", i.Name)
 	fmt.Printf("\tSize\t\t[%d bytes]\n", i.Size)
@@ -267,4 +409,10 @@ func dumpImage(i *types.Image) {
 	fmt.Printf("\tState\t\t[%s]\n", i.State)
 	fmt.Printf("\tVisibility\t[%s]\n", i.Visibility)
 	fmt.Printf("\tCreateTime\t[%s]\n", i.CreateTime)
+	if i.SourceInstanceID != "" {
+		fmt.Printf("\tSourceInstance\t[%s]\n", i.SourceInstanceID)
+	}
+	if i.Signer != "" {
+		fmt.Printf("\tSigner\t\t[%s]\n", i.Signer)
+	}
 }
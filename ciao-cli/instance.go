@@ -22,21 +22,29 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/intel/tfortools"
 	"github.com/pkg/errors"
 )
 
 var instanceCommand = &command{
 	SubCommands: map[string]subCommand{
-		"add":     new(instanceAddCommand),
-		"delete":  new(instanceDeleteCommand),
-		"list":    new(instanceListCommand),
-		"show":    new(instanceShowCommand),
-		"restart": new(instanceRestartCommand),
-		"stop":    new(instanceStopCommand),
+		"add":      new(instanceAddCommand),
+		"delete":   new(instanceDeleteCommand),
+		"list":     new(instanceListCommand),
+		"show":     new(instanceShowCommand),
+		"restart":  new(instanceRestartCommand),
+		"stop":     new(instanceStopCommand),
+		"snapshot": new(instanceSnapshotCommand),
+		"protect":  new(instanceProtectCommand),
+		"hot-add":  new(instanceHotAddCommand),
+		"transfer": new(instanceTransferCommand),
+		"deleted":  new(instanceDeletedCommand),
 	},
 }
 
@@ -66,8 +74,8 @@ func (cmd *instanceAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
 	cmd.Flag.IntVar(&cmd.instances, "instances", 1, "Number of instances to create")
 	cmd.Flag.StringVar(&cmd.label, "label", "", "Set a frame label. This will trigger frame tracing")
-	cmd.Flag.StringVar(&cmd.name, "name", "", "Name for this instance. When multiple instances are requested this is used as a prefix")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Name for this instance. May contain {index} and {id} placeholders. When multiple instances are requested without placeholders, instances are named <name>-1 to <name>-N")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -90,8 +98,9 @@ func (cmd *instanceAddCommand) validateAddCommandArgs() {
 	}
 
 	if cmd.name != "" {
+		name := strings.NewReplacer(types.InstanceIndex, "1", types.InstanceShortID, "0").Replace(cmd.name)
 		r := regexp.MustCompile("^[a-z0-9-]{1,64}$")
-		if !r.MatchString(cmd.name) {
+		if !r.MatchString(name) {
 			errorf("Requested name must be between 1 and 64 lowercase letters, numbers and hyphens")
 		}
 	}
@@ -123,8 +132,7 @@ func (cmd *instanceAddCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "instance-add", cmd.template,
-			&servers.Servers, nil)
+		return outputToTemplate("instance-add", cmd.template, &servers.Servers)
 	}
 
 	if len(servers.Servers) < cmd.instances {
@@ -132,22 +140,28 @@ func (cmd *instanceAddCommand) run(args []string) error {
 	}
 
 	for _, server := range servers.Servers {
-		fmt.Printf("Created new (pending) instance: %s\n", server.ID)
+		if server.Name != "" {
+			fmt.Printf("Created new (pending) instance: %s (%s)\n", server.ID, server.Name)
+		} else {
+			fmt.Printf("Created new (pending) instance: %s\n", server.ID)
+		}
 	}
 
 	return nil
 }
 
 type instanceDeleteCommand struct {
-	Flag     flag.FlagSet
-	instance string
-	all      bool
+	Flag flag.FlagSet
+	instanceSelection
+	all bool
 }
 
 func (cmd *instanceDeleteCommand) usage(...string) {
-	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance delete [flags]
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance delete [flags] [instance UUID...]
 
-Deletes a given instance
+Deletes the given instances, and the instances having all the tags given
+by -tags. With -parallel several instances are deleted concurrently, and
+the failures are reported once all the instances have been handled.
 
 The delete flags are:
 
@@ -157,8 +171,8 @@ The delete flags are:
 }
 
 func (cmd *instanceDeleteCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
-	cmd.Flag.BoolVar(&cmd.all, "all", false, "Delete all instances for the given tenant")
+	cmd.addFlags(&cmd.Flag)
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "Delete all instances for the given tenant, except those protected against deletion")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -170,33 +184,42 @@ func (cmd *instanceDeleteCommand) run(args []string) error {
 		if err != nil {
 			return errors.Wrap(err, "Error deleting all instances")
 		}
-		fmt.Printf("Deleted all instances\n")
+		fmt.Printf("Deleted all unprotected instances\n")
 		return nil
 	}
 
-	if cmd.instance == "" {
-		errorf("Missing required -instance parameter")
+	if cmd.empty(args) {
+		errorf("Missing required -instance or -tags parameter")
 		cmd.usage()
 	}
 
-	err := c.DeleteInstance(cmd.instance)
+	instances, err := cmd.instances(args)
 	if err != nil {
-		return errors.Wrap(err, "Error deleting instance")
+		return err
 	}
 
-	fmt.Printf("Deleted instance: %s\n", cmd.instance)
-	return nil
+	return applyParallel(instances, cmd.parallel, func(instance string) error {
+		err := c.DeleteInstance(instance)
+		if err != nil {
+			return errors.Wrap(err, "Error deleting instance")
+		}
+
+		fmt.Printf("Deleted instance: %s\n", instance)
+		return nil
+	})
 }
 
 type instanceRestartCommand struct {
-	Flag     flag.FlagSet
-	instance string
+	Flag flag.FlagSet
+	instanceSelection
 }
 
 func (cmd *instanceRestartCommand) usage(...string) {
-	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance restart [flags]
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance restart [flags] [instance UUID...]
 
-Restart a stopped Ciao instance
+Restart the given stopped instances, and the instances having all the tags
+given by -tags. With -parallel several instances are restarted concurrently,
+and the failures are reported once all the instances have been handled.
 
 The restart flags are:
 
@@ -206,29 +229,32 @@ The restart flags are:
 }
 
 func (cmd *instanceRestartCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.addFlags(&cmd.Flag)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
-func (cmd *instanceRestartCommand) run([]string) error {
-	err := startStopInstance(cmd.instance, false)
-	if err != nil {
+func (cmd *instanceRestartCommand) run(args []string) error {
+	if cmd.empty(args) {
+		errorf("Missing required -instance or -tags parameter")
 		cmd.usage()
 	}
-	return err
+
+	return startStopInstances(&cmd.instanceSelection, args, false)
 }
 
 type instanceStopCommand struct {
-	Flag     flag.FlagSet
-	instance string
+	Flag flag.FlagSet
+	instanceSelection
 }
 
 func (cmd *instanceStopCommand) usage(...string) {
-	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance stop [flags]
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance stop [flags] [instance UUID...]
 
-Stop a Ciao instance
+Stop the given instances, and the instances having all the tags given by
+-tags. With -parallel several instances are stopped concurrently, and the
+failures are reported once all the instances have been handled.
 
 The stop flags are:
 
@@ -238,29 +264,206 @@ The stop flags are:
 }
 
 func (cmd *instanceStopCommand) parseArgs(args []string) []string {
+	cmd.addFlags(&cmd.Flag)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceStopCommand) run(args []string) error {
+	if cmd.empty(args) {
+		errorf("Missing required -instance or -tags parameter")
+		cmd.usage()
+	}
+
+	return startStopInstances(&cmd.instanceSelection, args, true)
+}
+
+type instanceProtectCommand struct {
+	Flag      flag.FlagSet
+	instance  string
+	unprotect bool
+}
+
+func (cmd *instanceProtectCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance protect [flags]
+
+Protect a Ciao instance against deletion. A protected instance cannot be
+deleted until its protection is removed with -unprotect.
+
+The protect flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceProtectCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.BoolVar(&cmd.unprotect, "unprotect", false, "Remove the protection of the instance")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
-func (cmd *instanceStopCommand) run([]string) error {
-	err := startStopInstance(cmd.instance, true)
+func (cmd *instanceProtectCommand) run(args []string) error {
+	if cmd.instance == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	err := c.ProtectInstance(cmd.instance, !cmd.unprotect)
 	if err != nil {
+		return errors.Wrap(err, "Error changing instance protection")
+	}
+
+	if cmd.unprotect {
+		fmt.Printf("Unprotected instance: %s\n", cmd.instance)
+	} else {
+		fmt.Printf("Protected instance: %s\n", cmd.instance)
+	}
+	return nil
+}
+
+type instanceHotAddCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	vcpus    int
+	memMB    int
+}
+
+func (cmd *instanceHotAddCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance hot-add [flags]
+
+Add vCPUs and memory to a running Ciao instance. The resulting resources
+cannot exceed the max_vcpus and max_mem_mb of the instance workload, and
+memory is added in multiples of 128 MiB.
+
+The hot-add flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceHotAddCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.IntVar(&cmd.vcpus, "vcpus", 0, "Number of vCPUs to add")
+	cmd.Flag.IntVar(&cmd.memMB, "mem", 0, "Memory to add in MiB")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceHotAddCommand) run(args []string) error {
+	if cmd.instance == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	if cmd.vcpus == 0 && cmd.memMB == 0 {
+		errorf("Missing -vcpus or -mem parameter")
 		cmd.usage()
 	}
-	return err
+
+	err := c.HotAddInstance(cmd.instance, cmd.vcpus, cmd.memMB)
+	if err != nil {
+		return errors.Wrap(err, "Error hot adding resources")
+	}
+
+	fmt.Printf("Hot adding %d vCPUs and %d MiB to instance: %s\n", cmd.vcpus, cmd.memMB, cmd.instance)
+	return nil
 }
 
-func startStopInstance(instance string, stop bool) error {
+type instanceSnapshotCommand struct {
+	Flag       flag.FlagSet
+	instance   string
+	name       string
+	visibility string
+	template   string
+}
+
+func (cmd *instanceSnapshotCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance snapshot [flags]
+
+Creates an image from the boot volume of a Ciao instance. A running
+instance is stopped while its boot volume is copied and restarted
+afterwards.
+
+The snapshot flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", tfortools.GenerateUsageDecorated("f", types.Image{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *instanceSnapshotCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Image Name")
+	cmd.Flag.StringVar(&cmd.visibility, "visibility", string(types.Private),
+		"Image visibility (internal,public,private)")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceSnapshotCommand) run([]string) error {
 	if c.TenantID == "" {
 		return errors.New("Missing required -tenant-id parameter")
 	}
 
-	if instance == "" {
+	if cmd.instance == "" {
 		return errors.New("Missing required -instance parameter")
 	}
 
+	if cmd.name == "" {
+		return errors.New("Missing required -name parameter")
+	}
+
+	r := regexp.MustCompile("^[a-z0-9-.]{1,64}$")
+	if !r.MatchString(cmd.name) {
+		return errors.New("Requested name must be between 1 and 64 lowercase letters, numbers, hyphens and dots")
+	}
+
+	imageVisibility := types.Visibility(cmd.visibility)
+	switch imageVisibility {
+	case types.Public, types.Private, types.Internal:
+	default:
+		return fmt.Errorf("Invalid image visibility [%v]", imageVisibility)
+	}
+
+	image, err := c.SnapshotInstance(cmd.instance, cmd.name, imageVisibility)
+	if err != nil {
+		return errors.Wrap(err, "Error creating image from instance")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("instance-snapshot", cmd.template, image)
+	}
+
+	fmt.Printf("Created image:\n")
+	dumpImage(&image)
+	return nil
+}
+
+func startStopInstances(s *instanceSelection, args []string, stop bool) error {
+	if c.TenantID == "" {
+		return errors.New("Missing required -tenant-id parameter")
+	}
+
+	instances, err := s.instances(args)
+	if err != nil {
+		return err
+	}
+
+	return applyParallel(instances, s.parallel, func(instance string) error {
+		return startStopInstance(instance, stop)
+	})
+}
+
+func startStopInstance(instance string, stop bool) error {
 	if stop == true {
 		err := c.StopInstance(instance)
 		if err != nil {
@@ -282,6 +485,10 @@ type instanceListCommand struct {
 	workload string
 	cn       string
 	tenant   string
+	state    string
+	name     string
+	marker   string
+	limit    int
 	detail   bool
 	template string
 }
@@ -304,7 +511,11 @@ func (cmd *instanceListCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
 	cmd.Flag.StringVar(&cmd.cn, "cn", "", "Computer node to list instances from (default to all nodes when empty)")
 	cmd.Flag.StringVar(&cmd.tenant, "tenant", "", "Specify to list instances from a tenant other than -tenant-id")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.StringVar(&cmd.state, "state", "", "Only list instances in this state")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Only list instances whose name starts with this prefix")
+	cmd.Flag.IntVar(&cmd.limit, "limit", 0, "Maximum number of instances to list (default to all when 0)")
+	cmd.Flag.StringVar(&cmd.marker, "marker", "", "List instances following the instance with this UUID")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -325,7 +536,14 @@ func (cmd *instanceListCommand) run(args []string) error {
 		return listNodeInstances(cmd.cn)
 	}
 
-	servers, err := c.ListInstancesByWorkload(cmd.tenant, cmd.workload)
+	filter := types.InstanceFilter{
+		TenantID:   cmd.tenant,
+		WorkloadID: cmd.workload,
+		State:      cmd.state,
+		NamePrefix: cmd.name,
+	}
+
+	servers, err := c.ListInstancesPage(filter, cmd.marker, cmd.limit)
 	if err != nil {
 		return errors.Wrap(err, "Error listing instances")
 	}
@@ -337,8 +555,7 @@ func (cmd *instanceListCommand) run(args []string) error {
 	sort.Sort(byCreated(sortedServers))
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "instance-list", cmd.template,
-			&sortedServers, nil)
+		return outputToTemplate("instance-list", cmd.template, &sortedServers)
 	}
 
 	w := new(tabwriter.Writer)
@@ -366,12 +583,19 @@ func (cmd *instanceListCommand) run(args []string) error {
 			dumpInstance(&server)
 		}
 	}
+
+	if servers.NextMarker != "" {
+		fmt.Fprintf(os.Stderr, "%d instances in total, use -marker %s to list more\n",
+			servers.TotalServers, servers.NextMarker)
+	}
 	return nil
 }
 
 type instanceShowCommand struct {
 	Flag     flag.FlagSet
 	instance string
+	network  bool
+	disk     bool
 	template string
 }
 
@@ -390,26 +614,81 @@ The show flags are:
 
 func (cmd *instanceShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.BoolVar(&cmd.network, "network", false, "Show the latest network statistics of the instance")
+	cmd.Flag.BoolVar(&cmd.disk, "disk", false, "Show the latest disk I/O statistics of the instance")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
+func (cmd *instanceShowCommand) showNetworkStats() error {
+	stats, err := c.GetInstanceNetworkStats(cmd.instance)
+	if err != nil {
+		return errors.Wrap(err, "Error getting instance network statistics")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("instance-show", cmd.template, &stats)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 1, 1, ' ', 0)
+	fmt.Fprintln(w, "Timestamp\tInterface\tRx bytes\tTx bytes\tRx packets\tTx packets")
+	for _, s := range stats.Samples {
+		for _, n := range s.Networks {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", s.Timestamp.Format(time.RFC3339),
+				n.Interface, n.RxBytes, n.TxBytes, n.RxPackets, n.TxPackets)
+		}
+	}
+	if e := stats.External; e != nil {
+		fmt.Fprintf(w, "-\texternal\t%d\t%d\t%d\t%d\n",
+			e.RxBytes, e.TxBytes, e.RxPackets, e.TxPackets)
+	}
+	return w.Flush()
+}
+
+func (cmd *instanceShowCommand) showDiskStats() error {
+	stats, err := c.GetInstanceDiskStats(cmd.instance)
+	if err != nil {
+		return errors.Wrap(err, "Error getting instance disk statistics")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("instance-show", cmd.template, &stats)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 1, 1, ' ', 0)
+	fmt.Fprintln(w, "Timestamp\tRead bytes\tWrite bytes\tRead IOPS\tWrite IOPS")
+	for _, s := range stats.Samples {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1f\n", s.Timestamp.Format(time.RFC3339),
+			s.DiskIO.ReadBytes, s.DiskIO.WriteBytes, s.DiskIO.ReadIOPS, s.DiskIO.WriteIOPS)
+	}
+	return w.Flush()
+}
+
 func (cmd *instanceShowCommand) run(args []string) error {
 	if cmd.instance == "" {
 		errorf("Missing required -instance parameter")
 		cmd.usage()
 	}
 
+	if cmd.network {
+		return cmd.showNetworkStats()
+	}
+
+	if cmd.disk {
+		return cmd.showDiskStats()
+	}
+
 	server, err := c.GetInstance(cmd.instance)
 	if err != nil {
 		return errors.Wrap(err, "Error getting instance")
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "instance-show", cmd.template,
-			&server.Server, nil)
+		return outputToTemplate("instance-show", cmd.template, &server.Server)
 	}
 
 	dumpInstance(&server.Server)
@@ -423,6 +702,16 @@ func dumpInstance(server *api.ServerDetails) {
 	fmt.Printf("\tMAC Address: %s\n", server.PrivateAddresses[0].MacAddr)
 	fmt.Printf("\tCN UUID: %s\n", server.NodeID)
 	fmt.Printf("\tTenant UUID: %s\n", server.TenantID)
+	if server.VCPUs != 0 {
+		fmt.Printf("\tvCPUs: %d\n", server.VCPUs)
+		fmt.Printf("\tMemory: %d MiB\n", server.MemMB)
+	}
+	if server.Protected {
+		fmt.Printf("\tProtected against deletion\n")
+	}
+	if server.Warm {
+		fmt.Printf("\tWarm, waiting to be claimed\n")
+	}
 	if server.SSHIP != "" {
 		fmt.Printf("\tSSH IP: %s\n", server.SSHIP)
 		fmt.Printf("\tSSH Port: %d\n", server.SSHPort)
@@ -431,6 +720,29 @@ func dumpInstance(server *api.ServerDetails) {
 	for _, vol := range server.Volumes {
 		fmt.Printf("\tVolume: %s\n", vol)
 	}
+
+	if len(server.Tags) > 0 {
+		fmt.Printf("\tTags: %s\n", strings.Join(server.Tags, ", "))
+	}
+
+	if h := server.Health; h != nil {
+		fmt.Printf("\tHealth: %s\n", h.Status)
+		if h.Failures > 0 {
+			fmt.Printf("\tFailed health checks: %d (%s)\n", h.Failures, h.Message)
+		}
+	}
+
+	if h := server.SchedulerHints; h != nil {
+		if h.NodeID != "" {
+			fmt.Printf("\tRequired node: %s\n", h.NodeID)
+		}
+		if h.Hostname != "" {
+			fmt.Printf("\tRequired hostname: %s\n", h.Hostname)
+		}
+		if h.NetworkNode {
+			fmt.Printf("\tRequires network node\n")
+		}
+	}
 }
 
 func listNodeInstances(node string) error {
@@ -452,7 +764,150 @@ func listNodeInstances(node string) error {
 		fmt.Printf("\tCPUs used: %d\n", server.VCPUUsage)
 		fmt.Printf("\tMemory used: %d MB\n", server.MemUsage)
 		fmt.Printf("\tDisk used: %d MB\n", server.DiskUsage)
+		if server.DiskIO != nil {
+			fmt.Printf("\tDisk IOPS: %.1f read, %.1f write\n",
+				server.DiskIO.ReadIOPS, server.DiskIO.WriteIOPS)
+		}
+	}
+
+	return nil
+}
+
+type instanceTransferCommand struct {
+	Flag     flag.FlagSet
+	instance string
+	tenantID string
+}
+
+func (cmd *instanceTransferCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance transfer [flags]
+
+Move an instance, along with its volumes and external IPs, to another
+tenant and wait for the transfer to complete. The external IPs of the
+instance are unmapped and a running instance is stopped while it is
+given an address in the network of the new tenant.
+
+The transfer flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *instanceTransferCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instance, "instance", "", "Instance UUID")
+	cmd.Flag.StringVar(&cmd.tenantID, "to-tenant", "", "Tenant to move the instance to")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceTransferCommand) run(args []string) error {
+	if cmd.instance == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	if cmd.tenantID == "" {
+		errorf("Missing required -to-tenant parameter")
+		cmd.usage()
+	}
+
+	status, err := c.TransferInstance(cmd.instance, cmd.tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Error transferring instance")
+	}
+
+	var state types.InstanceTransferState
+	for status.State != types.InstanceTransferDone && status.State != types.InstanceTransferFailed {
+		if status.State != state {
+			state = status.State
+			fmt.Printf("Instance %s: %s\n", cmd.instance, state)
+		}
+
+		time.Sleep(2 * time.Second)
+
+		status, err = c.GetInstanceTransferStatus(cmd.instance)
+		if err != nil {
+			return errors.Wrap(err, "Error getting instance transfer status")
+		}
+	}
+
+	if status.State == types.InstanceTransferFailed {
+		return fmt.Errorf("Error transferring instance %s: %s", cmd.instance, status.Error)
+	}
+
+	fmt.Printf("Instance %s moved to tenant %s with address %s\n", cmd.instance, cmd.tenantID, status.IPAddress)
+
+	if status.Error != "" {
+		return fmt.Errorf("Instance %s moved incompletely: %s", cmd.instance, status.Error)
+	}
+
+	return nil
+}
+
+type instanceDeletedCommand struct {
+	Flag     flag.FlagSet
+	purge    bool
+	template string
+}
+
+func (cmd *instanceDeletedCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] instance deleted [flags]
+
+List the records kept of the deleted instances of the tenant, most recently
+deleted first. Records are kept for the number of days set by the
+deleted_instance_retention_days configuration setting. With -purge, the
+records are removed instead.
+
+The deleted flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.DeletedInstance{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *instanceDeletedCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.purge, "purge", false, "Remove the records of the deleted instances")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *instanceDeletedCommand) run(args []string) error {
+	if cmd.purge {
+		err := c.PurgeDeletedInstances()
+		if err != nil {
+			return errors.Wrap(err, "Error purging deleted instances")
+		}
+
+		fmt.Printf("Purged deleted instances of tenant %s\n", c.TenantID)
+		return nil
+	}
+
+	instances, err := c.ListDeletedInstances()
+	if err != nil {
+		return errors.Wrap(err, "Error listing deleted instances")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("instance-deleted", cmd.template, instances)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "#\tUUID\tName\tState\tIP\tNode\tDeleted\n")
+	for i, d := range instances {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, d.ID, d.Name, d.State,
+			d.IPAddress, d.NodeID, d.Deleted.Format(time.RFC3339))
 	}
+	w.Flush()
 
 	return nil
 }
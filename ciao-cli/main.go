@@ -85,6 +85,13 @@ var commands = map[string]subCommand{
 	"pool":        poolCommand,
 	"external-ip": externalIPCommand,
 	"quotas":      quotasCommand,
+	"faults":      faultsCommand,
+	"config":      configCommand,
+	"frames":      framesCommand,
+	"report":      reportCommand,
+	"stack":       stackCommand,
+	"auth":        authCommand,
+	"datastore":   datastoreCommand,
 }
 
 func infof(format string, args ...interface{}) {
@@ -106,6 +113,7 @@ var (
 	tenantIDFlag       = flag.String("tenant-id", "", "Tenant UUID")
 	caCertFileFlag     = flag.String("ca-file", "", "CA Certificate")
 	clientCertFileFlag = flag.String("client-cert-file", "", "Path to certificate for authenticating with controller")
+	impersonateFlag    = flag.String("impersonate", "", "Tenant UUID to act on behalf of (admin only, audited)")
 )
 
 const (
@@ -153,6 +161,11 @@ func getCiaoEnvVariables() {
 	if *tenantIDFlag != "" {
 		c.TenantID = *tenantIDFlag
 	}
+
+	if *impersonateFlag != "" {
+		c.TenantID = *impersonateFlag
+		c.ImpersonateTenant = *impersonateFlag
+	}
 }
 
 func prepareForCommand() {
@@ -180,6 +193,9 @@ func main() {
 	cmdName := args[0]
 	cmd := commands[cmdName]
 	if cmd == nil {
+		if path, ok := findPlugins()[cmdName]; ok {
+			runPlugin(path, args[1:])
+		}
 		usage()
 	}
 	if len(args) < 2 {
@@ -212,6 +228,14 @@ The commands are:
 Use "ciao-cli command -help" for more information about that command.
 `
 
+const pluginTemplate = `
+The plugin commands are:
+{{range .}}
+	{{.}}{{end}}
+
+Plugin commands are run by the ciao-<command> executables found on PATH.
+`
+
 const commandTemplate = `
 The sub-commands are:
 {{range $name, $cmd := .SubCommands}}
@@ -224,5 +248,9 @@ func usage() {
 	flag.PrintDefaults()
 	t = template.Must(template.New("usageTemplate2").Parse(usageTemplate2))
 	t.Execute(os.Stderr, commands)
+	if plugins := findPlugins(); len(plugins) > 0 {
+		t = template.Must(template.New("pluginTemplate").Parse(pluginTemplate))
+		t.Execute(os.Stderr, pluginNames(plugins))
+	}
 	os.Exit(2)
 }
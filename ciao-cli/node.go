@@ -21,7 +21,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/intel/tfortools"
@@ -30,10 +32,15 @@ import (
 
 var nodeCommand = &command{
 	SubCommands: map[string]subCommand{
-		"list":     new(nodeListCommand),
-		"show":     new(nodeShowCommand),
-		"evacuate": new(nodeEvacuateCommand),
-		"restore":  new(nodeRestoreCommand),
+		"list":      new(nodeListCommand),
+		"show":      new(nodeShowCommand),
+		"evacuate":  new(nodeEvacuateCommand),
+		"restore":   new(nodeRestoreCommand),
+		"drain":     new(nodeDrainCommand),
+		"command":   new(nodeRunCommand),
+		"commands":  new(nodeCommandsCommand),
+		"shadow":    new(nodeShadowCommand),
+		"rebalance": new(nodeRebalanceCommand),
 	},
 }
 
@@ -85,7 +92,7 @@ func (cmd *nodeListCommand) parseArgs(args []string) []string {
 	cmd.Flag.BoolVar(&cmd.network, "network", false, "List all network nodes")
 	cmd.Flag.BoolVar(&cmd.all, "all", false, "List all nodes")
 	cmd.Flag.BoolVar(&cmd.cnci, "cnci", false, "List all CNCIs")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -130,6 +137,27 @@ func dumpNode(node *types.CiaoNode) {
 	fmt.Printf("\t\tTotal Start Failures: %d\n", node.StartFailures)
 	fmt.Printf("\t\tTotal Delete Failures: %d\n", node.DeleteFailures)
 	fmt.Printf("\t\tTotal Attach Failures: %d\n", node.AttachVolumeFailures)
+	if node.Health != nil {
+		dumpNodeHealth(node.Health)
+	}
+}
+
+func dumpNodeHealth(health *types.CiaoNodeHealth) {
+	fmt.Printf("\tHealth:\n")
+	for _, t := range health.Temperatures {
+		fmt.Printf("\t\tTemperature %s: %d C\n", t.Sensor, t.Celsius)
+	}
+	if health.CorrectedECCErrors >= 0 {
+		fmt.Printf("\t\tCorrected/Uncorrected memory errors: %d/%d\n",
+			health.CorrectedECCErrors, health.UncorrectedECCErrors)
+	}
+	for _, d := range health.Disks {
+		status := "PASSED"
+		if !d.Healthy {
+			status = "FAILED"
+		}
+		fmt.Printf("\t\tDisk %s SMART health: %s\n", d.Device, status)
+	}
 }
 
 func dumpNodes(headerText string, nodes types.CiaoNodes, t *template.Template) {
@@ -227,7 +255,7 @@ The template passed to the -f option operates on one of the following types:
 func (cmd *nodeShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.BoolVar(&cmd.cnci, "cnci", false, "Show info about a cnci node")
 	cmd.Flag.StringVar(&cmd.nodeID, "node-id", "", "Node ID")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -262,8 +290,7 @@ func showNode(cmd *nodeShowCommand) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "node-show", cmd.template,
-			node, nil)
+		return outputToTemplate("node-show", cmd.template, node)
 	}
 
 	dumpNode(node)
@@ -279,8 +306,7 @@ func showCNCINode(cmd *nodeShowCommand) error {
 	var cnci types.CiaoCNCI
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "node-show", cmd.template,
-			&cnci, nil)
+		return outputToTemplate("node-show", cmd.template, &cnci)
 	}
 
 	fmt.Printf("\tCNCI UUID: %s\n", cnci.ID)
@@ -346,3 +372,379 @@ func (cmd *nodeRestoreCommand) parseArgs(args []string) []string {
 func (cmd *nodeRestoreCommand) run(args []string) error {
 	return c.ChangeNodeStatus(cmd.nodeID, types.NodeStatusReady)
 }
+
+type nodeDrainCommand struct {
+	Flag   flag.FlagSet
+	nodeID string
+	delete bool
+	yes    bool
+}
+
+func (cmd *nodeDrainCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] node drain [-delete [-yes]] <node-id>
+
+Evacuate all the instances of a node and wait for the node to be empty.
+With -delete the node is then removed from the cluster, after asking for
+confirmation if the node hosts instances.
+
+The drain flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *nodeDrainCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.nodeID, "node-id", "", "Node ID")
+	cmd.Flag.BoolVar(&cmd.delete, "delete", false, "Remove the node once evacuated")
+	cmd.Flag.BoolVar(&cmd.yes, "yes", false, "Remove the node without asking for confirmation")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *nodeDrainCommand) run(args []string) error {
+	if cmd.nodeID == "" && len(args) > 0 {
+		cmd.nodeID = args[0]
+	}
+
+	if cmd.nodeID == "" {
+		errorf("Missing required -node-id parameter")
+		cmd.usage()
+	}
+
+	token := ""
+	if cmd.delete {
+		plan, err := c.PlanNodeDeletion(cmd.nodeID)
+		if err != nil {
+			return errors.Wrap(err, "Error planning node removal")
+		}
+
+		if plan.Impact["instances"] > 0 && !confirmDryRun(plan, cmd.yes) {
+			return nil
+		}
+		token = plan.Token
+	}
+
+	status, err := c.DrainNode(cmd.nodeID, cmd.delete, token)
+	if err != nil {
+		return errors.Wrap(err, "Error draining node")
+	}
+
+	left := -1
+	for status.State == types.NodeDrainEvacuating {
+		if status.Instances != left {
+			left = status.Instances
+			fmt.Printf("Evacuating node %s: %d instances left\n", cmd.nodeID, left)
+		}
+
+		time.Sleep(2 * time.Second)
+
+		status, err = c.GetNodeDrainStatus(cmd.nodeID)
+		if err != nil {
+			return errors.Wrap(err, "Error getting node drain status")
+		}
+	}
+
+	switch status.State {
+	case types.NodeDrainFailed:
+		return fmt.Errorf("Error draining node %s: %s", cmd.nodeID, status.Error)
+	case types.NodeDrainDeleted:
+		fmt.Printf("Node %s drained and deleted\n", cmd.nodeID)
+	default:
+		fmt.Printf("Node %s drained\n", cmd.nodeID)
+	}
+
+	return nil
+}
+
+type nodeRunCommand struct {
+	Flag   flag.FlagSet
+	nodeID string
+	noWait bool
+}
+
+func (cmd *nodeRunCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] node command [-no-wait] <command> <node-id>
+
+Run a command on a node and wait for its result. The commands are:
+
+	evacuate       Evacuate the instances of the node
+	reconfigure    Send the cluster configuration to the node again
+	restart-agent  Restart the agent service of the node
+	self-test      Run the connectivity self-test of the node
+
+The command flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *nodeRunCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.nodeID, "node-id", "", "Node ID")
+	cmd.Flag.BoolVar(&cmd.noWait, "no-wait", false, "Do not wait for the command to complete")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *nodeRunCommand) run(args []string) error {
+	if len(args) < 1 {
+		errorf("Missing command")
+		cmd.usage()
+	}
+
+	if cmd.nodeID == "" && len(args) > 1 {
+		cmd.nodeID = args[1]
+	}
+
+	if cmd.nodeID == "" {
+		errorf("Missing required -node-id parameter")
+		cmd.usage()
+	}
+
+	status, err := c.RunNodeCommand(cmd.nodeID, types.NodeCommandType(args[0]))
+	if err != nil {
+		return errors.Wrap(err, "Error running node command")
+	}
+
+	if cmd.noWait {
+		fmt.Printf("Command %s sent to node %s\n", status.ID, cmd.nodeID)
+		return nil
+	}
+
+	for status.State == types.NodeCommandPending {
+		time.Sleep(2 * time.Second)
+
+		status, err = c.GetNodeCommand(cmd.nodeID, status.ID)
+		if err != nil {
+			return errors.Wrap(err, "Error getting node command status")
+		}
+	}
+
+	for _, check := range status.Checks {
+		result := "ok"
+		if !check.Success {
+			result = "failed: " + check.Message
+		}
+		fmt.Printf("%s: %s\n", check.Name, result)
+	}
+
+	if status.State == types.NodeCommandFailed {
+		return fmt.Errorf("Error running %s on node %s: %s", status.Command, cmd.nodeID, status.Error)
+	}
+
+	fmt.Printf("Command %s completed on node %s\n", status.Command, cmd.nodeID)
+	return nil
+}
+
+type nodeCommandsCommand struct {
+	Flag     flag.FlagSet
+	nodeID   string
+	template string
+}
+
+func (cmd *nodeCommandsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] node commands <node-id>
+
+List the commands recently run on a node
+
+The commands flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated(types.NodeCommandListResponse{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *nodeCommandsCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.nodeID, "node-id", "", "Node ID")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *nodeCommandsCommand) run(args []string) error {
+	if cmd.nodeID == "" && len(args) > 0 {
+		cmd.nodeID = args[0]
+	}
+
+	if cmd.nodeID == "" {
+		errorf("Missing required -node-id parameter")
+		cmd.usage()
+	}
+
+	resp, err := c.ListNodeCommands(cmd.nodeID)
+	if err != nil {
+		return errors.Wrap(err, "Error listing node commands")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("node-commands", cmd.template, resp)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "ID\tCommand\tState\tStarted\tError\n")
+	for _, cmd := range resp.Commands {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", cmd.ID, cmd.Command, cmd.State,
+			cmd.Started.Format(time.RFC3339), cmd.Error)
+	}
+	w.Flush()
+
+	return nil
+}
+
+type nodeShadowCommand struct {
+	Flag     flag.FlagSet
+	all      bool
+	template string
+}
+
+func (cmd *nodeShadowCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] node shadow [flags]
+
+Compare the placements of the shadow scheduling policy of the controller
+with the nodes the scheduler actually picked. With -all every recorded
+placement is listed.
+
+The shadow flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated(types.ShadowPlacementsResponse{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *nodeShadowCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.all, "all", false, "List all the recorded placements")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *nodeShadowCommand) run(args []string) error {
+	resp, err := c.ListShadowPlacements()
+	if err != nil {
+		return errors.Wrap(err, "Error listing shadow placements")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("node-shadow", cmd.template, resp)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Policy\tPlacements\tAgreed\tDisagreed\tUnplaceable\tPending\n")
+	for _, s := range resp.Summaries {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", s.Policy, s.Placements,
+			s.Agreed, s.Disagreed, s.Unplaceable, s.Pending)
+	}
+	w.Flush()
+
+	if !cmd.all {
+		return nil
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Instance\tPolicy\tShadow node\tActual node\n")
+	for _, p := range resp.Placements {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.InstanceID, p.Policy,
+			p.ShadowNodeID, p.ActualNodeID)
+	}
+	w.Flush()
+
+	return nil
+}
+
+type nodeRebalanceCommand struct {
+	Flag     flag.FlagSet
+	dryRun   bool
+	maxMoves int
+}
+
+func (cmd *nodeRebalanceCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] node rebalance [flags]
+
+Migrate instances from the most to the least loaded compute nodes until
+their memory usage is even, and wait for the migrations to complete.
+With -dry-run the planned migrations are only listed.
+
+The rebalance flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *nodeRebalanceCommand) parseArgs(args []string) []string {
+	cmd.Flag.BoolVar(&cmd.dryRun, "dry-run", false, "List the planned migrations without running them")
+	cmd.Flag.IntVar(&cmd.maxMoves, "max-moves", 0, "Maximum number of migrations (0 for the controller default)")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func printRebalanceMoves(moves []types.RebalanceMove) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Instance\tTenant\tFrom node\tTo node\tMemory (MB)\tStatus\n")
+	for _, m := range moves {
+		status := "pending"
+		if m.Error != "" {
+			status = "failed: " + m.Error
+		} else if m.Done {
+			status = "done"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", m.InstanceID, m.TenantID,
+			m.From, m.To, m.MemMB, status)
+	}
+	w.Flush()
+}
+
+func (cmd *nodeRebalanceCommand) run(args []string) error {
+	if cmd.maxMoves < 0 {
+		errorf("Invalid -max-moves parameter")
+		cmd.usage()
+	}
+
+	status, err := c.RebalanceNodes(cmd.dryRun, cmd.maxMoves)
+	if err != nil {
+		return errors.Wrap(err, "Error rebalancing nodes")
+	}
+
+	if len(status.Moves) == 0 {
+		fmt.Println("Nodes already balanced")
+		return nil
+	}
+
+	if cmd.dryRun {
+		printRebalanceMoves(status.Moves)
+		return nil
+	}
+
+	fmt.Printf("Migrating %d instances\n", len(status.Moves))
+	for status.State == types.RebalanceRunning {
+		time.Sleep(2 * time.Second)
+
+		status, err = c.GetRebalanceStatus()
+		if err != nil {
+			return errors.Wrap(err, "Error getting rebalance status")
+		}
+	}
+
+	printRebalanceMoves(status.Moves)
+
+	if status.State == types.RebalanceFailed {
+		return fmt.Errorf("Error rebalancing nodes: %s", status.Error)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pluginPrefix is the prefix of the executables on PATH which provide
+// the commands unknown to ciao-cli, e.g. ciao-backup for "ciao-cli backup".
+const pluginPrefix = "ciao-"
+
+const (
+	ciaoTenantIDEnv          = "CIAO_TENANT_ID"
+	ciaoImpersonateTenantEnv = "CIAO_IMPERSONATE_TENANT"
+)
+
+// ciaoBinaries are the ciao components whose executables share the prefix
+// of the plugins without being ones.
+var ciaoBinaries = map[string]bool{
+	"cli":             true,
+	"cert":            true,
+	"cnci-agent":      true,
+	"controller":      true,
+	"deploy":          true,
+	"launcher":        true,
+	"launcher-server": true,
+	"release":         true,
+	"scheduler":       true,
+}
+
+// findPlugins returns the paths of the plugins found on PATH indexed by
+// the name of the command they provide. Plugins cannot override the
+// commands of ciao-cli, and earlier PATH entries win over later ones.
+func findPlugins() map[string]string {
+	plugins := make(map[string]string)
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			name := strings.TrimPrefix(f.Name(), pluginPrefix)
+			if name == f.Name() || name == "" || ciaoBinaries[name] {
+				continue
+			}
+
+			if _, ok := commands[name]; ok {
+				continue
+			}
+
+			if _, ok := plugins[name]; ok {
+				continue
+			}
+
+			path := filepath.Join(dir, f.Name())
+			fi, err := os.Stat(path)
+			if err != nil || fi.IsDir() || fi.Mode()&0111 == 0 {
+				continue
+			}
+
+			plugins[name] = path
+		}
+	}
+
+	return plugins
+}
+
+// pluginNames returns the sorted names of the commands provided by plugins.
+func pluginNames(plugins map[string]string) []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// pluginEnv returns the environment of a plugin, in which the controller
+// and credentials ciao-cli was given, on its command line or in its own
+// environment, are set.
+func pluginEnv() []string {
+	vars := map[string]string{
+		ciaoControllerEnv:        c.ControllerURL,
+		ciaoCACertFileEnv:        c.CACertFile,
+		ciaoClientCertFileEnv:    c.ClientCertFile,
+		ciaoTenantIDEnv:          c.TenantID,
+		ciaoImpersonateTenantEnv: c.ImpersonateTenant,
+	}
+
+	var env []string
+	for _, e := range os.Environ() {
+		name := strings.SplitN(e, "=", 2)[0]
+		if _, ok := vars[name]; !ok {
+			env = append(env, e)
+		}
+	}
+
+	for name, value := range vars {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	return env
+}
+
+// execPlugin runs the plugin at path with the arguments following the name
+// of its command and returns the exit status of the plugin.
+func execPlugin(path string, args []string) (int, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = pluginEnv()
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+
+	return 0, err
+}
+
+// runPlugin runs the plugin at path with the arguments following the name
+// of its command and exits with the status of the plugin.
+func runPlugin(path string, args []string) {
+	infof("Running plugin %s %v\n", path, args)
+
+	status, err := execPlugin(path, args)
+	if err != nil {
+		fatalf("Error running plugin %s: %v", path, err)
+	}
+
+	os.Exit(status)
+}
@@ -0,0 +1,296 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ciao-project/ciao/client"
+)
+
+const fakePlugin = "#!/bin/sh\nexit 0\n"
+
+// setPath points PATH at dirs, returning a function restoring it.
+func setPath(t *testing.T, dirs ...string) func() {
+	oldPath := os.Getenv("PATH")
+	if err := os.Setenv("PATH", strings.Join(dirs, string(os.PathListSeparator))); err != nil {
+		t.Fatalf("Unable to set PATH: %v", err)
+	}
+
+	return func() { _ = os.Setenv("PATH", oldPath) }
+}
+
+func writePlugin(t *testing.T, dir, name, script string, mode os.FileMode) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(script), mode); err != nil {
+		t.Fatalf("Unable to write %s: %v", path, err)
+	}
+
+	return path
+}
+
+func TestFindPlugins(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ciao-cli-plugins")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	first := filepath.Join(tmpDir, "first")
+	second := filepath.Join(tmpDir, "second")
+	missing := filepath.Join(tmpDir, "missing")
+	for _, dir := range []string{first, second, filepath.Join(first, "ciao-dir")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Unable to create %s: %v", dir, err)
+		}
+	}
+
+	backup := writePlugin(t, first, "ciao-backup", fakePlugin, 0755)
+	writePlugin(t, first, "ciao-noexec", fakePlugin, 0644)
+	writePlugin(t, first, "ciao-instance", fakePlugin, 0755)
+	writePlugin(t, first, "ciao-launcher", fakePlugin, 0755)
+	writePlugin(t, first, "ciao-", fakePlugin, 0755)
+	writePlugin(t, first, "backup", fakePlugin, 0755)
+	secondBackup := writePlugin(t, second, "ciao-backup", fakePlugin, 0755)
+	mirror := writePlugin(t, second, "ciao-mirror", fakePlugin, 0755)
+
+	tests := []struct {
+		name    string
+		path    []string
+		plugins map[string]string
+	}{
+		{
+			name:    "empty PATH",
+			path:    nil,
+			plugins: map[string]string{},
+		},
+		{
+			name:    "missing directory",
+			path:    []string{missing},
+			plugins: map[string]string{},
+		},
+		{
+			name:    "single directory",
+			path:    []string{first},
+			plugins: map[string]string{"backup": backup},
+		},
+		{
+			name:    "first entry wins",
+			path:    []string{first, missing, second},
+			plugins: map[string]string{"backup": backup, "mirror": mirror},
+		},
+		{
+			name:    "reversed PATH",
+			path:    []string{second, first},
+			plugins: map[string]string{"backup": secondBackup, "mirror": mirror},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setPath(t, test.path...)()
+
+			plugins := findPlugins()
+			if !reflect.DeepEqual(plugins, test.plugins) {
+				t.Errorf("Expected plugins %v, got %v", test.plugins, plugins)
+			}
+		})
+	}
+}
+
+func TestFindPluginsCurrentDirectory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ciao-cli-plugins")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	writePlugin(t, tmpDir, "ciao-backup", fakePlugin, 0755)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to get working directory: %v", err)
+	}
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("Unable to change directory: %v", err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	defer setPath(t, "", filepath.Join(tmpDir, "missing"))()
+
+	plugins := findPlugins()
+	expected := map[string]string{"backup": "ciao-backup"}
+	if !reflect.DeepEqual(plugins, expected) {
+		t.Errorf("Expected plugins %v, got %v", expected, plugins)
+	}
+}
+
+func TestPluginNames(t *testing.T) {
+	names := pluginNames(map[string]string{
+		"report": "/bin/ciao-report",
+		"backup": "/bin/ciao-backup",
+		"mirror": "/bin/ciao-mirror",
+	})
+
+	expected := []string{"backup", "mirror", "report"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected names %v, got %v", expected, names)
+	}
+}
+
+// readPluginEnv returns the CIAO_ variables in the environment dumped by
+// the env plugin.
+func readPluginEnv(t *testing.T, path string) map[string]string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read plugin output: %v", err)
+	}
+
+	env := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.HasPrefix(kv[0], "CIAO_") {
+			env[kv[0]] = kv[1]
+		}
+	}
+
+	return env
+}
+
+func TestExecPlugin(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ciao-cli-plugins")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	envOut := filepath.Join(tmpDir, "env.out")
+	argsOut := filepath.Join(tmpDir, "args.out")
+	envPlugin := writePlugin(t, tmpDir, "ciao-env",
+		"#!/bin/sh\nenv > "+envOut+"\necho \"$@\" > "+argsOut+"\n", 0755)
+	failPlugin := writePlugin(t, tmpDir, "ciao-fail", "#!/bin/sh\nexit 3\n", 0755)
+
+	inherited := map[string]string{
+		ciaoControllerEnv:        "inherited.example.com",
+		ciaoCACertFileEnv:        "/inherited/ca.pem",
+		ciaoClientCertFileEnv:    "/inherited/cert.pem",
+		ciaoTenantIDEnv:          "inherited-tenant",
+		ciaoImpersonateTenantEnv: "inherited-impersonated",
+	}
+	for name, value := range inherited {
+		oldValue, ok := os.LookupEnv(name)
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatalf("Unable to set %s: %v", name, err)
+		}
+		defer func(name string) {
+			if ok {
+				_ = os.Setenv(name, oldValue)
+			} else {
+				_ = os.Unsetenv(name)
+			}
+		}(name)
+	}
+
+	oldClient := c
+	defer func() { c = oldClient }()
+
+	tests := []struct {
+		name   string
+		client client.Client
+		env    map[string]string
+	}{
+		{
+			name: "all set",
+			client: client.Client{
+				ControllerURL:     "controller.example.com",
+				CACertFile:        "/ciao/ca.pem",
+				ClientCertFile:    "/ciao/cert.pem",
+				TenantID:          "tenant",
+				ImpersonateTenant: "impersonated",
+			},
+			env: map[string]string{
+				ciaoControllerEnv:        "controller.example.com",
+				ciaoCACertFileEnv:        "/ciao/ca.pem",
+				ciaoClientCertFileEnv:    "/ciao/cert.pem",
+				ciaoTenantIDEnv:          "tenant",
+				ciaoImpersonateTenantEnv: "impersonated",
+			},
+		},
+		{
+			name: "unset values stripped",
+			client: client.Client{
+				ControllerURL:  "controller.example.com",
+				ClientCertFile: "/ciao/cert.pem",
+			},
+			env: map[string]string{
+				ciaoControllerEnv:     "controller.example.com",
+				ciaoClientCertFileEnv: "/ciao/cert.pem",
+			},
+		},
+		{
+			name:   "nothing set",
+			client: client.Client{},
+			env:    map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c = test.client
+
+			status, err := execPlugin(envPlugin, []string{"-all", "extra arg"})
+			if err != nil {
+				t.Fatalf("Unable to run plugin: %v", err)
+			}
+			if status != 0 {
+				t.Errorf("Expected exit status 0, got %d", status)
+			}
+
+			env := readPluginEnv(t, envOut)
+			if !reflect.DeepEqual(env, test.env) {
+				t.Errorf("Expected plugin environment %v, got %v", test.env, env)
+			}
+
+			args, err := ioutil.ReadFile(argsOut)
+			if err != nil {
+				t.Fatalf("Unable to read plugin arguments: %v", err)
+			}
+			if string(args) != "-all extra arg\n" {
+				t.Errorf("Unexpected plugin arguments %q", string(args))
+			}
+		})
+	}
+
+	c = client.Client{}
+
+	status, err := execPlugin(failPlugin, nil)
+	if err != nil {
+		t.Fatalf("Unable to run plugin: %v", err)
+	}
+	if status != 3 {
+		t.Errorf("Expected exit status 3, got %d", status)
+	}
+
+	if _, err := execPlugin(filepath.Join(tmpDir, "ciao-missing"), nil); err == nil {
+		t.Errorf("Expected error running missing plugin")
+	}
+}
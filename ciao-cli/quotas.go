@@ -31,8 +31,11 @@ import (
 
 var quotasCommand = &command{
 	SubCommands: map[string]subCommand{
-		"update": new(quotasUpdateCommand),
-		"list":   new(quotasListCommand),
+		"update":         new(quotasUpdateCommand),
+		"list":           new(quotasListCommand),
+		"profiles":       new(quotasProfilesCommand),
+		"profile-update": new(quotasProfileUpdateCommand),
+		"profile-delete": new(quotasProfileDeleteCommand),
 	},
 }
 
@@ -137,7 +140,7 @@ The template passed to the -f option operates on a
 func (cmd *quotasListCommand) parseArgs(args []string) []string {
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.StringVar(&cmd.tenantID, "for-tenant", "", "Tenant to get quotas for")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
@@ -163,8 +166,7 @@ func (cmd *quotasListCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "quotas-list", cmd.template,
-			qds, nil)
+		return outputToTemplate("quotas-list", cmd.template, qds)
 	}
 
 	fmt.Printf("Quotas for tenant: %s\n", cmd.tenantID)
@@ -183,3 +185,196 @@ func (cmd *quotasListCommand) run(args []string) error {
 	w.Flush()
 	return nil
 }
+
+type quotasProfilesCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *quotasProfilesCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] quotas profiles [flags]
+
+Show the quota profiles that can be assigned to tenants
+
+The profiles flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a 
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.QuotaProfile{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+
+	os.Exit(2)
+}
+
+func (cmd *quotasProfilesCommand) parseArgs(args []string) []string {
+	cmd.Flag.Usage = func() { cmd.usage() }
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *quotasProfilesCommand) run(args []string) error {
+	profiles, err := c.ListQuotaProfiles()
+	if err != nil {
+		return errors.Wrap(err, "Error listing quota profiles")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("quotas-profiles", cmd.template, profiles)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, p := range profiles {
+		fmt.Fprintf(w, "Profile: %s\n", p.Name)
+		for _, qd := range p.Quotas {
+			fmt.Fprintf(w, "\t%s:\t", qd.Name)
+			if qd.Value == -1 {
+				fmt.Fprint(w, "unlimited\n")
+			} else {
+				fmt.Fprintf(w, "%d\n", qd.Value)
+			}
+		}
+	}
+	w.Flush()
+	return nil
+}
+
+type quotasProfileUpdateCommand struct {
+	Flag    flag.FlagSet
+	profile string
+	name    string
+	value   string
+}
+
+func (cmd *quotasProfileUpdateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] quotas profile-update [flags]
+
+Sets a quota entry of the supplied profile, creating the profile if needed.
+Tenants assigned to the profile are updated too.
+
+The profile-update flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *quotasProfileUpdateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.profile, "profile", "", "Name of the quota profile")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of quota or limit")
+	cmd.Flag.StringVar(&cmd.value, "value", "", "Value of quota or limit")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *quotasProfileUpdateCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Updating quota profiles is only available for privileged users")
+	}
+
+	if cmd.profile == "" {
+		errorf("Missing required -profile parameter")
+		cmd.usage()
+	}
+
+	if cmd.name == "" {
+		errorf("Missing required -name parameter")
+		cmd.usage()
+	}
+
+	if cmd.value == "" {
+		errorf("Missing required -value parameter")
+		cmd.usage()
+	}
+
+	var v int
+	if cmd.value == "unlimited" {
+		v = -1
+	} else {
+		var err error
+		v, err = strconv.Atoi(cmd.value)
+		if err != nil {
+			fatalf(err.Error())
+		}
+	}
+
+	profiles, err := c.ListQuotaProfiles()
+	if err != nil {
+		return errors.Wrap(err, "Error listing quota profiles")
+	}
+
+	var quotas []types.QuotaDetails
+	for _, p := range profiles {
+		if p.Name != cmd.profile {
+			continue
+		}
+		for _, qd := range p.Quotas {
+			if qd.Name != cmd.name {
+				quotas = append(quotas, qd)
+			}
+		}
+	}
+
+	quotas = append(quotas, types.QuotaDetails{
+		Name:  cmd.name,
+		Value: v,
+	})
+
+	err = c.UpdateQuotaProfile(cmd.profile, quotas)
+	if err != nil {
+		return errors.Wrap(err, "Error updating quota profile")
+	}
+
+	fmt.Printf("Update quota profile succeeded\n")
+
+	return nil
+}
+
+type quotasProfileDeleteCommand struct {
+	Flag    flag.FlagSet
+	profile string
+}
+
+func (cmd *quotasProfileDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] quotas profile-delete [flags]
+
+Deletes a quota profile not assigned to any tenant
+
+The profile-delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *quotasProfileDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.profile, "profile", "", "Name of the quota profile")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *quotasProfileDeleteCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Deleting quota profiles is only available for privileged users")
+	}
+
+	if cmd.profile == "" {
+		errorf("Missing required -profile parameter")
+		cmd.usage()
+	}
+
+	err := c.DeleteQuotaProfile(cmd.profile)
+	if err != nil {
+		return errors.Wrap(err, "Error deleting quota profile")
+	}
+
+	fmt.Printf("Deleted quota profile %s\n", cmd.profile)
+
+	return nil
+}
@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+)
+
+var reportCommand = &command{
+	SubCommands: map[string]subCommand{
+		"list": new(reportListCommand),
+		"run":  new(reportRunCommand),
+	},
+}
+
+type reportListCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *reportListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] report list [flags]
+
+List the tenant reports scheduled with the -report_config option of the
+controller and the outcome of their last run
+
+The list flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.ReportStatus{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+
+	os.Exit(2)
+}
+
+func (cmd *reportListCommand) parseArgs(args []string) []string {
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *reportListCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Listing reports is only available for privileged users")
+	}
+
+	reports, err := c.ListReports()
+	if err != nil {
+		return errors.Wrap(err, "Error listing reports")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("report-list", cmd.template, reports)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Name\tFormat\tInterval\tLast run\tNext run\tDelivered\tErrors\n")
+	for _, r := range reports {
+		lastRun := "never"
+		if !r.LastRun.IsZero() {
+			lastRun = r.LastRun.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", r.Name, r.Format, r.Interval,
+			lastRun, r.NextRun.Format("2006-01-02 15:04:05"), r.Delivered, len(r.Errors))
+	}
+	w.Flush()
+
+	for _, r := range reports {
+		for _, e := range r.Errors {
+			fmt.Printf("%s: %s\n", r.Name, e)
+		}
+	}
+
+	return nil
+}
+
+type reportRunCommand struct {
+	Flag flag.FlagSet
+	name string
+}
+
+func (cmd *reportRunCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] report run [flags]
+
+Generate and deliver a scheduled tenant report straight away
+
+The run flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *reportRunCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of the report")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *reportRunCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Running reports is only available for privileged users")
+	}
+
+	if cmd.name == "" {
+		return errors.New("Missing required -name parameter")
+	}
+
+	_, err := c.RunReport(cmd.name)
+	if err != nil {
+		return errors.Wrap(err, "Error running report")
+	}
+
+	fmt.Printf("Generating report %s\n", cmd.name)
+
+	return nil
+}
@@ -0,0 +1,270 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/intel/tfortools"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+var stackCommand = &command{
+	SubCommands: map[string]subCommand{
+		"create": new(stackCreateCommand),
+		"show":   new(stackShowCommand),
+		"list":   new(stackListCommand),
+	},
+}
+
+type stackGroupOptions struct {
+	Name         string   `yaml:"name"`
+	Workload     string   `yaml:"workload"`
+	Instances    int      `yaml:"instances"`
+	InstanceName string   `yaml:"instance_name"`
+	DependsOn    []string `yaml:"depends_on"`
+}
+
+type stackOptions struct {
+	Name      string              `yaml:"name"`
+	Timeout   int                 `yaml:"timeout"`
+	OnFailure string              `yaml:"on_failure"`
+	Groups    []stackGroupOptions `yaml:"groups"`
+}
+
+type stackCreateCommand struct {
+	Flag     flag.FlagSet
+	yamlFile string
+	wait     bool
+	template string
+}
+
+func (cmd *stackCreateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] stack create [flags]
+
+Launches the groups of instances of a stack described in a yaml file, e.g.
+
+name: web
+timeout: 600
+on_failure: abort
+groups:
+- name: db
+  workload: <workload UUID>
+- name: app
+  workload: <workload UUID>
+  instances: 2
+  instance_name: app-{index}
+  depends_on: [db]
+
+The instances of a group are launched once the instances of the groups it
+depends on are running.  timeout is the number of seconds the instances of
+a group have to be running.  on_failure is abort, not to launch the groups
+depending on a failed group, or continue.
+
+The create flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", tfortools.GenerateUsageDecorated("f", types.StackStatus{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *stackCreateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.yamlFile, "yaml", "", "filename for yaml which describes the stack")
+	cmd.Flag.BoolVar(&cmd.wait, "wait", false, "Wait for the stack to be launched")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *stackCreateCommand) run(args []string) error {
+	if cmd.yamlFile == "" {
+		return errors.New("Missing required -yaml parameter")
+	}
+
+	f, err := ioutil.ReadFile(cmd.yamlFile)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read stack config file")
+	}
+
+	var opt stackOptions
+	err = yaml.Unmarshal(f, &opt)
+	if err != nil {
+		return errors.Wrap(err, "Config file invalid")
+	}
+
+	req := api.CreateStackRequest{
+		Name:      opt.Name,
+		Timeout:   opt.Timeout,
+		OnFailure: types.StackFailurePolicy(opt.OnFailure),
+	}
+
+	for _, g := range opt.Groups {
+		req.Groups = append(req.Groups, api.StackGroup{
+			Name:         g.Name,
+			WorkloadID:   g.Workload,
+			Instances:    g.Instances,
+			InstanceName: g.InstanceName,
+			DependsOn:    g.DependsOn,
+		})
+	}
+
+	stack, err := c.CreateStack(req)
+	if err != nil {
+		return errors.Wrap(err, "Error creating stack")
+	}
+
+	for cmd.wait && stack.State == types.StackLaunching {
+		time.Sleep(2 * time.Second)
+
+		stack, err = c.GetStack(stack.ID)
+		if err != nil {
+			return errors.Wrap(err, "Error getting stack")
+		}
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("stack-create", cmd.template, stack)
+	}
+
+	dumpStack(&stack)
+
+	if stack.State == types.StackFailed {
+		return fmt.Errorf("Stack %s failed", stack.ID)
+	}
+
+	return nil
+}
+
+type stackShowCommand struct {
+	Flag     flag.FlagSet
+	stack    string
+	template string
+}
+
+func (cmd *stackShowCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] stack show [flags]
+
+Show the progress of the launch of a stack
+
+The show flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", tfortools.GenerateUsageDecorated("f", types.StackStatus{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *stackShowCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.stack, "stack", "", "Stack UUID")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *stackShowCommand) run(args []string) error {
+	if cmd.stack == "" {
+		return errors.New("Missing required -stack parameter")
+	}
+
+	stack, err := c.GetStack(cmd.stack)
+	if err != nil {
+		return errors.Wrap(err, "Error getting stack")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("stack-show", cmd.template, stack)
+	}
+
+	dumpStack(&stack)
+
+	return nil
+}
+
+type stackListCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *stackListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] stack list [flags]
+
+List the recent stacks
+
+The list flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s
+`, tfortools.GenerateUsageUndecorated([]types.StackStatus{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *stackListCommand) parseArgs(args []string) []string {
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *stackListCommand) run(args []string) error {
+	stacks, err := c.ListStacks()
+	if err != nil {
+		return errors.Wrap(err, "Error listing stacks")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("stack-list", cmd.template, stacks)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "ID\tName\tState\tGroups\tStarted\n")
+	for _, s := range stacks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", s.ID, s.Name, s.State, len(s.Groups),
+			s.Started.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+
+	return nil
+}
+
+func dumpStack(s *types.StackStatus) {
+	fmt.Printf("Stack %s [%s]: %s\n", s.Name, s.ID, s.State)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Group\tDepends on\tState\tInstances\tError\n")
+	for _, g := range s.Groups {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", g.Name, strings.Join(g.DependsOn, ","),
+			g.State, strings.Join(g.Instances, ","), g.Error)
+	}
+	w.Flush()
+}
@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/intel/tfortools"
+)
+
+// builtinTemplates can be selected with -f @name instead of a template.
+// They format collections of objects, a single object being formatted as
+// a collection of one.
+var builtinTemplates = map[string]string{
+	"wide":     `{{table .}}`,
+	"ids-only": `{{select . "%s"}}`,
+	"csv":      `{{tocsv .}}`,
+}
+
+func builtinTemplateNames() string {
+	var names []string
+	for name := range builtinTemplates {
+		names = append(names, "@"+name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// addTemplateFlag adds the -f flag selecting the template used to format
+// the output of a command.
+func addTemplateFlag(f *flag.FlagSet, template *string) {
+	f.StringVar(template, "f", "", fmt.Sprintf("Template used to format output, or one of %s", builtinTemplateNames()))
+}
+
+// idField returns the name of the field identifying the elements of a
+// collection, for the ids-only template.
+func idField(elem reflect.Type) (string, error) {
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+
+	if elem.Kind() == reflect.Struct {
+		for _, name := range []string{"ID", "Name"} {
+			if _, ok := elem.FieldByName(name); ok {
+				return name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%s has no ID or Name field", elem)
+}
+
+// builtinTemplate returns the source of the built-in template name, and
+// obj as a collection.
+func builtinTemplate(name string, obj interface{}) (string, interface{}, error) {
+	src, ok := builtinTemplates[name]
+	if !ok {
+		return "", nil, fmt.Errorf("Unknown template @%s, expected one of %s", name, builtinTemplateNames())
+	}
+
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type()), 0, 1)
+		v = reflect.Append(s, v)
+	}
+
+	if name == "ids-only" {
+		field, err := idField(v.Type().Elem())
+		if err != nil {
+			return "", nil, fmt.Errorf("Template @%s not supported: %v", name, err)
+		}
+		src = fmt.Sprintf(src, field)
+	}
+
+	return src, v.Interface(), nil
+}
+
+// outputToTemplate formats obj on the standard output with tmpl, the
+// template given with -f, which is either a Go template or @name, the name
+// of a built-in template.
+func outputToTemplate(name string, tmpl string, obj interface{}) error {
+	if strings.HasPrefix(tmpl, "@") {
+		var err error
+		tmpl, obj, err = builtinTemplate(tmpl[1:], obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tfortools.OutputToTemplate(os.Stdout, name, tmpl, obj, nil)
+}
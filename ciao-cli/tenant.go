@@ -19,8 +19,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/uuid"
@@ -36,10 +41,14 @@ type Project struct {
 
 var tenantCommand = &command{
 	SubCommands: map[string]subCommand{
-		"list":   new(tenantListCommand),
-		"update": new(tenantUpdateCommand),
-		"create": new(tenantCreateCommand),
-		"delete": new(tenantDeleteCommand),
+		"list":     new(tenantListCommand),
+		"update":   new(tenantUpdateCommand),
+		"create":   new(tenantCreateCommand),
+		"delete":   new(tenantDeleteCommand),
+		"subnets":  new(tenantSubnetsCommand),
+		"resubnet": new(tenantResubnetCommand),
+		"ips":      new(tenantIPsCommand),
+		"vnis":     new(tenantVNIsCommand),
 	},
 }
 
@@ -59,6 +68,18 @@ type tenantUpdateCommand struct {
 	cidrPrefixSize             int
 	createPrivilegedContainers bool
 	tenantID                   string
+	quotaProfile               string
+	notifyMode                 string
+	notifyEmail                string
+	notifyDigestMinutes        int
+	maxInstancesPerRequest     int
+	warmPools                  string
+	networkPolicy              string
+	networkRules               string
+	instanceTags               string
+	instanceSSHKey             string
+	forbidPublicWorkloads      bool
+	maintenanceWindows         string
 }
 
 type tenantCreateCommand struct {
@@ -68,11 +89,42 @@ type tenantCreateCommand struct {
 	createPrivilegedContainers bool
 	tenantID                   string
 	template                   string
+	quotaProfile               string
+	notifyMode                 string
+	notifyEmail                string
+	notifyDigestMinutes        int
+	maxInstancesPerRequest     int
+	warmPools                  string
 }
 
 type tenantDeleteCommand struct {
 	Flag     flag.FlagSet
 	tenantID string
+	yes      bool
+}
+
+type tenantSubnetsCommand struct {
+	Flag     flag.FlagSet
+	tenantID string
+	template string
+}
+
+type tenantResubnetCommand struct {
+	Flag           flag.FlagSet
+	tenantID       string
+	cidrPrefixSize int
+}
+
+type tenantIPsCommand struct {
+	Flag     flag.FlagSet
+	tenantID string
+	release  bool
+	template string
+}
+
+type tenantVNIsCommand struct {
+	Flag     flag.FlagSet
+	template string
 }
 
 func (cmd *tenantUpdateCommand) usage(...string) {
@@ -80,6 +132,9 @@ func (cmd *tenantUpdateCommand) usage(...string) {
 
 Updates the configuration for the supplied tenant
 
+The -instance-tags, -instance-ssh-key and -forbid-public-workloads flags
+replace all the instance defaults of the tenant when any of them is given.
+
 The update flags are:
 
 `)
@@ -92,6 +147,18 @@ func (cmd *tenantUpdateCommand) parseArgs(args []string) []string {
 	cmd.Flag.IntVar(&cmd.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
 	cmd.Flag.BoolVar(&cmd.createPrivilegedContainers, "create-privileged-containers", false, "Whether this tenant can create privileged containers")
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Tenant name")
+	cmd.Flag.StringVar(&cmd.quotaProfile, "quota-profile", "", "Quota profile to apply to the tenant")
+	cmd.Flag.StringVar(&cmd.notifyMode, "notify-mode", "", "Email notification of error events: none, immediate or digest")
+	cmd.Flag.StringVar(&cmd.notifyEmail, "notify-email", "", "Address error events are emailed to")
+	cmd.Flag.IntVar(&cmd.notifyDigestMinutes, "notify-digest-minutes", 0, "Interval between digests of error events")
+	cmd.Flag.IntVar(&cmd.maxInstancesPerRequest, "max-instances-per-request", 0, "Maximum number of instances started by a single request, -1 for no limit")
+	cmd.Flag.StringVar(&cmd.warmPools, "warm-pools", "", "Comma separated workload=size booted instances kept ready for the tenant, 0 to empty a pool")
+	cmd.Flag.StringVar(&cmd.networkPolicy, "network-policy", "", "Routing between the tenant's subnets: allow_all, deny_all or rules")
+	cmd.Flag.StringVar(&cmd.networkRules, "network-rules", "", "Comma separated source:destination CIDRs allowed to route with the rules policy")
+	cmd.Flag.StringVar(&cmd.instanceTags, "instance-tags", "", "Comma separated tags given to every new instance of the tenant")
+	cmd.Flag.StringVar(&cmd.instanceSSHKey, "instance-ssh-key", "", "File holding an SSH public key injected in every new VM of the tenant")
+	cmd.Flag.BoolVar(&cmd.forbidPublicWorkloads, "forbid-public-workloads", false, "Restrict the tenant to launching its own workloads")
+	cmd.Flag.StringVar(&cmd.maintenanceWindows, "maintenance-windows", "", "Comma separated weekly windows, e.g. \"sat 22:00-02:00\" in UTC, during which disruptive automated actions may affect the tenant, none to use the cluster windows")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -103,7 +170,12 @@ func (cmd *tenantUpdateCommand) run(args []string) error {
 	}
 
 	// we should not require individual parameters?
-	if cmd.name == "" && cmd.cidrPrefixSize == 0 {
+	if cmd.name == "" && cmd.cidrPrefixSize == 0 && cmd.quotaProfile == "" &&
+		cmd.notifyMode == "" && cmd.notifyEmail == "" && cmd.notifyDigestMinutes == 0 &&
+		cmd.maxInstancesPerRequest == 0 && cmd.warmPools == "" &&
+		cmd.networkPolicy == "" && cmd.networkRules == "" &&
+		cmd.instanceTags == "" && cmd.instanceSSHKey == "" && !cmd.forbidPublicWorkloads &&
+		cmd.maintenanceWindows == "" {
 		errorf("Missing required parameters")
 		cmd.usage()
 	}
@@ -114,11 +186,47 @@ func (cmd *tenantUpdateCommand) run(args []string) error {
 		cmd.usage()
 	}
 
+	warmPools, err := parseWarmPools(cmd.warmPools)
+	if err != nil {
+		errorf("%v", err)
+		cmd.usage()
+	}
+
+	policy, err := parseNetworkPolicy(cmd.networkPolicy, splitList(cmd.networkRules))
+	if err != nil {
+		errorf("%v", err)
+		cmd.usage()
+	}
+
+	var sshKey string
+	if cmd.instanceSSHKey != "" {
+		b, err := ioutil.ReadFile(cmd.instanceSSHKey)
+		if err != nil {
+			return errors.Wrap(err, "Error reading SSH key")
+		}
+		sshKey = strings.TrimSpace(string(b))
+	}
+
 	config := types.TenantConfig{
-		Name:       cmd.name,
-		SubnetBits: cmd.cidrPrefixSize,
+		Name:                   cmd.name,
+		SubnetBits:             cmd.cidrPrefixSize,
+		QuotaProfile:           cmd.quotaProfile,
+		MaxInstancesPerRequest: cmd.maxInstancesPerRequest,
+		WarmPools:              warmPools,
+		NetworkPolicy:          policy,
+		InstanceDefaults: types.InstanceDefaults{
+			Tags:                  splitList(cmd.instanceTags),
+			SSHKey:                sshKey,
+			ForbidPublicWorkloads: cmd.forbidPublicWorkloads,
+		},
+		MaintenanceWindows: types.MaintenanceWindows(cmd.maintenanceWindows),
 	}
 	config.Permissions.PrivilegedContainers = cmd.createPrivilegedContainers
+	config.Notifications = types.NotificationConfig{
+		Mode:          types.NotificationMode(cmd.notifyMode),
+		Email:         cmd.notifyEmail,
+		DigestMinutes: cmd.notifyDigestMinutes,
+	}
 
 	return c.UpdateTenantConfig(cmd.tenantID, config)
 }
@@ -144,7 +252,13 @@ func (cmd *tenantCreateCommand) parseArgs(args []string) []string {
 	cmd.Flag.IntVar(&cmd.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
 	cmd.Flag.BoolVar(&cmd.createPrivilegedContainers, "create-privileged-containers", false, "Whether this tenant can create privileged containers")
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Tenant name")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.StringVar(&cmd.quotaProfile, "quota-profile", "", "Quota profile to apply to the tenant")
+	cmd.Flag.StringVar(&cmd.notifyMode, "notify-mode", "", "Email notification of error events: none, immediate or digest")
+	cmd.Flag.StringVar(&cmd.notifyEmail, "notify-email", "", "Address error events are emailed to")
+	cmd.Flag.IntVar(&cmd.notifyDigestMinutes, "notify-digest-minutes", 0, "Interval between digests of error events")
+	cmd.Flag.IntVar(&cmd.maxInstancesPerRequest, "max-instances-per-request", 0, "Maximum number of instances started by a single request, -1 for no limit")
+	cmd.Flag.StringVar(&cmd.warmPools, "warm-pools", "", "Comma separated workload=size booted instances kept ready for the tenant")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -180,11 +294,25 @@ func (cmd *tenantCreateCommand) run(args []string) error {
 		fatalf("Tenant ID must be a UUID4")
 	}
 
+	warmPools, err := parseWarmPools(cmd.warmPools)
+	if err != nil {
+		errorf("%v", err)
+		cmd.usage()
+	}
+
 	config := types.TenantConfig{
-		Name:       cmd.name,
-		SubnetBits: cmd.cidrPrefixSize,
+		Name:                   cmd.name,
+		SubnetBits:             cmd.cidrPrefixSize,
+		QuotaProfile:           cmd.quotaProfile,
+		MaxInstancesPerRequest: cmd.maxInstancesPerRequest,
+		WarmPools:              warmPools,
 	}
 	config.Permissions.PrivilegedContainers = cmd.createPrivilegedContainers
+	config.Notifications = types.NotificationConfig{
+		Mode:          types.NotificationMode(cmd.notifyMode),
+		Email:         cmd.notifyEmail,
+		DigestMinutes: cmd.notifyDigestMinutes,
+	}
 
 	summary, err := c.CreateTenantConfig(tuuid.String(), config)
 	if err != nil {
@@ -207,7 +335,8 @@ func (cmd *tenantCreateCommand) run(args []string) error {
 func (cmd *tenantDeleteCommand) usage(...string) {
 	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant delete [flags]
 
-Deletes a tenant
+Deletes a tenant along with its instances, volumes, images and workloads.
+What is deleted is shown and must be confirmed, unless -yes is given.
 
 The delete flags are:
 
@@ -218,6 +347,7 @@ The delete flags are:
 
 func (cmd *tenantDeleteCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.tenantID, "tenant", "", "ID for new tenant")
+	cmd.Flag.BoolVar(&cmd.yes, "yes", false, "Delete the tenant without asking for confirmation")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -233,7 +363,16 @@ func (cmd *tenantDeleteCommand) run(args []string) error {
 		cmd.usage()
 	}
 
-	err := c.DeleteTenant(cmd.tenantID)
+	plan, err := c.PlanTenantDeletion(cmd.tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Error planning tenant deletion")
+	}
+
+	if !confirmDryRun(plan, cmd.yes) {
+		return nil
+	}
+
+	err = c.DeleteTenant(cmd.tenantID, plan.Token)
 
 	return errors.Wrap(err, "Error deleting tenant")
 }
@@ -281,7 +420,7 @@ func (cmd *tenantListCommand) parseArgs(args []string) []string {
 	cmd.Flag.BoolVar(&cmd.config, "config", false, "List tenant config")
 	cmd.Flag.BoolVar(&cmd.all, "all", false, "List all known tenants")
 	cmd.Flag.StringVar(&cmd.tenantID, "for-tenant", "", "Tenant to get config for")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -376,6 +515,53 @@ func listTenantQuotas(t *template.Template) error {
 	return nil
 }
 
+// parseWarmPools parses comma separated workload=size warm pools.
+func parseWarmPools(s string) (map[string]int, error) {
+	var warmPools map[string]int
+
+	for _, pool := range splitList(s) {
+		p := strings.SplitN(pool, "=", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("invalid warm pool %q", pool)
+		}
+
+		size, err := strconv.Atoi(p[1])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size of warm pool %q", pool)
+		}
+
+		if warmPools == nil {
+			warmPools = make(map[string]int)
+		}
+		warmPools[p[0]] = size
+	}
+
+	return warmPools, nil
+}
+
+// parseNetworkPolicy builds a network policy from a mode and source:destination
+// rules. Rules without a mode imply the rules mode.
+func parseNetworkPolicy(mode string, rules []string) (types.NetworkPolicy, error) {
+	policy := types.NetworkPolicy{Mode: types.NetworkPolicyMode(mode)}
+	if policy.Mode == "" && len(rules) > 0 {
+		policy.Mode = types.NetworkRules
+	}
+
+	for _, rule := range rules {
+		r := strings.SplitN(rule, ":", 2)
+		if len(r) != 2 || r[0] == "" || r[1] == "" {
+			return policy, fmt.Errorf("invalid network rule %q", rule)
+		}
+
+		policy.Rules = append(policy.Rules, types.NetworkPolicyRule{
+			Source:      r[0],
+			Destination: r[1],
+		})
+	}
+
+	return policy, nil
+}
+
 func listTenantResources(t *template.Template) error {
 	if c.TenantID == "" {
 		fatalf("Missing required -tenant-id parameter")
@@ -400,7 +586,9 @@ func listTenantResources(t *template.Template) error {
 
 	fmt.Printf("Usage for tenant %s:\n", c.TenantID)
 	for _, u := range usage.Usages {
-		fmt.Printf("\t%v: [%d CPUs] [%d MB memory] [%d MB disk]\n", u.Timestamp, u.VCPU, u.Memory, u.Disk)
+		fmt.Printf("\t%v: [%d CPUs] [%d MB memory] [%d MB disk] [%.3f GB received] [%.3f GB sent]\n",
+			u.Timestamp, u.VCPU, u.Memory, u.Disk,
+			float64(u.NetworkRxBytes)/1e9, float64(u.NetworkTxBytes)/1e9)
 	}
 
 	return nil
@@ -423,6 +611,18 @@ func listTenantConfig(t *template.Template, tenantID string) error {
 	fmt.Printf("\tName: %s\n", config.Name)
 	fmt.Printf("\tCIDR Prefix Size: %d\n", config.SubnetBits)
 	fmt.Printf("\tCan create privileged containers: %v\n", config.Permissions.PrivilegedContainers)
+	if config.Notifications.Enabled() {
+		fmt.Printf("\tNotifications: %s to %s\n", config.Notifications.Mode, config.Notifications.Email)
+	}
+	if config.MaxInstancesPerRequest != 0 {
+		fmt.Printf("\tMax instances per request: %d\n", config.MaxInstancesPerRequest)
+	}
+	for workloadID, size := range config.WarmPools {
+		fmt.Printf("\tWarm pool of workload %s: %d\n", workloadID, size)
+	}
+	if config.MaintenanceWindows != "" {
+		fmt.Printf("\tMaintenance windows: %s\n", config.MaintenanceWindows)
+	}
 
 	return nil
 }
@@ -448,3 +648,244 @@ func listAllTenants(t *template.Template) error {
 
 	return nil
 }
+
+func (cmd *tenantSubnetsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant subnets [flags]
+
+Show the CNCI activation status of the subnets of the current tenant or
+of the supplied tenant if admin
+
+The subnets flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.TenantSubnet{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *tenantSubnetsCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.tenantID, "for-tenant", "", "Tenant to get subnets for")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantSubnetsCommand) run(args []string) error {
+	if cmd.tenantID != "" {
+		if !c.IsPrivileged() {
+			fatalf("Listing subnets for other tenants is for privileged users only")
+		}
+	} else {
+		if c.IsPrivileged() {
+			fatalf("Admin user must specify the tenant with -for-tenant")
+		}
+	}
+
+	subnets, err := c.ListTenantSubnets(cmd.tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Error listing tenant subnets")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("tenant-subnets", cmd.template, subnets)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Subnet\tState\tCNCI\tReason\n")
+	for _, s := range subnets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Subnet, s.State, s.CNCIID, s.Reason)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func (cmd *tenantResubnetCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant resubnet [flags]
+
+Change the number of bits in the network mask of a tenant which has
+instances and wait for the change to complete. The running instances of
+the tenant are stopped, given addresses in the new subnets and restarted.
+
+The resubnet flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *tenantResubnetCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.tenantID, "for-tenant", "", "Tenant to move to new subnets")
+	cmd.Flag.IntVar(&cmd.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantResubnetCommand) run(args []string) error {
+	if cmd.tenantID == "" {
+		errorf("Missing required -for-tenant parameter")
+		cmd.usage()
+	}
+
+	if cmd.cidrPrefixSize == 0 {
+		errorf("Missing required -cidr-prefix-size parameter")
+		cmd.usage()
+	}
+
+	status, err := c.ResubnetTenant(cmd.tenantID, cmd.cidrPrefixSize)
+	if err != nil {
+		return errors.Wrap(err, "Error changing tenant subnets")
+	}
+
+	var state types.TenantResubnetState
+	for status.State != types.TenantResubnetDone && status.State != types.TenantResubnetFailed {
+		if status.State != state {
+			state = status.State
+			fmt.Printf("Tenant %s: %s %d instances\n", cmd.tenantID, state, status.Instances)
+		}
+
+		time.Sleep(2 * time.Second)
+
+		status, err = c.GetTenantResubnetStatus(cmd.tenantID)
+		if err != nil {
+			return errors.Wrap(err, "Error getting tenant resubnet status")
+		}
+	}
+
+	if status.State == types.TenantResubnetFailed {
+		return fmt.Errorf("Error changing subnets of tenant %s: %s", cmd.tenantID, status.Error)
+	}
+
+	fmt.Printf("Tenant %s moved to /%d subnets\n", cmd.tenantID, status.SubnetBits)
+
+	return nil
+}
+
+func (cmd *tenantIPsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant ips [flags] [address...]
+
+List the IP addresses claimed in the network of a tenant along with the
+instances holding them. Addresses held by no instance are orphaned. With
+-release, the listed orphaned addresses, or all of them if none is listed,
+are released.
+
+The ips flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.TenantIP{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *tenantIPsCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.tenantID, "for-tenant", "", "Tenant to list IP addresses for")
+	cmd.Flag.BoolVar(&cmd.release, "release", false, "Release orphaned IP addresses")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantIPsCommand) run(args []string) error {
+	if cmd.tenantID == "" {
+		errorf("Missing required -for-tenant parameter")
+		cmd.usage()
+	}
+
+	if cmd.release {
+		released, err := c.ReleaseTenantIPs(cmd.tenantID, args)
+		if err != nil {
+			return errors.Wrap(err, "Error releasing tenant IPs")
+		}
+
+		for _, addr := range released {
+			fmt.Printf("Released %s\n", addr)
+		}
+		return nil
+	}
+
+	ips, err := c.ListTenantIPs(cmd.tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Error listing tenant IPs")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("tenant-ips", cmd.template, ips)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Address\tInstance\n")
+	for _, ip := range ips {
+		instance := ip.InstanceID
+		if ip.Orphaned {
+			instance = "orphaned"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", ip.Address, instance)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func (cmd *tenantVNIsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] tenant vnis [flags]
+
+List the VXLAN network identifiers allocated to the tenant subnets of the
+cluster. Identifiers released by torn down subnets are quarantined for a
+while before being allocated to other subnets.
+
+The vnis flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+The template passed to the -f option operates on a
+
+%s`,
+		tfortools.GenerateUsageUndecorated([]types.VNIAllocation{}))
+	fmt.Fprintln(os.Stderr, tfortools.TemplateFunctionHelp(nil))
+	os.Exit(2)
+}
+
+func (cmd *tenantVNIsCommand) parseArgs(args []string) []string {
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *tenantVNIsCommand) run(args []string) error {
+	vnis, err := c.ListVNIs()
+	if err != nil {
+		return errors.Wrap(err, "Error listing VNIs")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("tenant-vnis", cmd.template, vnis)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "VNI\tTenant\tSubnet\tState\n")
+	for _, v := range vnis {
+		state := string(v.State)
+		if v.State == types.VNIQuarantined {
+			state = fmt.Sprintf("%s since %s", state, v.ReleaseTime.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", v.VNI, v.TenantID, v.Subnet, state)
+	}
+	w.Flush()
+
+	return nil
+}
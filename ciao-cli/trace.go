@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/intel/tfortools"
 	"github.com/pkg/errors"
@@ -34,37 +35,71 @@ var traceCommand = &command{
 }
 
 type traceListCommand struct {
-	Flag     flag.FlagSet
-	template string
+	Flag      flag.FlagSet
+	label     string
+	instances bool
+	template  string
 }
 
 func (cmd *traceListCommand) usage(...string) {
-	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] trace list
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] trace list [flags]
+
+List all trace label, or the instances launched with a trace label
+
+The list flags are:
 
-List all trace label
 `)
 	cmd.Flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "\n%s",
 		tfortools.GenerateUsageDecorated("f", types.CiaoTracesSummary{}.Summaries, nil))
+	fmt.Fprintf(os.Stderr, "\nWith -instances:\n%s",
+		tfortools.GenerateUsageDecorated("f", api.Servers{}.Servers, nil))
 	os.Exit(2)
 }
 
 func (cmd *traceListCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.StringVar(&cmd.label, "label", "", "Label name")
+	cmd.Flag.BoolVar(&cmd.instances, "instances", false, "List the instances launched with the label")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
+func (cmd *traceListCommand) listInstances() error {
+	if cmd.label == "" {
+		return errors.New("Missing required -label parameter")
+	}
+
+	servers, err := c.ListTraceInstances(cmd.label)
+	if err != nil {
+		return errors.Wrap(err, "Error listing trace instances")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("trace-list", cmd.template, &servers.Servers)
+	}
+
+	fmt.Printf("%d instance(s) launched with label %s\n", servers.TotalServers, cmd.label)
+	for i, server := range servers.Servers {
+		fmt.Printf("\tInstance #%d: %s (%s, node %s)\n", i+1, server.ID, server.Status, server.NodeID)
+	}
+
+	return nil
+}
+
 func (cmd *traceListCommand) run(args []string) error {
+	if cmd.instances {
+		return cmd.listInstances()
+	}
+
 	traces, err := c.ListTraceLabels()
 	if err != nil {
 		return errors.Wrap(err, "Error listing trace labels")
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "trace-list", cmd.template,
-			&traces.Summaries, nil)
+		return outputToTemplate("trace-list", cmd.template, &traces.Summaries)
 	}
 
 	fmt.Printf("%d trace label(s) available\n", len(traces.Summaries))
@@ -97,7 +132,7 @@ The show flags are:
 
 func (cmd *traceShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.label, "label", "", "Label name")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -114,8 +149,7 @@ func (cmd *traceShowCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "trace-show", cmd.template,
-			&traceData.Summary, nil)
+		return outputToTemplate("trace-show", cmd.template, &traceData.Summary)
 	}
 
 	fmt.Printf("Trace data for [%s]:\n", cmd.label)
@@ -17,10 +17,12 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"text/template"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
@@ -32,12 +34,16 @@ import (
 
 var volumeCommand = &command{
 	SubCommands: map[string]subCommand{
-		"add":    new(volumeAddCommand),
-		"list":   new(volumeListCommand),
-		"show":   new(volumeShowCommand),
-		"delete": new(volumeDeleteCommand),
-		"attach": new(volumeAttachCommand),
-		"detach": new(volumeDetachCommand),
+		"add":     new(volumeAddCommand),
+		"list":    new(volumeListCommand),
+		"show":    new(volumeShowCommand),
+		"delete":  new(volumeDeleteCommand),
+		"attach":  new(volumeAttachCommand),
+		"detach":  new(volumeDetachCommand),
+		"protect": new(volumeProtectCommand),
+		"batch":   new(volumeBatchCommand),
+		"update":  new(volumeUpdateCommand),
+		"pools":   new(volumePoolsCommand),
 	},
 }
 
@@ -48,6 +54,11 @@ type volumeAddCommand struct {
 	name        string
 	sourceType  string
 	source      string
+	count       int
+	metadata    string
+	tags        string
+	pool        string
+	clone       bool
 }
 
 func (cmd *volumeAddCommand) usage(...string) {
@@ -55,6 +66,9 @@ func (cmd *volumeAddCommand) usage(...string) {
 
 Create a new block storage volume
 
+When -count is greater than 1, the volumes are created in the background
+and the command returns the ID of the batch in which they are created.
+
 The add flags are:
 
 `)
@@ -68,6 +82,11 @@ func (cmd *volumeAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.source, "source", "", "ID of image or volume to clone from")
 	cmd.Flag.IntVar(&cmd.size, "size", 1, "Size of the volume in GB")
 	cmd.Flag.StringVar(&cmd.description, "description", "", "Volume description")
+	cmd.Flag.IntVar(&cmd.count, "count", 1, "Number of volumes to create")
+	cmd.Flag.StringVar(&cmd.metadata, "metadata", "", "Comma separated key=value metadata of the volume")
+	cmd.Flag.StringVar(&cmd.tags, "tags", "", "Comma separated tags of the volume")
+	cmd.Flag.StringVar(&cmd.pool, "pool", "", "Storage pool of the volume, the default pool if empty")
+	cmd.Flag.BoolVar(&cmd.clone, "clone", false, "Create a copy on write clone of the source image rather than a full copy")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -78,16 +97,45 @@ func (cmd *volumeAddCommand) run(args []string) error {
 		Description: cmd.description,
 		Name:        cmd.name,
 		Size:        cmd.size,
+		Tags:        splitList(cmd.tags),
+		Pool:        cmd.pool,
+	}
+
+	metadata, err := parseVolumeMetadata(cmd.metadata)
+	if err != nil {
+		errorf("%v\n", err)
+		cmd.usage()
 	}
+	createReq.Metadata = metadata
 
 	if cmd.sourceType == "image" {
 		createReq.ImageRef = cmd.source
+		createReq.Clone = cmd.clone
 	} else if cmd.sourceType == "volume" {
 		createReq.SourceVolID = cmd.source
 	} else {
 		fatalf("Unknown source type [%s]\n", cmd.sourceType)
 	}
 
+	if cmd.count < 1 {
+		errorf("Invalid -count parameter %d\n", cmd.count)
+		cmd.usage()
+	}
+
+	if cmd.count > 1 {
+		batch, err := c.CreateVolumeBatch(api.RequestedVolumeBatch{
+			Count:  cmd.count,
+			Volume: createReq,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error creating volumes")
+		}
+
+		fmt.Printf("Creating %d volumes in batch: %s\n", batch.Count, batch.ID)
+		fmt.Printf("Use \"ciao-cli volume batch -batch %s\" to follow its progress\n", batch.ID)
+		return nil
+	}
+
 	vol, err := c.CreateVolume(createReq)
 	if err != nil {
 		return errors.Wrap(err, "Error creating volume")
@@ -98,15 +146,128 @@ func (cmd *volumeAddCommand) run(args []string) error {
 	return err
 }
 
+type volumeBatchCommand struct {
+	Flag     flag.FlagSet
+	batch    string
+	template string
+}
+
+func (cmd *volumeBatchCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume batch [flags]
+
+Show the progress of the creation of a batch of volumes
+
+The batch flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", tfortools.GenerateUsageDecorated("f", types.VolumeBatchStatus{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *volumeBatchCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.batch, "batch", "", "Batch UUID")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *volumeBatchCommand) run(args []string) error {
+	if cmd.batch == "" {
+		errorf("missing required -batch parameter")
+		cmd.usage()
+	}
+
+	batch, err := c.GetVolumeBatch(cmd.batch)
+	if err != nil {
+		return errors.Wrap(err, "Error getting volume batch")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("volume-batch", cmd.template, &batch)
+	}
+
+	fmt.Printf("\tUUID             [%s]\n", batch.ID)
+	fmt.Printf("\tState            [%s]\n", batch.State)
+	fmt.Printf("\tStarted          [%s]\n", batch.Started)
+	fmt.Printf("\tCreated          [%d/%d]\n", len(batch.Volumes), batch.Count)
+	for _, v := range batch.Volumes {
+		fmt.Printf("\tVolume           [%s]\n", v)
+	}
+	for _, f := range batch.Failures {
+		fmt.Printf("\tFailure %-8d [%s]\n", f.Index, f.Error)
+	}
+
+	return nil
+}
+
+type volumePoolsCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *volumePoolsCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume pools [flags]
+
+List the storage pools in which volumes can be created, along with their
+capacity
+
+The pools flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", tfortools.GenerateUsageDecorated("f", types.StoragePoolsResponse{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *volumePoolsCommand) parseArgs(args []string) []string {
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *volumePoolsCommand) run(args []string) error {
+	if !c.IsPrivileged() {
+		fatalf("Listing storage pools is only available for privileged users")
+	}
+
+	pools, err := c.ListStoragePools()
+	if err != nil {
+		return errors.Wrap(err, "Error listing storage pools")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("volume-pools", cmd.template, &pools)
+	}
+
+	for i, p := range pools.Pools {
+		fmt.Printf("Storage pool #%d\n", i+1)
+		fmt.Printf("\tName             [%s]\n", p.Name)
+		fmt.Printf("\tCeph pool        [%s]\n", p.CephPool)
+		fmt.Printf("\tDefault          [%t]\n", p.Default)
+		if p.Error != "" {
+			fmt.Printf("\tError            [%s]\n", p.Error)
+			continue
+		}
+		fmt.Printf("\tTotal            [%d GB]\n", p.Capacity.TotalGiB)
+		fmt.Printf("\tUsed             [%d GB]\n", p.Capacity.UsedGiB)
+		fmt.Printf("\tAvailable        [%d GB]\n", p.Capacity.AvailableGiB)
+	}
+
+	return nil
+}
+
 type volumeListCommand struct {
 	Flag     flag.FlagSet
 	template string
+	tags     string
+	metadata string
 }
 
 func (cmd *volumeListCommand) usage(...string) {
 	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume list
 
-List all volumes
+List all volumes, or the volumes having all the tags and metadata given
 `)
 	cmd.Flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, `
@@ -122,7 +283,9 @@ to the template to determine the total number of volumes.
 }
 
 func (cmd *volumeListCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.StringVar(&cmd.tags, "tags", "", "Comma separated tags the volumes must have")
+	cmd.Flag.StringVar(&cmd.metadata, "metadata", "", "Comma separated key=value or key metadata the volumes must have")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -146,7 +309,13 @@ func (cmd *volumeListCommand) run(args []string) error {
 		}
 	}
 
-	vols, err := c.ListVolumes()
+	metadata, err := parseVolumeMetadata(cmd.metadata)
+	if err != nil {
+		errorf("%v\n", err)
+		cmd.usage()
+	}
+
+	vols, err := c.ListVolumesByLabels(splitList(cmd.tags), metadata)
 	if err != nil {
 		if err != nil {
 			return errors.Wrap(err, "Error listing volumes")
@@ -191,7 +360,7 @@ The show flags are:
 
 func (cmd *volumeShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.volume, "volume", "", "Volume UUID")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -209,8 +378,7 @@ func (cmd *volumeShowCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "volume-show", cmd.template,
-			&vol, nil)
+		return outputToTemplate("volume-show", cmd.template, &vol)
 	}
 
 	dumpVolume(&vol)
@@ -345,6 +513,180 @@ func (cmd *volumeDetachCommand) run(args []string) error {
 	return err
 }
 
+type volumeProtectCommand struct {
+	Flag      flag.FlagSet
+	volume    string
+	unprotect bool
+}
+
+func (cmd *volumeProtectCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume protect [flags]
+
+Protect a volume against deletion. A protected volume cannot be deleted
+until its protection is removed with -unprotect.
+
+The protect flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *volumeProtectCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.volume, "volume", "", "Volume UUID")
+	cmd.Flag.BoolVar(&cmd.unprotect, "unprotect", false, "Remove the protection of the volume")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *volumeProtectCommand) run(args []string) error {
+	if cmd.volume == "" {
+		errorf("missing required -volume parameter")
+		cmd.usage()
+	}
+
+	err := c.ProtectVolume(cmd.volume, !cmd.unprotect)
+	if err != nil {
+		return errors.Wrap(err, "Error changing volume protection")
+	}
+
+	if cmd.unprotect {
+		fmt.Printf("Unprotected volume: %s\n", cmd.volume)
+	} else {
+		fmt.Printf("Protected volume: %s\n", cmd.volume)
+	}
+	return nil
+}
+
+type volumeUpdateCommand struct {
+	Flag        flag.FlagSet
+	volume      string
+	name        string
+	description string
+	metadata    string
+	unset       string
+	tags        string
+}
+
+func (cmd *volumeUpdateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume update [flags]
+
+Update the name, description, metadata or tags of a volume
+
+The update flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *volumeUpdateCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.volume, "volume", "", "Volume UUID")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "New volume name")
+	cmd.Flag.StringVar(&cmd.description, "description", "", "New volume description")
+	cmd.Flag.StringVar(&cmd.metadata, "metadata", "", "Comma separated key=value metadata to set")
+	cmd.Flag.StringVar(&cmd.unset, "unset", "", "Comma separated keys of the metadata to remove")
+	cmd.Flag.StringVar(&cmd.tags, "tags", "", "Comma separated tags replacing those of the volume, empty to remove them")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *volumeUpdateCommand) run(args []string) error {
+	if cmd.volume == "" {
+		errorf("missing required -volume parameter")
+		cmd.usage()
+	}
+
+	metadata, err := parseVolumeMetadata(cmd.metadata)
+	if err != nil {
+		errorf("%v\n", err)
+		cmd.usage()
+	}
+
+	patch := make(map[string]interface{})
+	m := make(map[string]interface{})
+
+	cmd.Flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			patch["name"] = cmd.name
+		case "description":
+			patch["description"] = cmd.description
+		case "tags":
+			patch["tags"] = splitList(cmd.tags)
+		case "metadata":
+			for k, v := range metadata {
+				m[k] = v
+			}
+		case "unset":
+			for _, k := range splitList(cmd.unset) {
+				m[k] = nil
+			}
+		}
+	})
+
+	if len(m) > 0 {
+		patch["metadata"] = m
+	}
+
+	if len(patch) == 0 {
+		errorf("nothing to update\n")
+		cmd.usage()
+	}
+
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return errors.Wrap(err, "Error creating patch")
+	}
+
+	err = c.PatchVolume(cmd.volume, b, types.MergePatch)
+	if err != nil {
+		return errors.Wrap(err, "Error updating volume")
+	}
+
+	fmt.Printf("Updated volume: %s\n", cmd.volume)
+	return nil
+}
+
+// splitList splits a comma separated list, an empty string being an empty
+// list.
+func splitList(s string) []string {
+	var l []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+// parseVolumeMetadata parses comma separated key=value metadata. A key
+// given without value has an empty value.
+func parseVolumeMetadata(s string) (map[string]string, error) {
+	var metadata map[string]string
+
+	for _, kv := range splitList(s) {
+		p := strings.SplitN(kv, "=", 2)
+		if p[0] == "" {
+			return nil, fmt.Errorf("invalid metadata %q", kv)
+		}
+
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+
+		if len(p) == 2 {
+			metadata[p[0]] = p[1]
+		} else {
+			metadata[p[0]] = ""
+		}
+	}
+
+	return metadata, nil
+}
+
 func dumpVolume(v *types.Volume) {
 	fmt.Printf("\tName             [%s]\n", v.Name)
 	fmt.Printf("\tSize             [%d GB]\n", v.Size)
@@ -353,4 +695,24 @@ func dumpVolume(v *types.Volume) {
 	fmt.Printf("\tTenantID         [%s]\n", v.TenantID)
 	fmt.Printf("\tState            [%s]\n", v.State)
 	fmt.Printf("\tDescription      [%s]\n", v.Description)
+	fmt.Printf("\tProtected        [%t]\n", v.Protected)
+	if v.Pool != "" {
+		fmt.Printf("\tPool             [%s]\n", v.Pool)
+	}
+	if v.Parent != "" {
+		fmt.Printf("\tParent image     [%s]\n", v.Parent)
+	}
+
+	keys := make([]string, 0, len(v.Metadata))
+	for k := range v.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("\tMetadata         [%s=%s]\n", k, v.Metadata[k])
+	}
+
+	if len(v.Tags) > 0 {
+		fmt.Printf("\tTags             [%s]\n", strings.Join(v.Tags, ","))
+	}
 }
@@ -32,10 +32,11 @@ import (
 
 var workloadCommand = &command{
 	SubCommands: map[string]subCommand{
-		"list":   new(workloadListCommand),
-		"create": new(workloadCreateCommand),
-		"delete": new(workloadDeleteCommand),
-		"show":   new(workloadShowCommand),
+		"list":    new(workloadListCommand),
+		"create":  new(workloadCreateCommand),
+		"delete":  new(workloadDeleteCommand),
+		"show":    new(workloadShowCommand),
+		"preview": new(workloadPreviewCommand),
 	},
 }
 
@@ -65,7 +66,7 @@ List all workloads
 }
 
 func (cmd *workloadListCommand) parseArgs(args []string) []string {
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -92,8 +93,7 @@ func (cmd *workloadListCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "workload-list", cmd.template,
-			workloads, nil)
+		return outputToTemplate("workload-list", cmd.template, workloads)
 	}
 
 	for i, wl := range workloads {
@@ -122,6 +122,15 @@ func (cmd *workloadCreateCommand) usage(...string) {
 
 Create a new workload
 
+Each disk of the workload either refers to an existing volume by its
+volume_id or describes the volume created for each instance: its size in
+GiB, its source (an image by ID or name, a volume, or empty) and whether
+it is ephemeral, that is deleted along with the instance.
+
+A lifetime limits the age of the instances to max_age_days. Expired
+instances are reported in the event log, and also replaced by new
+instances if the action is recycle rather than notify.
+
 The create flags are:
 
 `)
@@ -140,26 +149,41 @@ type disk struct {
 	Bootable  bool    `yaml:"bootable"`
 	Source    source  `yaml:"source"`
 	Ephemeral bool    `yaml:"ephemeral"`
+	Pool      string  `yaml:"pool,omitempty"`
+	Clone     bool    `yaml:"clone,omitempty"`
 }
 
 type workloadRequirements struct {
-	VCPUs      int    `yaml:"vcpus"`
-	MemMB      int    `yaml:"mem_mb"`
-	NodeID     string `yaml:"node_id,omitempty"`
-	Hostname   string `yaml:"hostname,omitempty"`
-	Privileged bool   `yaml:"privileged,omitempty"`
+	VCPUs       int      `yaml:"vcpus"`
+	MemMB       int      `yaml:"mem_mb"`
+	MaxVCPUs    int      `yaml:"max_vcpus,omitempty"`
+	MaxMemMB    int      `yaml:"max_mem_mb,omitempty"`
+	NodeID      string   `yaml:"node_id,omitempty"`
+	Hostname    string   `yaml:"hostname,omitempty"`
+	Privileged  bool     `yaml:"privileged,omitempty"`
+	CPUModel    string   `yaml:"cpu_model,omitempty"`
+	CPUFeatures []string `yaml:"cpu_features,omitempty"`
+}
+
+type workloadLifetime struct {
+	MaxAgeDays int                  `yaml:"max_age_days"`
+	Action     types.LifetimeAction `yaml:"action,omitempty"`
 }
 
 // we currently only use the first disk due to lack of support
 // in types.Workload for multiple storage resources.
 type workloadOptions struct {
-	Description     string               `yaml:"description"`
-	VMType          string               `yaml:"vm_type"`
-	FWType          string               `yaml:"fw_type,omitempty"`
-	ImageName       string               `yaml:"image_name,omitempty"`
-	Requirements    workloadRequirements `yaml:"requirements"`
-	CloudConfigFile string               `yaml:"cloud_init,omitempty"`
-	Disks           []disk               `yaml:"disks,omitempty"`
+	Description     string                `yaml:"description"`
+	VMType          string                `yaml:"vm_type"`
+	FWType          string                `yaml:"fw_type,omitempty"`
+	ImageName       string                `yaml:"image_name,omitempty"`
+	Requirements    workloadRequirements  `yaml:"requirements"`
+	CloudConfigFile string                `yaml:"cloud_init,omitempty"`
+	Disks           []disk                `yaml:"disks,omitempty"`
+	StaticNetwork   bool                  `yaml:"static_network,omitempty"`
+	Devices         payloads.DeviceModels `yaml:"devices,omitempty"`
+	HealthCheck     *payloads.HealthCheck `yaml:"health_check,omitempty"`
+	Lifetime        *workloadLifetime     `yaml:"lifetime,omitempty"`
 }
 
 func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
@@ -170,6 +194,8 @@ func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
 			Size:      disk.Size,
 			Bootable:  disk.Bootable,
 			Ephemeral: disk.Ephemeral,
+			Pool:      disk.Pool,
+			Clone:     disk.Clone,
 		}
 
 		// Use existing volume
@@ -238,9 +264,23 @@ func optToReq(opt workloadOptions, req *types.Workload) error {
 
 	req.Requirements.MemMB = opt.Requirements.MemMB
 	req.Requirements.VCPUs = opt.Requirements.VCPUs
+	req.Requirements.MaxMemMB = opt.Requirements.MaxMemMB
+	req.Requirements.MaxVCPUs = opt.Requirements.MaxVCPUs
 	req.Requirements.Hostname = opt.Requirements.Hostname
 	req.Requirements.NodeID = opt.Requirements.NodeID
 	req.Requirements.Privileged = opt.Requirements.Privileged
+	req.Requirements.CPUModel = opt.Requirements.CPUModel
+	req.Requirements.CPUFeatures = opt.Requirements.CPUFeatures
+	req.StaticNetwork = opt.StaticNetwork
+	req.Devices = opt.Devices
+	req.HealthCheck = opt.HealthCheck
+
+	if opt.Lifetime != nil {
+		req.Lifetime = &types.LifetimePolicy{
+			MaxAgeDays: opt.Lifetime.MaxAgeDays,
+			Action:     opt.Lifetime.Action,
+		}
+	}
 
 	return nil
 }
@@ -254,15 +294,31 @@ func outputWorkload(w types.Workload) {
 	opt.ImageName = w.ImageName
 	opt.Requirements.MemMB = w.Requirements.MemMB
 	opt.Requirements.VCPUs = w.Requirements.VCPUs
+	opt.Requirements.MaxMemMB = w.Requirements.MaxMemMB
+	opt.Requirements.MaxVCPUs = w.Requirements.MaxVCPUs
 	opt.Requirements.Hostname = w.Requirements.Hostname
 	opt.Requirements.NodeID = w.Requirements.NodeID
 	opt.Requirements.Privileged = w.Requirements.Privileged
+	opt.Requirements.CPUModel = w.Requirements.CPUModel
+	opt.Requirements.CPUFeatures = w.Requirements.CPUFeatures
+	opt.StaticNetwork = w.StaticNetwork
+	opt.Devices = w.Devices
+	opt.HealthCheck = w.HealthCheck
+
+	if w.Lifetime != nil {
+		opt.Lifetime = &workloadLifetime{
+			MaxAgeDays: w.Lifetime.MaxAgeDays,
+			Action:     w.Lifetime.Action,
+		}
+	}
 
 	for _, s := range w.Storage {
 		d := disk{
 			Size:      s.Size,
 			Bootable:  s.Bootable,
 			Ephemeral: s.Ephemeral,
+			Pool:      s.Pool,
+			Clone:     s.Clone,
 		}
 		if s.ID != "" {
 			d.ID = &s.ID
@@ -377,7 +433,7 @@ Show workload details
 
 func (cmd *workloadShowCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
-	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -396,9 +452,69 @@ func (cmd *workloadShowCommand) run(args []string) error {
 	}
 
 	if cmd.template != "" {
-		return tfortools.OutputToTemplate(os.Stdout, "workload-show", cmd.template, &wl, nil)
+		return outputToTemplate("workload-show", cmd.template, &wl)
 	}
 
 	outputWorkload(wl)
 	return nil
 }
+
+type workloadPreviewCommand struct {
+	Flag     flag.FlagSet
+	template string
+	workload string
+	tenant   string
+	name     string
+}
+
+func (cmd *workloadPreviewCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] workload preview
+
+Show the configuration, including the cloud-init data and metadata, the
+launcher would receive for an instance of a workload, without launching
+it. The values of secret cloud-init keys such as passwords are masked.
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s",
+		tfortools.GenerateUsageDecorated("f", types.LaunchPreview{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *workloadPreviewCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
+	cmd.Flag.StringVar(&cmd.tenant, "for-tenant", "", "Tenant to preview the launch for (admin only)")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of the instance")
+	addTemplateFlag(&cmd.Flag, &cmd.template)
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *workloadPreviewCommand) run(args []string) error {
+	if cmd.workload == "" {
+		cmd.usage()
+	}
+
+	if c.IsPrivileged() && cmd.tenant == "" {
+		errorf("Missing required -for-tenant parameter")
+		cmd.usage()
+	}
+
+	preview, err := c.PreviewLaunch(cmd.workload, cmd.tenant, cmd.name)
+	if err != nil {
+		return errors.Wrap(err, "Error previewing workload launch")
+	}
+
+	if cmd.template != "" {
+		return outputToTemplate("workload-preview", cmd.template, &preview)
+	}
+
+	fmt.Printf("Instance ID [%s]\n", preview.InstanceID)
+	if preview.Name != "" {
+		fmt.Printf("\tName: %s\n", preview.Name)
+	}
+	fmt.Printf("\tIP address: %s\n", preview.IPAddress)
+	fmt.Printf("%s", preview.Config)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ciao-project/ciao/uuid"
+)
+
+type pendingAck struct {
+	instanceID string
+	done       chan error
+}
+
+// ackTracker correlates the commands sent to the agents with the events
+// and errors sent back in response, so that the sender of a command can
+// await its completion. Agents echo the identifier of the command in
+// their response. Responses without identifier, sent by older agents,
+// complete the commands pending for the same instance.
+type ackTracker struct {
+	lock    sync.Mutex
+	pending map[string]pendingAck
+}
+
+// add registers a new command for an instance and returns its identifier
+// along with the channel its result is sent on.
+func (t *ackTracker) add(instanceID string) (string, <-chan error) {
+	ID := uuid.Generate().String()
+	done := make(chan error, 1)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[string]pendingAck)
+	}
+
+	t.pending[ID] = pendingAck{
+		instanceID: instanceID,
+		done:       done,
+	}
+
+	return ID, done
+}
+
+// ack completes a command with the result reported by the agent.
+func (t *ackTracker) ack(commandID string, instanceID string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if commandID != "" {
+		if p, ok := t.pending[commandID]; ok {
+			delete(t.pending, commandID)
+			p.done <- err
+		}
+		return
+	}
+
+	for ID, p := range t.pending {
+		if p.instanceID == instanceID {
+			delete(t.pending, ID)
+			p.done <- err
+		}
+	}
+}
+
+// wait waits for a command to be completed or for ctx to be done.
+func (t *ackTracker) wait(ctx context.Context, commandID string, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		t.cancel(commandID)
+		return ctx.Err()
+	}
+}
+
+// cancel forgets a command which could not be sent or is no longer
+// awaited.
+func (t *ackTracker) cancel(commandID string) {
+	t.lock.Lock()
+	delete(t.pending, commandID)
+	t.lock.Unlock()
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+func TestAckTracker(t *testing.T) {
+	var acks ackTracker
+
+	first, firstDone := acks.add("instance-1")
+	second, secondDone := acks.add("instance-1")
+	other, otherDone := acks.add("instance-2")
+
+	failure := errors.New("failure")
+	acks.ack(first, "instance-1", failure)
+
+	err := acks.wait(context.Background(), first, firstDone)
+	if err != failure {
+		t.Fatalf("Expected %v, got %v", failure, err)
+	}
+
+	// acks without command ID complete the commands of the instance
+	acks.ack("", "instance-1", nil)
+
+	err = acks.wait(context.Background(), second, secondDone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = acks.wait(ctx, other, otherDone)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if len(acks.pending) != 0 {
+		t.Fatalf("Expected no pending commands, got %d", len(acks.pending))
+	}
+}
+
+func TestStopInstanceSync(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	clientCh := client.AddCmdChan(ssntp.DELETE)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- ctl.stopInstanceSync(instances[0].ID)
+	}()
+
+	_, err := client.GetCmdChanResult(clientCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sendStopEvent(client, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(25 * time.Second):
+		t.Fatal("Timeout waiting for the instance to stop")
+	}
+
+	i, err := ctl.ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.Exited {
+		t.Fatalf("Expected instance to be %s, got %s", payloads.Exited, i.State)
+	}
+}
+
+func TestDeleteInstanceSyncFailure(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	client.DeleteFail = true
+	client.DeleteFailReason = payloads.DeleteNoInstance
+
+	sendStatsCmd(client, t)
+
+	start := time.Now()
+
+	err := ctl.deleteInstanceSync(instances[0].ID)
+	if err == nil {
+		t.Fatal("Expected the deletion to fail")
+	}
+
+	if time.Since(start) > time.Minute {
+		t.Fatal("Deletion failure not reported before the timeout")
+	}
+
+	i, err := ctl.ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the node answered, the instance is not hung.
+	if i.State == payloads.Hung {
+		t.Fatalf("Instance marked %s after a reported failure", payloads.Hung)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
@@ -55,8 +56,11 @@ type APIResponse struct {
 
 func errorResponse(err error) APIResponse {
 	switch err {
-	case types.ErrQuota:
+	case types.ErrQuota,
+		types.ErrInstanceProtected:
 		return APIResponse{http.StatusForbidden, nil}
+	case types.ErrBadRequest:
+		return APIResponse{http.StatusBadRequest, nil}
 	case types.ErrTenantNotFound,
 		types.ErrInstanceNotFound:
 		return APIResponse{http.StatusNotFound, nil}
@@ -365,6 +369,12 @@ func serversAction(c *controller, w http.ResponseWriter, r *http.Request) (APIRe
 				continue
 			}
 
+			// deleting all the instances of a tenant leaves the
+			// protected ones alone.
+			if servers.Action == "os-delete" && instance.Protected {
+				continue
+			}
+
 			err = actionFunc(instance.ID)
 			if err != nil {
 				return errorResponse(err), err
@@ -395,6 +405,16 @@ func trimComputeNodes(c *controller, nodeList types.CiaoNodes, targetRole ssntp.
 }
 
 func listSubsetOfNodes(c *controller, w http.ResponseWriter, r *http.Request, targetRole ssntp.Role) (APIResponse, error) {
+	// the node summaries include instance counts, so the tag must
+	// change when either the nodes or the instances are modified.
+	revision := c.ds.Revision(types.NodesRevision) + "." +
+		c.ds.Revision(types.InstancesRevision)
+	etag := api.ETag(r, revision)
+	if api.NotModified(r, etag) {
+		w.Header().Set("ETag", etag)
+		return APIResponse{http.StatusNotModified, nil}, nil
+	}
+
 	allNodes := c.ds.GetNodeLastStats()
 
 	var subsetOfNodes types.CiaoNodes
@@ -442,6 +462,7 @@ func listSubsetOfNodes(c *controller, w http.ResponseWriter, r *http.Request, ta
 		return errorResponse(err), err
 	}
 
+	w.Header().Set("ETag", etag)
 	return APIResponse{http.StatusOK, resp}, nil
 }
 
@@ -580,36 +601,138 @@ func listTraces(c *controller, w http.ResponseWriter, r *http.Request) (APIRespo
 	return APIResponse{http.StatusOK, traces}, err
 }
 
+func traceServers(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	label := vars["label"]
+	var servers api.Servers
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	sort.Sort(types.SortedInstancesByID(instances))
+
+	for _, instance := range instances {
+		if instance.TraceLabel != label {
+			continue
+		}
+
+		server, err := instanceToServer(c, instance)
+		if err != nil {
+			return errorResponse(err), err
+		}
+
+		servers.Servers = append(servers.Servers, server)
+	}
+
+	servers.TotalServers = len(servers.Servers)
+
+	return APIResponse{http.StatusOK, servers}, nil
+}
+
+// eventsTimeParse parses an optional RFC 3339 time of an events query.
+func eventsTimeParse(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, types.ErrBadRequest
+	}
+
+	return t, nil
+}
+
+// eventsQueryParse returns the filter, the marker and the page size of the
+// events requested.
+func eventsQueryParse(r *http.Request) (types.EventFilter, string, int, error) {
+	var filter types.EventFilter
+	var err error
+
+	values := r.URL.Query()
+
+	filter.Severity = types.EventSeverity(values.Get("severity"))
+	if filter.Severity != "" && filter.Severity.Level() == -1 {
+		return filter, "", 0, types.ErrBadRequest
+	}
+
+	filter.Category = types.EventCategory(values.Get("category"))
+	if filter.Category != "" && !filter.Category.Valid() {
+		return filter, "", 0, types.ErrBadRequest
+	}
+
+	filter.NodeID = values.Get("node_id")
+
+	filter.Since, err = eventsTimeParse(values.Get("since"))
+	if err != nil {
+		return filter, "", 0, err
+	}
+
+	filter.Until, err = eventsTimeParse(values.Get("until"))
+	if err != nil {
+		return filter, "", 0, err
+	}
+
+	limit := 0
+	if l := values.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			return filter, "", 0, types.ErrBadRequest
+		}
+	}
+
+	return filter, values.Get("marker"), limit, nil
+}
+
 func listEvents(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
 	vars := mux.Vars(r)
-	tenant := vars["tenant"]
+
+	filter, marker, limit, err := eventsQueryParse(r)
+	if err != nil {
+		return errorResponse(err), err
+	}
+	filter.TenantID = vars["tenant"]
 
 	events := types.NewCiaoEvents()
 
-	logs, err := c.ds.GetEventLog()
+	logs, next, err := c.ds.ListEvents(filter, marker, limit)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
 	for _, l := range logs {
-		if tenant != "" && tenant != l.TenantID {
-			continue
-		}
-
 		event := types.CiaoEvent{
-			Timestamp: l.Timestamp,
-			TenantID:  l.TenantID,
-			EventType: l.EventType,
-			Message:   l.Message,
+			Timestamp:  l.Timestamp,
+			TenantID:   l.TenantID,
+			Severity:   l.Severity,
+			Category:   l.Category,
+			Message:    l.Message,
+			NodeID:     l.NodeID,
+			InstanceID: l.InstanceID,
+			Reason:     l.Reason,
 		}
 		events.Events = append(events.Events, event)
 	}
+	events.NextMarker = next
 
 	return APIResponse{http.StatusOK, events}, err
 }
 
+// clearEvents removes all the events, or only those logged before the time
+// given by the before query parameter.
 func clearEvents(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
-	err := c.ds.ClearLog()
+	before, err := eventsTimeParse(r.URL.Query().Get("before"))
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if before.IsZero() {
+		err = c.ds.ClearLog()
+	} else {
+		_, err = c.ds.PruneEventLog(before)
+	}
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -21,9 +21,14 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ciao-project/ciao/ciao-controller/internal/ipalloc"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/service"
 	"github.com/ciao-project/ciao/uuid"
@@ -34,6 +39,10 @@ import (
 // Port is the default port number for the ciao API.
 const Port = 8889
 
+// ImpersonateHeader is the HTTP header an admin sets to the ID of a tenant
+// to perform a request on behalf of that tenant.
+const ImpersonateHeader = "X-Ciao-Impersonate-Tenant"
+
 const (
 	// PoolsV1 is the content-type string for v1 of our pools resource
 	PoolsV1 = "x.ciao.pools.v1"
@@ -58,8 +67,50 @@ const (
 
 	// InstancesV1 is the content-type string for v1 of our intances resource
 	InstancesV1 = "x.ciao.instances.v1"
+
+	// FaultsV1 is the content-type string for v1 of our faults resource
+	FaultsV1 = "x.ciao.faults.v1"
+
+	// MetricsV1 is the content-type string for v1 of our metrics resource
+	MetricsV1 = "x.ciao.metrics.v1"
+
+	// ConfigV1 is the content-type string for v1 of our config resource
+	ConfigV1 = "x.ciao.config.v1"
+
+	// FramesV1 is the content-type string for v1 of our frames resource
+	FramesV1 = "x.ciao.frames.v1"
+
+	// StoragePoolsV1 is the content-type string for v1 of our storage
+	// pools resource
+	StoragePoolsV1 = "x.ciao.storage-pools.v1"
+
+	// ReportsV1 is the content-type string for v1 of our reports
+	// resource
+	ReportsV1 = "x.ciao.reports.v1"
+
+	// AuthV1 is the content-type string for v1 of our authentication
+	// resource
+	AuthV1 = "x.ciao.auth.v1"
+
+	// DatastoreV1 is the content-type string for v1 of our datastore
+	// resource
+	DatastoreV1 = "x.ciao.datastore.v1"
 )
 
+// patchContent matches the content types of the supported patch formats.
+var patchContent = fmt.Sprintf(`application/(%s|%s)`,
+	strings.Replace(string(types.MergePatch), "+", `\+`, 1),
+	strings.Replace(string(types.JSONPatch), "+", `\+`, 1))
+
+// patchFormat returns the format of the patch carried by a request.
+func patchFormat(r *http.Request) types.PatchFormat {
+	if strings.Contains(r.Header.Get("Content-Type"), string(types.JSONPatch)) {
+		return types.JSONPatch
+	}
+
+	return types.MergePatch
+}
+
 // ErrorImage defines all possible image handling errors
 type ErrorImage error
 
@@ -86,6 +137,37 @@ type CreateImageRequest struct {
 	Name       string           `json:"name,omitempty"`
 	ID         string           `json:"id,omitempty"`
 	Visibility types.Visibility `json:"visibility,omitempty"`
+
+	// Signature and Signer sign the data of the image to be uploaded.
+	// The signature is verified when the data is uploaded.
+	Signature []byte `json:"signature,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+}
+
+// CreateInstanceImageRequest contains information for a request to create
+// an image from the boot volume of an instance.
+type CreateInstanceImageRequest struct {
+	CreateImage CreateImageRequest `json:"createImage"`
+}
+
+// HotAddRequest contains the vCPUs and memory, in MiB, to add to a running
+// instance.
+type HotAddRequest struct {
+	VCPUs int `json:"vcpus,omitempty"`
+	MemMB int `json:"mem_mb,omitempty"`
+}
+
+// HotAddInstanceRequest contains information for a request to hot add
+// resources to a running instance.
+type HotAddInstanceRequest struct {
+	HotAdd HotAddRequest `json:"hotAdd"`
+}
+
+// ExportImageRequest contains information for a request to export an
+// image to object storage.  The image is uploaded to the object named
+// after its ID if no key is given.
+type ExportImageRequest struct {
+	Key string `json:"key,omitempty"`
 }
 
 // RequestedVolume contains information about a volume to be created.
@@ -95,7 +177,19 @@ type RequestedVolume struct {
 	Description string `json:"description,omitempty"`
 	Name        string `json:"name,omitempty"`
 	ImageRef    string `json:"imageRef,omitempty"`
+	Pool        string `json:"pool,omitempty"`
+	Clone       bool   `json:"clone,omitempty"`
 	Internal    bool   `json:"-"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+}
+
+// RequestedVolumeBatch contains information about a batch of volumes to be
+// created from the same specification.
+type RequestedVolumeBatch struct {
+	Count  int             `json:"count"`
+	Volume RequestedVolume `json:"volume"`
 }
 
 // CreateServerRequest contains the details needed to start new instance(s)
@@ -111,6 +205,29 @@ type CreateServerRequest struct {
 	} `json:"server"`
 }
 
+// StackGroup describes a group of instances of a workload launched as
+// part of a stack.
+type StackGroup struct {
+	Name         string   `json:"name"`
+	WorkloadID   string   `json:"workload_id"`
+	Instances    int      `json:"instances,omitempty"`
+	InstanceName string   `json:"instance_name,omitempty"`
+	DependsOn    []string `json:"depends_on,omitempty"`
+}
+
+// CreateStackRequest contains the groups of instances of a stack.  The
+// instances of a group are only launched once the instances of the groups
+// it depends on are running, e.g. application servers after their
+// database.  Timeout is the number of seconds given to the instances of a
+// group to be running and OnFailure decides whether the dependent groups
+// of a group which failed are launched.
+type CreateStackRequest struct {
+	Name      string                   `json:"name"`
+	Groups    []StackGroup             `json:"groups"`
+	Timeout   int                      `json:"timeout,omitempty"`
+	OnFailure types.StackFailurePolicy `json:"on_failure,omitempty"`
+}
+
 // PrivateAddresses contains information about a single instance network
 // interface.
 type PrivateAddresses struct {
@@ -118,25 +235,52 @@ type PrivateAddresses struct {
 	MacAddr string `json:"mac_addr"`
 }
 
+// SchedulerHints contains the placement constraints the scheduler had to
+// honour when choosing the node of an instance.
+type SchedulerHints struct {
+	NodeID      string `json:"node_id,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	NetworkNode bool   `json:"network_node,omitempty"`
+}
+
 // ServerDetails contains information about a specific instance.
 type ServerDetails struct {
-	PrivateAddresses []PrivateAddresses `json:"private_addresses"`
-	Created          time.Time          `json:"created"`
-	WorkloadID       string             `json:"workload_id"`
-	NodeID           string             `json:"node_id"`
-	ID               string             `json:"id"`
-	Name             string             `json:"name"`
-	Volumes          []string           `json:"volumes"`
-	Status           string             `json:"status"`
-	TenantID         string             `json:"tenant_id"`
-	SSHIP            string             `json:"ssh_ip"`
-	SSHPort          int                `json:"ssh_port"`
-}
-
-// Servers holds multiple servers including a count
+	PrivateAddresses []PrivateAddresses        `json:"private_addresses"`
+	Created          time.Time                 `json:"created"`
+	WorkloadID       string                    `json:"workload_id"`
+	NodeID           string                    `json:"node_id"`
+	ID               string                    `json:"id"`
+	Name             string                    `json:"name"`
+	Volumes          []string                  `json:"volumes"`
+	Status           string                    `json:"status"`
+	TenantID         string                    `json:"tenant_id"`
+	SSHIP            string                    `json:"ssh_ip"`
+	SSHPort          int                       `json:"ssh_port"`
+	SchedulerHints   *SchedulerHints           `json:"scheduler_hints,omitempty"`
+	TraceLabel       string                    `json:"trace_label,omitempty"`
+	Protected        bool                      `json:"protected,omitempty"`
+	Warm             bool                      `json:"warm,omitempty"`
+	Health           *types.CiaoInstanceHealth `json:"health,omitempty"`
+	VCPUs            int                       `json:"vcpus,omitempty"`
+	MemMB            int                       `json:"mem_mb,omitempty"`
+	Tags             []string                  `json:"tags,omitempty"`
+}
+
+// Servers holds multiple servers including a count. TotalServers is the
+// number of servers matching the request across all pages, and NextMarker,
+// when set, is the marker to pass to retrieve the next page.
 type Servers struct {
 	TotalServers int             `json:"total_servers"`
 	Servers      []ServerDetails `json:"servers"`
+	NextMarker   string          `json:"next_marker,omitempty"`
+}
+
+// partialServers holds multiple servers restricted to the attributes
+// requested through the fields query parameter.
+type partialServers struct {
+	TotalServers int                      `json:"total_servers"`
+	Servers      []map[string]interface{} `json:"servers"`
+	NextMarker   string                   `json:"next_marker,omitempty"`
 }
 
 // Server holds a single server's worth of details.
@@ -181,12 +325,36 @@ type Response struct {
 }
 
 func errorResponse(err error) Response {
+	if _, ok := err.(*types.PatchError); ok {
+		return Response{http.StatusBadRequest, nil}
+	}
+
+	if _, ok := err.(*types.InstanceLimitError); ok {
+		return Response{http.StatusBadRequest, nil}
+	}
+
+	if _, ok := err.(*types.QuotaError); ok {
+		return Response{http.StatusForbidden, nil}
+	}
+
 	switch err {
 	case types.ErrPoolNotFound,
 		types.ErrTenantNotFound,
 		types.ErrAddressNotFound,
 		types.ErrInstanceNotFound,
-		types.ErrWorkloadNotFound:
+		types.ErrWorkloadNotFound,
+		types.ErrQuotaProfileNotFound,
+		types.ErrNodeNotFound,
+		types.ErrConfigKeyNotFound,
+		types.ErrVolumeBatchNotFound,
+		types.ErrImageExportNotFound,
+		types.ErrReportNotFound,
+		types.ErrStackNotFound,
+		types.ErrStoragePoolNotFound,
+		types.ErrNodeCommandNotFound,
+		types.ErrAuthLockoutNotFound,
+		types.ErrNoRebalance,
+		types.ErrNoInstanceTransfer:
 		return Response{http.StatusNotFound, nil}
 
 	case types.ErrQuota,
@@ -194,14 +362,48 @@ func errorResponse(err error) Response {
 		types.ErrDuplicateSubnet,
 		types.ErrDuplicateIP,
 		types.ErrInvalidIP,
+		types.ErrInvalidIPRange,
 		types.ErrPoolNotEmpty,
 		types.ErrInvalidPoolAddress,
 		types.ErrBadRequest,
 		types.ErrPoolEmpty,
 		types.ErrDuplicatePoolName,
-		types.ErrWorkloadInUse:
+		types.ErrAddressMapped,
+		types.ErrWorkloadInUse,
+		types.ErrNoBootVolume,
+		types.ErrQuotaProfileInUse,
+		types.ErrNodeNotEmpty,
+		types.ErrNodeDraining,
+		types.ErrNodeCommandPending,
+		types.ErrRebalancing,
+		types.ErrTenantResubnetting,
+		types.ErrInstanceTransferring,
+		types.ErrFaultInjectionDisabled,
+		types.ErrInvalidConfigValue,
+		types.ErrReplayDisabled,
+		types.ErrImageCorrupted,
+		types.ErrImageInUse,
+		types.ErrInstanceNameInUse,
+		types.ErrTenantIPInUse,
+		types.ErrInstanceProtected,
+		types.ErrVolumeProtected,
+		types.ErrImageExportDisabled,
+		types.ErrImageNotActive,
+		types.ErrReportsDisabled,
+		types.ErrInstanceNotRunning,
+		types.ErrHotAddNotSupported,
+		types.ErrPublicWorkloadForbidden,
+		types.ErrUntrustedSigner,
+		types.ErrImageSignature,
+		types.ErrUnsignedImage:
 		return Response{http.StatusForbidden, nil}
 
+	case types.ErrConfirmationRequired:
+		return Response{http.StatusPreconditionRequired, nil}
+
+	case types.ErrInvalidConfirmation:
+		return Response{http.StatusPreconditionFailed, nil}
+
 	default:
 		return Response{http.StatusInternalServerError, nil}
 	}
@@ -253,6 +455,11 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if resp.status == http.StatusNotModified {
+		w.WriteHeader(resp.status)
+		return
+	}
+
 	b, err := json.Marshal(resp.response)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError),
@@ -265,6 +472,143 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(b)
 }
 
+// ETag returns the entity tag for a collection whose state is identified
+// by the given datastore revision. The tag covers the attributes selected
+// by the fields query parameter of r, if any, so that each projection is
+// validated separately. Tags are weak as the same representation may be
+// served with or without gzip content encoding.
+func ETag(r *http.Request, revision string) string {
+	fields := requestedFields(r)
+	if len(fields) == 0 {
+		return `W/"` + revision + `"`
+	}
+
+	sort.Strings(fields)
+	unique := fields[:1]
+	for _, f := range fields[1:] {
+		if f != unique[len(unique)-1] {
+			unique = append(unique, f)
+		}
+	}
+
+	return `W/"` + revision + ";fields=" + strings.Join(unique, ",") + `"`
+}
+
+// NotModified returns true if the request carries an If-None-Match header
+// matching etag, meaning that the client already holds a current copy of
+// the resource. Tags are compared using the weak comparison function.
+func NotModified(r *http.Request, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, header := range r.Header["If-None-Match"] {
+		for _, tag := range entityTags(header) {
+			if strings.TrimPrefix(tag, "W/") == etag || tag == "*" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// entityTags splits an If-None-Match header value into its entity tags.
+// Commas are only treated as separators outside of quoted tags, as they
+// are valid characters within an entity tag.
+func entityTags(header string) []string {
+	var tags []string
+	var quoted bool
+
+	start := 0
+	for i, c := range header {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			tags = append(tags, strings.TrimSpace(header[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(tags, strings.TrimSpace(header[start:]))
+}
+
+// requestedFields returns the attributes listed in the fields query
+// parameter of a request, or nil if the client did not restrict them.
+func requestedFields(r *http.Request) []string {
+	var fields []string
+
+	for _, value := range r.URL.Query()["fields"] {
+		for _, f := range strings.Split(value, ",") {
+			f = strings.TrimSpace(f)
+			if f != "" {
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	return fields
+}
+
+// jsonFields stores the exported fields of a struct in m, keyed by their
+// JSON attribute names. Fields of embedded structs are promoted in the
+// same way encoding/json does it.
+func jsonFields(v reflect.Value, m map[string]interface{}) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(v.Field(i), m)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		m[name] = v.Field(i).Interface()
+	}
+}
+
+// selectFields takes a slice of structs and returns, for each element,
+// a map holding only the requested JSON attributes.
+func selectFields(items interface{}, fields []string) ([]map[string]interface{}, error) {
+	s := reflect.ValueOf(items)
+
+	known := make(map[string]interface{})
+	jsonFields(reflect.Zero(s.Type().Elem()), known)
+
+	for _, f := range fields {
+		if _, ok := known[f]; !ok {
+			return nil, fmt.Errorf("Unknown field %s", f)
+		}
+	}
+
+	selected := make([]map[string]interface{}, 0, s.Len())
+
+	for i := 0; i < s.Len(); i++ {
+		all := make(map[string]interface{})
+		jsonFields(s.Index(i), all)
+
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			m[f] = all[f]
+		}
+
+		selected = append(selected, m)
+	}
+
+	return selected, nil
+}
+
 func listResources(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	var links []types.APILink
 	vars := mux.Vars(r)
@@ -432,6 +776,7 @@ func listPools(c *Context, w http.ResponseWriter, r *http.Request) (Response, er
 				summary.TotalIPs = &pools[i].TotalIPs
 				summary.Free = &pools[i].Free
 				summary.Links = pools[i].Links
+				summary.Subnets = pools[i].Subnets
 			}
 
 			resp.Pools = append(resp.Pools, summary)
@@ -445,6 +790,33 @@ func listPools(c *Context, w http.ResponseWriter, r *http.Request) (Response, er
 	return Response{http.StatusOK, resp}, err
 }
 
+// maxIPRange is the largest number of addresses which can be added to a
+// pool as a range.
+const maxIPRange = 65536
+
+// expandIPRange appends the addresses of ipRange to ips.  Ranges cannot be
+// added along with a subnet, as only one of them would be added.
+func expandIPRange(ips []string, subnet *string, ipRange *string) ([]string, error) {
+	if ipRange == nil {
+		return ips, nil
+	}
+
+	if subnet != nil {
+		return nil, types.ErrBadRequest
+	}
+
+	rangeIPs, err := ipalloc.ParseRange(*ipRange, maxIPRange)
+	if err != nil {
+		return nil, types.ErrInvalidIPRange
+	}
+
+	for _, ip := range rangeIPs {
+		ips = append(ips, ip.String())
+	}
+
+	return ips, nil
+}
+
 func addPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	var req types.NewPoolRequest
 
@@ -464,6 +836,11 @@ func addPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, erro
 		ips = append(ips, ip.IP)
 	}
 
+	ips, err = expandIPRange(ips, req.Subnet, req.IPRange)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
 	_, err = c.AddPool(req.Name, req.Subnet, ips)
 	if err != nil {
 		return errorResponse(err), err
@@ -472,11 +849,39 @@ func addPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, erro
 	return Response{http.StatusNoContent, nil}, nil
 }
 
+// confirmOperation checks that a destructive operation is requested with
+// the token returned by its dry run, passed in the confirm query
+// parameter. If a dry run is requested instead, through the dry_run query
+// parameter, its result is returned and the operation must not be carried
+// out.
+func confirmOperation(c *Context, r *http.Request, op types.DestructiveOperation, ID string) (*types.DryRunResult, error) {
+	query := r.URL.Query()
+
+	if dryRun, _ := strconv.ParseBool(query.Get("dry_run")); dryRun {
+		res, err := c.DryRun(op, ID)
+		if err != nil {
+			return nil, err
+		}
+		return &res, nil
+	}
+
+	return nil, c.Confirm(op, ID, query.Get("confirm"))
+}
+
 func deletePool(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	ID := vars["pool"]
 
-	err := c.DeletePool(ID)
+	dryRun, err := confirmOperation(c, r, types.DeletePoolOperation, ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if dryRun != nil {
+		return Response{http.StatusOK, *dryRun}, nil
+	}
+
+	err = c.DeletePool(ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -484,6 +889,27 @@ func deletePool(c *Context, w http.ResponseWriter, r *http.Request) (Response, e
 	return Response{http.StatusNoContent, nil}, nil
 }
 
+func drainPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["pool"]
+
+	dryRun, err := confirmOperation(c, r, types.DrainPoolOperation, ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if dryRun != nil {
+		return Response{http.StatusOK, *dryRun}, nil
+	}
+
+	err = c.DrainPool(ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, nil}, nil
+}
+
 func addToPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	ID := vars["pool"]
@@ -506,6 +932,11 @@ func addToPool(c *Context, w http.ResponseWriter, r *http.Request) (Response, er
 		ips = append(ips, ip.IP)
 	}
 
+	ips, err = expandIPRange(ips, req.Subnet, req.IPRange)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
 	err = c.AddAddress(ID, req.Subnet, ips)
 	if err != nil {
 		return errorResponse(err), err
@@ -582,7 +1013,7 @@ func mapExternalIP(c *Context, w http.ResponseWriter, r *http.Request) (Response
 
 	tenantID := vars["tenant"]
 
-	err = c.MapAddress(tenantID, req.PoolName, req.InstanceID)
+	err = c.MapAddress(tenantID, req.PoolName, req.Address, req.InstanceID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -617,6 +1048,26 @@ func unmapExternalIP(c *Context, w http.ResponseWriter, r *http.Request) (Respon
 	return errorResponse(types.ErrAddressNotFound), types.ErrAddressNotFound
 }
 
+func unmapInstanceExternalIPs(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	var err error
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		err = c.UnMapInstanceAddresses(nil, instanceID)
+	} else {
+		err = c.UnMapInstanceAddresses(&tenantID, instanceID)
+	}
+
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, nil}, nil
+}
+
 func addWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	var req types.Workload
 
@@ -685,6 +1136,28 @@ func deleteWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 	return Response{http.StatusNoContent, nil}, nil
 }
 
+func patchWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["workload_id"]
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = c.PatchWorkload(tenantID, ID, body, patchFormat(r))
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
 func showWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	ID := vars["workload_id"]
@@ -702,6 +1175,41 @@ func showWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 	return Response{http.StatusOK, wl}, nil
 }
 
+func previewWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var req types.LaunchPreviewRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return errorResponse(err), err
+		}
+	}
+
+	// admins preview the launch for the tenant given in the request,
+	// tenants for themselves.
+	vars := mux.Vars(r)
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = req.TenantID
+	}
+
+	if tenantID == "" {
+		return errorResponse(types.ErrBadRequest), types.ErrBadRequest
+	}
+
+	preview, err := c.PreviewLaunch(tenantID, vars["workload_id"], req.Name)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, preview}, nil
+}
+
 func listWorkloads(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 
@@ -728,56 +1236,59 @@ func listQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response, e
 	return Response{http.StatusOK, resp}, nil
 }
 
-func updateQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+func showTenantSummary(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
-	tenantID := vars["for_tenant"]
+	tenantID, ok := vars["tenant"]
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return errorResponse(err), err
+	if !ok {
+		tenantID = vars["for_tenant"]
 	}
 
-	var req types.QuotaUpdateRequest
-	err = json.Unmarshal(body, &req)
+	summary, err := c.ShowTenantSummary(tenantID)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	err = c.UpdateQuotas(tenantID, req.Quotas)
+	return Response{http.StatusOK, summary}, nil
+}
+
+func listQuotaProfiles(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	profiles, err := c.ListQuotaProfiles()
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	var resp types.QuotaListResponse
-	resp.Quotas = c.ListQuotas(tenantID)
-
-	return Response{http.StatusCreated, resp}, nil
+	return Response{http.StatusOK, types.QuotaProfilesResponse{Profiles: profiles}}, nil
 }
 
-func changeNodeStatus(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+func updateQuotaProfile(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
-	ID := vars["node_id"]
+	name := vars["profile"]
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	var status types.CiaoNodeStatus
-	err = json.Unmarshal(body, &status)
+	var req types.QuotaUpdateRequest
+	err = json.Unmarshal(body, &req)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	if status.Status == types.NodeStatusReady {
-		err = c.RestoreNode(ID)
-	} else if status.Status == types.NodeStatusMaintenance {
-		err = c.EvacuateNode(ID)
-	} else {
-		err = fmt.Errorf("Cannot transition node %s to %s",
-			ID, status.Status)
+	err = c.UpdateQuotaProfile(name, req.Quotas)
+	if err != nil {
+		return errorResponse(err), err
 	}
 
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func deleteQuotaProfile(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	name := vars["profile"]
+
+	err := c.DeleteQuotaProfile(name)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -785,23 +1296,346 @@ func changeNodeStatus(c *Context, w http.ResponseWriter, r *http.Request) (Respo
 	return Response{http.StatusNoContent, nil}, nil
 }
 
-func listTenants(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
-	var resp types.TenantsListResponse
+func listTenantSubnets(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID, ok := vars["tenant"]
 
-	queries := r.URL.Query()
-	IDs, returnSingleTenant := queries["id"]
+	if !ok {
+		tenantID = vars["for_tenant"]
+	}
 
-	tenants, err := c.ListTenants()
+	subnets, err := c.ListTenantSubnets(tenantID)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	if returnSingleTenant != true {
-		resp.Tenants = tenants
-		return Response{http.StatusOK, resp}, nil
-	}
+	return Response{http.StatusOK, types.TenantSubnetsResponse{Subnets: subnets}}, nil
+}
 
-	for _, t := range tenants {
+func listTenantIPs(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["for_tenant"]
+
+	ips, err := c.ListTenantIPs(tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.TenantIPsResponse{IPs: ips}}, nil
+}
+
+func listShadowPlacements(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	placements, err := c.ListShadowPlacements()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, placements}, nil
+}
+
+func listVNIs(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vnis, err := c.ListVNIs()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.VNIsResponse{VNIs: vnis}}, nil
+}
+
+func releaseTenantIPs(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["for_tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.TenantIPReleaseRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	released, err := c.ReleaseTenantIPs(tenantID, req.Addresses)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.TenantIPReleaseResponse{Released: released}}, nil
+}
+
+func resubnetTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["for_tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.TenantResubnetRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	status, err := c.ResubnetTenant(tenantID, req.SubnetBits)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, status}, nil
+}
+
+func showTenantResubnet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["for_tenant"]
+
+	status, err := c.GetTenantResubnet(tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, status}, nil
+}
+
+func transferInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.InstanceTransferRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	status, err := c.TransferInstance(instanceID, req.TenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, status}, nil
+}
+
+func showInstanceTransfer(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	status, err := c.GetInstanceTransfer(instanceID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, status}, nil
+}
+
+func updateQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["for_tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.QuotaUpdateRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = c.UpdateQuotas(tenantID, req.Quotas)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var resp types.QuotaListResponse
+	resp.Quotas = c.ListQuotas(tenantID)
+
+	return Response{http.StatusCreated, resp}, nil
+}
+
+func changeNodeStatus(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var status types.CiaoNodeStatus
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if status.Status == types.NodeStatusReady {
+		err = c.RestoreNode(ID)
+	} else if status.Status == types.NodeStatusMaintenance {
+		err = c.EvacuateNode(ID)
+	} else {
+		err = fmt.Errorf("Cannot transition node %s to %s",
+			ID, status.Status)
+	}
+
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func drainNode(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.NodeDrainRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if req.Delete {
+		dryRun, err := confirmOperation(c, r, types.DeleteNodeOperation, ID)
+		if err != nil {
+			return errorResponse(err), err
+		}
+
+		if dryRun != nil {
+			return Response{http.StatusOK, *dryRun}, nil
+		}
+	}
+
+	status, err := c.DrainNode(ID, req.Delete)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, status}, nil
+}
+
+func showNodeDrain(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	status, err := c.GetNodeDrain(ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, status}, nil
+}
+
+func runNodeCommand(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.NodeCommandRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	status, err := c.RunNodeCommand(ID, req.Command)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, status}, nil
+}
+
+func listNodeCommands(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+
+	commands, err := c.ListNodeCommands(ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.NodeCommandListResponse{Commands: commands}}, nil
+}
+
+func showNodeCommand(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["node_id"]
+	commandID := vars["command_id"]
+
+	status, err := c.GetNodeCommand(ID, commandID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, status}, nil
+}
+
+func rebalanceNodes(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.RebalanceRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	status, err := c.RebalanceNodes(req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if req.DryRun {
+		return Response{http.StatusOK, status}, nil
+	}
+
+	return Response{http.StatusAccepted, status}, nil
+}
+
+func showRebalance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	status, err := c.GetRebalance()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, status}, nil
+}
+
+func listTenants(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	var resp types.TenantsListResponse
+
+	queries := r.URL.Query()
+	IDs, returnSingleTenant := queries["id"]
+
+	tenants, err := c.ListTenants()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if returnSingleTenant != true {
+		resp.Tenants = tenants
+		return Response{http.StatusOK, resp}, nil
+	}
+
+	for _, t := range tenants {
 		for _, tenantID := range IDs {
 			if t.ID == tenantID {
 				resp.Tenants = append(resp.Tenants, t)
@@ -833,7 +1667,7 @@ func updateTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 		return errorResponse(err), err
 	}
 
-	err = c.PatchTenant(ID, body)
+	err = c.PatchTenant(ID, body, patchFormat(r))
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -865,7 +1699,16 @@ func deleteTenant(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 	vars := mux.Vars(r)
 	ID := vars["tenant"]
 
-	err := c.DeleteTenant(ID)
+	dryRun, err := confirmOperation(c, r, types.DeleteTenantOperation, ID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if dryRun != nil {
+		return Response{http.StatusOK, *dryRun}, nil
+	}
+
+	err = c.DeleteTenant(ID)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -920,11 +1763,18 @@ func listImages(context *Context, w http.ResponseWriter, r *http.Request) (Respo
 		tenantID = "admin"
 	}
 
+	etag := ETag(r, context.Revision(types.ImagesRevision))
+	if NotModified(r, etag) {
+		w.Header().Set("ETag", etag)
+		return Response{http.StatusNotModified, nil}, nil
+	}
+
 	images, err := context.ListImages(tenantID)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
+	w.Header().Set("ETag", etag)
 	return Response{http.StatusOK, images}, nil
 }
 
@@ -932,22 +1782,101 @@ func listImages(context *Context, w http.ResponseWriter, r *http.Request) (Respo
 //
 func getImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
-	imageID := vars["image_id"]
+	imageID := vars["image_id"]
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	image, err := context.GetImage(tenantID, imageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, image}, nil
+}
+
+func uploadImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	err := context.UploadImage(tenantID, imageID, r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func exportImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	var req ExportImageRequest
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return errorResponse(types.ErrBadRequest), err
+		}
+	}
+
+	export, err := context.ExportImage(tenantID, imageID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, export}, nil
+}
+
+func listImageExports(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+
+	tenantID, ok := vars["tenant"]
+	if !ok {
+		tenantID = "admin"
+	}
+
+	exports, err := context.ListImageExports(tenantID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.ImageExportsResponse{Exports: exports}}, nil
+}
+
+func showImageExport(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	exportID := vars["export_id"]
 
 	tenantID, ok := vars["tenant"]
 	if !ok {
 		tenantID = "admin"
 	}
 
-	image, err := context.GetImage(tenantID, imageID)
+	export, err := context.GetImageExport(tenantID, exportID)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	return Response{http.StatusOK, image}, nil
+	return Response{http.StatusOK, export}, nil
 }
 
-func uploadImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+func deleteImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	imageID := vars["image_id"]
 
@@ -956,14 +1885,15 @@ func uploadImage(context *Context, w http.ResponseWriter, r *http.Request) (Resp
 		tenantID = "admin"
 	}
 
-	err := context.UploadImage(tenantID, imageID, r.Body)
+	err := context.DeleteImage(tenantID, imageID)
 	if err != nil {
 		return errorResponse(err), err
 	}
+
 	return Response{http.StatusNoContent, nil}, nil
 }
 
-func deleteImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+func patchImage(context *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	imageID := vars["image_id"]
 
@@ -972,7 +1902,12 @@ func deleteImage(context *Context, w http.ResponseWriter, r *http.Request) (Resp
 		tenantID = "admin"
 	}
 
-	err := context.DeleteImage(tenantID, imageID)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = context.PatchImage(tenantID, imageID, body, patchFormat(r))
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -1003,18 +1938,142 @@ func createVolume(bc *Context, w http.ResponseWriter, r *http.Request) (Response
 	return Response{http.StatusAccepted, vol}, nil
 }
 
+func createVolumeBatch(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	var req RequestedVolumeBatch
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(types.ErrBadRequest), err
+	}
+
+	batch, err := bc.CreateVolumeBatch(tenant, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, batch}, nil
+}
+
+func showVolumeBatch(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	batchID := vars["batch_id"]
+
+	batch, err := bc.GetVolumeBatch(tenant, batchID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, batch}, nil
+}
+
+// volumeMatches returns true if the volume has all the tags and metadata
+// given. Metadata are given either as key=value or as a key which the
+// volume must have, whatever its value.
+func volumeMatches(vol types.Volume, tags []string, metadata []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range vol.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for _, m := range metadata {
+		kv := strings.SplitN(m, "=", 2)
+
+		value, ok := vol.Metadata[kv[0]]
+		if !ok || (len(kv) == 2 && value != kv[1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterVolumes returns the volumes matching the tag and metadata query
+// values.
+func filterVolumes(vols []types.Volume, values url.Values) []types.Volume {
+	tags := values["tag"]
+	metadata := values["metadata"]
+
+	if len(tags) == 0 && len(metadata) == 0 {
+		return vols
+	}
+
+	filtered := []types.Volume{}
+	for _, vol := range vols {
+		if volumeMatches(vol, tags, metadata) {
+			filtered = append(filtered, vol)
+		}
+	}
+
+	return filtered
+}
+
 func listVolumesDetail(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 
+	etag := ETag(r, bc.Revision(types.VolumesRevision))
+	if NotModified(r, etag) {
+		w.Header().Set("ETag", etag)
+		return Response{http.StatusNotModified, nil}, nil
+	}
+
 	vols, err := bc.ListVolumesDetail(tenant)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
+	vols = filterVolumes(vols, r.URL.Query())
+
+	fields := requestedFields(r)
+	if fields != nil {
+		selected, err := selectFields(vols, fields)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+
+		w.Header().Set("ETag", etag)
+		return Response{http.StatusOK, selected}, nil
+	}
+
+	w.Header().Set("ETag", etag)
 	return Response{http.StatusOK, vols}, nil
 }
 
+func patchVolume(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	volume := vars["volume_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = bc.PatchVolume(tenant, volume, body, patchFormat(r))
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
 func showVolumeDetails(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -1089,6 +2148,15 @@ func volumeActionDetach(bc *Context, m map[string]interface{}, tenant string, vo
 	return Response{http.StatusAccepted, nil}, nil
 }
 
+func volumeActionProtect(bc *Context, tenant string, volume string, protect bool) (Response, error) {
+	err := bc.ProtectVolume(tenant, volume, protect)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, nil}, nil
+}
+
 func volumeAction(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -1118,9 +2186,188 @@ func volumeAction(bc *Context, w http.ResponseWriter, r *http.Request) (Response
 		return volumeActionDetach(bc, m, tenant, volume)
 	}
 
+	if _, ok := m["protect"]; ok {
+		return volumeActionProtect(bc, tenant, volume, true)
+	}
+
+	if _, ok := m["unprotect"]; ok {
+		return volumeActionProtect(bc, tenant, volume, false)
+	}
+
 	return Response{http.StatusBadRequest, nil}, err
 }
 
+func listVolumeMismatches(bc *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	res, err := bc.ListVolumeMismatches()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, res}, nil
+}
+
+func showFaults(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	faults, err := c.GetFaults()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, faults}, nil
+}
+
+func updateFaults(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.FaultConfig
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(types.ErrBadRequest), err
+	}
+
+	err = c.UpdateFaults(req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func showConfig(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	config, err := c.GetConfig()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, config}, nil
+}
+
+func showConfigSetting(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	setting, err := c.GetConfigSetting(key)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, setting}, nil
+}
+
+func updateConfigSetting(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.ConfigUpdateRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(types.ErrBadRequest), err
+	}
+
+	err = c.UpdateConfigSetting(key, req.Value)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func listStoragePools(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	pools, err := c.ListStoragePools()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, pools}, nil
+}
+
+func listReports(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	reports, err := c.ListReports()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.ReportsResponse{Reports: reports}}, nil
+}
+
+func runReport(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+
+	report, err := c.RunReport(vars["name"])
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, report}, nil
+}
+
+func verifyDatastore(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.DatastoreVerifyRequest
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return errorResponse(err), err
+		}
+	}
+
+	res, err := c.VerifyDatastore(req.Repair)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, res}, nil
+}
+
+func listAuthLockouts(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	lockouts, err := c.ListAuthLockouts()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.AuthLockoutListResponse{Lockouts: lockouts}}, nil
+}
+
+func clearAuthLockout(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+
+	err := c.ClearAuthLockout(vars["lockout_id"])
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func replayFrames(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	res, err := c.ReplayFrames(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, res}, nil
+}
+
+func showMetrics(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	metrics, err := c.GetMetrics()
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, metrics}, nil
+}
+
 func createInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -1137,50 +2384,115 @@ func createInstance(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 		return Response{http.StatusBadRequest, nil}, err
 	}
 
-	resp, err := c.CreateServer(tenant, req)
+	resp, err := c.CreateServer(tenant, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, resp}, nil
+}
+func createStack(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	var req CreateStackRequest
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return Response{http.StatusBadRequest, nil}, err
+	}
+
+	stack, err := c.CreateStack(tenant, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, stack}, nil
+}
+
+func listStacks(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	stacks, err := c.ListStacks(tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.StacksResponse{Stacks: stacks}}, nil
+}
+
+func showStack(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	stack, err := c.GetStack(tenant, vars["stack_id"])
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	return Response{http.StatusAccepted, resp}, nil
+	return Response{http.StatusOK, stack}, nil
 }
+
 func listInstanceDetails(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
 
 	values := r.URL.Query()
 
-	var workload string
+	filter := types.InstanceFilter{
+		TenantID:   tenant,
+		State:      values.Get("state"),
+		NodeID:     values.Get("node_id"),
+		NamePrefix: values.Get("name_prefix"),
+	}
 
 	// if this function is called via an admin context, we might
 	// have {workload} on the URL. If it's called from a user context,
 	// we might have workload as a query value.
 	workload, ok := vars["workload"]
 	if !ok {
-		if len(values["workload"]) > 0 {
-			workload = values["workload"][0]
+		workload = values.Get("workload")
+	}
+	filter.WorkloadID = workload
+
+	limit := 0
+	if l := values.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			return Response{http.StatusBadRequest, nil}, fmt.Errorf("Invalid limit %s", l)
 		}
 	}
 
-	servers, err := c.ListServersDetail(tenant)
+	etag := ETag(r, c.Revision(types.InstancesRevision))
+	if NotModified(r, etag) {
+		w.Header().Set("ETag", etag)
+		return Response{http.StatusNotModified, nil}, nil
+	}
+
+	resp, err := c.ListServersDetail(filter, values.Get("marker"), limit)
 	if err != nil {
 		return errorResponse(err), err
 	}
 
-	resp := Servers{}
-
-	if workload != "" {
-		for _, s := range servers {
-			if s.WorkloadID == workload {
-				resp.Servers = append(resp.Servers, s)
-			}
+	fields := requestedFields(r)
+	if fields != nil {
+		selected, err := selectFields(resp.Servers, fields)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
 		}
-	} else {
-		resp.Servers = servers
-	}
 
-	resp.TotalServers = len(resp.Servers)
+		w.Header().Set("ETag", etag)
+		return Response{http.StatusOK, partialServers{resp.TotalServers, selected, resp.NextMarker}}, nil
+	}
 
+	w.Header().Set("ETag", etag)
 	return Response{http.StatusOK, resp}, nil
 }
 
@@ -1197,6 +2509,56 @@ func showInstanceDetails(c *Context, w http.ResponseWriter, r *http.Request) (Re
 	return Response{http.StatusOK, resp}, nil
 }
 
+func showInstanceNetworkStats(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
+
+	resp, err := c.ShowServerNetworkStats(tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, resp}, nil
+}
+
+func showInstanceDiskStats(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	server := vars["instance_id"]
+
+	resp, err := c.ShowServerDiskStats(tenant, server)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, resp}, nil
+}
+
+func listDeletedInstances(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	instances, err := c.ListDeletedInstances(tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, types.DeletedInstancesResponse{Instances: instances}}, nil
+}
+
+func purgeDeletedInstances(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	err := c.PurgeDeletedInstances(tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
 func deleteInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenant := vars["tenant"]
@@ -1222,10 +2584,37 @@ func instanceAction(c *Context, w http.ResponseWriter, r *http.Request) (Respons
 
 	bodyString := string(body)
 
-	if strings.Contains(bodyString, "os-start") {
+	if strings.Contains(bodyString, "createImage") {
+		var req CreateInstanceImageRequest
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+
+		image, err := c.CreateInstanceImage(tenant, server, req.CreateImage)
+		if err != nil {
+			return errorResponse(err), err
+		}
+
+		return Response{http.StatusAccepted, image}, nil
+	} else if strings.Contains(bodyString, "hotAdd") {
+		var req HotAddInstanceRequest
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return Response{http.StatusBadRequest, nil}, err
+		}
+
+		err = c.HotAddServer(tenant, server, req.HotAdd)
+	} else if strings.Contains(bodyString, "os-start") {
 		err = c.StartServer(tenant, server)
 	} else if strings.Contains(bodyString, "os-stop") {
 		err = c.StopServer(tenant, server)
+	} else if strings.Contains(bodyString, "unprotect") {
+		err = c.ProtectServer(tenant, server, false)
+	} else if strings.Contains(bodyString, "protect") {
+		err = c.ProtectServer(tenant, server, true)
 	} else {
 		return Response{http.StatusServiceUnavailable, nil},
 			errors.New("Unsupported Action")
@@ -1247,19 +2636,61 @@ type Service interface {
 	AddAddress(poolID string, subnet *string, IPs []string) error
 	RemoveAddress(poolID string, subnetID *string, IPID *string) error
 	ListMappedAddresses(tenantID *string) []types.MappedIP
-	MapAddress(tenantID string, poolName *string, instanceID string) error
+	MapAddress(tenantID string, poolName *string, address *string, instanceID string) error
 	UnMapAddress(ID string) error
+	UnMapInstanceAddresses(tenantID *string, instanceID string) error
+	DrainPool(id string) error
 	CreateWorkload(req types.Workload) (types.Workload, error)
 	DeleteWorkload(tenantID string, workloadID string) error
 	ShowWorkload(tenantID string, workloadID string) (types.Workload, error)
+	PreviewLaunch(tenantID string, workloadID string, name string) (types.LaunchPreview, error)
+	PatchWorkload(tenantID string, workloadID string, patch []byte, format types.PatchFormat) error
 	ListWorkloads(tenantID string) ([]types.Workload, error)
 	ListQuotas(tenantID string) []types.QuotaDetails
 	UpdateQuotas(tenantID string, qds []types.QuotaDetails) error
+	ListQuotaProfiles() ([]types.QuotaProfile, error)
+	UpdateQuotaProfile(name string, qds []types.QuotaDetails) error
+	DeleteQuotaProfile(name string) error
+	ShowTenantSummary(tenantID string) (types.TenantResourceSummary, error)
+	ListTenantSubnets(tenantID string) ([]types.TenantSubnet, error)
+	ListVolumeMismatches() (types.VolumeCheckResponse, error)
 	EvacuateNode(nodeID string) error
 	RestoreNode(nodeID string) error
+	DrainNode(nodeID string, del bool) (types.NodeDrainStatus, error)
+	GetNodeDrain(nodeID string) (types.NodeDrainStatus, error)
+	RunNodeCommand(nodeID string, command types.NodeCommandType) (types.NodeCommandStatus, error)
+	GetNodeCommand(nodeID string, commandID string) (types.NodeCommandStatus, error)
+	ListNodeCommands(nodeID string) ([]types.NodeCommandStatus, error)
+	RebalanceNodes(req types.RebalanceRequest) (types.RebalanceStatus, error)
+	GetRebalance() (types.RebalanceStatus, error)
+	ResubnetTenant(tenantID string, subnetBits int) (types.TenantResubnetStatus, error)
+	ListTenantIPs(tenantID string) ([]types.TenantIP, error)
+	ReleaseTenantIPs(tenantID string, addresses []string) ([]string, error)
+	ListVNIs() ([]types.VNIAllocation, error)
+	ListShadowPlacements() (types.ShadowPlacementsResponse, error)
+	DryRun(op types.DestructiveOperation, ID string) (types.DryRunResult, error)
+	Confirm(op types.DestructiveOperation, ID string, token string) error
+	GetTenantResubnet(tenantID string) (types.TenantResubnetStatus, error)
+	TransferInstance(instanceID string, tenantID string) (types.InstanceTransferStatus, error)
+	GetInstanceTransfer(instanceID string) (types.InstanceTransferStatus, error)
+	ListDeletedInstances(tenantID string) ([]types.DeletedInstance, error)
+	PurgeDeletedInstances(tenantID string) error
+	GetFaults() (types.FaultConfig, error)
+	UpdateFaults(faults types.FaultConfig) error
+	GetConfig() (types.ConfigResponse, error)
+	GetConfigSetting(key string) (types.ConfigSetting, error)
+	UpdateConfigSetting(key string, value string) error
+	ListStoragePools() (types.StoragePoolsResponse, error)
+	ListReports() ([]types.ReportStatus, error)
+	RunReport(name string) (types.ReportStatus, error)
+	VerifyDatastore(repair bool) (types.DatastoreVerifyResponse, error)
+	ListAuthLockouts() ([]types.AuthLockout, error)
+	ClearAuthLockout(ID string) error
+	ReplayFrames(capture io.Reader) (types.FrameReplayResult, error)
+	GetMetrics() (types.ControllerMetrics, error)
 	ListTenants() ([]types.TenantSummary, error)
 	ShowTenant(ID string) (types.TenantConfig, error)
-	PatchTenant(ID string, patch []byte) error
+	PatchTenant(ID string, patch []byte, format types.PatchFormat) error
 	CreateTenant(ID string, config types.TenantConfig) (types.TenantSummary, error)
 	DeleteTenant(ID string) error
 	CreateImage(string, CreateImageRequest) (types.Image, error)
@@ -1267,18 +2698,35 @@ type Service interface {
 	ListImages(string) ([]types.Image, error)
 	GetImage(string, string) (types.Image, error)
 	DeleteImage(string, string) error
+	PatchImage(tenantID string, imageID string, patch []byte, format types.PatchFormat) error
+	CreateInstanceImage(tenant string, instanceID string, req CreateImageRequest) (types.Image, error)
+	ExportImage(tenantID string, imageID string, req ExportImageRequest) (types.ImageExportStatus, error)
+	ListImageExports(tenantID string) ([]types.ImageExportStatus, error)
+	GetImageExport(tenantID string, exportID string) (types.ImageExportStatus, error)
 	CreateVolume(tenant string, req RequestedVolume) (types.Volume, error)
+	CreateVolumeBatch(tenant string, req RequestedVolumeBatch) (types.VolumeBatchStatus, error)
+	GetVolumeBatch(tenant string, batchID string) (types.VolumeBatchStatus, error)
 	DeleteVolume(tenant string, volume string) error
 	AttachVolume(tenant string, volume string, instance string, mountpoint string) error
 	DetachVolume(tenant string, volume string, attachment string) error
+	ProtectVolume(tenant string, volume string, protect bool) error
 	ListVolumesDetail(tenant string) ([]types.Volume, error)
+	PatchVolume(tenant string, volume string, patch []byte, format types.PatchFormat) error
 	ShowVolumeDetails(tenant string, volume string) (types.Volume, error)
 	CreateServer(string, CreateServerRequest) (interface{}, error)
-	ListServersDetail(tenant string) ([]ServerDetails, error)
+	CreateStack(tenant string, req CreateStackRequest) (types.StackStatus, error)
+	ListStacks(tenant string) ([]types.StackStatus, error)
+	GetStack(tenant string, stackID string) (types.StackStatus, error)
+	ListServersDetail(filter types.InstanceFilter, marker string, limit int) (Servers, error)
 	ShowServerDetails(tenant string, server string) (Server, error)
+	ShowServerNetworkStats(tenant string, server string) (types.CiaoServerNetworkStats, error)
+	ShowServerDiskStats(tenant string, server string) (types.CiaoServerDiskStats, error)
 	DeleteServer(tenant string, server string) error
 	StartServer(tenant string, server string) error
 	StopServer(tenant string, server string) error
+	ProtectServer(tenant string, server string, protect bool) error
+	HotAddServer(tenant string, server string, req HotAddRequest) error
+	Revision(resource types.RevisionedResource) string
 }
 
 // Context is used to provide the services and current URL to the handlers.
@@ -1339,6 +2787,10 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}/drain", Handler{context, drainPool, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/pools/{pool:"+uuid.UUIDRegex+"}/subnets/{subnet:"+uuid.UUIDRegex+"}", Handler{context, deleteSubnet, true})
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1366,6 +2818,16 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/external-ips", Handler{context, unmapInstanceExternalIPs, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+	route.Queries("instance", "{instance_id}")
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips", Handler{context, unmapInstanceExternalIPs, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+	route.Queries("instance", "{instance_id}")
+
 	route = r.Handle("/external-ips/{mapping_id:"+uuid.UUIDRegex+"}", Handler{context, unmapExternalIP, true})
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1393,6 +2855,14 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, patchWorkload, true})
+	route.Methods("PATCH")
+	route.HeadersRegexp("Content-Type", patchContent)
+
+	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}/preview", Handler{context, previewWorkload, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads", Handler{context, addWorkload, false})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1409,6 +2879,14 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}", Handler{context, patchWorkload, false})
+	route.Methods("PATCH")
+	route.HeadersRegexp("Content-Type", patchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}/preview", Handler{context, previewWorkload, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// tenants
 	matchContent = fmt.Sprintf("application/(%s|json)", TenantsV1)
 
@@ -1434,7 +2912,7 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 
 	route = r.Handle("/tenants/{tenant:"+uuid.UUIDRegex+"}", Handler{context, updateTenant, true})
 	route.Methods("PATCH")
-	route.HeadersRegexp("Content-Type", `application/merge-patch\+json`)
+	route.HeadersRegexp("Content-Type", patchContent)
 
 	// tenant quotas
 	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tenants/quotas", Handler{context, listQuotas, false})
@@ -1449,6 +2927,60 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("PUT")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	// quota profiles
+	route = r.Handle("/tenants/quota-profiles", Handler{context, listQuotaProfiles, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/quota-profiles/{profile}", Handler{context, updateQuotaProfile, true})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/quota-profiles/{profile}", Handler{context, deleteQuotaProfile, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant resource summary
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tenants/summary", Handler{context, showTenantSummary, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{for_tenant:"+uuid.UUIDRegex+"}/summary", Handler{context, showTenantSummary, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant subnets
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/tenants/subnets", Handler{context, listTenantSubnets, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{for_tenant:"+uuid.UUIDRegex+"}/subnets", Handler{context, listTenantSubnets, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant subnet bits changes
+	route = r.Handle("/tenants/{for_tenant:"+uuid.UUIDRegex+"}/resubnet", Handler{context, resubnetTenant, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{for_tenant:"+uuid.UUIDRegex+"}/resubnet", Handler{context, showTenantResubnet, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// tenant IP claims
+	route = r.Handle("/tenants/{for_tenant:"+uuid.UUIDRegex+"}/ips", Handler{context, listTenantIPs, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/tenants/{for_tenant:"+uuid.UUIDRegex+"}/ips/release", Handler{context, releaseTenantIPs, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// VXLAN network identifiers of the tenant subnets
+	route = r.Handle("/tenants/vnis", Handler{context, listVNIs, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// evacuation and restore
 	matchContent = fmt.Sprintf("application/(%s|json)", NodeV1)
 
@@ -1456,6 +2988,41 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("PUT")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/drain", Handler{context, drainNode, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/drain", Handler{context, showNodeDrain, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// node commands
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/commands", Handler{context, runNodeCommand, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/commands", Handler{context, listNodeCommands, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/node/{node_id:"+uuid.UUIDRegex+"}/commands/{command_id:"+uuid.UUIDRegex+"}", Handler{context, showNodeCommand, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// rebalancing of the instances across the nodes
+	route = r.Handle("/node/rebalance", Handler{context, rebalanceNodes, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/node/rebalance", Handler{context, showRebalance, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// shadow scheduling
+	route = r.Handle("/node/shadow-placements", Handler{context, listShadowPlacements, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// images
 	matchContent = fmt.Sprintf("application/(%s|json)", ImagesV1)
 
@@ -1475,10 +3042,26 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/images/{image_id:"+uuid.UUIDRegex+"}", Handler{context, patchImage, false})
+	route.Methods("PATCH")
+	route.HeadersRegexp("Content-Type", patchContent)
+
 	route = r.Handle("/{tenant}/images/{image_id:"+uuid.UUIDRegex+"}", Handler{context, deleteImage, false})
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/images/{image_id:"+uuid.UUIDRegex+"}/export", Handler{context, exportImage, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/images/exports", Handler{context, listImageExports, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/images/exports/{export_id:"+uuid.UUIDRegex+"}", Handler{context, showImageExport, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/images", Handler{context, createImage, true})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1495,10 +3078,26 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/images/{image_id:"+uuid.UUIDRegex+"}", Handler{context, patchImage, true})
+	route.Methods("PATCH")
+	route.HeadersRegexp("Content-Type", patchContent)
+
 	route = r.Handle("/images/{image_id:"+uuid.UUIDRegex+"}", Handler{context, deleteImage, true})
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/images/{image_id:"+uuid.UUIDRegex+"}/export", Handler{context, exportImage, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/images/exports", Handler{context, listImageExports, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/images/exports/{export_id:"+uuid.UUIDRegex+"}", Handler{context, showImageExport, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// Volumes
 	matchContent = fmt.Sprintf("application/(%s|json)", VolumesV1)
 	route = r.Handle("/{tenant}/volumes", Handler{context, createVolume, false})
@@ -1509,10 +3108,22 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/volumes/batches", Handler{context, createVolumeBatch, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/volumes/batches/{batch_id}", Handler{context, showVolumeBatch, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/volumes/{volume_id}", Handler{context, showVolumeDetails, false})
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/volumes/{volume_id}", Handler{context, patchVolume, false})
+	route.Methods("PATCH")
+	route.HeadersRegexp("Content-Type", patchContent)
+
 	route = r.Handle("/{tenant}/volumes/{volume_id}", Handler{context, deleteVolume, false})
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1522,9 +3133,98 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	// Volume checks
+	route = r.Handle("/volumes/mismatches", Handler{context, listVolumeMismatches, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Fault injection
+	matchContent = fmt.Sprintf("application/(%s|json)", FaultsV1)
+
+	route = r.Handle("/faults", Handler{context, showFaults, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/faults", Handler{context, updateFaults, true})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Cluster configuration
+	matchContent = fmt.Sprintf("application/(%s|json)", ConfigV1)
+
+	route = r.Handle("/config", Handler{context, showConfig, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/config/{key}", Handler{context, showConfigSetting, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/config/{key}", Handler{context, updateConfigSetting, true})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Storage pools
+	matchContent = fmt.Sprintf("application/(%s|json)", StoragePoolsV1)
+
+	route = r.Handle("/storage/pools", Handler{context, listStoragePools, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Tenant reports
+	matchContent = fmt.Sprintf("application/(%s|json)", ReportsV1)
+
+	route = r.Handle("/reports", Handler{context, listReports, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/reports/{name}", Handler{context, runReport, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Datastore consistency
+	matchContent = fmt.Sprintf("application/(%s|json)", DatastoreV1)
+
+	route = r.Handle("/datastore/verify", Handler{context, verifyDatastore, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Authentication lockouts
+	matchContent = fmt.Sprintf("application/(%s|json)", AuthV1)
+
+	route = r.Handle("/auth/lockouts", Handler{context, listAuthLockouts, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/auth/lockouts/{lockout_id}", Handler{context, clearAuthLockout, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Frame replay
+	matchContent = fmt.Sprintf("application/(%s|json)", FramesV1)
+
+	route = r.Handle("/frames/replay", Handler{context, replayFrames, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// Metrics
+	matchContent = fmt.Sprintf("application/(%s|json)", MetricsV1)
+
+	route = r.Handle("/metrics", Handler{context, showMetrics, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// Instances
 	matchContent = fmt.Sprintf("application/(%s|json)", InstancesV1)
 
+	route = r.Handle("/instances/{instance_id}/transfer", Handler{context, transferInstance, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/instances/{instance_id}/transfer", Handler{context, showInstanceTransfer, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/instances", Handler{context, createInstance, false})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1533,6 +3233,14 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/instances/deleted", Handler{context, listDeletedInstances, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/deleted", Handler{context, purgeDeletedInstances, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/instances/{instance_id}", Handler{context, showInstanceDetails, false})
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -1541,9 +3249,29 @@ func Routes(config Config, r *mux.Router) *mux.Router {
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/instances/{instance_id}/network-stats", Handler{context, showInstanceNetworkStats, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/instances/{instance_id}/disk-stats", Handler{context, showInstanceDiskStats, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant}/instances/{instance_id}/action", Handler{context, instanceAction, false})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/{tenant}/stacks", Handler{context, createStack, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/stacks", Handler{context, listStacks, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant}/stacks/{stack_id:"+uuid.UUIDRegex+"}", Handler{context, showStack, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	return r
 }
@@ -53,7 +53,7 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", PoolsV1),
 		http.StatusOK,
-		`{"pools":[{"id":"ba58f471-0735-4773-9550-188e2d012941","name":"testpool","free":0,"total_ips":0,"links":[{"rel":"self","href":"/pools/ba58f471-0735-4773-9550-188e2d012941"}]}]}`,
+		`{"pools":[{"id":"ba58f471-0735-4773-9550-188e2d012941","name":"testpool","free":0,"total_ips":0,"links":[{"rel":"self","href":"/pools/ba58f471-0735-4773-9550-188e2d012941"}],"subnets":[{"id":"ba58f471-0735-4773-9550-188e2d012941","subnet":"192.168.0.0/30","used":1,"free":1,"mapped_ips":["192.168.0.1"],"links":null}]}]}`,
 	},
 	{
 		"GET",
@@ -61,7 +61,7 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", PoolsV1),
 		http.StatusOK,
-		`{"pools":[{"id":"ba58f471-0735-4773-9550-188e2d012941","name":"testpool","free":0,"total_ips":0,"links":[{"rel":"self","href":"/pools/ba58f471-0735-4773-9550-188e2d012941"}]}]}`,
+		`{"pools":[{"id":"ba58f471-0735-4773-9550-188e2d012941","name":"testpool","free":0,"total_ips":0,"links":[{"rel":"self","href":"/pools/ba58f471-0735-4773-9550-188e2d012941"}],"subnets":[{"id":"ba58f471-0735-4773-9550-188e2d012941","subnet":"192.168.0.0/30","used":1,"free":1,"mapped_ips":["192.168.0.1"],"links":null}]}]}`,
 	},
 	{
 		"POST",
@@ -87,6 +87,14 @@ var tests = []test{
 		http.StatusNoContent,
 		"null",
 	},
+	{
+		"DELETE",
+		"/pools/ba58f471-0735-4773-9550-188e2d012941?dry_run=true",
+		"",
+		fmt.Sprintf("application/%s", PoolsV1),
+		http.StatusOK,
+		`{"operation":"delete_pool","target":"ba58f471-0735-4773-9550-188e2d012941","impact":{"instances":2},"token":"b8c2b5a6-1d35-4bb0-a6a5-3ae3d3c6a7c1","expires":"2017-06-01T12:05:00Z"}`,
+	},
 	{
 		"POST",
 		"/pools/ba58f471-0735-4773-9550-188e2d012941",
@@ -95,6 +103,38 @@ var tests = []test{
 		http.StatusNoContent,
 		"null",
 	},
+	{
+		"POST",
+		"/pools/ba58f471-0735-4773-9550-188e2d012941",
+		`{"ip_range":"192.0.2.10-192.0.2.50"}`,
+		fmt.Sprintf("application/%s", PoolsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"POST",
+		"/pools/ba58f471-0735-4773-9550-188e2d012941",
+		`{"ip_range":"192.0.2.50-192.0.2.10"}`,
+		fmt.Sprintf("application/%s", PoolsV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid IP address range"}}` + "\n",
+	},
+	{
+		"POST",
+		"/pools/ba58f471-0735-4773-9550-188e2d012941/drain",
+		"",
+		fmt.Sprintf("application/%s", PoolsV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/pools/ba58f471-0735-4773-9550-188e2d012941/drain?dry_run=true",
+		"",
+		fmt.Sprintf("application/%s", PoolsV1),
+		http.StatusOK,
+		`{"operation":"drain_pool","target":"ba58f471-0735-4773-9550-188e2d012941","impact":{"instances":2},"token":"b8c2b5a6-1d35-4bb0-a6a5-3ae3d3c6a7c1","expires":"2017-06-01T12:05:00Z"}`,
+	},
 	{
 		"DELETE",
 		"/pools/ba58f471-0735-4773-9550-188e2d012941/subnets/ba58f471-0735-4773-9550-188e2d012941",
@@ -127,13 +167,37 @@ var tests = []test{
 		http.StatusNoContent,
 		"null",
 	},
+	{
+		"POST",
+		"/19df9b86-eda3-489d-b75f-d38710e210cb/external-ips",
+		`{"pool_name":"apool","address":"192.168.0.2","instance_id":"validinstanceID"}`,
+		fmt.Sprintf("application/%s", ExternalIPsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/external-ips?instance=validinstanceID",
+		"",
+		fmt.Sprintf("application/%s", ExternalIPsV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"DELETE",
+		"/19df9b86-eda3-489d-b75f-d38710e210cb/external-ips?instance=validinstanceID",
+		"",
+		fmt.Sprintf("application/%s", ExternalIPsV1),
+		http.StatusAccepted,
+		"null",
+	},
 	{
 		"POST",
 		"/workloads",
 		`{"id":"","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!"}`,
 		fmt.Sprintf("application/%s", WorkloadsV1),
 		http.StatusCreated,
-		`{"workload":{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"public","workload_requirements":{"MemMB":0,"VCPUs":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false}},"link":{"rel":"self","href":"/workloads/ba58f471-0735-4773-9550-188e2d012941"}}`,
+		`{"workload":{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"public","workload_requirements":{"MemMB":0,"VCPUs":0,"MaxVCPUs":0,"MaxMemMB":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"CPUModel":"","CPUFeatures":null},"devices":{"Disk":"","NIC":"","Machine":""}},"link":{"rel":"self","href":"/workloads/ba58f471-0735-4773-9550-188e2d012941"}}`,
 	},
 	{
 		"DELETE",
@@ -149,7 +213,7 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", WorkloadsV1),
 		http.StatusOK,
-		`{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false}}`,
+		`{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"MaxVCPUs":0,"MaxMemMB":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"CPUModel":"","CPUFeatures":null},"devices":{"Disk":"","NIC":"","Machine":""}}`,
 	},
 	{
 		"GET",
@@ -157,7 +221,31 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", WorkloadsV1),
 		http.StatusOK,
-		`[{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false}}]`,
+		`[{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_name":"","config":"this will totally work!","storage":null,"visibility":"private","workload_requirements":{"MemMB":0,"VCPUs":0,"MaxVCPUs":0,"MaxMemMB":0,"NodeID":"","Hostname":"","NetworkNode":false,"Privileged":false,"CPUModel":"","CPUFeatures":null},"devices":{"Disk":"","NIC":"","Machine":""}}]`,
+	},
+	{
+		"GET",
+		"/tenants/quota-profiles",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"profiles":[{"name":"small","quotas":[{"name":"tenant-instances-quota","value":"10","usage":"0"},{"name":"tenant-vcpu-per-instance-limit","value":"unlimited"}]}]}`,
+	},
+	{
+		"PUT",
+		"/tenants/quota-profiles/small",
+		`{"quotas":[{"name":"tenant-instances-quota","value":"20"}]}`,
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/tenants/quota-profiles/small",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusNoContent,
+		"null",
 	},
 	{
 		"GET",
@@ -167,6 +255,22 @@ var tests = []test{
 		http.StatusOK,
 		`{"quotas":[{"name":"test-quota-1","value":"10","usage":"3"},{"name":"test-quota-2","value":"unlimited","usage":"10"},{"name":"test-limit","value":"123"}]}`,
 	},
+	{
+		"GET",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/summary",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","total_instances":3,"instance_states":{"active":2,"exited":1},"vcpus":6,"mem_mb":1536,"total_volumes":1,"volume_size_gb":10}`,
+	},
+	{
+		"GET",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/subnets",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"subnets":[{"subnet":"172.16.0.0/24","cnci_id":"cnciID","state":"active"},{"subnet":"172.16.1.0/24","state":"failed","reason":"No Network Nodes"}]}`,
+	},
 	{
 		"GET",
 		"/tenants",
@@ -181,7 +285,7 @@ var tests = []test{
 		"",
 		fmt.Sprintf("application/%s", TenantsV1),
 		http.StatusOK,
-		`{"name":"Test Tenant","subnet_bits":24,"permissions":{"privileged_containers":false}}`,
+		`{"name":"Test Tenant","subnet_bits":24,"permissions":{"privileged_containers":false},"notifications":{},"network_policy":{},"instance_defaults":{}}`,
 	},
 	{
 		"PATCH",
@@ -191,6 +295,54 @@ var tests = []test{
 		http.StatusNoContent,
 		"null",
 	},
+	{
+		"PATCH",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22",
+		`[{"op":"replace","path":"/name","value":"Updated Test Tenant"}]`,
+		fmt.Sprintf("application/%s", "json-patch+json"),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"PATCH",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/workloads/ba58f471-0735-4773-9550-188e2d012941",
+		`[{"op":"replace","path":"/description","value":"Updated workload"}]`,
+		fmt.Sprintf("application/%s", "json-patch+json"),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"PATCH",
+		"/workloads/ba58f471-0735-4773-9550-188e2d012941",
+		`[{"op":"replace","path":"/id","value":"ba58f471-0735-4773-9550-188e2d012942"}]`,
+		fmt.Sprintf("application/%s", "json-patch+json"),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Patch operation 0 (replace /id) failed: field is immutable"}}` + "\n",
+	},
+	{
+		"POST",
+		"/093ae09b-f653-464e-9ae6-5ae28bd03a22/workloads/ba58f471-0735-4773-9550-188e2d012941/preview",
+		`{"name":"web"}`,
+		fmt.Sprintf("application/%s", WorkloadsV1),
+		http.StatusOK,
+		`{"workload_id":"ba58f471-0735-4773-9550-188e2d012941","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","instance_id":"validinstanceid","name":"web","ip_address":"172.16.0.2","config":"---\n...\n"}`,
+	},
+	{
+		"POST",
+		"/workloads/ba58f471-0735-4773-9550-188e2d012941/preview",
+		`{}`,
+		fmt.Sprintf("application/%s", WorkloadsV1),
+		http.StatusForbidden,
+		`{"error":{"code":403,"name":"Forbidden","message":"Invalid Request"}}` + "\n",
+	},
+	{
+		"PATCH",
+		"/images/b286cd45-7d0c-4525-a140-4db6c95e41fa",
+		`{"name":"renamed"}`,
+		fmt.Sprintf("application/%s", "merge-patch+json"),
+		http.StatusNoContent,
+		"null",
+	},
 	{
 		"POST",
 		"/tenants",
@@ -206,6 +358,13 @@ var tests = []test{
 		fmt.Sprintf("application/%s", TenantsV1),
 		http.StatusNoContent,
 		"null",
+	}, {
+		"DELETE",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22?dry_run=true",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"operation":"delete_tenant","target":"093ae09b-f653-464e-9ae6-5ae28bd03a22","impact":{"instances":2},"token":"b8c2b5a6-1d35-4bb0-a6a5-3ae3d3c6a7c1","expires":"2017-06-01T12:05:00Z"}`,
 	}, {
 		"POST",
 		"/images",
@@ -238,6 +397,30 @@ var tests = []test{
 		http.StatusNoContent,
 		`null`,
 	},
+	{
+		"POST",
+		"/validtenantid/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/export",
+		`{"key":"cirros.raw"}`,
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusAccepted,
+		`{"id":"a4bd2a3e-9f3b-4a43-b4b3-3b1bd2e0d4a1","image_id":"1bea47ed-f6a9-463b-b423-14b9cca9ad27","tenant_id":"validtenantid","url":"https://s3.example.com/ciao-images/cirros.raw","state":"uploading","size":13167616,"uploaded":0,"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/images/exports",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusOK,
+		`{"exports":[{"id":"a4bd2a3e-9f3b-4a43-b4b3-3b1bd2e0d4a1","image_id":"1bea47ed-f6a9-463b-b423-14b9cca9ad27","tenant_id":"admin","url":"https://s3.example.com/ciao-images/1bea47ed-f6a9-463b-b423-14b9cca9ad27.raw","state":"failed","size":13167616,"uploaded":4096,"started":"2017-06-01T12:00:00Z","error":"PUT /ciao-images/1bea47ed-f6a9-463b-b423-14b9cca9ad27.raw: 403 Forbidden"}]}`,
+	},
+	{
+		"GET",
+		"/validtenantid/images/exports/a4bd2a3e-9f3b-4a43-b4b3-3b1bd2e0d4a1",
+		"",
+		fmt.Sprintf("application/%s", ImagesV1),
+		http.StatusOK,
+		`{"id":"a4bd2a3e-9f3b-4a43-b4b3-3b1bd2e0d4a1","image_id":"1bea47ed-f6a9-463b-b423-14b9cca9ad27","tenant_id":"validtenantid","url":"https://s3.example.com/ciao-images/cirros.raw","state":"done","size":13167616,"uploaded":13167616,"started":"2017-06-01T12:00:00Z"}`,
+	},
 	{
 		"POST",
 		"/validtenantid/volumes",
@@ -246,13 +429,69 @@ var tests = []test{
 		http.StatusAccepted,
 		`{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"new volume","description":"newly created volume","internal":false}`,
 	},
+	{
+		"POST",
+		"/validtenantid/volumes/batches",
+		`{"count": 2,"volume":{"size": 10}}`,
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusAccepted,
+		`{"id":"batch-id","tenant_id":"validtenantid","count":2,"state":"creating","volumes":null,"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/validtenantid/volumes/batches/batch-id",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusOK,
+		`{"id":"batch-id","tenant_id":"validtenantid","count":2,"state":"partial","volumes":["new-test-id"],"failures":[{"index":1,"error":"Tenant over quota"}],"started":"2017-06-01T12:00:00Z"}`,
+	},
 	{
 		"GET",
 		"/validtenantid/volumes",
 		"",
 		fmt.Sprintf("application/%s", VolumesV1),
 		http.StatusOK,
-		`[{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false},{"id":"new-test-id2","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"volume 2","description":"my other volume","internal":false}]`,
+		`[{"id":"new-test-id","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"my volume","description":"my volume for stuff","internal":false},{"id":"new-test-id2","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"volume 2","description":"my other volume","internal":false,"metadata":{"role":"db"},"tags":["data"]}]`,
+	},
+	{
+		"GET",
+		"/validtenantid/volumes?tag=data&metadata=role=db",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusOK,
+		`[{"id":"new-test-id2","bootable":false,"boot_index":0,"ephemeral":false,"local":false,"swap":false,"size":123456,"tenant_id":"test-tenant-id","state":"available","created":"0001-01-01T00:00:00Z","name":"volume 2","description":"my other volume","internal":false,"metadata":{"role":"db"},"tags":["data"]}]`,
+	},
+	{
+		"GET",
+		"/validtenantid/volumes?metadata=role=web",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusOK,
+		`[]`,
+	},
+	{
+		"PATCH",
+		"/validtenantid/volumes/new-test-id",
+		`{"metadata":{"role":"db"},"tags":["data"]}`,
+		fmt.Sprintf("application/%s", "merge-patch+json"),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/validtenantid/volumes?fields=id,state",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusOK,
+		`[{"id":"new-test-id","state":"available"},{"id":"new-test-id2","state":"available"}]`,
+	},
+	{
+		"GET",
+		"/validtenantid/volumes?fields=id,bogus",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Unknown field bogus"}}` + "\n",
 	},
 	{
 		"GET",
@@ -286,6 +525,246 @@ var tests = []test{
 		http.StatusAccepted,
 		"null",
 	},
+	{
+		"POST",
+		"/validtenantid/volumes/validvolumeid/action",
+		`{"protect":{}}`,
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/volumes/validvolumeid/action",
+		`{"unprotect":{}}`,
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"GET",
+		"/volumes/mismatches",
+		"",
+		fmt.Sprintf("application/%s", VolumesV1),
+		http.StatusOK,
+		`{"checked_at":"2017-06-01T12:00:00Z","mismatches":[{"volume_id":"validvolumeid","tenant_id":"test-tenant-id","kind":"size","size":10,"actual_size":20},{"volume_id":"missingvolumeid","tenant_id":"test-tenant-id","kind":"missing","size":10}]}`,
+	},
+	{
+		"POST",
+		"/node/d7d86208-b46c-4465-9018-ee14087d415f/drain",
+		`{"delete":true}`,
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusAccepted,
+		`{"node_id":"d7d86208-b46c-4465-9018-ee14087d415f","delete":true,"state":"evacuating","instances":2,"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/node/d7d86208-b46c-4465-9018-ee14087d415f/drain",
+		"",
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusOK,
+		`{"node_id":"d7d86208-b46c-4465-9018-ee14087d415f","delete":true,"state":"deleted","instances":0,"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"POST",
+		"/node/d7d86208-b46c-4465-9018-ee14087d415f/commands",
+		`{"command":"restart-agent"}`,
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusAccepted,
+		`{"id":"4f2e4b3e-5a53-4c53-8a1b-95d2c1bbf6c0","node_id":"d7d86208-b46c-4465-9018-ee14087d415f","command":"restart-agent","state":"pending","started":"2017-06-01T12:00:00Z","completed":"0001-01-01T00:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/node/d7d86208-b46c-4465-9018-ee14087d415f/commands/4f2e4b3e-5a53-4c53-8a1b-95d2c1bbf6c0",
+		"",
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusOK,
+		`{"id":"4f2e4b3e-5a53-4c53-8a1b-95d2c1bbf6c0","node_id":"d7d86208-b46c-4465-9018-ee14087d415f","command":"self-test","state":"succeeded","started":"2017-06-01T12:00:00Z","completed":"2017-06-01T12:00:01Z","checks":[{"name":"scheduler","success":true}]}`,
+	},
+	{
+		"GET",
+		"/node/d7d86208-b46c-4465-9018-ee14087d415f/commands",
+		"",
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusOK,
+		`{"commands":[{"id":"4f2e4b3e-5a53-4c53-8a1b-95d2c1bbf6c0","node_id":"d7d86208-b46c-4465-9018-ee14087d415f","command":"self-test","state":"succeeded","started":"2017-06-01T12:00:00Z","completed":"2017-06-01T12:00:01Z","checks":[{"name":"scheduler","success":true}]}]}`,
+	},
+	{
+		"GET",
+		"/node/shadow-placements",
+		"",
+		fmt.Sprintf("application/%s", NodeV1),
+		http.StatusOK,
+		`{"summaries":[{"policy":"spread","placements":1,"agreed":0,"disagreed":1,"unplaceable":0,"pending":0}],"placements":[{"instance_id":"validinstanceid","tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","policy":"spread","timestamp":"2017-06-01T12:00:00Z","shadow_node_id":"node1","actual_node_id":"node2"}]}`,
+	},
+	{
+		"POST",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/resubnet",
+		`{"subnet_bits":20}`,
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusAccepted,
+		`{"tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","subnet_bits":20,"state":"stopping","instances":3,"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/resubnet",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","subnet_bits":20,"state":"done","instances":3,"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"POST",
+		"/instances/validinstanceid/transfer",
+		`{"tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22"}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"instance_id":"validinstanceid","from_tenant_id":"8a497c68-a88a-4c1c-be56-12a4883208d3","to_tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","state":"unmapping","volumes":["a5c3d8e6-1a6b-4c47-9b5e-5e4b5b5d2f04"],"external_ips":["192.168.0.1"],"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/instances/validinstanceid/transfer",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"instance_id":"validinstanceid","from_tenant_id":"8a497c68-a88a-4c1c-be56-12a4883208d3","to_tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","state":"done","ip_address":"172.16.0.5","volumes":["a5c3d8e6-1a6b-4c47-9b5e-5e4b5b5d2f04"],"external_ips":["192.168.0.1"],"started":"2017-06-01T12:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/ips",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"ips":[{"address":"172.16.0.2","instance_id":"validinstanceid","orphaned":false},{"address":"172.16.0.3","orphaned":true}]}`,
+	},
+	{
+		"POST",
+		"/tenants/093ae09b-f653-464e-9ae6-5ae28bd03a22/ips/release",
+		`{"addresses":["172.16.0.3"]}`,
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"released":["172.16.0.3"]}`,
+	},
+	{
+		"GET",
+		"/tenants/vnis",
+		"",
+		fmt.Sprintf("application/%s", TenantsV1),
+		http.StatusOK,
+		`{"vnis":[{"vni":1,"tenant_id":"093ae09b-f653-464e-9ae6-5ae28bd03a22","subnet":"172.16.0.0/24","state":"allocated","release_time":"0001-01-01T00:00:00Z"}]}`,
+	},
+	{
+		"GET",
+		"/faults",
+		"",
+		fmt.Sprintf("application/%s", FaultsV1),
+		http.StatusOK,
+		`{"frame_drop_percent":10,"write_delay_ms":100,"launch_failure_percent":50}`,
+	},
+	{
+		"PUT",
+		"/faults",
+		`{"frame_drop_percent":10,"write_delay_ms":100,"launch_failure_percent":50}`,
+		fmt.Sprintf("application/%s", FaultsV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/config",
+		"",
+		fmt.Sprintf("application/%s", ConfigV1),
+		http.StatusOK,
+		`{"settings":[{"key":"max_instance_samples","value":"30","schema":{"type":"int","default":"10","min":1,"max":1000,"description":"number of samples"}}]}`,
+	},
+	{
+		"GET",
+		"/config/max_instance_samples",
+		"",
+		fmt.Sprintf("application/%s", ConfigV1),
+		http.StatusOK,
+		`{"key":"max_instance_samples","value":"30","schema":{"type":"int","default":"10","min":1,"max":1000,"description":"number of samples"}}`,
+	},
+	{
+		"PUT",
+		"/config/max_instance_samples",
+		`{"value":"30"}`,
+		fmt.Sprintf("application/%s", ConfigV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"GET",
+		"/storage/pools",
+		"",
+		fmt.Sprintf("application/%s", StoragePoolsV1),
+		http.StatusOK,
+		`{"pools":[{"name":"default","ceph_pool":"rbd","default":true,"capacity":{"total_gib":1024,"used_gib":24,"available_gib":1000}},{"name":"ssd","ceph_pool":"ssd","default":false,"capacity":{"total_gib":0,"used_gib":0,"available_gib":0},"error":"Pool ssd not found"}]}`,
+	},
+	{
+		"GET",
+		"/reports",
+		"",
+		fmt.Sprintf("application/%s", ReportsV1),
+		http.StatusOK,
+		`{"reports":[{"name":"daily","format":"csv","interval":"24h0m0s","last_run":"2017-06-01T00:00:00Z","next_run":"2017-06-02T00:00:00Z","delivered":3,"errors":["tenant t1: email to ops@example.com: timeout"]}]}`,
+	},
+	{
+		"POST",
+		"/reports/daily",
+		"",
+		fmt.Sprintf("application/%s", ReportsV1),
+		http.StatusAccepted,
+		`{"name":"daily","format":"csv","interval":"24h0m0s","last_run":"2017-06-01T00:00:00Z","next_run":"2017-06-01T12:00:00Z","delivered":3}`,
+	},
+	{
+		"POST",
+		"/datastore/verify",
+		`{"repair":true}`,
+		fmt.Sprintf("application/%s", DatastoreV1),
+		http.StatusOK,
+		`{"verified_at":"2017-06-01T00:00:00Z","repair":true,"inconsistencies":[{"kind":"orphaned","resource":"attachment","id":"a1","reason":"instance i1 does not exist","repaired":true}]}`,
+	},
+	{
+		"GET",
+		"/auth/lockouts",
+		"",
+		fmt.Sprintf("application/%s", AuthV1),
+		http.StatusOK,
+		`{"lockouts":[{"id":"source:192.168.0.10","kind":"source","name":"192.168.0.10","failures":6,"last_failure":"2017-06-01T00:00:00Z","locked_until":"2017-06-01T00:02:00Z"}]}`,
+	},
+	{
+		"DELETE",
+		"/auth/lockouts/source:192.168.0.10",
+		"",
+		fmt.Sprintf("application/%s", AuthV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/auth/lockouts/account:unknown",
+		"",
+		fmt.Sprintf("application/%s", AuthV1),
+		http.StatusNotFound,
+		`{"error":{"code":404,"name":"Not Found","message":"Authentication lockout not found"}}` + "\n",
+	},
+	{
+		"POST",
+		"/frames/replay",
+		`{"kind":"EVENT","op":1,"name":"Instance Deleted","payload":""}`,
+		fmt.Sprintf("application/%s", FramesV1),
+		http.StatusOK,
+		`{"replayed":2,"skipped":1}`,
+	},
+	{
+		"GET",
+		"/metrics",
+		"",
+		fmt.Sprintf("application/%s", MetricsV1),
+		http.StatusOK,
+		`{"datastore":{"locks":[{"name":"tenants","writes":2,"reads":10,"write_wait_ns":1000,"read_wait_ns":5000,"max_wait_ns":800}],"caches":[{"name":"instances","hits":20,"misses":1}]}}`,
+	},
 	{
 		"POST",
 		"/validtenantid/instances",
@@ -294,6 +773,46 @@ var tests = []test{
 		http.StatusAccepted,
 		`{"server":{"id":"validServerID","name":"new-server-test","imageRef":"http://glance.openstack.example.com/images/70a599e0-31e7-49b7-b260-868f441e862b","workload_id":"http://openstack.example.com/flavors/1","max_count":0,"min_count":0,"metadata":{"My Server Name":"Apache1"}}}`,
 	},
+	{
+		"POST",
+		"/validtenantid/stacks",
+		`{"name":"web","groups":[{"name":"db","workload_id":"dbWorkloadUUID"},{"name":"app","workload_id":"appWorkloadUUID","instances":2,"depends_on":["db"]}]}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		`{"id":"a8f1e4b6-3c5e-4e0a-9a47-2d0b31d0e0a1","name":"web","tenant_id":"validtenantid","state":"launching","on_failure":"abort","timeout":600,"groups":[{"name":"db","workload_id":"dbWorkloadUUID","state":"waiting","instances":[]},{"name":"app","workload_id":"appWorkloadUUID","depends_on":["db"],"state":"waiting","instances":[]}],"started":"2017-06-01T00:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/validtenantid/stacks",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"stacks":[{"id":"a8f1e4b6-3c5e-4e0a-9a47-2d0b31d0e0a1","name":"web","tenant_id":"validtenantid","state":"failed","on_failure":"abort","timeout":600,"groups":[{"name":"db","workload_id":"dbWorkloadUUID","state":"failed","instances":["testUUID"],"error":"instance testUUID is exited"},{"name":"app","workload_id":"appWorkloadUUID","depends_on":["db"],"state":"skipped","instances":[],"error":"dependencies failed: db"}],"started":"2017-06-01T00:00:00Z"}]}`,
+	},
+	{
+		"GET",
+		"/validtenantid/stacks/a8f1e4b6-3c5e-4e0a-9a47-2d0b31d0e0a1",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"id":"a8f1e4b6-3c5e-4e0a-9a47-2d0b31d0e0a1","name":"web","tenant_id":"validtenantid","state":"failed","on_failure":"abort","timeout":600,"groups":[{"name":"db","workload_id":"dbWorkloadUUID","state":"failed","instances":["testUUID"],"error":"instance testUUID is exited"},{"name":"app","workload_id":"appWorkloadUUID","depends_on":["db"],"state":"skipped","instances":[],"error":"dependencies failed: db"}],"started":"2017-06-01T00:00:00Z"}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/deleted",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"deleted_instances":[{"instance_id":"testUUID","tenant_id":"validtenantid","name":"web","workload_id":"testWorkloadUUID","node_id":"nodeUUID","instance_state":"exited","ip_address":"172.16.0.2","mac_address":"02:00:ac:10:00:02","subnet":"172.16.0.0/24","created":"2017-06-01T12:00:00Z","deleted":"2017-06-02T12:00:00Z"}]}`,
+	},
+	{
+		"DELETE",
+		"/validtenantid/instances/deleted",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusNoContent,
+		"null",
+	},
 	{
 		"GET",
 		"/validtenantid/instances/detail",
@@ -301,6 +820,34 @@ var tests = []test{
 		fmt.Sprintf("application/%s", InstancesV1),
 		http.StatusOK,
 		`{"total_servers":1,"servers":[{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"nodeUUID","id":"testUUID","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}]}`},
+	{
+		"GET",
+		"/validtenantid/instances/detail?fields=id,status,node_id",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"total_servers":1,"servers":[{"id":"testUUID","node_id":"nodeUUID","status":"active"}]}`},
+	{
+		"GET",
+		"/validtenantid/instances/detail?limit=1&fields=id",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"total_servers":2,"servers":[{"id":"testUUID"}],"next_marker":"testUUID"}`},
+	{
+		"GET",
+		"/validtenantid/instances/detail?state=exited",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"total_servers":0,"servers":null}`},
+	{
+		"GET",
+		"/validtenantid/instances/detail?limit=-1",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusBadRequest,
+		`{"error":{"code":400,"name":"Bad Request","message":"Invalid limit -1"}}` + "\n"},
 	{
 		"GET",
 		"/validtenantid/instances/instanceid",
@@ -310,17 +857,57 @@ var tests = []test{
 		`{"server":{"private_addresses":[{"addr":"192.169.0.1","mac_addr":"00:02:00:01:02:03"}],"created":"0001-01-01T00:00:00Z","workload_id":"testWorkloadUUID","node_id":"nodeUUID","id":"instanceid","name":"","volumes":null,"status":"active","tenant_id":"validtenantid","ssh_ip":"","ssh_port":0}}`,
 	},
 	{
-		"DELETE",
-		"/validtenantid/instances/instanceid",
-		"",
+		"GET",
+		"/validtenantid/instances/instanceid/network-stats",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"id":"instanceid","samples":[{"timestamp":"0001-01-01T00:00:00Z","networks":[{"interface":"vnic0","rx_bytes":1000,"tx_bytes":2000,"rx_packets":10,"tx_packets":20}]}]}`,
+	},
+	{
+		"GET",
+		"/validtenantid/instances/instanceid/disk-stats",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusOK,
+		`{"id":"instanceid","samples":[{"timestamp":"0001-01-01T00:00:00Z","disk_io":{"read_bytes":4096,"write_bytes":8192,"read_ops":10,"write_ops":20,"read_iops":1,"write_iops":2}}]}`,
+	},
+	{
+		"DELETE",
+		"/validtenantid/instances/instanceid",
+		"",
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/action",
+		`{"os-start":null}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/action",
+		`{"os-stop":null}`,
+		fmt.Sprintf("application/%s", InstancesV1),
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/validtenantid/instances/instanceid/action",
+		`{"protect":null}`,
 		fmt.Sprintf("application/%s", InstancesV1),
-		http.StatusNoContent,
+		http.StatusAccepted,
 		"null",
 	},
 	{
 		"POST",
 		"/validtenantid/instances/instanceid/action",
-		`{"os-start":null}`,
+		`{"unprotect":null}`,
 		fmt.Sprintf("application/%s", InstancesV1),
 		http.StatusAccepted,
 		"null",
@@ -328,10 +915,10 @@ var tests = []test{
 	{
 		"POST",
 		"/validtenantid/instances/instanceid/action",
-		`{"os-stop":null}`,
+		`{"createImage":{"name":"snapshot"}}`,
 		fmt.Sprintf("application/%s", InstancesV1),
 		http.StatusAccepted,
-		"null",
+		`{"id":"b286cd45-7d0c-4525-a140-4db6c95e41fa","state":"active","tenant_id":"validtenantid","name":"snapshot","create_time":"0001-01-01T00:00:00Z","size":123456,"visibility":"private","source_instance_id":"instanceid","source_volume_id":"validvolumeid"}`,
 	},
 }
 
@@ -353,6 +940,15 @@ func (ts testCiaoService) ListPools() ([]types.Pool, error) {
 		Links:    []types.Link{self},
 	}
 
+	sub := types.ExternalSubnet{
+		ID:        "ba58f471-0735-4773-9550-188e2d012941",
+		CIDR:      "192.168.0.0/30",
+		Used:      1,
+		Free:      1,
+		MappedIPs: []string{"192.168.0.1"},
+	}
+	resp.Subnets = append(resp.Subnets, sub)
+
 	return []types.Pool{resp}, nil
 }
 
@@ -431,7 +1027,7 @@ func (ts testCiaoService) ListMappedAddresses(tenant *string) []types.MappedIP {
 	return []types.MappedIP{m}
 }
 
-func (ts testCiaoService) MapAddress(tenantID string, name *string, instanceID string) error {
+func (ts testCiaoService) MapAddress(tenantID string, name *string, address *string, instanceID string) error {
 	return nil
 }
 
@@ -439,6 +1035,14 @@ func (ts testCiaoService) UnMapAddress(string) error {
 	return nil
 }
 
+func (ts testCiaoService) UnMapInstanceAddresses(tenantID *string, instanceID string) error {
+	return nil
+}
+
+func (ts testCiaoService) DrainPool(id string) error {
+	return nil
+}
+
 func (ts testCiaoService) CreateWorkload(req types.Workload) (types.Workload, error) {
 	req.ID = "ba58f471-0735-4773-9550-188e2d012941"
 	return req, nil
@@ -460,6 +1064,17 @@ func (ts testCiaoService) ShowWorkload(tenant string, ID string) (types.Workload
 	}, nil
 }
 
+func (ts testCiaoService) PreviewLaunch(tenantID string, workloadID string, name string) (types.LaunchPreview, error) {
+	return types.LaunchPreview{
+		WorkloadID: workloadID,
+		TenantID:   tenantID,
+		InstanceID: "validinstanceid",
+		Name:       name,
+		IPAddress:  "172.16.0.2",
+		Config:     "---\n...\n",
+	}, nil
+}
+
 func (ts testCiaoService) ListWorkloads(tenant string) ([]types.Workload, error) {
 	return []types.Workload{
 		{
@@ -482,6 +1097,35 @@ func (ts testCiaoService) ListQuotas(tenantID string) []types.QuotaDetails {
 	}
 }
 
+func (ts testCiaoService) ShowTenantSummary(tenantID string) (types.TenantResourceSummary, error) {
+	return types.TenantResourceSummary{
+		TenantID:       tenantID,
+		TotalInstances: 3,
+		InstanceStates: map[string]int{"active": 2, "exited": 1},
+		VCPUs:          6,
+		MemMB:          1536,
+		TotalVolumes:   1,
+		VolumeSizeGB:   10,
+	}, nil
+}
+
+func (ts testCiaoService) ListTenantSubnets(tenantID string) ([]types.TenantSubnet, error) {
+	return []types.TenantSubnet{
+		{Subnet: "172.16.0.0/24", CNCIID: "cnciID", State: types.SubnetActive},
+		{Subnet: "172.16.1.0/24", State: types.SubnetFailed, Reason: "No Network Nodes"},
+	}, nil
+}
+
+func (ts testCiaoService) ListVolumeMismatches() (types.VolumeCheckResponse, error) {
+	return types.VolumeCheckResponse{
+		CheckedAt: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+		Mismatches: []types.VolumeMismatch{
+			{VolumeID: "validvolumeid", TenantID: "test-tenant-id", Kind: types.VolumeSize, Size: 10, ActualSize: 20},
+			{VolumeID: "missingvolumeid", TenantID: "test-tenant-id", Kind: types.VolumeMissing, Size: 10},
+		},
+	}, nil
+}
+
 func (ts testCiaoService) EvacuateNode(nodeID string) error {
 	return nil
 }
@@ -490,10 +1134,344 @@ func (ts testCiaoService) RestoreNode(nodeID string) error {
 	return nil
 }
 
+func (ts testCiaoService) DrainNode(nodeID string, del bool) (types.NodeDrainStatus, error) {
+	return types.NodeDrainStatus{
+		NodeID:    nodeID,
+		Delete:    del,
+		State:     types.NodeDrainEvacuating,
+		Instances: 2,
+		Started:   time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) GetNodeDrain(nodeID string) (types.NodeDrainStatus, error) {
+	return types.NodeDrainStatus{
+		NodeID:  nodeID,
+		Delete:  true,
+		State:   types.NodeDrainDeleted,
+		Started: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) RunNodeCommand(nodeID string, command types.NodeCommandType) (types.NodeCommandStatus, error) {
+	return types.NodeCommandStatus{
+		ID:      "4f2e4b3e-5a53-4c53-8a1b-95d2c1bbf6c0",
+		NodeID:  nodeID,
+		Command: command,
+		State:   types.NodeCommandPending,
+		Started: time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) GetNodeCommand(nodeID string, commandID string) (types.NodeCommandStatus, error) {
+	return types.NodeCommandStatus{
+		ID:        commandID,
+		NodeID:    nodeID,
+		Command:   types.NodeCommandSelfTest,
+		State:     types.NodeCommandSucceeded,
+		Started:   time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+		Completed: time.Date(2017, 6, 1, 12, 0, 1, 0, time.UTC),
+		Checks:    []types.NodeSelfTestCheck{{Name: "scheduler", Success: true}},
+	}, nil
+}
+
+func (ts testCiaoService) ListNodeCommands(nodeID string) ([]types.NodeCommandStatus, error) {
+	status, err := ts.GetNodeCommand(nodeID, "4f2e4b3e-5a53-4c53-8a1b-95d2c1bbf6c0")
+	return []types.NodeCommandStatus{status}, err
+}
+
+func (ts testCiaoService) ResubnetTenant(tenantID string, subnetBits int) (types.TenantResubnetStatus, error) {
+	return types.TenantResubnetStatus{
+		TenantID:   tenantID,
+		SubnetBits: subnetBits,
+		State:      types.TenantResubnetStopping,
+		Instances:  3,
+		Started:    time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) GetTenantResubnet(tenantID string) (types.TenantResubnetStatus, error) {
+	return types.TenantResubnetStatus{
+		TenantID:   tenantID,
+		SubnetBits: 20,
+		State:      types.TenantResubnetDone,
+		Instances:  3,
+		Started:    time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) TransferInstance(instanceID string, tenantID string) (types.InstanceTransferStatus, error) {
+	return types.InstanceTransferStatus{
+		InstanceID:   instanceID,
+		FromTenantID: "8a497c68-a88a-4c1c-be56-12a4883208d3",
+		ToTenantID:   tenantID,
+		State:        types.InstanceTransferUnmapping,
+		Volumes:      []string{"a5c3d8e6-1a6b-4c47-9b5e-5e4b5b5d2f04"},
+		ExternalIPs:  []string{"192.168.0.1"},
+		Started:      time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) GetInstanceTransfer(instanceID string) (types.InstanceTransferStatus, error) {
+	return types.InstanceTransferStatus{
+		InstanceID:   instanceID,
+		FromTenantID: "8a497c68-a88a-4c1c-be56-12a4883208d3",
+		ToTenantID:   "093ae09b-f653-464e-9ae6-5ae28bd03a22",
+		State:        types.InstanceTransferDone,
+		IPAddress:    "172.16.0.5",
+		Volumes:      []string{"a5c3d8e6-1a6b-4c47-9b5e-5e4b5b5d2f04"},
+		ExternalIPs:  []string{"192.168.0.1"},
+		Started:      time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) ListDeletedInstances(tenantID string) ([]types.DeletedInstance, error) {
+	return []types.DeletedInstance{
+		{
+			ID:         "testUUID",
+			TenantID:   tenantID,
+			Name:       "web",
+			WorkloadID: "testWorkloadUUID",
+			NodeID:     "nodeUUID",
+			State:      payloads.Exited,
+			IPAddress:  "172.16.0.2",
+			MACAddress: "02:00:ac:10:00:02",
+			Subnet:     "172.16.0.0/24",
+			Created:    time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+			Deleted:    time.Date(2017, 6, 2, 12, 0, 0, 0, time.UTC),
+		},
+	}, nil
+}
+
+func (ts testCiaoService) PurgeDeletedInstances(tenantID string) error {
+	return nil
+}
+
+func (ts testCiaoService) ListTenantIPs(tenantID string) ([]types.TenantIP, error) {
+	return []types.TenantIP{
+		{Address: "172.16.0.2", InstanceID: "validinstanceid"},
+		{Address: "172.16.0.3", Orphaned: true},
+	}, nil
+}
+
+func (ts testCiaoService) ReleaseTenantIPs(tenantID string, addresses []string) ([]string, error) {
+	return addresses, nil
+}
+
+func (ts testCiaoService) ListShadowPlacements() (types.ShadowPlacementsResponse, error) {
+	return types.ShadowPlacementsResponse{
+		Summaries: []types.ShadowPlacementSummary{
+			{Policy: "spread", Placements: 1, Disagreed: 1},
+		},
+		Placements: []types.ShadowPlacement{
+			{
+				InstanceID:   "validinstanceid",
+				TenantID:     "093ae09b-f653-464e-9ae6-5ae28bd03a22",
+				Policy:       "spread",
+				Timestamp:    time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+				ShadowNodeID: "node1",
+				ActualNodeID: "node2",
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ListVNIs() ([]types.VNIAllocation, error) {
+	return []types.VNIAllocation{
+		{
+			VNI:      1,
+			TenantID: "093ae09b-f653-464e-9ae6-5ae28bd03a22",
+			Subnet:   "172.16.0.0/24",
+			State:    types.VNIAllocated,
+		},
+	}, nil
+}
+
+func (ts testCiaoService) DryRun(op types.DestructiveOperation, ID string) (types.DryRunResult, error) {
+	return types.DryRunResult{
+		Operation: op,
+		Target:    ID,
+		Impact:    map[string]int{"instances": 2},
+		Token:     "b8c2b5a6-1d35-4bb0-a6a5-3ae3d3c6a7c1",
+		Expires:   time.Date(2017, 6, 1, 12, 5, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) Confirm(op types.DestructiveOperation, ID string, token string) error {
+	return nil
+}
+
+func (ts testCiaoService) GetFaults() (types.FaultConfig, error) {
+	return types.FaultConfig{
+		FrameDropPercent:     10,
+		WriteDelayMS:         100,
+		LaunchFailurePercent: 50,
+	}, nil
+}
+
+func (ts testCiaoService) UpdateFaults(faults types.FaultConfig) error {
+	return nil
+}
+
+func (ts testCiaoService) GetConfig() (types.ConfigResponse, error) {
+	s, _ := ts.GetConfigSetting("max_instance_samples")
+	return types.ConfigResponse{Settings: []types.ConfigSetting{s}}, nil
+}
+
+func (ts testCiaoService) GetConfigSetting(key string) (types.ConfigSetting, error) {
+	return types.ConfigSetting{
+		Key:   key,
+		Value: "30",
+		Schema: types.ConfigSchema{
+			Type:        types.ConfigInt,
+			Default:     "10",
+			Min:         1,
+			Max:         1000,
+			Description: "number of samples",
+		},
+	}, nil
+}
+
+func (ts testCiaoService) UpdateConfigSetting(key string, value string) error {
+	return nil
+}
+
+func (ts testCiaoService) ListStoragePools() (types.StoragePoolsResponse, error) {
+	return types.StoragePoolsResponse{
+		Pools: []types.StoragePool{
+			{
+				Name:     "default",
+				CephPool: "rbd",
+				Default:  true,
+				Capacity: storage.PoolCapacity{
+					TotalGiB:     1024,
+					UsedGiB:      24,
+					AvailableGiB: 1000,
+				},
+			},
+			{
+				Name:     "ssd",
+				CephPool: "ssd",
+				Error:    "Pool ssd not found",
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ListReports() ([]types.ReportStatus, error) {
+	return []types.ReportStatus{
+		{
+			Name:      "daily",
+			Format:    types.ReportCSV,
+			Interval:  "24h0m0s",
+			LastRun:   time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+			NextRun:   time.Date(2017, 6, 2, 0, 0, 0, 0, time.UTC),
+			Delivered: 3,
+			Errors:    []string{"tenant t1: email to ops@example.com: timeout"},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) RunReport(name string) (types.ReportStatus, error) {
+	return types.ReportStatus{
+		Name:      name,
+		Format:    types.ReportCSV,
+		Interval:  "24h0m0s",
+		LastRun:   time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+		NextRun:   time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+		Delivered: 3,
+	}, nil
+}
+
+func (ts testCiaoService) VerifyDatastore(repair bool) (types.DatastoreVerifyResponse, error) {
+	return types.DatastoreVerifyResponse{
+		VerifiedAt: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+		Repair:     repair,
+		Inconsistencies: []types.Inconsistency{
+			{
+				Kind:     types.InconsistencyOrphaned,
+				Resource: "attachment",
+				ID:       "a1",
+				Reason:   "instance i1 does not exist",
+				Repaired: repair,
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ListAuthLockouts() ([]types.AuthLockout, error) {
+	return []types.AuthLockout{
+		{
+			ID:          "source:192.168.0.10",
+			Kind:        types.AuthLockoutSource,
+			Name:        "192.168.0.10",
+			Failures:    6,
+			LastFailure: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+			LockedUntil: time.Date(2017, 6, 1, 0, 2, 0, 0, time.UTC),
+		},
+	}, nil
+}
+
+func (ts testCiaoService) ClearAuthLockout(ID string) error {
+	if ID != "source:192.168.0.10" {
+		return types.ErrAuthLockoutNotFound
+	}
+
+	return nil
+}
+
+func (ts testCiaoService) ReplayFrames(capture io.Reader) (types.FrameReplayResult, error) {
+	return types.FrameReplayResult{Replayed: 2, Skipped: 1}, nil
+}
+
+func (ts testCiaoService) GetMetrics() (types.ControllerMetrics, error) {
+	return types.ControllerMetrics{
+		Datastore: types.DatastoreMetrics{
+			Locks: []types.LockMetrics{
+				{
+					Name:        "tenants",
+					Writes:      2,
+					Reads:       10,
+					WriteWaitNS: 1000,
+					ReadWaitNS:  5000,
+					MaxWaitNS:   800,
+				},
+			},
+			Caches: []types.CacheMetrics{
+				{
+					Name:   "instances",
+					Hits:   20,
+					Misses: 1,
+				},
+			},
+		},
+	}, nil
+}
+
 func (ts testCiaoService) UpdateQuotas(tenantID string, qds []types.QuotaDetails) error {
 	return nil
 }
 
+func (ts testCiaoService) ListQuotaProfiles() ([]types.QuotaProfile, error) {
+	return []types.QuotaProfile{
+		{
+			Name: "small",
+			Quotas: []types.QuotaDetails{
+				{Name: "tenant-instances-quota", Value: 10},
+				{Name: "tenant-vcpu-per-instance-limit", Value: -1},
+			},
+		},
+	}, nil
+}
+
+func (ts testCiaoService) UpdateQuotaProfile(name string, qds []types.QuotaDetails) error {
+	return nil
+}
+
+func (ts testCiaoService) DeleteQuotaProfile(name string) error {
+	return nil
+}
+
 func (ts testCiaoService) ListTenants() ([]types.TenantSummary, error) {
 	summary := types.TenantSummary{
 		ID:   "bc70dcd6-7298-4933-98a9-cded2d232d02",
@@ -521,7 +1499,7 @@ func (ts testCiaoService) ShowTenant(ID string) (types.TenantConfig, error) {
 	return config, nil
 }
 
-func (ts testCiaoService) PatchTenant(string, []byte) error {
+func (ts testCiaoService) PatchTenant(string, []byte, types.PatchFormat) error {
 	return nil
 }
 
@@ -600,6 +1578,31 @@ func (ts testCiaoService) DeleteImage(string, string) error {
 	return nil
 }
 
+func (ts testCiaoService) PatchImage(tenantID string, imageID string, patch []byte, format types.PatchFormat) error {
+	return nil
+}
+
+func (ts testCiaoService) PatchWorkload(tenantID string, workloadID string, patch []byte, format types.PatchFormat) error {
+	if format == types.JSONPatch && bytes.Contains(patch, []byte(`"/id"`)) {
+		return &types.PatchError{Index: 0, Op: "replace", Path: "/id", Reason: "field is immutable"}
+	}
+
+	return nil
+}
+
+func (ts testCiaoService) CreateInstanceImage(tenant string, instanceID string, req CreateImageRequest) (types.Image, error) {
+	return types.Image{
+		ID:               "b286cd45-7d0c-4525-a140-4db6c95e41fa",
+		State:            types.Active,
+		TenantID:         tenant,
+		Name:             req.Name,
+		Size:             123456,
+		Visibility:       types.Private,
+		SourceInstanceID: instanceID,
+		SourceVolumeID:   "validvolumeid",
+	}, nil
+}
+
 func (ts testCiaoService) ShowVolumeDetails(tenant string, volume string) (types.Volume, error) {
 	return types.Volume{
 		BlockDevice: storage.BlockDevice{
@@ -626,6 +1629,69 @@ func (ts testCiaoService) CreateVolume(tenant string, req RequestedVolume) (type
 	}, nil
 }
 
+func (ts testCiaoService) CreateVolumeBatch(tenant string, req RequestedVolumeBatch) (types.VolumeBatchStatus, error) {
+	return types.VolumeBatchStatus{
+		ID:       "batch-id",
+		TenantID: tenant,
+		Count:    req.Count,
+		State:    types.VolumeBatchCreating,
+		Started:  time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) GetVolumeBatch(tenant string, batchID string) (types.VolumeBatchStatus, error) {
+	return types.VolumeBatchStatus{
+		ID:       batchID,
+		TenantID: tenant,
+		Count:    2,
+		State:    types.VolumeBatchPartial,
+		Volumes:  []string{"new-test-id"},
+		Failures: []types.VolumeBatchFailure{{Index: 1, Error: "Tenant over quota"}},
+		Started:  time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) ExportImage(tenantID string, imageID string, req ExportImageRequest) (types.ImageExportStatus, error) {
+	return types.ImageExportStatus{
+		ID:       "a4bd2a3e-9f3b-4a43-b4b3-3b1bd2e0d4a1",
+		ImageID:  imageID,
+		TenantID: tenantID,
+		URL:      "https://s3.example.com/ciao-images/" + req.Key,
+		State:    types.ImageExportUploading,
+		Size:     13167616,
+		Started:  time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) ListImageExports(tenantID string) ([]types.ImageExportStatus, error) {
+	return []types.ImageExportStatus{
+		{
+			ID:       "a4bd2a3e-9f3b-4a43-b4b3-3b1bd2e0d4a1",
+			ImageID:  "1bea47ed-f6a9-463b-b423-14b9cca9ad27",
+			TenantID: tenantID,
+			URL:      "https://s3.example.com/ciao-images/1bea47ed-f6a9-463b-b423-14b9cca9ad27.raw",
+			State:    types.ImageExportFailed,
+			Size:     13167616,
+			Uploaded: 4096,
+			Started:  time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+			Error:    "PUT /ciao-images/1bea47ed-f6a9-463b-b423-14b9cca9ad27.raw: 403 Forbidden",
+		},
+	}, nil
+}
+
+func (ts testCiaoService) GetImageExport(tenantID string, exportID string) (types.ImageExportStatus, error) {
+	return types.ImageExportStatus{
+		ID:       exportID,
+		ImageID:  "1bea47ed-f6a9-463b-b423-14b9cca9ad27",
+		TenantID: tenantID,
+		URL:      "https://s3.example.com/ciao-images/cirros.raw",
+		State:    types.ImageExportDone,
+		Size:     13167616,
+		Uploaded: 13167616,
+		Started:  time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+	}, nil
+}
+
 func (ts testCiaoService) DeleteVolume(tenant string, volume string) error {
 	return nil
 }
@@ -638,6 +1704,14 @@ func (ts testCiaoService) DetachVolume(tenant string, volume string, attachment
 	return nil
 }
 
+func (ts testCiaoService) ProtectVolume(tenant string, volume string, protect bool) error {
+	return nil
+}
+
+func (ts testCiaoService) PatchVolume(tenant string, volume string, patch []byte, format types.PatchFormat) error {
+	return nil
+}
+
 func (ts testCiaoService) ListVolumesDetail(tenant string) ([]types.Volume, error) {
 	return []types.Volume{
 		{
@@ -659,6 +1733,8 @@ func (ts testCiaoService) ListVolumesDetail(tenant string) ([]types.Volume, erro
 			Name:        "volume 2",
 			Description: "my other volume",
 			TenantID:    "test-tenant-id",
+			Metadata:    map[string]string{"role": "db"},
+			Tags:        []string{"data"},
 		},
 	}, nil
 }
@@ -668,13 +1744,75 @@ func (ts testCiaoService) CreateServer(tenant string, req CreateServerRequest) (
 	return req, nil
 }
 
-func (ts testCiaoService) ListServersDetail(tenant string) ([]ServerDetails, error) {
-	var servers []ServerDetails
+func (ts testCiaoService) CreateStack(tenant string, req CreateStackRequest) (types.StackStatus, error) {
+	s := types.StackStatus{
+		ID:        "a8f1e4b6-3c5e-4e0a-9a47-2d0b31d0e0a1",
+		Name:      req.Name,
+		TenantID:  tenant,
+		State:     types.StackLaunching,
+		OnFailure: types.StackAbort,
+		Timeout:   600,
+		Started:   time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, g := range req.Groups {
+		s.Groups = append(s.Groups, types.StackGroupStatus{
+			Name:       g.Name,
+			WorkloadID: g.WorkloadID,
+			DependsOn:  g.DependsOn,
+			State:      types.StackGroupWaiting,
+			Instances:  []string{},
+		})
+	}
+
+	return s, nil
+}
+
+func (ts testCiaoService) GetStack(tenant string, stackID string) (types.StackStatus, error) {
+	return types.StackStatus{
+		ID:        stackID,
+		Name:      "web",
+		TenantID:  tenant,
+		State:     types.StackFailed,
+		OnFailure: types.StackAbort,
+		Timeout:   600,
+		Groups: []types.StackGroupStatus{
+			{
+				Name:       "db",
+				WorkloadID: "dbWorkloadUUID",
+				State:      types.StackGroupFailed,
+				Instances:  []string{"testUUID"},
+				Error:      "instance testUUID is exited",
+			},
+			{
+				Name:       "app",
+				WorkloadID: "appWorkloadUUID",
+				DependsOn:  []string{"db"},
+				State:      types.StackGroupSkipped,
+				Instances:  []string{},
+				Error:      "dependencies failed: db",
+			},
+		},
+		Started: time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func (ts testCiaoService) ListStacks(tenant string) ([]types.StackStatus, error) {
+	s, err := ts.GetStack(tenant, "a8f1e4b6-3c5e-4e0a-9a47-2d0b31d0e0a1")
+	return []types.StackStatus{s}, err
+}
+
+func (ts testCiaoService) ListServersDetail(filter types.InstanceFilter, marker string, limit int) (Servers, error) {
+	var servers Servers
+
+	if filter.State != "" && filter.State != "active" {
+		return servers, nil
+	}
 
 	server := ServerDetails{
 		NodeID:     "nodeUUID",
 		ID:         "testUUID",
-		TenantID:   tenant,
+		TenantID:   filter.TenantID,
 		WorkloadID: "testWorkloadUUID",
 		Status:     "active",
 		PrivateAddresses: []PrivateAddresses{
@@ -685,7 +1823,14 @@ func (ts testCiaoService) ListServersDetail(tenant string) ([]ServerDetails, err
 		},
 	}
 
-	servers = append(servers, server)
+	servers.TotalServers = 2
+	servers.Servers = append(servers.Servers, server)
+	if limit != 1 || marker != "" {
+		servers.TotalServers = 1
+		return servers, nil
+	}
+
+	servers.NextMarker = server.ID
 
 	return servers, nil
 }
@@ -708,6 +1853,43 @@ func (ts testCiaoService) ShowServerDetails(tenant string, server string) (Serve
 	return Server{Server: s}, nil
 }
 
+func (ts testCiaoService) ShowServerNetworkStats(tenant string, server string) (types.CiaoServerNetworkStats, error) {
+	sample := types.CiaoNetworkSample{
+		Networks: []types.CiaoNetworkUsage{
+			{
+				Interface: "vnic0",
+				RxBytes:   1000,
+				TxBytes:   2000,
+				RxPackets: 10,
+				TxPackets: 20,
+			},
+		},
+	}
+
+	return types.CiaoServerNetworkStats{
+		ID:      server,
+		Samples: []types.CiaoNetworkSample{sample},
+	}, nil
+}
+
+func (ts testCiaoService) ShowServerDiskStats(tenant string, server string) (types.CiaoServerDiskStats, error) {
+	sample := types.CiaoDiskIOSample{
+		DiskIO: types.CiaoDiskIOUsage{
+			ReadBytes:  4096,
+			WriteBytes: 8192,
+			ReadOps:    10,
+			WriteOps:   20,
+			ReadIOPS:   1,
+			WriteIOPS:  2,
+		},
+	}
+
+	return types.CiaoServerDiskStats{
+		ID:      server,
+		Samples: []types.CiaoDiskIOSample{sample},
+	}, nil
+}
+
 func (ts testCiaoService) DeleteServer(tenant string, server string) error {
 	return nil
 }
@@ -720,6 +1902,26 @@ func (ts testCiaoService) StopServer(tenant string, server string) error {
 	return nil
 }
 
+func (ts testCiaoService) ProtectServer(tenant string, server string, protect bool) error {
+	return nil
+}
+
+func (ts testCiaoService) RebalanceNodes(req types.RebalanceRequest) (types.RebalanceStatus, error) {
+	return types.RebalanceStatus{}, nil
+}
+
+func (ts testCiaoService) GetRebalance() (types.RebalanceStatus, error) {
+	return types.RebalanceStatus{}, nil
+}
+
+func (ts testCiaoService) HotAddServer(tenant string, server string, req HotAddRequest) error {
+	return nil
+}
+
+func (ts testCiaoService) Revision(resource types.RevisionedResource) string {
+	return "1-" + string(resource)
+}
+
 func TestResponse(t *testing.T) {
 	var ts testCiaoService
 
@@ -749,6 +1951,76 @@ func TestResponse(t *testing.T) {
 	}
 }
 
+func TestETag(t *testing.T) {
+	var ts testCiaoService
+
+	mux := Routes(Config{"", ts}, nil)
+
+	lists := []struct {
+		request string
+		media   string
+		etag    string
+	}{
+		{"/images", ImagesV1, `"1-images"`},
+		{"/validtenantid/volumes", VolumesV1, `"1-volumes"`},
+		{"/validtenantid/instances/detail", InstancesV1, `"1-instances"`},
+		{"/validtenantid/instances/detail?fields=name,id,name", InstancesV1, `"1-instances;fields=id,name"`},
+		{"/validtenantid/volumes?fields=id", VolumesV1, `"1-volumes;fields=id"`},
+	}
+
+	for _, l := range lists {
+		for _, match := range []string{"", `"0-stale"`, l.etag, `W/` + l.etag, `"0-stale", ` + l.etag, "*"} {
+			req, err := http.NewRequest("GET", l.request, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req = req.WithContext(service.SetPrivilege(req.Context(), true))
+			req.Header.Set("Content-Type", fmt.Sprintf("application/%s", l.media))
+			if match != "" {
+				req.Header.Set("If-None-Match", match)
+			}
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Header().Get("ETag") != `W/`+l.etag {
+				t.Errorf("%s: got ETag %q, expected %q", l.request, rr.Header().Get("ETag"), `W/`+l.etag)
+			}
+
+			expectedStatus := http.StatusNotModified
+			if match == "" || match == `"0-stale"` {
+				expectedStatus = http.StatusOK
+			}
+
+			if rr.Code != expectedStatus {
+				t.Errorf("%s: If-None-Match %q: got %v, expected %v", l.request, match, rr.Code, expectedStatus)
+			}
+
+			if expectedStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("%s: unexpected body in 304 response: %s", l.request, rr.Body.String())
+			}
+		}
+	}
+
+	// the tag of the full collection must not validate a projection
+	req, err := http.NewRequest("GET", "/validtenantid/instances/detail?fields=id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = req.WithContext(service.SetPrivilege(req.Context(), true))
+	req.Header.Set("Content-Type", fmt.Sprintf("application/%s", InstancesV1))
+	req.Header.Set("If-None-Match", `W/"1-instances"`)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("projection validated by collection tag: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+}
+
 func TestRoutes(t *testing.T) {
 	var ts testCiaoService
 	config := Config{"", ts}
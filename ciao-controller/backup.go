@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// backupDBCommand is the name of the command writing a backup of a
+// datastore, to be restored with -restore.
+const backupDBCommand = "backup-db"
+
+// backupDB implements the backup-db command, which writes the content of
+// a datastore to a file.  The file is only replaced once the backup is
+// complete.
+func backupDB(args []string, out io.Writer) (errOut error) {
+	fs := flag.NewFlagSet(backupDBCommand, flag.ContinueOnError)
+	from := fs.String("from", "", "URI of the datastore to back up, e.g., sqlite:///var/lib/ciao/data/controller/ciao-controller.db")
+	output := fs.String("o", "", "path of the backup file")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *from == "" || *output == "" {
+		return errors.New("Both -from and -o must be specified")
+	}
+
+	config, err := datastoreConfig(*from)
+	if err != nil {
+		return err
+	}
+
+	var ds datastore.Datastore
+	err = ds.Init(config)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening datastore %s", *from)
+	}
+	defer ds.Exit()
+
+	tmp := *output + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Error creating backup file")
+	}
+	defer func() {
+		if errOut != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	err = ds.Backup(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return errors.Wrap(err, "Error writing backup file")
+	}
+
+	err = os.Rename(tmp, *output)
+	if err != nil {
+		return errors.Wrap(err, "Error renaming backup file")
+	}
+
+	fmt.Fprintf(out, "Backed up %s to %s\n", *from, *output)
+
+	return nil
+}
+
+// restoreDB restores the backup stored in path into an empty datastore.
+func restoreDB(ds *datastore.Datastore, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "Error opening backup")
+	}
+	defer func() { _ = f.Close() }()
+
+	report, err := ds.Restore(f)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range datastore.MigrationTables {
+		glog.Infof("Restored %d records in %s", report[table], table)
+	}
+	glog.Warningf("Datastore restored from %s", path)
+
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+)
+
+func TestBackupDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	from := "sqlite://" + path.Join(dir, "from.db") + "?workloads_path=" + dir
+	backup := path.Join(dir, "backup.json")
+
+	err = backupDB([]string{"-from", from, "-o", backup}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(backup + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Temporary backup file left behind")
+	}
+
+	var ds datastore.Datastore
+	err = ds.Init(datastore.Config{
+		PersistentURI:     "file:" + path.Join(dir, "to.db"),
+		InitWorkloadsPath: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Exit()
+
+	err = restoreDB(&ds, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.GetQuotaProfiles()) == 0 {
+		t.Error("Quota profiles not restored")
+	}
+
+	err = backupDB([]string{"-from", from}, ioutil.Discard)
+	if err == nil {
+		t.Error("Backup without output file succeeded")
+	}
+}
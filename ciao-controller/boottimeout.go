@@ -0,0 +1,243 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var bootTimeout = flag.Duration("boot_timeout", 15*time.Minute, "time after which an instance not yet running is classified as failing to boot, 0 to disable")
+var bootTimeoutPolicyFlag = flag.String("boot_timeout_policy", "", "comma separated policies applied to the instances failing to boot: retry, delete")
+
+// bootCheckInterval is the interval between checks of the booting
+// instances against the boot timeout.
+var bootCheckInterval = time.Minute
+
+// bootTimeoutPolicy selects what the controller does with the instances
+// failing to boot. Boot failures are always logged as events.
+type bootTimeoutPolicy struct {
+	// retry launches again the instances no node reported, within the
+	// limit of the launch retries.
+	retry bool
+
+	// delete deletes the instances stuck on their node, and removes
+	// the ones no node reported whose launch is not retried.
+	delete bool
+}
+
+// parseBootTimeoutPolicy parses a comma separated list of boot timeout
+// policies.
+func parseBootTimeoutPolicy(s string) (bootTimeoutPolicy, error) {
+	var p bootTimeoutPolicy
+
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "", "none":
+		case "retry":
+			p.retry = true
+		case "delete":
+			p.delete = true
+		default:
+			return p, fmt.Errorf("Unknown boot timeout policy %q", v)
+		}
+	}
+
+	return p, nil
+}
+
+// bootFailure is the component an instance failing to boot is blamed on.
+type bootFailure string
+
+const (
+	// bootFailureScheduler blames instances no node reported: the
+	// scheduler did not place them, or their START was lost.
+	bootFailureScheduler bootFailure = "scheduler"
+
+	// bootFailureLauncher blames instances their node still reports
+	// as pending, the launcher not having managed to start them.
+	bootFailureLauncher bootFailure = "launcher"
+
+	// bootFailureGuest blames instances which exited before ever being
+	// reported running, the guest having failed or shut down while
+	// booting.
+	bootFailureGuest bootFailure = "guest"
+)
+
+// classifyBootFailure blames an instance failing to boot on a component
+// from the latest stats of its node.
+func classifyBootFailure(i *types.Instance) bootFailure {
+	if i.NodeID == "" {
+		return bootFailureScheduler
+	}
+
+	if i.State == payloads.Exited {
+		return bootFailureGuest
+	}
+
+	return bootFailureLauncher
+}
+
+// trackBoot records the start of the boot of an instance.
+func (c *controller) trackBoot(instanceID string) {
+	if *bootTimeout <= 0 {
+		return
+	}
+
+	c.bootLock.Lock()
+	defer c.bootLock.Unlock()
+
+	if c.boots == nil {
+		c.boots = make(map[string]time.Time)
+	}
+	c.boots[instanceID] = time.Now()
+}
+
+func (c *controller) untrackBoot(instanceID string) {
+	c.bootLock.Lock()
+	delete(c.boots, instanceID)
+	c.bootLock.Unlock()
+}
+
+// bootsReported stops tracking the boots of the instances a node reports
+// as running.
+func (c *controller) bootsReported(stats payloads.Stat) {
+	c.bootLock.Lock()
+	defer c.bootLock.Unlock()
+
+	if len(c.boots) == 0 {
+		return
+	}
+
+	for _, i := range stats.Instances {
+		if i.State == payloads.Running {
+			delete(c.boots, i.InstanceUUID)
+		}
+	}
+}
+
+// bootFailureMessage describes the boot failure of an instance, with the
+// data it was classified from.
+func (c *controller) bootFailureMessage(i *types.Instance, failure bootFailure, elapsed time.Duration) string {
+	msg := fmt.Sprintf("Instance %s not running %v after its launch: %s failure", i.ID, elapsed, failure)
+
+	switch failure {
+	case bootFailureScheduler:
+		msg += ", no node reported the instance"
+	case bootFailureLauncher:
+		msg += fmt.Sprintf(", node %s reports it %s", i.NodeID, i.State)
+		if _, err := c.ds.GetNode(i.NodeID); err != nil {
+			msg += " but is no longer connected"
+		}
+	case bootFailureGuest:
+		msg += fmt.Sprintf(", the guest exited while booting on node %s", i.NodeID)
+	}
+
+	if i.TraceLabel != "" {
+		msg += fmt.Sprintf(", see trace %s", i.TraceLabel)
+	}
+
+	return msg
+}
+
+// bootTimedOut classifies and logs the boot failure of an instance, and
+// applies the boot timeout policy to it.
+func (c *controller) bootTimedOut(i *types.Instance, elapsed time.Duration, policy bootTimeoutPolicy) {
+	failure := classifyBootFailure(i)
+	msg := c.bootFailureMessage(i, failure, elapsed)
+	glog.Warning(msg)
+
+	err := c.ds.LogEvent(i.TenantID, types.EventError, types.EventCategoryInstance, msg)
+	if err != nil {
+		glog.Warningf("Error logging boot failure of instance %s: %v", i.ID, err)
+	}
+
+	if failure == bootFailureScheduler {
+		if policy.retry && c.relaunch(i.ID, "", payloads.BootTimeout) {
+			c.trackBoot(i.ID)
+		} else if policy.delete {
+			// no node hosts the instance, it is only removed from
+			// the controller.
+			c.client.RemoveInstance(i.ID)
+		}
+		return
+	}
+
+	c.untrackLaunch(i.ID)
+
+	if policy.delete {
+		if err := c.deleteInstance(i.ID); err != nil {
+			glog.Warningf("Error deleting instance %s failing to boot: %v", i.ID, err)
+		}
+	}
+}
+
+// checkBoots handles the instances which did not boot within the boot
+// timeout.  Each boot failure is only handled once.
+func (c *controller) checkBoots(timeout time.Duration, policy bootTimeoutPolicy) {
+	now := time.Now()
+	overdue := make(map[string]time.Duration)
+
+	c.bootLock.Lock()
+	for id, start := range c.boots {
+		if elapsed := now.Sub(start); elapsed >= timeout {
+			overdue[id] = elapsed
+			delete(c.boots, id)
+		}
+	}
+	c.bootLock.Unlock()
+
+	for id, elapsed := range overdue {
+		i, err := c.ds.GetInstance(id)
+		if err != nil || i.State == payloads.Running {
+			continue
+		}
+
+		c.bootTimedOut(i, elapsed.Truncate(time.Second), policy)
+	}
+}
+
+// startBootChecker periodically checks the booting instances until
+// stopBootChecker is called.
+func (c *controller) startBootChecker(timeout time.Duration, policy bootTimeoutPolicy) {
+	c.bootCheckStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(bootCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.checkBoots(timeout, policy)
+			case <-c.bootCheckStop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopBootChecker() {
+	if c.bootCheckStop != nil {
+		close(c.bootCheckStop)
+		c.bootCheckStop = nil
+	}
+}
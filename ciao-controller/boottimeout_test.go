@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestParseBootTimeoutPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected bootTimeoutPolicy
+		valid    bool
+	}{
+		{"", bootTimeoutPolicy{}, true},
+		{"none", bootTimeoutPolicy{}, true},
+		{"retry", bootTimeoutPolicy{retry: true}, true},
+		{"delete", bootTimeoutPolicy{delete: true}, true},
+		{"retry, delete", bootTimeoutPolicy{retry: true, delete: true}, true},
+		{"restart", bootTimeoutPolicy{}, false},
+	}
+
+	for _, test := range tests {
+		p, err := parseBootTimeoutPolicy(test.policy)
+		if (err == nil) != test.valid {
+			t.Errorf("Policy %q: unexpected error %v", test.policy, err)
+			continue
+		}
+
+		if test.valid && p != test.expected {
+			t.Errorf("Policy %q: expected %+v, got %+v", test.policy, test.expected, p)
+		}
+	}
+}
+
+func TestClassifyBootFailure(t *testing.T) {
+	tests := []struct {
+		nodeID   string
+		state    string
+		expected bootFailure
+	}{
+		{"", payloads.Pending, bootFailureScheduler},
+		{"node", payloads.Pending, bootFailureLauncher},
+		{"node", payloads.Exited, bootFailureGuest},
+	}
+
+	for _, test := range tests {
+		i := &types.Instance{NodeID: test.nodeID, State: test.state}
+		if f := classifyBootFailure(i); f != test.expected {
+			t.Errorf("Instance %s on node %q: expected %s failure, got %s",
+				test.state, test.nodeID, test.expected, f)
+		}
+	}
+}
+
+func TestCheckBoots(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	booting := types.Instance{
+		TenantID: tenant.ID,
+		State:    payloads.Pending,
+		NodeID:   uuid.Generate().String(),
+		ID:       uuid.Generate().String(),
+	}
+	booted := types.Instance{
+		TenantID: tenant.ID,
+		State:    payloads.Pending,
+		ID:       uuid.Generate().String(),
+	}
+
+	for _, i := range []*types.Instance{&booting, &booted} {
+		if err := ctl.ds.AddInstance(i); err != nil {
+			t.Fatal(err)
+		}
+		ctl.trackBoot(i.ID)
+	}
+
+	ctl.bootsReported(payloads.Stat{
+		Instances: []payloads.InstanceStat{
+			{InstanceUUID: booted.ID, State: payloads.Running},
+		},
+	})
+
+	countFailures := func() int {
+		logs, err := ctl.ds.GetEventLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		for _, e := range logs {
+			if e.TenantID == tenant.ID && strings.Contains(e.Message, "launcher failure") {
+				n++
+			}
+		}
+		return n
+	}
+
+	ctl.checkBoots(time.Hour, bootTimeoutPolicy{})
+	if n := countFailures(); n != 0 {
+		t.Fatalf("Expected no boot failure before the timeout, found %d", n)
+	}
+
+	ctl.checkBoots(0, bootTimeoutPolicy{})
+	ctl.checkBoots(0, bootTimeoutPolicy{})
+	if n := countFailures(); n != 1 {
+		t.Fatalf("Expected 1 boot failure, found %d", n)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var frameCapture = flag.String("frame_capture", "", "path of the file the SSNTP frames received by the controller are captured to, for debugging")
+var frameCaptureMax = flag.Int("frame_capture_max", 100000, "number of frames after which the capture file is rotated, 0 for no limit")
+
+// Kinds of captured frames.
+const (
+	captureStatus  = "STATUS"
+	captureCommand = "COMMAND"
+	captureEvent   = "EVENT"
+	captureError   = "ERROR"
+)
+
+// capturedFrame is an SSNTP frame received by the controller, as written
+// to the capture file, one JSON object per line.
+type capturedFrame struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Op       uint8     `json:"op"`
+	Name     string    `json:"name"`
+	Origin   string    `json:"origin"`
+	Sequence uint64    `json:"sequence"`
+	Payload  string    `json:"payload"`
+}
+
+// frameRecorder writes the SSNTP frames received by the controller to a
+// capture file so that they can be replayed against a simulated cluster.
+// Once the file holds max frames it is renamed with a .1 suffix, replacing
+// the previous one, and a new file is started, so at most twice max frames
+// are kept.
+type frameRecorder struct {
+	lock  sync.Mutex
+	path  string
+	max   int
+	file  *os.File
+	count int
+}
+
+func newFrameRecorder(path string, max int) (*frameRecorder, error) {
+	r := &frameRecorder{
+		path: path,
+		max:  max,
+	}
+
+	// the capture of the previous run is kept in the rotated file
+	err := r.rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *frameRecorder) rotate() error {
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+
+	err := os.Rename(r.path, r.path+".1")
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Error rotating frame capture")
+	}
+
+	r.file, err = os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Error creating frame capture")
+	}
+	r.count = 0
+
+	return nil
+}
+
+// record appends a frame to the capture file. A nil recorder records
+// nothing.
+func (r *frameRecorder) record(kind string, op uint8, name string, frame *ssntp.Frame) {
+	if r == nil {
+		return
+	}
+
+	f := capturedFrame{
+		Time:     time.Now(),
+		Kind:     kind,
+		Op:       op,
+		Name:     name,
+		Origin:   frame.Origin.String(),
+		Sequence: frame.Sequence,
+		Payload:  string(frame.Payload),
+	}
+
+	b, err := json.Marshal(&f)
+	if err != nil {
+		glog.Warningf("Error marshalling captured frame: %v", err)
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.max > 0 && r.count >= r.max {
+		if err := r.rotate(); err != nil {
+			glog.Warningf("Frame capture stopped: %v", err)
+			return
+		}
+	}
+
+	if r.file == nil {
+		return
+	}
+
+	_, err = r.file.Write(append(b, '\n'))
+	if err != nil {
+		glog.Warningf("Error writing captured frame: %v", err)
+		return
+	}
+	r.count++
+}
+
+func (r *frameRecorder) close() {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+}
+
+// ReplayFrames feeds the frames of a capture to the controller, in the
+// order in which they were captured, as if they had been sent by the
+// simulated nodes. Replaying is only possible when the controller runs
+// against simulated nodes.
+func (c *controller) ReplayFrames(capture io.Reader) (types.FrameReplayResult, error) {
+	var res types.FrameReplayResult
+
+	sim, ok := c.client.(*simulatedClient)
+	if !ok {
+		return res, types.ErrReplayDisabled
+	}
+
+	scanner := bufio.NewScanner(capture)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var f capturedFrame
+		err := json.Unmarshal(scanner.Bytes(), &f)
+		if err != nil {
+			return res, types.ErrBadRequest
+		}
+
+		if !sim.replay(f) {
+			glog.Warningf("Skipping captured frame of unknown kind %s", f.Kind)
+			res.Skipped++
+			continue
+		}
+		res.Replayed++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return res, errors.Wrap(err, "Error reading frame capture")
+	}
+
+	glog.Warningf("Replayed %d captured frames, skipped %d", res.Replayed, res.Skipped)
+
+	return res, nil
+}
+
+// replay hands a captured frame to the controller, returning false if
+// the frame is of an unknown kind.
+func (client *simulatedClient) replay(f capturedFrame) bool {
+	frame := &ssntp.Frame{
+		Sequence: f.Sequence,
+		Payload:  []byte(f.Payload),
+	}
+
+	if origin, err := uuid.Parse(f.Origin); err == nil {
+		frame.Origin = origin
+	}
+
+	client.notifyLock.Lock()
+	defer client.notifyLock.Unlock()
+
+	switch f.Kind {
+	case captureStatus:
+		client.notifier.StatusNotify(ssntp.Status(f.Op), frame)
+	case captureCommand:
+		client.notifier.CommandNotify(ssntp.Command(f.Op), frame)
+	case captureEvent:
+		client.notifier.EventNotify(ssntp.Event(f.Op), frame)
+	case captureError:
+		client.notifier.ErrorNotify(ssntp.Error(f.Op), frame)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCaptureReplayFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "frames")
+	r, err := newFrameRecorder(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nodes []string
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, uuid.Generate().String())
+		y, err := yaml.Marshal(payloads.NodeConnected{
+			Connected: payloads.NodeConnectedEvent{
+				NodeUUID: nodes[i],
+				NodeType: payloads.ComputeNode,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.record(captureEvent, uint8(ssntp.NodeConnected), ssntp.NodeConnected.String(),
+			&ssntp.Frame{Payload: y})
+	}
+	r.close()
+
+	rotated, err := ioutil.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := bytes.Count(rotated, []byte("\n")); n != 2 {
+		t.Fatalf("Expected 2 frames in the rotated capture, got %d", n)
+	}
+	if n := bytes.Count(current, []byte("\n")); n != 1 {
+		t.Fatalf("Expected 1 frame in the capture, got %d", n)
+	}
+
+	_, err = ctl.ReplayFrames(bytes.NewReader(rotated))
+	if err != types.ErrReplayDisabled {
+		t.Fatalf("Expected ErrReplayDisabled, got %v", err)
+	}
+
+	sim := &controller{ds: new(datastore.Datastore)}
+	err = sim.ds.Init(datastore.Config{
+		DBBackend:         &datastore.MemoryDB{},
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.ds.Exit()
+
+	defer func(latency time.Duration) { simulatedLatency = latency }(simulatedLatency)
+	simulatedLatency = 0
+
+	sim.client = newSimulatedClient(sim, 1)
+	defer sim.client.Disconnect()
+
+	capture := append(rotated, []byte(`{"kind":"UNKNOWN"}`+"\n")...)
+	capture = append(capture, current...)
+
+	res, err := sim.ReplayFrames(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Replayed != 3 || res.Skipped != 1 {
+		t.Fatalf("Unexpected replay result %+v", res)
+	}
+
+	for _, n := range nodes {
+		if _, err := sim.ds.GetNode(n); err != nil {
+			t.Fatalf("Node %s not replayed: %v", n, err)
+		}
+	}
+}
@@ -18,13 +18,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -36,21 +39,30 @@ type controllerClient interface {
 	StartWorkload(config string) error
 	DeleteInstance(instanceID string, nodeID string) error
 	StopInstance(instanceID string, nodeID string) error
+	DeleteInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error
+	StopInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error
 	RestartInstance(i *types.Instance, w *types.Workload, t *types.Tenant) error
+	RestartInstanceAndWait(ctx context.Context, i *types.Instance, w *types.Workload, t *types.Tenant) error
 	RemoveInstance(instanceID string)
-	EvacuateNode(nodeID string) error
-	RestoreNode(nodeID string) error
+	EvacuateNode(nodeID string, commandID string) error
+	RestoreNode(nodeID string, commandID string) error
+	cacheImage(nodeID string, image string) error
 	Disconnect()
 	mapExternalIP(t types.Tenant, m types.MappedIP) error
 	unMapExternalIP(t types.Tenant, m types.MappedIP) error
+	setNetworkPolicy(t types.Tenant, cnci *types.Instance) error
 	attachVolume(volID string, instanceID string, nodeID string) error
-	ssntpClient() *ssntp.Client
+	hotAdd(instanceID string, nodeID string, vcpus int, memMB int) error
+	restartAgent(nodeID string, commandID string) error
+	selfTest(nodeID string, commandID string) error
+	reconfigureNode(nodeID string, commandID string) error
 }
 
 type ssntpClient struct {
-	ctl   *controller
-	ssntp ssntp.Client
-	name  string
+	ctl       *controller
+	transport transport
+	name      string
+	acks      ackTracker
 }
 
 func (client *ssntpClient) ConnectNotify() {
@@ -63,6 +75,8 @@ func (client *ssntpClient) DisconnectNotify() {
 
 func (client *ssntpClient) StatusNotify(status ssntp.Status, frame *ssntp.Frame) {
 	glog.Info("STATUS for ", client.name)
+
+	client.ctl.capture.record(captureStatus, uint8(status), status.String(), frame)
 }
 
 func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
@@ -71,6 +85,18 @@ func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Fra
 
 	glog.Info("COMMAND ", command, " for ", client.name)
 
+	client.ctl.capture.record(captureCommand, uint8(command), command.String(), frame)
+
+	if !client.ctl.frames.accept(frame) {
+		glog.Warningf("Dropping duplicate %s frame %d from %s", command, frame.Sequence, frame.Origin)
+		return
+	}
+
+	if client.ctl.faults.dropFrame() {
+		glog.Warningf("Injected fault: dropping %s frame %d from %s", command, frame.Sequence, frame.Origin)
+		return
+	}
+
 	if command == ssntp.STATS {
 		stats.Init()
 		err := yaml.Unmarshal(payload, &stats)
@@ -82,6 +108,22 @@ func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Fra
 		if err != nil {
 			glog.Warningf("Error updating stats in datastore: %v", err)
 		}
+
+		client.ctl.launchesReported(stats)
+
+		client.ctl.shadowPlacementsReported(stats)
+
+		client.ctl.bootsReported(stats)
+
+		client.ctl.checkNodeHealth(stats)
+
+		client.ctl.checkInstanceHealth(stats)
+
+		client.ctl.checkDiskUsage(stats)
+
+		client.ctl.imagesReported(stats)
+
+		client.ctl.remapExternalIPs()
 	}
 	glog.V(1).Info(string(payload))
 }
@@ -111,13 +153,16 @@ func (client *ssntpClient) releaseResources(instanceID string) error {
 
 	resources := []payloads.RequestedResource{
 		{Type: payloads.Instance, Value: 1},
-		{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
+		{Type: payloads.MemMB, Value: wl.Requirements.MemMB + i.ExtraMemMB},
+		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs + i.ExtraVCPUs}}
 	client.ctl.qs.Release(i.TenantID, resources...)
 	return nil
 }
 
 func (client *ssntpClient) RemoveInstance(instanceID string) {
+	client.ctl.untrackLaunch(instanceID)
+	client.ctl.untrackBoot(instanceID)
+
 	err := client.releaseResources(instanceID)
 	if err != nil {
 		glog.Warningf("Error when releasing resources for deleted instance: %v", err)
@@ -130,6 +175,8 @@ func (client *ssntpClient) RemoveInstance(instanceID string) {
 		return
 	}
 
+	client.ctl.releaseInstanceExternalIPs(i)
+
 	err = client.ctl.ds.DeleteInstance(instanceID)
 	if err != nil {
 		glog.Warningf("Error deleting instance from datastore: %v", err)
@@ -163,6 +210,7 @@ func (client *ssntpClient) instanceDeleted(payload []byte) {
 		return
 	}
 	client.RemoveInstance(event.InstanceDeleted.InstanceUUID)
+	client.acks.ack(event.InstanceDeleted.CommandID, event.InstanceDeleted.InstanceUUID, nil)
 }
 
 func (client *ssntpClient) instanceStopped(payload []byte) {
@@ -175,6 +223,8 @@ func (client *ssntpClient) instanceStopped(payload []byte) {
 	instanceID := event.InstanceStopped.InstanceUUID
 	glog.Infof("Stopped instance %s", instanceID)
 
+	defer client.acks.ack(event.InstanceStopped.CommandID, instanceID, nil)
+
 	i, err := client.ctl.ds.GetInstance(instanceID)
 	if err != nil {
 		glog.Warningf("Error getting instance from datastore: %v", err)
@@ -230,6 +280,17 @@ func (client *ssntpClient) concentratorInstanceAdded(payload []byte) {
 	err = tenant.CNCIctrl.CNCIAdded(newCNCI.InstanceUUID)
 	if err != nil {
 		glog.Warningf("Error adding CNCI: %v", err)
+		return
+	}
+
+	// a new CNCI routes all the traffic until told otherwise
+	if tenant.NetworkPolicy.AllowAll() {
+		return
+	}
+
+	err = client.setNetworkPolicy(*tenant, i)
+	if err != nil {
+		glog.Warningf("Error programming network policy: %v", err)
 	}
 }
 
@@ -271,6 +332,8 @@ func (client *ssntpClient) nodeDisconnected(payload []byte) {
 	if err != nil {
 		glog.Warningf("Error marking node as deleted in datastore: %v", err)
 	}
+
+	client.ctl.forgetNodeImages(nodeDisconnected.Disconnected.NodeUUID)
 }
 
 func (client *ssntpClient) unassignEvent(payload []byte) {
@@ -281,9 +344,19 @@ func (client *ssntpClient) unassignEvent(payload []byte) {
 		return
 	}
 
-	i, err := client.ctl.ds.GetInstance(event.UnassignedIP.InstanceUUID)
-	if err != nil {
-		glog.Warningf("Error getting instance from datastore: %v", err)
+	if client.ctl.remapUnassigned(event.UnassignedIP.PublicIP) {
+		return
+	}
+
+	// the instance may already be gone when its addresses were
+	// unmapped as part of its deletion, in which case the mapping
+	// may have been released along with the instance.
+	m, err := client.ctl.ds.GetMappedIP(event.UnassignedIP.PublicIP)
+	if err == types.ErrAddressNotFound {
+		glog.V(1).Infof("%s already unmapped", event.UnassignedIP.PublicIP)
+		return
+	} else if err != nil {
+		glog.Warningf("Error getting mapped IP from datastore: %v", err)
 		return
 	}
 
@@ -293,10 +366,10 @@ func (client *ssntpClient) unassignEvent(payload []byte) {
 		return
 	}
 
-	client.ctl.qs.Release(i.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+	client.ctl.qs.Release(m.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
 
 	msg := fmt.Sprintf("Unmapped %s from %s", event.UnassignedIP.PublicIP, event.UnassignedIP.PrivateIP)
-	err = client.ctl.ds.LogEvent(i.TenantID, msg)
+	err = client.ctl.ds.LogEvent(m.TenantID, types.EventInfo, types.EventCategoryNetwork, msg)
 	if err != nil {
 		glog.Warningf("Error logging event: %v", err)
 	}
@@ -317,7 +390,11 @@ func (client *ssntpClient) assignEvent(payload []byte) {
 	}
 
 	msg := fmt.Sprintf("Mapped %s to %s", event.AssignedIP.PublicIP, event.AssignedIP.PrivateIP)
-	err = client.ctl.ds.LogEvent(i.TenantID, msg)
+	if client.ctl.remapAssigned(event.AssignedIP.PublicIP, false) {
+		msg = fmt.Sprintf("Remapped %s to %s", event.AssignedIP.PublicIP, event.AssignedIP.PrivateIP)
+	}
+
+	err = client.ctl.ds.LogEvent(i.TenantID, types.EventInfo, types.EventCategoryNetwork, msg)
 	if err != nil {
 		glog.Warningf("Error logging event: %v", err)
 	}
@@ -328,6 +405,18 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 
 	glog.Info("EVENT ", event, " for ", client.name)
 
+	client.ctl.capture.record(captureEvent, uint8(event), event.String(), frame)
+
+	if !client.ctl.frames.accept(frame) {
+		glog.Warningf("Dropping duplicate %s frame %d from %s", event, frame.Sequence, frame.Origin)
+		return
+	}
+
+	if client.ctl.faults.dropFrame() {
+		glog.Warningf("Injected fault: dropping %s frame %d from %s", event, frame.Sequence, frame.Origin)
+		return
+	}
+
 	glog.V(1).Info(string(payload))
 
 	switch event {
@@ -355,6 +444,44 @@ func (client *ssntpClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 	case ssntp.PublicIPUnassigned:
 		client.unassignEvent(payload)
 
+	case ssntp.NodeCommandResult:
+		client.nodeCommandResult(payload)
+
+	case ssntp.TrafficReport:
+		client.trafficReport(payload)
+
+	}
+}
+
+func (client *ssntpClient) trafficReport(payload []byte) {
+	var event payloads.EventTrafficReport
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling TrafficReport: %v", err)
+		return
+	}
+
+	err = client.ctl.ds.AddTrafficReport(event.TrafficReport)
+	if err != nil {
+		glog.Warningf("Error adding traffic report: %v", err)
+	}
+}
+
+func (client *ssntpClient) nodeCommandResult(payload []byte) {
+	var event payloads.EventNodeCommandResult
+	err := yaml.Unmarshal(payload, &event)
+	if err != nil {
+		glog.Warningf("Error unmarshalling NodeCommandResult: %v", err)
+		return
+	}
+
+	result := event.NodeCommandResult
+	client.ctl.nodeCommandResult(result)
+
+	// instance commands are acknowledged by a result without error,
+	// their failures are reported by the error frames of each command.
+	if result.CommandID != "" && result.Error == "" {
+		client.acks.ack(result.CommandID, "", nil)
 	}
 }
 
@@ -365,6 +492,16 @@ func (client *ssntpClient) startFailure(payload []byte) {
 		glog.Warningf("Error unmarshalling StartFailure: %v", err)
 		return
 	}
+
+	if failure.CommandID != "" {
+		defer client.acks.ack(failure.CommandID, failure.InstanceUUID,
+			fmt.Errorf("Error starting instance %s: %s", failure.InstanceUUID, failure.Reason))
+	}
+
+	if client.ctl.retryLaunch(failure) {
+		return
+	}
+	client.ctl.untrackBoot(failure.InstanceUUID)
 	if failure.Reason.IsFatal() && !failure.Restart {
 		client.deleteEphemeralStorage(failure.InstanceUUID)
 		err = client.releaseResources(failure.InstanceUUID)
@@ -382,6 +519,13 @@ func (client *ssntpClient) startFailure(payload []byte) {
 	cnci := i.CNCI
 	tenantID := i.TenantID
 
+	if failure.Reason == payloads.ImageCorrupt {
+		err = client.ctl.instanceImageCorrupted(i, failure.Reason.String())
+		if err != nil {
+			glog.Warningf("Error deactivating image of instance %s: %v", failure.InstanceUUID, err)
+		}
+	}
+
 	err = client.ctl.ds.StartFailure(failure.InstanceUUID, failure.Reason, failure.Restart, failure.NodeUUID)
 	if err != nil {
 		glog.Warningf("Error adding StartFailure to datastore: %v", err)
@@ -394,13 +538,27 @@ func (client *ssntpClient) startFailure(payload []byte) {
 			return
 		}
 
-		err = tenant.CNCIctrl.StartFailure(failure.InstanceUUID)
+		err = tenant.CNCIctrl.StartFailure(failure.InstanceUUID, failure.Reason.String())
 		if err != nil {
 			glog.Warningf("Error adding StartFailure to datastore: %v", err)
 		}
 	}
 }
 
+func (client *ssntpClient) deleteFailure(payload []byte) {
+	var failure payloads.ErrorDeleteFailure
+	err := yaml.Unmarshal(payload, &failure)
+	if err != nil {
+		glog.Warningf("Error unmarshalling DeleteFailure: %v", err)
+		return
+	}
+
+	glog.Warningf("Error deleting instance %s: %s", failure.InstanceUUID, failure.Reason)
+
+	client.acks.ack(failure.CommandID, failure.InstanceUUID,
+		fmt.Errorf("Error deleting instance %s: %s", failure.InstanceUUID, failure.Reason))
+}
+
 func (client *ssntpClient) attachVolumeFailure(payload []byte) {
 	var failure payloads.ErrorAttachVolumeFailure
 	err := yaml.Unmarshal(payload, &failure)
@@ -412,6 +570,29 @@ func (client *ssntpClient) attachVolumeFailure(payload []byte) {
 	if err != nil {
 		glog.Warningf("Error handling AttachVolumeFailure in datastore: %v", err)
 	}
+
+	if failure.CommandID != "" {
+		client.acks.ack(failure.CommandID, failure.InstanceUUID,
+			fmt.Errorf("Error attaching volume %s to instance %s: %s",
+				failure.VolumeUUID, failure.InstanceUUID, failure.Reason))
+	}
+}
+
+func (client *ssntpClient) hotAddFailure(payload []byte) {
+	var failure payloads.ErrorHotAddFailure
+	err := yaml.Unmarshal(payload, &failure)
+	if err != nil {
+		glog.Warningf("Error unmarshalling HotAddFailure: %v", err)
+		return
+	}
+
+	client.ctl.hotAddFailed(failure)
+
+	if failure.CommandID != "" {
+		client.acks.ack(failure.CommandID, failure.InstanceUUID,
+			fmt.Errorf("Error hot adding resources to instance %s: %s",
+				failure.InstanceUUID, failure.Reason))
+	}
 }
 
 func (client *ssntpClient) assignError(payload []byte) {
@@ -422,15 +603,19 @@ func (client *ssntpClient) assignError(payload []byte) {
 		return
 	}
 
-	err = client.ctl.ds.UnMapExternalIP(failure.PublicIP)
-	if err != nil {
-		glog.Warningf("Error unmapping external IP: %v", err)
-	}
+	msg := fmt.Sprintf("Failed to map %s to %s: %s", failure.PublicIP, failure.InstanceUUID, failure.Reason.String())
 
-	client.ctl.qs.Release(failure.TenantUUID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+	// a failed remap keeps the mapping so that it is retried.
+	if !client.ctl.remapAssigned(failure.PublicIP, true) {
+		err = client.ctl.ds.UnMapExternalIP(failure.PublicIP)
+		if err != nil {
+			glog.Warningf("Error unmapping external IP: %v", err)
+		}
 
-	msg := fmt.Sprintf("Failed to map %s to %s: %s", failure.PublicIP, failure.InstanceUUID, failure.Reason.String())
-	err = client.ctl.ds.LogError(failure.TenantUUID, msg)
+		client.ctl.qs.Release(failure.TenantUUID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+	}
+
+	err = client.ctl.ds.LogEvent(failure.TenantUUID, types.EventError, types.EventCategoryNetwork, msg)
 	if err != nil {
 		glog.Warningf("Error logging error: %v", err)
 	}
@@ -444,9 +629,12 @@ func (client *ssntpClient) unassignError(payload []byte) {
 		return
 	}
 
-	// we can't unmap the IP - all we can do is log.
+	// we can't unmap the IP - all we can do is log. When remapping
+	// the address is assigned again in case the CNCI lost the mapping.
+	client.ctl.remapUnassigned(failure.PublicIP)
+
 	msg := fmt.Sprintf("Failed to unmap %s from %s: %s", failure.PublicIP, failure.InstanceUUID, failure.Reason.String())
-	err = client.ctl.ds.LogError(failure.TenantUUID, msg)
+	err = client.ctl.ds.LogEvent(failure.TenantUUID, types.EventError, types.EventCategoryNetwork, msg)
 	if err != nil {
 		glog.Warningf("Error logging error: %v", err)
 	}
@@ -456,15 +644,34 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 	payload := frame.Payload
 
 	glog.Info("ERROR (", err, ") for ", client.name)
+
+	client.ctl.capture.record(captureError, uint8(err), err.String(), frame)
+
+	if !client.ctl.frames.accept(frame) {
+		glog.Warningf("Dropping duplicate %s frame %d from %s", err, frame.Sequence, frame.Origin)
+		return
+	}
+
+	if client.ctl.faults.dropFrame() {
+		glog.Warningf("Injected fault: dropping %s frame %d from %s", err, frame.Sequence, frame.Origin)
+		return
+	}
+
 	glog.V(1).Info(string(payload))
 
 	switch err {
 	case ssntp.StartFailure:
 		client.startFailure(payload)
 
+	case ssntp.DeleteFailure:
+		client.deleteFailure(payload)
+
 	case ssntp.AttachVolumeFailure:
 		client.attachVolumeFailure(payload)
 
+	case ssntp.HotAddFailure:
+		client.hotAddFailure(payload)
+
 	case ssntp.AssignPublicIPFailure:
 		client.assignError(payload)
 
@@ -474,10 +681,10 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 	}
 }
 
-func newSSNTPClient(ctl *controller, config *ssntp.Config) (controllerClient, error) {
-	client := &ssntpClient{name: "ciao Controller", ctl: ctl}
+func newControllerClient(ctl *controller, t transport) (controllerClient, error) {
+	client := &ssntpClient{name: "ciao Controller", ctl: ctl, transport: t}
 
-	err := client.ssntp.Dial(config, client)
+	err := t.Dial(client)
 	return client, err
 }
 
@@ -491,7 +698,7 @@ func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Tim
 		Label:     []byte(label),
 	}
 
-	_, err := client.ssntp.SendTracedCommand(ssntp.START, []byte(config), traceConfig)
+	err := client.transport.SendTracedCommand(ssntp.START, []byte(config), traceConfig)
 
 	return err
 }
@@ -500,7 +707,7 @@ func (client *ssntpClient) StartWorkload(config string) error {
 	glog.V(1).Info("START config:")
 	glog.V(1).Info(config)
 
-	_, err := client.ssntp.SendCommand(ssntp.START, []byte(config))
+	err := client.transport.SendCommand(ssntp.START, []byte(config))
 
 	return err
 }
@@ -514,7 +721,7 @@ func (client *ssntpClient) deleteInstance(payload *payloads.Delete, instanceID s
 	glog.Info("DELETE instance_id: ", instanceID, "node_id ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.DELETE, y)
+	err = client.transport.SendCommand(ssntp.DELETE, y)
 
 	return err
 }
@@ -551,8 +758,70 @@ func (client *ssntpClient) StopInstance(instanceID string, nodeID string) error
 	return client.deleteInstance(&payload, instanceID, nodeID)
 }
 
+// sendTracked sends a command for an instance with a new identifier and
+// waits for the agent to report the command completed, or for ctx to be
+// done.
+func (client *ssntpClient) sendTracked(ctx context.Context, instanceID string, send func(commandID string) error) error {
+	commandID, done := client.acks.add(instanceID)
+
+	err := send(commandID)
+	if err != nil {
+		client.acks.cancel(commandID)
+		return err
+	}
+
+	return client.acks.wait(ctx, commandID, done)
+}
+
+// sendAndWait sends a DELETE command for an instance and waits for the
+// agent to report the command completed, or for ctx to be done.
+func (client *ssntpClient) sendAndWait(ctx context.Context, instanceID string, nodeID string, stop bool) error {
+	return client.sendTracked(ctx, instanceID, func(commandID string) error {
+		payload := payloads.Delete{
+			Delete: payloads.StopCmd{
+				InstanceUUID:      instanceID,
+				WorkloadAgentUUID: nodeID,
+				Stop:              stop,
+				CommandID:         commandID,
+			},
+		}
+
+		return client.deleteInstance(&payload, instanceID, nodeID)
+	})
+}
+
+// DeleteInstanceAndWait deletes an instance and waits for its node to
+// report it deleted, or for ctx to be done.
+func (client *ssntpClient) DeleteInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error {
+	if nodeID == "" {
+		return client.DeleteInstance(instanceID, nodeID)
+	}
+
+	return client.sendAndWait(ctx, instanceID, nodeID, false)
+}
+
+// StopInstanceAndWait stops an instance and waits for its node to report
+// it stopped, or for ctx to be done.
+func (client *ssntpClient) StopInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error {
+	return client.sendAndWait(ctx, instanceID, nodeID, true)
+}
+
 func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 	t *types.Tenant) error {
+	return client.restartInstance(i, w, t, uuid.Generate().String())
+}
+
+// RestartInstanceAndWait restarts an instance and waits for its node to
+// report it started, or for ctx to be done.
+func (client *ssntpClient) RestartInstanceAndWait(ctx context.Context, i *types.Instance,
+	w *types.Workload, t *types.Tenant) error {
+	return client.sendTracked(ctx, i.ID, func(commandID string) error {
+		return client.restartInstance(i, w, t, commandID)
+	})
+}
+
+func (client *ssntpClient) restartInstance(i *types.Instance, w *types.Workload,
+	t *types.Tenant, commandID string) error {
 	var cnci *types.Instance
 
 	err := client.ctl.ds.InstanceRestarting(i.ID)
@@ -591,27 +860,46 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 			VnicMAC:  i.MACAddress,
 			VnicUUID: i.VnicUUID,
 		},
-		Storage: make([]payloads.StorageResource, len(attachments)),
-		Restart: true,
+		Storage:   make([]payloads.StorageResource, len(attachments)),
+		Restart:   true,
+		CommandID: commandID,
 	}
 
+	// instances restart with the resources hot added to them.
+	restartCmd.Requirements.VCPUs += i.ExtraVCPUs
+	restartCmd.Requirements.MemMB += i.ExtraMemMB
+
 	if cnci != nil {
 		restartCmd.Networking.ConcentratorUUID = cnci.ID
 		restartCmd.Networking.ConcentratorIP = cnci.IPAddress
 		restartCmd.Networking.Subnet = i.Subnet
 		restartCmd.Networking.PrivateIP = i.IPAddress
+
+		vni, err := client.ctl.ds.AllocateSubnetVNI(i.TenantID, i.Subnet)
+		if err != nil {
+			return err
+		}
+		restartCmd.Networking.SubnetKey = strconv.FormatUint(uint64(vni), 10)
 	}
 
 	if w.VMType == payloads.Docker {
 		restartCmd.DockerImage = w.ImageName
 	}
 
+	if !i.CNCI {
+		restartCmd.HealthCheck = w.HealthCheck
+	}
+
 	for k := range attachments {
 		vol := &restartCmd.Storage[k]
 		vol.ID = attachments[k].BlockID
 		vol.Bootable = attachments[k].Boot
 		vol.Ephemeral = attachments[k].Ephemeral
+		if bd, err := client.ctl.ds.GetBlockDevice(vol.ID); err == nil {
+			vol.Pool = client.ctl.cephPool(bd.Pool)
+		}
 	}
+	restartCmd.Requirements.StoragePools = client.ctl.volumeStoragePools(restartCmd.Storage)
 
 	payload := payloads.Start{
 		Start: restartCmd,
@@ -639,14 +927,15 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 	glog.Info("RESTART instance: ", i.ID)
 	glog.V(1).Info(buf.String())
 
-	_, err = client.ssntp.SendCommand(ssntp.START, buf.Bytes())
+	err = client.transport.SendCommand(ssntp.START, buf.Bytes())
 
 	return err
 }
 
-func (client *ssntpClient) EvacuateNode(nodeID string) error {
+func (client *ssntpClient) EvacuateNode(nodeID string, commandID string) error {
 	evacuateCmd := payloads.EvacuateCmd{
 		WorkloadAgentUUID: nodeID,
+		CommandID:         commandID,
 	}
 
 	payload := payloads.Evacuate{
@@ -661,14 +950,15 @@ func (client *ssntpClient) EvacuateNode(nodeID string) error {
 	glog.Info("EVACUATE node: ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.EVACUATE, y)
+	err = client.transport.SendCommand(ssntp.EVACUATE, y)
 
 	return err
 }
 
-func (client *ssntpClient) RestoreNode(nodeID string) error {
+func (client *ssntpClient) RestoreNode(nodeID string, commandID string) error {
 	restoreCmd := payloads.RestoreCmd{
 		WorkloadAgentUUID: nodeID,
+		CommandID:         commandID,
 	}
 
 	payload := payloads.Restore{
@@ -683,20 +973,62 @@ func (client *ssntpClient) RestoreNode(nodeID string) error {
 	glog.Info("Restore node: ", nodeID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.Restore, y)
+	err = client.transport.SendCommand(ssntp.Restore, y)
 
 	return err
 }
 
+func (client *ssntpClient) cacheImage(nodeID string, image string) error {
+	payload := payloads.CacheImage{
+		CacheImage: payloads.CacheImageCmd{
+			WorkloadAgentUUID: nodeID,
+			Image:             image,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.V(1).Info(string(y))
+
+	err = client.transport.SendCommand(ssntp.CacheImage, y)
+
+	return err
+}
+
+func (client *ssntpClient) setNetworkPolicy(t types.Tenant, cnci *types.Instance) error {
+	payload := payloads.CommandNetworkPolicy{
+		Policy: networkPolicyCommand(t, cnci),
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Request network policy %s of tenant %s\n", t.NetworkPolicy.Mode, t.ID)
+	glog.V(1).Info(string(y))
+
+	err = client.transport.SendCommand(ssntp.NetworkPolicy, y)
+	return err
+}
+
 func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID string) error {
 	payload := payloads.AttachVolume{
 		Attach: payloads.VolumeCmd{
 			InstanceUUID:      instanceID,
 			VolumeUUID:        volID,
 			WorkloadAgentUUID: nodeID,
+			CommandID:         uuid.Generate().String(),
 		},
 	}
 
+	if bd, err := client.ctl.ds.GetBlockDevice(volID); err == nil {
+		payload.Attach.Pool = client.ctl.cephPool(bd.Pool)
+	}
+
 	y, err := yaml.Marshal(payload)
 	if err != nil {
 		return err
@@ -705,17 +1037,100 @@ func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID
 	glog.Infof("AttachVolume %s to %s\n", volID, instanceID)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.AttachVolume, y)
+	err = client.transport.SendCommand(ssntp.AttachVolume, y)
+
+	return err
+}
+
+func (client *ssntpClient) hotAdd(instanceID string, nodeID string, vcpus int, memMB int) error {
+	payload := payloads.HotAdd{
+		HotAdd: payloads.HotAddCmd{
+			InstanceUUID:      instanceID,
+			WorkloadAgentUUID: nodeID,
+			VCPUs:             vcpus,
+			MemMB:             memMB,
+			CommandID:         uuid.Generate().String(),
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("HotAdd %d vCPUs and %d MB to %s\n", vcpus, memMB, instanceID)
+	glog.V(1).Info(string(y))
+
+	err = client.transport.SendCommand(ssntp.HotAdd, y)
+
+	return err
+}
+
+func (client *ssntpClient) restartAgent(nodeID string, commandID string) error {
+	payload := payloads.RestartAgent{
+		RestartAgent: payloads.NodeCommandCmd{
+			CommandID:         commandID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("Restart agent of node: ", nodeID)
+	glog.V(1).Info(string(y))
+
+	err = client.transport.SendCommand(ssntp.RestartAgent, y)
 
 	return err
 }
 
-func (client *ssntpClient) ssntpClient() *ssntp.Client {
-	return &client.ssntp
+func (client *ssntpClient) selfTest(nodeID string, commandID string) error {
+	payload := payloads.SelfTest{
+		SelfTest: payloads.NodeCommandCmd{
+			CommandID:         commandID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("Self test node: ", nodeID)
+	glog.V(1).Info(string(y))
+
+	err = client.transport.SendCommand(ssntp.SelfTest, y)
+
+	return err
+}
+
+func (client *ssntpClient) reconfigureNode(nodeID string, commandID string) error {
+	payload := payloads.Reconfigure{
+		Reconfigure: payloads.NodeCommandCmd{
+			CommandID:         commandID,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Info("Reconfigure node: ", nodeID)
+	glog.V(1).Info(string(y))
+
+	err = client.transport.SendCommand(ssntp.Reconfigure, y)
+
+	return err
 }
 
 func (client *ssntpClient) Disconnect() {
-	client.ssntp.Close()
+	client.transport.Close()
 }
 
 func (client *ssntpClient) mapExternalIP(t types.Tenant, m types.MappedIP) error {
@@ -744,7 +1159,7 @@ func (client *ssntpClient) mapExternalIP(t types.Tenant, m types.MappedIP) error
 	glog.Infof("Request Map of %s to %s\n", m.ExternalIP, m.InternalIP)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.AssignPublicIP, y)
+	err = client.transport.SendCommand(ssntp.AssignPublicIP, y)
 	return err
 }
 
@@ -774,6 +1189,6 @@ func (client *ssntpClient) unMapExternalIP(t types.Tenant, m types.MappedIP) err
 	glog.Infof("Request unmap of %s from %s\n", m.ExternalIP, m.InternalIP)
 	glog.V(1).Info(string(y))
 
-	_, err = client.ssntp.SendCommand(ssntp.ReleasePublicIP, y)
+	err = client.transport.SendCommand(ssntp.ReleasePublicIP, y)
 	return err
 }
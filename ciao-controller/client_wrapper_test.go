@@ -17,6 +17,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -35,6 +36,13 @@ type ssntpClientWrapper struct {
 	EventChansLock sync.Mutex
 	ErrorChans     map[ssntp.Error]chan struct{}
 	ErrorChansLock sync.Mutex
+	AckChans       []*ackChan
+	AckChansLock   sync.Mutex
+}
+
+type ackChan struct {
+	left int
+	c    chan struct{}
 }
 
 func (client *ssntpClientWrapper) ConnectNotify() {
@@ -57,6 +65,9 @@ func (client *ssntpClientWrapper) CommandNotify(command ssntp.Command, frame *ss
 func (client *ssntpClientWrapper) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 	client.realClient.EventNotify(event, frame)
 	client.sendAndDelEventChan(event)
+	if event == ssntp.NodeCommandResult {
+		client.countAck()
+	}
 }
 
 func (client *ssntpClientWrapper) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
@@ -64,13 +75,12 @@ func (client *ssntpClientWrapper) ErrorNotify(err ssntp.Error, frame *ssntp.Fram
 	client.sendAndDelErrorChan(err)
 }
 
-func newWrappedSSNTPClient(ctl *controller, config *ssntp.Config) (*ssntpClientWrapper, error) {
-	realClient := &ssntpClient{name: "ciao Controller", ctl: ctl}
+func newWrappedSSNTPClient(ctl *controller, t transport) (*ssntpClientWrapper, error) {
+	realClient := &ssntpClient{name: "ciao Controller", ctl: ctl, transport: t}
 	client := &ssntpClientWrapper{name: "ciao Controller", realClient: realClient}
 	client.openClientChans()
 
-	ssntp := client.realClient.ssntpClient()
-	err := ssntp.Dial(config, client)
+	err := t.Dial(client)
 	return client, err
 }
 
@@ -90,17 +100,38 @@ func (client *ssntpClientWrapper) StopInstance(instanceID string, nodeID string)
 	return client.realClient.StopInstance(instanceID, nodeID)
 }
 
+func (client *ssntpClientWrapper) DeleteInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error {
+	return client.realClient.DeleteInstanceAndWait(ctx, instanceID, nodeID)
+}
+
+func (client *ssntpClientWrapper) StopInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error {
+	return client.realClient.StopInstanceAndWait(ctx, instanceID, nodeID)
+}
+
 func (client *ssntpClientWrapper) RestartInstance(i *types.Instance, w *types.Workload,
 	t *types.Tenant) error {
 	return client.realClient.RestartInstance(i, w, t)
 }
 
-func (client *ssntpClientWrapper) EvacuateNode(nodeID string) error {
-	return client.realClient.EvacuateNode(nodeID)
+func (client *ssntpClientWrapper) RestartInstanceAndWait(ctx context.Context, i *types.Instance,
+	w *types.Workload, t *types.Tenant) error {
+	return client.realClient.RestartInstanceAndWait(ctx, i, w, t)
+}
+
+func (client *ssntpClientWrapper) EvacuateNode(nodeID string, commandID string) error {
+	return client.realClient.EvacuateNode(nodeID, commandID)
 }
 
-func (client *ssntpClientWrapper) RestoreNode(nodeID string) error {
-	return client.realClient.RestoreNode(nodeID)
+func (client *ssntpClientWrapper) RestoreNode(nodeID string, commandID string) error {
+	return client.realClient.RestoreNode(nodeID, commandID)
+}
+
+func (client *ssntpClientWrapper) cacheImage(nodeID string, image string) error {
+	return client.realClient.cacheImage(nodeID, image)
+}
+
+func (client *ssntpClientWrapper) setNetworkPolicy(t types.Tenant, cnci *types.Instance) error {
+	return client.realClient.setNetworkPolicy(t, cnci)
 }
 
 func (client *ssntpClientWrapper) mapExternalIP(t types.Tenant, m types.MappedIP) error {
@@ -115,8 +146,20 @@ func (client *ssntpClientWrapper) attachVolume(volID string, instanceID string,
 	return client.realClient.attachVolume(volID, instanceID, nodeID)
 }
 
-func (client *ssntpClientWrapper) ssntpClient() *ssntp.Client {
-	return client.realClient.ssntpClient()
+func (client *ssntpClientWrapper) hotAdd(instanceID string, nodeID string, vcpus int, memMB int) error {
+	return client.realClient.hotAdd(instanceID, nodeID, vcpus, memMB)
+}
+
+func (client *ssntpClientWrapper) restartAgent(nodeID string, commandID string) error {
+	return client.realClient.restartAgent(nodeID, commandID)
+}
+
+func (client *ssntpClientWrapper) selfTest(nodeID string, commandID string) error {
+	return client.realClient.selfTest(nodeID, commandID)
+}
+
+func (client *ssntpClientWrapper) reconfigureNode(nodeID string, commandID string) error {
+	return client.realClient.reconfigureNode(nodeID, commandID)
 }
 
 func (client *ssntpClientWrapper) Disconnect() {
@@ -159,6 +202,53 @@ func (client *ssntpClientWrapper) closeClientChans() {
 		delete(client.ErrorChans, k)
 	}
 	client.ErrorChansLock.Unlock()
+
+	client.AckChansLock.Lock()
+	for _, a := range client.AckChans {
+		close(a.c)
+	}
+	client.AckChans = nil
+	client.AckChansLock.Unlock()
+}
+
+// addAckChan monitors for n commands to be acknowledged by the agents,
+// once the controller processed the acknowledgements.
+func (client *ssntpClientWrapper) addAckChan(n int) chan struct{} {
+	a := &ackChan{left: n, c: make(chan struct{}, 1)}
+
+	client.AckChansLock.Lock()
+	client.AckChans = append(client.AckChans, a)
+	client.AckChansLock.Unlock()
+
+	return a.c
+}
+
+// getAckChan waits for the commands monitored by the supplied channel to be
+// acknowledged.
+func (client *ssntpClientWrapper) getAckChan(c chan struct{}) error {
+	select {
+	case <-c:
+		return nil
+	case <-time.After(25 * time.Second):
+		return fmt.Errorf("Timeout waiting for client acknowledgements")
+	}
+}
+
+func (client *ssntpClientWrapper) countAck() {
+	client.AckChansLock.Lock()
+	defer client.AckChansLock.Unlock()
+
+	acks := client.AckChans[:0]
+	for _, a := range client.AckChans {
+		a.left--
+		if a.left > 0 {
+			acks = append(acks, a)
+			continue
+		}
+		a.c <- struct{}{}
+		close(a.c)
+	}
+	client.AckChans = acks
 }
 
 // addCmdChan monitors for a ssntp.Command to be received.
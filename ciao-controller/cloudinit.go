@@ -0,0 +1,101 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// tenantMTU is the MTU of the tenant networks, which accounts for the
+// tunnel overhead.  It must match the MTU advertised by the CNCI DHCP
+// server.
+const tenantMTU = 1400
+
+// instanceInterface is the name given to the tenant network interface of
+// an instance.
+const instanceInterface = "eth0"
+
+type netConfigMatch struct {
+	MACAddress string `yaml:"macaddress"`
+}
+
+type netConfigNameservers struct {
+	Addresses []string `yaml:"addresses"`
+}
+
+type netConfigEthernet struct {
+	Match       netConfigMatch       `yaml:"match"`
+	SetName     string               `yaml:"set-name"`
+	Addresses   []string             `yaml:"addresses"`
+	Gateway4    string               `yaml:"gateway4"`
+	Nameservers netConfigNameservers `yaml:"nameservers"`
+	MTU         int                  `yaml:"mtu"`
+}
+
+// networkConfigV2 is a cloud-init network configuration, version 2.
+type networkConfigV2 struct {
+	Version   int                          `yaml:"version"`
+	Ethernets map[string]netConfigEthernet `yaml:"ethernets"`
+}
+
+// subnetGateway returns the gateway of a tenant subnet, which is its first
+// address.  The CNCI of the subnet serves both the gateway and DNS on it.
+func subnetGateway(subnet *net.IPNet) net.IP {
+	gw := make(net.IP, net.IPv4len)
+	copy(gw, subnet.IP.To4().Mask(subnet.Mask))
+	gw[3]++
+	return gw
+}
+
+// cloudInitNetworkConfig returns a cloud-init network configuration which
+// statically assigns the instance the address allocated to it, so that it
+// does not depend on the DHCP server of its CNCI.
+func cloudInitNetworkConfig(networking *payloads.NetworkResources) (string, error) {
+	ip := net.ParseIP(networking.PrivateIP)
+	_, subnet, err := net.ParseCIDR(networking.Subnet)
+	if ip == nil || ip.To4() == nil || err != nil {
+		return "", fmt.Errorf("invalid instance address %s in subnet %s",
+			networking.PrivateIP, networking.Subnet)
+	}
+
+	prefix, _ := subnet.Mask.Size()
+	gw := subnetGateway(subnet).String()
+
+	config := networkConfigV2{
+		Version: 2,
+		Ethernets: map[string]netConfigEthernet{
+			instanceInterface: {
+				Match:       netConfigMatch{MACAddress: networking.VnicMAC},
+				SetName:     instanceInterface,
+				Addresses:   []string{fmt.Sprintf("%s/%d", ip, prefix)},
+				Gateway4:    gw,
+				Nameservers: netConfigNameservers{Addresses: []string{gw}},
+				MTU:         tenantMTU,
+			},
+		},
+	}
+
+	b, err := yaml.Marshal(&config)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling network config")
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ciao-project/ciao/payloads"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCloudInitNetworkConfig(t *testing.T) {
+	networking := payloads.NetworkResources{
+		VnicMAC:   "02:00:ac:10:00:05",
+		PrivateIP: "172.16.0.5",
+		Subnet:    "172.16.0.0/24",
+	}
+
+	s, err := cloudInitNetworkConfig(&networking)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config networkConfigV2
+	err = yaml.Unmarshal([]byte(s), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := networkConfigV2{
+		Version: 2,
+		Ethernets: map[string]netConfigEthernet{
+			"eth0": {
+				Match:       netConfigMatch{MACAddress: "02:00:ac:10:00:05"},
+				SetName:     "eth0",
+				Addresses:   []string{"172.16.0.5/24"},
+				Gateway4:    "172.16.0.1",
+				Nameservers: netConfigNameservers{Addresses: []string{"172.16.0.1"}},
+				MTU:         tenantMTU,
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("Expected network config %+v, got %+v", expected, config)
+	}
+
+	networking.PrivateIP = ""
+	_, err = cloudInitNetworkConfig(&networking)
+	if err == nil {
+		t.Fatal("Network config generated without an address")
+	}
+}
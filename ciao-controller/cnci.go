@@ -18,6 +18,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -69,6 +70,10 @@ type CNCIManager struct {
 
 	// this is a map of subnet strings to CNCI structs
 	subnets map[string]*CNCI
+
+	// this is a map of subnet strings to the reason why
+	// their CNCI could not be activated.
+	failures map[string]string
 }
 
 func (c *CNCI) stop() error {
@@ -204,10 +209,13 @@ func (c *CNCIManager) WaitForActive(subnet string) error {
 	}()
 
 	c.subnets[subnet] = cnci
+	delete(c.failures, subnet)
 
 	// send a launch command
 	instance, err := c.launch(subnet)
 	if err != nil {
+		delete(c.subnets, subnet)
+		c.failures[subnet] = err.Error()
 		c.cnciLock.Unlock()
 		return err
 	}
@@ -223,7 +231,16 @@ func (c *CNCIManager) WaitForActive(subnet string) error {
 
 	// we release the lock before waiting because
 	// we need to be able to read the event channel.
-	return waitForEventTimeout(ch, added, cnciEventTimeout)
+	err = waitForEventTimeout(ch, added, cnciEventTimeout)
+	if err != nil {
+		c.cnciLock.Lock()
+		if _, ok := c.failures[subnet]; !ok {
+			c.failures[subnet] = err.Error()
+		}
+		c.cnciLock.Unlock()
+	}
+
+	return err
 }
 
 // ScheduleRemoveSubnet will kick off a timer to remove a subnet after 5 min.
@@ -277,7 +294,12 @@ func (c *CNCIManager) RemoveSubnet(subnet string) error {
 
 	delete(c.subnets, subnet)
 
-	err := cnci.stop()
+	err := c.ctrl.ds.ReleaseSubnetVNI(c.tenant, subnet)
+	if err != nil {
+		glog.Warningf("Unable to release VNI of subnet %s: %v", subnet, err)
+	}
+
+	err = cnci.stop()
 	if err != nil {
 		c.cnciLock.Unlock()
 		return err
@@ -344,13 +366,14 @@ func (c *CNCIManager) CNCIAdded(id string) error {
 	}
 
 	cnci.transitionState(active)
+	delete(c.failures, cnci.subnet)
 
 	return nil
 }
 
 // StartFailure will move the CNCI to the error state and
 // send an event through the event channel.
-func (c *CNCIManager) StartFailure(id string) error {
+func (c *CNCIManager) StartFailure(id string, reason string) error {
 	c.cnciLock.Lock()
 	defer c.cnciLock.Unlock()
 
@@ -361,6 +384,7 @@ func (c *CNCIManager) StartFailure(id string) error {
 
 	delete(c.cncis, id)
 	delete(c.subnets, cnci.subnet)
+	c.failures[cnci.subnet] = reason
 
 	cnci.transitionState(failed)
 
@@ -452,6 +476,55 @@ func (c *CNCIManager) getInstanceCount(subnet string) (int, error) {
 	return count, nil
 }
 
+// Subnets returns the CNCI activation status of the tenant subnets,
+// sorted by subnet.
+func (c *CNCIManager) Subnets() []types.TenantSubnet {
+	c.cnciLock.RLock()
+	defer c.cnciLock.RUnlock()
+
+	subnets := []types.TenantSubnet{}
+
+	for subnet, cnci := range c.subnets {
+		s := types.TenantSubnet{
+			Subnet: subnet,
+			State:  types.SubnetPending,
+		}
+
+		if cnci.instance != nil {
+			s.CNCIID = cnci.instance.ID
+
+			if instanceActive(cnci.instance) {
+				s.State = types.SubnetActive
+			}
+		}
+
+		if reason, ok := c.failures[subnet]; ok && s.State != types.SubnetActive {
+			s.State = types.SubnetFailed
+			s.Reason = reason
+		}
+
+		subnets = append(subnets, s)
+	}
+
+	for subnet, reason := range c.failures {
+		if _, ok := c.subnets[subnet]; ok {
+			continue
+		}
+
+		subnets = append(subnets, types.TenantSubnet{
+			Subnet: subnet,
+			State:  types.SubnetFailed,
+			Reason: reason,
+		})
+	}
+
+	sort.Slice(subnets, func(i, j int) bool {
+		return subnets[i].Subnet < subnets[j].Subnet
+	})
+
+	return subnets
+}
+
 // Shutdown cleans up a CNCIManager in anticipation of a shutdown.
 func (c *CNCIManager) Shutdown() {
 	// the only thing we need to do right now at shutdown time
@@ -472,8 +545,9 @@ func newCNCIManager(ctrl *controller, tenant string) (*CNCIManager, error) {
 		tenant: tenant,
 		ctrl:   ctrl,
 
-		cncis:   make(map[string]*CNCI),
-		subnets: make(map[string]*CNCI),
+		cncis:    make(map[string]*CNCI),
+		subnets:  make(map[string]*CNCI),
+		failures: make(map[string]string),
 	}
 
 	instances, err := ctrl.ds.GetTenantCNCIs(tenant)
@@ -17,6 +17,7 @@ package main
 import (
 	"testing"
 
+	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/ssntp"
 )
 
@@ -67,6 +68,15 @@ func TestCNCILaunch(t *testing.T) {
 	if !tenant.CNCIctrl.Active(instances[0].ID) {
 		t.Fatal(err)
 	}
+
+	subnets := tenant.CNCIctrl.Subnets()
+	if len(subnets) != 1 {
+		t.Fatalf("Expected 1 subnet, got %d", len(subnets))
+	}
+
+	if subnets[0].State != types.SubnetActive || subnets[0].CNCIID != instances[0].ID {
+		t.Fatalf("Unexpected subnet status %v", subnets[0])
+	}
 }
 
 func TestCNCIRemoved(t *testing.T) {
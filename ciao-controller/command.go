@@ -17,67 +17,124 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"net"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
 
 func (c *controller) restartInstance(instanceID string) error {
+	return c.restartInstanceOnNode(instanceID, "")
+}
+
+// restartInstanceOnNode restarts an exited instance, on the given node if
+// nodeID is not empty.
+func (c *controller) restartInstanceOnNode(instanceID string, nodeID string) error {
+	i, w, t, err := c.restartableInstance(instanceID, nodeID)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := c.client.RestartInstance(i, w, t); err != nil {
+			glog.Warningf("Error restarting instance: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// restart an instance, wait for its node to report it started.
+func (c *controller) restartInstanceSync(instanceID string) error {
+	i, w, t, err := c.restartableInstance(instanceID, "")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	err = c.client.RestartInstanceAndWait(ctx, i, w, t)
+	if err != nil {
+		return errors.Wrapf(err, "Error waiting for instance %s to restart", instanceID)
+	}
+
+	return nil
+}
+
+// restartableInstance returns the instance if it can be restarted, along
+// with its workload, targeted at the given node if nodeID is not empty,
+// and its tenant.
+func (c *controller) restartableInstance(instanceID string, nodeID string) (*types.Instance, *types.Workload, *types.Tenant, error) {
 	// should I bother to see if instanceID is valid?
 	i, err := c.ds.GetInstance(instanceID)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	if i.State != "exited" {
-		return errors.New("You may only restart paused instances")
+		return nil, nil, nil, errors.New("You may only restart paused instances")
 	}
 
 	w, err := c.ds.GetWorkload(i.WorkloadID)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
+	}
+
+	if nodeID != "" {
+		w.Requirements.NodeID = nodeID
 	}
 
 	t, err := c.ds.GetTenant(i.TenantID)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	if !i.CNCI {
 		err = t.CNCIctrl.WaitForActive(i.Subnet)
 		if err != nil {
-			return errors.Wrap(err, "Error waiting for active subnet")
+			return nil, nil, nil, errors.Wrap(err, "Error waiting for active subnet")
 		}
 	}
 
-	go func() {
-		if err := c.client.RestartInstance(i, &w, t); err != nil {
-			glog.Warningf("Error restarting instance: %v", err)
-		}
-	}()
+	if !i.CNCI {
+		c.trackBoot(i.ID)
+	}
 
-	return nil
+	return i, &w, t, nil
 }
 
-func (c *controller) stopInstance(instanceID string) error {
+// stoppableInstance returns the instance if it can be stopped.
+func (c *controller) stoppableInstance(instanceID string) (*types.Instance, error) {
 	// get node id.  If there is no node id we can't send a delete
 	i, err := c.ds.GetInstance(instanceID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if i.NodeID == "" {
-		return types.ErrInstanceNotAssigned
+		return nil, types.ErrInstanceNotAssigned
 	}
 
 	if i.State == payloads.ComputeStatusPending {
-		return errors.New("You may not stop a pending instance")
+		return nil, errors.New("You may not stop a pending instance")
+	}
+
+	return i, nil
+}
+
+func (c *controller) stopInstance(instanceID string) error {
+	i, err := c.stoppableInstance(instanceID)
+	if err != nil {
+		return err
 	}
 
 	go func() {
@@ -89,73 +146,89 @@ func (c *controller) stopInstance(instanceID string) error {
 	return nil
 }
 
-// delete an instance, wait for the deleted event.
-func (c *controller) deleteInstanceSync(instanceID string) error {
-	wait := make(chan struct{})
-
-	i, err := c.ds.GetInstance(instanceID)
+// stop an instance, wait for its node to report it stopped.
+func (c *controller) stopInstanceSync(instanceID string) error {
+	i, err := c.stoppableInstance(instanceID)
 	if err != nil {
 		return err
 	}
 
-	err = c.deleteInstance(instanceID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	err = c.client.StopInstanceAndWait(ctx, instanceID, i.NodeID)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "Error waiting for instance %s to stop", instanceID)
 	}
 
-	go func() {
-		i.StateChange.L.Lock()
-		for {
-			i.StateLock.RLock()
-			if i.State == payloads.Deleted || i.State == payloads.Hung {
-				break
-			}
-			glog.V(2).Infof("waiting for %s to be deleted", i.ID)
-			i.StateLock.RUnlock()
-			i.StateChange.Wait()
-		}
+	return nil
+}
 
-		i.StateLock.RUnlock()
-		i.StateChange.L.Unlock()
+// delete an instance, wait for its node to report it deleted.
+func (c *controller) deleteInstanceSync(instanceID string) error {
+	i, err := c.deletableInstance(instanceID)
+	if err != nil {
+		return err
+	}
 
-		glog.V(2).Infof("%s is hung or deleted", i.ID)
-		close(wait)
-	}()
+	err = c.UnMapInstanceAddresses(&i.TenantID, instanceID)
+	if err != nil {
+		return err
+	}
 
-	select {
-	case <-wait:
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	err = c.client.DeleteInstanceAndWait(ctx, instanceID, i.NodeID)
+	if err == nil {
 		return nil
-	case <-time.After(2 * time.Minute):
-		err = i.TransitionInstanceState(payloads.Hung)
-		if err != nil {
-			glog.Warningf("Error transitioning instance to hung state: %v", err)
+	}
+
+	// a node reporting a failure is still responsive, the instance is
+	// only hung if the node never answered.
+	if errors.Cause(err) == context.DeadlineExceeded {
+		if herr := c.ds.InstanceHung(instanceID); herr != nil {
+			glog.Warningf("Error marking instance %s as hung: %v", instanceID, herr)
 		}
-		return fmt.Errorf("timeout waiting for delete")
 	}
+
+	return errors.Wrapf(err, "Error waiting for instance %s to be deleted", instanceID)
 }
 
-func (c *controller) deleteInstance(instanceID string) error {
+// deletableInstance returns the instance if it can be deleted.
+func (c *controller) deletableInstance(instanceID string) (*types.Instance, error) {
 	// get node id.  If there is no node id and the instance is
 	// pending we can't send a delete
 	i, err := c.ds.GetInstance(instanceID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if i.NodeID == "" && i.State == payloads.Pending {
-		return types.ErrInstanceNotAssigned
+		return nil, types.ErrInstanceNotAssigned
 	}
 
 	if i.State == payloads.Missing {
-		return types.ErrInstanceNotAssigned
+		return nil, types.ErrInstanceNotAssigned
 	}
 
-	// check for any external IPs
-	IPs := c.ds.GetMappedIPs(&i.TenantID)
-	for _, m := range IPs {
-		if m.InstanceID == instanceID {
-			return types.ErrInstanceMapped
-		}
+	if i.Protected {
+		return nil, types.ErrInstanceProtected
+	}
+
+	return i, nil
+}
+
+func (c *controller) deleteInstance(instanceID string) error {
+	i, err := c.deletableInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	// the CNCI needs the instance to release its external IPs
+	err = c.UnMapInstanceAddresses(&i.TenantID, instanceID)
+	if err != nil {
+		return err
 	}
 
 	go func() {
@@ -232,16 +305,44 @@ func (c *controller) confirmTenant(tenantID string) error {
 	return err
 }
 
-func (c *controller) createInstance(w types.WorkloadRequest, wl types.Workload, name string, newIP net.IP) (*types.Instance, error) {
+func (c *controller) createInstance(w types.WorkloadRequest, wl types.Workload, id string, name string, newIP net.IP, batch *launchBatch) (*types.Instance, error) {
+	// CNCIs are not paced, as the instances of their tenant wait for
+	// them to be active before being dispatched.
+	if w.Subnet == "" {
+		if err := c.dispatcher.acquire(batch.cancelled); err != nil {
+			return nil, err
+		}
+		defer c.dispatcher.release()
+	}
+
+	instance, err := c.prepareInstance(w, wl, id, name, newIP)
+	if err != nil {
+		return nil, err
+	}
+
+	err = instance.Add()
+	if err != nil {
+		_ = instance.Clean()
+		return nil, errors.Wrap(err, "Error adding instance")
+	}
+
+	return c.launchInstance(w, wl, instance, batch)
+}
+
+// prepareInstance creates a new instance and checks it is within the quota
+// of its tenant, without adding it to the datastore.
+func (c *controller) prepareInstance(w types.WorkloadRequest, wl types.Workload, id string, name string, newIP net.IP) (*instance, error) {
 	startTime := time.Now()
 
-	instance, err := newInstance(c, w.TenantID, &wl, name, w.Subnet, newIP)
+	instance, err := newInstance(c, id, w.TenantID, &wl, name, w.Subnet, newIP)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error creating instance")
 	}
 	instance.startTime = startTime
+	instance.TraceLabel = w.TraceLabel
+	instance.Warm = w.Warm
 
-	ok, err := instance.Allowed()
+	ok, reason, err := instance.Allowed()
 	if err != nil {
 		_ = instance.Clean()
 		return nil, errors.Wrap(err, "Error checking if instance allowed")
@@ -249,29 +350,208 @@ func (c *controller) createInstance(w types.WorkloadRequest, wl types.Workload,
 
 	if !ok {
 		_ = instance.Clean()
-		return nil, errors.New("Over quota")
+		return nil, &types.QuotaError{Reason: reason}
 	}
 
-	err = instance.Add()
-	if err != nil {
-		_ = instance.Clean()
-		return nil, errors.Wrap(err, "Error adding instance")
+	return instance, nil
+}
+
+// launchInstance sends an instance added to the datastore to the scheduler.
+func (c *controller) launchInstance(w types.WorkloadRequest, wl types.Workload, instance *instance, batch *launchBatch) (*types.Instance, error) {
+	var err error
+
+	c.trackLaunch(instance)
+	if !instance.CNCI {
+		c.trackBoot(instance.ID)
 	}
 
-	if w.TraceLabel == "" {
+	if c.faults.failLaunch() {
+		go c.injectStartFailure(instance.ID)
+	} else if w.TraceLabel == "" {
 		err = c.client.StartWorkload(instance.newConfig.config)
 	} else {
 		err = c.client.StartTracedWorkload(instance.newConfig.config, instance.startTime, w.TraceLabel)
 	}
 
 	if err != nil {
+		batch.cancel()
+		c.untrackLaunch(instance.ID)
 		_ = instance.Clean()
 		return nil, errors.Wrap(err, "Error starting workload")
 	}
 
+	if wl.VMType == payloads.Docker {
+		c.imageLaunched(wl.ImageName)
+	}
+
+	c.shadowPlace(instance)
+
 	return instance.Instance, nil
 }
 
+// bulkLaunchThreshold is the number of new instances from which the
+// instances of a launch are added to the datastore in a single batch.
+const bulkLaunchThreshold = 10
+
+// launchRequest holds what is needed to create one of the new instances
+// of a launch.
+type launchRequest struct {
+	id   string
+	name string
+	ip   net.IP
+}
+
+// createInstances creates the instances of a large launch.  They are all
+// prepared first, then added to the datastore with a single write and
+// finally sent to the scheduler.  This is only done when launches are not
+// paced, paced launches being created one at a time as they are
+// dispatched.
+func (c *controller) createInstances(w types.WorkloadRequest, wl types.Workload, launches []launchRequest, batch *launchBatch) ([]*types.Instance, error) {
+	var e error
+	var sem = make(chan int, runtime.NumCPU())
+
+	type prepared struct {
+		instance *instance
+		err      error
+	}
+
+	preparedChan := make(chan prepared)
+
+	for _, l := range launches {
+		go func(l launchRequest) {
+			sem <- 1
+			instance, err := c.prepareInstance(w, wl, l.id, l.name, l.ip)
+			<-sem
+			preparedChan <- prepared{instance: instance, err: err}
+		}(l)
+	}
+
+	var instances []*instance
+	var newInstances []*types.Instance
+	for range launches {
+		p := <-preparedChan
+		if p.err == nil {
+			instances = append(instances, p.instance)
+			newInstances = append(newInstances, p.instance.Instance)
+		} else if e == nil {
+			// return the first error
+			e = p.err
+		}
+	}
+
+	err := c.ds.AddInstances(newInstances)
+	if err != nil {
+		for _, instance := range instances {
+			_ = instance.Clean()
+		}
+		// A name may have been taken since instanceNames checked it.
+		if err == types.ErrInstanceNameInUse {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "Error adding instances")
+	}
+
+	type result struct {
+		instance *types.Instance
+		err      error
+	}
+
+	errChan := make(chan result)
+
+	for _, pending := range instances {
+		go func(pending *instance) {
+			sem <- 1
+			i, err := c.launchAddedInstance(w, wl, pending, batch)
+			<-sem
+			errChan <- result{instance: i, err: err}
+		}(pending)
+	}
+
+	var launched []*types.Instance
+	for range instances {
+		retVal := <-errChan
+		if retVal.err == nil {
+			launched = append(launched, retVal.instance)
+		} else if e == nil {
+			e = retVal.err
+		}
+	}
+
+	return launched, e
+}
+
+// launchAddedInstance attaches the volumes of an instance added to the
+// datastore by createInstances and sends it to the scheduler, unless the
+// launch has been cancelled in the meantime.
+func (c *controller) launchAddedInstance(w types.WorkloadRequest, wl types.Workload, instance *instance, batch *launchBatch) (*types.Instance, error) {
+	err := instance.attachVolumes()
+	if err != nil {
+		_ = instance.Clean()
+		return nil, errors.Wrap(err, "Error adding instance")
+	}
+
+	select {
+	case <-batch.cancelled:
+		_ = instance.Clean()
+		return nil, errLaunchCancelled
+	default:
+	}
+
+	return c.launchInstance(w, wl, instance, batch)
+}
+
+// instanceName returns the name of the instance id launched at position
+// index, starting at 0, of a launch of n instances named after template.
+// Without placeholders in the template the instances of multi-instance
+// launches are named template-1 to template-n.
+func instanceName(template string, index int, n int, id string) string {
+	if template == "" {
+		return ""
+	}
+
+	if !strings.Contains(template, types.InstanceIndex) &&
+		!strings.Contains(template, types.InstanceShortID) {
+		if n == 1 {
+			return template
+		}
+		template += "-" + types.InstanceIndex
+	}
+
+	if len(id) > 8 {
+		id = id[:8]
+	}
+
+	r := strings.NewReplacer(types.InstanceIndex, strconv.Itoa(index+1),
+		types.InstanceShortID, id)
+	return r.Replace(template)
+}
+
+// instanceNames generates the names of the instances of a launch, making
+// sure they are not already in use within the tenant.
+func (c *controller) instanceNames(w types.WorkloadRequest, ids []string) ([]string, error) {
+	names := make([]string, len(ids))
+	used := make(map[string]bool)
+
+	for i, id := range ids {
+		names[i] = instanceName(w.Name, i, len(ids), id)
+		if names[i] == "" {
+			continue
+		}
+
+		existingID, err := c.ds.ResolveInstance(w.TenantID, names[i])
+		if err != nil {
+			return nil, errors.Wrap(err, "error trying to resolve name")
+		}
+
+		if existingID != "" || used[names[i]] {
+			return nil, types.ErrInstanceNameInUse
+		}
+		used[names[i]] = true
+	}
+
+	return names, nil
+}
+
 func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance, error) {
 	var e error
 	var sem = make(chan int, runtime.NumCPU())
@@ -285,28 +565,92 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 		return nil, err
 	}
 
-	if wl.Requirements.Privileged {
-		tenant, err := c.ds.GetTenant(w.TenantID)
-		if err != nil {
-			return nil, errors.Wrap(err, "error getting tenant from datastore")
+	for _, s := range wl.Storage {
+		if s.SourceType == types.ImageService {
+			if err := c.checkImageUsable(w.TenantID, s.Source); err != nil {
+				return nil, err
+			}
 		}
+	}
+
+	tenant, err := c.ds.GetTenant(w.TenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tenant from datastore")
+	}
+
+	if tenant == nil {
+		return nil, types.ErrTenantNotFound
+	}
+
+	if wl.Requirements.Privileged && !tenant.Permissions.PrivilegedContainers {
+		return nil, errors.New("Permission denied: you do not have permission to create privileged workloads")
+	}
+
+	if wl.Visibility == types.Public && !isCNCIWorkload(&wl) &&
+		tenant.InstanceDefaults.ForbidPublicWorkloads {
+		return nil, types.ErrPublicWorkloadForbidden
+	}
+
+	// warm instances are claimed first, new ones launched for the rest
+	warm := c.warmCandidates(w)
+	ids := make([]string, w.Instances)
+	for i := range ids {
+		if i < len(warm) {
+			ids[i] = warm[i]
+		} else {
+			ids[i] = uuid.Generate().String()
+		}
+	}
+
+	names, err := c.instanceNames(w, ids)
+	if err != nil {
+		return nil, err
+	}
 
-		if !tenant.Permissions.PrivilegedContainers {
-			return nil, errors.New("Permission denied: you do not have permission to create privileged workloads")
+	var newInstances []*types.Instance
+	claimed := c.claimWarmInstances(w, len(warm), ids, names)
+	launches := 0
+	for _, i := range claimed {
+		if i != nil {
+			newInstances = append(newInstances, i)
+		} else {
+			launches++
 		}
 	}
 
 	var IPPool []net.IP
 
 	// if this is for a CNCI, we don't want to allocate any IPs.
-	if w.Subnet == "" {
-		IPPool, err = c.ds.AllocateTenantIPPool(w.TenantID, w.Instances)
+	if w.Subnet == "" && launches > 0 {
+		IPPool, err = c.ds.AllocateTenantIPPool(w.TenantID, launches)
 		if err != nil {
-			return nil, err
+			return newInstances, err
 		}
 	}
 
-	var newInstances []*types.Instance
+	var requests []launchRequest
+	for i, n := 0, 0; i < w.Instances; i++ {
+		var newIP net.IP
+
+		if claimed[i] != nil {
+			continue
+		}
+
+		if w.Subnet == "" {
+			newIP = IPPool[n]
+			n++
+		}
+
+		requests = append(requests, launchRequest{id: ids[i], name: names[i], ip: newIP})
+	}
+
+	batch := newLaunchBatch()
+
+	if c.dispatcher == nil && len(requests) >= bulkLaunchThreshold {
+		launched, err := c.createInstances(w, wl, requests, batch)
+		return append(newInstances, launched...), err
+	}
+
 	type result struct {
 		instance *types.Instance
 		err      error
@@ -314,33 +658,20 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 
 	errChan := make(chan result)
 
-	for i := 0; i < w.Instances; i++ {
-		var newIP net.IP
-
-		if w.Subnet == "" {
-			newIP = IPPool[i]
-		}
-
-		name := w.Name
-		if name != "" {
-			if w.Instances > 1 {
-				name = fmt.Sprintf("%s-%d", name, i)
-			}
-		}
-
-		go func(newIP net.IP, name string) {
+	for _, r := range requests {
+		go func(r launchRequest) {
 			sem <- 1
-			instance, err := c.createInstance(w, wl, name, newIP)
+			instance, err := c.createInstance(w, wl, r.id, r.name, r.ip, batch)
 			ret := result{
 				err:      err,
 				instance: instance,
 			}
 			<-sem
 			errChan <- ret
-		}(newIP, name)
+		}(r)
 	}
 
-	for i := 0; i < w.Instances; i++ {
+	for i := 0; i < launches; i++ {
 		retVal := <-errChan
 		if retVal.err == nil {
 			newInstances = append(newInstances, retVal.instance)
@@ -363,23 +694,61 @@ func (c *controller) deleteEphemeralStorage(instanceID string) error {
 		if err != nil {
 			return errors.Wrap(err, "Error deleting storage attachment from datastore")
 		}
-		bd, err := c.ds.GetBlockDevice(attachment.BlockID)
+		err = c.destroyVolume(attachment.BlockID)
 		if err != nil {
-			return errors.Wrap(err, "Error getting block device from datastore")
+			return err
 		}
-		err = c.ds.DeleteBlockDevice(attachment.BlockID)
+	}
+	return nil
+}
+
+// deleteLaunchVolumes deletes the volumes created from its workload for
+// an instance whose launch failed, along with their attachments.
+func (c *controller) deleteLaunchVolumes(instanceID string, volumes []string) error {
+	attachments := c.ds.GetStorageAttachments(instanceID)
+	for _, volumeID := range volumes {
+		_, err := c.ds.GetBlockDevice(volumeID)
 		if err != nil {
-			return errors.Wrap(err, "Error deleting block device from datastore")
+			// already deleted as ephemeral storage
+			continue
 		}
-		err = c.DeleteBlockDevice(attachment.BlockID)
-		if err != nil {
-			return errors.Wrap(err, "Error deleting block device")
+
+		for _, attachment := range attachments {
+			if attachment.BlockID != volumeID {
+				continue
+			}
+			err = c.ds.DeleteStorageAttachment(attachment.ID)
+			if err != nil {
+				return errors.Wrap(err, "Error deleting storage attachment from datastore")
+			}
 		}
-		if !bd.Internal {
-			c.qs.Release(bd.TenantID,
-				payloads.RequestedResource{Type: payloads.Volume, Value: 1},
-				payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: bd.Size})
+
+		err = c.destroyVolume(volumeID)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// destroyVolume removes a volume, whatever its state, releasing its quota.
+func (c *controller) destroyVolume(volumeID string) error {
+	bd, err := c.ds.GetBlockDevice(volumeID)
+	if err != nil {
+		return errors.Wrap(err, "Error getting block device from datastore")
+	}
+	err = c.ds.DeleteBlockDevice(volumeID)
+	if err != nil {
+		return errors.Wrap(err, "Error deleting block device from datastore")
+	}
+	err = c.volumeDriver(bd.Pool).DeleteBlockDevice(volumeID)
+	if err != nil {
+		return errors.Wrap(err, "Error deleting block device")
+	}
+	if !bd.Internal {
+		c.qs.Release(bd.TenantID,
+			payloads.RequestedResource{Type: payloads.Volume, Value: 1},
+			payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: bd.Size})
+	}
+	return nil
+}
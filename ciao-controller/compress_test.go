@@ -0,0 +1,178 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"deflate", false},
+		{"deflate, gzip", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.5, deflate", true},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"identity, *;q=0.1", true},
+		{"gzip;q=bogus", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+
+		if acceptsGzip(r) != test.expected {
+			t.Errorf("Accept-Encoding %q: expected %v", test.acceptEncoding, test.expected)
+		}
+	}
+}
+
+const compressTestBody = `{"servers":[{"id":"a"},{"id":"b"},{"id":"c"}]}`
+
+var compressTestHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/empty" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(compressTestBody))
+})
+
+func TestGzipHandlerCompresses(t *testing.T) {
+	h := &gzipHandler{Next: compressTestHandler}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Content-Type not preserved: %q", w.Header().Get("Content-Type"))
+	}
+
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != compressTestBody {
+		t.Fatalf("Expected %s, got %s", compressTestBody, string(body))
+	}
+}
+
+func TestGzipHandlerIdentity(t *testing.T) {
+	h := &gzipHandler{Next: compressTestHandler}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Unexpected Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+
+	if w.Body.String() != compressTestBody {
+		t.Fatalf("Expected %s, got %s", compressTestBody, w.Body.String())
+	}
+}
+
+func TestGzipHandlerNoContent(t *testing.T) {
+	h := &gzipHandler{Next: compressTestHandler}
+
+	r := httptest.NewRequest("DELETE", "/empty", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Unexpected Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+
+	if w.Body.Len() != 0 {
+		t.Fatalf("Expected empty body, got %d bytes", w.Body.Len())
+	}
+}
+
+func TestGzipHandlerFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	event := "event 1\n"
+
+	h := &gzipHandler{Next: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		f, ok := rw.(http.Flusher)
+		if !ok {
+			t.Fatal("Compressed response writer is not a http.Flusher")
+		}
+
+		_, _ = rw.Write([]byte(event))
+		f.Flush()
+
+		if !w.Flushed {
+			t.Fatal("Response not flushed")
+		}
+
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, len(event))
+		if _, err := io.ReadFull(gr, b); err != nil || string(b) != event {
+			t.Fatalf("Expected %q flushed, got %q: %v", event, b, err)
+		}
+	})}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	h.ServeHTTP(w, r)
+}
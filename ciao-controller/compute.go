@@ -17,10 +17,10 @@ package main
 import (
 	"fmt"
 	"regexp"
-	"sort"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/gorilla/mux"
 )
 
@@ -45,16 +45,80 @@ func instanceToServer(ctl *controller, instance *types.Instance) (api.ServerDeta
 				MacAddr: instance.MACAddress,
 			},
 		},
-		Volumes: volumes,
-		SSHIP:   instance.SSHIP,
-		SSHPort: instance.SSHPort,
-		Created: instance.CreateTime,
-		Name:    instance.Name,
+		Volumes:    volumes,
+		SSHIP:      instance.SSHIP,
+		SSHPort:    instance.SSHPort,
+		Created:    instance.CreateTime,
+		Name:       instance.Name,
+		TraceLabel: instance.TraceLabel,
+		Protected:  instance.Protected,
+		Warm:       instance.Warm,
+		Health:     ctl.ds.GetInstanceHealth(instance.ID),
+		Tags:       instance.Tags,
+	}
+
+	// the workload may have been deleted since the instance was
+	// launched, in which case its placement constraints are unknown.
+	wl, err := ctl.ds.GetWorkload(instance.WorkloadID)
+	if err == nil {
+		server.SchedulerHints = schedulerHints(wl.Requirements)
+		server.VCPUs = wl.Requirements.VCPUs + instance.ExtraVCPUs
+		server.MemMB = wl.Requirements.MemMB + instance.ExtraMemMB
 	}
 
 	return server, nil
 }
 
+// schedulerHints returns the placement constraints of a workload, or nil
+// if the scheduler was free to choose any node.
+func schedulerHints(r payloads.WorkloadRequirements) *api.SchedulerHints {
+	if r.NodeID == "" && r.Hostname == "" && !r.NetworkNode {
+		return nil
+	}
+
+	return &api.SchedulerHints{
+		NodeID:      r.NodeID,
+		Hostname:    r.Hostname,
+		NetworkNode: r.NetworkNode,
+	}
+}
+
+// instanceLimit returns the maximum number of instances a single request
+// of a tenant can start, 0 if there is no limit.  The limit of the
+// controller applies unless the admin has set one for the tenant.
+func (c *controller) instanceLimit(tenantID string) (int, error) {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return 0, err
+	}
+
+	if tenant == nil {
+		return 0, types.ErrTenantNotFound
+	}
+
+	if tenant.MaxInstancesPerRequest < 0 {
+		return 0, nil
+	} else if tenant.MaxInstancesPerRequest > 0 {
+		return tenant.MaxInstancesPerRequest, nil
+	}
+
+	return *maxInstancesPerRequest, nil
+}
+
+// validInstanceNameTemplate checks the names generated from the template
+// of a launch of n instances, which may be empty.
+func validInstanceNameTemplate(template string, n int) bool {
+	if template == "" {
+		return true
+	}
+
+	// Between 1 and 64 (HOST_NAME_MAX) alphanum (+ "-"), checked on the
+	// longest name generated from the template.
+	name := instanceName(template, n-1, n, "00000000")
+	r := regexp.MustCompile("^[a-z0-9-]{1,64}$")
+	return r.MatchString(name)
+}
+
 func (c *controller) CreateServer(tenant string, server api.CreateServerRequest) (resp interface{}, err error) {
 	nInstances := 1
 
@@ -64,12 +128,21 @@ func (c *controller) CreateServer(tenant string, server api.CreateServerRequest)
 		nInstances = server.Server.MinInstances
 	}
 
-	if server.Server.Name != "" {
-		// Between 1 and 64 (HOST_NAME_MAX) alphanum (+ "-")
-		r := regexp.MustCompile("^[a-z0-9-]{1,64}$")
-		if !r.MatchString(server.Server.Name) {
-			return server, types.ErrBadName
-		}
+	if c.tenantResubnetting(tenant) {
+		return server, types.ErrTenantResubnetting
+	}
+
+	limit, err := c.instanceLimit(tenant)
+	if err != nil {
+		return server, err
+	}
+
+	if limit > 0 && nInstances > limit {
+		return server, &types.InstanceLimitError{Requested: nInstances, Limit: limit}
+	}
+
+	if !validInstanceNameTemplate(server.Server.Name, nInstances) {
+		return server, types.ErrBadName
 	}
 
 	label := server.Server.Metadata["label"]
@@ -98,7 +171,8 @@ func (c *controller) CreateServer(tenant string, server api.CreateServerRequest)
 	}
 
 	if e != nil {
-		_ = c.ds.LogError(tenant, fmt.Sprintf("Error launching instance(s): %v", e))
+		_ = c.ds.LogEvent(tenant, types.EventError, types.EventCategoryInstance,
+			fmt.Sprintf("Error launching instance(s): %v", e))
 	}
 
 	// If no instances launcher or if none converted bail early
@@ -129,22 +203,27 @@ func (c *controller) CreateServer(tenant string, server api.CreateServerRequest)
 	return builtServers, nil
 }
 
-func (c *controller) ListServersDetail(tenant string) ([]api.ServerDetails, error) {
-	var servers []api.ServerDetails
-	var err error
-	var instances []*types.Instance
+func (c *controller) ListServersDetail(filter types.InstanceFilter, marker string, limit int) (api.Servers, error) {
+	var servers api.Servers
 
-	if tenant != "" {
-		instances, err = c.ds.GetAllInstancesFromTenant(tenant)
-	} else {
-		instances, err = c.ds.GetAllInstances()
+	// Ask for one extra instance so we know whether there is a
+	// further page to point the caller to.
+	fetch := limit
+	if limit > 0 {
+		fetch = limit + 1
 	}
 
+	instances, total, err := c.ds.ListInstances(filter, marker, fetch)
 	if err != nil {
 		return servers, err
 	}
 
-	sort.Sort(types.SortedInstancesByID(instances))
+	if limit > 0 && len(instances) > limit {
+		instances = instances[:limit]
+		servers.NextMarker = instances[limit-1].ID
+	}
+
+	servers.TotalServers = total
 
 	for _, instance := range instances {
 		server, err := instanceToServer(c, instance)
@@ -152,7 +231,7 @@ func (c *controller) ListServersDetail(tenant string) ([]api.ServerDetails, erro
 			continue
 		}
 
-		servers = append(servers, server)
+		servers.Servers = append(servers.Servers, server)
 	}
 
 	return servers, nil
@@ -174,6 +253,31 @@ func (c *controller) ShowServerDetails(tenant string, server string) (api.Server
 	return s, nil
 }
 
+func (c *controller) ShowServerNetworkStats(tenant string, server string) (types.CiaoServerNetworkStats, error) {
+	instance, err := c.ds.GetTenantInstance(tenant, server)
+	if err != nil {
+		return types.CiaoServerNetworkStats{}, err
+	}
+
+	return types.CiaoServerNetworkStats{
+		ID:       instance.ID,
+		Samples:  c.ds.GetInstanceNetworkSamples(instance.ID),
+		External: c.ds.GetInstanceTraffic(instance.ID),
+	}, nil
+}
+
+func (c *controller) ShowServerDiskStats(tenant string, server string) (types.CiaoServerDiskStats, error) {
+	instance, err := c.ds.GetTenantInstance(tenant, server)
+	if err != nil {
+		return types.CiaoServerDiskStats{}, err
+	}
+
+	return types.CiaoServerDiskStats{
+		ID:      instance.ID,
+		Samples: c.ds.GetInstanceDiskIOSamples(instance.ID),
+	}, nil
+}
+
 func (c *controller) DeleteServer(tenant string, server string) error {
 	/* First check that the instance belongs to this tenant */
 	_, err := c.ds.GetTenantInstance(tenant, server)
@@ -186,6 +290,18 @@ func (c *controller) DeleteServer(tenant string, server string) error {
 	return err
 }
 
+// ProtectServer sets or clears the deletion protection of an instance.
+// A protected instance cannot be deleted until its protection is
+// cleared.
+func (c *controller) ProtectServer(tenant string, ID string, protect bool) error {
+	_, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	return c.ds.ProtectInstance(ID, protect)
+}
+
 func (c *controller) StartServer(tenant string, ID string) error {
 	_, err := c.ds.GetTenantInstance(tenant, ID)
 	if err != nil {
@@ -162,6 +162,57 @@ func TestCreateSingleServer(t *testing.T) {
 	_ = testCreateServer(t, 1)
 }
 
+func TestCreateServerInstanceLimit(t *testing.T) {
+	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No valid workloads for tenant: %s: %v", tenant.ID, err)
+	}
+
+	var server api.CreateServerRequest
+	server.Server.MaxInstances = *maxInstancesPerRequest + 1
+	server.Server.WorkloadID = wls[0].ID
+
+	_, err = ctl.CreateServer(tenant.ID, server)
+	if _, ok := err.(*types.InstanceLimitError); !ok {
+		t.Fatalf("Expected instance limit error, got %v", err)
+	}
+
+	err = ctl.ds.PatchTenant(tenant.ID, []byte(`{"max_instances_per_request":2}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ctl.ds.PatchTenant(tenant.ID, []byte(`{"max_instances_per_request":null}`), types.MergePatch)
+	}()
+
+	server.Server.MaxInstances = 3
+
+	_, err = ctl.CreateServer(tenant.ID, server)
+	if lerr, ok := err.(*types.InstanceLimitError); !ok || lerr.Limit != 2 {
+		t.Fatalf("Expected tenant instance limit error, got %v", err)
+	}
+
+	limit, err := ctl.instanceLimit(tenant.ID)
+	if err != nil || limit != 2 {
+		t.Fatalf("Expected tenant instance limit 2, got %d: %v", limit, err)
+	}
+
+	err = ctl.ds.PatchTenant(tenant.ID, []byte(`{"max_instances_per_request":-1}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit, err = ctl.instanceLimit(tenant.ID)
+	if err != nil || limit != 0 {
+		t.Fatalf("Expected no instance limit, got %d: %v", limit, err)
+	}
+}
+
 func TestListServerDetailsTenant(t *testing.T) {
 	tenant, err := ctl.ds.GetTenant(testutil.ComputeUser)
 	if err != nil {
@@ -240,17 +291,20 @@ func testDeleteServer(t *testing.T, httpExpectedStatus int, httpExpectedErrorSta
 
 	tURL := testutil.ComputeURL + "/" + tenant.ID + "/instances/"
 
+	ackCh := wrappedClient.addAckChan(10)
+
 	servers := testCreateServer(t, 10)
 	if servers.TotalServers != 10 {
 		t.Fatal(err)
 	}
 
-	time.Sleep(2 * time.Second)
+	err = wrappedClient.getAckChan(ackCh)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	sendStatsCmd(client, t)
 
-	time.Sleep(2 * time.Second)
-
 	s := testListServerDetailsTenant(t, tenant.ID)
 
 	if s.TotalServers < 1 {
@@ -285,17 +339,20 @@ func testServersActionStart(t *testing.T, httpExpectedStatus int, validToken boo
 	}
 	defer client.Shutdown()
 
+	ackCh := wrappedClient.addAckChan(1)
+
 	servers := testCreateServer(t, 1)
 	if servers.TotalServers != 1 {
 		t.Fatal(err)
 	}
 
-	time.Sleep(2 * time.Second)
+	err = wrappedClient.getAckChan(ackCh)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	sendStatsCmd(client, t)
 
-	time.Sleep(1 * time.Second)
-
 	serverCh := server.AddCmdChan(ssntp.DELETE)
 
 	err = ctl.stopInstance(servers.Servers[0].ID)
@@ -347,17 +404,20 @@ func testServersActionStop(t *testing.T, httpExpectedStatus int, action string)
 	}
 	defer client.Shutdown()
 
+	ackCh := wrappedClient.addAckChan(1)
+
 	servers := testCreateServer(t, 1)
 	if servers.TotalServers != 1 {
 		t.Fatal(err)
 	}
 
-	time.Sleep(2 * time.Second)
+	err = wrappedClient.getAckChan(ackCh)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	sendStatsCmd(client, t)
 
-	time.Sleep(1 * time.Second)
-
 	var ids []string
 	ids = append(ids, servers.Servers[0].ID)
 
@@ -396,17 +456,20 @@ func testServerActionStop(t *testing.T, httpExpectedStatus int, validToken bool)
 	}
 	defer client.Shutdown()
 
+	ackCh := wrappedClient.addAckChan(1)
+
 	servers := testCreateServer(t, 1)
 	if servers.TotalServers != 1 {
 		t.Fatal(err)
 	}
 
-	time.Sleep(2 * time.Second)
+	err = wrappedClient.getAckChan(ackCh)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	sendStatsCmd(client, t)
 
-	time.Sleep(1 * time.Second)
-
 	url := testutil.ComputeURL + "/" + tenant.ID + "/instances/" + servers.Servers[0].ID + "/action"
 	_ = testHTTPRequest(t, "POST", url, httpExpectedStatus, []byte(action), validToken)
 }
@@ -429,17 +492,20 @@ func TestServerActionStart(t *testing.T) {
 	}
 	defer client.Shutdown()
 
+	ackCh := wrappedClient.addAckChan(1)
+
 	servers := testCreateServer(t, 1)
 	if servers.TotalServers != 1 {
 		t.Fatal(err)
 	}
 
-	time.Sleep(1 * time.Second)
+	err = wrappedClient.getAckChan(ackCh)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	sendStatsCmd(client, t)
 
-	time.Sleep(1 * time.Second)
-
 	serverCh := server.AddCmdChan(ssntp.DELETE)
 
 	err = ctl.stopInstance(servers.Servers[0].ID)
@@ -612,8 +678,10 @@ func testListEventsTenant(t *testing.T, httpExpectedStatus int, validToken bool)
 		event := types.CiaoEvent{
 			Timestamp: l.Timestamp,
 			TenantID:  l.TenantID,
-			EventType: l.EventType,
+			Severity:  l.Severity,
+			Category:  l.Category,
 			Message:   l.Message,
+			NodeID:    l.NodeID,
 		}
 		expected.Events = append(expected.Events, event)
 	}
@@ -907,8 +975,10 @@ func testListEvents(t *testing.T, httpExpectedStatus int, validToken bool) {
 		event := types.CiaoEvent{
 			Timestamp: l.Timestamp,
 			TenantID:  l.TenantID,
-			EventType: l.EventType,
+			Severity:  l.Severity,
+			Category:  l.Category,
 			Message:   l.Message,
+			NodeID:    l.NodeID,
 		}
 		expected.Events = append(expected.Events, event)
 	}
@@ -935,6 +1005,90 @@ func TestListEvents(t *testing.T) {
 	testListEvents(t, http.StatusOK, true)
 }
 
+func TestListEventsFiltered(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []struct {
+		severity types.EventSeverity
+		category types.EventCategory
+	}{
+		{types.EventDebug, types.EventCategoryNetwork},
+		{types.EventWarning, types.EventCategoryNetwork},
+		{types.EventCritical, types.EventCategoryNode},
+		{types.EventError, types.EventCategoryStorage},
+	}
+
+	for _, e := range entries {
+		err = ctl.ds.LogEvent(tenant.ID, e.severity, e.category, "filtered event")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		status   int
+		expected int
+	}{
+		{"", http.StatusOK, 4},
+		{"?severity=warning", http.StatusOK, 3},
+		{"?category=network", http.StatusOK, 2},
+		{"?severity=warning&category=network", http.StatusOK, 1},
+		{"?severity=critical&category=storage", http.StatusOK, 0},
+		{"?severity=fatal", http.StatusBadRequest, 0},
+		{"?category=cpu", http.StatusBadRequest, 0},
+		{"?since=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), http.StatusOK, 4},
+		{"?until=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), http.StatusOK, 0},
+		{"?since=yesterday", http.StatusBadRequest, 0},
+		{"?node_id=" + testutil.AgentUUID, http.StatusOK, 0},
+		{"?limit=-1", http.StatusBadRequest, 0},
+		{"?limit=3&marker=bad", http.StatusBadRequest, 0},
+	}
+
+	for _, tst := range tests {
+		url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/events" + tst.query
+		body := testHTTPRequest(t, "GET", url, tst.status, nil, true)
+		if tst.status != http.StatusOK {
+			continue
+		}
+
+		var result types.CiaoEvents
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Events) != tst.expected {
+			t.Errorf("%s: expected %d events, got %d", tst.query, tst.expected, len(result.Events))
+		}
+	}
+
+	url := testutil.ComputeURL + "/v2.1/" + tenant.ID + "/events?limit=3"
+	var pages []types.CiaoEvents
+	for {
+		body := testHTTPRequest(t, "GET", url, http.StatusOK, nil, true)
+
+		var result types.CiaoEvents
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, result)
+
+		if result.NextMarker == "" {
+			break
+		}
+		url = testutil.ComputeURL + "/v2.1/" + tenant.ID + "/events?limit=3&marker=" + result.NextMarker
+	}
+
+	if len(pages) != 2 || len(pages[0].Events) != 3 || len(pages[1].Events) != 1 {
+		t.Fatalf("Unexpected pages of events: %+v", pages)
+	}
+}
+
 func testClearEvents(t *testing.T, httpExpectedStatus int, validToken bool) {
 	url := testutil.ComputeURL + "/v2.1/events"
 
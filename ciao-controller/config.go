@@ -0,0 +1,43 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// GetConfig returns the settings of the cluster configuration.
+func (c *controller) GetConfig() (types.ConfigResponse, error) {
+	return types.ConfigResponse{Settings: c.ds.GetConfig()}, nil
+}
+
+// GetConfigSetting returns a setting of the cluster configuration.
+func (c *controller) GetConfigSetting(key string) (types.ConfigSetting, error) {
+	return c.ds.GetConfigSetting(key)
+}
+
+// UpdateConfigSetting changes a setting of the cluster configuration. An
+// empty value restores the default value of the setting.
+func (c *controller) UpdateConfigSetting(key string, value string) error {
+	err := c.ds.SetConfigSetting(key, value)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Configuration setting %s changed to %q", key, value)
+
+	return nil
+}
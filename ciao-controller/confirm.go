@@ -0,0 +1,196 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+var confirmationTimeout = flag.Duration("confirmation_timeout", 5*time.Minute, "time allowed to confirm a destructive admin operation after its dry run")
+
+// pendingConfirmation is a destructive operation which was dry run and
+// is waiting to be confirmed.
+type pendingConfirmation struct {
+	op      types.DestructiveOperation
+	target  string
+	expires time.Time
+}
+
+// DryRun returns what a destructive operation would remove, along with
+// the token with which the operation must be requested to be carried out.
+func (c *controller) DryRun(op types.DestructiveOperation, ID string) (types.DryRunResult, error) {
+	var impact map[string]int
+	var err error
+
+	switch op {
+	case types.DeleteTenantOperation:
+		impact, err = c.tenantDeletionImpact(ID)
+	case types.DeletePoolOperation:
+		impact, err = c.poolDeletionImpact(ID)
+	case types.DrainPoolOperation:
+		impact, err = c.poolDrainImpact(ID)
+	case types.DeleteNodeOperation:
+		impact, err = c.nodeDeletionImpact(ID)
+	default:
+		err = types.ErrBadRequest
+	}
+
+	if err != nil {
+		return types.DryRunResult{}, err
+	}
+
+	res := types.DryRunResult{
+		Operation: op,
+		Target:    ID,
+		Impact:    impact,
+		Token:     uuid.Generate().String(),
+		Expires:   time.Now().Add(*confirmationTimeout),
+	}
+
+	c.confirmLock.Lock()
+	defer c.confirmLock.Unlock()
+
+	if c.confirmations == nil {
+		c.confirmations = make(map[string]pendingConfirmation)
+	}
+
+	for token, p := range c.confirmations {
+		if time.Now().After(p.expires) {
+			delete(c.confirmations, token)
+		}
+	}
+
+	c.confirmations[res.Token] = pendingConfirmation{
+		op:      op,
+		target:  ID,
+		expires: res.Expires,
+	}
+
+	return res, nil
+}
+
+// Confirm checks that token was returned by the dry run of the operation
+// and has not expired. A token confirms a single operation. Removing a
+// node without instances needs no confirmation.
+func (c *controller) Confirm(op types.DestructiveOperation, ID string, token string) error {
+	if token == "" {
+		if op == types.DeleteNodeOperation {
+			if n, err := c.ds.GetNodeInstanceCount(ID); err == nil && n == 0 {
+				return nil
+			}
+		}
+		return types.ErrConfirmationRequired
+	}
+
+	c.confirmLock.Lock()
+	defer c.confirmLock.Unlock()
+
+	p, ok := c.confirmations[token]
+	if !ok || p.op != op || p.target != ID {
+		return types.ErrInvalidConfirmation
+	}
+
+	delete(c.confirmations, token)
+
+	if time.Now().After(p.expires) {
+		return types.ErrInvalidConfirmation
+	}
+
+	return nil
+}
+
+func (c *controller) tenantDeletionImpact(tenantID string) (map[string]int, error) {
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, types.ErrTenantNotFound
+	}
+
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := c.ds.GetBlockDevices(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := c.ds.GetImages(tenantID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	privateImages := 0
+	for _, i := range images {
+		if i.Visibility != types.Public {
+			privateImages++
+		}
+	}
+
+	workloads, err := c.ds.GetTenantWorkloads(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int{
+		"instances":    len(instances),
+		"volumes":      len(volumes),
+		"images":       privateImages,
+		"workloads":    len(workloads),
+		"external_ips": len(c.ListMappedAddresses(&tenantID)),
+	}, nil
+}
+
+func (c *controller) poolDeletionImpact(ID string) (map[string]int, error) {
+	pool, err := c.ds.GetPool(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int{
+		"subnets":      len(pool.Subnets),
+		"external_ips": pool.TotalIPs,
+		"mapped_ips":   pool.TotalIPs - pool.Free,
+	}, nil
+}
+
+func (c *controller) poolDrainImpact(ID string) (map[string]int, error) {
+	pool, err := c.ds.GetPool(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int{
+		"mapped_ips": pool.TotalIPs - pool.Free,
+	}, nil
+}
+
+func (c *controller) nodeDeletionImpact(nodeID string) (map[string]int, error) {
+	n, err := c.ds.GetNodeInstanceCount(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int{
+		"instances": n,
+	}, nil
+}
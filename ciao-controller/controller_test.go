@@ -25,6 +25,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,6 +40,7 @@ import (
 	"github.com/ciao-project/ciao/testutil"
 	"github.com/ciao-project/ciao/uuid"
 	jsonpatch "github.com/evanphx/json-patch"
+	"gopkg.in/yaml.v2"
 )
 
 func addTestWorkload(tenantID string) error {
@@ -322,6 +324,8 @@ func TestTenantOutOfBounds(t *testing.T) {
 	_, err = ctl.startWorkload(w)
 	if err == nil {
 		t.Errorf("Not tracking limits correctly")
+	} else if qerr, ok := err.(*types.QuotaError); !ok || qerr.Reason != "Over quota: instance" {
+		t.Errorf("Unexpected quota error: %v", err)
 	}
 	quotas = []types.QuotaDetails{
 		{Name: "tenant-instances-quota", Value: -1},
@@ -329,6 +333,44 @@ func TestTenantOutOfBounds(t *testing.T) {
 	ctl.qs.Update(tenant.ID, quotas)
 }
 
+func TestRefusedLaunchUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.qs.Update(tenant.ID, []types.QuotaDetails{{Name: "tenant-instances-quota", Value: 1}})
+	defer ctl.qs.Update(tenant.ID, []types.QuotaDetails{{Name: "tenant-instances-quota", Value: -1}})
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+	}
+	_, err = ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.startWorkload(w)
+	if _, ok := err.(*types.QuotaError); !ok {
+		t.Fatalf("Expected quota error, got %v", err)
+	}
+
+	// the refused launch neither accounts for its instance nor
+	// releases the one of the running instance
+	for _, qd := range ctl.ListQuotas(tenant.ID) {
+		if qd.Name == "tenant-instances-quota" && qd.Usage != 1 {
+			t.Fatalf("Expected instances usage 1, got %d", qd.Usage)
+		}
+	}
+}
+
 func TestStartWorkload(t *testing.T) {
 	var reason payloads.StartFailureReason
 
@@ -336,6 +378,66 @@ func TestStartWorkload(t *testing.T) {
 	defer client.Shutdown()
 }
 
+func TestStartWorkloadBulk(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  bulkLaunchThreshold,
+		Name:       "bulk",
+	}
+
+	instances, err := ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != bulkLaunchThreshold {
+		t.Fatalf("Wrong number of instances, expected %d, got %d", bulkLaunchThreshold, len(instances))
+	}
+
+	names := make(map[string]bool)
+	for _, i := range instances {
+		stored, err := ctl.ds.GetInstance(i.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stored.State != payloads.Pending || names[stored.Name] {
+			t.Fatalf("Unexpected instance %s, state %s", stored.Name, stored.State)
+		}
+		names[stored.Name] = true
+	}
+}
+
+func TestStartWorkloadVNI(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	i := instances[0]
+	vni := ctl.ds.GetSubnetVNI(i.TenantID, i.Subnet)
+	if vni == 0 {
+		t.Fatalf("No VNI allocated to subnet %s", i.Subnet)
+	}
+
+	for _, v := range ctl.ds.GetVNIs() {
+		if v.VNI == vni && (v.TenantID != i.TenantID || v.Subnet != i.Subnet) {
+			t.Fatalf("VNI %d allocated to subnet %s and %+v", vni, i.Subnet, v)
+		}
+	}
+}
+
 func TestNamedWorkload(t *testing.T) {
 	var reason payloads.StartFailureReason
 
@@ -346,11 +448,13 @@ func TestNamedWorkload(t *testing.T) {
 		t.Errorf("Expected one instance created")
 	}
 
-	sds, err := ctl.ListServersDetail(instances[0].TenantID)
+	filter := types.InstanceFilter{TenantID: instances[0].TenantID}
+	servers, err := ctl.ListServersDetail(filter, "", 0)
 	if err != nil {
 		t.Error(err)
 	}
 
+	sds := servers.Servers
 	if len(sds) != 1 {
 		t.Errorf("Expected one server detail")
 	}
@@ -360,11 +464,183 @@ func TestNamedWorkload(t *testing.T) {
 	}
 }
 
+func TestWarmPool(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("WarmPool", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	tenant.WarmPools = map[string]int{wls[0].ID: 1}
+	defer func() { tenant.WarmPools = nil }()
+
+	clientCmdCh := client.AddCmdChan(ssntp.START)
+	ctl.refillWarmPool(tenant.ID, wls[0].ID)
+	result, err := client.GetCmdChanResult(clientCmdCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warm, err := ctl.ds.GetInstance(result.InstanceUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !warm.Warm {
+		t.Fatal("Warm pool instance not warm")
+	}
+
+	// the pool is full
+	ctl.refillWarmPool(tenant.ID, wls[0].ID)
+	instances, err := ctl.warmInstances(tenant.ID, wls[0].ID)
+	if err != nil || len(instances) != 1 {
+		t.Fatalf("Expected one warm instance, got %d", len(instances))
+	}
+
+	warm.State = payloads.Running
+
+	clientCmdCh = client.AddCmdChan(ssntp.START)
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+	}
+	claimed, err := ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != warm.ID || claimed[0].Warm {
+		t.Fatal("Warm instance not claimed")
+	}
+
+	// the claimed instance is replaced in the pool
+	result, err = client.GetCmdChanResult(clientCmdCh, ssntp.START)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID == warm.ID {
+		t.Fatal("Claimed instance relaunched")
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	id := "d7d86208-b46c-4465-9018-fe14087d415f"
+
+	tests := []struct {
+		template string
+		index    int
+		n        int
+		expected string
+	}{
+		{"", 0, 3, ""},
+		{"web", 0, 1, "web"},
+		{"web", 1, 3, "web-2"},
+		{"web-{index}-east", 2, 3, "web-3-east"},
+		{"web-{id}", 0, 3, "web-d7d86208"},
+		{"{index}-{id}", 0, 1, "1-d7d86208"},
+	}
+
+	for _, test := range tests {
+		name := instanceName(test.template, test.index, test.n, id)
+		if name != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.template, test.expected, name)
+		}
+	}
+}
+
+func TestInstanceNameInUse(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	w := types.WorkloadRequest{
+		WorkloadID: instances[0].WorkloadID,
+		TenantID:   instances[0].TenantID,
+		Instances:  1,
+		Name:       "test",
+	}
+
+	_, err := ctl.startWorkload(w)
+	if err != types.ErrInstanceNameInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNameInUse, err)
+	}
+
+	w.Name = "test-{index}"
+	w.Instances = 2
+
+	_, err = ctl.instanceNames(w, []string{uuid.Generate().String(), uuid.Generate().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerSchedulerHints(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	server, err := instanceToServer(ctl, instances[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.SchedulerHints != nil {
+		t.Fatalf("Unexpected scheduler hints: %+v", server.SchedulerHints)
+	}
+
+	hints := schedulerHints(payloads.WorkloadRequirements{NodeID: "node", NetworkNode: true})
+	if hints == nil || hints.NodeID != "node" || !hints.NetworkNode {
+		t.Fatalf("Unexpected scheduler hints: %+v", hints)
+	}
+}
+
 func TestStartTracedWorkload(t *testing.T) {
 	client := testStartTracedWorkload(t)
 	defer client.Shutdown()
 }
 
+func TestTraceLabel(t *testing.T) {
+	client := testStartTracedWorkload(t)
+	defer client.Shutdown()
+
+	instances, err := ctl.ds.GetAllInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, instance := range instances {
+		if instance.TraceLabel != "testtrace" {
+			continue
+		}
+
+		server, err := instanceToServer(ctl, instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if server.TraceLabel != "testtrace" {
+			t.Fatalf("Expected trace label testtrace, got %s", server.TraceLabel)
+		}
+		found = true
+	}
+
+	if !found {
+		t.Fatal("No instance launched with trace label")
+	}
+}
+
 func sendTraceReportEvent(client *testutil.SsntpTestClient, t *testing.T) {
 	clientCh := client.AddEventChan(ssntp.TraceReport)
 	serverCh := server.AddEventChan(ssntp.TraceReport)
@@ -425,6 +701,52 @@ func TestDeleteInstance(t *testing.T) {
 	}
 }
 
+func TestDeleteProtectedInstance(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	instance := instances[0]
+
+	err := ctl.ProtectServer(instance.TenantID, instance.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteServer(instance.TenantID, instance.ID)
+	if err != types.ErrInstanceProtected {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceProtected, err)
+	}
+
+	err = ctl.DeleteTenant(instance.TenantID)
+	if err != types.ErrInstanceProtected {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceProtected, err)
+	}
+
+	err = ctl.ProtectServer(instance.TenantID, instance.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+
+	err = ctl.DeleteServer(instance.TenantID, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instance.ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+}
+
 func TestStopInstance(t *testing.T) {
 	var reason payloads.StartFailureReason
 
@@ -523,60 +845,410 @@ func TestEvacuateNode(t *testing.T) {
 	}
 }
 
-func TestRestoreNode(t *testing.T) {
-	client, err := testutil.NewSsntpTestClientConnection("RestoreNode", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer client.Shutdown()
-
-	serverCh := server.AddCmdChan(ssntp.Restore)
-
-	err = ctl.RestoreNode(client.UUID)
-	if err != nil {
-		t.Error(err)
+func TestNodeHealthAlerts(t *testing.T) {
+	health := &payloads.NodeHealthStat{
+		Temperatures: []payloads.TemperatureStat{
+			{Sensor: "coretemp/Core 0", Celsius: 60},
+			{Sensor: "amdgpu/temp1", Celsius: 95},
+		},
+		CorrectedECCErrors:   150,
+		UncorrectedECCErrors: 1,
+		Disks: []payloads.DiskHealthStat{
+			{Device: "sda", Healthy: true},
+			{Device: "sdb", Healthy: false},
+		},
 	}
 
-	result, err := server.GetCmdChanResult(serverCh, ssntp.Restore)
-	if err != nil {
-		t.Fatal(err)
+	alerts := nodeHealthAlerts(health, 90, 100)
+	expected := []nodeHealthAlert{
+		{types.EventWarning, "sensor amdgpu/temp1 above 90 C"},
+		{types.EventWarning, "more than 100 corrected memory errors"},
+		{types.EventCritical, "uncorrected memory errors"},
+		{types.EventCritical, "disk sdb failing SMART health check"},
 	}
-	if result.NodeUUID != client.UUID {
-		t.Fatal("Did not get node ID")
+	if !reflect.DeepEqual(alerts, expected) {
+		t.Fatalf("Expected alerts %v, got %v", expected, alerts)
 	}
-}
 
-func TestAttachVolume(t *testing.T) {
-	client, err := testutil.NewSsntpTestClientConnection("AttachVolume", ssntp.AGENT, testutil.AgentUUID)
-	if err != nil {
-		t.Fatal(err)
+	alerts = nodeHealthAlerts(health, 0, 0)
+	if len(alerts) != 2 {
+		t.Fatalf("Disabled thresholds raised alerts: %v", alerts)
 	}
-	defer client.Ssntp.Close()
 
-	serverCh := server.AddCmdChan(ssntp.AttachVolume)
+	if nodeHealthAlerts(nil, 90, 100) != nil {
+		t.Fatal("Node without health report raised alerts")
+	}
+}
 
-	// ok to not send workload first?
+func TestCheckNodeHealth(t *testing.T) {
+	nodeID := uuid.Generate().String()
+	countAlerts := func(severity types.EventSeverity) int {
+		logs, err := ctl.ds.GetEventLog()
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	err = ctl.client.attachVolume("volID", "instanceID", client.UUID)
-	if err != nil {
-		t.Fatal(err)
+		n := 0
+		for _, e := range logs {
+			if e.Severity == severity && e.Category == types.EventCategoryNode &&
+				strings.Contains(e.Message, nodeID) {
+				n++
+			}
+		}
+		return n
 	}
 
-	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
-	if err != nil {
-		t.Fatal(err)
+	stat := payloads.Stat{
+		NodeUUID:     nodeID,
+		NodeHostName: "hot",
+		Health: &payloads.NodeHealthStat{
+			Temperatures: []payloads.TemperatureStat{
+				{Sensor: "coretemp/Core 0", Celsius: 120},
+			},
+		},
 	}
 
-	if result.NodeUUID != client.UUID {
-		t.Fatal("Did not get node ID")
+	ctl.checkNodeHealth(stat)
+	ctl.checkNodeHealth(stat)
+	if n := countAlerts(types.EventWarning); n != 1 {
+		t.Fatalf("Expected 1 alert, found %d", n)
 	}
 
-	if result.VolumeUUID != "volID" {
-		t.Fatal("Did not get volume ID")
+	stat.Health.Temperatures[0].Celsius = 50
+	ctl.checkNodeHealth(stat)
+	if n := countAlerts(types.EventInfo); n != 1 {
+		t.Fatalf("Expected alert to be cleared once, found %d", n)
 	}
 
-	if result.InstanceUUID != "instanceID" {
-		t.Fatal("Did not get instance ID")
+	ctl.healthLock.Lock()
+	_, ok := ctl.healthAlerts[nodeID]
+	ctl.healthLock.Unlock()
+	if ok {
+		t.Fatal("Cleared alerts not removed")
+	}
+}
+
+func TestInstanceDiskAlert(t *testing.T) {
+	tests := []struct {
+		usage       int
+		provisioned int
+		warn        int
+		alert       diskAlert
+	}{
+		{500, 1024, 90, diskAlertNone},
+		{1000, 1024, 90, diskAlertWarning},
+		{1000, 1024, 0, diskAlertNone},
+		{2000, 1024, 90, diskAlertOverLimit},
+		{2000, 0, 90, diskAlertNone},
+	}
+
+	for _, test := range tests {
+		alert := instanceDiskAlert(test.usage, test.provisioned, test.warn)
+		if alert != test.alert {
+			t.Errorf("Expected alert %d for %d MB of %d MB, got %d",
+				test.alert, test.usage, test.provisioned, alert)
+		}
+	}
+}
+
+func TestCheckDiskUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := types.Instance{
+		TenantID: tenant.ID,
+		State:    payloads.Exited,
+		ID:       uuid.Generate().String(),
+	}
+
+	err = ctl.ds.AddInstance(&instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 1, t)
+	_, err = ctl.ds.CreateStorageAttachment(instance.ID, payloads.StorageResource{ID: volID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countAlerts := func(severity types.EventSeverity) int {
+		logs, err := ctl.ds.GetEventLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		for _, e := range logs {
+			if e.Severity == severity && e.TenantID == tenant.ID {
+				n++
+			}
+		}
+		return n
+	}
+
+	stat := payloads.Stat{
+		NodeUUID: uuid.Generate().String(),
+		Instances: []payloads.InstanceStat{
+			{
+				InstanceUUID: instance.ID,
+				State:        payloads.Exited,
+				DiskUsageMB:  2000,
+			},
+		},
+	}
+
+	ctl.checkDiskUsage(stat)
+	ctl.checkDiskUsage(stat)
+	if n := countAlerts(types.EventCritical); n != 1 {
+		t.Fatalf("Expected 1 alert, found %d", n)
+	}
+
+	ctl.qs.Update(tenant.ID, []types.QuotaDetails{{Name: "tenant-storage-quota", Value: 1}})
+	defer ctl.qs.Update(tenant.ID, []types.QuotaDetails{{Name: "tenant-storage-quota", Value: -1}})
+
+	ctl.checkDiskUsage(stat)
+	if n := countAlerts(types.EventCritical); n != 2 {
+		t.Fatalf("Expected tenant over quota alert, found %d alerts", n)
+	}
+
+	stat.Instances[0].DiskUsageMB = 100
+	ctl.checkDiskUsage(stat)
+	if n := countAlerts(types.EventInfo); n != 2 {
+		t.Fatalf("Expected alerts to be cleared, found %d", n)
+	}
+}
+
+func TestDrainNode(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, _ := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	defer func(interval time.Duration) { drainPollInterval = interval }(drainPollInterval)
+	drainPollInterval = 10 * time.Millisecond
+
+	serverCh := server.AddCmdChan(ssntp.EVACUATE)
+
+	status, err := ctl.DrainNode(client.UUID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != types.NodeDrainEvacuating || status.Instances == 0 {
+		t.Fatalf("Unexpected drain status %v", status)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.EVACUATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+
+	_, err = ctl.DrainNode(client.UUID, true)
+	if err != types.ErrNodeDraining {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeDraining, err)
+	}
+
+	// pretend the instances of the node were migrated away
+	instances, err := ctl.ds.GetAllInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range instances {
+		if i.NodeID == client.UUID {
+			err = ctl.ds.InstanceStopped(i.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i := 0; i < 100 && status.State == types.NodeDrainEvacuating; i++ {
+		time.Sleep(drainPollInterval)
+
+		status, err = ctl.GetNodeDrain(client.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status.State != types.NodeDrainDeleted || status.Instances != 0 {
+		t.Fatalf("Unexpected drain status %v", status)
+	}
+
+	// stats sent by the removed node must not add it back
+	sendStatsCmd(client, t)
+
+	_, err = ctl.ds.GetNodeInstanceCount(client.UUID)
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotFound, err)
+	}
+
+	// the node connecting again makes it usable by the following tests
+	ctl.ds.AddNode(client.UUID, payloads.ComputeNode)
+
+	_, err = ctl.DrainNode("unknown-node", false)
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotFound, err)
+	}
+}
+
+func TestRestoreNode(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("RestoreNode", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	serverCh := server.AddCmdChan(ssntp.Restore)
+
+	err = ctl.RestoreNode(client.UUID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.Restore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+}
+
+func TestImagePrefetch(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("ImagePrefetch", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	saved := *imagePrefetchLaunches
+	*imagePrefetchLaunches = 2
+	defer func() { *imagePrefetchLaunches = saved }()
+
+	ctl.ds.AddNode(client.UUID, payloads.ComputeNode)
+	defer ctl.forgetNodeImages(client.UUID)
+
+	ctl.imagesReported(payloads.Stat{NodeUUID: client.UUID, CachedImages: []string{"fedora:25"}})
+
+	serverCh := server.AddCmdChan(ssntp.CacheImage)
+
+	ctl.imageLaunched("ubuntu:16.04")
+	ctl.imageLaunched("ubuntu:16.04")
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.CacheImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+
+	// the image is only requested once per node
+	ctl.imageLock.Lock()
+	if ctl.images.prefetch(client.UUID, "ubuntu:16.04") {
+		t.Error("Image requested twice")
+	}
+	if ctl.images.prefetch(client.UUID, "fedora:25") {
+		t.Error("Cached image requested")
+	}
+	ctl.imageLock.Unlock()
+}
+
+func TestAttachVolume(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("AttachVolume", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Ssntp.Close()
+
+	serverCh := server.AddCmdChan(ssntp.AttachVolume)
+
+	// ok to not send workload first?
+
+	err = ctl.client.attachVolume("volID", "instanceID", client.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+
+	if result.VolumeUUID != "volID" {
+		t.Fatal("Did not get volume ID")
+	}
+
+	if result.InstanceUUID != "instanceID" {
+		t.Fatal("Did not get instance ID")
+	}
+}
+
+func TestCreateInstanceImage(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	serverCh := server.AddCmdChan(ssntp.DELETE)
+
+	err := ctl.stopInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sendStopEvent(client, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantID := instances[0].TenantID
+	req := api.CreateImageRequest{Name: "instance-snapshot"}
+
+	_, err = ctl.CreateInstanceImage(tenantID, instances[0].ID, req)
+	if err != types.ErrNoBootVolume {
+		t.Fatalf("Expected %v, got %v", types.ErrNoBootVolume, err)
+	}
+
+	volume := addTestBlockDevice(t, tenantID)
+	_, err = ctl.ds.CreateStorageAttachment(instances[0].ID, payloads.StorageResource{ID: volume.ID, Bootable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := ctl.CreateInstanceImage(tenantID, instances[0].ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err = ctl.GetImage(tenantID, image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.State != types.Active || image.SourceInstanceID != instances[0].ID || image.SourceVolumeID != volume.ID {
+		t.Fatalf("incorrect image information stored: %+v", image)
 	}
 }
 
@@ -843,6 +1515,72 @@ func TestStartFailure(t *testing.T) {
 	// instance is no longer pending in the database
 }
 
+func sendStartFailure(t *testing.T, instanceID string, nodeID string, reason payloads.StartFailureReason) {
+	failure := payloads.ErrorStartFailure{
+		InstanceUUID: instanceID,
+		NodeUUID:     nodeID,
+		Reason:       reason,
+	}
+
+	y, err := yaml.Marshal(&failure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.client.ErrorNotify(ssntp.StartFailure, &ssntp.Frame{Payload: y})
+}
+
+func TestStartFailureRetry(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	id := instances[0].ID
+	var excluded []string
+
+	for i := 0; i < *launchRetries; i++ {
+		nodeID := fmt.Sprintf("failing-node-%d", i)
+		excluded = append(excluded, nodeID)
+
+		clientCmdCh := client.AddCmdChan(ssntp.START)
+		sendStartFailure(t, id, nodeID, payloads.FullComputeNode)
+
+		result, err := client.GetCmdChanResult(clientCmdCh, ssntp.START)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.InstanceUUID != id {
+			t.Fatalf("Expected launch of %s to be retried, got %s", id, result.InstanceUUID)
+		}
+
+		ctl.launchLock.Lock()
+		l := ctl.launches[id]
+		ctl.launchLock.Unlock()
+		if l == nil || !reflect.DeepEqual(l.config.sc.Start.ExcludeNodes, excluded) {
+			t.Fatalf("Expected excluded nodes %v, got %+v", excluded, l)
+		}
+
+		if _, err := ctl.ds.GetInstance(id); err != nil {
+			t.Fatalf("Instance deleted while its launch is retried: %v", err)
+		}
+	}
+
+	sendStartFailure(t, id, "failing-node", payloads.FullComputeNode)
+
+	if _, err := ctl.ds.GetInstance(id); err == nil {
+		t.Fatal("Instance not deleted once its launch retries are exhausted")
+	}
+
+	ctl.launchLock.Lock()
+	_, ok := ctl.launches[id]
+	ctl.launchLock.Unlock()
+	if ok {
+		t.Fatal("Launch still tracked once its retries are exhausted")
+	}
+}
+
 func TestStopFailure(t *testing.T) {
 	err := ctl.ds.ClearLog()
 	if err != nil {
@@ -1294,21 +2032,92 @@ func TestGetStorageForImage(t *testing.T) {
 	}
 }
 
-func TestStorageConfig(t *testing.T) {
-	var err error
-
+func TestImageCorrupted(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// get workload ID
-	wls, err := ctl.ds.GetWorkloads(tenant.ID)
-	if err != nil || len(wls) == 0 {
-		t.Fatal(err)
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		State:      types.Active,
+		Name:       "corrupted",
+		Visibility: types.Private,
 	}
 
-	tmpfile, err := ioutil.TempFile("", "test-image")
+	err = ctl.ds.AddImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl := types.Workload{
+		ID:       uuid.Generate().String(),
+		TenantID: tenant.ID,
+		FWType:   string(payloads.EFI),
+		VMType:   payloads.QEMU,
+		Config:   "#cloud-config",
+		Storage: []types.StorageResource{
+			{
+				Bootable:   true,
+				SourceType: types.ImageService,
+				Source:     image.ID,
+			},
+		},
+	}
+
+	err = ctl.ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		WorkloadID: wl.ID,
+	}
+
+	err = ctl.instanceImageCorrupted(&instance, payloads.ImageCorrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err = ctl.GetImage(tenant.ID, image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.State != types.ImageError {
+		t.Fatalf("Expected image in error state, got %s", image.State)
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: wl.ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+	}
+
+	_, err = ctl.startWorkload(w)
+	if err != types.ErrImageCorrupted {
+		t.Fatalf("Expected %v, got %v", types.ErrImageCorrupted, err)
+	}
+}
+
+func TestStorageConfig(t *testing.T) {
+	var err error
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get workload ID
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	tmpfile, err := ioutil.TempFile("", "test-image")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1342,6 +2151,79 @@ func TestStorageConfig(t *testing.T) {
 	wls[0].Storage = []types.StorageResource{}
 }
 
+func TestStorageConfigFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	wl := wls[0]
+	wl.Storage = []types.StorageResource{
+		{
+			Size:       10,
+			SourceType: types.Empty,
+			Ephemeral:  true,
+		},
+		{
+			SourceType: types.SourceType("unsupported"),
+			Source:     uuid.Generate().String(),
+		},
+	}
+
+	ip := net.ParseIP("172.16.0.2")
+
+	_, err = newConfig(ctl, &wl, uuid.Generate().String(), tenant.ID, "test", ip)
+	if err == nil {
+		t.Fatal("Storage of unsupported source type created")
+	}
+
+	volumes, err := ctl.ds.GetBlockDevices(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(volumes) != 0 {
+		t.Fatalf("Expected volumes created for instance to be deleted, got %d", len(volumes))
+	}
+}
+
+func TestValidateWorkloadStorage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		storage types.StorageResource
+		valid   bool
+	}{
+		{types.StorageResource{SourceType: types.Empty, Size: 10, Ephemeral: true}, true},
+		{types.StorageResource{SourceType: types.Empty}, false},
+		{types.StorageResource{SourceType: types.Empty, Size: -1}, false},
+		{types.StorageResource{ID: uuid.Generate().String(), SourceType: types.Empty, Ephemeral: true}, false},
+	}
+
+	for _, test := range tests {
+		wl := types.Workload{
+			TenantID: tenant.ID,
+			VMType:   payloads.Docker,
+			Storage:  []types.StorageResource{test.storage},
+		}
+
+		err = ctl.validateWorkloadStorage(&wl)
+		if test.valid && err != nil {
+			t.Errorf("Storage %v rejected: %v", test.storage, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Storage %v accepted", test.storage)
+		}
+	}
+}
+
 func createTestVolume(tenantID string, size int, t *testing.T) string {
 	req := api.RequestedVolume{
 		Size: size,
@@ -1380,6 +2262,127 @@ func TestCreateVolume(t *testing.T) {
 	}
 }
 
+func TestCreateVolumePool(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := payloads.ConfigureStorage{
+		Pools: []payloads.StoragePool{
+			{Name: "standard", CephPool: "rbd"},
+			{Name: "fast", CephPool: "ssd"},
+		},
+	}
+	err = ctl.initStoragePools(conf, func(string) storage.BlockDriver {
+		return &storage.NoopDriver{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctl.storagePools = nil
+		ctl.defaultPool = ""
+	}()
+
+	vol, err := ctl.CreateVolume(tenant.ID, api.RequestedVolume{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol.Pool != "standard" {
+		t.Fatalf("Expected volume in default pool, got %q", vol.Pool)
+	}
+
+	vol, err = ctl.CreateVolume(tenant.ID, api.RequestedVolume{Size: 1, Pool: "fast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctl.cephPool(vol.Pool) != "ssd" {
+		t.Fatalf("Expected volume in ssd ceph pool, got %q", vol.Pool)
+	}
+
+	_, err = ctl.CreateVolume(tenant.ID, api.RequestedVolume{Size: 1, Pool: "missing"})
+	if err != types.ErrStoragePoolNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrStoragePoolNotFound, err)
+	}
+
+	// volumes are copied within their ceph pool
+	_, err = ctl.CreateVolume(tenant.ID, api.RequestedVolume{SourceVolID: vol.ID})
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	pools, err := ctl.ListStoragePools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools.Pools) != 2 || pools.Pools[0].Name != "fast" || pools.Pools[0].Default ||
+		!pools.Pools[1].Default || pools.Pools[1].Capacity.TotalGiB == 0 {
+		t.Fatalf("Unexpected storage pools: %+v", pools)
+	}
+
+	err = ctl.initStoragePools(payloads.ConfigureStorage{Pools: conf.Pools, DefaultPool: "missing"},
+		func(string) storage.BlockDriver { return &storage.NoopDriver{} })
+	if err == nil {
+		t.Fatal("Expected error with missing default pool")
+	}
+}
+
+func TestPatchVolume(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := api.RequestedVolume{
+		Size:     1,
+		Metadata: map[string]string{"role": "db"},
+		Tags:     []string{"data"},
+	}
+
+	vol, err := ctl.CreateVolume(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := []byte(`{"name":"db-1","metadata":{"role":null,"tier":"back"},"tags":["data","ssd"]}`)
+	err = ctl.PatchVolume(tenant.ID, vol.ID, patch, types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err = ctl.ShowVolumeDetails(tenant.ID, vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Name != "db-1" || !reflect.DeepEqual(vol.Metadata, map[string]string{"tier": "back"}) ||
+		!reflect.DeepEqual(vol.Tags, []string{"data", "ssd"}) || vol.Size != 1 {
+		t.Fatalf("Volume not patched: %+v", vol)
+	}
+
+	err = ctl.PatchVolume(tenant.ID, vol.ID, []byte(`{"size":2}`), types.MergePatch)
+	if _, ok := err.(*types.PatchError); !ok {
+		t.Fatalf("Expected patch error, got %v", err)
+	}
+
+	err = ctl.PatchVolume(tenant.ID, vol.ID, []byte(`{"tags":["ssd","ssd"]}`), types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	err = ctl.PatchVolume("other-tenant", vol.ID, patch, types.MergePatch)
+	if err != api.ErrVolumeOwner {
+		t.Fatalf("Expected %v, got %v", api.ErrVolumeOwner, err)
+	}
+
+	req.Metadata = map[string]string{"": "empty"}
+	_, err = ctl.CreateVolume(tenant.ID, req)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
 func TestCreateImageVolume(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1408,6 +2411,62 @@ func TestCreateImageVolume(t *testing.T) {
 	}
 }
 
+func TestCloneImageVolume(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		State:      types.Active,
+		Name:       "parent",
+		Visibility: types.Private,
+	}
+
+	err = ctl.ds.AddImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := ctl.CreateVolume(tenant.ID, api.RequestedVolume{ImageRef: image.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.Parent != "" {
+		t.Fatalf("Full copy depends on image %s", copied.Parent)
+	}
+
+	clone, err := ctl.CreateVolume(tenant.ID, api.RequestedVolume{ImageRef: image.ID, Clone: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bd, err := ctl.ds.GetBlockDevice(clone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd.Parent != image.ID {
+		t.Fatalf("Expected parent %s, got %q", image.ID, bd.Parent)
+	}
+
+	err = ctl.DeleteImage(tenant.ID, image.ID)
+	if err != types.ErrImageInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrImageInUse, err)
+	}
+
+	err = ctl.DeleteVolume(tenant.ID, clone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteImage(tenant.ID, image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDeleteVolume(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1455,6 +2514,40 @@ func TestDeleteVolume(t *testing.T) {
 	}
 }
 
+func TestDeleteProtectedVolume(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	err = ctl.ProtectVolume(tenant.ID, volID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteVolume(tenant.ID, volID)
+	if err != types.ErrVolumeProtected {
+		t.Fatalf("Expected %v, got %v", types.ErrVolumeProtected, err)
+	}
+
+	err = ctl.DeleteTenant(tenant.ID)
+	if err != types.ErrVolumeProtected {
+		t.Fatalf("Expected %v, got %v", types.ErrVolumeProtected, err)
+	}
+
+	err = ctl.ProtectVolume(tenant.ID, volID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteVolume(tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestShowVolumeDetails(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1511,13 +2604,6 @@ func testAddPool(t *testing.T, name string, subnet *string, ips []string) {
 			t.Fatal("subnet id not created")
 		}
 
-		sub := types.ExternalSubnet{
-			ID:   pool.Subnets[0].ID,
-			CIDR: *subnet,
-		}
-
-		expected.Subnets = []types.ExternalSubnet{sub}
-
 		_, ipNet, err := net.ParseCIDR(*subnet)
 		if err != nil {
 			t.Fatal(err)
@@ -1526,6 +2612,14 @@ func testAddPool(t *testing.T, name string, subnet *string, ips []string) {
 		ones, bits := ipNet.Mask.Size()
 		expected.TotalIPs = (1 << uint32(bits-ones)) - 2
 		expected.Free = expected.TotalIPs
+
+		sub := types.ExternalSubnet{
+			ID:   pool.Subnets[0].ID,
+			CIDR: *subnet,
+			Free: expected.TotalIPs,
+		}
+
+		expected.Subnets = []types.ExternalSubnet{sub}
 	} else if len(ips) > 0 {
 		// not an easy way to check this, so we're going to
 		// do some manual tests
@@ -1842,7 +2936,7 @@ func TestMapAddress(t *testing.T) {
 		}
 	}
 
-	err = ctl.MapAddress(instances[0].TenantID, &poolName, instances[0].ID)
+	err = ctl.MapAddress(instances[0].TenantID, &poolName, nil, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1866,145 +2960,766 @@ func TestMapAddress(t *testing.T) {
 	}
 }
 
-func TestMapAddressNoPool(t *testing.T) {
+func TestMapSpecificAddress(t *testing.T) {
 	var reason payloads.StartFailureReason
 
 	client, instances := testStartWorkload(t, 1, false, reason)
 	defer client.Shutdown()
 
-	ips := []string{"10.10.0.2"}
-	poolName := "testmapnopool"
+	subnet := "10.10.8.0/29"
+	poolName := "testmapaddress"
 
-	testAddPool(t, poolName, nil, ips)
+	testAddPool(t, poolName, &subnet, nil)
 
-	err := ctl.MapAddress(instances[0].TenantID, nil, instances[0].ID)
+	address := "10.10.8.5"
+	err := ctl.MapAddress(instances[0].TenantID, nil, &address, instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools, err := ctl.ListPools()
+	m, err := ctl.ds.GetMappedIP(address)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	if m.InstanceID != instances[0].ID || m.PoolName != poolName {
+		t.Fatalf("Unexpected mapping %v", m)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "testmapnopool" {
-			if pool.Free != 0 {
-				fmt.Printf("%v", pool)
-				t.Fatal("Pool Free not decremented")
-			}
-		}
+	err = ctl.MapAddress(instances[0].TenantID, &poolName, &address, instances[0].ID)
+	if err != types.ErrAddressMapped {
+		t.Fatalf("Expected %v, got %v", types.ErrAddressMapped, err)
 	}
 
-	mappedIPs := ctl.ListMappedAddresses(&instances[0].TenantID)
-	if len(mappedIPs) != 1 {
-		t.Fatal("mapped IP not in list")
+	address = "10.10.8.7"
+	err = ctl.MapAddress(instances[0].TenantID, &poolName, &address, instances[0].ID)
+	if err != types.ErrInvalidPoolAddress {
+		t.Fatalf("Expected %v, got %v", types.ErrInvalidPoolAddress, err)
+	}
+
+	// leave no free pool behind for the tests mapping from any pool.
+	err = ctl.ds.UnMapExternalIP(m.ExternalIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = deletePool(poolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sendPublicIPEvent(t *testing.T, event ssntp.Event, m types.MappedIP) {
+	ipEvent := payloads.PublicIPEvent{
+		InstanceUUID: m.InstanceID,
+		PublicIP:     m.ExternalIP,
+		PrivateIP:    m.InternalIP,
+	}
+
+	var payload interface{}
+	if event == ssntp.PublicIPAssigned {
+		payload = payloads.EventPublicIPAssigned{AssignedIP: ipEvent}
+	} else {
+		payload = payloads.EventPublicIPUnassigned{UnassignedIP: ipEvent}
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.client.EventNotify(event, &ssntp.Frame{Payload: y})
+}
+
+func TestRemapExternalIP(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.9.1"}
+	poolName := "testremap"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(instances[0].TenantID, &poolName, nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pretend the instance was running on another node when mapped.
+	err = ctl.ds.UpdateMappedIPNode(ips[0], "old-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.ReleasePublicIP)
+
+	sendStatsCmd(client, t)
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.ReleasePublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ctl.ds.GetMappedIP(ips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the release must not remove the mapping but assign it again
+	serverCh = server.AddCmdChan(ssntp.AssignPublicIP)
+
+	sendPublicIPEvent(t, ssntp.PublicIPUnassigned, m)
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.AssignPublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendPublicIPEvent(t, ssntp.PublicIPAssigned, m)
+
+	m, err = ctl.ds.GetMappedIP(ips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.NodeID != client.UUID {
+		t.Fatalf("Expected mapping for node %s, got %s", client.UUID, m.NodeID)
+	}
+
+	if len(ctl.ds.GetStaleMappedIPs()) != 0 {
+		t.Fatal("Mapping still needs to be remapped")
+	}
+
+	err = ctl.ds.UnMapExternalIP(m.ExternalIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = deletePool(poolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteMappedInstance(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.10.1"}
+	poolName := "testdeletemapped"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(instances[0].TenantID, &poolName, nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ctl.ds.GetMappedIP(ips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendStatsCmd(client, t)
+
+	releaseCh := server.AddCmdChan(ssntp.ReleasePublicIP)
+	deleteCh := server.AddCmdChan(ssntp.DELETE)
+
+	err = ctl.deleteInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(releaseCh, ssntp.ReleasePublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(deleteCh, ssntp.DELETE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CNCI may confirm the release once the instance is gone.
+	err = ctl.ds.DeleteInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendPublicIPEvent(t, ssntp.PublicIPUnassigned, m)
+
+	_, err = ctl.ds.GetMappedIP(ips[0])
+	if err != types.ErrAddressNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAddressNotFound, err)
+	}
+
+	err = deletePool(poolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveMappedInstance(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.12.1"}
+	poolName := "testremovemapped"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(instances[0].TenantID, &poolName, nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ctl.ds.GetMappedIP(ips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the instance is gone without its external IP being unmapped.
+	ctl.client.RemoveInstance(instances[0].ID)
+
+	_, err = ctl.ds.GetMappedIP(ips[0])
+	if err != types.ErrAddressNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAddressNotFound, err)
+	}
+
+	pool, err := ctl.ShowPool(m.PoolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Free != 1 {
+		t.Fatalf("Expected pool to have 1 free IP, got %d", pool.Free)
+	}
+
+	entries, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMsg := fmt.Sprintf("Unmapped %s from deleted instance %s", ips[0], instances[0].ID)
+
+	found := false
+	for i := range entries {
+		if entries[i].Message == expectedMsg {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Error("Did not find unmap message in Log")
+	}
+
+	// a late release confirmation from the CNCI is ignored
+	sendPublicIPEvent(t, ssntp.PublicIPUnassigned, m)
+
+	err = deletePool(poolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainPool(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.11.1"}
+	poolName := "testdrain"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(instances[0].TenantID, &poolName, nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ctl.ds.GetMappedIP(ips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ctl.DryRun(types.DrainPoolOperation, m.PoolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Impact["mapped_ips"] != 1 {
+		t.Fatalf("Expected 1 mapped IP to be drained, got %d", res.Impact["mapped_ips"])
+	}
+
+	releaseCh := server.AddCmdChan(ssntp.ReleasePublicIP)
+
+	err = ctl.DrainPool(m.PoolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetCmdChanResult(releaseCh, ssntp.ReleasePublicIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendPublicIPEvent(t, ssntp.PublicIPUnassigned, m)
+
+	pool, err := ctl.ShowPool(m.PoolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Free != 1 {
+		t.Fatalf("Expected drained pool to have 1 free IP, got %d", pool.Free)
+	}
+
+	err = deletePool(poolName)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapAddressNoPool(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.0.2"}
+	poolName := "testmapnopool"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(instances[0].TenantID, nil, nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmapnopool" {
+			if pool.Free != 0 {
+				fmt.Printf("%v", pool)
+				t.Fatal("Pool Free not decremented")
+			}
+		}
+	}
+
+	mappedIPs := ctl.ListMappedAddresses(&instances[0].TenantID)
+	if len(mappedIPs) != 1 {
+		t.Fatal("mapped IP not in list")
+	}
+}
+
+func TestListTenants(t *testing.T) {
+	tenants, err := ctl.ds.GetAllTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := ctl.ListTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenant := range tenants {
+		var match bool
+
+		if tenant.ID == "public" {
+			continue
+		}
+
+		for _, s := range summary {
+			if s.ID != tenant.ID {
+				continue
+			}
+
+			if s.Name != tenant.Name {
+				t.Fatal("bad name")
+			}
+			match = true
+
+			break
+		}
+
+		if match == false {
+			t.Fatal("did not list all tenants")
+		}
+	}
+}
+
+func TestShowTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ctl.ShowTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Name != tenant.Name ||
+		config.SubnetBits != tenant.SubnetBits {
+		fmt.Printf("expect name %s, got %s\n", tenant.Name, config.Name)
+		fmt.Printf("expect bits %d, got %d\n", tenant.SubnetBits, config.SubnetBits)
+		t.Fatal("incorrect config returned")
+	}
+}
+
+func TestUpdateTenant(t *testing.T) {
+	tenant, err := addTestTenantNoCNCI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ctl.ShowTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldconfig := config
+
+	config.Name = "test1"
+	config.SubnetBits = 30
+
+	a, err := json.Marshal(oldconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merge, err := jsonpatch.CreateMergePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.PatchTenant(tenant.ID, merge, types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err = ctl.ShowTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Name != "test1" || config.SubnetBits != 30 {
+		t.Fatal("Tenant Update not successful")
+	}
+}
+
+func TestUpdateTenantNetworkPolicy(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []string{
+		`{"network_policy":{"mode":"deny_all","rules":[{"source":"172.16.0.0/24","destination":"172.16.1.0/24"}]}}`,
+		`{"network_policy":{"mode":"rules","rules":[{"source":"172.16.0.0","destination":"172.16.1.0/24"}]}}`,
+		`{"network_policy":{"mode":"block_some"}}`,
+	}
+	for _, patch := range invalid {
+		err = ctl.PatchTenant(tenant.ID, []byte(patch), types.MergePatch)
+		if err != types.ErrBadRequest {
+			t.Errorf("Expected %v for %s, got %v", types.ErrBadRequest, patch, err)
+		}
+	}
+
+	serverCh := server.AddCmdChan(ssntp.NetworkPolicy)
+
+	patch := `{"network_policy":{"mode":"rules","rules":[{"source":"172.16.0.0/24","destination":"172.16.1.0/24"}]}}`
+	err = ctl.PatchTenant(tenant.ID, []byte(patch), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.NetworkPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.TenantUUID != tenant.ID {
+		t.Fatalf("Expected policy for tenant %s, got %s", tenant.ID, result.TenantUUID)
+	}
+
+	config, err := ctl.ShowTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.NetworkPolicy.Mode != types.NetworkRules || len(config.NetworkPolicy.Rules) != 1 {
+		t.Fatalf("Network policy not updated: %+v", config.NetworkPolicy)
 	}
 }
 
-func TestListTenants(t *testing.T) {
-	tenants, err := ctl.ds.GetAllTenants()
+const testSSHKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMbOlyrEofjwLWAuHLkO+g5PvJkGbBu5onfROggJm/aI test"
+
+func TestTenantInstanceDefaults(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	summary, err := ctl.ListTenants()
+	err = ctl.PatchTenant(tenant.ID, []byte(`{"instance_defaults":{"ssh_key":"not a key"}}`), types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	patch := `{"instance_defaults":{"tags":["audited"],"ssh_key":"` + testSSHKey + `","forbid_public_workloads":true}}`
+	err = ctl.PatchTenant(tenant.ID, []byte(patch), types.MergePatch)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	for _, tenant := range tenants {
-		var match bool
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		if tenant.ID == "public" {
-			continue
+	var wl types.Workload
+	for _, w := range wls {
+		if w.TenantID == tenant.ID {
+			wl = w
+			break
 		}
+	}
 
-		for _, s := range summary {
-			if s.ID != tenant.ID {
-				continue
-			}
+	preview, err := ctl.PreviewLaunch(tenant.ID, wl.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			if s.Name != tenant.Name {
-				t.Fatal("bad name")
-			}
-			match = true
+	if !strings.Contains(preview.Config, testSSHKey) {
+		t.Fatalf("SSH key not injected in config:\n%s", preview.Config)
+	}
 
-			break
-		}
+	client, err := testutil.NewSsntpTestClientConnection("InstanceDefaults", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
 
-		if match == false {
-			t.Fatal("did not list all tenants")
-		}
+	clientCmdCh := client.AddCmdChan(ssntp.START)
+
+	w := types.WorkloadRequest{
+		WorkloadID: wl.ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+	}
+	instances, err := ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestShowTenant(t *testing.T) {
-	tenant, err := addTestTenant()
+	_, err = client.GetCmdChanResult(clientCmdCh, ssntp.START)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	config, err := ctl.ShowTenant(tenant.ID)
+	i, err := ctl.ds.GetInstance(instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if config.Name != tenant.Name ||
-		config.SubnetBits != tenant.SubnetBits {
-		fmt.Printf("expect name %s, got %s\n", tenant.Name, config.Name)
-		fmt.Printf("expect bits %d, got %d\n", tenant.SubnetBits, config.SubnetBits)
-		t.Fatal("incorrect config returned")
+	if !reflect.DeepEqual(i.Tags, []string{"audited"}) {
+		t.Fatalf("Unexpected instance tags %v", i.Tags)
 	}
-}
 
-func TestUpdateTenant(t *testing.T) {
-	tenant, err := addTestTenantNoCNCI()
+	public := wl
+	public.ID = uuid.Generate().String()
+	public.Visibility = types.Public
+	err = ctl.ds.AddWorkload(public)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() { _ = ctl.ds.DeleteWorkload(public.ID) }()
 
-	config, err := ctl.ShowTenant(tenant.ID)
+	w.WorkloadID = public.ID
+	_, err = ctl.startWorkload(w)
+	if err != types.ErrPublicWorkloadForbidden {
+		t.Fatalf("Expected %v, got %v", types.ErrPublicWorkloadForbidden, err)
+	}
+}
+
+func TestResubnetTenant(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	oldconfig := config
+	for _, bits := range []int{11, 24, 31} {
+		_, err = ctl.ResubnetTenant(tenant.ID, bits)
+		if err != types.ErrBadRequest {
+			t.Errorf("Expected %v for %d subnet bits, got %v", types.ErrBadRequest, bits, err)
+		}
+	}
 
-	config.Name = "test1"
-	config.SubnetBits = 30
+	_, err = ctl.ResubnetTenant(uuid.Generate().String(), 20)
+	if err == nil {
+		t.Error("Unknown tenant resubnetted")
+	}
 
-	a, err := json.Marshal(oldconfig)
+	_, err = ctl.GetTenantResubnet(tenant.ID)
+	if err != types.ErrTenantNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrTenantNotFound, err)
+	}
+
+	ctl.resubnetLock.Lock()
+	if ctl.resubnets == nil {
+		ctl.resubnets = make(map[string]*types.TenantResubnetStatus)
+	}
+	ctl.resubnets[tenant.ID] = &types.TenantResubnetStatus{
+		TenantID:   tenant.ID,
+		SubnetBits: 20,
+		State:      types.TenantResubnetRebuilding,
+	}
+	ctl.resubnetLock.Unlock()
+
+	_, err = ctl.ResubnetTenant(tenant.ID, 22)
+	if err != types.ErrTenantResubnetting {
+		t.Fatalf("Expected %v, got %v", types.ErrTenantResubnetting, err)
+	}
+
+	var server api.CreateServerRequest
+	server.Server.MaxInstances = 1
+	_, err = ctl.CreateServer(tenant.ID, server)
+	if err != types.ErrTenantResubnetting {
+		t.Fatalf("Expected %v, got %v", types.ErrTenantResubnetting, err)
+	}
+
+	status, err := ctl.GetTenantResubnet(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	b, err := json.Marshal(config)
+	if status.State != types.TenantResubnetRebuilding || status.SubnetBits != 20 {
+		t.Fatalf("Unexpected resubnet status %+v", status)
+	}
+
+	ctl.setResubnetState(tenant.ID, types.TenantResubnetFailed)
+	if ctl.tenantResubnetting(tenant.ID) {
+		t.Fatal("Failed resubnet still in progress")
+	}
+}
+
+func TestPatchWorkload(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	merge, err := jsonpatch.CreateMergePatch(a, b)
+	orig := types.Workload{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenant.ID,
+		Description: "patchWorkload",
+		VMType:      payloads.Docker,
+		ImageName:   "ubuntu:latest",
+		Config:      "#cloud-config",
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs: 2,
+			MemMB: 512,
+		},
+		Visibility: types.Private,
+	}
+
+	err = ctl.ds.AddWorkload(orig)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ctl.PatchTenant(tenant.ID, merge)
+	patch := []byte(`[{"op":"replace","path":"/description","value":"patched"},` +
+		`{"op":"replace","path":"/workload_requirements/MemMB","value":1024}]`)
+
+	err = ctl.PatchWorkload(tenant.ID, orig.ID, patch, types.JSONPatch)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	config, err = ctl.ShowTenant(tenant.ID)
+	wl, err := ctl.ShowWorkload(tenant.ID, orig.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if config.Name != "test1" || config.SubnetBits != 30 {
-		t.Fatal("Tenant Update not successful")
+	if wl.Description != "patched" || wl.Requirements.MemMB != 1024 {
+		t.Fatalf("Workload not patched: %+v", wl)
+	}
+
+	patch = []byte(`[{"op":"replace","path":"/id","value":"other"}]`)
+
+	err = ctl.PatchWorkload(tenant.ID, orig.ID, patch, types.JSONPatch)
+	if _, ok := err.(*types.PatchError); !ok {
+		t.Fatalf("Expected a patch error, got %v", err)
+	}
+
+	// containers do not have a config drive
+	patch = []byte(`{"static_network":true}`)
+
+	err = ctl.PatchWorkload(tenant.ID, orig.ID, patch, types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	// nor emulated devices
+	patch = []byte(`{"devices":{"NIC":"e1000"}}`)
+
+	err = ctl.PatchWorkload(tenant.ID, orig.ID, patch, types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	// nor CPU models
+	patch = []byte(`{"workload_requirements":{"CPUModel":"Skylake-Server"}}`)
+
+	err = ctl.PatchWorkload(tenant.ID, orig.ID, patch, types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
+func TestValidateCPURequirements(t *testing.T) {
+	tests := []struct {
+		req   payloads.WorkloadRequirements
+		valid bool
+	}{
+		{payloads.WorkloadRequirements{}, true},
+		{payloads.WorkloadRequirements{CPUModel: payloads.CPUModelHost}, true},
+		{payloads.WorkloadRequirements{
+			CPUModel:    "Skylake-Server",
+			CPUFeatures: []string{"+avx512f", "-sse4.1"},
+		}, true},
+		{payloads.WorkloadRequirements{CPUModel: "Skylake-Server,kvm=off"}, false},
+		{payloads.WorkloadRequirements{CPUFeatures: []string{"avx512f"}}, false},
+		{payloads.WorkloadRequirements{CPUFeatures: []string{"+avx,kvm=off"}}, false},
+	}
+
+	for _, test := range tests {
+		err := validateCPURequirements(&test.req)
+		if (err == nil) != test.valid {
+			t.Errorf("Unexpected result for %+v: %v", test.req, err)
+		}
 	}
 }
 
@@ -2030,6 +3745,75 @@ func TestCreateTenant(t *testing.T) {
 	}
 }
 
+func quotaValue(qds []types.QuotaDetails, name string) int {
+	for _, qd := range qds {
+		if qd.Name == name {
+			return qd.Value
+		}
+	}
+	return -1
+}
+
+func TestQuotaProfiles(t *testing.T) {
+	quotas := []types.QuotaDetails{{Name: "tenant-instances-quota", Value: 5}}
+
+	err := ctl.UpdateQuotaProfile("test-profile", quotas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := types.TenantConfig{
+		Name:         "quotaProfileTenant",
+		QuotaProfile: "test-profile",
+	}
+
+	ID := uuid.Generate().String()
+
+	_, err = ctl.CreateTenant(ID, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := quotaValue(ctl.ListQuotas(ID), "tenant-instances-quota"); v != 5 {
+		t.Fatalf("Expected instances quota 5, got %d", v)
+	}
+
+	quotas[0].Value = 7
+	err = ctl.UpdateQuotaProfile("test-profile", quotas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := quotaValue(ctl.ListQuotas(ID), "tenant-instances-quota"); v != 7 {
+		t.Fatalf("Profile update not cascaded: instances quota %d", v)
+	}
+
+	err = ctl.DeleteQuotaProfile("test-profile")
+	if err != types.ErrQuotaProfileInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrQuotaProfileInUse, err)
+	}
+
+	err = ctl.PatchTenant(ID, []byte(`{"quota_profile":"small"}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := quotaValue(ctl.ListQuotas(ID), "tenant-instances-quota"); v != 10 {
+		t.Fatalf("Expected instances quota 10, got %d", v)
+	}
+
+	err = ctl.DeleteQuotaProfile("test-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.QuotaProfile = "bogus"
+	_, err = ctl.CreateTenant(uuid.Generate().String(), config)
+	if err != types.ErrQuotaProfileNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrQuotaProfileNotFound, err)
+	}
+}
+
 func TestDeleteTenant(t *testing.T) {
 	config := types.TenantConfig{
 		Name:       "deleteTenant",
@@ -2049,6 +3833,54 @@ func TestDeleteTenant(t *testing.T) {
 	}
 }
 
+func TestConfirmDeleteTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTestVolume(tenant.ID, 20, t)
+
+	err = ctl.Confirm(types.DeleteTenantOperation, tenant.ID, "")
+	if err != types.ErrConfirmationRequired {
+		t.Fatalf("Expected %v, got %v", types.ErrConfirmationRequired, err)
+	}
+
+	res, err := ctl.DryRun(types.DeleteTenantOperation, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Token == "" || res.Impact["volumes"] != 1 {
+		t.Fatalf("Unexpected dry run result %v", res)
+	}
+
+	err = ctl.Confirm(types.DeleteTenantOperation, "other tenant", res.Token)
+	if err != types.ErrInvalidConfirmation {
+		t.Fatalf("Expected %v, got %v", types.ErrInvalidConfirmation, err)
+	}
+
+	res, err = ctl.DryRun(types.DeleteTenantOperation, tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.Confirm(types.DeleteTenantOperation, tenant.ID, res.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.Confirm(types.DeleteTenantOperation, tenant.ID, res.Token)
+	if err != types.ErrInvalidConfirmation {
+		t.Fatalf("Expected %v, got %v", types.ErrInvalidConfirmation, err)
+	}
+
+	_, err = ctl.DryRun(types.DeleteTenantOperation, uuid.Generate().String())
+	if err == nil {
+		t.Error("Deletion of unknown tenant dry run")
+	}
+}
+
 var ctl *controller
 var server *testutil.SsntpTestServer
 var wrappedClient *ssntpClientWrapper
@@ -2081,7 +3913,7 @@ func TestMain(m *testing.M) {
 	}
 
 	dsConfig := datastore.Config{
-		PersistentURI:     "file:memdb1?mode=memory&cache=shared",
+		DBBackend:         &datastore.MemoryDB{},
 		InitWorkloadsPath: *workloadsPath,
 	}
 
@@ -2096,13 +3928,13 @@ func TestMain(m *testing.M) {
 
 	ctl.qs.Init()
 
-	config := &ssntp.Config{
+	config := transportConfig{
 		URI:    "localhost",
-		CAcert: ssntp.DefaultCACert,
+		CACert: ssntp.DefaultCACert,
 		Cert:   ssntp.RoleToDefaultCertName(ssntp.Controller),
 	}
 
-	wrappedClient, err = newWrappedSSNTPClient(ctl, config)
+	wrappedClient, err = newWrappedSSNTPClient(ctl, newSSNTPTransport(config))
 	if err != nil {
 		os.Exit(1)
 	}
@@ -2130,3 +3962,96 @@ func TestMain(m *testing.M) {
 
 	os.Exit(code)
 }
+
+func TestTransferInstance(t *testing.T) {
+	from, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(from.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   from.ID,
+		Instances:  1,
+	}
+	instances, err := ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := instances[0]
+
+	// pending instances cannot be transferred
+	_, err = ctl.TransferInstance(i.ID, to.ID)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	i.StateLock.Lock()
+	i.State = payloads.Exited
+	i.StateLock.Unlock()
+
+	_, err = ctl.TransferInstance(i.ID, from.ID)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	_, err = ctl.TransferInstance(i.ID, uuid.Generate().String())
+	if err == nil {
+		t.Fatal("Instance transferred to unknown tenant")
+	}
+
+	_, err = ctl.GetInstanceTransfer(i.ID)
+	if err != types.ErrNoInstanceTransfer {
+		t.Fatalf("Expected %v, got %v", types.ErrNoInstanceTransfer, err)
+	}
+
+	ctl.qs.Update(to.ID, []types.QuotaDetails{{Name: "tenant-instances-quota", Value: 0}})
+	_, err = ctl.TransferInstance(i.ID, to.ID)
+	if err != types.ErrQuota {
+		t.Fatalf("Expected %v, got %v", types.ErrQuota, err)
+	}
+	ctl.qs.Update(to.ID, []types.QuotaDetails{{Name: "tenant-instances-quota", Value: -1}})
+
+	status, err := ctl.TransferInstance(i.ID, to.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.FromTenantID != from.ID || status.ToTenantID != to.ID {
+		t.Fatalf("Unexpected transfer status %+v", status)
+	}
+
+	for n := 0; n < 50 && transferInProgress(&status); n++ {
+		time.Sleep(100 * time.Millisecond)
+		status, err = ctl.GetInstanceTransfer(i.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status.State != types.InstanceTransferDone {
+		t.Fatalf("Transfer did not complete: %+v", status)
+	}
+
+	moved, err := ctl.ds.GetTenantInstance(to.ID, i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.IPAddress != status.IPAddress {
+		t.Fatalf("Expected address %s, got %s", status.IPAddress, moved.IPAddress)
+	}
+
+	_, err = ctl.ds.GetTenantInstance(from.ID, i.ID)
+	if err == nil {
+		t.Fatal("Transferred instance still in former tenant")
+	}
+}
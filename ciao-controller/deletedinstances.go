@@ -0,0 +1,61 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// ListDeletedInstances returns the records kept of the deleted instances of
+// a tenant, most recently deleted first.
+func (c *controller) ListDeletedInstances(tenantID string) ([]types.DeletedInstance, error) {
+	return c.ds.ListDeletedInstances(tenantID)
+}
+
+// PurgeDeletedInstances removes the records of the deleted instances of a
+// tenant.
+func (c *controller) PurgeDeletedInstances(tenantID string) error {
+	count, err := c.ds.PurgeDeletedInstances(tenantID)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Purged %d deleted instances of tenant %s", count, tenantID)
+	return nil
+}
+
+// pruneDeletedInstances removes the records of the deleted instances which
+// have outlived the deleted_instance_retention_days setting.  The records
+// are all kept while the setting is 0, as no new ones are made, until
+// purged.
+func (c *controller) pruneDeletedInstances(now time.Time) {
+	retention := c.ds.DeletedInstanceRetention()
+	if retention == 0 {
+		return
+	}
+
+	count, err := c.ds.PruneDeletedInstances(now.Add(-retention))
+	if err != nil {
+		glog.Warningf("Error pruning deleted instances: %v", err)
+		return
+	}
+
+	if count > 0 {
+		glog.Infof("Pruned %d instances deleted more than %v ago", count, retention)
+	}
+}
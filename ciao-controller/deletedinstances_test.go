@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func TestPruneDeletedInstances(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	err = ctl.ds.SetConfigSetting(datastore.DeletedInstanceRetentionDays, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.ds.SetConfigSetting(datastore.DeletedInstanceRetentionDays, "") }()
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+	}
+	instances, err := ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.ds.DeleteInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.pruneDeletedInstances(time.Now())
+
+	deleted, err := ctl.ListDeletedInstances(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0].ID != instances[0].ID {
+		t.Fatalf("Expected deleted instance %s, got %+v", instances[0].ID, deleted)
+	}
+
+	ctl.pruneDeletedInstances(time.Now().Add(48 * time.Hour))
+
+	deleted, err = ctl.ListDeletedInstances(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 0 {
+		t.Fatalf("Expected all deleted instances pruned, got %d", len(deleted))
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var instanceDiskWarning = flag.Int("instance_disk_warning", 90, "percentage of its provisioned disk above which an instance raises a disk usage warning, 0 to disable")
+var diskUsagePolicyFlag = flag.String("disk_usage_policy", "", "comma separated policies for instances using more disk than provisioned: stop_instances")
+
+// diskUsagePolicy selects what the controller does with the instances
+// using more disk than provisioned. Such instances are always logged.
+type diskUsagePolicy struct {
+	// stopInstances stops the running instances using more disk than
+	// provisioned.
+	stopInstances bool
+}
+
+// parseDiskUsagePolicy parses a comma separated list of disk usage
+// policies.
+func parseDiskUsagePolicy(s string) (diskUsagePolicy, error) {
+	var p diskUsagePolicy
+
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "", "none":
+		case "stop_instances":
+			p.stopInstances = true
+		default:
+			return p, fmt.Errorf("Unknown disk usage policy %q", v)
+		}
+	}
+
+	return p, nil
+}
+
+// diskAlert is the level of the disk usage of an instance.
+type diskAlert int
+
+const (
+	diskAlertNone diskAlert = iota
+	diskAlertWarning
+	diskAlertOverLimit
+)
+
+// instanceDiskUsage is the last disk usage reported for an instance.
+type instanceDiskUsage struct {
+	tenantID string
+	usageMB  int
+	alert    diskAlert
+	stopping bool
+}
+
+// instanceDiskAlert returns the level of the disk usage of an instance
+// against its provisioned disk, a warning percentage of 0 disabling
+// warnings.  Instances without provisioned disk are not limited.
+func instanceDiskAlert(usageMB int, provisionedMB int, warnPercent int) diskAlert {
+	if provisionedMB <= 0 {
+		return diskAlertNone
+	}
+
+	if usageMB > provisionedMB {
+		return diskAlertOverLimit
+	}
+
+	if warnPercent > 0 && usageMB*100 > provisionedMB*warnPercent {
+		return diskAlertWarning
+	}
+
+	return diskAlertNone
+}
+
+// provisionedDiskMB returns the size of the volumes attached to an
+// instance.
+func (c *controller) provisionedDiskMB(instanceID string) int {
+	size := 0
+	for _, a := range c.ds.GetStorageAttachments(instanceID) {
+		bd, err := c.ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			continue
+		}
+		size += bd.Size * 1024
+	}
+
+	return size
+}
+
+func (c *controller) logDiskEvent(tenantID string, severity types.EventSeverity, category types.EventCategory, msg string) {
+	if severity != types.EventInfo {
+		glog.Warning(msg)
+	}
+
+	err := c.ds.LogEvent(tenantID, severity, category, msg)
+	if err != nil {
+		glog.Warningf("Error logging disk usage alert: %v", err)
+	}
+}
+
+// checkDiskUsage compares the disk usage reported for the instances of a
+// node with their provisioned disk, and the disk usage of their tenants
+// with their storage quota.  As with node health alerts, events are
+// logged when alerts are raised and cleared, not on every sample.
+func (c *controller) checkDiskUsage(stat payloads.Stat) {
+	c.diskLock.Lock()
+	defer c.diskLock.Unlock()
+
+	if c.diskUsage == nil {
+		c.diskUsage = make(map[string]*instanceDiskUsage)
+		c.tenantDiskAlerts = make(map[string]bool)
+	}
+
+	tenants := make(map[string]bool)
+
+	for _, is := range stat.Instances {
+		if is.DiskUsageMB < 0 {
+			continue
+		}
+
+		i, err := c.ds.GetInstance(is.InstanceUUID)
+		if err != nil || i.CNCI {
+			continue
+		}
+
+		u, ok := c.diskUsage[i.ID]
+		if !ok {
+			u = &instanceDiskUsage{tenantID: i.TenantID}
+			c.diskUsage[i.ID] = u
+		}
+		u.usageMB = is.DiskUsageMB
+		tenants[i.TenantID] = true
+
+		provisioned := c.provisionedDiskMB(i.ID)
+		alert := instanceDiskAlert(u.usageMB, provisioned, *instanceDiskWarning)
+		if alert != u.alert {
+			switch alert {
+			case diskAlertWarning:
+				c.logDiskEvent(i.TenantID, types.EventWarning, types.EventCategoryInstance,
+					fmt.Sprintf("Instance %s uses %d MB of its %d MB of disk", i.ID, u.usageMB, provisioned))
+			case diskAlertOverLimit:
+				c.logDiskEvent(i.TenantID, types.EventCritical, types.EventCategoryInstance,
+					fmt.Sprintf("Instance %s uses %d MB, more than its %d MB of disk", i.ID, u.usageMB, provisioned))
+			default:
+				c.logDiskEvent(i.TenantID, types.EventInfo, types.EventCategoryInstance,
+					fmt.Sprintf("Instance %s disk usage back to %d MB", i.ID, u.usageMB))
+			}
+			u.alert = alert
+		}
+
+		if is.State != payloads.Running {
+			u.stopping = false
+			continue
+		}
+
+		if alert == diskAlertOverLimit && c.diskPolicy.stopInstances && !u.stopping {
+			glog.Warningf("Stopping instance %s using more disk than provisioned", i.ID)
+			if err := c.stopInstance(i.ID); err != nil {
+				glog.Warningf("Error stopping instance %s: %v", i.ID, err)
+				continue
+			}
+			u.stopping = true
+		}
+	}
+
+	for tenantID := range tenants {
+		c.checkTenantDiskUsage(tenantID)
+	}
+}
+
+// checkTenantDiskUsage compares the total disk usage of the instances of
+// a tenant with its storage quota.  It must be called with diskLock held.
+func (c *controller) checkTenantDiskUsage(tenantID string) {
+	total := 0
+	for ID, u := range c.diskUsage {
+		if u.tenantID != tenantID {
+			continue
+		}
+
+		// forget the instances deleted since they were reported
+		if _, err := c.ds.GetInstance(ID); err != nil {
+			delete(c.diskUsage, ID)
+			continue
+		}
+
+		total += u.usageMB
+	}
+
+	limit := -1
+	for _, q := range c.qs.DumpQuotas(tenantID) {
+		if q.Name == "tenant-storage-quota" {
+			limit = q.Value
+		}
+	}
+
+	over := limit > -1 && total > limit*1024
+	if over == c.tenantDiskAlerts[tenantID] {
+		return
+	}
+
+	if over {
+		c.logDiskEvent(tenantID, types.EventCritical, types.EventCategoryStorage,
+			fmt.Sprintf("Instances of tenant %s use %d MB of disk, over the %d GiB storage quota", tenantID, total, limit))
+		c.tenantDiskAlerts[tenantID] = true
+		return
+	}
+
+	c.logDiskEvent(tenantID, types.EventInfo, types.EventCategoryStorage,
+		fmt.Sprintf("Instances of tenant %s back within their storage quota", tenantID))
+	delete(c.tenantDiskAlerts, tenantID)
+}
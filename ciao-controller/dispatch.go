@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errLaunchCancelled is returned for the launches of a request which were
+// still waiting to be dispatched when the request was cancelled.
+var errLaunchCancelled = errors.New("Launch cancelled")
+
+// launchDispatcher paces the launches sent to the scheduler, so that large
+// requests do not flood SSNTP and the node agents.  A nil dispatcher does
+// not limit launches.
+type launchDispatcher struct {
+	slots  chan struct{}
+	ticker *time.Ticker
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// newLaunchDispatcher returns a dispatcher sending at most rate launches
+// per second, with at most concurrency launches being dispatched at once.
+// A value of 0 disables the corresponding limit.
+func newLaunchDispatcher(concurrency int, rate int) *launchDispatcher {
+	d := &launchDispatcher{
+		stop: make(chan struct{}),
+	}
+
+	if concurrency > 0 {
+		d.slots = make(chan struct{}, concurrency)
+	}
+
+	if rate > 0 {
+		d.ticker = time.NewTicker(time.Second / time.Duration(rate))
+	}
+
+	return d
+}
+
+// acquire waits until a launch can be dispatched.  It returns
+// errLaunchCancelled if cancel is closed or the dispatcher is shut down
+// before then.  A successful acquire must be followed by a release.
+func (d *launchDispatcher) acquire(cancel <-chan struct{}) error {
+	if d == nil {
+		select {
+		case <-cancel:
+			return errLaunchCancelled
+		default:
+			return nil
+		}
+	}
+
+	if d.slots != nil {
+		select {
+		case d.slots <- struct{}{}:
+		case <-cancel:
+			return errLaunchCancelled
+		case <-d.stop:
+			return errLaunchCancelled
+		}
+	}
+
+	if d.ticker != nil {
+		select {
+		case <-d.ticker.C:
+		case <-cancel:
+			d.release()
+			return errLaunchCancelled
+		case <-d.stop:
+			d.release()
+			return errLaunchCancelled
+		}
+	}
+
+	return nil
+}
+
+// release frees the slot taken by acquire once the launch is dispatched.
+func (d *launchDispatcher) release() {
+	if d != nil && d.slots != nil {
+		<-d.slots
+	}
+}
+
+// shutdown cancels all the launches waiting to be dispatched.
+func (d *launchDispatcher) shutdown() {
+	if d == nil {
+		return
+	}
+
+	d.once.Do(func() {
+		close(d.stop)
+		if d.ticker != nil {
+			d.ticker.Stop()
+		}
+	})
+}
+
+// launchBatch groups the launches of a single request.  They are all
+// cancelled once one of them cannot be sent to the scheduler, rather than
+// failing one after the other.
+type launchBatch struct {
+	cancelled chan struct{}
+	once      sync.Once
+}
+
+func newLaunchBatch() *launchBatch {
+	return &launchBatch{
+		cancelled: make(chan struct{}),
+	}
+}
+
+func (b *launchBatch) cancel() {
+	b.once.Do(func() { close(b.cancelled) })
+}
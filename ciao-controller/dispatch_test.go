@@ -0,0 +1,104 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLaunchDispatcherRate(t *testing.T) {
+	d := newLaunchDispatcher(0, 20)
+	defer d.shutdown()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := d.acquire(nil); err != nil {
+			t.Fatal(err)
+		}
+		d.release()
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("5 launches dispatched in %v at 20 launches per second", elapsed)
+	}
+}
+
+func TestLaunchDispatcherConcurrency(t *testing.T) {
+	d := newLaunchDispatcher(1, 0)
+	defer d.shutdown()
+
+	if err := d.acquire(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := newLaunchBatch()
+	acquired := make(chan error)
+	go func() {
+		acquired <- d.acquire(batch.cancelled)
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("Concurrency limit not enforced: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	batch.cancel()
+	if err := <-acquired; err != errLaunchCancelled {
+		t.Fatalf("Expected %v, got %v", errLaunchCancelled, err)
+	}
+
+	d.release()
+	if err := d.acquire(nil); err != nil {
+		t.Fatal(err)
+	}
+	d.release()
+}
+
+func TestLaunchDispatcherShutdown(t *testing.T) {
+	d := newLaunchDispatcher(1, 0)
+
+	if err := d.acquire(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- d.acquire(nil)
+	}()
+
+	d.shutdown()
+	if err := <-acquired; err != errLaunchCancelled {
+		t.Fatalf("Expected %v, got %v", errLaunchCancelled, err)
+	}
+}
+
+func TestNilLaunchDispatcher(t *testing.T) {
+	var d *launchDispatcher
+
+	if err := d.acquire(nil); err != nil {
+		t.Fatal(err)
+	}
+	d.release()
+
+	batch := newLaunchBatch()
+	batch.cancel()
+	if err := d.acquire(batch.cancelled); err != errLaunchCancelled {
+		t.Fatalf("Expected %v, got %v", errLaunchCancelled, err)
+	}
+
+	d.shutdown()
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// eventPruneInterval is the interval between two prunings of the events
+// older than the event_retention_days setting.
+const eventPruneInterval = time.Hour
+
+// pruneEvents removes the events which have outlived the retention of the
+// event log, if any.
+func (c *controller) pruneEvents(now time.Time) {
+	retention := c.ds.EventRetention()
+	if retention == 0 {
+		return
+	}
+
+	count, err := c.ds.PruneEventLog(now.Add(-retention))
+	if err != nil {
+		glog.Warningf("Error pruning event log: %v", err)
+		return
+	}
+
+	if count > 0 {
+		glog.Infof("Pruned %d events older than %v", count, retention)
+	}
+}
+
+// startEventPruner periodically prunes the event log and the records of
+// deleted instances, and rolls up the tenant usage histories, until
+// stopEventPruner is called.
+func (c *controller) startEventPruner() {
+	c.eventPruneStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(eventPruneInterval)
+		defer ticker.Stop()
+
+		c.pruneEvents(time.Now())
+		c.pruneDeletedInstances(time.Now())
+		c.rollUpTenantUsage(time.Now())
+
+		for {
+			select {
+			case now := <-ticker.C:
+				c.pruneEvents(now)
+				c.pruneDeletedInstances(now)
+				c.rollUpTenantUsage(now)
+			case <-c.eventPruneStop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopEventPruner() {
+	if c.eventPruneStop != nil {
+		close(c.eventPruneStop)
+		c.eventPruneStop = nil
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func TestPruneEvents(t *testing.T) {
+	err := ctl.ds.LogEvent("", types.EventInfo, types.EventCategoryNode, "event to prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// events are kept until cleared by default
+	ctl.pruneEvents(time.Now().Add(48 * time.Hour))
+
+	logs, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logs) == 0 {
+		t.Fatal("Events pruned without retention")
+	}
+
+	err = ctl.ds.SetConfigSetting(datastore.EventRetentionDays, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.ds.SetConfigSetting(datastore.EventRetentionDays, "") }()
+
+	ctl.pruneEvents(time.Now())
+
+	logs, err = ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logs) == 0 {
+		t.Fatal("Recent events pruned")
+	}
+
+	ctl.pruneEvents(time.Now().Add(48 * time.Hour))
+
+	logs, err = ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logs) != 0 {
+		t.Fatalf("Expected all events pruned, got %d", len(logs))
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
 )
 
 func (c *controller) makePoolLinks(pool *types.Pool) {
@@ -180,7 +181,7 @@ func (c *controller) ListMappedAddresses(tenant *string) []types.MappedIP {
 	return IPs
 }
 
-func (c *controller) MapAddress(tenantID string, poolName *string, instanceID string) (err error) {
+func (c *controller) MapAddress(tenantID string, poolName *string, address *string, instanceID string) (err error) {
 	var m types.MappedIP
 	var i *types.Instance
 
@@ -196,32 +197,48 @@ func (c *controller) MapAddress(tenantID string, poolName *string, instanceID st
 
 	// A matching release for this is in the client unAssignEvent
 	res := <-c.qs.Consume(i.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+	if !res.Allowed() {
+		return types.ErrQuota
+	}
+
 	defer func() {
 		if err != nil {
 			c.qs.Release(i.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
 		}
 	}()
 
-	if !res.Allowed() {
-		return types.ErrQuota
-	}
-
 	pools, err := c.ds.GetPools()
 	if err != nil {
 		return err
 	}
 
-	err = types.ErrPoolEmpty
+	if address != nil {
+		err = types.ErrInvalidPoolAddress
+
+		// the address decides the pool unless one was requested.
+		for _, pool := range pools {
+			if poolName != nil && pool.Name != *poolName {
+				continue
+			}
 
-	for _, pool := range pools {
-		if poolName != nil {
-			if pool.Name == *poolName {
+			m, err = c.ds.MapExternalIPAddress(pool.ID, *address, instanceID)
+			if err != types.ErrInvalidPoolAddress {
+				break
+			}
+		}
+	} else {
+		err = types.ErrPoolEmpty
+
+		for _, pool := range pools {
+			if poolName != nil {
+				if pool.Name == *poolName {
+					m, err = c.ds.MapExternalIP(pool.ID, instanceID)
+					break
+				}
+			} else if pool.Free > 0 {
 				m, err = c.ds.MapExternalIP(pool.ID, instanceID)
 				break
 			}
-		} else if pool.Free > 0 {
-			m, err = c.ds.MapExternalIP(pool.ID, instanceID)
-			break
 		}
 	}
 
@@ -260,3 +277,191 @@ func (c *controller) UnMapAddress(address string) error {
 
 	return c.client.unMapExternalIP(*t, m)
 }
+
+// UnMapInstanceAddresses unmaps all the external IPs mapped to the
+// instance. Only the mappings of tenant are considered if tenant is not
+// nil. The addresses return to their pools once the CNCI has released
+// them.
+func (c *controller) UnMapInstanceAddresses(tenant *string, instanceID string) error {
+	for _, m := range c.ds.GetMappedIPs(tenant) {
+		if m.InstanceID != instanceID {
+			continue
+		}
+
+		err := c.UnMapAddress(m.ExternalIP)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseInstanceExternalIPs returns the external IPs still mapped to an
+// instance which is being removed to their pools. The CNCI may confirm
+// the release of these addresses after the instance is gone.
+func (c *controller) releaseInstanceExternalIPs(i *types.Instance) {
+	for _, m := range c.ds.GetMappedIPs(&i.TenantID) {
+		if m.InstanceID != i.ID {
+			continue
+		}
+
+		err := c.ds.UnMapExternalIP(m.ExternalIP)
+		if err != nil {
+			glog.Warningf("Error unmapping external IP of deleted instance: %v", err)
+			continue
+		}
+
+		c.qs.Release(m.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+
+		msg := fmt.Sprintf("Unmapped %s from deleted instance %s", m.ExternalIP, i.ID)
+		err = c.ds.LogEvent(m.TenantID, types.EventInfo, types.EventCategoryNetwork, msg)
+		if err != nil {
+			glog.Warningf("Error logging event: %v", err)
+		}
+	}
+}
+
+// DrainPool unmaps all the mapped addresses of the pool, leaving the
+// pool itself in place.
+func (c *controller) DrainPool(poolID string) error {
+	_, err := c.ds.GetPool(poolID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range c.ds.GetMappedIPs(nil) {
+		if m.PoolID != poolID {
+			continue
+		}
+
+		err = c.UnMapAddress(m.ExternalIP)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remapExternalIPs reprograms the CNCI for the mapped external IPs whose
+// instance now runs on another node than the one the mapping was made
+// for. A remap releases the address and assigns it again once the CNCI
+// has confirmed the release, see remapUnassigned and remapAssigned.
+func (c *controller) remapExternalIPs() {
+	for _, m := range c.ds.GetStaleMappedIPs() {
+		i, err := c.ds.GetInstance(m.InstanceID)
+		if err != nil {
+			continue
+		}
+
+		// mappings made before the instance reported a node, or
+		// by an older controller, only need to learn the node.
+		if m.NodeID == "" {
+			err = c.ds.UpdateMappedIPNode(m.ExternalIP, i.NodeID)
+			if err != nil {
+				glog.Warningf("Error updating node of mapping %s: %v", m.ExternalIP, err)
+			}
+			continue
+		}
+
+		err = c.remapAddress(m, i.NodeID)
+		if err != nil {
+			glog.Warningf("Error remapping %s to %s: %v", m.ExternalIP, m.InstanceID, err)
+		}
+	}
+}
+
+func (c *controller) remapAddress(m types.MappedIP, nodeID string) error {
+	c.remapLock.Lock()
+	if c.remaps == nil {
+		c.remaps = make(map[string]string)
+	}
+
+	if _, ok := c.remaps[m.ExternalIP]; ok {
+		c.remapLock.Unlock()
+		return nil
+	}
+	c.remaps[m.ExternalIP] = nodeID
+	c.remapLock.Unlock()
+
+	glog.Infof("Remapping %s as instance %s moved from node %s to %s", m.ExternalIP, m.InstanceID, m.NodeID, nodeID)
+
+	t, err := c.ds.GetTenant(m.TenantID)
+	if err == nil {
+		err = c.client.unMapExternalIP(*t, m)
+	}
+
+	if err != nil {
+		c.endRemap(m.ExternalIP)
+	}
+
+	return err
+}
+
+// endRemap returns the node an address was being remapped for, if any.
+func (c *controller) endRemap(address string) (string, bool) {
+	c.remapLock.Lock()
+	defer c.remapLock.Unlock()
+
+	nodeID, ok := c.remaps[address]
+	delete(c.remaps, address)
+
+	return nodeID, ok
+}
+
+func (c *controller) isRemapping(address string) bool {
+	c.remapLock.Lock()
+	defer c.remapLock.Unlock()
+
+	_, ok := c.remaps[address]
+	return ok
+}
+
+// remapUnassigned assigns the address again once the CNCI released it,
+// or failed to, as part of a remap. It returns false if the address was
+// not being remapped.
+func (c *controller) remapUnassigned(address string) bool {
+	if !c.isRemapping(address) {
+		return false
+	}
+
+	m, err := c.ds.GetMappedIP(address)
+	if err != nil {
+		c.endRemap(address)
+		return true
+	}
+
+	t, err := c.ds.GetTenant(m.TenantID)
+	if err == nil {
+		err = c.client.mapExternalIP(*t, m)
+	}
+
+	if err != nil {
+		glog.Warningf("Error remapping %s: %v", address, err)
+		c.endRemap(address)
+	}
+
+	return true
+}
+
+// remapAssigned completes a remap once the CNCI assigned the address
+// again, or failed to. The mapping is kept on failure so that the remap
+// is retried. It returns false if the address was not being remapped.
+func (c *controller) remapAssigned(address string, failed bool) bool {
+	nodeID, ok := c.endRemap(address)
+	if !ok {
+		return false
+	}
+
+	if failed {
+		return true
+	}
+
+	err := c.ds.UpdateMappedIPNode(address, nodeID)
+	if err != nil {
+		glog.Warningf("Error updating node of mapping %s: %v", address, err)
+	}
+
+	return true
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// faultInjector decides which frames and launches fail when the
+// controller runs with -fault_injection. All the faults are disabled
+// until configured through the API.
+type faultInjector struct {
+	lock   sync.Mutex
+	config types.FaultConfig
+	rand   *rand.Rand
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// hit returns true for percent percents of its calls. A nil injector
+// never injects faults.
+func (f *faultInjector) hit(percent func(types.FaultConfig) int) bool {
+	if f == nil {
+		return false
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	p := percent(f.config)
+	return p > 0 && f.rand.Intn(100) < p
+}
+
+func (f *faultInjector) dropFrame() bool {
+	return f.hit(func(c types.FaultConfig) int { return c.FrameDropPercent })
+}
+
+func (f *faultInjector) failLaunch() bool {
+	return f.hit(func(c types.FaultConfig) int { return c.LaunchFailurePercent })
+}
+
+func validPercent(p int) bool {
+	return p >= 0 && p <= 100
+}
+
+// GetFaults returns the faults currently injected.
+func (c *controller) GetFaults() (types.FaultConfig, error) {
+	if c.faults == nil {
+		return types.FaultConfig{}, types.ErrFaultInjectionDisabled
+	}
+
+	c.faults.lock.Lock()
+	defer c.faults.lock.Unlock()
+
+	return c.faults.config, nil
+}
+
+// UpdateFaults replaces the faults injected by the controller.
+func (c *controller) UpdateFaults(faults types.FaultConfig) error {
+	if c.faults == nil {
+		return types.ErrFaultInjectionDisabled
+	}
+
+	if !validPercent(faults.FrameDropPercent) ||
+		!validPercent(faults.LaunchFailurePercent) ||
+		faults.WriteDelayMS < 0 {
+		return types.ErrBadRequest
+	}
+
+	c.faults.lock.Lock()
+	c.faults.config = faults
+	c.faults.lock.Unlock()
+
+	c.ds.SetWriteDelay(time.Duration(faults.WriteDelayMS) * time.Millisecond)
+
+	glog.Warningf("Injecting faults: %+v", faults)
+
+	return nil
+}
+
+// injectStartFailure fails the launch of an instance as if the launcher
+// had reported a StartFailure.
+func (c *controller) injectStartFailure(instanceID string) {
+	failure := payloads.ErrorStartFailure{
+		InstanceUUID: instanceID,
+		Reason:       payloads.LaunchFailure,
+	}
+
+	payload, err := yaml.Marshal(&failure)
+	if err != nil {
+		glog.Warningf("Error marshalling StartFailure: %v", err)
+		return
+	}
+
+	glog.Warningf("Injecting StartFailure for instance %s", instanceID)
+
+	c.client.ErrorNotify(ssntp.StartFailure, &ssntp.Frame{Payload: payload})
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func TestUpdateFaults(t *testing.T) {
+	_, err := ctl.GetFaults()
+	if err != types.ErrFaultInjectionDisabled {
+		t.Fatalf("Expected %v, got %v", types.ErrFaultInjectionDisabled, err)
+	}
+
+	ctl.faults = newFaultInjector()
+	defer func() { ctl.faults = nil }()
+
+	invalid := []types.FaultConfig{
+		{FrameDropPercent: -1},
+		{FrameDropPercent: 101},
+		{LaunchFailurePercent: 200},
+		{WriteDelayMS: -10},
+	}
+
+	for _, faults := range invalid {
+		if err := ctl.UpdateFaults(faults); err != types.ErrBadRequest {
+			t.Errorf("Expected %v for %+v, got %v", types.ErrBadRequest, faults, err)
+		}
+	}
+
+	faults := types.FaultConfig{
+		FrameDropPercent:     10,
+		LaunchFailurePercent: 20,
+	}
+
+	err = ctl.UpdateFaults(faults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := ctl.GetFaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current != faults {
+		t.Fatalf("Expected %+v, got %+v", faults, current)
+	}
+}
+
+func TestInjectLaunchFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.faults = newFaultInjector()
+	defer func() { ctl.faults = nil }()
+
+	err = ctl.UpdateFaults(types.FaultConfig{LaunchFailurePercent: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := types.WorkloadRequest{
+		WorkloadID: wls[0].ID,
+		TenantID:   tenant.ID,
+		Instances:  1,
+	}
+
+	instances, err := ctl.startWorkload(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		_, err = ctl.ds.GetInstance(instances[0].ID)
+		if err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("Instance not removed after an injected launch failure")
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// HotAddServer adds vCPUs and memory to a running instance, within the
+// maximums of its workload.  The resources are charged to the tenant quota
+// and recorded against the instance before the command is sent to its node,
+// and are given back if the node fails to add them.
+func (c *controller) HotAddServer(tenant string, ID string, req api.HotAddRequest) error {
+	if req.VCPUs < 0 || req.MemMB < 0 || (req.VCPUs == 0 && req.MemMB == 0) ||
+		req.MemMB%hotAddMemAlignMB != 0 {
+		return types.ErrBadRequest
+	}
+
+	c.hotAddLock.Lock()
+	defer c.hotAddLock.Unlock()
+
+	i, err := c.ds.GetTenantInstance(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	if i.CNCI {
+		return types.ErrHotAddNotSupported
+	}
+
+	if i.State != payloads.ComputeStatusRunning {
+		return types.ErrInstanceNotRunning
+	}
+
+	if i.NodeID == "" {
+		return types.ErrInstanceNotAssigned
+	}
+
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	r := wl.Requirements
+	if req.VCPUs > 0 && r.VCPUs+i.ExtraVCPUs+req.VCPUs > r.MaxVCPUs {
+		return types.ErrHotAddNotSupported
+	}
+
+	if req.MemMB > 0 && r.MemMB+i.ExtraMemMB+req.MemMB > r.MaxMemMB {
+		return types.ErrHotAddNotSupported
+	}
+
+	resources := []payloads.RequestedResource{
+		{Type: payloads.VCPUs, Value: req.VCPUs},
+		{Type: payloads.MemMB, Value: req.MemMB},
+	}
+	res := <-c.qs.Consume(tenant, resources...)
+	if !res.Allowed() {
+		return types.ErrQuota
+	}
+
+	err = c.ds.HotAddInstanceResources(i.ID, req.VCPUs, req.MemMB)
+	if err != nil {
+		c.qs.Release(tenant, resources...)
+		return errors.Wrap(err, "Error updating instance resources")
+	}
+
+	err = c.client.hotAdd(i.ID, i.NodeID, req.VCPUs, req.MemMB)
+	if err != nil {
+		_ = c.ds.HotAddInstanceResources(i.ID, -req.VCPUs, -req.MemMB)
+		c.qs.Release(tenant, resources...)
+		return errors.Wrap(err, "Error sending hot add command")
+	}
+
+	return nil
+}
+
+// hotAddFailed gives back the resources a node failed to add to an instance.
+func (c *controller) hotAddFailed(failure payloads.ErrorHotAddFailure) {
+	i, err := c.ds.GetInstance(failure.InstanceUUID)
+	if err != nil {
+		glog.Warningf("Hot add failed for unknown instance %s", failure.InstanceUUID)
+		return
+	}
+
+	if failure.VCPUs != 0 || failure.MemMB != 0 {
+		err = c.ds.HotAddInstanceResources(i.ID, -failure.VCPUs, -failure.MemMB)
+		if err != nil {
+			glog.Warningf("Unable to revert resources of %s: %v", i.ID, err)
+		} else {
+			c.qs.Release(i.TenantID,
+				payloads.RequestedResource{Type: payloads.VCPUs, Value: failure.VCPUs},
+				payloads.RequestedResource{Type: payloads.MemMB, Value: failure.MemMB})
+		}
+	}
+
+	msg := fmt.Sprintf("Hot add to instance %s failed: %s", i.ID, failure.Reason)
+	glog.Warning(msg)
+	_ = c.ds.LogEvent(i.TenantID, types.EventError, types.EventCategoryInstance, msg)
+}
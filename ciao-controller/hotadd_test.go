@@ -0,0 +1,158 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func addHotAddInstance(t *testing.T, maxVCPUs int, maxMemMB int) *types.Instance {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	wl := wls[0]
+	wl.ID = uuid.Generate().String()
+	wl.Requirements.MaxVCPUs = maxVCPUs
+	wl.Requirements.MaxMemMB = maxMemMB
+	err = ctl.ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: wl.ID,
+		State:      payloads.ComputeStatusRunning,
+		NodeID:     uuid.Generate().String(),
+		ID:         uuid.Generate().String(),
+	}
+	err = ctl.ds.AddInstance(&instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &instance
+}
+
+func TestHotAddServerInvalid(t *testing.T) {
+	instance := addHotAddInstance(t, 4, 2048)
+
+	wl, err := ctl.ds.GetWorkload(instance.WorkloadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeVCPUs := 4 - wl.Requirements.VCPUs
+
+	tests := []struct {
+		req api.HotAddRequest
+		err error
+	}{
+		{api.HotAddRequest{}, types.ErrBadRequest},
+		{api.HotAddRequest{VCPUs: -1}, types.ErrBadRequest},
+		{api.HotAddRequest{MemMB: 100}, types.ErrBadRequest},
+		{api.HotAddRequest{VCPUs: freeVCPUs + 1}, types.ErrHotAddNotSupported},
+		{api.HotAddRequest{MemMB: 4096}, types.ErrHotAddNotSupported},
+	}
+
+	for _, test := range tests {
+		err := ctl.HotAddServer(instance.TenantID, instance.ID, test.req)
+		if err != test.err {
+			t.Errorf("Expected %v for %+v, got %v", test.err, test.req, err)
+		}
+	}
+
+	err = ctl.HotAddServer(instance.TenantID, "badID", api.HotAddRequest{VCPUs: 1})
+	if err != types.ErrInstanceNotFound {
+		t.Errorf("Expected %v, got %v", types.ErrInstanceNotFound, err)
+	}
+
+	stopped := addHotAddInstance(t, 4, 2048)
+	stopped.State = payloads.ComputeStatusStopped
+	err = ctl.ds.UpdateInstance(stopped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.HotAddServer(stopped.TenantID, stopped.ID, api.HotAddRequest{VCPUs: 1})
+	if err != types.ErrInstanceNotRunning {
+		t.Errorf("Expected %v, got %v", types.ErrInstanceNotRunning, err)
+	}
+}
+
+func TestHotAddServer(t *testing.T) {
+	instance := addHotAddInstance(t, 8, 4096)
+
+	err := ctl.HotAddServer(instance.TenantID, instance.ID, api.HotAddRequest{VCPUs: 2, MemMB: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ctl.ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.ExtraVCPUs != 2 || i.ExtraMemMB != 512 {
+		t.Fatalf("Unexpected hot added resources: %d vCPUs %d MB", i.ExtraVCPUs, i.ExtraMemMB)
+	}
+
+	ctl.hotAddFailed(payloads.ErrorHotAddFailure{
+		InstanceUUID: instance.ID,
+		VCPUs:        2,
+		Reason:       payloads.HotAddHotAddFailure,
+	})
+
+	i, err = ctl.ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.ExtraVCPUs != 0 || i.ExtraMemMB != 512 {
+		t.Fatalf("Unexpected resources after failure: %d vCPUs %d MB", i.ExtraVCPUs, i.ExtraMemMB)
+	}
+}
+
+func TestValidateHotAddRequirements(t *testing.T) {
+	tests := []struct {
+		req   payloads.WorkloadRequirements
+		valid bool
+	}{
+		{payloads.WorkloadRequirements{VCPUs: 2, MemMB: 512}, true},
+		{payloads.WorkloadRequirements{VCPUs: 2, MemMB: 512, MaxVCPUs: 4, MaxMemMB: 1024}, true},
+		{payloads.WorkloadRequirements{VCPUs: 2, MemMB: 512, MaxVCPUs: 1}, false},
+		{payloads.WorkloadRequirements{VCPUs: 2, MemMB: 512, MaxMemMB: 256}, false},
+		{payloads.WorkloadRequirements{VCPUs: 2, MemMB: 512, MaxMemMB: 600}, false},
+		{payloads.WorkloadRequirements{VCPUs: 2, MemMB: 512, MaxVCPUs: -1}, false},
+	}
+
+	for _, test := range tests {
+		err := validateHotAddRequirements(&test.req)
+		if (err == nil) != test.valid {
+			t.Errorf("Unexpected result for %+v: %v", test.req, err)
+		}
+	}
+}
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -24,6 +26,7 @@ import (
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
@@ -50,6 +53,16 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 		return types.Image{}, types.ErrBadName
 	}
 
+	if len(req.Signature) > 0 || req.Signer != "" {
+		if len(req.Signature) == 0 {
+			return types.Image{}, types.ErrBadRequest
+		}
+
+		if _, err := imageSigner(req.Signer); err != nil {
+			return types.Image{}, err
+		}
+	}
+
 	i := types.Image{
 		ID:         id,
 		TenantID:   tenantID,
@@ -57,6 +70,8 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 		Name:       req.Name,
 		CreateTime: time.Now(),
 		Visibility: req.Visibility,
+		Signature:  req.Signature,
+		Signer:     req.Signer,
 	}
 
 	err := c.ds.AddImage(i)
@@ -68,7 +83,6 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 	res := <-c.qs.Consume(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
 	if !res.Allowed() {
 		_ = c.ds.DeleteImage(id)
-		c.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
 		return types.Image{}, api.ErrQuota
 	}
 
@@ -76,6 +90,108 @@ func (c *controller) CreateImage(tenantID string, req api.CreateImageRequest) (t
 	return i, nil
 }
 
+func (c *controller) snapshotVolume(volumeID string) (string, uint64, error) {
+	bd, err := c.CopyBlockDevice(volumeID)
+	if err != nil {
+		return "", 0, fmt.Errorf("Error copying block device: %v", err)
+	}
+
+	err = c.CreateBlockDeviceSnapshot(bd.ID, "ciao-image")
+	if err != nil {
+		_ = c.DeleteBlockDevice(bd.ID)
+		return "", 0, fmt.Errorf("Unable to create snapshot: %v", err)
+	}
+
+	size, err := c.GetBlockDeviceSize(bd.ID)
+	if err != nil {
+		_ = c.DeleteBlockDeviceSnapshot(bd.ID, "ciao-image")
+		_ = c.DeleteBlockDevice(bd.ID)
+		return "", 0, fmt.Errorf("Error getting block device size: %v", err)
+	}
+
+	return bd.ID, size, nil
+}
+
+// CreateInstanceImage will create an image from the boot volume of an
+// instance. A running instance is stopped while its boot volume is copied
+// and restarted afterwards.
+func (c *controller) CreateInstanceImage(tenantID string, instanceID string, req api.CreateImageRequest) (types.Image, error) {
+	glog.Infof("Creating image from instance: %v", instanceID)
+
+	i, err := c.ds.GetTenantInstance(tenantID, instanceID)
+	if err != nil {
+		return types.Image{}, err
+	}
+
+	r := regexp.MustCompile("^[a-z0-9-.]{1,64}$")
+	if !r.MatchString(req.Name) {
+		return types.Image{}, types.ErrBadName
+	}
+
+	var volumeID string
+	for _, a := range c.ds.GetStorageAttachments(instanceID) {
+		if a.Boot {
+			volumeID = a.BlockID
+			break
+		}
+	}
+
+	if volumeID == "" {
+		return types.Image{}, types.ErrNoBootVolume
+	}
+
+	res := <-c.qs.Consume(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
+	if !res.Allowed() {
+		return types.Image{}, api.ErrQuota
+	}
+
+	running := instanceActive(i)
+	if running {
+		err = c.stopInstanceSync(instanceID)
+		if err != nil {
+			c.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
+			return types.Image{}, fmt.Errorf("Error stopping instance: %v", err)
+		}
+
+		defer func() {
+			if err := c.restartInstance(instanceID); err != nil {
+				glog.Warningf("Error restarting instance %s: %v", instanceID, err)
+			}
+		}()
+	}
+
+	id, size, err := c.snapshotVolume(volumeID)
+	if err != nil {
+		glog.Errorf("Error creating image from instance: %v", err)
+		c.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
+		return types.Image{}, api.ErrImageSaving
+	}
+
+	image := types.Image{
+		ID:               id,
+		TenantID:         tenantID,
+		State:            types.Active,
+		Name:             req.Name,
+		CreateTime:       time.Now(),
+		Size:             size,
+		Visibility:       req.Visibility,
+		SourceInstanceID: instanceID,
+		SourceVolumeID:   volumeID,
+	}
+
+	err = c.ds.AddImage(image)
+	if err != nil {
+		glog.Errorf("Error adding image to datastore: %v", err)
+		_ = c.DeleteBlockDeviceSnapshot(id, "ciao-image")
+		_ = c.DeleteBlockDevice(id)
+		c.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
+		return types.Image{}, err
+	}
+
+	glog.Infof("Image %v created from instance %v", id, instanceID)
+	return image, nil
+}
+
 // ListImages will return a list of all the images in the datastore.
 func (c *controller) ListImages(tenant string) ([]types.Image, error) {
 	glog.Infof("Listing images from [%v]", tenant)
@@ -87,15 +203,21 @@ func (c *controller) ListImages(tenant string) ([]types.Image, error) {
 	return c.ds.GetImages(tenant, false)
 }
 
-func (c *controller) uploadImage(imageID string, body io.Reader) error {
+// uploadImage creates the block device of an image from its data, after
+// checking the signature of signed images. The digest of the data is
+// recorded in image.
+func (c *controller) uploadImage(image *types.Image, body io.Reader) error {
+	imageID := image.ID
+
 	f, err := ioutil.TempFile("", "ciao-image")
 	if err != nil {
 		return fmt.Errorf("Error creating temporary image file: %v", err)
 	}
 	defer func() { _ = os.Remove(f.Name()) }()
 
+	h := sha256.New()
 	buf := make([]byte, 1<<16)
-	_, err = io.CopyBuffer(f, body, buf)
+	_, err = io.CopyBuffer(io.MultiWriter(f, h), body, buf)
 	if err != nil {
 		_ = f.Close()
 		return fmt.Errorf("Error writing to temporary image file: %v", err)
@@ -106,6 +228,15 @@ func (c *controller) uploadImage(imageID string, body io.Reader) error {
 		return fmt.Errorf("Error closing temporary image file: %v", err)
 	}
 
+	digest := h.Sum(nil)
+	if len(image.Signature) > 0 {
+		err = verifyImageSignature(image.Signer, digest, image.Signature)
+		if err != nil {
+			return err
+		}
+	}
+	image.Digest = hex.EncodeToString(digest)
+
 	_, err = c.CreateBlockDevice(imageID, f.Name(), 0)
 	if err != nil {
 		return fmt.Errorf("Error creating block device: %v", err)
@@ -139,11 +270,14 @@ func (c *controller) UploadImage(tenantID, imageID string, body io.Reader) error
 		return err
 	}
 
-	err = c.uploadImage(imageID, body)
+	err = c.uploadImage(&image, body)
 	if err != nil {
 		glog.Errorf("Error uploading image: %v", err)
 		image.State = types.Killed
 		_ = c.ds.UpdateImage(image)
+		if err == types.ErrUntrustedSigner || err == types.ErrImageSignature {
+			return err
+		}
 		return api.ErrImageSaving
 	}
 
@@ -180,6 +314,18 @@ func (c *controller) DeleteImage(tenantID, imageID string) error {
 		return api.ErrNoImage
 	}
 
+	// the snapshot of the image cannot be removed while volumes
+	// cloned from it exist.
+	for _, v := range c.ds.GetAllBlockDevices() {
+		if v.Parent == imageID {
+			return types.ErrImageInUse
+		}
+	}
+
+	if c.imageExporting(imageID) {
+		return types.ErrImageInUse
+	}
+
 	err = c.ds.DeleteImage(imageID)
 	if err != nil {
 		return err
@@ -201,6 +347,96 @@ func (c *controller) DeleteImage(tenantID, imageID string) error {
 	return nil
 }
 
+// PatchImage updates the metadata of an image with a json merge patch or
+// a list of json patch operations. Only the name of an image can be
+// changed.
+func (c *controller) PatchImage(tenantID, imageID string, patch []byte, format types.PatchFormat) error {
+	image, err := c.ds.GetImage(imageID)
+	if err != nil {
+		return err
+	}
+
+	if tenantID != "admin" && image.TenantID != tenantID {
+		return api.ErrNoImage
+	}
+
+	var patched types.Image
+	err = utils.ApplyPatch(image, &patched, patch, format, "id", "state", "tenant_id",
+		"create_time", "size", "visibility", "source_instance_id", "source_volume_id")
+	if err != nil {
+		return err
+	}
+
+	return c.ds.UpdateImage(patched)
+}
+
+// deactivateImage moves an image whose data is corrupted to the error
+// state, preventing new instances from being launched from it.
+func (c *controller) deactivateImage(imageID string, reason string) error {
+	image, err := c.ds.GetImage(imageID)
+	if err != nil {
+		return err
+	}
+
+	if image.State == types.ImageError {
+		return nil
+	}
+
+	glog.Warningf("Deactivating corrupted image %s: %s", imageID, reason)
+
+	image.State = types.ImageError
+	err = c.ds.UpdateImage(image)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Image %s (%s) deactivated: %s", image.Name, imageID, reason)
+	return c.ds.LogEvent(image.TenantID, types.EventError, types.EventCategoryStorage, msg)
+}
+
+// instanceImageCorrupted deactivates the image the boot volume of an
+// instance was created from.
+func (c *controller) instanceImageCorrupted(instance *types.Instance, reason string) error {
+	wl, err := c.ds.GetWorkload(instance.WorkloadID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range wl.Storage {
+		if s.Bootable && s.SourceType == types.ImageService {
+			return c.deactivateImage(s.Source, reason)
+		}
+	}
+
+	return nil
+}
+
+// checkImageUsable returns an error if instances of a tenant cannot be
+// launched from an image: the image is corrupted, its signature is no
+// longer valid or the tenant requires signed images and the image is not
+// signed. Unknown images are left for the storage backend to reject.
+func (c *controller) checkImageUsable(tenantID string, imageID string) error {
+	image, err := c.ds.GetImage(imageID)
+	if err != nil {
+		return nil
+	}
+
+	if image.State == types.ImageError {
+		return types.ErrImageCorrupted
+	}
+
+	if len(image.Signature) > 0 {
+		return checkImageSignature(image)
+	}
+
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err == nil && tenant != nil && tenant.InstanceDefaults.RequireSignedImages {
+		return types.ErrUnsignedImage
+	}
+
+	return nil
+}
+
 // GetImage gets image metadata after checking permissions
 func (c *controller) GetImage(tenantID, imageID string) (types.Image, error) {
 	glog.Infof("Getting Image [%v] from [%v]", imageID, tenantID)
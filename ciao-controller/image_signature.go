@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+var signerNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_.-]{1,64}$")
+
+// imageSigner returns the public key of a trusted image signer, read from
+// the file signer.pem of the -image_signers directory. Keys are read on
+// each use so that removing the file of a signer revokes its images.
+func imageSigner(signer string) (crypto.PublicKey, error) {
+	if *imageSignersPath == "" || !signerNameRegexp.MatchString(signer) {
+		return nil, types.ErrUntrustedSigner
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(*imageSignersPath, signer+".pem"))
+	if os.IsNotExist(err) {
+		return nil, types.ErrUntrustedSigner
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading key of image signer %s: %v", signer, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Error decoding key of image signer %s", signer)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing key of image signer %s: %v", signer, err)
+	}
+
+	return key, nil
+}
+
+// verifyImageSignature checks that signature is a signature of the image
+// data whose SHA-256 is digest by the trusted key named signer. RSA keys
+// sign with PKCS #1 v1.5 and ECDSA keys produce ASN.1 signatures, as
+// "openssl dgst -sha256 -sign" does.
+func verifyImageSignature(signer string, digest []byte, signature []byte) error {
+	key, err := imageSigner(signer)
+	if err != nil {
+		return err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) != nil {
+			return types.ErrImageSignature
+		}
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+
+		rest, err := asn1.Unmarshal(signature, &sig)
+		if err != nil || len(rest) > 0 || sig.R == nil || sig.S == nil {
+			return types.ErrImageSignature
+		}
+
+		if !ecdsa.Verify(key, digest, sig.R, sig.S) {
+			return types.ErrImageSignature
+		}
+	default:
+		return fmt.Errorf("Unsupported key type %T for image signer %s", key, signer)
+	}
+
+	return nil
+}
+
+// checkImageSignature verifies the signature of a signed image against the
+// digest recorded when its data was uploaded.
+func checkImageSignature(image types.Image) error {
+	digest, err := hex.DecodeString(image.Digest)
+	if err != nil || len(digest) != sha256.Size {
+		return types.ErrImageSignature
+	}
+
+	err = verifyImageSignature(image.Signer, digest, image.Signature)
+	if err != nil {
+		glog.Warningf("Signature of image %s by %s rejected: %v", image.ID, image.Signer, err)
+	}
+
+	return err
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func writeSignerKey(t *testing.T, dir string, signer string, key crypto.PublicKey) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	err = ioutil.WriteFile(filepath.Join(dir, signer+".pem"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ciao-image-signers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSignerKey(t, dir, "rsa-signer", rsaKey.Public())
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSignerKey(t, dir, "ec-signer", ecKey.Public())
+
+	savedPath := *imageSignersPath
+	*imageSignersPath = dir
+	defer func() { *imageSignersPath = savedPath }()
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("signed image data")
+	digest := sha256.Sum256(data)
+
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecSig, err := ecKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.CreateImage(tenant.ID, api.CreateImageRequest{
+		Name:      "untrusted",
+		Signature: rsaSig,
+		Signer:    "unknown-signer",
+	})
+	if err != types.ErrUntrustedSigner {
+		t.Fatalf("Expected %v, got %v", types.ErrUntrustedSigner, err)
+	}
+
+	tests := []struct {
+		signer    string
+		signature []byte
+		data      []byte
+		err       error
+	}{
+		{"rsa-signer", rsaSig, data, nil},
+		{"ec-signer", ecSig, data, nil},
+		{"rsa-signer", rsaSig, []byte("tampered image data"), types.ErrImageSignature},
+		{"ec-signer", rsaSig, data, types.ErrImageSignature},
+	}
+
+	var signed types.Image
+	for i, test := range tests {
+		image, err := ctl.CreateImage(tenant.ID, api.CreateImageRequest{
+			Name:      fmt.Sprintf("signed-%d", i),
+			Signature: test.signature,
+			Signer:    test.signer,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ctl.UploadImage(tenant.ID, image.ID, bytes.NewReader(test.data))
+		if err != test.err {
+			t.Fatalf("Expected %v uploading image signed by %s, got %v", test.err, test.signer, err)
+		}
+
+		image, err = ctl.ds.GetImage(image.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.err != nil {
+			if image.State != types.Killed {
+				t.Fatalf("Expected image in state %s, got %s", types.Killed, image.State)
+			}
+			continue
+		}
+
+		if image.State != types.Active || image.Signer != test.signer {
+			t.Fatalf("Unexpected image state %s signed by %s", image.State, image.Signer)
+		}
+
+		err = ctl.checkImageUsable(tenant.ID, image.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed = image
+	}
+
+	unsigned, err := ctl.CreateImage(tenant.ID, api.CreateImageRequest{Name: "unsigned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.PatchTenant(tenant.ID, []byte(`{"instance_defaults":{"require_signed_images":true}}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.checkImageUsable(tenant.ID, unsigned.ID)
+	if err != types.ErrUnsignedImage {
+		t.Fatalf("Expected %v, got %v", types.ErrUnsignedImage, err)
+	}
+
+	_, err = ctl.CreateVolume(tenant.ID, api.RequestedVolume{ImageRef: unsigned.ID})
+	if err != types.ErrUnsignedImage {
+		t.Fatalf("Expected %v, got %v", types.ErrUnsignedImage, err)
+	}
+
+	err = ctl.checkImageUsable(tenant.ID, signed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// removing the key of a signer revokes its images
+	err = os.Remove(filepath.Join(dir, "ec-signer.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.checkImageUsable(tenant.ID, signed.ID)
+	if err != types.ErrUntrustedSigner {
+		t.Fatalf("Expected %v, got %v", types.ErrUntrustedSigner, err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var imagePrefetchLaunches = flag.Int("image_prefetch_launches", 0, "number of launches of a container image after which it is cached on all compute nodes, 0 to disable")
+
+// imageCaches tracks the container images cached by the compute nodes and
+// the number of launches of each image.  Images launched frequently
+// enough are pre-fetched on the compute nodes not caching them yet.
+type imageCaches struct {
+	launches  map[string]int
+	nodes     map[string]map[string]bool
+	requested map[string]map[string]bool
+}
+
+func (ic *imageCaches) init() {
+	if ic.launches == nil {
+		ic.launches = make(map[string]int)
+		ic.nodes = make(map[string]map[string]bool)
+		ic.requested = make(map[string]map[string]bool)
+	}
+}
+
+// popular returns whether an image has been launched often enough to be
+// cached on all compute nodes.
+func (ic *imageCaches) popular(image string) bool {
+	return *imagePrefetchLaunches > 0 && ic.launches[image] >= *imagePrefetchLaunches
+}
+
+// prefetch returns whether an image is to be cached on a node, recording
+// the request so that it is only sent once.
+func (ic *imageCaches) prefetch(nodeID string, image string) bool {
+	if ic.nodes[nodeID][image] || ic.requested[nodeID][image] {
+		return false
+	}
+
+	if ic.requested[nodeID] == nil {
+		ic.requested[nodeID] = make(map[string]bool)
+	}
+	ic.requested[nodeID][image] = true
+	return true
+}
+
+// imageLaunched counts a launch of a container image, caching the image on
+// all compute nodes once it becomes popular.
+func (c *controller) imageLaunched(image string) {
+	if *imagePrefetchLaunches <= 0 || image == "" {
+		return
+	}
+
+	c.imageLock.Lock()
+	c.images.init()
+	c.images.launches[image]++
+	var nodes []string
+	if c.images.launches[image] == *imagePrefetchLaunches {
+		for nodeID := range c.images.nodes {
+			if c.images.prefetch(nodeID, image) {
+				nodes = append(nodes, nodeID)
+			}
+		}
+	}
+	c.imageLock.Unlock()
+
+	for _, nodeID := range nodes {
+		c.cacheImage(nodeID, image)
+	}
+}
+
+// imagesReported records the images cached by a compute node and caches
+// the popular images it is missing.
+func (c *controller) imagesReported(stat payloads.Stat) {
+	node, err := c.ds.GetNode(stat.NodeUUID)
+	if err != nil || !node.NodeRole.IsAgent() {
+		return
+	}
+
+	c.imageLock.Lock()
+	c.images.init()
+	cached := make(map[string]bool, len(stat.CachedImages))
+	for _, image := range stat.CachedImages {
+		cached[image] = true
+	}
+	c.images.nodes[stat.NodeUUID] = cached
+
+	var images []string
+	for image := range c.images.launches {
+		if c.images.popular(image) && c.images.prefetch(stat.NodeUUID, image) {
+			images = append(images, image)
+		}
+	}
+	c.imageLock.Unlock()
+
+	for _, image := range images {
+		c.cacheImage(stat.NodeUUID, image)
+	}
+}
+
+// forgetNodeImages drops the image cache of a disconnected node.
+func (c *controller) forgetNodeImages(nodeID string) {
+	c.imageLock.Lock()
+	delete(c.images.nodes, nodeID)
+	delete(c.images.requested, nodeID)
+	c.imageLock.Unlock()
+}
+
+func (c *controller) cacheImage(nodeID string, image string) {
+	glog.Infof("Caching image %s on node %s", image, nodeID)
+
+	err := c.client.cacheImage(nodeID, image)
+	if err != nil {
+		glog.Warningf("Error caching image %s on node %s: %v", image, nodeID, err)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+var imageExportEndpoint = flag.String("image_export_endpoint", "", "URL of the S3 compatible object storage images are exported to, image exports are disabled if empty")
+var imageExportBucket = flag.String("image_export_bucket", "ciao-images", "bucket of the object storage images are exported to")
+var imageExportRegion = flag.String("image_export_region", "us-east-1", "region of the object storage images are exported to")
+var imageExportCredentials = flag.String("image_export_credentials", "/etc/ciao/image-export.yaml", "path to the access_key and secret_key of the object storage images are exported to")
+
+// imageExportRetention is the time for which the status of a completed
+// export can be retrieved.
+var imageExportRetention = 24 * time.Hour
+
+// openImageDevice opens the device an image is mapped to.
+var openImageDevice = func(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// ExportImage uploads the data of an image, which may have been created
+// from an instance, to the object storage configured for the cluster so
+// that it can be imported by other clusters or archived.  The image is
+// uploaded in the background and the progress of the export is reported
+// by GetImageExport.
+func (c *controller) ExportImage(tenantID string, imageID string, req api.ExportImageRequest) (types.ImageExportStatus, error) {
+	if c.objectStore == nil {
+		return types.ImageExportStatus{}, types.ErrImageExportDisabled
+	}
+
+	image, err := c.GetImage(tenantID, imageID)
+	if err != nil {
+		return types.ImageExportStatus{}, err
+	}
+
+	if image.State != types.Active {
+		return types.ImageExportStatus{}, types.ErrImageNotActive
+	}
+
+	key := req.Key
+	if key == "" {
+		key = image.ID + ".raw"
+	}
+
+	e := &types.ImageExportStatus{
+		ID:       uuid.Generate().String(),
+		ImageID:  image.ID,
+		TenantID: tenantID,
+		URL:      c.objectStore.objectURL(key),
+		State:    types.ImageExportUploading,
+		Size:     image.Size,
+		Started:  time.Now(),
+	}
+
+	c.imageExportLock.Lock()
+	defer c.imageExportLock.Unlock()
+
+	if c.imageExports == nil {
+		c.imageExports = make(map[string]*types.ImageExportStatus)
+	}
+
+	for ID, old := range c.imageExports {
+		if old.State != types.ImageExportUploading && time.Since(old.Started) > imageExportRetention {
+			delete(c.imageExports, ID)
+		}
+	}
+
+	c.imageExports[e.ID] = e
+
+	go c.exportImage(e.ID, image, key)
+
+	return *e, nil
+}
+
+// GetImageExport returns the progress of the export of an image.
+func (c *controller) GetImageExport(tenantID string, exportID string) (types.ImageExportStatus, error) {
+	c.imageExportLock.Lock()
+	defer c.imageExportLock.Unlock()
+
+	e, ok := c.imageExports[exportID]
+	if !ok || (tenantID != "admin" && e.TenantID != tenantID) {
+		return types.ImageExportStatus{}, types.ErrImageExportNotFound
+	}
+
+	return *e, nil
+}
+
+// ListImageExports returns the recent exports of the images of a tenant,
+// or of all the images for the admin, sorted by start time.
+func (c *controller) ListImageExports(tenantID string) ([]types.ImageExportStatus, error) {
+	c.imageExportLock.Lock()
+	defer c.imageExportLock.Unlock()
+
+	exports := []types.ImageExportStatus{}
+	for _, e := range c.imageExports {
+		if tenantID == "admin" || e.TenantID == tenantID {
+			exports = append(exports, *e)
+		}
+	}
+
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].Started.Before(exports[j].Started)
+	})
+
+	return exports, nil
+}
+
+// imageExporting returns true if an image is being exported.
+func (c *controller) imageExporting(imageID string) bool {
+	c.imageExportLock.Lock()
+	defer c.imageExportLock.Unlock()
+
+	for _, e := range c.imageExports {
+		if e.ImageID == imageID && e.State == types.ImageExportUploading {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportProgress counts the bytes of an image read for its export.
+type exportProgress struct {
+	r        io.Reader
+	c        *controller
+	exportID string
+}
+
+func (p *exportProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+
+	p.c.imageExportLock.Lock()
+	p.c.imageExports[p.exportID].Uploaded += uint64(n)
+	p.c.imageExportLock.Unlock()
+
+	return n, err
+}
+
+func (c *controller) uploadImageObject(exportID string, image types.Image, key string) error {
+	size, err := c.GetBlockDeviceSize(image.ID)
+	if err != nil {
+		return errors.Wrap(err, "error getting image size")
+	}
+
+	c.imageExportLock.Lock()
+	c.imageExports[exportID].Size = size
+	c.imageExportLock.Unlock()
+
+	path, err := c.MapVolumeToNode(image.ID)
+	if err != nil {
+		return errors.Wrap(err, "error mapping image")
+	}
+	defer func() {
+		if err := c.UnmapVolumeFromNode(image.ID); err != nil {
+			glog.Warningf("Error unmapping image %s: %v", image.ID, err)
+		}
+	}()
+
+	f, err := openImageDevice(path)
+	if err != nil {
+		return errors.Wrap(err, "error opening image")
+	}
+	defer func() { _ = f.Close() }()
+
+	metadata := map[string]string{
+		"ciao-image-id":   image.ID,
+		"ciao-image-name": image.Name,
+	}
+
+	return c.objectStore.putObject(key, &exportProgress{r: f, c: c, exportID: exportID},
+		int64(size), metadata)
+}
+
+func (c *controller) exportImage(exportID string, image types.Image, key string) {
+	err := c.uploadImageObject(exportID, image, key)
+
+	c.imageExportLock.Lock()
+	e := c.imageExports[exportID]
+	if err != nil {
+		e.State = types.ImageExportFailed
+		e.Error = err.Error()
+	} else {
+		e.State = types.ImageExportDone
+	}
+	url := e.URL
+	c.imageExportLock.Unlock()
+
+	if err != nil {
+		glog.Warningf("Error exporting image %s: %v", image.ID, err)
+		msg := fmt.Sprintf("Export of image %s (%s) failed: %v", image.Name, image.ID, err)
+		_ = c.ds.LogEvent(image.TenantID, types.EventError, types.EventCategoryStorage, msg)
+		return
+	}
+
+	glog.Infof("Image %s exported to %s", image.ID, url)
+	msg := fmt.Sprintf("Image %s (%s) exported to %s", image.Name, image.ID, url)
+	_ = c.ds.LogEvent(image.TenantID, types.EventInfo, types.EventCategoryStorage, msg)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// sizedNoopDriver is a noop driver reporting a size for all the block
+// devices.
+type sizedNoopDriver struct {
+	storage.NoopDriver
+	size uint64
+}
+
+func (d *sizedNoopDriver) GetBlockDeviceSize(string) (uint64, error) {
+	return d.size, nil
+}
+
+func waitImageExport(t *testing.T, tenantID string, exportID string) types.ImageExportStatus {
+	for i := 0; i < 50; i++ {
+		e, err := ctl.GetImageExport(tenantID, exportID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.State != types.ImageExportUploading {
+			return e
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("Export %s not completed", exportID)
+	return types.ImageExportStatus{}
+}
+
+func TestExportImage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		State:      types.Active,
+		Name:       "exported",
+		Visibility: types.Private,
+	}
+
+	err = ctl.ds.AddImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ExportImage(tenant.ID, image.ID, api.ExportImageRequest{})
+	if err != types.ErrImageExportDisabled {
+		t.Fatalf("Expected %v, got %v", types.ErrImageExportDisabled, err)
+	}
+
+	fake := newFakeObjectStore()
+	s, server := newTestObjectStore(t, fake)
+	defer server.Close()
+	s.partSize = 1024
+
+	data := make([]byte, 4000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	savedDriver := ctl.BlockDriver
+	savedOpen := openImageDevice
+	ctl.objectStore = s
+	ctl.BlockDriver = &sizedNoopDriver{size: uint64(len(data))}
+	openImageDevice = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	defer func() {
+		ctl.objectStore = nil
+		ctl.BlockDriver = savedDriver
+		openImageDevice = savedOpen
+	}()
+
+	e, err := ctl.ExportImage(tenant.ID, image.ID, api.ExportImageRequest{Key: "archive/exported.raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e = waitImageExport(t, tenant.ID, e.ID)
+	if e.State != types.ImageExportDone || e.Uploaded != uint64(len(data)) || e.Size != uint64(len(data)) {
+		t.Fatalf("Unexpected export status %+v", e)
+	}
+
+	if e.URL != server.URL+"/bucket/archive/exported.raw" {
+		t.Fatalf("Unexpected export URL %s", e.URL)
+	}
+
+	if !bytes.Equal(fake.objects["/bucket/archive/exported.raw"], data) {
+		t.Fatal("Exported object differs from the image")
+	}
+
+	fake.failPart = 1
+	failed, err := ctl.ExportImage(tenant.ID, image.ID, api.ExportImageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed = waitImageExport(t, tenant.ID, failed.ID)
+	if failed.State != types.ImageExportFailed || failed.Error == "" {
+		t.Fatalf("Unexpected export status %+v", failed)
+	}
+
+	exports, err := ctl.ListImageExports(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 2 || exports[0].ID != e.ID || exports[1].ID != failed.ID {
+		t.Fatalf("Unexpected exports %+v", exports)
+	}
+
+	exports, err = ctl.ListImageExports("other")
+	if err != nil || len(exports) != 0 {
+		t.Fatalf("Exports of other tenants listed: %+v", exports)
+	}
+
+	_, err = ctl.GetImageExport("other", e.ID)
+	if err != types.ErrImageExportNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrImageExportNotFound, err)
+	}
+
+	_, err = ctl.GetImageExport("admin", e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image.ID = uuid.Generate().String()
+	image.Name = "saving"
+	image.State = types.Saving
+	err = ctl.ds.AddImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ExportImage(tenant.ID, image.ID, api.ExportImageRequest{})
+	if err != types.ErrImageNotActive {
+		t.Fatalf("Expected %v, got %v", types.ErrImageNotActive, err)
+	}
+}
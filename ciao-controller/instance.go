@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -34,11 +35,13 @@ import (
 )
 
 type config struct {
-	sc     payloads.Start
-	config string
-	cnci   bool
-	mac    string
-	ip     string
+	sc        payloads.Start
+	config    string
+	cloudInit string
+	cnci      bool
+	mac       string
+	ip        string
+	volumes   []string
 }
 
 type instance struct {
@@ -46,21 +49,24 @@ type instance struct {
 	newConfig config
 	ctl       *controller
 	startTime time.Time
+
+	// consumed holds the resources accounted to the quotas of the
+	// tenant by Allowed, to be released by Clean.
+	consumed []payloads.RequestedResource
 }
 
 type userData struct {
-	UUID     string `json:"uuid"`
-	Hostname string `json:"hostname"`
+	UUID       string            `json:"uuid"`
+	Hostname   string            `json:"hostname"`
+	PublicKeys map[string]string `json:"public_keys,omitempty"`
 }
 
 func isCNCIWorkload(workload *types.Workload) bool {
 	return workload.Requirements.NetworkNode
 }
 
-func newInstance(ctl *controller, tenantID string, workload *types.Workload,
+func newInstance(ctl *controller, id string, tenantID string, workload *types.Workload,
 	name string, subnet string, IPAddr net.IP) (*instance, error) {
-	id := uuid.Generate()
-
 	if name != "" {
 		existingID, err := ctl.ds.ResolveInstance(tenantID, name)
 		if err != nil {
@@ -68,11 +74,11 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 		}
 
 		if existingID != "" {
-			return nil, fmt.Errorf("Instance name already in use: %s", name)
+			return nil, types.ErrInstanceNameInUse
 		}
 	}
 
-	config, err := newConfig(ctl, workload, id.String(), tenantID, name, IPAddr)
+	config, err := newConfig(ctl, workload, id, tenantID, name, IPAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -81,7 +87,7 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 		TenantID:    tenantID,
 		WorkloadID:  workload.ID,
 		State:       payloads.Pending,
-		ID:          id.String(),
+		ID:          id,
 		CNCI:        config.cnci,
 		IPAddress:   config.ip,
 		VnicUUID:    config.sc.Start.Networking.VnicUUID,
@@ -96,6 +102,13 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 		newInstance.Subnet = subnet
 	}
 
+	if !config.cnci {
+		tenant, err := ctl.ds.GetTenant(tenantID)
+		if err == nil && tenant != nil && len(tenant.InstanceDefaults.Tags) > 0 {
+			newInstance.Tags = append([]string(nil), tenant.InstanceDefaults.Tags...)
+		}
+	}
+
 	i := &instance{
 		ctl:       ctl,
 		newConfig: config,
@@ -106,13 +119,20 @@ func newInstance(ctl *controller, tenantID string, workload *types.Workload,
 }
 
 func (i *instance) Add() error {
-	ds := i.ctl.ds
-	var err error
-	err = ds.AddInstance(i.Instance)
+	err := i.ctl.ds.AddInstance(i.Instance)
 	if err != nil {
 		return errors.Wrapf(err, "Error creating instance in datastore")
 	}
 
+	return i.attachVolumes()
+}
+
+// attachVolumes records the attachments of the volumes of an instance
+// already added to the datastore.
+func (i *instance) attachVolumes() error {
+	ds := i.ctl.ds
+	var err error
+
 	for _, volume := range i.newConfig.sc.Start.Storage {
 		if volume.ID == "" && volume.Local {
 			// these are launcher auto-created ephemeral
@@ -143,36 +163,37 @@ func (i *instance) Clean() error {
 		return errors.Wrap(err, "error releasing tenant IP")
 	}
 
-	wl, err := i.ctl.ds.GetWorkload(i.WorkloadID)
-	if err != nil {
-		return errors.Wrap(err, "error getting workload from datastore")
+	if i.consumed != nil {
+		i.ctl.qs.Release(i.TenantID, i.consumed...)
+		i.consumed = nil
 	}
 
-	resources := []payloads.RequestedResource{
-		{Type: payloads.Instance, Value: 1},
-		{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
-	i.ctl.qs.Release(i.TenantID, resources...)
-
 	err = i.ctl.deleteEphemeralStorage(i.ID)
 	if err != nil {
 		return errors.Wrap(err, "error deleting ephemeral strorage")
 	}
 
+	// the instance never started, the volumes created for it from its
+	// workload are of no use whether they are ephemeral or not.
+	err = i.ctl.deleteLaunchVolumes(i.ID, i.newConfig.volumes)
+	if err != nil {
+		return errors.Wrap(err, "error deleting instance volumes")
+	}
+
 	return nil
 }
 
-func (i *instance) Allowed() (bool, error) {
+func (i *instance) Allowed() (bool, string, error) {
 	if i.CNCI == true {
 		// should I bother to check the tenant id exists?
-		return true, nil
+		return true, "", nil
 	}
 
 	ds := i.ctl.ds
 
 	wl, err := ds.GetWorkload(i.WorkloadID)
 	if err != nil {
-		return true, errors.Wrap(err, "error getting workload from datastore")
+		return true, "", errors.Wrap(err, "error getting workload from datastore")
 	}
 
 	resources := []payloads.RequestedResource{
@@ -180,9 +201,12 @@ func (i *instance) Allowed() (bool, error) {
 		{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
 		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
 	res := <-i.ctl.qs.Consume(i.TenantID, resources...)
+	if res.Allowed() {
+		i.consumed = res.Resources()
+	}
 
 	// Cleanup on disallowed happens in Clean()
-	return res.Allowed(), nil
+	return res.Allowed(), res.Reason(), nil
 }
 
 func instanceActive(i *types.Instance) bool {
@@ -199,7 +223,11 @@ func instanceActive(i *types.Instance) bool {
 func getStorage(c *controller, s types.StorageResource, tenant string, instanceID string) (payloads.StorageResource, error) {
 	// storage already exists, use preexisting definition.
 	if s.ID != "" {
-		return payloads.StorageResource{ID: s.ID, Bootable: s.Bootable}, nil
+		var pool string
+		if bd, err := c.ds.GetBlockDevice(s.ID); err == nil {
+			pool = c.cephPool(bd.Pool)
+		}
+		return payloads.StorageResource{ID: s.ID, Bootable: s.Bootable, Pool: pool}, nil
 	}
 
 	var err error
@@ -207,6 +235,8 @@ func getStorage(c *controller, s types.StorageResource, tenant string, instanceI
 		Description: fmt.Sprintf("Volume for instance: %s", instanceID),
 		Internal:    s.Internal,
 		Size:        s.Size,
+		Pool:        s.Pool,
+		Clone:       s.Clone,
 	}
 
 	switch s.SourceType {
@@ -224,7 +254,27 @@ func getStorage(c *controller, s types.StorageResource, tenant string, instanceI
 	if err != nil {
 		return payloads.StorageResource{}, errors.Wrap(err, "Error creating volume")
 	}
-	return payloads.StorageResource{ID: volume.ID, Bootable: s.Bootable, Ephemeral: s.Ephemeral}, nil
+	return payloads.StorageResource{
+		ID:        volume.ID,
+		Bootable:  s.Bootable,
+		Ephemeral: s.Ephemeral,
+		Pool:      c.cephPool(volume.Pool),
+	}, nil
+}
+
+// tenantNetworkAddress fills in the addresses of the tenant network
+// interface of an instance.
+func tenantNetworkAddress(tenant *types.Tenant, networking *payloads.NetworkResources, ipAddress net.IP) {
+	networking.VnicMAC = utils.NewTenantHardwareAddr(ipAddress).String()
+
+	// send in CIDR notation?
+	networking.PrivateIP = ipAddress.String()
+	mask := net.CIDRMask(tenant.SubnetBits, 32)
+	ipnet := net.IPNet{
+		IP:   ipAddress.Mask(mask),
+		Mask: mask,
+	}
+	networking.Subnet = ipnet.String()
 }
 
 func networkConfig(ctl *controller, tenant *types.Tenant, networking *payloads.NetworkResources, cnci bool, ipAddress net.IP) error {
@@ -240,16 +290,7 @@ func networkConfig(ctl *controller, tenant *types.Tenant, networking *payloads.N
 		return nil
 	}
 
-	networking.VnicMAC = utils.NewTenantHardwareAddr(ipAddress).String()
-
-	// send in CIDR notation?
-	networking.PrivateIP = ipAddress.String()
-	mask := net.CIDRMask(tenant.SubnetBits, 32)
-	ipnet := net.IPNet{
-		IP:   ipAddress.Mask(mask),
-		Mask: mask,
-	}
-	networking.Subnet = ipnet.String()
+	tenantNetworkAddress(tenant, networking, ipAddress)
 
 	cnciInstance, err := tenant.CNCIctrl.GetSubnetCNCI(networking.Subnet)
 	if err != nil {
@@ -258,6 +299,12 @@ func networkConfig(ctl *controller, tenant *types.Tenant, networking *payloads.N
 
 	networking.ConcentratorUUID = cnciInstance.ID
 
+	vni, err := ctl.ds.AllocateSubnetVNI(tenant.ID, networking.Subnet)
+	if err != nil {
+		return err
+	}
+	networking.SubnetKey = strconv.FormatUint(uint64(vni), 10)
+
 	// in theory we should refuse to go on if ip is null
 	// for now let's keep going
 	networking.ConcentratorIP = cnciInstance.IPAddress
@@ -266,27 +313,58 @@ func networkConfig(ctl *controller, tenant *types.Tenant, networking *payloads.N
 
 func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID string,
 	name string, IPaddr net.IP) (config, error) {
-	var metaData userData
-	var config config
 	var networking payloads.NetworkResources
 	var storage []payloads.StorageResource
-
-	baseConfig := wl.Config
-
-	fwType := wl.FWType
-	config.cnci = isCNCIWorkload(wl)
-	metaData.UUID = instanceID
+	var volumes []string
 
 	tenant, err := ctl.ds.GetTenant(tenantID)
 	if err != nil {
 		fmt.Println("unable to get tenant")
 	}
 
-	err = networkConfig(ctl, tenant, &networking, config.cnci, IPaddr)
+	err = networkConfig(ctl, tenant, &networking, isCNCIWorkload(wl), IPaddr)
 	if err != nil {
-		return config, err
+		return config{}, err
 	}
 
+	// handle storage resources in workload definition
+	for i := range wl.Storage {
+		workloadStorage, err := getStorage(ctl, wl.Storage[i], tenantID, instanceID)
+		if err != nil {
+			if err := ctl.deleteLaunchVolumes(instanceID, volumes); err != nil {
+				glog.Warningf("Error deleting volumes of instance %s: %v", instanceID, err)
+			}
+			return config{volumes: volumes}, err
+		}
+		storage = append(storage, workloadStorage)
+
+		if wl.Storage[i].ID == "" {
+			volumes = append(volumes, workloadStorage.ID)
+		}
+	}
+
+	config, err := renderConfig(ctl, wl, instanceID, tenantID, name, networking,
+		storage, ctl.vendorData)
+	config.volumes = volumes
+
+	return config, err
+}
+
+// renderConfig renders the start payload and cloud-init data sent to the
+// launcher for an instance whose network and storage are set up.
+func renderConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID string,
+	name string, networking payloads.NetworkResources, storage []payloads.StorageResource,
+	vendorData string) (config, error) {
+	var metaData userData
+	var config config
+	var err error
+
+	baseConfig := wl.Config
+
+	fwType := wl.FWType
+	config.cnci = isCNCIWorkload(wl)
+	metaData.UUID = instanceID
+
 	metaData.Hostname = instanceID
 	if name != "" {
 		metaData.Hostname = name
@@ -294,13 +372,14 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 
 	config.ip = networking.PrivateIP
 
-	// handle storage resources in workload definition
-	for i := range wl.Storage {
-		workloadStorage, err := getStorage(ctl, wl.Storage[i], tenantID, instanceID)
-		if err != nil {
-			return config, err
+	// the tenant may force its SSH key into all its VMs
+	if !config.cnci && wl.VMType != payloads.Docker {
+		tenant, err := ctl.ds.GetTenant(tenantID)
+		if err == nil && tenant != nil && tenant.InstanceDefaults.SSHKey != "" {
+			metaData.PublicKeys = map[string]string{
+				tenantID: tenant.InstanceDefaults.SSHKey,
+			}
 		}
-		storage = append(storage, workloadStorage)
 	}
 
 	// hardcode persistence until changes can be made to workload
@@ -315,10 +394,24 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 		Networking:          networking,
 		Storage:             storage,
 		Requirements:        wl.Requirements,
+		CommandID:           uuid.Generate().String(),
+	}
+	startCmd.Requirements.StoragePools = ctl.volumeStoragePools(storage)
+
+	if !config.cnci {
+		startCmd.HealthCheck = wl.HealthCheck
 	}
 
 	if wl.VMType == payloads.Docker {
 		startCmd.DockerImage = wl.ImageName
+	} else if !config.cnci {
+		startCmd.VendorData = vendorData
+		startCmd.StaticNetwork = wl.StaticNetwork
+		startCmd.Devices = wl.Devices
+		startCmd.NetworkConfig, err = cloudInitNetworkConfig(&networking)
+		if err != nil {
+			return config, err
+		}
 	}
 
 	cmd := payloads.Start{
@@ -336,7 +429,8 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 		glog.Warning("error marshalling user data: ", err)
 	}
 
-	config.config = "---\n" + string(y) + "...\n" + baseConfig + "---\n" + string(b) + "\n...\n"
+	config.cloudInit = baseConfig + "---\n" + string(b) + "\n...\n"
+	config.config = "---\n" + string(y) + "...\n" + config.cloudInit
 	config.mac = networking.VnicMAC
 
 	return config, err
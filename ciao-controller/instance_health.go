@@ -0,0 +1,91 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// restartUnhealthyInstance stops an unhealthy instance and starts it
+// again on its node.
+var restartUnhealthyInstance = func(c *controller, instanceID string) error {
+	err := c.stopInstanceSync(instanceID)
+	if err != nil {
+		return err
+	}
+
+	return c.restartInstance(instanceID)
+}
+
+// checkInstanceHealth looks for the running instances reported unhealthy
+// by a node.  Instances becoming unhealthy, or healthy again, are logged in
+// the event log of their tenant, and restarted if their workload asks so.
+func (c *controller) checkInstanceHealth(stat payloads.Stat) {
+	c.instanceHealthLock.Lock()
+	defer c.instanceHealthLock.Unlock()
+
+	if c.unhealthyInstances == nil {
+		c.unhealthyInstances = make(map[string]bool)
+	}
+
+	for _, s := range stat.Instances {
+		if s.Health == nil || s.State != payloads.Running {
+			continue
+		}
+
+		unhealthy := s.Health.Status == payloads.Unhealthy
+		if unhealthy == c.unhealthyInstances[s.InstanceUUID] {
+			continue
+		}
+
+		i, err := c.ds.GetInstance(s.InstanceUUID)
+		if err != nil {
+			continue
+		}
+
+		if !unhealthy {
+			delete(c.unhealthyInstances, i.ID)
+			if s.Health.Status == payloads.Healthy {
+				msg := fmt.Sprintf("Instance %s is healthy", i.ID)
+				_ = c.ds.LogEvent(i.TenantID, types.EventInfo, types.EventCategoryInstance, msg)
+			}
+			continue
+		}
+
+		c.unhealthyInstances[i.ID] = true
+
+		msg := fmt.Sprintf("Instance %s is unhealthy: %s", i.ID, s.Health.Message)
+		glog.Warning(msg)
+		_ = c.ds.LogEvent(i.TenantID, types.EventWarning, types.EventCategoryInstance, msg)
+
+		wl, err := c.ds.GetWorkload(i.WorkloadID)
+		if err != nil || wl.HealthCheck == nil || !wl.HealthCheck.RestartUnhealthy {
+			continue
+		}
+
+		go func(ID string, tenantID string) {
+			err := restartUnhealthyInstance(c, ID)
+			if err != nil {
+				msg := fmt.Sprintf("Unable to restart unhealthy instance %s: %v", ID, err)
+				glog.Warning(msg)
+				_ = c.ds.LogEvent(tenantID, types.EventError, types.EventCategoryInstance, msg)
+			}
+		}(i.ID, i.TenantID)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestValidateHealthCheck(t *testing.T) {
+	tests := []struct {
+		hc    payloads.HealthCheck
+		valid bool
+	}{
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckTCP, Port: 22}, true},
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckHTTP, Port: 80, Path: "/healthz", Interval: 30}, true},
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckTCP, Port: 22, Path: "/"}, false},
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckHTTP, Port: 80, Path: "healthz"}, false},
+		{payloads.HealthCheck{Protocol: "icmp", Port: 22}, false},
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckTCP, Port: 70000}, false},
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckTCP, Port: 22, Interval: 2, Timeout: 5}, false},
+		{payloads.HealthCheck{Protocol: payloads.HealthCheckTCP, Port: 22, UnhealthyThreshold: -1}, false},
+	}
+
+	for _, test := range tests {
+		hc := test.hc
+		err := validateHealthCheck(&hc)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error for %+v: %v", test.hc, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Invalid health check %+v accepted", test.hc)
+		}
+
+		if test.valid && (hc.Interval == 0 || hc.Timeout == 0 || hc.UnhealthyThreshold == 0) {
+			t.Errorf("Defaults not set in %+v", hc)
+		}
+	}
+}
+
+func TestCheckInstanceHealth(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	wl := wls[0]
+	wl.ID = uuid.Generate().String()
+	wl.HealthCheck = &payloads.HealthCheck{
+		Protocol:           payloads.HealthCheckTCP,
+		Port:               22,
+		Interval:           10,
+		Timeout:            5,
+		UnhealthyThreshold: 3,
+		RestartUnhealthy:   true,
+	}
+	err = ctl.ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance := types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: wl.ID,
+		State:      payloads.Running,
+		ID:         uuid.Generate().String(),
+	}
+	err = ctl.ds.AddInstance(&instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := make(chan string, 2)
+	saved := restartUnhealthyInstance
+	restartUnhealthyInstance = func(c *controller, instanceID string) error {
+		restarted <- instanceID
+		return nil
+	}
+	defer func() { restartUnhealthyInstance = saved }()
+
+	stat := payloads.Stat{
+		Instances: []payloads.InstanceStat{
+			{
+				InstanceUUID: instance.ID,
+				State:        payloads.Running,
+				Health: &payloads.InstanceHealthStat{
+					Status:   payloads.Unhealthy,
+					Failures: 3,
+					Message:  "connection refused",
+				},
+			},
+		},
+	}
+
+	ctl.checkInstanceHealth(stat)
+	ctl.checkInstanceHealth(stat)
+
+	select {
+	case ID := <-restarted:
+		if ID != instance.ID {
+			t.Fatalf("Unexpected instance %s restarted", ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unhealthy instance not restarted")
+	}
+
+	select {
+	case <-restarted:
+		t.Fatal("Unhealthy instance restarted twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stat.Instances[0].Health = &payloads.InstanceHealthStat{Status: payloads.Healthy}
+	ctl.checkInstanceHealth(stat)
+
+	logs, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, e := range logs {
+		if e.TenantID == tenant.ID && strings.Contains(e.Message, instance.ID) {
+			messages = append(messages, e.Message)
+		}
+	}
+
+	if len(messages) != 2 || !strings.Contains(messages[0], "unhealthy: connection refused") ||
+		!strings.Contains(messages[1], "is healthy") {
+		t.Fatalf("Unexpected events %v", messages)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// BackupVersion is the version of the format written by Backup.  Restore
+// accepts backups of this version or older.
+const BackupVersion = 1
+
+// backupTenant holds the persistent fields of a tenant.
+type backupTenant struct {
+	ID     string             `json:"id"`
+	Config types.TenantConfig `json:"config"`
+}
+
+// backupWorkload holds a workload along with its tenant, which is not
+// part of the JSON encoding of workloads.
+type backupWorkload struct {
+	TenantID string         `json:"tenant_id"`
+	Workload types.Workload `json:"workload"`
+}
+
+// backup is the content of a backup, i.e., the MigrationTables of a
+// persistent store.
+type backup struct {
+	Version       int                                   `json:"version"`
+	Created       time.Time                             `json:"created"`
+	Config        map[string]string                     `json:"config"`
+	QuotaProfiles []types.QuotaProfile                  `json:"quota_profiles"`
+	Tenants       []backupTenant                        `json:"tenants"`
+	Networks      map[string]map[uint32]map[uint32]bool `json:"networks"`
+	Quotas        map[string][]types.QuotaDetails       `json:"quotas"`
+	Workloads     []backupWorkload                      `json:"workloads"`
+	Images        []types.Image                         `json:"images"`
+	Instances     []instanceRecord                      `json:"instances"`
+	Volumes       map[string]types.Volume               `json:"volumes"`
+	Attachments   map[string]types.StorageAttachment    `json:"attachments"`
+	Pools         map[string]types.Pool                 `json:"pools"`
+	MappedIPs     map[string]types.MappedIP             `json:"mapped_ips"`
+	VNIs          []types.VNIAllocation                 `json:"vnis"`
+	Events        []*types.LogEntry                     `json:"events"`
+	Deleted       []types.DeletedInstance               `json:"deleted_instances,omitempty"`
+	Usage         []types.TenantUsageSample             `json:"tenant_usage,omitempty"`
+}
+
+func newBackup(s *snapshot) *backup {
+	b := &backup{
+		Version:       BackupVersion,
+		Created:       time.Now(),
+		Config:        s.config,
+		QuotaProfiles: s.quotaProfiles,
+		Networks:      s.networks,
+		Quotas:        s.quotas,
+		Images:        s.images,
+		Instances:     s.instances,
+		Volumes:       s.volumes,
+		Attachments:   s.attachments,
+		Pools:         s.pools,
+		MappedIPs:     s.mappedIPs,
+		VNIs:          s.vnis,
+		Events:        s.events,
+		Deleted:       s.deleted,
+		Usage:         s.usage,
+	}
+
+	for _, t := range s.tenants {
+		b.Tenants = append(b.Tenants, backupTenant{ID: t.ID, Config: t.TenantConfig})
+	}
+
+	for _, wl := range s.workloads {
+		b.Workloads = append(b.Workloads, backupWorkload{TenantID: wl.TenantID, Workload: wl})
+	}
+
+	return b
+}
+
+func (b *backup) snapshot() *snapshot {
+	s := &snapshot{
+		config:        b.Config,
+		quotaProfiles: b.QuotaProfiles,
+		networks:      b.Networks,
+		quotas:        b.Quotas,
+		images:        b.Images,
+		instances:     b.Instances,
+		volumes:       b.Volumes,
+		attachments:   b.Attachments,
+		pools:         b.Pools,
+		mappedIPs:     b.MappedIPs,
+		vnis:          b.VNIs,
+		events:        b.Events,
+		deleted:       b.Deleted,
+		usage:         b.Usage,
+	}
+
+	for _, t := range b.Tenants {
+		s.tenants = append(s.tenants, types.Tenant{ID: t.ID, TenantConfig: t.Config})
+	}
+
+	for _, wl := range b.Workloads {
+		workload := wl.Workload
+		workload.TenantID = wl.TenantID
+		s.workloads = append(s.workloads, workload)
+	}
+
+	return s
+}
+
+// Backup writes the content of the persistent store of the datastore to
+// w: configuration, quota profiles, tenants and their networks and
+// quotas, workloads, images, instances, volumes and their attachments,
+// external IP pools and mapped IPs, VNIs and the event log.  Statistics
+// and traces are not backed up.
+func (ds *Datastore) Backup(w io.Writer) error {
+	s, err := takeSnapshot(ds.db)
+	if err != nil {
+		return errors.Wrap(err, "Error reading datastore")
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return errors.Wrap(enc.Encode(newBackup(s)), "Error writing backup")
+}
+
+// Restore copies a backup written by Backup into the datastore, which
+// must not contain anything but the default quota profiles, and reloads
+// the datastore caches.  The restored content is read back and compared
+// to the backup.  Restore must be called before the datastore is used.
+func (ds *Datastore) Restore(r io.Reader) (MigrationReport, error) {
+	var b backup
+
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, errors.Wrap(err, "Error reading backup")
+	}
+
+	if b.Version < 1 || b.Version > BackupVersion {
+		return nil, fmt.Errorf("Unsupported backup version %d", b.Version)
+	}
+
+	existing, err := takeSnapshot(ds.db)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading datastore")
+	}
+
+	// Quota profiles are created when the datastore is initialised
+	// and are replaced by those of the backup.
+	profiles := existing.quotaProfiles
+	existing.quotaProfiles = nil
+	if !existing.empty() {
+		return nil, errors.New("Datastore is not empty")
+	}
+
+	for _, p := range profiles {
+		if err := ds.db.deleteQuotaProfile(p.Name); err != nil {
+			return nil, errors.Wrapf(err, "Error deleting quota profile %s", p.Name)
+		}
+	}
+
+	s := b.snapshot()
+
+	err = copySnapshot(s, ds.db)
+	if err != nil {
+		return nil, err
+	}
+
+	restored, err := takeSnapshot(ds.db)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading back datastore")
+	}
+
+	mismatches, err := s.compare(restored)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mismatches) > 0 {
+		return restored.report(), fmt.Errorf("Verification failed, tables differ: %v", mismatches)
+	}
+
+	err = ds.load()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reloading datastore")
+	}
+
+	return s.report(), nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ciao-project/ciao/payloads"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	from := migrationConfig(dir, "from")
+	to := migrationConfig(dir, "to")
+
+	populateStore(t, from)
+
+	var src Datastore
+	if err := src.Init(from); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Exit()
+
+	var b bytes.Buffer
+	if err := src.Backup(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := b.Bytes()
+
+	var dst Datastore
+	if err := dst.Init(to); err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Exit()
+
+	report, err := dst.Restore(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range MigrationTables {
+		if report[table] != 1 {
+			t.Errorf("Expected 1 record restored in %s, got %d", table, report[table])
+		}
+	}
+
+	instances, err := dst.GetAllInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != 1 || instances[0].State != payloads.Running || instances[0].NodeID != "node" {
+		t.Fatalf("Instance not restored: %+v", instances)
+	}
+
+	wls, err := dst.GetWorkloads(instances[0].TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, wl := range wls {
+		if wl.Description == "migrated workload" && wl.TenantID == instances[0].TenantID {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("Tenant workload not restored")
+	}
+
+	profiles := dst.GetQuotaProfiles()
+	if len(profiles) != 1 || profiles[0].Name != "small" {
+		t.Fatalf("Quota profiles not restored: %+v", profiles)
+	}
+
+	_, err = dst.Restore(bytes.NewReader(backup))
+	if err == nil {
+		t.Fatal("Restore to a non empty datastore succeeded")
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var d Datastore
+	if err := d.Init(migrationConfig(dir, "to")); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Exit()
+
+	_, err = d.Restore(strings.NewReader(`{"version": 2}`))
+	if err == nil || !strings.Contains(err.Error(), "Unsupported backup version") {
+		t.Fatalf("Expected unsupported version error, got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// TenantUsagePeriodMinutes is the minimum interval between two
+	// entries of the usage history of a tenant.
+	TenantUsagePeriodMinutes = "tenant_usage_period_minutes"
+
+	// TenantUsageRawRetentionDays is the number of days after which the
+	// entries of the usage history of a tenant are rolled up into
+	// hourly samples.
+	TenantUsageRawRetentionDays = "tenant_usage_raw_retention_days"
+
+	// TenantUsageHourlyRetentionDays is the number of days after which
+	// the hourly samples of the usage history of a tenant are rolled up
+	// into daily samples.
+	TenantUsageHourlyRetentionDays = "tenant_usage_hourly_retention_days"
+
+	// MaxTenantUsageSamples is the number of samples of the usage
+	// history of a tenant from which it is downsampled when queried.
+	MaxTenantUsageSamples = "max_tenant_usage_samples"
+
+	// MaxInstanceSamples is the number of network and disk I/O samples
+	// kept per instance.
+	MaxInstanceSamples = "max_instance_samples"
+
+	// VNIQuarantineMinutes is the time during which the VNI released by
+	// a tenant subnet is not assigned to another subnet.
+	VNIQuarantineMinutes = "vni_quarantine_minutes"
+
+	// EventRetentionDays is the number of days after which events are
+	// pruned from the event log.
+	EventRetentionDays = "event_retention_days"
+
+	// DeletedInstanceRetentionDays is the number of days for which the
+	// records of deleted instances are kept.
+	DeletedInstanceRetentionDays = "deleted_instance_retention_days"
+
+	// MaintenanceWindows are the periods during which disruptive
+	// automated actions may affect the instances of the tenants which
+	// do not define their own windows.
+	MaintenanceWindows = "maintenance_windows"
+
+	// MaintenanceOverride lets disruptive automated actions run outside
+	// of the maintenance windows, in emergencies.
+	MaintenanceOverride = "maintenance_override"
+)
+
+// configSchemas lists the settings of the cluster configuration.
+var configSchemas = map[string]types.ConfigSchema{
+	TenantUsagePeriodMinutes: {
+		Type:        types.ConfigInt,
+		Default:     "5",
+		Min:         1,
+		Max:         1440,
+		Description: "minimum interval in minutes between two entries of the usage history of a tenant",
+	},
+	TenantUsageRawRetentionDays: {
+		Type:        types.ConfigInt,
+		Default:     "7",
+		Min:         2,
+		Max:         365,
+		Description: "number of days after which the entries of the usage history of a tenant are rolled up into hourly samples",
+	},
+	TenantUsageHourlyRetentionDays: {
+		Type:        types.ConfigInt,
+		Default:     "90",
+		Min:         2,
+		Max:         3650,
+		Description: "number of days after which the hourly samples of the usage history of a tenant are rolled up into daily samples",
+	},
+	MaxTenantUsageSamples: {
+		Type:        types.ConfigInt,
+		Default:     "1000",
+		Min:         10,
+		Max:         100000,
+		Description: "number of samples of the usage history of a tenant returned by a query, beyond which it is downsampled",
+	},
+	MaxInstanceSamples: {
+		Type:        types.ConfigInt,
+		Default:     strconv.Itoa(maxInstanceSamples),
+		Min:         1,
+		Max:         1000,
+		Description: "number of network and disk I/O samples kept per instance",
+	},
+	VNIQuarantineMinutes: {
+		Type:        types.ConfigInt,
+		Default:     "10",
+		Min:         0,
+		Max:         10080,
+		Description: "time in minutes before the VNI released by a tenant subnet can be assigned to another subnet",
+	},
+	EventRetentionDays: {
+		Type:        types.ConfigInt,
+		Default:     "0",
+		Min:         0,
+		Max:         3650,
+		Description: "number of days after which events are pruned from the event log, 0 to keep them until cleared",
+	},
+	DeletedInstanceRetentionDays: {
+		Type:        types.ConfigInt,
+		Default:     "0",
+		Min:         0,
+		Max:         3650,
+		Description: "number of days for which the records of deleted instances are kept, 0 to not keep them",
+	},
+	MaintenanceWindows: {
+		Type:        types.ConfigWindows,
+		Default:     "",
+		Description: "comma separated weekly windows, e.g. \"sat 22:00-02:00\" in UTC, during which disruptive automated actions may run, always if empty",
+	},
+	MaintenanceOverride: {
+		Type:        types.ConfigBool,
+		Default:     "false",
+		Description: "let disruptive automated actions run outside of the maintenance windows, in emergencies",
+	},
+}
+
+func (ds *Datastore) initConfig() error {
+	ds.config = make(map[string]string)
+	ds.configLock = &sync.RWMutex{}
+
+	config, err := ds.db.getClusterConfig()
+	if err != nil {
+		return errors.Wrap(err, "error getting configuration from database")
+	}
+
+	for key, value := range config {
+		schema, ok := configSchemas[key]
+		if !ok {
+			glog.Warningf("Ignoring unknown configuration setting %s", key)
+			continue
+		}
+
+		if err := schema.Validate(value); err != nil {
+			glog.Warningf("Ignoring invalid value %q of configuration setting %s", value, key)
+			continue
+		}
+
+		ds.config[key] = value
+	}
+
+	return nil
+}
+
+// GetConfig returns all the settings of the cluster configuration, sorted
+// by key.
+func (ds *Datastore) GetConfig() []types.ConfigSetting {
+	settings := make([]types.ConfigSetting, 0, len(configSchemas))
+
+	for key := range configSchemas {
+		s, _ := ds.GetConfigSetting(key)
+		settings = append(settings, s)
+	}
+
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+
+	return settings
+}
+
+// GetConfigSetting returns a setting of the cluster configuration, with
+// its default value if it was never set.
+func (ds *Datastore) GetConfigSetting(key string) (types.ConfigSetting, error) {
+	schema, ok := configSchemas[key]
+	if !ok {
+		return types.ConfigSetting{}, types.ErrConfigKeyNotFound
+	}
+
+	ds.configLock.RLock()
+	value, ok := ds.config[key]
+	ds.configLock.RUnlock()
+
+	if !ok {
+		value = schema.Default
+	}
+
+	return types.ConfigSetting{
+		Key:    key,
+		Value:  value,
+		Schema: schema,
+	}, nil
+}
+
+// SetConfigSetting changes a setting of the cluster configuration once
+// validated against its schema. An empty value restores the default.
+func (ds *Datastore) SetConfigSetting(key string, value string) error {
+	schema, ok := configSchemas[key]
+	if !ok {
+		return types.ErrConfigKeyNotFound
+	}
+
+	if value != "" {
+		if err := schema.Validate(value); err != nil {
+			return err
+		}
+	}
+
+	ds.configLock.Lock()
+	defer ds.configLock.Unlock()
+
+	if value == "" {
+		err := ds.db.deleteClusterConfig(key)
+		if err != nil {
+			return errors.Wrap(err, "error deleting configuration setting from database")
+		}
+
+		delete(ds.config, key)
+		return nil
+	}
+
+	err := ds.db.updateClusterConfig(key, value)
+	if err != nil {
+		return errors.Wrap(err, "error updating configuration setting in database")
+	}
+
+	ds.config[key] = value
+
+	return nil
+}
+
+// configInt returns the value of an integer setting of the cluster
+// configuration.
+func (ds *Datastore) configInt(key string) int {
+	s, err := ds.GetConfigSetting(key)
+	if err != nil {
+		return 0
+	}
+
+	v, err := strconv.Atoi(s.Value)
+	if err != nil {
+		v, _ = strconv.Atoi(s.Schema.Default)
+	}
+
+	return v
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func TestConfigSetting(t *testing.T) {
+	cds := &Datastore{}
+
+	err := cds.Init(Config{
+		DBBackend:         &MemoryDB{},
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cds.Exit()
+
+	s, err := cds.GetConfigSetting(TenantUsagePeriodMinutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Value != s.Schema.Default {
+		t.Fatalf("Expected default value %s, got %s", s.Schema.Default, s.Value)
+	}
+
+	err = cds.SetConfigSetting(TenantUsagePeriodMinutes, "30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cds.configInt(TenantUsagePeriodMinutes) != 30 {
+		t.Fatal("Setting not updated")
+	}
+
+	for _, v := range []string{"0", "100000", "five"} {
+		err = cds.SetConfigSetting(TenantUsagePeriodMinutes, v)
+		if err != types.ErrInvalidConfigValue {
+			t.Fatalf("Expected ErrInvalidConfigValue for %q, got %v", v, err)
+		}
+	}
+
+	err = cds.SetConfigSetting("unknown", "1")
+	if err != types.ErrConfigKeyNotFound {
+		t.Fatalf("Expected ErrConfigKeyNotFound, got %v", err)
+	}
+
+	settings := cds.GetConfig()
+	if len(settings) != len(configSchemas) {
+		t.Fatalf("Expected %d settings, got %d", len(configSchemas), len(settings))
+	}
+
+	err = cds.SetConfigSetting(TenantUsagePeriodMinutes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cds.configInt(TenantUsagePeriodMinutes) != 5 {
+		t.Fatal("Setting not reset to its default value")
+	}
+}
@@ -16,24 +16,27 @@
 
 // Package datastore retrieves stores data for the ciao controller.
 // This package caches most data in memory, and uses a sql
-// database as persistent storage.
+// database, or an etcd cluster, as persistent storage.
 package datastore
 
 import (
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
+	"math"
 	"net"
+	"reflect"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/internal/ipalloc"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
 	"github.com/ciao-project/ciao/uuid"
-	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
@@ -47,17 +50,25 @@ var (
 
 // Config contains configuration information for the datastore.
 type Config struct {
-	DBBackend         persistentStore
+	DBBackend persistentStore
+
+	// PersistentURI locates the persistent store. When DBBackend is
+	// not set, etcd:// and etcds:// URIs select the etcd backend and
+	// any other URI the sqlite one.
 	PersistentURI     string
 	InitWorkloadsPath string
-}
 
-type userEventType string
+	// ReadReplicaURI optionally points to a read only copy of the
+	// persistent store. Only the sqlite backend supports it, sending
+	// the reporting queries (event log, trace statistics, tenant usage
+	// history) there instead of to the primary at PersistentURI. The
+	// other backends ignore it.
+	ReadReplicaURI string
 
-const (
-	userInfo  userEventType = "info"
-	userError userEventType = "error"
-)
+	// InjectFaults allows the writes to the persistent store to be
+	// delayed with SetWriteDelay, for testing only.
+	InjectFaults bool
+}
 
 type tenant struct {
 	types.Tenant
@@ -66,6 +77,11 @@ type tenant struct {
 	devices   map[string]types.Volume
 	workloads []string
 	images    []string
+
+	// names maps the names of the instances of the tenant to their
+	// IDs, and instanceNames their IDs to their names.
+	names         map[string]string
+	instanceNames map[string]string
 }
 
 type node struct {
@@ -92,6 +108,16 @@ type persistentStore interface {
 	clearLog() error
 	getEventLog() (logEntries []*types.LogEntry, err error)
 
+	// getEvents returns, in the order they were logged, at most limit
+	// events matching filter logged after the event identified by
+	// marker, along with the marker of the last event returned if
+	// more events match. A limit of 0 returns all the events.
+	getEvents(filter types.EventFilter, marker string, limit int) ([]*types.LogEntry, string, error)
+
+	// pruneLog removes the events logged before a time and returns the
+	// number of events removed.
+	pruneLog(before time.Time) (int, error)
+
 	// interfaces related to workloads
 	addWorkload(wl types.Workload) error
 	deleteWorkload(ID string) error
@@ -102,6 +128,7 @@ type persistentStore interface {
 	getTenant(id string) (t *tenant, err error)
 	getTenants() ([]*tenant, error)
 	releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) (err error)
+	releaseTenantIPs(tenantID string) (err error)
 	claimTenantIP(tenantID string, subnetInt uint32, rest uint32) (err error)
 	claimTenantIPs(tenantID string, IPs []tenantIP) (err error)
 	updateTenant(tenant *types.Tenant) error
@@ -110,6 +137,7 @@ type persistentStore interface {
 	// interfaces related to instances
 	getInstances() (instances []*types.Instance, err error)
 	addInstance(instance *types.Instance) (err error)
+	addInstances(instances []*types.Instance) (err error)
 	deleteInstance(instanceID string) (err error)
 	updateInstance(instance *types.Instance) (err error)
 
@@ -138,49 +166,116 @@ type persistentStore interface {
 	deletePool(ID string) error
 
 	addMappedIP(m types.MappedIP) error
+	updateMappedIP(m types.MappedIP) error
 	deleteMappedIP(ID string) error
 	getMappedIPs() map[string]types.MappedIP
 
 	// quotas
 	updateQuotas(tenantID string, qds []types.QuotaDetails) error
 	getQuotas(tenantID string) ([]types.QuotaDetails, error)
+	updateQuotaProfile(p types.QuotaProfile) error
+	deleteQuotaProfile(name string) error
+	getQuotaProfiles() ([]types.QuotaProfile, error)
 
 	// images
 	updateImage(i types.Image) error
 	deleteImage(ID string) error
 	getImages() ([]types.Image, error)
+
+	// cluster configuration
+	updateClusterConfig(key string, value string) error
+	deleteClusterConfig(key string) error
+	getClusterConfig() (map[string]string, error)
+
+	// VXLAN network identifiers
+	updateVNI(v types.VNIAllocation) error
+	getVNIs() ([]types.VNIAllocation, error)
+
+	// shadow scheduling
+	addShadowPlacement(p types.ShadowPlacement) error
+	setShadowPlacementNode(instanceID string, nodeID string) error
+	getShadowPlacements() ([]types.ShadowPlacement, error)
+
+	// records of deleted instances
+	addDeletedInstance(d types.DeletedInstance) error
+	deleteDeletedInstance(ID string) error
+	getDeletedInstances() ([]types.DeletedInstance, error)
+
+	// tenant usage history, samples are keyed by tenant, resolution
+	// and timestamp.  getTenantUsageReport may be served by a read
+	// replica lagging behind the primary, getTenantUsage never is.
+	updateTenantUsage(s types.TenantUsageSample) error
+	getTenantUsage(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error)
+	getTenantUsageReport(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error)
+	deleteTenantUsage(tenantID string, resolution types.UsageResolution, before time.Time) error
+	getAllTenantUsage() ([]types.TenantUsageSample, error)
+
+	// transactions, for the writes of composite operations.
+	beginTx() (persistentTx, error)
 }
 
 // Datastore provides context for the datastore package.
 type Datastore struct {
 	db persistentStore
 
+	// faults wraps db when fault injection is enabled.
+	faults *delayedStore
+
 	nodeLastStat     map[string]types.CiaoNode
 	nodeLastStatLock *sync.RWMutex
 
 	instanceLastStat     map[string]types.CiaoServerStats
+	instanceNetSamples   map[string][]types.CiaoNetworkSample
+	instanceDiskSamples  map[string][]types.CiaoDiskIOSample
 	instanceLastStatLock *sync.RWMutex
 
+	// agent timestamps of the last accepted stats samples, protected
+	// by nodeLastStatLock and instanceLastStatLock respectively.
+	nodeLastSample     map[string]time.Time
+	instanceLastSample map[string]statSample
+
+	// statsLock serialises stats handling so that samples are
+	// checked for staleness and applied atomically.
+	statsLock *sync.Mutex
+
+	reports *nodeReports
+
 	tenants     map[string]*tenant
-	tenantsLock *sync.RWMutex
+	tenantsLock *timedRWMutex
 
 	cnciWorkload types.Workload
 
 	nodes     map[string]*node
-	nodesLock *sync.RWMutex
+	nodesLock *timedRWMutex
+
+	// nodes removed after being drained, whose stats are ignored
+	// until they connect again.
+	removedNodes map[string]bool
 
 	instances     map[string]*types.Instance
-	instancesLock *sync.RWMutex
+	instancesLock *timedRWMutex
+
+	// lookup counters of the tenants, instances and block devices
+	// caches.
+	tenantCache      *cacheCounter
+	instanceCache    *cacheCounter
+	blockDeviceCache *cacheCounter
 
 	tenantUsage     map[string][]types.CiaoUsage
 	tenantUsageLock *sync.RWMutex
 
+	// last traffic counters reported by the CNCIs and external traffic
+	// of each instance.
+	cnciTraffic     map[trafficKey]payloads.TrafficCounter
+	instanceTraffic map[string]types.CiaoTrafficUsage
+	trafficLock     *sync.Mutex
+
 	blockDevices map[string]types.Volume
-	bdLock       *sync.RWMutex
+	bdLock       *timedRWMutex
 
 	attachments     map[string]types.StorageAttachment
 	instanceVolumes map[attachment]string
-	attachLock      *sync.RWMutex
+	attachLock      *timedRWMutex
 	// maybe add a map[instanceid][]types.StorageAttachment
 	// to make retrieval of volumes faster.
 
@@ -188,20 +283,69 @@ type Datastore struct {
 	externalSubnets map[string]bool
 	externalIPs     map[string]bool
 	mappedIPs       map[string]types.MappedIP
-	poolsLock       *sync.RWMutex
+	poolsLock       *timedRWMutex
 
-	imageLock      *sync.RWMutex
+	imageLock      *timedRWMutex
 	images         map[string]types.Image
 	publicImages   []string
 	internalImages []string
 
-	workloadsLock   *sync.RWMutex
+	workloadsLock   *timedRWMutex
 	workloads       map[string]types.Workload
 	publicWorkloads []string
+
+	quotaProfiles     map[string]types.QuotaProfile
+	quotaProfilesLock *sync.RWMutex
+
+	// quotas caches the quotas of the tenants read from the database,
+	// indexed by tenant and quota name.
+	quotas     map[string]map[string]int
+	quotasLock *sync.RWMutex
+
+	config     map[string]string
+	configLock *sync.RWMutex
+
+	vnis       map[uint32]types.VNIAllocation
+	subnetVNIs map[subnetKey]uint32
+	vnisLock   *sync.Mutex
+
+	// eventHandler is notified of the events added to the event log.
+	eventHandler     func(types.LogEntry)
+	eventHandlerLock sync.RWMutex
+
+	// revisions are bumped whenever the cached objects they track
+	// are modified. The epoch distinguishes revisions handed out by
+	// different runs of the controller.
+	revisions    map[types.RevisionedResource]uint64
+	revisionLock *sync.RWMutex
+	epoch        int64
+
+	summaries *tenantSummaries
+
+	// watchers are sent the changes made to the datastore objects.
+	watchers     map[*watcher]struct{}
+	watchersLock sync.Mutex
+}
+
+func (ds *Datastore) bumpRevision(r types.RevisionedResource) {
+	ds.revisionLock.Lock()
+	ds.revisions[r]++
+	ds.revisionLock.Unlock()
+}
+
+// Revision returns an opaque string identifying the current state of
+// the given collection of objects. The value changes whenever an object
+// in the collection is added, removed or modified.
+func (ds *Datastore) Revision(r types.RevisionedResource) string {
+	ds.revisionLock.RLock()
+	rev := ds.revisions[r]
+	ds.revisionLock.RUnlock()
+
+	return fmt.Sprintf("%x-%x", ds.epoch, rev)
 }
 
 func (ds *Datastore) initExternalIPs() {
-	ds.poolsLock = &sync.RWMutex{}
+	ds.poolsLock = newTimedRWMutex("pools")
 	ds.externalSubnets = make(map[string]bool)
 	ds.externalIPs = make(map[string]bool)
 
@@ -218,11 +362,32 @@ func (ds *Datastore) initExternalIPs() {
 	}
 
 	ds.mappedIPs = ds.db.getMappedIPs()
+
+	for _, pool := range ds.pools {
+		for i := range pool.Subnets {
+			sub := &pool.Subnets[i]
+			_, ipNet, err := net.ParseCIDR(sub.CIDR)
+			if err != nil {
+				glog.Warningf("Unable to parse subnet CIDR (%v)", sub.CIDR)
+				continue
+			}
+			sub.Free = subnetSize(ipNet)
+		}
+	}
+
+	for address, m := range ds.mappedIPs {
+		pool, ok := ds.pools[m.PoolID]
+		if ok {
+			updateSubnetUsage(&pool, address, true)
+		}
+	}
 }
 
 func (ds *Datastore) initImages() error {
-	ds.imageLock = &sync.RWMutex{}
+	ds.imageLock = newTimedRWMutex("images")
 	ds.images = make(map[string]types.Image)
+	ds.publicImages = nil
+	ds.internalImages = nil
 	images, err := ds.db.getImages()
 	if err != nil {
 		return errors.Wrap(err, "error getting images from database")
@@ -251,8 +416,9 @@ func (ds *Datastore) initImages() error {
 }
 
 func (ds *Datastore) initWorkloads() error {
-	ds.workloadsLock = &sync.RWMutex{}
+	ds.workloadsLock = newTimedRWMutex("workloads")
 	ds.workloads = make(map[string]types.Workload)
+	ds.publicWorkloads = nil
 	workloads, err := ds.db.getWorkloads()
 	if err != nil {
 		return errors.Wrap(err, "error getting workloads from database")
@@ -278,38 +444,74 @@ func (ds *Datastore) initWorkloads() error {
 	return nil
 }
 
+// newPersistentStore returns the backend of the persistent store of a
+// configuration.
+func newPersistentStore(config Config) persistentStore {
+	if config.DBBackend != nil {
+		return config.DBBackend
+	}
+
+	if strings.HasPrefix(config.PersistentURI, "etcd://") ||
+		strings.HasPrefix(config.PersistentURI, "etcds://") {
+		return &etcdDB{}
+	}
+
+	return &sqliteDB{}
+}
+
 // Init initializes the private data for the Datastore object.
 // The sql tables are populated with initial data from csv
 // files if this is the first time the database has been
 // created.  The datastore caches are also filled.
 func (ds *Datastore) Init(config Config) error {
-	ps := config.DBBackend
-
-	if ps == nil {
-		ps = &sqliteDB{}
-	}
+	ps := newPersistentStore(config)
 
 	err := ps.init(config)
 	if err != nil {
 		return errors.Wrap(err, "error initialising persistent store")
 	}
 
+	if config.InjectFaults {
+		ds.faults = &delayedStore{persistentStore: ps}
+		ps = ds.faults
+	}
+
 	ds.db = ps
 
+	return ds.load()
+}
+
+// load initialises the caches of the datastore from its persistent store.
+func (ds *Datastore) load() error {
+	ds.revisions = make(map[types.RevisionedResource]uint64)
+	ds.revisionLock = &sync.RWMutex{}
+	ds.epoch = time.Now().UnixNano()
+
 	ds.nodeLastStat = make(map[string]types.CiaoNode)
 	ds.nodeLastStatLock = &sync.RWMutex{}
 
 	ds.instanceLastStat = make(map[string]types.CiaoServerStats)
+	ds.instanceNetSamples = make(map[string][]types.CiaoNetworkSample)
+	ds.instanceDiskSamples = make(map[string][]types.CiaoDiskIOSample)
 	ds.instanceLastStatLock = &sync.RWMutex{}
 
+	ds.nodeLastSample = make(map[string]time.Time)
+	ds.instanceLastSample = make(map[string]statSample)
+	ds.statsLock = &sync.Mutex{}
+	ds.reports = newNodeReports()
+
+	ds.tenantCache = &cacheCounter{name: "tenants"}
+	ds.instanceCache = &cacheCounter{name: "instances"}
+	ds.blockDeviceCache = &cacheCounter{name: "block_devices"}
+
 	// warning, do not use the tenant cache to get
 	// networking information right now.  that is not
 	// updated, just the resources
 	ds.tenants = make(map[string]*tenant)
-	ds.tenantsLock = &sync.RWMutex{}
+	ds.tenantsLock = newTimedRWMutex("tenants")
 
 	// cache all our instances prior to getting tenants
-	ds.instancesLock = &sync.RWMutex{}
+	ds.instancesLock = newTimedRWMutex("instances")
 	ds.instances = make(map[string]*types.Instance)
 
 	instances, err := ds.db.getInstances()
@@ -341,8 +543,27 @@ func (ds *Datastore) Init(config Config) error {
 		return errors.Wrap(err, "error initialising workloads")
 	}
 
-	ds.nodesLock = &sync.RWMutex{}
+	err = ds.initQuotaProfiles()
+	if err != nil {
+		return errors.Wrap(err, "error initialising quota profiles")
+	}
+
+	ds.quotas = make(map[string]map[string]int)
+	ds.quotasLock = &sync.RWMutex{}
+
+	err = ds.initConfig()
+	if err != nil {
+		return errors.Wrap(err, "error initialising configuration")
+	}
+
+	err = ds.initVNIs()
+	if err != nil {
+		return errors.Wrap(err, "error initialising VNIs")
+	}
+
+	ds.nodesLock = newTimedRWMutex("nodes")
 	ds.nodes = make(map[string]*node)
+	ds.removedNodes = make(map[string]bool)
 
 	for key, i := range ds.instances {
 		_, ok := ds.nodes[i.NodeID]
@@ -365,18 +586,26 @@ func (ds *Datastore) Init(config Config) error {
 		tenant := ds.tenants[i.TenantID]
 		if tenant != nil {
 			tenant.instances[i.ID] = i
+
+			if err := tenant.indexName(i.ID, i.Name); err != nil {
+				glog.Warningf("Instance %s of tenant %s: name %q already in use", i.ID, i.TenantID, i.Name)
+			}
 		}
 	}
 
 	ds.tenantUsage = make(map[string][]types.CiaoUsage)
 	ds.tenantUsageLock = &sync.RWMutex{}
 
+	ds.cnciTraffic = make(map[trafficKey]payloads.TrafficCounter)
+	ds.instanceTraffic = make(map[string]types.CiaoTrafficUsage)
+	ds.trafficLock = &sync.Mutex{}
+
 	ds.blockDevices, err = ds.db.getAllBlockData()
 	if err != nil {
 		return errors.Wrap(err, "error getting block devices from database")
 	}
 
-	ds.bdLock = &sync.RWMutex{}
+	ds.bdLock = newTimedRWMutex("block_devices")
 
 	ds.attachments, err = ds.db.getAllStorageAttachments()
 	if err != nil {
@@ -394,15 +623,26 @@ func (ds *Datastore) Init(config Config) error {
 		ds.instanceVolumes[link] = key
 	}
 
-	ds.attachLock = &sync.RWMutex{}
+	ds.attachLock = newTimedRWMutex("attachments")
 
 	ds.initExternalIPs()
 
+	ds.summaries = newTenantSummaries()
+
+	for _, i := range ds.instances {
+		ds.summaryAddInstance(i)
+	}
+
+	for _, v := range ds.blockDevices {
+		ds.summaries.addVolume(v)
+	}
+
 	return nil
 }
 
 // Exit will disconnect the backing database.
 func (ds *Datastore) Exit() {
+	ds.flushTenantUsage()
 	ds.db.disconnect()
 }
 
@@ -417,6 +657,38 @@ func (ds *Datastore) AddTenant(id string, config types.TenantConfig) (*types.Ten
 		return nil, errors.New("Duplicate Tenant ID")
 	}
 
+	if config.QuotaProfile != "" {
+		if _, err := ds.GetQuotaProfile(config.QuotaProfile); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxInstancesPerRequest < -1 {
+		return nil, types.ErrBadRequest
+	}
+
+	for _, size := range config.WarmPools {
+		if size < 0 {
+			return nil, types.ErrBadRequest
+		}
+	}
+
+	if err := config.Notifications.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.NetworkPolicy.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.InstanceDefaults.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.MaintenanceWindows.Validate(); err != nil {
+		return nil, err
+	}
+
 	err := ds.db.addTenant(id, config)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error adding tenant (%v) to database", id)
@@ -429,6 +701,8 @@ func (ds *Datastore) AddTenant(id string, config types.TenantConfig) (*types.Ten
 
 	ds.tenants[id] = t
 
+	ds.tenantChanged(types.WatchAdded, id)
+
 	return &t.Tenant, nil
 }
 
@@ -446,7 +720,23 @@ func (ds *Datastore) DeleteTenant(ID string) error {
 
 	delete(ds.tenants, ID)
 
-	return ds.db.deleteTenant(ID)
+	ds.summaries.removeTenant(ID)
+
+	if err := ds.ReleaseTenantVNIs(ID); err != nil {
+		return err
+	}
+
+	if err := ds.db.deleteTenant(ID); err != nil {
+		return err
+	}
+
+	ds.quotasLock.Lock()
+	delete(ds.quotas, ID)
+	ds.quotasLock.Unlock()
+
+	ds.tenantChanged(types.WatchDeleted, ID)
+
+	return nil
 }
 
 func (ds *Datastore) getTenant(id string) (*tenant, error) {
@@ -455,6 +745,8 @@ func (ds *Datastore) getTenant(id string) (*tenant, error) {
 	t := ds.tenants[id]
 	ds.tenantsLock.RUnlock()
 
+	ds.tenantCache.lookup(t != nil)
+
 	if t != nil {
 		return t, nil
 	}
@@ -475,6 +767,12 @@ func (ds *Datastore) GetTenant(id string) (*types.Tenant, error) {
 
 // JSONPatchTenant will update a tenant with changes from a json merge patch.
 func (ds *Datastore) JSONPatchTenant(ID string, patch []byte) error {
+	return ds.PatchTenant(ID, patch, types.MergePatch)
+}
+
+// PatchTenant will update a tenant with changes from a json merge patch
+// or a list of json patch operations.
+func (ds *Datastore) PatchTenant(ID string, patch []byte, format types.PatchFormat) error {
 	var config types.TenantConfig
 
 	ds.tenantsLock.Lock()
@@ -487,33 +785,60 @@ func (ds *Datastore) JSONPatchTenant(ID string, patch []byte) error {
 
 	oldconfig := tenant.TenantConfig
 
-	orig, err := json.Marshal(oldconfig)
-	if err != nil {
-		return errors.Wrap(err, "error updating tenant")
+	// SubnetBits must not modified if there are active instances.
+	// for now, the cncis must also be removed. Tenants with instances
+	// are moved to new subnets by the controller, see ResubnetTenant.
+	var immutable []string
+	if len(tenant.instances) > 0 {
+		immutable = append(immutable, "subnet_bits")
 	}
 
-	new, err := jsonpatch.MergePatch(orig, patch)
+	err := utils.ApplyPatch(oldconfig, &config, patch, format, immutable...)
 	if err != nil {
-		return errors.Wrap(err, "error updating tenant")
+		return err
 	}
 
-	err = json.Unmarshal(new, &config)
-	if err != nil {
-		return errors.Wrap(err, "error updating tenant")
+	if config.QuotaProfile != "" && config.QuotaProfile != oldconfig.QuotaProfile {
+		if _, err := ds.GetQuotaProfile(config.QuotaProfile); err != nil {
+			return err
+		}
 	}
 
-	// SubnetBits must not modified if there are active instances.
-	// for now, the cncis must also be removed. In the future we might
-	// be able to just update the cnci with the new subnet info.
-	if len(tenant.instances) > 0 {
-		if oldconfig.SubnetBits != config.SubnetBits {
-			return errors.New("Unable to update with active instances")
+	if config.MaxInstancesPerRequest < -1 {
+		return types.ErrBadRequest
+	}
+
+	for _, size := range config.WarmPools {
+		if size < 0 {
+			return types.ErrBadRequest
 		}
 	}
 
+	if err := config.Notifications.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.NetworkPolicy.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.InstanceDefaults.Validate(); err != nil {
+		return err
+	}
+
+	if err := config.MaintenanceWindows.Validate(); err != nil {
+		return err
+	}
+
 	tenant.TenantConfig = config
 
-	return ds.db.updateTenant(&tenant.Tenant)
+	if err := ds.db.updateTenant(&tenant.Tenant); err != nil {
+		return err
+	}
+
+	ds.tenantChanged(types.WatchUpdated, ID)
+
+	return nil
 }
 
 // AddWorkload is used to add a new workload to the datastore.
@@ -600,6 +925,39 @@ func (ds *Datastore) DeleteWorkload(workloadID string) error {
 	return nil
 }
 
+// UpdateWorkload replaces a workload in the datastore. The visibility
+// and the tenant of the workload cannot be changed.
+func (ds *Datastore) UpdateWorkload(w types.Workload) error {
+	ds.workloadsLock.Lock()
+	defer ds.workloadsLock.Unlock()
+
+	old, ok := ds.workloads[w.ID]
+	if !ok {
+		return types.ErrWorkloadNotFound
+	}
+
+	if old.TenantID != w.TenantID || old.Visibility != w.Visibility {
+		return errors.New("Changing visibility or tenant for workload not permitted")
+	}
+
+	err := ds.db.deleteWorkload(w.ID)
+	if err != nil {
+		return errors.Wrapf(err, "error updating workload %v in database", w.ID)
+	}
+
+	err = ds.db.addWorkload(w)
+	if err != nil {
+		if rerr := ds.db.addWorkload(old); rerr != nil {
+			glog.Errorf("Unable to restore workload %v: %v", w.ID, rerr)
+		}
+		return errors.Wrapf(err, "error updating workload %v in database", w.ID)
+	}
+
+	ds.workloads[w.ID] = w
+
+	return nil
+}
+
 // GetWorkload returns details about a specific workload referenced by id
 func (ds *Datastore) GetWorkload(ID string) (types.Workload, error) {
 	if ID == ds.cnciWorkload.ID {
@@ -659,7 +1017,134 @@ func (ds *Datastore) getWorkloads(tenantID string, includePublic bool) ([]types.
 
 // UpdateInstance will update certain fields of an instance
 func (ds *Datastore) UpdateInstance(instance *types.Instance) error {
-	return ds.db.updateInstance(instance)
+	var oldName string
+
+	ds.tenantsLock.Lock()
+	tenant := ds.tenants[instance.TenantID]
+	if tenant != nil {
+		oldName = tenant.instanceNames[instance.ID]
+		if err := tenant.indexName(instance.ID, instance.Name); err != nil {
+			ds.tenantsLock.Unlock()
+			return err
+		}
+	}
+	ds.tenantsLock.Unlock()
+
+	if err := ds.db.updateInstance(instance); err != nil {
+		if tenant != nil {
+			ds.tenantsLock.Lock()
+			_ = tenant.indexName(instance.ID, oldName)
+			ds.tenantsLock.Unlock()
+		}
+		return err
+	}
+
+	ds.bumpRevision(types.InstancesRevision)
+	ds.summaries.instanceState(instance.ID, instance.State)
+
+	ds.instanceChanged(types.WatchUpdated, instance.ID, instance.TenantID, instance.State)
+
+	return nil
+}
+
+// ProtectInstance sets or clears the deletion protection of an instance.
+func (ds *Datastore) ProtectInstance(instanceID string, protected bool) error {
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		return types.ErrInstanceNotFound
+	}
+
+	old := i.Protected
+	i.Protected = protected
+
+	err := ds.db.updateInstance(i)
+	if err != nil {
+		i.Protected = old
+		return errors.Wrapf(err, "error updating instance (%v) in database", instanceID)
+	}
+
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchUpdated, i.ID, i.TenantID, i.State)
+
+	return nil
+}
+
+// HotAddInstanceResources records vCPUs and memory hot added to, or, when
+// negative, taken back from, a running instance.
+func (ds *Datastore) HotAddInstanceResources(instanceID string, vcpus int, memMB int) error {
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		return types.ErrInstanceNotFound
+	}
+
+	i.ExtraVCPUs += vcpus
+	i.ExtraMemMB += memMB
+
+	err := ds.db.updateInstance(i)
+	if err != nil {
+		i.ExtraVCPUs -= vcpus
+		i.ExtraMemMB -= memMB
+		return errors.Wrapf(err, "error updating instance (%v) in database", instanceID)
+	}
+
+	ds.summaries.instanceResources(instanceID, vcpus, memMB)
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchUpdated, i.ID, i.TenantID, i.State)
+
+	return nil
+}
+
+// ClaimInstance takes a warm instance out of its warm pool, giving it the
+// name and trace label of the request claiming it.  An instance can only
+// be claimed once.
+func (ds *Datastore) ClaimInstance(instanceID string, name string, traceLabel string) error {
+	ds.instancesLock.Lock()
+	defer ds.instancesLock.Unlock()
+
+	i, ok := ds.instances[instanceID]
+	if !ok {
+		return types.ErrInstanceNotFound
+	}
+
+	if !i.Warm {
+		return fmt.Errorf("instance %s is not warm", instanceID)
+	}
+
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	tenant := ds.tenants[i.TenantID]
+	if tenant != nil {
+		if err := tenant.indexName(i.ID, name); err != nil {
+			return err
+		}
+	}
+
+	oldName, oldLabel := i.Name, i.TraceLabel
+	i.Warm = false
+	i.Name = name
+	i.TraceLabel = traceLabel
+
+	err := ds.db.updateInstance(i)
+	if err != nil {
+		i.Warm = true
+		i.Name, i.TraceLabel = oldName, oldLabel
+		if tenant != nil {
+			_ = tenant.indexName(i.ID, oldName)
+		}
+		return errors.Wrapf(err, "error updating instance (%v) in database", instanceID)
+	}
+
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchUpdated, i.ID, i.TenantID, i.State)
+
+	return nil
 }
 
 // GetAllTenants returns all the tenants from the datastore.
@@ -677,17 +1162,30 @@ func (ds *Datastore) GetAllTenants() ([]*types.Tenant, error) {
 // Once a tenant IP address is released, it can be reassigned to another
 // instance.
 func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
-	removeSubnet := false
-	var i uint32
+	addr, ipNet, err := ds.tenantIPAddr(tenantID, ip)
+	if err != nil {
+		return err
+	}
+
+	ds.tenantIPReleased(tenantID, addr, ipNet)
+
+	return ds.db.releaseTenantIP(tenantID, addr.subnet, addr.host)
+}
 
+// tenantIPAddr parses an IP address of the network of a tenant, returning
+// the address along with the subnet it belongs to.
+func (ds *Datastore) tenantIPAddr(tenantID string, ip string) (tenantIP, net.IPNet, error) {
 	ipAddr := net.ParseIP(ip)
 	if ipAddr == nil {
-		return errors.New("Invalid IPv4 Address")
+		return tenantIP{}, net.IPNet{}, errors.New("Invalid IPv4 Address")
 	}
 
 	tenant, err := ds.GetTenant(tenantID)
 	if err != nil {
-		return err
+		return tenantIP{}, net.IPNet{}, err
+	}
+	if tenant == nil {
+		return tenantIP{}, net.IPNet{}, ErrNoTenant
 	}
 
 	mask := net.CIDRMask(tenant.SubnetBits, 32)
@@ -695,21 +1193,26 @@ func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
 		IP:   ipAddr.Mask(mask),
 		Mask: mask,
 	}
-	subMask := binary.BigEndian.Uint32(ipNet.Mask)
-	hostInt := binary.BigEndian.Uint32(ipAddr.To4())
-	subnetInt := hostInt & subMask
 
-	// clear from cache
+	return newTenantIP(ipAddr, tenant.SubnetBits), ipNet, nil
+}
+
+// tenantIPReleased clears a released IP address from the cache, and asks
+// the CNCI of the tenant to remove the subnet of the address once it is
+// no longer used.
+func (ds *Datastore) tenantIPReleased(tenantID string, addr tenantIP, ipNet net.IPNet) {
+	removeSubnet := false
+	subnetInt, hostInt := addr.subnet, addr.host
+
 	ds.tenantsLock.Lock()
 
 	if ds.tenants[tenantID] != nil {
 		delete(ds.tenants[tenantID].network[subnetInt], hostInt)
 		network := ds.tenants[tenantID].network
-		i = subnetInt
 
-		if len(network[i]) == 0 {
+		if len(network[subnetInt]) == 0 {
 			// delete the network map and the subnet
-			delete(ds.tenants[tenantID].network, i)
+			delete(ds.tenants[tenantID].network, subnetInt)
 
 			removeSubnet = true
 		}
@@ -723,24 +1226,116 @@ func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
 	}
 
 	ds.tenantsLock.Unlock()
-
-	return ds.db.releaseTenantIP(tenantID, subnetInt, hostInt)
 }
 
-// lock for tenant must be held.
-func (ds *Datastore) cleanTenantIPs(tenantID string, IPs []tenantIP) {
-	for _, IP := range IPs {
-		delete(ds.tenants[tenantID].network[IP.subnet], IP.host)
+// GetTenantIPs returns the IP addresses claimed in the network of a tenant,
+// sorted by address, along with the instances they are assigned to.  Claims
+// not held by any instance of the tenant are reported as orphaned.
+func (ds *Datastore) GetTenantIPs(tenantID string) ([]types.TenantIP, error) {
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
 
-		network := ds.tenants[tenantID].network
-		if len(network[IP.subnet]) == 0 {
-			delete(ds.tenants[tenantID].network, IP.subnet)
+	tenant, ok := ds.tenants[tenantID]
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	owners := make(map[string]string)
+	for _, i := range tenant.instances {
+		if i.IPAddress != "" {
+			owners[i.IPAddress] = i.ID
 		}
 	}
-}
 
-// lock for tenant must not be held here.
-func (ds *Datastore) activateSubnets(tenantID string, IPs []net.IP) error {
+	var hosts []uint32
+	for _, subnet := range tenant.network {
+		for host := range subnet {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i] < hosts[j] })
+
+	ips := []types.TenantIP{}
+	for _, host := range hosts {
+		addr := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(addr, host)
+
+		ip := types.TenantIP{
+			Address:    addr.String(),
+			InstanceID: owners[addr.String()],
+		}
+		ip.Orphaned = ip.InstanceID == ""
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// ReleaseOrphanedTenantIPs clears claims on tenant IP addresses which are
+// not assigned to any instance, both from the cache and from the database.
+// If addresses is empty all the orphaned claims of the tenant are released,
+// otherwise only the listed addresses are, and all of them must be orphaned.
+// The released addresses are returned.  As addresses are claimed before
+// the instances using them are added, claims made by launches still in
+// progress are reported as orphaned too.
+func (ds *Datastore) ReleaseOrphanedTenantIPs(tenantID string, addresses []string) ([]string, error) {
+	ips, err := ds.GetTenantIPs(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]types.TenantIP)
+	for _, ip := range ips {
+		claims[ip.Address] = ip
+	}
+
+	var release []string
+	if len(addresses) == 0 {
+		for _, ip := range ips {
+			if ip.Orphaned {
+				release = append(release, ip.Address)
+			}
+		}
+	} else {
+		for _, addr := range addresses {
+			ip, ok := claims[addr]
+			if !ok {
+				return nil, types.ErrAddressNotFound
+			}
+
+			if !ip.Orphaned {
+				return nil, types.ErrTenantIPInUse
+			}
+			release = append(release, addr)
+		}
+	}
+
+	released := []string{}
+	for _, addr := range release {
+		err := ds.ReleaseTenantIP(tenantID, addr)
+		if err != nil {
+			return released, errors.Wrapf(err, "error releasing tenant IP (%v)", addr)
+		}
+		released = append(released, addr)
+	}
+
+	return released, nil
+}
+
+// lock for tenant must be held.
+func (ds *Datastore) cleanTenantIPs(tenantID string, IPs []tenantIP) {
+	for _, IP := range IPs {
+		delete(ds.tenants[tenantID].network[IP.subnet], IP.host)
+
+		network := ds.tenants[tenantID].network
+		if len(network[IP.subnet]) == 0 {
+			delete(ds.tenants[tenantID].network, IP.subnet)
+		}
+	}
+}
+
+// lock for tenant must not be held here.
+func (ds *Datastore) activateSubnets(tenantID string, IPs []net.IP) error {
 	mgr := ds.tenants[tenantID].CNCIctrl
 	if mgr == nil {
 		return nil
@@ -763,34 +1358,54 @@ func (ds *Datastore) activateSubnets(tenantID string, IPs []net.IP) error {
 	return nil
 }
 
+// TenantNetwork is the network divided into the subnets of the tenants.
+const TenantNetwork = "172.16.0.0/12"
+
+// tenantAllocator returns an allocator for the subnets of a tenant holding
+// the addresses already claimed by the tenant.  The network, gateway and
+// broadcast addresses of each subnet are never allocated.  lock for tenant
+// must be held.
+func tenantAllocator(t *tenant) (*ipalloc.Allocator, error) {
+	alloc, err := ipalloc.New(TenantNetwork, t.SubnetBits, 0, 1, -1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating allocator for tenant (%v)", t.ID)
+	}
+
+	for _, hosts := range t.network {
+		for host, claimed := range hosts {
+			if !claimed {
+				continue
+			}
+
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, host)
+			if err := alloc.Claim(ip); err != nil {
+				return nil, errors.Wrapf(err, "error restoring claim on %v", ip)
+			}
+		}
+	}
+
+	return alloc, nil
+}
+
+// newTenantIP returns the subnet and host numbers of a tenant address, as
+// stored in the database.
+func newTenantIP(ip net.IP, subnetBits int) tenantIP {
+	host := binary.BigEndian.Uint32(ip.To4())
+	mask := binary.BigEndian.Uint32(net.CIDRMask(subnetBits, 32))
+	return tenantIP{subnet: host & mask, host: host}
+}
+
 // AllocateTenantIPPool will reserve a pool of IP addresses for the caller.
 func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, error) {
 	var addrs []net.IP
 	var tenantAddrs []tenantIP
 	var retval error
-	tenant, err := ds.GetTenant(tenantID)
+	_, err := ds.GetTenant(tenantID)
 	if err != nil {
 		return nil, err
 	}
 
-	// hardcode start address and max address for tenant network.
-	cidr := fmt.Sprintf("%s/%d", "172.16.0.0", tenant.SubnetBits)
-	IP, ipNet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return nil, err
-	}
-
-	start := binary.BigEndian.Uint32(IP.Mask(ipNet.Mask))
-	end := start >> 20
-	end = end + uint32(1)
-	end = end << 20
-	ones, bits := ipNet.Mask.Size()
-	hostBits := uint32(bits - ones)
-	maxHosts := (1 << hostBits)
-	mask := binary.BigEndian.Uint32(ipNet.Mask)
-
-	var hostCount int
-
 	ds.tenantsLock.Lock()
 	defer func() {
 		ds.tenantsLock.Unlock()
@@ -803,60 +1418,92 @@ func (ds *Datastore) AllocateTenantIPPool(tenantID string, num int) ([]net.IP, e
 		}
 	}()
 
-	subnets := ds.tenants[tenantID].network
+	t := ds.tenants[tenantID]
 
-	// look for any subnets that have available host nums
-	for k, v := range subnets {
-		if len(v) < maxHosts {
-			start = k
-			break
-		}
+	alloc, err := tenantAllocator(t)
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		if start >= end {
-			ds.cleanTenantIPs(tenantID, tenantAddrs)
-			addrs = nil
-			return nil, errors.New("out of addrs")
-		}
-
-		// if we have not yet allocated out of this subnet,
-		// we need to make a new map to hold the host addrs.
-		subnetNum := start & mask
-		if subnets[subnetNum] == nil {
-			subnets[subnetNum] = make(map[uint32]bool)
-		}
-		netmap := subnets[subnetNum]
-
-		// skip network, gateway, and broadcast addrs.
-		for host := 2; host < maxHosts-1; host++ {
-			if netmap[start+uint32(host)] == false {
-				addr := start + uint32(host)
-				netmap[addr] = true
-				newIP := make(net.IP, net.IPv4len)
-				binary.BigEndian.PutUint32(newIP, addr)
-				addrs = append(addrs, newIP)
-				tenantAddrs = append(tenantAddrs, tenantIP{subnetNum, addr})
-				hostCount++
-				if hostCount == num {
-					// attempt bulk db insert here.
-					err := ds.db.claimTenantIPs(tenantID, tenantAddrs)
-					if err != nil {
-						ds.cleanTenantIPs(tenantID, tenantAddrs)
-						addrs = nil
-						return nil, err
-					}
-
-					// go ahead and return the IPs to the
-					// user but possibly with error.
-					return addrs, retval
-				}
-			}
+	IPs, err := alloc.Allocate(num)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error allocating %d addresses for tenant (%v)", num, tenantID)
+	}
+
+	for _, IP := range IPs {
+		addr := newTenantIP(IP, t.SubnetBits)
+		if t.network[addr.subnet] == nil {
+			t.network[addr.subnet] = make(map[uint32]bool)
 		}
+		t.network[addr.subnet][addr.host] = true
+		tenantAddrs = append(tenantAddrs, addr)
+	}
 
-		// skip to the start of the next subnet
-		start += uint32(maxHosts)
+	// attempt bulk db insert here.
+	err = ds.db.claimTenantIPs(tenantID, tenantAddrs)
+	if err != nil {
+		ds.cleanTenantIPs(tenantID, tenantAddrs)
+		return nil, err
 	}
+
+	// go ahead and return the IPs to the
+	// user but possibly with error.
+	addrs = IPs
+	return addrs, retval
+}
+
+// ResubnetTenant changes the subnet bits of a tenant and releases all the
+// IP addresses allocated to its instances. It is the responsibility of
+// the caller to stop the instances and remove the CNCIs of the tenant
+// first, and to allocate new addresses to the instances afterwards.
+func (ds *Datastore) ResubnetTenant(tenantID string, subnetBits int) error {
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	tenant, ok := ds.tenants[tenantID]
+	if !ok {
+		return ErrNoTenant
+	}
+
+	err := ds.db.releaseTenantIPs(tenantID)
+	if err != nil {
+		return errors.Wrapf(err, "error releasing IPs of tenant (%v)", tenantID)
+	}
+	tenant.network = make(map[uint32]map[uint32]bool)
+
+	tenant.SubnetBits = subnetBits
+
+	if err := ds.db.updateTenant(&tenant.Tenant); err != nil {
+		return err
+	}
+
+	ds.tenantChanged(types.WatchUpdated, tenantID)
+
+	return nil
+}
+
+// PeekTenantIP returns the IP address the next allocation for a tenant
+// would return, without claiming it.
+func (ds *Datastore) PeekTenantIP(tenantID string) (net.IP, error) {
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+
+	t, ok := ds.tenants[tenantID]
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	alloc, err := tenantAllocator(t)
+	if err != nil {
+		return nil, err
+	}
+
+	IPs, err := alloc.Allocate(1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error allocating address for tenant (%v)", tenantID)
+	}
+
+	return IPs[0], nil
 }
 
 // AllocateTenantIP will allocate a single IP address for a tenant.
@@ -907,6 +1554,8 @@ func (ds *Datastore) GetInstance(id string) (*types.Instance, error) {
 
 	ds.instancesLock.RUnlock()
 
+	ds.instanceCache.lookup(ok)
+
 	if !ok {
 		return nil, types.ErrInstanceNotFound
 	}
@@ -924,6 +1573,8 @@ func (ds *Datastore) GetTenantInstance(tenantID string, instanceID string) (*typ
 
 	ds.instancesLock.RUnlock()
 
+	ds.instanceCache.lookup(ok)
+
 	if !ok || value.TenantID != tenantID || value.CNCI == true {
 		return nil, types.ErrInstanceNotFound
 	}
@@ -965,6 +1616,54 @@ func (ds *Datastore) GetTenantCNCIs(tenantID string) ([]*types.Instance, error)
 	return ds.getTenantInstances(tenantID, true)
 }
 
+// ListInstances returns the instances matching filter, sorted by ID. Only
+// instances whose ID sorts after marker are returned and, if limit is
+// greater than zero, at most limit of them. The total number of instances
+// matching the filter, regardless of marker and limit, is also returned.
+// CNCI instances are never listed.
+func (ds *Datastore) ListInstances(filter types.InstanceFilter, marker string, limit int) ([]*types.Instance, int, error) {
+	var instances []*types.Instance
+
+	if limit < 0 {
+		return nil, 0, types.ErrBadRequest
+	}
+
+	if filter.TenantID != "" {
+		ds.tenantsLock.RLock()
+		if t, ok := ds.tenants[filter.TenantID]; ok {
+			for _, val := range t.instances {
+				if !val.CNCI && filter.Match(val) {
+					instances = append(instances, val)
+				}
+			}
+		}
+		ds.tenantsLock.RUnlock()
+	} else {
+		ds.instancesLock.RLock()
+		for _, val := range ds.instances {
+			if !val.CNCI && filter.Match(val) {
+				instances = append(instances, val)
+			}
+		}
+		ds.instancesLock.RUnlock()
+	}
+
+	total := len(instances)
+
+	sort.Sort(types.SortedInstancesByID(instances))
+
+	start := sort.Search(len(instances), func(i int) bool {
+		return instances[i].ID > marker
+	})
+	instances = instances[start:]
+
+	if limit > 0 && len(instances) > limit {
+		instances = instances[:limit]
+	}
+
+	return instances, total, nil
+}
+
 // GetAllInstancesByNode will retrieve all the instances running on a specific compute Node.
 func (ds *Datastore) GetAllInstancesByNode(nodeID string) ([]*types.Instance, error) {
 	var instances []*types.Instance
@@ -988,39 +1687,79 @@ func (ds *Datastore) GetAllInstancesByNode(nodeID string) ([]*types.Instance, er
 // AddInstance will store a new instance in the datastore.
 // The instance will be updated both in the cache and in the database
 func (ds *Datastore) AddInstance(instance *types.Instance) error {
-	err := ds.db.addInstance(instance)
+	return ds.AddInstances([]*types.Instance{instance})
+}
+
+// AddInstances stores new instances in the datastore with a single
+// write to the database, and then updates the cache in a single pass.
+// It is meant for launches of many instances, for which adding them one
+// at a time would be dominated by the cost of the database writes.
+func (ds *Datastore) AddInstances(instances []*types.Instance) error {
+	if len(instances) == 0 {
+		return nil
+	}
 
+	ds.tenantsLock.Lock()
+	err := ds.indexNames(instances)
+	ds.tenantsLock.Unlock()
 	if err != nil {
+		return err
+	}
+
+	err = ds.db.addInstances(instances)
+	if err != nil {
+		ds.tenantsLock.Lock()
+		ds.unindexNames(instances)
+		ds.tenantsLock.Unlock()
 		return errors.Wrap(err, "Error adding instance to database")
 	}
 
-	// add to cache
+	ds.instancesCached(instances)
+
+	return nil
+}
+
+// instancesCached adds instances stored in the persistent store to the
+// caches.  The names of the instances must already be indexed.
+func (ds *Datastore) instancesCached(instances []*types.Instance) {
+	now := time.Now()
+
 	ds.instancesLock.Lock()
+	ds.instanceLastStatLock.Lock()
 
-	ds.instances[instance.ID] = instance
+	for _, instance := range instances {
+		ds.instances[instance.ID] = instance
 
-	instanceStat := types.CiaoServerStats{
-		ID:        instance.ID,
-		TenantID:  instance.TenantID,
-		NodeID:    instance.NodeID,
-		Timestamp: time.Now(),
-		Status:    instance.State,
+		ds.instanceLastStat[instance.ID] = types.CiaoServerStats{
+			ID:        instance.ID,
+			TenantID:  instance.TenantID,
+			NodeID:    instance.NodeID,
+			Timestamp: now,
+			Status:    instance.State,
+		}
 	}
 
-	ds.instanceLastStatLock.Lock()
-	ds.instanceLastStat[instance.ID] = instanceStat
 	ds.instanceLastStatLock.Unlock()
-
 	ds.instancesLock.Unlock()
 
 	ds.tenantsLock.Lock()
-	tenant := ds.tenants[instance.TenantID]
-	if tenant != nil {
-		tenant.instances[instance.ID] = instance
+	for _, instance := range instances {
+		tenant := ds.tenants[instance.TenantID]
+		if tenant != nil {
+			tenant.instances[instance.ID] = instance
+		}
 	}
 	ds.tenantsLock.Unlock()
 
-	return nil
+	for _, instance := range instances {
+		ds.summaryAddInstance(instance)
+	}
+
+	ds.bumpRevision(types.InstancesRevision)
+
+	for _, instance := range instances {
+		ds.instanceChanged(types.WatchAdded, instance.ID, instance.TenantID, instance.State)
+	}
 }
 
 // StartFailure will clean up after a failure to start an instance.
@@ -1060,12 +1799,45 @@ func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailur
 
 	msg := fmt.Sprintf("Start Failure %s: %s", instanceID, reason.String())
 	e := types.LogEntry{
-		TenantID:  i.TenantID,
-		EventType: string(userError),
-		Message:   msg,
-		NodeID:    nodeID,
+		TenantID:   i.TenantID,
+		Severity:   types.EventError,
+		Category:   types.EventCategoryInstance,
+		Message:    msg,
+		NodeID:     nodeID,
+		InstanceID: instanceID,
+		Reason:     string(reason),
 	}
-	return errors.Wrap(ds.db.logEvent(e), "Error logging event")
+	return errors.Wrap(ds.logEvent(e), "Error logging event")
+}
+
+// StartRetry records a failure to start an instance on a node after which
+// the launch of the instance is retried on another node.  The failure is
+// accounted to the node but, unlike StartFailure, the instance is kept.
+func (ds *Datastore) StartRetry(instanceID string, reason payloads.StartFailureReason, nodeID string, attempt int, retries int) error {
+	i, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return errors.Wrapf(err, "error getting instance (%v)", instanceID)
+	}
+
+	ds.nodesLock.Lock()
+	defer ds.nodesLock.Unlock()
+
+	n, ok := ds.nodes[nodeID]
+	if ok {
+		n.TotalFailures++
+		n.StartFailures++
+	}
+
+	msg := fmt.Sprintf("Start Failure %s: %s, retrying on another node (attempt %d of %d)",
+		instanceID, reason.String(), attempt, retries)
+	e := types.LogEntry{
+		TenantID: i.TenantID,
+		Severity: types.EventWarning,
+		Category: types.EventCategoryInstance,
+		Message:  msg,
+		NodeID:   nodeID,
+	}
+	return errors.Wrap(ds.logEvent(e), "Error logging event")
 }
 
 // AttachVolumeFailure will clean up after a failure to attach a volume.
@@ -1103,27 +1875,111 @@ func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, rea
 
 	msg := fmt.Sprintf("Attach Volume Failure %s to %s: %s", volumeID, instanceID, reason.String())
 	e := types.LogEntry{
-		TenantID:  i.TenantID,
-		EventType: string(userError),
-		Message:   msg,
-		NodeID:    i.NodeID,
+		TenantID: i.TenantID,
+		Severity: types.EventError,
+		Category: types.EventCategoryStorage,
+		Message:  msg,
+		NodeID:   i.NodeID,
 	}
 
-	return errors.Wrap(ds.db.logEvent(e), "Error logging event")
+	return errors.Wrap(ds.logEvent(e), "Error logging event")
+}
+
+// deleteInstanceData deletes an instance from the persistent store, along
+// with its storage attachments, and releases its IP address unless addr is
+// nil.  A record of the instance is kept if the records of deleted
+// instances are retained.  Either all these writes are stored or none is.
+func (ds *Datastore) deleteInstanceData(i *types.Instance, addr *tenantIP) error {
+	ds.attachLock.Lock()
+	defer ds.attachLock.Unlock()
+
+	record := !i.CNCI && ds.DeletedInstanceRetention() > 0
+
+	var links []types.StorageAttachment
+	var volumes []types.Volume
+
+	err := ds.transaction(func(tx persistentTx) error {
+		err := tx.deleteInstance(i.ID)
+		if err != nil {
+			return errors.Wrapf(err, "error deleting instance from database (%v)", i.ID)
+		}
+
+		if record {
+			err = tx.addDeletedInstance(deletedInstance(i, time.Now().UTC()))
+			if err != nil {
+				return errors.Wrapf(err, "error recording deleted instance (%v)", i.ID)
+			}
+		}
+
+		if addr != nil {
+			err = tx.releaseTenantIP(i.TenantID, addr.subnet, addr.host)
+			if err != nil {
+				return errors.Wrapf(err, "error releasing IP for instance (%v)", i.ID)
+			}
+		}
+
+		links, volumes, err = ds.detachStorage(tx, i.ID)
+		return errors.Wrapf(err, "error updating storage attachments of instance (%v)", i.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	ds.storageDetached(links, volumes)
+
+	return nil
 }
 
 func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
-	if err := ds.db.deleteInstance(instanceID); err != nil {
+	ds.instancesLock.RLock()
+	i, ok := ds.instances[instanceID]
+	ds.instancesLock.RUnlock()
+
+	if !ok {
+		return "", types.ErrInstanceNotFound
+	}
+
+	var addr *tenantIP
+	var ipNet net.IPNet
+
+	if i.CNCI == false {
+		ip, n, err := ds.tenantIPAddr(i.TenantID, i.IPAddress)
+		if err != nil {
+			glog.Warningf("error releasing IP for instance (%v): %v", i.ID, err)
+		} else {
+			addr, ipNet = &ip, n
+		}
+	}
+
+	if err := ds.deleteInstanceData(i, addr); err != nil {
 		glog.Warningf("error deleting instance (%v): %v", instanceID, err)
-		return "", errors.Wrapf(err, "error deleting instance from database (%v)", instanceID)
+		return "", err
+	}
+
+	ds.instanceDeleted(i)
+
+	if addr != nil {
+		ds.tenantIPReleased(i.TenantID, *addr, ipNet)
 	}
 
+	return i.TenantID, nil
+}
+
+// instanceDeleted removes an instance deleted from the persistent store
+// from the caches.
+func (ds *Datastore) instanceDeleted(i *types.Instance) {
+	instanceID := i.ID
+
 	ds.instanceLastStatLock.Lock()
 	delete(ds.instanceLastStat, instanceID)
+	delete(ds.instanceLastSample, instanceID)
+	delete(ds.instanceNetSamples, instanceID)
+	delete(ds.instanceDiskSamples, instanceID)
 	ds.instanceLastStatLock.Unlock()
 
+	ds.deleteInstanceTraffic(instanceID)
+
 	ds.instancesLock.Lock()
-	i := ds.instances[instanceID]
 	delete(ds.instances, instanceID)
 	ds.instancesLock.Unlock()
 
@@ -1131,6 +1987,7 @@ func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
 	tenant := ds.tenants[i.TenantID]
 	if tenant != nil {
 		delete(tenant.instances, instanceID)
+		tenant.unindexName(instanceID)
 	}
 	ds.tenantsLock.Unlock()
 
@@ -1141,24 +1998,9 @@ func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
 		ds.nodesLock.Unlock()
 	}
 
-	var err error
-	if tmpErr := ds.db.deleteInstance(i.ID); tmpErr != nil {
-		glog.Warningf("error deleting instance (%v): %v", i.ID, err)
-		err = errors.Wrapf(tmpErr, "error deleting instance from database (%v)", i.ID)
-	}
-
-	if i.CNCI == false {
-		if tmpErr := ds.ReleaseTenantIP(i.TenantID, i.IPAddress); tmpErr != nil {
-			glog.Warningf("error releasing IP for instance (%v): %v", i.ID, tmpErr)
-			if err == nil {
-				err = errors.Wrapf(err, "error releasing IP for instance (%v)", i.ID)
-			}
-		}
-	}
-
-	ds.updateStorageAttachments(instanceID)
-
-	return i.TenantID, err
+	ds.summaries.removeInstance(instanceID)
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchDeleted, instanceID, i.TenantID, "")
 }
 
 // DeleteInstance removes an instance from the datastore.
@@ -1177,12 +2019,13 @@ func (ds *Datastore) DeleteInstance(instanceID string) error {
 
 	msg := fmt.Sprintf("Deleted Instance %s", instanceID)
 	e := types.LogEntry{
-		TenantID:  tenantID,
-		EventType: string(userInfo),
-		Message:   msg,
-		NodeID:    nodeID,
+		TenantID: tenantID,
+		Severity: types.EventInfo,
+		Category: types.EventCategoryInstance,
+		Message:  msg,
+		NodeID:   nodeID,
 	}
-	return errors.Wrap(ds.db.logEvent(e), "Error logging event")
+	return errors.Wrap(ds.logEvent(e), "Error logging event")
 }
 
 func (ds *Datastore) updateInstanceStatus(status, instanceID string) error {
@@ -1212,33 +2055,51 @@ func (ds *Datastore) updateInstanceStatus(status, instanceID string) error {
 
 // InstanceRestarting resets a restarting instance's state to pending.
 func (ds *Datastore) InstanceRestarting(instanceID string) error {
-	err := ds.updateInstanceStatus(payloads.Pending, instanceID)
+	ds.instancesLock.Lock()
+	i := ds.instances[instanceID]
+	err := i.TransitionInstanceState(payloads.Pending)
+	ds.instancesLock.Unlock()
+
 	if err != nil {
+		glog.Warningf("Instance %s not restarting: %v", instanceID, err)
 		return errors.Wrap(err, "Error marking instance as restarting")
 	}
 
-	ds.instancesLock.Lock()
-	i := ds.instances[instanceID]
-	i.State = payloads.Pending
-	ds.instancesLock.Unlock()
+	err = ds.updateInstanceStatus(payloads.Pending, instanceID)
+	if err != nil {
+		return errors.Wrap(err, "Error marking instance as restarting")
+	}
+
+	ds.summaries.instanceState(instanceID, payloads.Pending)
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchUpdated, instanceID, i.TenantID, payloads.Pending)
 
 	return nil
 }
 
 // InstanceStopped removes the link between an instance and its node
 func (ds *Datastore) InstanceStopped(instanceID string) error {
-	err := ds.updateInstanceStatus(payloads.Exited, instanceID)
+	ds.instancesLock.Lock()
+	i := ds.instances[instanceID]
+	err := i.TransitionInstanceState(payloads.Exited)
 	if err != nil {
+		ds.instancesLock.Unlock()
+		glog.Warningf("Instance %s not stopped: %v", instanceID, err)
 		return errors.Wrap(err, "Error marked instance as stopped")
 	}
-
-	ds.instancesLock.Lock()
-	i := ds.instances[instanceID]
 	oldNodeID := i.NodeID
 	i.NodeID = ""
-	i.State = payloads.Exited
 	ds.instancesLock.Unlock()
 
+	err = ds.updateInstanceStatus(payloads.Exited, instanceID)
+	if err != nil {
+		return errors.Wrap(err, "Error marked instance as stopped")
+	}
+
+	ds.summaries.instanceState(instanceID, payloads.Exited)
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchUpdated, instanceID, i.TenantID, payloads.Exited)
+
 	// we may not have received any node stats for this instance
 	if oldNodeID != "" {
 		ds.nodesLock.Lock()
@@ -1249,23 +2110,90 @@ func (ds *Datastore) InstanceStopped(instanceID string) error {
 	return nil
 }
 
+// InstanceHung marks an instance whose node failed to act on a command
+// in time as hung. The instance stays linked to its node, whose next
+// stats report will move it out of the hung state.
+func (ds *Datastore) InstanceHung(instanceID string) error {
+	ds.instancesLock.Lock()
+	i := ds.instances[instanceID]
+	if i == nil {
+		ds.instancesLock.Unlock()
+		return types.ErrInstanceNotFound
+	}
+	err := i.TransitionInstanceState(payloads.Hung)
+	ds.instancesLock.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "Error marking instance as hung")
+	}
+
+	ds.summaries.instanceState(instanceID, payloads.Hung)
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchUpdated, instanceID, i.TenantID, payloads.Hung)
+
+	return nil
+}
+
 // DeleteNode removes a node from the node cache.
 func (ds *Datastore) DeleteNode(nodeID string) error {
 	ds.nodesLock.Lock()
-	for _, i := range ds.nodes[nodeID].instances {
-		_ = i.TransitionInstanceState(payloads.Missing)
-		i.NodeID = ""
+	if n := ds.nodes[nodeID]; n != nil {
+		for _, i := range n.instances {
+			_ = i.TransitionInstanceState(payloads.Missing)
+			i.NodeID = ""
+			ds.summaries.instanceState(i.ID, payloads.Missing)
+			ds.instanceChanged(types.WatchUpdated, i.ID, i.TenantID, payloads.Missing)
+		}
 	}
 	delete(ds.nodes, nodeID)
 	ds.nodesLock.Unlock()
 
 	ds.nodeLastStatLock.Lock()
 	delete(ds.nodeLastStat, nodeID)
+	delete(ds.nodeLastSample, nodeID)
 	ds.nodeLastStatLock.Unlock()
 
+	ds.reports.remove(nodeID)
+
+	ds.bumpRevision(types.InstancesRevision)
+	ds.bumpRevision(types.NodesRevision)
+
 	return nil
 }
 
+// RemoveNode removes a node without instances from the node cache. The
+// stats the node may still send are ignored until it connects again.
+func (ds *Datastore) RemoveNode(nodeID string) error {
+	ds.nodesLock.Lock()
+	n, ok := ds.nodes[nodeID]
+	if !ok {
+		ds.nodesLock.Unlock()
+		return types.ErrNodeNotFound
+	}
+
+	if len(n.instances) > 0 {
+		ds.nodesLock.Unlock()
+		return types.ErrNodeNotEmpty
+	}
+
+	ds.removedNodes[nodeID] = true
+	ds.nodesLock.Unlock()
+
+	return ds.DeleteNode(nodeID)
+}
+
+// GetNodeInstanceCount returns the number of instances the node hosts.
+func (ds *Datastore) GetNodeInstanceCount(nodeID string) (int, error) {
+	ds.nodesLock.RLock()
+	defer ds.nodesLock.RUnlock()
+
+	n, ok := ds.nodes[nodeID]
+	if !ok {
+		return 0, types.ErrNodeNotFound
+	}
+
+	return len(n.instances), nil
+}
+
 // AddNode adds a node into the node cache, updating the node's tracked
 // role bitmask if the node is already present to be the superset of all
 // reported roles.
@@ -1281,6 +2209,10 @@ func (ds *Datastore) AddNode(nodeID string, nodeType payloads.Resource) {
 	ds.nodesLock.Lock()
 	defer ds.nodesLock.Unlock()
 
+	defer ds.bumpRevision(types.NodesRevision)
+
+	delete(ds.removedNodes, nodeID)
+
 	if ds.nodes[nodeID] != nil {
 		ds.nodes[nodeID].NodeRole |= role
 		return
@@ -1312,13 +2244,79 @@ func (ds *Datastore) GetNode(nodeID string) (types.Node, error) {
 
 // HandleStats makes sure that the data from the stat payload is stored.
 func (ds *Datastore) HandleStats(stat payloads.Stat) error {
+	ds.statsLock.Lock()
+	defer ds.statsLock.Unlock()
+
+	ds.nodesLock.RLock()
+	removed := ds.removedNodes[stat.NodeUUID]
+	ds.nodesLock.RUnlock()
+
+	if removed {
+		glog.Warningf("Dropping stats from removed node %s", stat.NodeUUID)
+		return nil
+	}
+
+	sampled, ok := statTimestamp(stat.Timestamp)
+	if ok {
+		ds.nodeLastStatLock.Lock()
+		last, found := ds.nodeLastSample[stat.NodeUUID]
+		stale := found && sampled.Before(last)
+		if !stale {
+			ds.nodeLastSample[stat.NodeUUID] = sampled
+		}
+		ds.nodeLastStatLock.Unlock()
+
+		if stale {
+			glog.Warningf("Dropping stale stats from node %s sampled at %s", stat.NodeUUID, stat.Timestamp)
+			return nil
+		}
+	}
+
 	if stat.Load != -1 {
 		if err := ds.addNodeStat(stat); err != nil {
 			return errors.Wrap(err, "error updating node stats")
 		}
 	}
 
-	return errors.Wrapf(ds.addInstanceStats(stat.Instances, stat.NodeUUID), "error updating stats")
+	ds.reports.record(stat)
+
+	instances := stat.Instances
+	if stat.Timestamp != "" {
+		// instances not timestamped by the agent were sampled
+		// along with the node.
+		instances = make([]payloads.InstanceStat, len(stat.Instances))
+		for i := range stat.Instances {
+			instances[i] = stat.Instances[i]
+			if instances[i].Timestamp == "" {
+				instances[i].Timestamp = stat.Timestamp
+			}
+		}
+	}
+
+	return errors.Wrapf(ds.addInstanceStats(instances, stat.NodeUUID), "error updating stats")
+}
+
+// statSample records when and where the last stats of an instance
+// were sampled.
+type statSample struct {
+	nodeID    string
+	timestamp time.Time
+}
+
+// statTimestamp parses the agent timestamp of a stats sample. It
+// returns false if the sample was not timestamped by the agent.
+func statTimestamp(timestamp string) (time.Time, bool) {
+	if timestamp == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		glog.Warningf("Invalid stats timestamp %q: %v", timestamp, err)
+		return time.Time{}, false
+	}
+
+	return t, true
 }
 
 // HandleTraceReport stores the provided trace data in the datastore.
@@ -1377,6 +2375,32 @@ func (ds *Datastore) GetNodeLastStats() types.CiaoNodes {
 	return nodes
 }
 
+// AddShadowPlacement records the node a shadow scheduling policy picked
+// for an instance.
+func (ds *Datastore) AddShadowPlacement(p types.ShadowPlacement) error {
+	return errors.Wrap(ds.db.addShadowPlacement(p), "error adding shadow placement to database")
+}
+
+// SetShadowPlacementNode records the node the scheduler placed an instance
+// on next to its shadow placement.
+func (ds *Datastore) SetShadowPlacementNode(instanceID string, nodeID string) error {
+	return errors.Wrap(ds.db.setShadowPlacementNode(instanceID, nodeID), "error updating shadow placement in database")
+}
+
+// GetShadowPlacements returns the recorded shadow placements, oldest first.
+func (ds *Datastore) GetShadowPlacements() ([]types.ShadowPlacement, error) {
+	placements, err := ds.db.getShadowPlacements()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting shadow placements from database")
+	}
+
+	sort.SliceStable(placements, func(i, j int) bool {
+		return placements[i].Timestamp.Before(placements[j].Timestamp)
+	})
+
+	return placements, nil
+}
+
 func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
 	ds.nodesLock.Lock()
 
@@ -1390,9 +2414,15 @@ func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
 	n.ID = stat.NodeUUID
 	n.Hostname = stat.NodeHostName
 
+	timestamp, ok := statTimestamp(stat.Timestamp)
+	if !ok {
+		timestamp = time.Now()
+	}
+
 	cnStat := types.CiaoNode{
 		ID:                   stat.NodeUUID,
 		Hostname:             n.Hostname,
+		Timestamp:            timestamp,
 		Status:               stat.Status,
 		Load:                 stat.Load,
 		MemTotal:             stat.MemTotalMB,
@@ -1404,25 +2434,62 @@ func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
 		StartFailures:        n.StartFailures,
 		AttachVolumeFailures: n.AttachVolumeFailures,
 		DeleteFailures:       n.DeleteFailures,
+		Health:               nodeHealth(stat.Health),
 	}
 
 	ds.nodesLock.Unlock()
 	ds.nodeLastStatLock.Lock()
 
+	lastStat, ok := ds.nodeLastStat[stat.NodeUUID]
 	delete(ds.nodeLastStat, stat.NodeUUID)
 	ds.nodeLastStat[stat.NodeUUID] = cnStat
 
 	ds.nodeLastStatLock.Unlock()
 
+	// a new sample alone does not change the node
+	lastStat.Timestamp = cnStat.Timestamp
+	lastHealth := lastStat.Health
+	lastStat.Health = cnStat.Health
+	if !ok || lastStat != cnStat || !reflect.DeepEqual(lastHealth, cnStat.Health) {
+		ds.bumpRevision(types.NodesRevision)
+	}
+
 	return errors.Wrap(ds.db.addNodeStat(stat), "error adding node stats to database")
 }
 
-var tenantUsagePeriodMinutes float64 = 5
+func nodeHealth(stat *payloads.NodeHealthStat) *types.CiaoNodeHealth {
+	if stat == nil {
+		return nil
+	}
+
+	health := &types.CiaoNodeHealth{
+		CorrectedECCErrors:   stat.CorrectedECCErrors,
+		UncorrectedECCErrors: stat.UncorrectedECCErrors,
+	}
+
+	for _, t := range stat.Temperatures {
+		health.Temperatures = append(health.Temperatures, types.CiaoTemperature{
+			Sensor:  t.Sensor,
+			Celsius: t.Celsius,
+		})
+	}
+
+	for _, d := range stat.Disks {
+		health.Disks = append(health.Disks, types.CiaoDiskHealth{
+			Device:  d.Device,
+			Healthy: d.Healthy,
+		})
+	}
+
+	return health
+}
 
 func (ds *Datastore) updateTenantUsageNeeded(delta types.CiaoUsage, tenantID string) bool {
 	if delta.VCPU == 0 &&
 		delta.Memory == 0 &&
-		delta.Disk == 0 {
+		delta.Disk == 0 &&
+		delta.NetworkRxBytes == 0 &&
+		delta.NetworkTxBytes == 0 {
 		return false
 	}
 
@@ -1442,23 +2509,28 @@ func (ds *Datastore) updateTenantUsage(delta types.CiaoUsage, tenantID string) {
 	tenantUsage := ds.tenantUsage[tenantID]
 	if len(tenantUsage) != 0 {
 		lastUsage = tenantUsage[len(tenantUsage)-1]
-		// We will not create more than one entry per tenant every tenantUsagePeriodMinutes
-		if time.Since(lastUsage.Timestamp).Minutes() < tenantUsagePeriodMinutes {
+		// We will not create more than one entry per tenant every
+		// tenant_usage_period_minutes
+		period := time.Duration(ds.configInt(TenantUsagePeriodMinutes)) * time.Minute
+		if time.Since(lastUsage.Timestamp) < period {
 			createNewUsage = false
 		}
 	}
 
 	newUsage := types.CiaoUsage{
-		VCPU:   lastUsage.VCPU + delta.VCPU,
-		Memory: lastUsage.Memory + delta.Memory,
-		Disk:   lastUsage.Disk + delta.Disk,
+		VCPU:           lastUsage.VCPU + delta.VCPU,
+		Memory:         lastUsage.Memory + delta.Memory,
+		Disk:           lastUsage.Disk + delta.Disk,
+		NetworkRxBytes: lastUsage.NetworkRxBytes + delta.NetworkRxBytes,
+		NetworkTxBytes: lastUsage.NetworkTxBytes + delta.NetworkTxBytes,
 	}
 
 	// If we need to create a new usage entry, we timestamp it now.
 	// If not we just update the last entry.
 	if createNewUsage == true {
 		newUsage.Timestamp = time.Now()
-		ds.tenantUsage[tenantID] = append(ds.tenantUsage[tenantID], newUsage)
+		tenantUsage = append(tenantUsage, newUsage)
+		ds.tenantUsage[tenantID] = ds.persistTenantUsage(tenantID, tenantUsage)
 	} else {
 		newUsage.Timestamp = lastUsage.Timestamp
 		tenantUsage[len(tenantUsage)-1] = newUsage
@@ -1467,36 +2539,68 @@ func (ds *Datastore) updateTenantUsage(delta types.CiaoUsage, tenantID string) {
 	ds.tenantUsageLock.Unlock()
 }
 
+// persistTenantUsage stores the closed entries of the usage history of a
+// tenant, i.e. all but the last one, and returns the entries to keep in
+// memory.  Entries which cannot be stored are kept and retried when the
+// next entry closes.
+func (ds *Datastore) persistTenantUsage(tenantID string, tenantUsage []types.CiaoUsage) []types.CiaoUsage {
+	last := len(tenantUsage) - 1
+	for i, u := range tenantUsage[:last] {
+		err := ds.storeTenantUsage(tenantID, u)
+		if err != nil {
+			glog.Warningf("Unable to store usage of tenant %s: %v", tenantID, err)
+			return tenantUsage[i:]
+		}
+	}
+
+	return tenantUsage[last:]
+}
+
+// flushTenantUsage stores the usage entries still held in memory, including
+// the last entry of each tenant, which is only closed by the next change
+// of usage.  It is called when the datastore exits.
+func (ds *Datastore) flushTenantUsage() {
+	ds.tenantUsageLock.Lock()
+	defer ds.tenantUsageLock.Unlock()
+
+	for tenantID, tenantUsage := range ds.tenantUsage {
+		for _, u := range tenantUsage {
+			err := ds.storeTenantUsage(tenantID, u)
+			if err != nil {
+				glog.Warningf("Unable to store usage of tenant %s: %v", tenantID, err)
+				break
+			}
+		}
+	}
+}
+
+func (ds *Datastore) storeTenantUsage(tenantID string, u types.CiaoUsage) error {
+	u.Timestamp = u.Timestamp.UTC()
+	return ds.db.updateTenantUsage(types.TenantUsageSample{
+		TenantID:   tenantID,
+		Resolution: types.UsageRaw,
+		CiaoUsage:  u,
+	})
+}
+
 // GetTenantUsage provides statistics on actual resource usage.
-// Usage is provided between a specified time period.
+// Usage is provided between a specified time period.  Histories longer
+// than max_tenant_usage_samples are downsampled to that many samples.
 func (ds *Datastore) GetTenantUsage(tenantID string, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
-	ds.tenantUsageLock.RLock()
-	defer ds.tenantUsageLock.RUnlock()
-
-	tenantUsage := ds.tenantUsage[tenantID]
-	if tenantUsage == nil || len(tenantUsage) == 0 {
-		return nil, nil
+	history, err := ds.tenantUsageHistory(tenantID, start, end)
+	if err != nil {
+		return nil, err
 	}
 
-	historyLength := len(tenantUsage)
-	if tenantUsage[0].Timestamp.After(end) == true ||
-		start.After(tenantUsage[historyLength-1].Timestamp) == true {
+	if len(history) == 0 {
 		return nil, nil
 	}
 
-	first := 0
-	last := 0
-	for _, u := range tenantUsage {
-		if start.After(u.Timestamp) == true {
-			first++
-		}
-
-		if end.After(u.Timestamp) == true {
-			last++
-		}
+	if max := ds.configInt(MaxTenantUsageSamples); len(history) > max {
+		history = downsampleUsage(history, max)
 	}
 
-	return tenantUsage[first:last], nil
+	return history, nil
 }
 
 func reduceToZero(v int) int {
@@ -1507,22 +2611,186 @@ func reduceToZero(v int) int {
 	return v
 }
 
+// maxInstanceSamples is the default number of network and disk I/O
+// samples kept per instance.
+const maxInstanceSamples = 60
+
+func networkUsage(stats []payloads.InstanceNetworkStat) []types.CiaoNetworkUsage {
+	var usage []types.CiaoNetworkUsage
+
+	for _, s := range stats {
+		usage = append(usage, types.CiaoNetworkUsage{
+			Interface: s.Interface,
+			RxBytes:   s.RxBytes,
+			TxBytes:   s.TxBytes,
+			RxPackets: s.RxPackets,
+			TxPackets: s.TxPackets,
+		})
+	}
+
+	return usage
+}
+
+// addNetworkSample must be called with instanceLastStatLock held.
+func (ds *Datastore) addNetworkSample(instanceID string, sample types.CiaoNetworkSample) {
+	samples := append(ds.instanceNetSamples[instanceID], sample)
+	if max := ds.configInt(MaxInstanceSamples); len(samples) > max {
+		samples = samples[len(samples)-max:]
+	}
+
+	ds.instanceNetSamples[instanceID] = samples
+}
+
+// GetInstanceNetworkSamples returns the latest network samples received
+// for an instance, oldest first.
+func (ds *Datastore) GetInstanceNetworkSamples(instanceID string) []types.CiaoNetworkSample {
+	ds.instanceLastStatLock.RLock()
+	defer ds.instanceLastStatLock.RUnlock()
+
+	samples := ds.instanceNetSamples[instanceID]
+	return append([]types.CiaoNetworkSample(nil), samples...)
+}
+
+// opsRate returns the number of operations per second between two
+// samples of a cumulative counter.  Counters going backwards mean the
+// instance was restarted and no rate can be computed.
+func opsRate(prev, cur uint64, elapsed time.Duration) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+
+	return float64(cur-prev) / elapsed.Seconds()
+}
+
+// diskIOUsage must be called with instanceLastStatLock held.
+func (ds *Datastore) diskIOUsage(instanceID string, stat *payloads.InstanceDiskIOStat,
+	sampled time.Time) *types.CiaoDiskIOUsage {
+	if stat == nil {
+		return nil
+	}
+
+	usage := &types.CiaoDiskIOUsage{
+		ReadBytes:  stat.ReadBytes,
+		WriteBytes: stat.WriteBytes,
+		ReadOps:    stat.ReadOps,
+		WriteOps:   stat.WriteOps,
+	}
+
+	samples := ds.instanceDiskSamples[instanceID]
+	if len(samples) > 0 {
+		prev := samples[len(samples)-1]
+		elapsed := sampled.Sub(prev.Timestamp)
+		usage.ReadIOPS = opsRate(prev.DiskIO.ReadOps, usage.ReadOps, elapsed)
+		usage.WriteIOPS = opsRate(prev.DiskIO.WriteOps, usage.WriteOps, elapsed)
+	}
+
+	return usage
+}
+
+// addDiskIOSample must be called with instanceLastStatLock held.
+func (ds *Datastore) addDiskIOSample(instanceID string, sample types.CiaoDiskIOSample) {
+	samples := append(ds.instanceDiskSamples[instanceID], sample)
+	if max := ds.configInt(MaxInstanceSamples); len(samples) > max {
+		samples = samples[len(samples)-max:]
+	}
+
+	ds.instanceDiskSamples[instanceID] = samples
+}
+
+// GetInstanceDiskIOSamples returns the latest disk I/O samples received
+// for an instance, oldest first.
+func (ds *Datastore) GetInstanceDiskIOSamples(instanceID string) []types.CiaoDiskIOSample {
+	ds.instanceLastStatLock.RLock()
+	defer ds.instanceLastStatLock.RUnlock()
+
+	samples := ds.instanceDiskSamples[instanceID]
+	return append([]types.CiaoDiskIOSample(nil), samples...)
+}
+
+// GetInstanceHealth returns the result of the last health checks of an
+// instance, or nil if its health is not checked.
+func (ds *Datastore) GetInstanceHealth(instanceID string) *types.CiaoInstanceHealth {
+	ds.instanceLastStatLock.RLock()
+	defer ds.instanceLastStatLock.RUnlock()
+
+	health := ds.instanceLastStat[instanceID].Health
+	if health == nil {
+		return nil
+	}
+
+	h := *health
+	return &h
+}
+
+func instanceHealth(stat *payloads.InstanceHealthStat) *types.CiaoInstanceHealth {
+	if stat == nil {
+		return nil
+	}
+
+	health := &types.CiaoInstanceHealth{
+		Status:   stat.Status,
+		Failures: stat.Failures,
+		Message:  stat.Message,
+	}
+
+	checked, err := time.Parse(time.RFC3339, stat.Checked)
+	if err == nil {
+		health.Checked = checked
+	}
+
+	return health
+}
+
 func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+	accepted := make([]payloads.InstanceStat, 0, len(stats))
+
 	for index := range stats {
 		stat := stats[index]
 
+		sampled, stamped := statTimestamp(stat.Timestamp)
+		if !stamped {
+			sampled = time.Now()
+		}
+
+		// Samples reporting a state the instance cannot move to are
+		// skipped before they update any cache.
+		if err := ds.validStatState(stat); err != nil {
+			glog.Warningf("Ignoring stats reported for instance %s by %s: %v", stat.InstanceUUID, nodeID, err)
+			continue
+		}
+
 		instanceStat := types.CiaoServerStats{
 			ID:        stat.InstanceUUID,
 			NodeID:    nodeID,
-			Timestamp: time.Now(),
+			Timestamp: sampled,
 			Status:    stat.State,
 			VCPUUsage: reduceToZero(stat.CPUUsage),
 			MemUsage:  reduceToZero(stat.MemoryUsageMB),
 			DiskUsage: reduceToZero(stat.DiskUsageMB),
+			Networks:  networkUsage(stat.Networks),
+			Health:    instanceHealth(stat.Health),
 		}
 
 		ds.instanceLastStatLock.Lock()
 
+		// Agent clocks are only compared with themselves, samples
+		// from a different node are always newer.
+		if stamped {
+			last, ok := ds.instanceLastSample[stat.InstanceUUID]
+			if ok && last.nodeID == nodeID && sampled.Before(last.timestamp) {
+				ds.instanceLastStatLock.Unlock()
+				glog.Warningf("Dropping stale stats for instance %s sampled at %s", stat.InstanceUUID, stat.Timestamp)
+				continue
+			}
+
+			ds.instanceLastSample[stat.InstanceUUID] = statSample{
+				nodeID:    nodeID,
+				timestamp: sampled,
+			}
+		}
+
+		instanceStat.DiskIO = ds.diskIOUsage(stat.InstanceUUID, stat.DiskIO, sampled)
+
 		lastInstanceStat := ds.instanceLastStat[stat.InstanceUUID]
 
 		deltaUsage := types.CiaoUsage{
@@ -1538,12 +2806,45 @@ func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID stri
 		delete(ds.instanceLastStat, stat.InstanceUUID)
 		ds.instanceLastStat[stat.InstanceUUID] = instanceStat
 
+		if len(instanceStat.Networks) > 0 {
+			ds.addNetworkSample(stat.InstanceUUID, types.CiaoNetworkSample{
+				Timestamp: sampled,
+				Networks:  instanceStat.Networks,
+			})
+		}
+
+		if instanceStat.DiskIO != nil {
+			ds.addDiskIOSample(stat.InstanceUUID, types.CiaoDiskIOSample{
+				Timestamp: sampled,
+				DiskIO:    *instanceStat.DiskIO,
+			})
+		}
+
 		ds.instanceLastStatLock.Unlock()
 
 		ds.instancesLock.Lock()
 		instance, ok := ds.instances[stat.InstanceUUID]
 		if ok {
-			instance.State = stat.State
+			instance.StateLock.RLock()
+			oldState := instance.State
+			instance.StateLock.RUnlock()
+
+			if err := instance.TransitionInstanceState(stat.State); err != nil {
+				ds.instancesLock.Unlock()
+				glog.Warningf("Ignoring state reported for instance %s by %s: %v", instance.ID, nodeID, err)
+				continue
+			}
+
+			if oldState != stat.State ||
+				instance.NodeID != nodeID ||
+				instance.SSHIP != stat.SSHIP ||
+				instance.SSHPort != stat.SSHPort {
+				ds.bumpRevision(types.InstancesRevision)
+				ds.instanceChanged(types.WatchUpdated, instance.ID, instance.TenantID, stat.State)
+			}
+
+			ds.summaries.instanceState(instance.ID, stat.State)
+
 			instance.NodeID = nodeID
 			instance.SSHIP = stat.SSHIP
 			instance.SSHPort = stat.SSHPort
@@ -1552,9 +2853,32 @@ func (ds *Datastore) addInstanceStats(stats []payloads.InstanceStat, nodeID stri
 			ds.nodesLock.Unlock()
 		}
 		ds.instancesLock.Unlock()
+
+		accepted = append(accepted, stat)
 	}
 
-	return errors.Wrapf(ds.db.addInstanceStats(stats, nodeID), "error adding instance stats to database")
+	return errors.Wrapf(ds.db.addInstanceStats(accepted, nodeID), "error adding instance stats to database")
+}
+
+// validStatState checks that the instance a stat reports about, if known,
+// may move to the reported state.
+func (ds *Datastore) validStatState(stat payloads.InstanceStat) error {
+	ds.instancesLock.RLock()
+	instance, ok := ds.instances[stat.InstanceUUID]
+	ds.instancesLock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	instance.StateLock.RLock()
+	state := instance.State
+	instance.StateLock.RUnlock()
+
+	if !types.ValidInstanceTransition(state, stat.State) {
+		return fmt.Errorf("Invalid state transition %s -> %s", state, stat.State)
+	}
+
+	return nil
 }
 
 // GetTenantCNCISummary retrieves information about a given CNCI id, or all CNCIs
@@ -1668,24 +2992,75 @@ func (ds *Datastore) ClearLog() error {
 	return ds.db.clearLog()
 }
 
-// LogEvent will add a message to the persistent event log.
-func (ds *Datastore) LogEvent(tenant string, msg string) error {
+// ListEvents returns a page of at most limit events matching filter, in
+// the order they were logged, starting after the event identified by
+// marker. The marker to pass to get the next page is returned if more
+// events match. A limit of 0 returns all the events.
+func (ds *Datastore) ListEvents(filter types.EventFilter, marker string, limit int) ([]*types.LogEntry, string, error) {
+	if limit < 0 {
+		return nil, "", types.ErrBadRequest
+	}
+
+	events, next, err := ds.db.getEvents(filter, marker, limit)
+	if err == types.ErrBadRequest {
+		return nil, "", err
+	} else if err != nil {
+		return nil, "", errors.Wrap(err, "Error getting events")
+	}
+
+	return events, next, nil
+}
+
+// PruneEventLog removes the events logged before a time from the event
+// log and returns the number of events removed.
+func (ds *Datastore) PruneEventLog(before time.Time) (int, error) {
+	count, err := ds.db.pruneLog(before)
+	return count, errors.Wrap(err, "Error pruning event log")
+}
+
+// EventRetention returns how long events are kept in the event log, or
+// 0 if they are kept until cleared.
+func (ds *Datastore) EventRetention() time.Duration {
+	return time.Duration(ds.configInt(EventRetentionDays)) * 24 * time.Hour
+}
+
+// LogEvent will add a message to the persistent event log with the given
+// severity and category.
+func (ds *Datastore) LogEvent(tenant string, severity types.EventSeverity, category types.EventCategory, msg string) error {
 	e := types.LogEntry{
-		TenantID:  tenant,
-		EventType: string(userInfo),
-		Message:   msg,
+		TenantID: tenant,
+		Severity: severity,
+		Category: category,
+		Message:  msg,
 	}
-	return ds.db.logEvent(e)
+	return ds.logEvent(e)
+}
+
+// SetEventHandler registers a function that is called with every event
+// successfully added to the event log.  The handler may be called with
+// datastore locks held and must not block or call back into the datastore.
+func (ds *Datastore) SetEventHandler(handler func(types.LogEntry)) {
+	ds.eventHandlerLock.Lock()
+	ds.eventHandler = handler
+	ds.eventHandlerLock.Unlock()
 }
 
-// LogError will add a message to the persistent event log as an error
-func (ds *Datastore) LogError(tenant string, msg string) error {
-	e := types.LogEntry{
-		TenantID:  tenant,
-		EventType: string(userError),
-		Message:   msg,
+func (ds *Datastore) logEvent(e types.LogEntry) error {
+	err := ds.db.logEvent(e)
+	if err != nil {
+		return err
+	}
+
+	ds.eventHandlerLock.RLock()
+	handler := ds.eventHandler
+	ds.eventHandlerLock.RUnlock()
+
+	if handler != nil {
+		e.Timestamp = time.Now().UTC()
+		handler(e)
 	}
-	return ds.db.logEvent(e)
+
+	return nil
 }
 
 // AddBlockDevice will store information about new BlockData into
@@ -1707,6 +3082,14 @@ func (ds *Datastore) AddBlockDevice(device types.Volume) error {
 		return err
 	}
 
+	ds.blockDeviceStored(device, update)
+
+	return nil
+}
+
+// blockDeviceStored updates the caches with a volume added to, or updated
+// in, the persistent store.
+func (ds *Datastore) blockDeviceStored(device types.Volume, update bool) {
 	ds.bdLock.Lock()
 	ds.blockDevices[device.ID] = device
 	ds.bdLock.Unlock()
@@ -1716,7 +3099,15 @@ func (ds *Datastore) AddBlockDevice(device types.Volume) error {
 	devices := ds.tenants[device.TenantID].devices
 	devices[device.ID] = device
 	ds.tenantsLock.Unlock()
-	return nil
+
+	ds.summaries.addVolume(device)
+	ds.bumpRevision(types.VolumesRevision)
+
+	if update {
+		ds.volumeChanged(types.WatchUpdated, device)
+	} else {
+		ds.volumeChanged(types.WatchAdded, device)
+	}
 }
 
 // DeleteBlockDevice will delete a volume from the datastore.
@@ -1745,6 +3136,10 @@ func (ds *Datastore) DeleteBlockDevice(ID string) error {
 	ds.tenantsLock.Unlock()
 	ds.bdLock.Unlock()
 
+	ds.summaries.removeVolume(ID)
+	ds.bumpRevision(types.VolumesRevision)
+	ds.volumeChanged(types.WatchDeleted, dev)
+
 	return nil
 }
 
@@ -1777,12 +3172,28 @@ func (ds *Datastore) GetBlockDevice(ID string) (types.Volume, error) {
 	data, ok := ds.blockDevices[ID]
 	ds.bdLock.RUnlock()
 
+	ds.blockDeviceCache.lookup(ok)
+
 	if !ok {
 		return types.Volume{}, ErrNoBlockData
 	}
 	return data, nil
 }
 
+// GetAllBlockDevices will return all the block devices known to the
+// datastore, regardless of the tenant owning them.
+func (ds *Datastore) GetAllBlockDevices() []types.Volume {
+	var devices []types.Volume
+
+	ds.bdLock.RLock()
+	for _, value := range ds.blockDevices {
+		devices = append(devices, value)
+	}
+	ds.bdLock.RUnlock()
+
+	return devices
+}
+
 // UpdateBlockDevice will replace existing information about a block device
 // in the datastore.
 func (ds *Datastore) UpdateBlockDevice(data types.Volume) error {
@@ -1813,31 +3224,37 @@ func (ds *Datastore) CreateStorageAttachment(instanceID string, volume payloads.
 		Boot:       volume.Bootable,
 	}
 
-	err := ds.db.addStorageAttachment(a)
-	if err != nil {
-		return types.StorageAttachment{}, errors.Wrap(err, "error adding storage attachment to database")
-	}
-
-	// ensure that the volume is marked in use as we have created an attachment
 	bd, err := ds.GetBlockDevice(volume.ID)
 	if err != nil {
-		_ = ds.db.deleteStorageAttachment(a.ID)
 		return types.StorageAttachment{}, errors.Wrapf(err, "error fetching block device (%v)", volume.ID)
 	}
 
+	// ensure that the volume is marked in use as we have created an attachment
 	bd.State = types.InUse
-	err = ds.UpdateBlockDevice(bd)
+
+	err = ds.transaction(func(tx persistentTx) error {
+		err := tx.addStorageAttachment(a)
+		if err != nil {
+			return errors.Wrap(err, "error adding storage attachment to database")
+		}
+
+		err = tx.updateBlockData(bd)
+		return errors.Wrapf(err, "error updating block device (%v)", volume.ID)
+	})
 	if err != nil {
-		_ = ds.db.deleteStorageAttachment(a.ID)
-		return types.StorageAttachment{}, errors.Wrapf(err, "error updating block device (%v)", volume.ID)
+		return types.StorageAttachment{}, err
 	}
 
+	ds.blockDeviceStored(bd, true)
+
 	// add it to our links map
 	ds.attachLock.Lock()
 	ds.attachments[a.ID] = a
 	ds.instanceVolumes[link] = a.ID
 	ds.attachLock.Unlock()
 
+	ds.bumpRevision(types.InstancesRevision)
+
 	return a, nil
 }
 
@@ -1858,46 +3275,80 @@ func (ds *Datastore) GetStorageAttachments(instanceID string) []types.StorageAtt
 
 func (ds *Datastore) updateStorageAttachments(instanceID string) {
 	ds.attachLock.Lock()
+	defer ds.attachLock.Unlock()
+
+	var links []types.StorageAttachment
+	var volumes []types.Volume
+
+	err := ds.transaction(func(tx persistentTx) error {
+		var err error
+		links, volumes, err = ds.detachStorage(tx, instanceID)
+		return err
+	})
+	if err != nil {
+		glog.Warningf("error updating storage attachments: %v", err)
+		return
+	}
+
+	ds.storageDetached(links, volumes)
+}
+
+// detachStorage deletes the storage attachments of an instance as part of
+// tx, and marks the attached volumes available.  It returns the deleted
+// attachments and the updated volumes, for storageDetached to update the
+// caches with once tx is committed.  attachLock must be held.
+func (ds *Datastore) detachStorage(tx persistentTx, instanceID string) ([]types.StorageAttachment, []types.Volume, error) {
+	var links []types.StorageAttachment
+	var volumes []types.Volume
 
-	// check to see if all the attachments we already
-	// know about are in the list.
 	for _, ID := range ds.instanceVolumes {
 		a := ds.attachments[ID]
 
-		if a.InstanceID == instanceID {
-			bd, err := ds.GetBlockDevice(a.BlockID)
-			if err != nil {
-				glog.Warningf("error fetching block device (%v): %v", a.BlockID, err)
-				continue
-			}
+		if a.InstanceID != instanceID {
+			continue
+		}
 
-			// update the state of the volume.
-			bd.State = types.Available
-			err = ds.UpdateBlockDevice(bd)
-			if err != nil {
-				glog.Warningf("error updating block device (%v): %v", a.BlockID, err)
-			}
+		bd, err := ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			glog.Warningf("error fetching block device (%v): %v", a.BlockID, err)
+			continue
+		}
 
-			// delete the attachment.
-			key := attachment{
-				instanceID: a.InstanceID,
-				volumeID:   a.BlockID,
-			}
+		// update the state of the volume.
+		bd.State = types.Available
+		err = tx.updateBlockData(bd)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error updating block device (%v)", a.BlockID)
+		}
 
-			delete(ds.attachments, ID)
-			delete(ds.instanceVolumes, key)
+		err = tx.deleteStorageAttachment(a.ID)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error deleting storage attachment (%v)", a.ID)
+		}
 
-			// update persistent store asynch.
-			// ok for lock to be held here, but
-			// not needed as the db keeps it's
-			// own locks.
-			err = ds.db.deleteStorageAttachment(ID)
-			if err != nil {
-				glog.Warningf("error updating storage attachments: %v", err)
-			}
+		links = append(links, a)
+		volumes = append(volumes, bd)
+	}
+
+	return links, volumes, nil
+}
+
+// storageDetached updates the caches with the storage attachments and
+// volumes written by detachStorage.  attachLock must be held.
+func (ds *Datastore) storageDetached(links []types.StorageAttachment, volumes []types.Volume) {
+	for _, a := range links {
+		key := attachment{
+			instanceID: a.InstanceID,
+			volumeID:   a.BlockID,
 		}
+
+		delete(ds.attachments, a.ID)
+		delete(ds.instanceVolumes, key)
+	}
+
+	for _, bd := range volumes {
+		ds.blockDeviceStored(bd, true)
 	}
-	ds.attachLock.Unlock()
 }
 
 func (ds *Datastore) getStorageAttachment(instanceID string, volumeID string) (types.StorageAttachment, error) {
@@ -1947,6 +3398,8 @@ func (ds *Datastore) DeleteStorageAttachment(ID string) error {
 		return ErrNoStorageAttachment
 	}
 
+	ds.bumpRevision(types.InstancesRevision)
+
 	return nil
 }
 
@@ -1972,6 +3425,9 @@ func (ds *Datastore) GetVolumeAttachments(volume string) ([]types.StorageAttachm
 func (ds *Datastore) GetPool(ID string) (types.Pool, error) {
 	ds.poolsLock.RLock()
 	p, ok := ds.pools[ID]
+	if ok {
+		p = copyPool(p)
+	}
 	ds.poolsLock.RUnlock()
 
 	if !ok {
@@ -1988,7 +3444,7 @@ func (ds *Datastore) GetPools() ([]types.Pool, error) {
 	ds.poolsLock.RLock()
 
 	for _, p := range ds.pools {
-		pools = append(pools, p)
+		pools = append(pools, copyPool(p))
 	}
 
 	ds.poolsLock.RUnlock()
@@ -1996,6 +3452,87 @@ func (ds *Datastore) GetPools() ([]types.Pool, error) {
 	return pools, nil
 }
 
+// copyPool returns a copy of a cached pool which can be modified and
+// returned to callers without holding the pools lock.
+func copyPool(p types.Pool) types.Pool {
+	if p.Subnets != nil {
+		subnets := make([]types.ExternalSubnet, len(p.Subnets))
+		for i, sub := range p.Subnets {
+			sub.MappedIPs = append([]string(nil), sub.MappedIPs...)
+			subnets[i] = sub
+		}
+		p.Subnets = subnets
+	}
+
+	if p.IPs != nil {
+		p.IPs = append([]types.ExternalIP{}, p.IPs...)
+	}
+
+	return p
+}
+
+// subnetSize returns the number of addresses of an external subnet which
+// can be mapped, i.e. without the gateway and broadcast addresses.
+// subnetAllocator returns an allocator for the host addresses of an
+// external subnet, the network and broadcast addresses of which are never
+// mapped.
+func subnetAllocator(ipNet *net.IPNet) (*ipalloc.Allocator, error) {
+	ones, _ := ipNet.Mask.Size()
+	return ipalloc.New(ipNet.String(), ones, 0, -1)
+}
+
+// subnetSize returns the number of mappable addresses of an external
+// subnet, capped for large IPv6 subnets.
+func subnetSize(ipNet *net.IPNet) int {
+	alloc, err := subnetAllocator(ipNet)
+	if err != nil {
+		return 0
+	}
+
+	hosts := alloc.SubnetHosts()
+	if !hosts.IsInt64() || hosts.Int64() > math.MaxInt32 {
+		return math.MaxInt32
+	}
+
+	return int(hosts.Int64())
+}
+
+// updateSubnetUsage accounts for the mapping or unmapping of address in
+// the pool subnet containing it. Addresses not in any subnet of the pool
+// are ignored. The pools lock must be held by the caller.
+func updateSubnetUsage(pool *types.Pool, address string, mapped bool) {
+	IP := net.ParseIP(address)
+	if IP == nil {
+		return
+	}
+
+	for i := range pool.Subnets {
+		sub := &pool.Subnets[i]
+
+		_, ipNet, err := net.ParseCIDR(sub.CIDR)
+		if err != nil || !ipNet.Contains(IP) {
+			continue
+		}
+
+		n := sort.SearchStrings(sub.MappedIPs, address)
+		found := n < len(sub.MappedIPs) && sub.MappedIPs[n] == address
+
+		if mapped && !found {
+			sub.MappedIPs = append(sub.MappedIPs, "")
+			copy(sub.MappedIPs[n+1:], sub.MappedIPs[n:])
+			sub.MappedIPs[n] = address
+			sub.Used++
+			sub.Free--
+		} else if !mapped && found {
+			sub.MappedIPs = append(sub.MappedIPs[:n], sub.MappedIPs[n+1:]...)
+			sub.Used--
+			sub.Free++
+		}
+
+		return
+	}
+}
+
 // lock for the map must be held by caller.
 func (ds *Datastore) isDuplicateSubnet(new *net.IPNet) bool {
 	for s, exists := range ds.externalSubnets {
@@ -2035,9 +3572,12 @@ func (ds *Datastore) isDuplicateIP(new net.IP) bool {
 func (ds *Datastore) AddPool(pool types.Pool) error {
 	ds.poolsLock.Lock()
 
+	pool = copyPool(pool)
+
 	if len(pool.Subnets) > 0 {
 		// check each one to make sure it's not in use.
-		for _, subnet := range pool.Subnets {
+		for i := range pool.Subnets {
+			subnet := &pool.Subnets[i]
 			_, newSubnet, err := net.ParseCIDR(subnet.CIDR)
 			if err != nil {
 				ds.poolsLock.Unlock()
@@ -2049,6 +3589,10 @@ func (ds *Datastore) AddPool(pool types.Pool) error {
 				return types.ErrDuplicateSubnet
 			}
 
+			subnet.Used = 0
+			subnet.Free = subnetSize(newSubnet)
+			subnet.MappedIPs = nil
+
 			// update our list of used subnets
 			ds.externalSubnets[subnet.CIDR] = true
 		}
@@ -2148,16 +3692,16 @@ func (ds *Datastore) AddExternalSubnet(poolID string, subnet string) error {
 		return types.ErrDuplicateSubnet
 	}
 
-	ones, bits := ipNet.Mask.Size()
-
 	// intentionally do not support /32 here, user should add by IP address instead
 	// deduct gateway and broadcast
-	newIPs := (1 << uint32(bits-ones)) - 2
+	newIPs := subnetSize(ipNet)
 	if newIPs <= 0 {
 		return types.ErrSubnetTooSmall
 	}
 	p.TotalIPs += newIPs
 	p.Free += newIPs
+	sub.Free = newIPs
+	p = copyPool(p)
 	p.Subnets = append(p.Subnets, sub)
 
 	err = ds.db.updatePool(p)
@@ -2243,23 +3787,20 @@ func (ds *Datastore) DeleteSubnet(poolID string, subnetID string) error {
 		}
 
 		// this path will be taken only once.
-		IP, ipNet, err := net.ParseCIDR(sub.CIDR)
+		_, ipNet, err := net.ParseCIDR(sub.CIDR)
 		if err != nil {
 			return errors.Wrapf(err, "unable to parse subnet CIDR (%v)", sub.CIDR)
 		}
 
-		// check each address in this subnet is not mapped.
-		for IP := IP.Mask(ipNet.Mask); ipNet.Contains(IP); incrementIP(IP) {
-			_, ok := ds.mappedIPs[IP.String()]
-			if ok {
-				return types.ErrPoolNotEmpty
-			}
+		// check no address in this subnet is mapped.
+		if sub.Used > 0 {
+			return types.ErrPoolNotEmpty
 		}
 
-		ones, bits := ipNet.Mask.Size()
-		numIPs := (1 << uint32(bits-ones)) - 2
+		numIPs := subnetSize(ipNet)
 		p.TotalIPs -= numIPs
 		p.Free -= numIPs
+		p = copyPool(p)
 		p.Subnets = append(p.Subnets[:i], p.Subnets[i+1:]...)
 
 		err = ds.db.updatePool(p)
@@ -2316,15 +3857,6 @@ func (ds *Datastore) DeleteExternalIP(poolID string, addrID string) error {
 	return types.ErrInvalidPoolAddress
 }
 
-func incrementIP(IP net.IP) {
-	for i := len(IP) - 1; i >= 0; i-- {
-		IP[i]++
-		if IP[i] > 0 {
-			break
-		}
-	}
-}
-
 // GetMappedIPs will return a list of mapped external IPs by tenant.
 func (ds *Datastore) GetMappedIPs(tenant *string) []types.MappedIP {
 	var mappedIPs []types.MappedIP
@@ -2357,6 +3889,39 @@ func (ds *Datastore) GetMappedIP(address string) (types.MappedIP, error) {
 	return m, nil
 }
 
+// mapAddress records the mapping of address from pool to the instance.
+// The pools lock must be held by the caller.
+func (ds *Datastore) mapAddress(pool types.Pool, address string, instance *types.Instance) (types.MappedIP, error) {
+	m := types.MappedIP{
+		ID:         uuid.Generate().String(),
+		ExternalIP: address,
+		InternalIP: instance.IPAddress,
+		InstanceID: instance.ID,
+		TenantID:   instance.TenantID,
+		PoolID:     pool.ID,
+		PoolName:   pool.Name,
+		NodeID:     instance.NodeID,
+	}
+
+	pool.Free--
+
+	err := ds.db.addMappedIP(m)
+	if err != nil {
+		return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
+	}
+	ds.mappedIPs[address] = m
+	updateSubnetUsage(&pool, address, true)
+
+	err = ds.db.updatePool(pool)
+	if err != nil {
+		return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
+	}
+
+	ds.pools[pool.ID] = pool
+
+	return m, nil
+}
+
 // MapExternalIP will allocate an external IP to an instance from a given pool.
 func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.MappedIP, error) {
 	var m types.MappedIP
@@ -2380,82 +3945,155 @@ func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.Mapp
 
 	// find a free IP address in any subnet.
 	for _, sub := range pool.Subnets {
-		IP, ipNet, err := net.ParseCIDR(sub.CIDR)
+		if sub.Free == 0 {
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(sub.CIDR)
 		if err != nil {
 			return m, errors.Wrapf(err, "error parsing subnet CIDR (%v)", sub.CIDR)
 		}
 
-		initIP := IP.Mask(ipNet.Mask)
-
-		// skip gateway
-		incrementIP(initIP)
-
-		// check each address in this subnet
-		for IP := initIP; ipNet.Contains(IP); incrementIP(IP) {
-			_, ok := ds.mappedIPs[IP.String()]
-			if !ok {
-				m.ID = uuid.Generate().String()
-				m.ExternalIP = IP.String()
-				m.InternalIP = instance.IPAddress
-				m.InstanceID = instanceID
-				m.TenantID = instance.TenantID
-				m.PoolID = pool.ID
-				m.PoolName = pool.Name
-
-				pool.Free--
-
-				err = ds.db.addMappedIP(m)
-				if err != nil {
-					return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
-				}
-				ds.mappedIPs[IP.String()] = m
-
-				err = ds.db.updatePool(pool)
-				if err != nil {
-					return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
-				}
-
-				ds.pools[poolID] = pool
+		alloc, err := subnetAllocator(ipNet)
+		if err != nil {
+			return m, errors.Wrapf(err, "error creating allocator for subnet (%v)", sub.CIDR)
+		}
 
-				return m, nil
+		for address := range ds.mappedIPs {
+			if IP := net.ParseIP(address); alloc.Contains(IP) {
+				_ = alloc.Claim(IP)
 			}
 		}
+
+		IPs, err := alloc.Allocate(1)
+		if err == nil {
+			return ds.mapAddress(pool, IPs[0].String(), instance)
+		}
 	}
 
 	// we are still looking. Check our individual IPs
 	for _, IP := range pool.IPs {
 		_, ok := ds.mappedIPs[IP.Address]
 		if !ok {
-			m.ID = uuid.Generate().String()
-			m.ExternalIP = IP.Address
-			m.InternalIP = instance.IPAddress
-			m.InstanceID = instanceID
-			m.TenantID = instance.TenantID
-			m.PoolID = pool.ID
-			m.PoolName = pool.Name
+			return ds.mapAddress(pool, IP.Address, instance)
+		}
+	}
 
-			pool.Free--
+	// if you got here you are out of luck. But you never should.
+	glog.Warningf("Pool reports %d free addresses but none found", pool.Free)
+	return m, types.ErrPoolEmpty
+}
 
-			err = ds.db.addMappedIP(m)
-			if err != nil {
-				return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
-			}
-			ds.mappedIPs[IP.Address] = m
+// poolContains checks whether address can be mapped from the pool, i.e.
+// whether it is one of the individual IPs of the pool or a host address
+// of one of its subnets.
+func poolContains(pool *types.Pool, IP net.IP) bool {
+	for _, extIP := range pool.IPs {
+		if IP.Equal(net.ParseIP(extIP.Address)) {
+			return true
+		}
+	}
 
-			err = ds.db.updatePool(pool)
-			if err != nil {
-				return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
-			}
+	for _, sub := range pool.Subnets {
+		_, ipNet, err := net.ParseCIDR(sub.CIDR)
+		if err != nil || !ipNet.Contains(IP) {
+			continue
+		}
+
+		// the network and broadcast addresses are not mappable.
+		alloc, err := subnetAllocator(ipNet)
+		return err == nil && !alloc.Reserved(IP)
+	}
+
+	return false
+}
+
+// MapExternalIPAddress will map a specific external IP address from a
+// given pool to an instance.
+func (ds *Datastore) MapExternalIPAddress(poolID string, address string, instanceID string) (types.MappedIP, error) {
+	var m types.MappedIP
+
+	instance, err := ds.GetInstance(instanceID)
+	if err != nil {
+		return m, errors.Wrapf(err, "error getting instance (%v)", instanceID)
+	}
+
+	IP := net.ParseIP(address)
+	if IP == nil {
+		return m, types.ErrInvalidIP
+	}
+
+	ds.poolsLock.Lock()
+	defer ds.poolsLock.Unlock()
+
+	pool, ok := ds.pools[poolID]
+	if !ok {
+		return m, types.ErrPoolNotFound
+	}
+
+	if !poolContains(&pool, IP) {
+		return m, types.ErrInvalidPoolAddress
+	}
+
+	_, ok = ds.mappedIPs[IP.String()]
+	if ok {
+		return m, types.ErrAddressMapped
+	}
+
+	return ds.mapAddress(pool, IP.String(), instance)
+}
+
+// GetStaleMappedIPs returns the mapped external IPs whose instance is
+// running on a different node than the one recorded in the mapping, i.e.
+// the mappings which need to be reprogrammed after the instance was
+// restarted on another node or migrated.
+func (ds *Datastore) GetStaleMappedIPs() []types.MappedIP {
+	var stale []types.MappedIP
+
+	mapped := ds.GetMappedIPs(nil)
+
+	ds.instancesLock.RLock()
+	defer ds.instancesLock.RUnlock()
 
-			ds.pools[poolID] = pool
+	for _, m := range mapped {
+		i, ok := ds.instances[m.InstanceID]
+		if !ok || i.NodeID == "" || i.NodeID == m.NodeID {
+			continue
+		}
 
-			return m, nil
+		i.StateLock.RLock()
+		running := i.State == payloads.Running
+		i.StateLock.RUnlock()
+
+		if running {
+			stale = append(stale, m)
 		}
 	}
 
-	// if you got here you are out of luck. But you never should.
-	glog.Warningf("Pool reports %d free addresses but none found", pool.Free)
-	return m, types.ErrPoolEmpty
+	return stale
+}
+
+// UpdateMappedIPNode records the node of the instance for which the
+// mapping of the given address was programmed.
+func (ds *Datastore) UpdateMappedIPNode(address string, nodeID string) error {
+	ds.poolsLock.Lock()
+	defer ds.poolsLock.Unlock()
+
+	m, ok := ds.mappedIPs[address]
+	if !ok {
+		return types.ErrAddressNotFound
+	}
+
+	m.NodeID = nodeID
+
+	err := ds.db.updateMappedIP(m)
+	if err != nil {
+		return errors.Wrap(err, "error updating IP mapping in database")
+	}
+
+	ds.mappedIPs[address] = m
+
+	return nil
 }
 
 // UnMapExternalIP will stop associating a given address with an instance.
@@ -2481,6 +4119,7 @@ func (ds *Datastore) UnMapExternalIP(address string) error {
 		return errors.Wrap(err, "error deleting IP mapping from database")
 	}
 	delete(ds.mappedIPs, address)
+	updateSubnetUsage(&pool, address, false)
 
 	err = ds.db.updatePool(pool)
 	if err != nil {
@@ -2536,18 +4175,72 @@ users:
 	ds.cnciWorkload = wl
 }
 
-// GetQuotas returns the set of quotas from the database without any caching.
+func quotaDetails(quotas map[string]int) []types.QuotaDetails {
+	qds := []types.QuotaDetails{}
+	for name, value := range quotas {
+		qds = append(qds, types.QuotaDetails{Name: name, Value: value})
+	}
+
+	sort.Slice(qds, func(i, j int) bool { return qds[i].Name < qds[j].Name })
+
+	return qds
+}
+
+// GetQuotas returns the set of quotas of a tenant, sorted by name. They are
+// read from the database the first time and cached afterwards.
 func (ds *Datastore) GetQuotas(tenantID string) ([]types.QuotaDetails, error) {
-	return ds.db.getQuotas(tenantID)
+	ds.quotasLock.RLock()
+	quotas, ok := ds.quotas[tenantID]
+	if ok {
+		qds := quotaDetails(quotas)
+		ds.quotasLock.RUnlock()
+		return qds, nil
+	}
+	ds.quotasLock.RUnlock()
+
+	ds.quotasLock.Lock()
+	defer ds.quotasLock.Unlock()
+
+	if quotas, ok := ds.quotas[tenantID]; ok {
+		return quotaDetails(quotas), nil
+	}
+
+	qds, err := ds.db.getQuotas(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas = make(map[string]int)
+	for _, qd := range qds {
+		quotas[qd.Name] = qd.Value
+	}
+	ds.quotas[tenantID] = quotas
+
+	return quotaDetails(quotas), nil
 }
 
-// UpdateQuotas updates the quotas for a tenant in the database.
+// UpdateQuotas updates the quotas for a tenant in the database and in the
+// cache, if they have already been read.
 func (ds *Datastore) UpdateQuotas(tenantID string, qds []types.QuotaDetails) error {
-	return ds.db.updateQuotas(tenantID, qds)
+	ds.quotasLock.Lock()
+	defer ds.quotasLock.Unlock()
+
+	err := ds.db.updateQuotas(tenantID, qds)
+	if err != nil {
+		return err
+	}
+
+	if quotas, ok := ds.quotas[tenantID]; ok {
+		for _, qd := range qds {
+			quotas[qd.Name] = qd.Value
+		}
+	}
+
+	return nil
 }
 
-// ResolveInstance maps an instance name to an uuid, returning "" if not found
-// TODO: Replace this O(n) algorithm with another name to id map.
+// ResolveInstance maps an instance name or uuid to an uuid, returning "" if
+// not found
 func (ds *Datastore) ResolveInstance(tenantID string, name string) (string, error) {
 	ds.tenantsLock.RLock()
 	defer ds.tenantsLock.RUnlock()
@@ -2557,10 +4250,12 @@ func (ds *Datastore) ResolveInstance(tenantID string, name string) (string, erro
 		return "", fmt.Errorf("Tenant not found: %s", tenantID)
 	}
 
-	for _, i := range t.instances {
-		if i.Name == name || i.ID == name {
-			return i.ID, nil
-		}
+	if ID, ok := t.names[name]; ok {
+		return ID, nil
+	}
+
+	if _, ok := t.instances[name]; ok {
+		return name, nil
 	}
 
 	return "", nil
@@ -2615,6 +4310,8 @@ func (ds *Datastore) AddImage(i types.Image) error {
 		ds.internalImages = append(ds.internalImages, i.ID)
 	}
 
+	ds.bumpRevision(types.ImagesRevision)
+
 	return nil
 }
 
@@ -2648,6 +4345,8 @@ func (ds *Datastore) UpdateImage(i types.Image) error {
 
 	ds.images[i.ID] = i
 
+	ds.bumpRevision(types.ImagesRevision)
+
 	return nil
 }
 
@@ -2783,5 +4482,7 @@ func (ds *Datastore) DeleteImage(ID string) error {
 
 	delete(ds.images, ID)
 
+	ds.bumpRevision(types.ImagesRevision)
+
 	return nil
 }
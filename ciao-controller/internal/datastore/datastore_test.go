@@ -31,47 +31,14 @@ import (
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
-	"github.com/ciao-project/ciao/ciao-controller/utils"
 	"github.com/ciao-project/ciao/ciao-storage"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
 	jsonpatch "github.com/evanphx/json-patch"
 )
 
-func addInstance(tenant *types.Tenant, workload types.Workload, name string) (instance *types.Instance, err error) {
-	id := uuid.Generate()
-
-	ip, err := ds.AllocateTenantIP(tenant.ID)
-	if err != nil {
-		return
-	}
-
-	mac := utils.NewTenantHardwareAddr(ip)
-
-	mask := net.CIDRMask(tenant.SubnetBits, 32)
-	ipnet := net.IPNet{
-		IP:   ip.Mask(mask),
-		Mask: mask,
-	}
-
-	instance = &types.Instance{
-		TenantID:   tenant.ID,
-		WorkloadID: workload.ID,
-		State:      payloads.Pending,
-		ID:         id.String(),
-		CNCI:       false,
-		IPAddress:  ip.String(),
-		Subnet:     ipnet.String(),
-		MACAddress: mac.String(),
-		Name:       name,
-	}
-
-	err = ds.AddInstance(instance)
-	if err != nil {
-		return
-	}
-
-	return
+func addInstance(tenant *types.Tenant, workload types.Workload, name string) (*types.Instance, error) {
+	return NewFixture(ds).AddInstance(tenant, workload, name)
 }
 
 func addTestInstance(tenant *types.Tenant, workload types.Workload) (*types.Instance, error) {
@@ -79,93 +46,20 @@ func addTestInstance(tenant *types.Tenant, workload types.Workload) (*types.Inst
 }
 
 func addTestInstances(tenant *types.Tenant, workload types.Workload, count int) ([]*types.Instance, error) {
-	var instances []*types.Instance
-	for i := 0; i < count; i++ {
-		instance, err := addInstance(tenant, workload, fmt.Sprintf("test-%d", i))
-		if err != nil {
-			return make([]*types.Instance, 0), err
-		}
-		instances = append(instances, instance)
+	instances, err := NewFixture(ds).AddInstances(tenant, workload, count)
+	if err != nil {
+		return make([]*types.Instance, 0), err
 	}
 	return instances, nil
 }
 
 func addTestWorkload(tenantID string) error {
-	testConfig := `
----
-#cloud-config
-users:
-  - name: demouser
-    gecos: CIAO Demo User
-    lock-passwd: false
-    passwd: $6$rounds=4096$w9I3hR4g/hu$AnYjaC2DfznbPSG3vxsgtgAS4mJwWBkcR74Y/KHNB5OsfAlA4gpU5j6CHWMOkkt9j.9d7OYJXJ4icXHzKXTAO.
-    sudo: ALL=(ALL) NOPASSWD:ALL
-    ssh-authorized-keys:
-    - ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAACAQDerQfD+qkb0V0XdQs8SBWqy4sQmqYFP96n/kI4Cq162w4UE8pTxy0ozAPldOvBJjljMvgaNKSAddknkhGcrNUvvJsUcZFm2qkafi32WyBdGFvIc45A+8O7vsxPXgHEsS9E3ylEALXAC3D0eX7pPtRiAbasLlY+VcACRqr3bPDSZTfpCmIkV2334uZD9iwOvTVeR+FjGDqsfju4DyzoAIqpPasE0+wk4Vbog7osP+qvn1gj5kQyusmr62+t0wx+bs2dF5QemksnFOswUrv9PGLhZgSMmDQrRYuvEfIAC7IdN/hfjTn0OokzljBiuWQ4WIIba/7xTYLVujJV65qH3heaSMxJJD7eH9QZs9RdbbdTXMFuJFsHV2OF6wZRp18tTNZZJMqiHZZSndC5WP1WrUo3Au/9a+ighSaOiVddHsPG07C/TOEnr3IrwU7c9yIHeeRFHmcQs9K0+n9XtrmrQxDQ9/mLkfje80Ko25VJ/QpAQPzCKh2KfQ4RD+/PxBUScx/lHIHOIhTSCh57ic629zWgk0coSQDi4MKSa5guDr3cuDvt4RihGviDM6V68ewsl0gh6Z9c0Hw7hU0vky4oxak5AiySiPz0FtsOnAzIL0UON+yMuKzrJgLjTKodwLQ0wlBXu43cD+P8VXwQYeqNSzfrhBnHqsrMf4lTLtc7kDDTcw== ciao@ciao
-...
-	`
-
-	storage := types.StorageResource{
-		ID:        "",
-		Ephemeral: true,
-		Size:      20,
-	}
-
-	wl := types.Workload{
-		ID:          uuid.Generate().String(),
-		TenantID:    tenantID,
-		Description: fmt.Sprintf("Private workload for %s", tenantID),
-		FWType:      string(payloads.EFI),
-		VMType:      payloads.QEMU,
-		ImageName:   "",
-		Config:      testConfig,
-		Requirements: payloads.WorkloadRequirements{
-			VCPUs: 2,
-			MemMB: 512,
-		},
-		Storage: []types.StorageResource{storage},
-	}
-
-	return ds.AddWorkload(wl)
+	_, err := NewFixture(ds).AddWorkload(tenantID)
+	return err
 }
 
-func addTestTenant() (tenant *types.Tenant, err error) {
-	/* add a new tenant */
-	tuuid := uuid.Generate()
-
-	config := types.TenantConfig{
-		Name:       "",
-		SubnetBits: 24,
-	}
-
-	tenant, err = ds.AddTenant(tuuid.String(), config)
-	if err != nil {
-		return
-	}
-
-	mac, err := utils.NewHardwareAddr()
-	if err != nil {
-		return
-	}
-
-	// Add fake CNCI
-	CNCI := types.Instance{
-		TenantID:   tenant.ID,
-		State:      payloads.Running,
-		ID:         uuid.Generate().String(),
-		CNCI:       true,
-		IPAddress:  "192.168.0.1",
-		MACAddress: mac.String(),
-	}
-
-	err = ds.AddInstance(&CNCI)
-	if err != nil {
-		return
-	}
-
-	err = addTestWorkload(tuuid.String())
-
-	return
+func addTestTenant() (*types.Tenant, error) {
+	return NewFixture(ds).AddTenant()
 }
 
 func addTestInstanceStats(t *testing.T) ([]*types.Instance, payloads.Stat) {
@@ -352,72 +246,66 @@ func TestAddInstance(t *testing.T) {
 	}
 }
 
-func TestDeleteInstanceNetwork(t *testing.T) {
+func TestAddInstances(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	wls, err := ds.GetWorkloads(tenant.ID)
-	if err != nil {
+	if err != nil || len(wls) == 0 {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
-	}
-
-	instance, err := addTestInstance(tenant, wls[0])
+	instances, err := addTestInstances(tenant, wls[0], 10)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.DeleteInstance(instance.ID)
+	tenantInstances, err := ds.GetAllInstancesFromTenant(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tenantAfter, err := ds.getTenant(tenant.ID)
-	if err != nil {
-		t.Fatal(err)
+	if len(tenantInstances) != len(instances) {
+		t.Fatalf("Expected %d tenant instances, got %d", len(instances), len(tenantInstances))
 	}
 
-	IP, ipNet, err := net.ParseCIDR(instance.Subnet)
-	if err != nil {
-		t.Fatal(err)
+	for _, i := range instances {
+		if _, err := ds.GetInstance(i.ID); err != nil {
+			t.Fatal(err)
+		}
 	}
-	mask := binary.BigEndian.Uint32(ipNet.Mask)
-	hostInt := binary.BigEndian.Uint32(IP.To4())
-	subnetInt := hostInt & mask
 
-	// confirm that tenant map shows it not used.
-	if tenantAfter.network[subnetInt][hostInt] != false {
-		t.Fatal("IP Address not released from cache")
+	// a batch including an existing instance is rejected as a whole
+	duplicate := &types.Instance{
+		ID:         instances[0].ID,
+		TenantID:   tenant.ID,
+		WorkloadID: wls[0].ID,
+		State:      payloads.Pending,
+	}
+	added := &types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		WorkloadID: wls[0].ID,
+		State:      payloads.Pending,
 	}
 
-	// clear tenant from cache
-	ds.tenantsLock.Lock()
-	delete(ds.tenants, tenant.ID)
-	ds.tenantsLock.Unlock()
-
-	// get updated tenant info - should hit database
-	newTenant, err := ds.getTenant(tenant.ID)
-	if err != nil {
-		t.Fatal(err)
+	err = ds.AddInstances([]*types.Instance{added, duplicate})
+	if err == nil {
+		t.Fatal("Batch with a duplicate instance added")
 	}
 
-	// confirm that tenant map shows it not used.
-	if newTenant.network[subnetInt][hostInt] != false {
-		t.Fatal("IP Address not released from database")
+	if _, err := ds.GetInstance(added.ID); err == nil {
+		t.Fatal("Instance of a rejected batch added")
 	}
-}
 
-func TestGetAllInstances(t *testing.T) {
-	instancesBefore, err := ds.GetAllInstances()
-	if err != nil {
+	if err := ds.AddInstances(nil); err != nil {
 		t.Fatal(err)
 	}
+}
 
+func TestProtectInstance(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
@@ -428,29 +316,42 @@ func TestGetAllInstances(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	_, err = addTestInstances(tenant, wls[0], 10)
+	err = ds.ProtectInstance(instance.ID, true)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	instances, err := ds.GetAllInstances()
+	cached, err := ds.GetInstance(instance.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(instances) != (len(instancesBefore) + 10) {
+	if !cached.Protected {
+		t.Fatal("Instance not protected in cache")
+	}
+
+	instances, err := ds.db.getInstances()
+	if err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestGetAllInstancesFromTenant(t *testing.T) {
-	var err error
+	for _, i := range instances {
+		if i.ID == instance.ID && !i.Protected {
+			t.Fatal("Instance not protected in database")
+		}
+	}
 
-	/* add a new tenant */
+	if err := ds.ProtectInstance("badID", true); err != types.ErrInstanceNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNotFound, err)
+	}
+}
+
+func TestHotAddInstanceResources(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
@@ -461,99 +362,78 @@ func TestGetAllInstancesFromTenant(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
-	}
-
-	_, err = addTestInstances(tenant, wls[0], 10)
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// if we don't get 10 eventually, the test will timeout and fail
-	instances, err := ds.GetAllInstancesFromTenant(tenant.ID)
-	for len(instances) < 10 {
-		instances, err = ds.GetAllInstancesFromTenant(tenant.ID)
-	}
-
+	before, err := ds.GetTenantResourceSummary(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(instances) < 10 {
-		t.Fatal("Didn't get right number of instances")
+	err = ds.HotAddInstanceResources(instance.ID, 2, 512)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestGetAllInstancesByNode(t *testing.T) {
-	instances, stat := addTestInstanceStats(t)
-	newInstances, err := ds.GetAllInstancesByNode(stat.NodeUUID)
+	err = ds.HotAddInstanceResources(instance.ID, 0, -256)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	retry := 5
-	for len(newInstances) < len(instances) && retry > 0 {
-		retry--
-		newInstances, err = ds.GetAllInstancesByNode(stat.NodeUUID)
-		if err != nil {
-			t.Fatal(err)
-		}
+	cached, err := ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if len(newInstances) != len(instances) {
-		msg := fmt.Sprintf("expected %d instances, got %d", len(instances), len(newInstances))
-		t.Fatal(msg)
+	if cached.ExtraVCPUs != 2 || cached.ExtraMemMB != 256 {
+		t.Fatalf("Unexpected resources in cache: %d vCPUs %d MB",
+			cached.ExtraVCPUs, cached.ExtraMemMB)
 	}
-}
 
-func TestGetInstance(t *testing.T) {
-	instances, stat := addTestInstanceStats(t)
-	instance, err := ds.GetInstance(instances[0].ID)
-	if err != nil && err != sql.ErrNoRows {
+	after, err := ds.GetTenantResourceSummary(tenant.ID)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	for instance == nil {
-		instance, err = ds.GetInstance(instances[0].ID)
-		if err != nil && err != sql.ErrNoRows {
-			t.Fatal(err)
-		}
+	if after.VCPUs != before.VCPUs+2 || after.MemMB != before.MemMB+256 {
+		t.Fatalf("Unexpected tenant summary: %d vCPUs %d MB, expected %d vCPUs %d MB",
+			after.VCPUs, after.MemMB, before.VCPUs+2, before.MemMB+256)
 	}
 
-	if instance.NodeID != stat.NodeUUID {
-		t.Fatal("retrieved incorrect NodeID")
+	// summaries rebuilt from stored instances include the hot added resources
+	ds.summaries.removeInstance(instance.ID)
+	ds.summaryAddInstance(cached)
+
+	rebuilt, err := ds.GetTenantResourceSummary(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if instance.State != payloads.ComputeStatusRunning {
-		t.Fatal("retrieved incorrect state")
+	if rebuilt.VCPUs != after.VCPUs || rebuilt.MemMB != after.MemMB {
+		t.Fatalf("Unexpected rebuilt summary: %d vCPUs %d MB, expected %d vCPUs %d MB",
+			rebuilt.VCPUs, rebuilt.MemMB, after.VCPUs, after.MemMB)
 	}
-}
 
-func TestGetTenantInstance(t *testing.T) {
-	instances, stat := addTestInstanceStats(t)
-	instance, err := ds.GetTenantInstance(instances[0].TenantID, instances[0].ID)
-	if err != nil && err != sql.ErrNoRows {
+	instances, err := ds.db.getInstances()
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	for instance == nil {
-		instance, err = ds.GetTenantInstance(instances[0].TenantID, instances[0].ID)
-		if err != nil && err != sql.ErrNoRows {
-			t.Fatal(err)
+	for _, i := range instances {
+		if i.ID == instance.ID && (i.ExtraVCPUs != 2 || i.ExtraMemMB != 256) {
+			t.Fatalf("Unexpected resources in database: %d vCPUs %d MB",
+				i.ExtraVCPUs, i.ExtraMemMB)
 		}
 	}
 
-	if instance.NodeID != stat.NodeUUID {
-		t.Fatal("retrieved incorrect NodeID")
-	}
-
-	if instance.State != payloads.ComputeStatusRunning {
-		t.Fatal("retrieved incorrect state")
+	if err := ds.HotAddInstanceResources("badID", 1, 0); err != types.ErrInstanceNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNotFound, err)
 	}
 }
 
-func TestHandleStats(t *testing.T) {
+func TestClaimInstance(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
@@ -564,65 +444,46 @@ func TestHandleStats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
-	}
-
-	instances, err := addTestInstances(tenant, wls[0], 10)
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var stats []payloads.InstanceStat
+	if err := ds.ClaimInstance(instance.ID, "claimed", ""); err == nil {
+		t.Fatal("Instance not warm claimed")
+	}
 
-	for i := range instances {
-		stat := payloads.InstanceStat{
-			InstanceUUID:  instances[i].ID,
-			State:         payloads.ComputeStatusRunning,
-			SSHIP:         "192.168.0.1",
-			SSHPort:       34567,
-			MemoryUsageMB: 0,
-			DiskUsageMB:   0,
-			CPUUsage:      0,
-		}
-		stats = append(stats, stat)
+	instance.Warm = true
+	err = ds.ClaimInstance(instance.ID, "claimed", "label")
+	if err != nil {
+		t.Fatal(err)
 	}
-	stat := payloads.Stat{
-		NodeUUID:        uuid.Generate().String(),
-		MemTotalMB:      256,
-		MemAvailableMB:  256,
-		DiskTotalMB:     1024,
-		DiskAvailableMB: 1024,
-		Load:            20,
-		CpusOnline:      4,
-		NodeHostName:    "test",
-		Instances:       stats,
+
+	if instance.Warm || instance.Name != "claimed" || instance.TraceLabel != "label" {
+		t.Fatalf("Instance not claimed in cache: %+v", instance)
 	}
 
-	err = ds.HandleStats(stat)
+	instances, err := ds.db.getInstances()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// check instance stats recorded
-	for i := range stats {
-		id := stats[i].InstanceUUID
-		instance, err := ds.GetInstance(id)
-		if err != nil {
-			t.Fatal(err)
+	for _, i := range instances {
+		if i.ID == instance.ID && (i.Warm || i.Name != "claimed") {
+			t.Fatal("Instance not claimed in database")
 		}
+	}
 
-		if instance.NodeID != stat.NodeUUID {
-			t.Fatal("Incorrect NodeID in stats table")
-		}
+	if err := ds.ClaimInstance(instance.ID, "again", ""); err == nil {
+		t.Fatal("Instance claimed twice")
+	}
 
-		if instance.State != payloads.ComputeStatusRunning {
-			t.Fatal("state not updated")
-		}
+	if err := ds.ClaimInstance("badID", "", ""); err != types.ErrInstanceNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNotFound, err)
 	}
 }
 
-func TestGetInstanceLastStats(t *testing.T) {
+func TestDeleteInstanceNetwork(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
@@ -637,24 +498,437 @@ func TestGetInstanceLastStats(t *testing.T) {
 		t.Fatal("No Workloads Found")
 	}
 
-	instances, err := addTestInstances(tenant, wls[0], 10)
+	instance, err := addTestInstance(tenant, wls[0])
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var stats []payloads.InstanceStat
-
-	for i := range instances {
-		stat := payloads.InstanceStat{
-			InstanceUUID:  instances[i].ID,
-			State:         payloads.ComputeStatusRunning,
-			SSHIP:         "192.168.0.1",
-			SSHPort:       34567,
-			MemoryUsageMB: 0,
-			DiskUsageMB:   0,
-			CPUUsage:      0,
-		}
-		stats = append(stats, stat)
+	err = ds.DeleteInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantAfter, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	IP, ipNet, err := net.ParseCIDR(instance.Subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := binary.BigEndian.Uint32(ipNet.Mask)
+	hostInt := binary.BigEndian.Uint32(IP.To4())
+	subnetInt := hostInt & mask
+
+	// confirm that tenant map shows it not used.
+	if tenantAfter.network[subnetInt][hostInt] != false {
+		t.Fatal("IP Address not released from cache")
+	}
+
+	// clear tenant from cache
+	ds.tenantsLock.Lock()
+	delete(ds.tenants, tenant.ID)
+	ds.tenantsLock.Unlock()
+
+	// get updated tenant info - should hit database
+	newTenant, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that tenant map shows it not used.
+	if newTenant.network[subnetInt][hostInt] != false {
+		t.Fatal("IP Address not released from database")
+	}
+}
+
+func TestGetAllInstances(t *testing.T) {
+	instancesBefore, err := ds.GetAllInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	_, err = addTestInstances(tenant, wls[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := ds.GetAllInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != (len(instancesBefore) + 10) {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllInstancesFromTenant(t *testing.T) {
+	var err error
+
+	/* add a new tenant */
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	_, err = addTestInstances(tenant, wls[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// if we don't get 10 eventually, the test will timeout and fail
+	instances, err := ds.GetAllInstancesFromTenant(tenant.ID)
+	for len(instances) < 10 {
+		instances, err = ds.GetAllInstancesFromTenant(tenant.ID)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) < 10 {
+		t.Fatal("Didn't get right number of instances")
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	added, err := addTestInstances(tenant, wls[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := types.InstanceFilter{TenantID: tenant.ID}
+
+	var listed []*types.Instance
+	marker := ""
+	for {
+		instances, total, err := ds.ListInstances(filter, marker, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if total != len(added) {
+			t.Fatalf("Expected %d matching instances, got %d", len(added), total)
+		}
+
+		if len(instances) > 3 {
+			t.Fatalf("Expected at most 3 instances, got %d", len(instances))
+		}
+
+		if len(instances) == 0 {
+			break
+		}
+
+		listed = append(listed, instances...)
+		marker = instances[len(instances)-1].ID
+	}
+
+	if len(listed) != len(added) {
+		t.Fatalf("Expected %d instances, got %d", len(added), len(listed))
+	}
+
+	for i := 1; i < len(listed); i++ {
+		if listed[i-1].ID >= listed[i].ID {
+			t.Fatal("Instances not sorted by ID")
+		}
+	}
+
+	filter.NamePrefix = "test-1"
+	instances, total, err := ds.ListInstances(filter, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 1 || len(instances) != 1 || instances[0].Name != "test-1" {
+		t.Fatalf("Expected only instance test-1, got %d instances", total)
+	}
+
+	filter = types.InstanceFilter{
+		TenantID: tenant.ID,
+		State:    payloads.Running,
+	}
+	_, total, err = ds.ListInstances(filter, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 0 {
+		t.Fatalf("Expected no running instances, got %d", total)
+	}
+
+	_, _, err = ds.ListInstances(filter, "", -1)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
+func TestGetAllInstancesByNode(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	newInstances, err := ds.GetAllInstancesByNode(stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retry := 5
+	for len(newInstances) < len(instances) && retry > 0 {
+		retry--
+		newInstances, err = ds.GetAllInstancesByNode(stat.NodeUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(newInstances) != len(instances) {
+		msg := fmt.Sprintf("expected %d instances, got %d", len(instances), len(newInstances))
+		t.Fatal(msg)
+	}
+}
+
+func TestGetInstance(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	instance, err := ds.GetInstance(instances[0].ID)
+	if err != nil && err != sql.ErrNoRows {
+		t.Fatal(err)
+	}
+
+	for instance == nil {
+		instance, err = ds.GetInstance(instances[0].ID)
+		if err != nil && err != sql.ErrNoRows {
+			t.Fatal(err)
+		}
+	}
+
+	if instance.NodeID != stat.NodeUUID {
+		t.Fatal("retrieved incorrect NodeID")
+	}
+
+	if instance.State != payloads.ComputeStatusRunning {
+		t.Fatal("retrieved incorrect state")
+	}
+}
+
+func TestGetTenantInstance(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	instance, err := ds.GetTenantInstance(instances[0].TenantID, instances[0].ID)
+	if err != nil && err != sql.ErrNoRows {
+		t.Fatal(err)
+	}
+
+	for instance == nil {
+		instance, err = ds.GetTenantInstance(instances[0].TenantID, instances[0].ID)
+		if err != nil && err != sql.ErrNoRows {
+			t.Fatal(err)
+		}
+	}
+
+	if instance.NodeID != stat.NodeUUID {
+		t.Fatal("retrieved incorrect NodeID")
+	}
+
+	if instance.State != payloads.ComputeStatusRunning {
+		t.Fatal("retrieved incorrect state")
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instances, err := addTestInstances(tenant, wls[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats []payloads.InstanceStat
+
+	for i := range instances {
+		stat := payloads.InstanceStat{
+			InstanceUUID:  instances[i].ID,
+			State:         payloads.ComputeStatusRunning,
+			SSHIP:         "192.168.0.1",
+			SSHPort:       34567,
+			MemoryUsageMB: 0,
+			DiskUsageMB:   0,
+			CPUUsage:      0,
+		}
+		stats = append(stats, stat)
+	}
+	stat := payloads.Stat{
+		NodeUUID:        uuid.Generate().String(),
+		MemTotalMB:      256,
+		MemAvailableMB:  256,
+		DiskTotalMB:     1024,
+		DiskAvailableMB: 1024,
+		Load:            20,
+		CpusOnline:      4,
+		NodeHostName:    "test",
+		Instances:       stats,
+	}
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check instance stats recorded
+	for i := range stats {
+		id := stats[i].InstanceUUID
+		instance, err := ds.GetInstance(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if instance.NodeID != stat.NodeUUID {
+			t.Fatal("Incorrect NodeID in stats table")
+		}
+
+		if instance.State != payloads.ComputeStatusRunning {
+			t.Fatal("state not updated")
+		}
+	}
+}
+
+func TestGetInstanceLastStats(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instances, err := addTestInstances(tenant, wls[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats []payloads.InstanceStat
+
+	for i := range instances {
+		stat := payloads.InstanceStat{
+			InstanceUUID:  instances[i].ID,
+			State:         payloads.ComputeStatusRunning,
+			SSHIP:         "192.168.0.1",
+			SSHPort:       34567,
+			MemoryUsageMB: 0,
+			DiskUsageMB:   0,
+			CPUUsage:      0,
+		}
+		stats = append(stats, stat)
+	}
+	stat := payloads.Stat{
+		NodeUUID:        uuid.Generate().String(),
+		MemTotalMB:      256,
+		MemAvailableMB:  256,
+		DiskTotalMB:     1024,
+		DiskAvailableMB: 1024,
+		Load:            20,
+		CpusOnline:      4,
+		NodeHostName:    "test",
+		Instances:       stats,
+	}
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverStats := ds.GetInstanceLastStats(stat.NodeUUID)
+
+	if len(serverStats.Servers) != len(instances) {
+		t.Fatal("Not enough instance stats retrieved")
+	}
+}
+
+func TestGetNodeLastStats(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instances, err := addTestInstances(tenant, wls[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats []payloads.InstanceStat
+
+	for i := range instances {
+		stat := payloads.InstanceStat{
+			InstanceUUID:  instances[i].ID,
+			State:         payloads.ComputeStatusRunning,
+			SSHIP:         "192.168.0.1",
+			SSHPort:       34567,
+			MemoryUsageMB: 0,
+			DiskUsageMB:   0,
+			CPUUsage:      0,
+		}
+		stats = append(stats, stat)
 	}
 	stat := payloads.Stat{
 		NodeUUID:        uuid.Generate().String(),
@@ -668,75 +942,538 @@ func TestGetInstanceLastStats(t *testing.T) {
 		Instances:       stats,
 	}
 
-	err = ds.HandleStats(stat)
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	computeNodes := ds.GetNodeLastStats()
+
+	// how many compute Nodes should be here?  If we want to
+	// control we need to clear out previous test stats
+	if len(computeNodes.Nodes) == 0 {
+		t.Fatal("Not enough compute Nodes found")
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	instanceRev := ds.Revision(types.InstancesRevision)
+	nodeRev := ds.Revision(types.NodesRevision)
+
+	// resending identical stats must not change the revisions
+	err := ds.addNodeStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.addInstanceStats(stat.Instances, stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ds.Revision(types.InstancesRevision) != instanceRev {
+		t.Fatal("Instance revision changed by identical stats")
+	}
+
+	if ds.Revision(types.NodesRevision) != nodeRev {
+		t.Fatal("Node revision changed by identical stats")
+	}
+
+	stat.Load++
+	err = ds.addNodeStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ds.Revision(types.NodesRevision) == nodeRev {
+		t.Fatal("Node revision not updated")
+	}
+
+	volumeRev := ds.Revision(types.VolumesRevision)
+
+	err = ds.InstanceStopped(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ds.Revision(types.InstancesRevision) == instanceRev {
+		t.Fatal("Instance revision not updated")
+	}
+
+	if ds.Revision(types.VolumesRevision) != volumeRev {
+		t.Fatal("Volume revision changed by instance update")
+	}
+
+	instanceRev = ds.Revision(types.InstancesRevision)
+
+	err = ds.InstanceHung(instances[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instances[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.Hung {
+		t.Fatalf("Expected instance state %s, got %s", payloads.Hung, i.State)
+	}
+
+	if ds.Revision(types.InstancesRevision) == instanceRev {
+		t.Fatal("Instance revision not updated by hung instance")
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	n, err := ds.GetNodeInstanceCount(stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != len(instances) {
+		t.Fatalf("Expected %d instances, got %d", len(instances), n)
+	}
+
+	err = ds.RemoveNode(stat.NodeUUID)
+	if err != types.ErrNodeNotEmpty {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotEmpty, err)
+	}
+
+	for _, i := range instances {
+		err = ds.InstanceStopped(i.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ds.RemoveNode(stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetNodeInstanceCount(stat.NodeUUID)
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotFound, err)
+	}
+
+	// stats from a removed node are dropped until it connects again
+	stat.Instances = nil
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetNodeInstanceCount(stat.NodeUUID)
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotFound, err)
+	}
+
+	ds.AddNode(stat.NodeUUID, payloads.ComputeNode)
+
+	err = ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = ds.GetNodeInstanceCount(stat.NodeUUID)
+	if err != nil || n != 0 {
+		t.Fatalf("Expected empty node, got %d instances: %v", n, err)
+	}
+
+	err = ds.RemoveNode(uuid.Generate().String())
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotFound, err)
+	}
+}
+
+func TestStaleStats(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	now := time.Now()
+	stat.Timestamp = now.Format(time.RFC3339Nano)
+	stat.Instances = stat.Instances[:1]
+	stat.Instances[0].State = payloads.ComputeStatusRunning
+
+	err := ds.HandleStats(stat)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	serverStats := ds.GetInstanceLastStats(stat.NodeUUID)
+	// an older sample received after a newer one must be dropped
+	stale := stat
+	stale.Timestamp = now.Add(-time.Minute).Format(time.RFC3339Nano)
+	stale.Load = 50
+	stale.Instances = []payloads.InstanceStat{stat.Instances[0]}
+	stale.Instances[0].State = payloads.ComputeStatusPending
 
-	if len(serverStats.Servers) != len(instances) {
-		t.Fatal("Not enough instance stats retrieved")
+	err = ds.HandleStats(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.ComputeStatusRunning {
+		t.Fatalf("Stale stats applied, instance is %s", i.State)
+	}
+
+	for _, n := range ds.GetNodeLastStats().Nodes {
+		if n.ID != stat.NodeUUID {
+			continue
+		}
+
+		if n.Load != stat.Load {
+			t.Fatalf("Stale stats applied, node load is %d", n.Load)
+		}
+
+		if !n.Timestamp.Equal(now) {
+			t.Fatalf("Expected node timestamp %v, got %v", now, n.Timestamp)
+		}
+	}
+
+	// samples from another node are not compared with this one
+	other := stale
+	other.NodeUUID = uuid.Generate().String()
+
+	err = ds.HandleStats(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err = ds.GetInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State != payloads.ComputeStatusPending || i.NodeID != other.NodeUUID {
+		t.Fatalf("Stats from new node not applied: %s on %s", i.State, i.NodeID)
 	}
 }
 
-func TestGetNodeLastStats(t *testing.T) {
-	tenant, err := addTestTenant()
+func TestInstanceNetworkSamples(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	stat.Instances = stat.Instances[:1]
+	now := time.Now()
+
+	for i := 0; i < maxInstanceSamples+5; i++ {
+		stat.Timestamp = now.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano)
+		stat.Instances[0].Networks = []payloads.InstanceNetworkStat{
+			{
+				Interface: "vnic0",
+				RxBytes:   uint64(i * 1000),
+				TxBytes:   uint64(i * 100),
+				RxPackets: uint64(i * 10),
+				TxPackets: uint64(i),
+			},
+		}
+
+		err := ds.HandleStats(stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples := ds.GetInstanceNetworkSamples(instances[0].ID)
+	if len(samples) != maxInstanceSamples {
+		t.Fatalf("Expected %d samples, got %d", maxInstanceSamples, len(samples))
+	}
+
+	last := samples[len(samples)-1]
+	if len(last.Networks) != 1 || last.Networks[0].TxPackets != maxInstanceSamples+4 {
+		t.Fatalf("Unexpected last sample %+v", last)
+	}
+
+	if samples[0].Networks[0].TxPackets != 5 {
+		t.Fatalf("Oldest samples not dropped: %+v", samples[0])
+	}
+
+	_, err := ds.deleteInstance(instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	wls, err := ds.GetWorkloads(tenant.ID)
+	if len(ds.GetInstanceNetworkSamples(instances[0].ID)) != 0 {
+		t.Fatal("Samples of deleted instance not removed")
+	}
+}
+
+func TestInstanceDiskIOSamples(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	stat.Instances = stat.Instances[:1]
+	now := time.Now()
+
+	for i := 0; i < maxInstanceSamples+5; i++ {
+		stat.Timestamp = now.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano)
+		stat.Instances[0].DiskIO = &payloads.InstanceDiskIOStat{
+			ReadBytes:  uint64(i * 4096),
+			WriteBytes: uint64(i * 8192),
+			ReadOps:    uint64(i * 10),
+			WriteOps:   uint64(i * 20),
+		}
+
+		err := ds.HandleStats(stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples := ds.GetInstanceDiskIOSamples(instances[0].ID)
+	if len(samples) != maxInstanceSamples {
+		t.Fatalf("Expected %d samples, got %d", maxInstanceSamples, len(samples))
+	}
+
+	last := samples[len(samples)-1].DiskIO
+	if last.WriteOps != (maxInstanceSamples+4)*20 || last.ReadIOPS != 10 || last.WriteIOPS != 20 {
+		t.Fatalf("Unexpected last sample %+v", last)
+	}
+
+	stats := ds.GetInstanceLastStats(stat.NodeUUID)
+	found := false
+	for _, s := range stats.Servers {
+		if s.ID == instances[0].ID {
+			found = s.DiskIO != nil && s.DiskIO.WriteIOPS == 20
+		}
+	}
+	if !found {
+		t.Fatalf("Disk I/O not reported in instance stats: %+v", stats)
+	}
+
+	// counters going backwards after a restart give no rate
+	stat.Timestamp = now.Add(time.Hour).Format(time.RFC3339Nano)
+	stat.Instances[0].DiskIO = &payloads.InstanceDiskIOStat{ReadOps: 1, WriteOps: 1}
+	if err := ds.HandleStats(stat); err != nil {
+		t.Fatal(err)
+	}
+
+	samples = ds.GetInstanceDiskIOSamples(instances[0].ID)
+	last = samples[len(samples)-1].DiskIO
+	if last.ReadIOPS != 0 || last.WriteIOPS != 0 {
+		t.Fatalf("Unexpected rate after restart %+v", last)
+	}
+
+	_, err := ds.deleteInstance(instances[0].ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(wls) == 0 {
-		t.Fatal("No Workloads Found")
+	if len(ds.GetInstanceDiskIOSamples(instances[0].ID)) != 0 {
+		t.Fatal("Samples of deleted instance not removed")
 	}
+}
 
-	instances, err := addTestInstances(tenant, wls[0], 10)
+func TestNodeHealth(t *testing.T) {
+	_, stat := addTestInstanceStats(t)
+
+	stat.Health = &payloads.NodeHealthStat{
+		Temperatures: []payloads.TemperatureStat{
+			{Sensor: "coretemp/Core 0", Celsius: 45},
+		},
+		CorrectedECCErrors:   2,
+		UncorrectedECCErrors: 0,
+		Disks: []payloads.DiskHealthStat{
+			{Device: "sda", Healthy: true},
+		},
+	}
+
+	err := ds.HandleStats(stat)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var stats []payloads.InstanceStat
+	expected := &types.CiaoNodeHealth{
+		Temperatures: []types.CiaoTemperature{
+			{Sensor: "coretemp/Core 0", Celsius: 45},
+		},
+		CorrectedECCErrors:   2,
+		UncorrectedECCErrors: 0,
+		Disks: []types.CiaoDiskHealth{
+			{Device: "sda", Healthy: true},
+		},
+	}
 
-	for i := range instances {
-		stat := payloads.InstanceStat{
-			InstanceUUID:  instances[i].ID,
-			State:         payloads.ComputeStatusRunning,
-			SSHIP:         "192.168.0.1",
-			SSHPort:       34567,
-			MemoryUsageMB: 0,
-			DiskUsageMB:   0,
-			CPUUsage:      0,
+	for _, n := range ds.GetNodeLastStats().Nodes {
+		if n.ID != stat.NodeUUID {
+			continue
 		}
-		stats = append(stats, stat)
+
+		if !reflect.DeepEqual(n.Health, expected) {
+			t.Fatalf("Expected health %+v, got %+v", expected, n.Health)
+		}
+		return
 	}
-	stat := payloads.Stat{
-		NodeUUID:        uuid.Generate().String(),
-		MemTotalMB:      256,
-		MemAvailableMB:  256,
-		DiskTotalMB:     1024,
-		DiskAvailableMB: 1024,
-		Load:            20,
-		CpusOnline:      4,
-		NodeHostName:    "test",
-		Instances:       stats,
+
+	t.Fatalf("Node %s not found", stat.NodeUUID)
+}
+
+func TestInstanceHealth(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	checked := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	stat.Instances[0].Health = &payloads.InstanceHealthStat{
+		Status:   payloads.Unhealthy,
+		Failures: 3,
+		Message:  "connection refused",
+		Checked:  checked.Format(time.RFC3339),
 	}
 
-	err = ds.HandleStats(stat)
+	err := ds.HandleStats(stat)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	computeNodes := ds.GetNodeLastStats()
+	expected := &types.CiaoInstanceHealth{
+		Status:   payloads.Unhealthy,
+		Failures: 3,
+		Message:  "connection refused",
+		Checked:  checked,
+	}
 
-	// how many compute Nodes should be here?  If we want to
-	// control we need to clear out previous test stats
-	if len(computeNodes.Nodes) == 0 {
-		t.Fatal("Not enough compute Nodes found")
+	h := ds.GetInstanceHealth(instances[0].ID)
+	if h == nil || !h.Checked.Equal(expected.Checked) {
+		t.Fatalf("Expected health %+v, got %+v", expected, h)
+	}
+	h.Checked = expected.Checked
+	if !reflect.DeepEqual(h, expected) {
+		t.Fatalf("Expected health %+v, got %+v", expected, h)
+	}
+
+	if ds.GetInstanceHealth(instances[1].ID) != nil {
+		t.Fatal("Health reported for an unchecked instance")
+	}
+}
+
+func TestInstanceStateTransitions(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+	stat.Instances = stat.Instances[:1]
+	id := instances[0].ID
+
+	checkState := func(expected string) {
+		i, err := ds.GetInstance(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i.State != expected {
+			t.Fatalf("Expected instance state %s, got %s", expected, i.State)
+		}
+	}
+
+	err := ds.InstanceStopped(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(payloads.Exited)
+
+	lastStat := func() types.CiaoServerStats {
+		ds.instanceLastStatLock.Lock()
+		defer ds.instanceLastStatLock.Unlock()
+		return ds.instanceLastStat[id]
+	}
+	before := lastStat()
+
+	// an exited instance must be restarted before running again, the
+	// stats reporting it running are ignored altogether.
+	stat.Instances[0].CPUUsage = before.VCPUUsage + 10
+	err = ds.addInstanceStats(stat.Instances, stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(payloads.Exited)
+
+	if after := lastStat(); after.VCPUUsage != before.VCPUUsage || after.Status != before.Status {
+		t.Fatalf("Ignored stats cached: %+v", after)
+	}
+
+	err = ds.InstanceRestarting(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(payloads.Pending)
+
+	err = ds.addInstanceStats(stat.Instances, stat.NodeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(payloads.Running)
+
+	i, err := ds.GetInstance(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an instance whose deletion timed out recovers when its node
+	// reports it running.
+	for _, state := range []string{payloads.Hung, payloads.Missing} {
+		err = i.TransitionInstanceState(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ds.addInstanceStats(stat.Instances, stat.NodeUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkState(payloads.Running)
+	}
+
+	err = i.TransitionInstanceState(payloads.Deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.InstanceRestarting(id)
+	if err == nil {
+		t.Fatal("Deleted instance restarted")
+	}
+	checkState(payloads.Deleted)
+}
+
+func TestGetOrphanedInstances(t *testing.T) {
+	instances, stat := addTestInstanceStats(t)
+
+	unknown := uuid.Generate().String()
+	missing := instances[0].ID
+
+	// drop the first instance from the node report and add one
+	// the datastore does not know about.
+	stat.Instances = append(stat.Instances[1:], payloads.InstanceStat{
+		InstanceUUID: unknown,
+		State:        payloads.ComputeStatusRunning,
+	})
+
+	err := ds.HandleStats(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]types.OrphanKind{}
+	for _, o := range ds.GetOrphanedInstances(0) {
+		if o.NodeID == stat.NodeUUID {
+			found[o.InstanceID] = o.Kind
+		}
+	}
+
+	if len(found) != 2 || found[unknown] != types.OrphanUnknown || found[missing] != types.OrphanMissing {
+		t.Fatalf("Unexpected orphans %v", found)
+	}
+
+	instances[0].CreateTime = time.Now()
+
+	for _, o := range ds.GetOrphanedInstances(time.Hour) {
+		if o.NodeID == stat.NodeUUID && o.Kind == types.OrphanMissing {
+			t.Fatalf("Instance %s reported missing within grace period", o.InstanceID)
+		}
 	}
 }
 
@@ -793,45 +1530,177 @@ func TestGetBatchFrameSummary(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = ds.db.getBatchFrameSummary()
+	_, err = ds.db.getBatchFrameSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetEventLog(t *testing.T) {
+	e := types.LogEntry{
+		TenantID: "test-tenantID",
+		Severity: types.EventInfo,
+		Category: types.EventCategoryNode,
+		Message:  "this is a test",
+	}
+	err := ds.db.logEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.db.getEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogEvent(t *testing.T) {
+	e := types.LogEntry{
+		TenantID: "test-tenantID",
+		Severity: types.EventInfo,
+		Category: types.EventCategoryNode,
+		Message:  "this is a test",
+	}
+	err := ds.db.logEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClearLog(t *testing.T) {
+	err := ds.db.clearLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testEventQueries checks the filtering, pagination and pruning of the
+// events of an empty event log.
+func testEventQueries(t *testing.T, ps persistentStore) {
+	start := time.Now().UTC().Add(-time.Hour)
+
+	for i := 0; i < 10; i++ {
+		e := types.LogEntry{
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			TenantID:  fmt.Sprintf("tenant-%d", i%2),
+			NodeID:    fmt.Sprintf("node-%d", i%3),
+			Severity:  types.EventInfo,
+			Category:  types.EventCategoryInstance,
+			Message:   fmt.Sprintf("event %d", i),
+		}
+
+		if i%5 == 0 {
+			e.Severity = types.EventError
+			e.Category = types.EventCategoryNode
+		}
+
+		err := ps.logEvent(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter   types.EventFilter
+		messages []string
+	}{
+		{types.EventFilter{}, []string{"event 0", "event 1", "event 2", "event 3", "event 4",
+			"event 5", "event 6", "event 7", "event 8", "event 9"}},
+		{types.EventFilter{TenantID: "tenant-1"}, []string{"event 1", "event 3", "event 5", "event 7", "event 9"}},
+		{types.EventFilter{NodeID: "node-0"}, []string{"event 0", "event 3", "event 6", "event 9"}},
+		{types.EventFilter{Severity: types.EventWarning}, []string{"event 0", "event 5"}},
+		{types.EventFilter{Category: types.EventCategoryInstance, TenantID: "tenant-0"},
+			[]string{"event 2", "event 4", "event 6", "event 8"}},
+		{types.EventFilter{Since: start.Add(3 * time.Minute), Until: start.Add(6 * time.Minute)},
+			[]string{"event 3", "event 4", "event 5"}},
+	}
+
+	for _, test := range tests {
+		for _, limit := range []int{0, 1, 3} {
+			var messages []string
+			marker := ""
+			for {
+				events, next, err := ps.getEvents(test.filter, marker, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if limit > 0 && len(events) > limit {
+					t.Fatalf("Expected at most %d events, got %d", limit, len(events))
+				}
+
+				for _, e := range events {
+					messages = append(messages, e.Message)
+				}
+
+				if next == "" {
+					break
+				}
+				marker = next
+			}
+
+			if !reflect.DeepEqual(messages, test.messages) {
+				t.Fatalf("Expected events %v with filter %+v and limit %d, got %v",
+					test.messages, test.filter, limit, messages)
+			}
+		}
+	}
+
+	count, err := ps.pruneLog(start.Add(4 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 4 {
+		t.Fatalf("Expected 4 events pruned, got %d", count)
+	}
+
+	events, _, err := ps.getEvents(types.EventFilter{}, "", 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestGetEventLog(t *testing.T) {
-	e := types.LogEntry{
-		TenantID:  "test-tenantID",
-		EventType: "info",
-		Message:   "this is a test",
+	if len(events) != 6 || events[0].Message != "event 4" {
+		t.Fatalf("Unexpected events after pruning: %d", len(events))
 	}
-	err := ds.db.logEvent(e)
+}
+
+func TestEventQueries(t *testing.T) {
+	mds := &Datastore{}
+	err := mds.Init(Config{
+		DBBackend:         &MemoryDB{},
+		InitWorkloadsPath: *workloadsPath,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer mds.Exit()
 
-	_, err = ds.db.getEventLog()
+	testEventQueries(t, mds.db)
+
+	sqliteDB, err := getPersistentStore()
 	if err != nil {
 		t.Fatal(err)
 	}
-}
+	defer sqliteDB.disconnect()
 
-func TestLogEvent(t *testing.T) {
-	e := types.LogEntry{
-		TenantID:  "test-tenantID",
-		EventType: "info",
-		Message:   "this is a test",
-	}
-	err := ds.db.logEvent(e)
+	testEventQueries(t, sqliteDB)
+
+	server := newFakeEtcd()
+	defer server.Close()
+
+	eds := &Datastore{}
+	err = eds.Init(etcdConfig(server))
 	if err != nil {
 		t.Fatal(err)
 	}
-}
+	defer eds.Exit()
 
-func TestClearLog(t *testing.T) {
-	err := ds.db.clearLog()
-	if err != nil {
-		t.Fatal(err)
+	testEventQueries(t, eds.db)
+
+	_, _, err = eds.ListEvents(types.EventFilter{}, "", -1)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
 	}
 }
 
@@ -955,98 +1824,444 @@ func TestGetCNCIWorkloadID(t *testing.T) {
 	}
 }
 
-func TestGetTenant(t *testing.T) {
+func TestGetTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTenant, err := ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testTenant.ID != tenant.ID {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAllTenants(t *testing.T) {
+	_, err := ds.GetAllTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// for now, just check that the query has no
+	// errors.
+}
+
+func TestUpdateTenant(t *testing.T) {
+	/* add a new tenant without CNCI*/
+	tuuid := uuid.Generate()
+
+	initConfig := types.TenantConfig{
+		Name:       "",
+		SubnetBits: 24,
+	}
+
+	tenant, err := ds.AddTenant(tuuid.String(), initConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldconfig := types.TenantConfig{
+		Name:       tenant.Name,
+		SubnetBits: tenant.SubnetBits,
+	}
+
+	a, err := json.Marshal(oldconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := types.TenantConfig{
+		Name:       "name1",
+		SubnetBits: 20,
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merge, err := jsonpatch.CreateMergePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.JSONPatchTenant(tenant.ID, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTenant, err := ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.Name != "name1" || testTenant.SubnetBits != 20 {
+		t.Fatal("Tenant update not successful")
+	}
+}
+
+func TestPatchTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := []byte(`[{"op":"test","path":"/subnet_bits","value":24},{"op":"replace","path":"/name","value":"patched"}]`)
+
+	err = ds.PatchTenant(tenant.ID, patch, types.JSONPatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTenant, err := ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.Name != "patched" {
+		t.Fatal("Tenant not patched")
+	}
+
+	// the fake CNCI of the tenant prevents changing its subnet size
+	patch = []byte(`[{"op":"replace","path":"/name","value":"other"},{"op":"replace","path":"/subnet_bits","value":20}]`)
+
+	err = ds.PatchTenant(tenant.ID, patch, types.JSONPatch)
+	pe, ok := err.(*types.PatchError)
+	if !ok || pe.Index != 1 {
+		t.Fatalf("Expected failure of operation 1, got %v", err)
+	}
+
+	testTenant, err = ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.Name != "patched" || testTenant.SubnetBits != 24 {
+		t.Fatal("Tenant modified by a failed patch")
+	}
+}
+
+func TestResubnetTenant(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ResubnetTenant(tenant.ID, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.SubnetBits != 20 || len(cached.network) != 0 {
+		t.Fatalf("Tenant not resubnetted in cache: %d bits, %d subnets", cached.SubnetBits, len(cached.network))
+	}
+
+	dbTenant, err := ds.db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbTenant.SubnetBits != 20 || len(dbTenant.network) != 0 {
+		t.Fatalf("Tenant not resubnetted in database: %d bits, %d subnets", dbTenant.SubnetBits, len(dbTenant.network))
+	}
+
+	// the released address is the first one allocated in the new subnets
+	newIP, err := ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !newIP.Equal(ip) {
+		t.Fatalf("Expected %v to be allocated, got %v", ip, newIP)
+	}
+
+	if err := ds.ResubnetTenant(uuid.Generate().String(), 20); err != ErrNoTenant {
+		t.Fatalf("Expected %v, got %v", ErrNoTenant, err)
+	}
+}
+
+func TestPatchTenantNotifications(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := []byte(`{"notifications":{"mode":"digest","email":"ops@example.com","digest_minutes":30}}`)
+	err = ds.PatchTenant(tenant.ID, patch, types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := types.NotificationConfig{
+		Mode:          types.NotifyDigest,
+		Email:         "ops@example.com",
+		DigestMinutes: 30,
+	}
+
+	dbTenant, err := ds.db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbTenant.Notifications != expected {
+		t.Fatalf("Expected notifications %+v, got %+v", expected, dbTenant.Notifications)
+	}
+
+	invalid := []string{
+		`{"notifications":{"mode":"hourly","email":"ops@example.com"}}`,
+		`{"notifications":{"mode":"immediate","email":"not an address"}}`,
+		`{"notifications":{"digest_minutes":-1}}`,
+	}
+
+	for _, p := range invalid {
+		err = ds.PatchTenant(tenant.ID, []byte(p), types.MergePatch)
+		if err != types.ErrBadRequest {
+			t.Errorf("Patch %s: expected %v, got %v", p, types.ErrBadRequest, err)
+		}
+	}
+
+	testTenant, err := ds.GetTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTenant.Notifications != expected {
+		t.Fatalf("Notifications modified by an invalid patch: %+v", testTenant.Notifications)
+	}
+}
+
+func TestPatchTenantInstanceLimit(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"max_instances_per_request":5000}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err := ds.db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbTenant.MaxInstancesPerRequest != 5000 {
+		t.Fatalf("Expected instance limit 5000, got %d", dbTenant.MaxInstancesPerRequest)
+	}
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"max_instances_per_request":-2}`), types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
+func TestPatchTenantWarmPools(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"warm_pools":{"workload":3}}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err := ds.db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbTenant.WarmPools["workload"] != 3 {
+		t.Fatalf("Expected warm pool of 3 instances, got %v", dbTenant.WarmPools)
+	}
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"warm_pools":{"workload":-1}}`), types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
+func TestPatchTenantNetworkPolicy(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := `{"network_policy":{"mode":"rules","rules":[{"source":"172.16.0.0/24","destination":"172.16.1.0/24"}]}}`
+	err = ds.PatchTenant(tenant.ID, []byte(patch), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err := ds.db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := dbTenant.NetworkPolicy
+	if policy.Mode != types.NetworkRules || len(policy.Rules) != 1 ||
+		policy.Rules[0].Source != "172.16.0.0/24" || policy.Rules[0].Destination != "172.16.1.0/24" {
+		t.Fatalf("Unexpected network policy %+v", policy)
+	}
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"network_policy":{"mode":"deny_all","rules":null}}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err = ds.db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbTenant.NetworkPolicy.Mode != types.NetworkDenyAll || len(dbTenant.NetworkPolicy.Rules) != 0 {
+		t.Fatalf("Unexpected network policy %+v", dbTenant.NetworkPolicy)
+	}
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"network_policy":{"mode":"allow_all","rules":[{"source":"172.16.0.0/24","destination":"172.16.1.0/24"}]}}`), types.MergePatch)
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
+func TestPatchTenantInstanceDefaults(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	testTenant, err := ds.GetTenant(tenant.ID)
+	patch := `{"instance_defaults":{"tags":["audited","team-a"],"forbid_public_workloads":true}}`
+	err = ds.PatchTenant(tenant.ID, []byte(patch), types.MergePatch)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if testTenant.ID != tenant.ID {
-		t.Fatal(err)
-	}
-}
 
-func TestGetAllTenants(t *testing.T) {
-	_, err := ds.GetAllTenants()
+	dbTenant, err := ds.db.getTenant(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// for now, just check that the query has no
-	// errors.
-}
-
-func TestUpdateTenant(t *testing.T) {
-	/* add a new tenant without CNCI*/
-	tuuid := uuid.Generate()
 
-	initConfig := types.TenantConfig{
-		Name:       "",
-		SubnetBits: 24,
+	defaults := dbTenant.InstanceDefaults
+	if len(defaults.Tags) != 2 || defaults.Tags[1] != "team-a" || !defaults.ForbidPublicWorkloads {
+		t.Fatalf("Unexpected instance defaults %+v", defaults)
 	}
 
-	tenant, err := ds.AddTenant(tuuid.String(), initConfig)
-	if err != nil {
-		t.Fatal(err)
+	invalid := []string{
+		`{"instance_defaults":{"tags":["audited","audited"]}}`,
+		`{"instance_defaults":{"tags":[""]}}`,
+		`{"instance_defaults":{"ssh_key":"ssh-rsa garbage"}}`,
 	}
-
-	oldconfig := types.TenantConfig{
-		Name:       tenant.Name,
-		SubnetBits: tenant.SubnetBits,
+	for _, patch := range invalid {
+		err = ds.PatchTenant(tenant.ID, []byte(patch), types.MergePatch)
+		if err != types.ErrBadRequest {
+			t.Errorf("Expected %v for %s, got %v", types.ErrBadRequest, patch, err)
+		}
 	}
+}
 
-	a, err := json.Marshal(oldconfig)
+func TestEventHandler(t *testing.T) {
+	var events []types.LogEntry
+	ds.SetEventHandler(func(e types.LogEntry) {
+		events = append(events, e)
+	})
+	defer ds.SetEventHandler(nil)
+
+	err := ds.LogEvent("test-tenantID", types.EventError, types.EventCategoryInstance, "handled")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	config := types.TenantConfig{
-		Name:       "name1",
-		SubnetBits: 20,
+	if len(events) != 1 || events[0].Message != "handled" || events[0].Timestamp.IsZero() {
+		t.Fatalf("Unexpected events passed to handler: %+v", events)
 	}
+}
 
-	b, err := json.Marshal(config)
+func TestDeleteTenant(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	merge, err := jsonpatch.CreateMergePatch(a, b)
+	err = ds.DeleteTenant(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ds.JSONPatchTenant(tenant.ID, merge)
+	testTenant, err := ds.GetTenant(tenant.ID)
+	if err == nil || testTenant != nil {
+		t.Fatal("Tenant not deleted")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	testTenant, err := ds.GetTenant(tenant.ID)
+	err = ds.UpdateQuotas(tenant.ID, []types.QuotaDetails{
+		{Name: "tenant-vcpu-quota", Value: 10},
+		{Name: "tenant-instances-quota", Value: 2},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if testTenant.Name != "name1" || testTenant.SubnetBits != 20 {
-		t.Fatal("Tenant update not successful")
+	expected := []types.QuotaDetails{
+		{Name: "tenant-instances-quota", Value: 2},
+		{Name: "tenant-vcpu-quota", Value: 10},
+	}
+	qds, err := ds.GetQuotas(tenant.ID)
+	if err != nil || !reflect.DeepEqual(qds, expected) {
+		t.Fatalf("Expected quotas %+v, got %+v: %v", expected, qds, err)
 	}
-}
 
-func TestDeleteTenant(t *testing.T) {
-	tenant, err := addTestTenant()
+	// updates of cached quotas are merged in the cache and written
+	// through to the database
+	err = ds.UpdateQuotas(tenant.ID, []types.QuotaDetails{{Name: "tenant-instances-quota", Value: 5}})
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	expected[0].Value = 5
+	qds, err = ds.GetQuotas(tenant.ID)
+	if err != nil || !reflect.DeepEqual(qds, expected) {
+		t.Fatalf("Expected quotas %+v, got %+v: %v", expected, qds, err)
+	}
+
+	qds, err = ds.db.getQuotas(tenant.ID)
+	if err != nil || !reflect.DeepEqual(qds, expected) {
+		t.Fatalf("Expected stored quotas %+v, got %+v: %v", expected, qds, err)
+	}
+
+	// the returned quotas are copies
+	qds[0].Value = 0
+	qds, err = ds.GetQuotas(tenant.ID)
+	if err != nil || qds[0].Value != 5 {
+		t.Fatalf("Cached quotas modified through a returned copy: %+v", qds)
+	}
+
 	err = ds.DeleteTenant(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	testTenant, err := ds.GetTenant(tenant.ID)
-	if err == nil || testTenant != nil {
-		t.Fatal("Tenant not deleted")
+	qds, err = ds.GetQuotas(tenant.ID)
+	if err != nil || len(qds) != 0 {
+		t.Fatalf("Quotas of deleted tenant still cached: %+v: %v", qds, err)
 	}
 }
 
@@ -1153,6 +2368,75 @@ func TestReleaseTenantIP(t *testing.T) {
 	}
 }
 
+func TestReleaseOrphanedTenantIPs(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaked, err := ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ips, err := ds.GetTenantIPs(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ips) != 2 {
+		t.Fatalf("Expected 2 tenant IPs, got %d", len(ips))
+	}
+
+	for _, ip := range ips {
+		if ip.Address == instance.IPAddress {
+			if ip.InstanceID != instance.ID || ip.Orphaned {
+				t.Errorf("Unexpected claim for instance IP: %+v", ip)
+			}
+		} else if ip.Address != leaked.String() || !ip.Orphaned {
+			t.Errorf("Unexpected claim for leaked IP: %+v", ip)
+		}
+	}
+
+	_, err = ds.ReleaseOrphanedTenantIPs(tenant.ID, []string{instance.IPAddress})
+	if err != types.ErrTenantIPInUse {
+		t.Errorf("Expected %v releasing an instance IP, got %v", types.ErrTenantIPInUse, err)
+	}
+
+	_, err = ds.ReleaseOrphanedTenantIPs(tenant.ID, []string{"10.0.0.1"})
+	if err != types.ErrAddressNotFound {
+		t.Errorf("Expected %v releasing an unclaimed IP, got %v", types.ErrAddressNotFound, err)
+	}
+
+	released, err := ds.ReleaseOrphanedTenantIPs(tenant.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(released) != 1 || released[0] != leaked.String() {
+		t.Fatalf("Expected %s to be released, got %v", leaked, released)
+	}
+
+	ips, err = ds.GetTenantIPs(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ips) != 1 || ips[0].InstanceID != instance.ID {
+		t.Fatalf("Unexpected tenant IPs after release: %+v", ips)
+	}
+}
+
 func TestStartFailureFullCloud(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1180,6 +2464,21 @@ func TestStartFailureFullCloud(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected instance not to be present")
 	}
+
+	logs, err := ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range logs {
+		if l.InstanceID == instance.ID {
+			if l.TenantID != tenant.ID || l.Reason != string(reason) {
+				t.Fatalf("Unexpected start failure event %+v", *l)
+			}
+			return
+		}
+	}
+	t.Fatal("Start failure event not found")
 }
 
 func TestAttachVolumeFailure(t *testing.T) {
@@ -1322,6 +2621,99 @@ func TestAllocate1024IPs(t *testing.T) {
 	testAllocateTenantIPs(t, 1024)
 }
 
+func TestTenantResourceSummary(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instances, err := addTestInstances(tenant, wls[0], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.InstanceStopped(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID:   uuid.Generate().String(),
+			Size: 20,
+		},
+		State:    types.Available,
+		TenantID: tenant.ID,
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// internal volumes must not be accounted
+	internal := data
+	internal.ID = uuid.Generate().String()
+	internal.Internal = true
+
+	err = ds.AddBlockDevice(internal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := ds.GetTenantResourceSummary(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.TotalInstances != 3 ||
+		summary.InstanceStates[payloads.Pending] != 2 ||
+		summary.InstanceStates[payloads.Exited] != 1 {
+		t.Fatalf("Unexpected instance counts: %+v", summary)
+	}
+
+	if summary.VCPUs != 3*wls[0].Requirements.VCPUs ||
+		summary.MemMB != 3*wls[0].Requirements.MemMB {
+		t.Fatalf("Unexpected footprint: %+v", summary)
+	}
+
+	if summary.TotalVolumes != 1 || summary.VolumeSizeGB != 20 {
+		t.Fatalf("Unexpected volume usage: %+v", summary)
+	}
+
+	err = ds.DeleteInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err = ds.GetTenantResourceSummary(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.TotalInstances != 2 ||
+		summary.InstanceStates[payloads.Pending] != 2 ||
+		summary.InstanceStates[payloads.Exited] != 0 ||
+		summary.TotalVolumes != 0 ||
+		summary.VolumeSizeGB != 0 {
+		t.Fatalf("Unexpected summary after deletion: %+v", summary)
+	}
+}
+
 func TestAddBlockDevice(t *testing.T) {
 	newTenant, err := addTestTenant()
 	if err != nil {
@@ -1380,80 +2772,167 @@ func TestDeleteBlockDevice(t *testing.T) {
 		CreateTime:  time.Now(),
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	_, err = ds.GetBlockDevice("validID")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// remove the block device
+	err = ds.DeleteBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that it is no longer there.
+	_, err = ds.GetBlockDevice(data.ID)
+	if err == nil {
+		t.Fatal("Did not delete block device")
+	}
+
+	// attempt to delete a non-existing block device
+	err = ds.DeleteBlockDevice("unknownID")
+	if err != ErrNoBlockData {
+		t.Fatalf("expecting %s error, received %s\n", ErrNoBlockData, err)
+	}
+}
+
+func TestUpdateBlockDevice(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockDevice := storage.BlockDevice{
+		ID: uuid.Generate().String(),
+	}
+
+	data := types.Volume{
+		BlockDevice: blockDevice,
+		State:       types.Available,
+		TenantID:    newTenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	_, err = ds.GetBlockDevice(blockDevice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// update the state of the block device.
+	data.State = types.Attaching
+
+	err = ds.UpdateBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// confirm that we can retrieve the block data.
+	d, err := ds.GetBlockDevice(blockDevice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.State != types.Attaching {
+		t.Fatalf("expected State == %s, got %s\n", types.Attaching, d.State)
+	}
+}
+
+func TestGetAllBlockDevices(t *testing.T) {
+	newTenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	_, err = ds.GetBlockDevice("validID")
-	if err != nil {
-		t.Fatal(err)
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID: uuid.Generate().String(),
+		},
+		State:      types.Available,
+		TenantID:   newTenant.ID,
+		CreateTime: time.Now(),
 	}
 
-	// remove the block device
-	err = ds.DeleteBlockDevice(data.ID)
+	err = ds.AddBlockDevice(data)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that it is no longer there.
-	_, err = ds.GetBlockDevice(data.ID)
-	if err == nil {
-		t.Fatal("Did not delete block device")
+	for _, d := range ds.GetAllBlockDevices() {
+		if d.ID == data.ID {
+			return
+		}
 	}
 
-	// attempt to delete a non-existing block device
-	err = ds.DeleteBlockDevice("unknownID")
-	if err != ErrNoBlockData {
-		t.Fatalf("expecting %s error, received %s\n", ErrNoBlockData, err)
-	}
+	t.Fatalf("Block device %s not found", data.ID)
 }
 
-func TestUpdateBlockDevice(t *testing.T) {
-	newTenant, err := addTestTenant()
+func TestQuotaProfiles(t *testing.T) {
+	_, err := ds.GetQuotaProfile("medium")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	blockDevice := storage.BlockDevice{
-		ID: uuid.Generate().String(),
-	}
-
-	data := types.Volume{
-		BlockDevice: blockDevice,
-		State:       types.Available,
-		TenantID:    newTenant.ID,
-		CreateTime:  time.Now(),
+	p := types.QuotaProfile{
+		Name:   "test-profile",
+		Quotas: []types.QuotaDetails{{Name: "tenant-instances-quota", Value: 3}},
 	}
 
-	err = ds.AddBlockDevice(data)
+	err = ds.UpdateQuotaProfile(p)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	_, err = ds.GetBlockDevice(blockDevice.ID)
+	config := types.TenantConfig{
+		Name:         "test-profile-tenant",
+		SubnetBits:   24,
+		QuotaProfile: "test-profile",
+	}
+
+	tenant, err := ds.AddTenant(uuid.Generate().String(), config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// update the state of the block device.
-	data.State = types.Attaching
+	tenants := ds.GetQuotaProfileTenants("test-profile")
+	if len(tenants) != 1 || tenants[0] != tenant.ID {
+		t.Fatalf("Unexpected profile tenants %v", tenants)
+	}
 
-	err = ds.UpdateBlockDevice(data)
+	err = ds.DeleteQuotaProfile("test-profile")
+	if err != types.ErrQuotaProfileInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrQuotaProfileInUse, err)
+	}
+
+	err = ds.JSONPatchTenant(tenant.ID, []byte(`{"quota_profile":"bogus"}`))
+	if err != types.ErrQuotaProfileNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrQuotaProfileNotFound, err)
+	}
+
+	err = ds.JSONPatchTenant(tenant.ID, []byte(`{"quota_profile":null}`))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// confirm that we can retrieve the block data.
-	d, err := ds.GetBlockDevice(blockDevice.ID)
+	err = ds.DeleteQuotaProfile("test-profile")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if d.State != types.Attaching {
-		t.Fatalf("expected State == %s, got %s\n", types.Attaching, d.State)
+	_, err = ds.GetQuotaProfile("test-profile")
+	if err != types.ErrQuotaProfileNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrQuotaProfileNotFound, err)
 	}
 }
 
@@ -2396,6 +3875,286 @@ func TestMapIPs(t *testing.T) {
 	}
 }
 
+func TestMapIPAddress(t *testing.T) {
+	orig := types.Pool{
+		ID:   uuid.Generate().String(),
+		Name: "address",
+	}
+
+	err := ds.AddPool(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddExternalSubnet(orig.ID, "10.201.0.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddExternalIPs(orig.ID, []string{"10.202.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []string{"10.201.0.0", "10.201.0.3", "10.202.0.2"}
+	for _, address := range invalid {
+		_, err = ds.MapExternalIPAddress(orig.ID, address, instance.ID)
+		if err != types.ErrInvalidPoolAddress {
+			t.Fatalf("%s: expected %v, got %v", address, types.ErrInvalidPoolAddress, err)
+		}
+	}
+
+	_, err = ds.MapExternalIPAddress(orig.ID, "bogus", instance.ID)
+	if err != types.ErrInvalidIP {
+		t.Fatalf("Expected %v, got %v", types.ErrInvalidIP, err)
+	}
+
+	var mapped []types.MappedIP
+	for _, address := range []string{"10.201.0.2", "10.202.0.1"} {
+		m, err := ds.MapExternalIPAddress(orig.ID, address, instance.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.ExternalIP != address {
+			t.Fatalf("Expected %s to be mapped, got %s", address, m.ExternalIP)
+		}
+		mapped = append(mapped, m)
+	}
+
+	_, err = ds.MapExternalIPAddress(orig.ID, "10.202.0.1", instance.ID)
+	if err != types.ErrAddressMapped {
+		t.Fatalf("Expected %v, got %v", types.ErrAddressMapped, err)
+	}
+
+	// the first free address is still handed out by MapExternalIP
+	m, err := ds.MapExternalIP(orig.ID, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ExternalIP != "10.201.0.1" {
+		t.Fatalf("Expected 10.201.0.1 to be mapped, got %s", m.ExternalIP)
+	}
+	mapped = append(mapped, m)
+
+	for _, m := range mapped {
+		err = ds.UnMapExternalIP(m.ExternalIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ds.DeletePool(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleMappedIPs(t *testing.T) {
+	orig := types.Pool{
+		ID:   uuid.Generate().String(),
+		Name: "stale",
+	}
+
+	err := ds.AddPool(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddExternalIPs(orig.ID, []string{"10.203.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ds.MapExternalIP(orig.ID, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.UpdateMappedIPNode(m.ExternalIP, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.instancesLock.Lock()
+	instance.NodeID = "node-2"
+	ds.instancesLock.Unlock()
+
+	// only running instances need their mappings reprogrammed
+	if len(ds.GetStaleMappedIPs()) != 0 {
+		t.Fatal("Mapping of a pending instance reported as stale")
+	}
+
+	err = instance.TransitionInstanceState(payloads.Running)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := ds.GetStaleMappedIPs()
+	if len(stale) != 1 || stale[0].ExternalIP != m.ExternalIP || stale[0].NodeID != "node-1" {
+		t.Fatalf("Unexpected stale mappings %v", stale)
+	}
+
+	err = ds.UpdateMappedIPNode(m.ExternalIP, "node-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.GetStaleMappedIPs()) != 0 {
+		t.Fatal("Mapping still reported as stale")
+	}
+
+	err = ds.UpdateMappedIPNode("10.203.0.2", "node-2")
+	if err != types.ErrAddressNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAddressNotFound, err)
+	}
+
+	err = ds.UnMapExternalIP(m.ExternalIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeletePool(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubnetUtilization(t *testing.T) {
+	orig := types.Pool{
+		ID:   uuid.Generate().String(),
+		Name: "utilization",
+	}
+
+	err := ds.AddPool(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddExternalSubnet(orig.ID, "10.200.0.0/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := ds.GetPool(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Subnets[0].Used != 0 || pool.Subnets[0].Free != 6 {
+		t.Fatalf("Unexpected subnet usage %+v", pool.Subnets[0])
+	}
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mapped []types.MappedIP
+	for i := 0; i < 2; i++ {
+		instance, err := addInstance(tenant, wls[0], fmt.Sprintf("utilization-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := ds.MapExternalIP(pool.ID, instance.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapped = append(mapped, m)
+	}
+
+	pool, err = ds.GetPool(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := pool.Subnets[0]
+	if sub.Used != 2 || sub.Free != 4 {
+		t.Fatalf("Unexpected subnet usage %+v", sub)
+	}
+
+	expected := []string{"10.200.0.1", "10.200.0.2"}
+	if !reflect.DeepEqual(sub.MappedIPs, expected) {
+		t.Fatalf("Expected mapped IPs %v, got %v", expected, sub.MappedIPs)
+	}
+
+	// callers must not be able to modify the cached pool
+	pool.Subnets[0].MappedIPs[0] = "bogus"
+
+	err = ds.DeleteSubnet(pool.ID, sub.ID)
+	if err != types.ErrPoolNotEmpty {
+		t.Fatalf("Expected %v, got %v", types.ErrPoolNotEmpty, err)
+	}
+
+	err = ds.UnMapExternalIP(mapped[0].ExternalIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err = ds.GetPool(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub = pool.Subnets[0]
+	if sub.Used != 1 || sub.Free != 5 || len(sub.MappedIPs) != 1 ||
+		sub.MappedIPs[0] != mapped[1].ExternalIP {
+		t.Fatalf("Unexpected subnet usage %+v", sub)
+	}
+
+	err = ds.UnMapExternalIP(mapped[1].ExternalIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteSubnet(pool.ID, sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeletePool(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetMappedIPs(t *testing.T) {
 	orig := types.Pool{
 		ID:   uuid.Generate().String(),
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// deletedInstance returns the record kept of an instance deleted at a
+// time.
+func deletedInstance(i *types.Instance, deleted time.Time) types.DeletedInstance {
+	i.StateLock.RLock()
+	state := i.State
+	i.StateLock.RUnlock()
+
+	return types.DeletedInstance{
+		ID:         i.ID,
+		TenantID:   i.TenantID,
+		Name:       i.Name,
+		WorkloadID: i.WorkloadID,
+		NodeID:     i.NodeID,
+		State:      state,
+		IPAddress:  i.IPAddress,
+		MACAddress: i.MACAddress,
+		Subnet:     i.Subnet,
+		TraceLabel: i.TraceLabel,
+		Created:    i.CreateTime,
+		Deleted:    deleted,
+	}
+}
+
+// DeletedInstanceRetention returns how long the records of deleted
+// instances are kept, or 0 if deleted instances are not recorded.
+func (ds *Datastore) DeletedInstanceRetention() time.Duration {
+	return time.Duration(ds.configInt(DeletedInstanceRetentionDays)) * 24 * time.Hour
+}
+
+// ListDeletedInstances returns the records of the deleted instances of a
+// tenant, most recently deleted first.
+func (ds *Datastore) ListDeletedInstances(tenantID string) ([]types.DeletedInstance, error) {
+	all, err := ds.db.getDeletedInstances()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting deleted instances")
+	}
+
+	instances := []types.DeletedInstance{}
+	for _, d := range all {
+		if d.TenantID == tenantID {
+			instances = append(instances, d)
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Deleted.After(instances[j].Deleted)
+	})
+
+	return instances, nil
+}
+
+// PurgeDeletedInstances removes the records of the deleted instances of a
+// tenant and returns the number of records removed.
+func (ds *Datastore) PurgeDeletedInstances(tenantID string) (int, error) {
+	return ds.purgeDeletedInstances(func(d types.DeletedInstance) bool {
+		return d.TenantID == tenantID
+	})
+}
+
+// PruneDeletedInstances removes the records of the instances deleted
+// before a time and returns the number of records removed.
+func (ds *Datastore) PruneDeletedInstances(before time.Time) (int, error) {
+	return ds.purgeDeletedInstances(func(d types.DeletedInstance) bool {
+		return d.Deleted.Before(before)
+	})
+}
+
+func (ds *Datastore) purgeDeletedInstances(match func(types.DeletedInstance) bool) (int, error) {
+	instances, err := ds.db.getDeletedInstances()
+	if err != nil {
+		return 0, errors.Wrap(err, "Error getting deleted instances")
+	}
+
+	count := 0
+	for _, d := range instances {
+		if !match(d) {
+			continue
+		}
+
+		err = ds.db.deleteDeletedInstance(d.ID)
+		if err != nil {
+			return count, errors.Wrapf(err, "Error purging deleted instance %s", d.ID)
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestDeletedInstances(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	// deleted instances are not recorded by default
+	instance, err := addInstance(tenant, wls[0], "forgotten")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.DeleteInstance(instance.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := ds.ListDeletedInstances(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("Deleted instance recorded without retention: %+v", deleted)
+	}
+
+	if err := ds.SetConfigSetting(DeletedInstanceRetentionDays, "1"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.SetConfigSetting(DeletedInstanceRetentionDays, "") }()
+
+	instances, err := addTestInstances(tenant, wls[0], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range instances {
+		if err := ds.DeleteInstance(i.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err = ds.ListDeletedInstances(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("Expected 2 deleted instances, got %d", len(deleted))
+	}
+
+	// most recently deleted first
+	if deleted[0].ID != instances[1].ID || deleted[1].ID != instances[0].ID {
+		t.Fatalf("Unexpected deleted instances %+v", deleted)
+	}
+	if deleted[0].IPAddress != instances[1].IPAddress || deleted[0].WorkloadID != wls[0].ID {
+		t.Fatalf("Deleted instance not recorded: %+v", deleted[0])
+	}
+
+	count, err := ds.PruneDeletedInstances(time.Now().Add(-time.Hour))
+	if err != nil || count != 0 {
+		t.Fatalf("Expected no deleted instance pruned, got %d: %v", count, err)
+	}
+
+	count, err = ds.PurgeDeletedInstances(tenant.ID)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 deleted instances purged, got %d: %v", count, err)
+	}
+
+	deleted, err = ds.ListDeletedInstances(tenant.ID)
+	if err != nil || len(deleted) != 0 {
+		t.Fatalf("Deleted instances not purged: %+v %v", deleted, err)
+	}
+}
+
+func TestDeletedInstanceRollback(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	instance, err := addInstance(tenant, wls[0], "kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+		State:       types.Available,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	if err := ds.AddBlockDevice(data); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.CreateStorageAttachment(instance.ID, payloads.StorageResource{ID: data.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.SetConfigSetting(DeletedInstanceRetentionDays, "1"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.SetConfigSetting(DeletedInstanceRetentionDays, "") }()
+
+	memDB := ds.db.(*MemoryDB)
+	ds.db = failingStore{memDB}
+
+	err = ds.DeleteInstance(instance.ID)
+
+	ds.db = memDB
+
+	if err == nil {
+		t.Fatal("Expected instance deletion to fail")
+	}
+
+	deleted, err := ds.ListDeletedInstances(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("Instance recorded as deleted: %+v", deleted)
+	}
+}
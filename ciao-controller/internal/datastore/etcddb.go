@@ -0,0 +1,1498 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// etcdTimeout bounds the duration of each request to etcd.
+const etcdTimeout = 10 * time.Second
+
+// etcdMaxTxnOps is the default maximum number of operations etcd accepts
+// in a single transaction.
+const etcdMaxTxnOps = 128
+
+// etcdKV is a key value pair returned by etcd. Keys and values are
+// base64 encoded and revisions are strings in the JSON API of etcd,
+// which the field types and tags take care of.
+type etcdKV struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	ModRevision    int64  `json:"mod_revision,string"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKV `json:"kvs"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdDeleteRangeResponse struct {
+	Deleted int64 `json:"deleted,string"`
+}
+
+// etcdCompare is the condition of a transaction on the creation or
+// modification revision of a key, which is 0 for missing keys.  Only one
+// of the revisions may be set, etcd comparing with 0 when it is not.
+type etcdCompare struct {
+	Key            []byte `json:"key"`
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	CreateRevision int64  `json:"create_revision,string,omitempty"`
+	ModRevision    int64  `json:"mod_revision,string,omitempty"`
+}
+
+type etcdOp struct {
+	RequestPut         *etcdPutRequest   `json:"request_put,omitempty"`
+	RequestDeleteRange *etcdRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare `json:"compare"`
+	Success []etcdOp      `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+// etcdInstance holds the persistent fields of an instance along with the
+// ones reported by the latest instance statistics.
+type etcdInstance struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id"`
+	WorkloadID string    `json:"workload_id"`
+	MACAddress string    `json:"mac_address"`
+	VnicUUID   string    `json:"vnic_uuid"`
+	Subnet     string    `json:"subnet"`
+	IPAddress  string    `json:"ip_address"`
+	CreateTime time.Time `json:"create_time"`
+	Name       string    `json:"name"`
+	CNCI       bool      `json:"cnci"`
+	TraceLabel string    `json:"trace_label"`
+	Protected  bool      `json:"protected"`
+	Warm       bool      `json:"warm"`
+	ExtraVCPUs int       `json:"extra_vcpus"`
+	ExtraMemMB int       `json:"extra_mem_mb"`
+	Tags       []string  `json:"tags"`
+
+	State   string `json:"state"`
+	NodeID  string `json:"node_id"`
+	SSHIP   string `json:"ssh_ip"`
+	SSHPort int    `json:"ssh_port"`
+}
+
+func (i *etcdInstance) instance() *types.Instance {
+	state := i.State
+	if state == "" {
+		state = payloads.ComputeStatusPending
+	}
+
+	return &types.Instance{
+		ID:          i.ID,
+		TenantID:    i.TenantID,
+		State:       state,
+		WorkloadID:  i.WorkloadID,
+		NodeID:      i.NodeID,
+		MACAddress:  i.MACAddress,
+		VnicUUID:    i.VnicUUID,
+		Subnet:      i.Subnet,
+		IPAddress:   i.IPAddress,
+		SSHIP:       i.SSHIP,
+		SSHPort:     i.SSHPort,
+		CNCI:        i.CNCI,
+		CreateTime:  i.CreateTime,
+		Name:        i.Name,
+		TraceLabel:  i.TraceLabel,
+		Protected:   i.Protected,
+		Warm:        i.Warm,
+		ExtraVCPUs:  i.ExtraVCPUs,
+		ExtraMemMB:  i.ExtraMemMB,
+		Tags:        i.Tags,
+		StateChange: sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+// etcdWorkload adds the tenant of a workload, which types.Workload does
+// not marshal.
+type etcdWorkload struct {
+	types.Workload
+	TenantID string `json:"tenant_id"`
+}
+
+// etcdDB is a persistentStore keeping the content of the datastore in an
+// etcd cluster, through the JSON API of etcd v3, so that the state of
+// the controller does not depend on its local disk.  Its URIs are of
+// the form etcd://host:2379/prefix, or etcds:// for HTTPS, every record
+// being stored as JSON under the prefix, /ciao by default.
+type etcdDB struct {
+	endpoint string
+	prefix   string
+	client   *http.Client
+}
+
+// prefixEnd returns the end of the range of keys starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (db *etcdDB) key(parts ...string) string {
+	return db.prefix + strings.Join(parts, "/")
+}
+
+func (db *etcdDB) call(method string, request interface{}, response interface{}) error {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling etcd request")
+	}
+
+	resp, err := db.client.Post(db.endpoint+"/v3/"+method, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "Error sending etcd %s request", method)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading etcd %s response", method)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s request failed: %s: %s", method, resp.Status, body)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return errors.Wrapf(json.Unmarshal(body, response), "Error unmarshalling etcd %s response", method)
+}
+
+func (db *etcdDB) get(key string) (*etcdKV, error) {
+	var resp etcdRangeResponse
+	err := db.call("kv/range", etcdRangeRequest{Key: []byte(key)}, &resp)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+	return &resp.Kvs[0], nil
+}
+
+// list returns the key value pairs whose key starts with prefix, sorted
+// by key.
+func (db *etcdDB) list(prefix string) ([]etcdKV, error) {
+	var resp etcdRangeResponse
+	req := etcdRangeRequest{Key: []byte(prefix), RangeEnd: prefixEnd(prefix)}
+	err := db.call("kv/range", req, &resp)
+	return resp.Kvs, err
+}
+
+func (db *etcdDB) put(key string, value []byte) error {
+	return db.call("kv/put", etcdPutRequest{Key: []byte(key), Value: value}, nil)
+}
+
+func (db *etcdDB) putJSON(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "Error marshalling %s", key)
+	}
+	return db.put(key, b)
+}
+
+// deleteRange deletes a key or, if prefix is true, all the keys starting
+// with it, and returns the number of keys deleted.
+func (db *etcdDB) deleteRange(key string, prefix bool) (int64, error) {
+	req := etcdRangeRequest{Key: []byte(key)}
+	if prefix {
+		req.RangeEnd = prefixEnd(key)
+	}
+
+	var resp etcdDeleteRangeResponse
+	err := db.call("kv/deleterange", req, &resp)
+	return resp.Deleted, err
+}
+
+func (db *etcdDB) txn(compare []etcdCompare, success []etcdOp) (bool, error) {
+	var resp etcdTxnResponse
+	err := db.call("kv/txn", etcdTxnRequest{Compare: compare, Success: success}, &resp)
+	return resp.Succeeded, err
+}
+
+func keyMissing(key string) etcdCompare {
+	return etcdCompare{Key: []byte(key), Target: "CREATE", Result: "EQUAL"}
+}
+
+func keyExists(key string) etcdCompare {
+	return etcdCompare{Key: []byte(key), Target: "CREATE", Result: "GREATER"}
+}
+
+func putOp(key string, value []byte) etcdOp {
+	return etcdOp{RequestPut: &etcdPutRequest{Key: []byte(key), Value: value}}
+}
+
+func deleteOp(key string, prefix bool) etcdOp {
+	req := &etcdRangeRequest{Key: []byte(key)}
+	if prefix {
+		req.RangeEnd = prefixEnd(key)
+	}
+	return etcdOp{RequestDeleteRange: req}
+}
+
+// create stores v under key unless the key exists, in which case it
+// returns false.
+func (db *etcdDB) create(key string, v interface{}) (bool, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error marshalling %s", key)
+	}
+	return db.txn([]etcdCompare{keyMissing(key)}, []etcdOp{putOp(key, b)})
+}
+
+// update unmarshals the value of key into v, lets modify change it and
+// stores it back, unless the key was modified in the meantime, in which
+// case it starts over. Missing keys are not created.
+func (db *etcdDB) update(key string, v interface{}, modify func()) error {
+	for {
+		kv, err := db.get(key)
+		if err != nil || kv == nil {
+			return err
+		}
+
+		if err := json.Unmarshal(kv.Value, v); err != nil {
+			return errors.Wrapf(err, "Error unmarshalling %s", key)
+		}
+
+		modify()
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrapf(err, "Error marshalling %s", key)
+		}
+
+		unchanged := etcdCompare{Key: []byte(key), Target: "MOD", Result: "EQUAL", ModRevision: kv.ModRevision}
+		ok, err := db.txn([]etcdCompare{unchanged}, []etcdOp{putOp(key, b)})
+		if err != nil || ok {
+			return err
+		}
+	}
+}
+
+// etcdTxRetries bounds the number of times a transaction is started over
+// because the keys it modifies were changed by somebody else.
+const etcdTxRetries = 8
+
+// etcdWrite computes the conditions and operations of a write, from the
+// current content of the store.
+type etcdWrite func() ([]etcdCompare, []etcdOp, error)
+
+// etcdTx is a transaction on the etcd cluster.  Its writes are recorded
+// and sent in a single etcd transaction by commitTx, which fails as a
+// whole if one of the keys they read changed in the meantime.
+type etcdTx struct {
+	db     *etcdDB
+	writes []etcdWrite
+}
+
+func (db *etcdDB) beginTx() (persistentTx, error) {
+	return &etcdTx{db: db}, nil
+}
+
+// update records a write storing back the value of key once modify has
+// changed it, as etcdDB.update. Missing keys are not created.
+func (tx *etcdTx) update(key string, v interface{}, modify func()) {
+	tx.writes = append(tx.writes, func() ([]etcdCompare, []etcdOp, error) {
+		kv, err := tx.db.get(key)
+		if err != nil || kv == nil {
+			return nil, nil, err
+		}
+
+		if err := json.Unmarshal(kv.Value, v); err != nil {
+			return nil, nil, errors.Wrapf(err, "Error unmarshalling %s", key)
+		}
+
+		modify()
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Error marshalling %s", key)
+		}
+
+		unchanged := etcdCompare{Key: []byte(key), Target: "MOD", Result: "EQUAL", ModRevision: kv.ModRevision}
+		return []etcdCompare{unchanged}, []etcdOp{putOp(key, b)}, nil
+	})
+}
+
+func (tx *etcdTx) delete(key string) {
+	tx.writes = append(tx.writes, func() ([]etcdCompare, []etcdOp, error) {
+		return nil, []etcdOp{deleteOp(key, false)}, nil
+	})
+}
+
+func (tx *etcdTx) deleteInstance(instanceID string) error {
+	tx.delete(tx.db.key("instances", instanceID))
+	return nil
+}
+
+func (tx *etcdTx) updateInstance(instance *types.Instance) error {
+	var i etcdInstance
+	tx.update(tx.db.key("instances", instance.ID), &i, func() {
+		i.setUpdatable(instance)
+	})
+	return nil
+}
+
+func (tx *etcdTx) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	tx.delete(tx.db.tenantIPKey(tenantID, subnetInt, rest))
+	return nil
+}
+
+func (tx *etcdTx) updateBlockData(data types.Volume) error {
+	var d types.Volume
+	tx.update(tx.db.key("volumes", data.ID), &d, func() {
+		setUpdatableVolume(&d, data)
+	})
+	return nil
+}
+
+func (tx *etcdTx) addStorageAttachment(a types.StorageAttachment) error {
+	key := tx.db.key("attachments", a.ID)
+	b, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrapf(err, "Error marshalling %s", key)
+	}
+
+	tx.writes = append(tx.writes, func() ([]etcdCompare, []etcdOp, error) {
+		kv, err := tx.db.get(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv != nil {
+			return nil, nil, fmt.Errorf("Attachment %s already exists", a.ID)
+		}
+		return []etcdCompare{keyMissing(key)}, []etcdOp{putOp(key, b)}, nil
+	})
+	return nil
+}
+
+func (tx *etcdTx) deleteStorageAttachment(ID string) error {
+	tx.delete(tx.db.key("attachments", ID))
+	return nil
+}
+
+func (tx *etcdTx) addDeletedInstance(d types.DeletedInstance) error {
+	key := tx.db.key("deleted-instances", d.ID)
+	b, err := json.Marshal(d)
+	if err != nil {
+		return errors.Wrapf(err, "Error marshalling %s", key)
+	}
+
+	tx.writes = append(tx.writes, func() ([]etcdCompare, []etcdOp, error) {
+		return nil, []etcdOp{putOp(key, b)}, nil
+	})
+	return nil
+}
+
+func (tx *etcdTx) commitTx() error {
+	for attempt := 0; attempt < etcdTxRetries; attempt++ {
+		var compare []etcdCompare
+		var success []etcdOp
+		for _, w := range tx.writes {
+			c, ops, err := w()
+			if err != nil {
+				return err
+			}
+			compare = append(compare, c...)
+			success = append(success, ops...)
+		}
+
+		if len(success) == 0 {
+			return nil
+		}
+		if len(success) > etcdMaxTxnOps {
+			return fmt.Errorf("etcd transaction of %d operations exceeds the limit of %d",
+				len(success), etcdMaxTxnOps)
+		}
+
+		ok, err := tx.db.txn(compare, success)
+		if err != nil || ok {
+			return err
+		}
+	}
+
+	return fmt.Errorf("etcd transaction kept conflicting after %d attempts", etcdTxRetries)
+}
+
+func (tx *etcdTx) rollbackTx() error {
+	tx.writes = nil
+	return nil
+}
+
+func (db *etcdDB) init(config Config) error {
+	u, err := url.Parse(config.PersistentURI)
+	if err != nil {
+		return fmt.Errorf("Invalid URL (%s) for persistent data store: %v", config.PersistentURI, err)
+	}
+
+	switch u.Scheme {
+	case "etcd":
+		db.endpoint = "http://" + u.Host
+	case "etcds":
+		db.endpoint = "https://" + u.Host
+	default:
+		return fmt.Errorf("Invalid etcd URL (%s) for persistent data store", config.PersistentURI)
+	}
+
+	db.prefix = strings.TrimSuffix(u.Path, "/")
+	if db.prefix == "" {
+		db.prefix = "/ciao"
+	}
+	db.prefix += "/"
+
+	db.client = &http.Client{Timeout: etcdTimeout}
+
+	err = db.call("maintenance/status", struct{}{}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to connect to etcd at %s", db.endpoint)
+	}
+
+	return nil
+}
+
+func (db *etcdDB) disconnect() {
+	if t, ok := db.client.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+func (db *etcdDB) logEvent(entry types.LogEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+
+	// keys sort the log by time
+	key := db.key("log", fmt.Sprintf("%020d-%s", entry.Timestamp.UnixNano(), uuid.Generate()))
+	return db.putJSON(key, entry)
+}
+
+func (db *etcdDB) clearLog() error {
+	_, err := db.deleteRange(db.key("log", ""), true)
+	return err
+}
+
+func (db *etcdDB) getEventLog() ([]*types.LogEntry, error) {
+	kvs, err := db.list(db.key("log", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	logEntries := make([]*types.LogEntry, 0, len(kvs))
+	for _, kv := range kvs {
+		var e types.LogEntry
+		if err := json.Unmarshal(kv.Value, &e); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling event")
+		}
+		logEntries = append(logEntries, &e)
+	}
+
+	return logEntries, nil
+}
+
+func (db *etcdDB) getEvents(filter types.EventFilter, marker string, limit int) ([]*types.LogEntry, string, error) {
+	prefix := db.key("log", "")
+
+	kvs, err := db.list(prefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// markers are the keys of the events, less the prefix, which sort
+	// the log by time.
+	logEntries := make([]*types.LogEntry, 0)
+	for _, kv := range kvs {
+		key := strings.TrimPrefix(string(kv.Key), prefix)
+		if key <= marker {
+			continue
+		}
+
+		var e types.LogEntry
+		if err := json.Unmarshal(kv.Value, &e); err != nil {
+			return nil, "", errors.Wrap(err, "Error unmarshalling event")
+		}
+
+		if !filter.Match(&e) {
+			continue
+		}
+
+		if limit > 0 && len(logEntries) == limit {
+			return logEntries, marker, nil
+		}
+
+		logEntries = append(logEntries, &e)
+		marker = key
+	}
+
+	return logEntries, "", nil
+}
+
+func (db *etcdDB) pruneLog(before time.Time) (int, error) {
+	req := etcdRangeRequest{
+		Key:      []byte(db.key("log", "")),
+		RangeEnd: []byte(db.key("log", fmt.Sprintf("%020d", before.UnixNano()))),
+	}
+
+	var resp etcdDeleteRangeResponse
+	err := db.call("kv/deleterange", req, &resp)
+	return int(resp.Deleted), err
+}
+
+func (db *etcdDB) addWorkload(wl types.Workload) error {
+	ok, err := db.create(db.key("workloads", wl.ID), etcdWorkload{Workload: wl, TenantID: wl.TenantID})
+	if err == nil && !ok {
+		err = fmt.Errorf("Workload %s already exists", wl.ID)
+	}
+	return err
+}
+
+func (db *etcdDB) deleteWorkload(ID string) error {
+	deleted, err := db.deleteRange(db.key("workloads", ID), false)
+	if err == nil && deleted == 0 {
+		err = fmt.Errorf("Workload %s not found", ID)
+	}
+	return err
+}
+
+func (db *etcdDB) getAllWorkloads() ([]types.Workload, error) {
+	kvs, err := db.list(db.key("workloads", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := []types.Workload{}
+	for _, kv := range kvs {
+		var wl etcdWorkload
+		if err := json.Unmarshal(kv.Value, &wl); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling workload")
+		}
+
+		wl.Workload.TenantID = wl.TenantID
+		workloads = append(workloads, wl.Workload)
+	}
+
+	return workloads, nil
+}
+
+func (db *etcdDB) getWorkloads() ([]types.Workload, error) {
+	all, err := db.getAllWorkloads()
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := []types.Workload{}
+	for _, wl := range all {
+		if wl.Visibility != types.Internal {
+			workloads = append(workloads, wl)
+		}
+	}
+
+	return workloads, nil
+}
+
+func (db *etcdDB) addTenant(id string, config types.TenantConfig) error {
+	ok, err := db.create(db.key("tenants", id), config)
+	if err == nil && !ok {
+		err = fmt.Errorf("Tenant %s already exists", id)
+	}
+	return err
+}
+
+// tenantIPKey returns the key marking an address of a tenant as used.
+func (db *etcdDB) tenantIPKey(tenantID string, subnetInt uint32, rest uint32) string {
+	return db.key("tenant-ips", tenantID, strconv.FormatUint(uint64(subnetInt), 10),
+		strconv.FormatUint(uint64(rest), 10))
+}
+
+// getTenantRecords builds the tenant records the datastore caches, those
+// of all the tenants if id is empty.
+func (db *etcdDB) getTenantRecords(id string) ([]*tenant, error) {
+	var tenantKVs []etcdKV
+	if id != "" {
+		kv, err := db.get(db.key("tenants", id))
+		if err != nil || kv == nil {
+			return nil, err
+		}
+		tenantKVs = []etcdKV{*kv}
+	} else {
+		var err error
+		tenantKVs, err = db.list(db.key("tenants", ""))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tenants := make(map[string]*tenant)
+	var result []*tenant
+	for _, kv := range tenantKVs {
+		t := &tenant{
+			Tenant: types.Tenant{
+				ID: strings.TrimPrefix(string(kv.Key), db.key("tenants", "")),
+			},
+			network:   make(map[uint32]map[uint32]bool),
+			instances: make(map[string]*types.Instance),
+			devices:   make(map[string]types.Volume),
+		}
+
+		if err := json.Unmarshal(kv.Value, &t.TenantConfig); err != nil {
+			return nil, errors.Wrapf(err, "Error unmarshalling tenant %s", t.ID)
+		}
+
+		tenants[t.ID] = t
+		result = append(result, t)
+	}
+
+	IPs, err := db.list(db.key("tenant-ips", id))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range IPs {
+		parts := strings.Split(strings.TrimPrefix(string(kv.Key), db.key("tenant-ips", "")), "/")
+		if len(parts) != 3 || tenants[parts[0]] == nil {
+			continue
+		}
+
+		subnet, err1 := strconv.ParseUint(parts[1], 10, 32)
+		host, err2 := strconv.ParseUint(parts[2], 10, 32)
+		if err1 != nil || err2 != nil {
+			glog.Warningf("Ignoring invalid tenant address %s", kv.Key)
+			continue
+		}
+
+		network := tenants[parts[0]].network
+		if network[uint32(subnet)] == nil {
+			network[uint32(subnet)] = make(map[uint32]bool)
+		}
+		network[uint32(subnet)][uint32(host)] = true
+	}
+
+	instances, err := db.getInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range instances {
+		if t := tenants[i.TenantID]; t != nil {
+			t.instances[i.ID] = i
+		}
+	}
+
+	devices, err := db.getAllBlockData()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range devices {
+		if t := tenants[d.TenantID]; t != nil {
+			t.devices[d.ID] = d
+		}
+	}
+
+	return result, nil
+}
+
+func (db *etcdDB) getTenant(id string) (*tenant, error) {
+	tenants, err := db.getTenantRecords(id)
+	if err != nil || len(tenants) == 0 {
+		// a missing tenant is not an error
+		return nil, err
+	}
+
+	return tenants[0], nil
+}
+
+func (db *etcdDB) getTenants() ([]*tenant, error) {
+	return db.getTenantRecords("")
+}
+
+func (db *etcdDB) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	_, err := db.deleteRange(db.tenantIPKey(tenantID, subnetInt, rest), false)
+	return err
+}
+
+func (db *etcdDB) releaseTenantIPs(tenantID string) error {
+	_, err := db.deleteRange(db.key("tenant-ips", tenantID, ""), true)
+	return err
+}
+
+func (db *etcdDB) claimTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	return db.claimTenantIPs(tenantID, []tenantIP{{subnet: subnetInt, host: rest}})
+}
+
+func (db *etcdDB) claimTenantIPs(tenantID string, IPs []tenantIP) error {
+	ops := make([]etcdOp, 0, len(IPs))
+	for _, ip := range IPs {
+		ops = append(ops, putOp(db.tenantIPKey(tenantID, ip.subnet, ip.host), nil))
+	}
+
+	ok, err := db.txn([]etcdCompare{keyExists(db.key("tenants", tenantID))}, ops)
+	if err == nil && !ok {
+		err = fmt.Errorf("Tenant %s not found", tenantID)
+	}
+	return err
+}
+
+func (db *etcdDB) updateTenant(tenant *types.Tenant) error {
+	b, err := json.Marshal(tenant.TenantConfig)
+	if err != nil {
+		return errors.Wrapf(err, "Error marshalling tenant %s", tenant.ID)
+	}
+
+	key := db.key("tenants", tenant.ID)
+	_, err = db.txn([]etcdCompare{keyExists(key)}, []etcdOp{putOp(key, b)})
+	return err
+}
+
+func (db *etcdDB) deleteTenant(tenantID string) error {
+	ops := []etcdOp{
+		deleteOp(db.key("tenants", tenantID), false),
+		deleteOp(db.key("tenant-ips", tenantID, ""), true),
+		deleteOp(db.key("quotas", tenantID, ""), true),
+		deleteOp(db.key("tenant-usage", tenantID, ""), true),
+	}
+
+	_, err := db.txn(nil, ops)
+	return err
+}
+
+func (db *etcdDB) getInstances() ([]*types.Instance, error) {
+	kvs, err := db.list(db.key("instances", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []*types.Instance
+	for _, kv := range kvs {
+		var i etcdInstance
+		if err := json.Unmarshal(kv.Value, &i); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling instance")
+		}
+		instances = append(instances, i.instance())
+	}
+
+	return instances, nil
+}
+
+func newEtcdInstance(instance *types.Instance) etcdInstance {
+	return etcdInstance{
+		ID:         instance.ID,
+		TenantID:   instance.TenantID,
+		WorkloadID: instance.WorkloadID,
+		MACAddress: instance.MACAddress,
+		VnicUUID:   instance.VnicUUID,
+		Subnet:     instance.Subnet,
+		IPAddress:  instance.IPAddress,
+		CreateTime: instance.CreateTime,
+		Name:       instance.Name,
+		CNCI:       instance.CNCI,
+		TraceLabel: instance.TraceLabel,
+		Protected:  instance.Protected,
+		Warm:       instance.Warm,
+		ExtraVCPUs: instance.ExtraVCPUs,
+		ExtraMemMB: instance.ExtraMemMB,
+		Tags:       instance.Tags,
+	}
+}
+
+func (db *etcdDB) addInstance(instance *types.Instance) error {
+	ok, err := db.create(db.key("instances", instance.ID), newEtcdInstance(instance))
+	if err == nil && !ok {
+		err = fmt.Errorf("Instance %s already exists", instance.ID)
+	}
+	return err
+}
+
+// addInstances creates the instances in transactions of at most
+// etcdMaxTxnOps instances. Each transaction fails if one of its instances
+// already exists, but the transactions committed before are kept.
+func (db *etcdDB) addInstances(instances []*types.Instance) error {
+	for len(instances) > 0 {
+		n := len(instances)
+		if n > etcdMaxTxnOps {
+			n = etcdMaxTxnOps
+		}
+
+		var compare []etcdCompare
+		var ops []etcdOp
+		for _, instance := range instances[:n] {
+			key := db.key("instances", instance.ID)
+			b, err := json.Marshal(newEtcdInstance(instance))
+			if err != nil {
+				return errors.Wrapf(err, "Error marshalling %s", key)
+			}
+			compare = append(compare, keyMissing(key))
+			ops = append(ops, putOp(key, b))
+		}
+
+		ok, err := db.txn(compare, ops)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("Instance already exists")
+		}
+
+		instances = instances[n:]
+	}
+
+	return nil
+}
+
+func (db *etcdDB) deleteInstance(instanceID string) error {
+	_, err := db.deleteRange(db.key("instances", instanceID), false)
+	return err
+}
+
+func (db *etcdDB) updateInstance(instance *types.Instance) error {
+	var i etcdInstance
+	return db.update(db.key("instances", instance.ID), &i, func() {
+		i.setUpdatable(instance)
+	})
+}
+
+// setUpdatable copies the fields of instance which updateInstance stores.
+func (i *etcdInstance) setUpdatable(instance *types.Instance) {
+	i.TenantID = instance.TenantID
+	i.MACAddress = instance.MACAddress
+	i.Subnet = instance.Subnet
+	i.IPAddress = instance.IPAddress
+	i.Name = instance.Name
+	i.TraceLabel = instance.TraceLabel
+	i.Protected = instance.Protected
+	i.Warm = instance.Warm
+	i.ExtraVCPUs = instance.ExtraVCPUs
+	i.ExtraMemMB = instance.ExtraMemMB
+}
+
+// Node statistics are only needed by the datastore cache, no history is
+// kept.
+func (db *etcdDB) addNodeStat(stat payloads.Stat) error {
+	return nil
+}
+
+func (db *etcdDB) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+	for _, stat := range stats {
+		var i etcdInstance
+		err := db.update(db.key("instances", stat.InstanceUUID), &i, func() {
+			i.State = stat.State
+			i.NodeID = nodeID
+			i.SSHIP = stat.SSHIP
+			i.SSHPort = stat.SSHPort
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *etcdDB) addFrameStat(stat payloads.FrameTrace) error {
+	return db.putJSON(db.key("frames", uuid.Generate().String()), stat)
+}
+
+func (db *etcdDB) getFrameStats() ([]payloads.FrameTrace, error) {
+	kvs, err := db.list(db.key("frames", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]payloads.FrameTrace, 0, len(kvs))
+	for _, kv := range kvs {
+		var f payloads.FrameTrace
+		if err := json.Unmarshal(kv.Value, &f); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling frame trace")
+		}
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
+
+func (db *etcdDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
+	frames, err := db.getFrameStats()
+	if err != nil {
+		return nil, err
+	}
+
+	return batchFrameSummary(frames), nil
+}
+
+func (db *etcdDB) getBatchFrameStatistics(label string) ([]types.BatchFrameStat, error) {
+	frames, err := db.getFrameStats()
+	if err != nil {
+		return nil, err
+	}
+
+	return batchFrameStatistics(frames, label), nil
+}
+
+func (db *etcdDB) getWorkloadStorage(ID string) ([]types.StorageResource, error) {
+	res := []types.StorageResource{}
+
+	kv, err := db.get(db.key("workloads", ID))
+	if err != nil || kv == nil {
+		return res, err
+	}
+
+	var wl etcdWorkload
+	if err := json.Unmarshal(kv.Value, &wl); err != nil {
+		return nil, errors.Wrapf(err, "Error unmarshalling workload %s", ID)
+	}
+
+	return append(res, wl.Storage...), nil
+}
+
+func (db *etcdDB) getAllBlockData() (map[string]types.Volume, error) {
+	kvs, err := db.list(db.key("volumes", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]types.Volume)
+	for _, kv := range kvs {
+		var v types.Volume
+		if err := json.Unmarshal(kv.Value, &v); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling volume")
+		}
+		devices[v.ID] = v
+	}
+
+	return devices, nil
+}
+
+func (db *etcdDB) addBlockData(data types.Volume) error {
+	ok, err := db.create(db.key("volumes", data.ID), data)
+	if err == nil && !ok {
+		err = fmt.Errorf("Volume %s already exists", data.ID)
+	}
+	return err
+}
+
+// For now we only support updating the tenant, the state, the protection,
+// the name, the description, the metadata and the tags.
+func (db *etcdDB) updateBlockData(data types.Volume) error {
+	var d types.Volume
+	return db.update(db.key("volumes", data.ID), &d, func() {
+		setUpdatableVolume(&d, data)
+	})
+}
+
+func setUpdatableVolume(d *types.Volume, data types.Volume) {
+	d.TenantID = data.TenantID
+	d.State = data.State
+	d.Protected = data.Protected
+	d.Name = data.Name
+	d.Description = data.Description
+	d.Metadata = data.Metadata
+	d.Tags = data.Tags
+}
+
+func (db *etcdDB) deleteBlockData(ID string) error {
+	_, err := db.deleteRange(db.key("volumes", ID), false)
+	return err
+}
+
+func (db *etcdDB) getTenantDevices(tenantID string) (map[string]types.Volume, error) {
+	all, err := db.getAllBlockData()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]types.Volume)
+	for k, v := range all {
+		if v.TenantID == tenantID {
+			devices[k] = v
+		}
+	}
+
+	return devices, nil
+}
+
+func (db *etcdDB) addStorageAttachment(a types.StorageAttachment) error {
+	ok, err := db.create(db.key("attachments", a.ID), a)
+	if err == nil && !ok {
+		err = fmt.Errorf("Attachment %s already exists", a.ID)
+	}
+	return err
+}
+
+func (db *etcdDB) getAllStorageAttachments() (map[string]types.StorageAttachment, error) {
+	kvs, err := db.list(db.key("attachments", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make(map[string]types.StorageAttachment)
+	for _, kv := range kvs {
+		var a types.StorageAttachment
+		if err := json.Unmarshal(kv.Value, &a); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling attachment")
+		}
+		attachments[a.ID] = a
+	}
+
+	return attachments, nil
+}
+
+func (db *etcdDB) deleteStorageAttachment(ID string) error {
+	_, err := db.deleteRange(db.key("attachments", ID), false)
+	return err
+}
+
+// this is here just for readability.
+func (db *etcdDB) addPool(pool types.Pool) error {
+	return db.updatePool(pool)
+}
+
+func (db *etcdDB) updatePool(pool types.Pool) error {
+	return db.putJSON(db.key("pools", pool.ID), storedPool(pool))
+}
+
+func (db *etcdDB) deletePool(ID string) error {
+	_, err := db.deleteRange(db.key("pools", ID), false)
+	return err
+}
+
+func (db *etcdDB) getPools() (map[string]types.Pool, error) {
+	kvs, err := db.list(db.key("pools", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make(map[string]types.Pool)
+	for _, kv := range kvs {
+		var p types.Pool
+		if err := json.Unmarshal(kv.Value, &p); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling pool")
+		}
+		pools[p.ID] = p
+	}
+
+	return pools, nil
+}
+
+func (db *etcdDB) getAllPools() map[string]types.Pool {
+	pools, err := db.getPools()
+	if err != nil {
+		glog.Warningf("Error getting pools: %v", err)
+		return make(map[string]types.Pool)
+	}
+
+	return pools
+}
+
+// Mapped addresses are indexed by external address, so that an address
+// cannot be mapped twice.
+func (db *etcdDB) addMappedIP(m types.MappedIP) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrapf(err, "Error marshalling mapped IP %s", m.ID)
+	}
+
+	addressKey := db.key("external-ips", m.ExternalIP)
+	ops := []etcdOp{
+		putOp(db.key("mapped-ips", m.ID), b),
+		putOp(addressKey, []byte(m.ID)),
+	}
+
+	ok, err := db.txn([]etcdCompare{keyMissing(addressKey)}, ops)
+	if err == nil && !ok {
+		err = fmt.Errorf("Address %s already mapped", m.ExternalIP)
+	}
+	return err
+}
+
+func (db *etcdDB) updateMappedIP(m types.MappedIP) error {
+	var mapped types.MappedIP
+	return db.update(db.key("mapped-ips", m.ID), &mapped, func() {
+		mapped.NodeID = m.NodeID
+	})
+}
+
+func (db *etcdDB) deleteMappedIP(ID string) error {
+	key := db.key("mapped-ips", ID)
+
+	kv, err := db.get(key)
+	if err != nil || kv == nil {
+		return err
+	}
+
+	var m types.MappedIP
+	if err := json.Unmarshal(kv.Value, &m); err != nil {
+		return errors.Wrapf(err, "Error unmarshalling mapped IP %s", ID)
+	}
+
+	ops := []etcdOp{
+		deleteOp(key, false),
+		deleteOp(db.key("external-ips", m.ExternalIP), false),
+	}
+
+	_, err = db.txn(nil, ops)
+	return err
+}
+
+// getMappedIPs returns the mappings of existing instances and pools,
+// indexed by external address, like the SQL backend join does.
+func (db *etcdDB) getMappedIPs() map[string]types.MappedIP {
+	IPs := make(map[string]types.MappedIP)
+
+	kvs, err := db.list(db.key("mapped-ips", ""))
+	if err != nil {
+		glog.Warningf("Error getting mapped IPs: %v", err)
+		return IPs
+	}
+
+	instances, err := db.getInstances()
+	if err != nil {
+		glog.Warningf("Error getting mapped IPs: %v", err)
+		return IPs
+	}
+
+	byID := make(map[string]*types.Instance)
+	for _, i := range instances {
+		byID[i.ID] = i
+	}
+
+	pools := db.getAllPools()
+
+	for _, kv := range kvs {
+		var m types.MappedIP
+		if err := json.Unmarshal(kv.Value, &m); err != nil {
+			glog.Warningf("Error unmarshalling mapped IP: %v", err)
+			continue
+		}
+
+		i, ok := byID[m.InstanceID]
+		if !ok {
+			continue
+		}
+
+		pool, ok := pools[m.PoolID]
+		if !ok {
+			continue
+		}
+
+		m.InternalIP = i.IPAddress
+		m.TenantID = i.TenantID
+		m.PoolName = pool.Name
+
+		IPs[m.ExternalIP] = m
+	}
+
+	return IPs
+}
+
+func (db *etcdDB) updateQuotas(tenantID string, qds []types.QuotaDetails) error {
+	ops := make([]etcdOp, 0, len(qds))
+	for _, qd := range qds {
+		ops = append(ops, putOp(db.key("quotas", tenantID, qd.Name), []byte(strconv.Itoa(qd.Value))))
+	}
+
+	_, err := db.txn(nil, ops)
+	return err
+}
+
+func (db *etcdDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
+	prefix := db.key("quotas", tenantID, "")
+
+	kvs, err := db.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []types.QuotaDetails{}
+	for _, kv := range kvs {
+		value, err := strconv.Atoi(string(kv.Value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid quota %s", kv.Key)
+		}
+
+		name := strings.TrimPrefix(string(kv.Key), prefix)
+		results = append(results, types.QuotaDetails{Name: name, Value: value})
+	}
+
+	return results, nil
+}
+
+func (db *etcdDB) updateQuotaProfile(p types.QuotaProfile) error {
+	return db.putJSON(db.key("quota-profiles", p.Name), p.Quotas)
+}
+
+func (db *etcdDB) deleteQuotaProfile(name string) error {
+	_, err := db.deleteRange(db.key("quota-profiles", name), false)
+	return err
+}
+
+func (db *etcdDB) getQuotaProfiles() ([]types.QuotaProfile, error) {
+	prefix := db.key("quota-profiles", "")
+
+	kvs, err := db.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []types.QuotaProfile{}
+	for _, kv := range kvs {
+		p := types.QuotaProfile{Name: strings.TrimPrefix(string(kv.Key), prefix)}
+		if err := json.Unmarshal(kv.Value, &p.Quotas); err != nil {
+			return nil, errors.Wrapf(err, "Error unmarshalling quota profile %s", p.Name)
+		}
+
+		// the SQL backend has no row, hence no profile, without quotas
+		if len(p.Quotas) == 0 {
+			continue
+		}
+
+		results = append(results, p)
+	}
+
+	return results, nil
+}
+
+func (db *etcdDB) getImages() ([]types.Image, error) {
+	kvs, err := db.list(db.key("images", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	images := []types.Image{}
+	for _, kv := range kvs {
+		var i types.Image
+		if err := json.Unmarshal(kv.Value, &i); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling image")
+		}
+		images = append(images, i)
+	}
+
+	return images, nil
+}
+
+func (db *etcdDB) updateImage(i types.Image) error {
+	return db.putJSON(db.key("images", i.ID), i)
+}
+
+func (db *etcdDB) deleteImage(ID string) error {
+	_, err := db.deleteRange(db.key("images", ID), false)
+	return err
+}
+
+func (db *etcdDB) updateClusterConfig(key string, value string) error {
+	return db.put(db.key("config", key), []byte(value))
+}
+
+func (db *etcdDB) deleteClusterConfig(key string) error {
+	_, err := db.deleteRange(db.key("config", key), false)
+	return err
+}
+
+func (db *etcdDB) getClusterConfig() (map[string]string, error) {
+	prefix := db.key("config", "")
+
+	kvs, err := db.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	config := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		config[strings.TrimPrefix(string(kv.Key), prefix)] = string(kv.Value)
+	}
+
+	return config, nil
+}
+
+func (db *etcdDB) updateVNI(v types.VNIAllocation) error {
+	return db.putJSON(db.key("vnis", fmt.Sprintf("%010d", v.VNI)), v)
+}
+
+func (db *etcdDB) getVNIs() ([]types.VNIAllocation, error) {
+	kvs, err := db.list(db.key("vnis", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	vnis := []types.VNIAllocation{}
+	for _, kv := range kvs {
+		var v types.VNIAllocation
+		if err := json.Unmarshal(kv.Value, &v); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling VNI")
+		}
+		vnis = append(vnis, v)
+	}
+
+	return vnis, nil
+}
+
+func (db *etcdDB) addShadowPlacement(p types.ShadowPlacement) error {
+	return db.putJSON(db.key("shadow-placements", p.InstanceID), p)
+}
+
+func (db *etcdDB) setShadowPlacementNode(instanceID string, nodeID string) error {
+	var p types.ShadowPlacement
+	return db.update(db.key("shadow-placements", instanceID), &p, func() {
+		p.ActualNodeID = nodeID
+	})
+}
+
+func (db *etcdDB) getShadowPlacements() ([]types.ShadowPlacement, error) {
+	kvs, err := db.list(db.key("shadow-placements", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	placements := []types.ShadowPlacement{}
+	for _, kv := range kvs {
+		var p types.ShadowPlacement
+		if err := json.Unmarshal(kv.Value, &p); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling shadow placement")
+		}
+		placements = append(placements, p)
+	}
+
+	return placements, nil
+}
+
+func (db *etcdDB) addDeletedInstance(d types.DeletedInstance) error {
+	return db.putJSON(db.key("deleted-instances", d.ID), d)
+}
+
+func (db *etcdDB) deleteDeletedInstance(ID string) error {
+	_, err := db.deleteRange(db.key("deleted-instances", ID), false)
+	return err
+}
+
+func (db *etcdDB) getDeletedInstances() ([]types.DeletedInstance, error) {
+	kvs, err := db.list(db.key("deleted-instances", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []types.DeletedInstance{}
+	for _, kv := range kvs {
+		var d types.DeletedInstance
+		if err := json.Unmarshal(kv.Value, &d); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling deleted instance")
+		}
+		instances = append(instances, d)
+	}
+
+	return instances, nil
+}
+
+// tenantUsageKey returns the key of a tenant usage sample. The keys sort
+// the samples of a tenant and resolution by time.
+func (db *etcdDB) tenantUsageKey(tenantID string, resolution types.UsageResolution, t time.Time) string {
+	return db.key("tenant-usage", tenantID, string(resolution), fmt.Sprintf("%020d", t.UnixNano()))
+}
+
+func (db *etcdDB) updateTenantUsage(s types.TenantUsageSample) error {
+	s.Timestamp = s.Timestamp.UTC()
+	return db.putJSON(db.tenantUsageKey(s.TenantID, s.Resolution, s.Timestamp), s)
+}
+
+func (db *etcdDB) getTenantUsageReport(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	return db.getTenantUsage(tenantID, resolution, start, end)
+}
+
+func (db *etcdDB) getTenantUsage(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	req := etcdRangeRequest{
+		Key:      []byte(db.tenantUsageKey(tenantID, resolution, start)),
+		RangeEnd: []byte(db.tenantUsageKey(tenantID, resolution, end)),
+	}
+
+	var resp etcdRangeResponse
+	if err := db.call("kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+
+	usage := []types.CiaoUsage{}
+	for _, kv := range resp.Kvs {
+		var s types.TenantUsageSample
+		if err := json.Unmarshal(kv.Value, &s); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling tenant usage")
+		}
+		usage = append(usage, s.CiaoUsage)
+	}
+
+	return usage, nil
+}
+
+func (db *etcdDB) deleteTenantUsage(tenantID string, resolution types.UsageResolution, before time.Time) error {
+	req := etcdRangeRequest{
+		Key:      []byte(db.key("tenant-usage", tenantID, string(resolution), "")),
+		RangeEnd: []byte(db.tenantUsageKey(tenantID, resolution, before)),
+	}
+
+	return db.call("kv/deleterange", req, nil)
+}
+
+func (db *etcdDB) getAllTenantUsage() ([]types.TenantUsageSample, error) {
+	kvs, err := db.list(db.key("tenant-usage", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []types.TenantUsageSample{}
+	for _, kv := range kvs {
+		var s types.TenantUsageSample
+		if err := json.Unmarshal(kv.Value, &s); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling tenant usage")
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, nil
+}
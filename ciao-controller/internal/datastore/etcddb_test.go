@@ -0,0 +1,360 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// fakeEtcd implements the subset of the JSON API of etcd v3 used by
+// etcdDB.
+type fakeEtcd struct {
+	lock     sync.Mutex
+	revision int64
+	kvs      map[string]etcdKV
+}
+
+func newFakeEtcd() *httptest.Server {
+	f := &fakeEtcd{kvs: make(map[string]etcdKV)}
+	return httptest.NewServer(f)
+}
+
+func (f *fakeEtcd) inRange(key string, r etcdRangeRequest) bool {
+	if len(r.RangeEnd) == 0 {
+		return key == string(r.Key)
+	}
+	return key >= string(r.Key) && key < string(r.RangeEnd)
+}
+
+func (f *fakeEtcd) rangeKVs(r etcdRangeRequest) []etcdKV {
+	var kvs []etcdKV
+	for k, kv := range f.kvs {
+		if f.inRange(k, r) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return string(kvs[i].Key) < string(kvs[j].Key) })
+	return kvs
+}
+
+func (f *fakeEtcd) put(r etcdPutRequest) {
+	f.revision++
+	kv, ok := f.kvs[string(r.Key)]
+	if !ok {
+		kv = etcdKV{Key: r.Key, CreateRevision: f.revision}
+	}
+	kv.Value = r.Value
+	kv.ModRevision = f.revision
+	f.kvs[string(r.Key)] = kv
+}
+
+func (f *fakeEtcd) deleteRange(r etcdRangeRequest) int64 {
+	kvs := f.rangeKVs(r)
+	for _, kv := range kvs {
+		delete(f.kvs, string(kv.Key))
+	}
+	return int64(len(kvs))
+}
+
+func (f *fakeEtcd) compare(c etcdCompare) bool {
+	kv := f.kvs[string(c.Key)]
+
+	value, target := kv.CreateRevision, c.CreateRevision
+	if c.Target == "MOD" {
+		value, target = kv.ModRevision, c.ModRevision
+	}
+
+	if c.Result == "GREATER" {
+		return value > target
+	}
+	return value == target
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var resp interface{}
+	var err error
+
+	switch strings.TrimPrefix(r.URL.Path, "/v3/") {
+	case "maintenance/status":
+		resp = struct{}{}
+	case "kv/range":
+		var req etcdRangeRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		resp = etcdRangeResponse{Kvs: f.rangeKVs(req)}
+	case "kv/put":
+		var req etcdPutRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		f.put(req)
+		resp = struct{}{}
+	case "kv/deleterange":
+		var req etcdRangeRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		resp = etcdDeleteRangeResponse{Deleted: f.deleteRange(req)}
+	case "kv/txn":
+		var req etcdTxnRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+
+		succeeded := true
+		for _, c := range req.Compare {
+			succeeded = succeeded && f.compare(c)
+		}
+
+		if succeeded {
+			for _, op := range req.Success {
+				if op.RequestPut != nil {
+					f.put(*op.RequestPut)
+				} else if op.RequestDeleteRange != nil {
+					f.deleteRange(*op.RequestDeleteRange)
+				}
+			}
+		}
+		resp = etcdTxnResponse{Succeeded: succeeded}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func etcdConfig(server *httptest.Server) Config {
+	return Config{
+		PersistentURI:     strings.Replace(server.URL, "http://", "etcd://", 1) + "/test",
+		InitWorkloadsPath: *workloadsPath,
+	}
+}
+
+func TestEtcdDBFixture(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+
+	eds := &Datastore{}
+	err := eds.Init(etcdConfig(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eds.Exit()
+
+	db, ok := eds.db.(*etcdDB)
+	if !ok {
+		t.Fatalf("Expected the etcd backend, got %T", eds.db)
+	}
+
+	f := NewFixture(eds)
+
+	tenant, err := f.AddTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := f.AddWorkload(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := f.AddInstances(tenant, wl, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volume, err := f.AddVolume(tenant.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := []payloads.InstanceStat{
+		{
+			InstanceUUID: instances[0].ID,
+			State:        payloads.ComputeStatusRunning,
+			SSHIP:        "10.0.0.1",
+			SSHPort:      33000,
+		},
+	}
+
+	err = db.addInstanceStats(stats, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err := db.getTenant(tenant.ID)
+	if err != nil || dbTenant == nil {
+		t.Fatalf("Tenant %s not found: %v", tenant.ID, err)
+	}
+
+	// fixture instances plus the CNCI
+	if len(dbTenant.instances) != 4 {
+		t.Fatalf("Expected 4 instances, got %d", len(dbTenant.instances))
+	}
+
+	i := dbTenant.instances[instances[0].ID]
+	if i.State != payloads.ComputeStatusRunning || i.NodeID != "node" ||
+		i.SSHIP != "10.0.0.1" || i.SSHPort != 33000 {
+		t.Fatalf("Instance statistics not stored: %+v", i)
+	}
+
+	if dbTenant.instances[instances[1].ID].State != payloads.ComputeStatusPending {
+		t.Fatal("Expected instance without statistics to be pending")
+	}
+
+	if dbTenant.devices[volume.ID].Size != 10 {
+		t.Fatal("Volume not found in tenant devices")
+	}
+
+	hosts := 0
+	for _, subnet := range dbTenant.network {
+		hosts += len(subnet)
+	}
+	if hosts != 3 {
+		t.Fatalf("Expected 3 tenant IPs claimed, got %d", hosts)
+	}
+
+	storage, err := db.getWorkloadStorage(wl.ID)
+	if err != nil || len(storage) != len(wl.Storage) {
+		t.Fatalf("Unexpected workload storage %+v: %v", storage, err)
+	}
+
+	err = eds.DeleteTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err = db.getTenant(tenant.ID)
+	if err != nil || dbTenant != nil {
+		t.Fatalf("Deleted tenant found: %v", err)
+	}
+}
+
+func TestEtcdDBConflicts(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+
+	db := &etcdDB{}
+	err := db.init(etcdConfig(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	tenantID := uuid.Generate().String()
+	err = db.claimTenantIPs(tenantID, []tenantIP{{subnet: 1, host: 2}})
+	if err == nil {
+		t.Fatal("Addresses claimed for a missing tenant")
+	}
+
+	config := types.TenantConfig{Name: "conflicts", SubnetBits: 24}
+	if err := db.addTenant(tenantID, config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.addTenant(tenantID, config); err == nil {
+		t.Fatal("Tenant added twice")
+	}
+
+	m := types.MappedIP{ID: uuid.Generate().String(), ExternalIP: "10.0.0.1"}
+	if err := db.addMappedIP(m); err != nil {
+		t.Fatal(err)
+	}
+
+	m2 := types.MappedIP{ID: uuid.Generate().String(), ExternalIP: m.ExternalIP}
+	if err := db.addMappedIP(m2); err == nil {
+		t.Fatal("Address mapped twice")
+	}
+
+	if err := db.deleteMappedIP(m.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.addMappedIP(m2); err != nil {
+		t.Fatalf("Released address not mapped: %v", err)
+	}
+
+	if err := db.deleteWorkload(uuid.Generate().String()); err == nil {
+		t.Fatal("Missing workload deleted")
+	}
+
+	// instance batches are split in several transactions
+	var instances []*types.Instance
+	for i := 0; i <= etcdMaxTxnOps; i++ {
+		instances = append(instances, &types.Instance{
+			ID:       uuid.Generate().String(),
+			TenantID: tenantID,
+		})
+	}
+
+	if err := db.addInstances(instances); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.getInstances()
+	if err != nil || len(stored) != len(instances) {
+		t.Fatalf("Expected %d instances, got %d: %v", len(instances), len(stored), err)
+	}
+
+	if err := db.addInstances(instances[:1]); err == nil {
+		t.Fatal("Instance added twice")
+	}
+}
+
+func TestMigrateToEtcd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	server := newFakeEtcd()
+	defer server.Close()
+
+	from := migrationConfig(dir, "from")
+	to := etcdConfig(server)
+
+	populateStore(t, from)
+
+	report, err := Migrate(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range MigrationTables {
+		if report[table] != 1 {
+			t.Errorf("Expected 1 record copied in %s, got %d", table, report[table])
+		}
+	}
+
+	_, err = Migrate(from, to)
+	if err == nil {
+		t.Fatal("Migration to a non empty datastore succeeded")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+)
+
+// delayedStore is a persistentStore delaying the writes of instances,
+// volumes, mapped addresses, statistics and events.
+type delayedStore struct {
+	// delay is accessed atomically, keep it first for alignment.
+	delay int64
+
+	persistentStore
+}
+
+func (db *delayedStore) wait() {
+	d := time.Duration(atomic.LoadInt64(&db.delay))
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// SetWriteDelay delays the writes to the persistent store by d. It has
+// no effect unless the datastore was initialised with InjectFaults.
+func (ds *Datastore) SetWriteDelay(d time.Duration) {
+	if ds.faults != nil {
+		atomic.StoreInt64(&ds.faults.delay, int64(d))
+	}
+}
+
+func (db *delayedStore) logEvent(entry types.LogEntry) error {
+	db.wait()
+	return db.persistentStore.logEvent(entry)
+}
+
+func (db *delayedStore) addInstance(instance *types.Instance) error {
+	db.wait()
+	return db.persistentStore.addInstance(instance)
+}
+
+func (db *delayedStore) addInstances(instances []*types.Instance) error {
+	db.wait()
+	return db.persistentStore.addInstances(instances)
+}
+
+func (db *delayedStore) deleteInstance(instanceID string) error {
+	db.wait()
+	return db.persistentStore.deleteInstance(instanceID)
+}
+
+func (db *delayedStore) updateInstance(instance *types.Instance) error {
+	db.wait()
+	return db.persistentStore.updateInstance(instance)
+}
+
+func (db *delayedStore) addNodeStat(stat payloads.Stat) error {
+	db.wait()
+	return db.persistentStore.addNodeStat(stat)
+}
+
+func (db *delayedStore) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+	db.wait()
+	return db.persistentStore.addInstanceStats(stats, nodeID)
+}
+
+func (db *delayedStore) addBlockData(data types.Volume) error {
+	db.wait()
+	return db.persistentStore.addBlockData(data)
+}
+
+func (db *delayedStore) updateBlockData(data types.Volume) error {
+	db.wait()
+	return db.persistentStore.updateBlockData(data)
+}
+
+func (db *delayedStore) deleteBlockData(ID string) error {
+	db.wait()
+	return db.persistentStore.deleteBlockData(ID)
+}
+
+func (db *delayedStore) addStorageAttachment(a types.StorageAttachment) error {
+	db.wait()
+	return db.persistentStore.addStorageAttachment(a)
+}
+
+func (db *delayedStore) deleteStorageAttachment(ID string) error {
+	db.wait()
+	return db.persistentStore.deleteStorageAttachment(ID)
+}
+
+func (db *delayedStore) beginTx() (persistentTx, error) {
+	db.wait()
+	return db.persistentStore.beginTx()
+}
+
+func (db *delayedStore) addMappedIP(m types.MappedIP) error {
+	db.wait()
+	return db.persistentStore.addMappedIP(m)
+}
+
+func (db *delayedStore) updateMappedIP(m types.MappedIP) error {
+	db.wait()
+	return db.persistentStore.updateMappedIP(m)
+}
+
+func (db *delayedStore) deleteMappedIP(ID string) error {
+	db.wait()
+	return db.persistentStore.deleteMappedIP(ID)
+}
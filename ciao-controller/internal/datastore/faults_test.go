@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetWriteDelay(t *testing.T) {
+	fds := &Datastore{}
+
+	err := fds.Init(Config{
+		DBBackend:         &MemoryDB{},
+		InitWorkloadsPath: *workloadsPath,
+		InjectFaults:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fds.Exit()
+
+	f := NewFixture(fds)
+
+	tenant, err := f.AddTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delay := 50 * time.Millisecond
+	fds.SetWriteDelay(delay)
+
+	start := time.Now()
+	_, err = f.AddVolume(tenant.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) < delay {
+		t.Fatal("Write not delayed")
+	}
+
+	fds.SetWriteDelay(0)
+
+	start = time.Now()
+	_, err = f.AddVolume(tenant.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) >= delay {
+		t.Fatal("Write delayed after the delay was cleared")
+	}
+}
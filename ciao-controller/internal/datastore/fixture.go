@@ -0,0 +1,202 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/pkg/errors"
+)
+
+const fixtureWorkloadConfig = `
+---
+#cloud-config
+users:
+  - name: demouser
+    gecos: CIAO Demo User
+    lock-passwd: false
+    sudo: ALL=(ALL) NOPASSWD:ALL
+...
+`
+
+// Fixture populates a datastore with tenants, instances and volumes
+// for tests. It is usually used along with a MemoryDB backend.
+type Fixture struct {
+	ds *Datastore
+}
+
+// NewFixture returns a Fixture adding its objects to ds.
+func NewFixture(ds *Datastore) *Fixture {
+	return &Fixture{ds: ds}
+}
+
+// AddTenant adds a tenant with a running fake CNCI and a private
+// workload.
+func (f *Fixture) AddTenant() (*types.Tenant, error) {
+	config := types.TenantConfig{
+		Name:       "",
+		SubnetBits: 24,
+	}
+
+	tenant, err := f.ds.AddTenant(uuid.Generate().String(), config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error adding tenant")
+	}
+
+	mac, err := utils.NewHardwareAddr()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error generating CNCI MAC address")
+	}
+
+	CNCI := types.Instance{
+		TenantID:   tenant.ID,
+		State:      payloads.Running,
+		ID:         uuid.Generate().String(),
+		CNCI:       true,
+		IPAddress:  "192.168.0.1",
+		MACAddress: mac.String(),
+	}
+
+	err = f.ds.AddInstance(&CNCI)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error adding CNCI")
+	}
+
+	_, err = f.AddWorkload(tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return tenant, nil
+}
+
+// AddWorkload adds a private workload with an ephemeral volume to the
+// tenant.
+func (f *Fixture) AddWorkload(tenantID string) (types.Workload, error) {
+	wl := types.Workload{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenantID,
+		Description: fmt.Sprintf("Private workload for %s", tenantID),
+		FWType:      string(payloads.EFI),
+		VMType:      payloads.QEMU,
+		ImageName:   "",
+		Config:      fixtureWorkloadConfig,
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs: 2,
+			MemMB: 512,
+		},
+		Storage: []types.StorageResource{
+			{
+				Ephemeral: true,
+				Size:      20,
+			},
+		},
+	}
+
+	err := f.ds.AddWorkload(wl)
+	if err != nil {
+		return types.Workload{}, errors.Wrap(err, "Error adding workload")
+	}
+
+	return wl, nil
+}
+
+// AddInstance adds a pending instance of the workload, with an address
+// allocated from the tenant subnet.
+func (f *Fixture) AddInstance(tenant *types.Tenant, workload types.Workload, name string) (*types.Instance, error) {
+	instance, err := f.newInstance(tenant, workload, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.ds.AddInstance(instance)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error adding instance")
+	}
+
+	return instance, nil
+}
+
+// AddInstances adds count instances of the workload, named after their
+// index, in a single batch.
+func (f *Fixture) AddInstances(tenant *types.Tenant, workload types.Workload, count int) ([]*types.Instance, error) {
+	var instances []*types.Instance
+	for i := 0; i < count; i++ {
+		instance, err := f.newInstance(tenant, workload, fmt.Sprintf("test-%d", i))
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+
+	err := f.ds.AddInstances(instances)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error adding instances")
+	}
+
+	return instances, nil
+}
+
+func (f *Fixture) newInstance(tenant *types.Tenant, workload types.Workload, name string) (*types.Instance, error) {
+	ip, err := f.ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error allocating instance IP")
+	}
+
+	mask := net.CIDRMask(tenant.SubnetBits, 32)
+	ipnet := net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+
+	return &types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: workload.ID,
+		State:      payloads.Pending,
+		ID:         uuid.Generate().String(),
+		CNCI:       false,
+		IPAddress:  ip.String(),
+		Subnet:     ipnet.String(),
+		MACAddress: utils.NewTenantHardwareAddr(ip).String(),
+		Name:       name,
+	}, nil
+}
+
+// AddVolume adds an available volume of size GB to the tenant.
+func (f *Fixture) AddVolume(tenantID string, size int) (types.Volume, error) {
+	volume := types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID:   uuid.Generate().String(),
+			Size: size,
+		},
+		State:      types.Available,
+		TenantID:   tenantID,
+		CreateTime: time.Now(),
+	}
+
+	err := f.ds.AddBlockDevice(volume)
+	if err != nil {
+		return types.Volume{}, errors.Wrap(err, "Error adding volume")
+	}
+
+	return volume, nil
+}
@@ -17,23 +17,112 @@ package datastore
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 )
 
-// MemoryDB is a memory backed persistentStore implementation for unit testing
+// memoryInstance holds the persistent fields of an instance along with
+// the ones reported by the latest instance statistics.
+type memoryInstance struct {
+	id         string
+	tenantID   string
+	workloadID string
+	macAddress string
+	vnicUUID   string
+	subnet     string
+	ipAddress  string
+	createTime time.Time
+	name       string
+	cnci       bool
+	traceLabel string
+	protected  bool
+	warm       bool
+	extraVCPUs int
+	extraMemMB int
+	tags       []string
+
+	state   string
+	nodeID  string
+	sshIP   string
+	sshPort int
+}
+
+func (i *memoryInstance) instance() *types.Instance {
+	state := i.state
+	if state == "" {
+		state = payloads.ComputeStatusPending
+	}
+
+	return &types.Instance{
+		ID:          i.id,
+		TenantID:    i.tenantID,
+		State:       state,
+		WorkloadID:  i.workloadID,
+		NodeID:      i.nodeID,
+		MACAddress:  i.macAddress,
+		VnicUUID:    i.vnicUUID,
+		Subnet:      i.subnet,
+		IPAddress:   i.ipAddress,
+		SSHIP:       i.sshIP,
+		SSHPort:     i.sshPort,
+		CNCI:        i.cnci,
+		CreateTime:  i.createTime,
+		Name:        i.name,
+		TraceLabel:  i.traceLabel,
+		Protected:   i.protected,
+		Warm:        i.warm,
+		ExtraVCPUs:  i.extraVCPUs,
+		ExtraMemMB:  i.extraMemMB,
+		Tags:        i.tags,
+		StateChange: sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+// MemoryDB is a memory backed persistentStore implementation. It keeps
+// nothing on disk, which makes it suitable for unit tests and for
+// running the controller in simulation mode. Nothing survives the
+// process and each call to init starts from an empty store.
 type MemoryDB struct {
-	tenants         map[string]*tenant
-	nodes           map[string]*node
-	instances       map[string]*types.Instance
-	tenantUsage     map[string][]types.CiaoUsage
-	blockDevices    map[string]types.Volume
-	attachments     map[string]types.StorageAttachment
-	instanceVolumes map[attachment]string
-	logEntries      []*types.LogEntry
+	lock sync.Mutex
+
+	tenants       map[string]types.TenantConfig
+	tenantNetwork map[string]map[uint32]map[uint32]bool
+	instances     map[string]*memoryInstance
+	workloads     map[string]types.Workload
+	blockDevices  map[string]types.Volume
+	attachments   map[string]types.StorageAttachment
+	pools         map[string]types.Pool
+	mappedIPs     map[string]types.MappedIP
+	quotas        map[string]map[string]int
+	quotaProfiles map[string][]types.QuotaDetails
+	config        map[string]string
+	images        map[string]types.Image
+	vnis          map[uint32]types.VNIAllocation
+	shadows       map[string]types.ShadowPlacement
+	deleted       map[string]types.DeletedInstance
+	usage         map[usageKey]types.CiaoUsage
+	logEntries    []memoryLogEntry
+	lastLogID     int
+	frameStats    []payloads.FrameTrace
+}
+
+// usageKey identifies a sample of the usage history of a tenant.
+type usageKey struct {
+	tenantID   string
+	resolution types.UsageResolution
+	timestamp  int64
+}
 
-	workloadsPath string
+// memoryLogEntry is an event of the log along with its sequence number,
+// used as the marker of pages of events.
+type memoryLogEntry struct {
+	id    int
+	entry types.LogEntry
 }
 
 func (db *MemoryDB) fillWorkloads() error {
@@ -47,15 +136,25 @@ func (db *MemoryDB) fillWorkloads() error {
 }
 
 func (db *MemoryDB) init(config Config) error {
-	db.tenants = make(map[string]*tenant)
-	db.nodes = make(map[string]*node)
-	db.instances = make(map[string]*types.Instance)
-	db.tenantUsage = make(map[string][]types.CiaoUsage)
+	db.tenants = make(map[string]types.TenantConfig)
+	db.tenantNetwork = make(map[string]map[uint32]map[uint32]bool)
+	db.instances = make(map[string]*memoryInstance)
+	db.workloads = make(map[string]types.Workload)
 	db.blockDevices = make(map[string]types.Volume)
 	db.attachments = make(map[string]types.StorageAttachment)
-	db.instanceVolumes = make(map[attachment]string)
+	db.pools = make(map[string]types.Pool)
+	db.mappedIPs = make(map[string]types.MappedIP)
+	db.quotas = make(map[string]map[string]int)
+	db.quotaProfiles = make(map[string][]types.QuotaDetails)
+	db.config = make(map[string]string)
+	db.images = make(map[string]types.Image)
+	db.vnis = make(map[uint32]types.VNIAllocation)
+	db.shadows = make(map[string]types.ShadowPlacement)
+	db.deleted = make(map[string]types.DeletedInstance)
+	db.usage = make(map[usageKey]types.CiaoUsage)
+	db.logEntries = nil
+	db.frameStats = nil
 
-	db.workloadsPath = config.InitWorkloadsPath
 	return db.fillWorkloads()
 }
 
@@ -64,206 +163,1099 @@ func (db *MemoryDB) disconnect() {
 }
 
 func (db *MemoryDB) logEvent(entry types.LogEntry) error {
-	db.logEntries = append(db.logEntries, &entry)
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+	db.lastLogID++
+	db.logEntries = append(db.logEntries, memoryLogEntry{id: db.lastLogID, entry: entry})
 
 	return nil
 }
 
 func (db *MemoryDB) clearLog() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
 	db.logEntries = nil
 	return nil
 }
 
 func (db *MemoryDB) getEventLog() ([]*types.LogEntry, error) {
-	return db.logEntries, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logEntries := make([]*types.LogEntry, 0, len(db.logEntries))
+	for i := range db.logEntries {
+		e := db.logEntries[i].entry
+		logEntries = append(logEntries, &e)
+	}
+
+	return logEntries, nil
+}
+
+func (db *MemoryDB) getEvents(filter types.EventFilter, marker string, limit int) ([]*types.LogEntry, string, error) {
+	after := 0
+	if marker != "" {
+		var err error
+		after, err = strconv.Atoi(marker)
+		if err != nil {
+			return nil, "", types.ErrBadRequest
+		}
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	logEntries := make([]*types.LogEntry, 0)
+	for i := range db.logEntries {
+		l := db.logEntries[i]
+		if l.id <= after || !filter.Match(&l.entry) {
+			continue
+		}
+
+		if limit > 0 && len(logEntries) == limit {
+			return logEntries, strconv.Itoa(after), nil
+		}
+
+		logEntries = append(logEntries, &l.entry)
+		after = l.id
+	}
+
+	return logEntries, "", nil
+}
+
+func (db *MemoryDB) pruneLog(before time.Time) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var kept []memoryLogEntry
+	for _, l := range db.logEntries {
+		if !l.entry.Timestamp.Before(before) {
+			kept = append(kept, l)
+		}
+	}
+
+	count := len(db.logEntries) - len(kept)
+	db.logEntries = kept
+
+	return count, nil
 }
 
 func (db *MemoryDB) addTenant(id string, config types.TenantConfig) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.tenants[id]; ok {
+		return fmt.Errorf("Tenant %s already exists", id)
+	}
+
+	db.tenants[id] = config
+	db.tenantNetwork[id] = make(map[uint32]map[uint32]bool)
+
+	return nil
+}
+
+// newTenant builds the tenant record the datastore caches. It must be
+// called with the lock held.
+func (db *MemoryDB) newTenant(id string) *tenant {
 	t := &tenant{
 		Tenant: types.Tenant{
-			ID: id,
-			TenantConfig: types.TenantConfig{
-				Name:       config.Name,
-				SubnetBits: config.SubnetBits,
-			},
+			ID:           id,
+			TenantConfig: db.tenants[id],
 		},
 		network:   make(map[uint32]map[uint32]bool),
 		instances: make(map[string]*types.Instance),
 		devices:   make(map[string]types.Volume),
 	}
-	db.tenants[id] = t
-	return nil
+
+	for subnet, hosts := range db.tenantNetwork[id] {
+		t.network[subnet] = make(map[uint32]bool)
+		for host := range hosts {
+			t.network[subnet][host] = true
+		}
+	}
+
+	for _, i := range db.instances {
+		if i.tenantID == id {
+			t.instances[i.id] = i.instance()
+		}
+	}
+
+	for _, d := range db.blockDevices {
+		if d.TenantID == id {
+			t.devices[d.ID] = d
+		}
+	}
+
+	return t
 }
 
 func (db *MemoryDB) getTenant(id string) (*tenant, error) {
-	tenant, ok := db.tenants[id]
-	if !ok {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.tenants[id]; !ok {
 		return nil, fmt.Errorf("Tenant %s not found", id)
 	}
-	return tenant, nil
+
+	return db.newTenant(id), nil
 }
 
 func (db *MemoryDB) getTenants() ([]*tenant, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
 	var tenants []*tenant
-	for _, t := range db.tenants {
-		tenants = append(tenants, t)
+	for id := range db.tenants {
+		tenants = append(tenants, db.newTenant(id))
 	}
 	return tenants, nil
 }
 
 func (db *MemoryDB) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.tenantNetwork[tenantID][subnetInt], rest)
 	return nil
 }
 
-func (db *MemoryDB) claimTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+func (db *MemoryDB) releaseTenantIPs(tenantID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.tenantNetwork[tenantID]; ok {
+		db.tenantNetwork[tenantID] = make(map[uint32]map[uint32]bool)
+	}
+	return nil
+}
+
+// claimIP must be called with the lock held.
+func (db *MemoryDB) claimIP(tenantID string, subnetInt uint32, rest uint32) error {
+	network, ok := db.tenantNetwork[tenantID]
+	if !ok {
+		return fmt.Errorf("Tenant %s not found", tenantID)
+	}
+
+	if network[subnetInt] == nil {
+		network[subnetInt] = make(map[uint32]bool)
+	}
+	network[subnetInt][rest] = true
+
 	return nil
 }
 
+func (db *MemoryDB) claimTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.claimIP(tenantID, subnetInt, rest)
+}
+
 func (db *MemoryDB) claimTenantIPs(tenantID string, IPs []tenantIP) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, ip := range IPs {
+		err := db.claimIP(tenantID, ip.subnet, ip.host)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (db *MemoryDB) getInstances() ([]*types.Instance, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
 	var instances []*types.Instance
-	for _, instance := range db.instances {
-		instances = append(instances, instance)
+	for _, i := range db.instances {
+		instances = append(instances, i.instance())
 	}
 	return instances, nil
 }
 
 func (db *MemoryDB) addInstance(instance *types.Instance) error {
+	return db.addInstances([]*types.Instance{instance})
+}
+
+func (db *MemoryDB) addInstances(instances []*types.Instance) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	added := make(map[string]bool)
+	for _, instance := range instances {
+		if _, ok := db.instances[instance.ID]; ok || added[instance.ID] {
+			return fmt.Errorf("Instance %s already exists", instance.ID)
+		}
+		added[instance.ID] = true
+	}
+
+	for _, instance := range instances {
+		db.instances[instance.ID] = newMemoryInstance(instance)
+	}
+
 	return nil
 }
 
+func newMemoryInstance(instance *types.Instance) *memoryInstance {
+	return &memoryInstance{
+		id:         instance.ID,
+		tenantID:   instance.TenantID,
+		workloadID: instance.WorkloadID,
+		macAddress: instance.MACAddress,
+		vnicUUID:   instance.VnicUUID,
+		subnet:     instance.Subnet,
+		ipAddress:  instance.IPAddress,
+		createTime: instance.CreateTime,
+		name:       instance.Name,
+		cnci:       instance.CNCI,
+		traceLabel: instance.TraceLabel,
+		protected:  instance.Protected,
+		warm:       instance.Warm,
+		extraVCPUs: instance.ExtraVCPUs,
+		extraMemMB: instance.ExtraMemMB,
+		tags:       instance.Tags,
+	}
+}
+
 func (db *MemoryDB) deleteInstance(instanceID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.instances, instanceID)
+	return nil
+}
+
+func (db *MemoryDB) updateInstance(instance *types.Instance) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.setInstance(instance)
+}
+
+// lock must be held by caller
+func (db *MemoryDB) setInstance(instance *types.Instance) error {
+	i, ok := db.instances[instance.ID]
+	if ok {
+		i.tenantID = instance.TenantID
+		i.macAddress = instance.MACAddress
+		i.subnet = instance.Subnet
+		i.ipAddress = instance.IPAddress
+		i.name = instance.Name
+		i.traceLabel = instance.TraceLabel
+		i.protected = instance.Protected
+		i.warm = instance.Warm
+		i.extraVCPUs = instance.ExtraVCPUs
+		i.extraMemMB = instance.ExtraMemMB
+	}
+
+	return nil
+}
+
+// memoryTx is a transaction on a MemoryDB.  Its writes are applied
+// together by commitTx, under the lock, once all their checks passed.
+type memoryTx struct {
+	db     *MemoryDB
+	checks []func() error
+	writes []func()
+}
+
+func (db *MemoryDB) beginTx() (persistentTx, error) {
+	return &memoryTx{db: db}, nil
+}
+
+func (tx *memoryTx) deleteInstance(instanceID string) error {
+	tx.writes = append(tx.writes, func() { delete(tx.db.instances, instanceID) })
 	return nil
 }
 
+func (tx *memoryTx) updateInstance(instance *types.Instance) error {
+	tx.writes = append(tx.writes, func() { _ = tx.db.setInstance(instance) })
+	return nil
+}
+
+func (tx *memoryTx) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	tx.writes = append(tx.writes, func() { delete(tx.db.tenantNetwork[tenantID][subnetInt], rest) })
+	return nil
+}
+
+func (tx *memoryTx) updateBlockData(data types.Volume) error {
+	tx.writes = append(tx.writes, func() { _ = tx.db.setBlockData(data) })
+	return nil
+}
+
+func (tx *memoryTx) addStorageAttachment(a types.StorageAttachment) error {
+	tx.checks = append(tx.checks, func() error {
+		if _, ok := tx.db.attachments[a.ID]; ok {
+			return fmt.Errorf("Attachment %s already exists", a.ID)
+		}
+		return nil
+	})
+	tx.writes = append(tx.writes, func() { tx.db.attachments[a.ID] = a })
+	return nil
+}
+
+func (tx *memoryTx) deleteStorageAttachment(ID string) error {
+	tx.writes = append(tx.writes, func() { delete(tx.db.attachments, ID) })
+	return nil
+}
+
+func (tx *memoryTx) addDeletedInstance(d types.DeletedInstance) error {
+	tx.writes = append(tx.writes, func() { tx.db.deleted[d.ID] = d })
+	return nil
+}
+
+func (tx *memoryTx) commitTx() error {
+	tx.db.lock.Lock()
+	defer tx.db.lock.Unlock()
+
+	for _, check := range tx.checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	for _, write := range tx.writes {
+		write()
+	}
+
+	return nil
+}
+
+func (tx *memoryTx) rollbackTx() error {
+	tx.checks = nil
+	tx.writes = nil
+	return nil
+}
+
+// Node statistics are only needed by the datastore cache, no history is
+// kept.
 func (db *MemoryDB) addNodeStat(stat payloads.Stat) error {
 	return nil
 }
 
 func (db *MemoryDB) addInstanceStats(stats []payloads.InstanceStat, nodeID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, stat := range stats {
+		i, ok := db.instances[stat.InstanceUUID]
+		if !ok {
+			continue
+		}
+
+		i.state = stat.State
+		i.nodeID = nodeID
+		i.sshIP = stat.SSHIP
+		i.sshPort = stat.SSHPort
+	}
+
 	return nil
 }
 
 func (db *MemoryDB) addFrameStat(stat payloads.FrameTrace) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.frameStats = append(db.frameStats, stat)
 	return nil
 }
 
 func (db *MemoryDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
-	return nil, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return batchFrameSummary(db.frameStats), nil
+}
+
+// batchFrameSummary counts the frames of each trace label, for the
+// backends which do not compute it with a query.
+func batchFrameSummary(frames []payloads.FrameTrace) []types.BatchFrameSummary {
+	stats := make([]types.BatchFrameSummary, 0)
+	index := make(map[string]int)
+
+	for _, f := range frames {
+		i, ok := index[f.Label]
+		if !ok {
+			i = len(stats)
+			index[f.Label] = i
+			stats = append(stats, types.BatchFrameSummary{BatchID: f.Label})
+		}
+		stats[i].NumInstances++
+	}
+
+	return stats
+}
+
+func elapsed(start string, end string) (float64, bool) {
+	s, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return 0, false
+	}
+
+	e, err := time.Parse(time.RFC3339Nano, end)
+	if err != nil {
+		return 0, false
+	}
+
+	return e.Sub(s).Seconds(), true
+}
+
+func meanVariance(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var mean, variance float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+
+	return mean, variance
 }
 
 func (db *MemoryDB) getBatchFrameStatistics(label string) ([]types.BatchFrameStat, error) {
-	return nil, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return batchFrameStatistics(db.frameStats, label), nil
+}
+
+// batchFrameStatistics computes the same statistics as the SQL backend:
+// the controller time runs from the frame start to its first
+// transmission, the launcher time from its last reception to the frame
+// end and the scheduler time between reception and transmission.
+func batchFrameStatistics(frames []payloads.FrameTrace, label string) []types.BatchFrameStat {
+	var stat types.BatchFrameStat
+	var totals, controller, launcher, scheduler []float64
+	var first, last time.Time
+
+	for _, f := range frames {
+		if f.Label != label {
+			continue
+		}
+
+		stat.NumInstances++
+
+		if t, ok := elapsed(f.StartTimestamp, f.EndTimestamp); ok {
+			totals = append(totals, t)
+		}
+
+		if s, err := time.Parse(time.RFC3339Nano, f.StartTimestamp); err == nil && (first.IsZero() || s.Before(first)) {
+			first = s
+		}
+
+		if e, err := time.Parse(time.RFC3339Nano, f.EndTimestamp); err == nil && e.After(last) {
+			last = e
+		}
+
+		for _, n := range f.Nodes {
+			var t float64
+			var ok bool
+
+			switch {
+			case n.RxTimestamp == "":
+				if t, ok = elapsed(f.StartTimestamp, n.TxTimestamp); ok {
+					controller = append(controller, t)
+				}
+			case n.TxTimestamp == "":
+				if t, ok = elapsed(n.RxTimestamp, f.EndTimestamp); ok {
+					launcher = append(launcher, t)
+				}
+			default:
+				if t, ok = elapsed(n.RxTimestamp, n.TxTimestamp); ok {
+					scheduler = append(scheduler, t)
+				}
+			}
+		}
+	}
+
+	if !first.IsZero() && !last.IsZero() {
+		stat.TotalElapsed = last.Sub(first).Seconds()
+	}
+
+	stat.AverageElapsed, _ = meanVariance(totals)
+	stat.AverageControllerElapsed, stat.VarianceController = meanVariance(controller)
+	stat.AverageLauncherElapsed, stat.VarianceLauncher = meanVariance(launcher)
+	stat.AverageSchedulerElapsed, stat.VarianceScheduler = meanVariance(scheduler)
+
+	return []types.BatchFrameStat{stat}
 }
 
 func (db *MemoryDB) getWorkloadStorage(ID string) ([]types.StorageResource, error) {
-	return []types.StorageResource{}, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	res := []types.StorageResource{}
+	if wl, ok := db.workloads[ID]; ok {
+		res = append(res, wl.Storage...)
+	}
+
+	return res, nil
 }
 
 func (db *MemoryDB) getAllBlockData() (map[string]types.Volume, error) {
-	return db.blockDevices, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	devices := make(map[string]types.Volume)
+	for k, v := range db.blockDevices {
+		devices[k] = v
+	}
+
+	return devices, nil
 }
 
 func (db *MemoryDB) addBlockData(data types.Volume) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.blockDevices[data.ID]; ok {
+		return fmt.Errorf("Volume %s already exists", data.ID)
+	}
+
+	db.blockDevices[data.ID] = data
 	return nil
 }
 
-func (db *MemoryDB) updateBlockData(types.Volume) error {
+// For now we only support updating the tenant, the state, the protection,
+// the name, the description, the metadata and the tags.
+func (db *MemoryDB) updateBlockData(data types.Volume) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.setBlockData(data)
+}
+
+// lock must be held by caller
+func (db *MemoryDB) setBlockData(data types.Volume) error {
+	d, ok := db.blockDevices[data.ID]
+	if ok {
+		d.TenantID = data.TenantID
+		d.State = data.State
+		d.Protected = data.Protected
+		d.Name = data.Name
+		d.Description = data.Description
+		d.Metadata = data.Metadata
+		d.Tags = data.Tags
+		db.blockDevices[data.ID] = d
+	}
+
 	return nil
 }
 
-func (db *MemoryDB) deleteBlockData(string) error {
+func (db *MemoryDB) deleteBlockData(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.blockDevices, ID)
 	return nil
 }
 
 func (db *MemoryDB) getTenantDevices(tenantID string) (map[string]types.Volume, error) {
-	return nil, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	devices := make(map[string]types.Volume)
+	for k, v := range db.blockDevices {
+		if v.TenantID == tenantID {
+			devices[k] = v
+		}
+	}
+
+	return devices, nil
 }
 
 func (db *MemoryDB) addStorageAttachment(a types.StorageAttachment) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.attachments[a.ID]; ok {
+		return fmt.Errorf("Attachment %s already exists", a.ID)
+	}
+
+	db.attachments[a.ID] = a
 	return nil
 }
 
 func (db *MemoryDB) getAllStorageAttachments() (map[string]types.StorageAttachment, error) {
-	return db.attachments, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	attachments := make(map[string]types.StorageAttachment)
+	for k, v := range db.attachments {
+		attachments[k] = v
+	}
+
+	return attachments, nil
 }
 
 func (db *MemoryDB) deleteStorageAttachment(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.attachments, ID)
 	return nil
 }
 
+// storedPool keeps what the SQL backend persists of a pool, the subnet
+// usage being recomputed by the datastore from the mapped addresses.
+func storedPool(pool types.Pool) types.Pool {
+	p := types.Pool{
+		ID:       pool.ID,
+		Name:     pool.Name,
+		Free:     pool.Free,
+		TotalIPs: pool.TotalIPs,
+	}
+
+	for _, sub := range pool.Subnets {
+		p.Subnets = append(p.Subnets, types.ExternalSubnet{ID: sub.ID, CIDR: sub.CIDR})
+	}
+
+	for _, IP := range pool.IPs {
+		p.IPs = append(p.IPs, types.ExternalIP{ID: IP.ID, Address: IP.Address})
+	}
+
+	return p
+}
+
+// this is here just for readability.
 func (db *MemoryDB) addPool(pool types.Pool) error {
-	return nil
+	return db.updatePool(pool)
 }
 
 func (db *MemoryDB) updatePool(pool types.Pool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.pools[pool.ID] = storedPool(pool)
 	return nil
 }
 
 func (db *MemoryDB) deletePool(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.pools, ID)
 	return nil
 }
 
 func (db *MemoryDB) getAllPools() map[string]types.Pool {
-	return make(map[string]types.Pool)
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	pools := make(map[string]types.Pool)
+	for k, v := range db.pools {
+		pools[k] = storedPool(v)
+	}
+
+	return pools
 }
 
 func (db *MemoryDB) addMappedIP(m types.MappedIP) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, mapped := range db.mappedIPs {
+		if mapped.ExternalIP == m.ExternalIP {
+			return fmt.Errorf("Address %s already mapped", m.ExternalIP)
+		}
+	}
+
+	db.mappedIPs[m.ID] = m
+	return nil
+}
+
+func (db *MemoryDB) updateMappedIP(m types.MappedIP) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	mapped, ok := db.mappedIPs[m.ID]
+	if ok {
+		mapped.NodeID = m.NodeID
+		db.mappedIPs[m.ID] = mapped
+	}
+
 	return nil
 }
 
 func (db *MemoryDB) deleteMappedIP(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.mappedIPs, ID)
 	return nil
 }
 
+// getMappedIPs returns the mappings of existing instances and pools,
+// indexed by external address, like the SQL backend join does.
 func (db *MemoryDB) getMappedIPs() map[string]types.MappedIP {
-	return make(map[string]types.MappedIP)
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	IPs := make(map[string]types.MappedIP)
+	for _, m := range db.mappedIPs {
+		i, ok := db.instances[m.InstanceID]
+		if !ok {
+			continue
+		}
+
+		pool, ok := db.pools[m.PoolID]
+		if !ok {
+			continue
+		}
+
+		m.InternalIP = i.ipAddress
+		m.TenantID = i.tenantID
+		m.PoolName = pool.Name
+
+		IPs[m.ExternalIP] = m
+	}
+
+	return IPs
 }
 
 func (db *MemoryDB) addWorkload(wl types.Workload) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.workloads[wl.ID]; ok {
+		return fmt.Errorf("Workload %s already exists", wl.ID)
+	}
+
+	wl.Storage = append([]types.StorageResource(nil), wl.Storage...)
+	db.workloads[wl.ID] = wl
 	return nil
 }
 
 func (db *MemoryDB) deleteWorkload(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.workloads[ID]; !ok {
+		return fmt.Errorf("Workload %s not found", ID)
+	}
+
+	delete(db.workloads, ID)
 	return nil
 }
 
 func (db *MemoryDB) getWorkloads() ([]types.Workload, error) {
-	return []types.Workload{}, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	workloads := []types.Workload{}
+	for _, wl := range db.workloads {
+		if wl.Visibility == types.Internal {
+			continue
+		}
+
+		wl.Storage = append([]types.StorageResource{}, wl.Storage...)
+		workloads = append(workloads, wl)
+	}
+
+	return workloads, nil
 }
 
 func (db *MemoryDB) updateQuotas(tenantID string, qds []types.QuotaDetails) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	quotas, ok := db.quotas[tenantID]
+	if !ok {
+		quotas = make(map[string]int)
+		db.quotas[tenantID] = quotas
+	}
+
+	for _, qd := range qds {
+		quotas[qd.Name] = qd.Value
+	}
+
 	return nil
 }
 
 func (db *MemoryDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
-	return []types.QuotaDetails{}, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	results := []types.QuotaDetails{}
+	for name, value := range db.quotas[tenantID] {
+		results = append(results, types.QuotaDetails{Name: name, Value: value})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	return results, nil
 }
 
-func (db *MemoryDB) updateInstance(instance *types.Instance) error {
+func (db *MemoryDB) updateQuotaProfile(p types.QuotaProfile) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.quotaProfiles[p.Name] = append([]types.QuotaDetails(nil), p.Quotas...)
+	return nil
+}
+
+func (db *MemoryDB) deleteQuotaProfile(name string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.quotaProfiles, name)
+	return nil
+}
+
+func (db *MemoryDB) getQuotaProfiles() ([]types.QuotaProfile, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	results := []types.QuotaProfile{}
+	for name, quotas := range db.quotaProfiles {
+		// the SQL backend has no row, hence no profile, without quotas
+		if len(quotas) == 0 {
+			continue
+		}
+
+		results = append(results, types.QuotaProfile{
+			Name:   name,
+			Quotas: append([]types.QuotaDetails(nil), quotas...),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	return results, nil
+}
+
+func (db *MemoryDB) updateClusterConfig(key string, value string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.config[key] = value
 	return nil
 }
 
+func (db *MemoryDB) deleteClusterConfig(key string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.config, key)
+	return nil
+}
+
+func (db *MemoryDB) getClusterConfig() (map[string]string, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	config := make(map[string]string, len(db.config))
+	for key, value := range db.config {
+		config[key] = value
+	}
+
+	return config, nil
+}
+
 func (db *MemoryDB) updateTenant(tenant *types.Tenant) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.tenants[tenant.ID]; ok {
+		db.tenants[tenant.ID] = tenant.TenantConfig
+	}
+
 	return nil
 }
 
 func (db *MemoryDB) deleteTenant(tenantID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.quotas, tenantID)
 	delete(db.tenants, tenantID)
+	delete(db.tenantNetwork, tenantID)
+	for k := range db.usage {
+		if k.tenantID == tenantID {
+			delete(db.usage, k)
+		}
+	}
 	return nil
 }
 
 func (db *MemoryDB) getImages() ([]types.Image, error) {
-	return []types.Image{}, nil
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	images := []types.Image{}
+	for _, i := range db.images {
+		images = append(images, i)
+	}
+
+	return images, nil
 }
 
 func (db *MemoryDB) updateImage(i types.Image) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.images[i.ID] = i
 	return nil
 }
 
 func (db *MemoryDB) deleteImage(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.images, ID)
+	return nil
+}
+
+func (db *MemoryDB) updateVNI(v types.VNIAllocation) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.vnis[v.VNI] = v
+	return nil
+}
+
+func (db *MemoryDB) getVNIs() ([]types.VNIAllocation, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	vnis := []types.VNIAllocation{}
+	for _, v := range db.vnis {
+		vnis = append(vnis, v)
+	}
+
+	return vnis, nil
+}
+
+func (db *MemoryDB) addShadowPlacement(p types.ShadowPlacement) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.shadows[p.InstanceID] = p
+	return nil
+}
+
+func (db *MemoryDB) setShadowPlacementNode(instanceID string, nodeID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	p, ok := db.shadows[instanceID]
+	if ok {
+		p.ActualNodeID = nodeID
+		db.shadows[instanceID] = p
+	}
 	return nil
 }
+
+func (db *MemoryDB) getShadowPlacements() ([]types.ShadowPlacement, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	placements := []types.ShadowPlacement{}
+	for _, p := range db.shadows {
+		placements = append(placements, p)
+	}
+
+	return placements, nil
+}
+
+func (db *MemoryDB) addDeletedInstance(d types.DeletedInstance) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.deleted[d.ID] = d
+	return nil
+}
+
+func (db *MemoryDB) deleteDeletedInstance(ID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.deleted, ID)
+	return nil
+}
+
+func (db *MemoryDB) getDeletedInstances() ([]types.DeletedInstance, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	instances := []types.DeletedInstance{}
+	for _, d := range db.deleted {
+		instances = append(instances, d)
+	}
+
+	return instances, nil
+}
+
+func (db *MemoryDB) updateTenantUsage(s types.TenantUsageSample) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	s.Timestamp = s.Timestamp.UTC()
+	db.usage[usageKey{s.TenantID, s.Resolution, s.Timestamp.UnixNano()}] = s.CiaoUsage
+	return nil
+}
+
+func (db *MemoryDB) getTenantUsageReport(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	return db.getTenantUsage(tenantID, resolution, start, end)
+}
+
+func (db *MemoryDB) getTenantUsage(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	usage := []types.CiaoUsage{}
+	for k, u := range db.usage {
+		if k.tenantID == tenantID && k.resolution == resolution &&
+			!u.Timestamp.Before(start) && u.Timestamp.Before(end) {
+			usage = append(usage, u)
+		}
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Timestamp.Before(usage[j].Timestamp) })
+
+	return usage, nil
+}
+
+func (db *MemoryDB) deleteTenantUsage(tenantID string, resolution types.UsageResolution, before time.Time) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for k, u := range db.usage {
+		if k.tenantID == tenantID && k.resolution == resolution && u.Timestamp.Before(before) {
+			delete(db.usage, k)
+		}
+	}
+
+	return nil
+}
+
+func (db *MemoryDB) getAllTenantUsage() ([]types.TenantUsageSample, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	samples := []types.TenantUsageSample{}
+	for k, u := range db.usage {
+		samples = append(samples, types.TenantUsageSample{
+			TenantID:   k.tenantID,
+			Resolution: k.resolution,
+			CiaoUsage:  u,
+		})
+	}
+
+	return samples, nil
+}
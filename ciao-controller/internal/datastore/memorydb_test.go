@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/payloads"
+)
+
+func TestMemoryDBFixture(t *testing.T) {
+	db := &MemoryDB{}
+	mds := &Datastore{}
+
+	err := mds.Init(Config{
+		DBBackend:         db,
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mds.Exit()
+
+	f := NewFixture(mds)
+
+	tenant, err := f.AddTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wl, err := f.AddWorkload(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := f.AddInstances(tenant, wl, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volume, err := f.AddVolume(tenant.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := []payloads.InstanceStat{
+		{
+			InstanceUUID: instances[0].ID,
+			State:        payloads.ComputeStatusRunning,
+			SSHIP:        "10.0.0.1",
+			SSHPort:      33000,
+		},
+	}
+
+	err = db.addInstanceStats(stats, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTenant, err := db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fixture instances plus the CNCI
+	if len(dbTenant.instances) != 4 {
+		t.Fatalf("Expected 4 instances, got %d", len(dbTenant.instances))
+	}
+
+	i := dbTenant.instances[instances[0].ID]
+	if i.State != payloads.ComputeStatusRunning || i.NodeID != "node" ||
+		i.SSHIP != "10.0.0.1" || i.SSHPort != 33000 {
+		t.Fatalf("Instance statistics not stored: %+v", i)
+	}
+
+	if dbTenant.instances[instances[1].ID].State != payloads.ComputeStatusPending {
+		t.Fatal("Expected instance without statistics to be pending")
+	}
+
+	if dbTenant.devices[volume.ID].Size != 10 {
+		t.Fatal("Volume not found in tenant devices")
+	}
+
+	hosts := 0
+	for _, subnet := range dbTenant.network {
+		hosts += len(subnet)
+	}
+	if hosts != 3 {
+		t.Fatalf("Expected 3 tenant IPs claimed, got %d", hosts)
+	}
+
+	// returned records must not alias the stored ones
+	dbTenant.instances[instances[1].ID].State = payloads.ComputeStatusStopped
+	dbTenant.Name = "changed"
+
+	dbTenant, err = db.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dbTenant.instances[instances[1].ID].State != payloads.ComputeStatusPending ||
+		dbTenant.Name != "" {
+		t.Fatal("Stored tenant modified through a returned copy")
+	}
+
+	err = mds.DeleteTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.getTenant(tenant.ID)
+	if err == nil {
+		t.Fatal("Expected error getting a deleted tenant")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+// timedRWMutex is a sync.RWMutex recording how long its users waited
+// to acquire it.
+type timedRWMutex struct {
+	// counters are accessed atomically, keep them first for alignment.
+	writes    uint64
+	reads     uint64
+	writeWait int64
+	readWait  int64
+	maxWait   int64
+
+	sync.RWMutex
+	name string
+}
+
+func newTimedRWMutex(name string) *timedRWMutex {
+	return &timedRWMutex{name: name}
+}
+
+func (m *timedRWMutex) acquired(count *uint64, wait *int64, start time.Time) {
+	d := int64(time.Since(start))
+
+	atomic.AddUint64(count, 1)
+	atomic.AddInt64(wait, d)
+
+	for {
+		max := atomic.LoadInt64(&m.maxWait)
+		if d <= max || atomic.CompareAndSwapInt64(&m.maxWait, max, d) {
+			return
+		}
+	}
+}
+
+// Lock locks m for writing.
+func (m *timedRWMutex) Lock() {
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.acquired(&m.writes, &m.writeWait, start)
+}
+
+// RLock locks m for reading.
+func (m *timedRWMutex) RLock() {
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.acquired(&m.reads, &m.readWait, start)
+}
+
+func (m *timedRWMutex) metrics() types.LockMetrics {
+	return types.LockMetrics{
+		Name:        m.name,
+		Writes:      atomic.LoadUint64(&m.writes),
+		Reads:       atomic.LoadUint64(&m.reads),
+		WriteWaitNS: atomic.LoadInt64(&m.writeWait),
+		ReadWaitNS:  atomic.LoadInt64(&m.readWait),
+		MaxWaitNS:   atomic.LoadInt64(&m.maxWait),
+	}
+}
+
+// cacheCounter counts the lookups of a datastore cache.
+type cacheCounter struct {
+	hits   uint64
+	misses uint64
+	name   string
+}
+
+func (c *cacheCounter) lookup(hit bool) {
+	if hit {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+func (c *cacheCounter) metrics() types.CacheMetrics {
+	return types.CacheMetrics{
+		Name:   c.name,
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// Metrics reports the contention of the datastore locks and the hit
+// rates of its caches since the datastore was initialised.
+func (ds *Datastore) Metrics() types.DatastoreMetrics {
+	locks := []*timedRWMutex{
+		ds.tenantsLock,
+		ds.instancesLock,
+		ds.nodesLock,
+		ds.bdLock,
+		ds.attachLock,
+		ds.poolsLock,
+		ds.imageLock,
+		ds.workloadsLock,
+	}
+
+	caches := []*cacheCounter{
+		ds.tenantCache,
+		ds.instanceCache,
+		ds.blockDeviceCache,
+	}
+
+	m := types.DatastoreMetrics{
+		Locks:  make([]types.LockMetrics, 0, len(locks)),
+		Caches: make([]types.CacheMetrics, 0, len(caches)),
+	}
+
+	for _, l := range locks {
+		m.Locks = append(m.Locks, l.metrics())
+	}
+
+	for _, c := range caches {
+		m.Caches = append(m.Caches, c.metrics())
+	}
+
+	return m
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func findCacheMetrics(m types.DatastoreMetrics, name string) types.CacheMetrics {
+	for _, c := range m.Caches {
+		if c.Name == name {
+			return c
+		}
+	}
+	return types.CacheMetrics{}
+}
+
+func findLockMetrics(m types.DatastoreMetrics, name string) types.LockMetrics {
+	for _, l := range m.Locks {
+		if l.Name == name {
+			return l
+		}
+	}
+	return types.LockMetrics{}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := findCacheMetrics(ds.Metrics(), "instances")
+
+	_, err = ds.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetInstance(uuid.Generate().String())
+	if err == nil {
+		t.Fatal("Expected error getting unknown instance")
+	}
+
+	after := findCacheMetrics(ds.Metrics(), "instances")
+
+	if after.Hits != before.Hits+1 || after.Misses != before.Misses+1 {
+		t.Fatalf("Unexpected instance cache metrics %+v, was %+v", after, before)
+	}
+}
+
+func TestLockMetrics(t *testing.T) {
+	before := findLockMetrics(ds.Metrics(), "block_devices")
+
+	hold := 20 * time.Millisecond
+
+	ds.bdLock.Lock()
+	done := make(chan struct{})
+	go func() {
+		ds.GetAllBlockDevices()
+		close(done)
+	}()
+	time.Sleep(hold)
+	ds.bdLock.Unlock()
+	<-done
+
+	after := findLockMetrics(ds.Metrics(), "block_devices")
+
+	if after.Writes != before.Writes+1 || after.Reads != before.Reads+1 {
+		t.Fatalf("Unexpected lock acquisitions %+v, was %+v", after, before)
+	}
+
+	if after.ReadWaitNS-before.ReadWaitNS < int64(hold/2) || after.MaxWaitNS < int64(hold/2) {
+		t.Fatalf("Contention not recorded: %+v", after)
+	}
+}
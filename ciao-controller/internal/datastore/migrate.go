@@ -0,0 +1,507 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/pkg/errors"
+)
+
+// MigrationTables lists the tables copied by Migrate, in the order in
+// which they are copied.
+var MigrationTables = []string{
+	"config",
+	"quota_profiles",
+	"tenants",
+	"quotas",
+	"workloads",
+	"images",
+	"instances",
+	"volumes",
+	"attachments",
+	"pools",
+	"mapped_ips",
+	"vnis",
+	"events",
+	"deleted_instances",
+	"tenant_usage",
+}
+
+// MigrationReport is the number of records copied by Migrate for each of
+// the MigrationTables.
+type MigrationReport map[string]int
+
+// instanceRecord holds the persistent fields of an instance.
+type instanceRecord struct {
+	ID         string
+	TenantID   string
+	State      string
+	WorkloadID string
+	NodeID     string
+	MACAddress string
+	VnicUUID   string
+	Subnet     string
+	IPAddress  string
+	SSHIP      string
+	SSHPort    int
+	CNCI       bool
+	Name       string
+	TraceLabel string
+}
+
+// snapshot holds the content of a persistent store, with every table
+// sorted so that snapshots of different backends can be compared.
+type snapshot struct {
+	config        map[string]string
+	quotaProfiles []types.QuotaProfile
+	tenants       []types.Tenant
+	networks      map[string]map[uint32]map[uint32]bool
+	quotas        map[string][]types.QuotaDetails
+	workloads     []types.Workload
+	images        []types.Image
+	instances     []instanceRecord
+	volumes       map[string]types.Volume
+	attachments   map[string]types.StorageAttachment
+	pools         map[string]types.Pool
+	mappedIPs     map[string]types.MappedIP
+	vnis          []types.VNIAllocation
+	events        []*types.LogEntry
+	deleted       []types.DeletedInstance
+	usage         []types.TenantUsageSample
+}
+
+func takeSnapshot(ps persistentStore) (*snapshot, error) {
+	var err error
+	s := &snapshot{
+		networks: make(map[string]map[uint32]map[uint32]bool),
+		quotas:   make(map[string][]types.QuotaDetails),
+	}
+
+	s.config, err = ps.getClusterConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting configuration")
+	}
+
+	s.quotaProfiles, err = ps.getQuotaProfiles()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting quota profiles")
+	}
+	sort.Slice(s.quotaProfiles, func(i, j int) bool {
+		return s.quotaProfiles[i].Name < s.quotaProfiles[j].Name
+	})
+
+	tenants, err := ps.getTenants()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting tenants")
+	}
+	for _, t := range tenants {
+		s.tenants = append(s.tenants, t.Tenant)
+		if len(t.network) > 0 {
+			s.networks[t.ID] = t.network
+		}
+
+		quotas, err := ps.getQuotas(t.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting quotas of tenant %s", t.ID)
+		}
+		if len(quotas) > 0 {
+			sort.Slice(quotas, func(i, j int) bool { return quotas[i].Name < quotas[j].Name })
+			s.quotas[t.ID] = quotas
+		}
+	}
+	sort.Slice(s.tenants, func(i, j int) bool { return s.tenants[i].ID < s.tenants[j].ID })
+
+	s.workloads, err = ps.getWorkloads()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting workloads")
+	}
+	sort.Slice(s.workloads, func(i, j int) bool { return s.workloads[i].ID < s.workloads[j].ID })
+
+	s.images, err = ps.getImages()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting images")
+	}
+	sort.Slice(s.images, func(i, j int) bool { return s.images[i].ID < s.images[j].ID })
+
+	instances, err := ps.getInstances()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting instances")
+	}
+	for _, i := range instances {
+		s.instances = append(s.instances, instanceRecord{
+			ID:         i.ID,
+			TenantID:   i.TenantID,
+			State:      i.State,
+			WorkloadID: i.WorkloadID,
+			NodeID:     i.NodeID,
+			MACAddress: i.MACAddress,
+			VnicUUID:   i.VnicUUID,
+			Subnet:     i.Subnet,
+			IPAddress:  i.IPAddress,
+			SSHIP:      i.SSHIP,
+			SSHPort:    i.SSHPort,
+			CNCI:       i.CNCI,
+			Name:       i.Name,
+			TraceLabel: i.TraceLabel,
+		})
+	}
+	sort.Slice(s.instances, func(i, j int) bool { return s.instances[i].ID < s.instances[j].ID })
+
+	s.volumes, err = ps.getAllBlockData()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting volumes")
+	}
+
+	s.attachments, err = ps.getAllStorageAttachments()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting attachments")
+	}
+
+	s.pools = ps.getAllPools()
+	s.mappedIPs = ps.getMappedIPs()
+
+	s.vnis, err = ps.getVNIs()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting VNIs")
+	}
+	sort.Slice(s.vnis, func(i, j int) bool { return s.vnis[i].VNI < s.vnis[j].VNI })
+
+	s.events, err = ps.getEventLog()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting events")
+	}
+
+	s.deleted, err = ps.getDeletedInstances()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting deleted instances")
+	}
+	sort.Slice(s.deleted, func(i, j int) bool { return s.deleted[i].ID < s.deleted[j].ID })
+
+	s.usage, err = ps.getAllTenantUsage()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting tenant usage")
+	}
+	sort.Slice(s.usage, func(i, j int) bool {
+		a, b := s.usage[i], s.usage[j]
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		if a.Resolution != b.Resolution {
+			return a.Resolution < b.Resolution
+		}
+		return a.Timestamp.Before(b.Timestamp)
+	})
+
+	return s, nil
+}
+
+// tables returns the content of each of the MigrationTables.
+func (s *snapshot) tables() map[string]interface{} {
+	return map[string]interface{}{
+		"config":            s.config,
+		"quota_profiles":    s.quotaProfiles,
+		"tenants":           []interface{}{s.tenants, s.networks},
+		"quotas":            s.quotas,
+		"workloads":         s.workloads,
+		"images":            s.images,
+		"instances":         s.instances,
+		"volumes":           s.volumes,
+		"attachments":       s.attachments,
+		"pools":             s.pools,
+		"mapped_ips":        s.mappedIPs,
+		"vnis":              s.vnis,
+		"events":            s.events,
+		"deleted_instances": s.deleted,
+		"tenant_usage":      s.usage,
+	}
+}
+
+func (s *snapshot) report() MigrationReport {
+	quotas := 0
+	for _, q := range s.quotas {
+		quotas += len(q)
+	}
+
+	return MigrationReport{
+		"config":            len(s.config),
+		"quota_profiles":    len(s.quotaProfiles),
+		"tenants":           len(s.tenants),
+		"quotas":            quotas,
+		"workloads":         len(s.workloads),
+		"images":            len(s.images),
+		"instances":         len(s.instances),
+		"volumes":           len(s.volumes),
+		"attachments":       len(s.attachments),
+		"pools":             len(s.pools),
+		"mapped_ips":        len(s.mappedIPs),
+		"vnis":              len(s.vnis),
+		"events":            len(s.events),
+		"deleted_instances": len(s.deleted),
+		"tenant_usage":      len(s.usage),
+	}
+}
+
+func (s *snapshot) empty() bool {
+	for _, n := range s.report() {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compare returns the tables whose content differs between two
+// snapshots.  Tables are compared through their JSON encoding, which
+// does not depend on how each backend represents times.
+func (s *snapshot) compare(o *snapshot) ([]string, error) {
+	var mismatches []string
+
+	tables, other := s.tables(), o.tables()
+	for _, name := range MigrationTables {
+		b1, err := json.Marshal(tables[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error marshalling %s", name)
+		}
+		b2, err := json.Marshal(other[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error marshalling %s", name)
+		}
+		if !bytes.Equal(b1, b2) && !(isEmptyJSON(b1) && isEmptyJSON(b2)) {
+			mismatches = append(mismatches, name)
+		}
+	}
+
+	return mismatches, nil
+}
+
+// isEmptyJSON returns true for the encodings of nil and empty slices and
+// maps, which the backends do not use consistently.
+func isEmptyJSON(b []byte) bool {
+	switch string(b) {
+	case "null", "[]", "{}":
+		return true
+	}
+	return false
+}
+
+func copySnapshot(s *snapshot, ps persistentStore) error {
+	for key, value := range s.config {
+		if err := ps.updateClusterConfig(key, value); err != nil {
+			return errors.Wrapf(err, "Error copying configuration setting %s", key)
+		}
+	}
+
+	for _, p := range s.quotaProfiles {
+		if err := ps.updateQuotaProfile(p); err != nil {
+			return errors.Wrapf(err, "Error copying quota profile %s", p.Name)
+		}
+	}
+
+	for _, t := range s.tenants {
+		if err := ps.addTenant(t.ID, t.TenantConfig); err != nil {
+			return errors.Wrapf(err, "Error copying tenant %s", t.ID)
+		}
+
+		var IPs []tenantIP
+		for subnet, hosts := range s.networks[t.ID] {
+			for host := range hosts {
+				IPs = append(IPs, tenantIP{subnet: subnet, host: host})
+			}
+		}
+		if len(IPs) > 0 {
+			if err := ps.claimTenantIPs(t.ID, IPs); err != nil {
+				return errors.Wrapf(err, "Error copying network of tenant %s", t.ID)
+			}
+		}
+
+		if len(s.quotas[t.ID]) > 0 {
+			if err := ps.updateQuotas(t.ID, s.quotas[t.ID]); err != nil {
+				return errors.Wrapf(err, "Error copying quotas of tenant %s", t.ID)
+			}
+		}
+	}
+
+	for _, wl := range s.workloads {
+		if err := ps.addWorkload(wl); err != nil {
+			return errors.Wrapf(err, "Error copying workload %s", wl.ID)
+		}
+	}
+
+	for _, i := range s.images {
+		if err := ps.updateImage(i); err != nil {
+			return errors.Wrapf(err, "Error copying image %s", i.ID)
+		}
+	}
+
+	for _, r := range s.instances {
+		i := &types.Instance{
+			ID:         r.ID,
+			TenantID:   r.TenantID,
+			WorkloadID: r.WorkloadID,
+			MACAddress: r.MACAddress,
+			VnicUUID:   r.VnicUUID,
+			Subnet:     r.Subnet,
+			IPAddress:  r.IPAddress,
+			CNCI:       r.CNCI,
+			Name:       r.Name,
+			TraceLabel: r.TraceLabel,
+		}
+		if err := ps.addInstance(i); err != nil {
+			return errors.Wrapf(err, "Error copying instance %s", r.ID)
+		}
+
+		// The state and location of instances are those reported by
+		// the last stats of their node.
+		if r.NodeID == "" || r.NodeID == "Not Assigned" {
+			continue
+		}
+
+		stat := payloads.InstanceStat{
+			InstanceUUID:  r.ID,
+			State:         r.State,
+			SSHIP:         r.SSHIP,
+			SSHPort:       r.SSHPort,
+			MemoryUsageMB: -1,
+			DiskUsageMB:   -1,
+			CPUUsage:      -1,
+		}
+		err := ps.addInstanceStats([]payloads.InstanceStat{stat}, r.NodeID)
+		if err != nil {
+			return errors.Wrapf(err, "Error copying state of instance %s", r.ID)
+		}
+	}
+
+	for _, v := range s.volumes {
+		if err := ps.addBlockData(v); err != nil {
+			return errors.Wrapf(err, "Error copying volume %s", v.ID)
+		}
+	}
+
+	for _, a := range s.attachments {
+		if err := ps.addStorageAttachment(a); err != nil {
+			return errors.Wrapf(err, "Error copying attachment %s", a.ID)
+		}
+	}
+
+	for _, p := range s.pools {
+		if err := ps.addPool(p); err != nil {
+			return errors.Wrapf(err, "Error copying pool %s", p.ID)
+		}
+	}
+
+	for _, m := range s.mappedIPs {
+		if err := ps.addMappedIP(m); err != nil {
+			return errors.Wrapf(err, "Error copying mapped IP %s", m.ID)
+		}
+	}
+
+	for _, v := range s.vnis {
+		if err := ps.updateVNI(v); err != nil {
+			return errors.Wrapf(err, "Error copying VNI %d", v.VNI)
+		}
+	}
+
+	for _, e := range s.events {
+		if err := ps.logEvent(*e); err != nil {
+			return errors.Wrap(err, "Error copying event")
+		}
+	}
+
+	for _, d := range s.deleted {
+		if err := ps.addDeletedInstance(d); err != nil {
+			return errors.Wrapf(err, "Error copying deleted instance %s", d.ID)
+		}
+	}
+
+	for _, u := range s.usage {
+		if err := ps.updateTenantUsage(u); err != nil {
+			return errors.Wrapf(err, "Error copying usage of tenant %s", u.TenantID)
+		}
+	}
+
+	return nil
+}
+
+func openStore(config Config) (persistentStore, error) {
+	ps := newPersistentStore(config)
+
+	err := ps.init(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening datastore %s", config.PersistentURI)
+	}
+
+	return ps, nil
+}
+
+// Migrate copies the content of the persistent store described by from
+// into the empty store described by to, which may use a different
+// backend.  Once copied, the content of both stores is compared and an
+// error is returned if they differ.  Statistics and traces are not
+// copied.  The controller must not be running while its store is
+// migrated.
+func Migrate(from Config, to Config) (MigrationReport, error) {
+	src, err := openStore(from)
+	if err != nil {
+		return nil, err
+	}
+	defer src.disconnect()
+
+	dst, err := openStore(to)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.disconnect()
+
+	s, err := takeSnapshot(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading source datastore")
+	}
+
+	existing, err := takeSnapshot(dst)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading destination datastore")
+	}
+
+	if !existing.empty() {
+		return nil, fmt.Errorf("Destination datastore %s is not empty", to.PersistentURI)
+	}
+
+	err = copySnapshot(s, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	copied, err := takeSnapshot(dst)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading back destination datastore")
+	}
+
+	mismatches, err := s.compare(copied)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mismatches) > 0 {
+		return copied.report(), fmt.Errorf("Verification failed, tables differ: %v", mismatches)
+	}
+
+	return s.report(), nil
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func migrationConfig(dir string, name string) Config {
+	return Config{
+		PersistentURI:     "file:" + path.Join(dir, name+".db"),
+		InitWorkloadsPath: path.Join(dir, name+"_workloads"),
+	}
+}
+
+func populateStore(t *testing.T, config Config) {
+	ps, err := openStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.disconnect()
+
+	if err := ps.updateClusterConfig(MaxInstanceSamples, "30"); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := types.QuotaProfile{
+		Name:   "small",
+		Quotas: []types.QuotaDetails{{Name: "tenant-instances-quota", Value: 10}},
+	}
+	if err := ps.updateQuotaProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+
+	tenantID := uuid.Generate().String()
+	tenantConfig := types.TenantConfig{Name: "migrated", SubnetBits: 24, QuotaProfile: "small"}
+	if err := ps.addTenant(tenantID, tenantConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.claimTenantIPs(tenantID, []tenantIP{{subnet: 2, host: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	quotas := []types.QuotaDetails{{Name: "tenant-vcpu-quota", Value: 8}}
+	if err := ps.updateQuotas(tenantID, quotas); err != nil {
+		t.Fatal(err)
+	}
+
+	wl := types.Workload{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenantID,
+		Description: "migrated workload",
+		FWType:      string(payloads.EFI),
+		VMType:      payloads.QEMU,
+		Config:      "#cloud-config",
+		Visibility:  types.Private,
+		Requirements: payloads.WorkloadRequirements{
+			VCPUs:    2,
+			MemMB:    512,
+			CPUModel: "Skylake-Server",
+		},
+		Storage: []types.StorageResource{{Bootable: true, Size: 20, Ephemeral: true}},
+	}
+	if err := ps.addWorkload(wl); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenantID,
+		WorkloadID: wl.ID,
+		MACAddress: "02:00:c0:a8:02:03",
+		Subnet:     "172.16.2.0/24",
+		IPAddress:  "172.16.2.3",
+		Name:       "migrated",
+		CreateTime: time.Now(),
+	}
+	if err := ps.addInstance(instance); err != nil {
+		t.Fatal(err)
+	}
+
+	stat := payloads.InstanceStat{
+		InstanceUUID: instance.ID,
+		State:        payloads.Running,
+		SSHIP:        "192.168.0.1",
+		SSHPort:      33003,
+	}
+	if err := ps.addInstanceStats([]payloads.InstanceStat{stat}, "node"); err != nil {
+		t.Fatal(err)
+	}
+
+	volume := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String(), Size: 20},
+		State:       types.InUse,
+		TenantID:    tenantID,
+		CreateTime:  time.Now(),
+	}
+	if err := ps.addBlockData(volume); err != nil {
+		t.Fatal(err)
+	}
+
+	attachment := types.StorageAttachment{
+		ID:         uuid.Generate().String(),
+		InstanceID: instance.ID,
+		BlockID:    volume.ID,
+		Boot:       true,
+	}
+	if err := ps.addStorageAttachment(attachment); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := types.Pool{
+		ID:   uuid.Generate().String(),
+		Name: "public",
+		IPs:  []types.ExternalIP{{ID: uuid.Generate().String(), Address: "10.0.0.1"}},
+	}
+	if err := ps.addPool(pool); err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := types.MappedIP{
+		ID:         uuid.Generate().String(),
+		ExternalIP: "10.0.0.1",
+		InternalIP: instance.IPAddress,
+		InstanceID: instance.ID,
+		TenantID:   tenantID,
+		PoolID:     pool.ID,
+		PoolName:   pool.Name,
+	}
+	if err := ps.addMappedIP(mapping); err != nil {
+		t.Fatal(err)
+	}
+
+	image := types.Image{
+		ID:         uuid.Generate().String(),
+		State:      types.Active,
+		TenantID:   tenantID,
+		Name:       "migrated",
+		CreateTime: time.Now(),
+		Visibility: types.Private,
+	}
+	if err := ps.updateImage(image); err != nil {
+		t.Fatal(err)
+	}
+
+	vni := types.VNIAllocation{
+		VNI:      1,
+		TenantID: tenantID,
+		Subnet:   instance.Subnet,
+		State:    types.VNIAllocated,
+	}
+	if err := ps.updateVNI(vni); err != nil {
+		t.Fatal(err)
+	}
+
+	event := types.LogEntry{
+		Timestamp: time.Now().Add(-time.Hour),
+		TenantID:  tenantID,
+		Severity:  types.EventError,
+		Category:  types.EventCategoryInstance,
+		Message:   "migrated event",
+	}
+	if err := ps.logEvent(event); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := types.DeletedInstance{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenantID,
+		WorkloadID: wl.ID,
+		State:      payloads.Exited,
+		IPAddress:  "172.16.0.3",
+		Created:    time.Now().Add(-2 * time.Hour).UTC(),
+		Deleted:    time.Now().Add(-time.Hour).UTC(),
+	}
+	if err := ps.addDeletedInstance(deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := types.TenantUsageSample{
+		TenantID:   tenantID,
+		Resolution: types.UsageHourly,
+		CiaoUsage: types.CiaoUsage{
+			VCPU:           2,
+			Memory:         256,
+			Disk:           1024,
+			NetworkRxBytes: 4096,
+			NetworkTxBytes: 2048,
+			Timestamp:      time.Now().Add(-time.Hour).Truncate(time.Hour).UTC(),
+		},
+	}
+	if err := ps.updateTenantUsage(usage); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	from := migrationConfig(dir, "from")
+	to := migrationConfig(dir, "to")
+
+	populateStore(t, from)
+
+	report, err := Migrate(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range MigrationTables {
+		if report[table] != 1 {
+			t.Errorf("Expected 1 record copied in %s, got %d", table, report[table])
+		}
+	}
+
+	ps, err := openStore(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.disconnect()
+
+	instances, err := ps.getInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances) != 1 || instances[0].State != payloads.Running ||
+		instances[0].NodeID != "node" || instances[0].SSHPort != 33003 {
+		t.Fatalf("Instance state not migrated: %+v", instances)
+	}
+
+	events, err := ps.getEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || time.Since(events[0].Timestamp) < 59*time.Minute {
+		t.Fatalf("Event timestamp not migrated: %+v", events)
+	}
+}
+
+func TestMigrateNotEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	from := migrationConfig(dir, "from")
+	to := migrationConfig(dir, "to")
+
+	populateStore(t, from)
+	populateStore(t, to)
+
+	_, err = Migrate(from, to)
+	if err == nil {
+		t.Fatal("Migration to a non empty datastore succeeded")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+// indexName records the name of an instance of the tenant, replacing its
+// previous name if it was renamed.  Unnamed instances are not indexed.
+// It returns types.ErrInstanceNameInUse if the name is used by another
+// instance of the tenant.  tenantsLock must be held.
+func (t *tenant) indexName(instanceID string, name string) error {
+	if t.names == nil {
+		t.names = make(map[string]string)
+		t.instanceNames = make(map[string]string)
+	}
+
+	if owner, ok := t.names[name]; ok && name != "" && owner != instanceID {
+		return types.ErrInstanceNameInUse
+	}
+
+	t.unindexName(instanceID)
+
+	if name != "" {
+		t.names[name] = instanceID
+		t.instanceNames[instanceID] = name
+	}
+
+	return nil
+}
+
+// unindexName forgets the name of an instance of the tenant.  tenantsLock
+// must be held.
+func (t *tenant) unindexName(instanceID string) {
+	name, ok := t.instanceNames[instanceID]
+	if !ok {
+		return
+	}
+
+	delete(t.names, name)
+	delete(t.instanceNames, instanceID)
+}
+
+// indexNames records the names of instances about to be added to their
+// tenants, all or none of them.  tenantsLock must be held.
+func (ds *Datastore) indexNames(instances []*types.Instance) error {
+	for i, instance := range instances {
+		tenant := ds.tenants[instance.TenantID]
+		if tenant == nil {
+			continue
+		}
+
+		if err := tenant.indexName(instance.ID, instance.Name); err != nil {
+			ds.unindexNames(instances[:i])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexNames forgets the names of instances.  tenantsLock must be held.
+func (ds *Datastore) unindexNames(instances []*types.Instance) {
+	for _, instance := range instances {
+		if tenant := ds.tenants[instance.TenantID]; tenant != nil {
+			tenant.unindexName(instance.ID)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestInstanceNameIndex(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	instance, err := addInstance(tenant, wls[0], "indexed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"indexed", instance.ID} {
+		ID, err := ds.ResolveInstance(tenant.ID, name)
+		if err != nil || ID != instance.ID {
+			t.Fatalf("Expected %s to resolve to %s, got %s: %v", name, instance.ID, ID, err)
+		}
+	}
+
+	newInstance := func(name string) *types.Instance {
+		return &types.Instance{
+			TenantID: tenant.ID,
+			ID:       uuid.Generate().String(),
+			Name:     name,
+			State:    payloads.Pending,
+		}
+	}
+
+	if err := ds.AddInstance(newInstance("indexed")); err != types.ErrInstanceNameInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNameInUse, err)
+	}
+
+	otherWls, err := ds.GetWorkloads(other.ID)
+	if err != nil || len(otherWls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	if _, err := addInstance(other, otherWls[0], "indexed"); err != nil {
+		t.Fatalf("Name not allowed in another tenant: %v", err)
+	}
+
+	batch := []*types.Instance{newInstance("batch-0"), newInstance("batch-1"), newInstance("batch-0")}
+	if err := ds.AddInstances(batch); err != types.ErrInstanceNameInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNameInUse, err)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "batch-1"); ID != "" {
+		t.Fatalf("Name of rejected instance resolved to %s", ID)
+	}
+
+	warm := newInstance("")
+	warm.Warm = true
+	if err := ds.AddInstance(warm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.ClaimInstance(warm.ID, "indexed", ""); err != types.ErrInstanceNameInUse {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceNameInUse, err)
+	}
+
+	if !warm.Warm || warm.Name != "" {
+		t.Fatalf("Instance claimed with a name in use: %+v", warm)
+	}
+
+	if err := ds.ClaimInstance(warm.ID, "claimed", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "claimed"); ID != warm.ID {
+		t.Fatalf("Expected claimed to resolve to %s, got %s", warm.ID, ID)
+	}
+
+	if err := ds.DeleteInstance(instance.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "indexed"); ID != "" {
+		t.Fatalf("Name of deleted instance resolved to %s", ID)
+	}
+
+	if _, err := addInstance(tenant, wls[0], "indexed"); err != nil {
+		t.Fatalf("Name of deleted instance not released: %v", err)
+	}
+}
+
+// failingInstanceStore is a persistent store failing to update instances.
+type failingInstanceStore struct {
+	persistentStore
+}
+
+func (db failingInstanceStore) updateInstance(instance *types.Instance) error {
+	return errInjected
+}
+
+func TestUpdateInstanceNameRollback(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	instance, err := addInstance(tenant, wls[0], "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := &types.Instance{
+		TenantID: tenant.ID,
+		ID:       instance.ID,
+		Name:     "after",
+		State:    instance.State,
+	}
+
+	memDB := ds.db.(*MemoryDB)
+	ds.db = failingInstanceStore{memDB}
+
+	err = ds.UpdateInstance(update)
+
+	ds.db = memDB
+
+	if err != errInjected {
+		t.Fatalf("Expected %v, got %v", errInjected, err)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "after"); ID != "" {
+		t.Fatalf("Name of failed update resolved to %s", ID)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "before"); ID != instance.ID {
+		t.Fatalf("Expected before to resolve to %s, got %s", instance.ID, ID)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+func quotaProfile(name string, instances, vcpus, memMB, storageGiB, volumes, images, externalIPs int) types.QuotaProfile {
+	return types.QuotaProfile{
+		Name: name,
+		Quotas: []types.QuotaDetails{
+			{Name: "tenant-instances-quota", Value: instances},
+			{Name: "tenant-vcpu-quota", Value: vcpus},
+			{Name: "tenant-mem-quota", Value: memMB},
+			{Name: "tenant-storage-quota", Value: storageGiB},
+			{Name: "tenant-volumes-quota", Value: volumes},
+			{Name: "tenant-images-quota", Value: images},
+			{Name: "tenant-external-ips-quota", Value: externalIPs},
+		},
+	}
+}
+
+// defaultQuotaProfiles are stored in the datastore the first time it is
+// initialised without any quota profile.
+var defaultQuotaProfiles = []types.QuotaProfile{
+	quotaProfile("small", 10, 20, 20480, 100, 10, 5, 2),
+	quotaProfile("medium", 50, 100, 102400, 500, 50, 20, 10),
+	quotaProfile("large", 200, 400, 409600, 2000, 200, 50, 50),
+}
+
+func (ds *Datastore) initQuotaProfiles() error {
+	ds.quotaProfiles = make(map[string]types.QuotaProfile)
+	ds.quotaProfilesLock = &sync.RWMutex{}
+
+	profiles, err := ds.db.getQuotaProfiles()
+	if err != nil {
+		return errors.Wrap(err, "error getting quota profiles from database")
+	}
+
+	if len(profiles) == 0 {
+		for _, p := range defaultQuotaProfiles {
+			err = ds.db.updateQuotaProfile(p)
+			if err != nil {
+				return errors.Wrap(err, "error adding default quota profiles to database")
+			}
+		}
+		profiles = defaultQuotaProfiles
+	}
+
+	for _, p := range profiles {
+		ds.quotaProfiles[p.Name] = p
+	}
+
+	return nil
+}
+
+// GetQuotaProfiles returns all the quota profiles, sorted by name.
+func (ds *Datastore) GetQuotaProfiles() []types.QuotaProfile {
+	profiles := []types.QuotaProfile{}
+
+	ds.quotaProfilesLock.RLock()
+	for _, p := range ds.quotaProfiles {
+		profiles = append(profiles, p)
+	}
+	ds.quotaProfilesLock.RUnlock()
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles
+}
+
+// GetQuotaProfile returns the quota profile with the given name.
+func (ds *Datastore) GetQuotaProfile(name string) (types.QuotaProfile, error) {
+	ds.quotaProfilesLock.RLock()
+	p, ok := ds.quotaProfiles[name]
+	ds.quotaProfilesLock.RUnlock()
+
+	if !ok {
+		return types.QuotaProfile{}, types.ErrQuotaProfileNotFound
+	}
+
+	return p, nil
+}
+
+// UpdateQuotaProfile adds a quota profile or replaces the quotas of an
+// existing one. It is the responsibility of the caller to update the
+// quotas of the tenants assigned to the profile.
+func (ds *Datastore) UpdateQuotaProfile(p types.QuotaProfile) error {
+	ds.quotaProfilesLock.Lock()
+	defer ds.quotaProfilesLock.Unlock()
+
+	err := ds.db.updateQuotaProfile(p)
+	if err != nil {
+		return errors.Wrap(err, "error updating quota profile in database")
+	}
+
+	ds.quotaProfiles[p.Name] = p
+
+	return nil
+}
+
+// DeleteQuotaProfile removes a quota profile which is not assigned to any
+// tenant.
+func (ds *Datastore) DeleteQuotaProfile(name string) error {
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+
+	ds.quotaProfilesLock.Lock()
+	defer ds.quotaProfilesLock.Unlock()
+
+	if _, ok := ds.quotaProfiles[name]; !ok {
+		return types.ErrQuotaProfileNotFound
+	}
+
+	for _, t := range ds.tenants {
+		if t.QuotaProfile == name {
+			return types.ErrQuotaProfileInUse
+		}
+	}
+
+	err := ds.db.deleteQuotaProfile(name)
+	if err != nil {
+		return errors.Wrap(err, "error deleting quota profile from database")
+	}
+
+	delete(ds.quotaProfiles, name)
+
+	return nil
+}
+
+// GetQuotaProfileTenants returns the IDs of the tenants assigned to the
+// quota profile with the given name.
+func (ds *Datastore) GetQuotaProfileTenants(name string) []string {
+	var tenants []string
+
+	ds.tenantsLock.RLock()
+	for _, t := range ds.tenants {
+		if t.QuotaProfile == name {
+			tenants = append(tenants, t.ID)
+		}
+	}
+	ds.tenantsLock.RUnlock()
+
+	sort.Strings(tenants)
+
+	return tenants
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+)
+
+// nodeReports keeps the set of instances last reported by the agent
+// running on each node.
+type nodeReports struct {
+	sync.Mutex
+	instances map[string]map[string]struct{}
+}
+
+func newNodeReports() *nodeReports {
+	return &nodeReports{
+		instances: make(map[string]map[string]struct{}),
+	}
+}
+
+func (r *nodeReports) record(stat payloads.Stat) {
+	instances := make(map[string]struct{}, len(stat.Instances))
+	for _, i := range stat.Instances {
+		instances[i.InstanceUUID] = struct{}{}
+	}
+
+	r.Lock()
+	r.instances[stat.NodeUUID] = instances
+	r.Unlock()
+}
+
+func (r *nodeReports) remove(nodeID string) {
+	r.Lock()
+	delete(r.instances, nodeID)
+	r.Unlock()
+}
+
+// GetOrphanedInstances compares the instances the datastore places on
+// each node with the instances last reported by the node agent. It
+// returns the instances reported by an agent but unknown to the
+// datastore and the instances the datastore places on a node which no
+// longer reports them. Instances created less than grace ago are not
+// considered missing as their node may not have reported them yet.
+func (ds *Datastore) GetOrphanedInstances(grace time.Duration) []types.OrphanedInstance {
+	orphans := []types.OrphanedInstance{}
+
+	ds.reports.Lock()
+	defer ds.reports.Unlock()
+
+	ds.instancesLock.RLock()
+	defer ds.instancesLock.RUnlock()
+
+	for nodeID, reported := range ds.reports.instances {
+		for id := range reported {
+			if _, ok := ds.instances[id]; ok {
+				continue
+			}
+
+			orphans = append(orphans, types.OrphanedInstance{
+				InstanceID: id,
+				NodeID:     nodeID,
+				Kind:       types.OrphanUnknown,
+			})
+		}
+	}
+
+	for _, i := range ds.instances {
+		if i.NodeID == "" || time.Since(i.CreateTime) < grace {
+			continue
+		}
+
+		reported, ok := ds.reports.instances[i.NodeID]
+		if !ok {
+			continue
+		}
+
+		if _, ok := reported[i.ID]; ok {
+			continue
+		}
+
+		orphans = append(orphans, types.OrphanedInstance{
+			InstanceID: i.ID,
+			NodeID:     i.NodeID,
+			Kind:       types.OrphanMissing,
+		})
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].NodeID != orphans[j].NodeID {
+			return orphans[i].NodeID < orphans[j].NodeID
+		}
+		return orphans[i].InstanceID < orphans[j].InstanceID
+	})
+
+	return orphans
+}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -42,6 +43,76 @@ type sqliteDB struct {
 	tables        []persistentData
 	workloadsPath string
 	dbLock        *sync.Mutex
+
+	// replica, when configured, serves the reporting queries so
+	// that they do not contend with writers for dbLock.
+	replica     *sql.DB
+	replicaLock *sync.RWMutex
+}
+
+// sqlExecer runs the statements of a write, either directly on the
+// database or as part of a transaction.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqliteTx is a transaction on the database.  dbLock is held until the
+// transaction is committed or rolled back.
+type sqliteTx struct {
+	ds *sqliteDB
+	tx *sql.Tx
+}
+
+func (ds *sqliteDB) beginTx() (persistentTx, error) {
+	ds.dbLock.Lock()
+
+	tx, err := ds.db.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return nil, errors.Wrap(err, "Error starting database transaction")
+	}
+
+	return &sqliteTx{ds: ds, tx: tx}, nil
+}
+
+func (tx *sqliteTx) deleteInstance(instanceID string) error {
+	return tx.ds.execDeleteInstance(tx.tx, instanceID)
+}
+
+func (tx *sqliteTx) updateInstance(instance *types.Instance) error {
+	return tx.ds.execUpdateInstance(tx.tx, instance)
+}
+
+func (tx *sqliteTx) releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error {
+	return tx.ds.execReleaseTenantIP(tx.tx, tenantID, subnetInt, rest)
+}
+
+func (tx *sqliteTx) updateBlockData(data types.Volume) error {
+	return tx.ds.execUpdateBlockData(tx.tx, data)
+}
+
+func (tx *sqliteTx) addStorageAttachment(a types.StorageAttachment) error {
+	return tx.ds.execAddStorageAttachment(tx.tx, a)
+}
+
+func (tx *sqliteTx) deleteStorageAttachment(ID string) error {
+	return tx.ds.execDeleteStorageAttachment(tx.tx, ID)
+}
+
+func (tx *sqliteTx) addDeletedInstance(d types.DeletedInstance) error {
+	return tx.ds.execAddDeletedInstance(tx.tx, d)
+}
+
+func (tx *sqliteTx) commitTx() error {
+	defer tx.ds.dbLock.Unlock()
+
+	return errors.Wrap(tx.tx.Commit(), "Error committing database transaction")
+}
+
+func (tx *sqliteTx) rollbackTx() error {
+	defer tx.ds.dbLock.Unlock()
+
+	return errors.Wrap(tx.tx.Rollback(), "Error rolling back database transaction")
 }
 
 type persistentData interface {
@@ -81,7 +152,10 @@ func (d logData) Init() error {
 		tenant_id varchar(32),
 		node_id varchar(32),
 		type string,
+		category string,
 		message string,
+		instance_id varchar(32),
+		reason string,
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
 		);`
 
@@ -122,6 +196,12 @@ func (d instanceData) Init() error {
 		create_time DATETIME,
 		name string,
 		cnci int,
+		trace_label string,
+		protected int,
+		warm int,
+		extra_vcpus int,
+		extra_mem_mb int,
+		tags text,
 		foreign key(tenant_id) references tenants(id),
 		foreign key(workload_id) references workload_template(id),
 		unique(tenant_id, ip, mac_address)
@@ -146,6 +226,11 @@ func (d blockData) Init() error {
 		name string,
 		description string,
 		internal int,
+		protected int,
+		metadata string,
+		tags string,
+		pool string,
+		parent string,
 		foreign key(tenant_id) references tenants(id)
 		);`
 
@@ -206,7 +291,14 @@ func (d tenantData) Init() error {
 		id varchar(32) primary key,
 		name text,
 		subnet_bits int,
-		permissions text
+		permissions text,
+		quota_profile text,
+		notifications text,
+		max_instances_per_request int,
+		warm_pools text,
+		network_policy text,
+		instance_defaults text,
+		maintenance_windows text
 		);`
 
 	return d.ds.exec(d.db, cmd)
@@ -228,7 +320,11 @@ func (d workloadTemplateData) Init() error {
 		vm_type text,
 		image_name text,
 		visibility text,
-		requirements text
+		requirements text,
+		static_network int,
+		devices text,
+		health_check text,
+		lifetime text
 		);`
 
 	return d.ds.exec(d.db, cmd)
@@ -371,7 +467,8 @@ func (d mappedIPData) Init() error {
 			id varchar(32) primary key,
 			external_ip string,
 			instance_id varchar(32),
-			pool_id varchar(32)
+			pool_id varchar(32),
+			node_id varchar(32)
 		);`
 
 	return d.ds.exec(d.db, cmd)
@@ -393,6 +490,116 @@ func (d quotaData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+type quotaProfileData struct {
+	namedData
+}
+
+func (d quotaProfileData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS quota_profiles
+		(
+			profile string,
+			name string,
+			value int,
+			unique(profile, name)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type configData struct {
+	namedData
+}
+
+func (d configData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS config
+		(
+			name string primary key,
+			value string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type vniData struct {
+	namedData
+}
+
+func (d vniData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS subnet_vnis
+		(
+			vni int primary key,
+			tenant_id string,
+			subnet string,
+			state string,
+			release_time DATETIME
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type shadowPlacementData struct {
+	namedData
+}
+
+func (d shadowPlacementData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS shadow_placements
+		(
+			instance_id varchar(32) primary key,
+			tenant_id varchar(32),
+			policy string,
+			timestamp DATETIME,
+			shadow_node_id string,
+			actual_node_id string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type tenantUsageData struct {
+	namedData
+}
+
+func (d tenantUsageData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS tenant_usage
+		(
+			tenant_id varchar(32),
+			resolution string,
+			timestamp DATETIME,
+			vcpu int,
+			memory int,
+			disk int,
+			rx_bytes int,
+			tx_bytes int,
+			PRIMARY KEY(tenant_id, resolution, timestamp)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type deletedInstanceData struct {
+	namedData
+}
+
+func (d deletedInstanceData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS deleted_instances
+		(
+			id varchar(32) primary key,
+			tenant_id varchar(32),
+			name string,
+			workload_id varchar(32),
+			node_id varchar(32),
+			state string,
+			ip string,
+			mac_address string,
+			subnet string,
+			trace_label string,
+			create_time DATETIME,
+			delete_time DATETIME
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 type imageData struct {
 	namedData
 }
@@ -406,7 +613,12 @@ func (d imageData) Init() error {
 			name string,
 			createtime DATETIME,
 			size int,
-			visibility string
+			visibility string,
+			source_instance_id string,
+			source_volume_id string,
+			digest string,
+			signature blob,
+			signer string
 		);`
 
 	return d.ds.exec(d.db, cmd)
@@ -468,6 +680,17 @@ func (ds *sqliteDB) getTableDB(name string) *sql.DB {
 	return nil
 }
 
+// getReportingDB returns the connection used for the heavy read only
+// queries on the named table, along with the lock to hold while running
+// them.
+func (ds *sqliteDB) getReportingDB(name string) (*sql.DB, sync.Locker) {
+	if ds.replica != nil {
+		return ds.replica, ds.replicaLock.RLocker()
+	}
+
+	return ds.getTableDB(name), ds.dbLock
+}
+
 // init initializes the private data for the database object.
 // The datastore caches are also filled.
 func (ds *sqliteDB) init(config Config) error {
@@ -490,6 +713,14 @@ func (ds *sqliteDB) init(config Config) error {
 	}
 
 	ds.dbLock = &sync.Mutex{}
+	ds.replicaLock = &sync.RWMutex{}
+
+	if config.ReadReplicaURI != "" {
+		err = ds.connectReplica(config.ReadReplicaURI)
+		if err != nil {
+			return errors.Wrapf(err, "Error connecting to read replica (%s)", config.ReadReplicaURI)
+		}
+	}
 
 	ds.tables = []persistentData{
 		tenantData{namedData{ds: ds, name: "tenants", db: ds.db}},
@@ -509,7 +740,14 @@ func (ds *sqliteDB) init(config Config) error {
 		addressData{namedData{ds: ds, name: "address_pool", db: ds.db}},
 		mappedIPData{namedData{ds: ds, name: "mapped_ips", db: ds.db}},
 		quotaData{namedData{ds: ds, name: "quotas", db: ds.db}},
+		quotaProfileData{namedData{ds: ds, name: "quota_profiles", db: ds.db}},
 		imageData{namedData{ds: ds, name: "images", db: ds.db}},
+		configData{namedData{ds: ds, name: "config", db: ds.db}},
+		vniData{namedData{ds: ds, name: "subnet_vnis", db: ds.db}},
+		shadowPlacementData{namedData{ds: ds, name: "shadow_placements", db: ds.db}},
+		deletedInstanceData{namedData{ds: ds, name: "deleted_instances", db: ds.db}},
+		tenantUsageData{namedData{ds: ds, name: "tenant_usage", db: ds.db}},
+		schemaVersionData{namedData{ds: ds, name: "schema_version", db: ds.db}},
 	}
 
 	ds.workloadsPath = config.InitWorkloadsPath
@@ -524,7 +762,7 @@ func (ds *sqliteDB) init(config Config) error {
 		}
 	}
 
-	return nil
+	return ds.migrateSchema(sqliteMigrations)
 }
 
 var pSQLLiteConfig = []string{
@@ -557,8 +795,20 @@ func (ds *sqliteDB) sqliteConnect(name string, URI string, config []string) (*sq
 	return db, nil
 }
 
+// registerDriver registers the sqlite driver under the URI of a database,
+// unless the database has already been opened by this process.
+func registerDriver(URI string) {
+	for _, d := range sql.Drivers() {
+		if d == URI {
+			return
+		}
+	}
+
+	sql.Register(URI, &sqlite3.SQLiteDriver{})
+}
+
 func (ds *sqliteDB) Connect(persistentURI string) error {
-	sql.Register(persistentURI, &sqlite3.SQLiteDriver{})
+	registerDriver(persistentURI)
 
 	db, err := ds.sqliteConnect(persistentURI, persistentURI, pSQLLiteConfig)
 	if err != nil {
@@ -571,8 +821,34 @@ func (ds *sqliteDB) Connect(persistentURI string) error {
 	return err
 }
 
+var pSQLLiteReplicaConfig = []string{
+	"PRAGMA temp_store = MEMORY",
+	"PRAGMA busy_timeout = 1000",
+	"PRAGMA query_only = ON",
+}
+
+func (ds *sqliteDB) connectReplica(replicaURI string) error {
+	registerDriver(replicaURI)
+
+	db, err := ds.sqliteConnect(replicaURI, replicaURI, pSQLLiteReplicaConfig)
+	if err != nil {
+		return err
+	}
+
+	ds.replica = db
+
+	return nil
+}
+
 // Disconnect is used to close the connection to the sql database
 func (ds *sqliteDB) disconnect() {
+	if ds.replica != nil {
+		ds.replicaLock.Lock()
+		_ = ds.replica.Close()
+		ds.replica = nil
+		ds.replicaLock.Unlock()
+	}
+
 	_ = ds.db.Close()
 }
 
@@ -582,7 +858,13 @@ func (ds *sqliteDB) logEvent(event types.LogEntry) error {
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	_, err := db.Exec("INSERT INTO log (tenant_id, node_id, type, message) VALUES (?, ?, ?, ?)", event.TenantID, event.NodeID, event.EventType, event.Message)
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	_, err := db.Exec("INSERT INTO log (tenant_id, node_id, type, category, message, instance_id, reason, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		event.TenantID, event.NodeID, event.Severity, event.Category, event.Message, event.InstanceID, event.Reason, timestamp.UTC())
 
 	return err
 }
@@ -672,7 +954,27 @@ func (ds *sqliteDB) addTenant(ID string, config types.TenantConfig) error {
 		return errors.Wrap(err, "Error marshalling permissions")
 	}
 
-	err = ds.create("tenants", ID, config.Name, config.SubnetBits, string(perms))
+	notifications, err := json.Marshal(config.Notifications)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling notifications")
+	}
+
+	warmPools, err := json.Marshal(config.WarmPools)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling warm pools")
+	}
+
+	policy, err := json.Marshal(config.NetworkPolicy)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling network policy")
+	}
+
+	defaults, err := json.Marshal(config.InstanceDefaults)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling instance defaults")
+	}
+
+	err = ds.create("tenants", ID, config.Name, config.SubnetBits, string(perms), config.QuotaProfile, string(notifications), config.MaxInstancesPerRequest, string(warmPools), string(policy), string(defaults), string(config.MaintenanceWindows))
 
 	return err
 }
@@ -681,7 +983,14 @@ func (ds *sqliteDB) getTenant(ID string) (*tenant, error) {
 	query := `SELECT	tenants.id,
 				tenants.name,
 				tenants.subnet_bits,
-				tenants.permissions
+				tenants.permissions,
+				tenants.quota_profile,
+				tenants.notifications,
+				IFNULL(tenants.max_instances_per_request, 0),
+				tenants.warm_pools,
+				tenants.network_policy,
+				tenants.instance_defaults,
+				IFNULL(tenants.maintenance_windows, '')
 		  FROM tenants
 		  WHERE tenants.id = ?`
 
@@ -692,7 +1001,13 @@ func (ds *sqliteDB) getTenant(ID string) (*tenant, error) {
 	t := &tenant{}
 
 	var perms []byte
-	err := row.Scan(&t.ID, &t.Name, &t.SubnetBits, &perms)
+	var profile sql.NullString
+	var notifications []byte
+	var warmPools []byte
+	var policy []byte
+	var defaults []byte
+	var windows string
+	err := row.Scan(&t.ID, &t.Name, &t.SubnetBits, &perms, &profile, &notifications, &t.MaxInstancesPerRequest, &warmPools, &policy, &defaults, &windows)
 	if err != nil {
 		glog.Warning("unable to retrieve tenant from tenants")
 
@@ -708,6 +1023,33 @@ func (ds *sqliteDB) getTenant(ID string) (*tenant, error) {
 		return nil, errors.Wrap(err, "Error unmarshalling permissions")
 	}
 
+	if len(notifications) > 0 {
+		if err := json.Unmarshal(notifications, &t.Notifications); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling notifications")
+		}
+	}
+
+	if len(warmPools) > 0 {
+		if err := json.Unmarshal(warmPools, &t.WarmPools); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling warm pools")
+		}
+	}
+
+	if len(policy) > 0 {
+		if err := json.Unmarshal(policy, &t.NetworkPolicy); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling network policy")
+		}
+	}
+
+	if len(defaults) > 0 {
+		if err := json.Unmarshal(defaults, &t.InstanceDefaults); err != nil {
+			return nil, errors.Wrap(err, "Error unmarshalling instance defaults")
+		}
+	}
+
+	t.QuotaProfile = profile.String
+	t.MaintenanceWindows = types.MaintenanceWindows(windows)
+
 	// for these items below, its ok to get err returned
 	// because a tenant could simply not have used any
 	// resources or networks yet.
@@ -741,7 +1083,11 @@ func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
 			 vm_type,
 			 image_name,
 			 visibility,
-			 requirements
+			 requirements,
+			 IFNULL(static_network, 0),
+			 devices,
+			 health_check,
+			 lifetime
 		  FROM workload_template`
 
 	rows, err := db.Query(query)
@@ -756,8 +1102,11 @@ func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
 		var VMType string
 		var visibility string
 		var requirements []byte
+		var devices []byte
+		var healthCheck []byte
+		var lifetime []byte
 
-		err = rows.Scan(&wl.ID, &wl.TenantID, &wl.Description, &wl.FWType, &VMType, &wl.ImageName, &visibility, &requirements)
+		err = rows.Scan(&wl.ID, &wl.TenantID, &wl.Description, &wl.FWType, &VMType, &wl.ImageName, &visibility, &requirements, &wl.StaticNetwork, &devices, &healthCheck, &lifetime)
 		if err != nil {
 			return nil, err
 		}
@@ -767,6 +1116,27 @@ func (ds *sqliteDB) getWorkloads() ([]types.Workload, error) {
 			return nil, err
 		}
 
+		if len(devices) > 0 {
+			err = json.Unmarshal(devices, &wl.Devices)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(healthCheck) > 0 {
+			err = json.Unmarshal(healthCheck, &wl.HealthCheck)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(lifetime) > 0 {
+			err = json.Unmarshal(lifetime, &wl.Lifetime)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		wl.Visibility = types.Visibility(visibility)
 
 		if wl.Visibility == types.Internal {
@@ -830,7 +1200,25 @@ func (ds *sqliteDB) addWorkload(w types.Workload) error {
 		return err
 	}
 
-	_, err = tx.Exec("INSERT INTO workload_template (id, tenant_id, description, filename, fw_type, vm_type, image_name, visibility, requirements) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", w.ID, w.TenantID, w.Description, filename, w.FWType, string(w.VMType), w.ImageName, w.Visibility, string(requirements))
+	devices, err := json.Marshal(w.Devices)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	healthCheck, err := json.Marshal(w.HealthCheck)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	lifetime, err := json.Marshal(w.Lifetime)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO workload_template (id, tenant_id, description, filename, fw_type, vm_type, image_name, visibility, requirements, static_network, devices, health_check, lifetime) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", w.ID, w.TenantID, w.Description, filename, w.FWType, string(w.VMType), w.ImageName, w.Visibility, string(requirements), w.StaticNetwork, string(devices), string(healthCheck), string(lifetime))
 	if err != nil {
 		_ = tx.Rollback()
 		return err
@@ -883,7 +1271,14 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 	query := `SELECT	tenants.id,
 				tenants.name,
 				tenants.subnet_bits,
-				tenants.permissions
+				tenants.permissions,
+				tenants.quota_profile,
+				tenants.notifications,
+				IFNULL(tenants.max_instances_per_request, 0),
+				tenants.warm_pools,
+				tenants.network_policy,
+				tenants.instance_defaults,
+				IFNULL(tenants.maintenance_windows, '')
 		  FROM tenants `
 
 	rows, err := db.Query(query)
@@ -895,10 +1290,16 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 	for rows.Next() {
 		var id sql.NullString
 		var name sql.NullString
+		var profile sql.NullString
 		var perms []byte
+		var notifications []byte
+		var warmPools []byte
+		var policy []byte
+		var defaults []byte
+		var windows string
 
 		t := new(tenant)
-		err = rows.Scan(&id, &name, &t.SubnetBits, &perms)
+		err = rows.Scan(&id, &name, &t.SubnetBits, &perms, &profile, &notifications, &t.MaxInstancesPerRequest, &warmPools, &policy, &defaults, &windows)
 		if err != nil {
 			return nil, err
 		}
@@ -915,6 +1316,33 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 			return nil, errors.Wrap(err, "Error getting unmarshalling permissions")
 		}
 
+		if len(notifications) > 0 {
+			if err := json.Unmarshal(notifications, &t.Notifications); err != nil {
+				return nil, errors.Wrap(err, "Error unmarshalling notifications")
+			}
+		}
+
+		if len(warmPools) > 0 {
+			if err := json.Unmarshal(warmPools, &t.WarmPools); err != nil {
+				return nil, errors.Wrap(err, "Error unmarshalling warm pools")
+			}
+		}
+
+		if len(policy) > 0 {
+			if err := json.Unmarshal(policy, &t.NetworkPolicy); err != nil {
+				return nil, errors.Wrap(err, "Error unmarshalling network policy")
+			}
+		}
+
+		if len(defaults) > 0 {
+			if err := json.Unmarshal(defaults, &t.InstanceDefaults); err != nil {
+				return nil, errors.Wrap(err, "Error unmarshalling instance defaults")
+			}
+		}
+
+		t.QuotaProfile = profile.String
+		t.MaintenanceWindows = types.MaintenanceWindows(windows)
+
 		err = ds.getTenantNetwork(t)
 		if err != nil {
 			return nil, err
@@ -988,11 +1416,27 @@ func (ds *sqliteDB) releaseTenantIP(tenantID string, subnetInt uint32, rest uint
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
+	return ds.execReleaseTenantIP(db, tenantID, subnetInt, rest)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execReleaseTenantIP(db sqlExecer, tenantID string, subnetInt uint32, rest uint32) error {
 	_, err := db.Exec("DELETE FROM tenant_network WHERE tenant_id = ? AND subnet = ? AND rest = ?", tenantID, subnetInt, rest)
 
 	return err
 }
 
+func (ds *sqliteDB) releaseTenantIPs(tenantID string) error {
+	db := ds.getTableDB("tenant_network")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("DELETE FROM tenant_network WHERE tenant_id = ?", tenantID)
+
+	return err
+}
+
 func (ds *sqliteDB) getTenantNetwork(tenant *tenant) error {
 	tenant.network = make(map[uint32]map[uint32]bool)
 
@@ -1044,7 +1488,27 @@ func (ds *sqliteDB) updateTenant(tenant *types.Tenant) error {
 		return errors.Wrap(err, "Error marshalling permissions")
 	}
 
-	_, err = db.Exec("UPDATE tenants SET name = ?, subnet_bits = ?, permissions = ? WHERE id = ?", tenant.Name, tenant.SubnetBits, string(perms), tenant.ID)
+	notifications, err := json.Marshal(tenant.Notifications)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling notifications")
+	}
+
+	warmPools, err := json.Marshal(tenant.WarmPools)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling warm pools")
+	}
+
+	policy, err := json.Marshal(tenant.NetworkPolicy)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling network policy")
+	}
+
+	defaults, err := json.Marshal(tenant.InstanceDefaults)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling instance defaults")
+	}
+
+	_, err = db.Exec("UPDATE tenants SET name = ?, subnet_bits = ?, permissions = ?, quota_profile = ?, notifications = ?, max_instances_per_request = ?, warm_pools = ?, network_policy = ?, instance_defaults = ?, maintenance_windows = ? WHERE id = ?", tenant.Name, tenant.SubnetBits, string(perms), tenant.QuotaProfile, string(notifications), tenant.MaxInstancesPerRequest, string(warmPools), string(policy), string(defaults), string(tenant.MaintenanceWindows), tenant.ID)
 
 	return err
 }
@@ -1067,6 +1531,12 @@ func (ds *sqliteDB) deleteTenant(tenantID string) error {
 		return err
 	}
 
+	_, err = tx.Exec("DELETE FROM tenant_usage WHERE tenant_id = ?", tenantID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
 	_, err = tx.Exec("DELETE FROM tenants WHERE id = ?", tenantID)
 	if err != nil {
 		_ = tx.Rollback()
@@ -1110,7 +1580,13 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		subnet,
 		ip,
 		name,
-		cnci
+		cnci,
+		IFNULL(trace_label, "") AS trace_label,
+		IFNULL(protected, 0) AS protected,
+		IFNULL(warm, 0) AS warm,
+		IFNULL(extra_vcpus, 0) AS extra_vcpus,
+		IFNULL(extra_mem_mb, 0) AS extra_mem_mb,
+		IFNULL(tags, "") AS tags
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1126,12 +1602,19 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		var i types.Instance
 
 		var sshPort sql.NullInt64
+		var tags []byte
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI, &i.TraceLabel, &i.Protected, &i.Warm, &i.ExtraVCPUs, &i.ExtraMemMB, &tags)
 		if err != nil {
 			return nil, err
 		}
 
+		if len(tags) > 0 {
+			if err := json.Unmarshal(tags, &i.Tags); err != nil {
+				return nil, errors.Wrap(err, "Error unmarshalling instance tags")
+			}
+		}
+
 		if sshPort.Valid {
 			i.SSHPort = int(sshPort.Int64)
 		}
@@ -1178,7 +1661,13 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		subnet,
 		ip,
 		name,
-		cnci
+		cnci,
+		IFNULL(trace_label, "") AS trace_label,
+		IFNULL(protected, 0) AS protected,
+		IFNULL(warm, 0) AS warm,
+		IFNULL(extra_vcpus, 0) AS extra_vcpus,
+		IFNULL(extra_mem_mb, 0) AS extra_mem_mb,
+		IFNULL(tags, "") AS tags
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1196,14 +1685,21 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		var nodeID sql.NullString
 		var sshIP sql.NullString
 		var sshPort sql.NullInt64
+		var tags []byte
 
 		i := &types.Instance{}
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.Name, &i.CNCI, &i.TraceLabel, &i.Protected, &i.Warm, &i.ExtraVCPUs, &i.ExtraMemMB, &tags)
 		if err != nil {
 			return nil, err
 		}
 
+		if len(tags) > 0 {
+			if err := json.Unmarshal(tags, &i.Tags); err != nil {
+				return nil, errors.Wrap(err, "Error unmarshalling instance tags")
+			}
+		}
+
 		if nodeID.Valid {
 			i.NodeID = nodeID.String
 		}
@@ -1229,14 +1725,51 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 }
 
 func (ds *sqliteDB) addInstance(instance *types.Instance) error {
+	return ds.addInstances([]*types.Instance{instance})
+}
+
+// addInstances inserts the instances in a single transaction, so that
+// either all or none of them are added.
+func (ds *sqliteDB) addInstances(instances []*types.Instance) error {
 	db := ds.getTableDB("instances")
 
+	tags := make([]string, len(instances))
+	for i, instance := range instances {
+		if len(instance.Tags) == 0 {
+			continue
+		}
+
+		b, err := json.Marshal(instance.Tags)
+		if err != nil {
+			return errors.Wrap(err, "Error marshalling instance tags")
+		}
+		tags[i] = string(b)
+	}
+
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	_, err := db.Exec("INSERT INTO instances VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.VnicUUID, instance.Subnet, instance.IPAddress, instance.CreateTime.Format(time.RFC3339Nano), instance.Name, instance.CNCI)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
 
-	return err
+	stmt, err := tx.Prepare("INSERT INTO instances VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	for i, instance := range instances {
+		_, err = stmt.Exec(instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.VnicUUID, instance.Subnet, instance.IPAddress, instance.CreateTime.Format(time.RFC3339Nano), instance.Name, instance.CNCI, instance.TraceLabel, instance.Protected, instance.Warm, instance.ExtraVCPUs, instance.ExtraMemMB, tags[i])
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (ds *sqliteDB) deleteInstance(instanceID string) error {
@@ -1245,6 +1778,11 @@ func (ds *sqliteDB) deleteInstance(instanceID string) error {
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
+	return ds.execDeleteInstance(db, instanceID)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execDeleteInstance(db sqlExecer, instanceID string) error {
 	_, err := db.Exec("DELETE FROM instances WHERE id = ?", instanceID)
 
 	return err
@@ -1256,7 +1794,12 @@ func (ds *sqliteDB) updateInstance(instance *types.Instance) error {
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	_, err := db.Exec("UPDATE instances SET mac_address = ?, ip = ? WHERE id = ?", instance.MACAddress, instance.IPAddress, instance.ID)
+	return ds.execUpdateInstance(db, instance)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execUpdateInstance(db sqlExecer, instance *types.Instance) error {
+	_, err := db.Exec("UPDATE instances SET tenant_id = ?, mac_address = ?, subnet = ?, ip = ?, name = ?, trace_label = ?, protected = ?, warm = ?, extra_vcpus = ?, extra_mem_mb = ? WHERE id = ?", instance.TenantID, instance.MACAddress, instance.Subnet, instance.IPAddress, instance.Name, instance.TraceLabel, instance.Protected, instance.Warm, instance.ExtraVCPUs, instance.ExtraMemMB, instance.ID)
 
 	return err
 }
@@ -1359,12 +1902,12 @@ func (ds *sqliteDB) addFrameStat(stat payloads.FrameTrace) error {
 func (ds *sqliteDB) getEventLog() ([]*types.LogEntry, error) {
 	var logEntries []*types.LogEntry
 
-	db := ds.getTableDB("log")
+	db, lock := ds.getReportingDB("log")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
 
-	rows, err := db.Query("SELECT timestamp, tenant_id, node_id, type, message FROM log")
+	rows, err := db.Query("SELECT timestamp, tenant_id, node_id, type, IFNULL(category, \"\"), message, IFNULL(instance_id, \"\"), IFNULL(reason, \"\") FROM log")
 	if err != nil {
 		return nil, err
 	}
@@ -1373,7 +1916,7 @@ func (ds *sqliteDB) getEventLog() ([]*types.LogEntry, error) {
 	logEntries = make([]*types.LogEntry, 0)
 	for rows.Next() {
 		var e types.LogEntry
-		err = rows.Scan(&e.Timestamp, &e.TenantID, &e.NodeID, &e.EventType, &e.Message)
+		err = rows.Scan(&e.Timestamp, &e.TenantID, &e.NodeID, &e.Severity, &e.Category, &e.Message, &e.InstanceID, &e.Reason)
 		if err != nil {
 			return nil, err
 		}
@@ -1383,14 +1926,128 @@ func (ds *sqliteDB) getEventLog() ([]*types.LogEntry, error) {
 	return logEntries, err
 }
 
+func (ds *sqliteDB) getEvents(filter types.EventFilter, marker string, limit int) ([]*types.LogEntry, string, error) {
+	var after int64
+	if marker != "" {
+		var err error
+		after, err = strconv.ParseInt(marker, 10, 64)
+		if err != nil {
+			return nil, "", types.ErrBadRequest
+		}
+	}
+
+	conditions := []string{"id > ?"}
+	args := []interface{}{after}
+
+	if filter.TenantID != "" {
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, filter.TenantID)
+	}
+
+	if filter.NodeID != "" {
+		conditions = append(conditions, "node_id = ?")
+		args = append(args, filter.NodeID)
+	}
+
+	if filter.Severity != "" {
+		severities := filter.Severity.AtLeast()
+		if len(severities) == 0 {
+			return []*types.LogEntry{}, "", nil
+		}
+
+		placeholders := make([]string, len(severities))
+		for i, sev := range severities {
+			placeholders[i] = "?"
+			args = append(args, string(sev))
+		}
+		conditions = append(conditions, "type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	if filter.Category != "" {
+		conditions = append(conditions, "category = ?")
+		args = append(args, string(filter.Category))
+	}
+
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := "SELECT id, timestamp, tenant_id, node_id, type, IFNULL(category, \"\"), message, IFNULL(instance_id, \"\"), IFNULL(reason, \"\") FROM log WHERE " +
+		strings.Join(conditions, " AND ") + " ORDER BY id"
+
+	// one more event than requested tells whether there is a next page
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+
+	db, lock := ds.getReportingDB("log")
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var ids []int64
+	logEntries := make([]*types.LogEntry, 0)
+	for rows.Next() {
+		var id int64
+		var e types.LogEntry
+		err = rows.Scan(&id, &e.Timestamp, &e.TenantID, &e.NodeID, &e.Severity, &e.Category, &e.Message, &e.InstanceID, &e.Reason)
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, id)
+		logEntries = append(logEntries, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if limit > 0 && len(logEntries) > limit {
+		logEntries = logEntries[:limit]
+		next = strconv.FormatInt(ids[limit-1], 10)
+	}
+
+	return logEntries, next, nil
+}
+
+func (ds *sqliteDB) pruneLog(before time.Time) (int, error) {
+	db := ds.getTableDB("log")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	res, err := db.Exec("DELETE FROM log WHERE timestamp < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+
+	return int(count), err
+}
+
 // GetBatchFrameSummary will retieve the count of traces we have for a specific label
 func (ds *sqliteDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
 	var stats []types.BatchFrameSummary
 
-	db := ds.getTableDB("frame_statistics")
+	db, lock := ds.getReportingDB("frame_statistics")
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
 
 	query := `SELECT label, count(id)
 		  FROM frame_statistics
@@ -1423,7 +2080,7 @@ func (ds *sqliteDB) getBatchFrameSummary() ([]types.BatchFrameSummary, error) {
 func (ds *sqliteDB) getBatchFrameStatistics(label string) ([]types.BatchFrameStat, error) {
 	var stats []types.BatchFrameStat
 
-	db := ds.getTableDB("frame_statistics")
+	db, lock := ds.getReportingDB("frame_statistics")
 
 	query := `WITH total AS
 		 (
@@ -1508,8 +2165,8 @@ func (ds *sqliteDB) getBatchFrameStatistics(label string) ([]types.BatchFrameSta
 		JOIN total
 		JOIN averages;`
 
-	ds.dbLock.Lock()
-	defer ds.dbLock.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
 
 	rows, err := db.Query(query, label)
 	if err != nil {
@@ -1593,7 +2250,12 @@ func (ds *sqliteDB) getTenantDevices(tenantID string) (map[string]types.Volume,
 				block_data.create_time,
 				block_data.name,
 				block_data.description,
-				block_data.internal
+				block_data.internal,
+				IFNULL(block_data.protected, 0),
+				IFNULL(block_data.metadata, ""),
+				IFNULL(block_data.tags, ""),
+				IFNULL(block_data.pool, ""),
+				IFNULL(block_data.parent, "")
 		  FROM	block_data
 		  WHERE block_data.tenant_id = ?`
 
@@ -1605,13 +2267,19 @@ func (ds *sqliteDB) getTenantDevices(tenantID string) (map[string]types.Volume,
 
 	for rows.Next() {
 		var state string
+		var metadata, tags []byte
 		var data types.Volume
 
-		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal)
+		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal, &data.Protected, &metadata, &tags, &data.Pool, &data.Parent)
 		if err != nil {
 			continue
 		}
 
+		err = unmarshalVolumeLabels(&data, metadata, tags)
+		if err != nil {
+			glog.Warningf("Invalid metadata of volume %s: %v", data.ID, err)
+		}
+
 		data.State = types.BlockState(state)
 		devices[data.ID] = data
 	}
@@ -1635,7 +2303,12 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.Volume, error) {
 				block_data.create_time,
 				block_data.name,
 				block_data.description,
-				block_data.internal
+				block_data.internal,
+				IFNULL(block_data.protected, 0),
+				IFNULL(block_data.metadata, ""),
+				IFNULL(block_data.tags, ""),
+				IFNULL(block_data.pool, ""),
+				IFNULL(block_data.parent, "")
 		  FROM	block_data `
 
 	rows, err := db.Query(query)
@@ -1647,12 +2320,18 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.Volume, error) {
 	for rows.Next() {
 		var data types.Volume
 		var state string
+		var metadata, tags []byte
 
-		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal)
+		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description, &data.Internal, &data.Protected, &metadata, &tags, &data.Pool, &data.Parent)
 		if err != nil {
 			continue
 		}
 
+		err = unmarshalVolumeLabels(&data, metadata, tags)
+		if err != nil {
+			glog.Warningf("Invalid metadata of volume %s: %v", data.ID, err)
+		}
+
 		data.State = types.BlockState(state)
 		devices[data.ID] = data
 	}
@@ -1663,23 +2342,72 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.Volume, error) {
 	return devices, nil
 }
 
+// marshalVolumeLabels encodes the metadata and tags of a volume for the
+// block_data table.
+func marshalVolumeLabels(data types.Volume) (string, string, error) {
+	metadata, err := json.Marshal(data.Metadata)
+	if err != nil {
+		return "", "", errors.Wrap(err, "Error marshalling metadata")
+	}
+
+	tags, err := json.Marshal(data.Tags)
+	if err != nil {
+		return "", "", errors.Wrap(err, "Error marshalling tags")
+	}
+
+	return string(metadata), string(tags), nil
+}
+
+func unmarshalVolumeLabels(data *types.Volume, metadata []byte, tags []byte) error {
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &data.Metadata); err != nil {
+			return errors.Wrap(err, "Error unmarshalling metadata")
+		}
+	}
+
+	if len(tags) > 0 {
+		if err := json.Unmarshal(tags, &data.Tags); err != nil {
+			return errors.Wrap(err, "Error unmarshalling tags")
+		}
+	}
+
+	return nil
+}
+
 func (ds *sqliteDB) addBlockData(data types.Volume) error {
+	metadata, tags, err := marshalVolumeLabels(data)
+	if err != nil {
+		return err
+	}
+
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	err := ds.create("block_data", data.ID, data.TenantID, data.Size, string(data.State), data.CreateTime.Format(time.RFC3339Nano), data.Name, data.Description, data.Internal)
+	err = ds.create("block_data", data.ID, data.TenantID, data.Size, string(data.State), data.CreateTime.Format(time.RFC3339Nano), data.Name, data.Description, data.Internal, data.Protected, metadata, tags, data.Pool, data.Parent)
 
 	return err
 }
 
-// For now we only support updating the state.
+// For now we only support updating the tenant, the state, the protection,
+// the name, the description, the metadata and the tags.
 func (ds *sqliteDB) updateBlockData(data types.Volume) error {
 	db := ds.getTableDB("block_data")
 
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	_, err := db.Exec("UPDATE block_data SET state = ? WHERE id = ?", string(data.State), data.ID)
+	return ds.execUpdateBlockData(db, data)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execUpdateBlockData(db sqlExecer, data types.Volume) error {
+	metadata, tags, err := marshalVolumeLabels(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE block_data SET tenant_id = ?, state = ?, protected = ?, name = ?, description = ?, metadata = ?, tags = ? WHERE id = ?",
+		data.TenantID, string(data.State), data.Protected, data.Name, data.Description, metadata, tags, data.ID)
 
 	return err
 }
@@ -1701,6 +2429,11 @@ func (ds *sqliteDB) addStorageAttachment(a types.StorageAttachment) error {
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
+	return ds.execAddStorageAttachment(db, a)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execAddStorageAttachment(db sqlExecer, a types.StorageAttachment) error {
 	_, err := db.Exec("INSERT INTO attachments (id, instance_id, block_id, ephemeral, boot) VALUES (?, ?, ?, ?, ?)", a.ID, a.InstanceID, a.BlockID, a.Ephemeral, a.Boot)
 
 	return err
@@ -1747,6 +2480,11 @@ func (ds *sqliteDB) deleteStorageAttachment(ID string) error {
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
+	return ds.execDeleteStorageAttachment(db, ID)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execDeleteStorageAttachment(db sqlExecer, ID string) error {
 	_, err := db.Exec("DELETE FROM attachments WHERE id = ?", ID)
 
 	return err
@@ -2056,7 +2794,18 @@ func (ds *sqliteDB) addMappedIP(m types.MappedIP) error {
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	_, err := db.Exec("INSERT INTO mapped_ips (id, pool_id, external_ip, instance_id) VALUES (?, ?, ?, ?)", m.ID, m.PoolID, m.ExternalIP, m.InstanceID)
+	_, err := db.Exec("INSERT INTO mapped_ips (id, pool_id, external_ip, instance_id, node_id) VALUES (?, ?, ?, ?, ?)", m.ID, m.PoolID, m.ExternalIP, m.InstanceID, m.NodeID)
+
+	return err
+}
+
+func (ds *sqliteDB) updateMappedIP(m types.MappedIP) error {
+	db := ds.getTableDB("mapped_ips")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("UPDATE mapped_ips SET node_id = ? WHERE id = ?", m.NodeID, m.ID)
 
 	return err
 }
@@ -2081,6 +2830,7 @@ func (ds *sqliteDB) getMappedIPs() map[string]types.MappedIP {
 				mapped_ips.pool_id,
 				mapped_ips.external_ip,
 				mapped_ips.instance_id,
+				mapped_ips.node_id,
 				instances.ip,
 				instances.tenant_id,
 				pools.name
@@ -2099,12 +2849,15 @@ func (ds *sqliteDB) getMappedIPs() map[string]types.MappedIP {
 
 	for rows.Next() {
 		var IP types.MappedIP
+		var nodeID sql.NullString
 
-		err = rows.Scan(&IP.ID, &IP.PoolID, &IP.ExternalIP, &IP.InstanceID, &IP.InternalIP, &IP.TenantID, &IP.PoolName)
+		err = rows.Scan(&IP.ID, &IP.PoolID, &IP.ExternalIP, &IP.InstanceID, &nodeID, &IP.InternalIP, &IP.TenantID, &IP.PoolName)
 		if err != nil {
 			continue
 		}
 
+		IP.NodeID = nodeID.String
+
 		IPs[IP.ExternalIP] = IP
 	}
 
@@ -2167,10 +2920,129 @@ func (ds *sqliteDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
 	return results, nil
 }
 
+func (ds *sqliteDB) updateQuotaProfile(p types.QuotaProfile) error {
+	db := ds.getTableDB("quota_profiles")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error starting transaction for quota profile update")
+	}
+
+	_, err = tx.Exec("DELETE FROM quota_profiles WHERE profile = ?", p.Name)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "error executing query for quota profile update")
+	}
+
+	for i := range p.Quotas {
+		_, err = tx.Exec("INSERT INTO quota_profiles (profile, name, value) VALUES (?, ?, ?)", p.Name, p.Quotas[i].Name, p.Quotas[i].Value)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Wrap(err, "error executing query for quota profile update")
+		}
+	}
+
+	err = tx.Commit()
+
+	return errors.Wrap(err, "error committing transaction for quota profile update")
+}
+
+func (ds *sqliteDB) deleteQuotaProfile(name string) error {
+	db := ds.getTableDB("quota_profiles")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("DELETE FROM quota_profiles WHERE profile = ?", name)
+
+	return errors.Wrap(err, "error deleting quota profile from database")
+}
+
+func (ds *sqliteDB) getQuotaProfiles() ([]types.QuotaProfile, error) {
+	query := `SELECT profile, name, value FROM quota_profiles ORDER BY profile`
+
+	db := ds.getTableDB("quota_profiles")
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting quota profiles from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	results := []types.QuotaProfile{}
+	for rows.Next() {
+		var profile, name string
+		var value int
+
+		err = rows.Scan(&profile, &name, &value)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading quota profile row from database")
+		}
+
+		if len(results) == 0 || results[len(results)-1].Name != profile {
+			results = append(results, types.QuotaProfile{Name: profile})
+		}
+
+		p := &results[len(results)-1]
+		p.Quotas = append(p.Quotas, types.QuotaDetails{Name: name, Value: value})
+	}
+
+	return results, nil
+}
+
+func (ds *sqliteDB) updateClusterConfig(key string, value string) error {
+	db := ds.getTableDB("config")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("INSERT OR REPLACE INTO config (name, value) VALUES (?, ?)", key, value)
+
+	return errors.Wrap(err, "error updating configuration in database")
+}
+
+func (ds *sqliteDB) deleteClusterConfig(key string) error {
+	db := ds.getTableDB("config")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("DELETE FROM config WHERE name = ?", key)
+
+	return errors.Wrap(err, "error deleting configuration from database")
+}
+
+func (ds *sqliteDB) getClusterConfig() (map[string]string, error) {
+	db := ds.getTableDB("config")
+
+	rows, err := db.Query("SELECT name, value FROM config")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting configuration from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	config := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading configuration row from database")
+		}
+
+		config[key] = value
+	}
+
+	return config, nil
+}
+
 func (ds *sqliteDB) getImages() ([]types.Image, error) {
 	images := []types.Image{}
 
-	query := `SELECT id, state, tenant_id, name, createtime, size, visibility FROM images`
+	query := `SELECT id, state, tenant_id, name, createtime, size, visibility, source_instance_id, source_volume_id, digest, signature, signer FROM images`
 
 	db := ds.getTableDB("images")
 	ds.dbLock.Lock()
@@ -2185,14 +3057,21 @@ func (ds *sqliteDB) getImages() ([]types.Image, error) {
 	for rows.Next() {
 		i := types.Image{}
 		var state, visibility string
+		var sourceInstance, sourceVolume, digest, signer sql.NullString
+		var signature []byte
 
-		err = rows.Scan(&i.ID, &state, &i.TenantID, &i.Name, &i.CreateTime, &i.Size, &visibility)
+		err = rows.Scan(&i.ID, &state, &i.TenantID, &i.Name, &i.CreateTime, &i.Size, &visibility, &sourceInstance, &sourceVolume, &digest, &signature, &signer)
 		if err != nil {
 			return []types.Image{}, errors.Wrap(err, "error reading image row from database")
 		}
 
 		i.State = types.ImageState(state)
 		i.Visibility = types.Visibility(visibility)
+		i.SourceInstanceID = sourceInstance.String
+		i.SourceVolumeID = sourceVolume.String
+		i.Digest = digest.String
+		i.Signature = signature
+		i.Signer = signer.String
 
 		images = append(images, i)
 	}
@@ -2201,13 +3080,13 @@ func (ds *sqliteDB) getImages() ([]types.Image, error) {
 }
 
 func (ds *sqliteDB) updateImage(i types.Image) error {
-	query := `REPLACE INTO images (id, state, tenant_id, name, createtime, size, visibility) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	query := `REPLACE INTO images (id, state, tenant_id, name, createtime, size, visibility, source_instance_id, source_volume_id, digest, signature, signer) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	db := ds.getTableDB("images")
 	ds.dbLock.Lock()
 	defer ds.dbLock.Unlock()
 
-	_, err := db.Exec(query, i.ID, i.State, i.TenantID, i.Name, i.CreateTime, i.Size, i.Visibility)
+	_, err := db.Exec(query, i.ID, i.State, i.TenantID, i.Name, i.CreateTime, i.Size, i.Visibility, i.SourceInstanceID, i.SourceVolumeID, i.Digest, i.Signature, i.Signer)
 
 	return errors.Wrap(err, "Error updatiing image into database")
 }
@@ -2223,3 +3102,261 @@ func (ds *sqliteDB) deleteImage(ID string) error {
 
 	return errors.Wrap(err, "Error deleting image from database")
 }
+
+func (ds *sqliteDB) updateVNI(v types.VNIAllocation) error {
+	query := `REPLACE INTO subnet_vnis (vni, tenant_id, subnet, state, release_time) VALUES (?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("subnet_vnis")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec(query, v.VNI, v.TenantID, v.Subnet, string(v.State), v.ReleaseTime)
+
+	return errors.Wrap(err, "Error updating VNI into database")
+}
+
+func (ds *sqliteDB) getVNIs() ([]types.VNIAllocation, error) {
+	vnis := []types.VNIAllocation{}
+
+	query := `SELECT vni, tenant_id, subnet, state, release_time FROM subnet_vnis`
+
+	db := ds.getTableDB("subnet_vnis")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return vnis, errors.Wrap(err, "error getting VNIs from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var v types.VNIAllocation
+		var state string
+
+		err = rows.Scan(&v.VNI, &v.TenantID, &v.Subnet, &state, &v.ReleaseTime)
+		if err != nil {
+			return []types.VNIAllocation{}, errors.Wrap(err, "error reading VNI row from database")
+		}
+
+		v.State = types.VNIState(state)
+
+		vnis = append(vnis, v)
+	}
+
+	return vnis, nil
+}
+
+func (ds *sqliteDB) addShadowPlacement(p types.ShadowPlacement) error {
+	query := `REPLACE INTO shadow_placements (instance_id, tenant_id, policy, timestamp, shadow_node_id, actual_node_id) VALUES (?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("shadow_placements")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec(query, p.InstanceID, p.TenantID, p.Policy, p.Timestamp, p.ShadowNodeID, p.ActualNodeID)
+
+	return errors.Wrap(err, "Error adding shadow placement into database")
+}
+
+func (ds *sqliteDB) setShadowPlacementNode(instanceID string, nodeID string) error {
+	db := ds.getTableDB("shadow_placements")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("UPDATE shadow_placements SET actual_node_id = ? WHERE instance_id = ?", nodeID, instanceID)
+
+	return errors.Wrap(err, "Error updating shadow placement in database")
+}
+
+func (ds *sqliteDB) getShadowPlacements() ([]types.ShadowPlacement, error) {
+	placements := []types.ShadowPlacement{}
+
+	query := `SELECT instance_id, tenant_id, policy, timestamp, shadow_node_id, actual_node_id FROM shadow_placements`
+
+	db := ds.getTableDB("shadow_placements")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return placements, errors.Wrap(err, "error getting shadow placements from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var p types.ShadowPlacement
+
+		err = rows.Scan(&p.InstanceID, &p.TenantID, &p.Policy, &p.Timestamp, &p.ShadowNodeID, &p.ActualNodeID)
+		if err != nil {
+			return []types.ShadowPlacement{}, errors.Wrap(err, "error reading shadow placement row from database")
+		}
+
+		placements = append(placements, p)
+	}
+
+	return placements, nil
+}
+
+func (ds *sqliteDB) addDeletedInstance(d types.DeletedInstance) error {
+	db := ds.getTableDB("deleted_instances")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	return ds.execAddDeletedInstance(db, d)
+}
+
+// lock must be held by caller
+func (ds *sqliteDB) execAddDeletedInstance(db sqlExecer, d types.DeletedInstance) error {
+	query := `REPLACE INTO deleted_instances (id, tenant_id, name, workload_id, node_id, state, ip, mac_address, subnet, trace_label, create_time, delete_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := db.Exec(query, d.ID, d.TenantID, d.Name, d.WorkloadID, d.NodeID, d.State, d.IPAddress, d.MACAddress, d.Subnet, d.TraceLabel, d.Created, d.Deleted)
+
+	return errors.Wrap(err, "Error adding deleted instance into database")
+}
+
+func (ds *sqliteDB) deleteDeletedInstance(ID string) error {
+	db := ds.getTableDB("deleted_instances")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("DELETE FROM deleted_instances WHERE id = ?", ID)
+
+	return errors.Wrap(err, "Error deleting deleted instance from database")
+}
+
+func (ds *sqliteDB) getDeletedInstances() ([]types.DeletedInstance, error) {
+	instances := []types.DeletedInstance{}
+
+	query := `SELECT id, tenant_id, name, workload_id, node_id, state, ip, mac_address, subnet, trace_label, create_time, delete_time FROM deleted_instances`
+
+	db := ds.getTableDB("deleted_instances")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return instances, errors.Wrap(err, "error getting deleted instances from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var d types.DeletedInstance
+
+		err = rows.Scan(&d.ID, &d.TenantID, &d.Name, &d.WorkloadID, &d.NodeID, &d.State, &d.IPAddress, &d.MACAddress, &d.Subnet, &d.TraceLabel, &d.Created, &d.Deleted)
+		if err != nil {
+			return []types.DeletedInstance{}, errors.Wrap(err, "error reading deleted instance row from database")
+		}
+
+		instances = append(instances, d)
+	}
+
+	return instances, nil
+}
+
+func (ds *sqliteDB) updateTenantUsage(s types.TenantUsageSample) error {
+	query := `REPLACE INTO tenant_usage (tenant_id, resolution, timestamp, vcpu, memory, disk, rx_bytes, tx_bytes) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	db := ds.getTableDB("tenant_usage")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec(query, s.TenantID, string(s.Resolution), s.Timestamp.UTC(), s.VCPU, s.Memory, s.Disk,
+		int64(s.NetworkRxBytes), int64(s.NetworkTxBytes))
+
+	return errors.Wrap(err, "Error adding tenant usage into database")
+}
+
+func (ds *sqliteDB) scanTenantUsage(rows *sql.Rows) (types.TenantUsageSample, error) {
+	var s types.TenantUsageSample
+	var resolution string
+	var rx, tx int64
+
+	err := rows.Scan(&s.TenantID, &resolution, &s.Timestamp, &s.VCPU, &s.Memory, &s.Disk, &rx, &tx)
+	if err != nil {
+		return s, errors.Wrap(err, "error reading tenant usage row from database")
+	}
+
+	s.Resolution = types.UsageResolution(resolution)
+	s.NetworkRxBytes = uint64(rx)
+	s.NetworkTxBytes = uint64(tx)
+
+	return s, nil
+}
+
+func (ds *sqliteDB) getTenantUsage(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	return ds.queryTenantUsage(ds.getTableDB("tenant_usage"), ds.dbLock, tenantID, resolution, start, end)
+}
+
+// getTenantUsageReport reads the tenant usage from the read replica if
+// there is one.  Samples just stored may be missing.
+func (ds *sqliteDB) getTenantUsageReport(tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	db, lock := ds.getReportingDB("tenant_usage")
+	return ds.queryTenantUsage(db, lock, tenantID, resolution, start, end)
+}
+
+func (ds *sqliteDB) queryTenantUsage(db *sql.DB, lock sync.Locker, tenantID string, resolution types.UsageResolution, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	usage := []types.CiaoUsage{}
+
+	query := `SELECT tenant_id, resolution, timestamp, vcpu, memory, disk, rx_bytes, tx_bytes FROM tenant_usage
+		WHERE tenant_id = ? AND resolution = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp`
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	rows, err := db.Query(query, tenantID, string(resolution), start.UTC(), end.UTC())
+	if err != nil {
+		return usage, errors.Wrap(err, "error getting tenant usage from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		s, err := ds.scanTenantUsage(rows)
+		if err != nil {
+			return []types.CiaoUsage{}, err
+		}
+
+		usage = append(usage, s.CiaoUsage)
+	}
+
+	return usage, nil
+}
+
+func (ds *sqliteDB) deleteTenantUsage(tenantID string, resolution types.UsageResolution, before time.Time) error {
+	db := ds.getTableDB("tenant_usage")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	_, err := db.Exec("DELETE FROM tenant_usage WHERE tenant_id = ? AND resolution = ? AND timestamp < ?",
+		tenantID, string(resolution), before.UTC())
+
+	return errors.Wrap(err, "Error deleting tenant usage from database")
+}
+
+func (ds *sqliteDB) getAllTenantUsage() ([]types.TenantUsageSample, error) {
+	samples := []types.TenantUsageSample{}
+
+	query := `SELECT tenant_id, resolution, timestamp, vcpu, memory, disk, rx_bytes, tx_bytes FROM tenant_usage`
+
+	db := ds.getTableDB("tenant_usage")
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return samples, errors.Wrap(err, "error getting tenant usage from database")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		s, err := ds.scanTenantUsage(rows)
+		if err != nil {
+			return []types.TenantUsageSample{}, err
+		}
+
+		samples = append(samples, s)
+	}
+
+	return samples, nil
+}
@@ -182,6 +182,65 @@ func TestSQLiteDBGetAllBlockData(t *testing.T) {
 	db.disconnect()
 }
 
+func TestSQLiteDBBlockDataLabels(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{
+			ID: uuid.Generate().String(),
+		},
+		State:      types.Available,
+		TenantID:   uuid.Generate().String(),
+		CreateTime: time.Now(),
+		Metadata:   map[string]string{"role": "db"},
+		Tags:       []string{"data"},
+		Pool:       "ssd",
+		Parent:     uuid.Generate().String(),
+	}
+
+	err = db.addBlockData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := db.getAllBlockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := devices[data.ID]
+	if !reflect.DeepEqual(d.Metadata, data.Metadata) || !reflect.DeepEqual(d.Tags, data.Tags) {
+		t.Fatalf("Expected %v %v, got %v %v", data.Metadata, data.Tags, d.Metadata, d.Tags)
+	}
+
+	if d.Pool != data.Pool || d.Parent != data.Parent {
+		t.Fatalf("Expected pool %s and parent %s, got %s and %s", data.Pool, data.Parent, d.Pool, d.Parent)
+	}
+
+	data.Name = "renamed"
+	data.Metadata = map[string]string{"role": "web", "tier": "front"}
+	data.Tags = nil
+
+	err = db.updateBlockData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err = db.getAllBlockData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = devices[data.ID]
+	if d.Name != data.Name || !reflect.DeepEqual(d.Metadata, data.Metadata) || len(d.Tags) != 0 {
+		t.Fatalf("Volume not updated: %+v", d)
+	}
+}
+
 func TestSQLiteDBDeleteBlockData(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -846,10 +905,11 @@ func TestSQLiteDBEventLog(t *testing.T) {
 	tn := createTestTenant(db, t)
 
 	e := types.LogEntry{
-		TenantID:  tn.ID,
-		EventType: string(userError),
-		Message:   "test message 1",
-		NodeID:    "validNodeID",
+		TenantID: tn.ID,
+		Severity: types.EventError,
+		Category: types.EventCategoryInstance,
+		Message:  "test message 1",
+		NodeID:   "validNodeID",
 	}
 	err = db.logEvent(e)
 	if err != nil {
@@ -863,6 +923,10 @@ func TestSQLiteDBEventLog(t *testing.T) {
 	if len(log) != 1 {
 		t.Fatal("Expected 1 log message")
 	}
+	if log[0].Severity != e.Severity || log[0].Category != e.Category {
+		t.Fatalf("Expected %s %s log message, got %s %s", e.Severity, e.Category,
+			log[0].Severity, log[0].Category)
+	}
 
 	e.Message = "test message 2"
 	err = db.logEvent(e)
@@ -892,6 +956,72 @@ func TestSQLiteDBEventLog(t *testing.T) {
 	}
 }
 
+func TestSQLiteDBReadReplica(t *testing.T) {
+	ps := &sqliteDB{}
+	config := Config{
+		PersistentURI:     fmt.Sprintf("file:memdb%d?mode=memory&cache=shared", dbCount),
+		ReadReplicaURI:    fmt.Sprintf("file:memdb%d?mode=memory&cache=shared", dbCount+1),
+		InitWorkloadsPath: *workloadsPath,
+	}
+	dbCount = dbCount + 2
+
+	err := ps.init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.disconnect()
+
+	// The replica is a separate, empty database here, so entries
+	// written to the primary must not be visible to the reporting
+	// queries.
+	tn := createTestTenant(ps, t)
+
+	e := types.LogEntry{
+		TenantID: tn.ID,
+		Severity: types.EventInfo,
+		Category: types.EventCategoryInstance,
+		Message:  "test message",
+	}
+	err = ps.logEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ps.getEventLog()
+	if err == nil {
+		t.Fatal("Expected event log to be read from the replica")
+	}
+
+	sample := types.TenantUsageSample{
+		TenantID:   tn.ID,
+		Resolution: types.UsageRaw,
+		CiaoUsage:  types.CiaoUsage{VCPU: 1, Timestamp: time.Now()},
+	}
+	err = ps.updateTenantUsage(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ps.getTenantUsageReport(tn.ID, types.UsageRaw, usageEpoch, time.Now().Add(time.Minute))
+	if err == nil {
+		t.Fatal("Expected tenant usage report to be read from the replica")
+	}
+
+	// the roll up deletes the samples it read, it must use the primary.
+	usage, err := ps.getTenantUsage(tn.ID, types.UsageRaw, usageEpoch, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 {
+		t.Fatalf("Expected 1 usage sample from the primary, got %d", len(usage))
+	}
+
+	_, err = ps.replica.Exec("CREATE TABLE log (id integer)")
+	if err == nil {
+		t.Fatal("Expected replica connection to be read only")
+	}
+}
+
 func TestSQLiteDBInstanceStats(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -961,7 +1091,13 @@ users:
 			VCPUs: 2,
 			MemMB: 512,
 		},
-		Storage: []types.StorageResource{storage},
+		Storage:       []types.StorageResource{storage},
+		StaticNetwork: true,
+		Devices: payloads.DeviceModels{
+			Disk:    payloads.VirtioSCSI,
+			NIC:     payloads.E1000,
+			Machine: payloads.MachineQ35,
+		},
 	}
 
 	// file will be added, so we will want to remove it.
@@ -1185,6 +1321,61 @@ func TestAddCNCIInstance(t *testing.T) {
 	db.disconnect()
 }
 
+func TestSQLiteDBAddInstances(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	tenantID := uuid.Generate().String()
+	var instances []*types.Instance
+	for i := 0; i < 3; i++ {
+		instances = append(instances, &types.Instance{
+			ID:         uuid.Generate().String(),
+			TenantID:   tenantID,
+			WorkloadID: uuid.Generate().String(),
+			IPAddress:  fmt.Sprintf("172.16.0.%d", i+2),
+			Name:       fmt.Sprintf("test-%d", i),
+			Tags:       []string{"bulk"},
+		})
+	}
+
+	err = db.addInstances(instances)
+	if err != nil {
+		t.Fatalf("unable to store instances %v\n", err)
+	}
+
+	stored, err := db.getInstances()
+	if err != nil || len(stored) != len(instances) {
+		t.Fatalf("Expected %d instances, got %d: %v", len(instances), len(stored), err)
+	}
+
+	for _, i := range stored {
+		if len(i.Tags) != 1 || i.Tags[0] != "bulk" {
+			t.Fatalf("Instance tags not properly stored: %v", i.Tags)
+		}
+	}
+
+	// the transaction is rolled back when one of the instances fails
+	added := &types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenantID,
+		WorkloadID: uuid.Generate().String(),
+		IPAddress:  "172.16.0.10",
+	}
+
+	err = db.addInstances([]*types.Instance{added, instances[0]})
+	if err == nil {
+		t.Fatal("Expected instances add to fail (duplicate ID)")
+	}
+
+	stored, err = db.getInstances()
+	if err != nil || len(stored) != len(instances) {
+		t.Fatalf("Expected %d instances after rollback, got %d: %v", len(instances), len(stored), err)
+	}
+}
+
 func TestSQLiteDBUpdateTenant(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -0,0 +1,250 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// sqliteMigration is a step of the upgrade of the schema of a sqlite
+// datastore. Steps are applied in order of version, each in its own
+// transaction, and the versions applied are recorded in the schema_version
+// table.
+//
+// The CREATE TABLE statements of the tables always describe the latest
+// schema, so that new datastores are created up to date. Steps must
+// therefore be idempotent: a step adding a column skips it if the table
+// already has it.
+type sqliteMigration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// sqliteMigrations lists the steps of the upgrade of the sqlite schema.
+// New steps are appended with the next version and must never be changed
+// once released. Tables added to the schema are created by their Init
+// and need no step.
+var sqliteMigrations = []sqliteMigration{
+	{
+		version:     1,
+		description: "Add severities, categories, instances and reasons to events",
+		apply: addColumns("log",
+			"category string",
+			"instance_id varchar(32)",
+			"reason string"),
+	},
+	{
+		version:     2,
+		description: "Add trace labels, protection, warm pools, hot added resources and tags to instances",
+		apply: addColumns("instances",
+			"trace_label string",
+			"protected int",
+			"warm int",
+			"extra_vcpus int",
+			"extra_mem_mb int",
+			"tags text"),
+	},
+	{
+		version:     3,
+		description: "Add protection, metadata, tags, storage pools and parents to volumes",
+		apply: addColumns("block_data",
+			"protected int",
+			"metadata string",
+			"tags string",
+			"pool string",
+			"parent string"),
+	},
+	{
+		version:     4,
+		description: "Add quota profiles, notifications, launch limits, warm pools, network policies and instance defaults to tenants",
+		apply: addColumns("tenants",
+			"quota_profile text",
+			"notifications text",
+			"max_instances_per_request int",
+			"warm_pools text",
+			"network_policy text",
+			"instance_defaults text"),
+	},
+	{
+		version:     5,
+		description: "Add static network configuration, device models and health checks to workloads",
+		apply: addColumns("workload_template",
+			"static_network int",
+			"devices text",
+			"health_check text"),
+	},
+	{
+		version:     6,
+		description: "Add nodes to mapped IPs",
+		apply:       addColumns("mapped_ips", "node_id varchar(32)"),
+	},
+	{
+		version:     7,
+		description: "Add sources and signatures to images",
+		apply: addColumns("images",
+			"source_instance_id string",
+			"source_volume_id string",
+			"digest string",
+			"signature blob",
+			"signer string"),
+	},
+	{
+		version:     8,
+		description: "Add lifetime policies to workloads",
+		apply:       addColumns("workload_template", "lifetime text"),
+	},
+	{
+		version:     9,
+		description: "Add maintenance windows to tenants",
+		apply:       addColumns("tenants", "maintenance_windows text"),
+	},
+}
+
+// tableColumns returns the names of the columns of a table.
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting columns of table %s", table)
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt interface{}
+
+		err = rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading columns of table %s", table)
+		}
+
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// addColumns returns a migration step adding the columns, given as column
+// definitions, which a table lacks.
+func addColumns(table string, columns ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		existing, err := tableColumns(tx, table)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range columns {
+			name := strings.Fields(c)[0]
+			if existing[name] {
+				continue
+			}
+
+			_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, c))
+			if err != nil {
+				return errors.Wrapf(err, "Error adding column %s to table %s", name, table)
+			}
+		}
+
+		return nil
+	}
+}
+
+type schemaVersionData struct {
+	namedData
+}
+
+func (d schemaVersionData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS schema_version
+		(
+			version int primary key,
+			description string,
+			applied DATETIME
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// getSchemaVersion returns the version of the schema of the datastore, 0
+// for datastores which predate schema versioning.
+func (ds *sqliteDB) getSchemaVersion() (int, error) {
+	var version sql.NullInt64
+
+	db := ds.getTableDB("schema_version")
+
+	err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error getting schema version")
+	}
+
+	return int(version.Int64), nil
+}
+
+// migrateSchema upgrades the schema of the datastore by applying the
+// migration steps newer than its version.
+func (ds *sqliteDB) migrateSchema(migrations []sqliteMigration) error {
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	current, err := ds.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("Datastore schema version %d is newer than the supported version %d", current, latest)
+	}
+
+	db := ds.getTableDB("schema_version")
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		glog.Infof("Upgrading datastore schema to version %d: %s", m.version, m.description)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "Error starting schema upgrade")
+		}
+
+		err = m.apply(tx)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?)",
+				m.version, m.description, time.Now())
+		}
+
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "Error upgrading datastore schema to version %d", m.version)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return errors.Wrapf(err, "Error committing datastore schema version %d", m.version)
+		}
+	}
+
+	return nil
+}
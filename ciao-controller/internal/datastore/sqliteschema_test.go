@@ -0,0 +1,219 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// legacySchema is the schema of some of the tables of datastores created
+// before schema versioning.
+var legacySchema = []string{
+	`CREATE TABLE log
+		(
+		id integer primary key,
+		tenant_id varchar(32),
+		node_id varchar(32),
+		type string,
+		message string,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+		);`,
+	`CREATE TABLE images
+		(
+			id varchar(32) primary key,
+			state string,
+			tenant_id string,
+			name string,
+			createtime DATETIME,
+			size int,
+			visibility string
+		);`,
+	`INSERT INTO images (id, state, tenant_id, name, createtime, size, visibility)
+		VALUES ('legacy-image', 'active', 'legacy-tenant', 'legacy', '2017-01-01 00:00:00', 1024, 'private')`,
+}
+
+func createLegacyDB(t *testing.T, path string) {
+	registerDriver(path)
+
+	db, err := sql.Open(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	for _, cmd := range legacySchema {
+		_, err = db.Exec(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func openSchemaTestDB(t *testing.T, path string) *sqliteDB {
+	ps := &sqliteDB{}
+	config := Config{
+		PersistentURI:     path,
+		InitWorkloadsPath: *workloadsPath,
+	}
+
+	err := ps.init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ps
+}
+
+func TestSQLiteSchemaUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "legacy.db")
+	createLegacyDB(t, path)
+
+	ps := openSchemaTestDB(t, path)
+
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+	version, err := ps.getSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != latest {
+		t.Fatalf("Expected schema version %d, got %d", latest, version)
+	}
+
+	images, err := ps.getImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || images[0].ID != "legacy-image" || images[0].Size != 1024 {
+		t.Fatalf("Legacy image not read back: %+v", images)
+	}
+
+	images[0].Signer = "signer"
+	images[0].Signature = []byte("signature")
+	err = ps.updateImage(images[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ps.getEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps.disconnect()
+
+	// opening an up to date datastore applies nothing
+	ps = openSchemaTestDB(t, path)
+	defer ps.disconnect()
+
+	var count int
+	err = ps.db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != len(sqliteMigrations) {
+		t.Fatalf("Expected %d schema versions, got %d", len(sqliteMigrations), count)
+	}
+
+	images, err = ps.getImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || images[0].Signer != "signer" || string(images[0].Signature) != "signature" {
+		t.Fatalf("Upgraded image not read back: %+v", images)
+	}
+}
+
+func TestSQLiteSchemaNewDB(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	ps := db.(*sqliteDB)
+
+	version, err := ps.getSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+	if version != latest {
+		t.Fatalf("Expected schema version %d, got %d", latest, version)
+	}
+}
+
+func TestSQLiteSchemaNewer(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	ps := db.(*sqliteDB)
+
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+	_, err = ps.db.Exec("INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?)",
+		latest+1, "from the future", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ps.migrateSchema(sqliteMigrations)
+	if err == nil {
+		t.Fatal("Expected failure opening datastore with a newer schema")
+	}
+
+	steps := append(sqliteMigrations, sqliteMigration{
+		version:     latest + 2,
+		description: "Add columns to images",
+		apply:       addColumns("images", "extra string"),
+	})
+
+	err = ps.migrateSchema(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := ps.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	columns, err := tableColumns(tx, "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !columns["extra"] || !columns["signer"] {
+		t.Fatalf("Missing columns of images: %v", columns)
+	}
+}
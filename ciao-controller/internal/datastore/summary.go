@@ -0,0 +1,252 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sync"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// instanceFootprint records what an instance contributes to the
+// resource summary of its tenant, so that it can be taken back out
+// without having to look the workload up again.
+type instanceFootprint struct {
+	tenantID string
+	state    string
+	vcpus    int
+	memMB    int
+}
+
+// volumeFootprint records what a volume contributes to the resource
+// summary of its tenant.
+type volumeFootprint struct {
+	tenantID string
+	sizeGB   int
+}
+
+// tenantSummaries maintains the per tenant resource summaries
+// incrementally as instances and volumes come and go.
+type tenantSummaries struct {
+	sync.Mutex
+	summaries map[string]*types.TenantResourceSummary
+	instances map[string]instanceFootprint
+	volumes   map[string]volumeFootprint
+}
+
+func newTenantSummaries() *tenantSummaries {
+	return &tenantSummaries{
+		summaries: make(map[string]*types.TenantResourceSummary),
+		instances: make(map[string]instanceFootprint),
+		volumes:   make(map[string]volumeFootprint),
+	}
+}
+
+// get returns the summary of a tenant, creating it if needed.
+// The lock must be held.
+func (s *tenantSummaries) get(tenantID string) *types.TenantResourceSummary {
+	summary, ok := s.summaries[tenantID]
+	if !ok {
+		summary = &types.TenantResourceSummary{
+			TenantID:       tenantID,
+			InstanceStates: make(map[string]int),
+		}
+		s.summaries[tenantID] = summary
+	}
+
+	return summary
+}
+
+func (s *tenantSummaries) addInstance(i *types.Instance, vcpus int, memMB int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.instances[i.ID]; ok {
+		return
+	}
+
+	f := instanceFootprint{
+		tenantID: i.TenantID,
+		state:    i.State,
+		vcpus:    vcpus,
+		memMB:    memMB,
+	}
+	s.instances[i.ID] = f
+
+	summary := s.get(f.tenantID)
+	summary.TotalInstances++
+	summary.InstanceStates[f.state]++
+	summary.VCPUs += f.vcpus
+	summary.MemMB += f.memMB
+}
+
+func (s *tenantSummaries) removeInstance(instanceID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	f, ok := s.instances[instanceID]
+	if !ok {
+		return
+	}
+	delete(s.instances, instanceID)
+
+	summary := s.get(f.tenantID)
+	summary.TotalInstances--
+	summary.InstanceStates[f.state]--
+	if summary.InstanceStates[f.state] == 0 {
+		delete(summary.InstanceStates, f.state)
+	}
+	summary.VCPUs -= f.vcpus
+	summary.MemMB -= f.memMB
+}
+
+func (s *tenantSummaries) instanceState(instanceID string, state string) {
+	s.Lock()
+	defer s.Unlock()
+
+	f, ok := s.instances[instanceID]
+	if !ok || f.state == state {
+		return
+	}
+
+	summary := s.get(f.tenantID)
+	summary.InstanceStates[f.state]--
+	if summary.InstanceStates[f.state] == 0 {
+		delete(summary.InstanceStates, f.state)
+	}
+	summary.InstanceStates[state]++
+
+	f.state = state
+	s.instances[instanceID] = f
+}
+
+// instanceResources accounts for resources hot added to an instance.
+func (s *tenantSummaries) instanceResources(instanceID string, vcpus int, memMB int) {
+	s.Lock()
+	defer s.Unlock()
+
+	f, ok := s.instances[instanceID]
+	if !ok {
+		return
+	}
+
+	summary := s.get(f.tenantID)
+	summary.VCPUs += vcpus
+	summary.MemMB += memMB
+
+	f.vcpus += vcpus
+	f.memMB += memMB
+	s.instances[instanceID] = f
+}
+
+func (s *tenantSummaries) addVolume(v types.Volume) {
+	s.Lock()
+	defer s.Unlock()
+
+	if old, ok := s.volumes[v.ID]; ok {
+		summary := s.get(old.tenantID)
+		summary.TotalVolumes--
+		summary.VolumeSizeGB -= old.sizeGB
+		delete(s.volumes, v.ID)
+	}
+
+	// internal volumes are not visible to the tenant
+	if v.Internal {
+		return
+	}
+
+	f := volumeFootprint{
+		tenantID: v.TenantID,
+		sizeGB:   v.Size,
+	}
+	s.volumes[v.ID] = f
+
+	summary := s.get(f.tenantID)
+	summary.TotalVolumes++
+	summary.VolumeSizeGB += f.sizeGB
+}
+
+func (s *tenantSummaries) removeVolume(volumeID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	f, ok := s.volumes[volumeID]
+	if !ok {
+		return
+	}
+	delete(s.volumes, volumeID)
+
+	summary := s.get(f.tenantID)
+	summary.TotalVolumes--
+	summary.VolumeSizeGB -= f.sizeGB
+}
+
+func (s *tenantSummaries) removeTenant(tenantID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.summaries, tenantID)
+}
+
+func (s *tenantSummaries) snapshot(tenantID string) types.TenantResourceSummary {
+	s.Lock()
+	defer s.Unlock()
+
+	summary := *s.get(tenantID)
+	summary.InstanceStates = make(map[string]int)
+	for state, count := range s.summaries[tenantID].InstanceStates {
+		summary.InstanceStates[state] = count
+	}
+
+	return summary
+}
+
+// summaryAddInstance accounts for a new tenant instance in the resource
+// summary of its tenant, including any resources hot added to it. CNCIs
+// are not included.
+func (ds *Datastore) summaryAddInstance(i *types.Instance) {
+	if i.CNCI {
+		return
+	}
+
+	var vcpus, memMB int
+	wl, err := ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		glog.Warningf("Unable to account resources of instance %s: %v", i.ID, err)
+	} else {
+		vcpus = wl.Requirements.VCPUs
+		memMB = wl.Requirements.MemMB
+	}
+	vcpus += i.ExtraVCPUs
+	memMB += i.ExtraMemMB
+
+	ds.summaries.addInstance(i, vcpus, memMB)
+}
+
+// GetTenantResourceSummary returns the number of instances per state,
+// the compute footprint and the volume usage of a tenant.
+func (ds *Datastore) GetTenantResourceSummary(tenantID string) (types.TenantResourceSummary, error) {
+	t, err := ds.getTenant(tenantID)
+	if err != nil {
+		return types.TenantResourceSummary{}, err
+	}
+
+	if t == nil {
+		return types.TenantResourceSummary{}, types.ErrTenantNotFound
+	}
+
+	return ds.summaries.snapshot(tenantID), nil
+}
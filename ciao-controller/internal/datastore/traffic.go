@@ -0,0 +1,168 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// trafficKey identifies the traffic counter of an IP on a CNCI.
+type trafficKey struct {
+	concentratorID string
+	ip             string
+}
+
+// counterDelta returns the traffic accounted by a cumulative counter
+// since its previous report.  Counters going backwards mean the CNCI was
+// restarted and started counting again from 0.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+
+	return cur - prev
+}
+
+// instanceByIP returns the ID of the instance of a tenant which has a
+// tenant network IP address, or "" if there is none.
+func (ds *Datastore) instanceByIP(tenantID string, ip string) string {
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+
+	tenant, ok := ds.tenants[tenantID]
+	if !ok {
+		return ""
+	}
+
+	for _, i := range tenant.instances {
+		if !i.CNCI && i.IPAddress == ip {
+			return i.ID
+		}
+	}
+
+	return ""
+}
+
+// AddTrafficReport accounts the external traffic reported by a CNCI to its
+// instances and adds it to the usage of the tenant of the CNCI.  The
+// first report received from a CNCI after the controller started is
+// accounted in full.
+func (ds *Datastore) AddTrafficReport(report payloads.TrafficReportEvent) error {
+	cnci, err := ds.GetInstance(report.ConcentratorUUID)
+	if err != nil {
+		return errors.Wrapf(err, "Error getting CNCI %s", report.ConcentratorUUID)
+	}
+
+	if !cnci.CNCI {
+		return errors.Errorf("Instance %s is not a CNCI", cnci.ID)
+	}
+
+	var delta types.CiaoUsage
+
+	ds.trafficLock.Lock()
+
+	for _, c := range report.Counters {
+		key := trafficKey{concentratorID: cnci.ID, ip: c.PrivateIP}
+		prev := ds.cnciTraffic[key]
+		ds.cnciTraffic[key] = c
+
+		traffic := types.CiaoTrafficUsage{
+			RxBytes:   counterDelta(prev.RxBytes, c.RxBytes),
+			TxBytes:   counterDelta(prev.TxBytes, c.TxBytes),
+			RxPackets: counterDelta(prev.RxPackets, c.RxPackets),
+			TxPackets: counterDelta(prev.TxPackets, c.TxPackets),
+		}
+
+		delta.NetworkRxBytes += traffic.RxBytes
+		delta.NetworkTxBytes += traffic.TxBytes
+
+		instanceID := ds.instanceByIP(cnci.TenantID, c.PrivateIP)
+		if instanceID == "" {
+			continue
+		}
+
+		total := ds.instanceTraffic[instanceID]
+		total.RxBytes += traffic.RxBytes
+		total.TxBytes += traffic.TxBytes
+		total.RxPackets += traffic.RxPackets
+		total.TxPackets += traffic.TxPackets
+		ds.instanceTraffic[instanceID] = total
+	}
+
+	ds.trafficLock.Unlock()
+
+	ds.updateTenantUsage(delta, cnci.TenantID)
+
+	return nil
+}
+
+// GetInstanceTraffic returns the external traffic of an instance accounted
+// by its CNCI, or nil if none was reported.
+func (ds *Datastore) GetInstanceTraffic(instanceID string) *types.CiaoTrafficUsage {
+	ds.trafficLock.Lock()
+	defer ds.trafficLock.Unlock()
+
+	traffic, ok := ds.instanceTraffic[instanceID]
+	if !ok {
+		return nil
+	}
+
+	return &traffic
+}
+
+// deleteInstanceTraffic forgets the traffic of an instance and, if it is a
+// CNCI, the last counters it reported.
+func (ds *Datastore) deleteInstanceTraffic(instanceID string) {
+	ds.trafficLock.Lock()
+	defer ds.trafficLock.Unlock()
+
+	delete(ds.instanceTraffic, instanceID)
+
+	for key := range ds.cnciTraffic {
+		if key.concentratorID == instanceID {
+			delete(ds.cnciTraffic, key)
+		}
+	}
+}
+
+// GetTenantTraffic returns the bytes received from and sent to the external
+// network by the instances of a tenant between start and end, with the
+// precision of the tenant usage history.
+func (ds *Datastore) GetTenantTraffic(tenantID string, start time.Time, end time.Time) (uint64, uint64) {
+	history, err := ds.tenantUsageHistory(tenantID, usageEpoch, end.Add(time.Nanosecond))
+	if err != nil {
+		glog.Warningf("Unable to retrieve usage of tenant %s: %v", tenantID, err)
+		return 0, 0
+	}
+
+	// The network counters of the history start again from 0 when the
+	// controller restarts.
+	var prev types.CiaoUsage
+	var rx, tx uint64
+	for _, u := range history {
+		if !u.Timestamp.Before(start) {
+			rx += counterDelta(prev.NetworkRxBytes, u.NetworkRxBytes)
+			tx += counterDelta(prev.NetworkTxBytes, u.NetworkTxBytes)
+		}
+		prev = u
+	}
+
+	return rx, tx
+}
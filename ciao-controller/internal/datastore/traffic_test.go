@@ -0,0 +1,159 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestTrafficReport(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	instances, err := addTestInstances(tenant, wls[0], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnci := &types.Instance{
+		TenantID:  tenant.ID,
+		State:     payloads.Running,
+		ID:        uuid.Generate().String(),
+		CNCI:      true,
+		IPAddress: "192.168.0.1",
+	}
+	err = ds.AddInstance(cnci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+
+	report := func(rx, tx uint64) payloads.TrafficReportEvent {
+		return payloads.TrafficReportEvent{
+			ConcentratorUUID: cnci.ID,
+			TenantUUID:       tenant.ID,
+			Counters: []payloads.TrafficCounter{
+				{
+					PrivateIP: instances[0].IPAddress,
+					RxBytes:   rx,
+					RxPackets: rx / 100,
+					TxBytes:   tx,
+					TxPackets: tx / 100,
+				},
+				{
+					PrivateIP: "172.31.255.254",
+					RxBytes:   100,
+					RxPackets: 1,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		rx, tx     uint64
+		instanceRx uint64
+		instanceTx uint64
+		tenantRx   uint64
+	}{
+		{1000, 500, 1000, 500, 1100},
+		{3000, 600, 3000, 600, 3100},
+		// CNCI restarted, counters start again from 0
+		{400, 0, 3400, 600, 3500},
+	}
+
+	for _, test := range tests {
+		err = ds.AddTrafficReport(report(test.rx, test.tx))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		traffic := ds.GetInstanceTraffic(instances[0].ID)
+		if traffic == nil || traffic.RxBytes != test.instanceRx || traffic.TxBytes != test.instanceTx ||
+			traffic.RxPackets != test.instanceRx/100 {
+			t.Fatalf("Expected %d/%d bytes of instance traffic, got %+v",
+				test.instanceRx, test.instanceTx, traffic)
+		}
+
+		rx, tx := ds.GetTenantTraffic(tenant.ID, start, time.Now())
+		if rx != test.tenantRx || tx != test.instanceTx {
+			t.Fatalf("Expected %d/%d bytes of tenant traffic, got %d/%d",
+				test.tenantRx, test.instanceTx, rx, tx)
+		}
+	}
+
+	if ds.GetInstanceTraffic(instances[1].ID) != nil {
+		t.Fatal("Traffic accounted to an idle instance")
+	}
+
+	usage, err := ds.GetTenantUsage(tenant.ID, start, time.Now())
+	if err != nil || len(usage) == 0 {
+		t.Fatalf("No tenant usage: %v", err)
+	}
+
+	if last := usage[len(usage)-1]; last.NetworkRxBytes != 3500 || last.NetworkTxBytes != 600 {
+		t.Fatalf("Unexpected tenant usage %+v", last)
+	}
+
+	rx, tx := ds.GetTenantTraffic(tenant.ID, time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	if rx != 0 || tx != 0 {
+		t.Fatalf("Unexpected tenant traffic after the last report: %d/%d", rx, tx)
+	}
+
+	bad := report(0, 0)
+	bad.ConcentratorUUID = instances[1].ID
+	if err = ds.AddTrafficReport(bad); err == nil {
+		t.Fatal("Traffic report from an instance accepted")
+	}
+
+	bad.ConcentratorUUID = uuid.Generate().String()
+	if err = ds.AddTrafficReport(bad); err == nil {
+		t.Fatal("Traffic report from an unknown CNCI accepted")
+	}
+
+	_, err = ds.deleteInstance(instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ds.GetInstanceTraffic(instances[0].ID) != nil {
+		t.Fatal("Traffic of deleted instance not removed")
+	}
+
+	_, err = ds.deleteInstance(cnci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.trafficLock.Lock()
+	for key := range ds.cnciTraffic {
+		if key.concentratorID == cnci.ID {
+			t.Errorf("Counters of deleted CNCI not removed: %v", key)
+		}
+	}
+	ds.trafficLock.Unlock()
+}
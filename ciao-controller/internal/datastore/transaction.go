@@ -0,0 +1,61 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// persistentTx is a transaction on a persistent store, started by
+// beginTx.  Its writes are stored atomically by commitTx, and are not
+// visible to the readers of the store until then.  They are all discarded
+// if commitTx fails or if rollbackTx is called instead.  The store may be
+// locked while the transaction is in progress, so it must not be used
+// until the transaction ends.
+type persistentTx interface {
+	deleteInstance(instanceID string) error
+	updateInstance(instance *types.Instance) error
+	releaseTenantIP(tenantID string, subnetInt uint32, rest uint32) error
+	updateBlockData(data types.Volume) error
+	addStorageAttachment(a types.StorageAttachment) error
+	deleteStorageAttachment(ID string) error
+	addDeletedInstance(d types.DeletedInstance) error
+
+	commitTx() error
+	rollbackTx() error
+}
+
+// transaction carries out the writes of a composite operation in a
+// transaction of the persistent store, which is committed if writes
+// succeeds and rolled back otherwise.  Either all the writes are stored or
+// none is.  The caches of the datastore must only be updated once
+// transaction succeeds.
+func (ds *Datastore) transaction(writes func(tx persistentTx) error) error {
+	tx, err := ds.db.beginTx()
+	if err != nil {
+		return errors.Wrap(err, "error starting datastore transaction")
+	}
+
+	if err := writes(tx); err != nil {
+		if rerr := tx.rollbackTx(); rerr != nil {
+			glog.Errorf("Error rolling back datastore transaction: %v", rerr)
+		}
+		return err
+	}
+
+	return errors.Wrap(tx.commitTx(), "error committing datastore transaction")
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingStore is a persistent store failing to delete storage
+// attachments, to interrupt composite operations halfway.
+type failingStore struct {
+	persistentStore
+}
+
+type failingTx struct {
+	persistentTx
+}
+
+func (db failingStore) deleteStorageAttachment(ID string) error {
+	return errInjected
+}
+
+func (db failingStore) beginTx() (persistentTx, error) {
+	tx, err := db.persistentStore.beginTx()
+	return failingTx{tx}, err
+}
+
+func (tx failingTx) deleteStorageAttachment(ID string) error {
+	return errInjected
+}
+
+// testStoreTransaction checks that the writes of a transaction are only
+// stored once it is committed.
+func testStoreTransaction(t *testing.T, ps persistentStore) {
+	volume := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+		State:       types.Available,
+		TenantID:    uuid.Generate().String(),
+		CreateTime:  time.Now(),
+	}
+	if err := ps.addBlockData(volume); err != nil {
+		t.Fatal(err)
+	}
+
+	a := types.StorageAttachment{
+		ID:         uuid.Generate().String(),
+		InstanceID: uuid.Generate().String(),
+		BlockID:    volume.ID,
+	}
+
+	write := func(commit bool) {
+		tx, err := ps.beginTx()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inUse := volume
+		inUse.State = types.InUse
+		if err := tx.addStorageAttachment(a); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.updateBlockData(inUse); err != nil {
+			t.Fatal(err)
+		}
+
+		if commit {
+			err = tx.commitTx()
+		} else {
+			err = tx.rollbackTx()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored := func() (bool, types.BlockState) {
+		attachments, err := ps.getAllStorageAttachments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumes, err := ps.getAllBlockData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := attachments[a.ID]
+		return ok, volumes[volume.ID].State
+	}
+
+	write(false)
+	if attached, state := stored(); attached || state != types.Available {
+		t.Fatalf("Rolled back writes stored: attachment %v, volume %s", attached, state)
+	}
+
+	write(true)
+	if attached, state := stored(); !attached || state != types.InUse {
+		t.Fatalf("Committed writes not stored: attachment %v, volume %s", attached, state)
+	}
+
+	tx, err := ps.beginTx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	volume.State = types.Detaching
+	if err := tx.updateBlockData(volume); err != nil {
+		t.Fatal(err)
+	}
+	err = tx.addStorageAttachment(a)
+	if err == nil {
+		err = tx.commitTx()
+	} else if rerr := tx.rollbackTx(); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if err == nil {
+		t.Fatal("Expected the creation of an existing attachment to fail")
+	}
+	if _, state := stored(); state != types.InUse {
+		t.Fatalf("Failed transaction stored volume %s", state)
+	}
+}
+
+func TestMemoryDBTransaction(t *testing.T) {
+	db := &MemoryDB{}
+	if err := db.init(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.disconnect()
+
+	testStoreTransaction(t, db)
+}
+
+func TestSqliteDBTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ps, err := openStore(migrationConfig(dir, "transaction"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.disconnect()
+
+	testStoreTransaction(t, ps)
+}
+
+func TestEtcdDBTransaction(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+
+	ps, err := openStore(etcdConfig(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.disconnect()
+
+	testStoreTransaction(t, ps)
+}
+
+func TestDeleteInstanceRollback(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	instance, err := addInstance(tenant, wls[0], "rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+		State:       types.Available,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	if err := ds.AddBlockDevice(data); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ds.CreateStorageAttachment(instance.ID, payloads.StorageResource{ID: data.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memDB := ds.db.(*MemoryDB)
+	ds.db = failingStore{memDB}
+
+	err = ds.DeleteInstance(instance.ID)
+
+	ds.db = memDB
+
+	if err == nil {
+		t.Fatal("Expected instance deletion to fail")
+	}
+
+	if _, err := ds.GetInstance(instance.ID); err != nil {
+		t.Fatalf("Instance removed from the cache: %v", err)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "rollback"); ID != instance.ID {
+		t.Fatal("Instance name released")
+	}
+
+	if _, err := ds.getStorageAttachment(instance.ID, data.ID); err != nil {
+		t.Fatalf("Storage attachment removed from the cache: %v", err)
+	}
+
+	if bd, _ := ds.GetBlockDevice(data.ID); bd.State != types.InUse {
+		t.Fatalf("Expected volume %s, got %s", types.InUse, bd.State)
+	}
+
+	addr, _, err := ds.tenantIPAddr(tenant.ID, instance.IPAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memDB.lock.Lock()
+	_, stored := memDB.instances[instance.ID]
+	_, attached := memDB.attachments[a.ID]
+	state := memDB.blockDevices[data.ID].State
+	claimed := memDB.tenantNetwork[tenant.ID][addr.subnet][addr.host]
+	memDB.lock.Unlock()
+
+	if !stored || !attached || state != types.InUse || !claimed {
+		t.Fatalf("Deletion not rolled back: instance %v, attachment %v, volume %s, IP claimed %v",
+			stored, attached, state, claimed)
+	}
+
+	if err := ds.DeleteInstance(instance.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if bd, _ := ds.GetBlockDevice(data.ID); bd.State != types.Available {
+		t.Fatalf("Expected volume %s, got %s", types.Available, bd.State)
+	}
+
+	memDB.lock.Lock()
+	_, stored = memDB.instances[instance.ID]
+	_, attached = memDB.attachments[a.ID]
+	claimed = memDB.tenantNetwork[tenant.ID][addr.subnet][addr.host]
+	memDB.lock.Unlock()
+
+	if stored || attached || claimed {
+		t.Fatalf("Instance not deleted: instance %v, attachment %v, IP claimed %v", stored, attached, claimed)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"net"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// TransferInstance moves an instance to another tenant, along with the
+// volumes attached to it.  The instance is given ip, an address of the
+// network of the new tenant already claimed with AllocateTenantIP, and its
+// address in the network of its former tenant is released.  Either all
+// these updates are stored or none is.
+func (ds *Datastore) TransferInstance(instanceID string, tenantID string, ip net.IP) error {
+	ds.instancesLock.RLock()
+	i, ok := ds.instances[instanceID]
+	ds.instancesLock.RUnlock()
+
+	if !ok {
+		return types.ErrInstanceNotFound
+	}
+
+	from := i.TenantID
+	if from == tenantID {
+		return types.ErrBadRequest
+	}
+
+	ds.tenantsLock.Lock()
+	to := ds.tenants[tenantID]
+	if to == nil {
+		ds.tenantsLock.Unlock()
+		return types.ErrTenantNotFound
+	}
+	subnetBits := to.SubnetBits
+	err := to.indexName(i.ID, i.Name)
+	ds.tenantsLock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	var addr *tenantIP
+	oldAddr, oldNet, err := ds.tenantIPAddr(from, i.IPAddress)
+	if err != nil {
+		glog.Warningf("error releasing IP for instance (%v): %v", i.ID, err)
+	} else {
+		addr = &oldAddr
+	}
+
+	mask := net.CIDRMask(subnetBits, 32)
+	ipNet := net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+
+	volumes, err := ds.transferInstanceData(i, tenantID, ip, ipNet, addr)
+	if err != nil {
+		ds.tenantsLock.Lock()
+		to.unindexName(i.ID)
+		ds.tenantsLock.Unlock()
+		return err
+	}
+
+	ds.tenantsLock.Lock()
+	if t := ds.tenants[from]; t != nil {
+		delete(t.instances, i.ID)
+		t.unindexName(i.ID)
+
+		for _, bd := range volumes {
+			delete(t.devices, bd.ID)
+		}
+	}
+	to.instances[i.ID] = i
+	ds.tenantsLock.Unlock()
+
+	ds.instanceLastStatLock.Lock()
+	if stat, ok := ds.instanceLastStat[i.ID]; ok {
+		stat.TenantID = tenantID
+		ds.instanceLastStat[i.ID] = stat
+	}
+	ds.instanceLastStatLock.Unlock()
+
+	for _, bd := range volumes {
+		ds.blockDeviceStored(bd, true)
+	}
+
+	if addr != nil {
+		ds.tenantIPReleased(from, *addr, oldNet)
+	}
+
+	ds.summaries.removeInstance(i.ID)
+	ds.summaryAddInstance(i)
+
+	ds.bumpRevision(types.InstancesRevision)
+	ds.instanceChanged(types.WatchDeleted, i.ID, from, "")
+	ds.instanceChanged(types.WatchAdded, i.ID, tenantID, i.State)
+
+	return nil
+}
+
+// transferInstanceData stores an instance, and the volumes attached to
+// it, as belonging to another tenant, releasing the address of the
+// instance in the network of its former tenant unless addr is nil.  The
+// instance is only updated once all these writes are stored.  The updated
+// volumes are returned.
+func (ds *Datastore) transferInstanceData(i *types.Instance, tenantID string, ip net.IP, ipNet net.IPNet,
+	addr *tenantIP) ([]types.Volume, error) {
+	ds.attachLock.Lock()
+	defer ds.attachLock.Unlock()
+
+	updated := types.Instance{
+		ID:         i.ID,
+		TenantID:   tenantID,
+		IPAddress:  ip.String(),
+		MACAddress: utils.NewTenantHardwareAddr(ip).String(),
+		Subnet:     ipNet.String(),
+		Name:       i.Name,
+		TraceLabel: i.TraceLabel,
+		Protected:  i.Protected,
+		Warm:       i.Warm,
+		ExtraVCPUs: i.ExtraVCPUs,
+		ExtraMemMB: i.ExtraMemMB,
+	}
+
+	var volumes []types.Volume
+
+	for _, ID := range ds.instanceVolumes {
+		a := ds.attachments[ID]
+		if a.InstanceID != i.ID {
+			continue
+		}
+
+		bd, err := ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			glog.Warningf("error fetching block device (%v): %v", a.BlockID, err)
+			continue
+		}
+
+		bd.TenantID = tenantID
+		volumes = append(volumes, bd)
+	}
+
+	err := ds.transaction(func(tx persistentTx) error {
+		err := tx.updateInstance(&updated)
+		if err != nil {
+			return errors.Wrapf(err, "error updating instance (%v)", i.ID)
+		}
+
+		if addr != nil {
+			err = tx.releaseTenantIP(i.TenantID, addr.subnet, addr.host)
+			if err != nil {
+				return errors.Wrapf(err, "error releasing IP for instance (%v)", i.ID)
+			}
+		}
+
+		for _, bd := range volumes {
+			err = tx.updateBlockData(bd)
+			if err != nil {
+				return errors.Wrapf(err, "error updating block device (%v)", bd.ID)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	i.TenantID = updated.TenantID
+	i.IPAddress, i.MACAddress, i.Subnet = updated.IPAddress, updated.MACAddress, updated.Subnet
+
+	return volumes, nil
+}
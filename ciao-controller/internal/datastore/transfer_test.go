@@ -0,0 +1,236 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// failingVolumeStore is a persistent store failing to update volumes.
+type failingVolumeStore struct {
+	persistentStore
+}
+
+func (db failingVolumeStore) updateBlockData(data types.Volume) error {
+	return errInjected
+}
+
+type failingVolumeTx struct {
+	persistentTx
+}
+
+func (db failingVolumeStore) beginTx() (persistentTx, error) {
+	tx, err := db.persistentStore.beginTx()
+	return failingVolumeTx{tx}, err
+}
+
+func (tx failingVolumeTx) updateBlockData(data types.Volume) error {
+	return errInjected
+}
+
+// tenantIPClaim returns whether an address is claimed in the network of a
+// tenant, and the instance holding it.
+func tenantIPClaim(t *testing.T, tenantID string, address string) (bool, string) {
+	ips, err := ds.GetTenantIPs(tenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range ips {
+		if ip.Address == address {
+			return true, ip.InstanceID
+		}
+	}
+
+	return false, ""
+}
+
+func addTransferInstance(t *testing.T) (*types.Tenant, *types.Instance, types.Volume) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	instance, err := addInstance(tenant, wls[0], "transferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String(), Size: 10},
+		State:       types.Available,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	if err := ds.AddBlockDevice(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ds.CreateStorageAttachment(instance.ID, payloads.StorageResource{ID: data.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	return tenant, instance, data
+}
+
+func TestTransferInstance(t *testing.T) {
+	from, instance, data := addTransferInstance(t)
+
+	to, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldIP := instance.IPAddress
+
+	ip, err := ds.AllocateTenantIP(to.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.TransferInstance(instance.ID, from.ID, ip); err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+
+	if err := ds.TransferInstance(instance.ID, to.ID, ip); err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := ds.GetTenantInstance(to.ID, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.TenantID != to.ID || i.IPAddress != ip.String() {
+		t.Fatalf("Instance not transferred: tenant %s, address %s", i.TenantID, i.IPAddress)
+	}
+
+	if _, err := ds.GetTenantInstance(from.ID, instance.ID); err == nil {
+		t.Fatalf("Instance %s still in tenant %s", instance.ID, from.ID)
+	}
+
+	if ID, _ := ds.ResolveInstance(to.ID, "transferred"); ID != instance.ID {
+		t.Fatal("Instance name not indexed in the new tenant")
+	}
+
+	if ID, _ := ds.ResolveInstance(from.ID, "transferred"); ID != "" {
+		t.Fatal("Instance name still indexed in the former tenant")
+	}
+
+	if claimed, _ := tenantIPClaim(t, from.ID, oldIP); claimed {
+		t.Fatalf("Address %s not released", oldIP)
+	}
+
+	if _, owner := tenantIPClaim(t, to.ID, ip.String()); owner != instance.ID {
+		t.Fatalf("Address %s not held by instance %s", ip, instance.ID)
+	}
+
+	bd, err := ds.GetBlockDevice(data.ID)
+	if err != nil || bd.TenantID != to.ID {
+		t.Fatalf("Volume %s not transferred: %v", data.ID, err)
+	}
+
+	devices, err := ds.GetBlockDevices(to.ID)
+	if err != nil || len(devices) != 1 || devices[0].ID != data.ID {
+		t.Fatalf("Volume %s not in the devices of tenant %s: %v", data.ID, to.ID, err)
+	}
+
+	summary, err := ds.GetTenantResourceSummary(to.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.TotalInstances != 1 || summary.TotalVolumes != 1 || summary.VolumeSizeGB != data.Size {
+		t.Fatalf("Unexpected summary of tenant %s: %+v", to.ID, summary)
+	}
+
+	memDB := ds.db.(*MemoryDB)
+	memDB.lock.Lock()
+	stored := memDB.instances[instance.ID].tenantID
+	storedVolume := memDB.blockDevices[data.ID].TenantID
+	memDB.lock.Unlock()
+
+	if stored != to.ID || storedVolume != to.ID {
+		t.Fatalf("Transfer not stored: instance tenant %s, volume tenant %s", stored, storedVolume)
+	}
+}
+
+func TestTransferInstanceRollback(t *testing.T) {
+	from, instance, data := addTransferInstance(t)
+
+	to, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldIP := instance.IPAddress
+
+	ip, err := ds.AllocateTenantIP(to.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memDB := ds.db.(*MemoryDB)
+	ds.db = failingVolumeStore{memDB}
+
+	err = ds.TransferInstance(instance.ID, to.ID, ip)
+
+	ds.db = memDB
+
+	if err == nil {
+		t.Fatal("Expected instance transfer to fail")
+	}
+
+	i, err := ds.GetTenantInstance(from.ID, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.TenantID != from.ID || i.IPAddress != oldIP {
+		t.Fatalf("Instance not restored: tenant %s, address %s", i.TenantID, i.IPAddress)
+	}
+
+	if ID, _ := ds.ResolveInstance(to.ID, "transferred"); ID != "" {
+		t.Fatal("Instance name left indexed in the new tenant")
+	}
+
+	if _, owner := tenantIPClaim(t, from.ID, oldIP); owner != instance.ID {
+		t.Fatalf("Address %s released", oldIP)
+	}
+
+	if bd, _ := ds.GetBlockDevice(data.ID); bd.TenantID != from.ID {
+		t.Fatalf("Volume %s transferred", data.ID)
+	}
+
+	memDB.lock.Lock()
+	stored := memDB.instances[instance.ID]
+	memDB.lock.Unlock()
+
+	if stored.tenantID != from.ID || stored.ipAddress != oldIP {
+		t.Fatalf("Transfer not rolled back: tenant %s, address %s", stored.tenantID, stored.ipAddress)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// usageEpoch precedes all the samples of the tenant usage histories.
+var usageEpoch = time.Unix(0, 0)
+
+// usageTier describes the samples of a resolution of the tenant usage
+// histories, each of which covers interval.
+type usageTier struct {
+	resolution types.UsageResolution
+	interval   time.Duration
+}
+
+// usageTiers lists the resolutions of the tenant usage histories, the
+// coarsest first.
+var usageTiers = []usageTier{
+	{types.UsageDaily, 24 * time.Hour},
+	{types.UsageHourly, time.Hour},
+	{types.UsageRaw, time.Nanosecond},
+}
+
+// tenantUsageHistory returns the usage history of a tenant between start and
+// end, oldest first.  The samples stored at each resolution only complete
+// those of the coarser resolutions, and the entries kept in memory those
+// which have been stored.
+func (ds *Datastore) tenantUsageHistory(tenantID string, start time.Time, end time.Time) ([]types.CiaoUsage, error) {
+	var history []types.CiaoUsage
+
+	from := start
+	for _, t := range usageTiers {
+		samples, err := ds.db.getTenantUsageReport(tenantID, t.resolution, from, end)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error retrieving %s usage of tenant %s", t.resolution, tenantID)
+		}
+
+		if len(samples) > 0 {
+			history = append(history, samples...)
+			from = samples[len(samples)-1].Timestamp.Add(t.interval)
+		}
+	}
+
+	ds.tenantUsageLock.RLock()
+	defer ds.tenantUsageLock.RUnlock()
+
+	for _, u := range ds.tenantUsage[tenantID] {
+		if !u.Timestamp.Before(from) && u.Timestamp.Before(end) {
+			history = append(history, u)
+		}
+	}
+
+	return history, nil
+}
+
+// aggregateUsage groups consecutive samples by the bucket returned for their
+// timestamps and replaces each group by a single sample timestamped with the
+// start of its bucket.  Resource usage is averaged over the group, each
+// sample weighing the time until the next one, as usage only changes when a
+// new sample is taken.  The last sample weighs the interval preceding it.
+// The network counters, which are cumulative, are those of the last sample
+// of the group.
+func aggregateUsage(samples []types.CiaoUsage, bucket func(time.Time) time.Time) []types.CiaoUsage {
+	var aggregated []types.CiaoUsage
+
+	weight := func(i int) float64 {
+		switch {
+		case i+1 < len(samples):
+			return samples[i+1].Timestamp.Sub(samples[i].Timestamp).Seconds()
+		case i > 0:
+			return samples[i].Timestamp.Sub(samples[i-1].Timestamp).Seconds()
+		}
+		return 0
+	}
+
+	for i := 0; i < len(samples); {
+		ts := bucket(samples[i].Timestamp)
+
+		var vcpu, memory, disk, total float64
+		j := i
+		for ; j < len(samples) && bucket(samples[j].Timestamp).Equal(ts); j++ {
+			w := weight(j)
+			vcpu += w * float64(samples[j].VCPU)
+			memory += w * float64(samples[j].Memory)
+			disk += w * float64(samples[j].Disk)
+			total += w
+		}
+
+		// Samples taken at the same time all weigh the same.
+		if total <= 0 {
+			vcpu, memory, disk = 0, 0, 0
+			for k := i; k < j; k++ {
+				vcpu += float64(samples[k].VCPU)
+				memory += float64(samples[k].Memory)
+				disk += float64(samples[k].Disk)
+			}
+			total = float64(j - i)
+		}
+
+		aggregated = append(aggregated, types.CiaoUsage{
+			VCPU:           int(vcpu / total),
+			Memory:         int(memory / total),
+			Disk:           int(disk / total),
+			NetworkRxBytes: samples[j-1].NetworkRxBytes,
+			NetworkTxBytes: samples[j-1].NetworkTxBytes,
+			Timestamp:      ts,
+		})
+		i = j
+	}
+
+	return aggregated
+}
+
+// downsampleUsage aggregates a usage history into at most max samples
+// covering periods of equal length.
+func downsampleUsage(history []types.CiaoUsage, max int) []types.CiaoUsage {
+	first := history[0].Timestamp
+	span := history[len(history)-1].Timestamp.Sub(first)
+	width := span/time.Duration(max) + 1
+
+	return aggregateUsage(history, func(t time.Time) time.Time {
+		return first.Add(t.Sub(first) / width * width)
+	})
+}
+
+// RollUpTenantUsage aggregates the samples of the tenant usage histories
+// older than tenant_usage_raw_retention_days into hourly samples, and the
+// hourly samples older than tenant_usage_hourly_retention_days into daily
+// samples.  It returns the number of samples which have been aggregated.
+func (ds *Datastore) RollUpTenantUsage(now time.Time) (int, error) {
+	day := 24 * time.Hour
+
+	rawRetention := time.Duration(ds.configInt(TenantUsageRawRetentionDays)) * day
+	rawCutoff := now.Add(-rawRetention).Truncate(time.Hour)
+
+	// A day is only rolled up once all its raw samples have been.
+	hourlyRetention := time.Duration(ds.configInt(TenantUsageHourlyRetentionDays)) * day
+	hourlyCutoff := now.Add(-hourlyRetention).Truncate(day)
+	if rawDay := rawCutoff.Truncate(day); rawDay.Before(hourlyCutoff) {
+		hourlyCutoff = rawDay
+	}
+
+	ds.tenantsLock.RLock()
+	tenantIDs := make([]string, 0, len(ds.tenants))
+	for ID := range ds.tenants {
+		tenantIDs = append(tenantIDs, ID)
+	}
+	ds.tenantsLock.RUnlock()
+
+	total := 0
+	for _, ID := range tenantIDs {
+		n, err := ds.rollUpTenantUsage(ID, usageTiers[2], usageTiers[1], rawCutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+
+		n, err = ds.rollUpTenantUsage(ID, usageTiers[1], usageTiers[0], hourlyCutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	return total, nil
+}
+
+// rollUpTenantUsage aggregates the samples of a tenant older than cutoff
+// from one resolution into a coarser one.
+func (ds *Datastore) rollUpTenantUsage(tenantID string, from usageTier, to usageTier, cutoff time.Time) (int, error) {
+	samples, err := ds.db.getTenantUsage(tenantID, from.resolution, usageEpoch, cutoff)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error retrieving %s usage of tenant %s", from.resolution, tenantID)
+	}
+
+	if len(samples) == 0 {
+		return 0, nil
+	}
+
+	aggregated := aggregateUsage(samples, func(t time.Time) time.Time {
+		return t.Truncate(to.interval).UTC()
+	})
+	for _, u := range aggregated {
+		err := ds.db.updateTenantUsage(types.TenantUsageSample{
+			TenantID:   tenantID,
+			Resolution: to.resolution,
+			CiaoUsage:  u,
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "error storing %s usage of tenant %s", to.resolution, tenantID)
+		}
+	}
+
+	err = ds.db.deleteTenantUsage(tenantID, from.resolution, cutoff)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error deleting %s usage of tenant %s", from.resolution, tenantID)
+	}
+
+	return len(samples), nil
+}
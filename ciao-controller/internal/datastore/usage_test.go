@@ -0,0 +1,237 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func addTestUsage(t *testing.T, tenantID string, resolution types.UsageResolution, usage ...types.CiaoUsage) {
+	for _, u := range usage {
+		err := ds.db.updateTenantUsage(types.TenantUsageSample{
+			TenantID:   tenantID,
+			Resolution: resolution,
+			CiaoUsage:  u,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPersistTenantUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := time.Now().Add(-time.Hour)
+
+	ds.tenantUsageLock.Lock()
+	ds.tenantUsage[tenant.ID] = []types.CiaoUsage{{VCPU: 1, Memory: 128, Timestamp: closed}}
+	ds.tenantUsageLock.Unlock()
+
+	ds.updateTenantUsage(types.CiaoUsage{VCPU: 1, Memory: 128}, tenant.ID)
+
+	stored, err := ds.db.getTenantUsage(tenant.ID, types.UsageRaw, usageEpoch, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].VCPU != 1 || !stored[0].Timestamp.Equal(closed) {
+		t.Fatalf("Closed usage entry not stored: %+v", stored)
+	}
+
+	ds.tenantUsageLock.RLock()
+	inMemory := len(ds.tenantUsage[tenant.ID])
+	ds.tenantUsageLock.RUnlock()
+	if inMemory != 1 {
+		t.Fatalf("Expected 1 usage entry in memory, got %d", inMemory)
+	}
+
+	usage, err := ds.GetTenantUsage(tenant.ID, usageEpoch, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 || usage[0].VCPU != 1 || usage[1].VCPU != 2 {
+		t.Fatalf("Unexpected tenant usage %+v", usage)
+	}
+}
+
+func TestFlushTenantUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.updateTenantUsage(types.CiaoUsage{VCPU: 3, Memory: 256}, tenant.ID)
+	ds.flushTenantUsage()
+
+	stored, err := ds.db.getTenantUsage(tenant.ID, types.UsageRaw, usageEpoch, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].VCPU != 3 || stored[0].Memory != 256 {
+		t.Fatalf("Last usage entry not stored: %+v", stored)
+	}
+}
+
+func TestAggregateUsageWeighted(t *testing.T) {
+	start := time.Now().Truncate(time.Hour)
+	samples := []types.CiaoUsage{
+		{VCPU: 10, Timestamp: start},
+		{VCPU: 0, Timestamp: start.Add(50 * time.Minute)},
+		{VCPU: 4, Timestamp: start.Add(60 * time.Minute)},
+		{VCPU: 8, Timestamp: start.Add(65 * time.Minute)},
+		{VCPU: 2, Timestamp: start.Add(3 * time.Hour)},
+		{VCPU: 6, Timestamp: start.Add(3 * time.Hour)},
+	}
+
+	aggregated := aggregateUsage(samples, func(t time.Time) time.Time {
+		return t.Truncate(time.Hour)
+	})
+
+	// 50 minutes at 10 and 10 at 0, 5 minutes at 4 and 115 at 8, and
+	// samples taken at the same time at the end.
+	expected := []int{8, 7, 4}
+	if len(aggregated) != len(expected) {
+		t.Fatalf("Expected %d samples, got %+v", len(expected), aggregated)
+	}
+	for i, vcpu := range expected {
+		if aggregated[i].VCPU != vcpu {
+			t.Errorf("Expected %d vCPUs in sample %d, got %d", vcpu, i, aggregated[i].VCPU)
+		}
+	}
+}
+
+func TestRollUpTenantUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	day := 24 * time.Hour
+	hour := now.Add(-10 * day).Truncate(time.Hour)
+	recent := now.Add(-time.Hour)
+
+	addTestUsage(t, tenant.ID, types.UsageRaw,
+		types.CiaoUsage{VCPU: 2, NetworkRxBytes: 100, Timestamp: hour.Add(10 * time.Minute)},
+		types.CiaoUsage{VCPU: 4, NetworkRxBytes: 300, Timestamp: hour.Add(20 * time.Minute)},
+		types.CiaoUsage{VCPU: 8, NetworkRxBytes: 700, Timestamp: recent})
+
+	old := now.Add(-100 * day).Truncate(day)
+	addTestUsage(t, tenant.ID, types.UsageHourly,
+		types.CiaoUsage{Memory: 100, Timestamp: old.Add(time.Hour)},
+		types.CiaoUsage{Memory: 300, Timestamp: old.Add(2 * time.Hour)})
+
+	count, err := ds.RollUpTenantUsage(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 4 {
+		t.Fatalf("Expected at least 4 samples rolled up, got %d", count)
+	}
+
+	usage, err := ds.GetTenantUsage(tenant.ID, usageEpoch, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.CiaoUsage{
+		{Memory: 200, Timestamp: old},
+		{VCPU: 3, NetworkRxBytes: 300, Timestamp: hour},
+		{VCPU: 8, NetworkRxBytes: 700, Timestamp: recent},
+	}
+	if len(usage) != len(expected) {
+		t.Fatalf("Expected %d samples, got %+v", len(expected), usage)
+	}
+	for i := range expected {
+		u, e := usage[i], expected[i]
+		if u.VCPU != e.VCPU || u.Memory != e.Memory || u.NetworkRxBytes != e.NetworkRxBytes ||
+			!u.Timestamp.Equal(e.Timestamp) {
+			t.Fatalf("Expected sample %+v, got %+v", e, u)
+		}
+	}
+
+	raw, err := ds.db.getTenantUsage(tenant.ID, types.UsageRaw, usageEpoch, now)
+	if err != nil || len(raw) != 1 {
+		t.Fatalf("Expected 1 raw sample left, got %d: %v", len(raw), err)
+	}
+
+	rx, _ := ds.GetTenantTraffic(tenant.ID, hour.Add(time.Hour), now)
+	if rx != 400 {
+		t.Fatalf("Expected 400 bytes of traffic, got %d", rx)
+	}
+}
+
+func TestDownsampleTenantUsage(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.SetConfigSetting(MaxTenantUsageSamples, "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.SetConfigSetting(MaxTenantUsageSamples, "") }()
+
+	start := time.Now().Add(-2 * time.Hour).UTC()
+	for i := 0; i < 100; i++ {
+		addTestUsage(t, tenant.ID, types.UsageRaw, types.CiaoUsage{
+			VCPU:           i,
+			NetworkRxBytes: uint64(i),
+			Timestamp:      start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	usage, err := ds.GetTenantUsage(tenant.ID, start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) == 0 || len(usage) > 10 {
+		t.Fatalf("Expected at most 10 samples, got %d", len(usage))
+	}
+
+	if !usage[0].Timestamp.Equal(start) || usage[0].VCPU != 4 {
+		t.Fatalf("Unexpected first sample %+v", usage[0])
+	}
+
+	if last := usage[len(usage)-1]; last.NetworkRxBytes != 99 {
+		t.Fatalf("Unexpected last sample %+v", last)
+	}
+}
+
+func TestTenantTrafficReset(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour).UTC()
+	addTestUsage(t, tenant.ID, types.UsageRaw,
+		types.CiaoUsage{NetworkRxBytes: 100, NetworkTxBytes: 10, Timestamp: start.Add(-time.Minute)},
+		types.CiaoUsage{NetworkRxBytes: 300, NetworkTxBytes: 30, Timestamp: start.Add(time.Minute)},
+		// controller restarted, counters start again from 0
+		types.CiaoUsage{NetworkRxBytes: 50, NetworkTxBytes: 5, Timestamp: start.Add(2 * time.Minute)})
+
+	rx, tx := ds.GetTenantTraffic(tenant.ID, start, time.Now())
+	if rx != 250 || tx != 25 {
+		t.Fatalf("Expected 250/25 bytes of traffic, got %d/%d", rx, tx)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Resources of the inconsistencies reported by Verify.
+const (
+	verifyInstance   = "instance"
+	verifyAttachment = "attachment"
+	verifyMappedIP   = "mapped_ip"
+)
+
+// Verify cross-checks the caches of the datastore with the persistent
+// store, and looks for instances of tenants which do not exist, storage
+// attachments of instances or volumes which do not exist and mapped
+// external IPs of pools which do not exist.
+//
+// When repair is set, the persistent store is taken as the reference:
+// objects missing from it are dropped from the caches, objects missing
+// from the caches are cached and orphaned objects are deleted.  Objects
+// updated while Verify runs may be reported inconsistent, so repairs are
+// best made on a quiet cluster.
+func (ds *Datastore) Verify(repair bool) ([]types.Inconsistency, error) {
+	found := []types.Inconsistency{}
+
+	checks := []func(bool) ([]types.Inconsistency, error){
+		ds.verifyInstances,
+		ds.verifyAttachments,
+		ds.verifyMappedIPs,
+	}
+
+	for _, check := range checks {
+		inconsistencies, err := check(repair)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, inconsistencies...)
+	}
+
+	return found, nil
+}
+
+// repaired records the outcome of the repair of an inconsistency.
+func repaired(inc types.Inconsistency, err error) types.Inconsistency {
+	if err != nil {
+		glog.Warningf("Unable to repair %s %s: %v", inc.Resource, inc.ID, err)
+		inc.Error = err.Error()
+		return inc
+	}
+
+	glog.Infof("Repaired %s %s %s: %s", inc.Kind, inc.Resource, inc.ID, inc.Reason)
+	inc.Repaired = true
+	return inc
+}
+
+func (ds *Datastore) verifyInstances(repair bool) ([]types.Inconsistency, error) {
+	var found []types.Inconsistency
+
+	instances, err := ds.db.getInstances()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting instances from database")
+	}
+
+	stored := make(map[string]*types.Instance)
+	for _, i := range instances {
+		stored[i.ID] = i
+	}
+
+	var notStored, notCached []*types.Instance
+
+	ds.instancesLock.RLock()
+	for ID, i := range ds.instances {
+		if _, ok := stored[ID]; !ok {
+			notStored = append(notStored, i)
+		}
+	}
+	for ID, i := range stored {
+		if _, ok := ds.instances[ID]; !ok {
+			notCached = append(notCached, i)
+		}
+	}
+	ds.instancesLock.RUnlock()
+
+	for _, i := range notStored {
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyNotStored,
+			Resource: verifyInstance,
+			ID:       i.ID,
+			Reason:   "instance missing from the persistent store",
+		}
+
+		if repair {
+			ds.instanceDeleted(i)
+			inc = repaired(inc, nil)
+		}
+
+		found = append(found, inc)
+	}
+
+	for _, i := range notCached {
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyNotCached,
+			Resource: verifyInstance,
+			ID:       i.ID,
+			Reason:   "instance missing from the datastore cache",
+		}
+
+		if repair {
+			ds.tenantsLock.Lock()
+			if tenant := ds.tenants[i.TenantID]; tenant != nil {
+				if err := tenant.indexName(i.ID, i.Name); err != nil {
+					glog.Warningf("Instance %s of tenant %s: name %q already in use", i.ID, i.TenantID, i.Name)
+				}
+			}
+			ds.tenantsLock.Unlock()
+
+			ds.instancesCached([]*types.Instance{i})
+			inc = repaired(inc, nil)
+		}
+
+		found = append(found, inc)
+	}
+
+	// the instances cached by the repairs above are checked too
+	var orphaned []*types.Instance
+
+	ds.instancesLock.RLock()
+	ds.tenantsLock.RLock()
+	for _, i := range ds.instances {
+		if _, ok := ds.tenants[i.TenantID]; !ok {
+			orphaned = append(orphaned, i)
+		}
+	}
+	ds.tenantsLock.RUnlock()
+	ds.instancesLock.RUnlock()
+
+	for _, i := range orphaned {
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyOrphaned,
+			Resource: verifyInstance,
+			ID:       i.ID,
+			Reason:   fmt.Sprintf("tenant %s does not exist", i.TenantID),
+		}
+
+		if repair {
+			_, err := ds.deleteInstance(i.ID)
+			inc = repaired(inc, err)
+		}
+
+		found = append(found, inc)
+	}
+
+	return found, nil
+}
+
+func (ds *Datastore) verifyAttachments(repair bool) ([]types.Inconsistency, error) {
+	var found []types.Inconsistency
+
+	stored, err := ds.db.getAllStorageAttachments()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting storage attachments from database")
+	}
+
+	instances := make(map[string]bool)
+
+	ds.instancesLock.RLock()
+	for ID := range ds.instances {
+		instances[ID] = true
+	}
+	ds.instancesLock.RUnlock()
+
+	ds.attachLock.Lock()
+	defer ds.attachLock.Unlock()
+
+	for ID, a := range ds.attachments {
+		if _, ok := stored[ID]; ok {
+			continue
+		}
+
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyNotStored,
+			Resource: verifyAttachment,
+			ID:       ID,
+			Reason:   "storage attachment missing from the persistent store",
+		}
+
+		if repair {
+			delete(ds.attachments, ID)
+			delete(ds.instanceVolumes, attachment{instanceID: a.InstanceID, volumeID: a.BlockID})
+			inc = repaired(inc, nil)
+		}
+
+		found = append(found, inc)
+	}
+
+	for ID, a := range stored {
+		if _, ok := ds.attachments[ID]; ok {
+			continue
+		}
+
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyNotCached,
+			Resource: verifyAttachment,
+			ID:       ID,
+			Reason:   "storage attachment missing from the datastore cache",
+		}
+
+		if repair {
+			ds.attachments[ID] = a
+			ds.instanceVolumes[attachment{instanceID: a.InstanceID, volumeID: a.BlockID}] = ID
+			inc = repaired(inc, nil)
+		}
+
+		found = append(found, inc)
+	}
+
+	for _, a := range ds.attachments {
+		_, err := ds.GetBlockDevice(a.BlockID)
+		volume := err == nil
+
+		var reason string
+		switch {
+		case !instances[a.InstanceID]:
+			reason = fmt.Sprintf("instance %s does not exist", a.InstanceID)
+		case !volume:
+			reason = fmt.Sprintf("volume %s does not exist", a.BlockID)
+		default:
+			continue
+		}
+
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyOrphaned,
+			Resource: verifyAttachment,
+			ID:       a.ID,
+			Reason:   reason,
+		}
+
+		if repair {
+			inc = repaired(inc, ds.deleteOrphanedAttachment(a, volume))
+		}
+
+		found = append(found, inc)
+	}
+
+	return found, nil
+}
+
+// deleteOrphanedAttachment deletes a storage attachment and, if the volume
+// exists and is not attached elsewhere, marks it available.  attachLock
+// must be held.
+func (ds *Datastore) deleteOrphanedAttachment(a types.StorageAttachment, volume bool) error {
+	var volumes []types.Volume
+
+	if volume {
+		attached := false
+		for _, other := range ds.attachments {
+			if other.BlockID == a.BlockID && other.ID != a.ID {
+				attached = true
+				break
+			}
+		}
+
+		bd, err := ds.GetBlockDevice(a.BlockID)
+		if err == nil && !attached && bd.State == types.InUse {
+			bd.State = types.Available
+			volumes = append(volumes, bd)
+		}
+	}
+
+	err := ds.transaction(func(tx persistentTx) error {
+		for _, bd := range volumes {
+			err := tx.updateBlockData(bd)
+			if err != nil {
+				return errors.Wrapf(err, "error updating block device (%v)", bd.ID)
+			}
+		}
+
+		err := tx.deleteStorageAttachment(a.ID)
+		return errors.Wrapf(err, "error deleting storage attachment (%v)", a.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	ds.storageDetached([]types.StorageAttachment{a}, volumes)
+
+	return nil
+}
+
+func (ds *Datastore) verifyMappedIPs(repair bool) ([]types.Inconsistency, error) {
+	var found []types.Inconsistency
+
+	ds.poolsLock.Lock()
+	defer ds.poolsLock.Unlock()
+
+	for address, m := range ds.mappedIPs {
+		if _, ok := ds.pools[m.PoolID]; ok {
+			continue
+		}
+
+		inc := types.Inconsistency{
+			Kind:     types.InconsistencyOrphaned,
+			Resource: verifyMappedIP,
+			ID:       m.ID,
+			Reason:   fmt.Sprintf("pool %s of external IP %s does not exist", m.PoolID, address),
+		}
+
+		if repair {
+			err := ds.db.deleteMappedIP(m.ID)
+			if err == nil {
+				delete(ds.mappedIPs, address)
+			}
+			inc = repaired(inc, errors.Wrap(err, "error deleting IP mapping from database"))
+		}
+
+		found = append(found, inc)
+	}
+
+	return found, nil
+}
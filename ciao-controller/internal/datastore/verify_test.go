@@ -0,0 +1,200 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func findInconsistency(found []types.Inconsistency, kind types.InconsistencyKind, ID string) *types.Inconsistency {
+	for i := range found {
+		if found[i].Kind == kind && found[i].ID == ID {
+			return &found[i]
+		}
+	}
+
+	return nil
+}
+
+func TestVerify(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatalf("No workloads found: %v", err)
+	}
+
+	notStored, err := addInstance(tenant, wls[0], "not-stored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.db.deleteInstance(notStored.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	notCached := &types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: wls[0].ID,
+		ID:         uuid.Generate().String(),
+		Name:       "not-cached",
+		State:      payloads.Pending,
+	}
+
+	if err := ds.db.addInstance(notCached); err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned := &types.Instance{
+		TenantID:   uuid.Generate().String(),
+		WorkloadID: wls[0].ID,
+		ID:         uuid.Generate().String(),
+		State:      payloads.Pending,
+	}
+
+	if err := ds.AddInstance(orphaned); err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.Volume{
+		BlockDevice: storage.BlockDevice{ID: uuid.Generate().String()},
+		State:       types.InUse,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+	}
+
+	if err := ds.AddBlockDevice(data); err != nil {
+		t.Fatal(err)
+	}
+
+	a := types.StorageAttachment{
+		ID:         uuid.Generate().String(),
+		InstanceID: uuid.Generate().String(),
+		BlockID:    data.ID,
+	}
+
+	if err := ds.db.addStorageAttachment(a); err != nil {
+		t.Fatal(err)
+	}
+
+	m := types.MappedIP{
+		ID:         uuid.Generate().String(),
+		ExternalIP: "203.0.113.10",
+		InstanceID: notCached.ID,
+		TenantID:   tenant.ID,
+		PoolID:     uuid.Generate().String(),
+	}
+
+	if err := ds.db.addMappedIP(m); err != nil {
+		t.Fatal(err)
+	}
+
+	ds.poolsLock.Lock()
+	ds.mappedIPs[m.ExternalIP] = m
+	ds.poolsLock.Unlock()
+
+	type inconsistency struct {
+		kind types.InconsistencyKind
+		ID   string
+	}
+
+	expected := []inconsistency{
+		{types.InconsistencyNotStored, notStored.ID},
+		{types.InconsistencyNotCached, notCached.ID},
+		{types.InconsistencyOrphaned, orphaned.ID},
+		{types.InconsistencyNotCached, a.ID},
+		{types.InconsistencyOrphaned, m.ID},
+	}
+
+	found, err := ds.Verify(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range expected {
+		inc := findInconsistency(found, e.kind, e.ID)
+		if inc == nil {
+			t.Fatalf("%s %s not found in %v", e.kind, e.ID, found)
+		}
+		if inc.Repaired {
+			t.Fatalf("%s %s repaired without asking", e.kind, e.ID)
+		}
+	}
+
+	if _, err := ds.GetInstance(notStored.ID); err != nil {
+		t.Fatalf("Instance %s removed from the cache: %v", notStored.ID, err)
+	}
+
+	found, err = ds.Verify(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the repaired orphaned attachment is found not cached first
+	expected = append(expected, inconsistency{types.InconsistencyOrphaned, a.ID})
+
+	for _, e := range expected {
+		inc := findInconsistency(found, e.kind, e.ID)
+		if inc == nil || !inc.Repaired {
+			t.Fatalf("%s %s not repaired: %v", e.kind, e.ID, found)
+		}
+	}
+
+	if _, err := ds.GetInstance(notStored.ID); err == nil {
+		t.Fatalf("Instance %s still cached", notStored.ID)
+	}
+
+	if _, err := ds.GetInstance(notCached.ID); err != nil {
+		t.Fatalf("Instance %s not cached: %v", notCached.ID, err)
+	}
+
+	if ID, _ := ds.ResolveInstance(tenant.ID, "not-cached"); ID != notCached.ID {
+		t.Fatalf("Name of instance %s not indexed", notCached.ID)
+	}
+
+	if _, err := ds.GetInstance(orphaned.ID); err == nil {
+		t.Fatalf("Orphaned instance %s not deleted", orphaned.ID)
+	}
+
+	if bd, _ := ds.GetBlockDevice(data.ID); bd.State != types.Available {
+		t.Fatalf("Expected volume %s, got %s", types.Available, bd.State)
+	}
+
+	if _, err := ds.GetMappedIP(m.ExternalIP); err != types.ErrAddressNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAddressNotFound, err)
+	}
+
+	found, err = ds.Verify(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inc := range found {
+		for _, e := range expected {
+			if inc.ID == e.ID {
+				t.Fatalf("%s %s %s left after repair", inc.Kind, inc.Resource, inc.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// VNIs are 24 bit identifiers, 0 is reserved.
+const (
+	minVNI = 1
+	maxVNI = 1<<24 - 1
+)
+
+type subnetKey struct {
+	tenantID string
+	subnet   string
+}
+
+func (ds *Datastore) initVNIs() error {
+	ds.vnisLock = &sync.Mutex{}
+	ds.vnis = make(map[uint32]types.VNIAllocation)
+	ds.subnetVNIs = make(map[subnetKey]uint32)
+
+	vnis, err := ds.db.getVNIs()
+	if err != nil {
+		return errors.Wrap(err, "error getting VNIs from database")
+	}
+
+	for _, v := range vnis {
+		k := subnetKey{tenantID: v.TenantID, subnet: v.Subnet}
+
+		if v.State == types.VNIAllocated {
+			if old, ok := ds.subnetVNIs[k]; ok {
+				glog.Warningf("Subnet %s of tenant %s has VNIs %d and %d", v.Subnet, v.TenantID, old, v.VNI)
+				continue
+			}
+			ds.subnetVNIs[k] = v.VNI
+		}
+
+		ds.vnis[v.VNI] = v
+	}
+
+	return nil
+}
+
+func (ds *Datastore) vniQuarantine() time.Duration {
+	return time.Duration(ds.configInt(VNIQuarantineMinutes)) * time.Minute
+}
+
+// AllocateSubnetVNI returns the VNI of a tenant subnet, assigning one to
+// the subnet if it has none.  A subnet released during its quarantine
+// gets its previous VNI back.
+func (ds *Datastore) AllocateSubnetVNI(tenantID string, subnet string) (uint32, error) {
+	ds.vnisLock.Lock()
+	defer ds.vnisLock.Unlock()
+
+	k := subnetKey{tenantID: tenantID, subnet: subnet}
+	if vni, ok := ds.subnetVNIs[k]; ok {
+		return vni, nil
+	}
+
+	quarantine := ds.vniQuarantine()
+	now := time.Now()
+
+	var vni uint32
+	for _, v := range ds.vnis {
+		if v.State == types.VNIQuarantined && v.TenantID == tenantID && v.Subnet == subnet {
+			vni = v.VNI
+			break
+		}
+	}
+
+	for candidate := uint32(minVNI); vni == 0 && candidate <= maxVNI; candidate++ {
+		v, ok := ds.vnis[candidate]
+		if !ok || (v.State == types.VNIQuarantined && now.Sub(v.ReleaseTime) >= quarantine) {
+			vni = candidate
+		}
+	}
+
+	if vni == 0 {
+		return 0, types.ErrNoVNI
+	}
+
+	v := types.VNIAllocation{
+		VNI:      vni,
+		TenantID: tenantID,
+		Subnet:   subnet,
+		State:    types.VNIAllocated,
+	}
+
+	err := ds.db.updateVNI(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "error updating VNI in database")
+	}
+
+	ds.vnis[vni] = v
+	ds.subnetVNIs[k] = vni
+
+	return vni, nil
+}
+
+// GetSubnetVNI returns the VNI assigned to a tenant subnet, or 0 if the
+// subnet has none.
+func (ds *Datastore) GetSubnetVNI(tenantID string, subnet string) uint32 {
+	ds.vnisLock.Lock()
+	defer ds.vnisLock.Unlock()
+
+	return ds.subnetVNIs[subnetKey{tenantID: tenantID, subnet: subnet}]
+}
+
+func (ds *Datastore) releaseVNI(k subnetKey, now time.Time) error {
+	vni, ok := ds.subnetVNIs[k]
+	if !ok {
+		return nil
+	}
+
+	v := ds.vnis[vni]
+	v.State = types.VNIQuarantined
+	v.ReleaseTime = now
+
+	err := ds.db.updateVNI(v)
+	if err != nil {
+		return errors.Wrap(err, "error updating VNI in database")
+	}
+
+	ds.vnis[vni] = v
+	delete(ds.subnetVNIs, k)
+
+	return nil
+}
+
+// ReleaseSubnetVNI quarantines the VNI of a tenant subnet which was torn
+// down.
+func (ds *Datastore) ReleaseSubnetVNI(tenantID string, subnet string) error {
+	ds.vnisLock.Lock()
+	defer ds.vnisLock.Unlock()
+
+	return ds.releaseVNI(subnetKey{tenantID: tenantID, subnet: subnet}, time.Now())
+}
+
+// ReleaseTenantVNIs quarantines the VNIs of all the subnets of a tenant.
+func (ds *Datastore) ReleaseTenantVNIs(tenantID string) error {
+	ds.vnisLock.Lock()
+	defer ds.vnisLock.Unlock()
+
+	now := time.Now()
+	for k := range ds.subnetVNIs {
+		if k.tenantID != tenantID {
+			continue
+		}
+
+		if err := ds.releaseVNI(k, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetVNIs returns the allocated and quarantined VNIs of the cluster,
+// sorted by VNI.  The VNIs whose quarantine has ended are not returned.
+func (ds *Datastore) GetVNIs() []types.VNIAllocation {
+	ds.vnisLock.Lock()
+	defer ds.vnisLock.Unlock()
+
+	quarantine := ds.vniQuarantine()
+	now := time.Now()
+
+	vnis := []types.VNIAllocation{}
+	for _, v := range ds.vnis {
+		if v.State == types.VNIQuarantined && now.Sub(v.ReleaseTime) >= quarantine {
+			continue
+		}
+		vnis = append(vnis, v)
+	}
+
+	sort.Slice(vnis, func(i, j int) bool { return vnis[i].VNI < vnis[j].VNI })
+
+	return vnis
+}
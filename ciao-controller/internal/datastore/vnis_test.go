@@ -0,0 +1,123 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestSubnetVNIs(t *testing.T) {
+	vds := &Datastore{}
+
+	err := vds.Init(Config{
+		DBBackend:         &MemoryDB{},
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vds.Exit()
+
+	tenant1 := uuid.Generate().String()
+	tenant2 := uuid.Generate().String()
+
+	vni1, err := vds.AllocateSubnetVNI(tenant1, "172.16.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same subnet of another tenant must not share the VNI
+	vni2, err := vds.AllocateSubnetVNI(tenant2, "172.16.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vni1 == 0 || vni2 == 0 || vni1 == vni2 {
+		t.Fatalf("Expected distinct VNIs, got %d and %d", vni1, vni2)
+	}
+
+	vni, err := vds.AllocateSubnetVNI(tenant1, "172.16.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vni != vni1 {
+		t.Fatalf("Expected VNI %d to be kept, got %d", vni1, vni)
+	}
+
+	err = vds.ReleaseSubnetVNI(tenant1, "172.16.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vds.GetSubnetVNI(tenant1, "172.16.0.0/24") != 0 {
+		t.Fatal("Released subnet still has a VNI")
+	}
+
+	vnis := vds.GetVNIs()
+	if len(vnis) != 2 || vnis[0].VNI != vni1 || vnis[0].State != types.VNIQuarantined ||
+		vnis[0].ReleaseTime.IsZero() {
+		t.Fatalf("Expected VNI %d to be quarantined: %+v", vni1, vnis)
+	}
+
+	// a quarantined VNI is not reused by another subnet
+	vni3, err := vds.AllocateSubnetVNI(tenant2, "172.16.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vni3 == vni1 || vni3 == vni2 {
+		t.Fatalf("Quarantined or allocated VNI %d reused", vni3)
+	}
+
+	// but is given back to the subnet which released it
+	vni, err = vds.AllocateSubnetVNI(tenant1, "172.16.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vni != vni1 {
+		t.Fatalf("Expected VNI %d to be given back, got %d", vni1, vni)
+	}
+
+	err = vds.ReleaseTenantVNIs(tenant1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vds.SetConfigSetting(VNIQuarantineMinutes, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// once the quarantine is over the VNI is reused
+	vni, err = vds.AllocateSubnetVNI(tenant2, "172.16.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vni != vni1 {
+		t.Fatalf("Expected VNI %d to be reused, got %d", vni1, vni)
+	}
+
+	vnis = vds.GetVNIs()
+	if len(vnis) != 3 {
+		t.Fatalf("Expected 3 VNIs, got %+v", vnis)
+	}
+	for _, v := range vnis {
+		if v.TenantID != tenant2 || v.State != types.VNIAllocated {
+			t.Fatalf("Unexpected VNI %+v", v)
+		}
+	}
+}
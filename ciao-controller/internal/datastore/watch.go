@@ -0,0 +1,130 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// watchQueueSize is the number of events buffered for each watcher.
+const watchQueueSize = 256
+
+type watcher struct {
+	resource types.WatchResource
+	filter   types.WatchFilter
+	events   chan types.WatchEvent
+}
+
+// Watch returns a channel on which the changes made to the objects of the
+// given type and matching the filter are sent, and a function to stop
+// watching them which closes the channel.
+//
+// Events are never silently dropped. A watcher which falls more than
+// watchQueueSize events behind is stopped and its channel closed, its
+// owner then has to list the objects again before watching them anew.
+func (ds *Datastore) Watch(resource types.WatchResource, filter types.WatchFilter) (<-chan types.WatchEvent, func(), error) {
+	switch resource {
+	case types.WatchInstances, types.WatchTenants, types.WatchVolumes:
+	default:
+		return nil, nil, types.ErrBadRequest
+	}
+
+	w := &watcher{
+		resource: resource,
+		filter:   filter,
+		events:   make(chan types.WatchEvent, watchQueueSize),
+	}
+
+	ds.watchersLock.Lock()
+	if ds.watchers == nil {
+		ds.watchers = make(map[*watcher]struct{})
+	}
+	ds.watchers[w] = struct{}{}
+	ds.watchersLock.Unlock()
+
+	cancel := func() {
+		ds.watchersLock.Lock()
+		ds.removeWatcher(w)
+		ds.watchersLock.Unlock()
+	}
+
+	return w.events, cancel, nil
+}
+
+// removeWatcher must be called with the watchersLock held.
+func (ds *Datastore) removeWatcher(w *watcher) {
+	if _, ok := ds.watchers[w]; ok {
+		delete(ds.watchers, w)
+		close(w.events)
+	}
+}
+
+// notifyWatchers sends an event to the matching watchers. It never
+// blocks, so it can be called with the datastore locks held.
+func (ds *Datastore) notifyWatchers(e types.WatchEvent) {
+	ds.watchersLock.Lock()
+	defer ds.watchersLock.Unlock()
+
+	if len(ds.watchers) == 0 {
+		return
+	}
+
+	e.Timestamp = time.Now()
+
+	for w := range ds.watchers {
+		if w.resource != e.Resource || !w.filter.Match(e) {
+			continue
+		}
+
+		select {
+		case w.events <- e:
+		default:
+			glog.Warningf("Stopping %s watcher lagging behind", w.resource)
+			ds.removeWatcher(w)
+		}
+	}
+}
+
+func (ds *Datastore) instanceChanged(t types.WatchEventType, ID string, tenantID string, state string) {
+	ds.notifyWatchers(types.WatchEvent{
+		Resource: types.WatchInstances,
+		Type:     t,
+		ID:       ID,
+		TenantID: tenantID,
+		State:    state,
+	})
+}
+
+func (ds *Datastore) tenantChanged(t types.WatchEventType, ID string) {
+	ds.notifyWatchers(types.WatchEvent{
+		Resource: types.WatchTenants,
+		Type:     t,
+		ID:       ID,
+		TenantID: ID,
+	})
+}
+
+func (ds *Datastore) volumeChanged(t types.WatchEventType, volume types.Volume) {
+	ds.notifyWatchers(types.WatchEvent{
+		Resource: types.WatchVolumes,
+		Type:     t,
+		ID:       volume.ID,
+		TenantID: volume.TenantID,
+		State:    string(volume.State),
+	})
+}
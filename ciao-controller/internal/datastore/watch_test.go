@@ -0,0 +1,215 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+)
+
+// nextWatchEvent returns the next event sent to a watcher. Events are sent
+// synchronously by the datastore, so they are already queued.
+func nextWatchEvent(t *testing.T, events <-chan types.WatchEvent) types.WatchEvent {
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("Watch channel closed")
+		}
+		return e
+	default:
+		t.Fatal("Missing watch event")
+	}
+
+	return types.WatchEvent{}
+}
+
+func expectWatchEvent(t *testing.T, events <-chan types.WatchEvent, eventType types.WatchEventType, ID string, state string) {
+	e := nextWatchEvent(t, events)
+	if e.Type != eventType || e.ID != ID || e.State != state || e.Timestamp.IsZero() {
+		t.Fatalf("Unexpected watch event %+v, expected %s %s %s", e, eventType, ID, state)
+	}
+}
+
+func expectNoWatchEvent(t *testing.T, events <-chan types.WatchEvent) {
+	select {
+	case e := <-events:
+		t.Fatalf("Unexpected watch event %+v", e)
+	default:
+	}
+}
+
+func TestWatchInstances(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	otherWls, err := ds.GetWorkloads(other.ID)
+	if err != nil || len(otherWls) == 0 {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := ds.Watch(types.WatchInstances, types.WatchFilter{TenantID: tenant.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if _, err = addTestInstance(other, otherWls[0]); err != nil {
+		t.Fatal(err)
+	}
+	expectNoWatchEvent(t, events)
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchAdded, instance.ID, payloads.Pending)
+
+	if err = ds.ProtectInstance(instance.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchUpdated, instance.ID, payloads.Pending)
+
+	if err = ds.ProtectInstance(instance.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchUpdated, instance.ID, payloads.Pending)
+
+	if err = ds.DeleteInstance(instance.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchDeleted, instance.ID, "")
+	expectNoWatchEvent(t, events)
+}
+
+func TestWatchTenants(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := ds.Watch(types.WatchTenants, types.WatchFilter{ID: tenant.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if _, err = addTestTenant(); err != nil {
+		t.Fatal(err)
+	}
+	expectNoWatchEvent(t, events)
+
+	err = ds.PatchTenant(tenant.ID, []byte(`{"name":"watched"}`), types.MergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchUpdated, tenant.ID, "")
+
+	if err = ds.DeleteTenant(tenant.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchDeleted, tenant.ID, "")
+}
+
+func TestWatchVolumes(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := ds.Watch(types.WatchVolumes, types.WatchFilter{TenantID: tenant.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	volume, err := NewFixture(ds).AddVolume(tenant.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchAdded, volume.ID, string(types.Available))
+
+	volume.State = types.Attaching
+	if err = ds.UpdateBlockDevice(volume); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchUpdated, volume.ID, string(types.Attaching))
+
+	if err = ds.DeleteBlockDevice(volume.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectWatchEvent(t, events, types.WatchDeleted, volume.ID, string(types.Attaching))
+}
+
+func TestWatchCancel(t *testing.T) {
+	if _, _, err := ds.Watch("nodes", types.WatchFilter{}); err != types.ErrBadRequest {
+		t.Fatalf("Unexpected error watching an invalid resource: %v", err)
+	}
+
+	events, cancel, err := ds.Watch(types.WatchTenants, types.WatchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	cancel()
+
+	if _, ok := <-events; ok {
+		t.Fatal("Watch channel not closed")
+	}
+}
+
+func TestWatchLagging(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := ds.Watch(types.WatchTenants, types.WatchFilter{ID: tenant.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	for i := 0; i <= watchQueueSize; i++ {
+		err = ds.PatchTenant(tenant.ID, []byte(`{"name":"lagging"}`), types.MergePatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+
+	if received != watchQueueSize {
+		t.Fatalf("Received %d events before the watcher was stopped, expected %d",
+			received, watchQueueSize)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipalloc allocates host addresses out of the subnets of a fixed
+// prefix length into which a base IPv4 or IPv6 network is divided.
+package ipalloc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrExhausted is returned when not enough free addresses are left
+	// to satisfy an allocation.
+	ErrExhausted = errors.New("No free address left")
+
+	// ErrOutOfRange is returned for addresses outside the base network.
+	ErrOutOfRange = errors.New("Address is out of range")
+
+	// ErrReserved is returned when claiming a reserved address.
+	ErrReserved = errors.New("Address is reserved")
+
+	// ErrClaimed is returned when claiming an address twice.
+	ErrClaimed = errors.New("Address is already claimed")
+
+	// ErrInvalidRange is returned for malformed address ranges.
+	ErrInvalidRange = errors.New("Invalid address range")
+
+	// ErrRangeTooLarge is returned for address ranges holding more
+	// addresses than allowed.
+	ErrRangeTooLarge = errors.New("Address range is too large")
+)
+
+// Allocator hands out the host addresses of the subnets of a base network.
+// Addresses are allocated from the subnets already holding claims first,
+// lowest subnet and address first, and only then from unused subnets, so
+// that as few subnets as possible are in use at any time.  An Allocator
+// is not safe for concurrent use.
+type Allocator struct {
+	base    *net.IPNet
+	prefix  int
+	size    *big.Int
+	offsets []*big.Int
+
+	reserved map[string]bool
+	claimed  map[string]bool
+	subnets  map[string]int
+}
+
+// New returns an Allocator for the subnets of length prefix of the base
+// network, in CIDR notation.  offsets lists the host addresses reserved in
+// every subnet as offsets from the subnet address; negative offsets count
+// back from the end of the subnet, so that 0 and -1 reserve the network
+// and broadcast addresses.
+func New(base string, prefix int, offsets ...int) (*Allocator, error) {
+	_, ipNet, err := net.ParseCIDR(base)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if prefix < ones || prefix > bits {
+		return nil, fmt.Errorf("prefix length %d not within %s", prefix, base)
+	}
+
+	a := &Allocator{
+		base:     ipNet,
+		prefix:   prefix,
+		size:     new(big.Int).Lsh(big.NewInt(1), uint(bits-prefix)),
+		reserved: make(map[string]bool),
+		claimed:  make(map[string]bool),
+		subnets:  make(map[string]int),
+	}
+
+	for _, o := range offsets {
+		off := big.NewInt(int64(o))
+		if o < 0 {
+			off.Add(off, a.size)
+		}
+
+		if off.Sign() >= 0 && off.Cmp(a.size) < 0 && !a.isOffset(off) {
+			a.offsets = append(a.offsets, off)
+		}
+	}
+
+	return a, nil
+}
+
+func (a *Allocator) isOffset(off *big.Int) bool {
+	for _, o := range a.offsets {
+		if o.Cmp(off) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Allocator) toInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip)
+}
+
+func (a *Allocator) toIP(i *big.Int) net.IP {
+	ip := make(net.IP, len(a.base.IP))
+	b := i.Bytes()
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
+
+// normalize returns ip in the representation of the base network, or nil
+// if it is not in the base network.
+func (a *Allocator) normalize(ip net.IP) net.IP {
+	if len(a.base.IP) == net.IPv4len {
+		ip = ip.To4()
+	} else if ip.To4() == nil {
+		ip = ip.To16()
+	} else {
+		ip = nil
+	}
+
+	if ip == nil || !a.base.Contains(ip) {
+		return nil
+	}
+
+	return ip
+}
+
+// Contains checks whether ip is an address of the base network.
+func (a *Allocator) Contains(ip net.IP) bool {
+	return a.normalize(ip) != nil
+}
+
+// Subnet returns the subnet of ip, or nil if ip is not in the base network.
+func (a *Allocator) Subnet(ip net.IP) *net.IPNet {
+	ip = a.normalize(ip)
+	if ip == nil {
+		return nil
+	}
+
+	mask := net.CIDRMask(a.prefix, len(ip)*8)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// SubnetHosts returns the number of addresses of a subnet which can be
+// allocated, i.e., which are not reserved by offset.
+func (a *Allocator) SubnetHosts() *big.Int {
+	return new(big.Int).Sub(a.size, big.NewInt(int64(len(a.offsets))))
+}
+
+// Reserve prevents ip from ever being allocated.
+func (a *Allocator) Reserve(ip net.IP) error {
+	ip = a.normalize(ip)
+	if ip == nil {
+		return ErrOutOfRange
+	}
+
+	a.reserved[ip.String()] = true
+	return nil
+}
+
+// Reserved checks whether ip is reserved, either explicitly or by offset.
+// Addresses out of range are not reserved.
+func (a *Allocator) Reserved(ip net.IP) bool {
+	ip = a.normalize(ip)
+	if ip == nil {
+		return false
+	}
+
+	if a.reserved[ip.String()] {
+		return true
+	}
+
+	start := a.toInt(a.Subnet(ip).IP)
+	return a.isOffset(start.Sub(a.toInt(ip), start))
+}
+
+// Claimed checks whether ip is claimed.
+func (a *Allocator) Claimed(ip net.IP) bool {
+	ip = a.normalize(ip)
+	return ip != nil && a.claimed[ip.String()]
+}
+
+// Claim marks ip as allocated, e.g., when restoring the addresses already
+// in use.
+func (a *Allocator) Claim(ip net.IP) error {
+	ip = a.normalize(ip)
+	if ip == nil {
+		return ErrOutOfRange
+	}
+
+	if a.Reserved(ip) {
+		return ErrReserved
+	}
+
+	if a.claimed[ip.String()] {
+		return ErrClaimed
+	}
+
+	a.claimed[ip.String()] = true
+	a.subnets[a.Subnet(ip).String()]++
+
+	return nil
+}
+
+// Release returns ip to the free addresses and reports whether its subnet
+// no longer holds any claim.  Releasing an address which is not claimed
+// has no effect.
+func (a *Allocator) Release(ip net.IP) bool {
+	ip = a.normalize(ip)
+	if ip == nil || !a.claimed[ip.String()] {
+		return false
+	}
+
+	delete(a.claimed, ip.String())
+
+	subnet := a.Subnet(ip).String()
+	a.subnets[subnet]--
+	if a.subnets[subnet] > 0 {
+		return false
+	}
+
+	delete(a.subnets, subnet)
+	return true
+}
+
+// usedSubnets returns the addresses of the subnets holding claims, sorted.
+func (a *Allocator) usedSubnets() []*big.Int {
+	var subnets []*big.Int
+
+	for s := range a.subnets {
+		_, ipNet, _ := net.ParseCIDR(s)
+		subnets = append(subnets, a.toInt(ipNet.IP))
+	}
+
+	sort.Slice(subnets, func(i, j int) bool {
+		return subnets[i].Cmp(subnets[j]) < 0
+	})
+
+	return subnets
+}
+
+// fill claims free addresses of the subnet starting at start until ips
+// holds n addresses.
+func (a *Allocator) fill(start *big.Int, ips []net.IP, n int) []net.IP {
+	end := new(big.Int).Add(start, a.size)
+	one := big.NewInt(1)
+
+	for i := new(big.Int).Set(start); len(ips) < n && i.Cmp(end) < 0; i.Add(i, one) {
+		ip := a.toIP(i)
+		if a.Claim(ip) == nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// Allocate claims n free addresses.  Either all n addresses are allocated
+// or, if not enough addresses are free, none is and ErrExhausted is
+// returned.
+func (a *Allocator) Allocate(n int) ([]net.IP, error) {
+	var ips []net.IP
+
+	for _, start := range a.usedSubnets() {
+		if len(ips) == n {
+			break
+		}
+		ips = a.fill(start, ips, n)
+	}
+
+	ones, bits := a.base.Mask.Size()
+	start := a.toInt(a.base.IP)
+	end := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	end.Add(end, start)
+
+	for ; len(ips) < n && start.Cmp(end) < 0; start.Add(start, a.size) {
+		ips = a.fill(start, ips, n)
+	}
+
+	if len(ips) < n {
+		for _, ip := range ips {
+			a.Release(ip)
+		}
+		return nil, ErrExhausted
+	}
+
+	return ips, nil
+}
+
+// ParseRange returns the addresses of a range of the form start-end, e.g.,
+// 192.0.2.10-192.0.2.50, both ends included.  Both ends must be addresses
+// of the same family and the range may not hold more than max addresses.
+func ParseRange(r string, max int) ([]net.IP, error) {
+	ends := strings.Split(r, "-")
+	if len(ends) != 2 {
+		return nil, ErrInvalidRange
+	}
+
+	start := net.ParseIP(strings.TrimSpace(ends[0]))
+	end := net.ParseIP(strings.TrimSpace(ends[1]))
+	if start == nil || end == nil {
+		return nil, ErrInvalidRange
+	}
+
+	if start4, end4 := start.To4(), end.To4(); start4 != nil && end4 != nil {
+		start, end = start4, end4
+	} else if start4 != nil || end4 != nil {
+		return nil, ErrInvalidRange
+	}
+
+	first := new(big.Int).SetBytes(start)
+	last := new(big.Int).SetBytes(end)
+
+	count := new(big.Int).Sub(last, first)
+	if count.Sign() < 0 {
+		return nil, ErrInvalidRange
+	}
+
+	if count.Cmp(big.NewInt(int64(max-1))) > 0 {
+		return nil, ErrRangeTooLarge
+	}
+
+	var ips []net.IP
+	one := big.NewInt(1)
+	for i := first; i.Cmp(last) <= 0; i.Add(i, one) {
+		ip := make(net.IP, len(start))
+		b := i.Bytes()
+		copy(ip[len(ip)-len(b):], b)
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipalloc
+
+import (
+	"net"
+	"testing"
+)
+
+func allocate(t *testing.T, a *Allocator, n int) []string {
+	ips, err := a.Allocate(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs
+}
+
+func checkAddrs(t *testing.T, addrs []string, expected ...string) {
+	if len(addrs) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, addrs)
+	}
+
+	for i := range addrs {
+		if addrs[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, addrs)
+		}
+	}
+}
+
+func TestNewBadPrefix(t *testing.T) {
+	if _, err := New("172.16.0.0/12", 8); err == nil {
+		t.Error("Expected error for prefix shorter than the base network")
+	}
+
+	if _, err := New("172.16.0.0/12", 33); err == nil {
+		t.Error("Expected error for prefix longer than the address")
+	}
+
+	if _, err := New("172.16.0.0", 24); err == nil {
+		t.Error("Expected error for base network without prefix")
+	}
+}
+
+func TestAllocateReserved(t *testing.T) {
+	a, err := New("172.16.0.0/12", 30, 0, 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only one address of each /30 is not reserved
+	checkAddrs(t, allocate(t, a, 3), "172.16.0.2", "172.16.0.6", "172.16.0.10")
+
+	if a.SubnetHosts().Int64() != 1 {
+		t.Errorf("Expected 1 host per subnet, got %v", a.SubnetHosts())
+	}
+
+	if !a.Reserved(net.ParseIP("172.16.0.7")) || a.Reserved(net.ParseIP("172.16.0.6")) {
+		t.Error("Broadcast address not reserved or host address reserved")
+	}
+
+	if err := a.Claim(net.ParseIP("172.16.0.5")); err != ErrReserved {
+		t.Errorf("Expected %v claiming a gateway, got %v", ErrReserved, err)
+	}
+
+	if err := a.Claim(net.ParseIP("172.16.0.2")); err != ErrClaimed {
+		t.Errorf("Expected %v claiming twice, got %v", ErrClaimed, err)
+	}
+
+	if err := a.Claim(net.ParseIP("10.0.0.2")); err != ErrOutOfRange {
+		t.Errorf("Expected %v claiming out of range, got %v", ErrOutOfRange, err)
+	}
+}
+
+func TestAllocatePrefersUsedSubnets(t *testing.T) {
+	a, err := New("10.1.0.0/16", 24, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Claim(net.ParseIP("10.1.3.7")); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAddrs(t, allocate(t, a, 2), "10.1.3.1", "10.1.3.2")
+
+	if a.Release(net.ParseIP("10.1.3.1")) {
+		t.Error("Subnet reported empty while holding claims")
+	}
+	a.Release(net.ParseIP("10.1.3.2"))
+
+	if !a.Release(net.ParseIP("10.1.3.7")) {
+		t.Error("Subnet not reported empty after releasing its last claim")
+	}
+
+	checkAddrs(t, allocate(t, a, 1), "10.1.0.1")
+}
+
+func TestAllocateExhausted(t *testing.T) {
+	a, err := New("192.168.0.0/29", 30, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Reserve(net.ParseIP("192.168.0.5")); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAddrs(t, allocate(t, a, 2), "192.168.0.1", "192.168.0.2")
+
+	if _, err := a.Allocate(2); err != ErrExhausted {
+		t.Fatalf("Expected %v, got %v", ErrExhausted, err)
+	}
+
+	// a failed allocation claims nothing
+	checkAddrs(t, allocate(t, a, 1), "192.168.0.6")
+
+	if _, err := a.Allocate(1); err != ErrExhausted {
+		t.Fatalf("Expected %v, got %v", ErrExhausted, err)
+	}
+}
+
+func TestAllocateWholeAddressSpace(t *testing.T) {
+	a, err := New("255.255.255.252/30", 31)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkAddrs(t, allocate(t, a, 4), "255.255.255.252", "255.255.255.253",
+		"255.255.255.254", "255.255.255.255")
+
+	if _, err := a.Allocate(1); err != ErrExhausted {
+		t.Fatalf("Expected %v, got %v", ErrExhausted, err)
+	}
+}
+
+func TestAllocateIPv6(t *testing.T) {
+	a, err := New("fd00:1::/64", 120, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Contains(net.ParseIP("172.16.0.1")) {
+		t.Error("IPv4 address contained in an IPv6 network")
+	}
+
+	if a.SubnetHosts().Int64() != 255 {
+		t.Errorf("Expected 255 hosts per subnet, got %v", a.SubnetHosts())
+	}
+
+	if err := a.Claim(net.ParseIP("fd00:1::1:ff")); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAddrs(t, allocate(t, a, 2), "fd00:1::1:1", "fd00:1::1:2")
+
+	subnet := a.Subnet(net.ParseIP("fd00:1::1:2"))
+	if subnet == nil || subnet.String() != "fd00:1::1:0/120" {
+		t.Errorf("Unexpected subnet %v", subnet)
+	}
+}
+
+func TestSubnet(t *testing.T) {
+	a, err := New("172.16.0.0/12", 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnet := a.Subnet(net.ParseIP("172.17.4.9"))
+	if subnet == nil || subnet.String() != "172.17.4.0/24" {
+		t.Errorf("Unexpected subnet %v", subnet)
+	}
+
+	if a.Subnet(net.ParseIP("172.32.0.1")) != nil {
+		t.Error("Subnet found for an address out of range")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	ips, err := ParseRange("192.0.2.254 - 192.0.3.1", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	checkAddrs(t, addrs, "192.0.2.254", "192.0.2.255", "192.0.3.0", "192.0.3.1")
+
+	ips, err = ParseRange("2001:db8::ffff-2001:db8::1:0", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ips) != 2 || ips[1].String() != "2001:db8::1:0" {
+		t.Errorf("Unexpected IPv6 range %v", ips)
+	}
+
+	var badRanges = []struct {
+		r   string
+		err error
+	}{
+		{"192.0.2.10", ErrInvalidRange},
+		{"192.0.2.10-192.0.2.5", ErrInvalidRange},
+		{"192.0.2.10-2001:db8::1", ErrInvalidRange},
+		{"192.0.2.10-foo", ErrInvalidRange},
+		{"192.0.2.10-192.0.2.20-192.0.2.30", ErrInvalidRange},
+		{"192.0.2.0-192.0.2.16", ErrRangeTooLarge},
+	}
+
+	for _, test := range badRanges {
+		if _, err := ParseRange(test.r, 16); err != test.err {
+			t.Errorf("Expected %v parsing %s, got %v", test.err, test.r, err)
+		}
+	}
+}
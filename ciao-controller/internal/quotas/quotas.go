@@ -15,6 +15,9 @@
 package quotas
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/payloads"
 )
@@ -50,6 +53,12 @@ type consumeOp struct {
 	ch        chan Result
 }
 
+type chargeOp struct {
+	tenantID  string
+	resources []payloads.RequestedResource
+	doneCh    chan struct{}
+}
+
 type releaseOp struct {
 	tenantID  string
 	resources []payloads.RequestedResource
@@ -112,57 +121,67 @@ func getTenantData(tenantDetails map[string]*tenantData, tenantID string) *tenan
 	return td
 }
 
+// consumeQuota checks the resources requested against the quotas and the
+// limits of the tenant, and only accounts for them if they are all
+// allowed, so that a refused request never affects concurrent ones.
 func consumeQuota(tenantDetails map[string]*tenantData, op *consumeOp) Result {
 	td := getTenantData(tenantDetails, op.tenantID)
-	allowed := true
+	var exceeded []string
 
 	for _, r := range op.resources {
 		q, ok := td.quotas[r.Type]
 
-		if ok {
-			q.consumed += r.Value
-			if q.limit > -1 && q.consumed > q.limit {
-				allowed = false
-			}
+		if ok && q.limit > -1 && q.consumed+r.Value > q.limit {
+			exceeded = append(exceeded, string(r.Type))
 		}
 	}
 
 	res := &result{resources: op.resources}
-	res.allowed = allowed
-	if !allowed {
+	if len(exceeded) > 0 {
+		res.reason = fmt.Sprintf("Over quota: %s", strings.Join(exceeded, ", "))
+		return res
+	}
+
+	if !checkLimit(td, op.resources) {
 		// TODO: produce more precise reason
-		res.reason = "Over quota"
+		res.reason = "Over limit"
+		return res
 	}
+
+	charge(td, op.resources)
+	res.allowed = true
 	return res
 }
 
-func checkLimit(tenantDetails map[string]*tenantData, op *consumeOp) Result {
-	td := getTenantData(tenantDetails, op.tenantID)
-
-	allowed := true
-	for _, r := range op.resources {
+func checkLimit(td *tenantData, resources []payloads.RequestedResource) bool {
+	for _, r := range resources {
 		switch r.Type {
 		case payloads.VCPUs:
 			if td.perInstanceVCPUs > -1 && r.Value > td.perInstanceVCPUs {
-				allowed = false
+				return false
 			}
 		case payloads.MemMB:
 			if td.perInstanceMemory > -1 && r.Value > td.perInstanceMemory {
-				allowed = false
+				return false
 			}
 		case payloads.SharedDiskGiB:
 			if td.perVolumeSize > -1 && r.Value > td.perVolumeSize {
-				allowed = false
+				return false
 			}
 		}
 	}
-	res := &result{resources: op.resources}
-	res.allowed = allowed
-	if !allowed {
-		// TODO: produce more precise reason
-		res.reason = "Over limit"
+
+	return true
+}
+
+func charge(td *tenantData, resources []payloads.RequestedResource) {
+	for _, r := range resources {
+		q, ok := td.quotas[r.Type]
+
+		if ok {
+			q.consumed += r.Value
+		}
 	}
-	return res
 }
 
 func release(tenantDetails map[string]*tenantData, op *releaseOp) {
@@ -298,16 +317,13 @@ func (qs *Quotas) Init() {
 			switch op := data.(type) {
 
 			case *consumeOp:
-				res := consumeQuota(tenantDetails, op)
-				if !res.Allowed() {
-					op.ch <- res
-					close(op.ch)
-					continue
-				}
-				res = checkLimit(tenantDetails, op)
-				op.ch <- res
+				op.ch <- consumeQuota(tenantDetails, op)
 				close(op.ch)
 
+			case *chargeOp:
+				charge(getTenantData(tenantDetails, op.tenantID), op.resources)
+				close(op.doneCh)
+
 			case *releaseOp:
 				release(tenantDetails, op)
 
@@ -339,18 +355,18 @@ func copyResources(resources []payloads.RequestedResource) []payloads.RequestedR
 }
 
 // Consume will update the quota records to indicate that the tenant is using
-// all the resources specified. This method should usually be used on a
-// per-instance/volume/image basis as it will also check against the limits.
-// The exception to this is for initial import when disregarding the result.
+// all the resources specified, provided that this keeps the tenant within its
+// quotas. This method should usually be used on a per-instance/volume/image
+// basis as it will also check against the limits.
 //
 // This method returns a Result channel indicating whether the consumption is
-// allowed. The result of the Consume() is indicated by
-// Result.Allowed(). The caller can choose to ignore this or reclaim the
-// resources used (e.g. by terminating an instance.) If the caller chooses to
-// reclaim the resource they must call Quotas.Release(). The resources used in
-// the original request are available in the result by calling
-// Result.Resources(). If Result.Allowed() returns false then then
-// Result.Reason() returns an explanation that can be shared with the user.
+// allowed. The result of the Consume() is indicated by Result.Allowed(). The
+// check and the update of the quota records are atomic: if Result.Allowed()
+// returns false none of the resources have been accounted for, and
+// Result.Reason() returns an explanation that can be shared with the user. If
+// it returns true the caller must call Quotas.Release() once the resources are
+// no longer used. The resources used in the original request are available in
+// the result by calling Result.Resources().
 func (qs *Quotas) Consume(tenantID string, resources ...payloads.RequestedResource) chan Result {
 	ch := make(chan Result, 1)
 	data := &consumeOp{tenantID, copyResources(resources), ch}
@@ -359,6 +375,16 @@ func (qs *Quotas) Consume(tenantID string, resources ...payloads.RequestedResour
 	return ch
 }
 
+// Charge will update the quota records to indicate that the tenant is using
+// the resources specified, regardless of its quotas and limits. It is meant
+// for accounting for resources which already exist, e.g. on initial import.
+func (qs *Quotas) Charge(tenantID string, resources ...payloads.RequestedResource) {
+	ch := make(chan struct{})
+	data := &chargeOp{tenantID, copyResources(resources), ch}
+	qs.ch <- data
+	<-ch
+}
+
 // Release will update the quota records for a tenant to indicate that it is no
 // longer using the supplied resources.
 func (qs *Quotas) Release(tenantID string, resources ...payloads.RequestedResource) {
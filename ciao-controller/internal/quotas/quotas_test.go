@@ -16,6 +16,7 @@ package quotas
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
@@ -45,8 +46,6 @@ func TestConsumeAndRelease(t *testing.T) {
 	if res2.Allowed() {
 		t.Fatal("Expected to be denied")
 	}
-	// If denied we are responsible for releasing
-	qs.Release("test-tenant-1", res2.Resources()...)
 
 	// Now release "first instance"
 	qs.Release("test-tenant-1", res.Resources()...)
@@ -240,3 +239,109 @@ func TestAllLimits(t *testing.T) {
 		}
 	}
 }
+
+func quotaUsage(qs *Quotas, tenantID string, name string) int {
+	for _, qd := range qs.DumpQuotas(tenantID) {
+		if qd.Name == name {
+			return qd.Usage
+		}
+	}
+	return -1
+}
+
+func TestRefusedConsume(t *testing.T) {
+	qs := &Quotas{}
+	qs.Init()
+	defer qs.Shutdown()
+
+	quotas := []types.QuotaDetails{
+		{Name: "tenant-vcpu-quota", Value: 10},
+		{Name: "tenant-instances-quota", Value: 2},
+		{Name: "tenant-mem-per-instance-limit", Value: 128},
+	}
+	qs.Update("test-tenant-1", quotas)
+
+	res := <-qs.Consume("test-tenant-1",
+		payloads.RequestedResource{Type: payloads.Instance, Value: 1},
+		payloads.RequestedResource{Type: payloads.VCPUs, Value: 12})
+	if res.Allowed() || res.Reason() != "Over quota: vcpus" {
+		t.Fatalf("Expected to be over quota, got %q", res.Reason())
+	}
+
+	res = <-qs.Consume("test-tenant-1",
+		payloads.RequestedResource{Type: payloads.Instance, Value: 1},
+		payloads.RequestedResource{Type: payloads.MemMB, Value: 256})
+	if res.Allowed() || res.Reason() != "Over limit" {
+		t.Fatalf("Expected to be over limit, got %q", res.Reason())
+	}
+
+	for _, name := range []string{"tenant-vcpu-quota", "tenant-instances-quota", "tenant-mem-quota"} {
+		if u := quotaUsage(qs, "test-tenant-1", name); u != 0 {
+			t.Fatalf("Refused consumption accounted for: %s usage %d", name, u)
+		}
+	}
+}
+
+func TestCharge(t *testing.T) {
+	qs := &Quotas{}
+	qs.Init()
+	defer qs.Shutdown()
+
+	qs.Update("test-tenant-1", []types.QuotaDetails{{Name: "tenant-images-quota", Value: 1}})
+
+	qs.Charge("test-tenant-1", payloads.RequestedResource{Type: payloads.Image, Value: 2})
+	if u := quotaUsage(qs, "test-tenant-1", "tenant-images-quota"); u != 2 {
+		t.Fatalf("Expected images usage 2, got %d", u)
+	}
+
+	res := <-qs.Consume("test-tenant-1", payloads.RequestedResource{Type: payloads.Image, Value: 1})
+	if res.Allowed() {
+		t.Fatal("Expected to be denied")
+	}
+
+	qs.Release("test-tenant-1", payloads.RequestedResource{Type: payloads.Image, Value: 2})
+
+	res = <-qs.Consume("test-tenant-1", payloads.RequestedResource{Type: payloads.Image, Value: 1})
+	if !res.Allowed() {
+		t.Fatal("Expected to be allowed")
+	}
+}
+
+func TestConcurrentConsume(t *testing.T) {
+	qs := &Quotas{}
+	qs.Init()
+	defer qs.Shutdown()
+
+	const quota = 10
+	const requests = 50
+
+	qs.Update("test-tenant-1", []types.QuotaDetails{{Name: "tenant-instances-quota", Value: quota}})
+
+	var wg sync.WaitGroup
+	allowed := make(chan bool, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := <-qs.Consume("test-tenant-1", payloads.RequestedResource{Type: payloads.Instance, Value: 1})
+			allowed <- res.Allowed()
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	n := 0
+	for ok := range allowed {
+		if ok {
+			n++
+		}
+	}
+
+	if n != quota {
+		t.Fatalf("Expected %d requests to be allowed, got %d", quota, n)
+	}
+
+	if u := quotaUsage(qs, "test-tenant-1", "tenant-instances-quota"); u != quota {
+		t.Fatalf("Expected instances usage %d, got %d", quota, u)
+	}
+}
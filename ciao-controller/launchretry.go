@@ -0,0 +1,137 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var launchRetries = flag.Int("launch_retries", 2, "number of times the launch of an instance is retried on other nodes after a node specific start failure, 0 to disable")
+
+// pendingLaunch is the launch of an instance not yet reported by any node,
+// which can be retried on another node if it fails.
+type pendingLaunch struct {
+	config   config
+	attempts int
+}
+
+// trackLaunch records the launch of an instance so that it can be retried.
+// CNCI launches are not retried, their failures are handled by the CNCI
+// manager of their tenant.
+func (c *controller) trackLaunch(i *instance) {
+	if *launchRetries <= 0 || i.CNCI {
+		return
+	}
+
+	c.launchLock.Lock()
+	defer c.launchLock.Unlock()
+
+	if c.launches == nil {
+		c.launches = make(map[string]*pendingLaunch)
+	}
+	c.launches[i.ID] = &pendingLaunch{config: i.newConfig}
+}
+
+func (c *controller) untrackLaunch(instanceID string) {
+	c.launchLock.Lock()
+	delete(c.launches, instanceID)
+	c.launchLock.Unlock()
+}
+
+// launchesReported stops tracking the launches of the instances a node
+// reports as no longer pending.
+func (c *controller) launchesReported(stats payloads.Stat) {
+	c.launchLock.Lock()
+	defer c.launchLock.Unlock()
+
+	if len(c.launches) == 0 {
+		return
+	}
+
+	for _, i := range stats.Instances {
+		if i.State != payloads.Pending {
+			delete(c.launches, i.InstanceUUID)
+		}
+	}
+}
+
+// retryLaunch launches an instance again after a start failure caused by
+// the node that reported it, excluding that node and the nodes of the
+// previous attempts from its placement.  It returns false once the
+// retries of the instance are exhausted or if the failure is not worth
+// retrying, in which case the failure is to be surfaced to the tenant.
+func (c *controller) retryLaunch(failure payloads.ErrorStartFailure) bool {
+	if failure.Restart || failure.NodeUUID == "" || !failure.Reason.IsNodeSpecific() {
+		c.untrackLaunch(failure.InstanceUUID)
+		return false
+	}
+
+	return c.relaunch(failure.InstanceUUID, failure.NodeUUID, failure.Reason)
+}
+
+// relaunch launches a tracked instance again after reason prevented it from
+// starting, excluding nodeID, if set, from its placement.  It returns false
+// once the retries of the instance are exhausted.
+func (c *controller) relaunch(instanceID string, nodeID string, reason payloads.StartFailureReason) bool {
+	c.launchLock.Lock()
+	l := c.launches[instanceID]
+	if l == nil || l.attempts >= *launchRetries {
+		delete(c.launches, instanceID)
+		c.launchLock.Unlock()
+		return false
+	}
+
+	l.attempts++
+	attempt := l.attempts
+	if nodeID != "" {
+		l.config.sc.Start.ExcludeNodes = append(l.config.sc.Start.ExcludeNodes, nodeID)
+	}
+	l.config.sc.Start.CommandID = uuid.Generate().String()
+	y, err := yaml.Marshal(&l.config.sc)
+	cloudInit := l.config.cloudInit
+	c.launchLock.Unlock()
+
+	if err != nil {
+		glog.Warningf("Error marshalling retried launch of instance %s: %v", instanceID, err)
+		c.untrackLaunch(instanceID)
+		return false
+	}
+
+	if nodeID != "" {
+		glog.Warningf("Retrying launch of instance %s after start failure on node %s: %s",
+			instanceID, nodeID, reason)
+	} else {
+		glog.Warningf("Retrying launch of instance %s: %s", instanceID, reason)
+	}
+
+	err = c.ds.StartRetry(instanceID, reason, nodeID, attempt, *launchRetries)
+	if err != nil {
+		glog.Warningf("Error recording retried launch of instance %s: %v", instanceID, err)
+	}
+
+	err = c.client.StartWorkload("---\n" + string(y) + "...\n" + cloudInit)
+	if err != nil {
+		glog.Warningf("Error retrying launch of instance %s: %v", instanceID, err)
+		c.untrackLaunch(instanceID)
+		return false
+	}
+
+	return true
+}
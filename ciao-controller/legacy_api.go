@@ -68,6 +68,11 @@ func (h legacyAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if resp.status == http.StatusNotModified {
+		w.WriteHeader(resp.status)
+		return
+	}
+
 	b, err := json.Marshal(resp.response)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError),
@@ -139,6 +144,10 @@ func legacyTraceData(c *controller, w http.ResponseWriter, r *http.Request) (API
 	return traceData(c, w, r)
 }
 
+func legacyTraceServers(c *controller, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	return traceServers(c, w, r)
+}
+
 func legacyComputeRoutes(ctl *controller, r *mux.Router) *mux.Router {
 	r.Handle("/v2.1/{tenant}/servers/action",
 		legacyAPIHandler{ctl, tenantServersAction, false}).Methods("POST")
@@ -174,6 +183,8 @@ func legacyComputeRoutes(ctl *controller, r *mux.Router) *mux.Router {
 		legacyAPIHandler{ctl, legacyListTraces, true}).Methods("GET")
 	r.Handle("/v2.1/traces/{label}",
 		legacyAPIHandler{ctl, legacyTraceData, true}).Methods("GET")
+	r.Handle("/v2.1/traces/{label}/servers",
+		legacyAPIHandler{ctl, legacyTraceServers, true}).Methods("GET")
 
 	return r
 }
@@ -0,0 +1,195 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+var lifetimeCheckInterval = flag.Duration("lifetime_check_interval", time.Hour, "interval between checks of instances against the lifetime policies of their workloads, 0 to disable")
+
+// recycleInstance deletes an expired instance and, unless it belongs to a
+// warm pool which will refill itself, launches a replacement with the same
+// workload and name.
+var recycleInstance = func(c *controller, i *types.Instance) (string, error) {
+	err := c.deleteInstanceSync(i.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if i.Warm {
+		return "", nil
+	}
+
+	instances, err := c.startWorkload(types.WorkloadRequest{
+		WorkloadID: i.WorkloadID,
+		TenantID:   i.TenantID,
+		Instances:  1,
+		Name:       i.Name,
+		TraceLabel: i.TraceLabel,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return instances[0].ID, nil
+}
+
+// instanceExpiry is how far the expiry of an instance was handled.
+type instanceExpiry int
+
+const (
+	// expiryDeferred instances had their expiry logged, and are
+	// recycled in the next maintenance window of their tenant.
+	expiryDeferred instanceExpiry = iota + 1
+
+	// expiryHandled instances were recycled, or are not to be.
+	expiryHandled
+)
+
+// instanceExpired logs the expiry of an instance in the event log of its
+// tenant, unless it was deferred, and recycles it if the lifetime policy
+// of its workload asks so and the maintenance windows of the tenant allow
+// it.
+func (c *controller) instanceExpired(i *types.Instance, policy types.LifetimePolicy, age time.Duration,
+	state instanceExpiry, now time.Time) instanceExpiry {
+	recycle := policy.Action == types.LifetimeRecycle && !i.Protected
+	open := !recycle || c.inMaintenanceWindow(i.TenantID, now)
+
+	if state != expiryDeferred {
+		msg := fmt.Sprintf("Instance %s is %d days old, past the %d days lifetime of workload %s",
+			i.ID, int(age/(24*time.Hour)), policy.MaxAgeDays, i.WorkloadID)
+
+		switch {
+		case policy.Action == types.LifetimeRecycle && i.Protected:
+			msg += ", not recycling protected instance"
+		case recycle && !open:
+			msg += ", recycling it in the next maintenance window"
+		case recycle:
+			msg += ", recycling it"
+		}
+
+		glog.Warning(msg)
+		_ = c.ds.LogEvent(i.TenantID, types.EventWarning, types.EventCategoryInstance, msg)
+	}
+
+	if !recycle {
+		return expiryHandled
+	}
+
+	if !open {
+		return expiryDeferred
+	}
+
+	go func() {
+		ID, err := recycleInstance(c, i)
+		if err != nil {
+			msg := fmt.Sprintf("Unable to recycle expired instance %s: %v", i.ID, err)
+			glog.Warning(msg)
+			_ = c.ds.LogEvent(i.TenantID, types.EventError, types.EventCategoryInstance, msg)
+			return
+		}
+
+		if ID != "" {
+			msg := fmt.Sprintf("Expired instance %s replaced by instance %s", i.ID, ID)
+			_ = c.ds.LogEvent(i.TenantID, types.EventInfo, types.EventCategoryInstance, msg)
+		}
+	}()
+
+	return expiryHandled
+}
+
+// checkLifetimes looks for the instances older than the lifetime policy of
+// their workload.  Each expired instance is only handled once, possibly
+// deferred to the next maintenance window of its tenant.
+func (c *controller) checkLifetimes(now time.Time) {
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		glog.Warningf("Unable to check instance lifetimes: %v", err)
+		return
+	}
+
+	c.lifetimeLock.Lock()
+	defer c.lifetimeLock.Unlock()
+
+	policies := make(map[string]*types.LifetimePolicy)
+	expired := make(map[string]instanceExpiry)
+
+	for _, i := range instances {
+		if i.CNCI || i.State == payloads.Pending {
+			continue
+		}
+
+		policy, ok := policies[i.WorkloadID]
+		if !ok {
+			wl, err := c.ds.GetWorkload(i.WorkloadID)
+			if err == nil {
+				policy = wl.Lifetime
+			}
+			policies[i.WorkloadID] = policy
+		}
+
+		if policy == nil || policy.MaxAgeDays <= 0 {
+			continue
+		}
+
+		age := now.Sub(i.CreateTime)
+		if age < policy.MaxAge() {
+			continue
+		}
+
+		state := c.expiredInstances[i.ID]
+		if state != expiryHandled {
+			state = c.instanceExpired(i, *policy, age, state, now)
+		}
+		expired[i.ID] = state
+	}
+
+	// forget the expired instances which are gone
+	c.expiredInstances = expired
+}
+
+// startLifetimeChecker periodically checks the lifetimes of the instances
+// until stopLifetimeChecker is called.
+func (c *controller) startLifetimeChecker(interval time.Duration) {
+	c.lifetimeCheckStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.checkLifetimes(time.Now())
+			case <-c.lifetimeCheckStop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopLifetimeChecker() {
+	if c.lifetimeCheckStop != nil {
+		close(c.lifetimeCheckStop)
+		c.lifetimeCheckStop = nil
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestValidateLifetime(t *testing.T) {
+	tests := []struct {
+		policy types.LifetimePolicy
+		valid  bool
+	}{
+		{types.LifetimePolicy{MaxAgeDays: 30}, true},
+		{types.LifetimePolicy{MaxAgeDays: 7, Action: types.LifetimeRecycle}, true},
+		{types.LifetimePolicy{MaxAgeDays: 0}, false},
+		{types.LifetimePolicy{MaxAgeDays: -1, Action: types.LifetimeNotify}, false},
+		{types.LifetimePolicy{MaxAgeDays: 30, Action: "rebuild"}, false},
+	}
+
+	for _, test := range tests {
+		p := test.policy
+		err := validateLifetime(&p)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error for %+v: %v", test.policy, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Invalid lifetime policy %+v accepted", test.policy)
+		}
+
+		if test.valid && p.Action == "" {
+			t.Errorf("Default action not set in %+v", p)
+		}
+	}
+}
+
+func TestCheckLifetimes(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	wl := wls[0]
+	wl.ID = uuid.Generate().String()
+	wl.Lifetime = &types.LifetimePolicy{MaxAgeDays: 30, Action: types.LifetimeRecycle}
+	err = ctl.ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	newInstance := func(days int, protected bool) *types.Instance {
+		i := &types.Instance{
+			TenantID:   tenant.ID,
+			WorkloadID: wl.ID,
+			State:      payloads.Running,
+			ID:         uuid.Generate().String(),
+			CreateTime: now.Add(-time.Duration(days) * 24 * time.Hour),
+			Protected:  protected,
+		}
+		if err := ctl.ds.AddInstance(i); err != nil {
+			t.Fatal(err)
+		}
+		return i
+	}
+
+	expired := newInstance(31, false)
+	young := newInstance(29, false)
+	protected := newInstance(40, true)
+
+	recycled := make(chan string, 3)
+	saved := recycleInstance
+	recycleInstance = func(c *controller, i *types.Instance) (string, error) {
+		recycled <- i.ID
+		return uuid.Generate().String(), nil
+	}
+	defer func() { recycleInstance = saved }()
+
+	ctl.checkLifetimes(now)
+	ctl.checkLifetimes(now)
+
+	select {
+	case ID := <-recycled:
+		if ID != expired.ID {
+			t.Fatalf("Unexpected instance %s recycled", ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expired instance not recycled")
+	}
+
+	select {
+	case ID := <-recycled:
+		t.Fatalf("Instance %s recycled twice or unexpectedly", ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	logs, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(map[string][]string)
+	for _, e := range logs {
+		if e.TenantID != tenant.ID {
+			continue
+		}
+		for _, i := range []*types.Instance{expired, young, protected} {
+			if strings.Contains(e.Message, "instance "+i.ID) || strings.HasPrefix(e.Message, "Instance "+i.ID) {
+				events[i.ID] = append(events[i.ID], e.Message)
+			}
+		}
+	}
+
+	if len(events[young.ID]) != 0 {
+		t.Fatalf("Unexpected events for young instance: %v", events[young.ID])
+	}
+
+	if m := events[protected.ID]; len(m) != 1 || !strings.Contains(m[0], "not recycling protected instance") {
+		t.Fatalf("Unexpected events for protected instance: %v", m)
+	}
+
+	if m := events[expired.ID]; len(m) != 2 || !strings.Contains(m[0], "31 days old, past the 30 days lifetime") ||
+		!strings.Contains(m[1], "replaced by instance") {
+		t.Fatalf("Unexpected events for expired instance: %v", m)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// authLockoutReset is how long after its last failed authentication a
+// caller which is not locked out has its failures forgotten.
+const authLockoutReset = 24 * time.Hour
+
+func authLockoutID(kind types.AuthLockoutKind, name string) string {
+	return string(kind) + ":" + name
+}
+
+// requestSource returns the address of the client of a request.
+func requestSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// authLockoutDelay returns the duration of the lockout of a caller which
+// failed to authenticate failures times.
+func authLockoutDelay(failures int) time.Duration {
+	delay := *authLockoutDuration
+	for i := *authLockoutThreshold; i < failures && delay < *authLockoutMax; i++ {
+		delay *= 2
+	}
+
+	if delay > *authLockoutMax {
+		delay = *authLockoutMax
+	}
+
+	return delay
+}
+
+// pruneAuthLockouts forgets the callers which are not locked out and have
+// not failed to authenticate recently. authLockoutLock must be held.
+func (c *controller) pruneAuthLockouts(now time.Time) {
+	for ID, l := range c.authLockouts {
+		if now.After(l.LockedUntil) && now.Sub(l.LastFailure) > authLockoutReset {
+			delete(c.authLockouts, ID)
+		}
+	}
+}
+
+// authLocked returns how long requests of the caller identified by kind and
+// name are still rejected, or 0 if the caller is not locked out.
+func (c *controller) authLocked(kind types.AuthLockoutKind, name string) time.Duration {
+	if *authLockoutThreshold == 0 || name == "" {
+		return 0
+	}
+
+	c.authLockoutLock.Lock()
+	defer c.authLockoutLock.Unlock()
+
+	l, ok := c.authLockouts[authLockoutID(kind, name)]
+	if !ok {
+		return 0
+	}
+
+	remaining := time.Until(l.LockedUntil)
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// authFailed records a failed authentication of the caller identified by
+// kind and name, locking it out once it has failed too many times.
+func (c *controller) authFailed(kind types.AuthLockoutKind, name string, reason string) {
+	if *authLockoutThreshold == 0 || name == "" {
+		return
+	}
+
+	now := time.Now()
+
+	c.authLockoutLock.Lock()
+
+	if c.authLockouts == nil {
+		c.authLockouts = make(map[string]*types.AuthLockout)
+	}
+
+	c.pruneAuthLockouts(now)
+
+	ID := authLockoutID(kind, name)
+	l, ok := c.authLockouts[ID]
+	if !ok {
+		l = &types.AuthLockout{
+			ID:   ID,
+			Kind: kind,
+			Name: name,
+		}
+		c.authLockouts[ID] = l
+	}
+
+	l.Failures++
+	l.LastFailure = now
+
+	var delay time.Duration
+	if l.Failures >= *authLockoutThreshold {
+		delay = authLockoutDelay(l.Failures)
+		l.LockedUntil = now.Add(delay)
+	}
+
+	failures := l.Failures
+
+	c.authLockoutLock.Unlock()
+
+	glog.Warningf("Failed authentication of %s %s: %s", kind, name, reason)
+
+	if delay == 0 {
+		return
+	}
+
+	msg := fmt.Sprintf("Locked out %s %s for %v after %d failed authentications", kind, name, delay, failures)
+	glog.Warning(msg)
+
+	if err := c.ds.LogEvent("", types.EventWarning, types.EventCategoryAuth, msg); err != nil {
+		glog.Warningf("Error logging authentication lockout: %v", err)
+	}
+}
+
+// authSucceeded forgets the failed authentications of an account which is
+// not locked out.
+func (c *controller) authSucceeded(account string) {
+	if account == "" {
+		return
+	}
+
+	c.authLockoutLock.Lock()
+	defer c.authLockoutLock.Unlock()
+
+	ID := authLockoutID(types.AuthLockoutAccount, account)
+	if l, ok := c.authLockouts[ID]; ok && time.Now().After(l.LockedUntil) {
+		delete(c.authLockouts, ID)
+	}
+}
+
+// ListAuthLockouts returns the client addresses and accounts which failed
+// to authenticate recently, whether they are locked out or not.
+func (c *controller) ListAuthLockouts() ([]types.AuthLockout, error) {
+	c.authLockoutLock.Lock()
+	defer c.authLockoutLock.Unlock()
+
+	c.pruneAuthLockouts(time.Now())
+
+	lockouts := make([]types.AuthLockout, 0, len(c.authLockouts))
+	for _, l := range c.authLockouts {
+		lockouts = append(lockouts, *l)
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].ID < lockouts[j].ID
+	})
+
+	return lockouts, nil
+}
+
+// ClearAuthLockout forgets the failed authentications of a client address
+// or account, lifting its lockout.
+func (c *controller) ClearAuthLockout(ID string) error {
+	c.authLockoutLock.Lock()
+
+	l, ok := c.authLockouts[ID]
+	if ok {
+		delete(c.authLockouts, ID)
+	}
+
+	c.authLockoutLock.Unlock()
+
+	if !ok {
+		return types.ErrAuthLockoutNotFound
+	}
+
+	msg := fmt.Sprintf("Cleared authentication lockout of %s %s", l.Kind, l.Name)
+	glog.Info(msg)
+
+	if err := c.ds.LogEvent("", types.EventInfo, types.EventCategoryAuth, msg); err != nil {
+		glog.Warningf("Error logging authentication lockout: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/gorilla/mux"
+)
+
+func TestAuthLockoutDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{20, time.Hour},
+	}
+
+	for _, test := range tests {
+		if d := authLockoutDelay(test.failures); d != test.delay {
+			t.Errorf("%d failures: expected lockout of %v, got %v", test.failures, test.delay, d)
+		}
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threshold := *authLockoutThreshold
+	*authLockoutThreshold = 3
+	defer func() { *authLockoutThreshold = threshold }()
+
+	r := mux.NewRouter()
+	r.Handle("/{tenant}/instances", &clientCertAuthHandler{
+		Controller: ctl,
+		Next:       http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Resource:   "instances",
+	})
+
+	request := func(source string, tenantID string) int {
+		req := httptest.NewRequest("GET", "/"+tenantID+"/instances", nil)
+		req.RemoteAddr = source + ":4242"
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{
+					CommonName:   "lockout-" + source,
+					Organization: []string{tenant.ID},
+				},
+			}}},
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	source := "192.0.2.1"
+
+	for i := 0; i < 2; i++ {
+		if code := request(source, other.ID); code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, code)
+		}
+	}
+
+	if code := request(source, tenant.ID); code != http.StatusOK {
+		t.Fatalf("Caller locked out before reaching the threshold: %d", code)
+	}
+
+	if code := request(source, other.ID); code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	if code := request(source, tenant.ID); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected caller to be locked out, got %d", code)
+	}
+
+	lockouts, err := ctl.ListAuthLockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var locked *types.AuthLockout
+	for i := range lockouts {
+		if lockouts[i].ID == "source:"+source {
+			locked = &lockouts[i]
+		}
+	}
+
+	if locked == nil || locked.Failures != 3 || !locked.LockedUntil.After(time.Now()) {
+		t.Fatalf("Source lockout not reported: %+v", locked)
+	}
+
+	log, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged := false
+	for _, e := range log {
+		if strings.Contains(e.Message, "Locked out source "+source) {
+			logged = true
+		}
+	}
+
+	if !logged {
+		t.Error("Lockout not recorded in the event log")
+	}
+
+	if err := ctl.ClearAuthLockout(locked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := request(source, tenant.ID); code != http.StatusOK {
+		t.Fatalf("Expected lockout to be cleared, got %d", code)
+	}
+
+	if err := ctl.ClearAuthLockout(locked.ID); err != types.ErrAuthLockoutNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrAuthLockoutNotFound, err)
+	}
+}
+
+func TestAuthLockoutAdminExempt(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threshold := *authLockoutThreshold
+	*authLockoutThreshold = 1
+	defer func() { *authLockoutThreshold = threshold }()
+
+	r := mux.NewRouter()
+	if err := ctl.createCiaoRoutes(r); err != nil {
+		t.Fatal(err)
+	}
+
+	source := "192.0.2.2"
+
+	request := func(method string, path string, organization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = source + ":4242"
+		req.Header.Set("Content-Type", "application/json")
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{
+					CommonName:   "lockout-" + organization,
+					Organization: []string{organization},
+				},
+			}}},
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tenantServers := "/" + other.ID + "/instances/detail"
+	if w := request("GET", tenantServers, tenant.ID); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	tenantServers = "/" + tenant.ID + "/instances/detail"
+	if w := request("GET", tenantServers, tenant.ID); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected caller to be locked out, got %d", w.Code)
+	}
+
+	w := request("GET", "/auth/lockouts", "admin")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Admin locked out of the lockout list: %d", w.Code)
+	}
+
+	for _, lockoutID := range []string{"source:" + source, "account:lockout-" + tenant.ID} {
+		if !strings.Contains(w.Body.String(), lockoutID) {
+			t.Fatalf("Lockout %s not listed: %s", lockoutID, w.Body.String())
+		}
+
+		if w := request("DELETE", "/auth/lockouts/"+lockoutID, "admin"); w.Code != http.StatusNoContent {
+			t.Fatalf("Admin unable to clear lockout %s: %d", lockoutID, w.Code)
+		}
+	}
+
+	if w := request("GET", tenantServers, tenant.ID); w.Code != http.StatusOK {
+		t.Fatalf("Expected lockout to be cleared, got %d", w.Code)
+	}
+}
@@ -22,20 +22,23 @@ import (
 	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
 	"github.com/ciao-project/ciao/ciao-controller/internal/quotas"
+	"github.com/ciao-project/ciao/ciao-controller/types"
 	storage "github.com/ciao-project/ciao/ciao-storage"
 	"github.com/ciao-project/ciao/clogger/gloginterface"
 	"github.com/ciao-project/ciao/database"
 	"github.com/ciao-project/ciao/osprepare"
-	"github.com/ciao-project/ciao/ssntp"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
@@ -54,23 +57,131 @@ type controller struct {
 	tenantReadinessLock sync.Mutex
 	qs                  *quotas.Quotas
 	httpServers         []*http.Server
+	reconcileStop       chan struct{}
+	volumeCheckStop     chan struct{}
+	warmPoolStop        chan struct{}
+	bootCheckStop       chan struct{}
+	lifetimeCheckStop   chan struct{}
+	eventPruneStop      chan struct{}
+	volumeCheckLock     sync.Mutex
+	volumeCheck         types.VolumeCheckResponse
+	volumeBatches       map[string]*types.VolumeBatchStatus
+	volumeBatchLock     sync.Mutex
+	remaps              map[string]string
+	remapLock           sync.Mutex
+	drains              map[string]*types.NodeDrainStatus
+	drainLock           sync.Mutex
+	nodeCommands        map[string]*types.NodeCommandStatus
+	nodeCommandLock     sync.Mutex
+	authLockouts        map[string]*types.AuthLockout
+	authLockoutLock     sync.Mutex
+	rebalance           *types.RebalanceStatus
+	rebalanceLock       sync.Mutex
+	resubnets           map[string]*types.TenantResubnetStatus
+	resubnetLock        sync.Mutex
+	transfers           map[string]*types.InstanceTransferStatus
+	transferLock        sync.Mutex
+	confirmations       map[string]pendingConfirmation
+	confirmLock         sync.Mutex
+	healthAlerts        map[string]map[string]bool
+	healthLock          sync.Mutex
+	diskUsage           map[string]*instanceDiskUsage
+	tenantDiskAlerts    map[string]bool
+	diskPolicy          diskUsagePolicy
+	diskLock            sync.Mutex
+	notifier            *notifier
+	reporter            *reporter
+	vendorData          string
+	faults              *faultInjector
+	capture             *frameRecorder
+	frames              frameFilter
+	dispatcher          *launchDispatcher
+	launches            map[string]*pendingLaunch
+	launchLock          sync.Mutex
+	storagePools        map[string]*storagePool
+	defaultPool         string
+	images              imageCaches
+	imageLock           sync.Mutex
+	warmPoolLock        sync.Mutex
+	boots               map[string]time.Time
+	bootLock            sync.Mutex
+	shadow              *shadowScheduler
+	objectStore         *objectStore
+	imageExports        map[string]*types.ImageExportStatus
+	imageExportLock     sync.Mutex
+	stacks              map[string]*types.StackStatus
+	stackLock           sync.Mutex
+	unhealthyInstances  map[string]bool
+	instanceHealthLock  sync.Mutex
+	expiredInstances    map[string]instanceExpiry
+	lifetimeLock        sync.Mutex
+	hotAddLock          sync.Mutex
+	verifyLock          sync.Mutex
 }
 
 var cert = flag.String("cert", "", "Client certificate")
 var caCert = flag.String("cacert", "", "CA certificate")
 var serverURL = flag.String("url", "", "Server URL")
+var transportName = flag.String("transport", "ssntp", "transport used to exchange commands and events with the agents")
 var prepare = flag.Bool("osprepare", false, "Install dependencies")
 var controllerAPIPort = api.Port
 var httpsCAcert = "/etc/pki/ciao/ciao-controller-cacert.pem"
 var httpsKey = "/etc/pki/ciao/ciao-controller-key.pem"
 var workloadsPath = flag.String("workloads_path", "/var/lib/ciao/data/controller/workloads", "path to yaml files")
-var persistentDatastoreLocation = flag.String("database_path", "/var/lib/ciao/data/controller/ciao-controller.db", "path to persistent database")
+var persistentDatastoreLocation = flag.String("database_path", "/var/lib/ciao/data/controller/ciao-controller.db", "path to persistent database, or datastore URI: sqlite:///path or etcd://host:2379/prefix")
+var restoreBackup = flag.String("restore", "", "path to a backup written by the backup-db command, restored into the empty datastore at startup")
+var replicaDatastoreLocation = flag.String("database_replica_path", "", "path to read only replica of the persistent database used for reporting queries, sqlite datastores only")
 var logDir = "/var/lib/ciao/logs/controller"
 
 var clientCertCAPath = "/etc/pki/ciao/auth-CA.pem"
 
+var compressResponses bool
+var enableHTTP2 = true
+
 var cephID = flag.String("ceph_id", "", "ceph client id")
 
+var reconcileInterval = flag.Duration("reconcile_interval", 5*time.Minute, "interval between reconciliations of instances against node reports, 0 to disable")
+var orphanCleanup = flag.String("orphan_cleanup", "", "comma separated orphaned instances cleanup policies: delete_unknown, mark_missing")
+
+var volumeCheckInterval = flag.Duration("volume_check_interval", 30*time.Minute, "interval between checks of volumes against block devices, 0 to disable")
+var volumeCheckPolicyFlag = flag.String("volume_check_policy", "", "comma separated volume mismatch policies: resync_size, mark_error")
+
+var simulate = flag.Bool("simulate", false, "Run against simulated nodes with an in-memory datastore, for development only")
+var simulatedNodes = flag.Int("simulated_nodes", 4, "number of compute nodes simulated with -simulate")
+
+// CNCI resources used with -simulate, as there is no cluster configuration.
+const (
+	simulatedCNCIVcpus = 4
+	simulatedCNCIMem   = 2048
+	simulatedCNCIDisk  = 2048
+)
+
+var faultInjection = flag.Bool("fault_injection", false, "Allow faults to be injected through the API, for testing only")
+
+var nodeMaxTemperature = flag.Int("node_max_temperature", 90, "temperature in Celsius above which a node sensor raises a health alert, 0 to disable")
+var nodeMaxECCErrors = flag.Int("node_max_ecc_errors", 100, "number of corrected memory errors above which a node raises a health alert, 0 to disable")
+
+var smtpServer = flag.String("smtp_server", "", "host:port of the SMTP server used to notify tenants of error events, empty to disable notifications")
+var smtpFrom = flag.String("smtp_from", "ciao-controller@localhost", "sender address of tenant notifications")
+var notificationTemplate = flag.String("notification_template", "", "path to a text/template defining the \"subject\" and \"body\" of tenant notifications")
+var notificationRateLimit = flag.Duration("notification_rate_limit", 10*time.Minute, "minimum interval between two immediate notifications of a tenant")
+
+var vendorDataPath = flag.String("cloudinit_vendor_data", "", "path to the cloud-init vendor data passed to qemu instances")
+
+var drainTimeout = flag.Duration("drain_timeout", 30*time.Minute, "time allowed for the instances of a drained node to be evacuated")
+var nodeCommandTimeout = flag.Duration("node_command_timeout", 5*time.Minute, "time allowed for a node to report the result of a restart or self-test command")
+
+var launchRate = flag.Int("launch_rate", 0, "maximum number of instance launches sent to the scheduler per second, 0 for no limit")
+var launchConcurrency = flag.Int("launch_concurrency", 0, "maximum number of instance launches being dispatched at once, 0 for no limit")
+
+var authLockoutThreshold = flag.Int("auth_lockout_threshold", 5, "number of failed authentications after which a client address or account is locked out, 0 to disable")
+var authLockoutDuration = flag.Duration("auth_lockout_duration", time.Minute, "duration of the first lockout of a client address or account, doubled on each further failure")
+var authLockoutMax = flag.Duration("auth_lockout_max", time.Hour, "maximum duration of a lockout")
+
+var imageSignersPath = flag.String("image_signers", "", "directory of the PEM encoded public keys, named <signer>.pem, trusted to sign images")
+
+var maxInstancesPerRequest = flag.Int("max_instances_per_request", 1000, "maximum number of instances started by a single request, unless overridden for the tenant, 0 for no limit")
+
 var adminSSHKey = ""
 
 func init() {
@@ -131,6 +242,22 @@ func main() {
 		return
 	}
 
+	if flag.Arg(0) == migrateDBCommand {
+		if err := migrateDB(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == backupDBCommand {
+		if err := backupDB(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var wg sync.WaitGroup
 	var err error
 
@@ -139,17 +266,91 @@ func main() {
 	ctl.ds = new(datastore.Datastore)
 	ctl.qs = new(quotas.Quotas)
 
+	if *vendorDataPath != "" {
+		vendorData, err := ioutil.ReadFile(*vendorDataPath)
+		if err != nil {
+			glog.Fatalf("Unable to read cloud-init vendor data: %v", err)
+			return
+		}
+		ctl.vendorData = string(vendorData)
+	}
+
+	if *imageExportEndpoint != "" {
+		ctl.objectStore, err = newObjectStore(*imageExportEndpoint, *imageExportBucket,
+			*imageExportRegion, *imageExportCredentials)
+		if err != nil {
+			glog.Fatalf("Invalid image export object storage: %v", err)
+			return
+		}
+	}
+
 	dsConfig := datastore.Config{
 		PersistentURI:     "file:" + *persistentDatastoreLocation,
 		InitWorkloadsPath: *workloadsPath,
 	}
 
+	if strings.Contains(*persistentDatastoreLocation, "://") {
+		dsConfig, err = datastoreConfig(*persistentDatastoreLocation)
+		if err != nil {
+			glog.Fatalf("Invalid -database_path: %v", err)
+			return
+		}
+	}
+
+	if *faultInjection {
+		glog.Warning("Fault injection enabled")
+		ctl.faults = newFaultInjector()
+		dsConfig.InjectFaults = true
+	}
+
+	if *frameCapture != "" {
+		glog.Warningf("Capturing SSNTP frames to %s", *frameCapture)
+		ctl.capture, err = newFrameRecorder(*frameCapture, *frameCaptureMax)
+		if err != nil {
+			glog.Fatalf("Unable to capture frames: %v", err)
+			return
+		}
+	}
+
+	if *launchRate > 0 || *launchConcurrency > 0 {
+		ctl.dispatcher = newLaunchDispatcher(*launchConcurrency, *launchRate)
+	}
+
+	if *shadowPolicyFlag != "" {
+		policy, err := parsePlacementPolicy(*shadowPolicyFlag)
+		if err != nil {
+			glog.Fatalf("Invalid shadow scheduler policy: %v", err)
+			return
+		}
+
+		glog.Infof("Evaluating the %s placement policy in shadow mode", policy)
+		ctl.shadow = newShadowScheduler(policy)
+	}
+
+	if *simulate {
+		dsConfig.DBBackend = &datastore.MemoryDB{}
+	} else if *replicaDatastoreLocation != "" {
+		err = setReadReplica(&dsConfig, *replicaDatastoreLocation)
+		if err != nil {
+			glog.Fatalf("Invalid -database_replica_path: %v", err)
+			return
+		}
+	}
+
 	err = ctl.ds.Init(dsConfig)
 	if err != nil {
 		glog.Fatalf("unable to Init datastore: %s", err)
 		return
 	}
 
+	if *restoreBackup != "" {
+		err = restoreDB(ctl.ds, *restoreBackup)
+		if err != nil {
+			glog.Fatalf("Unable to restore datastore: %v", err)
+			return
+		}
+	}
+
 	ctl.qs.Init()
 	err = populateQuotasFromDatastore(ctl.qs, ctl.ds)
 	if err != nil {
@@ -157,61 +358,99 @@ func main() {
 		return
 	}
 
-	config := &ssntp.Config{
-		URI:    *serverURL,
-		CAcert: *caCert,
-		Cert:   *cert,
-		Log:    ssntp.Log,
+	if *simulate {
+		glog.Warning("Running against simulated nodes")
+		ctl.client = newSimulatedClient(ctl, *simulatedNodes)
+		ctl.ds.GenerateCNCIWorkload(simulatedCNCIVcpus, simulatedCNCIMem, simulatedCNCIDisk, "")
+		ctl.BlockDriver = &storage.NoopDriver{}
+	} else {
+		err = connectCluster(ctl)
+		if err != nil {
+			glog.Fatalf("Unable to connect to the cluster: %v", err)
+			return
+		}
 	}
 
-	ctl.client, err = newSSNTPClient(ctl, config)
-	if err != nil {
-		// spawn some retry routine?
-		glog.Fatalf("unable to connect to SSNTP server")
-		return
-	}
+	database.Logger = gloginterface.CiaoGlogLogger{}
 
-	ssntpClient := ctl.client.ssntpClient()
-	clusterConfig, err := ssntpClient.ClusterConfiguration()
+	err = initializeCNCICtrls(ctl)
 	if err != nil {
-		glog.Fatalf("Unable to retrieve Cluster Configuration: %v", err)
+		glog.Fatal("Unable to initialize CNCI controllers: ", err)
 		return
 	}
 
-	controllerAPIPort = clusterConfig.Configure.Controller.CiaoPort
-	httpsCAcert = clusterConfig.Configure.Controller.HTTPSCACert
-	httpsKey = clusterConfig.Configure.Controller.HTTPSKey
-	if *cephID == "" {
-		*cephID = clusterConfig.Configure.Storage.CephID
+	if *reconcileInterval > 0 {
+		policy, err := parseOrphanPolicy(*orphanCleanup)
+		if err != nil {
+			glog.Fatalf("Invalid orphan cleanup policy: %v", err)
+			return
+		}
+
+		ctl.startReconciler(*reconcileInterval, policy)
 	}
 
-	cnciVCPUs := clusterConfig.Configure.Controller.CNCIVcpus
-	cnciMem := clusterConfig.Configure.Controller.CNCIMem
-	cnciDisk := clusterConfig.Configure.Controller.CNCIDisk
+	if *volumeCheckInterval > 0 {
+		policy, err := parseVolumeCheckPolicy(*volumeCheckPolicyFlag)
+		if err != nil {
+			glog.Fatalf("Invalid volume check policy: %v", err)
+			return
+		}
 
-	adminSSHKey = clusterConfig.Configure.Controller.AdminSSHKey
+		ctl.startVolumeChecker(*volumeCheckInterval, policy)
+	}
 
-	if clusterConfig.Configure.Controller.ClientAuthCACertPath != "" {
-		clientCertCAPath = clusterConfig.Configure.Controller.ClientAuthCACertPath
+	if *warmPoolInterval > 0 {
+		ctl.startWarmPools(*warmPoolInterval)
 	}
 
-	ctl.ds.GenerateCNCIWorkload(cnciVCPUs, cnciMem, cnciDisk, adminSSHKey)
+	if *bootTimeout > 0 {
+		policy, err := parseBootTimeoutPolicy(*bootTimeoutPolicyFlag)
+		if err != nil {
+			glog.Fatalf("Invalid boot timeout policy: %v", err)
+			return
+		}
 
-	database.Logger = gloginterface.CiaoGlogLogger{}
+		ctl.startBootChecker(*bootTimeout, policy)
+	}
 
-	ctl.BlockDriver = func() storage.BlockDriver {
-		driver := storage.CephDriver{
-			ID: *cephID,
-		}
-		return driver
-	}()
+	if *lifetimeCheckInterval > 0 {
+		ctl.startLifetimeChecker(*lifetimeCheckInterval)
+	}
 
-	err = initializeCNCICtrls(ctl)
+	ctl.startEventPruner()
+
+	ctl.diskPolicy, err = parseDiskUsagePolicy(*diskUsagePolicyFlag)
 	if err != nil {
-		glog.Fatal("Unable to initialize CNCI controllers: ", err)
+		glog.Fatalf("Invalid disk usage policy: %v", err)
 		return
 	}
 
+	if *smtpServer != "" {
+		tmpl, err := parseNotificationTemplate(*notificationTemplate)
+		if err != nil {
+			glog.Fatalf("Invalid notification template: %v", err)
+			return
+		}
+
+		ctl.startNotifier(newNotifier(ctl.ds.GetTenant, smtpSender(*smtpServer, *smtpFrom),
+			tmpl, *notificationRateLimit))
+	}
+
+	if *reportConfigPath != "" {
+		schedules, err := loadReportSchedules(*reportConfigPath, *smtpServer != "", ctl.objectStore != nil)
+		if err != nil {
+			glog.Fatalf("Invalid report schedules: %v", err)
+			return
+		}
+
+		var send mailSender
+		if *smtpServer != "" {
+			send = smtpSender(*smtpServer, *smtpFrom)
+		}
+
+		ctl.startReporter(newReporter(ctl, schedules, send, time.Now()))
+	}
+
 	host, err := getNameFromCert(httpsCAcert, httpsKey)
 	if err != nil {
 		glog.Warningf("Unable to get name from certificate: %s", err)
@@ -232,6 +471,15 @@ func main() {
 		s := <-signalCh
 		glog.Warningf("Received signal: %s", s)
 		ctl.ShutdownHTTPServers()
+		ctl.dispatcher.shutdown()
+		ctl.stopReconciler()
+		ctl.stopVolumeChecker()
+		ctl.stopWarmPools()
+		ctl.stopBootChecker()
+		ctl.stopLifetimeChecker()
+		ctl.stopEventPruner()
+		ctl.stopNotifier()
+		ctl.stopReporter()
 		shutdownCNCICtrls(ctl)
 	}()
 
@@ -250,5 +498,72 @@ func main() {
 	ctl.qs.Shutdown()
 	ctl.ds.Exit()
 	ctl.client.Disconnect()
+	ctl.capture.close()
 	glog.Flush()
 }
+
+// connectCluster connects the controller to the cluster through the
+// transport selected by -transport and configures it from the cluster
+// configuration.
+func connectCluster(c *controller) error {
+	t, err := newTransport(*transportName, transportConfig{
+		URI:    *serverURL,
+		CACert: *caCert,
+		Cert:   *cert,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.client, err = newControllerClient(c, t)
+	if err != nil {
+		return errors.Wrapf(err, "unable to connect to the cluster over %s", *transportName)
+	}
+
+	clusterConfig, err := t.ClusterConfiguration()
+	if err != nil {
+		return errors.Wrap(err, "Unable to retrieve Cluster Configuration")
+	}
+
+	controllerAPIPort = clusterConfig.Configure.Controller.CiaoPort
+	httpsCAcert = clusterConfig.Configure.Controller.HTTPSCACert
+	httpsKey = clusterConfig.Configure.Controller.HTTPSKey
+	if *cephID == "" {
+		*cephID = clusterConfig.Configure.Storage.CephID
+	}
+
+	cnciVCPUs := clusterConfig.Configure.Controller.CNCIVcpus
+	cnciMem := clusterConfig.Configure.Controller.CNCIMem
+	cnciDisk := clusterConfig.Configure.Controller.CNCIDisk
+
+	adminSSHKey = clusterConfig.Configure.Controller.AdminSSHKey
+
+	compressResponses = clusterConfig.Configure.Controller.CompressResponses
+	enableHTTP2 = !clusterConfig.Configure.Controller.DisableHTTP2
+
+	if clusterConfig.Configure.Controller.ClientAuthCACertPath != "" {
+		clientCertCAPath = clusterConfig.Configure.Controller.ClientAuthCACertPath
+	}
+
+	c.ds.GenerateCNCIWorkload(cnciVCPUs, cnciMem, cnciDisk, adminSSHKey)
+
+	c.BlockDriver = func() storage.BlockDriver {
+		driver := storage.CephDriver{
+			ID: *cephID,
+		}
+		return driver
+	}()
+
+	err = c.initStoragePools(clusterConfig.Configure.Storage, func(cephPool string) storage.BlockDriver {
+		return storage.CephDriver{
+			ID:           *cephID,
+			Pool:         cephPool,
+			SnapshotPool: imagePool,
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "Invalid storage pools")
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// maintenanceOverridden returns true if the maintenance override of the
+// cluster lets disruptive automated actions run at any time.
+func (c *controller) maintenanceOverridden() bool {
+	s, err := c.ds.GetConfigSetting(datastore.MaintenanceOverride)
+	if err != nil {
+		return false
+	}
+
+	override, _ := strconv.ParseBool(s.Value)
+	return override
+}
+
+// maintenanceWindows returns the maintenance windows applying to the
+// instances of a tenant: those of the tenant, or of the cluster if the
+// tenant defines none.
+func (c *controller) maintenanceWindows(tenantID string) []types.MaintenanceWindow {
+	if tenant, err := c.ds.GetTenant(tenantID); err == nil && tenant != nil {
+		windows, err := tenant.MaintenanceWindows.Parse()
+		if err == nil && len(windows) > 0 {
+			return windows
+		}
+	}
+
+	s, err := c.ds.GetConfigSetting(datastore.MaintenanceWindows)
+	if err != nil {
+		return nil
+	}
+
+	windows, err := types.MaintenanceWindows(s.Value).Parse()
+	if err != nil {
+		glog.Warningf("Ignoring invalid maintenance windows %q", s.Value)
+		return nil
+	}
+
+	return windows
+}
+
+// inMaintenanceWindow returns true if disruptive automated actions, such
+// as rebalancing or recycling expired instances, may affect the instances
+// of a tenant at t: when no maintenance window applies to the tenant,
+// within one of them, or at any time when the maintenance override is
+// set.
+func (c *controller) inMaintenanceWindow(tenantID string, t time.Time) bool {
+	windows := c.maintenanceWindows(tenantID)
+	if len(windows) == 0 || c.maintenanceOverridden() {
+		return true
+	}
+
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
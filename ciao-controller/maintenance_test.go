@@ -0,0 +1,231 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// maintenanceTime returns a time of the week of Saturday 1 July 2017.
+func maintenanceTime(day int, hour int) time.Time {
+	return time.Date(2017, time.July, day, hour, 30, 0, 0, time.UTC)
+}
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	tests := []struct {
+		windows string
+		count   int
+		valid   bool
+	}{
+		{"", 0, true},
+		{"none", 0, true},
+		{"sat 22:00-02:00", 1, true},
+		{"* 03:00-04:00, Wed 12:00-12:00", 2, true},
+		{"sat", 0, false},
+		{"someday 22:00-02:00", 0, false},
+		{"sat 22:00", 0, false},
+		{"sat 25:00-02:00", 0, false},
+		{"sat 22:00-02:00,", 0, false},
+	}
+
+	for _, test := range tests {
+		windows, err := types.MaintenanceWindows(test.windows).Parse()
+		if (err == nil) != test.valid {
+			t.Errorf("Windows %q: unexpected error %v", test.windows, err)
+			continue
+		}
+
+		if len(windows) != test.count {
+			t.Errorf("Windows %q: expected %d windows, got %+v", test.windows, test.count, windows)
+		}
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	tests := []struct {
+		window   string
+		t        time.Time
+		expected bool
+	}{
+		{"sat 10:00-12:00", maintenanceTime(1, 10), true},
+		{"sat 10:00-12:00", maintenanceTime(1, 12), false},
+		{"sat 10:00-12:00", maintenanceTime(8, 11), true},
+		{"sat 10:00-12:00", maintenanceTime(2, 11), false},
+		{"sat 22:00-02:00", maintenanceTime(1, 23), true},
+		{"sat 22:00-02:00", maintenanceTime(2, 1), true},
+		{"sat 22:00-02:00", maintenanceTime(2, 2), false},
+		{"sat 22:00-02:00", maintenanceTime(1, 1), false},
+		{"sun 22:00-02:00", maintenanceTime(3, 1), true},
+		{"* 23:00-01:00", maintenanceTime(4, 0), true},
+		{"* 23:00-01:00", maintenanceTime(4, 12), false},
+		{"wed 12:00-12:00", maintenanceTime(6, 11), true},
+	}
+
+	for _, test := range tests {
+		windows, err := types.MaintenanceWindows(test.window).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if windows[0].Contains(test.t) != test.expected {
+			t.Errorf("Window %q at %v: expected %v", test.window, test.t, test.expected)
+		}
+	}
+}
+
+func TestInMaintenanceWindow(t *testing.T) {
+	tenant, err := ctl.ds.AddTenant(uuid.Generate().String(), types.TenantConfig{
+		SubnetBits:         24,
+		MaintenanceWindows: "* 10:00-11:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ctl.inMaintenanceWindow(other.ID, maintenanceTime(2, 3)) {
+		t.Fatal("Actions restricted without maintenance windows")
+	}
+
+	err = ctl.ds.SetConfigSetting(datastore.MaintenanceWindows, "sat 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.ds.SetConfigSetting(datastore.MaintenanceWindows, "") }()
+
+	if err := ctl.ds.SetConfigSetting(datastore.MaintenanceWindows, "sat"); err == nil {
+		t.Fatal("Invalid maintenance windows accepted")
+	}
+
+	tests := []struct {
+		tenantID string
+		t        time.Time
+		expected bool
+	}{
+		{other.ID, maintenanceTime(2, 1), true},
+		{other.ID, maintenanceTime(2, 3), false},
+		{tenant.ID, maintenanceTime(2, 1), false},
+		{tenant.ID, maintenanceTime(2, 10), true},
+	}
+
+	for _, test := range tests {
+		if ctl.inMaintenanceWindow(test.tenantID, test.t) != test.expected {
+			t.Errorf("Tenant %s at %v: expected %v", test.tenantID, test.t, test.expected)
+		}
+	}
+
+	err = ctl.ds.SetConfigSetting(datastore.MaintenanceOverride, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.ds.SetConfigSetting(datastore.MaintenanceOverride, "") }()
+
+	if !ctl.inMaintenanceWindow(other.ID, maintenanceTime(2, 3)) {
+		t.Fatal("Maintenance override ignored")
+	}
+}
+
+func TestLifetimeRecycleDeferred(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	wl := wls[0]
+	wl.ID = uuid.Generate().String()
+	wl.Lifetime = &types.LifetimePolicy{MaxAgeDays: 30, Action: types.LifetimeRecycle}
+	err = ctl.ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := maintenanceTime(2, 3)
+	instance := &types.Instance{
+		TenantID:   tenant.ID,
+		WorkloadID: wl.ID,
+		State:      payloads.Running,
+		ID:         uuid.Generate().String(),
+		CreateTime: closed.Add(-31 * 24 * time.Hour),
+	}
+	if err := ctl.ds.AddInstance(instance); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.ds.SetConfigSetting(datastore.MaintenanceWindows, "sat 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ctl.ds.SetConfigSetting(datastore.MaintenanceWindows, "") }()
+
+	recycled := make(chan string, 2)
+	saved := recycleInstance
+	recycleInstance = func(c *controller, i *types.Instance) (string, error) {
+		recycled <- i.ID
+		return "", nil
+	}
+	defer func() { recycleInstance = saved }()
+
+	ctl.checkLifetimes(closed)
+	ctl.checkLifetimes(closed)
+
+	select {
+	case <-recycled:
+		t.Fatal("Instance recycled outside of the maintenance windows")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ctl.checkLifetimes(maintenanceTime(8, 23))
+
+	select {
+	case ID := <-recycled:
+		if ID != instance.ID {
+			t.Fatalf("Unexpected instance %s recycled", ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Instance not recycled in the maintenance window")
+	}
+
+	logs, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, e := range logs {
+		if e.TenantID == tenant.ID && strings.Contains(e.Message, instance.ID) {
+			messages = append(messages, e.Message)
+		}
+	}
+
+	if len(messages) != 1 || !strings.Contains(messages[0], "recycling it in the next maintenance window") {
+		t.Fatalf("Unexpected events %v", messages)
+	}
+}
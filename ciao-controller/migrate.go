@@ -0,0 +1,126 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/pkg/errors"
+)
+
+// migrateDBCommand is the name of the command copying the content of a
+// datastore to another one.
+const migrateDBCommand = "migrate-db"
+
+// datastoreConfig returns the datastore configuration described by a
+// datastore URI.  The supported backends are sqlite, whose URIs are of
+// the form sqlite:///path/to/ciao-controller.db, and etcd, whose URIs
+// are of the form etcd://host:2379/prefix or etcds:// for HTTPS.  The
+// directory holding the workload definitions of a datastore defaults to
+// -workloads_path and can be set with the workloads_path query parameter.
+func datastoreConfig(URI string) (datastore.Config, error) {
+	u, err := url.Parse(URI)
+	if err != nil {
+		return datastore.Config{}, errors.Wrapf(err, "Invalid datastore URI %s", URI)
+	}
+
+	config := datastore.Config{
+		InitWorkloadsPath: *workloadsPath,
+	}
+
+	if p := u.Query().Get("workloads_path"); p != "" {
+		config.InitWorkloadsPath = p
+	}
+
+	switch u.Scheme {
+	case "sqlite":
+		if u.Path == "" {
+			return datastore.Config{}, fmt.Errorf("Missing database path in %s", URI)
+		}
+
+		config.PersistentURI = "file:" + u.Path
+		return config, nil
+
+	case "etcd", "etcds":
+		if u.Host == "" {
+			return datastore.Config{}, fmt.Errorf("Missing etcd endpoint in %s", URI)
+		}
+
+		config.PersistentURI = URI
+		return config, nil
+	}
+
+	return datastore.Config{}, fmt.Errorf("Unsupported datastore backend %q, supported backends: sqlite, etcd", u.Scheme)
+}
+
+// setReadReplica points the datastore described by config to a read only
+// copy of its database, for reporting queries.  Only sqlite datastores
+// have replicas.
+func setReadReplica(config *datastore.Config, path string) error {
+	if !strings.HasPrefix(config.PersistentURI, "file:") {
+		return fmt.Errorf("Read replicas are only supported by sqlite datastores, not %s", config.PersistentURI)
+	}
+
+	config.ReadReplicaURI = "file:" + path + "?mode=ro"
+	return nil
+}
+
+// migrateDB implements the migrate-db command, which copies all the
+// tables of a datastore to an empty datastore and verifies the copy.
+func migrateDB(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(migrateDBCommand, flag.ContinueOnError)
+	from := fs.String("from", "", "URI of the datastore to copy, e.g., sqlite:///var/lib/ciao/data/controller/ciao-controller.db")
+	to := fs.String("to", "", "URI of the empty datastore to copy to")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		return errors.New("Both -from and -to must be specified")
+	}
+
+	fromConfig, err := datastoreConfig(*from)
+	if err != nil {
+		return err
+	}
+
+	toConfig, err := datastoreConfig(*to)
+	if err != nil {
+		return err
+	}
+
+	if fromConfig.PersistentURI == toConfig.PersistentURI {
+		return errors.New("Source and destination datastores are the same")
+	}
+
+	report, err := datastore.Migrate(fromConfig, toConfig)
+	if err != nil {
+		return errors.Wrap(err, "Migration failed")
+	}
+
+	for _, table := range datastore.MigrationTables {
+		fmt.Fprintf(out, "%-16s %d\n", table, report[table])
+	}
+	fmt.Fprintf(out, "Copied and verified %s to %s\n", *from, *to)
+
+	return nil
+}
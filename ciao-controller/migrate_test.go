@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestDatastoreConfig(t *testing.T) {
+	config, err := datastoreConfig("sqlite:///var/lib/ciao/ciao-controller.db?workloads_path=/tmp/workloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.PersistentURI != "file:/var/lib/ciao/ciao-controller.db" {
+		t.Errorf("Unexpected persistent URI %s", config.PersistentURI)
+	}
+
+	if config.InitWorkloadsPath != "/tmp/workloads" {
+		t.Errorf("Unexpected workloads path %s", config.InitWorkloadsPath)
+	}
+
+	config, err = datastoreConfig("sqlite:///var/lib/ciao/ciao-controller.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.InitWorkloadsPath != *workloadsPath {
+		t.Errorf("Expected default workloads path, got %s", config.InitWorkloadsPath)
+	}
+
+	config, err = datastoreConfig("etcd://etcd.example.com:2379/ciao")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.PersistentURI != "etcd://etcd.example.com:2379/ciao" {
+		t.Errorf("Unexpected persistent URI %s", config.PersistentURI)
+	}
+
+	if config.InitWorkloadsPath != *workloadsPath {
+		t.Errorf("Expected default workloads path, got %s", config.InitWorkloadsPath)
+	}
+
+	for _, URI := range []string{"postgres://localhost/ciao", "sqlite://", "etcd:///ciao", "/var/lib/ciao/ciao-controller.db"} {
+		if _, err := datastoreConfig(URI); err == nil {
+			t.Errorf("Invalid datastore URI %s accepted", URI)
+		}
+	}
+}
+
+func TestSetReadReplica(t *testing.T) {
+	config, err := datastoreConfig("sqlite:///var/lib/ciao/ciao-controller.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setReadReplica(&config, "/var/lib/ciao/replica.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ReadReplicaURI != "file:/var/lib/ciao/replica.db?mode=ro" {
+		t.Errorf("Unexpected read replica URI %s", config.ReadReplicaURI)
+	}
+
+	config, err = datastoreConfig("etcd://etcd.example.com:2379/ciao")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setReadReplica(&config, "/var/lib/ciao/replica.db"); err == nil {
+		t.Error("Read replica of an etcd datastore accepted")
+	}
+}
+
+func TestMigrateDBSameDatastore(t *testing.T) {
+	err := migrateDB([]string{"-from", "sqlite:///tmp/ciao.db", "-to", "sqlite:///tmp/ciao.db"}, ioutil.Discard)
+	if err == nil {
+		t.Fatal("Migration to the source datastore succeeded")
+	}
+}
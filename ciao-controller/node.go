@@ -14,12 +14,24 @@
 
 package main
 
-import "github.com/golang/glog"
+import (
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// drainPollInterval is the interval at which a node drain checks the
+// instances left on the node.
+var drainPollInterval = 5 * time.Second
 
 func (c *controller) EvacuateNode(nodeID string) error {
 	// should I bother to see if nodeID is valid?
 	go func() {
-		if err := c.client.EvacuateNode(nodeID); err != nil {
+		if err := c.client.EvacuateNode(nodeID, uuid.Generate().String()); err != nil {
 			glog.Warningf("Error evacuating node")
 		}
 	}()
@@ -28,9 +40,123 @@ func (c *controller) EvacuateNode(nodeID string) error {
 
 func (c *controller) RestoreNode(nodeID string) error {
 	go func() {
-		if err := c.client.RestoreNode(nodeID); err != nil {
+		if err := c.client.RestoreNode(nodeID, uuid.Generate().String()); err != nil {
 			glog.Warning("Error restoring node")
 		}
 	}()
 	return nil
 }
+
+// DrainNode puts the node in maintenance mode and evacuates its
+// instances, removing the node once empty if del is set. The drain
+// proceeds in the background and its progress is reported by
+// GetNodeDrain.
+func (c *controller) DrainNode(nodeID string, del bool) (types.NodeDrainStatus, error) {
+	n, err := c.ds.GetNodeInstanceCount(nodeID)
+	if err != nil {
+		return types.NodeDrainStatus{}, err
+	}
+
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+
+	if c.drains == nil {
+		c.drains = make(map[string]*types.NodeDrainStatus)
+	}
+
+	d, ok := c.drains[nodeID]
+	if ok && d.State == types.NodeDrainEvacuating {
+		return *d, types.ErrNodeDraining
+	}
+
+	d = &types.NodeDrainStatus{
+		NodeID:    nodeID,
+		Delete:    del,
+		State:     types.NodeDrainEvacuating,
+		Instances: n,
+		Started:   time.Now(),
+	}
+	c.drains[nodeID] = d
+
+	go c.drainNode(nodeID, del, *drainTimeout)
+
+	return *d, nil
+}
+
+// GetNodeDrain returns the progress of the last drain of the node.
+func (c *controller) GetNodeDrain(nodeID string) (types.NodeDrainStatus, error) {
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+
+	d, ok := c.drains[nodeID]
+	if !ok {
+		return types.NodeDrainStatus{}, types.ErrNodeNotFound
+	}
+
+	return *d, nil
+}
+
+func (c *controller) drainNode(nodeID string, del bool, timeout time.Duration) {
+	err := c.client.EvacuateNode(nodeID, uuid.Generate().String())
+	if err == nil {
+		err = c.waitNodeEvacuated(nodeID, timeout, func(n int) {
+			c.drainLock.Lock()
+			c.drains[nodeID].Instances = n
+			c.drainLock.Unlock()
+		})
+	}
+
+	state := types.NodeDrainDone
+	if err == nil && del {
+		err = c.ds.RemoveNode(nodeID)
+		if err == nil {
+			c.frames.forget(nodeID)
+		}
+		state = types.NodeDrainDeleted
+	}
+
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+
+	d := c.drains[nodeID]
+	if err != nil {
+		glog.Warningf("Error draining node %s: %v", nodeID, err)
+		d.State = types.NodeDrainFailed
+		d.Error = err.Error()
+		return
+	}
+
+	glog.Infof("Node %s drained", nodeID)
+	d.State = state
+}
+
+// waitNodeEvacuated waits for the node to report no instance, calling
+// progress with the number of instances left. The wait fails if the node
+// disconnects, as its instances are then missing rather than evacuated.
+func (c *controller) waitNodeEvacuated(nodeID string, timeout time.Duration, progress func(int)) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	deadline := time.After(timeout)
+
+	for {
+		n, err := c.ds.GetNodeInstanceCount(nodeID)
+		if err == types.ErrNodeNotFound {
+			return errors.New("Node disconnected before being evacuated")
+		} else if err != nil {
+			return err
+		}
+
+		progress(n)
+
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return fmt.Errorf("Timed out with %d instances left on the node", n)
+		}
+	}
+}
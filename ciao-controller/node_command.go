@@ -0,0 +1,184 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+)
+
+// nodeCommandRetention is how long completed node commands are kept
+// around for their status to be queried.
+const nodeCommandRetention = 24 * time.Hour
+
+// RunNodeCommand sends a command to a node. The command proceeds in the
+// background and its result is reported by GetNodeCommand.
+func (c *controller) RunNodeCommand(nodeID string, command types.NodeCommandType) (types.NodeCommandStatus, error) {
+	var send func(nodeID string, commandID string) error
+
+	switch command {
+	case types.NodeCommandEvacuate:
+		send = c.client.EvacuateNode
+	case types.NodeCommandReconfigure:
+		send = c.client.reconfigureNode
+	case types.NodeCommandRestartAgent:
+		send = c.client.restartAgent
+	case types.NodeCommandSelfTest:
+		send = c.client.selfTest
+	default:
+		return types.NodeCommandStatus{}, types.ErrBadRequest
+	}
+
+	if _, err := c.ds.GetNodeInstanceCount(nodeID); err != nil {
+		return types.NodeCommandStatus{}, err
+	}
+
+	c.nodeCommandLock.Lock()
+	defer c.nodeCommandLock.Unlock()
+
+	if c.nodeCommands == nil {
+		c.nodeCommands = make(map[string]*types.NodeCommandStatus)
+	}
+
+	for ID, cmd := range c.nodeCommands {
+		if cmd.State != types.NodeCommandPending && time.Since(cmd.Completed) > nodeCommandRetention {
+			delete(c.nodeCommands, ID)
+			continue
+		}
+
+		if cmd.NodeID == nodeID && cmd.Command == command && cmd.State == types.NodeCommandPending {
+			return *cmd, types.ErrNodeCommandPending
+		}
+	}
+
+	cmd := &types.NodeCommandStatus{
+		ID:      uuid.Generate().String(),
+		NodeID:  nodeID,
+		Command: command,
+		State:   types.NodeCommandPending,
+		Started: time.Now(),
+	}
+	c.nodeCommands[cmd.ID] = cmd
+
+	go c.runNodeCommand(*cmd, send)
+
+	return *cmd, nil
+}
+
+func (c *controller) runNodeCommand(cmd types.NodeCommandStatus, send func(string, string) error) {
+	err := send(cmd.NodeID, cmd.ID)
+	if err != nil {
+		c.completeNodeCommand(cmd.ID, err, nil)
+		return
+	}
+
+	switch cmd.Command {
+	case types.NodeCommandEvacuate:
+		// the node reports the result once its instances are stopped.
+		time.AfterFunc(*drainTimeout, func() {
+			c.completeNodeCommand(cmd.ID, errors.New("Timed out waiting for the node to be evacuated"), nil)
+		})
+	case types.NodeCommandReconfigure:
+		// The scheduler does not acknowledge the configuration it
+		// sends, the node restarts if it needs to apply it.
+		c.completeNodeCommand(cmd.ID, nil, nil)
+	default:
+		time.AfterFunc(*nodeCommandTimeout, func() {
+			c.completeNodeCommand(cmd.ID, errors.New("Timed out waiting for the node"), nil)
+		})
+	}
+}
+
+// nodeCommandResult completes the node command reported by a
+// NodeCommandResult event.
+func (c *controller) nodeCommandResult(result payloads.NodeCommandResultEvent) {
+	var err error
+	if result.Error != "" {
+		err = errors.New(result.Error)
+	}
+
+	checks := make([]types.NodeSelfTestCheck, 0, len(result.Checks))
+	for _, check := range result.Checks {
+		checks = append(checks, types.NodeSelfTestCheck{
+			Name:    check.Name,
+			Success: check.Success,
+			Message: check.Message,
+		})
+	}
+
+	c.completeNodeCommand(result.CommandID, err, checks)
+}
+
+// completeNodeCommand records the outcome of a node command, unless it
+// already completed, e.g., a result received after the command timed out.
+func (c *controller) completeNodeCommand(ID string, err error, checks []types.NodeSelfTestCheck) {
+	c.nodeCommandLock.Lock()
+	defer c.nodeCommandLock.Unlock()
+
+	cmd, ok := c.nodeCommands[ID]
+	if !ok || cmd.State != types.NodeCommandPending {
+		return
+	}
+
+	cmd.Completed = time.Now()
+	cmd.Checks = checks
+	if err != nil {
+		glog.Warningf("Node %s command %s failed: %v", cmd.NodeID, cmd.Command, err)
+		cmd.State = types.NodeCommandFailed
+		cmd.Error = err.Error()
+		return
+	}
+
+	glog.Infof("Node %s command %s succeeded", cmd.NodeID, cmd.Command)
+	cmd.State = types.NodeCommandSucceeded
+}
+
+// GetNodeCommand returns the status of a command run on a node.
+func (c *controller) GetNodeCommand(nodeID string, commandID string) (types.NodeCommandStatus, error) {
+	c.nodeCommandLock.Lock()
+	defer c.nodeCommandLock.Unlock()
+
+	cmd, ok := c.nodeCommands[commandID]
+	if !ok || cmd.NodeID != nodeID {
+		return types.NodeCommandStatus{}, types.ErrNodeCommandNotFound
+	}
+
+	return *cmd, nil
+}
+
+// ListNodeCommands returns the commands run on a node, oldest first.
+func (c *controller) ListNodeCommands(nodeID string) ([]types.NodeCommandStatus, error) {
+	c.nodeCommandLock.Lock()
+	defer c.nodeCommandLock.Unlock()
+
+	commands := []types.NodeCommandStatus{}
+	for _, cmd := range c.nodeCommands {
+		if cmd.NodeID == nodeID {
+			commands = append(commands, *cmd)
+		}
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Started.Before(commands[j].Started)
+	})
+
+	return commands, nil
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/testutil"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestNodeSelfTest(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("NodeSelfTest", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	controllerCh := wrappedClient.addEventChan(ssntp.NodeCommandResult)
+
+	status, err := ctl.RunNodeCommand(client.UUID, types.NodeCommandSelfTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != types.NodeCommandPending || status.NodeID != client.UUID {
+		t.Fatalf("Unexpected command status %v", status)
+	}
+
+	err = wrappedClient.getEventChan(controllerCh, ssntp.NodeCommandResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = ctl.GetNodeCommand(client.UUID, status.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != types.NodeCommandSucceeded || len(status.Checks) != 1 ||
+		!status.Checks[0].Success {
+		t.Fatalf("Unexpected command status %v", status)
+	}
+
+	if status.Completed.Before(status.Started) {
+		t.Fatalf("Command completed before it started")
+	}
+
+	commands, err := ctl.ListNodeCommands(client.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, cmd := range commands {
+		found = found || cmd.ID == status.ID
+	}
+	if !found {
+		t.Fatalf("Command %s not listed", status.ID)
+	}
+
+	_, err = ctl.GetNodeCommand(uuid.Generate().String(), status.ID)
+	if err != types.ErrNodeCommandNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeCommandNotFound, err)
+	}
+}
+
+func TestNodeCommandEvacuate(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("NodeCommandEvacuate", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	ackCh := wrappedClient.addAckChan(1)
+
+	status, err := ctl.RunNodeCommand(client.UUID, types.NodeCommandEvacuate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wrappedClient.getAckChan(ackCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = ctl.GetNodeCommand(client.UUID, status.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != types.NodeCommandSucceeded {
+		t.Fatalf("Unexpected command status %v", status)
+	}
+}
+
+func TestNodeCommandTimeout(t *testing.T) {
+	// a node the scheduler cannot forward commands to
+	nodeID := uuid.Generate().String()
+	ctl.ds.AddNode(nodeID, payloads.ComputeNode)
+	defer func() { _ = ctl.ds.RemoveNode(nodeID) }()
+
+	defer func(timeout time.Duration) { *nodeCommandTimeout = timeout }(*nodeCommandTimeout)
+	*nodeCommandTimeout = 50 * time.Millisecond
+
+	status, err := ctl.RunNodeCommand(nodeID, types.NodeCommandRestartAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.RunNodeCommand(nodeID, types.NodeCommandRestartAgent)
+	if err != types.ErrNodeCommandPending {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeCommandPending, err)
+	}
+
+	for i := 0; i < 100 && status.State == types.NodeCommandPending; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		status, err = ctl.GetNodeCommand(nodeID, status.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status.State != types.NodeCommandFailed || status.Error == "" {
+		t.Fatalf("Unexpected command status %v", status)
+	}
+
+	// a late result must not override the timeout
+	ctl.nodeCommandResult(payloads.NodeCommandResultEvent{
+		CommandID: status.ID,
+		NodeUUID:  nodeID,
+	})
+
+	status, err = ctl.GetNodeCommand(nodeID, status.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != types.NodeCommandFailed {
+		t.Fatalf("Unexpected command status %v", status)
+	}
+}
+
+func TestNodeCommandReconfigure(t *testing.T) {
+	nodeID := uuid.Generate().String()
+	ctl.ds.AddNode(nodeID, payloads.ComputeNode)
+	defer func() { _ = ctl.ds.RemoveNode(nodeID) }()
+
+	status, err := ctl.RunNodeCommand(nodeID, types.NodeCommandReconfigure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && status.State == types.NodeCommandPending; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		status, err = ctl.GetNodeCommand(nodeID, status.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status.State != types.NodeCommandSucceeded {
+		t.Fatalf("Unexpected command status %v", status)
+	}
+}
+
+func TestNodeCommandInvalid(t *testing.T) {
+	_, err := ctl.RunNodeCommand(uuid.Generate().String(), types.NodeCommandSelfTest)
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrNodeNotFound, err)
+	}
+
+	_, err = ctl.RunNodeCommand(testutil.AgentUUID, types.NodeCommandType("reboot"))
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
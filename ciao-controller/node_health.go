@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// nodeHealthAlert is a health indicator of a node that crossed its
+// threshold.
+type nodeHealthAlert struct {
+	severity types.EventSeverity
+	message  string
+}
+
+// nodeHealthAlerts returns the health indicators of a node that crossed
+// their thresholds, a threshold of 0 disabling its check.  Uncorrected
+// memory errors and failing disks always raise a critical alert.
+func nodeHealthAlerts(h *payloads.NodeHealthStat, maxTemperature int, maxECCErrors int) []nodeHealthAlert {
+	var alerts []nodeHealthAlert
+
+	if h == nil {
+		return nil
+	}
+
+	for _, t := range h.Temperatures {
+		if maxTemperature > 0 && t.Celsius > maxTemperature {
+			alerts = append(alerts, nodeHealthAlert{types.EventWarning,
+				fmt.Sprintf("sensor %s above %d C", t.Sensor, maxTemperature)})
+		}
+	}
+
+	if maxECCErrors > 0 && h.CorrectedECCErrors > maxECCErrors {
+		alerts = append(alerts, nodeHealthAlert{types.EventWarning,
+			fmt.Sprintf("more than %d corrected memory errors", maxECCErrors)})
+	}
+
+	if h.UncorrectedECCErrors > 0 {
+		alerts = append(alerts, nodeHealthAlert{types.EventCritical,
+			"uncorrected memory errors"})
+	}
+
+	for _, d := range h.Disks {
+		if !d.Healthy {
+			alerts = append(alerts, nodeHealthAlert{types.EventCritical,
+				fmt.Sprintf("disk %s failing SMART health check", d.Device)})
+		}
+	}
+
+	return alerts
+}
+
+// checkNodeHealth compares the health reported by a node with the
+// thresholds of the controller.  Alerts are logged in the event log with
+// their severity when raised and as info events when cleared, not on every
+// sample.
+func (c *controller) checkNodeHealth(stat payloads.Stat) {
+	alerts := nodeHealthAlerts(stat.Health, *nodeMaxTemperature, *nodeMaxECCErrors)
+
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+
+	if c.healthAlerts == nil {
+		c.healthAlerts = make(map[string]map[string]bool)
+	}
+
+	active := make(map[string]bool)
+	for _, alert := range alerts {
+		active[alert.message] = true
+		if c.healthAlerts[stat.NodeUUID][alert.message] {
+			continue
+		}
+
+		msg := fmt.Sprintf("Node %s (%s) health alert: %s", stat.NodeHostName, stat.NodeUUID, alert.message)
+		glog.Warning(msg)
+		err := c.ds.LogEvent("", alert.severity, types.EventCategoryNode, msg)
+		if err != nil {
+			glog.Warningf("Error logging node health alert: %v", err)
+		}
+	}
+
+	for alert := range c.healthAlerts[stat.NodeUUID] {
+		if active[alert] {
+			continue
+		}
+
+		msg := fmt.Sprintf("Node %s (%s) health alert cleared: %s", stat.NodeHostName, stat.NodeUUID, alert)
+		err := c.ds.LogEvent("", types.EventInfo, types.EventCategoryNode, msg)
+		if err != nil {
+			glog.Warningf("Error logging node health alert: %v", err)
+		}
+	}
+
+	if len(active) == 0 {
+		delete(c.healthAlerts, stat.NodeUUID)
+		return
+	}
+
+	c.healthAlerts[stat.NodeUUID] = active
+}
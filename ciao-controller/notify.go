@@ -0,0 +1,277 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// notifyQueueSize is the number of events buffered between the
+	// datastore and the notifier.  Events are dropped when it is full.
+	notifyQueueSize = 1024
+
+	// notifyMaxEvents is the maximum number of events listed in a
+	// single email.  Older events are counted but not listed.
+	notifyMaxEvents = 100
+
+	// notifyCheckInterval is the interval at which pending
+	// notifications are checked.
+	notifyCheckInterval = time.Minute
+
+	// defaultDigestMinutes is the interval between digests of the
+	// tenants which do not configure one.
+	defaultDigestMinutes = 60
+)
+
+// defaultNotificationTemplate is used when no template is configured with
+// -notification_template.  A template must define both a subject and a body.
+const defaultNotificationTemplate = `{{define "subject"}}[ciao] {{len .Events}}{{if .Dropped}}+{{end}} error event(s) for {{if .TenantName}}{{.TenantName}}{{else}}{{.TenantID}}{{end}}{{end}}
+{{- define "body"}}The following error events were logged for tenant {{.TenantID}}:
+
+{{range .Events}}{{.Timestamp.Format "2006-01-02 15:04:05 MST"}} [{{.Severity}}/{{.Category}}] {{.Message}}
+{{end}}{{if .Dropped}}
+{{.Dropped}} older event(s) were not listed.
+{{end}}{{end}}`
+
+// notificationData is passed to the notification templates.
+type notificationData struct {
+	TenantID   string
+	TenantName string
+	Events     []types.LogEntry
+
+	// Dropped is the number of events not listed in Events.
+	Dropped int
+}
+
+// mailSender sends an email to the given recipient.
+type mailSender func(to string, subject string, body string) error
+
+// smtpSender returns a mailSender which relays emails through an SMTP
+// server.
+func smtpSender(server string, from string) mailSender {
+	return func(to string, subject string, body string) error {
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+			from, to, subject, strings.Replace(body, "\n", "\r\n", -1))
+		return smtp.SendMail(server, nil, from, []string{to}, []byte(msg))
+	}
+}
+
+// pendingNotification holds the events of a tenant waiting to be sent.
+type pendingNotification struct {
+	events  []types.LogEntry
+	dropped int
+
+	// due is the time at which the events can be sent.
+	due time.Time
+}
+
+// notifier emails tenants about the error events affecting their
+// resources, either as soon as they are logged or as periodic digests.
+// Immediate notifications are rate limited, the events logged within the
+// rate limit interval of the previous email being sent together.
+type notifier struct {
+	getTenant func(string) (*types.Tenant, error)
+	send      mailSender
+	tmpl      *template.Template
+	rateLimit time.Duration
+
+	events   chan types.LogEntry
+	stop     chan struct{}
+	pending  map[string]*pendingNotification
+	lastSent map[string]time.Time
+}
+
+func newNotifier(getTenant func(string) (*types.Tenant, error), send mailSender,
+	tmpl *template.Template, rateLimit time.Duration) *notifier {
+	return &notifier{
+		getTenant: getTenant,
+		send:      send,
+		tmpl:      tmpl,
+		rateLimit: rateLimit,
+		events:    make(chan types.LogEntry, notifyQueueSize),
+		pending:   make(map[string]*pendingNotification),
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+// parseNotificationTemplate parses the notification template stored in
+// path, or the default template if path is empty.
+func parseNotificationTemplate(path string) (*template.Template, error) {
+	text := defaultNotificationTemplate
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read notification template")
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to parse notification template")
+	}
+
+	for _, name := range []string{"subject", "body"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("Notification template does not define %q", name)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// eventLogged is registered as the event handler of the datastore.  It is
+// called with datastore locks held and so only queues the event.
+func (n *notifier) eventLogged(e types.LogEntry) {
+	if e.TenantID == "" || e.Severity.Level() < types.EventError.Level() {
+		return
+	}
+
+	select {
+	case n.events <- e:
+	default:
+		glog.Warningf("Notification queue full, dropping event for tenant %s", e.TenantID)
+	}
+}
+
+// queue adds an event to the pending notification of its tenant, sending
+// it straight away if the tenant asked for immediate notifications and
+// has not been notified recently.
+func (n *notifier) queue(e types.LogEntry, now time.Time) {
+	tenant, err := n.getTenant(e.TenantID)
+	if err != nil || tenant == nil {
+		return
+	}
+
+	config := tenant.Notifications
+	if !config.Enabled() {
+		return
+	}
+
+	p := n.pending[e.TenantID]
+	if p == nil {
+		p = &pendingNotification{}
+		switch config.Mode {
+		case types.NotifyImmediate:
+			p.due = n.lastSent[e.TenantID].Add(n.rateLimit)
+		case types.NotifyDigest:
+			minutes := config.DigestMinutes
+			if minutes == 0 {
+				minutes = defaultDigestMinutes
+			}
+			p.due = now.Add(time.Duration(minutes) * time.Minute)
+		}
+		n.pending[e.TenantID] = p
+	}
+
+	p.events = append(p.events, e)
+	if len(p.events) > notifyMaxEvents {
+		p.events = p.events[1:]
+		p.dropped++
+	}
+
+	if config.Mode == types.NotifyImmediate && !now.Before(p.due) {
+		n.notify(e.TenantID, now)
+	}
+}
+
+// flush sends the pending notifications which are due.
+func (n *notifier) flush(now time.Time) {
+	for tenantID, p := range n.pending {
+		if !now.Before(p.due) {
+			n.notify(tenantID, now)
+		}
+	}
+}
+
+// notify emails the pending events of a tenant to the address it
+// currently configures.  The events are discarded if the email cannot be
+// sent.
+func (n *notifier) notify(tenantID string, now time.Time) {
+	p := n.pending[tenantID]
+	delete(n.pending, tenantID)
+
+	tenant, err := n.getTenant(tenantID)
+	if err != nil || tenant == nil || !tenant.Notifications.Enabled() {
+		return
+	}
+
+	data := notificationData{
+		TenantID:   tenantID,
+		TenantName: tenant.Name,
+		Events:     p.events,
+		Dropped:    p.dropped,
+	}
+
+	var subject, body bytes.Buffer
+	err = n.tmpl.ExecuteTemplate(&subject, "subject", data)
+	if err == nil {
+		err = n.tmpl.ExecuteTemplate(&body, "body", data)
+	}
+	if err != nil {
+		glog.Warningf("Unable to format notification for tenant %s: %v", tenantID, err)
+		return
+	}
+
+	n.lastSent[tenantID] = now
+
+	err = n.send(tenant.Notifications.Email, strings.TrimSpace(subject.String()), body.String())
+	if err != nil {
+		glog.Warningf("Unable to notify tenant %s: %v", tenantID, err)
+	}
+}
+
+func (n *notifier) run() {
+	ticker := time.NewTicker(notifyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-n.events:
+			n.queue(e, time.Now())
+		case now := <-ticker.C:
+			n.flush(now)
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (c *controller) startNotifier(n *notifier) {
+	n.stop = make(chan struct{})
+	c.notifier = n
+	c.ds.SetEventHandler(n.eventLogged)
+
+	go n.run()
+}
+
+func (c *controller) stopNotifier() {
+	if c.notifier != nil {
+		c.ds.SetEventHandler(nil)
+		close(c.notifier.stop)
+		c.notifier = nil
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+type sentMail struct {
+	to      string
+	subject string
+	body    string
+}
+
+func newTestNotifier(t *testing.T, config types.NotificationConfig) (*notifier, *[]sentMail) {
+	tmpl, err := parseNotificationTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant := &types.Tenant{ID: "tenant"}
+	tenant.Name = "test"
+	tenant.Notifications = config
+
+	getTenant := func(ID string) (*types.Tenant, error) {
+		if ID != tenant.ID {
+			return nil, nil
+		}
+		return tenant, nil
+	}
+
+	var mails []sentMail
+	send := func(to string, subject string, body string) error {
+		mails = append(mails, sentMail{to, subject, body})
+		return nil
+	}
+
+	return newNotifier(getTenant, send, tmpl, 10*time.Minute), &mails
+}
+
+func errorEvent(msg string) types.LogEntry {
+	return types.LogEntry{
+		TenantID: "tenant",
+		Severity: types.EventError,
+		Category: types.EventCategoryInstance,
+		Message:  msg,
+	}
+}
+
+func TestNotifierFiltersEvents(t *testing.T) {
+	n, _ := newTestNotifier(t, types.NotificationConfig{
+		Mode:  types.NotifyImmediate,
+		Email: "ops@example.com",
+	})
+
+	n.eventLogged(types.LogEntry{TenantID: "tenant", Severity: types.EventWarning})
+	n.eventLogged(types.LogEntry{Severity: types.EventCritical})
+	n.eventLogged(types.LogEntry{TenantID: "tenant", Severity: types.EventCritical})
+
+	if len(n.events) != 1 {
+		t.Fatalf("Expected 1 queued event, got %d", len(n.events))
+	}
+}
+
+func TestNotifierImmediate(t *testing.T) {
+	n, mails := newTestNotifier(t, types.NotificationConfig{
+		Mode:  types.NotifyImmediate,
+		Email: "ops@example.com",
+	})
+
+	now := time.Now()
+	n.queue(errorEvent("first"), now)
+
+	if len(*mails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(*mails))
+	}
+
+	m := (*mails)[0]
+	if m.to != "ops@example.com" || !strings.Contains(m.subject, "test") ||
+		!strings.Contains(m.body, "first") {
+		t.Fatalf("Unexpected email %+v", m)
+	}
+
+	// events are batched until the rate limit interval has elapsed
+	n.queue(errorEvent("second"), now.Add(time.Minute))
+	n.queue(errorEvent("third"), now.Add(2*time.Minute))
+	n.flush(now.Add(5 * time.Minute))
+
+	if len(*mails) != 1 {
+		t.Fatalf("Rate limit not enforced, got %d emails", len(*mails))
+	}
+
+	n.flush(now.Add(10 * time.Minute))
+
+	if len(*mails) != 2 {
+		t.Fatalf("Expected 2 emails, got %d", len(*mails))
+	}
+
+	m = (*mails)[1]
+	if !strings.Contains(m.body, "second") || !strings.Contains(m.body, "third") {
+		t.Fatalf("Batched events missing from %q", m.body)
+	}
+}
+
+func TestNotifierDigest(t *testing.T) {
+	n, mails := newTestNotifier(t, types.NotificationConfig{
+		Mode:          types.NotifyDigest,
+		Email:         "ops@example.com",
+		DigestMinutes: 30,
+	})
+
+	now := time.Now()
+	n.queue(errorEvent("first"), now)
+	n.queue(errorEvent("second"), now.Add(time.Minute))
+	n.flush(now.Add(29 * time.Minute))
+
+	if len(*mails) != 0 {
+		t.Fatalf("Digest sent early")
+	}
+
+	n.flush(now.Add(30 * time.Minute))
+
+	if len(*mails) != 1 {
+		t.Fatalf("Expected 1 digest, got %d", len(*mails))
+	}
+
+	if !strings.Contains((*mails)[0].body, "first") || !strings.Contains((*mails)[0].body, "second") {
+		t.Fatalf("Events missing from digest %q", (*mails)[0].body)
+	}
+}
+
+func TestNotifierDisabled(t *testing.T) {
+	n, mails := newTestNotifier(t, types.NotificationConfig{})
+
+	now := time.Now()
+	n.queue(errorEvent("ignored"), now)
+	n.flush(now.Add(24 * time.Hour))
+
+	if len(*mails) != 0 || len(n.pending) != 0 {
+		t.Fatalf("Tenant without notifications notified")
+	}
+}
+
+func TestNotifierMaxEvents(t *testing.T) {
+	n, mails := newTestNotifier(t, types.NotificationConfig{
+		Mode:  types.NotifyDigest,
+		Email: "ops@example.com",
+	})
+
+	now := time.Now()
+	for i := 0; i < notifyMaxEvents+5; i++ {
+		n.queue(errorEvent("event"), now)
+	}
+	n.flush(now.Add(defaultDigestMinutes * time.Minute))
+
+	if len(*mails) != 1 || !strings.Contains((*mails)[0].body, "5 older event(s)") {
+		t.Fatalf("Unexpected digest %+v", *mails)
+	}
+}
+
+func TestParseNotificationTemplate(t *testing.T) {
+	f, err := ioutil.TempFile("", "notification")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.WriteString(`{{define "subject"}}{{.TenantID}}{{end}}`)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parseNotificationTemplate(f.Name())
+	if err == nil {
+		t.Fatal("Template without body accepted")
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// objectStorePartSize is the size of the parts of the objects uploaded
+// with multipart uploads.  Smaller objects are uploaded at once.
+const objectStorePartSize = 64 << 20
+
+// unsignedPayload is given as the hash of request bodies which are
+// streamed, and thus not part of the signature.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// objectStoreCredentials are the keys signing the requests to an object
+// storage.
+type objectStoreCredentials struct {
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// objectStore is a minimal client of an S3 compatible object storage.  It
+// uploads objects to a bucket with path style requests, signed with AWS
+// signature version 4.
+type objectStore struct {
+	endpoint string
+	bucket   string
+	region   string
+	creds    objectStoreCredentials
+	partSize int64
+	client   *http.Client
+}
+
+func newObjectStore(endpoint string, bucket string, region string, credentialsPath string) (*objectStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid object storage endpoint %q", endpoint)
+	}
+
+	if bucket == "" {
+		return nil, errors.New("no object storage bucket")
+	}
+
+	data, err := ioutil.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading object storage credentials")
+	}
+
+	var creds objectStoreCredentials
+	err = yaml.Unmarshal(data, &creds)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing object storage credentials")
+	}
+
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, errors.New("missing object storage access or secret key")
+	}
+
+	return &objectStore{
+		endpoint: strings.TrimSuffix(u.String(), "/"),
+		bucket:   bucket,
+		region:   region,
+		creds:    creds,
+		partSize: objectStorePartSize,
+		client:   &http.Client{},
+	}, nil
+}
+
+// awsURIEncode escapes a string as required by the AWS signatures, which
+// is stricter than url.PathEscape and url.QueryEscape.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds the AWS signature version 4 of a request to its headers.  All
+// the headers of the request are signed.
+func (s *objectStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.creds.SecretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		s.creds.AccessKey, scope, signedHeaders, signature))
+}
+
+// objectStoreError is the body of the error responses of the object
+// storage.
+type objectStoreError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func responseError(req *http.Request, status string, body []byte) error {
+	var e objectStoreError
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s %s: %s: %s: %s", req.Method, req.URL.Path, status, e.Code, e.Message)
+	}
+
+	return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, status)
+}
+
+// do signs and sends a request, returning the body of its response.
+func (s *objectStore) do(req *http.Request, payloadHash string) (http.Header, []byte, error) {
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error reading response to %s %s", req.Method, req.URL.Path)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, responseError(req, resp.Status, body)
+	}
+
+	return resp.Header, body, nil
+}
+
+func (s *objectStore) objectURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s", s.endpoint, awsURIEncode(s.bucket, true), awsURIEncode(key, false))
+}
+
+func setObjectMetadata(req *http.Request, metadata map[string]string) {
+	for k, v := range metadata {
+		req.Header.Set("x-amz-meta-"+k, v)
+	}
+}
+
+// putObject uploads size bytes read from body to the object key of the
+// bucket, in parts if the object is too large to be uploaded at once.
+func (s *objectStore) putObject(key string, body io.Reader, size int64, metadata map[string]string) error {
+	if size > s.partSize {
+		return s.putMultipartObject(key, body, size, metadata)
+	}
+
+	// a request with an empty body of unknown length would be chunked
+	var reqBody io.Reader = http.NoBody
+	if size > 0 {
+		reqBody = io.LimitReader(body, size)
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(key), reqBody)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	setObjectMetadata(req, metadata)
+
+	_, _, err = s.do(req, unsignedPayload)
+	return err
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (s *objectStore) putMultipartObject(key string, body io.Reader, size int64, metadata map[string]string) error {
+	req, err := http.NewRequest("POST", s.objectURL(key)+"?uploads", nil)
+	if err != nil {
+		return err
+	}
+	setObjectMetadata(req, metadata)
+
+	_, data, err := s.do(req, hexSHA256(nil))
+	if err != nil {
+		return err
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.Unmarshal(data, &initiated)
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("invalid response to the creation of multipart upload of %s", key)
+	}
+
+	uploadURL := s.objectURL(key) + "?uploadId=" + awsURIEncode(initiated.UploadID, true)
+
+	err = s.uploadParts(key, uploadURL, body, size)
+	if err != nil {
+		if err := s.abortMultipartUpload(uploadURL); err != nil {
+			glog.Warningf("Error aborting multipart upload of %s: %v", key, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *objectStore) uploadParts(key string, uploadURL string, body io.Reader, size int64) error {
+	var complete completeMultipartUpload
+
+	for offset, n := int64(0), 1; offset < size; n++ {
+		length := size - offset
+		if length > s.partSize {
+			length = s.partSize
+		}
+
+		partURL := fmt.Sprintf("%s&partNumber=%d", uploadURL, n)
+		req, err := http.NewRequest("PUT", partURL, io.LimitReader(body, length))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = length
+
+		header, _, err := s.do(req, unsignedPayload)
+		if err != nil {
+			return err
+		}
+
+		complete.Parts = append(complete.Parts, completedPart{
+			PartNumber: n,
+			ETag:       header.Get("ETag"),
+		})
+		offset += length
+	}
+
+	data, err := xml.Marshal(&complete)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling multipart upload completion")
+	}
+
+	req, err := http.NewRequest("POST", uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// the completion can fail after the response headers are sent
+	_, data, err = s.do(req, hexSHA256(data))
+	if err != nil {
+		return err
+	}
+
+	var e objectStoreError
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("error completing multipart upload of %s: %s: %s", key, e.Code, e.Message)
+	}
+
+	return nil
+}
+
+func (s *objectStore) abortMultipartUpload(uploadURL string) error {
+	req, err := http.NewRequest("DELETE", uploadURL, nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.do(req, hexSHA256(nil))
+	return err
+}
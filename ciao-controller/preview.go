@@ -0,0 +1,199 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// maskedValue replaces the values of secret cloud-init keys in previews.
+const maskedValue = "********"
+
+// secretKeyMarkers are the substrings identifying the cloud-init keys
+// whose values are secret, e.g. passwd, chpasswd, plain_text_passwd or the
+// rsa_private host key.
+var secretKeyMarkers = []string{"passw", "secret", "token", "private"}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range secretKeyMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func maskSecretValues(v interface{}) bool {
+	masked := false
+
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i := range v {
+			if key, ok := v[i].Key.(string); ok && isSecretKey(key) {
+				v[i].Value = maskedValue
+				masked = true
+			} else if maskSecretValues(v[i].Value) {
+				masked = true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if maskSecretValues(e) {
+				masked = true
+			}
+		}
+	}
+
+	return masked
+}
+
+// maskSecrets masks the values of the secret keys of a cloud-config
+// document.  Documents without secrets, or which are not YAML mappings
+// such as scripts, are returned unchanged.
+func maskSecrets(cloudConfig string) string {
+	var doc yaml.MapSlice
+
+	err := yaml.Unmarshal([]byte(cloudConfig), &doc)
+	if err != nil || !maskSecretValues(doc) {
+		return cloudConfig
+	}
+
+	b, err := yaml.Marshal(doc)
+	if err != nil {
+		return cloudConfig
+	}
+
+	// keep the #cloud-config header cloud-init relies on
+	header := ""
+	for _, line := range strings.SplitAfter(cloudConfig, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		header += line
+	}
+
+	return header + string(b)
+}
+
+// previewNetworkConfig returns the network resources the next instance of
+// a tenant would be given, without allocating them.
+func previewNetworkConfig(ctl *controller, tenant *types.Tenant, cnci bool) (payloads.NetworkResources, error) {
+	var networking payloads.NetworkResources
+
+	if cnci {
+		err := networkConfig(ctl, tenant, &networking, cnci, nil)
+		return networking, err
+	}
+
+	ip, err := ctl.ds.PeekTenantIP(tenant.ID)
+	if err != nil {
+		return networking, errors.Wrap(err, "error getting tenant address")
+	}
+
+	networking.VnicUUID = uuid.Generate().String()
+	tenantNetworkAddress(tenant, &networking, ip)
+
+	// the CNCI and VNI of a subnet without instances are only set up
+	// by a launch.
+	if tenant.CNCIctrl != nil {
+		if cnciInstance, err := tenant.CNCIctrl.GetSubnetCNCI(networking.Subnet); err == nil {
+			networking.ConcentratorUUID = cnciInstance.ID
+			networking.ConcentratorIP = cnciInstance.IPAddress
+		}
+	}
+
+	if vni := ctl.ds.GetSubnetVNI(tenant.ID, networking.Subnet); vni != 0 {
+		networking.SubnetKey = strconv.FormatUint(uint64(vni), 10)
+	}
+
+	return networking, nil
+}
+
+// PreviewLaunch renders the configuration the launcher would receive for
+// an instance of a workload, so that its cloud-init data and metadata can
+// be checked without launching it.  Nothing is allocated or created and
+// the values of secret keys are masked.
+func (c *controller) PreviewLaunch(tenantID string, workloadID string, name string) (types.LaunchPreview, error) {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil || tenant == nil {
+		return types.LaunchPreview{}, types.ErrTenantNotFound
+	}
+
+	wl, err := c.ShowWorkload(tenantID, workloadID)
+	if err != nil {
+		return types.LaunchPreview{}, err
+	}
+
+	instanceID := uuid.Generate().String()
+	w := types.WorkloadRequest{
+		WorkloadID: workloadID,
+		TenantID:   tenantID,
+		Instances:  1,
+		Name:       name,
+	}
+	names, err := c.instanceNames(w, []string{instanceID})
+	if err != nil {
+		return types.LaunchPreview{}, err
+	}
+
+	networking, err := previewNetworkConfig(c, tenant, isCNCIWorkload(&wl))
+	if err != nil {
+		return types.LaunchPreview{}, err
+	}
+
+	var storage []payloads.StorageResource
+	for _, s := range wl.Storage {
+		if s.ID != "" {
+			volume, err := getStorage(c, s, tenantID, instanceID)
+			if err != nil {
+				return types.LaunchPreview{}, err
+			}
+			storage = append(storage, volume)
+			continue
+		}
+
+		storage = append(storage, payloads.StorageResource{
+			ID:        uuid.Generate().String(),
+			Bootable:  s.Bootable,
+			Ephemeral: s.Ephemeral,
+			Pool:      c.cephPool(s.Pool),
+		})
+	}
+
+	wl.Config = maskSecrets(wl.Config)
+	config, err := renderConfig(c, &wl, instanceID, tenantID, names[0], networking,
+		storage, maskSecrets(c.vendorData))
+	if err != nil {
+		return types.LaunchPreview{}, err
+	}
+
+	return types.LaunchPreview{
+		WorkloadID: workloadID,
+		TenantID:   tenantID,
+		InstanceID: instanceID,
+		Name:       names[0],
+		IPAddress:  config.ip,
+		Config:     config.config,
+	}, nil
+}
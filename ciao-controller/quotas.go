@@ -35,6 +35,41 @@ func (c *controller) ListQuotas(tenantID string) []types.QuotaDetails {
 	return c.qs.DumpQuotas(tenantID)
 }
 
+func (c *controller) ListQuotaProfiles() ([]types.QuotaProfile, error) {
+	return c.ds.GetQuotaProfiles(), nil
+}
+
+// UpdateQuotaProfile adds or replaces a quota profile and updates the
+// quotas of the tenants assigned to it.
+func (c *controller) UpdateQuotaProfile(name string, qds []types.QuotaDetails) error {
+	err := c.ds.UpdateQuotaProfile(types.QuotaProfile{Name: name, Quotas: qds})
+	if err != nil {
+		return err
+	}
+
+	for _, tenantID := range c.ds.GetQuotaProfileTenants(name) {
+		err = c.UpdateQuotas(tenantID, qds)
+		if err != nil {
+			return errors.Wrapf(err, "error updating quotas of tenant %s", tenantID)
+		}
+	}
+
+	return nil
+}
+
+func (c *controller) DeleteQuotaProfile(name string) error {
+	return c.ds.DeleteQuotaProfile(name)
+}
+
+func (c *controller) applyQuotaProfile(tenantID string, name string) error {
+	p, err := c.ds.GetQuotaProfile(name)
+	if err != nil {
+		return err
+	}
+
+	return c.UpdateQuotas(tenantID, p.Quotas)
+}
+
 func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) error {
 	ts, err := ds.GetAllTenants()
 	if err != nil {
@@ -50,8 +85,6 @@ func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) err
 		qs.Update(t.ID, qds)
 
 		// Populate volume usage
-		// TODO: populate image usage
-		// TODO: populate external IP usage
 		bds, err := ds.GetBlockDevices(t.ID)
 		if err != nil {
 			return errors.Wrapf(err, "error getting block devices for tenant %s", t.ID)
@@ -64,11 +97,28 @@ func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) err
 			size += bd.Size
 			count++
 		}
-		// With initial population we disregard the result of consumption
-		<-qs.Consume(t.ID,
+		// With initial population the existing usage is accounted for
+		// regardless of the quotas
+		qs.Charge(t.ID,
 			payloads.RequestedResource{Type: payloads.Volume, Value: count},
 			payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: size})
 
+		// Populate image usage
+		images, err := ds.GetImages(t.ID, false)
+		if err != nil {
+			return errors.Wrapf(err, "error getting images for tenant %s", t.ID)
+		}
+		count = 0
+		for _, image := range images {
+			if image.TenantID == t.ID {
+				count++
+			}
+		}
+		qs.Charge(t.ID, payloads.RequestedResource{Type: payloads.Image, Value: count})
+
+		// Populate external IP usage
+		qs.Charge(t.ID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: len(ds.GetMappedIPs(&t.ID))})
+
 		instances, err := ds.GetAllInstancesFromTenant(t.ID)
 		if err != nil {
 			return errors.Wrapf(err, "error getting tenant instances")
@@ -81,9 +131,9 @@ func populateQuotasFromDatastore(qs *quotas.Quotas, ds *datastore.Datastore) err
 			}
 			resources := []payloads.RequestedResource{
 				{Type: payloads.Instance, Value: 1},
-				{Type: payloads.MemMB, Value: wl.Requirements.MemMB},
-				{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs}}
-			<-qs.Consume(t.ID, resources...)
+				{Type: payloads.MemMB, Value: wl.Requirements.MemMB + instance.ExtraMemMB},
+				{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs + instance.ExtraVCPUs}}
+			qs.Charge(t.ID, resources...)
 		}
 	}
 
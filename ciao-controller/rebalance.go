@@ -0,0 +1,319 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRebalanceMoves is the number of migrations a rebalancing
+	// plans when the request does not limit them.
+	defaultRebalanceMoves = 10
+
+	// rebalanceSpread is the difference, in percent, between the
+	// memory usage of the most and the least loaded nodes under which
+	// the nodes are considered balanced.
+	rebalanceSpread = 10
+)
+
+// rebalanceCandidate is a running instance a rebalancing may migrate.
+type rebalanceCandidate struct {
+	instance *types.Instance
+	memMB    int
+}
+
+// rebalanceNode is the memory usage of a compute node, as last reported
+// by the node and updated by the planned migrations.
+type rebalanceNode struct {
+	id         string
+	totalMB    int
+	usedMB     int
+	availMB    int
+	candidates []rebalanceCandidate
+}
+
+func (n *rebalanceNode) usage(deltaMB int) float64 {
+	return float64(n.usedMB+deltaMB) / float64(n.totalMB)
+}
+
+// rebalanceNodesStats returns the ready compute nodes with their memory
+// usage and the running instances which can be migrated off them.  CNCIs,
+// the instances whose workload requires a given node and those of the
+// tenants outside of their maintenance windows are not moved.
+func (c *controller) rebalanceNodesStats() ([]*rebalanceNode, error) {
+	var nodes []*rebalanceNode
+	byID := make(map[string]*rebalanceNode)
+	now := time.Now()
+	inWindow := make(map[string]bool)
+
+	for _, s := range c.ds.GetNodeLastStats().Nodes {
+		if s.Status != ssntp.READY.String() || s.MemTotal <= 0 {
+			continue
+		}
+
+		node, err := c.ds.GetNode(s.ID)
+		if err != nil || !node.NodeRole.IsAgent() {
+			continue
+		}
+
+		n := &rebalanceNode{
+			id:      s.ID,
+			totalMB: s.MemTotal,
+			usedMB:  s.MemTotal - s.MemAvailable,
+			availMB: s.MemAvailable,
+		}
+		nodes = append(nodes, n)
+		byID[n.id] = n
+	}
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting instances")
+	}
+
+	for _, i := range instances {
+		n := byID[i.NodeID]
+		if n == nil || i.CNCI || i.State != payloads.ComputeStatusRunning {
+			continue
+		}
+
+		wl, err := c.ds.GetWorkload(i.WorkloadID)
+		if err != nil {
+			continue
+		}
+
+		r := wl.Requirements
+		if r.NodeID != "" || r.Hostname != "" || r.NetworkNode {
+			continue
+		}
+
+		open, ok := inWindow[i.TenantID]
+		if !ok {
+			open = c.inMaintenanceWindow(i.TenantID, now)
+			inWindow[i.TenantID] = open
+		}
+
+		if !open {
+			continue
+		}
+
+		n.candidates = append(n.candidates, rebalanceCandidate{
+			instance: i,
+			memMB:    r.MemMB + i.ExtraMemMB,
+		})
+	}
+
+	return nodes, nil
+}
+
+// planRebalance plans up to maxMoves migrations, each moving the instance
+// of the most loaded node which best evens its memory usage with the least
+// loaded node.  Planning stops once the usages are within rebalanceSpread
+// percent of each other or no migration narrows their difference.
+func planRebalance(nodes []*rebalanceNode, maxMoves int) []types.RebalanceMove {
+	var moves []types.RebalanceMove
+
+	for len(moves) < maxMoves && len(nodes) > 1 {
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].usage(0) != nodes[j].usage(0) {
+				return nodes[i].usage(0) > nodes[j].usage(0)
+			}
+			return nodes[i].id < nodes[j].id
+		})
+
+		from := nodes[0]
+		to := nodes[len(nodes)-1]
+
+		spread := from.usage(0) - to.usage(0)
+		if spread*100 < rebalanceSpread {
+			break
+		}
+
+		best := -1
+		for k, cand := range from.candidates {
+			if cand.memMB <= 0 || cand.memMB > to.availMB {
+				continue
+			}
+
+			s := math.Abs(from.usage(-cand.memMB) - to.usage(cand.memMB))
+			if s < spread {
+				best = k
+				spread = s
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		cand := from.candidates[best]
+		from.candidates = append(from.candidates[:best], from.candidates[best+1:]...)
+		from.usedMB -= cand.memMB
+		from.availMB += cand.memMB
+		to.usedMB += cand.memMB
+		to.availMB -= cand.memMB
+
+		moves = append(moves, types.RebalanceMove{
+			InstanceID: cand.instance.ID,
+			TenantID:   cand.instance.TenantID,
+			From:       from.id,
+			To:         to.id,
+			MemMB:      cand.memMB,
+		})
+	}
+
+	return moves
+}
+
+// rebalanceSnapshot returns a copy of the status of the last rebalancing
+// which the migrations do not update. It must be called with
+// rebalanceLock held.
+func (c *controller) rebalanceSnapshot() types.RebalanceStatus {
+	status := *c.rebalance
+	status.Moves = append([]types.RebalanceMove(nil), c.rebalance.Moves...)
+	return status
+}
+
+// RebalanceNodes plans the migrations evening out the memory usage of the
+// compute nodes, based on their last stats.  Unless the request is a dry
+// run the migrations are carried out one after the other in the
+// background, and their progress is reported by GetRebalance.
+func (c *controller) RebalanceNodes(req types.RebalanceRequest) (types.RebalanceStatus, error) {
+	if req.MaxMoves < 0 {
+		return types.RebalanceStatus{}, types.ErrBadRequest
+	}
+
+	maxMoves := req.MaxMoves
+	if maxMoves == 0 {
+		maxMoves = defaultRebalanceMoves
+	}
+
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+
+	if c.rebalance != nil && c.rebalance.State == types.RebalanceRunning {
+		return c.rebalanceSnapshot(), types.ErrRebalancing
+	}
+
+	nodes, err := c.rebalanceNodesStats()
+	if err != nil {
+		return types.RebalanceStatus{}, err
+	}
+
+	status := types.RebalanceStatus{
+		ID:      uuid.Generate().String(),
+		DryRun:  req.DryRun,
+		State:   types.RebalanceDone,
+		Moves:   planRebalance(nodes, maxMoves),
+		Started: time.Now(),
+	}
+
+	if status.Moves == nil {
+		status.Moves = []types.RebalanceMove{}
+	}
+
+	if req.DryRun {
+		return status, nil
+	}
+
+	if len(status.Moves) > 0 {
+		status.State = types.RebalanceRunning
+	}
+
+	c.rebalance = &status
+	if status.State == types.RebalanceRunning {
+		go c.rebalanceNodes(append([]types.RebalanceMove(nil), status.Moves...))
+	}
+
+	return c.rebalanceSnapshot(), nil
+}
+
+// GetRebalance returns the progress of the last rebalancing.
+func (c *controller) GetRebalance() (types.RebalanceStatus, error) {
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+
+	if c.rebalance == nil {
+		return types.RebalanceStatus{}, types.ErrNoRebalance
+	}
+
+	return c.rebalanceSnapshot(), nil
+}
+
+// migrateInstance moves a running instance from a node to another one by
+// stopping it and restarting it on the other node.
+var migrateInstance = func(c *controller, instanceID string, from string, to string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.NodeID != from || i.State != payloads.ComputeStatusRunning {
+		return errors.New("Instance moved or stopped since the rebalancing was planned")
+	}
+
+	err = c.stopInstanceSync(instanceID)
+	if err != nil {
+		return err
+	}
+
+	return c.restartInstanceOnNode(instanceID, to)
+}
+
+func (c *controller) rebalanceNodes(moves []types.RebalanceMove) {
+	failed := 0
+
+	for k, m := range moves {
+		var err error
+		if c.inMaintenanceWindow(m.TenantID, time.Now()) {
+			err = migrateInstance(c, m.InstanceID, m.From, m.To)
+		} else {
+			err = errors.New("Maintenance window of the tenant closed since the rebalancing was planned")
+		}
+
+		c.rebalanceLock.Lock()
+		if err != nil {
+			glog.Warningf("Error migrating instance %s to node %s: %v", m.InstanceID, m.To, err)
+			c.rebalance.Moves[k].Error = err.Error()
+			failed++
+		} else {
+			c.rebalance.Moves[k].Done = true
+		}
+		c.rebalanceLock.Unlock()
+	}
+
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+
+	if failed > 0 {
+		c.rebalance.State = types.RebalanceFailed
+		c.rebalance.Error = fmt.Sprintf("%d of %d migrations failed", failed, len(moves))
+		return
+	}
+
+	glog.Infof("Rebalanced %d instances", len(moves))
+	c.rebalance.State = types.RebalanceDone
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func testRebalanceNode(id string, totalMB int, sizes ...int) *rebalanceNode {
+	n := &rebalanceNode{id: id, totalMB: totalMB, availMB: totalMB}
+	for k, s := range sizes {
+		n.usedMB += s
+		n.availMB -= s
+		n.candidates = append(n.candidates, rebalanceCandidate{
+			instance: &types.Instance{ID: id + "-" + string('a'+rune(k))},
+			memMB:    s,
+		})
+	}
+	return n
+}
+
+func TestPlanRebalance(t *testing.T) {
+	nodes := []*rebalanceNode{
+		testRebalanceNode("node1", 8192, 2048, 2048, 1024, 512),
+		testRebalanceNode("node2", 8192, 1024),
+		testRebalanceNode("node3", 8192),
+	}
+
+	moves := planRebalance(nodes, defaultRebalanceMoves)
+	if len(moves) != 2 {
+		t.Fatalf("Expected 2 moves, got %+v", moves)
+	}
+
+	for _, m := range moves {
+		if m.From != "node1" || m.To == "node1" {
+			t.Errorf("Unexpected move %+v", m)
+		}
+	}
+
+	for _, n := range nodes {
+		if n.usedMB > 3072 || n.usedMB < 1024 {
+			t.Errorf("Node %s not balanced: %d MB used", n.id, n.usedMB)
+		}
+	}
+
+	nodes = []*rebalanceNode{
+		testRebalanceNode("node1", 8192, 2048, 2048, 1024, 512),
+		testRebalanceNode("node2", 8192),
+	}
+	moves = planRebalance(nodes, 1)
+	if len(moves) != 1 {
+		t.Fatalf("Expected 1 move, got %+v", moves)
+	}
+
+	nodes = []*rebalanceNode{
+		testRebalanceNode("node1", 8192, 6144),
+		testRebalanceNode("node2", 8192),
+	}
+	moves = planRebalance(nodes, defaultRebalanceMoves)
+	if len(moves) != 0 {
+		t.Fatalf("Moving the only instance does not balance the nodes %+v", moves)
+	}
+}
+
+func TestRebalanceDryRun(t *testing.T) {
+	_, err := ctl.RebalanceNodes(types.RebalanceRequest{MaxMoves: -1})
+	if err != types.ErrBadRequest {
+		t.Fatalf("Negative number of moves accepted: %v", err)
+	}
+
+	status, err := ctl.RebalanceNodes(types.RebalanceRequest{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !status.DryRun || status.State != types.RebalanceDone {
+		t.Fatalf("Unexpected dry run status %+v", status)
+	}
+
+	ctl.rebalanceLock.Lock()
+	recorded := ctl.rebalance != nil && ctl.rebalance.ID == status.ID
+	ctl.rebalanceLock.Unlock()
+	if recorded {
+		t.Fatal("Dry run recorded as the last rebalancing")
+	}
+}
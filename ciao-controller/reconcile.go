@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// orphanPolicy selects what the reconciliation loop does with the
+// orphaned instances it finds. Orphans are always logged.
+type orphanPolicy struct {
+	// deleteUnknown deletes the instances running on a node but
+	// unknown to the controller.
+	deleteUnknown bool
+
+	// markMissing moves the instances no longer reported by their
+	// node to the missing state.
+	markMissing bool
+}
+
+// parseOrphanPolicy parses a comma separated list of cleanup policies.
+func parseOrphanPolicy(s string) (orphanPolicy, error) {
+	var p orphanPolicy
+
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "", "none":
+		case "delete_unknown":
+			p.deleteUnknown = true
+		case "mark_missing":
+			p.markMissing = true
+		default:
+			return p, fmt.Errorf("Unknown orphan cleanup policy %q", v)
+		}
+	}
+
+	return p, nil
+}
+
+func (c *controller) markInstanceMissing(instanceID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.State == payloads.Missing {
+		return nil
+	}
+
+	err = i.TransitionInstanceState(payloads.Missing)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(c.ds.UpdateInstance(i), "Error updating instance")
+}
+
+// reconcileInstances compares the instances known to the datastore with
+// the ones reported by the node agents and applies the cleanup policy to
+// the differences. Instances younger than grace are not reported as
+// missing as their node may not have sent stats for them yet.
+func (c *controller) reconcileInstances(policy orphanPolicy, grace time.Duration) {
+	for _, o := range c.ds.GetOrphanedInstances(grace) {
+		switch o.Kind {
+		case types.OrphanUnknown:
+			glog.Warningf("Instance %s running on node %s is unknown to the controller", o.InstanceID, o.NodeID)
+
+			if !policy.deleteUnknown {
+				continue
+			}
+
+			if err := c.client.DeleteInstance(o.InstanceID, o.NodeID); err != nil {
+				glog.Warningf("Error deleting unknown instance %s: %v", o.InstanceID, err)
+			}
+		case types.OrphanMissing:
+			glog.Warningf("Instance %s is no longer reported by node %s", o.InstanceID, o.NodeID)
+
+			if !policy.markMissing {
+				continue
+			}
+
+			if err := c.markInstanceMissing(o.InstanceID); err != nil {
+				glog.Warningf("Error marking instance %s as missing: %v", o.InstanceID, err)
+			}
+		}
+	}
+}
+
+// startReconciler periodically reconciles the instances until
+// stopReconciler is called.
+func (c *controller) startReconciler(interval time.Duration, policy orphanPolicy) {
+	c.reconcileStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.reconcileInstances(policy, interval)
+			case <-c.reconcileStop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopReconciler() {
+	if c.reconcileStop != nil {
+		close(c.reconcileStop)
+		c.reconcileStop = nil
+	}
+}
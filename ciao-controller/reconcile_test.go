@@ -0,0 +1,46 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseOrphanPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected orphanPolicy
+		valid    bool
+	}{
+		{"", orphanPolicy{}, true},
+		{"none", orphanPolicy{}, true},
+		{"delete_unknown", orphanPolicy{deleteUnknown: true}, true},
+		{"mark_missing", orphanPolicy{markMissing: true}, true},
+		{"delete_unknown, mark_missing", orphanPolicy{deleteUnknown: true, markMissing: true}, true},
+		{"delete_all", orphanPolicy{}, false},
+	}
+
+	for _, test := range tests {
+		p, err := parseOrphanPolicy(test.policy)
+		if (err == nil) != test.valid {
+			t.Errorf("Policy %q: unexpected error %v", test.policy, err)
+			continue
+		}
+
+		if test.valid && p != test.expected {
+			t.Errorf("Policy %q: expected %+v, got %+v", test.policy, test.expected, p)
+		}
+	}
+}
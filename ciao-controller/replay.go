@@ -0,0 +1,136 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// replayWindow is the number of sequence numbers below the highest one
+// received from an origin for which duplicates are still detected.
+// Frames falling behind the window are considered replayed.
+const replayWindow = 1024
+
+// maxRetiredEpochs is the number of former epochs remembered per origin,
+// whose frames are considered replayed.
+const maxRetiredEpochs = 64
+
+type originSequences struct {
+	epoch   uint64
+	retired []uint64
+	highest uint64
+	seen    map[uint64]struct{}
+}
+
+// frameFilter keeps track of the sequence numbers of the SSNTP frames
+// handled by the controller, so that frames retransmitted or replayed
+// are not applied twice, even after their origin reconnected. Frames are
+// handled concurrently and can be received out of order, hence the
+// window. An origin restarted with a new epoch starts a new window, as
+// its sequence numbers may start lower, and the frames of its former
+// epochs are rejected from then on.
+type frameFilter struct {
+	sync.Mutex
+	origins map[uuid.UUID]*originSequences
+}
+
+// newEpoch starts the window of a new epoch of the origin. It returns
+// false if epoch is a former epoch of the origin.
+func (o *originSequences) newEpoch(epoch uint64) bool {
+	for _, e := range o.retired {
+		if e == epoch {
+			return false
+		}
+	}
+
+	o.retired = append(o.retired, o.epoch)
+	if len(o.retired) > maxRetiredEpochs {
+		o.retired = o.retired[1:]
+	}
+
+	o.epoch = epoch
+	o.highest = 0
+	o.seen = make(map[uint64]struct{})
+
+	return true
+}
+
+// accept records the sequence number of a frame and returns false if
+// the frame has already been seen, is too old to tell or belongs to a
+// former epoch of its origin. Frames without a sequence number, sent by
+// older agents, are always accepted.
+func (f *frameFilter) accept(frame *ssntp.Frame) bool {
+	seq := frame.Sequence
+	if seq == 0 {
+		return true
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.origins == nil {
+		f.origins = make(map[uuid.UUID]*originSequences)
+	}
+
+	o := f.origins[frame.Origin]
+	if o == nil {
+		o = &originSequences{epoch: frame.Epoch, seen: make(map[uint64]struct{})}
+		f.origins[frame.Origin] = o
+	}
+
+	if frame.Epoch != o.epoch && !o.newEpoch(frame.Epoch) {
+		return false
+	}
+
+	if o.highest > replayWindow && seq <= o.highest-replayWindow {
+		return false
+	}
+
+	if _, ok := o.seen[seq]; ok {
+		return false
+	}
+
+	o.seen[seq] = struct{}{}
+
+	if seq > o.highest {
+		o.highest = seq
+	}
+
+	if len(o.seen) > 2*replayWindow {
+		for s := range o.seen {
+			if o.highest > replayWindow && s <= o.highest-replayWindow {
+				delete(o.seen, s)
+			}
+		}
+	}
+
+	return true
+}
+
+// forget drops the sequence numbers recorded for an origin, once its
+// node is removed.
+func (f *frameFilter) forget(origin string) {
+	u, err := uuid.Parse(origin)
+	if err != nil {
+		return
+	}
+
+	f.Lock()
+	delete(f.origins, u)
+	f.Unlock()
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+func TestFrameFilter(t *testing.T) {
+	var f frameFilter
+
+	origin := uuid.Generate()
+	other := uuid.Generate()
+
+	frame := func(o uuid.UUID, seq uint64) *ssntp.Frame {
+		return &ssntp.Frame{Origin: o, Sequence: seq}
+	}
+
+	tests := []struct {
+		frame    *ssntp.Frame
+		expected bool
+	}{
+		{frame(origin, 0), true},
+		{frame(origin, 0), true},
+		{frame(origin, 5000), true},
+		{frame(origin, 5000), false},
+		{frame(other, 5000), true},
+		{frame(origin, 5002), true},
+		{frame(origin, 5001), true},
+		{frame(origin, 5001), false},
+		{frame(origin, 5002-replayWindow), false},
+		{frame(origin, 5003-replayWindow), true},
+		{frame(origin, 1), false},
+	}
+
+	for i, test := range tests {
+		if f.accept(test.frame) != test.expected {
+			t.Errorf("Frame %d (sequence %d): expected %v", i, test.frame.Sequence, test.expected)
+		}
+	}
+}
+
+func TestFrameFilterPrune(t *testing.T) {
+	var f frameFilter
+
+	origin := uuid.Generate()
+
+	for seq := uint64(1); seq <= 4*replayWindow; seq++ {
+		if !f.accept(&ssntp.Frame{Origin: origin, Sequence: seq}) {
+			t.Fatalf("Frame %d unexpectedly rejected", seq)
+		}
+	}
+
+	if len(f.origins[origin].seen) > 2*replayWindow {
+		t.Fatalf("Sequence numbers not pruned: %d", len(f.origins[origin].seen))
+	}
+
+	if f.accept(&ssntp.Frame{Origin: origin, Sequence: 4 * replayWindow}) {
+		t.Fatal("Duplicate frame accepted")
+	}
+}
+
+func TestFrameFilterEpochs(t *testing.T) {
+	var f frameFilter
+
+	origin := uuid.Generate()
+
+	frame := func(epoch uint64, seq uint64) *ssntp.Frame {
+		return &ssntp.Frame{Origin: origin, Epoch: epoch, Sequence: seq}
+	}
+
+	tests := []struct {
+		frame    *ssntp.Frame
+		expected bool
+	}{
+		{frame(1, 5000), true},
+		// the agent reconnects, its frames are still filtered
+		{frame(1, 5000), false},
+		{frame(1, 1), false},
+		{frame(1, 5001), true},
+		// the agent restarts with a clock which stepped back
+		{frame(2, 10), true},
+		{frame(2, 11), true},
+		{frame(2, 10), false},
+		// frames of the former process are replayed
+		{frame(1, 5002), false},
+		{frame(1, 6000), false},
+		{frame(2, 12), true},
+	}
+
+	for i, test := range tests {
+		if f.accept(test.frame) != test.expected {
+			t.Errorf("Frame %d (epoch %d, sequence %d): expected %v", i,
+				test.frame.Epoch, test.frame.Sequence, test.expected)
+		}
+	}
+
+	f.forget(origin.String())
+
+	if _, ok := f.origins[origin]; ok {
+		t.Fatal("Origin not pruned")
+	}
+}
@@ -0,0 +1,506 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+var reportConfigPath = flag.String("report_config", "", "path to the yaml file scheduling the tenant reports, reports are disabled if empty")
+
+const (
+	// reportCheckInterval is the interval at which the report
+	// schedules are checked.
+	reportCheckInterval = time.Minute
+
+	// reportMaxErrors is the number of delivery errors kept for the
+	// last run of a report.
+	reportMaxErrors = 10
+)
+
+// reportSchedule is a periodic report configured by the admin in the file
+// given with -report_config, e.g.
+//
+//	reports:
+//	- name: daily
+//	  interval: 24h
+//	  format: csv
+//	  email: [billing@example.com]
+//	  object_prefix: reports/
+type reportSchedule struct {
+	Name     string             `yaml:"name"`
+	Interval time.Duration      `yaml:"interval"`
+	Format   types.ReportFormat `yaml:"format"`
+
+	// Tenants are the tenants reported on, all the tenants if empty.
+	Tenants []string `yaml:"tenants"`
+
+	// Email lists the addresses every tenant report is sent to.
+	Email []string `yaml:"email"`
+
+	// NotifyTenants sends the tenants their own report, to the address
+	// of their notification settings.
+	NotifyTenants bool `yaml:"notify_tenants"`
+
+	// ObjectPrefix is the prefix of the keys under which the reports
+	// are uploaded to the object storage configured for image exports.
+	// Reports are not uploaded if it is empty.
+	ObjectPrefix string `yaml:"object_prefix"`
+}
+
+type reportConfig struct {
+	Reports []reportSchedule `yaml:"reports"`
+}
+
+func (s *reportSchedule) validate(mail bool, upload bool) error {
+	if s.Name == "" {
+		return errors.New("report with no name")
+	}
+
+	if s.Interval < reportCheckInterval {
+		return fmt.Errorf("interval of report %s is shorter than %s", s.Name, reportCheckInterval)
+	}
+
+	switch s.Format {
+	case "":
+		s.Format = types.ReportCSV
+	case types.ReportCSV, types.ReportJSON:
+	default:
+		return fmt.Errorf("invalid format %q for report %s", s.Format, s.Name)
+	}
+
+	emails := len(s.Email) > 0 || s.NotifyTenants
+	if !emails && s.ObjectPrefix == "" {
+		return fmt.Errorf("report %s is not delivered", s.Name)
+	}
+
+	if emails && !mail {
+		return fmt.Errorf("report %s is emailed but no SMTP server is configured", s.Name)
+	}
+
+	if s.ObjectPrefix != "" && !upload {
+		return fmt.Errorf("report %s is uploaded but no object storage is configured", s.Name)
+	}
+
+	return nil
+}
+
+// loadReportSchedules reads the report schedules stored in path.  mail
+// and upload tell whether reports can be emailed and uploaded.
+func loadReportSchedules(path string, mail bool, upload bool) ([]reportSchedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read report schedules")
+	}
+
+	var config reportConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to parse report schedules")
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Reports {
+		s := &config.Reports[i]
+		if err := s.validate(mail, upload); err != nil {
+			return nil, err
+		}
+
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate report %s", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return config.Reports, nil
+}
+
+// tenantReport gathers the quotas, usage history and events of a tenant
+// over a period.
+func (c *controller) tenantReport(tenantID string, start time.Time, end time.Time) (types.TenantReport, error) {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil || tenant == nil {
+		return types.TenantReport{}, types.ErrTenantNotFound
+	}
+
+	report := types.TenantReport{
+		TenantID:   tenantID,
+		TenantName: tenant.Name,
+		Start:      start,
+		End:        end,
+		Quotas:     c.qs.DumpQuotas(tenantID),
+		Usage:      []types.CiaoUsage{},
+		Events:     []types.LogEntry{},
+	}
+
+	usage, err := c.ds.GetTenantUsage(tenantID, start, end)
+	if err != nil {
+		return types.TenantReport{}, errors.Wrap(err, "error getting tenant usage")
+	}
+	report.Usage = append(report.Usage, usage...)
+
+	rx, tx := c.ds.GetTenantTraffic(tenantID, start, end)
+	report.NetworkRxGB = gigabytes(rx)
+	report.NetworkTxGB = gigabytes(tx)
+
+	events, err := c.ds.GetEventLog()
+	if err != nil {
+		return types.TenantReport{}, errors.Wrap(err, "error getting event log")
+	}
+
+	for _, e := range events {
+		if e.TenantID == tenantID && !e.Timestamp.Before(start) && e.Timestamp.Before(end) {
+			report.Events = append(report.Events, *e)
+		}
+	}
+
+	return report, nil
+}
+
+// gigabytes converts a number of bytes to gigabytes, as used for billing.
+func gigabytes(bytes uint64) float64 {
+	return float64(bytes) / 1e9
+}
+
+// renderReport formats a tenant report.  CSV reports have a row per
+// quota, usage sample and event, the columns not applying to a row being
+// left empty.
+func renderReport(report types.TenantReport, format types.ReportFormat) ([]byte, error) {
+	if format == types.ReportJSON {
+		return json.MarshalIndent(&report, "", "\t")
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{"record", "timestamp", "name", "value", "limit", "category", "message"})
+
+	for _, q := range report.Quotas {
+		limit := strconv.Itoa(q.Value)
+		if q.Value == -1 {
+			limit = "unlimited"
+		}
+
+		value := ""
+		if !strings.Contains(q.Name, "limit") {
+			value = strconv.Itoa(q.Usage)
+		}
+
+		_ = w.Write([]string{"quota", "", q.Name, value, limit, "", ""})
+	}
+
+	for _, u := range report.Usage {
+		timestamp := u.Timestamp.UTC().Format(time.RFC3339)
+		_ = w.Write([]string{"usage", timestamp, "cpus_usage", strconv.Itoa(u.VCPU), "", "", ""})
+		_ = w.Write([]string{"usage", timestamp, "ram_usage", strconv.Itoa(u.Memory), "", "", ""})
+		_ = w.Write([]string{"usage", timestamp, "disk_usage", strconv.Itoa(u.Disk), "", "", ""})
+		_ = w.Write([]string{"usage", timestamp, "network_rx_bytes",
+			strconv.FormatUint(u.NetworkRxBytes, 10), "", "", ""})
+		_ = w.Write([]string{"usage", timestamp, "network_tx_bytes",
+			strconv.FormatUint(u.NetworkTxBytes, 10), "", "", ""})
+	}
+
+	_ = w.Write([]string{"network", "", "network_rx_gb",
+		strconv.FormatFloat(report.NetworkRxGB, 'f', 3, 64), "", "", ""})
+	_ = w.Write([]string{"network", "", "network_tx_gb",
+		strconv.FormatFloat(report.NetworkTxGB, 'f', 3, 64), "", "", ""})
+
+	for _, e := range report.Events {
+		_ = w.Write([]string{"event", e.Timestamp.UTC().Format(time.RFC3339), string(e.Severity),
+			"", "", string(e.Category), e.Message})
+	}
+
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// scheduledReport is the state of a report schedule.
+type scheduledReport struct {
+	reportSchedule
+
+	lastRun   time.Time
+	nextRun   time.Time
+	delivered int
+	errors    []string
+}
+
+func (r *scheduledReport) status() types.ReportStatus {
+	return types.ReportStatus{
+		Name:      r.Name,
+		Format:    r.Format,
+		Interval:  r.Interval.String(),
+		Tenants:   r.Tenants,
+		LastRun:   r.lastRun,
+		NextRun:   r.nextRun,
+		Delivered: r.delivered,
+		Errors:    r.errors,
+	}
+}
+
+// reporter periodically generates the tenant reports scheduled by the
+// admin and delivers them by email, through the SMTP server used for
+// notifications, or to the object storage used for image exports.
+type reporter struct {
+	ctl  *controller
+	send mailSender
+
+	lock    sync.Mutex
+	reports []*scheduledReport
+
+	trigger chan struct{}
+	stop    chan struct{}
+}
+
+func newReporter(ctl *controller, schedules []reportSchedule, send mailSender, now time.Time) *reporter {
+	r := &reporter{
+		ctl:     ctl,
+		send:    send,
+		trigger: make(chan struct{}, 1),
+	}
+
+	for _, s := range schedules {
+		r.reports = append(r.reports, &scheduledReport{
+			reportSchedule: s,
+			nextRun:        now.Add(s.Interval),
+		})
+	}
+
+	return r
+}
+
+func (r *reporter) find(name string) *scheduledReport {
+	for _, s := range r.reports {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// deliver emails and uploads the report of a tenant.  It returns the
+// number of successful deliveries and the errors of the failed ones.
+func (r *reporter) deliver(s *reportSchedule, report types.TenantReport) (int, []string) {
+	data, err := renderReport(report, s.Format)
+	if err != nil {
+		return 0, []string{fmt.Sprintf("tenant %s: %v", report.TenantID, err)}
+	}
+
+	delivered := 0
+	var errs []string
+
+	recipients := s.Email
+	if s.NotifyTenants {
+		tenant, err := r.ctl.ds.GetTenant(report.TenantID)
+		if err == nil && tenant != nil && tenant.Notifications.Email != "" {
+			recipients = append(recipients[:len(recipients):len(recipients)], tenant.Notifications.Email)
+		}
+	}
+
+	name := report.TenantName
+	if name == "" {
+		name = report.TenantID
+	}
+	subject := fmt.Sprintf("[ciao] %s report for %s", s.Name, name)
+
+	for _, to := range recipients {
+		if err := r.send(to, subject, string(data)); err != nil {
+			errs = append(errs, fmt.Sprintf("tenant %s: email to %s: %v", report.TenantID, to, err))
+			continue
+		}
+		delivered++
+	}
+
+	if s.ObjectPrefix != "" {
+		key := path.Join(s.ObjectPrefix, s.Name, report.TenantID,
+			report.End.UTC().Format("20060102T150405Z")+"."+string(s.Format))
+		metadata := map[string]string{
+			"ciao-tenant-id": report.TenantID,
+			"ciao-report":    s.Name,
+		}
+
+		err := r.ctl.objectStore.putObject(key, bytes.NewReader(data), int64(len(data)), metadata)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("tenant %s: upload: %v", report.TenantID, err))
+		} else {
+			delivered++
+		}
+	}
+
+	return delivered, errs
+}
+
+// generate reports on the tenants of a schedule over the period ending
+// at end.
+func (r *reporter) generate(s reportSchedule, start time.Time, end time.Time) (int, []string) {
+	tenantIDs := s.Tenants
+	if len(tenantIDs) == 0 {
+		tenants, err := r.ctl.ds.GetAllTenants()
+		if err != nil {
+			return 0, []string{fmt.Sprintf("error getting tenants: %v", err)}
+		}
+
+		for _, t := range tenants {
+			tenantIDs = append(tenantIDs, t.ID)
+		}
+	}
+
+	delivered := 0
+	var errs []string
+
+	for _, tenantID := range tenantIDs {
+		report, err := r.ctl.tenantReport(tenantID, start, end)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("tenant %s: %v", tenantID, err))
+			continue
+		}
+
+		n, e := r.deliver(&s, report)
+		delivered += n
+		errs = append(errs, e...)
+	}
+
+	return delivered, errs
+}
+
+// runDue generates the reports which are due.  Each report covers the
+// period since its previous run, or its interval for its first run.
+func (r *reporter) runDue(now time.Time) {
+	r.lock.Lock()
+	var due []*scheduledReport
+	var schedules []reportSchedule
+	var starts []time.Time
+	for _, s := range r.reports {
+		if now.Before(s.nextRun) {
+			continue
+		}
+
+		start := s.lastRun
+		if start.IsZero() {
+			start = now.Add(-s.Interval)
+		}
+
+		due = append(due, s)
+		schedules = append(schedules, s.reportSchedule)
+		starts = append(starts, start)
+	}
+	r.lock.Unlock()
+
+	for i, s := range due {
+		delivered, errs := r.generate(schedules[i], starts[i], now)
+		if len(errs) > 0 {
+			glog.Warningf("Report %s: %d deliveries failed: %s", s.Name, len(errs), errs[0])
+		}
+		if len(errs) > reportMaxErrors {
+			errs = errs[:reportMaxErrors]
+		}
+
+		r.lock.Lock()
+		s.lastRun = now
+		s.nextRun = now.Add(s.Interval)
+		s.delivered = delivered
+		s.errors = errs
+		r.lock.Unlock()
+	}
+}
+
+func (r *reporter) run() {
+	ticker := time.NewTicker(reportCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			r.runDue(now)
+		case <-r.trigger:
+			r.runDue(time.Now())
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (c *controller) startReporter(r *reporter) {
+	r.stop = make(chan struct{})
+	c.reporter = r
+
+	go r.run()
+}
+
+func (c *controller) stopReporter() {
+	if c.reporter != nil {
+		close(c.reporter.stop)
+		c.reporter = nil
+	}
+}
+
+// ListReports returns the report schedules and the outcome of their last
+// run.
+func (c *controller) ListReports() ([]types.ReportStatus, error) {
+	r := c.reporter
+	if r == nil {
+		return nil, types.ErrReportsDisabled
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	reports := make([]types.ReportStatus, 0, len(r.reports))
+	for _, s := range r.reports {
+		reports = append(reports, s.status())
+	}
+
+	return reports, nil
+}
+
+// RunReport generates a report straight away.  The report is generated in
+// the background, its next scheduled run being postponed by its interval.
+func (c *controller) RunReport(name string) (types.ReportStatus, error) {
+	r := c.reporter
+	if r == nil {
+		return types.ReportStatus{}, types.ErrReportsDisabled
+	}
+
+	r.lock.Lock()
+	s := r.find(name)
+	if s == nil {
+		r.lock.Unlock()
+		return types.ReportStatus{}, types.ErrReportNotFound
+	}
+	s.nextRun = time.Now()
+	status := s.status()
+	r.lock.Unlock()
+
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+
+	return status, nil
+}
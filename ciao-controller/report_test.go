@@ -0,0 +1,289 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadReportSchedules(t *testing.T) {
+	f, err := ioutil.TempFile("", "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_ = f.Close()
+
+	tests := []struct {
+		config string
+		mail   bool
+		upload bool
+		valid  bool
+	}{
+		{"reports:\n- name: daily\n  interval: 24h\n  email: [ops@example.com]\n", true, false, true},
+		{"reports:\n- name: daily\n  interval: 24h\n  format: json\n  object_prefix: reports\n", false, true, true},
+		{"reports:\n- name: daily\n  interval: 24h\n  email: [ops@example.com]\n", false, true, false},
+		{"reports:\n- name: daily\n  interval: 24h\n  object_prefix: reports\n", true, false, false},
+		{"reports:\n- name: daily\n  interval: 24h\n", true, true, false},
+		{"reports:\n- name: daily\n  interval: 10s\n  notify_tenants: true\n", true, true, false},
+		{"reports:\n- name: daily\n  interval: 24h\n  format: xml\n  notify_tenants: true\n", true, true, false},
+		{"reports:\n- interval: 24h\n  notify_tenants: true\n", true, true, false},
+		{"reports:\n- name: daily\n  interval: 24h\n  notify_tenants: true\n- name: daily\n  interval: 1h\n  notify_tenants: true\n", true, true, false},
+	}
+
+	for _, test := range tests {
+		err := ioutil.WriteFile(f.Name(), []byte(test.config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		schedules, err := loadReportSchedules(f.Name(), test.mail, test.upload)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.config, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Invalid schedule %q accepted", test.config)
+		}
+
+		if test.valid && (schedules[0].Format == "" || schedules[0].Interval != 24*time.Hour) {
+			t.Errorf("Unexpected schedule %+v", schedules[0])
+		}
+	}
+}
+
+func TestRenderReport(t *testing.T) {
+	timestamp := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	report := types.TenantReport{
+		TenantID: "tenant",
+		Quotas: []types.QuotaDetails{
+			{Name: "tenant-vcpu-quota", Value: -1, Usage: 4},
+			{Name: "tenant-vcpu-per-instance-limit", Value: 8},
+		},
+		Usage: []types.CiaoUsage{
+			{VCPU: 4, Memory: 512, Disk: 10, NetworkRxBytes: 3000000000,
+				NetworkTxBytes: 1500, Timestamp: timestamp},
+		},
+		Events: []types.LogEntry{
+			{
+				Timestamp: timestamp,
+				Severity:  types.EventError,
+				Category:  types.EventCategoryInstance,
+				Message:   "Instance failed, to start",
+			},
+		},
+		NetworkRxGB: 2.5,
+		NetworkTxGB: 0.0000015,
+	}
+
+	data, err := renderReport(report, types.ReportCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `record,timestamp,name,value,limit,category,message
+quota,,tenant-vcpu-quota,4,unlimited,,
+quota,,tenant-vcpu-per-instance-limit,,8,,
+usage,2017-06-01T12:00:00Z,cpus_usage,4,,,
+usage,2017-06-01T12:00:00Z,ram_usage,512,,,
+usage,2017-06-01T12:00:00Z,disk_usage,10,,,
+usage,2017-06-01T12:00:00Z,network_rx_bytes,3000000000,,,
+usage,2017-06-01T12:00:00Z,network_tx_bytes,1500,,,
+network,,network_rx_gb,2.500,,,
+network,,network_tx_gb,0.000,,,
+event,2017-06-01T12:00:00Z,error,,,instance,"Instance failed, to start"
+`
+	if string(data) != expected {
+		t.Errorf("Expected CSV report:\n%s\ngot:\n%s", expected, data)
+	}
+
+	data, err = renderReport(report, types.ReportJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded types.TenantReport
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.TenantID != report.TenantID || len(decoded.Quotas) != 2 ||
+		decoded.Quotas[0].Usage != 4 || len(decoded.Usage) != 1 || len(decoded.Events) != 1 ||
+		decoded.Usage[0].NetworkRxBytes != 3000000000 || decoded.NetworkRxGB != 2.5 {
+		t.Errorf("Unexpected JSON report %s", data)
+	}
+}
+
+func TestTenantReportTraffic(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnci, err := addFakeCNCI(tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnciClient, err := testutil.NewSsntpTestClientConnection("TenantReportTraffic", ssntp.CNCIAGENT, cnci.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cnciClient.Shutdown()
+
+	start := time.Now().Add(-time.Minute)
+
+	event := payloads.EventTrafficReport{
+		TrafficReport: payloads.TrafficReportEvent{
+			ConcentratorUUID: cnci.ID,
+			TenantUUID:       tenant.ID,
+			Counters: []payloads.TrafficCounter{
+				{PrivateIP: "172.16.0.2", RxBytes: 2000000000, TxBytes: 500000000},
+				{PrivateIP: "172.16.0.3", RxBytes: 500000000},
+			},
+		},
+	}
+
+	y, err := yaml.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controllerCh := wrappedClient.addEventChan(ssntp.TrafficReport)
+
+	_, err = cnciClient.Ssntp.SendEvent(ssntp.TrafficReport, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wrappedClient.getEventChan(controllerCh, ssntp.TrafficReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ctl.tenantReport(tenant.ID, start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.NetworkRxGB != 2.5 || report.NetworkTxGB != 0.5 {
+		t.Fatalf("Expected 2.5/0.5 GB of traffic, got %v/%v", report.NetworkRxGB, report.NetworkTxGB)
+	}
+
+	if len(report.Usage) == 0 || report.Usage[len(report.Usage)-1].NetworkRxBytes != 2500000000 {
+		t.Fatalf("Traffic missing from tenant usage: %+v", report.Usage)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeObjectStore()
+	s, server := newTestObjectStore(t, fake)
+	defer server.Close()
+
+	ctl.objectStore = s
+	defer func() { ctl.objectStore = nil }()
+
+	var mails []sentMail
+	send := func(to string, subject string, body string) error {
+		mails = append(mails, sentMail{to, subject, body})
+		return nil
+	}
+
+	schedule := reportSchedule{
+		Name:         "daily",
+		Interval:     24 * time.Hour,
+		Format:       types.ReportCSV,
+		Tenants:      []string{tenant.ID, "unknown"},
+		Email:        []string{"billing@example.com"},
+		ObjectPrefix: "reports",
+	}
+
+	r := newReporter(ctl, []reportSchedule{schedule}, send, time.Now().Add(-schedule.Interval))
+
+	_, err = ctl.ListReports()
+	if err != types.ErrReportsDisabled {
+		t.Fatalf("Expected %v, got %v", types.ErrReportsDisabled, err)
+	}
+
+	ctl.reporter = r
+	defer func() { ctl.reporter = nil }()
+
+	err = ctl.ds.LogEvent(tenant.ID, types.EventError, types.EventCategoryInstance, "reported event")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Add(time.Second)
+	r.runDue(now)
+
+	if len(mails) != 1 || mails[0].to != "billing@example.com" ||
+		!strings.Contains(mails[0].subject, "daily report for controller test tenant") {
+		t.Fatalf("Unexpected emails %+v", mails)
+	}
+
+	if !strings.Contains(mails[0].body, "reported event") {
+		t.Errorf("Event not reported:\n%s", mails[0].body)
+	}
+
+	key := "/bucket/reports/daily/" + tenant.ID + "/" + now.UTC().Format("20060102T150405Z") + ".csv"
+	if string(fake.objects[key]) != mails[0].body {
+		t.Errorf("Report not uploaded to %s: %v", key, fake.objects)
+	}
+
+	reports, err := ctl.ListReports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) != 1 || reports[0].Delivered != 2 || len(reports[0].Errors) != 1 ||
+		!reports[0].LastRun.Equal(now) || !reports[0].NextRun.Equal(now.Add(schedule.Interval)) {
+		t.Fatalf("Unexpected report status %+v", reports)
+	}
+
+	// nothing is due until the next run
+	r.runDue(now.Add(time.Hour))
+	if len(mails) != 1 {
+		t.Fatalf("Report generated before it was due")
+	}
+
+	status, err := ctl.RunReport("daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.NextRun.After(time.Now()) || len(r.trigger) != 1 {
+		t.Fatalf("Report not triggered: %+v", status)
+	}
+
+	_, err = ctl.RunReport("weekly")
+	if err != types.ErrReportNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrReportNotFound, err)
+	}
+}
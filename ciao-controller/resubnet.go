@@ -0,0 +1,237 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// ResubnetTenant changes the subnet bits of a tenant which has instances.
+// The running instances of the tenant are stopped, its CNCIs removed and
+// new addresses allocated to its instances before the instances are
+// restarted. The change proceeds in the background and its progress is
+// reported by GetTenantResubnet.
+func (c *controller) ResubnetTenant(tenantID string, subnetBits int) (types.TenantResubnetStatus, error) {
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return types.TenantResubnetStatus{}, err
+	}
+	if t == nil {
+		return types.TenantResubnetStatus{}, types.ErrTenantNotFound
+	}
+
+	if subnetBits < 12 || subnetBits > 30 || subnetBits == t.SubnetBits {
+		return types.TenantResubnetStatus{}, types.ErrBadRequest
+	}
+
+	// the mappings of external IPs refer to the current addresses of
+	// the instances.
+	if len(c.ListMappedAddresses(&tenantID)) > 0 {
+		return types.TenantResubnetStatus{}, types.ErrAddressMapped
+	}
+
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return types.TenantResubnetStatus{}, err
+	}
+
+	c.resubnetLock.Lock()
+	defer c.resubnetLock.Unlock()
+
+	if c.resubnets == nil {
+		c.resubnets = make(map[string]*types.TenantResubnetStatus)
+	}
+
+	r, ok := c.resubnets[tenantID]
+	if ok && resubnetInProgress(r) {
+		return *r, types.ErrTenantResubnetting
+	}
+
+	r = &types.TenantResubnetStatus{
+		TenantID:   tenantID,
+		SubnetBits: subnetBits,
+		State:      types.TenantResubnetStopping,
+		Instances:  len(instances),
+		Started:    time.Now(),
+	}
+	c.resubnets[tenantID] = r
+
+	go c.resubnetTenant(tenantID, subnetBits)
+
+	return *r, nil
+}
+
+// GetTenantResubnet returns the progress of the last change of the subnet
+// bits of the tenant.
+func (c *controller) GetTenantResubnet(tenantID string) (types.TenantResubnetStatus, error) {
+	c.resubnetLock.Lock()
+	defer c.resubnetLock.Unlock()
+
+	r, ok := c.resubnets[tenantID]
+	if !ok {
+		return types.TenantResubnetStatus{}, types.ErrTenantNotFound
+	}
+
+	return *r, nil
+}
+
+func resubnetInProgress(r *types.TenantResubnetStatus) bool {
+	return r.State != types.TenantResubnetDone && r.State != types.TenantResubnetFailed
+}
+
+// tenantResubnetting returns true if the subnet bits of the tenant are
+// being changed, in which case no instance can be launched for the
+// tenant.
+func (c *controller) tenantResubnetting(tenantID string) bool {
+	c.resubnetLock.Lock()
+	defer c.resubnetLock.Unlock()
+
+	r, ok := c.resubnets[tenantID]
+	return ok && resubnetInProgress(r)
+}
+
+func (c *controller) setResubnetState(tenantID string, state types.TenantResubnetState) {
+	c.resubnetLock.Lock()
+	c.resubnets[tenantID].State = state
+	c.resubnetLock.Unlock()
+}
+
+func (c *controller) resubnetTenant(tenantID string, subnetBits int) {
+	err := c.doResubnetTenant(tenantID, subnetBits)
+
+	c.resubnetLock.Lock()
+	defer c.resubnetLock.Unlock()
+
+	r := c.resubnets[tenantID]
+	if err != nil {
+		glog.Warningf("Error changing subnet bits of tenant %s: %v", tenantID, err)
+		r.State = types.TenantResubnetFailed
+		r.Error = err.Error()
+		return
+	}
+
+	glog.Infof("Tenant %s moved to /%d subnets", tenantID, subnetBits)
+	r.State = types.TenantResubnetDone
+}
+
+func (c *controller) doResubnetTenant(tenantID string, subnetBits int) error {
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range instances {
+		if i.State != payloads.Running && i.State != payloads.Exited {
+			return fmt.Errorf("Instance %s is %s, instances must be running or exited", i.ID, i.State)
+		}
+	}
+
+	var running []*types.Instance
+	for _, i := range instances {
+		if i.State != payloads.Running {
+			continue
+		}
+
+		err = c.stopInstanceSync(i.ID)
+		if err != nil {
+			return errors.Wrapf(err, "Error stopping instance %s", i.ID)
+		}
+		running = append(running, i)
+	}
+
+	c.setResubnetState(tenantID, types.TenantResubnetRebuilding)
+
+	err = c.deleteCNCIInstances(tenantID)
+	if err != nil {
+		return err
+	}
+
+	err = c.ds.ResubnetTenant(tenantID, subnetBits)
+	if err != nil {
+		return errors.Wrap(err, "Error updating tenant")
+	}
+
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	t.CNCIctrl, err = newCNCIManager(c, tenantID)
+	if err != nil {
+		return err
+	}
+
+	err = c.readdressInstances(t, instances)
+	if err != nil {
+		return err
+	}
+
+	c.setResubnetState(tenantID, types.TenantResubnetRestarting)
+
+	for _, i := range running {
+		err = c.restartInstance(i.ID)
+		if err != nil {
+			return errors.Wrapf(err, "Error restarting instance %s", i.ID)
+		}
+	}
+
+	return nil
+}
+
+// readdressInstances allocates addresses in the new subnets of the tenant
+// to its instances, launching the CNCIs of these subnets.
+func (c *controller) readdressInstances(t *types.Tenant, instances []*types.Instance) error {
+	if len(instances) == 0 {
+		return nil
+	}
+
+	IPs, err := c.ds.AllocateTenantIPPool(t.ID, len(instances))
+	if err != nil {
+		return errors.Wrap(err, "Error allocating tenant IPs")
+	}
+
+	mask := net.CIDRMask(t.SubnetBits, 32)
+
+	for n, i := range instances {
+		ipnet := net.IPNet{
+			IP:   IPs[n].Mask(mask),
+			Mask: mask,
+		}
+
+		i.IPAddress = IPs[n].String()
+		i.MACAddress = utils.NewTenantHardwareAddr(IPs[n]).String()
+		i.Subnet = ipnet.String()
+
+		err = c.ds.UpdateInstance(i)
+		if err != nil {
+			return errors.Wrapf(err, "Error updating instance %s", i.ID)
+		}
+
+		err = t.CNCIctrl.WaitForActive(i.Subnet)
+		if err != nil {
+			return errors.Wrapf(err, "Error waiting for subnet %s", i.Subnet)
+		}
+	}
+
+	return nil
+}
@@ -21,10 +21,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/ciao-project/ciao/service"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
@@ -34,10 +36,66 @@ import (
 type clientCertAuthHandler struct {
 	Controller *controller
 	Next       http.Handler
+
+	// Resource is the API resource served by Next, against which the
+	// scopes of restricted certificates are checked.
+	Resource string
+}
+
+// routeResource returns the API resource served by a route, i.e. the
+// first fixed element of its path, ignoring the version prefix of the
+// legacy API. It returns an empty string for the discovery routes, which
+// are available whatever the scopes of the caller.
+func routeResource(template string) string {
+	aliases := map[string]string{
+		"servers": "instances",
+		"nodes":   "node",
+	}
+
+	for _, element := range strings.Split(template, "/") {
+		if element == "" || element == "v2.1" || strings.HasPrefix(element, "{") {
+			continue
+		}
+
+		if alias, ok := aliases[element]; ok {
+			return alias
+		}
+
+		return element
+	}
+
+	return ""
+}
+
+// scopeAccess returns the access needed to perform a request.
+func scopeAccess(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return service.ReadAccess
+	}
+
+	return service.WriteAccess
+}
+
+// lockedOut rejects the request if the caller is locked out after failing
+// to authenticate too many times.
+func lockedOut(w http.ResponseWriter, remaining time.Duration) bool {
+	if remaining == 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(remaining.Seconds())+1))
+	http.Error(w, "Too many failed authentications", http.StatusTooManyRequests)
+	return true
 }
 
 func (h *clientCertAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	source := requestSource(r)
 	if len(r.TLS.VerifiedChains) != 1 {
+		if lockedOut(w, h.Controller.authLocked(types.AuthLockoutSource, source)) {
+			return
+		}
+
+		h.Controller.authFailed(types.AuthLockoutSource, source, "unexpected certificate chains")
 		http.Error(w, "Unexpected number of certificate chains presented", http.StatusUnauthorized)
 		return
 	}
@@ -51,6 +109,25 @@ func (h *clientCertAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		privileged = true
 	}
 
+	// Verified admin certificates are exempt from the source lockout,
+	// otherwise tenants sharing an address with an administrator could
+	// prevent them from clearing the lockout.
+	if !privileged && lockedOut(w, h.Controller.authLocked(types.AuthLockoutSource, source)) {
+		return
+	}
+
+	account := cert.Subject.CommonName
+	if lockedOut(w, h.Controller.authLocked(types.AuthLockoutAccount, account)) {
+		return
+	}
+
+	// failed records an authentication failure against both the
+	// client address and the account of the request.
+	failed := func(reason string) {
+		h.Controller.authFailed(types.AuthLockoutSource, source, reason)
+		h.Controller.authFailed(types.AuthLockoutAccount, account, reason)
+	}
+
 	r = r.WithContext(service.SetPrivilege(r.Context(), true))
 
 	vars := mux.Vars(r)
@@ -64,11 +141,44 @@ func (h *clientCertAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 			}
 		}
 		if !tenantMatched {
+			failed(fmt.Sprintf("access to tenant %s not permitted", tenantFromVars))
 			http.Error(w, "Access to tenant not permitted with certificate", http.StatusUnauthorized)
 			return
 		}
 	}
 
+	scopes, err := service.ScopesFromUnits(cert.Subject.OrganizationalUnit)
+	if err != nil {
+		failed(err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	h.Controller.authSucceeded(account)
+
+	if scopes != nil && h.Resource != "" && !service.Permits(scopes, h.Resource, scopeAccess(r)) {
+		http.Error(w, "Operation not permitted by certificate scopes", http.StatusForbidden)
+		return
+	}
+
+	impersonated := r.Header.Get(api.ImpersonateHeader)
+	if impersonated != "" {
+		if !privileged {
+			http.Error(w, "Impersonation requires an admin certificate", http.StatusForbidden)
+			return
+		}
+
+		if tenantFromVars != impersonated {
+			http.Error(w, "Request is not for the impersonated tenant", http.StatusForbidden)
+			return
+		}
+
+		admin := cert.Subject.CommonName
+		r = r.WithContext(service.SetPrivilege(r.Context(), false))
+		r = r.WithContext(service.SetImpersonator(r.Context(), admin))
+		h.Controller.auditImpersonation(admin, impersonated, r)
+	}
+
 	r = r.WithContext(service.SetTenantID(r.Context(), tenantFromVars))
 	if tenantFromVars != "" {
 		err := h.Controller.confirmTenant(tenantFromVars)
@@ -80,15 +190,32 @@ func (h *clientCertAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	h.Next.ServeHTTP(w, r)
 }
 
+// auditImpersonation records a request made by an admin on behalf of a
+// tenant in the event log of the tenant.
+func (c *controller) auditImpersonation(admin string, tenant string, r *http.Request) {
+	msg := fmt.Sprintf("Admin %s acting as tenant %s: %s %s", admin, tenant, r.Method, r.URL.Path)
+	glog.Info(msg)
+
+	if err := c.ds.LogEvent(tenant, types.EventInfo, types.EventCategoryAuth, msg); err != nil {
+		glog.Warningf("Error logging impersonation: %v", err)
+	}
+}
+
 func (c *controller) createCiaoRoutes(r *mux.Router) error {
 	config := api.Config{URL: c.apiURL, CiaoService: c}
 
 	r = api.Routes(config, r)
 
 	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
 		h := &clientCertAuthHandler{
 			Next:       route.GetHandler(),
 			Controller: c,
+			Resource:   routeResource(template),
 		}
 		route.Handler(h)
 
@@ -98,6 +225,17 @@ func (c *controller) createCiaoRoutes(r *mux.Router) error {
 	return err
 }
 
+// Revision returns the datastore revision of a collection of objects,
+// used by the API to generate entity tags for list responses.
+func (c *controller) Revision(resource types.RevisionedResource) string {
+	return c.ds.Revision(resource)
+}
+
+// GetMetrics returns the performance metrics of the controller.
+func (c *controller) GetMetrics() (types.ControllerMetrics, error) {
+	return types.ControllerMetrics{Datastore: c.ds.Metrics()}, nil
+}
+
 func (c *controller) createCiaoServer() (*http.Server, error) {
 	r := mux.NewRouter()
 
@@ -123,6 +261,12 @@ func (c *controller) createCiaoServer() (*http.Server, error) {
 	}
 	server.TLSConfig = &tlsConfig
 
+	// net/http negotiates HTTP/2 over TLS unless TLSNextProto is set
+	// to a non-nil, empty map.
+	if !enableHTTP2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
 	if err := c.createComputeRoutes(r); err != nil {
 		return nil, errors.Wrap(err, "Error adding compute routes")
 	}
@@ -132,6 +276,10 @@ func (c *controller) createCiaoServer() (*http.Server, error) {
 		return nil, errors.Wrap(err, "Error adding ciao routes")
 	}
 
+	if compressResponses {
+		server.Handler = &gzipHandler{Next: r}
+	}
+
 	return server, nil
 }
 
@@ -0,0 +1,188 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/service"
+	"github.com/gorilla/mux"
+)
+
+func TestImpersonation(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var privileged bool
+	var impersonator string
+
+	r := mux.NewRouter()
+	r.Handle("/{tenant}/instances", &clientCertAuthHandler{
+		Controller: ctl,
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			privileged = service.GetPrivilege(r.Context())
+			impersonator = service.GetImpersonator(r.Context())
+		}),
+	})
+
+	tests := []struct {
+		org          string
+		impersonated string
+		status       int
+	}{
+		{"admin", tenant.ID, http.StatusOK},
+		{"admin", other.ID, http.StatusForbidden},
+		{tenant.ID, tenant.ID, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		privileged = true
+		impersonator = ""
+
+		req := httptest.NewRequest("GET", "/"+tenant.ID+"/instances", nil)
+		req.Header.Set(api.ImpersonateHeader, test.impersonated)
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{
+					CommonName:   "operator",
+					Organization: []string{test.org},
+				},
+			}}},
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s impersonating %s: expected status %d, got %d", test.org, test.impersonated, test.status, w.Code)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		if privileged || impersonator != "operator" {
+			t.Errorf("Unexpected request context: privileged %v, impersonator %q", privileged, impersonator)
+		}
+	}
+
+	log, err := ctl.ds.GetEventLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range log {
+		if e.TenantID == tenant.ID && strings.Contains(e.Message, "Admin operator acting as tenant") {
+			return
+		}
+	}
+
+	t.Fatal("Impersonation not recorded in the event log")
+}
+
+func TestRouteResource(t *testing.T) {
+	tests := []struct {
+		template string
+		resource string
+	}{
+		{"/", ""},
+		{"/{tenant}", ""},
+		{"/{tenant}/instances/{instance_id}/action", "instances"},
+		{"/{tenant:[a-f0-9-]+}/volumes", "volumes"},
+		{"/node/{node_id}/commands", "node"},
+		{"/v2.1/{tenant}/servers/action", "instances"},
+		{"/v2.1/nodes/{node}/servers/detail", "node"},
+	}
+
+	for _, test := range tests {
+		if r := routeResource(test.template); r != test.resource {
+			t.Errorf("%s: expected resource %q, got %q", test.template, test.resource, r)
+		}
+	}
+}
+
+func TestCertificateScopes(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	r := mux.NewRouter()
+	r.Handle("/{tenant}/instances", &clientCertAuthHandler{
+		Controller: ctl,
+		Next:       next,
+		Resource:   "instances",
+	})
+	r.Handle("/{tenant}/volumes", &clientCertAuthHandler{
+		Controller: ctl,
+		Next:       next,
+		Resource:   "volumes",
+	})
+
+	tests := []struct {
+		units  []string
+		method string
+		path   string
+		status int
+	}{
+		{nil, "POST", "/instances", http.StatusOK},
+		{[]string{"Engineering"}, "POST", "/instances", http.StatusOK},
+		{[]string{"scope:instances:read"}, "GET", "/instances", http.StatusOK},
+		{[]string{"scope:instances:read"}, "POST", "/instances", http.StatusForbidden},
+		{[]string{"scope:instances:read"}, "GET", "/volumes", http.StatusForbidden},
+		{[]string{"scope:instances:read", "scope:volumes:write"}, "POST", "/volumes", http.StatusOK},
+		{[]string{"scope:volumes:write"}, "GET", "/volumes", http.StatusOK},
+		{[]string{"scope:*:read"}, "GET", "/volumes", http.StatusOK},
+		{[]string{"scope:*:read"}, "DELETE", "/instances", http.StatusForbidden},
+		{[]string{"scope:instances:admin"}, "GET", "/instances", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/"+tenant.ID+test.path, nil)
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{
+				Subject: pkix.Name{
+					CommonName:         "automation",
+					Organization:       []string{tenant.ID},
+					OrganizationalUnit: test.units,
+				},
+			}}},
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%v: %s %s: expected status %d, got %d", test.units, test.method, test.path, test.status, w.Code)
+		}
+	}
+}
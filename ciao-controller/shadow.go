@@ -0,0 +1,242 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+)
+
+var shadowPolicyFlag = flag.String("shadow_scheduler_policy", "", "scheduling policy evaluating every placement without acting on it, to be compared with the scheduler: first_fit, spread or pack")
+
+// placementPolicy picks a node among the nodes fitting an instance.
+type placementPolicy string
+
+const (
+	// firstFitPolicy picks the first fitting node in node ID order.
+	firstFitPolicy placementPolicy = "first_fit"
+
+	// spreadPolicy picks the fitting node with the most memory available.
+	spreadPolicy placementPolicy = "spread"
+
+	// packPolicy picks the fitting node with the least memory available.
+	packPolicy placementPolicy = "pack"
+)
+
+func parsePlacementPolicy(s string) (placementPolicy, error) {
+	switch p := placementPolicy(s); p {
+	case firstFitPolicy, spreadPolicy, packPolicy:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("Unknown placement policy %q", s)
+}
+
+// shadowClaim is the memory and disk the shadow placements since the last
+// stats of a node would have used on it.
+type shadowClaim struct {
+	memMB  int
+	diskMB int
+	since  time.Time
+}
+
+// shadowScheduler evaluates a placement policy on every launch without
+// acting on it, so that the policy can be compared with the scheduler on
+// real traffic before switching to it.
+type shadowScheduler struct {
+	policy placementPolicy
+
+	lock    sync.Mutex
+	claims  map[string]shadowClaim
+	pending map[string]bool
+}
+
+func newShadowScheduler(policy placementPolicy) *shadowScheduler {
+	return &shadowScheduler{
+		policy:  policy,
+		claims:  make(map[string]shadowClaim),
+		pending: make(map[string]bool),
+	}
+}
+
+// place returns the node the policy picks for a workload, or an empty
+// string if no node fits it, and claims the resources of the workload on
+// that node until its next stats.
+func (s *shadowScheduler) place(nodes []types.CiaoNode, start *payloads.StartCmd) string {
+	diskMB := 0
+	for _, volume := range start.Storage {
+		if volume.Local {
+			diskMB += volume.Size * 1024
+		}
+	}
+
+	excluded := make(map[string]bool)
+	for _, n := range start.ExcludeNodes {
+		excluded[n] = true
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var picked string
+	var pickedMem int
+	for _, n := range nodes {
+		claim := s.claims[n.ID]
+		if !n.Timestamp.Before(claim.since) {
+			claim = shadowClaim{}
+			delete(s.claims, n.ID)
+		}
+
+		mem := n.MemAvailable - claim.memMB
+		if n.Status != ssntp.READY.String() || excluded[n.ID] ||
+			mem < start.Requirements.MemMB ||
+			n.DiskAvailable-claim.diskMB < diskMB ||
+			(start.Requirements.NodeID != "" && start.Requirements.NodeID != n.ID) ||
+			(start.Requirements.Hostname != "" && start.Requirements.Hostname != n.Hostname) {
+			continue
+		}
+
+		if picked == "" ||
+			(s.policy == spreadPolicy && mem > pickedMem) ||
+			(s.policy == packPolicy && mem < pickedMem) {
+			picked = n.ID
+			pickedMem = mem
+		}
+
+		if s.policy == firstFitPolicy {
+			break
+		}
+	}
+
+	if picked != "" {
+		claim := s.claims[picked]
+		if claim.since.IsZero() {
+			claim.since = time.Now()
+		}
+		claim.memMB += start.Requirements.MemMB
+		claim.diskMB += diskMB
+		s.claims[picked] = claim
+	}
+
+	return picked
+}
+
+// shadowPlace records where the shadow scheduler would have placed an
+// instance being launched.  CNCIs are placed on network nodes, which the
+// controller cannot tell from compute nodes, and are not evaluated.
+func (c *controller) shadowPlace(i *instance) {
+	if c.shadow == nil || i.CNCI {
+		return
+	}
+
+	start := &i.newConfig.sc.Start
+	p := types.ShadowPlacement{
+		InstanceID:   i.ID,
+		TenantID:     i.TenantID,
+		Policy:       string(c.shadow.policy),
+		Timestamp:    time.Now(),
+		ShadowNodeID: c.shadow.place(c.ds.GetNodeLastStats().Nodes, start),
+	}
+
+	err := c.ds.AddShadowPlacement(p)
+	if err != nil {
+		glog.Warningf("Error recording shadow placement of instance %s: %v", i.ID, err)
+		return
+	}
+
+	c.shadow.lock.Lock()
+	c.shadow.pending[i.ID] = true
+	c.shadow.lock.Unlock()
+}
+
+// shadowPlacementsReported records the nodes the scheduler placed the
+// instances with a pending shadow placement on.
+func (c *controller) shadowPlacementsReported(stats payloads.Stat) {
+	if c.shadow == nil {
+		return
+	}
+
+	var placed []string
+
+	c.shadow.lock.Lock()
+	for _, i := range stats.Instances {
+		if c.shadow.pending[i.InstanceUUID] {
+			delete(c.shadow.pending, i.InstanceUUID)
+			placed = append(placed, i.InstanceUUID)
+		}
+	}
+	c.shadow.lock.Unlock()
+
+	for _, instanceID := range placed {
+		err := c.ds.SetShadowPlacementNode(instanceID, stats.NodeUUID)
+		if err != nil {
+			glog.Warningf("Error recording placement of instance %s: %v", instanceID, err)
+		}
+	}
+}
+
+// ListShadowPlacements returns the placements recorded in shadow
+// scheduling mode, along with how often each policy agreed with the
+// scheduler.
+func (c *controller) ListShadowPlacements() (types.ShadowPlacementsResponse, error) {
+	placements, err := c.ds.GetShadowPlacements()
+	if err != nil {
+		return types.ShadowPlacementsResponse{}, err
+	}
+
+	summaries := make(map[string]*types.ShadowPlacementSummary)
+	for _, p := range placements {
+		s := summaries[p.Policy]
+		if s == nil {
+			s = &types.ShadowPlacementSummary{Policy: p.Policy}
+			summaries[p.Policy] = s
+		}
+
+		s.Placements++
+		switch {
+		case p.ActualNodeID == "":
+			s.Pending++
+		case p.ShadowNodeID == "":
+			s.Unplaceable++
+		case p.ShadowNodeID == p.ActualNodeID:
+			s.Agreed++
+		default:
+			s.Disagreed++
+		}
+	}
+
+	resp := types.ShadowPlacementsResponse{
+		Summaries:  []types.ShadowPlacementSummary{},
+		Placements: placements,
+	}
+	for _, s := range summaries {
+		resp.Summaries = append(resp.Summaries, *s)
+	}
+	sort.Slice(resp.Summaries, func(i, j int) bool {
+		return resp.Summaries[i].Policy < resp.Summaries[j].Policy
+	})
+
+	return resp, nil
+}
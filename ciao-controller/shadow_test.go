@@ -0,0 +1,166 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/testutil"
+)
+
+func shadowTestNodes() []types.CiaoNode {
+	now := time.Now().Add(-time.Minute)
+	ready := ssntp.READY.String()
+
+	return []types.CiaoNode{
+		{ID: "node-c", Hostname: "c", Timestamp: now, Status: ready, MemAvailable: 4096, DiskAvailable: 100000},
+		{ID: "node-a", Hostname: "a", Timestamp: now, Status: ready, MemAvailable: 2048, DiskAvailable: 100000},
+		{ID: "node-b", Hostname: "b", Timestamp: now, Status: ready, MemAvailable: 8192, DiskAvailable: 100000},
+		{ID: "node-d", Hostname: "d", Timestamp: now, Status: ssntp.FULL.String(), MemAvailable: 16384, DiskAvailable: 100000},
+	}
+}
+
+func TestParsePlacementPolicy(t *testing.T) {
+	for _, s := range []string{"first_fit", "spread", "pack"} {
+		p, err := parsePlacementPolicy(s)
+		if err != nil || string(p) != s {
+			t.Errorf("Expected policy %s, got %s: %v", s, p, err)
+		}
+	}
+
+	if _, err := parsePlacementPolicy("random"); err == nil {
+		t.Error("Unknown policy accepted")
+	}
+}
+
+func TestShadowPlace(t *testing.T) {
+	tests := []struct {
+		policy   placementPolicy
+		start    payloads.StartCmd
+		expected []string
+	}{
+		{
+			firstFitPolicy,
+			payloads.StartCmd{Requirements: payloads.WorkloadRequirements{MemMB: 1024}},
+			[]string{"node-a", "node-a", "node-b"},
+		},
+		{
+			spreadPolicy,
+			payloads.StartCmd{Requirements: payloads.WorkloadRequirements{MemMB: 3072}},
+			[]string{"node-b", "node-b", "node-c", ""},
+		},
+		{
+			packPolicy,
+			payloads.StartCmd{Requirements: payloads.WorkloadRequirements{MemMB: 2048}},
+			[]string{"node-a", "node-c", "node-c", "node-b"},
+		},
+		{
+			spreadPolicy,
+			payloads.StartCmd{
+				Requirements: payloads.WorkloadRequirements{MemMB: 1024},
+				ExcludeNodes: []string{"node-b"},
+			},
+			[]string{"node-c", "node-c", "node-a", "node-c"},
+		},
+		{
+			spreadPolicy,
+			payloads.StartCmd{Requirements: payloads.WorkloadRequirements{MemMB: 1024, Hostname: "a"}},
+			[]string{"node-a", "node-a", ""},
+		},
+		{
+			firstFitPolicy,
+			payloads.StartCmd{
+				Requirements: payloads.WorkloadRequirements{MemMB: 128},
+				Storage:      []payloads.StorageResource{{Local: true, Size: 200}},
+			},
+			[]string{""},
+		},
+	}
+
+	for _, test := range tests {
+		s := newShadowScheduler(test.policy)
+		for i, expected := range test.expected {
+			node := s.place(shadowTestNodes(), &test.start)
+			if node != expected {
+				t.Errorf("%s placement %d: expected %q, got %q", test.policy, i, expected, node)
+			}
+		}
+	}
+}
+
+func TestShadowPlaceNewStats(t *testing.T) {
+	s := newShadowScheduler(firstFitPolicy)
+	start := &payloads.StartCmd{Requirements: payloads.WorkloadRequirements{MemMB: 2048}}
+
+	nodes := shadowTestNodes()
+	if node := s.place(nodes, start); node != "node-a" {
+		t.Fatalf("Expected node-a, got %q", node)
+	}
+
+	if node := s.place(nodes, start); node != "node-b" {
+		t.Fatalf("Expected node-b while node-a is claimed, got %q", node)
+	}
+
+	// stats newer than the claim account for the instance
+	for i := range nodes {
+		nodes[i].Timestamp = time.Now().Add(time.Second)
+	}
+
+	if node := s.place(nodes, start); node != "node-a" {
+		t.Fatalf("Expected claim on node-a to be reset, got %q", node)
+	}
+}
+
+func TestShadowPlacements(t *testing.T) {
+	ctl.shadow = newShadowScheduler(spreadPolicy)
+	defer func() { ctl.shadow = nil }()
+
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	resp, err := ctl.ListShadowPlacements()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var placement *types.ShadowPlacement
+	for i := range resp.Placements {
+		if resp.Placements[i].InstanceID == instances[0].ID {
+			placement = &resp.Placements[i]
+		}
+	}
+
+	if placement == nil {
+		t.Fatalf("No shadow placement for instance %s", instances[0].ID)
+	}
+
+	if placement.Policy != string(spreadPolicy) || placement.TenantID != instances[0].TenantID ||
+		placement.ActualNodeID != testutil.AgentUUID {
+		t.Fatalf("Unexpected shadow placement %+v", placement)
+	}
+
+	if len(resp.Summaries) != 1 || resp.Summaries[0].Policy != string(spreadPolicy) ||
+		resp.Summaries[0].Placements != len(resp.Placements) {
+		t.Fatalf("Unexpected shadow placement summaries %+v", resp.Summaries)
+	}
+}
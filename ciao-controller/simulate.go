@@ -0,0 +1,686 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Resources of each simulated node.
+const (
+	simulatedMemMB  = 32768
+	simulatedDiskMB = 512000
+	simulatedCPUs   = 16
+)
+
+// simulatedLatency is the time the simulated nodes take to act on a
+// command.
+var simulatedLatency = 500 * time.Millisecond
+
+// simulatedStatsInterval is the interval at which the simulated nodes
+// send their stats.
+var simulatedStatsInterval = 10 * time.Second
+
+type simulatedNode struct {
+	id          string
+	hostname    string
+	nodeType    payloads.Resource
+	maintenance bool
+	instances   map[string]payloads.WorkloadRequirements
+	images      map[string]bool
+}
+
+func (n *simulatedNode) freeMemMB() int {
+	free := simulatedMemMB
+	for _, r := range n.instances {
+		free -= r.MemMB
+	}
+	return free
+}
+
+func (n *simulatedNode) stats() payloads.Stat {
+	stat := payloads.Stat{
+		NodeUUID:        n.id,
+		Status:          ssntp.READY.String(),
+		MemTotalMB:      simulatedMemMB,
+		MemAvailableMB:  n.freeMemMB(),
+		DiskTotalMB:     simulatedDiskMB,
+		DiskAvailableMB: simulatedDiskMB,
+		CpusOnline:      simulatedCPUs,
+		NodeHostName:    n.hostname,
+		Timestamp:       time.Now().Format(time.RFC3339Nano),
+	}
+
+	if n.maintenance {
+		stat.Status = ssntp.MAINTENANCE.String()
+	}
+
+	for image := range n.images {
+		stat.CachedImages = append(stat.CachedImages, image)
+	}
+	sort.Strings(stat.CachedImages)
+
+	for id, r := range n.instances {
+		stat.Load += r.VCPUs
+		stat.Instances = append(stat.Instances, payloads.InstanceStat{
+			InstanceUUID:  id,
+			State:         payloads.Running,
+			MemoryUsageMB: r.MemMB,
+		})
+	}
+
+	return stat
+}
+
+// simulatedClient is a controllerClient which does not connect to any
+// SSNTP server. The commands it is given are acknowledged by a set of
+// simulated nodes, which send back the events and stats real nodes would
+// send.
+type simulatedClient struct {
+	notifier *ssntpClient
+
+	nodesLock sync.Mutex
+	nodes     []*simulatedNode
+	next      int
+	cncis     int
+
+	notifyLock sync.Mutex
+	stop       chan struct{}
+}
+
+func newSimulatedClient(ctl *controller, computeNodes int) *simulatedClient {
+	client := &simulatedClient{
+		notifier: &ssntpClient{name: "ciao Controller", ctl: ctl},
+		stop:     make(chan struct{}),
+	}
+
+	for i := 0; i < computeNodes; i++ {
+		client.addNode(fmt.Sprintf("sim-compute-%d", i), payloads.ComputeNode)
+	}
+	client.addNode("sim-network-0", payloads.NetworkNode)
+
+	for _, n := range client.nodes {
+		client.sendEvent(ssntp.NodeConnected, payloads.NodeConnected{
+			Connected: payloads.NodeConnectedEvent{
+				NodeUUID: n.id,
+				NodeType: n.nodeType,
+			},
+		})
+	}
+
+	client.sendAllStats()
+
+	go func() {
+		ticker := time.NewTicker(simulatedStatsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				client.sendAllStats()
+			case <-client.stop:
+				return
+			}
+		}
+	}()
+
+	return client
+}
+
+func (client *simulatedClient) addNode(hostname string, nodeType payloads.Resource) {
+	client.nodes = append(client.nodes, &simulatedNode{
+		id:        uuid.Generate().String(),
+		hostname:  hostname,
+		nodeType:  nodeType,
+		instances: make(map[string]payloads.WorkloadRequirements),
+		images:    make(map[string]bool),
+	})
+}
+
+func (client *simulatedClient) sendEvent(event ssntp.Event, payload interface{}) {
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		glog.Warningf("Error marshalling simulated %s: %v", event, err)
+		return
+	}
+
+	client.notifyLock.Lock()
+	client.notifier.EventNotify(event, &ssntp.Frame{Payload: y})
+	client.notifyLock.Unlock()
+}
+
+// sendAck acknowledges the successful completion of a command carrying an
+// identifier, as a node does.
+func (client *simulatedClient) sendAck(nodeID string, commandID string) {
+	if commandID == "" {
+		return
+	}
+
+	client.sendEvent(ssntp.NodeCommandResult, payloads.EventNodeCommandResult{
+		NodeCommandResult: payloads.NodeCommandResultEvent{
+			CommandID: commandID,
+			NodeUUID:  nodeID,
+		},
+	})
+}
+
+func (client *simulatedClient) sendError(e ssntp.Error, payload interface{}) {
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		glog.Warningf("Error marshalling simulated %s: %v", e, err)
+		return
+	}
+
+	client.notifyLock.Lock()
+	client.notifier.ErrorNotify(e, &ssntp.Frame{Payload: y})
+	client.notifyLock.Unlock()
+}
+
+func (client *simulatedClient) sendStats(stat payloads.Stat) {
+	y, err := yaml.Marshal(stat)
+	if err != nil {
+		glog.Warningf("Error marshalling simulated STATS: %v", err)
+		return
+	}
+
+	client.notifyLock.Lock()
+	client.notifier.CommandNotify(ssntp.STATS, &ssntp.Frame{Payload: y})
+	client.notifyLock.Unlock()
+}
+
+func (client *simulatedClient) sendAllStats() {
+	client.nodesLock.Lock()
+	stats := make([]payloads.Stat, 0, len(client.nodes))
+	for _, n := range client.nodes {
+		stats = append(stats, n.stats())
+	}
+	client.nodesLock.Unlock()
+
+	for _, stat := range stats {
+		client.sendStats(stat)
+	}
+}
+
+// schedule picks the node an instance is started on, spreading the
+// instances across the nodes with enough free memory. It must be called
+// with nodesLock held.
+func (client *simulatedClient) schedule(cnci bool, r payloads.WorkloadRequirements) *simulatedNode {
+	var nodeType payloads.Resource = payloads.ComputeNode
+	if cnci {
+		nodeType = payloads.NetworkNode
+	}
+
+	for i := range client.nodes {
+		n := client.nodes[(client.next+i)%len(client.nodes)]
+
+		if n.nodeType != nodeType || n.maintenance {
+			continue
+		}
+
+		if r.NodeID != "" && r.NodeID != n.id {
+			continue
+		}
+
+		if n.freeMemMB() < r.MemMB {
+			continue
+		}
+
+		client.next = (client.next + i + 1) % len(client.nodes)
+		return n
+	}
+
+	return nil
+}
+
+func (client *simulatedClient) findNode(nodeID string) *simulatedNode {
+	for _, n := range client.nodes {
+		if n.id == nodeID {
+			return n
+		}
+	}
+
+	return nil
+}
+
+func (client *simulatedClient) start(cmd payloads.StartCmd) {
+	i, err := client.notifier.ctl.ds.GetInstance(cmd.InstanceUUID)
+	if err != nil {
+		glog.Warningf("Error getting simulated instance %s: %v", cmd.InstanceUUID, err)
+		return
+	}
+
+	var stat payloads.Stat
+	var cnciIP string
+
+	client.nodesLock.Lock()
+	n := client.schedule(i.CNCI, cmd.Requirements)
+	if n != nil {
+		n.instances[cmd.InstanceUUID] = cmd.Requirements
+		stat = n.stats()
+	}
+	if i.CNCI {
+		client.cncis++
+		cnciIP = fmt.Sprintf("192.168.%d.%d", client.cncis/254, client.cncis%254+1)
+	}
+	client.nodesLock.Unlock()
+
+	if n == nil {
+		client.sendError(ssntp.StartFailure, payloads.ErrorStartFailure{
+			InstanceUUID: cmd.InstanceUUID,
+			Reason:       payloads.FullCloud,
+			Restart:      cmd.Restart,
+			CommandID:    cmd.CommandID,
+		})
+		return
+	}
+
+	client.sendStats(stat)
+	client.sendAck(n.id, cmd.CommandID)
+
+	if i.CNCI {
+		client.sendEvent(ssntp.ConcentratorInstanceAdded, payloads.EventConcentratorInstanceAdded{
+			CNCIAdded: payloads.ConcentratorInstanceAddedEvent{
+				InstanceUUID:    cmd.InstanceUUID,
+				TenantUUID:      cmd.TenantUUID,
+				ConcentratorIP:  cnciIP,
+				ConcentratorMAC: cmd.Networking.VnicMAC,
+			},
+		})
+	}
+}
+
+// removeInstance removes an instance from its node, returning false if
+// the node does not host it.
+func (client *simulatedClient) removeInstance(instanceID string, nodeID string) bool {
+	client.nodesLock.Lock()
+	defer client.nodesLock.Unlock()
+
+	n := client.findNode(nodeID)
+	if n == nil {
+		return false
+	}
+
+	if _, ok := n.instances[instanceID]; !ok {
+		return false
+	}
+
+	delete(n.instances, instanceID)
+	return true
+}
+
+func (client *simulatedClient) ConnectNotify() {
+	client.notifier.ConnectNotify()
+}
+
+func (client *simulatedClient) DisconnectNotify() {
+	client.notifier.DisconnectNotify()
+}
+
+func (client *simulatedClient) StatusNotify(status ssntp.Status, frame *ssntp.Frame) {
+	client.notifier.StatusNotify(status, frame)
+}
+
+func (client *simulatedClient) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
+	client.notifier.CommandNotify(command, frame)
+}
+
+func (client *simulatedClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
+	client.notifier.EventNotify(event, frame)
+}
+
+func (client *simulatedClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
+	client.notifier.ErrorNotify(err, frame)
+}
+
+func (client *simulatedClient) StartTracedWorkload(config string, startTime time.Time, label string) error {
+	return client.StartWorkload(config)
+}
+
+func (client *simulatedClient) StartWorkload(config string) error {
+	var payload payloads.Start
+
+	err := yaml.Unmarshal([]byte(config), &payload)
+	if err != nil {
+		return errors.Wrap(err, "Error parsing START payload")
+	}
+
+	time.AfterFunc(simulatedLatency, func() { client.start(payload.Start) })
+
+	return nil
+}
+
+// delete removes an instance from its node and reports it deleted or
+// stopped, or reports a DeleteFailure if the node does not host it.
+func (client *simulatedClient) delete(instanceID string, nodeID string, stop bool, commandID string) {
+	time.AfterFunc(simulatedLatency, func() {
+		if !client.removeInstance(instanceID, nodeID) {
+			glog.Warningf("Simulated node %s does not host instance %s", nodeID, instanceID)
+			client.sendError(ssntp.DeleteFailure, payloads.ErrorDeleteFailure{
+				NodeUUID:     nodeID,
+				InstanceUUID: instanceID,
+				Reason:       payloads.DeleteNoInstance,
+				CommandID:    commandID,
+			})
+			return
+		}
+
+		if stop {
+			client.sendEvent(ssntp.InstanceStopped, payloads.EventInstanceStopped{
+				InstanceStopped: payloads.InstanceStoppedEvent{
+					InstanceUUID: instanceID,
+					CommandID:    commandID,
+				},
+			})
+			return
+		}
+
+		client.sendEvent(ssntp.InstanceDeleted, payloads.EventInstanceDeleted{
+			InstanceDeleted: payloads.InstanceDeletedEvent{
+				InstanceUUID: instanceID,
+				CommandID:    commandID,
+			},
+		})
+	})
+}
+
+func (client *simulatedClient) DeleteInstance(instanceID string, nodeID string) error {
+	if nodeID == "" {
+		glog.Info("Deleting unassigned instance")
+		client.notifier.RemoveInstance(instanceID)
+		return nil
+	}
+
+	client.delete(instanceID, nodeID, false, "")
+
+	return nil
+}
+
+func (client *simulatedClient) StopInstance(instanceID string, nodeID string) error {
+	client.delete(instanceID, nodeID, true, "")
+
+	return nil
+}
+
+func (client *simulatedClient) DeleteInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error {
+	if nodeID == "" {
+		return client.DeleteInstance(instanceID, nodeID)
+	}
+
+	commandID, done := client.notifier.acks.add(instanceID)
+	client.delete(instanceID, nodeID, false, commandID)
+
+	return client.notifier.acks.wait(ctx, commandID, done)
+}
+
+func (client *simulatedClient) StopInstanceAndWait(ctx context.Context, instanceID string, nodeID string) error {
+	commandID, done := client.notifier.acks.add(instanceID)
+	client.delete(instanceID, nodeID, true, commandID)
+
+	return client.notifier.acks.wait(ctx, commandID, done)
+}
+
+func (client *simulatedClient) RestartInstance(i *types.Instance, w *types.Workload, t *types.Tenant) error {
+	return client.restartInstance(i, w, t, uuid.Generate().String())
+}
+
+func (client *simulatedClient) RestartInstanceAndWait(ctx context.Context, i *types.Instance, w *types.Workload, t *types.Tenant) error {
+	commandID, done := client.notifier.acks.add(i.ID)
+	err := client.restartInstance(i, w, t, commandID)
+	if err != nil {
+		client.notifier.acks.cancel(commandID)
+		return err
+	}
+
+	return client.notifier.acks.wait(ctx, commandID, done)
+}
+
+func (client *simulatedClient) restartInstance(i *types.Instance, w *types.Workload, t *types.Tenant, commandID string) error {
+	err := client.notifier.ctl.ds.InstanceRestarting(i.ID)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to update instance state before restarting")
+	}
+
+	cmd := payloads.StartCmd{
+		TenantUUID:   i.TenantID,
+		InstanceUUID: i.ID,
+		Requirements: w.Requirements,
+		Networking: payloads.NetworkResources{
+			VnicMAC:  i.MACAddress,
+			VnicUUID: i.VnicUUID,
+		},
+		Restart:   true,
+		CommandID: commandID,
+	}
+	cmd.Requirements.VCPUs += i.ExtraVCPUs
+	cmd.Requirements.MemMB += i.ExtraMemMB
+
+	time.AfterFunc(simulatedLatency, func() { client.start(cmd) })
+
+	return nil
+}
+
+func (client *simulatedClient) EvacuateNode(nodeID string, commandID string) error {
+	time.AfterFunc(simulatedLatency, func() {
+		client.nodesLock.Lock()
+		n := client.findNode(nodeID)
+		if n == nil {
+			client.nodesLock.Unlock()
+			glog.Warningf("Unknown simulated node %s", nodeID)
+			client.sendEvent(ssntp.NodeCommandResult, payloads.EventNodeCommandResult{
+				NodeCommandResult: payloads.NodeCommandResultEvent{
+					CommandID: commandID,
+					NodeUUID:  nodeID,
+					Error:     "Unknown simulated node",
+				},
+			})
+			return
+		}
+
+		n.maintenance = true
+		evacuated := n.instances
+		n.instances = make(map[string]payloads.WorkloadRequirements)
+		stat := n.stats()
+		client.nodesLock.Unlock()
+
+		for id := range evacuated {
+			client.sendEvent(ssntp.InstanceStopped, payloads.EventInstanceStopped{
+				InstanceStopped: payloads.InstanceStoppedEvent{InstanceUUID: id},
+			})
+		}
+
+		client.sendStats(stat)
+		client.sendAck(nodeID, commandID)
+	})
+
+	return nil
+}
+
+func (client *simulatedClient) RestoreNode(nodeID string, commandID string) error {
+	client.nodesLock.Lock()
+	n := client.findNode(nodeID)
+	if n == nil {
+		client.nodesLock.Unlock()
+		return fmt.Errorf("Unknown simulated node %s", nodeID)
+	}
+
+	n.maintenance = false
+	client.nodesLock.Unlock()
+
+	client.sendAck(nodeID, commandID)
+
+	return nil
+}
+
+func (client *simulatedClient) cacheImage(nodeID string, image string) error {
+	client.nodesLock.Lock()
+	defer client.nodesLock.Unlock()
+
+	n := client.findNode(nodeID)
+	if n == nil {
+		return fmt.Errorf("Unknown simulated node %s", nodeID)
+	}
+
+	n.images[image] = true
+
+	return nil
+}
+
+func (client *simulatedClient) setNetworkPolicy(t types.Tenant, cnci *types.Instance) error {
+	glog.Infof("Simulated network policy %s for CNCI %s", t.NetworkPolicy.Mode, cnci.ID)
+	return nil
+}
+
+func (client *simulatedClient) attachVolume(volID string, instanceID string, nodeID string) error {
+	glog.Infof("Simulated AttachVolume %s to %s", volID, instanceID)
+	return nil
+}
+
+func (client *simulatedClient) hotAdd(instanceID string, nodeID string, vcpus int, memMB int) error {
+	time.AfterFunc(simulatedLatency, func() {
+		client.nodesLock.Lock()
+		var stat payloads.Stat
+		reason := payloads.HotAddFailureReason("")
+		n := client.findNode(nodeID)
+		if n == nil {
+			reason = payloads.HotAddNoInstance
+		} else if r, ok := n.instances[instanceID]; !ok {
+			reason = payloads.HotAddNoInstance
+		} else if n.freeMemMB() < memMB {
+			reason = payloads.HotAddHotAddFailure
+		} else {
+			r.VCPUs += vcpus
+			r.MemMB += memMB
+			n.instances[instanceID] = r
+			stat = n.stats()
+		}
+		client.nodesLock.Unlock()
+
+		if reason != "" {
+			client.sendError(ssntp.HotAddFailure, payloads.ErrorHotAddFailure{
+				NodeUUID:     nodeID,
+				InstanceUUID: instanceID,
+				VCPUs:        vcpus,
+				MemMB:        memMB,
+				Reason:       reason,
+			})
+			return
+		}
+
+		client.sendStats(stat)
+	})
+
+	return nil
+}
+
+// nodeCommandResult reports the result of a node command after the
+// simulated latency, failing it if the node is unknown.
+func (client *simulatedClient) nodeCommandResult(nodeID string, commandID string, checks []payloads.SelfTestCheck) {
+	time.AfterFunc(simulatedLatency, func() {
+		client.nodesLock.Lock()
+		n := client.findNode(nodeID)
+		client.nodesLock.Unlock()
+
+		result := payloads.NodeCommandResultEvent{
+			CommandID: commandID,
+			NodeUUID:  nodeID,
+			Checks:    checks,
+		}
+		if n == nil {
+			result.Error = "Unknown simulated node"
+			result.Checks = nil
+		}
+
+		client.sendEvent(ssntp.NodeCommandResult, payloads.EventNodeCommandResult{
+			NodeCommandResult: result,
+		})
+	})
+}
+
+func (client *simulatedClient) restartAgent(nodeID string, commandID string) error {
+	client.nodeCommandResult(nodeID, commandID, nil)
+	return nil
+}
+
+func (client *simulatedClient) selfTest(nodeID string, commandID string) error {
+	client.nodeCommandResult(nodeID, commandID, []payloads.SelfTestCheck{
+		{Name: "scheduler", Success: true},
+	})
+	return nil
+}
+
+func (client *simulatedClient) reconfigureNode(nodeID string, commandID string) error {
+	glog.Infof("Simulated Reconfigure of %s", nodeID)
+	return nil
+}
+
+func (client *simulatedClient) mapExternalIP(t types.Tenant, m types.MappedIP) error {
+	i, err := t.CNCIctrl.GetInstanceCNCI(m.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	event := payloads.EventPublicIPAssigned{
+		AssignedIP: payloads.PublicIPEvent{
+			ConcentratorUUID: i.ID,
+			InstanceUUID:     m.InstanceID,
+			PublicIP:         m.ExternalIP,
+			PrivateIP:        m.InternalIP,
+		},
+	}
+
+	time.AfterFunc(simulatedLatency, func() { client.sendEvent(ssntp.PublicIPAssigned, event) })
+
+	return nil
+}
+
+func (client *simulatedClient) unMapExternalIP(t types.Tenant, m types.MappedIP) error {
+	i, err := t.CNCIctrl.GetInstanceCNCI(m.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	event := payloads.EventPublicIPUnassigned{
+		UnassignedIP: payloads.PublicIPEvent{
+			ConcentratorUUID: i.ID,
+			InstanceUUID:     m.InstanceID,
+			PublicIP:         m.ExternalIP,
+			PrivateIP:        m.InternalIP,
+		},
+	}
+
+	time.AfterFunc(simulatedLatency, func() { client.sendEvent(ssntp.PublicIPUnassigned, event) })
+
+	return nil
+}
+
+func (client *simulatedClient) RemoveInstance(instanceID string) {
+	client.notifier.RemoveInstance(instanceID)
+}
+
+func (client *simulatedClient) Disconnect() {
+	close(client.stop)
+}
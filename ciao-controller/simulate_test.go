@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+func TestSimulatedNodes(t *testing.T) {
+	sim := &controller{ds: new(datastore.Datastore)}
+
+	err := sim.ds.Init(datastore.Config{
+		DBBackend:         &datastore.MemoryDB{},
+		InitWorkloadsPath: *workloadsPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.ds.Exit()
+
+	defer func(latency time.Duration) { simulatedLatency = latency }(simulatedLatency)
+	simulatedLatency = 0
+
+	client := newSimulatedClient(sim, 2)
+	defer client.Disconnect()
+
+	if len(sim.ds.GetNodeLastStats().Nodes) != 3 {
+		t.Fatalf("Expected stats for 3 nodes, got %v", sim.ds.GetNodeLastStats())
+	}
+
+	for _, n := range client.nodes {
+		node, err := sim.ds.GetNode(n.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var role ssntp.Role = ssntp.AGENT
+		if n.nodeType == payloads.NetworkNode {
+			role = ssntp.NETAGENT
+		}
+
+		if node.NodeRole != role || node.Hostname != n.hostname {
+			t.Fatalf("Unexpected node %v", node)
+		}
+	}
+
+	full := payloads.WorkloadRequirements{MemMB: simulatedMemMB}
+
+	client.nodesLock.Lock()
+	first := client.schedule(false, full)
+	first.instances["first"] = full
+	second := client.schedule(false, full)
+	if second != nil {
+		second.instances["second"] = full
+	}
+	overflow := client.schedule(false, full)
+	cnci := client.schedule(true, payloads.WorkloadRequirements{MemMB: 128})
+	client.nodesLock.Unlock()
+
+	if first == nil || second == nil || first == second {
+		t.Fatal("Instances not spread across compute nodes")
+	}
+
+	if overflow != nil {
+		t.Fatal("Instance scheduled on a full node")
+	}
+
+	if cnci == nil || cnci.nodeType != payloads.NetworkNode {
+		t.Fatal("CNCI not scheduled on the network node")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	commandID, done := client.notifier.acks.add(first.id)
+	err = client.EvacuateNode(first.id, commandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.notifier.acks.wait(ctx, commandID, done)
+	if err != nil {
+		t.Fatalf("Node evacuation not acknowledged: %v", err)
+	}
+
+	client.nodesLock.Lock()
+	evacuated := first.maintenance && len(first.instances) == 0
+	client.nodesLock.Unlock()
+
+	if !evacuated {
+		t.Fatal("Node not evacuated")
+	}
+
+	client.nodesLock.Lock()
+	n := client.schedule(false, full)
+	client.nodesLock.Unlock()
+
+	if n != nil {
+		t.Fatal("Instance scheduled on a node in maintenance")
+	}
+
+	commandID, done = client.notifier.acks.add(first.id)
+	err = client.RestoreNode(first.id, commandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.notifier.acks.wait(ctx, commandID, done)
+	if err != nil {
+		t.Fatalf("Node restore not acknowledged: %v", err)
+	}
+
+	client.nodesLock.Lock()
+	n = client.schedule(false, full)
+	client.nodesLock.Unlock()
+
+	if n != first {
+		t.Fatal("Instance not scheduled on the restored node")
+	}
+}
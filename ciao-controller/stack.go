@@ -0,0 +1,382 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+)
+
+var stackTimeout = flag.Duration("stack_timeout", 10*time.Minute, "time given to the instances of a stack group to be running when the stack does not set a timeout")
+
+// maxStackGroups is the largest number of groups of a stack.
+const maxStackGroups = 32
+
+// stackPollInterval is the interval at which the instances of a starting
+// group are checked.
+var stackPollInterval = 2 * time.Second
+
+// stackRetention is the time for which the status of a launched stack can
+// be retrieved.
+var stackRetention = 24 * time.Hour
+
+// stackDependencyCycle returns true if the dependencies of the groups of a
+// stack, which are known to exist, form a cycle.
+func stackDependencyCycle(groups []api.StackGroup) bool {
+	deps := make(map[string][]string)
+	for _, g := range groups {
+		deps[g.Name] = g.DependsOn
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch marks[name] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+
+		marks[name] = visiting
+		for _, dep := range deps[name] {
+			if visit(dep) {
+				return true
+			}
+		}
+		marks[name] = visited
+
+		return false
+	}
+
+	for _, g := range groups {
+		if visit(g.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *controller) validateStack(tenant string, req *api.CreateStackRequest) error {
+	if len(req.Groups) == 0 || len(req.Groups) > maxStackGroups || req.Timeout < 0 {
+		return types.ErrBadRequest
+	}
+
+	switch req.OnFailure {
+	case "":
+		req.OnFailure = types.StackAbort
+	case types.StackAbort, types.StackContinue:
+	default:
+		return types.ErrBadRequest
+	}
+
+	limit, err := c.instanceLimit(tenant)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for i := range req.Groups {
+		g := &req.Groups[i]
+		if g.Name == "" || names[g.Name] || g.Instances < 0 {
+			return types.ErrBadRequest
+		}
+		names[g.Name] = true
+
+		if g.Instances == 0 {
+			g.Instances = 1
+		}
+
+		if limit > 0 && g.Instances > limit {
+			return &types.InstanceLimitError{Requested: g.Instances, Limit: limit}
+		}
+
+		if !validInstanceNameTemplate(g.InstanceName, g.Instances) {
+			return types.ErrBadName
+		}
+
+		_, err := c.ShowWorkload(tenant, g.WorkloadID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, g := range req.Groups {
+		for _, dep := range g.DependsOn {
+			if !names[dep] || dep == g.Name {
+				return types.ErrBadRequest
+			}
+		}
+	}
+
+	if stackDependencyCycle(req.Groups) {
+		return types.ErrBadRequest
+	}
+
+	return nil
+}
+
+// CreateStack launches the groups of instances of a stack, each group
+// being launched once the instances of the groups it depends on are
+// running.  The stack is launched in the background and its progress is
+// reported by GetStack.
+func (c *controller) CreateStack(tenant string, req api.CreateStackRequest) (types.StackStatus, error) {
+	if c.tenantResubnetting(tenant) {
+		return types.StackStatus{}, types.ErrTenantResubnetting
+	}
+
+	err := c.validateStack(tenant, &req)
+	if err != nil {
+		return types.StackStatus{}, err
+	}
+
+	timeout := time.Duration(req.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = *stackTimeout
+	}
+
+	s := &types.StackStatus{
+		ID:        uuid.Generate().String(),
+		Name:      req.Name,
+		TenantID:  tenant,
+		State:     types.StackLaunching,
+		OnFailure: req.OnFailure,
+		Timeout:   int(timeout / time.Second),
+		Started:   time.Now(),
+	}
+
+	for _, g := range req.Groups {
+		s.Groups = append(s.Groups, types.StackGroupStatus{
+			Name:       g.Name,
+			WorkloadID: g.WorkloadID,
+			DependsOn:  g.DependsOn,
+			State:      types.StackGroupWaiting,
+			Instances:  []string{},
+		})
+	}
+
+	c.stackLock.Lock()
+	defer c.stackLock.Unlock()
+
+	if c.stacks == nil {
+		c.stacks = make(map[string]*types.StackStatus)
+	}
+
+	for ID, old := range c.stacks {
+		if old.State != types.StackLaunching && time.Since(old.Started) > stackRetention {
+			delete(c.stacks, ID)
+		}
+	}
+
+	c.stacks[s.ID] = s
+
+	go c.launchStack(s.ID, tenant, req, timeout)
+
+	return copyStack(s), nil
+}
+
+// GetStack returns the progress of the launch of a stack.
+func (c *controller) GetStack(tenant string, stackID string) (types.StackStatus, error) {
+	c.stackLock.Lock()
+	defer c.stackLock.Unlock()
+
+	s, ok := c.stacks[stackID]
+	if !ok || s.TenantID != tenant {
+		return types.StackStatus{}, types.ErrStackNotFound
+	}
+
+	return copyStack(s), nil
+}
+
+// ListStacks returns the recent stacks of a tenant, sorted by start time.
+func (c *controller) ListStacks(tenant string) ([]types.StackStatus, error) {
+	c.stackLock.Lock()
+	defer c.stackLock.Unlock()
+
+	stacks := []types.StackStatus{}
+	for _, s := range c.stacks {
+		if s.TenantID == tenant {
+			stacks = append(stacks, copyStack(s))
+		}
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		return stacks[i].Started.Before(stacks[j].Started)
+	})
+
+	return stacks, nil
+}
+
+func copyStack(s *types.StackStatus) types.StackStatus {
+	cs := *s
+	cs.Groups = make([]types.StackGroupStatus, len(s.Groups))
+	for i, g := range s.Groups {
+		cs.Groups[i] = g
+		cs.Groups[i].Instances = append([]string{}, g.Instances...)
+	}
+
+	return cs
+}
+
+// updateStackGroup applies update to the status of the group at index of
+// a stack.
+func (c *controller) updateStackGroup(stackID string, index int, update func(g *types.StackGroupStatus)) {
+	c.stackLock.Lock()
+	update(&c.stacks[stackID].Groups[index])
+	c.stackLock.Unlock()
+}
+
+// waitStackInstances waits for the instances of a group to be running.
+func (c *controller) waitStackInstances(instanceIDs []string, deadline time.Time) error {
+	for {
+		running := 0
+		for _, ID := range instanceIDs {
+			i, err := c.ds.GetInstance(ID)
+			if err != nil {
+				return fmt.Errorf("instance %s deleted", ID)
+			}
+
+			switch i.State {
+			case payloads.Running:
+				running++
+			case payloads.Pending:
+			default:
+				return fmt.Errorf("instance %s is %s", ID, i.State)
+			}
+		}
+
+		if running == len(instanceIDs) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d of %d instances not running after timeout",
+				len(instanceIDs)-running, len(instanceIDs))
+		}
+
+		time.Sleep(stackPollInterval)
+	}
+}
+
+// launchStackGroup launches the instances of a group and waits for them to
+// be running.
+func (c *controller) launchStackGroup(stackID string, index int, tenant string, g api.StackGroup, timeout time.Duration) error {
+	w := types.WorkloadRequest{
+		WorkloadID: g.WorkloadID,
+		TenantID:   tenant,
+		Instances:  g.Instances,
+		Name:       g.InstanceName,
+	}
+
+	instances, err := c.startWorkload(w)
+
+	var IDs []string
+	for _, i := range instances {
+		IDs = append(IDs, i.ID)
+	}
+
+	c.updateStackGroup(stackID, index, func(s *types.StackGroupStatus) {
+		s.State = types.StackGroupStarting
+		s.Instances = IDs
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return c.waitStackInstances(IDs, time.Now().Add(timeout))
+}
+
+func (c *controller) launchStack(stackID string, tenant string, req api.CreateStackRequest, timeout time.Duration) {
+	// done is closed when a group is running, has failed or was skipped
+	done := make(map[string]chan struct{})
+	failed := make(map[string]bool)
+	for _, g := range req.Groups {
+		done[g.Name] = make(chan struct{})
+	}
+
+	results := make(chan bool)
+
+	for i, g := range req.Groups {
+		go func(index int, g api.StackGroup) {
+			var failedDeps []string
+			for _, dep := range g.DependsOn {
+				<-done[dep]
+
+				c.stackLock.Lock()
+				if failed[dep] {
+					failedDeps = append(failedDeps, dep)
+				}
+				c.stackLock.Unlock()
+			}
+
+			var err error
+			state := types.StackGroupRunning
+			if len(failedDeps) > 0 && req.OnFailure == types.StackAbort {
+				state = types.StackGroupSkipped
+				err = fmt.Errorf("dependencies failed: %s", strings.Join(failedDeps, ", "))
+			} else {
+				err = c.launchStackGroup(stackID, index, tenant, g, timeout)
+				if err != nil {
+					state = types.StackGroupFailed
+				}
+			}
+
+			if err != nil {
+				glog.Warningf("Group %s of stack %s %s: %v", g.Name, stackID, state, err)
+				_ = c.ds.LogEvent(tenant, types.EventError, types.EventCategoryInstance,
+					fmt.Sprintf("Group %s of stack %s %s: %v", g.Name, req.Name, state, err))
+			}
+
+			// failed is also protected by the stack lock
+			c.updateStackGroup(stackID, index, func(s *types.StackGroupStatus) {
+				s.State = state
+				if err != nil {
+					s.Error = err.Error()
+					failed[g.Name] = true
+				}
+			})
+
+			close(done[g.Name])
+			results <- err == nil
+		}(i, g)
+	}
+
+	state := types.StackDone
+	for range req.Groups {
+		if !<-results {
+			state = types.StackFailed
+		}
+	}
+
+	c.stackLock.Lock()
+	c.stacks[stackID].State = state
+	c.stackLock.Unlock()
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/ciao-project/ciao/testutil"
+)
+
+func TestStackDependencyCycle(t *testing.T) {
+	groups := []api.StackGroup{
+		{Name: "db"},
+		{Name: "cache"},
+		{Name: "app", DependsOn: []string{"db", "cache"}},
+		{Name: "proxy", DependsOn: []string{"app"}},
+	}
+
+	if stackDependencyCycle(groups) {
+		t.Fatal("Cycle found in acyclic stack")
+	}
+
+	groups[0].DependsOn = []string{"proxy"}
+	if !stackDependencyCycle(groups) {
+		t.Fatal("Cycle not found")
+	}
+}
+
+func waitStackGroup(t *testing.T, tenantID string, stackID string, index int,
+	state types.StackGroupState) types.StackStatus {
+	for i := 0; i < 100; i++ {
+		s, err := ctl.GetStack(tenantID, stackID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.Groups[index].State == state {
+			return s
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("Group %d of stack %s not %s", index, stackID, state)
+	return types.StackStatus{}
+}
+
+func setStackInstancesState(t *testing.T, instanceIDs []string, state string) {
+	for _, ID := range instanceIDs {
+		i, err := ctl.ds.GetInstance(ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i.State = state
+	}
+}
+
+func TestCreateStack(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("CreateStack", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	saved := stackPollInterval
+	stackPollInterval = 10 * time.Millisecond
+	defer func() { stackPollInterval = saved }()
+
+	invalid := []api.CreateStackRequest{
+		{},
+		{Groups: []api.StackGroup{{Name: "db", WorkloadID: wls[0].ID, DependsOn: []string{"cache"}}}},
+		{Groups: []api.StackGroup{{Name: "db", WorkloadID: wls[0].ID, DependsOn: []string{"db"}}}},
+		{Groups: []api.StackGroup{{Name: "db", WorkloadID: wls[0].ID}, {Name: "db", WorkloadID: wls[0].ID}}},
+		{Groups: []api.StackGroup{{Name: "db", WorkloadID: wls[0].ID}}, OnFailure: "retry"},
+		{Groups: []api.StackGroup{{Name: "db", WorkloadID: wls[0].ID, InstanceName: "DB"}}},
+		{Groups: []api.StackGroup{{Name: "db", WorkloadID: "unknown"}}},
+	}
+
+	for _, req := range invalid {
+		_, err := ctl.CreateStack(tenant.ID, req)
+		if err == nil {
+			t.Errorf("Invalid stack %+v accepted", req)
+		}
+	}
+
+	req := api.CreateStackRequest{
+		Name: "web",
+		Groups: []api.StackGroup{
+			{Name: "app", WorkloadID: wls[0].ID, Instances: 2, InstanceName: "app-{index}", DependsOn: []string{"db"}},
+			{Name: "db", WorkloadID: wls[0].ID, InstanceName: "db"},
+		},
+		Timeout: 5,
+	}
+
+	s, err := ctl.CreateStack(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.OnFailure != types.StackAbort || s.Timeout != 5 || len(s.Groups) != 2 {
+		t.Fatalf("Unexpected stack %+v", s)
+	}
+
+	s = waitStackGroup(t, tenant.ID, s.ID, 1, types.StackGroupStarting)
+	if len(s.Groups[1].Instances) != 1 {
+		t.Fatalf("Expected one db instance, got %v", s.Groups[1].Instances)
+	}
+
+	// the application waits for its database
+	time.Sleep(5 * stackPollInterval)
+	s, err = ctl.GetStack(tenant.ID, s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Groups[0].State != types.StackGroupWaiting || len(s.Groups[0].Instances) != 0 {
+		t.Fatalf("Application launched before its database: %+v", s.Groups[0])
+	}
+
+	setStackInstancesState(t, s.Groups[1].Instances, payloads.Running)
+
+	s = waitStackGroup(t, tenant.ID, s.ID, 0, types.StackGroupStarting)
+	if len(s.Groups[0].Instances) != 2 || s.Groups[1].State != types.StackGroupRunning {
+		t.Fatalf("Unexpected stack %+v", s)
+	}
+
+	setStackInstancesState(t, s.Groups[0].Instances, payloads.Running)
+
+	s = waitStackGroup(t, tenant.ID, s.ID, 0, types.StackGroupRunning)
+	for i := 0; i < 100 && s.State == types.StackLaunching; i++ {
+		time.Sleep(10 * time.Millisecond)
+		s, _ = ctl.GetStack(tenant.ID, s.ID)
+	}
+	if s.State != types.StackDone {
+		t.Fatalf("Expected stack done, got %+v", s)
+	}
+
+	stacks, err := ctl.ListStacks(tenant.ID)
+	if err != nil || len(stacks) != 1 || stacks[0].ID != s.ID {
+		t.Fatalf("Unexpected stacks %+v", stacks)
+	}
+
+	_, err = ctl.GetStack("other", s.ID)
+	if err != types.ErrStackNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrStackNotFound, err)
+	}
+}
+
+func TestCreateStackFailure(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := testutil.NewSsntpTestClientConnection("CreateStackFailure", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No workloads for this tenant")
+	}
+
+	saved := stackPollInterval
+	stackPollInterval = 10 * time.Millisecond
+	defer func() { stackPollInterval = saved }()
+
+	req := api.CreateStackRequest{
+		Name: "web",
+		Groups: []api.StackGroup{
+			{Name: "db", WorkloadID: wls[0].ID},
+			{Name: "app", WorkloadID: wls[0].ID, DependsOn: []string{"db"}},
+			{Name: "proxy", WorkloadID: wls[0].ID, DependsOn: []string{"app"}},
+		},
+	}
+
+	s, err := ctl.CreateStack(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = waitStackGroup(t, tenant.ID, s.ID, 0, types.StackGroupStarting)
+	setStackInstancesState(t, s.Groups[0].Instances, payloads.Exited)
+
+	s = waitStackGroup(t, tenant.ID, s.ID, 2, types.StackGroupSkipped)
+	if s.Groups[0].State != types.StackGroupFailed || s.Groups[1].State != types.StackGroupSkipped ||
+		len(s.Groups[1].Instances) != 0 || s.Groups[1].Error == "" {
+		t.Fatalf("Unexpected stack %+v", s)
+	}
+
+	for i := 0; i < 100 && s.State == types.StackLaunching; i++ {
+		time.Sleep(10 * time.Millisecond)
+		s, _ = ctl.GetStack(tenant.ID, s.ID)
+	}
+	if s.State != types.StackFailed {
+		t.Fatalf("Expected stack failed, got %+v", s)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// imagePool is the ceph pool holding the images, from which the volumes
+// created from images are cloned whatever their pool.
+const imagePool = "rbd"
+
+// storagePool is a named storage pool, backed by a ceph pool.
+type storagePool struct {
+	name     string
+	cephPool string
+	driver   storage.BlockDriver
+}
+
+// initStoragePools sets up the storage pools of the cluster configuration,
+// newDriver returning the block driver of a ceph pool.  Without pools,
+// volumes are created by the default block driver of the controller.
+func (c *controller) initStoragePools(conf payloads.ConfigureStorage, newDriver func(cephPool string) storage.BlockDriver) error {
+	pools := make(map[string]*storagePool, len(conf.Pools))
+
+	for _, p := range conf.Pools {
+		if p.Name == "" || p.CephPool == "" {
+			return fmt.Errorf("Storage pools must have a name and a ceph pool")
+		}
+
+		if _, ok := pools[p.Name]; ok {
+			return fmt.Errorf("Duplicate storage pool %s", p.Name)
+		}
+
+		pools[p.Name] = &storagePool{
+			name:     p.Name,
+			cephPool: p.CephPool,
+			driver:   newDriver(p.CephPool),
+		}
+	}
+
+	defaultPool := conf.DefaultPool
+	if defaultPool == "" && len(conf.Pools) > 0 {
+		defaultPool = conf.Pools[0].Name
+	}
+
+	if defaultPool != "" {
+		if _, ok := pools[defaultPool]; !ok {
+			return fmt.Errorf("Default storage pool %s is not configured", defaultPool)
+		}
+	}
+
+	c.storagePools = pools
+	c.defaultPool = defaultPool
+
+	return nil
+}
+
+// storagePool returns the storage pool in which to create a volume, the
+// default pool when name is empty.
+func (c *controller) storagePool(name string) (*storagePool, error) {
+	if name == "" {
+		name = c.defaultPool
+	}
+
+	if name == "" {
+		return &storagePool{driver: c.BlockDriver}, nil
+	}
+
+	p, ok := c.storagePools[name]
+	if !ok {
+		return nil, types.ErrStoragePoolNotFound
+	}
+
+	return p, nil
+}
+
+// volumeDriver returns the block driver of the pool holding a volume.
+// Volumes without pool were created before pools were configured and
+// live in the default ceph pool.
+func (c *controller) volumeDriver(pool string) storage.BlockDriver {
+	if pool == "" {
+		return c.BlockDriver
+	}
+
+	p, ok := c.storagePools[pool]
+	if !ok {
+		glog.Warningf("Unknown storage pool %s, using default driver", pool)
+		return c.BlockDriver
+	}
+
+	return p.driver
+}
+
+// cephPool returns the ceph pool holding the volumes of a storage pool,
+// as sent to the launchers.  It is empty for the default ceph pool.
+func (c *controller) cephPool(pool string) string {
+	p, ok := c.storagePools[pool]
+	if !ok {
+		return ""
+	}
+
+	return p.cephPool
+}
+
+// sameCephPool returns true if two ceph pools, as returned by cephPool,
+// are the same pool.
+func sameCephPool(a string, b string) bool {
+	if a == "" {
+		a = imagePool
+	}
+	if b == "" {
+		b = imagePool
+	}
+	return a == b
+}
+
+// volumeStoragePools returns the ceph pools holding the volumes, which
+// must be reachable from the node running an instance using them.
+func (c *controller) volumeStoragePools(volumes []payloads.StorageResource) []string {
+	var pools []string
+
+	seen := make(map[string]bool)
+	for _, v := range volumes {
+		if v.Pool == "" || seen[v.Pool] {
+			continue
+		}
+		seen[v.Pool] = true
+		pools = append(pools, v.Pool)
+	}
+
+	return pools
+}
+
+// ListStoragePools returns the storage pools along with their capacity, as
+// reported by their storage driver.
+func (c *controller) ListStoragePools() (types.StoragePoolsResponse, error) {
+	var resp types.StoragePoolsResponse
+
+	names := make([]string, 0, len(c.storagePools))
+	for name := range c.storagePools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pools := make([]*storagePool, 0, len(names))
+	for _, name := range names {
+		pools = append(pools, c.storagePools[name])
+	}
+
+	// the default ceph pool when no pools are configured
+	if len(pools) == 0 {
+		pools = append(pools, &storagePool{cephPool: imagePool, driver: c.BlockDriver})
+	}
+
+	for _, p := range pools {
+		sp := types.StoragePool{
+			Name:     p.name,
+			CephPool: p.cephPool,
+			Default:  p.name == c.defaultPool,
+		}
+
+		if d, ok := p.driver.(storage.PoolDriver); ok {
+			capacity, err := d.Capacity()
+			if err != nil {
+				sp.Error = err.Error()
+			}
+			sp.Capacity = capacity
+		}
+
+		resp.Pools = append(resp.Pools, sp)
+	}
+
+	return resp, nil
+}
@@ -16,9 +16,13 @@ package main
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 
+	"github.com/ciao-project/ciao/ciao-controller/internal/datastore"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
@@ -66,9 +70,153 @@ func (c *controller) ShowTenant(tenantID string) (types.TenantConfig, error) {
 	return tenant.TenantConfig, err
 }
 
-func (c *controller) PatchTenant(tenantID string, patch []byte) error {
+func (c *controller) ShowTenantSummary(tenantID string) (types.TenantResourceSummary, error) {
+	return c.ds.GetTenantResourceSummary(tenantID)
+}
+
+func (c *controller) ListTenantSubnets(tenantID string) ([]types.TenantSubnet, error) {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant == nil {
+		return nil, types.ErrTenantNotFound
+	}
+
+	if tenant.CNCIctrl == nil {
+		return []types.TenantSubnet{}, nil
+	}
+
+	return tenant.CNCIctrl.Subnets(), nil
+}
+
+func (c *controller) ListTenantIPs(tenantID string) ([]types.TenantIP, error) {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant == nil {
+		return nil, types.ErrTenantNotFound
+	}
+
+	return c.ds.GetTenantIPs(tenantID)
+}
+
+// ReleaseTenantIPs force releases the claims on IP addresses of a tenant
+// that are not held by any of its instances, so that leaked addresses can
+// be reused without editing the database by hand.
+func (c *controller) ReleaseTenantIPs(tenantID string, addresses []string) ([]string, error) {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant == nil {
+		return nil, types.ErrTenantNotFound
+	}
+
+	released, err := c.ds.ReleaseOrphanedTenantIPs(tenantID, addresses)
+	if len(released) > 0 {
+		msg := fmt.Sprintf("Released orphaned tenant IPs: %s", strings.Join(released, ", "))
+		_ = c.ds.LogEvent(tenantID, types.EventInfo, types.EventCategoryNetwork, msg)
+	}
+
+	return released, err
+}
+
+func (c *controller) ListVNIs() ([]types.VNIAllocation, error) {
+	return c.ds.GetVNIs(), nil
+}
+
+func (c *controller) PatchTenant(tenantID string, patch []byte, format types.PatchFormat) error {
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	if tenant == nil {
+		return types.ErrTenantNotFound
+	}
+
+	oldProfile := tenant.QuotaProfile
+	oldPolicy := tenant.NetworkPolicy
+
 	// we need to update through datastore.
-	return c.ds.JSONPatchTenant(tenantID, patch)
+	err = c.ds.PatchTenant(tenantID, patch, format)
+	if err != nil {
+		return err
+	}
+
+	tenant, err = c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(tenant.NetworkPolicy, oldPolicy) {
+		err = c.programNetworkPolicy(tenant)
+		if err != nil {
+			return errors.Wrap(err, "error programming network policy")
+		}
+	}
+
+	if tenant.QuotaProfile == "" || tenant.QuotaProfile == oldProfile {
+		return nil
+	}
+
+	return errors.Wrap(c.applyQuotaProfile(tenantID, tenant.QuotaProfile), "error applying quota profile")
+}
+
+// programNetworkPolicy sends the network policy of the tenant to each of
+// its active CNCIs. The CNCIs activated later are sent the policy once
+// they are added.
+func (c *controller) programNetworkPolicy(t *types.Tenant) error {
+	if t.CNCIctrl == nil {
+		return nil
+	}
+
+	for _, s := range t.CNCIctrl.Subnets() {
+		if s.State != types.SubnetActive {
+			continue
+		}
+
+		i, err := c.ds.GetInstance(s.CNCIID)
+		if err != nil {
+			return err
+		}
+
+		err = c.client.setNetworkPolicy(*t, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// networkPolicyCommand converts the network policy of a tenant into the
+// command programming it into one of its CNCIs.
+func networkPolicyCommand(t types.Tenant, cnci *types.Instance) payloads.NetworkPolicyCommand {
+	cmd := payloads.NetworkPolicyCommand{
+		ConcentratorUUID: cnci.ID,
+		TenantUUID:       t.ID,
+		TenantNetwork:    datastore.TenantNetwork,
+		AllowAll:         t.NetworkPolicy.AllowAll(),
+	}
+
+	if t.NetworkPolicy.Mode != types.NetworkRules {
+		return cmd
+	}
+
+	for _, r := range t.NetworkPolicy.Rules {
+		cmd.Rules = append(cmd.Rules, payloads.NetworkPolicyRule{
+			Source:      r.Source,
+			Destination: r.Destination,
+		})
+	}
+
+	return cmd
 }
 
 func (c *controller) CreateTenant(tenantID string, config types.TenantConfig) (types.TenantSummary, error) {
@@ -97,6 +245,13 @@ func (c *controller) CreateTenant(tenantID string, config types.TenantConfig) (t
 		return types.TenantSummary{}, err
 	}
 
+	if config.QuotaProfile != "" {
+		err = c.applyQuotaProfile(tenant.ID, config.QuotaProfile)
+		if err != nil {
+			return types.TenantSummary{}, errors.Wrap(err, "error applying quota profile")
+		}
+	}
+
 	ts := types.TenantSummary{
 		ID:   tenant.ID,
 		Name: tenant.Name,
@@ -185,13 +340,46 @@ func (c *controller) deleteInstances(tenantID string) error {
 	return nil
 }
 
+// checkTenantProtection refuses the deletion of a tenant which owns
+// instances or volumes protected against deletion.
+func (c *controller) checkTenantProtection(tenantID string) error {
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Unable to remove tenant")
+	}
+
+	for _, i := range instances {
+		if i.Protected {
+			return types.ErrInstanceProtected
+		}
+	}
+
+	bds, err := c.ds.GetBlockDevices(tenantID)
+	if err != nil {
+		return errors.Wrap(err, "Unable to remove tenant")
+	}
+
+	for _, bd := range bds {
+		if bd.Protected {
+			return types.ErrVolumeProtected
+		}
+	}
+
+	return nil
+}
+
 // DeleteTenant will remove any object associated with this tenant.
 // at this point we can assume the admin has already
 // revoked the tenant's certificate. So no more
 // activity can happen for this tenant while this
 // command is going.
 func (c *controller) DeleteTenant(tenantID string) error {
-	err := c.deleteInstances(tenantID)
+	err := c.checkTenantProtection(tenantID)
+	if err != nil {
+		return err
+	}
+
+	err = c.deleteInstances(tenantID)
 	if err != nil {
 		return err
 	}
@@ -214,30 +402,36 @@ func (c *controller) DeleteTenant(tenantID string) error {
 		}
 	}
 
-	// remove any images for this tenant.
-	images, err := c.ds.GetImages(tenantID, false)
+	// remove any storage for this tenant, before the images from
+	// which volumes may have been cloned.
+	bds, err := c.ds.GetBlockDevices(tenantID)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "Unable to remove tenant")
 	}
 
-	for _, i := range images {
-		if i.Visibility == types.Public {
-			continue
+	for _, bd := range bds {
+		err := c.volumeDriver(bd.Pool).DeleteBlockDevice(bd.ID)
+		if err != nil {
+			return errors.Wrap(err, "Unable to remove tenant")
 		}
-		err := c.DeleteImage(tenantID, i.ID)
+
+		err = c.ds.DeleteBlockDevice(bd.ID)
 		if err != nil {
 			return errors.Wrap(err, "Unable to remove tenant")
 		}
 	}
 
-	// remove any storage for this tenant.
-	bds, err := c.ds.GetBlockDevices(tenantID)
+	// remove any images for this tenant.
+	images, err := c.ds.GetImages(tenantID, false)
 	if err != nil {
-		return errors.Wrap(err, "Unable to remove tenant")
+		return err
 	}
 
-	for _, bd := range bds {
-		err := c.DeleteBlockDevice(bd.ID)
+	for _, i := range images {
+		if i.Visibility == types.Public {
+			continue
+		}
+		err := c.DeleteImage(tenantID, i.ID)
 		if err != nil {
 			return errors.Wrap(err, "Unable to remove tenant")
 		}
@@ -0,0 +1,392 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// transferUnmapTimeout is how long a transfer waits for the CNCI of the
+// former tenant to release the external IPs of the instance.
+var transferUnmapTimeout = 2 * time.Minute
+
+// instanceTransfer holds what a transfer needs to move an instance and
+// to undo what it did if the move does not happen.
+type instanceTransfer struct {
+	instanceID string
+	from       string
+	to         string
+	running    bool
+	mappings   []types.MappedIP
+	resources  []payloads.RequestedResource
+}
+
+// TransferInstance moves an instance, along with its volumes and the
+// external IPs mapped to it, to another tenant.  The quotas of the new
+// tenant are charged before anything is changed.  The external IPs are
+// unmapped and the instance stopped before it is given an address in the
+// network of its new tenant, after which it is restarted and its external
+// IPs mapped again.  The transfer proceeds in the background and its
+// progress is reported by GetInstanceTransfer.
+func (c *controller) TransferInstance(instanceID string, tenantID string) (types.InstanceTransferStatus, error) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return types.InstanceTransferStatus{}, err
+	}
+
+	if i.CNCI || i.TenantID == tenantID {
+		return types.InstanceTransferStatus{}, types.ErrBadRequest
+	}
+
+	i.StateLock.RLock()
+	state := i.State
+	i.StateLock.RUnlock()
+
+	if state != payloads.Running && state != payloads.Exited {
+		return types.InstanceTransferStatus{}, types.ErrBadRequest
+	}
+
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return types.InstanceTransferStatus{}, err
+	}
+	if t == nil {
+		return types.InstanceTransferStatus{}, types.ErrTenantNotFound
+	}
+
+	if c.tenantResubnetting(i.TenantID) || c.tenantResubnetting(tenantID) {
+		return types.InstanceTransferStatus{}, types.ErrTenantResubnetting
+	}
+
+	if i.Name != "" {
+		ID, err := c.ds.ResolveInstance(tenantID, i.Name)
+		if err != nil {
+			return types.InstanceTransferStatus{}, err
+		}
+		if ID != "" {
+			return types.InstanceTransferStatus{}, types.ErrInstanceNameInUse
+		}
+	}
+
+	tr, volumes, err := c.prepareTransfer(i, tenantID, state == payloads.Running)
+	if err != nil {
+		return types.InstanceTransferStatus{}, err
+	}
+
+	c.transferLock.Lock()
+	defer c.transferLock.Unlock()
+
+	if c.transfers == nil {
+		c.transfers = make(map[string]*types.InstanceTransferStatus)
+	}
+
+	s, ok := c.transfers[instanceID]
+	if ok && transferInProgress(s) {
+		return *s, types.ErrInstanceTransferring
+	}
+
+	res := <-c.qs.Consume(tenantID, tr.resources...)
+	if !res.Allowed() {
+		return types.InstanceTransferStatus{}, types.ErrQuota
+	}
+
+	s = &types.InstanceTransferStatus{
+		InstanceID:   instanceID,
+		FromTenantID: tr.from,
+		ToTenantID:   tenantID,
+		State:        types.InstanceTransferUnmapping,
+		Volumes:      volumes,
+		Started:      time.Now(),
+	}
+	for _, m := range tr.mappings {
+		s.ExternalIPs = append(s.ExternalIPs, m.ExternalIP)
+	}
+	c.transfers[instanceID] = s
+
+	go c.transferInstance(tr)
+
+	return *s, nil
+}
+
+// prepareTransfer collects the external IPs mapped to the instance and
+// the resources the new tenant is charged for the instance, its volumes
+// and these external IPs.
+func (c *controller) prepareTransfer(i *types.Instance, tenantID string, running bool) (*instanceTransfer, []string, error) {
+	wl, err := c.ds.GetWorkload(i.WorkloadID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var volumes []string
+	var count, size int
+	for _, a := range c.ds.GetStorageAttachments(i.ID) {
+		bd, err := c.ds.GetBlockDevice(a.BlockID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		volumes = append(volumes, bd.ID)
+		if bd.Internal {
+			continue
+		}
+		size += bd.Size
+		count++
+	}
+
+	tr := &instanceTransfer{
+		instanceID: i.ID,
+		from:       i.TenantID,
+		to:         tenantID,
+		running:    running,
+	}
+
+	for _, m := range c.ds.GetMappedIPs(&i.TenantID) {
+		if m.InstanceID == i.ID {
+			tr.mappings = append(tr.mappings, m)
+		}
+	}
+
+	tr.resources = []payloads.RequestedResource{
+		{Type: payloads.Instance, Value: 1},
+		{Type: payloads.MemMB, Value: wl.Requirements.MemMB + i.ExtraMemMB},
+		{Type: payloads.VCPUs, Value: wl.Requirements.VCPUs + i.ExtraVCPUs},
+		{Type: payloads.Volume, Value: count},
+		{Type: payloads.SharedDiskGiB, Value: size},
+		{Type: payloads.ExternalIP, Value: len(tr.mappings)},
+	}
+
+	return tr, volumes, nil
+}
+
+// GetInstanceTransfer returns the progress of the last transfer of the
+// instance to another tenant.
+func (c *controller) GetInstanceTransfer(instanceID string) (types.InstanceTransferStatus, error) {
+	c.transferLock.Lock()
+	defer c.transferLock.Unlock()
+
+	s, ok := c.transfers[instanceID]
+	if !ok {
+		return types.InstanceTransferStatus{}, types.ErrNoInstanceTransfer
+	}
+
+	return *s, nil
+}
+
+func transferInProgress(s *types.InstanceTransferStatus) bool {
+	return s.State != types.InstanceTransferDone && s.State != types.InstanceTransferFailed
+}
+
+func (c *controller) setTransferState(instanceID string, state types.InstanceTransferState) {
+	c.transferLock.Lock()
+	c.transfers[instanceID].State = state
+	c.transferLock.Unlock()
+}
+
+func (c *controller) transferInstance(tr *instanceTransfer) {
+	err := c.doTransferInstance(tr)
+
+	c.transferLock.Lock()
+	defer c.transferLock.Unlock()
+
+	s := c.transfers[tr.instanceID]
+	if err, ok := err.(movedError); ok {
+		msg := fmt.Sprintf("Instance %s transferred to tenant %s incompletely: %v", tr.instanceID, tr.to, err)
+		glog.Warning(msg)
+		_ = c.ds.LogEvent(tr.to, types.EventWarning, types.EventCategoryInstance, msg)
+		s.State = types.InstanceTransferDone
+		s.Error = err.Error()
+		return
+	}
+
+	if err != nil {
+		msg := fmt.Sprintf("Error transferring instance %s to tenant %s: %v", tr.instanceID, tr.to, err)
+		glog.Warning(msg)
+		_ = c.ds.LogEvent(tr.from, types.EventError, types.EventCategoryInstance, msg)
+		s.State = types.InstanceTransferFailed
+		s.Error = err.Error()
+		return
+	}
+
+	glog.Infof("Instance %s transferred from tenant %s to %s", tr.instanceID, tr.from, tr.to)
+	s.State = types.InstanceTransferDone
+}
+
+func (c *controller) doTransferInstance(tr *instanceTransfer) error {
+	var unmapped []types.MappedIP
+	stopped := false
+
+	// until the instance moved, a failure leaves it where it was.
+	abort := func(err error) error {
+		c.qs.Release(tr.to, tr.resources...)
+		c.remapTransferAddresses(tr.from, unmapped)
+		if stopped {
+			if rerr := c.restartInstanceSync(tr.instanceID); rerr != nil {
+				glog.Warningf("Error restarting instance %s: %v", tr.instanceID, rerr)
+			}
+		}
+		return err
+	}
+
+	for _, m := range tr.mappings {
+		err := c.unmapTransferAddress(m)
+		if err != nil {
+			return abort(err)
+		}
+		unmapped = append(unmapped, m)
+	}
+
+	c.setTransferState(tr.instanceID, types.InstanceTransferStopping)
+
+	if tr.running {
+		err := c.stopInstanceSync(tr.instanceID)
+		if err != nil {
+			return abort(err)
+		}
+		stopped = true
+	}
+
+	c.setTransferState(tr.instanceID, types.InstanceTransferMoving)
+
+	ip, err := c.ds.AllocateTenantIP(tr.to)
+	if err != nil {
+		return abort(errors.Wrap(err, "Error allocating tenant IP"))
+	}
+
+	err = c.ds.TransferInstance(tr.instanceID, tr.to, ip)
+	if err != nil {
+		if rerr := c.ds.ReleaseTenantIP(tr.to, ip.String()); rerr != nil {
+			glog.Warningf("Error releasing tenant IP %s: %v", ip, rerr)
+		}
+		return abort(errors.Wrap(err, "Error moving instance"))
+	}
+
+	// the external IPs, last of the resources, were released from the
+	// quotas of the former tenant as they were unmapped.
+	c.qs.Release(tr.from, tr.resources[:len(tr.resources)-1]...)
+
+	c.transferLock.Lock()
+	c.transfers[tr.instanceID].IPAddress = ip.String()
+	c.transfers[tr.instanceID].State = types.InstanceTransferRestarting
+	c.transferLock.Unlock()
+
+	msg := fmt.Sprintf("Instance %s transferred to tenant %s", tr.instanceID, tr.to)
+	_ = c.ds.LogEvent(tr.from, types.EventInfo, types.EventCategoryInstance, msg)
+	msg = fmt.Sprintf("Instance %s transferred from tenant %s", tr.instanceID, tr.from)
+	_ = c.ds.LogEvent(tr.to, types.EventInfo, types.EventCategoryInstance, msg)
+
+	var restartErr error
+	if stopped {
+		restartErr = c.restartInstanceSync(tr.instanceID)
+		if restartErr != nil {
+			restartErr = errors.Wrapf(restartErr, "Error restarting instance %s", tr.instanceID)
+		}
+	}
+
+	// the new tenant was charged for these external IPs up front, they
+	// are mapped again even if the instance did not restart.
+	err = c.mapTransferAddresses(tr.to, tr.instanceID, unmapped)
+	if restartErr != nil {
+		if err != nil {
+			glog.Warningf("Error mapping external IPs of instance %s: %v", tr.instanceID, err)
+		}
+		return movedError{restartErr}
+	}
+	if err != nil {
+		return movedError{err}
+	}
+
+	return nil
+}
+
+// movedError is returned by doTransferInstance when the instance was moved
+// to the new tenant but could not be restarted or have its external IPs
+// mapped again.  The transfer is done all the same.
+type movedError struct {
+	error
+}
+
+// unmapTransferAddress unmaps an external IP and waits for the CNCI to
+// release it.
+func (c *controller) unmapTransferAddress(m types.MappedIP) error {
+	err := c.UnMapAddress(m.ExternalIP)
+	if err != nil {
+		return errors.Wrapf(err, "Error unmapping %s", m.ExternalIP)
+	}
+
+	deadline := time.Now().Add(transferUnmapTimeout)
+	for {
+		_, err = c.ds.GetMappedIP(m.ExternalIP)
+		if err == types.ErrAddressNotFound {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for %s to be unmapped", m.ExternalIP)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// mapTransferAddresses maps the external IPs unmapped by a transfer to
+// the instance again, in the network of tenantID.
+func (c *controller) mapTransferAddresses(tenantID string, instanceID string, mappings []types.MappedIP) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	for n, m := range mappings {
+		mapped, err := c.ds.MapExternalIPAddress(m.PoolID, m.ExternalIP, instanceID)
+		if err != nil {
+			c.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: len(mappings) - n})
+			return errors.Wrapf(err, "Error mapping %s", m.ExternalIP)
+		}
+
+		// the release of the mapping returns its quota.
+		err = c.client.mapExternalIP(*t, mapped)
+		if err != nil {
+			_ = c.UnMapAddress(mapped.ExternalIP)
+			c.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: len(mappings) - n - 1})
+			return errors.Wrapf(err, "Error mapping %s", m.ExternalIP)
+		}
+	}
+
+	return nil
+}
+
+// remapTransferAddresses maps the external IPs unmapped by a transfer
+// which did not happen back to the instance in its former tenant, whose
+// quota was released as they were unmapped.
+func (c *controller) remapTransferAddresses(tenantID string, mappings []types.MappedIP) {
+	for _, m := range mappings {
+		c.qs.Charge(tenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+
+		err := c.mapTransferAddresses(tenantID, m.InstanceID, []types.MappedIP{m})
+		if err != nil {
+			glog.Warningf("Error mapping %s back to instance %s: %v", m.ExternalIP, m.InstanceID, err)
+		}
+	}
+}
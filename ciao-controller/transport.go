@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+)
+
+// transport delivers the commands of the controller to the agents, and
+// reports the events, errors and status updates of the agents back to the
+// controller.  The commands, events and their payloads are the same for
+// every transport.
+type transport interface {
+	Dial(notifier ssntp.ClientNotifier) error
+	SendCommand(cmd ssntp.Command, payload []byte) error
+	SendTracedCommand(cmd ssntp.Command, payload []byte, trace *ssntp.TraceConfig) error
+	ClusterConfiguration() (payloads.Configure, error)
+	Close()
+}
+
+// transportConfig describes how to reach the cluster and authenticate to
+// it, whichever the transport.
+type transportConfig struct {
+	URI    string
+	CACert string
+	Cert   string
+}
+
+// transports lists the transports a deployment can select with the
+// -transport flag.
+var transports = map[string]func(config transportConfig) transport{
+	"ssntp": newSSNTPTransport,
+}
+
+// newTransport returns the transport called name.
+func newTransport(name string, config transportConfig) (transport, error) {
+	newTransport, ok := transports[name]
+	if !ok {
+		names := make([]string, 0, len(transports))
+		for n := range transports {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("Unsupported transport %q, supported transports: %s",
+			name, strings.Join(names, ", "))
+	}
+
+	return newTransport(config), nil
+}
+
+// ssntpTransport exchanges commands and events with the agents through the
+// SSNTP server.
+type ssntpTransport struct {
+	config ssntp.Config
+	client ssntp.Client
+}
+
+func newSSNTPTransport(config transportConfig) transport {
+	return &ssntpTransport{
+		config: ssntp.Config{
+			URI:    config.URI,
+			CAcert: config.CACert,
+			Cert:   config.Cert,
+			Log:    ssntp.Log,
+		},
+	}
+}
+
+func (t *ssntpTransport) Dial(notifier ssntp.ClientNotifier) error {
+	return t.client.Dial(&t.config, notifier)
+}
+
+func (t *ssntpTransport) SendCommand(cmd ssntp.Command, payload []byte) error {
+	_, err := t.client.SendCommand(cmd, payload)
+	return err
+}
+
+func (t *ssntpTransport) SendTracedCommand(cmd ssntp.Command, payload []byte, trace *ssntp.TraceConfig) error {
+	_, err := t.client.SendTracedCommand(cmd, payload, trace)
+	return err
+}
+
+func (t *ssntpTransport) ClusterConfiguration() (payloads.Configure, error) {
+	return t.client.ClusterConfiguration()
+}
+
+func (t *ssntpTransport) Close() {
+	t.client.Close()
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestNewTransport(t *testing.T) {
+	tr, err := newTransport("ssntp", transportConfig{URI: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := tr.(*ssntpTransport); !ok {
+		t.Fatalf("Expected an SSNTP transport, got %T", tr)
+	}
+
+	if _, err := newTransport("carrier-pigeon", transportConfig{}); err == nil {
+		t.Fatal("Unknown transport accepted")
+	}
+}
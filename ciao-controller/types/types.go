@@ -19,6 +19,9 @@ package types
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/mail"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,6 +31,7 @@ import (
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
 	"github.com/golang/glog"
+	"golang.org/x/crypto/ssh"
 )
 
 // SourceType contains the valid values of the storage source.
@@ -53,12 +57,14 @@ type StorageResource struct {
 	// Bootable indicates whether should the resource be used for booting
 	Bootable bool `json:"bootable"`
 
-	// Ephemeral indicates whether the storage is temporary
-	// TBD: do we bother to save info about temp storage?
-	//      does it count against quota?
+	// Ephemeral indicates whether the storage is temporary, the volume
+	// created for an instance being deleted along with the instance.
+	// Existing volumes cannot be ephemeral.
 	Ephemeral bool `json:"ephemeral"`
 
-	// Size is the size of the storage to be created if new.
+	// Size is the size in GiB of the storage to be created if new. It is
+	// required for empty volumes and grows volumes created from a source
+	// if larger than the source.
 	Size int `json:"size"`
 
 	// ImageType indicates whether we are making a new resource
@@ -75,6 +81,14 @@ type StorageResource struct {
 
 	// Internal indicates whether this storage should be shown to the user
 	Internal bool
+
+	// Pool is the storage pool in which the storage is created if new,
+	// the default pool when empty.
+	Pool string `json:"pool,omitempty"`
+
+	// Clone indicates whether storage created from an image is a copy on
+	// write clone of the image rather than a full copy.
+	Clone bool `json:"clone,omitempty"`
 }
 
 // Workload contains resource and configuration information for a user
@@ -90,6 +104,55 @@ type Workload struct {
 	Storage      []StorageResource             `json:"storage"`
 	Visibility   Visibility                    `json:"visibility"`
 	Requirements payloads.WorkloadRequirements `json:"workload_requirements"`
+
+	// StaticNetwork requests the launcher to inject the network
+	// configuration of the instances in their config drive, for images
+	// which use neither cloud-init nor DHCP.  Only used for VMs.
+	StaticNetwork bool `json:"static_network,omitempty"`
+
+	// Devices selects the device models of the instances, for guests
+	// which do not support the default virtio devices.  Only used for
+	// VMs.
+	Devices payloads.DeviceModels `json:"devices"`
+
+	// HealthCheck is the probe checking the health of the instances.
+	// Nil if the health of the instances is not checked.
+	HealthCheck *payloads.HealthCheck `json:"health_check,omitempty"`
+
+	// Lifetime limits the age of the instances.  Nil if the instances
+	// may run for ever.
+	Lifetime *LifetimePolicy `json:"lifetime,omitempty"`
+}
+
+// LifetimeAction is what the controller does with the instances which
+// outlive the lifetime policy of their workload.
+type LifetimeAction string
+
+const (
+	// LifetimeNotify only logs the expiry of the instances in the event
+	// log of their tenant.
+	LifetimeNotify LifetimeAction = "notify"
+
+	// LifetimeRecycle also deletes the expired instances and launches
+	// replacements with the same name, which boot from the current
+	// image of the workload.
+	LifetimeRecycle LifetimeAction = "recycle"
+)
+
+// LifetimePolicy bounds the age of the instances of a workload, so that
+// long running instances are refreshed, e.g., to pick up patched images.
+type LifetimePolicy struct {
+	// MaxAgeDays is the age, in days, past which instances expire.
+	MaxAgeDays int `json:"max_age_days"`
+
+	// Action is applied to the expired instances, LifetimeNotify if
+	// empty.
+	Action LifetimeAction `json:"action,omitempty"`
+}
+
+// MaxAge returns the age past which instances expire.
+func (p LifetimePolicy) MaxAge() time.Duration {
+	return time.Duration(p.MaxAgeDays) * 24 * time.Hour
 }
 
 // WorkloadResponse will be returned from /workloads apis
@@ -108,6 +171,39 @@ type WorkloadRequest struct {
 	TraceLabel string
 	Name       string
 	Subnet     string
+	Warm       bool
+}
+
+// Placeholders recognised in the name of a workload request. They let
+// the instances of a multi-instance launch be named after a template.
+const (
+	// InstanceIndex is replaced by the position, starting at 1, of the
+	// instance in the launch.
+	InstanceIndex = "{index}"
+
+	// InstanceShortID is replaced by the first eight characters of the
+	// UUID of the instance.
+	InstanceShortID = "{id}"
+)
+
+// LaunchPreviewRequest describes the launch of a workload to preview.
+// TenantID is only used by admins, tenants preview their own launches.
+type LaunchPreviewRequest struct {
+	TenantID string `json:"tenant_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// LaunchPreview contains the configuration the launcher would receive for
+// an instance of a workload, with the values of secret cloud-init keys
+// masked.  The instance ID, addresses and new volume IDs are only samples
+// of the ones a launch would allocate.
+type LaunchPreview struct {
+	WorkloadID string `json:"workload_id"`
+	TenantID   string `json:"tenant_id"`
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+	IPAddress  string `json:"ip_address"`
+	Config     string `json:"config"`
 }
 
 // Instance contains information about an instance of a workload.
@@ -126,6 +222,12 @@ type Instance struct {
 	CNCI        bool         `json:"-"`
 	CreateTime  time.Time    `json:"-"`
 	Name        string       `json:"name"`
+	TraceLabel  string       `json:"trace_label,omitempty"`
+	Protected   bool         `json:"protected,omitempty"`
+	Warm        bool         `json:"warm,omitempty"`
+	ExtraVCPUs  int          `json:"extra_vcpus,omitempty"`
+	ExtraMemMB  int          `json:"extra_mem_mb,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
 	StateLock   sync.RWMutex `json:"-"`
 	StateChange *sync.Cond   `json:"-"`
 }
@@ -137,6 +239,56 @@ func (s SortedInstancesByID) Len() int           { return len(s) }
 func (s SortedInstancesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s SortedInstancesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
 
+// InstanceFilter restricts the instances returned by a listing. Empty
+// fields match all instances.
+type InstanceFilter struct {
+	TenantID   string
+	State      string
+	NodeID     string
+	WorkloadID string
+	NamePrefix string
+}
+
+// Match returns true if the instance matches the filter.
+func (f InstanceFilter) Match(i *Instance) bool {
+	if f.State != "" {
+		i.StateLock.RLock()
+		state := i.State
+		i.StateLock.RUnlock()
+
+		if state != f.State {
+			return false
+		}
+	}
+
+	return (f.TenantID == "" || f.TenantID == i.TenantID) &&
+		(f.NodeID == "" || f.NodeID == i.NodeID) &&
+		(f.WorkloadID == "" || f.WorkloadID == i.WorkloadID) &&
+		strings.HasPrefix(i.Name, f.NamePrefix)
+}
+
+// DeletedInstance is the record of a deleted instance kept, for the
+// deleted_instance_retention_days setting, for post-mortem debugging.
+type DeletedInstance struct {
+	ID         string    `json:"instance_id"`
+	TenantID   string    `json:"tenant_id"`
+	Name       string    `json:"name,omitempty"`
+	WorkloadID string    `json:"workload_id"`
+	NodeID     string    `json:"node_id,omitempty"`
+	State      string    `json:"instance_state"`
+	IPAddress  string    `json:"ip_address"`
+	MACAddress string    `json:"mac_address"`
+	Subnet     string    `json:"subnet"`
+	TraceLabel string    `json:"trace_label,omitempty"`
+	Created    time.Time `json:"created"`
+	Deleted    time.Time `json:"deleted"`
+}
+
+// DeletedInstancesResponse lists the deleted instances of a tenant.
+type DeletedInstancesResponse struct {
+	Instances []DeletedInstance `json:"deleted_instances"`
+}
+
 // SortedNodesByID implements sort.Interface for Node by ID string
 type SortedNodesByID []CiaoNode
 
@@ -151,6 +303,302 @@ type TenantConfig struct {
 	Permissions struct {
 		PrivilegedContainers bool `json:"privileged_containers"`
 	} `json:"permissions"`
+	QuotaProfile  string             `json:"quota_profile,omitempty"`
+	Notifications NotificationConfig `json:"notifications"`
+
+	// MaxInstancesPerRequest overrides the controller wide limit on
+	// the number of instances a single request of the tenant can
+	// start. 0 applies the controller limit and -1 removes the limit.
+	MaxInstancesPerRequest int `json:"max_instances_per_request,omitempty"`
+
+	// WarmPools maps workload IDs to the number of instances of the
+	// workload kept booted for the tenant, ready to be claimed by its
+	// launch requests.
+	WarmPools map[string]int `json:"warm_pools,omitempty"`
+
+	// NetworkPolicy controls the routing between the subnets of the
+	// tenant.
+	NetworkPolicy NetworkPolicy `json:"network_policy"`
+
+	// InstanceDefaults are applied to, or enforced on, the instances
+	// launched by the tenant.
+	InstanceDefaults InstanceDefaults `json:"instance_defaults"`
+
+	// MaintenanceWindows are the periods during which disruptive
+	// automated actions may affect the instances of the tenant. The
+	// windows of the cluster apply if empty.
+	MaintenanceWindows MaintenanceWindows `json:"maintenance_windows,omitempty"`
+}
+
+// MaintenanceWindows is a comma separated list of weekly maintenance
+// windows, such as "sat 22:00-02:00, wed 03:00-04:00". A window opens on
+// a day, mon to sun or * for every day, at a UTC time and closes at a
+// time which, if not after the opening time, is on the next day. An
+// empty list, or none, defines no window.
+type MaintenanceWindows string
+
+// MaintenanceWindow is a weekly maintenance window.
+type MaintenanceWindow struct {
+	// Daily windows open every day and ignore Day.
+	Daily bool
+	Day   time.Weekday
+
+	// Start and End are the opening and closing times of the window,
+	// from midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+var maintenanceDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseTimeOfDay parses a time of the day in the HH:MM format.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Parse returns the windows of the list, or ErrBadRequest if one of them
+// is invalid.
+func (w MaintenanceWindows) Parse() ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+
+	if s := strings.TrimSpace(string(w)); s == "" || s == "none" {
+		return nil, nil
+	}
+
+	for _, desc := range strings.Split(string(w), ",") {
+		fields := strings.Fields(desc)
+		if len(fields) != 2 {
+			return nil, ErrBadRequest
+		}
+
+		var window MaintenanceWindow
+
+		day := strings.ToLower(fields[0])
+		if day == "*" {
+			window.Daily = true
+		} else if d, ok := maintenanceDays[day]; ok {
+			window.Day = d
+		} else {
+			return nil, ErrBadRequest
+		}
+
+		times := strings.Split(fields[1], "-")
+		if len(times) != 2 {
+			return nil, ErrBadRequest
+		}
+
+		var err error
+		if window.Start, err = parseTimeOfDay(times[0]); err != nil {
+			return nil, ErrBadRequest
+		}
+		if window.End, err = parseTimeOfDay(times[1]); err != nil {
+			return nil, ErrBadRequest
+		}
+
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+// Validate returns ErrBadRequest if one of the windows is invalid.
+func (w MaintenanceWindows) Validate() error {
+	_, err := w.Parse()
+	return err
+}
+
+// Contains returns true if t is within the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	since := t.Sub(midnight)
+
+	opensToday := w.Daily || t.Weekday() == w.Day
+	openedYesterday := w.Daily || (t.Weekday()+6)%7 == w.Day
+
+	if w.Start < w.End {
+		return opensToday && since >= w.Start && since < w.End
+	}
+
+	// the window closes the next day
+	return (opensToday && since >= w.Start) || (openedYesterday && since < w.End)
+}
+
+// Limits on the tags of the instance defaults of a tenant.
+const (
+	maxInstanceTags      = 64
+	maxInstanceTagLength = 255
+)
+
+// InstanceDefaults is the security posture of the instances of a tenant.
+// The controller applies it to every instance launched for the tenant,
+// CNCIs excepted.
+type InstanceDefaults struct {
+	// Tags are mandatory tags given to every new instance.
+	Tags []string `json:"tags,omitempty"`
+
+	// SSHKey is an SSH public key, in authorized_keys format, injected
+	// in the metadata of every new VM whatever its workload.
+	SSHKey string `json:"ssh_key,omitempty"`
+
+	// ForbidPublicWorkloads restricts the tenant to launching its own
+	// workloads.
+	ForbidPublicWorkloads bool `json:"forbid_public_workloads,omitempty"`
+
+	// RequireSignedImages restricts the tenant to launching instances
+	// and creating volumes from images signed by a trusted key.
+	RequireSignedImages bool `json:"require_signed_images,omitempty"`
+}
+
+// Validate checks the tags and SSH key of instance defaults.
+func (d InstanceDefaults) Validate() error {
+	if len(d.Tags) > maxInstanceTags {
+		return ErrBadRequest
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range d.Tags {
+		if t == "" || len(t) > maxInstanceTagLength || seen[t] {
+			return ErrBadRequest
+		}
+		seen[t] = true
+	}
+
+	if d.SSHKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.SSHKey)); err != nil {
+			return ErrBadRequest
+		}
+	}
+
+	return nil
+}
+
+// NetworkPolicyMode selects which traffic is routed between the subnets
+// of a tenant.
+type NetworkPolicyMode string
+
+const (
+	// NetworkAllowAll routes all the traffic between the subnets of
+	// the tenant, as does an empty mode.
+	NetworkAllowAll NetworkPolicyMode = "allow_all"
+
+	// NetworkDenyAll routes no traffic between the subnets of the
+	// tenant.
+	NetworkDenyAll NetworkPolicyMode = "deny_all"
+
+	// NetworkRules only routes the traffic allowed by the rules of
+	// the policy.
+	NetworkRules NetworkPolicyMode = "rules"
+)
+
+// NetworkPolicyRule allows the traffic from the source subnet to the
+// destination subnet, along with the replies to this traffic.
+type NetworkPolicyRule struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// NetworkPolicy contains the inter-subnet routing policy of a tenant.
+type NetworkPolicy struct {
+	Mode  NetworkPolicyMode   `json:"mode,omitempty"`
+	Rules []NetworkPolicyRule `json:"rules,omitempty"`
+}
+
+// AllowAll returns true if the policy routes all the traffic between the
+// subnets of the tenant.
+func (p NetworkPolicy) AllowAll() bool {
+	return p.Mode == "" || p.Mode == NetworkAllowAll
+}
+
+// Validate checks that the policy mode is known and that its rules are
+// made of valid CIDRs. Rules are only allowed in the rules mode.
+func (p NetworkPolicy) Validate() error {
+	switch p.Mode {
+	case "", NetworkAllowAll, NetworkDenyAll:
+		if len(p.Rules) > 0 {
+			return ErrBadRequest
+		}
+		return nil
+	case NetworkRules:
+	default:
+		return ErrBadRequest
+	}
+
+	for _, r := range p.Rules {
+		if _, _, err := net.ParseCIDR(r.Source); err != nil {
+			return ErrBadRequest
+		}
+		if _, _, err := net.ParseCIDR(r.Destination); err != nil {
+			return ErrBadRequest
+		}
+	}
+
+	return nil
+}
+
+// NotificationMode selects how a tenant is notified of error events.
+type NotificationMode string
+
+const (
+	// NotifyNone disables notifications, as does an empty mode.
+	NotifyNone NotificationMode = "none"
+
+	// NotifyImmediate sends an email as soon as an error event is
+	// logged, subject to rate limiting.
+	NotifyImmediate NotificationMode = "immediate"
+
+	// NotifyDigest sends a periodic email summarising the error
+	// events logged since the previous one.
+	NotifyDigest NotificationMode = "digest"
+)
+
+// NotificationConfig contains the email notification settings of a tenant.
+type NotificationConfig struct {
+	Mode  NotificationMode `json:"mode,omitempty"`
+	Email string           `json:"email,omitempty"`
+
+	// DigestMinutes is the interval between two digests. The default
+	// interval is used if zero.
+	DigestMinutes int `json:"digest_minutes,omitempty"`
+}
+
+// Validate checks that the notification settings are consistent.
+func (n NotificationConfig) Validate() error {
+	switch n.Mode {
+	case "", NotifyNone:
+		return nil
+	case NotifyImmediate, NotifyDigest:
+	default:
+		return ErrBadRequest
+	}
+
+	if _, err := mail.ParseAddress(n.Email); err != nil {
+		return ErrBadRequest
+	}
+
+	if n.DigestMinutes < 0 {
+		return ErrBadRequest
+	}
+
+	return nil
+}
+
+// Enabled returns true if the tenant is to be notified of error events.
+func (n NotificationConfig) Enabled() bool {
+	return n.Mode == NotifyImmediate || n.Mode == NotifyDigest
 }
 
 // Tenant contains information about a tenant or project.
@@ -178,13 +626,145 @@ type TenantRequest struct {
 	Config TenantConfig `json:"config"`
 }
 
+// EventSeverity is the severity of an event logged by the controller.
+type EventSeverity string
+
+const (
+	// EventDebug is used for events only useful when debugging the
+	// cluster.
+	EventDebug EventSeverity = "debug"
+
+	// EventInfo is used for events reporting normal operations.
+	EventInfo EventSeverity = "info"
+
+	// EventWarning is used for events which may require the attention
+	// of an administrator.
+	EventWarning EventSeverity = "warning"
+
+	// EventError is used for failed operations.
+	EventError EventSeverity = "error"
+
+	// EventCritical is used for failures affecting the availability of
+	// the cluster, e.g., failing hardware.
+	EventCritical EventSeverity = "critical"
+)
+
+var eventSeverities = []EventSeverity{EventDebug, EventInfo, EventWarning, EventError, EventCritical}
+
+// Level returns the rank of a severity, from 0 for EventDebug to 4 for
+// EventCritical, or -1 if the severity is unknown.
+func (s EventSeverity) Level() int {
+	for i, severity := range eventSeverities {
+		if s == severity {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// AtLeast returns the known severities at least as severe as s.
+func (s EventSeverity) AtLeast() []EventSeverity {
+	level := s.Level()
+	if level == -1 {
+		return nil
+	}
+
+	return append([]EventSeverity(nil), eventSeverities[level:]...)
+}
+
+// EventCategory is the kind of resource an event relates to.
+type EventCategory string
+
+const (
+	// EventCategoryInstance is used for events about instances.
+	EventCategoryInstance EventCategory = "instance"
+
+	// EventCategoryNetwork is used for events about tenant networks and
+	// external IPs.
+	EventCategoryNetwork EventCategory = "network"
+
+	// EventCategoryStorage is used for events about volumes and images.
+	EventCategoryStorage EventCategory = "storage"
+
+	// EventCategoryNode is used for events about compute and network
+	// nodes.
+	EventCategoryNode EventCategory = "node"
+
+	// EventCategoryAuth is used for events about authentication and
+	// authorization.
+	EventCategoryAuth EventCategory = "auth"
+)
+
+// Valid returns true if c is one of the known event categories.
+func (c EventCategory) Valid() bool {
+	switch c {
+	case EventCategoryInstance, EventCategoryNetwork, EventCategoryStorage,
+		EventCategoryNode, EventCategoryAuth:
+		return true
+	}
+
+	return false
+}
+
 // LogEntry stores information about events.
 type LogEntry struct {
-	Timestamp time.Time `json:"time_stamp"`
-	TenantID  string    `json:"tenant_id"`
-	NodeID    string    `json:"node_id"`
-	EventType string    `json:"type"`
-	Message   string    `json:"message"`
+	Timestamp time.Time     `json:"time_stamp"`
+	TenantID  string        `json:"tenant_id"`
+	NodeID    string        `json:"node_id"`
+	Severity  EventSeverity `json:"severity"`
+	Category  EventCategory `json:"category"`
+	Message   string        `json:"message"`
+
+	// InstanceID and Reason identify the instance an event is about
+	// and the machine readable reason of the event, when known.
+	InstanceID string `json:"instance_id"`
+	Reason     string `json:"reason"`
+}
+
+// EventFilter selects events of the event log. Empty fields match all
+// events.
+type EventFilter struct {
+	TenantID string
+	NodeID   string
+
+	// Severity is the minimum severity of the events.
+	Severity EventSeverity
+	Category EventCategory
+
+	// Since and Until restrict the events to those logged at or after
+	// Since and before Until.
+	Since time.Time
+	Until time.Time
+}
+
+// Match returns true if the event e is selected by the filter.
+func (f EventFilter) Match(e *LogEntry) bool {
+	if f.TenantID != "" && f.TenantID != e.TenantID {
+		return false
+	}
+
+	if f.NodeID != "" && f.NodeID != e.NodeID {
+		return false
+	}
+
+	if f.Severity != "" && e.Severity.Level() < f.Severity.Level() {
+		return false
+	}
+
+	if f.Category != "" && f.Category != e.Category {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Timestamp.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !e.Timestamp.Before(f.Until) {
+		return false
+	}
+
+	return true
 }
 
 // NodeStats stores statistics for individual nodes in the cluster.
@@ -278,6 +858,10 @@ const (
 	// Detaching means that the volume is in process
 	// of detaching.
 	Detaching BlockState = "detaching"
+
+	// Error means that the volume no longer matches the
+	// backing block device and cannot be used.
+	Error BlockState = "error"
 )
 
 // Volume respresents the attributes of this block device.
@@ -285,12 +869,20 @@ const (
 // or can we use a set of interfaces to get the info?
 type Volume struct {
 	storage.BlockDevice
-	TenantID    string     `json:"tenant_id"`   // the tenant who owns this volume
-	State       BlockState `json:"state"`       // status of
-	CreateTime  time.Time  `json:"created"`     // when we created the volume
-	Name        string     `json:"name"`        // a human readable name for this volume
-	Description string     `json:"description"` // some text to describe this volume.
-	Internal    bool       `json:"internal"`    // whether this storage should be shown to the user
+	TenantID    string     `json:"tenant_id"`           // the tenant who owns this volume
+	State       BlockState `json:"state"`               // status of
+	CreateTime  time.Time  `json:"created"`             // when we created the volume
+	Name        string     `json:"name"`                // a human readable name for this volume
+	Description string     `json:"description"`         // some text to describe this volume.
+	Internal    bool       `json:"internal"`            // whether this storage should be shown to the user
+	Protected   bool       `json:"protected,omitempty"` // whether this volume is protected against deletion
+
+	Metadata map[string]string `json:"metadata,omitempty"` // arbitrary key/value pairs set by the tenant
+	Tags     []string          `json:"tags,omitempty"`     // arbitrary labels set by the tenant
+
+	Pool string `json:"pool,omitempty"` // the storage pool holding the volume, the default pool when empty
+
+	Parent string `json:"parent,omitempty"` // the image a thin clone volume depends on
 }
 
 // StorageAttachment represents a link between a block device and
@@ -324,6 +916,29 @@ type CiaoNode struct {
 	StartFailures         int       `json:"start_failures"`
 	AttachVolumeFailures  int       `json:"attach_failures"`
 	DeleteFailures        int       `json:"delete_failures"`
+
+	Health *CiaoNodeHealth `json:"health,omitempty"`
+}
+
+// CiaoNodeHealth contains the hardware health indicators last reported by
+// a node.
+type CiaoNodeHealth struct {
+	Temperatures         []CiaoTemperature `json:"temperatures,omitempty"`
+	CorrectedECCErrors   int               `json:"corrected_ecc_errors"`
+	UncorrectedECCErrors int               `json:"uncorrected_ecc_errors"`
+	Disks                []CiaoDiskHealth  `json:"disks,omitempty"`
+}
+
+// CiaoTemperature contains the reading of a temperature sensor of a node.
+type CiaoTemperature struct {
+	Sensor  string `json:"sensor"`
+	Celsius int    `json:"celsius"`
+}
+
+// CiaoDiskHealth contains the SMART health assessment of a disk of a node.
+type CiaoDiskHealth struct {
+	Device  string `json:"device"`
+	Healthy bool   `json:"healthy"`
 }
 
 // NodeStatusType contains the valid values of a node's status
@@ -381,11 +996,15 @@ type CiaoTenantResources struct {
 }
 
 // CiaoUsage contains a snapshot of resource consumption for a tenant.
+// NetworkRxBytes and NetworkTxBytes are the cumulative traffic received
+// from and sent to the external network by the instances of the tenant.
 type CiaoUsage struct {
-	VCPU      int       `json:"cpus_usage"`
-	Memory    int       `json:"ram_usage"`
-	Disk      int       `json:"disk_usage"`
-	Timestamp time.Time `json:"timestamp"`
+	VCPU           int       `json:"cpus_usage"`
+	Memory         int       `json:"ram_usage"`
+	Disk           int       `json:"disk_usage"`
+	NetworkRxBytes uint64    `json:"network_rx_bytes"`
+	NetworkTxBytes uint64    `json:"network_tx_bytes"`
+	Timestamp      time.Time `json:"timestamp"`
 }
 
 // CiaoUsageHistory represents the unmarshalled version of the contents of a
@@ -395,6 +1014,30 @@ type CiaoUsageHistory struct {
 	Usages []CiaoUsage `json:"usage"`
 }
 
+// UsageResolution is the interval covered by each sample of a persisted
+// tenant usage history.
+type UsageResolution string
+
+const (
+	// UsageRaw samples are the entries of the usage history, created
+	// at most every tenant_usage_period_minutes.
+	UsageRaw UsageResolution = "raw"
+
+	// UsageHourly samples aggregate the raw samples of an hour.
+	UsageHourly UsageResolution = "hourly"
+
+	// UsageDaily samples aggregate the hourly samples of a day.
+	UsageDaily UsageResolution = "daily"
+)
+
+// TenantUsageSample is a sample of the usage history of a tenant, as
+// persisted by the datastore.
+type TenantUsageSample struct {
+	TenantID   string          `json:"tenant_id"`
+	Resolution UsageResolution `json:"resolution"`
+	CiaoUsage
+}
+
 // CiaoCNCISubnet contains subnet information for a CNCI.
 type CiaoCNCISubnet struct {
 	Subnet string `json:"subnet_cidr"`
@@ -442,6 +1085,81 @@ type CiaoServerStats struct {
 	VCPUUsage int       `json:"cpus_usage"`
 	MemUsage  int       `json:"ram_usage"`
 	DiskUsage int       `json:"disk_usage"`
+
+	Networks []CiaoNetworkUsage  `json:"networks,omitempty"`
+	DiskIO   *CiaoDiskIOUsage    `json:"disk_io,omitempty"`
+	Health   *CiaoInstanceHealth `json:"health,omitempty"`
+}
+
+// CiaoInstanceHealth contains the result of the last health checks of an
+// instance, as reported by its node.
+type CiaoInstanceHealth struct {
+	Status   payloads.HealthStatus `json:"status"`
+	Failures int                   `json:"failures"`
+	Message  string                `json:"message,omitempty"`
+	Checked  time.Time             `json:"checked"`
+}
+
+// CiaoDiskIOUsage contains the cumulative disk I/O counters of an instance
+// and the rate of I/O operations since its previous sample.
+type CiaoDiskIOUsage struct {
+	ReadBytes  uint64  `json:"read_bytes"`
+	WriteBytes uint64  `json:"write_bytes"`
+	ReadOps    uint64  `json:"read_ops"`
+	WriteOps   uint64  `json:"write_ops"`
+	ReadIOPS   float64 `json:"read_iops"`
+	WriteIOPS  float64 `json:"write_iops"`
+}
+
+// CiaoDiskIOSample contains the disk I/O counters of an instance at the
+// time they were sampled by its node.
+type CiaoDiskIOSample struct {
+	Timestamp time.Time       `json:"timestamp"`
+	DiskIO    CiaoDiskIOUsage `json:"disk_io"`
+}
+
+// CiaoServerDiskStats represents the unmarshalled version of the response
+// to a {tenant}/instances/{instance}/disk-stats request. It contains the
+// latest disk I/O samples of an instance, oldest first.
+type CiaoServerDiskStats struct {
+	ID      string             `json:"id"`
+	Samples []CiaoDiskIOSample `json:"samples"`
+}
+
+// CiaoNetworkUsage contains the traffic counters, as seen from the
+// instance, of a network interface of an instance.
+type CiaoNetworkUsage struct {
+	Interface string `json:"interface"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+}
+
+// CiaoNetworkSample contains the network counters of an instance at the
+// time they were sampled by its node.
+type CiaoNetworkSample struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Networks  []CiaoNetworkUsage `json:"networks"`
+}
+
+// CiaoTrafficUsage contains the cumulative traffic routed by its CNCI
+// between an instance and the external network.
+type CiaoTrafficUsage struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+}
+
+// CiaoServerNetworkStats represents the unmarshalled version of the
+// response to a {tenant}/instances/{instance}/network-stats request. It
+// contains the latest network samples of an instance, oldest first, and
+// its external traffic as accounted by its CNCI.
+type CiaoServerNetworkStats struct {
+	ID       string              `json:"id"`
+	Samples  []CiaoNetworkSample `json:"samples"`
+	External *CiaoTrafficUsage   `json:"external,omitempty"`
 }
 
 // CiaoServersStats represents the unmarshalled version of the contents of a
@@ -520,16 +1238,31 @@ type CiaoTraceData struct {
 // CiaoEvent contains information about an individual event generated
 // in a ciao cluster.
 type CiaoEvent struct {
-	Timestamp time.Time `json:"time_stamp"`
-	TenantID  string    `json:"tenant_id"`
-	EventType string    `json:"type"`
-	Message   string    `json:"message"`
+	Timestamp time.Time     `json:"time_stamp"`
+	TenantID  string        `json:"tenant_id"`
+	Severity  EventSeverity `json:"severity"`
+	Category  EventCategory `json:"category"`
+	Message   string        `json:"message"`
+
+	// NodeID is the node the event is about, if any.
+	NodeID string `json:"node_id,omitempty"`
+
+	// InstanceID is the instance the event is about, if any.
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// Reason is a machine readable reason for the event, e.g., the
+	// reason why an instance failed to start such as no_node_memory.
+	Reason string `json:"reason,omitempty"`
 }
 
 // CiaoEvents represents the unmarshalled version of the response to a
 // v2.1/{tenant}/event or v2.1/event request.
 type CiaoEvents struct {
 	Events []CiaoEvent `json:"events"`
+
+	// NextMarker is set when more events match the query, and is
+	// passed back as the marker to get the next page of events.
+	NextMarker string `json:"next_marker,omitempty"`
 }
 
 // NewCiaoEvents allocates a CiaoEvents structure.
@@ -587,10 +1320,54 @@ var (
 	// ErrDuplicatePoolName is returned when a duplicate pool name is used
 	ErrDuplicatePoolName = errors.New("Pool by that name already exists")
 
+	// ErrAddressMapped is returned when a requested external IP is
+	// already mapped to an instance
+	ErrAddressMapped = errors.New("Address is already mapped")
+
 	// ErrInstanceMapped is returned when an instance cannot be deleted
 	// due to having an external IP assigned to it.
 	ErrInstanceMapped = errors.New("Unmap the external IP prior to deletion")
 
+	// ErrInstanceProtected is returned when an instance cannot be
+	// deleted because it is protected against deletion.
+	ErrInstanceProtected = errors.New("Unprotect the instance prior to deletion")
+
+	// ErrVolumeBatchNotFound is returned when a batch of volumes is
+	// not found.
+	ErrVolumeBatchNotFound = errors.New("Volume batch not found")
+
+	// ErrImageExportNotFound is returned when the export of an image is
+	// not found.
+	ErrImageExportNotFound = errors.New("Image export not found")
+
+	// ErrImageExportDisabled is returned when an image is exported while
+	// no object storage is configured for the cluster.
+	ErrImageExportDisabled = errors.New("No object storage configured for image exports")
+
+	// ErrImageNotActive is returned when an image whose data is not
+	// available is exported.
+	ErrImageNotActive = errors.New("Image is not active")
+
+	// ErrStackNotFound is returned when a stack of instances is not
+	// found.
+	ErrStackNotFound = errors.New("Stack not found")
+
+	// ErrReportNotFound is returned when a report schedule is not
+	// found.
+	ErrReportNotFound = errors.New("Report not found")
+
+	// ErrReportsDisabled is returned when reports are requested while
+	// no report schedules are configured.
+	ErrReportsDisabled = errors.New("No report schedules configured")
+
+	// ErrStoragePoolNotFound is returned when a volume is requested in
+	// a storage pool which is not configured.
+	ErrStoragePoolNotFound = errors.New("Storage pool not found")
+
+	// ErrVolumeProtected is returned when a volume cannot be deleted
+	// because it is protected against deletion.
+	ErrVolumeProtected = errors.New("Unprotect the volume prior to deletion")
+
 	// ErrWorkloadNotFound is returned when a workload ID cannot be found
 	ErrWorkloadNotFound = errors.New("Workload not found")
 
@@ -599,6 +1376,135 @@ var (
 
 	// ErrBadName is returned when a name doesn't match the requirements
 	ErrBadName = errors.New("Requested name doesn't match requirements")
+
+	// ErrQuotaProfileNotFound is returned when a quota profile name
+	// cannot be found.
+	ErrQuotaProfileNotFound = errors.New("Quota profile not found")
+
+	// ErrQuotaProfileInUse is returned when deleting a quota profile
+	// still assigned to a tenant.
+	ErrQuotaProfileInUse = errors.New("Quota profile still in use")
+
+	// ErrNoBootVolume is returned when an image is requested from an
+	// instance which does not boot from a volume.
+	ErrNoBootVolume = errors.New("Instance has no boot volume")
+
+	// ErrNodeNotFound is returned when a node is not known to the
+	// controller.
+	ErrNodeNotFound = errors.New("Node not found")
+
+	// ErrNodeNotEmpty is returned when removing a node which still
+	// hosts instances.
+	ErrNodeNotEmpty = errors.New("Node still has instances")
+
+	// ErrNodeDraining is returned when a drain is requested for a node
+	// which is already being drained.
+	ErrNodeDraining = errors.New("Node is already being drained")
+
+	// ErrNodeCommandPending is returned when a node command is requested
+	// while the same command is still pending on the node.
+	ErrNodeCommandPending = errors.New("Node command already pending")
+
+	// ErrNodeCommandNotFound is returned when a node command is not
+	// known to the controller.
+	ErrNodeCommandNotFound = errors.New("Node command not found")
+
+	// ErrAuthLockoutNotFound is returned when an authentication lockout
+	// is not known to the controller.
+	ErrAuthLockoutNotFound = errors.New("Authentication lockout not found")
+
+	// ErrConfirmationRequired is returned when a destructive operation
+	// is requested without the token returned by its dry run.
+	ErrConfirmationRequired = errors.New("Operation must be confirmed")
+
+	// ErrInvalidConfirmation is returned when a destructive operation is
+	// requested with a token which is unknown, expired or was returned
+	// by the dry run of another operation.
+	ErrInvalidConfirmation = errors.New("Invalid or expired confirmation token")
+
+	// ErrTenantResubnetting is returned when changing the network of a
+	// tenant whose subnet bits are already being changed.
+	ErrTenantResubnetting = errors.New("Tenant network is being changed")
+
+	// ErrConfigKeyNotFound is returned when a cluster configuration
+	// setting is not known to the controller.
+	ErrConfigKeyNotFound = errors.New("Configuration setting not found")
+
+	// ErrInvalidConfigValue is returned when the value of a cluster
+	// configuration setting does not match its schema.
+	ErrInvalidConfigValue = errors.New("Invalid configuration value")
+
+	// ErrReplayDisabled is returned when replaying captured frames on
+	// a controller not running against simulated nodes.
+	ErrReplayDisabled = errors.New("Frame replay is only available with simulated nodes")
+
+	// ErrFaultInjectionDisabled is returned when configuring faults
+	// on a controller not started with fault injection enabled.
+	ErrFaultInjectionDisabled = errors.New("Fault injection is disabled")
+
+	// ErrImageCorrupted is returned when launching instances from an
+	// image whose data has been found to be corrupted.
+	ErrImageCorrupted = errors.New("Image is corrupted")
+
+	// ErrImageInUse is returned when deleting an image from which
+	// volumes were cloned.
+	ErrImageInUse = errors.New("Image has cloned volumes")
+
+	// ErrUntrustedSigner is returned when an image is signed by a key
+	// which is not trusted by the controller.
+	ErrUntrustedSigner = errors.New("Image signer is not trusted")
+
+	// ErrImageSignature is returned when the signature of an image does
+	// not match its data.
+	ErrImageSignature = errors.New("Image signature verification failed")
+
+	// ErrUnsignedImage is returned when a tenant which requires signed
+	// images uses an image which is not signed.
+	ErrUnsignedImage = errors.New("Tenant requires signed images")
+
+	// ErrInstanceNameInUse is returned when an instance name is
+	// already used by another instance of the tenant.
+	ErrInstanceNameInUse = errors.New("Instance name already in use")
+
+	// ErrInvalidIPRange is returned when a range of IP addresses is
+	// malformed or too large.
+	ErrInvalidIPRange = errors.New("Invalid IP address range")
+
+	// ErrTenantIPInUse is returned when releasing a tenant IP address
+	// which is still assigned to an instance.
+	ErrTenantIPInUse = errors.New("Tenant IP is assigned to an instance")
+
+	// ErrNoVNI is returned when all the VXLAN network identifiers are
+	// either allocated or quarantined.
+	ErrNoVNI = errors.New("No VXLAN network identifier available")
+
+	// ErrInstanceNotRunning is returned when an operation needs a running
+	// instance.
+	ErrInstanceNotRunning = errors.New("Instance is not running")
+
+	// ErrHotAddNotSupported is returned when resources are hot added to
+	// an instance beyond the maximums of its workload.
+	ErrHotAddNotSupported = errors.New("Instance cannot be hot added these resources")
+
+	// ErrPublicWorkloadForbidden is returned when a tenant forbidden to
+	// launch public workloads launches one.
+	ErrPublicWorkloadForbidden = errors.New("Tenant is not allowed to launch public workloads")
+
+	// ErrRebalancing is returned when a rebalancing is requested while
+	// another one is migrating instances.
+	ErrRebalancing = errors.New("Instances are already being rebalanced")
+
+	// ErrNoRebalance is returned when the status of a rebalancing is
+	// requested before any was started.
+	ErrNoRebalance = errors.New("No rebalancing was started")
+
+	// ErrInstanceTransferring is returned when an instance is already
+	// being transferred to another tenant.
+	ErrInstanceTransferring = errors.New("Instance is being transferred")
+
+	// ErrNoInstanceTransfer is returned when the status of the transfer
+	// of an instance is requested before any was started.
+	ErrNoInstanceTransfer = errors.New("No transfer of the instance was started")
 )
 
 // Link provides a url and relationship for a resource.
@@ -617,9 +1523,12 @@ type APILink struct {
 
 // ExternalSubnet represents a subnet for External IPs.
 type ExternalSubnet struct {
-	ID    string `json:"id"`
-	CIDR  string `json:"subnet"`
-	Links []Link `json:"links"`
+	ID        string   `json:"id"`
+	CIDR      string   `json:"subnet"`
+	Used      int      `json:"used"`
+	Free      int      `json:"free"`
+	MappedIPs []string `json:"mapped_ips,omitempty"`
+	Links     []Link   `json:"links"`
 }
 
 // ExternalIP represents an External IP individual address.
@@ -647,15 +1556,20 @@ type NewPoolRequest struct {
 	IPs    []struct {
 		IP string `json:"ip"`
 	} `json:"ips"`
+
+	// IPRange is a range of addresses, e.g., 192.0.2.10-192.0.2.50,
+	// added to the pool along with IPs.
+	IPRange *string `json:"ip_range,omitempty"`
 }
 
 // PoolSummary is a short form of Pool.
 type PoolSummary struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Free     *int   `json:"free,omitempty"`
-	TotalIPs *int   `json:"total_ips,omitempty"`
-	Links    []Link `json:"links,omitempty"`
+	ID       string           `json:"id"`
+	Name     string           `json:"name"`
+	Free     *int             `json:"free,omitempty"`
+	TotalIPs *int             `json:"total_ips,omitempty"`
+	Links    []Link           `json:"links,omitempty"`
+	Subnets  []ExternalSubnet `json:"subnets,omitempty"`
 }
 
 // ListPoolsResponse respresents a summary list of all pools.
@@ -672,6 +1586,10 @@ type NewIPAddressRequest struct {
 type NewAddressRequest struct {
 	Subnet *string               `json:"subnet"`
 	IPs    []NewIPAddressRequest `json:"ips"`
+
+	// IPRange is a range of addresses, e.g., 192.0.2.10-192.0.2.50,
+	// added to the pool along with IPs.
+	IPRange *string `json:"ip_range,omitempty"`
 }
 
 // MappedIP represents a mapping of external IP -> instance IP.
@@ -683,6 +1601,7 @@ type MappedIP struct {
 	TenantID   string `json:"tenant_id"`
 	PoolID     string `json:"pool_id"`
 	PoolName   string `json:"pool_name"`
+	NodeID     string `json:"node_id,omitempty"`
 	Links      []Link `json:"links"`
 }
 
@@ -699,6 +1618,7 @@ type MappedIPShort struct {
 // to a particular instance.
 type MapIPRequest struct {
 	PoolName   *string `json:"pool_name"`
+	Address    *string `json:"address,omitempty"`
 	InstanceID string  `json:"instance_id"`
 }
 
@@ -772,13 +1692,27 @@ type QuotaListResponse struct {
 	Quotas []QuotaDetails `json:"quotas"`
 }
 
+// QuotaProfile is a named set of quotas and limits which can be assigned
+// to tenants.
+type QuotaProfile struct {
+	Name   string         `json:"name"`
+	Quotas []QuotaDetails `json:"quotas"`
+}
+
+// QuotaProfilesResponse holds the layout for returning quota profiles in
+// the API
+type QuotaProfilesResponse struct {
+	Profiles []QuotaProfile `json:"profiles"`
+}
+
 // CNCIController is the interface for the cnci controller associated with each tenant
 type CNCIController interface {
 	CNCIAdded(ID string) error
 	CNCIRemoved(ID string) error
 	CNCIStopped(id string) error
-	StartFailure(ID string) error
+	StartFailure(ID string, reason string) error
 	Active(ID string) bool
+	Subnets() []TenantSubnet
 	ScheduleRemoveSubnet(subnet string) error
 	RemoveSubnet(subnet string) error
 	WaitForActive(subnet string) error
@@ -802,6 +1736,10 @@ const (
 
 	// Killed means that an image data upload error occurred.
 	Killed ImageState = "killed"
+
+	// ImageError means that the image data is corrupted and that the
+	// image can no longer be used to launch instances.
+	ImageError ImageState = "error"
 )
 
 // Visibility defines whether an image is per tenant or public.
@@ -827,6 +1765,63 @@ type Image struct {
 	CreateTime time.Time  `json:"create_time"`
 	Size       uint64     `json:"size"`
 	Visibility Visibility `json:"visibility"`
+
+	// SourceInstanceID and SourceVolumeID record the instance and
+	// the boot volume an image was created from, if any.
+	SourceInstanceID string `json:"source_instance_id,omitempty"`
+	SourceVolumeID   string `json:"source_volume_id,omitempty"`
+
+	// Signature is the signature of the data of a signed image by the
+	// trusted key named Signer. Digest is the hex encoded SHA-256 of
+	// the data, recorded when it is uploaded.
+	Digest    string `json:"digest,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+}
+
+// instanceTransitions lists the states an instance may move to from each
+// of its states. Moving back to pending from active or exited happens when
+// an instance is restarted or migrated to another node, and instances on
+// a disconnected node may move to missing from any state but deleted.
+// Hung and missing instances recover to any state their node reports.
+var instanceTransitions = map[string][]string{
+	payloads.Pending: {payloads.Running, payloads.Exited, payloads.ExitFailed,
+		payloads.Deleted, payloads.Hung, payloads.Missing},
+	payloads.Running: {payloads.Pending, payloads.Stopping, payloads.Exited,
+		payloads.ExitFailed, payloads.Deleted, payloads.Hung, payloads.Missing},
+	payloads.Stopping: {payloads.Exited, payloads.Deleted, payloads.Hung,
+		payloads.Missing},
+	payloads.Exited: {payloads.Pending, payloads.Deleted, payloads.Hung,
+		payloads.Missing},
+	payloads.ExitFailed: {payloads.Pending, payloads.Exited, payloads.Deleted,
+		payloads.Missing},
+	payloads.Hung: {payloads.Pending, payloads.Running, payloads.Stopping,
+		payloads.Exited, payloads.ExitFailed, payloads.Deleted, payloads.Missing},
+	payloads.Missing: {payloads.Pending, payloads.Running, payloads.Stopping,
+		payloads.Exited, payloads.ExitFailed, payloads.Deleted, payloads.Hung},
+	payloads.Deleted: {},
+}
+
+// ValidInstanceTransition returns true if an instance in the from state
+// is allowed to move to the to state. Staying in the same state is always
+// allowed, as are transitions from states the controller does not track.
+func ValidInstanceTransition(from string, to string) bool {
+	if from == to {
+		return true
+	}
+
+	allowed, ok := instanceTransitions[from]
+	if !ok {
+		return true
+	}
+
+	for _, s := range allowed {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
 }
 
 // TransitionInstanceState safely sets thes state on an instance
@@ -836,15 +1831,13 @@ func (i *Instance) TransitionInstanceState(to string) error {
 
 	glog.V(2).Infof("Instance %s: %s -> %s", i.ID, i.State, to)
 
-	switch to {
-	case payloads.Stopping:
-		if i.State != payloads.Running {
-			return errors.New("Stop operation not allowed")
-		}
-	case payloads.Running:
-		if i.State != payloads.Pending {
-			return errors.New("Set active without pending")
-		}
+	if !ValidInstanceTransition(i.State, to) {
+		return fmt.Errorf("Invalid state transition %s -> %s", i.State, to)
+	}
+
+	if i.StateChange == nil {
+		i.State = to
+		return nil
 	}
 
 	i.StateChange.L.Lock()
@@ -854,3 +1847,1035 @@ func (i *Instance) TransitionInstanceState(to string) error {
 
 	return nil
 }
+
+// RevisionedResource identifies a collection of objects in the controller
+// datastore whose modifications are tracked by a revision counter.
+type RevisionedResource string
+
+const (
+	// InstancesRevision tracks changes to instances and their attachments.
+	InstancesRevision RevisionedResource = "instances"
+
+	// VolumesRevision tracks changes to block devices.
+	VolumesRevision RevisionedResource = "volumes"
+
+	// ImagesRevision tracks changes to images.
+	ImagesRevision RevisionedResource = "images"
+
+	// NodesRevision tracks changes to the last reported node statistics.
+	NodesRevision RevisionedResource = "nodes"
+)
+
+// WatchResource identifies the type of the datastore objects whose
+// changes are reported to watchers.
+type WatchResource string
+
+const (
+	// WatchInstances reports the instances added, updated or deleted.
+	WatchInstances WatchResource = "instances"
+
+	// WatchTenants reports the tenants added, updated or deleted.
+	WatchTenants WatchResource = "tenants"
+
+	// WatchVolumes reports the volumes added, updated or deleted.
+	WatchVolumes WatchResource = "volumes"
+)
+
+// WatchEventType is the type of change reported by a WatchEvent.
+type WatchEventType string
+
+const (
+	// WatchAdded is reported when an object is created.
+	WatchAdded WatchEventType = "added"
+
+	// WatchUpdated is reported when an object is modified.
+	WatchUpdated WatchEventType = "updated"
+
+	// WatchDeleted is reported when an object is deleted.
+	WatchDeleted WatchEventType = "deleted"
+)
+
+// WatchEvent describes a change made to a datastore object. The object
+// itself is not included, watchers fetch it from the datastore if they
+// need more than its state.
+type WatchEvent struct {
+	Resource  WatchResource  `json:"resource"`
+	Type      WatchEventType `json:"type"`
+	ID        string         `json:"id"`
+	TenantID  string         `json:"tenant_id"`
+	State     string         `json:"state,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// WatchFilter restricts the events sent to a watcher. Empty fields match
+// all objects.
+type WatchFilter struct {
+	TenantID string
+	ID       string
+}
+
+// Match returns true if the event matches the filter.
+func (f WatchFilter) Match(e WatchEvent) bool {
+	return (f.TenantID == "" || f.TenantID == e.TenantID) &&
+		(f.ID == "" || f.ID == e.ID)
+}
+
+// TenantResourceSummary contains aggregate counts of the instances and
+// volumes owned by a tenant.
+type TenantResourceSummary struct {
+	TenantID       string         `json:"tenant_id"`
+	TotalInstances int            `json:"total_instances"`
+	InstanceStates map[string]int `json:"instance_states"`
+	VCPUs          int            `json:"vcpus"`
+	MemMB          int            `json:"mem_mb"`
+	TotalVolumes   int            `json:"total_volumes"`
+	VolumeSizeGB   int            `json:"volume_size_gb"`
+}
+
+// SubnetState represents the CNCI programming state of a tenant subnet.
+type SubnetState string
+
+const (
+	// SubnetPending means that the CNCI serving the subnet has been
+	// requested but is not active yet.
+	SubnetPending SubnetState = "pending"
+
+	// SubnetActive means that the CNCI serving the subnet is active.
+	SubnetActive SubnetState = "active"
+
+	// SubnetFailed means that the CNCI serving the subnet could not
+	// be activated.
+	SubnetFailed SubnetState = "failed"
+)
+
+// TenantSubnet contains the CNCI activation status of a tenant subnet.
+type TenantSubnet struct {
+	Subnet string      `json:"subnet"`
+	CNCIID string      `json:"cnci_id,omitempty"`
+	State  SubnetState `json:"state"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// TenantSubnetsResponse stores the list of subnets of a tenant.
+type TenantSubnetsResponse struct {
+	Subnets []TenantSubnet `json:"subnets"`
+}
+
+// TenantIP is an IP address claimed in the network of a tenant.
+type TenantIP struct {
+	Address    string `json:"address"`
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// Orphaned is true when no instance of the tenant holds the address,
+	// e.g., because the claim was leaked by a failed operation.
+	Orphaned bool `json:"orphaned"`
+}
+
+// TenantIPsResponse stores the list of IP addresses claimed by a tenant.
+type TenantIPsResponse struct {
+	IPs []TenantIP `json:"ips"`
+}
+
+// TenantIPReleaseRequest lists the orphaned IP addresses of a tenant to
+// release.  All the orphaned addresses are released if the list is empty.
+type TenantIPReleaseRequest struct {
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// TenantIPReleaseResponse lists the IP addresses released by a
+// TenantIPReleaseRequest.
+type TenantIPReleaseResponse struct {
+	Released []string `json:"released"`
+}
+
+// ShadowPlacement records the node a shadow scheduling policy would have
+// placed an instance on, next to the node the scheduler placed it on.
+type ShadowPlacement struct {
+	InstanceID string    `json:"instance_id"`
+	TenantID   string    `json:"tenant_id"`
+	Policy     string    `json:"policy"`
+	Timestamp  time.Time `json:"timestamp"`
+
+	// ShadowNodeID is empty if the shadow policy found no node fitting
+	// the instance.
+	ShadowNodeID string `json:"shadow_node_id"`
+
+	// ActualNodeID is empty until a node reports the instance.
+	ActualNodeID string `json:"actual_node_id"`
+}
+
+// ShadowPlacementSummary compares the placements of a shadow scheduling
+// policy with the ones of the scheduler.
+type ShadowPlacementSummary struct {
+	Policy      string `json:"policy"`
+	Placements  int    `json:"placements"`
+	Agreed      int    `json:"agreed"`
+	Disagreed   int    `json:"disagreed"`
+	Unplaceable int    `json:"unplaceable"`
+	Pending     int    `json:"pending"`
+}
+
+// ShadowPlacementsResponse stores the placements recorded in shadow
+// scheduling mode, summarised per policy.
+type ShadowPlacementsResponse struct {
+	Summaries  []ShadowPlacementSummary `json:"summaries"`
+	Placements []ShadowPlacement        `json:"placements"`
+}
+
+// VNIState is the state of a VXLAN network identifier.
+type VNIState string
+
+const (
+	// VNIAllocated is the state of a VNI assigned to a tenant subnet.
+	VNIAllocated VNIState = "allocated"
+
+	// VNIQuarantined is the state of a VNI released by a tenant subnet
+	// which cannot be assigned to another subnet until the end of its
+	// quarantine, so that stale tunnels never carry traffic of a new
+	// subnet.
+	VNIQuarantined VNIState = "quarantined"
+)
+
+// VNIAllocation is the assignment of a VXLAN network identifier to a
+// tenant subnet.
+type VNIAllocation struct {
+	VNI      uint32   `json:"vni"`
+	TenantID string   `json:"tenant_id"`
+	Subnet   string   `json:"subnet"`
+	State    VNIState `json:"state"`
+
+	// ReleaseTime is the start of the quarantine of a released VNI.
+	ReleaseTime time.Time `json:"release_time"`
+}
+
+// VNIsResponse stores the VNI allocations of the cluster.
+type VNIsResponse struct {
+	VNIs []VNIAllocation `json:"vnis"`
+}
+
+// OrphanKind describes how the controller and a node agent disagree
+// about an instance.
+type OrphanKind string
+
+const (
+	// OrphanUnknown is an instance reported by a node agent but
+	// unknown to the controller.
+	OrphanUnknown OrphanKind = "unknown"
+
+	// OrphanMissing is an instance the controller places on a node
+	// whose agent no longer reports it.
+	OrphanMissing OrphanKind = "missing"
+)
+
+// OrphanedInstance is an instance found in only one of the controller
+// datastore and the stats reported by the agent running on its node.
+type OrphanedInstance struct {
+	InstanceID string     `json:"instance_id"`
+	NodeID     string     `json:"node_id"`
+	Kind       OrphanKind `json:"kind"`
+}
+
+// VolumeMismatchKind describes how a volume record differs from its
+// backing block device.
+type VolumeMismatchKind string
+
+const (
+	// VolumeMissing is a volume whose block device does not exist.
+	VolumeMissing VolumeMismatchKind = "missing"
+
+	// VolumeSize is a volume whose size differs from the size of its
+	// block device.
+	VolumeSize VolumeMismatchKind = "size"
+)
+
+// VolumeMismatch is a volume record which does not match the block
+// device backing it.
+type VolumeMismatch struct {
+	VolumeID   string             `json:"volume_id"`
+	TenantID   string             `json:"tenant_id"`
+	Kind       VolumeMismatchKind `json:"kind"`
+	Size       int                `json:"size"`
+	ActualSize int                `json:"actual_size,omitempty"`
+}
+
+// VolumeCheckResponse contains the result of the last check of the
+// volume records against the block devices.
+type VolumeCheckResponse struct {
+	CheckedAt  time.Time        `json:"checked_at"`
+	Mismatches []VolumeMismatch `json:"mismatches"`
+}
+
+// InconsistencyKind describes how an object of the datastore is
+// inconsistent.
+type InconsistencyKind string
+
+const (
+	// InconsistencyNotStored is an object cached by the datastore but
+	// missing from the persistent store.
+	InconsistencyNotStored InconsistencyKind = "not_stored"
+
+	// InconsistencyNotCached is an object of the persistent store
+	// missing from the datastore caches.
+	InconsistencyNotCached InconsistencyKind = "not_cached"
+
+	// InconsistencyOrphaned is an object referring to an object which
+	// does not exist.
+	InconsistencyOrphaned InconsistencyKind = "orphaned"
+)
+
+// Inconsistency is an object found inconsistent by a verification of
+// the datastore.
+type Inconsistency struct {
+	Kind     InconsistencyKind `json:"kind"`
+	Resource string            `json:"resource"`
+	ID       string            `json:"id"`
+	Reason   string            `json:"reason"`
+	Repaired bool              `json:"repaired"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// DatastoreVerifyRequest is the request to verify the consistency of the
+// datastore, and optionally repair it.
+type DatastoreVerifyRequest struct {
+	Repair bool `json:"repair"`
+}
+
+// DatastoreVerifyResponse contains the result of a verification of the
+// datastore.
+type DatastoreVerifyResponse struct {
+	VerifiedAt      time.Time       `json:"verified_at"`
+	Repair          bool            `json:"repair"`
+	Inconsistencies []Inconsistency `json:"inconsistencies"`
+}
+
+// NodeDrainState is the state of the drain of a node.
+type NodeDrainState string
+
+const (
+	// NodeDrainEvacuating is the state of a node drain waiting for the
+	// instances of the node to be evacuated.
+	NodeDrainEvacuating NodeDrainState = "evacuating"
+
+	// NodeDrainDone is the state of a node drain which evacuated all
+	// the instances of the node.
+	NodeDrainDone NodeDrainState = "done"
+
+	// NodeDrainDeleted is the state of a node drain which evacuated all
+	// the instances of the node and removed the node.
+	NodeDrainDeleted NodeDrainState = "deleted"
+
+	// NodeDrainFailed is the state of a node drain which did not
+	// complete.
+	NodeDrainFailed NodeDrainState = "failed"
+)
+
+// NodeDrainRequest is used to drain a node, i.e. to put it in
+// maintenance mode and evacuate its instances.
+type NodeDrainRequest struct {
+	Delete bool `json:"delete"`
+}
+
+// NodeDrainStatus reports the progress of the drain of a node.
+type NodeDrainStatus struct {
+	NodeID    string         `json:"node_id"`
+	Delete    bool           `json:"delete"`
+	State     NodeDrainState `json:"state"`
+	Instances int            `json:"instances"`
+	Started   time.Time      `json:"started"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// NodeCommandType identifies an operation run on a node.
+type NodeCommandType string
+
+const (
+	// NodeCommandEvacuate puts the node in maintenance mode and
+	// evacuates its instances.
+	NodeCommandEvacuate NodeCommandType = "evacuate"
+
+	// NodeCommandReconfigure sends the cluster configuration to the
+	// node again.
+	NodeCommandReconfigure NodeCommandType = "reconfigure"
+
+	// NodeCommandRestartAgent restarts the agent service of the node.
+	NodeCommandRestartAgent NodeCommandType = "restart-agent"
+
+	// NodeCommandSelfTest runs the connectivity self-test of the node.
+	NodeCommandSelfTest NodeCommandType = "self-test"
+)
+
+// NodeCommandState is the state of a node command.
+type NodeCommandState string
+
+const (
+	// NodeCommandPending is the state of a node command waiting for
+	// the node to complete it.
+	NodeCommandPending NodeCommandState = "pending"
+
+	// NodeCommandSucceeded is the state of a node command completed
+	// by the node.
+	NodeCommandSucceeded NodeCommandState = "succeeded"
+
+	// NodeCommandFailed is the state of a node command which failed or
+	// timed out.
+	NodeCommandFailed NodeCommandState = "failed"
+)
+
+// NodeCommandRequest is used to run a command on a node.
+type NodeCommandRequest struct {
+	Command NodeCommandType `json:"command"`
+}
+
+// NodeSelfTestCheck is the result of one of the checks of a node
+// self-test.
+type NodeSelfTestCheck struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+// NodeCommandStatus reports the progress of a command run on a node.
+type NodeCommandStatus struct {
+	ID        string              `json:"id"`
+	NodeID    string              `json:"node_id"`
+	Command   NodeCommandType     `json:"command"`
+	State     NodeCommandState    `json:"state"`
+	Started   time.Time           `json:"started"`
+	Completed time.Time           `json:"completed"`
+	Error     string              `json:"error,omitempty"`
+	Checks    []NodeSelfTestCheck `json:"checks,omitempty"`
+}
+
+// NodeCommandListResponse lists the commands run on a node.
+type NodeCommandListResponse struct {
+	Commands []NodeCommandStatus `json:"commands"`
+}
+
+// AuthLockoutKind is the kind of caller whose failed authentications are
+// tracked.
+type AuthLockoutKind string
+
+const (
+	// AuthLockoutSource tracks the failures of a client address.
+	AuthLockoutSource AuthLockoutKind = "source"
+
+	// AuthLockoutAccount tracks the failures of a certificate common
+	// name.
+	AuthLockoutAccount AuthLockoutKind = "account"
+)
+
+// AuthLockout contains the failed authentications of a client address or
+// account. Requests from a caller are rejected until LockedUntil.
+type AuthLockout struct {
+	ID          string          `json:"id"`
+	Kind        AuthLockoutKind `json:"kind"`
+	Name        string          `json:"name"`
+	Failures    int             `json:"failures"`
+	LastFailure time.Time       `json:"last_failure"`
+	LockedUntil time.Time       `json:"locked_until"`
+}
+
+// AuthLockoutListResponse lists the callers with failed authentications.
+type AuthLockoutListResponse struct {
+	Lockouts []AuthLockout `json:"lockouts"`
+}
+
+// RebalanceState is the state of the rebalancing of the instances across
+// the compute nodes.
+type RebalanceState string
+
+const (
+	// RebalanceRunning is the state of a rebalancing migrating its
+	// instances.
+	RebalanceRunning RebalanceState = "running"
+
+	// RebalanceDone is the state of a rebalancing which migrated all
+	// its instances, or had nothing to migrate.
+	RebalanceDone RebalanceState = "done"
+
+	// RebalanceFailed is the state of a rebalancing which failed to
+	// migrate some of its instances.
+	RebalanceFailed RebalanceState = "failed"
+)
+
+// RebalanceRequest is used to rebalance the instances across the compute
+// nodes.  A dry run only returns the planned migrations.  MaxMoves limits
+// the number of migrations, 0 applies the controller default.
+type RebalanceRequest struct {
+	DryRun   bool `json:"dry_run"`
+	MaxMoves int  `json:"max_moves,omitempty"`
+}
+
+// RebalanceMove is the migration of an instance planned by a rebalancing.
+type RebalanceMove struct {
+	InstanceID string `json:"instance_id"`
+	TenantID   string `json:"tenant_id"`
+	From       string `json:"from_node"`
+	To         string `json:"to_node"`
+	MemMB      int    `json:"mem_mb"`
+	Done       bool   `json:"done"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RebalanceStatus reports the migrations planned by a rebalancing and the
+// progress of their execution.
+type RebalanceStatus struct {
+	ID      string          `json:"id"`
+	DryRun  bool            `json:"dry_run"`
+	State   RebalanceState  `json:"state"`
+	Moves   []RebalanceMove `json:"moves"`
+	Started time.Time       `json:"started"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// TenantResubnetState is the state of the change of the subnet bits of
+// a tenant.
+type TenantResubnetState string
+
+const (
+	// TenantResubnetStopping is the state of a resubnet waiting for the
+	// running instances of the tenant to stop.
+	TenantResubnetStopping TenantResubnetState = "stopping"
+
+	// TenantResubnetRebuilding is the state of a resubnet removing the
+	// CNCIs of the tenant and allocating new addresses to its
+	// instances, launching the CNCIs of the new subnets.
+	TenantResubnetRebuilding TenantResubnetState = "rebuilding"
+
+	// TenantResubnetRestarting is the state of a resubnet restarting
+	// the instances it stopped.
+	TenantResubnetRestarting TenantResubnetState = "restarting"
+
+	// TenantResubnetDone is the state of a resubnet which moved all
+	// the instances of the tenant to the new subnets.
+	TenantResubnetDone TenantResubnetState = "done"
+
+	// TenantResubnetFailed is the state of a resubnet which did not
+	// complete.
+	TenantResubnetFailed TenantResubnetState = "failed"
+)
+
+// TenantResubnetRequest is used to change the subnet bits of a tenant
+// which has instances, moving them to new subnets.
+type TenantResubnetRequest struct {
+	SubnetBits int `json:"subnet_bits"`
+}
+
+// TenantResubnetStatus reports the progress of the change of the subnet
+// bits of a tenant.
+type TenantResubnetStatus struct {
+	TenantID   string              `json:"tenant_id"`
+	SubnetBits int                 `json:"subnet_bits"`
+	State      TenantResubnetState `json:"state"`
+	Instances  int                 `json:"instances"`
+	Started    time.Time           `json:"started"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// InstanceTransferState is the state of the transfer of an instance to
+// another tenant.
+type InstanceTransferState string
+
+const (
+	// InstanceTransferUnmapping is the state of a transfer waiting for
+	// the external IPs of the instance to be unmapped.
+	InstanceTransferUnmapping InstanceTransferState = "unmapping"
+
+	// InstanceTransferStopping is the state of a transfer waiting for
+	// the instance to stop.
+	InstanceTransferStopping InstanceTransferState = "stopping"
+
+	// InstanceTransferMoving is the state of a transfer moving the
+	// instance and its volumes to the new tenant, allocating it an
+	// address in the network of the new tenant.
+	InstanceTransferMoving InstanceTransferState = "moving"
+
+	// InstanceTransferRestarting is the state of a transfer restarting
+	// the instance it stopped and mapping its external IPs again.
+	InstanceTransferRestarting InstanceTransferState = "restarting"
+
+	// InstanceTransferDone is the state of a transfer which moved the
+	// instance to the new tenant.  The error of the transfer reports a
+	// failure to restart the instance or to map its external IPs again.
+	InstanceTransferDone InstanceTransferState = "done"
+
+	// InstanceTransferFailed is the state of a transfer which did not
+	// complete.
+	InstanceTransferFailed InstanceTransferState = "failed"
+)
+
+// InstanceTransferRequest is used to transfer an instance to another
+// tenant.
+type InstanceTransferRequest struct {
+	TenantID string `json:"tenant_id"`
+}
+
+// InstanceTransferStatus reports the progress of the transfer of an
+// instance to another tenant.
+type InstanceTransferStatus struct {
+	InstanceID   string                `json:"instance_id"`
+	FromTenantID string                `json:"from_tenant_id"`
+	ToTenantID   string                `json:"to_tenant_id"`
+	State        InstanceTransferState `json:"state"`
+	IPAddress    string                `json:"ip_address,omitempty"`
+	Volumes      []string              `json:"volumes,omitempty"`
+	ExternalIPs  []string              `json:"external_ips,omitempty"`
+	Started      time.Time             `json:"started"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// VolumeBatchState is the state of the creation of a batch of volumes.
+type VolumeBatchState string
+
+const (
+	// VolumeBatchCreating is the state of a batch whose volumes are
+	// being created.
+	VolumeBatchCreating VolumeBatchState = "creating"
+
+	// VolumeBatchDone is the state of a batch whose volumes were all
+	// created.
+	VolumeBatchDone VolumeBatchState = "done"
+
+	// VolumeBatchPartial is the state of a batch for which some of the
+	// volumes could not be created.
+	VolumeBatchPartial VolumeBatchState = "partial"
+
+	// VolumeBatchFailed is the state of a batch for which none of the
+	// volumes could be created.
+	VolumeBatchFailed VolumeBatchState = "failed"
+)
+
+// VolumeBatchFailure reports the failure to create a volume of a batch.
+type VolumeBatchFailure struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// VolumeBatchStatus reports the progress of the creation of a batch of
+// volumes sharing the same specification.
+type VolumeBatchStatus struct {
+	ID       string               `json:"id"`
+	TenantID string               `json:"tenant_id"`
+	Count    int                  `json:"count"`
+	State    VolumeBatchState     `json:"state"`
+	Volumes  []string             `json:"volumes"`
+	Failures []VolumeBatchFailure `json:"failures,omitempty"`
+	Started  time.Time            `json:"started"`
+}
+
+// StackFailurePolicy decides whether the groups of a stack which depend
+// on a group whose instances did not start are launched.
+type StackFailurePolicy string
+
+const (
+	// StackAbort does not launch the groups which depend on a failed
+	// group.
+	StackAbort StackFailurePolicy = "abort"
+
+	// StackContinue launches the groups which depend on a failed group
+	// anyway.
+	StackContinue StackFailurePolicy = "continue"
+)
+
+// StackGroupState is the state of a group of instances of a stack.
+type StackGroupState string
+
+const (
+	// StackGroupWaiting is the state of a group waiting for the groups
+	// it depends on to be running.
+	StackGroupWaiting StackGroupState = "waiting"
+
+	// StackGroupStarting is the state of a group whose instances were
+	// launched but are not all running yet.
+	StackGroupStarting StackGroupState = "starting"
+
+	// StackGroupRunning is the state of a group whose instances are all
+	// running.
+	StackGroupRunning StackGroupState = "running"
+
+	// StackGroupFailed is the state of a group whose instances could not
+	// be launched or did not run before the timeout of the stack.
+	StackGroupFailed StackGroupState = "failed"
+
+	// StackGroupSkipped is the state of a group which was not launched
+	// because a group it depends on failed.
+	StackGroupSkipped StackGroupState = "skipped"
+)
+
+// StackGroupStatus reports the progress of the launch of a group of
+// instances of a stack.
+type StackGroupStatus struct {
+	Name       string          `json:"name"`
+	WorkloadID string          `json:"workload_id"`
+	DependsOn  []string        `json:"depends_on,omitempty"`
+	State      StackGroupState `json:"state"`
+	Instances  []string        `json:"instances"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// StackState is the state of the launch of a stack of instances.
+type StackState string
+
+const (
+	// StackLaunching is the state of a stack some of whose groups are
+	// waiting or starting.
+	StackLaunching StackState = "launching"
+
+	// StackDone is the state of a stack whose groups are all running.
+	StackDone StackState = "done"
+
+	// StackFailed is the state of a stack some of whose groups failed or
+	// were skipped.
+	StackFailed StackState = "failed"
+)
+
+// StackStatus reports the progress of the launch of a stack of instances.
+type StackStatus struct {
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	TenantID  string             `json:"tenant_id"`
+	State     StackState         `json:"state"`
+	OnFailure StackFailurePolicy `json:"on_failure"`
+	Timeout   int                `json:"timeout"`
+	Groups    []StackGroupStatus `json:"groups"`
+	Started   time.Time          `json:"started"`
+}
+
+// StacksResponse holds the layout for returning the stacks of a tenant in
+// response to a request.
+type StacksResponse struct {
+	Stacks []StackStatus `json:"stacks"`
+}
+
+// ImageExportState is the state of the export of an image to object
+// storage.
+type ImageExportState string
+
+const (
+	// ImageExportUploading is the state of an export whose image is
+	// being uploaded.
+	ImageExportUploading ImageExportState = "uploading"
+
+	// ImageExportDone is the state of an export whose image was
+	// uploaded.
+	ImageExportDone ImageExportState = "done"
+
+	// ImageExportFailed is the state of an export whose image could not
+	// be uploaded.
+	ImageExportFailed ImageExportState = "failed"
+)
+
+// ImageExportStatus reports the progress of the export of an image to
+// the object storage configured for the cluster.
+type ImageExportStatus struct {
+	ID       string           `json:"id"`
+	ImageID  string           `json:"image_id"`
+	TenantID string           `json:"tenant_id"`
+	URL      string           `json:"url"`
+	State    ImageExportState `json:"state"`
+	Size     uint64           `json:"size"`
+	Uploaded uint64           `json:"uploaded"`
+	Started  time.Time        `json:"started"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// ImageExportsResponse holds the layout for returning the image exports
+// in response to a request.
+type ImageExportsResponse struct {
+	Exports []ImageExportStatus `json:"exports"`
+}
+
+// ReportFormat is the format in which tenant reports are generated.
+type ReportFormat string
+
+const (
+	// ReportCSV generates a report as comma separated values, with a
+	// row per quota, usage sample and event.
+	ReportCSV ReportFormat = "csv"
+
+	// ReportJSON generates a report as a JSON TenantReport.
+	ReportJSON ReportFormat = "json"
+)
+
+// TenantReport contains the resources used by a tenant and the events
+// logged for it over the period of a report.
+type TenantReport struct {
+	TenantID   string         `json:"tenant_id"`
+	TenantName string         `json:"tenant_name"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Quotas     []QuotaDetails `json:"quotas"`
+	Usage      []CiaoUsage    `json:"usage"`
+	Events     []LogEntry     `json:"events"`
+
+	// NetworkRxGB and NetworkTxGB are the traffic received from and
+	// sent to the external network over the period, in gigabytes.
+	NetworkRxGB float64 `json:"network_rx_gb"`
+	NetworkTxGB float64 `json:"network_tx_gb"`
+}
+
+// ReportStatus describes a report schedule configured by the admin and
+// the outcome of its last run.
+type ReportStatus struct {
+	Name     string       `json:"name"`
+	Format   ReportFormat `json:"format"`
+	Interval string       `json:"interval"`
+
+	// Tenants are the tenants reported on, all the tenants if empty.
+	Tenants []string `json:"tenants,omitempty"`
+
+	LastRun   time.Time `json:"last_run"`
+	NextRun   time.Time `json:"next_run"`
+	Delivered int       `json:"delivered"`
+	Errors    []string  `json:"errors,omitempty"`
+}
+
+// ReportsResponse holds the layout for returning the report schedules in
+// response to a request.
+type ReportsResponse struct {
+	Reports []ReportStatus `json:"reports"`
+}
+
+// StoragePool describes a named storage pool in which volumes can be
+// created, along with the capacity reported by its storage driver.
+type StoragePool struct {
+	Name     string               `json:"name"`
+	CephPool string               `json:"ceph_pool"`
+	Default  bool                 `json:"default"`
+	Capacity storage.PoolCapacity `json:"capacity"`
+
+	// Error is the reason why the capacity of the pool could not be
+	// retrieved.
+	Error string `json:"error,omitempty"`
+}
+
+// StoragePoolsResponse holds the layout for returning the storage pools
+// in response to a request.
+type StoragePoolsResponse struct {
+	Pools []StoragePool `json:"pools"`
+}
+
+// DestructiveOperation is a cluster wide destructive admin operation,
+// which must be confirmed with the token returned by its dry run.
+type DestructiveOperation string
+
+const (
+	// DeleteTenantOperation is the deletion of a tenant along with all
+	// its instances, volumes, images and workloads.
+	DeleteTenantOperation DestructiveOperation = "delete_tenant"
+
+	// DeletePoolOperation is the deletion of an external IP pool.
+	DeletePoolOperation DestructiveOperation = "delete_pool"
+
+	// DeleteNodeOperation is the removal of a node from the cluster
+	// once its instances are evacuated.
+	DeleteNodeOperation DestructiveOperation = "delete_node"
+
+	// DrainPoolOperation is the unmapping of all the mapped addresses
+	// of an external IP pool.
+	DrainPoolOperation DestructiveOperation = "drain_pool"
+)
+
+// DryRunResult describes what a destructive operation would remove. The
+// operation is carried out only if requested with the token of the
+// result before it expires.
+type DryRunResult struct {
+	Operation DestructiveOperation `json:"operation"`
+	Target    string               `json:"target"`
+	Impact    map[string]int       `json:"impact"`
+	Token     string               `json:"token"`
+	Expires   time.Time            `json:"expires"`
+}
+
+// ConfigType is the type of the value of a cluster configuration setting.
+type ConfigType string
+
+const (
+	// ConfigInt settings hold an integer within the bounds of their
+	// schema.
+	ConfigInt ConfigType = "int"
+
+	// ConfigBool settings hold true or false.
+	ConfigBool ConfigType = "bool"
+
+	// ConfigString settings hold one of the values listed by their
+	// schema, or any string if it lists none.
+	ConfigString ConfigType = "string"
+
+	// ConfigWindows settings hold a list of maintenance windows, as
+	// described by MaintenanceWindows.
+	ConfigWindows ConfigType = "maintenance_windows"
+)
+
+// ConfigSchema describes the values a cluster configuration setting can
+// take.
+type ConfigSchema struct {
+	Type        ConfigType `json:"type"`
+	Default     string     `json:"default"`
+	Min         int        `json:"min,omitempty"`
+	Max         int        `json:"max,omitempty"`
+	Values      []string   `json:"values,omitempty"`
+	Description string     `json:"description"`
+}
+
+// Validate returns ErrInvalidConfigValue if value does not match the
+// schema.
+func (s ConfigSchema) Validate(value string) error {
+	switch s.Type {
+	case ConfigInt:
+		v, err := strconv.Atoi(value)
+		if err != nil || v < s.Min || (s.Max > s.Min && v > s.Max) {
+			return ErrInvalidConfigValue
+		}
+	case ConfigBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return ErrInvalidConfigValue
+		}
+	case ConfigWindows:
+		if err := MaintenanceWindows(value).Validate(); err != nil {
+			return ErrInvalidConfigValue
+		}
+	case ConfigString:
+		if len(s.Values) == 0 {
+			return nil
+		}
+		for _, v := range s.Values {
+			if v == value {
+				return nil
+			}
+		}
+		return ErrInvalidConfigValue
+	default:
+		return ErrInvalidConfigValue
+	}
+
+	return nil
+}
+
+// ConfigSetting is a runtime tunable setting of the cluster, shared by
+// the controllers using the same datastore.
+type ConfigSetting struct {
+	Key    string       `json:"key"`
+	Value  string       `json:"value"`
+	Schema ConfigSchema `json:"schema"`
+}
+
+// ConfigResponse holds the layout for returning the cluster configuration
+// in response to a request.
+type ConfigResponse struct {
+	Settings []ConfigSetting `json:"settings"`
+}
+
+// ConfigUpdateRequest holds the layout for changing a setting of the
+// cluster configuration. An empty value restores the default value.
+type ConfigUpdateRequest struct {
+	Value string `json:"value"`
+}
+
+// FrameReplayResult reports the replay of a capture of the SSNTP frames
+// received by a controller.
+type FrameReplayResult struct {
+	Replayed int `json:"replayed"`
+	Skipped  int `json:"skipped"`
+}
+
+// FaultConfig describes the faults injected by the controller to test
+// its recovery code paths.
+type FaultConfig struct {
+	// FrameDropPercent is the percentage of received SSNTP frames
+	// which are dropped.
+	FrameDropPercent int `json:"frame_drop_percent"`
+
+	// WriteDelayMS is the delay, in milliseconds, of the writes to the
+	// persistent datastore.
+	WriteDelayMS int `json:"write_delay_ms"`
+
+	// LaunchFailurePercent is the percentage of instance launches
+	// failed with a StartFailure instead of being sent to the cluster.
+	LaunchFailurePercent int `json:"launch_failure_percent"`
+}
+
+// LockMetrics reports how long the users of a datastore lock waited to
+// acquire it.
+type LockMetrics struct {
+	Name        string `json:"name"`
+	Writes      uint64 `json:"writes"`        // number of write acquisitions
+	Reads       uint64 `json:"reads"`         // number of read acquisitions
+	WriteWaitNS int64  `json:"write_wait_ns"` // total wait of the writers
+	ReadWaitNS  int64  `json:"read_wait_ns"`  // total wait of the readers
+	MaxWaitNS   int64  `json:"max_wait_ns"`   // longest wait of any user
+}
+
+// CacheMetrics reports the lookups of a datastore cache.
+type CacheMetrics struct {
+	Name   string `json:"name"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// DatastoreMetrics reports the lock contention and cache hit rates of
+// the datastore.
+type DatastoreMetrics struct {
+	Locks  []LockMetrics  `json:"locks"`
+	Caches []CacheMetrics `json:"caches"`
+}
+
+// ControllerMetrics is the response to a metrics request.
+type ControllerMetrics struct {
+	Datastore DatastoreMetrics `json:"datastore"`
+}
+
+// PatchFormat is the format of a JSON patch document, as given by the
+// content type of the request carrying it.
+type PatchFormat string
+
+const (
+	// MergePatch is a JSON merge patch (RFC 7386).
+	MergePatch PatchFormat = "merge-patch+json"
+
+	// JSONPatch is a list of JSON patch operations (RFC 6902).
+	JSONPatch PatchFormat = "json-patch+json"
+)
+
+// PatchError is returned when a patch cannot be applied to an object.
+type PatchError struct {
+	// Index of the failed operation of a JSON patch, -1 when the
+	// error is not specific to an operation.
+	Index int
+
+	Op     string
+	Path   string
+	Reason string
+}
+
+func (e *PatchError) Error() string {
+	if e.Index < 0 {
+		if e.Path != "" {
+			return fmt.Sprintf("Invalid patch of %s: %s", e.Path, e.Reason)
+		}
+		return fmt.Sprintf("Invalid patch: %s", e.Reason)
+	}
+
+	return fmt.Sprintf("Patch operation %d (%s %s) failed: %s", e.Index, e.Op, e.Path, e.Reason)
+}
+
+// QuotaError is returned when starting an instance would exceed the
+// quotas of its tenant.  Reason names the exceeded resources.
+type QuotaError struct {
+	Reason string
+}
+
+func (e *QuotaError) Error() string {
+	return e.Reason
+}
+
+// InstanceLimitError is returned when a request asks for more instances
+// than a single request is allowed to start.
+type InstanceLimitError struct {
+	Requested int
+	Limit     int
+}
+
+func (e *InstanceLimitError) Error() string {
+	return fmt.Sprintf("Cannot start %d instances in a single request, the limit is %d", e.Requested, e.Limit)
+}
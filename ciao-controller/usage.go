@@ -0,0 +1,35 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// rollUpTenantUsage aggregates the samples of the tenant usage histories
+// which have outlived the retention of their resolution.
+func (c *controller) rollUpTenantUsage(now time.Time) {
+	count, err := c.ds.RollUpTenantUsage(now)
+	if err != nil {
+		glog.Warningf("Error rolling up tenant usage: %v", err)
+		return
+	}
+
+	if count > 0 {
+		glog.Infof("Rolled up %d tenant usage samples", count)
+	}
+}
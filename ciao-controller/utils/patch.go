@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// ApplyPatch applies a patch document of the given format to the JSON
+// encoding of orig and decodes the result into patched. The top level
+// fields listed in immutable, by their JSON name, cannot be modified by
+// the patch. Invalid patches are reported with a *types.PatchError.
+func ApplyPatch(orig interface{}, patched interface{}, patch []byte, format types.PatchFormat, immutable ...string) error {
+	doc, err := json.Marshal(orig)
+	if err != nil {
+		return err
+	}
+
+	var result []byte
+
+	switch format {
+	case types.MergePatch:
+		result, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return &types.PatchError{Index: -1, Reason: err.Error()}
+		}
+	case types.JSONPatch:
+		result, err = applyOperations(doc, patch, immutable)
+		if err != nil {
+			return err
+		}
+	default:
+		return &types.PatchError{Index: -1, Reason: fmt.Sprintf("unsupported format %s", format)}
+	}
+
+	field, err := changedField(doc, result, immutable)
+	if err != nil {
+		return &types.PatchError{Index: -1, Reason: err.Error()}
+	}
+
+	if field != "" {
+		return &types.PatchError{Index: -1, Path: "/" + field, Reason: "field is immutable"}
+	}
+
+	err = json.Unmarshal(result, patched)
+	if err != nil {
+		return &types.PatchError{Index: -1, Reason: err.Error()}
+	}
+
+	return nil
+}
+
+func opString(op map[string]*json.RawMessage, key string) string {
+	var s string
+
+	raw := op[key]
+	if raw != nil {
+		_ = json.Unmarshal(*raw, &s)
+	}
+
+	return s
+}
+
+func immutablePath(path string, immutable []string) bool {
+	for _, field := range immutable {
+		if path == "/"+field || strings.HasPrefix(path, "/"+field+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyOperations applies the operations of a JSON patch one at a time,
+// so that the operation failing can be reported.
+func applyOperations(doc []byte, patch []byte, immutable []string) ([]byte, error) {
+	p, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, &types.PatchError{Index: -1, Reason: err.Error()}
+	}
+
+	for i, op := range p {
+		pe := &types.PatchError{
+			Index: i,
+			Op:    opString(op, "op"),
+			Path:  opString(op, "path"),
+		}
+
+		// test and copy operations only read their source, move
+		// operations also remove it.
+		modified := pe.Op != "test" && immutablePath(pe.Path, immutable)
+		if pe.Op == "move" && immutablePath(opString(op, "from"), immutable) {
+			modified = true
+		}
+
+		if modified {
+			pe.Reason = "field is immutable"
+			return nil, pe
+		}
+
+		doc, err = jsonpatch.Patch{op}.Apply(doc)
+		if err != nil {
+			pe.Reason = err.Error()
+			return nil, pe
+		}
+	}
+
+	return doc, nil
+}
+
+// changedField returns the first of the fields whose value differs
+// between the two JSON objects.
+func changedField(orig []byte, patched []byte, fields []string) (string, error) {
+	var o, p map[string]interface{}
+
+	err := json.Unmarshal(orig, &o)
+	if err != nil {
+		return "", err
+	}
+
+	err = json.Unmarshal(patched, &p)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range fields {
+		if !reflect.DeepEqual(o[f], p[f]) {
+			return f, nil
+		}
+	}
+
+	return "", nil
+}
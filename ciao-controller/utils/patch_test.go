@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+type patchDoc struct {
+	ID   string   `json:"id"`
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func TestApplyPatch(t *testing.T) {
+	orig := patchDoc{ID: "id", Name: "name", Tags: []string{"a"}}
+
+	tests := []struct {
+		patch    string
+		format   types.PatchFormat
+		expected patchDoc
+		failedOp int
+	}{
+		{`{"name":"new"}`, types.MergePatch, patchDoc{ID: "id", Name: "new", Tags: []string{"a"}}, 0},
+		{`{"id":"new"}`, types.MergePatch, patchDoc{}, -1},
+		{`[{"op":"replace","path":"/name","value":"new"},{"op":"add","path":"/tags/-","value":"b"}]`,
+			types.JSONPatch, patchDoc{ID: "id", Name: "new", Tags: []string{"a", "b"}}, 0},
+		{`[{"op":"replace","path":"/name","value":"new"},{"op":"replace","path":"/id","value":"new"}]`,
+			types.JSONPatch, patchDoc{}, 1},
+		{`[{"op":"test","path":"/name","value":"name"},{"op":"remove","path":"/missing"}]`,
+			types.JSONPatch, patchDoc{}, 1},
+		{`[{"op":"move","from":"/id","path":"/name"}]`, types.JSONPatch, patchDoc{}, 0},
+		{`[{"op":"copy","from":"/id","path":"/name"},{"op":"test","path":"/id","value":"id"}]`,
+			types.JSONPatch, patchDoc{ID: "id", Name: "id", Tags: []string{"a"}}, 0},
+		{`{"name":"new"}`, types.JSONPatch, patchDoc{}, -1},
+		{`{"name":"new"}`, types.PatchFormat("xml"), patchDoc{}, -1},
+	}
+
+	for i, test := range tests {
+		var patched patchDoc
+
+		err := ApplyPatch(orig, &patched, []byte(test.patch), test.format, "id")
+		if test.expected.ID != "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error %v", i, err)
+				continue
+			}
+
+			if patched.ID != test.expected.ID || patched.Name != test.expected.Name ||
+				len(patched.Tags) != len(test.expected.Tags) {
+				t.Errorf("test %d: expected %+v, got %+v", i, test.expected, patched)
+			}
+			continue
+		}
+
+		pe, ok := err.(*types.PatchError)
+		if !ok {
+			t.Errorf("test %d: expected a patch error, got %v", i, err)
+			continue
+		}
+
+		if pe.Index != test.failedOp {
+			t.Errorf("test %d: expected failure of operation %d, got %v", i, test.failedOp, pe)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// VerifyDatastore cross-checks the datastore caches with the persistent
+// store and looks for orphaned objects, repairing the inconsistencies
+// found if asked to.  Only one verification runs at a time.
+func (c *controller) VerifyDatastore(repair bool) (types.DatastoreVerifyResponse, error) {
+	c.verifyLock.Lock()
+	defer c.verifyLock.Unlock()
+
+	inconsistencies, err := c.ds.Verify(repair)
+	if err != nil {
+		return types.DatastoreVerifyResponse{}, err
+	}
+
+	if len(inconsistencies) > 0 {
+		glog.Warningf("Datastore verification found %d inconsistencies", len(inconsistencies))
+	}
+
+	return types.DatastoreVerifyResponse{
+		VerifiedAt:      time.Now(),
+		Repair:          repair,
+		Inconsistencies: inconsistencies,
+	}, nil
+}
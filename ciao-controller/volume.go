@@ -20,33 +20,118 @@ import (
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
 	"github.com/ciao-project/ciao/ciao-storage"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 )
 
-// CreateVolume will create a new block device and store it in the datastore.
-func (c *controller) CreateVolume(tenant string, req api.RequestedVolume) (types.Volume, error) {
+const (
+	// maxVolumeLabels is the largest number of metadata entries, and
+	// of tags, of a volume.
+	maxVolumeLabels = 64
+
+	// maxVolumeLabelLength is the longest metadata key or value, or
+	// tag, of a volume.
+	maxVolumeLabelLength = 255
+)
+
+// validateVolumeLabels checks the metadata and tags of a volume.
+func validateVolumeLabels(metadata map[string]string, tags []string) error {
+	if len(metadata) > maxVolumeLabels || len(tags) > maxVolumeLabels {
+		return types.ErrBadRequest
+	}
+
+	for k, v := range metadata {
+		if k == "" || len(k) > maxVolumeLabelLength || len(v) > maxVolumeLabelLength {
+			return types.ErrBadRequest
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		if t == "" || len(t) > maxVolumeLabelLength || seen[t] {
+			return types.ErrBadRequest
+		}
+		seen[t] = true
+	}
+
+	return nil
+}
+
+// createBlockDevice creates the block device of a new volume of a tenant
+// in a storage pool.
+func (c *controller) createBlockDevice(tenant string, pool *storagePool, req api.RequestedVolume) (storage.BlockDevice, error) {
 	var bd storage.BlockDevice
 
+	driver := pool.driver
+
+	// only volumes created from images can be cloned
+	if req.Clone && req.ImageRef == "" {
+		return storage.BlockDevice{}, types.ErrBadRequest
+	}
+
 	var err error
 	// no limits checking for now.
 	if req.ImageRef != "" {
+		if err := c.checkImageUsable(tenant, req.ImageRef); err != nil {
+			return storage.BlockDevice{}, err
+		}
+
 		// create bootable volume
-		bd, err = c.CreateBlockDeviceFromSnapshot(req.ImageRef, "ciao-image")
+		bd, err = driver.CreateBlockDeviceFromSnapshot(req.ImageRef, "ciao-image")
 		bd.Bootable = true
+
+		// unlike clones, full copies do not depend on the image
+		if err == nil && !req.Clone {
+			err = driver.FlattenBlockDevice(bd.ID)
+			if err != nil {
+				_ = driver.DeleteBlockDevice(bd.ID)
+			}
+		}
 	} else if req.SourceVolID != "" {
+		// volumes are copied within their ceph pool
+		if src, err := c.ds.GetBlockDevice(req.SourceVolID); err == nil &&
+			!sameCephPool(c.cephPool(src.Pool), pool.cephPool) {
+			return storage.BlockDevice{}, types.ErrBadRequest
+		}
+
 		// copy existing volume
-		bd, err = c.CopyBlockDevice(req.SourceVolID)
+		bd, err = driver.CopyBlockDevice(req.SourceVolID)
 	} else {
 		// create empty volume
-		bd, err = c.CreateBlockDevice("", "", req.Size)
+		bd, err = driver.CreateBlockDevice("", "", req.Size)
 	}
 
 	if err == nil && req.Size > bd.Size {
-		bd.Size, err = c.Resize(bd.ID, req.Size)
+		bd.Size, err = driver.Resize(bd.ID, req.Size)
 	}
 
+	return bd, err
+}
+
+// volumeParent returns the image on which the volume created by a request
+// depends, if any.
+func volumeParent(req api.RequestedVolume) string {
+	if req.ImageRef != "" && req.Clone {
+		return req.ImageRef
+	}
+
+	return ""
+}
+
+// CreateVolume will create a new block device and store it in the datastore.
+func (c *controller) CreateVolume(tenant string, req api.RequestedVolume) (types.Volume, error) {
+	if err := validateVolumeLabels(req.Metadata, req.Tags); err != nil {
+		return types.Volume{}, err
+	}
+
+	pool, err := c.storagePool(req.Pool)
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	bd, err := c.createBlockDevice(tenant, pool, req)
 	if err != nil {
 		return types.Volume{}, err
 	}
@@ -63,6 +148,10 @@ func (c *controller) CreateVolume(tenant string, req api.RequestedVolume) (types
 		Name:        req.Name,
 		Description: req.Description,
 		Internal:    req.Internal,
+		Metadata:    req.Metadata,
+		Tags:        req.Tags,
+		Pool:        pool.name,
+		Parent:      volumeParent(req),
 	}
 
 	// It's best to make the quota request here as we don't know the volume
@@ -77,15 +166,14 @@ func (c *controller) CreateVolume(tenant string, req api.RequestedVolume) (types
 		res := <-c.qs.Consume(tenant, resources...)
 
 		if !res.Allowed() {
-			_ = c.DeleteBlockDevice(bd.ID)
-			c.qs.Release(tenant, res.Resources()...)
+			_ = pool.driver.DeleteBlockDevice(bd.ID)
 			return types.Volume{}, api.ErrQuota
 		}
 	}
 
 	err = c.ds.AddBlockDevice(data)
 	if err != nil {
-		_ = c.DeleteBlockDevice(bd.ID)
+		_ = pool.driver.DeleteBlockDevice(bd.ID)
 		if !data.Internal {
 			c.qs.Release(tenant, resources...)
 		}
@@ -107,6 +195,10 @@ func (c *controller) DeleteVolume(tenant string, volume string) error {
 		return api.ErrVolumeOwner
 	}
 
+	if info.Protected {
+		return types.ErrVolumeProtected
+	}
+
 	// check that the block device is available.
 	if info.State != types.Available {
 		return api.ErrVolumeNotAvailable
@@ -119,7 +211,7 @@ func (c *controller) DeleteVolume(tenant string, volume string) error {
 	}
 
 	// tell the underlying storage media to remove.
-	err = c.DeleteBlockDevice(volume)
+	err = c.volumeDriver(info.Pool).DeleteBlockDevice(volume)
 	if err != nil {
 		return err
 	}
@@ -132,6 +224,23 @@ func (c *controller) DeleteVolume(tenant string, volume string) error {
 	return nil
 }
 
+// ProtectVolume sets or clears the deletion protection of a volume. A
+// protected volume cannot be deleted until its protection is cleared.
+func (c *controller) ProtectVolume(tenant string, volume string, protect bool) error {
+	info, err := c.ds.GetBlockDevice(volume)
+	if err != nil {
+		return err
+	}
+
+	if info.TenantID != tenant {
+		return api.ErrVolumeOwner
+	}
+
+	info.Protected = protect
+
+	return c.ds.UpdateBlockDevice(info)
+}
+
 func (c *controller) AttachVolume(tenant string, volume string, instance string, mountpoint string) error {
 	// get the block device information
 	info, err := c.ds.GetBlockDevice(volume)
@@ -168,6 +277,7 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 		ID:        info.ID,
 		Ephemeral: false,
 		Bootable:  false,
+		Pool:      c.cephPool(info.Pool),
 	}
 	_, err = c.ds.CreateStorageAttachment(i.ID, a)
 	if err != nil {
@@ -287,6 +397,34 @@ func (c *controller) ListVolumesDetail(tenant string) ([]types.Volume, error) {
 	return vols, nil
 }
 
+// PatchVolume updates a volume with a json merge patch or a list of json
+// patch operations. Only the name, the description, the metadata and the
+// tags of a volume can be changed.
+func (c *controller) PatchVolume(tenant string, volume string, patch []byte, format types.PatchFormat) error {
+	vol, err := c.ShowVolumeDetails(tenant, volume)
+	if err != nil {
+		return err
+	}
+
+	var patched types.Volume
+	err = utils.ApplyPatch(vol, &patched, patch, format, "id", "bootable", "boot_index",
+		"ephemeral", "local", "swap", "size", "tenant_id", "state", "created",
+		"internal", "protected", "pool", "parent")
+	if err != nil {
+		return err
+	}
+
+	err = validateVolumeLabels(patched.Metadata, patched.Tags)
+	if err != nil {
+		return err
+	}
+
+	// fields not encoded in JSON
+	patched.BlockDevice = vol.BlockDevice
+
+	return c.ds.UpdateBlockDevice(patched)
+}
+
 func (c *controller) ShowVolumeDetails(tenant string, volume string) (types.Volume, error) {
 	vol, err := c.ds.GetBlockDevice(volume)
 	if err != nil {
@@ -0,0 +1,279 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+)
+
+// maxVolumeBatch is the largest number of volumes created by a batch.
+const maxVolumeBatch = 100
+
+// volumeBatchConcurrency is the number of volumes of a batch created at
+// once.
+var volumeBatchConcurrency = 8
+
+// volumeBatchRetention is the time for which the status of a completed
+// batch can be retrieved.
+var volumeBatchRetention = 24 * time.Hour
+
+// volumeRequestSize returns the size in GiB of the volume created for a
+// request.
+func (c *controller) volumeRequestSize(req api.RequestedVolume) (int, error) {
+	size := req.Size
+
+	if req.ImageRef != "" {
+		image, err := c.ds.GetImage(req.ImageRef)
+		if err != nil {
+			return 0, err
+		}
+
+		imageSize := int((image.Size + (1 << 30) - 1) >> 30)
+		if imageSize > size {
+			size = imageSize
+		}
+	} else if req.SourceVolID != "" {
+		bd, err := c.ds.GetBlockDevice(req.SourceVolID)
+		if err != nil {
+			return 0, err
+		}
+
+		if bd.Size > size {
+			size = bd.Size
+		}
+	}
+
+	if size <= 0 {
+		return 0, types.ErrBadRequest
+	}
+
+	return size, nil
+}
+
+// CreateVolumeBatch creates count volumes from the same request. The quota
+// of the tenant is checked for the whole batch before any volume is
+// created. The volumes are created in the background and the progress of
+// the batch, including the volumes which could not be created, is
+// reported by GetVolumeBatch.
+func (c *controller) CreateVolumeBatch(tenant string, req api.RequestedVolumeBatch) (types.VolumeBatchStatus, error) {
+	if req.Count < 1 || req.Count > maxVolumeBatch {
+		return types.VolumeBatchStatus{}, types.ErrBadRequest
+	}
+
+	err := validateVolumeLabels(req.Volume.Metadata, req.Volume.Tags)
+	if err != nil {
+		return types.VolumeBatchStatus{}, err
+	}
+
+	_, err = c.storagePool(req.Volume.Pool)
+	if err != nil {
+		return types.VolumeBatchStatus{}, err
+	}
+
+	size, err := c.volumeRequestSize(req.Volume)
+	if err != nil {
+		return types.VolumeBatchStatus{}, err
+	}
+
+	resources := []payloads.RequestedResource{
+		{Type: payloads.Volume, Value: req.Count},
+		{Type: payloads.SharedDiskGiB, Value: req.Count * size},
+	}
+
+	res := <-c.qs.Consume(tenant, resources...)
+	if !res.Allowed() {
+		return types.VolumeBatchStatus{}, api.ErrQuota
+	}
+
+	b := &types.VolumeBatchStatus{
+		ID:       uuid.Generate().String(),
+		TenantID: tenant,
+		Count:    req.Count,
+		State:    types.VolumeBatchCreating,
+		Volumes:  []string{},
+		Started:  time.Now(),
+	}
+
+	c.volumeBatchLock.Lock()
+	defer c.volumeBatchLock.Unlock()
+
+	if c.volumeBatches == nil {
+		c.volumeBatches = make(map[string]*types.VolumeBatchStatus)
+	}
+
+	for ID, old := range c.volumeBatches {
+		if old.State != types.VolumeBatchCreating && time.Since(old.Started) > volumeBatchRetention {
+			delete(c.volumeBatches, ID)
+		}
+	}
+
+	c.volumeBatches[b.ID] = b
+
+	go c.createVolumeBatch(b.ID, tenant, req, size)
+
+	return copyVolumeBatch(b), nil
+}
+
+// GetVolumeBatch returns the progress of the creation of a batch of
+// volumes.
+func (c *controller) GetVolumeBatch(tenant string, batchID string) (types.VolumeBatchStatus, error) {
+	c.volumeBatchLock.Lock()
+	defer c.volumeBatchLock.Unlock()
+
+	b, ok := c.volumeBatches[batchID]
+	if !ok || b.TenantID != tenant {
+		return types.VolumeBatchStatus{}, types.ErrVolumeBatchNotFound
+	}
+
+	return copyVolumeBatch(b), nil
+}
+
+func copyVolumeBatch(b *types.VolumeBatchStatus) types.VolumeBatchStatus {
+	cb := *b
+	cb.Volumes = append([]string{}, b.Volumes...)
+	if b.Failures != nil {
+		cb.Failures = append([]types.VolumeBatchFailure{}, b.Failures...)
+	}
+
+	return cb
+}
+
+func (c *controller) createVolumeBatch(batchID string, tenant string, req api.RequestedVolumeBatch, size int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, volumeBatchConcurrency)
+
+	for i := 0; i < req.Count; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(index int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ID, err := c.createBatchVolume(tenant, req.Volume, index, size)
+
+			c.volumeBatchLock.Lock()
+			defer c.volumeBatchLock.Unlock()
+
+			b := c.volumeBatches[batchID]
+			if err != nil {
+				glog.Warningf("Error creating volume %d of batch %s: %v", index, batchID, err)
+				b.Failures = append(b.Failures, types.VolumeBatchFailure{
+					Index: index,
+					Error: err.Error(),
+				})
+				return
+			}
+			b.Volumes = append(b.Volumes, ID)
+		}(i)
+	}
+
+	wg.Wait()
+
+	c.volumeBatchLock.Lock()
+	defer c.volumeBatchLock.Unlock()
+
+	b := c.volumeBatches[batchID]
+	sort.Slice(b.Failures, func(i, j int) bool {
+		return b.Failures[i].Index < b.Failures[j].Index
+	})
+
+	switch len(b.Failures) {
+	case 0:
+		b.State = types.VolumeBatchDone
+	case b.Count:
+		b.State = types.VolumeBatchFailed
+	default:
+		b.State = types.VolumeBatchPartial
+	}
+
+	glog.Infof("Created %d of the %d volumes of batch %s", len(b.Volumes), b.Count, batchID)
+}
+
+// createBatchVolume creates a volume of a batch, for which the quota of
+// the tenant has been consumed when the batch was created. The index of
+// the volume in the batch is appended to its name.
+func (c *controller) createBatchVolume(tenant string, req api.RequestedVolume, index int, size int) (string, error) {
+	reserved := []payloads.RequestedResource{
+		{Type: payloads.Volume, Value: 1},
+		{Type: payloads.SharedDiskGiB, Value: size},
+	}
+
+	pool, err := c.storagePool(req.Pool)
+	if err != nil {
+		c.qs.Release(tenant, reserved...)
+		return "", err
+	}
+
+	bd, err := c.createBlockDevice(tenant, pool, req)
+	if err != nil {
+		c.qs.Release(tenant, reserved...)
+		return "", err
+	}
+
+	// the size of volumes created from images is only known once
+	// created
+	if bd.Size > size {
+		extra := payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: bd.Size - size}
+		res := <-c.qs.Consume(tenant, extra)
+		if !res.Allowed() {
+			c.qs.Release(tenant, reserved...)
+			_ = pool.driver.DeleteBlockDevice(bd.ID)
+			return "", api.ErrQuota
+		}
+	} else if bd.Size < size {
+		c.qs.Release(tenant, payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: size - bd.Size})
+	}
+
+	name := req.Name
+	if name != "" {
+		name = fmt.Sprintf("%s-%d", name, index)
+	}
+
+	data := types.Volume{
+		BlockDevice: bd,
+		CreateTime:  time.Now(),
+		TenantID:    tenant,
+		State:       types.Available,
+		Name:        name,
+		Description: req.Description,
+		Metadata:    req.Metadata,
+		Tags:        req.Tags,
+		Pool:        pool.name,
+		Parent:      volumeParent(req),
+	}
+
+	err = c.ds.AddBlockDevice(data)
+	if err != nil {
+		_ = pool.driver.DeleteBlockDevice(bd.ID)
+		c.qs.Release(tenant,
+			payloads.RequestedResource{Type: payloads.Volume, Value: 1},
+			payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: bd.Size})
+		return "", err
+	}
+
+	return bd.ID, nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+func waitForVolumeBatch(t *testing.T, tenantID string, batchID string) types.VolumeBatchStatus {
+	for i := 0; i < 100; i++ {
+		b, err := ctl.GetVolumeBatch(tenantID, batchID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.State != types.VolumeBatchCreating {
+			return b
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("Batch %s still creating volumes", batchID)
+	return types.VolumeBatchStatus{}
+}
+
+func TestCreateVolumeBatch(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := api.RequestedVolumeBatch{
+		Count: 5,
+		Volume: api.RequestedVolume{
+			Size: 2,
+			Name: "data",
+		},
+	}
+
+	b, err := ctl.CreateVolumeBatch(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Count != req.Count || b.TenantID != tenant.ID {
+		t.Fatalf("Incorrect batch returned: %+v", b)
+	}
+
+	b = waitForVolumeBatch(t, tenant.ID, b.ID)
+	if b.State != types.VolumeBatchDone || len(b.Volumes) != req.Count || len(b.Failures) != 0 {
+		t.Fatalf("Batch not completed: %+v", b)
+	}
+
+	names := make(map[string]bool)
+	for _, ID := range b.Volumes {
+		vol, err := ctl.ShowVolumeDetails(tenant.ID, ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if vol.Size != 2 || vol.State != types.Available {
+			t.Fatalf("Incorrect volume created: %+v", vol)
+		}
+		names[vol.Name] = true
+	}
+
+	if len(names) != req.Count {
+		t.Fatalf("Expected %d volume names, got %v", req.Count, names)
+	}
+
+	_, err = ctl.GetVolumeBatch("other-tenant", b.ID)
+	if err != types.ErrVolumeBatchNotFound {
+		t.Fatalf("Expected %v, got %v", types.ErrVolumeBatchNotFound, err)
+	}
+}
+
+func TestCreateVolumeBatchInvalid(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, count := range []int{0, maxVolumeBatch + 1} {
+		req := api.RequestedVolumeBatch{
+			Count:  count,
+			Volume: api.RequestedVolume{Size: 1},
+		}
+
+		_, err = ctl.CreateVolumeBatch(tenant.ID, req)
+		if err != types.ErrBadRequest {
+			t.Fatalf("Expected %v for %d volumes, got %v", types.ErrBadRequest, count, err)
+		}
+	}
+}
+
+func TestCreateVolumeBatchQuota(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctl.qs.Update(tenant.ID, []types.QuotaDetails{{Name: "tenant-volumes-quota", Value: 3}})
+	defer ctl.qs.Update(tenant.ID, []types.QuotaDetails{{Name: "tenant-volumes-quota", Value: -1}})
+
+	req := api.RequestedVolumeBatch{
+		Count:  4,
+		Volume: api.RequestedVolume{Size: 1},
+	}
+
+	_, err = ctl.CreateVolumeBatch(tenant.ID, req)
+	if err != api.ErrQuota {
+		t.Fatalf("Expected %v, got %v", api.ErrQuota, err)
+	}
+
+	vols, err := ctl.ds.GetBlockDevices(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vols) != 0 {
+		t.Fatalf("Expected no volume created, got %d", len(vols))
+	}
+
+	req.Count = 3
+	b, err := ctl.CreateVolumeBatch(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = waitForVolumeBatch(t, tenant.ID, b.ID)
+	if b.State != types.VolumeBatchDone {
+		t.Fatalf("Batch not completed: %+v", b)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// volumeCheckPolicy selects what the volume checker does with the
+// mismatches it finds. Mismatches are always logged.
+type volumeCheckPolicy struct {
+	// resyncSize updates the size of the volume records to the
+	// size of their block device.
+	resyncSize bool
+
+	// markError moves the available volumes whose block device is
+	// missing to the error state.
+	markError bool
+}
+
+// parseVolumeCheckPolicy parses a comma separated list of volume check
+// policies.
+func parseVolumeCheckPolicy(s string) (volumeCheckPolicy, error) {
+	var p volumeCheckPolicy
+
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "", "none":
+		case "resync_size":
+			p.resyncSize = true
+		case "mark_error":
+			p.markError = true
+		default:
+			return p, fmt.Errorf("Unknown volume check policy %q", v)
+		}
+	}
+
+	return p, nil
+}
+
+// findVolumeMismatches compares the volume records of the datastore with
+// the block devices known to the storage driver.
+func (c *controller) findVolumeMismatches() ([]types.VolumeMismatch, error) {
+	devices, err := c.ListBlockDevices()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing block devices")
+	}
+
+	sizes := make(map[string]int, len(devices))
+	for _, d := range devices {
+		sizes[d.ID] = d.Size
+	}
+
+	mismatches := []types.VolumeMismatch{}
+	for _, v := range c.ds.GetAllBlockDevices() {
+		size, ok := sizes[v.ID]
+		if !ok {
+			mismatches = append(mismatches, types.VolumeMismatch{
+				VolumeID: v.ID,
+				TenantID: v.TenantID,
+				Kind:     types.VolumeMissing,
+				Size:     v.Size,
+			})
+			continue
+		}
+
+		if v.Size == 0 || v.Size == size {
+			continue
+		}
+
+		mismatches = append(mismatches, types.VolumeMismatch{
+			VolumeID:   v.ID,
+			TenantID:   v.TenantID,
+			Kind:       types.VolumeSize,
+			Size:       v.Size,
+			ActualSize: size,
+		})
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].VolumeID < mismatches[j].VolumeID
+	})
+
+	return mismatches, nil
+}
+
+func (c *controller) fixVolumeMismatch(m types.VolumeMismatch, policy volumeCheckPolicy) error {
+	v, err := c.ds.GetBlockDevice(m.VolumeID)
+	if err != nil {
+		return err
+	}
+
+	switch m.Kind {
+	case types.VolumeSize:
+		if !policy.resyncSize {
+			return nil
+		}
+		v.Size = m.ActualSize
+	case types.VolumeMissing:
+		// Volumes which are attached or being attached are left to
+		// the instance they belong to.
+		if !policy.markError || v.State != types.Available {
+			return nil
+		}
+		v.State = types.Error
+	default:
+		return nil
+	}
+
+	return errors.Wrap(c.ds.UpdateBlockDevice(v), "Error updating volume")
+}
+
+// checkVolumes looks for mismatches between the volume records and the
+// block devices, applies the policy to them and keeps the result for the
+// admin API.
+func (c *controller) checkVolumes(policy volumeCheckPolicy) error {
+	mismatches, err := c.findVolumeMismatches()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mismatches {
+		switch m.Kind {
+		case types.VolumeMissing:
+			glog.Warningf("Block device of volume %s is missing", m.VolumeID)
+		case types.VolumeSize:
+			glog.Warningf("Volume %s is %d GiB but its block device is %d GiB", m.VolumeID, m.Size, m.ActualSize)
+		}
+
+		if err := c.fixVolumeMismatch(m, policy); err != nil {
+			glog.Warningf("Error fixing volume %s: %v", m.VolumeID, err)
+		}
+	}
+
+	c.volumeCheckLock.Lock()
+	c.volumeCheck = types.VolumeCheckResponse{
+		CheckedAt:  time.Now(),
+		Mismatches: mismatches,
+	}
+	c.volumeCheckLock.Unlock()
+
+	return nil
+}
+
+// ListVolumeMismatches returns the result of the last volume check,
+// checking the volumes first if they have never been checked.
+func (c *controller) ListVolumeMismatches() (types.VolumeCheckResponse, error) {
+	c.volumeCheckLock.Lock()
+	res := c.volumeCheck
+	c.volumeCheckLock.Unlock()
+
+	if !res.CheckedAt.IsZero() {
+		return res, nil
+	}
+
+	mismatches, err := c.findVolumeMismatches()
+	if err != nil {
+		return res, err
+	}
+
+	return types.VolumeCheckResponse{
+		CheckedAt:  time.Now(),
+		Mismatches: mismatches,
+	}, nil
+}
+
+// startVolumeChecker periodically checks the volumes until
+// stopVolumeChecker is called.
+func (c *controller) startVolumeChecker(interval time.Duration, policy volumeCheckPolicy) {
+	c.volumeCheckStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.checkVolumes(policy); err != nil {
+					glog.Warningf("Error checking volumes: %v", err)
+				}
+			case <-c.volumeCheckStop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopVolumeChecker() {
+	if c.volumeCheckStop != nil {
+		close(c.volumeCheckStop)
+		c.volumeCheckStop = nil
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/uuid"
+)
+
+type listingDriver struct {
+	storage.NoopDriver
+	devices []storage.BlockDevice
+}
+
+func (d *listingDriver) ListBlockDevices() ([]storage.BlockDevice, error) {
+	return d.devices, nil
+}
+
+func TestParseVolumeCheckPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected volumeCheckPolicy
+		valid    bool
+	}{
+		{"", volumeCheckPolicy{}, true},
+		{"none", volumeCheckPolicy{}, true},
+		{"resync_size", volumeCheckPolicy{resyncSize: true}, true},
+		{"mark_error", volumeCheckPolicy{markError: true}, true},
+		{"resync_size, mark_error", volumeCheckPolicy{resyncSize: true, markError: true}, true},
+		{"delete", volumeCheckPolicy{}, false},
+	}
+
+	for _, test := range tests {
+		p, err := parseVolumeCheckPolicy(test.policy)
+		if (err == nil) != test.valid {
+			t.Errorf("Policy %q: unexpected error %v", test.policy, err)
+			continue
+		}
+
+		if test.valid && p != test.expected {
+			t.Errorf("Policy %q: expected %+v, got %+v", test.policy, test.expected, p)
+		}
+	}
+}
+
+func TestCheckVolumes(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := &listingDriver{}
+	for _, v := range ctl.ds.GetAllBlockDevices() {
+		driver.devices = append(driver.devices, v.BlockDevice)
+	}
+
+	volumes := []types.Volume{
+		{BlockDevice: storage.BlockDevice{ID: uuid.Generate().String(), Size: 10}},
+		{BlockDevice: storage.BlockDevice{ID: uuid.Generate().String(), Size: 10}},
+		{BlockDevice: storage.BlockDevice{ID: uuid.Generate().String(), Size: 10}},
+	}
+
+	for i := range volumes {
+		volumes[i].TenantID = tenant.ID
+		volumes[i].State = types.Available
+		volumes[i].CreateTime = time.Now()
+
+		err = ctl.ds.AddBlockDevice(volumes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	driver.devices = append(driver.devices,
+		storage.BlockDevice{ID: volumes[0].ID, Size: 10},
+		storage.BlockDevice{ID: volumes[1].ID, Size: 20})
+
+	saved := ctl.BlockDriver
+	ctl.BlockDriver = driver
+	defer func() {
+		ctl.BlockDriver = saved
+	}()
+
+	err = ctl.checkVolumes(volumeCheckPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ctl.ListVolumeMismatches()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.CheckedAt.IsZero() {
+		t.Error("Check time not set")
+	}
+
+	kinds := make(map[string]types.VolumeMismatchKind)
+	for _, m := range res.Mismatches {
+		kinds[m.VolumeID] = m.Kind
+	}
+
+	if _, ok := kinds[volumes[0].ID]; ok {
+		t.Errorf("Unexpected mismatch for volume %s", volumes[0].ID)
+	}
+
+	if kinds[volumes[1].ID] != types.VolumeSize {
+		t.Errorf("Size mismatch not found for volume %s", volumes[1].ID)
+	}
+
+	if kinds[volumes[2].ID] != types.VolumeMissing {
+		t.Errorf("Missing volume %s not found", volumes[2].ID)
+	}
+
+	err = ctl.checkVolumes(volumeCheckPolicy{resyncSize: true, markError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := ctl.ds.GetBlockDevice(volumes[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Size != 20 {
+		t.Errorf("Volume size not resynced: %d", v.Size)
+	}
+
+	v, err = ctl.ds.GetBlockDevice(volumes[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.State != types.Error {
+		t.Errorf("Missing volume not marked in error: %s", v.State)
+	}
+}
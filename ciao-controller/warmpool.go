@@ -0,0 +1,218 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/golang/glog"
+)
+
+var warmPoolInterval = flag.Duration("warm_pool_interval", time.Minute, "interval between refills of the tenant warm pools, 0 to disable")
+
+// warmInstances returns the warm instances of a workload of a tenant.
+func (c *controller) warmInstances(tenantID string, workloadID string) ([]*types.Instance, error) {
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var warm []*types.Instance
+	for _, i := range instances {
+		if i.Warm && i.WorkloadID == workloadID {
+			warm = append(warm, i)
+		}
+	}
+
+	return warm, nil
+}
+
+// warmCandidates returns the IDs of the running warm instances a launch
+// request can claim, at most one per instance requested.
+func (c *controller) warmCandidates(w types.WorkloadRequest) []string {
+	if w.Warm || w.Subnet != "" {
+		return nil
+	}
+
+	instances, err := c.warmInstances(w.TenantID, w.WorkloadID)
+	if err != nil {
+		glog.Warningf("Error getting warm instances of tenant %s: %v", w.TenantID, err)
+		return nil
+	}
+
+	var ids []string
+	for _, i := range instances {
+		if len(ids) == w.Instances {
+			break
+		}
+		if i.State == payloads.Running {
+			ids = append(ids, i.ID)
+		}
+	}
+
+	return ids
+}
+
+// claimWarmInstances claims the warm instances of a launch request, the
+// first ids of the request.  It returns the claimed instances, indexed as
+// the ids.  The warm instances claimed in the meantime by another request
+// are replaced by new instances, whose id and name are updated.
+func (c *controller) claimWarmInstances(w types.WorkloadRequest, warm int, ids []string, names []string) []*types.Instance {
+	claimed := make([]*types.Instance, len(ids))
+
+	for k := 0; k < warm; k++ {
+		err := c.ds.ClaimInstance(ids[k], names[k], w.TraceLabel)
+		if err == nil {
+			claimed[k], err = c.ds.GetInstance(ids[k])
+		}
+		if err != nil {
+			glog.Warningf("Unable to claim warm instance %s: %v", ids[k], err)
+			ids[k] = uuid.Generate().String()
+			names[k] = instanceName(w.Name, k, len(ids), ids[k])
+			claimed[k] = nil
+			continue
+		}
+
+		glog.Infof("Warm instance %s claimed by tenant %s", ids[k], w.TenantID)
+
+		if names[k] != "" {
+			go c.repersonalizeInstance(ids[k])
+		}
+	}
+
+	if warm > 0 {
+		go c.refillWarmPool(w.TenantID, w.WorkloadID)
+	}
+
+	return claimed
+}
+
+// repersonalizeInstance restarts a claimed warm instance, so that
+// cloud-init runs again with the metadata of the request which claimed
+// it.
+func (c *controller) repersonalizeInstance(instanceID string) {
+	err := c.stopInstanceSync(instanceID)
+	if err == nil {
+		err = c.restartInstance(instanceID)
+	}
+	if err != nil {
+		glog.Warningf("Error restarting claimed warm instance %s: %v", instanceID, err)
+	}
+}
+
+// refillWarmPool launches the warm instances missing from the warm pool of
+// a workload of a tenant, or deletes those in excess.
+func (c *controller) refillWarmPool(tenantID string, workloadID string) {
+	c.warmPoolLock.Lock()
+	defer c.warmPoolLock.Unlock()
+
+	if c.tenantResubnetting(tenantID) {
+		return
+	}
+
+	tenant, err := c.ds.GetTenant(tenantID)
+	if err != nil || tenant == nil {
+		return
+	}
+
+	instances, err := c.warmInstances(tenantID, workloadID)
+	if err != nil {
+		glog.Warningf("Error getting warm instances of tenant %s: %v", tenantID, err)
+		return
+	}
+
+	size := tenant.WarmPools[workloadID]
+	if len(instances) >= size {
+		for _, i := range instances[size:] {
+			if err := c.deleteInstance(i.ID); err != nil {
+				glog.Warningf("Error deleting warm instance %s: %v", i.ID, err)
+			}
+		}
+		return
+	}
+
+	_, err = c.startWorkload(types.WorkloadRequest{
+		WorkloadID: workloadID,
+		TenantID:   tenantID,
+		Instances:  size - len(instances),
+		Warm:       true,
+	})
+	if err != nil {
+		glog.Warningf("Error refilling warm pool of workload %s of tenant %s: %v", workloadID, tenantID, err)
+	}
+}
+
+// refillWarmPools refills the warm pools of all the tenants, including the
+// pools whose size was set to 0 or removed but which still hold warm
+// instances.
+func (c *controller) refillWarmPools() {
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		glog.Warningf("Error getting tenants: %v", err)
+		return
+	}
+
+	for _, t := range tenants {
+		workloads := make(map[string]bool)
+		for workloadID := range t.WarmPools {
+			workloads[workloadID] = true
+		}
+
+		instances, err := c.ds.GetAllInstancesFromTenant(t.ID)
+		if err != nil {
+			continue
+		}
+		for _, i := range instances {
+			if i.Warm {
+				workloads[i.WorkloadID] = true
+			}
+		}
+
+		for workloadID := range workloads {
+			c.refillWarmPool(t.ID, workloadID)
+		}
+	}
+}
+
+// startWarmPools periodically refills the warm pools until stopWarmPools
+// is called.
+func (c *controller) startWarmPools(interval time.Duration) {
+	c.warmPoolStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.refillWarmPools()
+			case <-c.warmPoolStop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopWarmPools() {
+	if c.warmPoolStop != nil {
+		close(c.warmPoolStop)
+		c.warmPoolStop = nil
+	}
+}
@@ -15,9 +15,13 @@
 package main
 
 import (
+	"regexp"
+	"strings"
+
 	"github.com/golang/glog"
 
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/ciao-project/ciao/ciao-controller/utils"
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/uuid"
 )
@@ -33,6 +37,156 @@ func validateVMWorkload(req *types.Workload) error {
 		return types.ErrBadRequest
 	}
 
+	err := validateCPURequirements(&req.Requirements)
+	if err != nil {
+		return err
+	}
+
+	err = validateHotAddRequirements(&req.Requirements)
+	if err != nil {
+		return err
+	}
+
+	return validateDeviceModels(req.Devices)
+}
+
+// hotAddMemAlignMB is the granularity, in MiB, at which memory can be hot
+// added to instances.  It matches the size of the memory sections the
+// guest kernels online.
+const hotAddMemAlignMB = 128
+
+// validateHotAddRequirements checks that the vCPUs and memory up to which
+// the instances of a workload can grow leave room to hot add resources.
+func validateHotAddRequirements(req *payloads.WorkloadRequirements) error {
+	if req.MaxVCPUs < 0 || req.MaxMemMB < 0 {
+		return types.ErrBadRequest
+	}
+
+	if req.MaxVCPUs > 0 && req.MaxVCPUs < req.VCPUs {
+		return types.ErrBadRequest
+	}
+
+	if req.MaxMemMB > 0 &&
+		(req.MaxMemMB < req.MemMB || (req.MaxMemMB-req.MemMB)%hotAddMemAlignMB != 0) {
+		return types.ErrBadRequest
+	}
+
+	return nil
+}
+
+// validateCPURequirements checks that the CPU model and features of a
+// workload are well formed.  Whether a model exists is only known to the
+// nodes able to run it.
+func validateCPURequirements(req *payloads.WorkloadRequirements) error {
+	if req.CPUModel != "" {
+		r := regexp.MustCompile("^[a-zA-Z0-9._-]{1,64}$")
+		if !r.MatchString(req.CPUModel) {
+			return types.ErrBadRequest
+		}
+	}
+
+	r := regexp.MustCompile("^[+-][a-zA-Z0-9._-]{1,64}$")
+	for _, f := range req.CPUFeatures {
+		if !r.MatchString(f) {
+			return types.ErrBadRequest
+		}
+	}
+
+	return nil
+}
+
+func validateDeviceModels(d payloads.DeviceModels) error {
+	switch d.Disk {
+	case "", payloads.VirtioBlk, payloads.VirtioSCSI:
+	default:
+		return types.ErrBadRequest
+	}
+
+	switch d.NIC {
+	case "", payloads.VirtioNet, payloads.E1000:
+	default:
+		return types.ErrBadRequest
+	}
+
+	switch d.Machine {
+	case "", payloads.MachinePC, payloads.MachineQ35:
+	default:
+		return types.ErrBadRequest
+	}
+
+	return nil
+}
+
+// Default parameters of the health checks of workloads.
+const (
+	defaultHealthCheckInterval  = 10
+	defaultHealthCheckTimeout   = 5
+	defaultHealthCheckThreshold = 3
+)
+
+// validateHealthCheck checks the health check of a workload and sets the
+// defaults of its unset parameters.
+func validateHealthCheck(hc *payloads.HealthCheck) error {
+	if hc == nil {
+		return nil
+	}
+
+	switch hc.Protocol {
+	case payloads.HealthCheckTCP:
+		if hc.Path != "" {
+			return types.ErrBadRequest
+		}
+	case payloads.HealthCheckHTTP:
+		if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+			return types.ErrBadRequest
+		}
+	default:
+		return types.ErrBadRequest
+	}
+
+	if hc.Port <= 0 || hc.Port > 65535 || hc.Interval < 0 || hc.Timeout < 0 ||
+		hc.UnhealthyThreshold < 0 {
+		return types.ErrBadRequest
+	}
+
+	if hc.Interval == 0 {
+		hc.Interval = defaultHealthCheckInterval
+	}
+
+	if hc.Timeout == 0 {
+		hc.Timeout = defaultHealthCheckTimeout
+	}
+
+	if hc.UnhealthyThreshold == 0 {
+		hc.UnhealthyThreshold = defaultHealthCheckThreshold
+	}
+
+	if hc.Timeout > hc.Interval {
+		return types.ErrBadRequest
+	}
+
+	return nil
+}
+
+// validateLifetime checks the lifetime policy of a workload and sets its
+// default action.
+func validateLifetime(p *types.LifetimePolicy) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.MaxAgeDays <= 0 {
+		return types.ErrBadRequest
+	}
+
+	switch p.Action {
+	case "":
+		p.Action = types.LifetimeNotify
+	case types.LifetimeNotify, types.LifetimeRecycle:
+	default:
+		return types.ErrBadRequest
+	}
+
 	return nil
 }
 
@@ -43,6 +197,22 @@ func validateContainerWorkload(req *types.Workload) error {
 		return types.ErrBadRequest
 	}
 
+	// containers are configured by docker, not by a config drive,
+	// and do not emulate devices.
+	if req.StaticNetwork || req.Devices != (payloads.DeviceModels{}) {
+		return types.ErrBadRequest
+	}
+
+	// nor do they have a choice of CPU model
+	if req.Requirements.CPUModel != "" || len(req.Requirements.CPUFeatures) > 0 {
+		return types.ErrBadRequest
+	}
+
+	// nor can they be hot added resources
+	if req.Requirements.MaxVCPUs != 0 || req.Requirements.MaxMemMB != 0 {
+		return types.ErrBadRequest
+	}
+
 	return nil
 }
 
@@ -85,6 +255,21 @@ func (c *controller) validateWorkloadStorage(req *types.Workload) error {
 			return types.ErrBadRequest
 		}
 
+		if req.Storage[i].Size < 0 {
+			return types.ErrBadRequest
+		}
+
+		// only volumes created from images can be cloned
+		if req.Storage[i].Clone && req.Storage[i].SourceType != types.ImageService {
+			return types.ErrBadRequest
+		}
+
+		// a new empty volume needs a size
+		if req.Storage[i].ID == "" && req.Storage[i].SourceType == types.Empty &&
+			req.Storage[i].Size == 0 {
+			return types.ErrBadRequest
+		}
+
 		if req.Storage[i].ID != "" {
 			// validate that the id is at least valid
 			// uuid4.
@@ -97,6 +282,21 @@ func (c *controller) validateWorkloadStorage(req *types.Workload) error {
 			if req.Storage[i].SourceType != types.Empty {
 				return types.ErrBadRequest
 			}
+
+			// only the volumes created for an instance can be
+			// deleted when the instance is.
+			if req.Storage[i].Ephemeral {
+				return types.ErrBadRequest
+			}
+
+			// existing volumes stay in their pool
+			if req.Storage[i].Pool != "" {
+				return types.ErrBadRequest
+			}
+		}
+
+		if _, err := c.storagePool(req.Storage[i].Pool); err != nil {
+			return err
 		}
 
 		err := c.validateWorkloadStorageSourceID(&req.Storage[i], req.TenantID)
@@ -146,6 +346,18 @@ func (c *controller) validateWorkloadRequest(req *types.Workload) error {
 		}
 	}
 
+	err := validateHealthCheck(req.HealthCheck)
+	if err != nil {
+		glog.V(2).Info("Invalid workload request: invalid health check")
+		return err
+	}
+
+	err = validateLifetime(req.Lifetime)
+	if err != nil {
+		glog.V(2).Info("Invalid workload request: invalid lifetime policy")
+		return err
+	}
+
 	if req.Config == "" {
 		glog.V(2).Info("Invalid workload request: config is blank")
 		return types.ErrBadRequest
@@ -190,6 +402,37 @@ func (c *controller) DeleteWorkload(tenantID string, workloadID string) error {
 	return types.ErrWorkloadNotFound
 }
 
+// PatchWorkload updates a workload with a json merge patch or a list of
+// json patch operations. Public workloads can only be patched by admins.
+func (c *controller) PatchWorkload(tenantID string, workloadID string, patch []byte, format types.PatchFormat) error {
+	wl, err := c.ds.GetWorkload(workloadID)
+	if err != nil {
+		return err
+	}
+
+	if tenantID != "admin" && tenantID != wl.TenantID {
+		return types.ErrWorkloadNotFound
+	}
+
+	var patched types.Workload
+	err = utils.ApplyPatch(wl, &patched, patch, format, "id", "visibility")
+	if err != nil {
+		return err
+	}
+
+	// validation expects a new workload, without ID.
+	patched.ID = ""
+	err = c.validateWorkloadRequest(&patched)
+	if err != nil {
+		return err
+	}
+
+	patched.ID = wl.ID
+	patched.TenantID = wl.TenantID
+
+	return c.ds.UpdateWorkload(patched)
+}
+
 func (c *controller) ShowWorkload(tenantID string, workloadID string) (types.Workload, error) {
 	wl, err := c.ds.GetWorkload(workloadID)
 	if err != nil {
@@ -24,6 +24,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var scopes []string
+
 func createAuth(args []string) int {
 	ctx, cancelFunc := getSignalContext()
 	defer cancelFunc()
@@ -37,7 +39,7 @@ func createAuth(args []string) int {
 		fmt.Printf("No tenant specified: creating new tenant: %s\n", tenants[0])
 	}
 
-	certPath, err := deploy.CreateUserCert(ctx, username, tenants)
+	certPath, err := deploy.CreateUserCert(ctx, username, tenants, scopes)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating user certificate: %v\n", err)
 		return 1
@@ -58,4 +60,5 @@ var authCreateCmd = &cobra.Command{
 
 func init() {
 	authCmd.AddCommand(authCreateCmd)
+	authCreateCmd.Flags().StringSliceVar(&scopes, "scope", nil, "Restrict the user to API operations, e.g. instances:read or volumes:write (default to all operations)")
 }
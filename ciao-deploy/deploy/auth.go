@@ -29,10 +29,11 @@ import (
 	"path"
 	"time"
 
+	"github.com/ciao-project/ciao/service"
 	"github.com/pkg/errors"
 )
 
-func createCertTemplate(username string, tenants []string) (*x509.Certificate, error) {
+func createCertTemplate(username string, tenants []string, scopes []string) (*x509.Certificate, error) {
 	notBefore := time.Now()
 	notAfter := notBefore.Add(365 * 24 * time.Hour)
 
@@ -49,6 +50,12 @@ func createCertTemplate(username string, tenants []string) (*x509.Certificate, e
 	if len(tenants) > 0 {
 		subject.Organization = tenants
 	}
+	for _, scope := range scopes {
+		if _, err := service.ParseScope(scope); err != nil {
+			return nil, err
+		}
+		subject.OrganizationalUnit = append(subject.OrganizationalUnit, service.ScopeUnitPrefix+scope)
+	}
 
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
@@ -65,7 +72,7 @@ func createCertTemplate(username string, tenants []string) (*x509.Certificate, e
 }
 
 func populateAdminCerts(certFile, caCertFile io.Writer) error {
-	template, err := createCertTemplate("admin", []string{"admin"})
+	template, err := createCertTemplate("admin", []string{"admin"}, nil)
 	if err != nil {
 		return errors.Wrap(err, "Error creating certificate template")
 	}
@@ -208,14 +215,16 @@ func loadAdminCert() (*x509.Certificate, *rsa.PrivateKey, error) {
 	return adminCert, adminPrivKey, nil
 }
 
-// CreateUserCert creates a user certificate in current working directory
-func CreateUserCert(ctx context.Context, username string, tenants []string) (_ string, errOut error) {
+// CreateUserCert creates a user certificate in current working directory.
+// If scopes are given, the certificate only grants access to the API
+// operations they cover.
+func CreateUserCert(ctx context.Context, username string, tenants []string, scopes []string) (_ string, errOut error) {
 	adminCert, adminPrivKey, err := loadAdminCert()
 	if err != nil {
 		return "", errors.Wrap(err, "Error loading admin certificate")
 	}
 
-	template, err := createCertTemplate(username, tenants)
+	template, err := createCertTemplate(username, tenants, scopes)
 	if err != nil {
 		return "", errors.Wrap(err, "Error creating certificate template")
 	}
@@ -27,12 +27,12 @@ type attachVolumeError struct {
 	code payloads.AttachVolumeFailureReason
 }
 
-func (ave *attachVolumeError) send(conn serverConn, instance, volume string) {
+func (ave *attachVolumeError) send(conn serverConn, instance, volume, commandID string) {
 	if !conn.isConnected() {
 		return
 	}
 
-	payload, err := generateAttachVolumeError(conn.UUID(), instance, volume, ave)
+	payload, err := generateAttachVolumeError(conn.UUID(), instance, volume, commandID, ave)
 	if err != nil {
 		glog.Errorf("Unable to generate payload for attach_volume_failure: %v", err)
 		return
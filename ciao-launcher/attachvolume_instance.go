@@ -23,7 +23,7 @@ import (
 )
 
 func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan interface{}, cfg *vmConfig,
-	instance, instanceDir, volumeUUID string, conn serverConn) *attachVolumeError {
+	instance, instanceDir, volumeUUID, pool string, conn serverConn) *attachVolumeError {
 
 	if cfg.Container {
 		attachErr := &attachVolumeError{nil, payloads.AttachVolumeNotSupported}
@@ -48,12 +48,13 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		}
 
 		var devName string
+		driver := poolDriver(storageDriver, pool)
 
 		if len(volumeMap[volumeUUID]) > 0 {
 			devName = volumeMap[volumeUUID][0]
 			glog.Infof("Volume %s already mapped %s", volumeUUID, devName)
 		} else {
-			devName, err = storageDriver.MapVolumeToNode(volumeUUID)
+			devName, err = driver.MapVolumeToNode(volumeUUID)
 			if err != nil {
 				attachErr := &attachVolumeError{err, payloads.AttachVolumeAttachFailure}
 				glog.Errorf("Unable to map volume  %s [%s]: %v",
@@ -69,13 +70,14 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 			responseCh: responseCh,
 			volumeUUID: volumeUUID,
 			device:     devName,
+			diskModel:  cfg.DiskModel,
 		}
 
 		err = <-responseCh
 		if err != nil {
 			glog.Errorf("Unable to attach volume %s to instance %s: %v",
 				volumeUUID, instance, err)
-			unmapErr := storageDriver.UnmapVolumeFromNode(devName)
+			unmapErr := driver.UnmapVolumeFromNode(devName)
 			if unmapErr != nil {
 				glog.Warningf("Unable to unmap %s : %v", devName, unmapErr)
 			}
@@ -84,7 +86,7 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		}
 	}
 
-	cfg.Volumes = append(cfg.Volumes, volumeConfig{UUID: volumeUUID})
+	cfg.Volumes = append(cfg.Volumes, volumeConfig{UUID: volumeUUID, Pool: pool})
 
 	err := cfg.save(instanceDir)
 	if err != nil {
@@ -0,0 +1,152 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// tenantMTU is the MTU of the tenant networks advertised by the CNCI DHCP
+// server, which accounts for the tunnel overhead.
+const tenantMTU = 1400
+
+// writeConfigDrive lays out the files of an OpenStack config drive in dir.
+// On top of the user and meta data the drive contains the vendor data of
+// the instance, in the format read by cloud-init, and its network
+// configuration, as a cloud-init version 2 network configuration and, if
+// the workload asked for it, in the OpenStack network_data.json format.
+func writeConfigDrive(dir string, cfg *vmConfig, userData, metaData []byte) error {
+	dataDir := path.Join(dir, "openstack", "latest")
+
+	err := os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return fmt.Errorf("Unable to create config drive directory %s : %v",
+			dataDir, err)
+	}
+
+	files := map[string][]byte{
+		"meta_data.json": metaData,
+		"user_data":      userData,
+	}
+
+	if cfg.VendorData != "" {
+		vendorData, err := json.Marshal(map[string]string{"cloud-init": cfg.VendorData})
+		if err != nil {
+			return fmt.Errorf("Unable to marshal vendor data : %v", err)
+		}
+		files["vendor_data.json"] = vendorData
+	}
+
+	if cfg.NetworkConfig != "" {
+		files["network_config"] = []byte(cfg.NetworkConfig)
+	}
+
+	if cfg.StaticNetwork {
+		networkData, err := staticNetworkData(cfg)
+		if err != nil {
+			return err
+		}
+		files["network_data.json"] = networkData
+	}
+
+	for name, data := range files {
+		p := path.Join(dataDir, name)
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			return fmt.Errorf("Unable to create %s : %v", p, err)
+		}
+	}
+
+	return nil
+}
+
+// staticNetworkData returns the network configuration of an instance in the
+// OpenStack network_data.json format, which is understood by the tools
+// configuring the network of images that use neither cloud-init nor DHCP.
+// The gateway and DNS server of the instance are those served by its CNCI,
+// on the first address of its subnet.
+func staticNetworkData(cfg *vmConfig) ([]byte, error) {
+	ip := net.ParseIP(cfg.VnicIP)
+	_, subnet, err := net.ParseCIDR(cfg.SubnetIP)
+	if ip == nil || ip.To4() == nil || err != nil {
+		return nil, fmt.Errorf("Invalid instance address %s in subnet %s",
+			cfg.VnicIP, cfg.SubnetIP)
+	}
+
+	gw := make(net.IP, net.IPv4len)
+	copy(gw, subnet.IP.To4().Mask(subnet.Mask))
+	gw[3]++
+
+	networkData := map[string]interface{}{
+		"links": []map[string]interface{}{{
+			"id":                   "eth0",
+			"type":                 "phy",
+			"ethernet_mac_address": cfg.VnicMAC,
+			"mtu":                  tenantMTU,
+		}},
+		"networks": []map[string]interface{}{{
+			"id":         "network0",
+			"type":       "ipv4",
+			"link":       "eth0",
+			"ip_address": ip.String(),
+			"netmask":    net.IP(subnet.Mask).String(),
+			"routes": []map[string]string{{
+				"network": "0.0.0.0",
+				"netmask": "0.0.0.0",
+				"gateway": gw.String(),
+			}},
+		}},
+		"services": []map[string]string{{
+			"type":    "dns",
+			"address": gw.String(),
+		}},
+	}
+
+	return json.Marshal(networkData)
+}
+
+// createConfigDriveISO creates the config drive of an instance that needs
+// vendor data or a network configuration, which qemu.CreateCloudInitISO does
+// not support.
+func createConfigDriveISO(ctx context.Context, instanceDir, isoPath string, cfg *vmConfig,
+	userData, metaData []byte) error {
+	configDrivePath := path.Join(instanceDir, "clr-cloud-init")
+	defer func() {
+		_ = os.RemoveAll(configDrivePath)
+	}()
+
+	err := writeConfigDrive(configDrivePath, cfg, userData, metaData)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "xorriso", "-as", "mkisofs", "-R", "-V", "config-2",
+		"-o", isoPath, configDrivePath)
+	cmd.SysProcAttr = childProcessCreds
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Unable to create cloudinit iso image %v", err)
+	}
+
+	return nil
+}
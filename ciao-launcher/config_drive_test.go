@@ -0,0 +1,134 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWriteConfigDrive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cfg := &vmConfig{
+		NetworkConfig: "version: 2\n",
+		VendorData:    "#cloud-config\n",
+	}
+
+	err = writeConfigDrive(dir, cfg, []byte("user"), []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := path.Join(dir, "openstack", "latest")
+	expected := map[string]string{
+		"meta_data.json": "{}",
+		"user_data":      "user",
+		"network_config": cfg.NetworkConfig,
+	}
+
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(path.Join(dataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != content {
+			t.Errorf("Unexpected content of %s: %q", name, string(data))
+		}
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dataDir, "vendor_data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vendorData map[string]string
+	err = json.Unmarshal(data, &vendorData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vendorData["cloud-init"] != cfg.VendorData {
+		t.Errorf("Unexpected vendor data %q", string(data))
+	}
+}
+
+func TestStaticNetworkData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cfg := &vmConfig{
+		VnicMAC:       "02:00:ac:10:00:05",
+		VnicIP:        "172.16.0.5",
+		SubnetIP:      "172.16.0.0/24",
+		StaticNetwork: true,
+	}
+
+	err = writeConfigDrive(dir, cfg, []byte("user"), []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, "openstack", "latest", "network_data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var networkData struct {
+		Links []struct {
+			MAC string `json:"ethernet_mac_address"`
+		} `json:"links"`
+		Networks []struct {
+			IP      string `json:"ip_address"`
+			Netmask string `json:"netmask"`
+			Routes  []struct {
+				Gateway string `json:"gateway"`
+			} `json:"routes"`
+		} `json:"networks"`
+	}
+
+	err = json.Unmarshal(data, &networkData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(networkData.Links) != 1 || networkData.Links[0].MAC != cfg.VnicMAC ||
+		len(networkData.Networks) != 1 || len(networkData.Networks[0].Routes) != 1 {
+		t.Fatalf("Unexpected network data %s", string(data))
+	}
+
+	n := networkData.Networks[0]
+	if n.IP != "172.16.0.5" || n.Netmask != "255.255.255.0" || n.Routes[0].Gateway != "172.16.0.1" {
+		t.Fatalf("Unexpected network data %s", string(data))
+	}
+
+	cfg.VnicIP = ""
+	if err = writeConfigDrive(dir, cfg, nil, nil); err == nil {
+		t.Fatal("Static network configured without an address")
+	}
+}
@@ -0,0 +1,100 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/ciao-project/ciao/payloads"
+)
+
+type cpuModel struct {
+	name string
+
+	// flags lists the /proc/cpuinfo flags a CPU needs to run the model
+	// on top of those of the previous model of the table.
+	flags []string
+}
+
+// cpuModels lists the QEMU CPU models advertised to the scheduler, each
+// model including the features of the models preceding it.  Only the
+// features which distinguish the models from one another are checked.
+var cpuModels = []cpuModel{
+	{"Nehalem", []string{"sse4_1", "sse4_2", "ssse3", "popcnt", "cx16"}},
+	{"Westmere", []string{"aes", "pclmulqdq"}},
+	{"SandyBridge", []string{"avx", "xsave", "x2apic", "tsc_deadline_timer"}},
+	{"IvyBridge", []string{"f16c", "rdrand", "fsgsbase", "erms", "smep"}},
+	{"Haswell-noTSX", []string{"avx2", "fma", "bmi1", "bmi2", "movbe", "invpcid"}},
+	{"Broadwell-noTSX", []string{"rdseed", "adx", "smap"}},
+	{"Skylake-Client", []string{"xsavec", "xsaves", "clflushopt", "hle", "rtm"}},
+	{"Skylake-Server", []string{"avx512f", "avx512dq", "avx512cd", "avx512bw",
+		"avx512vl", "clwb"}},
+}
+
+// tsxModels are the variants of the models above which also require TSX.
+var tsxModels = map[string]string{
+	"Haswell-noTSX":   "Haswell",
+	"Broadwell-noTSX": "Broadwell",
+}
+
+// supportedCPUModels returns the CPU models, from cpuModels, which can be
+// run by a CPU with the given feature flags.
+func supportedCPUModels(flags []string) []string {
+	available := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		available[f] = true
+	}
+
+	hasFlags := func(flags ...string) bool {
+		for _, f := range flags {
+			if !available[f] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var models []string
+	for _, m := range cpuModels {
+		if !hasFlags(m.flags...) {
+			break
+		}
+		models = append(models, m.name)
+		if tsx, ok := tsxModels[m.name]; ok && hasFlags("hle", "rtm") {
+			models = append(models, tsx)
+		}
+	}
+
+	return models
+}
+
+// qemuCPUParam returns the value of the -cpu parameter of an instance, or
+// an empty string if qemu's default model is to be used.
+func qemuCPUParam(cfg *vmConfig, useKvm bool) string {
+	model := cfg.CPUModel
+	if model == "" {
+		model = payloads.CPUModelHost
+	}
+
+	// Without kvm the host model is not available and the other models
+	// are emulated.
+	if !useKvm && model == payloads.CPUModelHost {
+		return ""
+	}
+
+	return strings.Join(append([]string{model}, cfg.CPUFeatures...), ",")
+}
@@ -0,0 +1,85 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Checks that the CPU models supported by a node are correctly computed.
+//
+// supportedCPUModels is called with the flags of a CPU without TSX, of one
+// with TSX, and of one lacking a single feature of a model.
+//
+// The models up to the most recent one supported should be returned, the
+// TSX variants only for the CPU supporting TSX.
+func TestSupportedCPUModels(t *testing.T) {
+	var flags []string
+	for _, m := range cpuModels[:5] {
+		flags = append(flags, m.flags...)
+	}
+
+	expected := []string{"Nehalem", "Westmere", "SandyBridge", "IvyBridge",
+		"Haswell-noTSX"}
+	models := supportedCPUModels(flags)
+	if !reflect.DeepEqual(models, expected) {
+		t.Errorf("Expected %v, got %v", expected, models)
+	}
+
+	expected = append(expected, "Haswell")
+	models = supportedCPUModels(append(flags, "hle", "rtm"))
+	if !reflect.DeepEqual(models, expected) {
+		t.Errorf("Expected %v, got %v", expected, models)
+	}
+
+	models = supportedCPUModels(flags[1:])
+	if len(models) != 0 {
+		t.Errorf("Expected no model, got %v", models)
+	}
+}
+
+// Checks the -cpu parameter passed to qemu.
+//
+// qemuCPUParam is called for instances using the default, host and named
+// models, with and without kvm.
+//
+// The host model should be used by default with kvm, the qemu default
+// without, and the requested features should be appended to the model.
+func TestQemuCPUParam(t *testing.T) {
+	tests := []struct {
+		model    string
+		features []string
+		useKvm   bool
+		param    string
+	}{
+		{"", nil, true, "host"},
+		{"", nil, false, ""},
+		{"host", []string{"-hle"}, true, "host,-hle"},
+		{"Skylake-Server", []string{"+avx512f", "-rtm"}, true,
+			"Skylake-Server,+avx512f,-rtm"},
+		{"Skylake-Server", nil, false, "Skylake-Server"},
+	}
+
+	for _, tt := range tests {
+		cfg := &vmConfig{CPUModel: tt.model, CPUFeatures: tt.features}
+		param := qemuCPUParam(cfg, tt.useKvm)
+		if param != tt.param {
+			t.Errorf("Expected %q for %+v, got %q", tt.param, tt, param)
+		}
+	}
+}
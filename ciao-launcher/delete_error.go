@@ -23,8 +23,9 @@ import (
 )
 
 type deleteError struct {
-	err  error
-	code payloads.DeleteFailureReason
+	err       error
+	code      payloads.DeleteFailureReason
+	commandID string
 }
 
 func (de *deleteError) send(conn serverConn, instance string) {
@@ -31,6 +31,7 @@ import (
 	"context"
 
 	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
 
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/engine-api/client"
@@ -60,6 +61,7 @@ type docker struct {
 	dockerID       string
 	prevCPUTime    int64
 	prevSampleTime time.Time
+	ioStat         *payloads.InstanceDiskIOStat
 	storageDriver  storage.BlockDriver
 	mount          mounter
 	cli            containerManager
@@ -268,7 +270,7 @@ func (d *docker) umountVolumes(vols []volumeConfig) {
 
 func (d *docker) unmapVolumes() {
 	for _, vol := range d.cfg.Volumes {
-		if err := d.storageDriver.UnmapVolumeFromNode(vol.UUID); err != nil {
+		if err := poolDriver(d.storageDriver, vol.Pool).UnmapVolumeFromNode(vol.UUID); err != nil {
 			glog.Warningf("Unable to unmap %s: %v", vol.UUID, err)
 			continue
 		}
@@ -280,7 +282,7 @@ func (d *docker) mapAndMountVolumes() error {
 	for mapped, vol := range d.cfg.Volumes {
 		var devName string
 		var err error
-		if devName, err = d.storageDriver.MapVolumeToNode(vol.UUID); err != nil {
+		if devName, err = poolDriver(d.storageDriver, vol.Pool).MapVolumeToNode(vol.UUID); err != nil {
 			d.umountVolumes(d.cfg.Volumes[:mapped])
 			return fmt.Errorf("Unable to map (%s) %v", vol.UUID, err)
 		}
@@ -435,6 +437,9 @@ DONE:
 			case virtualizerAttachCmd:
 				err := fmt.Errorf("Live Attach of volumes not supported for containers")
 				cmd.responseCh <- err
+			case virtualizerHotAddCmd:
+				err := fmt.Errorf("Hot add of resources not supported for containers")
+				cmd.responseCh <- err
 			}
 		}
 	}
@@ -566,15 +571,54 @@ func (d *docker) stats() (disk, memory, cpu int) {
 	d.prevCPUTime = cpuTime
 	d.prevSampleTime = now
 
+	ioStat := blkioStats(&stats.BlkioStats)
+	d.ioStat = &ioStat
+
 	return
 }
 
+// blkioStats sums the per device counters of the blkio cgroup of a
+// container.
+func blkioStats(blkio *types.BlkioStats) payloads.InstanceDiskIOStat {
+	var stat payloads.InstanceDiskIOStat
+
+	for _, e := range blkio.IoServiceBytesRecursive {
+		switch e.Op {
+		case "Read":
+			stat.ReadBytes += e.Value
+		case "Write":
+			stat.WriteBytes += e.Value
+		}
+	}
+
+	for _, e := range blkio.IoServicedRecursive {
+		switch e.Op {
+		case "Read":
+			stat.ReadOps += e.Value
+		case "Write":
+			stat.WriteOps += e.Value
+		}
+	}
+
+	return stat
+}
+
+// ioStats returns the counters read by the last call to stats.
+func (d *docker) ioStats() (payloads.InstanceDiskIOStat, bool) {
+	if d.ioStat == nil {
+		return payloads.InstanceDiskIOStat{}, false
+	}
+
+	return *d.ioStat, true
+}
+
 func (d *docker) connected() {
 	d.prevCPUTime = -1
 }
 
 func (d *docker) lostVM() {
 	d.prevCPUTime = -1
+	d.ioStat = nil
 
 	d.umountVolumes(d.cfg.Volumes)
 }
@@ -83,6 +83,10 @@ func (s dockerTestStorage) CreateBlockDeviceSnapshot(volumeUUID string, snapshot
 	return nil
 }
 
+func (s dockerTestStorage) FlattenBlockDevice(volumeUUID string) error {
+	return nil
+}
+
 func (s dockerTestStorage) DeleteBlockDevice(string) error {
 	return nil
 }
@@ -99,6 +103,10 @@ func (s dockerTestStorage) GetVolumeMapping() (map[string][]string, error) {
 	return nil, nil
 }
 
+func (s dockerTestStorage) ListBlockDevices() ([]storage.BlockDevice, error) {
+	return nil, nil
+}
+
 func (s dockerTestStorage) CopyBlockDevice(volumeUUID string) (storage.BlockDevice, error) {
 	return storage.BlockDevice{}, nil
 }
@@ -196,6 +204,20 @@ func (d *dockerTestClient) ContainerStats(context.Context, string, bool) (io.Rea
   },
   "memory_stats" : {
      "usage" : 104857600
+  },
+  "blkio_stats" : {
+    "io_service_bytes_recursive" : [
+      { "major" : 8, "minor" : 0, "op" : "Read", "value" : 4096 },
+      { "major" : 8, "minor" : 0, "op" : "Write", "value" : 8192 },
+      { "major" : 8, "minor" : 0, "op" : "Total", "value" : 12288 },
+      { "major" : 8, "minor" : 16, "op" : "Read", "value" : 1024 }
+    ],
+    "io_serviced_recursive" : [
+      { "major" : 8, "minor" : 0, "op" : "Read", "value" : 3 },
+      { "major" : 8, "minor" : 0, "op" : "Write", "value" : 2 },
+      { "major" : 8, "minor" : 0, "op" : "Total", "value" : 5 },
+      { "major" : 8, "minor" : 16, "op" : "Read", "value" : 1 }
+    ]
   }
 }`)
 
@@ -837,11 +859,17 @@ func TestDockerMonitorVMClose(t *testing.T) {
 // Call the stats method twice.  The second call is required to retrieve cpu stats.
 //
 // The stats method should return the statistics provisioned in the dockerTestClient
-// ContainerInspectWithRaw and ContainerStats methods.
+// ContainerInspectWithRaw and ContainerStats methods.  The ioStats method
+// should fail before stats is called and return the summed blkio counters
+// afterwards.
 func TestDockerStats(t *testing.T) {
 	tc := &dockerTestClient{}
 	d := &docker{dockerID: testutil.InstanceUUID, cfg: &vmConfig{}, cli: tc, prevCPUTime: -1}
 
+	if _, ok := d.ioStats(); ok {
+		t.Errorf("Expected ioStats to fail before stats is called")
+	}
+
 	disk, mem, cpu := d.stats()
 	if mem != 100 {
 		t.Errorf("Expected memory usage of 100.  Got %d", mem)
@@ -859,4 +887,12 @@ func TestDockerStats(t *testing.T) {
 	if cpu != 0 {
 		t.Errorf("Expected cpu usage of 0.  Got %d", cpu)
 	}
+	io, ok := d.ioStats()
+	if !ok {
+		t.Fatalf("Expected ioStats to succeed")
+	}
+
+	if io.ReadBytes != 5120 || io.WriteBytes != 8192 || io.ReadOps != 4 || io.WriteOps != 2 {
+		t.Errorf("Unexpected disk I/O statistics %+v", io)
+	}
 }
@@ -0,0 +1,122 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// probeInstance runs a health check against the instance whose address
+// is ip.  It returns nil if the instance passed the check.
+func probeInstance(hc *payloads.HealthCheck, ip string) error {
+	timeout := time.Duration(hc.Timeout) * time.Second
+	addr := net.JoinHostPort(ip, strconv.Itoa(hc.Port))
+
+	if hc.Protocol == payloads.HealthCheckHTTP {
+		path := hc.Path
+		if path == "" {
+			path = "/"
+		}
+
+		client := http.Client{Timeout: timeout}
+		resp, err := client.Get("http://" + addr + path)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("HTTP status %d", resp.StatusCode)
+		}
+
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// updateHealth records the result of a health check of an instance.  The
+// instance only becomes unhealthy once it failed threshold consecutive
+// checks.
+func updateHealth(h *payloads.InstanceHealthStat, threshold int, err error, now time.Time) {
+	h.Checked = now.Format(time.RFC3339)
+
+	if err == nil {
+		h.Status = payloads.Healthy
+		h.Failures = 0
+		h.Message = ""
+		return
+	}
+
+	h.Failures++
+	h.Message = err.Error()
+	if h.Failures >= threshold {
+		h.Status = payloads.Unhealthy
+	}
+}
+
+func (id *instanceData) startHealthChecks() {
+	if id.cfg.HealthCheck == nil {
+		return
+	}
+
+	id.health = &payloads.InstanceHealthStat{Status: payloads.HealthUnknown}
+	id.healthCh = make(chan error, 1)
+	id.healthTimer = time.After(time.Duration(id.cfg.HealthCheck.Interval) * time.Second)
+}
+
+// probeHealth runs a health check in the background, so that the instance
+// keeps processing its commands.  The result is sent to healthCh.
+func (id *instanceData) probeHealth() {
+	hc := id.cfg.HealthCheck
+	ip := id.cfg.VnicIP
+	ch := id.healthCh
+	go func() {
+		ch <- probeInstance(hc, ip)
+	}()
+}
+
+func (id *instanceData) healthProbed(err error) {
+	hc := id.cfg.HealthCheck
+	updateHealth(id.health, hc.UnhealthyThreshold, err, time.Now())
+	if err != nil {
+		glog.Warningf("Health check of instance %s failed: %v", id.instance, err)
+	}
+
+	id.healthTimer = time.After(time.Duration(hc.Interval) * time.Second)
+}
+
+func (id *instanceData) getHealth() *payloads.InstanceHealthStat {
+	if id.health == nil {
+		return nil
+	}
+
+	h := *id.health
+	return &h
+}
@@ -0,0 +1,112 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/payloads"
+)
+
+// Verify that instances only become unhealthy after threshold failures
+//
+// This test records failed and passed checks with a threshold of two.
+//
+// The instance should stay unknown after its first failure, become
+// unhealthy after its second and healthy again after a passed check.
+func TestUpdateHealth(t *testing.T) {
+	h := payloads.InstanceHealthStat{Status: payloads.HealthUnknown}
+	now := time.Now()
+	failed := errors.New("connection refused")
+
+	updateHealth(&h, 2, failed, now)
+	if h.Status != payloads.HealthUnknown || h.Failures != 1 || h.Message != failed.Error() {
+		t.Fatalf("Unexpected health after one failure %+v", h)
+	}
+
+	updateHealth(&h, 2, failed, now)
+	if h.Status != payloads.Unhealthy || h.Failures != 2 {
+		t.Fatalf("Unexpected health after two failures %+v", h)
+	}
+
+	updateHealth(&h, 2, nil, now)
+	if h.Status != payloads.Healthy || h.Failures != 0 || h.Message != "" ||
+		h.Checked != now.Format(time.RFC3339) {
+		t.Fatalf("Unexpected health after a passed check %+v", h)
+	}
+}
+
+// Verify the TCP and HTTP probes
+//
+// This test probes an HTTP server answering 200 on /ok and 503 elsewhere,
+// over HTTP and TCP, and a closed port over TCP.
+//
+// The HTTP probe should only pass for /ok, the TCP probe should pass for
+// the server and fail for the closed port.
+func TestProbeInstance(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	hc := payloads.HealthCheck{
+		Protocol: payloads.HealthCheckHTTP,
+		Port:     port,
+		Path:     "/ok",
+		Timeout:  5,
+	}
+
+	if err := probeInstance(&hc, host); err != nil {
+		t.Errorf("HTTP probe failed: %v", err)
+	}
+
+	hc.Path = ""
+	if err := probeInstance(&hc, host); err == nil {
+		t.Errorf("HTTP probe passed for an unavailable service")
+	}
+
+	hc.Protocol = payloads.HealthCheckTCP
+	if err := probeInstance(&hc, host); err != nil {
+		t.Errorf("TCP probe failed: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, portStr, _ = net.SplitHostPort(l.Addr().String())
+	_ = l.Close()
+	hc.Port, _ = strconv.Atoi(portStr)
+
+	if err := probeInstance(&hc, "127.0.0.1"); err == nil {
+		t.Errorf("TCP probe passed for a closed port")
+	}
+}
@@ -0,0 +1,49 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+package main
+
+import (
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+)
+
+// hotAddError records the reason for which resources could not be hot
+// added to an instance, along with the vCPUs and memory which were not
+// added.
+type hotAddError struct {
+	err   error
+	code  payloads.HotAddFailureReason
+	vcpus int
+	memMB int
+}
+
+func (hae *hotAddError) send(conn serverConn, instance, commandID string) {
+	if !conn.isConnected() {
+		return
+	}
+
+	payload, err := generateHotAddError(conn.UUID(), instance, commandID, hae)
+	if err != nil {
+		glog.Errorf("Unable to generate payload for hot_add_failure: %v", err)
+		return
+	}
+
+	_, err = conn.SendError(ssntp.HotAddFailure, payload)
+	if err != nil {
+		glog.Errorf("Unable to send hot_add_failure: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+func hotAddResource(monitorCh chan interface{}, cpus, memMB int, memID string) error {
+	responseCh := make(chan error)
+
+	monitorCh <- virtualizerHotAddCmd{
+		responseCh: responseCh,
+		cpus:       cpus,
+		memMB:      memMB,
+		memID:      memID,
+	}
+
+	return <-responseCh
+}
+
+// processHotAdd hot adds memory, then vCPUs, to an instance and records them
+// in its configuration.  The error returned lists the resources which could
+// not be added, if any.
+func processHotAdd(monitorCh chan interface{}, cfg *vmConfig, instance, instanceDir string,
+	cpus, memMB int) *hotAddError {
+
+	if cfg.Container {
+		hotAddErr := &hotAddError{nil, payloads.HotAddNotSupported, cpus, memMB}
+		glog.Errorf("Cannot hot add resources to a container [%s]", string(hotAddErr.code))
+		return hotAddErr
+	}
+
+	if (cpus > 0 && cfg.Cpus+cpus > cfg.MaxCpus) || (memMB > 0 && cfg.Mem+memMB > cfg.MaxMem) {
+		err := fmt.Errorf("Instance %s limited to %d vCPUs and %d MB", instance,
+			cfg.MaxCpus, cfg.MaxMem)
+		hotAddErr := &hotAddError{err, payloads.HotAddNotSupported, cpus, memMB}
+		glog.Errorf("Unable to hot add resources [%s]: %v", string(hotAddErr.code), err)
+		return hotAddErr
+	}
+
+	var hotAddErr *hotAddError
+
+	if monitorCh != nil {
+		if memMB > 0 {
+			// The memory of the instance grows with each hot add, so
+			// its size before the hot add uniquely identifies the DIMM.
+
+			memID := fmt.Sprintf("mem%d", cfg.Mem)
+			err := hotAddResource(monitorCh, 0, memMB, memID)
+			if err != nil {
+				glog.Errorf("Unable to hot add %d MB to instance %s: %v",
+					memMB, instance, err)
+				return &hotAddError{err, payloads.HotAddHotAddFailure, cpus, memMB}
+			}
+		}
+
+		if cpus > 0 {
+			err := hotAddResource(monitorCh, cpus, 0, "")
+			if err != nil {
+				glog.Errorf("Unable to hot add %d vCPUs to instance %s: %v",
+					cpus, instance, err)
+				hotAddErr = &hotAddError{err, payloads.HotAddHotAddFailure, cpus, 0}
+				cpus = 0
+			}
+		}
+	}
+
+	cfg.Cpus += cpus
+	cfg.Mem += memMB
+
+	err := cfg.save(instanceDir)
+	if err != nil && hotAddErr == nil {
+		hotAddErr = &hotAddError{err, payloads.HotAddStateFailure, 0, 0}
+		glog.Errorf("Unable to persist instance %s state [%s]: %v",
+			instance, string(hotAddErr.code), err)
+	}
+
+	return hotAddErr
+}
@@ -0,0 +1,68 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// imageCache records the docker images present on the node.  Its contents
+// are reported in the READY and STATS status so that the scheduler can
+// prefer the nodes which do not need to download the image of a container.
+type imageCache struct {
+	sync.Mutex
+	images map[string]bool
+}
+
+var cachedImages = &imageCache{images: make(map[string]bool)}
+
+func (c *imageCache) add(image string) {
+	c.Lock()
+	c.images[image] = true
+	c.Unlock()
+}
+
+// list returns the sorted names of the cached images.
+func (c *imageCache) list() []string {
+	c.Lock()
+	defer c.Unlock()
+
+	images := make([]string, 0, len(c.images))
+	for i := range c.images {
+		images = append(images, i)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// cacheImage downloads a docker image, if not already present on the
+// node, and adds it to the image cache.
+func cacheImage(image string) {
+	if !simulate {
+		d := &docker{cfg: &vmConfig{DockerImage: image}}
+		if err := d.ensureBackingImage(); err != nil {
+			glog.Errorf("Unable to cache image %s: %v", image, err)
+			return
+		}
+	}
+
+	cachedImages.add(image)
+	glog.Infof("Image %s cached", image)
+}
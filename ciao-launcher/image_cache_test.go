@@ -0,0 +1,45 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Checks that cached images are listed in order.
+//
+// Two images are cached in simulation mode, one of them twice.
+//
+// Each image is listed once and the list is sorted.
+func TestCacheImage(t *testing.T) {
+	saved := simulate
+	simulate = true
+	defer func() { simulate = saved }()
+
+	savedCache := cachedImages
+	cachedImages = &imageCache{images: make(map[string]bool)}
+	defer func() { cachedImages = savedCache }()
+
+	cacheImage("ubuntu:16.04")
+	cacheImage("fedora:25")
+	cacheImage("ubuntu:16.04")
+
+	images := cachedImages.list()
+	expected := []string{"fedora:25", "ubuntu:16.04"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Unexpected cached images %v, expected %v", images, expected)
+	}
+}
@@ -42,6 +42,9 @@ type instanceData struct {
 	connectedCh    chan struct{}
 	monitorCloseCh chan struct{}
 	statsTimer     <-chan time.Time
+	healthTimer    <-chan time.Time
+	healthCh       chan error
+	health         *payloads.InstanceHealthStat
 	vm             virtualizer
 	instanceDir    string
 	shuttingDown   bool
@@ -57,6 +60,10 @@ type insStartCmd struct {
 	frame    *ssntp.Frame
 	cfg      *vmConfig
 	rcvStamp time.Time
+
+	// The identifier of the START command, echoed back to controller
+	// in the acknowledgement or error sent in response to the command.
+	commandID string
 }
 type insDeleteCmd struct {
 	// Indicates that the delete command originated in launcher rather
@@ -74,11 +81,23 @@ type insDeleteCmd struct {
 	// two operations are almost identical for launcher.  The only difference
 	// is in the events that get sent back to controller.
 	stop bool
+
+	// The identifier of the DELETE command, echoed back to controller
+	// in the events and errors sent in response to the command.
+	commandID string
 }
 type insMonitorCmd struct{}
 
 type insAttachVolumeCmd struct {
 	volumeUUID string
+	pool       string
+	commandID  string
+}
+
+type insHotAddCmd struct {
+	vcpus     int
+	memMB     int
+	commandID string
 }
 
 /*
@@ -128,14 +147,14 @@ func (id *instanceData) startCommand(cmd *insStartCmd) {
 	if id.monitorCh != nil {
 		startErr := &startError{nil, payloads.AlreadyRunning, cmd.cfg.Restart}
 		glog.Errorf("Unable to start instance[%s]", string(startErr.code))
-		startErr.send(id.ac.conn, id.instance)
+		startErr.send(id.ac.conn, id.instance, cmd.commandID)
 		return
 	}
 	id.creating = true
 	st, startErr := processStart(cmd, id.instanceDir, id.vm, id.ac.conn)
 	if startErr != nil {
 		glog.Errorf("Unable to start instance[%s]: %v", string(startErr.code), startErr.err)
-		startErr.send(id.ac.conn, id.instance)
+		startErr.send(id.ac.conn, id.instance, cmd.commandID)
 
 		if startErr.code != payloads.InstanceExists {
 			glog.Warningf("Unable to create VM instance: %s.  Killing it", id.instance)
@@ -154,6 +173,7 @@ func (id *instanceData) startCommand(cmd *insStartCmd) {
 	if cmd.frame != nil && cmd.frame.PathTrace() {
 		id.ovsCh <- &ovsTraceFrame{cmd.frame}
 	}
+	sendCommandAck(id.ac.conn, cmd.commandID)
 }
 
 func (id *instanceData) monitorCommand(cmd *insMonitorCmd) {
@@ -162,10 +182,11 @@ func (id *instanceData) monitorCommand(cmd *insMonitorCmd) {
 	id.monitorCh = id.vm.monitorVM(id.monitorCloseCh, id.connectedCh, &id.instanceWg, true)
 }
 
-func (id *instanceData) sendInstanceDeletedEvent() {
+func (id *instanceData) sendInstanceDeletedEvent(commandID string) {
 	var event payloads.EventInstanceDeleted
 
 	event.InstanceDeleted.InstanceUUID = id.instance
+	event.InstanceDeleted.CommandID = commandID
 
 	payload, err := yaml.Marshal(&event)
 	if err != nil {
@@ -179,10 +200,11 @@ func (id *instanceData) sendInstanceDeletedEvent() {
 	}
 }
 
-func (id *instanceData) sendInstanceStoppedEvent() {
+func (id *instanceData) sendInstanceStoppedEvent(commandID string) {
 	var event payloads.EventInstanceStopped
 
 	event.InstanceStopped.InstanceUUID = id.instance
+	event.InstanceStopped.CommandID = commandID
 
 	payload, err := yaml.Marshal(&event)
 	if err != nil {
@@ -198,7 +220,7 @@ func (id *instanceData) sendInstanceStoppedEvent() {
 
 func (id *instanceData) deleteCommand(cmd *insDeleteCmd) bool {
 	if id.shuttingDown && !cmd.suicide {
-		deleteErr := &deleteError{nil, payloads.DeleteNoInstance}
+		deleteErr := &deleteError{nil, payloads.DeleteNoInstance, cmd.commandID}
 		glog.Errorf("Unable to delete instance[%s]", string(deleteErr.code))
 		deleteErr.send(id.ac.conn, id.instance)
 		return false
@@ -217,9 +239,9 @@ func (id *instanceData) deleteCommand(cmd *insDeleteCmd) bool {
 
 	if !cmd.skipDeleteEvent {
 		if cmd.stop {
-			id.sendInstanceStoppedEvent()
+			id.sendInstanceStoppedEvent(cmd.commandID)
 		} else {
-			id.sendInstanceDeletedEvent()
+			id.sendInstanceDeletedEvent(cmd.commandID)
 		}
 		id.ovsCh <- &ovsStatusCmd{}
 	}
@@ -230,22 +252,46 @@ func (id *instanceData) attachVolumeCommand(cmd *insAttachVolumeCmd) {
 	if id.shuttingDown {
 		attachErr := &attachVolumeError{nil, payloads.AttachVolumeInstanceFailure}
 		glog.Errorf("Unable to attach instance[%s]", string(attachErr.code))
-		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID, cmd.commandID)
 		return
 	}
 
 	attachErr := processAttachVolume(id.storageDriver, id.monitorCh, id.cfg, id.instance, id.instanceDir,
-		cmd.volumeUUID, id.ac.conn)
+		cmd.volumeUUID, cmd.pool, id.ac.conn)
 	if attachErr != nil {
-		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID, cmd.commandID)
 		return
 	}
-	d, m, c := id.vm.stats()
-	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+	id.sendStats()
+	sendCommandAck(id.ac.conn, cmd.commandID)
 
 	glog.Infof("Volume %s attached to instance %s", cmd.volumeUUID, id.instance)
 }
 
+func (id *instanceData) hotAddCommand(cmd *insHotAddCmd) {
+	if id.shuttingDown {
+		hotAddErr := &hotAddError{nil, payloads.HotAddInstanceFailure, cmd.vcpus, cmd.memMB}
+		glog.Errorf("Unable to hot add resources to instance[%s]", string(hotAddErr.code))
+		hotAddErr.send(id.ac.conn, id.instance, cmd.commandID)
+		return
+	}
+
+	cpus, mem := id.cfg.Cpus, id.cfg.Mem
+	hotAddErr := processHotAdd(id.monitorCh, id.cfg, id.instance, id.instanceDir,
+		cmd.vcpus, cmd.memMB)
+	if id.cfg.Cpus != cpus || id.cfg.Mem != mem {
+		id.ovsCh <- &ovsHotAddCmd{id.instance, id.cfg.Cpus - cpus, id.cfg.Mem - mem}
+		id.sendStats()
+	}
+	if hotAddErr != nil {
+		hotAddErr.send(id.ac.conn, id.instance, cmd.commandID)
+		return
+	}
+	sendCommandAck(id.ac.conn, cmd.commandID)
+
+	glog.Infof("%d vCPUs and %d MB hot added to instance %s", cmd.vcpus, cmd.memMB, id.instance)
+}
+
 func (id *instanceData) logStartTrace() {
 	if id.st == nil {
 		return
@@ -283,6 +329,8 @@ func (id *instanceData) instanceCommand(cmd interface{}) bool {
 		id.monitorCommand(cmd)
 	case *insAttachVolumeCmd:
 		id.attachVolumeCommand(cmd)
+	case *insHotAddCmd:
+		id.hotAddCommand(cmd)
 	case *insDeleteCmd:
 		if id.deleteCommand(cmd) {
 			return false
@@ -302,6 +350,34 @@ func (id *instanceData) getVolumes() []string {
 	return volumes
 }
 
+func (id *instanceData) getNetworks() []payloads.InstanceNetworkStat {
+	if id.cfg.VnicName == "" {
+		return nil
+	}
+
+	stat, ok := computeNICStats(id.cfg.VnicName)
+	if !ok {
+		return nil
+	}
+
+	return []payloads.InstanceNetworkStat{stat}
+}
+
+func (id *instanceData) getDiskIO() *payloads.InstanceDiskIOStat {
+	stat, ok := id.vm.ioStats()
+	if !ok {
+		return nil
+	}
+
+	return &stat
+}
+
+func (id *instanceData) sendStats() {
+	d, m, c := id.vm.stats()
+	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes(), id.getNetworks(),
+		id.getDiskIO(), id.getHealth()}
+}
+
 func (id *instanceData) unmapVolumes() {
 	glog.Infof("Unmapping volumes for %s", id.instance)
 
@@ -311,7 +387,7 @@ func (id *instanceData) unmapVolumes() {
 		// instances on the same node.  We don't treat this as an
 		// error for now.
 
-		if err := id.storageDriver.UnmapVolumeFromNode(v.UUID); err == nil {
+		if err := poolDriver(id.storageDriver, v.Pool).UnmapVolumeFromNode(v.UUID); err == nil {
 			glog.Infof("Unmapping volume %s", v.UUID)
 		}
 	}
@@ -321,8 +397,7 @@ func (id *instanceData) instanceLoop() {
 
 	id.vm.init(id.cfg, id.instanceDir)
 
-	d, m, c := id.vm.stats()
-	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+	id.sendStats()
 
 DONE:
 	for {
@@ -330,9 +405,13 @@ DONE:
 		case <-id.doneCh:
 			break DONE
 		case <-id.statsTimer:
-			d, m, c := id.vm.stats()
-			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+			id.sendStats()
 			id.statsTimer = time.After(time.Second * resourcePeriod)
+		case <-id.healthTimer:
+			id.healthTimer = nil
+			id.probeHealth()
+		case err := <-id.healthCh:
+			id.healthProbed(err)
 		case cmd := <-id.cmdCh:
 			if !id.instanceCommand(cmd) {
 				break DONE
@@ -340,8 +419,7 @@ DONE:
 		case <-id.monitorCloseCh:
 			// Means we've lost VM for now
 			id.vm.lostVM()
-			d, m, c := id.vm.stats()
-			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+			id.sendStats()
 
 			glog.Infof("Lost VM instance: %s", id.instance)
 			id.monitorCloseCh = nil
@@ -349,6 +427,8 @@ DONE:
 			close(id.monitorCh)
 			id.monitorCh = nil
 			id.statsTimer = nil
+			id.healthTimer = nil
+			id.healthCh = nil
 			id.ovsCh <- &ovsStateChange{id.instance, ovsStopped}
 			id.st = nil
 			killMe(id.instance, false, true, id.doneCh, id.ac, &id.instanceWg)
@@ -358,8 +438,8 @@ DONE:
 			id.connectedCh = nil
 			id.vm.connected()
 			id.ovsCh <- &ovsStateChange{id.instance, ovsRunning}
-			d, m, c := id.vm.stats()
-			id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
+			id.startHealthChecks()
+			id.sendStats()
 			id.statsTimer = time.After(time.Second * resourcePeriod)
 		}
 	}
@@ -59,9 +59,11 @@ type instanceTestState struct {
 	stf             payloads.ErrorStartFailure
 	df              payloads.ErrorDeleteFailure
 	avf             payloads.ErrorAttachVolumeFailure
+	haf             payloads.ErrorHotAddFailure
 	deMigration     bool
 	de              payloads.EventInstanceDeleted
 	se              payloads.EventInstanceStopped
+	ncr             payloads.EventNodeCommandResult
 	connect         bool
 	monitorCh       chan interface{}
 	errorCh         chan struct{}
@@ -118,6 +120,10 @@ func (v *instanceTestState) stats() (disk, memory, cpu int) {
 	return v.statsArray[0], v.statsArray[1], v.statsArray[2]
 }
 
+func (v *instanceTestState) ioStats() (payloads.InstanceDiskIOStat, bool) {
+	return payloads.InstanceDiskIOStat{}, false
+}
+
 func (v *instanceTestState) connected() {
 
 }
@@ -142,6 +148,11 @@ func (v *instanceTestState) SendError(error ssntp.Error, payload []byte) (int, e
 		if err != nil {
 			v.t.Fatalf("Failed to unmarshall attach volume error %v", err)
 		}
+	case ssntp.HotAddFailure:
+		err := yaml.Unmarshal(payload, &v.haf)
+		if err != nil {
+			v.t.Fatalf("Failed to unmarshall hot add error %v", err)
+		}
 	}
 
 	if v.errorCh != nil {
@@ -165,6 +176,12 @@ func (v *instanceTestState) SendEvent(event ssntp.Event, payload []byte) (int, e
 		if err != nil {
 			v.t.Fatalf("Failed to unmarshall instanceStopped event %v", err)
 		}
+	case ssntp.NodeCommandResult:
+		err := yaml.Unmarshal(payload, &v.ncr)
+		if err != nil {
+			v.t.Fatalf("Failed to unmarshall NodeCommandResult event %v", err)
+		}
+		return 0, nil
 	}
 
 	if v.eventCh != nil {
@@ -821,15 +838,16 @@ func TestMigrateInstance(t *testing.T) {
 // and then delete the instance.
 //
 // The instanceLoop and then instance should start correctly.  The volume should
-// be correctly attached and the stats command should verify this.  The instance
-// should be correctly deleted.
+// be correctly attached and the stats command should verify this.  The attach
+// command should be acknowledged.  The instance should be correctly deleted.
 func TestAttachVolumeToInstance(t *testing.T) {
 	var wg sync.WaitGroup
 	cfg := standardCfg
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
+	commandID := "attach-command"
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeUUID: testutil.VolumeUUID, commandID: commandID}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -848,6 +866,13 @@ func TestAttachVolumeToInstance(t *testing.T) {
 	}
 
 	wg.Wait()
+
+	// the instance loop handles its commands in order, the attach was
+	// acknowledged before the instance was deleted.
+	ack := state.ncr.NodeCommandResult
+	if ack.CommandID != commandID || ack.Error != "" {
+		t.Errorf("Attach command not acknowledged: %+v", ack)
+	}
 }
 
 // Check that adding an existing volume fails
@@ -864,7 +889,7 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeUUID: testutil.VolumeUUID}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -881,7 +906,7 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	select {
 	case <-state.errorCh:
 		t.Error("Initial Volume attach failed")
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeUUID: testutil.VolumeUUID}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -903,6 +928,113 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	wg.Wait()
 }
 
+// Check we can hot add vCPUs and memory to an instance
+//
+// We start the instance loop with room for more vCPUs and memory, hot add
+// some, wait for the overseer to be notified and then delete the instance.
+//
+// The instanceLoop and then instance should start correctly.  The memory and
+// then the vCPUs should be hot added by the monitor, the overseer should be
+// told of the new resources and the configuration of the instance should
+// be updated.  The instance should be correctly deleted.
+func TestHotAddToInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	cfg.MaxCpus = 4
+	cfg.MaxMem = cfg.Mem + 256
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	select {
+	case cmdCh <- &insHotAddCmd{vcpus: 1, memMB: 256}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending hot add command")
+	}
+
+	for _, expected := range []virtualizerHotAddCmd{{memMB: 256}, {cpus: 1}} {
+		select {
+		case monCmd := <-state.monitorCh:
+			hotAdd := monCmd.(virtualizerHotAddCmd)
+			if hotAdd.cpus != expected.cpus || hotAdd.memMB != expected.memMB {
+				t.Errorf("Unexpected hot add command %+v", hotAdd)
+			}
+			hotAdd.responseCh <- nil
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for hot add command result")
+		}
+	}
+
+DONE:
+	for {
+		select {
+		case ovsCmd := <-ovsCh:
+			switch ovsCmd := ovsCmd.(type) {
+			case *ovsHotAddCmd:
+				if ovsCmd.vcpus != 1 || ovsCmd.memMB != 256 {
+					t.Errorf("Unexpected overseer hot add %+v", ovsCmd)
+				}
+				break DONE
+			case *ovsStatsUpdateCmd:
+			default:
+				t.Error("Unexpected commands received on ovsCh")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for ovsHotAddCmd")
+		}
+	}
+
+	_ = state.getStatsUpdate(t, ovsCh)
+
+	if cfg.Cpus != standardCfg.Cpus+1 || cfg.Mem != standardCfg.Mem+256 {
+		t.Errorf("Instance config not updated: %d vCPUs %d MB", cfg.Cpus, cfg.Mem)
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+// Check that hot adding more resources than an instance can take fails
+//
+// We start the instance loop, without room for more vCPUs, try to hot add
+// one and then delete the instance.
+//
+// The instanceLoop and then instance should start correctly.  The hot add
+// should fail with HotAddNotSupported, echoing the command identifier, without
+// reaching the monitor.  The instance should be correctly deleted.
+func TestHotAddTooManyCPUs(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	state.errorCh = make(chan struct{})
+	select {
+	case cmdCh <- &insHotAddCmd{vcpus: 1, commandID: "hot-add-command"}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending hot add command")
+	}
+
+	select {
+	case <-state.errorCh:
+		if state.haf.Reason != payloads.HotAddNotSupported || state.haf.VCPUs != 1 ||
+			state.haf.CommandID != "hot-add-command" {
+			t.Errorf("Unexpected error.  Expected %s got %+v",
+				payloads.HotAddNotSupported, state.haf)
+		}
+	case <-state.monitorCh:
+		t.Error("Unexpected monitor command")
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for hot add to fail")
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	var err error
@@ -184,7 +184,7 @@ func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{})
 		return
 	}
 
-	switch cmd.cmd.(type) {
+	switch c := cmd.cmd.(type) {
 	case *statusCmd:
 		ovsCh <- &ovsStatsStatusCmd{}
 		return
@@ -207,11 +207,21 @@ func processCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- interface{})
 		}
 		wg.Wait()
 		glog.Info("All instances evacuated")
+		sendCommandAck(conn, c.commandID)
 	case *restoreCmd:
 		doneCh := make(chan struct{})
 		ovsCh <- &ovsRestoreCmd{doneCh}
 		<-doneCh
 		glog.Info("Node restored")
+		sendCommandAck(conn, c.commandID)
+	case *cacheImageCmd:
+		go cacheImage(c.image)
+	case *restartAgentCmd:
+		restartAgent(conn, c.commandID)
+	case *selfTestCmd:
+		go runSelfTest(conn, c.commandID)
+	case *configureCmd:
+		reconfigure(conn)
 	}
 }
 
@@ -234,7 +244,7 @@ func processInstanceCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- inter
 					insCmd.cfg.Instance)
 			}
 			se := startError{nil, addResult.errorCode, insCmd.cfg.Restart}
-			se.send(conn, cmd.instance)
+			se.send(conn, cmd.instance, insCmd.commandID)
 			return
 		}
 		target = addResult.cmdCh
@@ -243,11 +253,19 @@ func processInstanceCommand(conn serverConn, cmd *cmdWrapper, ovsCh chan<- inter
 		target = insState.cmdCh
 		if target == nil {
 			glog.Errorf("Instance %s does not exist", cmd.instance)
-			de := deleteError{nil, payloads.DeleteNoInstance}
+			de := deleteError{nil, payloads.DeleteNoInstance, insCmd.commandID}
 			de.send(conn, cmd.instance)
 			return
 		}
 		delCmd = insCmd
+	case *insHotAddCmd:
+		target = insCmdChannel(cmd.instance, ovsCh)
+		if target == nil {
+			glog.Errorf("Instance %s does not exist", cmd.instance)
+			he := hotAddError{nil, payloads.HotAddNoInstance, insCmd.vcpus, insCmd.memMB}
+			he.send(conn, cmd.instance, insCmd.commandID)
+			return
+		}
 	default:
 		target = insCmdChannel(cmd.instance, ovsCh)
 	}
@@ -293,6 +311,7 @@ func loadClusterConfig(conn serverConn) error {
 	if err != nil {
 		return err
 	}
+	activeClusterConfig = clusterConfig
 	netConfig.ComputeNet = clusterConfig.Configure.Launcher.ComputeNetwork
 	netConfig.MgmtNet = clusterConfig.Configure.Launcher.ManagementNetwork
 	diskLimit = clusterConfig.Configure.Launcher.DiskLimit
@@ -300,6 +319,7 @@ func loadClusterConfig(conn serverConn) error {
 	if cephID == "" {
 		cephID = clusterConfig.Configure.Storage.CephID
 	}
+	storagePools = probeStoragePools(clusterConfig.Configure.Storage.Pools)
 
 	childUser := clusterConfig.Configure.Launcher.ChildUser
 	if childUser != "" {
@@ -550,7 +570,7 @@ func setLimits() {
 	maxInstances = int(rlim.Cur / 5)
 }
 
-func startLauncher() int {
+func startLauncher() (exitCode int, restart bool) {
 	doneCh := make(chan struct{})
 	statusCh := make(chan struct{})
 	signalCh := make(chan os.Signal, 1)
@@ -559,16 +579,30 @@ func startLauncher() int {
 
 	go connectToServer(doneCh, statusCh)
 
+	quitting := false
+	quit := func() {
+		if quitting {
+			return
+		}
+		quitting = true
+		close(doneCh)
+		go func() {
+			time.Sleep(time.Second)
+			timeoutCh <- struct{}{}
+		}()
+	}
+
 DONE:
 	for {
 		select {
 		case <-signalCh:
 			glog.Info("Received terminating signal.  Waiting for server loop to quit")
-			close(doneCh)
-			go func() {
-				time.Sleep(time.Second)
-				timeoutCh <- struct{}{}
-			}()
+			restart = false
+			quit()
+		case <-restartCh:
+			glog.Info("Restart requested.  Waiting for server loop to quit")
+			restart = !quitting
+			quit()
 		case <-statusCh:
 			glog.Info("Server Loop quit cleanly")
 			break DONE
@@ -582,7 +616,18 @@ DONE:
 		}
 	}
 
-	return 0
+	return 0, restart
+}
+
+// restartLauncher replaces the current process with a new launcher
+// started with the same arguments.
+func restartLauncher() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(exe, os.Args, os.Environ())
 }
 
 func main() {
@@ -609,6 +654,7 @@ func main() {
 	glog.Info("Starting Launcher")
 
 	exitCode := 0
+	restart := false
 	var stopProfile func()
 	if profileFN != nil {
 		stopProfile = profileFN()
@@ -630,7 +676,7 @@ func main() {
 			glog.Fatalf("Unable to create mandatory dirs: %v", err)
 		}
 
-		exitCode = startLauncher()
+		exitCode, restart = startLauncher()
 	}
 
 	if stopTrace != nil {
@@ -641,6 +687,15 @@ func main() {
 		stopProfile()
 	}
 
+	if restart {
+		glog.Info("Restarting")
+		glog.Flush()
+		if err := restartLauncher(); err != nil {
+			glog.Errorf("Unable to restart launcher: %v", err)
+			exitCode = 1
+		}
+	}
+
 	glog.Flush()
 	glog.Info("Exit")
 
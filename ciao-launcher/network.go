@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"strconv"
 
 	"context"
 
@@ -216,7 +217,16 @@ func createCNVnicCfg(cfg *vmConfig) (*libsnnet.VnicConfig, error) {
 		return nil, fmt.Errorf("Invalid vnicIP ip %s", cfg.VnicIP)
 	}
 
+	// Controllers which do not allocate VNIs expect the subnet key to
+	// be derived from the subnet.
 	subnetKey := binary.LittleEndian.Uint32(vnet.IP)
+	if cfg.SubnetKey != "" {
+		key, err := strconv.ParseUint(cfg.SubnetKey, 10, 32)
+		if err != nil || key == 0 {
+			return nil, fmt.Errorf("Invalid subnet key %s", cfg.SubnetKey)
+		}
+		subnetKey = uint32(key)
+	}
 	var role libsnnet.VnicRole
 	if cfg.Container {
 		role = libsnnet.TenantContainer
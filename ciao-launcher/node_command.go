@@ -0,0 +1,188 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/ssntp"
+	"github.com/golang/glog"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// restartCh is signalled when the launcher needs to restart itself, either
+// because it was asked to or to apply a new cluster configuration.
+var restartCh = make(chan struct{}, 1)
+
+// activeClusterConfig is the cluster configuration the launcher was started
+// with.
+var activeClusterConfig payloads.Configure
+
+func requestRestart() {
+	select {
+	case restartCh <- struct{}{}:
+	default:
+	}
+}
+
+func sendNodeCommandResult(conn serverConn, commandID, errMsg string,
+	checks []payloads.SelfTestCheck) {
+	evt := payloads.EventNodeCommandResult{
+		NodeCommandResult: payloads.NodeCommandResultEvent{
+			CommandID: commandID,
+			NodeUUID:  conn.UUID(),
+			Error:     errMsg,
+			Checks:    checks,
+		},
+	}
+
+	payload, err := yaml.Marshal(&evt)
+	if err != nil {
+		glog.Errorf("Unable to Marshall NodeCommandResult %v", err)
+		return
+	}
+
+	_, err = conn.SendEvent(ssntp.NodeCommandResult, payload)
+	if err != nil {
+		glog.Errorf("Failed to send event command %v", err)
+	}
+}
+
+// sendCommandAck acknowledges the successful completion of a command that
+// carried an identifier.  Failures are reported through the error frame
+// specific to each command, which echoes the identifier back instead.
+func sendCommandAck(conn serverConn, commandID string) {
+	if commandID == "" {
+		return
+	}
+	sendNodeCommandResult(conn, commandID, "", nil)
+}
+
+func checkScheduler(conn serverConn) payloads.SelfTestCheck {
+	check := payloads.SelfTestCheck{Name: "scheduler", Success: true}
+	if !conn.isConnected() {
+		check.Success = false
+		check.Message = "not connected to the scheduler"
+	}
+	return check
+}
+
+// checkNetworks verifies that one of addrs belongs to one of the subnets
+// of the cluster configuration.  The check passes if no subnet is
+// configured, as the launcher then uses whatever network is available.
+func checkNetworks(name string, subnets []string, addrs []net.Addr) payloads.SelfTestCheck {
+	check := payloads.SelfTestCheck{Name: name, Success: true}
+	if len(subnets) == 0 {
+		return check
+	}
+
+	for _, s := range subnets {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if ok && subnet.Contains(ipNet.IP) {
+				return check
+			}
+		}
+	}
+
+	check.Success = false
+	check.Message = fmt.Sprintf("no address in %v", subnets)
+	return check
+}
+
+func checkInstancesDir(dir string) payloads.SelfTestCheck {
+	check := payloads.SelfTestCheck{Name: "instances", Success: true}
+
+	f, err := ioutil.TempFile(dir, ".selftest")
+	if err != nil {
+		check.Success = false
+		check.Message = err.Error()
+		return check
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return check
+}
+
+func runSelfTest(conn serverConn, commandID string) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		glog.Warningf("Unable to retrieve node addresses: %v", err)
+	}
+
+	checks := []payloads.SelfTestCheck{
+		checkScheduler(conn),
+		checkNetworks("compute-network", netConfig.ComputeNet, addrs),
+		checkNetworks("management-network", netConfig.MgmtNet, addrs),
+		checkInstancesDir(instancesDir),
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if !c.Success {
+			glog.Warningf("Self test check %s failed: %s", c.Name, c.Message)
+			failed++
+		}
+	}
+
+	errMsg := ""
+	if failed > 0 {
+		errMsg = fmt.Sprintf("%d of %d checks failed", failed, len(checks))
+	}
+
+	glog.Infof("Self test %s completed, %d checks failed", commandID, failed)
+	sendNodeCommandResult(conn, commandID, errMsg, checks)
+}
+
+// restartAgent acknowledges the restart before asking the main loop to
+// quit.  Instances keep running and are picked up again by the new
+// launcher process.
+func restartAgent(conn serverConn, commandID string) {
+	glog.Infof("Restart requested by command %s", commandID)
+	sendNodeCommandResult(conn, commandID, "", nil)
+	requestRestart()
+}
+
+// reconfigure restarts the launcher if the cluster configuration pushed by
+// the scheduler differs from the one it is running with, as the network
+// and storage settings can only be applied at start up.
+func reconfigure(conn serverConn) {
+	clusterConfig, err := conn.ClusterConfiguration()
+	if err != nil {
+		glog.Errorf("Unable to get Cluster Configuration %v", err)
+		return
+	}
+
+	if reflect.DeepEqual(clusterConfig, activeClusterConfig) {
+		glog.Info("Cluster configuration unchanged")
+		return
+	}
+
+	glog.Info("Cluster configuration changed, restarting")
+	requestRestart()
+}
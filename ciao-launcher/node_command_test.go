@@ -0,0 +1,131 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+)
+
+func restartRequested() bool {
+	select {
+	case <-restartCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Check the network self-test checks
+//
+// Run checkNetworks against a set of node addresses, with no subnet,
+// a matching subnet and a non matching one.
+//
+// The check should only fail for the non matching subnet.
+func TestCheckNetworks(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)},
+	}
+
+	if c := checkNetworks("compute-network", nil, addrs); !c.Success {
+		t.Errorf("Check failed without any subnet configured")
+	}
+
+	if c := checkNetworks("compute-network", []string{"10.0.0.0/8", "192.168.1.0/24"}, addrs); !c.Success {
+		t.Errorf("Check failed with a matching subnet: %s", c.Message)
+	}
+
+	c := checkNetworks("compute-network", []string{"10.0.0.0/8"}, addrs)
+	if c.Success || c.Message == "" {
+		t.Errorf("Check passed without any matching subnet")
+	}
+}
+
+// Check the instances directory self-test check
+//
+// Run checkInstancesDir against a temporary directory and a directory
+// that does not exist.
+//
+// The check should only pass for the temporary directory, which should
+// be left empty.
+func TestCheckInstancesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launcher-selftest")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if c := checkInstancesDir(dir); !c.Success {
+		t.Errorf("Check failed on a writable directory: %s", c.Message)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Check left files behind")
+	}
+
+	if c := checkInstancesDir(path.Join(dir, "missing")); c.Success {
+		t.Errorf("Check passed on a missing directory")
+	}
+}
+
+// Check that restartAgent requests a restart
+//
+// Call restartAgent and then drain the restart channel.
+//
+// A single restart request should be pending.
+func TestRestartAgent(t *testing.T) {
+	restartAgent(&ssntpTestState{}, testutil.NodeCommandID)
+	restartAgent(&ssntpTestState{}, testutil.NodeCommandID)
+
+	if !restartRequested() {
+		t.Fatalf("Restart not requested")
+	}
+
+	if restartRequested() {
+		t.Fatalf("Restart requested twice")
+	}
+}
+
+// Check that a new cluster configuration triggers a restart
+//
+// Call reconfigure with the active cluster configuration and then with a
+// different one.
+//
+// A restart should only be requested for the different configuration.
+func TestReconfigure(t *testing.T) {
+	saved := activeClusterConfig
+	defer func() { activeClusterConfig = saved }()
+
+	activeClusterConfig = payloads.Configure{}
+	reconfigure(&ssntpTestState{})
+	if restartRequested() {
+		t.Fatalf("Restart requested for an unchanged configuration")
+	}
+
+	activeClusterConfig.Configure.Launcher.DiskLimit = true
+	reconfigure(&ssntpTestState{})
+	if !restartRequested() {
+		t.Fatalf("Restart not requested for a new configuration")
+	}
+}
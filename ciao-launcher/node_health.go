@@ -0,0 +1,114 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/deviceinfo"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// diskHealthPeriod is the interval between two SMART health checks of the
+// disks of the node.
+const diskHealthPeriod = time.Hour
+
+// sysBlock is the sysfs directory exposing the block devices of the node.
+var sysBlock = "/sys/block"
+
+func getNodeHealth() *payloads.NodeHealthStat {
+	var h payloads.NodeHealthStat
+
+	for _, t := range deviceinfo.GetTemperatures() {
+		h.Temperatures = append(h.Temperatures, payloads.TemperatureStat{
+			Sensor:  t.Sensor,
+			Celsius: t.Celsius,
+		})
+	}
+	h.CorrectedECCErrors, h.UncorrectedECCErrors = deviceinfo.GetECCErrors()
+
+	return &h
+}
+
+// listDisks returns the block devices of the node backed by a physical
+// device, skipping loop, rbd and device mapper devices.
+func listDisks() []string {
+	entries, err := ioutil.ReadDir(sysBlock)
+	if err != nil {
+		return nil
+	}
+
+	var disks []string
+	for _, e := range entries {
+		if _, err := os.Stat(path.Join(sysBlock, e.Name(), "device")); err == nil {
+			disks = append(disks, e.Name())
+		}
+	}
+
+	return disks
+}
+
+// parseSMARTHealth parses the output of smartctl -H.  ATA disks report
+// PASSED or FAILED, SCSI disks OK or a failure reason.
+func parseSMARTHealth(output string) (healthy, known bool) {
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "SMART overall-health self-assessment test result:"):
+			return strings.HasSuffix(strings.TrimSpace(line), "PASSED"), true
+		case strings.HasPrefix(line, "SMART Health Status:"):
+			return strings.HasSuffix(strings.TrimSpace(line), "OK"), true
+		}
+	}
+
+	return false, false
+}
+
+// getDiskHealth returns the SMART health of the disks of the node, or nil
+// if smartctl is not installed.
+func getDiskHealth() []payloads.DiskHealthStat {
+	smartctl, err := exec.LookPath("smartctl")
+	if err != nil {
+		return nil
+	}
+
+	var disks []payloads.DiskHealthStat
+	for _, disk := range listDisks() {
+		// smartctl encodes the health of the disk in its exit
+		// status, so only its output is checked.
+		out, _ := exec.Command(smartctl, "-H", path.Join("/dev", disk)).Output()
+		healthy, known := parseSMARTHealth(string(out))
+		if !known {
+			if glog.V(1) {
+				glog.Warningf("Unable to read SMART health of %s", disk)
+			}
+			continue
+		}
+
+		disks = append(disks, payloads.DiskHealthStat{
+			Device:  disk,
+			Healthy: healthy,
+		})
+	}
+
+	return disks
+}
@@ -0,0 +1,87 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Verify the smartctl output parser
+//
+// This test passes the health section printed by smartctl for ATA and SCSI
+// disks, both healthy and failing, as well as an error message.
+//
+// parseSMARTHealth should return the health of the disks and report the
+// error message as unknown.
+func TestParseSMARTHealth(t *testing.T) {
+	tests := []struct {
+		output  string
+		healthy bool
+		known   bool
+	}{
+		{"=== START OF READ SMART DATA SECTION ===\nSMART overall-health self-assessment test result: PASSED\n", true, true},
+		{"SMART overall-health self-assessment test result: FAILED!\n", false, true},
+		{"SMART Health Status: OK\n", true, true},
+		{"SMART Health Status: FIRMWARE IMPENDING FAILURE\n", false, true},
+		{"Smartctl open device: /dev/sdz failed: No such device\n", false, false},
+	}
+
+	for _, tst := range tests {
+		healthy, known := parseSMARTHealth(tst.output)
+		if healthy != tst.healthy || known != tst.known {
+			t.Errorf("Expected %v %v for %q, got %v %v", tst.healthy, tst.known,
+				tst.output, healthy, known)
+		}
+	}
+}
+
+// Verify that only physical disks are checked
+//
+// This test creates a fake sysfs block directory containing a disk with a
+// device link and a loop device without one.
+//
+// listDisks should only return the disk.
+func TestListDisks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node_health_test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory : %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	savedSysBlock := sysBlock
+	sysBlock = dir
+	defer func() {
+		sysBlock = savedSysBlock
+	}()
+
+	for _, d := range []string{"sda/device", "loop0/queue"} {
+		if err = os.MkdirAll(path.Join(dir, d), 0755); err != nil {
+			t.Fatalf("Unable to create %s : %v", d, err)
+		}
+	}
+
+	disks := listDisks()
+	if !reflect.DeepEqual(disks, []string{"sda"}) {
+		t.Errorf("Expected [sda], got %v", disks)
+	}
+}
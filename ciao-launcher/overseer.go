@@ -82,6 +82,15 @@ type ovsStatsUpdateCmd struct {
 	diskUsageMB   int
 	CPUUsage      int
 	volumes       []string
+	networks      []payloads.InstanceNetworkStat
+	diskIO        *payloads.InstanceDiskIOStat
+	health        *payloads.InstanceHealthStat
+}
+
+type ovsHotAddCmd struct {
+	instance string
+	vcpus    int
+	memMB    int
 }
 
 type ovsMaintenanceCmd struct {
@@ -151,6 +160,9 @@ type ovsInstanceState struct {
 	sshIP          string
 	sshPort        int
 	volumes        []string
+	networks       []payloads.InstanceNetworkStat
+	diskIO         *payloads.InstanceDiskIOStat
+	health         *payloads.InstanceHealthStat
 }
 
 type overseer struct {
@@ -171,6 +183,8 @@ type overseer struct {
 	statsInterval      time.Duration
 	di                 deviceInfo
 	maintenance        bool
+	diskHealth         []payloads.DiskHealthStat
+	diskHealthChecked  time.Time
 }
 
 type cnStats struct {
@@ -180,6 +194,8 @@ type cnStats struct {
 	availableDiskMB int
 	load            int
 	cpusOnline      int
+	cpuFlags        []string
+	health          *payloads.NodeHealthStat
 }
 
 func (ovs *overseer) roomAvailable(cfg *vmConfig) payloads.StartFailureReason {
@@ -280,6 +296,10 @@ func (ovs *overseer) sendReadyStatusCommand(cns *cnStats) {
 		s.Networks[i] = *nic
 	}
 	s.NodeHostName = hostname
+	s.CPUFlags = cns.cpuFlags
+	s.CPUModels = supportedCPUModels(cns.cpuFlags)
+	s.StoragePools = storagePools
+	s.CachedImages = cachedImages.list()
 
 	payload, err := yaml.Marshal(&s)
 	if err != nil {
@@ -317,6 +337,7 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 	s.Init()
 
 	s.NodeUUID = ovs.ac.conn.UUID()
+	s.Timestamp = time.Now().Format(time.RFC3339Nano)
 	s.Status = status.String()
 	s.MemTotalMB, s.MemAvailableMB = cns.totalMemMB, cns.availableMemMB
 	s.Load = cns.load
@@ -327,6 +348,7 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 	for i, nic := range nicInfo {
 		s.Networks[i] = *nic
 	}
+	s.CachedImages = cachedImages.list()
 	s.Instances = make([]payloads.InstanceStat, len(ovs.instances))
 	i := 0
 	for uuid, state := range ovs.instances {
@@ -344,9 +366,17 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 		s.Instances[i].SSHIP = state.sshIP
 		s.Instances[i].SSHPort = state.sshPort
 		s.Instances[i].Volumes = state.volumes
+		s.Instances[i].Networks = state.networks
+		s.Instances[i].DiskIO = state.diskIO
+		s.Instances[i].Health = state.health
 		i++
 	}
 
+	if cns.health != nil {
+		s.Health = cns.health
+		s.Health.Disks = ovs.nodeDiskHealth()
+	}
+
 	payload, err := yaml.Marshal(&s)
 	if err != nil {
 		glog.Errorf("Unable to Marshall STATS %v", err)
@@ -399,11 +429,24 @@ func getStats(instancesDir string) *cnStats {
 	s.totalMemMB, s.availableMemMB = deviceinfo.GetMemoryInfo()
 	s.load = deviceinfo.GetLoadAvg()
 	s.cpusOnline = deviceinfo.GetOnlineCPUs()
+	s.cpuFlags = deviceinfo.GetCPUFlags()
 	s.totalDiskMB, s.availableDiskMB = deviceinfo.GetFSInfo(instancesDir)
+	s.health = getNodeHealth()
 
 	return &s
 }
 
+// nodeDiskHealth returns the SMART health of the disks of the node, which
+// is only checked every diskHealthPeriod.
+func (ovs *overseer) nodeDiskHealth() []payloads.DiskHealthStat {
+	if time.Since(ovs.diskHealthChecked) >= diskHealthPeriod {
+		ovs.diskHealth = getDiskHealth()
+		ovs.diskHealthChecked = time.Now()
+	}
+
+	return ovs.diskHealth
+}
+
 func (ovs *overseer) processGetCommand(cmd *ovsGetCmd) {
 	glog.Infof("Overseer: looking for instance %s", cmd.instance)
 	var insState ovsGetResult
@@ -488,6 +531,20 @@ func (ovs *overseer) processRemoveCommand(cmd *ovsRemoveCmd) {
 	cmd.errCh <- nil
 }
 
+func (ovs *overseer) processHotAddCommand(cmd *ovsHotAddCmd) {
+	glog.Infof("Overseer: hot adding %d vCPUs and %d MB to %s", cmd.vcpus, cmd.memMB,
+		cmd.instance)
+	target := ovs.instances[cmd.instance]
+	if target == nil {
+		return
+	}
+
+	target.maxVCPUs += cmd.vcpus
+	target.maxMemoryMB += cmd.memMB
+	ovs.vcpusAllocated += cmd.vcpus
+	ovs.memoryAllocated += cmd.memMB
+}
+
 func (ovs *overseer) processStatusCommand(cmd *ovsStatusCmd) {
 	glog.Info("Overseer: Received Status Command")
 	if !ovs.ac.conn.isConnected() {
@@ -530,6 +587,9 @@ func (ovs *overseer) processStatusUpdateCommand(cmd *ovsStatsUpdateCmd) {
 		target.diskUsageMB = cmd.diskUsageMB
 		target.CPUUsage = cmd.CPUUsage
 		target.volumes = cmd.volumes
+		target.networks = cmd.networks
+		target.diskIO = cmd.diskIO
+		target.health = cmd.health
 	}
 }
 
@@ -588,6 +648,8 @@ func (ovs *overseer) processCommand(cmd interface{}) {
 		ovs.processStateChangeCommand(cmd)
 	case *ovsStatsUpdateCmd:
 		ovs.processStatusUpdateCommand(cmd)
+	case *ovsHotAddCmd:
+		ovs.processHotAddCommand(cmd)
 	case *ovsTraceFrame:
 		ovs.processTraceFrameCommand(cmd)
 	case *ovsMaintenanceCmd:
@@ -44,11 +44,15 @@ type extractedDoc struct {
 var indentedRegexp *regexp.Regexp
 var startRegexp *regexp.Regexp
 var uuidRegexp *regexp.Regexp
+var cpuModelRegexp *regexp.Regexp
+var cpuFeatureRegexp *regexp.Regexp
 
 func init() {
 	indentedRegexp = regexp.MustCompile("\\s+.*")
 	startRegexp = regexp.MustCompile("^start\\s*:\\s*$")
 	uuidRegexp = regexp.MustCompile("^[0-9a-fA-F]+(-[0-9a-fA-F]+)*$")
+	cpuModelRegexp = regexp.MustCompile("^[a-zA-Z0-9._-]+$")
+	cpuFeatureRegexp = regexp.MustCompile("^[+-][a-zA-Z0-9._-]+$")
 }
 
 func printCloudinit(data *payloads.Start) {
@@ -65,10 +69,15 @@ func printCloudinit(data *payloads.Start) {
 	glog.Infof("VnicIP:               %v", net.PrivateIP)
 	glog.Infof("ConcIP:               %v", net.ConcentratorIP)
 	glog.Infof("SubnetIP:             %v", net.Subnet)
+	glog.Infof("SubnetKey:            %v", net.SubnetKey)
 	glog.Infof("ConcUUID:             %v", net.ConcentratorUUID)
 	glog.Infof("VnicUUID:             %v", net.VnicUUID)
 	glog.Infof("Restart:              %t", start.Restart)
 	glog.Infof("Requirements:         %+v", start.Requirements)
+	glog.Infof("Devices:              %+v", start.Devices)
+	if start.HealthCheck != nil {
+		glog.Infof("HealthCheck:          %+v", *start.HealthCheck)
+	}
 
 	for _, storage := range start.Storage {
 		if storage.ID != "" {
@@ -110,37 +119,108 @@ func parseVMTtype(start *payloads.StartCmd) (bool, error) {
 	return vmType == payloads.Docker, nil
 }
 
-func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
+func checkDeviceModels(devices *payloads.DeviceModels) error {
+	switch devices.Disk {
+	case "", payloads.VirtioBlk, payloads.VirtioSCSI:
+	default:
+		return fmt.Errorf("Invalid disk model received: %s", devices.Disk)
+	}
+
+	switch devices.NIC {
+	case "", payloads.VirtioNet, payloads.E1000:
+	default:
+		return fmt.Errorf("Invalid NIC model received: %s", devices.NIC)
+	}
+
+	switch devices.Machine {
+	case "", payloads.MachinePC, payloads.MachineQ35:
+	default:
+		return fmt.Errorf("Invalid machine type received: %s", devices.Machine)
+	}
+
+	return nil
+}
+
+func checkHealthCheck(hc *payloads.HealthCheck) error {
+	if hc == nil {
+		return nil
+	}
+
+	if hc.Protocol != payloads.HealthCheckTCP && hc.Protocol != payloads.HealthCheckHTTP {
+		return fmt.Errorf("Invalid health check protocol received: %s", hc.Protocol)
+	}
+
+	if hc.Port <= 0 || hc.Port > 65535 || hc.Interval <= 0 || hc.Timeout <= 0 ||
+		hc.UnhealthyThreshold <= 0 {
+		return fmt.Errorf("Invalid health check received: %+v", *hc)
+	}
+
+	return nil
+}
+
+func checkCPURequirements(req *payloads.WorkloadRequirements) error {
+	if req.CPUModel != "" && !cpuModelRegexp.MatchString(req.CPUModel) {
+		return fmt.Errorf("Invalid CPU model received: %s", req.CPUModel)
+	}
+
+	for _, f := range req.CPUFeatures {
+		if !cpuFeatureRegexp.MatchString(f) {
+			return fmt.Errorf("Invalid CPU feature received: %s", f)
+		}
+	}
+
+	return nil
+}
+
+func parseStartPayload(data []byte) (*vmConfig, string, *payloadError) {
 	var clouddata payloads.Start
 
 	err := yaml.Unmarshal(data, &clouddata)
 	if err != nil {
-		return nil, &payloadError{err, payloads.InvalidPayload}
+		return nil, "", &payloadError{err, payloads.InvalidPayload}
 	}
 	printCloudinit(&clouddata)
 
 	start := &clouddata.Start
+	commandID := start.CommandID
 
 	instance := strings.TrimSpace(start.InstanceUUID)
 	if !uuidRegexp.MatchString(instance) {
 		err = fmt.Errorf("Invalid instance id received: %s", instance)
-		return nil, &payloadError{err, payloads.InvalidData}
+		return nil, commandID, &payloadError{err, payloads.InvalidData}
 	}
 
 	fwType := start.FWType
 	if fwType != "" && fwType != payloads.Legacy && fwType != payloads.EFI {
 		err = fmt.Errorf("Invalid fwtype received: %s", fwType)
-		return nil, &payloadError{err, payloads.InvalidData}
+		return nil, commandID, &payloadError{err, payloads.InvalidData}
 	}
 	legacy := fwType == payloads.Legacy
 
 	container, err := parseVMTtype(start)
 	if err != nil {
-		return nil, &payloadError{err, payloads.InvalidData}
+		return nil, commandID, &payloadError{err, payloads.InvalidData}
+	}
+
+	err = checkDeviceModels(&start.Devices)
+	if err != nil {
+		return nil, commandID, &payloadError{err, payloads.InvalidData}
+	}
+
+	err = checkCPURequirements(&start.Requirements)
+	if err != nil {
+		return nil, commandID, &payloadError{err, payloads.InvalidData}
+	}
+
+	err = checkHealthCheck(start.HealthCheck)
+	if err != nil {
+		return nil, commandID, &payloadError{err, payloads.InvalidData}
 	}
 
 	cpus := start.Requirements.VCPUs
 	mem := start.Requirements.MemMB
+	maxCpus := start.Requirements.MaxVCPUs
+	maxMem := start.Requirements.MaxMemMB
 	networkNode := start.Requirements.NetworkNode
 	privileged := start.Requirements.Privileged
 
@@ -153,42 +233,56 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 			volumes = append(volumes, volumeConfig{
 				UUID:     storage.ID,
 				Bootable: storage.Bootable,
+				Pool:     storage.Pool,
 			})
 		} else {
 			/* See github issue #972:
 			   A storage.ID == "" implies an auto-created-by-launcher
 			   local disk.  This is not yet supported. */
-			return nil, &payloadError{err, payloads.InvalidData}
+			return nil, commandID, &payloadError{err, payloads.InvalidData}
 		}
 	}
 
 	return &vmConfig{Cpus: cpus,
-		Mem:         mem,
-		Instance:    instance,
-		DockerImage: start.DockerImage,
-		Legacy:      legacy,
-		Container:   container,
-		NetworkNode: networkNode,
-		VnicMAC:     strings.TrimSpace(net.VnicMAC),
-		VnicIP:      vnicIP,
-		ConcIP:      strings.TrimSpace(net.ConcentratorIP),
-		SubnetIP:    strings.TrimSpace(net.Subnet),
-		TenantUUID:  strings.TrimSpace(start.TenantUUID),
-		ConcUUID:    strings.TrimSpace(net.ConcentratorUUID),
-		VnicUUID:    strings.TrimSpace(net.VnicUUID),
-		SSHPort:     sshPort,
-		Volumes:     volumes,
-		Restart:     clouddata.Start.Restart,
-		Privileged:  privileged,
-	}, nil
+		Mem:           mem,
+		MaxCpus:       maxCpus,
+		MaxMem:        maxMem,
+		Instance:      instance,
+		DockerImage:   start.DockerImage,
+		Legacy:        legacy,
+		Container:     container,
+		NetworkNode:   networkNode,
+		VnicMAC:       strings.TrimSpace(net.VnicMAC),
+		VnicIP:        vnicIP,
+		ConcIP:        strings.TrimSpace(net.ConcentratorIP),
+		SubnetIP:      strings.TrimSpace(net.Subnet),
+		SubnetKey:     strings.TrimSpace(net.SubnetKey),
+		TenantUUID:    strings.TrimSpace(start.TenantUUID),
+		ConcUUID:      strings.TrimSpace(net.ConcentratorUUID),
+		VnicUUID:      strings.TrimSpace(net.VnicUUID),
+		SSHPort:       sshPort,
+		Volumes:       volumes,
+		Restart:       clouddata.Start.Restart,
+		Privileged:    privileged,
+		NetworkConfig: start.NetworkConfig,
+		VendorData:    start.VendorData,
+		StaticNetwork: start.StaticNetwork,
+		DiskModel:     start.Devices.Disk,
+		NICModel:      start.Devices.NIC,
+		Machine:       start.Devices.Machine,
+		CPUModel:      start.Requirements.CPUModel,
+		CPUFeatures:   start.Requirements.CPUFeatures,
+		HealthCheck:   start.HealthCheck,
+	}, commandID, nil
 }
 
-func generateStartError(node, instance string, startErr *startError) (out []byte, err error) {
+func generateStartError(node, instance, commandID string, startErr *startError) (out []byte, err error) {
 	sf := &payloads.ErrorStartFailure{
 		NodeUUID:     node,
 		InstanceUUID: instance,
 		Reason:       startErr.code,
 		Restart:      startErr.restart,
+		CommandID:    commandID,
 	}
 	return yaml.Marshal(sf)
 }
@@ -198,20 +292,34 @@ func generateDeleteError(node, instance string, deleteErr *deleteError) (out []b
 		NodeUUID:     node,
 		InstanceUUID: instance,
 		Reason:       deleteErr.code,
+		CommandID:    deleteErr.commandID,
 	}
 	return yaml.Marshal(df)
 }
 
-func generateAttachVolumeError(node, instance, volume string, ave *attachVolumeError) (out []byte, err error) {
+func generateAttachVolumeError(node, instance, volume, commandID string, ave *attachVolumeError) (out []byte, err error) {
 	avf := &payloads.ErrorAttachVolumeFailure{
 		NodeUUID:     node,
 		InstanceUUID: instance,
 		VolumeUUID:   volume,
 		Reason:       ave.code,
+		CommandID:    commandID,
 	}
 	return yaml.Marshal(avf)
 }
 
+func generateHotAddError(node, instance, commandID string, hae *hotAddError) (out []byte, err error) {
+	haf := &payloads.ErrorHotAddFailure{
+		NodeUUID:     node,
+		InstanceUUID: instance,
+		VCPUs:        hae.vcpus,
+		MemMB:        hae.memMB,
+		Reason:       hae.code,
+		CommandID:    commandID,
+	}
+	return yaml.Marshal(haf)
+}
+
 func generateNetEventPayload(ssntpEvent *libsnnet.SsntpEventInfo, agentUUID string) ([]byte, error) {
 	var event interface{}
 	var eventData *payloads.TenantAddedEvent
@@ -241,20 +349,21 @@ func generateNetEventPayload(ssntpEvent *libsnnet.SsntpEventInfo, agentUUID stri
 	return yaml.Marshal(event)
 }
 
-func parseDeletePayload(data []byte) (string, bool, *payloadError) {
+func parseDeletePayload(data []byte) (string, bool, string, *payloadError) {
 	var clouddata payloads.Delete
 
 	err := yaml.Unmarshal(data, &clouddata)
 	if err != nil {
-		return "", false, &payloadError{err, payloads.DeleteInvalidPayload}
+		return "", false, "", &payloadError{err, payloads.DeleteInvalidPayload}
 	}
 
+	commandID := clouddata.Delete.CommandID
 	instance := strings.TrimSpace(clouddata.Delete.InstanceUUID)
 	if !uuidRegexp.MatchString(instance) {
 		err = fmt.Errorf("Invalid instance id received: %s", instance)
-		return "", false, &payloadError{err, payloads.DeleteInvalidData}
+		return "", false, commandID, &payloadError{err, payloads.DeleteInvalidData}
 	}
-	return instance, clouddata.Delete.Stop, nil
+	return instance, clouddata.Delete.Stop, commandID, nil
 }
 
 func extractVolumeInfo(cmd *payloads.VolumeCmd, errString string) (string, string, *payloadError) {
@@ -272,16 +381,46 @@ func extractVolumeInfo(cmd *payloads.VolumeCmd, errString string) (string, strin
 	return instance, volume, nil
 }
 
-func parseAttachVolumePayload(data []byte) (string, string, *payloadError) {
+func parseAttachVolumePayload(data []byte) (string, string, string, string, *payloadError) {
 	var clouddata payloads.AttachVolume
 
 	err := yaml.Unmarshal(data, &clouddata)
 	if err != nil {
 		glog.Errorf("YAML error: %v", err)
-		return "", "", &payloadError{err, payloads.AttachVolumeInvalidPayload}
+		return "", "", "", "", &payloadError{err, payloads.AttachVolumeInvalidPayload}
+	}
+
+	commandID := clouddata.Attach.CommandID
+	instance, volume, payloadErr := extractVolumeInfo(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	if payloadErr != nil {
+		return "", "", "", commandID, payloadErr
+	}
+
+	return instance, volume, clouddata.Attach.Pool, commandID, nil
+}
+
+func parseHotAddPayload(data []byte) (string, int, int, string, *payloadError) {
+	var clouddata payloads.HotAdd
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		glog.Errorf("YAML error: %v", err)
+		return "", 0, 0, "", &payloadError{err, payloads.HotAddInvalidPayload}
+	}
+
+	cmd := &clouddata.HotAdd
+	instance := strings.TrimSpace(cmd.InstanceUUID)
+	if !uuidRegexp.MatchString(instance) {
+		err = fmt.Errorf("Invalid instance id received: %s", instance)
+		return "", 0, 0, cmd.CommandID, &payloadError{err, payloads.HotAddInvalidData}
+	}
+
+	if cmd.VCPUs < 0 || cmd.MemMB < 0 || (cmd.VCPUs == 0 && cmd.MemMB == 0) {
+		err = fmt.Errorf("Invalid resources received: %d vCPUs %d MB", cmd.VCPUs, cmd.MemMB)
+		return "", 0, 0, cmd.CommandID, &payloadError{err, payloads.HotAddInvalidData}
 	}
 
-	return extractVolumeInfo(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	return instance, cmd.VCPUs, cmd.MemMB, cmd.CommandID, nil
 }
 
 func linesToBytes(doc []string, buf *bytes.Buffer) {
@@ -67,8 +67,8 @@ start:
 			SSHPort:    35050,
 			Volumes: []volumeConfig{
 				{
-					"69e84267-ed01-4738-b15f-b47de06b62e7",
-					true,
+					UUID:     "69e84267-ed01-4738-b15f-b47de06b62e7",
+					Bootable: true,
 				},
 			},
 		},
@@ -141,6 +141,53 @@ start:
   storage:
      - id: 69e84267-ed01-4738-b15f-b47de06b62e7
        boot: true
+`,
+		nil,
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  devices:
+    disk: ide
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+  storage:
+     - id: 69e84267-ed01-4738-b15f-b47de06b62e7
+       boot: true
+`,
+		nil,
+	},
+	{
+		`
+start:
+  requirements:
+    vcpus: 2
+    mem_mb: 370
+    cpu_model: Skylake-Server,kvm=off
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  networking:
+    vnic_mac: 02:00:e6:f5:af:f9
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 192.168.8.0/21
+    private_ip: 192.168.8.2
+  storage:
+     - id: 69e84267-ed01-4738-b15f-b47de06b62e7
+       boot: true
 `,
 		nil,
 	},
@@ -154,25 +201,52 @@ start:
 // and volume UUIDs should match what is in the payload.  Errors should be
 // returned for the invalid payloads.
 func TestParseAttachVolumePayload(t *testing.T) {
-	instance, volume, err := parseAttachVolumePayload([]byte(testutil.AttachVolumeYaml))
+	instance, volume, pool, _, err := parseAttachVolumePayload([]byte(testutil.AttachVolumeYaml))
 	if err != nil {
 		t.Fatalf("parseAttachVolumePayload failed: %v", err)
 	}
-	if instance != testutil.InstanceUUID || volume != testutil.VolumeUUID {
-		t.Fatalf("VolumeUUID or InstanceUUID is invalid")
+	if instance != testutil.InstanceUUID || volume != testutil.VolumeUUID || pool != "" {
+		t.Fatalf("VolumeUUID, InstanceUUID or pool is invalid")
 	}
 
-	_, _, err = parseAttachVolumePayload([]byte("  -"))
+	_, _, _, _, err = parseAttachVolumePayload([]byte("  -"))
 	if err == nil || err.code != payloads.AttachVolumeInvalidPayload {
 		t.Fatalf("AttachVolumeInvalidPayload error expected")
 	}
 
-	_, _, err = parseAttachVolumePayload([]byte(testutil.BadAttachVolumeYaml))
+	_, _, _, _, err = parseAttachVolumePayload([]byte(testutil.BadAttachVolumeYaml))
 	if err == nil || err.code != payloads.AttachVolumeInvalidData {
 		t.Fatalf("AttachVolumeInvalidData error expected")
 	}
 }
 
+// Verify the parseHotAddPayload function.
+//
+// The function is passed one valid payload and two invalid payloads.
+//
+// No error should be returned for the valid payload and the returned instance
+// UUID and resources should match what is in the payload.  Errors should be
+// returned for the invalid payloads.
+func TestParseHotAddPayload(t *testing.T) {
+	instance, vcpus, memMB, _, err := parseHotAddPayload([]byte(testutil.HotAddYaml))
+	if err != nil {
+		t.Fatalf("parseHotAddPayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID || vcpus != 2 || memMB != 512 {
+		t.Fatalf("InstanceUUID, vcpus or mem_mb is invalid")
+	}
+
+	_, _, _, _, err = parseHotAddPayload([]byte("  -"))
+	if err == nil || err.code != payloads.HotAddInvalidPayload {
+		t.Fatalf("HotAddInvalidPayload error expected")
+	}
+
+	_, _, _, _, err = parseHotAddPayload([]byte(testutil.BadHotAddYaml))
+	if err == nil || err.code != payloads.HotAddInvalidData {
+		t.Fatalf("HotAddInvalidData error expected")
+	}
+}
+
 // Verify the parseStartPayload function.
 //
 // The function is passed one valid payload and a number of invalid payloads.
@@ -182,7 +256,7 @@ func TestParseAttachVolumePayload(t *testing.T) {
 // payload.  The invalid payloads should fail to parse.
 func TestParseStartPayload(t *testing.T) {
 	for i, st := range startTests {
-		cfg, err := parseStartPayload([]byte(st.payload))
+		cfg, _, err := parseStartPayload([]byte(st.payload))
 		if st.config == nil {
 			if cfg != nil {
 				t.Errorf("Expected nil config due to bad payload %d", i)
@@ -274,7 +348,7 @@ func TestGenerateNetEventPayload(t *testing.T) {
 // The payload should parse without any error and the instance UUID in the
 // resulting payloads data structure should be as expected.
 func TestParseDeletePayload(t *testing.T) {
-	instance, stop, err := parseDeletePayload([]byte(testutil.DeleteYaml))
+	instance, stop, commandID, err := parseDeletePayload([]byte(testutil.DeleteYaml))
 	if err != nil {
 		t.Fatalf("Failed to parse delete payload : %v", err.err)
 	}
@@ -285,4 +359,7 @@ func TestParseDeletePayload(t *testing.T) {
 	if stop {
 		t.Errorf("Expected stop to be false")
 	}
+	if commandID != "" {
+		t.Errorf("Expected no command ID, found %s", commandID)
+	}
 }
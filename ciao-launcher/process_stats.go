@@ -19,13 +19,20 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 )
 
+// sysClassNet is the sysfs directory exposing the network interfaces of
+// the node.
+var sysClassNet = "/sys/class/net"
+
 func computeProcessMemUsage(pid int) int {
 	mapsPath := path.Join("/proc", fmt.Sprintf("%d", pid), "smaps")
 	return parseProcSmaps(mapsPath)
@@ -64,6 +71,59 @@ func computeProcessCPUTime(pid int) int64 {
 	return parseProcStat(statPath)
 }
 
+func computeProcessIOStats(pid int) (payloads.InstanceDiskIOStat, bool) {
+	ioPath := path.Join("/proc", fmt.Sprintf("%d", pid), "io")
+	return parseProcIO(ioPath)
+}
+
+// parseProcIO reads the storage I/O counters of a process.  The number of
+// read and write system calls is used as an approximation of the number
+// of I/O operations.
+func parseProcIO(ioPath string) (payloads.InstanceDiskIOStat, bool) {
+	var stat payloads.InstanceDiskIOStat
+
+	io, err := os.Open(ioPath)
+	if err != nil {
+		if glog.V(1) {
+			glog.Warningf("Unable to open %s: %v", ioPath, err)
+		}
+		return stat, false
+	}
+	defer func() { _ = io.Close() }()
+
+	counters := map[string]*uint64{
+		"read_bytes:":  &stat.ReadBytes,
+		"write_bytes:": &stat.WriteBytes,
+		"syscr:":       &stat.ReadOps,
+		"syscw:":       &stat.WriteOps,
+	}
+
+	found := 0
+	scanner := bufio.NewScanner(io)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		counter, ok := counters[fields[0]]
+		if !ok {
+			continue
+		}
+
+		*counter, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			if glog.V(1) {
+				glog.Warningf("Invalid %s %s", fields[0], fields[1])
+			}
+			return stat, false
+		}
+		found++
+	}
+
+	return stat, found == len(counters)
+}
+
 func parseProcStat(statPath string) int64 {
 	stat, err := os.Open(statPath)
 	if err != nil {
@@ -108,3 +168,41 @@ func parseProcStat(statPath string) int64 {
 
 	return cpuTime
 }
+
+func readNICCounter(iface, counter string) (uint64, error) {
+	data, err := ioutil.ReadFile(path.Join(sysClassNet, iface, "statistics", counter))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// computeNICStats reads the traffic counters of the node side of an
+// instance network interface.  What the node receives on this interface
+// was sent by the instance, so the counters are swapped.
+func computeNICStats(iface string) (payloads.InstanceNetworkStat, bool) {
+	stat := payloads.InstanceNetworkStat{Interface: iface}
+	counters := []struct {
+		name  string
+		value *uint64
+	}{
+		{"rx_bytes", &stat.TxBytes},
+		{"tx_bytes", &stat.RxBytes},
+		{"rx_packets", &stat.TxPackets},
+		{"tx_packets", &stat.RxPackets},
+	}
+
+	for _, c := range counters {
+		v, err := readNICCounter(iface, c.name)
+		if err != nil {
+			if glog.V(1) {
+				glog.Warningf("Unable to read %s of %s: %v", c.name, iface, err)
+			}
+			return stat, false
+		}
+		*c.value = v
+	}
+
+	return stat, true
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 )
 
@@ -191,3 +192,105 @@ func TestParseProcStat(t *testing.T) {
 		t.Errorf("Expected parseProcStat to fail when passed invalid path")
 	}
 }
+
+// Verify the NIC counters parser
+//
+// This test creates a fake sysfs statistics directory for an interface and
+// calls computeNICStats on it and on an unknown interface.
+//
+// computeNICStats should return the swapped counters of the interface and
+// fail for the unknown interface.
+func TestComputeNICStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process_stats_test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory : %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	savedSysClassNet := sysClassNet
+	sysClassNet = dir
+	defer func() {
+		sysClassNet = savedSysClassNet
+	}()
+
+	stats := path.Join(dir, "vnic0", "statistics")
+	if err = os.MkdirAll(stats, 0755); err != nil {
+		t.Fatalf("Unable to create statistics directory : %v", err)
+	}
+
+	counters := map[string]string{
+		"rx_bytes":   "1000\n",
+		"tx_bytes":   "2000\n",
+		"rx_packets": "10\n",
+		"tx_packets": "20\n",
+	}
+	for name, value := range counters {
+		err = ioutil.WriteFile(path.Join(stats, name), []byte(value), 0644)
+		if err != nil {
+			t.Fatalf("Unable to write %s : %v", name, err)
+		}
+	}
+
+	stat, ok := computeNICStats("vnic0")
+	if !ok {
+		t.Fatalf("Expected computeNICStats to succeed")
+	}
+
+	if stat.Interface != "vnic0" || stat.RxBytes != 2000 || stat.TxBytes != 1000 ||
+		stat.RxPackets != 20 || stat.TxPackets != 10 {
+		t.Errorf("Incorrect value from computeNICStats: %+v", stat)
+	}
+
+	if _, ok = computeNICStats("vnic1"); ok {
+		t.Errorf("Expected computeNICStats to fail for an unknown interface")
+	}
+}
+
+// Verify the process I/O counters parser
+//
+// This test passes a valid and a truncated /proc/<pid>/io file to the
+// parseProcIO function.
+//
+// parseProcIO should return the counters of the valid file and fail to
+// parse the truncated one.
+func TestParseProcIO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "process_stats_test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory : %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	valid := path.Join(dir, "io")
+	data := "rchar: 5000\nwchar: 6000\nsyscr: 30\nsyscw: 40\n" +
+		"read_bytes: 4096\nwrite_bytes: 8192\ncancelled_write_bytes: 0\n"
+	if err = ioutil.WriteFile(valid, []byte(data), 0644); err != nil {
+		t.Fatalf("Unable to write %s : %v", valid, err)
+	}
+
+	stat, ok := parseProcIO(valid)
+	if !ok {
+		t.Fatalf("Expected parseProcIO to succeed")
+	}
+
+	if stat.ReadBytes != 4096 || stat.WriteBytes != 8192 ||
+		stat.ReadOps != 30 || stat.WriteOps != 40 {
+		t.Errorf("Incorrect value from parseProcIO: %+v", stat)
+	}
+
+	truncated := path.Join(dir, "io-truncated")
+	if err = ioutil.WriteFile(truncated, []byte(data[:30]), 0644); err != nil {
+		t.Fatalf("Unable to write %s : %v", truncated, err)
+	}
+
+	if _, ok = parseProcIO(truncated); ok {
+		t.Errorf("Expected parseProcIO to fail on a truncated file")
+	}
+
+	if _, ok = parseProcIO(""); ok {
+		t.Errorf("Expected parseProcIO to fail when passed invalid path")
+	}
+}
@@ -19,7 +19,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path"
@@ -31,6 +33,7 @@ import (
 
 	"context"
 
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 	"github.com/intel/govmm/qemu"
 )
@@ -39,6 +42,25 @@ const (
 	qemuEfiFw = "/usr/share/qemu/OVMF.fd"
 	seedImage = "seed.iso"
 	vcTries   = 10
+
+	// scsiController is the id of the virtio-scsi controller of the
+	// VMs using the virtio-scsi disk model.
+	scsiController = "scsi0"
+
+	// qemuMemSlotMB is the smallest amount of memory, in MiB, hot added to
+	// an instance.  Each hot add uses one DIMM slot.
+	qemuMemSlotMB = 128
+
+	// qemuMaxMemSlots is the maximum number of DIMM slots of a VM.
+	qemuMaxMemSlots = 256
+
+	// qemuHotplugSocket is the QMP socket used to hot add memory, in
+	// the directory of an instance.  The QMP socket of the monitor only
+	// accepts one client, and govmm has no commands to hot add memory.
+	qemuHotplugSocket = "hotplug_socket"
+
+	// qmpHotplugTimeout bounds a hot add of memory.
+	qmpHotplugTimeout = 30 * time.Second
 )
 
 type qmpGlogLogger struct{}
@@ -89,8 +111,15 @@ func createCloudInitISO(instanceDir, isoPath string, cfg *vmConfig, userData, me
 		metaData = []byte(defaultMeta)
 	}
 
-	if err := qemu.CreateCloudInitISO(context.TODO(), instanceDir, isoPath,
-		userData, metaData, childProcessCreds); err != nil {
+	var err error
+	if cfg.NetworkConfig == "" && cfg.VendorData == "" && !cfg.StaticNetwork {
+		err = qemu.CreateCloudInitISO(context.TODO(), instanceDir, isoPath,
+			userData, metaData, childProcessCreds)
+	} else {
+		err = createConfigDriveISO(context.TODO(), instanceDir, isoPath, cfg,
+			userData, metaData)
+	}
+	if err != nil {
 		glog.Errorf("Unable to create cloudinit iso image %v", err)
 		return err
 	}
@@ -247,6 +276,20 @@ func computeTapParam(infds []*os.File, vnicName, mac string) ([]string, []*os.Fi
 	return params, fds, toClose, nil
 }
 
+// computeE1000TapParam computes the parameters of an emulated e1000 NIC.
+// The e1000 supports neither multiple queues nor vhost so only the first
+// queue of the tap device is used.
+func computeE1000TapParam(infds []*os.File, vnicName, mac string) ([]string, []*os.File, error) {
+	if len(infds) == 0 {
+		return nil, nil, fmt.Errorf("No tap device for %s", vnicName)
+	}
+
+	netdev := fmt.Sprintf("type=tap,fd=3,id=%s", vnicName)
+	device := fmt.Sprintf("driver=e1000,netdev=%s,mac=%s", vnicName, mac)
+
+	return []string{"-netdev", netdev, "-device", device}, infds[:1], nil
+}
+
 func launchQemuWithNC(params []string, fds []*os.File, ipAddress string) (int, error) {
 	var err error
 
@@ -335,6 +378,23 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 		addr = 4
 	}
 
+	bus := "pci.0"
+	if cfg.Machine == payloads.MachineQ35 {
+		bus = "pcie.0"
+	}
+
+	if cfg.Machine != "" {
+		params = append(params, "-machine", string(cfg.Machine))
+	}
+
+	scsi := cfg.DiskModel == payloads.VirtioSCSI
+	if scsi {
+		scsiStr := fmt.Sprintf("virtio-scsi-pci,id=%s,bus=%s,addr=0x%x",
+			scsiController, bus, addr)
+		params = append(params, "-device", scsiStr)
+		addr++
+	}
+
 	// I know this is nasty but we have to specify a bus and address otherwise qemu
 	// hangs on startup.  I can't find a way to get qemu to pre-allocate the address.
 	// It will do this when using the legacy method of adding volumes but we can't do
@@ -343,12 +403,18 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 
 	for _, v := range cfg.Volumes {
 		blockdevID := fmt.Sprintf("drive_%s", v.UUID)
-		volDriveStr := fmt.Sprintf("file=rbd:rbd/%s:id=%s,if=none,id=%s,format=raw",
-			v.UUID, cephID, blockdevID)
+		volDriveStr := fmt.Sprintf("file=rbd:%s/%s:id=%s,if=none,id=%s,format=raw",
+			cephPool(v.Pool), v.UUID, cephID, blockdevID)
 		params = append(params, "-drive", volDriveStr)
+		if scsi {
+			volDeviceStr := fmt.Sprintf("scsi-hd,bus=%s.0,id=device_%s,drive=%s",
+				scsiController, v.UUID, blockdevID)
+			params = append(params, "-device", volDeviceStr)
+			continue
+		}
 		volDeviceStr :=
-			fmt.Sprintf("virtio-blk-pci,scsi=off,bus=pci.0,addr=0x%x,id=device_%s,drive=%s",
-				addr, v.UUID, blockdevID)
+			fmt.Sprintf("virtio-blk-pci,scsi=off,bus=%s,addr=0x%x,id=device_%s,drive=%s",
+				bus, addr, v.UUID, blockdevID)
 		params = append(params, "-device", volDeviceStr)
 		addr++
 	}
@@ -372,11 +438,14 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 
 	if useKvm {
 		params = append(params, "-enable-kvm")
-		params = append(params, "-cpu", "host")
 	} else {
 		glog.Warning("Running qemu without kvm support")
 	}
 
+	if cpuParam := qemuCPUParam(cfg, useKvm); cpuParam != "" {
+		params = append(params, "-cpu", cpuParam)
+	}
+
 	params = append(params, "-daemonize")
 
 	qmpSocket := path.Join(instanceDir, "socket")
@@ -385,10 +454,20 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 
 	if cfg.Mem > 0 {
 		memoryParam := fmt.Sprintf("%d", cfg.Mem)
+		if cfg.MaxMem > cfg.Mem {
+			memoryParam = fmt.Sprintf("%d,slots=%d,maxmem=%dM", cfg.Mem,
+				qemuMemSlots(cfg), cfg.MaxMem)
+			hotplugParam := fmt.Sprintf("unix:%s,server,nowait",
+				path.Join(instanceDir, qemuHotplugSocket))
+			params = append(params, "-qmp", hotplugParam)
+		}
 		params = append(params, "-m", memoryParam)
 	}
 	if cfg.Cpus > 0 {
 		cpusParam := fmt.Sprintf("cpus=%d", cfg.Cpus)
+		if cfg.MaxCpus > cfg.Cpus {
+			cpusParam = fmt.Sprintf("%s,maxcpus=%d", cpusParam, cfg.MaxCpus)
+		}
 		params = append(params, "-smp", cpusParam)
 	}
 
@@ -416,6 +495,14 @@ func (q *qemuV) startVM(vnicName, ipAddress, cephID string, fds []*os.File) erro
 			}
 			networkParams = append(networkParams, macvtapParam...)
 			defer cleanupFds(fds, len(fds))
+		} else if q.cfg.NICModel == payloads.E1000 {
+			var err error
+			var tapParam []string
+			tapParam, fds, err = computeE1000TapParam(fds, vnicName, q.cfg.VnicMAC)
+			if err != nil {
+				return err
+			}
+			networkParams = append(networkParams, tapParam...)
 		} else {
 			var err error
 			var tapParam []string
@@ -428,7 +515,11 @@ func (q *qemuV) startVM(vnicName, ipAddress, cephID string, fds []*os.File) erro
 			defer cleanupFds(toClose, len(toClose))
 		}
 	} else {
-		networkParams = append(networkParams, "-net", "nic,model=virtio")
+		model := "virtio"
+		if q.cfg.NICModel == payloads.E1000 {
+			model = "e1000"
+		}
+		networkParams = append(networkParams, "-net", "nic,model="+model)
 		networkParams = append(networkParams, "-net", "user")
 	}
 
@@ -489,8 +580,12 @@ func qmpAttach(cmd virtualizerAttachCmd, q *qemu.QMP) {
 		glog.Errorf("Failed to execute blockdev-add: %v", err)
 	} else {
 		devID := fmt.Sprintf("device_%s", cmd.volumeUUID)
+		driver, bus := "virtio-blk-pci", ""
+		if cmd.diskModel == payloads.VirtioSCSI {
+			driver, bus = "scsi-hd", scsiController+".0"
+		}
 		err = q.ExecuteDeviceAdd(context.Background(), blockdevID,
-			devID, "virtio-blk-pci", "")
+			devID, driver, bus)
 		if err != nil {
 			glog.Errorf("Failed to execute device_add: %v", err)
 			if err := q.ExecuteBlockdevDel(context.Background(), blockdevID); err != nil {
@@ -501,6 +596,193 @@ func qmpAttach(cmd virtualizerAttachCmd, q *qemu.QMP) {
 	cmd.responseCh <- err
 }
 
+// qemuMemSlots returns the number of DIMM slots needed to hot add memory,
+// in steps of at least qemuMemSlotMB, up to the maximum memory of an
+// instance.
+func qemuMemSlots(cfg *vmConfig) int {
+	slots := (cfg.MaxMem - cfg.Mem + qemuMemSlotMB - 1) / qemuMemSlotMB
+	if slots > qemuMaxMemSlots {
+		slots = qemuMaxMemSlots
+	}
+	return slots
+}
+
+func qmpHotAddCPUs(cpus int, q *qemu.QMP) error {
+	hotpluggable, err := q.ExecuteQueryHotpluggableCPUs(context.Background())
+	if err != nil {
+		return fmt.Errorf("Failed to execute query-hotpluggable-cpus: %v", err)
+	}
+
+	var free []qemu.HotpluggableCPU
+	for _, c := range hotpluggable {
+		if c.QOMPath == "" {
+			free = append(free, c)
+		}
+	}
+
+	if len(free) < cpus {
+		return fmt.Errorf("Only %d vCPUs can be hot added, %d requested", len(free), cpus)
+	}
+
+	var added []string
+	for _, c := range free[:cpus] {
+		p := c.Properties
+		cpuID := fmt.Sprintf("cpu-%d-%d-%d", p.Socket, p.Core, p.Thread)
+		err = q.ExecuteCPUDeviceAdd(context.Background(), c.Type, cpuID,
+			strconv.Itoa(p.Socket), strconv.Itoa(p.Core), strconv.Itoa(p.Thread))
+		if err != nil {
+			break
+		}
+		added = append(added, cpuID)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	for _, cpuID := range added {
+		if err := q.ExecuteDeviceDel(context.Background(), cpuID); err != nil {
+			glog.Warningf("Failed to remove vCPU %s: %v", cpuID, err)
+		}
+	}
+
+	return fmt.Errorf("Failed to execute device_add: %v", err)
+}
+
+// qmpResponse is a reply to a QMP command, or an asynchronous event.
+type qmpResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// qmpSession is a QMP session on the hotplug socket of an instance, for
+// the commands govmm does not provide.
+type qmpSession struct {
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+func qmpDial(socket string) (*qmpSession, error) {
+	conn, err := net.DialTimeout("unix", socket, qmpHotplugTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(qmpHotplugTimeout))
+
+	s := &qmpSession{
+		conn: conn,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(conn),
+	}
+
+	var greeting struct {
+		QMP json.RawMessage `json:"QMP"`
+	}
+	err = s.dec.Decode(&greeting)
+	if err == nil && greeting.QMP == nil {
+		err = fmt.Errorf("Unexpected QMP greeting")
+	}
+	if err == nil {
+		err = s.execute("qmp_capabilities", nil)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// execute sends a command and waits for its reply, skipping the events
+// sent in the meantime.
+func (s *qmpSession) execute(command string, args map[string]interface{}) error {
+	cmd := map[string]interface{}{"execute": command}
+	if args != nil {
+		cmd["arguments"] = args
+	}
+
+	if err := s.enc.Encode(cmd); err != nil {
+		return err
+	}
+
+	for {
+		var r qmpResponse
+		if err := s.dec.Decode(&r); err != nil {
+			return err
+		}
+
+		if r.Error != nil {
+			return fmt.Errorf("%s failed: %s: %s", command, r.Error.Class, r.Error.Desc)
+		}
+
+		if r.Return != nil {
+			return nil
+		}
+	}
+}
+
+func (s *qmpSession) close() {
+	_ = s.conn.Close()
+}
+
+// qmpHotAddMemory adds a DIMM of memMB MiB, backed by the memory object
+// memID, to an instance through its hotplug socket.
+func qmpHotAddMemory(socket string, memID string, memMB int) error {
+	s, err := qmpDial(socket)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %v", socket, err)
+	}
+	defer s.close()
+
+	err = s.execute("object-add", map[string]interface{}{
+		"qom-type": "memory-backend-ram",
+		"id":       memID,
+		"props":    map[string]interface{}{"size": uint64(memMB) << 20},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.execute("device_add", map[string]interface{}{
+		"driver": "pc-dimm",
+		"id":     "dimm" + memID,
+		"memdev": memID,
+	})
+	if err != nil {
+		delErr := s.execute("object-del", map[string]interface{}{"id": memID})
+		if delErr != nil {
+			glog.Warningf("Failed to remove memory object %s: %v", memID, delErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func qmpHotAdd(cmd virtualizerHotAddCmd, q *qemu.QMP, hotplugSocket string) {
+	glog.Info("Hot add command received")
+
+	var err error
+	if cmd.memMB > 0 {
+		err = qmpHotAddMemory(hotplugSocket, cmd.memID, cmd.memMB)
+		if err != nil {
+			glog.Errorf("Failed to hot add memory: %v", err)
+		}
+	}
+
+	if err == nil && cmd.cpus > 0 {
+		err = qmpHotAddCPUs(cmd.cpus, q)
+		if err != nil {
+			glog.Errorf("Failed to hot add vCPUs: %v", err)
+		}
+	}
+	cmd.responseCh <- err
+}
+
 func qmpConnect(qmpChannel chan interface{}, instance, instanceDir string, closedCh chan struct{},
 	connectedCh chan struct{}, wg *sync.WaitGroup, boot bool) {
 
@@ -553,6 +835,8 @@ DONE:
 			}
 		case virtualizerAttachCmd:
 			qmpAttach(cmd, q)
+		case virtualizerHotAddCmd:
+			qmpHotAdd(cmd, q, path.Join(instanceDir, qemuHotplugSocket))
 		}
 	}
 }
@@ -605,6 +889,14 @@ func (q *qemuV) stats() (disk, memory, cpu int) {
 	return
 }
 
+func (q *qemuV) ioStats() (payloads.InstanceDiskIOStat, bool) {
+	if q.pid == 0 {
+		return payloads.InstanceDiskIOStat{}, false
+	}
+
+	return computeProcessIOStats(q.pid)
+}
+
 func (q *qemuV) connected() {
 	qmpSocket := path.Join(q.instanceDir, "socket")
 	var buf bytes.Buffer
@@ -18,7 +18,9 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
@@ -26,6 +28,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/ciao-project/ciao/payloads"
 )
 
 func genQEMUParams(networkParams []string) []string {
@@ -86,6 +90,177 @@ func TestGenerateQEMULaunchParams(t *testing.T) {
 	if !reflect.DeepEqual(params, genParams) {
 		t.Fatalf("%s and %s do not match", params, genParams)
 	}
+
+}
+
+func TestGenerateQEMULaunchParamsDeviceModels(t *testing.T) {
+	cfg := vmConfig{
+		Legacy:    true,
+		DiskModel: payloads.VirtioSCSI,
+		Machine:   payloads.MachineQ35,
+		Volumes:   []volumeConfig{{UUID: "vol1", Bootable: true}},
+	}
+
+	params := []string{
+		"-machine", "q35",
+		"-device", "virtio-scsi-pci,id=scsi0,bus=pcie.0,addr=0x3",
+		"-drive", "file=rbd:rbd/vol1:id=ciao,if=none,id=drive_vol1,format=raw",
+		"-device", "scsi-hd,bus=scsi0.0,id=device_vol1,drive=drive_vol1",
+	}
+	params = append(params, genQEMUParams(nil)...)
+
+	genParams := generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+
+	cfg.DiskModel = payloads.VirtioBlk
+	cfg.Machine = ""
+
+	params = []string{
+		"-drive", "file=rbd:rbd/vol1:id=ciao,if=none,id=drive_vol1,format=raw",
+		"-device", "virtio-blk-pci,scsi=off,bus=pci.0,addr=0x3,id=device_vol1,drive=drive_vol1",
+	}
+	params = append(params, genQEMUParams(nil)...)
+
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+}
+
+func TestGenerateQEMULaunchParamsHotAdd(t *testing.T) {
+	cfg := vmConfig{
+		Legacy:  true,
+		Mem:     512,
+		MaxMem:  2048,
+		Cpus:    2,
+		MaxCpus: 8,
+	}
+
+	params := genQEMUParams(nil)
+	params = append(params, "-qmp", "unix:/var/lib/ciao/instance/1/hotplug_socket,server,nowait",
+		"-m", "512,slots=12,maxmem=2048M", "-smp", "cpus=2,maxcpus=8")
+	genParams := generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+
+	cfg.MaxMem = 512
+	cfg.MaxCpus = 2
+
+	params = genQEMUParams(nil)
+	params = append(params, "-m", "512", "-smp", "cpus=2")
+	genParams = generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	if !reflect.DeepEqual(params, genParams) {
+		t.Fatalf("%s and %s do not match", params, genParams)
+	}
+}
+
+// serveHotplugSocket runs a fake QMP server on socket, which records the
+// commands it receives and fails those listed in failures.
+func serveHotplugSocket(t *testing.T, socket string, failures map[string]bool) chan []string {
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unable to open domain socket %s: %v", socket, err)
+	}
+
+	commandsCh := make(chan []string, 1)
+	go func() {
+		defer ln.Close()
+
+		var commands []string
+		defer func() { commandsCh <- commands }()
+
+		fd, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer fd.Close()
+
+		_ = fd.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintln(fd, `{ "QMP": { "version": { "qemu": { "micro": 0, "minor": 5, "major": 2}, "package": ""}, "capabilities": []}}`)
+
+		dec := json.NewDecoder(fd)
+		for {
+			var cmd struct {
+				Execute string `json:"execute"`
+			}
+			if dec.Decode(&cmd) != nil {
+				return
+			}
+			commands = append(commands, cmd.Execute)
+
+			if failures[cmd.Execute] {
+				fmt.Fprintln(fd, `{"error": {"class": "GenericError", "desc": "failed"}}`)
+				continue
+			}
+
+			fmt.Fprintln(fd, `{"timestamp": {"seconds": 1487084520, "microseconds": 332329}, "event": "ACPI_DEVICE_OST"}`)
+			fmt.Fprintln(fd, `{ "return": {}}`)
+		}
+	}()
+
+	return commandsCh
+}
+
+func TestQmpHotAddMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hotplug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	tests := []struct {
+		failures map[string]bool
+		commands []string
+	}{
+		{nil, []string{"qmp_capabilities", "object-add", "device_add"}},
+		{map[string]bool{"device_add": true}, []string{"qmp_capabilities", "object-add", "device_add", "object-del"}},
+		{map[string]bool{"object-add": true}, []string{"qmp_capabilities", "object-add"}},
+	}
+
+	for i, test := range tests {
+		socket := path.Join(dir, fmt.Sprintf("socket%d", i))
+		commandsCh := serveHotplugSocket(t, socket, test.failures)
+
+		err := qmpHotAddMemory(socket, "mem512", 256)
+		if (err != nil) != (test.failures != nil) {
+			t.Errorf("Unexpected result %v with failures %v", err, test.failures)
+		}
+
+		if commands := <-commandsCh; !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("Expected commands %v, got %v", test.commands, commands)
+		}
+	}
+
+	if err := qmpHotAddMemory(path.Join(dir, "missing"), "mem512", 256); err == nil {
+		t.Error("Memory hot added without a hotplug socket")
+	}
+}
+
+func TestComputeE1000TapParam(t *testing.T) {
+	fds := []*os.File{os.Stdin, os.Stdout}
+
+	params, qemuFds, err := computeE1000TapParam(fds, "vnic", "02:00:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"-netdev", "type=tap,fd=3,id=vnic",
+		"-device", "driver=e1000,netdev=vnic,mac=02:00:00:00:00:01"}
+	if !reflect.DeepEqual(params, expected) || len(qemuFds) != 1 {
+		t.Fatalf("Unexpected parameters %v, %d fds", params, len(qemuFds))
+	}
+
+	_, _, err = computeE1000TapParam(nil, "vnic", "02:00:00:00:00:01")
+	if err == nil {
+		t.Fatal("e1000 configured without a tap device")
+	}
 }
 
 func TestQmpConnectBadSocket(t *testing.T) {
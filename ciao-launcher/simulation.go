@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 )
 
@@ -79,6 +80,9 @@ VM:
 			if _, stopCmd := cmd.(virtualizerStopCmd); stopCmd {
 				break VM
 			}
+			if hotAddCmd, ok := cmd.(virtualizerHotAddCmd); ok {
+				hotAddCmd.responseCh <- nil
+			}
 		case <-s.killCh:
 			break VM
 		case <-ticker.C:
@@ -119,6 +123,10 @@ func (s *simulation) stats() (disk, memory, cpu int) {
 	return s.disk / 10, s.mem / 10, s.cpus / 10
 }
 
+func (s *simulation) ioStats() (payloads.InstanceDiskIOStat, bool) {
+	return payloads.InstanceDiskIOStat{}, false
+}
+
 func (s *simulation) connected() {
 	glog.Infof("connected\n")
 }
@@ -23,6 +23,7 @@ import (
 	"github.com/ciao-project/ciao/payloads"
 	"github.com/ciao-project/ciao/ssntp"
 	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
 )
 
 type cmdWrapper struct {
@@ -30,8 +31,22 @@ type cmdWrapper struct {
 	cmd      interface{}
 }
 type statusCmd struct{}
-type evacuateCmd struct{}
-type restoreCmd struct{}
+type evacuateCmd struct {
+	commandID string
+}
+type restoreCmd struct {
+	commandID string
+}
+type cacheImageCmd struct {
+	image string
+}
+type restartAgentCmd struct {
+	commandID string
+}
+type selfTestCmd struct {
+	commandID string
+}
+type configureCmd struct{}
 
 // serverConn is an abstract interface representing a connection to
 // a server.  It contains methods to connect to the server and to
@@ -100,46 +115,91 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 	switch cmd {
 	case ssntp.START:
 		start, cn, md := splitYaml(payload)
-		cfg, payloadErr := parseStartPayload(start)
+		cfg, commandID, payloadErr := parseStartPayload(start)
 		if payloadErr != nil {
 			startError := &startError{
 				payloadErr.err,
 				payloads.StartFailureReason(payloadErr.code),
 				false,
 			}
-			startError.send(client.conn, "")
+			startError.send(client.conn, "", commandID)
 			glog.Errorf("Unable to parse YAML: %v", payloadErr.err)
 			return
 		}
-		client.cmdCh <- &cmdWrapper{cfg.Instance, &insStartCmd{cn, md, frame, cfg, time.Now()}}
+		client.cmdCh <- &cmdWrapper{cfg.Instance, &insStartCmd{cn, md, frame, cfg, time.Now(), commandID}}
 	case ssntp.DELETE:
-		instance, stop, payloadErr := parseDeletePayload(payload)
+		instance, stop, commandID, payloadErr := parseDeletePayload(payload)
 		if payloadErr != nil {
 			deleteError := &deleteError{
 				payloadErr.err,
 				payloads.DeleteFailureReason(payloadErr.code),
+				commandID,
 			}
 			deleteError.send(client.conn, "")
 			glog.Errorf("Unable to parse YAML: %s", payloadErr.err)
 			return
 		}
-		client.cmdCh <- &cmdWrapper{instance, &insDeleteCmd{stop: stop}}
+		client.cmdCh <- &cmdWrapper{instance, &insDeleteCmd{stop: stop, commandID: commandID}}
 	case ssntp.AttachVolume:
-		instance, volume, payloadErr := parseAttachVolumePayload(payload)
+		instance, volume, pool, commandID, payloadErr := parseAttachVolumePayload(payload)
 		if payloadErr != nil {
 			attachVolumeError := &attachVolumeError{
 				payloadErr.err,
 				payloads.AttachVolumeFailureReason(payloadErr.code),
 			}
-			attachVolumeError.send(client.conn, "", "")
+			attachVolumeError.send(client.conn, "", "", commandID)
 			glog.Errorf("Unable to parse YAML: %s", payloadErr.err)
 			return
 		}
-		client.cmdCh <- &cmdWrapper{instance, &insAttachVolumeCmd{volume}}
+		client.cmdCh <- &cmdWrapper{instance, &insAttachVolumeCmd{volume, pool, commandID}}
+	case ssntp.HotAdd:
+		instance, vcpus, memMB, commandID, payloadErr := parseHotAddPayload(payload)
+		if payloadErr != nil {
+			hotAddError := &hotAddError{
+				payloadErr.err,
+				payloads.HotAddFailureReason(payloadErr.code),
+				0, 0,
+			}
+			hotAddError.send(client.conn, "", commandID)
+			glog.Errorf("Unable to parse YAML: %s", payloadErr.err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insHotAddCmd{vcpus, memMB, commandID}}
 	case ssntp.EVACUATE:
-		client.cmdCh <- &cmdWrapper{"", &evacuateCmd{}}
+		var evacuate payloads.Evacuate
+		if err := yaml.Unmarshal(payload, &evacuate); err != nil {
+			glog.Warningf("Unable to parse EVACUATE YAML: %v", err)
+		}
+		client.cmdCh <- &cmdWrapper{"", &evacuateCmd{evacuate.Evacuate.CommandID}}
 	case ssntp.Restore:
-		client.cmdCh <- &cmdWrapper{"", &restoreCmd{}}
+		var restore payloads.Restore
+		if err := yaml.Unmarshal(payload, &restore); err != nil {
+			glog.Warningf("Unable to parse Restore YAML: %v", err)
+		}
+		client.cmdCh <- &cmdWrapper{"", &restoreCmd{restore.Restore.CommandID}}
+	case ssntp.CacheImage:
+		var cache payloads.CacheImage
+		if err := yaml.Unmarshal(payload, &cache); err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{"", &cacheImageCmd{cache.CacheImage.Image}}
+	case ssntp.RestartAgent:
+		var restart payloads.RestartAgent
+		if err := yaml.Unmarshal(payload, &restart); err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{"", &restartAgentCmd{restart.RestartAgent.CommandID}}
+	case ssntp.SelfTest:
+		var selfTest payloads.SelfTest
+		if err := yaml.Unmarshal(payload, &selfTest); err != nil {
+			glog.Errorf("Unable to parse YAML: %v", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{"", &selfTestCmd{selfTest.SelfTest.CommandID}}
+	case ssntp.CONFIGURE:
+		client.cmdCh <- &cmdWrapper{"", &configureCmd{}}
 	}
 }
 
@@ -280,3 +280,82 @@ func TestAgentAttachVolume(t *testing.T) {
 
 	checkErrorPayload(t, &ac, state, ssntp.AttachVolume, ssntp.AttachVolumeFailure)
 }
+
+// Verify that the agentClient correctly processes ssntp.HotAdd
+//
+// Send the ssntp.HotAdd command to the agent client with a valid payload,
+// then send another ssntp.HotAdd command with an invalid payload.
+//
+// The command with the valid payload should be processed correctly and a
+// insHotAddCmd should be received on the agent's cmdCh.  The second
+// command with the invalid payload should result in a call to state.SendError.
+func TestAgentHotAdd(t *testing.T) {
+	state := &ssntpTestState{}
+	cmdCh := make(chan *cmdWrapper)
+	ac := agentClient{conn: state, cmdCh: cmdCh}
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		select {
+		case cmd := <-cmdCh:
+			hotAdd, ok := cmd.cmd.(*insHotAddCmd)
+			if !ok {
+				t.Errorf("Unexpected command received.  Expected hotAddCmd")
+			} else if hotAdd.vcpus != 2 || hotAdd.memMB != 512 {
+				t.Errorf("Unexpected resources %d vCPUs %d MB", hotAdd.vcpus, hotAdd.memMB)
+			}
+			if cmd.instance != testutil.InstanceUUID {
+				t.Errorf("Unexpected instanced.  Expected %s found %s",
+					testutil.InstanceUUID, cmd.instance)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timedout waiting for cmdCh")
+		}
+		wg.Done()
+	}()
+
+	frame := &ssntp.Frame{Payload: []byte(testutil.HotAddYaml)}
+	ac.CommandNotify(ssntp.HotAdd, frame)
+	wg.Wait()
+
+	checkErrorPayload(t, &ac, state, ssntp.HotAdd, ssntp.HotAddFailure)
+}
+
+// Verify that the agentClient correctly processes ssntp.SelfTest
+//
+// Send the ssntp.SelfTest command to the agent client with a valid payload.
+//
+// The command should be processed correctly and a selfTestCmd carrying the
+// command identifier should be received on the agent's cmdCh.
+func TestAgentSelfTest(t *testing.T) {
+	state := &ssntpTestState{}
+	cmdCh := make(chan *cmdWrapper)
+	ac := agentClient{conn: state, cmdCh: cmdCh}
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		select {
+		case cmd := <-cmdCh:
+			selfTest, ok := cmd.cmd.(*selfTestCmd)
+			if !ok {
+				t.Errorf("Unexpected command received.  Expected selfTestCmd")
+			} else if selfTest.commandID != testutil.NodeCommandID {
+				t.Errorf("Unexpected command ID %s", selfTest.commandID)
+			}
+			if cmd.instance != "" {
+				t.Errorf("Unexpected instance %s", cmd.instance)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timedout waiting for cmdCh")
+		}
+		wg.Done()
+	}()
+
+	frame := &ssntp.Frame{Payload: []byte(testutil.SelfTestYaml)}
+	ac.CommandNotify(ssntp.SelfTest, frame)
+	wg.Wait()
+}
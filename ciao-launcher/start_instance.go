@@ -100,6 +100,9 @@ func processStart(cmd *insStartCmd, instanceDir string, vm virtualizer, conn ser
 	}
 
 	st.backingImageCheck = time.Now()
+	if cfg.Container {
+		cachedImages.add(cfg.DockerImage)
+	}
 
 	if networking {
 		vnicCfg, err = createVnicCfg(cfg)
@@ -114,6 +117,7 @@ func processStart(cmd *insStartCmd, instanceDir string, vm virtualizer, conn ser
 		if err != nil {
 			return nil, &startError{err, payloads.NetworkFailure, cmd.cfg.Restart}
 		}
+		cfg.VnicName = vnicName
 		defer func() {
 			for _, f := range fds {
 				_ = f.Close()
@@ -0,0 +1,65 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+// storagePools lists the ceph pools of the cluster configuration which
+// can be reached from the node.  It is reported in the READY status so
+// that instances are only scheduled on nodes able to map their volumes.
+var storagePools []string
+
+// cephPool returns the name of the ceph pool of a volume, volumes without
+// pool living in the default rbd pool.
+func cephPool(pool string) string {
+	if pool == "" {
+		return "rbd"
+	}
+	return pool
+}
+
+// poolDriver returns the driver operating on the volumes of a ceph pool.
+func poolDriver(driver storage.BlockDriver, pool string) storage.BlockDriver {
+	if d, ok := driver.(storage.CephDriver); ok && pool != "" {
+		d.Pool = pool
+		return d
+	}
+
+	return driver
+}
+
+// probeStoragePools returns the ceph pools of the cluster configuration
+// which can be reached from the node.
+func probeStoragePools(pools []payloads.StoragePool) []string {
+	var reachable []string
+
+	for _, p := range pools {
+		if !simulate {
+			d := storage.CephDriver{ID: cephID, Pool: p.CephPool}
+			if err := d.CheckPool(); err != nil {
+				glog.Warningf("Storage pool %s unreachable: %v", p.Name, err)
+				continue
+			}
+		}
+
+		reachable = append(reachable, p.CephPool)
+	}
+
+	return reachable
+}
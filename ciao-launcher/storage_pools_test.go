@@ -0,0 +1,62 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	storage "github.com/ciao-project/ciao/ciao-storage"
+	"github.com/ciao-project/ciao/payloads"
+)
+
+func TestPoolDriver(t *testing.T) {
+	ceph := storage.CephDriver{ID: "ciao"}
+
+	d := poolDriver(ceph, "ssd")
+	if d != (storage.CephDriver{ID: "ciao", Pool: "ssd"}) {
+		t.Fatalf("Unexpected driver for ssd pool: %+v", d)
+	}
+
+	d = poolDriver(ceph, "")
+	if d != ceph {
+		t.Fatalf("Unexpected driver for default pool: %+v", d)
+	}
+
+	noop := &storage.NoopDriver{}
+	if poolDriver(noop, "ssd") != noop {
+		t.Fatal("Noop driver not used for ssd pool")
+	}
+
+	if cephPool("") != "rbd" || cephPool("ssd") != "ssd" {
+		t.Fatal("Unexpected ceph pool names")
+	}
+}
+
+func TestProbeStoragePools(t *testing.T) {
+	saved := simulate
+	simulate = true
+	defer func() { simulate = saved }()
+
+	pools := []payloads.StoragePool{
+		{Name: "fast", CephPool: "ssd"},
+		{Name: "slow", CephPool: "hdd"},
+	}
+
+	reachable := probeStoragePools(pools)
+	if !reflect.DeepEqual(reachable, []string{"ssd", "hdd"}) {
+		t.Fatalf("Unexpected reachable pools %v", reachable)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"errors"
 	"os"
 	"sync"
+
+	"github.com/ciao-project/ciao/payloads"
 )
 
 type virtualizerStopCmd struct{}
@@ -27,6 +29,16 @@ type virtualizerAttachCmd struct {
 	responseCh chan error
 	volumeUUID string
 	device     string
+	diskModel  payloads.DiskModel
+}
+
+// virtualizerHotAddCmd asks the monitor to hot add vCPUs or memory, in a
+// DIMM identified by memID, to a running instance.
+type virtualizerHotAddCmd struct {
+	responseCh chan error
+	cpus       int
+	memMB      int
+	memID      string
 }
 
 var errImageNotFound = errors.New("Image Not Found")
@@ -100,6 +112,10 @@ type virtualizer interface {
 	// cpu: Normalized CPU time of VM or container process
 	stats() (disk, memory, cpu int)
 
+	// Returns the cumulative disk I/O counters of the VM or container, or
+	// false if they are not known.
+	ioStats() (payloads.InstanceDiskIOStat, bool)
+
 	// connected is called by the instance go routine to inform the virtualizer that
 	// the VM is running.  The virtualizer can used this notification to perform some
 	// bookkeeping, for example determine the pid of the underlying process.  It may
@@ -21,34 +21,49 @@ import (
 	"os"
 	"path"
 
+	"github.com/ciao-project/ciao/payloads"
 	"github.com/golang/glog"
 )
 
 type volumeConfig struct {
 	UUID     string
 	Bootable bool
+	Pool     string
 }
 
 type vmConfig struct {
-	Cpus        int
-	Mem         int
-	Disk        int
-	Instance    string
-	DockerImage string
-	Legacy      bool
-	Container   bool
-	NetworkNode bool
-	VnicMAC     string
-	VnicIP      string
-	ConcIP      string
-	SubnetIP    string
-	TenantUUID  string
-	ConcUUID    string
-	VnicUUID    string
-	SSHPort     int
-	Volumes     []volumeConfig
-	Restart     bool
-	Privileged  bool
+	Cpus          int
+	Mem           int
+	MaxCpus       int
+	MaxMem        int
+	Disk          int
+	Instance      string
+	DockerImage   string
+	Legacy        bool
+	Container     bool
+	NetworkNode   bool
+	VnicMAC       string
+	VnicIP        string
+	ConcIP        string
+	SubnetIP      string
+	SubnetKey     string
+	TenantUUID    string
+	ConcUUID      string
+	VnicUUID      string
+	VnicName      string
+	SSHPort       int
+	Volumes       []volumeConfig
+	Restart       bool
+	Privileged    bool
+	NetworkConfig string
+	VendorData    string
+	StaticNetwork bool
+	DiskModel     payloads.DiskModel
+	NICModel      payloads.NICModel
+	Machine       payloads.MachineType
+	CPUModel      string
+	CPUFeatures   []string
+	HealthCheck   *payloads.HealthCheck
 }
 
 func loadVMConfig(instanceDir string) (*vmConfig, error) {
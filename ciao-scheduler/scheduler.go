@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -44,6 +45,12 @@ var prepare = flag.Bool("osprepare", false, "Install dependencies")
 var logDir = "/var/lib/ciao/logs/scheduler"
 var configURI = flag.String("configuration-uri", "file:///etc/ciao/configuration.yaml",
 	"Cluster configuration URI")
+var peerFrameRate = flag.Float64("peer-frame-rate", 0,
+	"Maximum number of frames per second handled from each agent, 0 for no limit")
+var peerFrameBurst = flag.Int("peer-frame-burst", 0,
+	"Number of frames an agent can send at once above -peer-frame-rate, defaults to one second worth of frames")
+var peerStatsInterval = flag.Duration("peer-stats-interval", 0,
+	"Interval between logs of the frames and bytes exchanged with each client, 0 to disable")
 
 type ssntpSchedulerServer struct {
 	// user config overrides ------------------------------------------
@@ -100,6 +107,10 @@ type nodeStat struct {
 	isNetNode   bool
 	networks    []payloads.NetworkStat
 	hostname    string
+	cpuFlags    map[string]bool
+	cpuModels   map[string]bool
+	pools       map[string]bool
+	images      map[string]bool
 }
 
 type controllerStatus uint8
@@ -434,6 +445,22 @@ func (sched *ssntpSchedulerServer) updateNodeStat(node *nodeStat, status ssntp.S
 		node.cpus = stats.CpusOnline
 		node.networks = stats.Networks
 		node.hostname = stats.NodeHostName
+		node.cpuFlags = make(map[string]bool, len(stats.CPUFlags))
+		for _, f := range stats.CPUFlags {
+			node.cpuFlags[cpuFeatureName(f)] = true
+		}
+		node.cpuModels = make(map[string]bool, len(stats.CPUModels))
+		for _, m := range stats.CPUModels {
+			node.cpuModels[m] = true
+		}
+		node.pools = make(map[string]bool, len(stats.StoragePools))
+		for _, p := range stats.StoragePools {
+			node.pools[p] = true
+		}
+		node.images = make(map[string]bool, len(stats.CachedImages))
+		for _, i := range stats.CachedImages {
+			node.images[i] = true
+		}
 
 		//any changes to the payloads.Ready struct should be
 		//accompanied by a change here
@@ -474,8 +501,11 @@ func (sched *ssntpSchedulerServer) StatusNotify(uuid string, status ssntp.Status
 
 type workResources struct {
 	instanceUUID string
+	commandID    string
 	diskReqMB    int
 	requirements payloads.WorkloadRequirements
+	excludeNodes []string
+	image        string
 }
 
 func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (workload workResources, err error) {
@@ -487,9 +517,12 @@ func (sched *ssntpSchedulerServer) getWorkloadResources(work *payloads.Start) (w
 	}
 
 	workload.requirements = work.Start.Requirements
+	workload.excludeNodes = work.Start.ExcludeNodes
+	workload.image = work.Start.DockerImage
 
 	// note the uuid
 	workload.instanceUUID = work.Start.InstanceUUID
+	workload.commandID = work.Start.CommandID
 
 	return workload, nil
 }
@@ -502,26 +535,117 @@ func (sched *ssntpSchedulerServer) workloadFits(node *nodeStat, workload *workRe
 		node.status == ssntp.READY &&
 		node.isNetNode == workload.requirements.NetworkNode {
 
-		if workload.requirements.Hostname != "" &&
-			workload.requirements.Hostname != node.hostname {
-			return false
+		for _, excluded := range workload.excludeNodes {
+			if excluded == node.uuid {
+				return false
+			}
+		}
+
+		return constraintsFit(node, workload)
+	}
+	return false
+}
+
+// constraintsFit checks that the referenced, locked nodeStat object
+// matches the placement constraints of a workload, regardless of the
+// resources currently available on the node.
+func constraintsFit(node *nodeStat, workload *workResources) bool {
+	if workload.requirements.Hostname != "" &&
+		workload.requirements.Hostname != node.hostname {
+		return false
+	}
+
+	if workload.requirements.NodeID != "" &&
+		workload.requirements.NodeID != node.uuid {
+		return false
+	}
+
+	return cpuFits(node, &workload.requirements) &&
+		poolsFit(node, &workload.requirements)
+}
+
+// unfitReason returns the reason why a workload fits none of the
+// unlocked nodeStat objects of a list, so that tenants can tell a
+// placement they asked for that cannot be satisfied from a lack of
+// capacity.  Only the nodes matching the placement constraints of the
+// workload are considered when looking for the missing resource.
+func unfitReason(nodes []*nodeStat, workload *workResources) payloads.StartFailureReason {
+	var matching, memory, disk bool
+
+	for _, node := range nodes {
+		node.mutex.Lock()
+		if constraintsFit(node, workload) {
+			matching = true
+			if node.memAvailMB >= workload.requirements.MemMB {
+				memory = true
+				if node.diskAvailMB >= workload.diskReqMB {
+					disk = true
+				}
+			}
 		}
+		node.mutex.Unlock()
+	}
 
-		if workload.requirements.NodeID != "" &&
-			workload.requirements.NodeID != node.uuid {
+	switch {
+	case !matching:
+		return payloads.UnsatisfiableConstraints
+	case !memory:
+		return payloads.NoNodeMemory
+	case !disk:
+		return payloads.NoNodeDisk
+	}
+
+	return payloads.FullCloud
+}
+
+// poolsFit checks that the referenced, locked nodeStat object can reach
+// all the ceph pools holding the volumes of a workload.
+func poolsFit(node *nodeStat, req *payloads.WorkloadRequirements) bool {
+	for _, p := range req.StoragePools {
+		if !node.pools[p] {
 			return false
 		}
+	}
+
+	return true
+}
+
+// cpuFeatureName returns the name of a CPU feature as listed in
+// /proc/cpuinfo.  QEMU also accepts dots and dashes in feature names,
+// e.g., sse4.1 and lahf-lm, where the kernel uses underscores.
+func cpuFeatureName(feature string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' {
+			return '_'
+		}
+		return r
+	}, feature)
+}
 
-		return true
+// cpuFits checks that the CPUs of the referenced, locked nodeStat object
+// can run the CPU model requested by a workload, with all the features
+// it enables.  Disabled features do not constrain the choice of node.
+func cpuFits(node *nodeStat, req *payloads.WorkloadRequirements) bool {
+	if req.CPUModel != "" && req.CPUModel != payloads.CPUModelHost &&
+		!node.cpuModels[req.CPUModel] {
+		return false
 	}
-	return false
+
+	for _, f := range req.CPUFeatures {
+		if strings.HasPrefix(f, "+") && !node.cpuFlags[cpuFeatureName(f[1:])] {
+			return false
+		}
+	}
+
+	return true
 }
 
-func (sched *ssntpSchedulerServer) sendStartFailureError(clientUUID string, instanceUUID string, reason payloads.StartFailureReason, restart bool) {
+func (sched *ssntpSchedulerServer) sendStartFailureError(clientUUID string, workload *workResources, reason payloads.StartFailureReason, restart bool) {
 	error := payloads.ErrorStartFailure{
-		InstanceUUID: instanceUUID,
+		InstanceUUID: workload.instanceUUID,
 		Reason:       reason,
 		Restart:      restart,
+		CommandID:    workload.commandID,
 	}
 
 	payload, err := yaml.Marshal(&error)
@@ -546,6 +670,10 @@ func (sched *ssntpSchedulerServer) getCommandConcentratorUUID(command ssntp.Comm
 		var cmd payloads.CommandReleasePublicIP
 		err := yaml.Unmarshal(payload, &cmd)
 		return cmd.ReleaseIP.ConcentratorUUID, err
+	case ssntp.NetworkPolicy:
+		var cmd payloads.CommandNetworkPolicy
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Policy.ConcentratorUUID, err
 	}
 }
 
@@ -614,10 +742,30 @@ func getWorkloadAgentUUID(sched *ssntpSchedulerServer, command ssntp.Command, pa
 		var cmd payloads.Restore
 		err := yaml.Unmarshal(payload, &cmd)
 		return "", cmd.Restore.WorkloadAgentUUID, err
+	case ssntp.CacheImage:
+		var cmd payloads.CacheImage
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.CacheImage.WorkloadAgentUUID, err
 	case ssntp.AttachVolume:
 		var cmd payloads.AttachVolume
 		err := yaml.Unmarshal(payload, &cmd)
 		return cmd.Attach.InstanceUUID, cmd.Attach.WorkloadAgentUUID, err
+	case ssntp.HotAdd:
+		var cmd payloads.HotAdd
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.HotAdd.InstanceUUID, cmd.HotAdd.WorkloadAgentUUID, err
+	case ssntp.RestartAgent:
+		var cmd payloads.RestartAgent
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.RestartAgent.WorkloadAgentUUID, err
+	case ssntp.SelfTest:
+		var cmd payloads.SelfTest
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.SelfTest.WorkloadAgentUUID, err
+	case ssntp.Reconfigure:
+		var cmd payloads.Reconfigure
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.Reconfigure.WorkloadAgentUUID, err
 	}
 }
 
@@ -649,10 +797,26 @@ func pickComputeNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 
 	if len(sched.cnList) == 0 {
 		glog.Errorf("No compute nodes connected, unable to start workload")
-		sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoComputeNodes, restart)
+		sched.sendStartFailureError(controllerUUID, workload, payloads.NoComputeNodes, restart)
 		return nil
 	}
 
+	/* Prefer the nodes caching the image, starting after the MRU */
+	if workload.image != "" {
+		n := len(sched.cnList)
+		for k := 1; k <= n; k++ {
+			i := (sched.cnMRUIndex + k) % n
+			node := sched.cnList[i]
+			node.mutex.Lock()
+			if node.images[workload.image] && sched.workloadFits(node, workload) == true {
+				sched.cnMRUIndex = i
+				sched.cnMRU = node
+				return node // locked nodeStat
+			}
+			node.mutex.Unlock()
+		}
+	}
+
 	/* First try nodes after the MRU */
 	if sched.cnMRUIndex != -1 && sched.cnMRUIndex < len(sched.cnList)-1 {
 		for i, node := range sched.cnList[sched.cnMRUIndex+1:] {
@@ -682,7 +846,7 @@ func pickComputeNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 		node.mutex.Unlock()
 	}
 
-	sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.FullCloud, restart)
+	sched.sendStartFailureError(controllerUUID, workload, unfitReason(sched.cnList, workload), restart)
 	return nil
 }
 
@@ -693,7 +857,7 @@ func pickNetworkNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 
 	if len(sched.nnList) == 0 {
 		glog.Errorf("No network nodes connected, unable to start network workload")
-		sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoNetworkNodes, restart)
+		sched.sendStartFailureError(controllerUUID, workload, payloads.NoNetworkNodes, restart)
 		return nil
 	}
 
@@ -726,7 +890,7 @@ func pickNetworkNode(sched *ssntpSchedulerServer, controllerUUID string, workloa
 		node.mutex.Unlock()
 	}
 
-	sched.sendStartFailureError(controllerUUID, workload.instanceUUID, payloads.NoNetworkNodes, restart)
+	sched.sendStartFailureError(controllerUUID, workload, payloads.NoNetworkNodes, restart)
 	return nil
 }
 
@@ -774,6 +938,25 @@ func startWorkload(sched *ssntpSchedulerServer, controllerUUID string, payload [
 	return dest, instanceUUID
 }
 
+// reconfigureNode sends the current cluster configuration back to the node
+// targeted by a Reconfigure command. The command itself is never forwarded.
+func (sched *ssntpSchedulerServer) reconfigureNode(payload []byte) (dest ssntp.ForwardDestination) {
+	dest.SetDecision(ssntp.Discard)
+
+	_, cnDestUUID, err := getWorkloadAgentUUID(sched, ssntp.Reconfigure, payload)
+	if err != nil || cnDestUUID == "" {
+		glog.Errorf("Bad %s command yaml from Controller, WorkloadAgentUUID == %s\n", ssntp.Reconfigure, cnDestUUID)
+		return
+	}
+
+	glog.V(2).Infof("Sending cluster configuration to %s\n", cnDestUUID)
+	if _, err := sched.ssntp.SendConfiguration(cnDestUUID); err != nil {
+		glog.Errorf("Unable to send cluster configuration to %s: %v\n", cnDestUUID, err)
+	}
+
+	return
+}
+
 func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command ssntp.Command, frame *ssntp.Frame) (dest ssntp.ForwardDestination) {
 	payload := frame.Payload
 	instanceUUID := ""
@@ -807,13 +990,25 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 		fallthrough
 	case ssntp.AttachVolume:
 		fallthrough
+	case ssntp.HotAdd:
+		fallthrough
+	case ssntp.RestartAgent:
+		fallthrough
+	case ssntp.SelfTest:
+		fallthrough
 	case ssntp.EVACUATE:
 		fallthrough
 	case ssntp.Restore:
+		fallthrough
+	case ssntp.CacheImage:
 		dest, instanceUUID = sched.fwdCmdToComputeNode(command, payload)
+	case ssntp.Reconfigure:
+		dest = sched.reconfigureNode(payload)
 	case ssntp.AssignPublicIP:
 		fallthrough
 	case ssntp.ReleasePublicIP:
+		fallthrough
+	case ssntp.NetworkPolicy:
 		dest = sched.fwdCmdToCNCI(command, payload)
 	default:
 		dest.SetDecision(ssntp.Discard)
@@ -1018,6 +1213,22 @@ func heartBeatLoop(sched *ssntpSchedulerServer) {
 	}
 }
 
+// logPeerStats logs the frames and bytes exchanged with each client
+// since their connection, and how long their frames were throttled.
+func logPeerStats(sched *ssntpSchedulerServer) {
+	for _, p := range sched.ssntp.PeerStats() {
+		glog.Infof("%s %s: received %d frames (%d bytes), sent %d frames (%d bytes), throttled %d frames for %v",
+			p.Role.String(), p.UUID, p.FramesReceived, p.BytesReceived, p.FramesSent, p.BytesSent,
+			p.FramesThrottled, p.ThrottledTime)
+	}
+}
+
+func peerStatsLoop(sched *ssntpSchedulerServer, interval time.Duration) {
+	for range time.Tick(interval) {
+		logPeerStats(sched)
+	}
+}
+
 func toggleDebug(sched *ssntpSchedulerServer) {
 	if len(sched.cpuprofile) != 0 {
 		f, err := os.Create(sched.cpuprofile)
@@ -1101,6 +1312,10 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.Restore,
 			CommandForward: sched,
 		},
+		{ // all CacheImage command are processed by the Command forwarder
+			Operand:        ssntp.CacheImage,
+			CommandForward: sched,
+		},
 		{ // all TenantAdded events are processed by the Event forwarder
 			Operand:      ssntp.TenantAdded,
 			EventForward: sched,
@@ -1117,6 +1332,34 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand: ssntp.AttachVolumeFailure,
 			Dest:    ssntp.Controller,
 		},
+		{ // all HotAdd command are processed by the Command forwarder
+			Operand:        ssntp.HotAdd,
+			CommandForward: sched,
+		},
+		{ // all HotAddFailure errors go to all Controllers
+			Operand: ssntp.HotAddFailure,
+			Dest:    ssntp.Controller,
+		},
+		{ // all RestartAgent command are processed by the Command forwarder
+			Operand:        ssntp.RestartAgent,
+			CommandForward: sched,
+		},
+		{ // all SelfTest command are processed by the Command forwarder
+			Operand:        ssntp.SelfTest,
+			CommandForward: sched,
+		},
+		{ // all Reconfigure command are processed by the Command forwarder
+			Operand:        ssntp.Reconfigure,
+			CommandForward: sched,
+		},
+		{ // all NodeCommandResult events go to all Controllers
+			Operand: ssntp.NodeCommandResult,
+			Dest:    ssntp.Controller,
+		},
+		{ // all TrafficReport events go to all Controllers
+			Operand: ssntp.TrafficReport,
+			Dest:    ssntp.Controller,
+		},
 		{ // all AssignPublicIP commands are processed by the Command forwarder
 			Operand:        ssntp.AssignPublicIP,
 			CommandForward: sched,
@@ -1125,6 +1368,10 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.ReleasePublicIP,
 			CommandForward: sched,
 		},
+		{ // all NetworkPolicy commands are processed by the Command forwarder
+			Operand:        ssntp.NetworkPolicy,
+			CommandForward: sched,
+		},
 	}
 }
 
@@ -1162,10 +1409,16 @@ func configSchedulerServer() (sched *ssntpSchedulerServer) {
 	toggleDebug(sched)
 
 	sched.config = &ssntp.Config{
-		CAcert:    *cacert,
-		Cert:      *cert,
-		ConfigURI: *configURI,
-		Log:       ssntp.Log,
+		CAcert:         *cacert,
+		Cert:           *cert,
+		ConfigURI:      *configURI,
+		Log:            ssntp.Log,
+		PeerFrameRate:  *peerFrameRate,
+		PeerFrameBurst: *peerFrameBurst,
+	}
+
+	if *peerStatsInterval > 0 {
+		go peerStatsLoop(sched, *peerStatsInterval)
 	}
 
 	setSSNTPForwardRules(sched)
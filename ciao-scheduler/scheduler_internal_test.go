@@ -208,6 +208,156 @@ func TestPickComputeNode(t *testing.T) {
 	}
 }
 
+func TestPickComputeNodeCPU(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	var work = createStartWorkload(2, 256, 10000)
+	work.Start.Requirements.CPUModel = "Skylake-Server"
+	work.Start.Requirements.CPUFeatures = []string{"+sse4.1", "-hle"}
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// compute node without the requested model
+	spinUpComputeNodeLarge(sched, 1)
+	node := PickComputeNode(sched, "", &resources, false)
+	if node != nil {
+		t.Error("found compute fit without the requested CPU model")
+	}
+
+	// compute node with the model but not the feature
+	spinUpComputeNodeLarge(sched, 2)
+	sched.cnMap["00000002"].cpuModels = map[string]bool{"Skylake-Server": true}
+	node = PickComputeNode(sched, "", &resources, false)
+	if node != nil {
+		t.Error("found compute fit without the requested CPU feature")
+	}
+
+	sched.cnMap["00000002"].cpuFlags = map[string]bool{"sse4_1": true}
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000002" {
+		t.Fatal("found no compute fit when one should exist")
+	}
+	node.mutex.Unlock()
+
+	// the host model runs anywhere
+	resources.requirements.CPUModel = payloads.CPUModelHost
+	resources.requirements.CPUFeatures = nil
+	sched.cnMRUIndex = -1
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000001" {
+		t.Fatal("host CPU model not fit on first node")
+	}
+	node.mutex.Unlock()
+}
+
+func TestPickComputeNodeStoragePools(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	var work = createStartWorkload(2, 256, 10000)
+	work.Start.Requirements.StoragePools = []string{"ssd"}
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// compute node not reaching the pool
+	spinUpComputeNodeLarge(sched, 1)
+	node := PickComputeNode(sched, "", &resources, false)
+	if node != nil {
+		t.Error("found compute fit without the requested storage pool")
+	}
+
+	spinUpComputeNodeLarge(sched, 2)
+	sched.cnMap["00000002"].pools = map[string]bool{"rbd": true, "ssd": true}
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000002" {
+		t.Fatal("found no compute fit when one should exist")
+	}
+	node.mutex.Unlock()
+
+	// workloads without volumes run anywhere
+	resources.requirements.StoragePools = nil
+	sched.cnMRUIndex = -1
+	node = PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000001" {
+		t.Fatal("workload without storage pools not fit on first node")
+	}
+	node.mutex.Unlock()
+}
+
+func TestPickComputeNodeCachedImage(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpComputeNodeLarge(sched, 1)
+	spinUpComputeNodeLarge(sched, 2)
+	sched.cnMap["00000002"].images = map[string]bool{"ubuntu:16.04": true}
+
+	var work = createStartWorkload(2, 256, 10000)
+	work.Start.DockerImage = "ubuntu:16.04"
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		node := PickComputeNode(sched, "", &resources, false)
+		if node == nil || node.uuid != "00000002" {
+			t.Fatal("node caching the image not preferred")
+		}
+		node.mutex.Unlock()
+	}
+
+	// fall back to other nodes when no node caches the image
+	resources.image = "fedora:25"
+	node := PickComputeNode(sched, "", &resources, false)
+	if node == nil || node.uuid != "00000001" {
+		t.Fatal("found no compute fit for an uncached image")
+	}
+	node.mutex.Unlock()
+}
+
+func TestPickComputeNodeExcluded(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpComputeNodeLarge(sched, 1)
+	spinUpComputeNodeLarge(sched, 2)
+
+	var work = createStartWorkload(2, 256, 10000)
+	work.Start.ExcludeNodes = []string{"00000001"}
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		node := PickComputeNode(sched, "", &resources, false)
+		if node == nil || node.uuid != "00000002" {
+			t.Fatal("excluded node picked or no fit found")
+		}
+		node.mutex.Unlock()
+	}
+
+	resources.excludeNodes = append(resources.excludeNodes, "00000002")
+	node := PickComputeNode(sched, "", &resources, false)
+	if node != nil {
+		t.Error("found compute fit on an excluded node")
+	}
+}
+
 func benchmarkPickComputeNode(b *testing.B, nodecount int) {
 	sched = configSchedulerServer()
 	if sched == nil {
@@ -747,6 +897,9 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		{ssntp.EVACUATE, []byte(testutil.EvacuateYaml), "", testutil.AgentUUID},
 		{ssntp.Restore, []byte(testutil.RestoreYaml), "", testutil.AgentUUID},
 		{ssntp.AttachVolume, []byte(testutil.AttachVolumeYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.HotAdd, []byte(testutil.HotAddYaml), testutil.InstanceUUID, testutil.AgentUUID},
+		{ssntp.RestartAgent, []byte(testutil.RestartAgentYaml), "", testutil.AgentUUID},
+		{ssntp.SelfTest, []byte(testutil.SelfTestYaml), "", testutil.AgentUUID},
 	}
 	for _, test := range stringTests {
 		instanceUUID, agentUUID, _ := GetWorkloadAgentUUID(sched, test.cmd, test.yaml)
@@ -758,3 +911,70 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCommandConcentratorUUID(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	var stringTests = []struct {
+		cmd  ssntp.Command
+		yaml []byte
+	}{
+		{ssntp.AssignPublicIP, []byte(testutil.AssignIPYaml)},
+		{ssntp.ReleasePublicIP, []byte(testutil.ReleaseIPYaml)},
+		{ssntp.NetworkPolicy, []byte(testutil.NetworkPolicyYaml)},
+	}
+	for _, test := range stringTests {
+		concentratorUUID, err := sched.getCommandConcentratorUUID(test.cmd, test.yaml)
+		if err != nil {
+			t.Errorf("failed to get concentratorUUID of %s: %v", test.cmd, err)
+		}
+		if concentratorUUID != testutil.CNCIUUID {
+			t.Errorf("failed to get correct concentratorUUID, expected %s, got %s", testutil.CNCIUUID, concentratorUUID)
+		}
+	}
+}
+
+func TestUnfitReason(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	spinUpComputeNodeVerySmall(sched, 1)
+	spinUpComputeNodeLarge(sched, 2)
+
+	var work = createStartWorkload(2, 256, 10000)
+	work.Start.Requirements.NodeID = "00000001"
+	resources, err := sched.getWorkloadResources(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reason := unfitReason(sched.cnList, &resources)
+	if reason != payloads.NoNodeMemory {
+		t.Errorf("expected %s, got %s", payloads.NoNodeMemory, reason)
+	}
+
+	resources.requirements.NodeID = "00000002"
+	resources.diskReqMB = 1024
+	reason = unfitReason(sched.cnList, &resources)
+	if reason != payloads.NoNodeDisk {
+		t.Errorf("expected %s, got %s", payloads.NoNodeDisk, reason)
+	}
+
+	sched.cnMap["00000002"].diskAvailMB = 2048
+	sched.cnMap["00000002"].status = ssntp.FULL
+	reason = unfitReason(sched.cnList, &resources)
+	if reason != payloads.FullCloud {
+		t.Errorf("expected %s, got %s", payloads.FullCloud, reason)
+	}
+
+	resources.requirements.NodeID = "00000003"
+	reason = unfitReason(sched.cnList, &resources)
+	if reason != payloads.UnsatisfiableConstraints {
+		t.Errorf("expected %s, got %s", payloads.UnsatisfiableConstraints, reason)
+	}
+}
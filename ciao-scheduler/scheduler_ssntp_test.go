@@ -453,6 +453,17 @@ func TestPublicIPUnassigned(t *testing.T) {
 	}
 }
 
+func TestTrafficReport(t *testing.T) {
+	controllerCh := controller.AddEventChan(ssntp.TrafficReport)
+
+	go cnciAgent.SendTrafficReportEvent()
+
+	_, err := controller.GetEventChanResult(controllerCh, ssntp.TrafficReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func waitForController(uuid string) {
 	for {
 		server.controllerMutex.Lock()
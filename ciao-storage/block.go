@@ -37,6 +37,8 @@ type BlockDriver interface {
 	GetBlockDeviceSize(volumeUUID string) (uint64, error)
 	IsValidSnapshotUUID(string) error
 	Resize(volumeUUID string, sizeGiB int) (int, error)
+	ListBlockDevices() ([]BlockDevice, error)
+	FlattenBlockDevice(volumeUUID string) error
 }
 
 // BlockDevice contains information about a block device
@@ -50,3 +52,21 @@ type BlockDevice struct {
 	Tag       string `json:"-"`          // arbitrary text identifier
 	Size      int    `json:"size"`       // size in GiB
 }
+
+// PoolCapacity is the capacity of the storage pool of a block driver.
+type PoolCapacity struct {
+	TotalGiB     int `json:"total_gib"`
+	UsedGiB      int `json:"used_gib"`
+	AvailableGiB int `json:"available_gib"`
+}
+
+// PoolDriver is implemented by the block drivers able to report on the
+// storage pool holding their block devices.
+type PoolDriver interface {
+	// Capacity returns the capacity of the storage pool.
+	Capacity() (PoolCapacity, error)
+
+	// CheckPool returns an error if the storage pool cannot be
+	// reached from the node.
+	CheckPool() error
+}
@@ -30,6 +30,24 @@ import (
 type CephDriver struct {
 	// ID is the cephx user ID to use
 	ID string
+
+	// Pool is the ceph pool holding the rbd images, the default rbd
+	// pool when empty.
+	Pool string
+
+	// SnapshotPool is the ceph pool holding the snapshots from which
+	// block devices are cloned, Pool when empty.
+	SnapshotPool string
+}
+
+func sizeGiB(bytes uint64) int {
+	// When converting to GiB round up unless we've got a multiple of 1GiB
+	res := bytes / (1024 * 1024 * 1024)
+	rem := bytes % (1024 * 1024 * 1024)
+	if rem == 0 {
+		return int(res)
+	}
+	return int(res + 1)
 }
 
 func (d CephDriver) getBlockDeviceSizeGiB(volumeUUID string) (int, error) {
@@ -39,13 +57,7 @@ func (d CephDriver) getBlockDeviceSizeGiB(volumeUUID string) (int, error) {
 		return 0, err
 	}
 
-	// When converting to GiB round up unless we've got a multiple of 1GiB
-	res := bytes / (1024 * 1024 * 1024)
-	rem := bytes % (1024 * 1024 * 1024)
-	if rem == 0 {
-		return int(res), nil
-	}
-	return int(res + 1), nil
+	return sizeGiB(bytes), nil
 }
 
 // CreateBlockDevice will create a rbd image in the ceph cluster.
@@ -65,11 +77,12 @@ func (d CephDriver) CreateBlockDevice(volumeUUID string, imagePath string, size
 	// Currently the kernel rdb client only supports layering but in the future more feaures
 	// should be added as they are enabled in the kernel.
 	if imagePath != "" {
-		rbdStr := fmt.Sprintf("rbd:rbd/%s:id=%s", volumeUUID, d.ID)
+		rbdStr := fmt.Sprintf("rbd:%s/%s:id=%s", d.pool(), volumeUUID, d.ID)
 		cmd = exec.Command("qemu-img", "convert", "-O", "rbd", imagePath, rbdStr)
 	} else {
 		// create an empty volume
-		cmd = exec.Command("rbd", "--id", d.ID, "--image-feature", "layering", "create", "--size", strconv.Itoa(size)+"G", volumeUUID)
+		args := append(d.getImageArgs(), "--image-feature", "layering", "create", "--size", strconv.Itoa(size)+"G", volumeUUID)
+		cmd = exec.Command("rbd", args...)
 	}
 
 	out, err := cmd.CombinedOutput()
@@ -86,33 +99,48 @@ func (d CephDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID
 
 	var cmd *exec.Cmd
 
-	cmd = exec.Command("rbd", "--id", d.ID, "clone", volumeUUID+"@"+snapshotID, ID)
+	cmd = exec.Command("rbd", "--id", d.ID, "clone", d.snapshotPool()+"/"+volumeUUID+"@"+snapshotID, d.pool()+"/"+ID)
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	size, err := d.getBlockDeviceSizeGiB(volumeUUID)
+	// clones have the size of the image they are cloned from
+	size, err := d.getBlockDeviceSizeGiB(ID)
 	if err != nil {
-		d.DeleteBlockDevice(volumeUUID)
+		d.DeleteBlockDevice(ID)
 		return BlockDevice{}, fmt.Errorf("Error when querying block device size: %v", err)
 	}
 
 	return BlockDevice{ID: ID, Size: size}, nil
 }
 
+// FlattenBlockDevice copies the data of the snapshot a rbd image was
+// cloned from into the image, so that it no longer depends on the
+// snapshot.
+func (d CephDriver) FlattenBlockDevice(volumeUUID string) error {
+	args := append(d.getImageArgs(), "flatten", "--no-progress", volumeUUID)
+	cmd := exec.Command("rbd", args...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+	return nil
+}
+
 // CreateBlockDeviceSnapshot creates and protects the snapshot with the provided name
 func (d CephDriver) CreateBlockDeviceSnapshot(volumeUUID string, snapshotID string) error {
 	var cmd *exec.Cmd
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "create", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", append(d.getImageArgs(), "snap", "create", volumeUUID+"@"+snapshotID)...)
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "protect", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", append(d.getImageArgs(), "snap", "protect", volumeUUID+"@"+snapshotID)...)
 
 	out, err = cmd.CombinedOutput()
 	if err != nil {
@@ -128,7 +156,7 @@ func (d CephDriver) CopyBlockDevice(volumeUUID string) (BlockDevice, error) {
 
 	var cmd *exec.Cmd
 
-	cmd = exec.Command("rbd", "--id", d.ID, "cp", volumeUUID, ID)
+	cmd = exec.Command("rbd", append(d.getImageArgs(), "cp", volumeUUID, ID)...)
 
 	out, err := cmd.CombinedOutput()
 	if err != nil {
@@ -146,7 +174,7 @@ func (d CephDriver) CopyBlockDevice(volumeUUID string) (BlockDevice, error) {
 
 // DeleteBlockDevice will remove a rbd image from the ceph cluster.
 func (d CephDriver) DeleteBlockDevice(volumeUUID string) error {
-	cmd := exec.Command("rbd", "--id", d.ID, "rm", volumeUUID)
+	cmd := exec.Command("rbd", append(d.getImageArgs(), "rm", volumeUUID)...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
@@ -158,13 +186,13 @@ func (d CephDriver) DeleteBlockDevice(volumeUUID string) error {
 func (d CephDriver) DeleteBlockDeviceSnapshot(volumeUUID string, snapshotID string) error {
 	var cmd *exec.Cmd
 
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "unprotect", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", append(d.getImageArgs(), "snap", "unprotect", volumeUUID+"@"+snapshotID)...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
 	}
 
-	cmd = exec.Command("rbd", "--id", d.ID, "snap", "rm", volumeUUID+"@"+snapshotID)
+	cmd = exec.Command("rbd", append(d.getImageArgs(), "snap", "rm", volumeUUID+"@"+snapshotID)...)
 	out, err = cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
@@ -174,7 +202,7 @@ func (d CephDriver) DeleteBlockDeviceSnapshot(volumeUUID string, snapshotID stri
 
 // GetBlockDeviceSize returns the number of bytes used by the block device
 func (d CephDriver) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
-	args := append(d.getCredentials(), "info", "--format", "json", volumeUUID)
+	args := append(d.getImageArgs(), "info", "--format", "json", volumeUUID)
 	cmd := exec.Command("rbd", args...)
 	data, err := cmd.Output()
 	if err != nil {
@@ -203,10 +231,34 @@ func (d CephDriver) getCredentials() []string {
 	return args
 }
 
+// getImageArgs returns the credentials and pool arguments of the rbd
+// commands operating on the images of the pool.
+func (d CephDriver) getImageArgs() []string {
+	args := d.getCredentials()
+	if d.Pool != "" {
+		args = append(args, "--pool", d.Pool)
+	}
+	return args
+}
+
+func (d CephDriver) pool() string {
+	if d.Pool == "" {
+		return "rbd"
+	}
+	return d.Pool
+}
+
+func (d CephDriver) snapshotPool() string {
+	if d.SnapshotPool == "" {
+		return d.pool()
+	}
+	return d.SnapshotPool
+}
+
 // MapVolumeToNode maps a ceph volume to a rbd device on a node.  The
 // path to the new device is returned if the mapping succeeds.
 func (d CephDriver) MapVolumeToNode(volumeUUID string) (string, error) {
-	args := append(d.getCredentials(), "map", volumeUUID)
+	args := append(d.getImageArgs(), "map", volumeUUID)
 	cmd := exec.Command("rbd", args...)
 	data, err := cmd.Output()
 	if err != nil {
@@ -221,7 +273,7 @@ func (d CephDriver) MapVolumeToNode(volumeUUID string) (string, error) {
 
 // UnmapVolumeFromNode unmaps a ceph volume from a local device on a node.
 func (d CephDriver) UnmapVolumeFromNode(volumeUUID string) error {
-	args := append(d.getCredentials(), "unmap", volumeUUID)
+	args := append(d.getImageArgs(), "unmap", volumeUUID)
 	cmd := exec.Command("rbd", args...)
 
 	out, err := cmd.CombinedOutput()
@@ -261,6 +313,41 @@ func (d CephDriver) GetVolumeMapping() (map[string][]string, error) {
 	return volumeDevMap, nil
 }
 
+// ListBlockDevices returns the rbd images of the ceph pool along with their
+// size in GiB. Snapshots are not included.
+func (d CephDriver) ListBlockDevices() ([]BlockDevice, error) {
+	args := append(d.getImageArgs(), "ls", "--long", "--format", "json")
+	cmd := exec.Command("rbd", args...)
+	data, err := cmd.Output()
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, err.Stderr)
+		}
+		return nil, fmt.Errorf("Error when running: %v: %v", cmd.Args, err)
+	}
+
+	images := []struct {
+		Image    string `json:"image"`
+		Snapshot string `json:"snapshot"`
+		Size     uint64 `json:"size"`
+	}{}
+	err = json.Unmarshal([]byte(data), &images)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse output from rbd ls: %v", err)
+	}
+
+	var devices []BlockDevice
+	for _, i := range images {
+		if i.Snapshot != "" {
+			continue
+		}
+
+		devices = append(devices, BlockDevice{ID: i.Image, Size: sizeGiB(i.Size)})
+	}
+
+	return devices, nil
+}
+
 // IsValidSnapshotUUID returns true if the uuid matches the ciao/ceph expected
 // form of {UUID}@{UUID}
 func (d CephDriver) IsValidSnapshotUUID(snapshotUUID string) error {
@@ -279,7 +366,7 @@ func (d CephDriver) IsValidSnapshotUUID(snapshotUUID string) error {
 
 // Resize the underlying rbd image. Only extending is permitted. Returns the new size in GiB.
 func (d CephDriver) Resize(volumeUUID string, sizeGiB int) (int, error) {
-	args := append(d.getCredentials(), "resize", volumeUUID, "--no-progress", "-s", fmt.Sprintf("%dG", sizeGiB))
+	args := append(d.getImageArgs(), "resize", volumeUUID, "--no-progress", "-s", fmt.Sprintf("%dG", sizeGiB))
 	cmd := exec.Command("rbd", args...)
 
 	out, err := cmd.CombinedOutput()
@@ -290,3 +377,67 @@ func (d CephDriver) Resize(volumeUUID string, sizeGiB int) (int, error) {
 	size, _ := d.getBlockDeviceSizeGiB(volumeUUID)
 	return size, err
 }
+
+// Capacity returns the capacity of the ceph pool, as reported by ceph df.
+// Pools share the raw capacity of the cluster, the space available in a
+// pool depending on its replication.
+func (d CephDriver) Capacity() (PoolCapacity, error) {
+	args := append(d.getCredentials(), "df", "--format", "json")
+	cmd := exec.Command("ceph", args...)
+	data, err := cmd.Output()
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return PoolCapacity{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, err.Stderr)
+		}
+		return PoolCapacity{}, fmt.Errorf("Error when running: %v: %v", cmd.Args, err)
+	}
+
+	return parseCephDF(data, d.pool())
+}
+
+func parseCephDF(data []byte, pool string) (PoolCapacity, error) {
+	df := struct {
+		Pools []struct {
+			Name  string `json:"name"`
+			Stats struct {
+				BytesUsed uint64 `json:"bytes_used"`
+				MaxAvail  uint64 `json:"max_avail"`
+			} `json:"stats"`
+		} `json:"pools"`
+	}{}
+
+	err := json.Unmarshal(data, &df)
+	if err != nil {
+		return PoolCapacity{}, fmt.Errorf("Unable to parse output from ceph df: %v", err)
+	}
+
+	for _, p := range df.Pools {
+		if p.Name != pool {
+			continue
+		}
+
+		// available space is rounded down, unlike block devices sizes
+		used := sizeGiB(p.Stats.BytesUsed)
+		avail := int(p.Stats.MaxAvail / (1024 * 1024 * 1024))
+
+		return PoolCapacity{
+			TotalGiB:     used + avail,
+			UsedGiB:      used,
+			AvailableGiB: avail,
+		}, nil
+	}
+
+	return PoolCapacity{}, fmt.Errorf("Pool %s not found", pool)
+}
+
+// CheckPool checks that the images of the ceph pool can be listed.
+func (d CephDriver) CheckPool() error {
+	args := append(d.getImageArgs(), "ls")
+	cmd := exec.Command("rbd", args...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "testing"
+
+const cephDFOutput = `{
+	"stats": {"total_bytes": 32212254720, "total_used_bytes": 3221225472, "total_avail_bytes": 28991029248},
+	"pools": [
+		{"name": "rbd", "id": 0, "stats": {"kb_used": 1048576, "bytes_used": 1073741824, "max_avail": 9663676416, "objects": 260}},
+		{"name": "ssd", "id": 1, "stats": {"kb_used": 0, "bytes_used": 1, "max_avail": 4294967295, "objects": 1}}
+	]
+}`
+
+func TestParseCephDF(t *testing.T) {
+	c, err := parseCephDF([]byte(cephDFOutput), "rbd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != (PoolCapacity{TotalGiB: 10, UsedGiB: 1, AvailableGiB: 9}) {
+		t.Fatalf("Unexpected rbd capacity %+v", c)
+	}
+
+	c, err = parseCephDF([]byte(cephDFOutput), "ssd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != (PoolCapacity{TotalGiB: 4, UsedGiB: 1, AvailableGiB: 3}) {
+		t.Fatalf("Unexpected ssd capacity %+v", c)
+	}
+
+	_, err = parseCephDF([]byte(cephDFOutput), "hdd")
+	if err == nil {
+		t.Fatal("Capacity of unknown pool returned")
+	}
+}
@@ -79,6 +79,12 @@ func (d *NoopDriver) GetVolumeMapping() (map[string][]string, error) {
 	return nil, nil
 }
 
+// ListBlockDevices returns an empty slice, as the noop driver does not
+// keep track of the block devices it pretends to create.
+func (d *NoopDriver) ListBlockDevices() ([]BlockDevice, error) {
+	return nil, nil
+}
+
 // IsValidSnapshotUUID checks for the Ciao standard snapshot uuid form of
 // {UUID}@{UUID}
 func (d *NoopDriver) IsValidSnapshotUUID(snapshotUUID string) error {
@@ -99,3 +105,19 @@ func (d *NoopDriver) IsValidSnapshotUUID(snapshotUUID string) error {
 func (d *NoopDriver) Resize(volumeUUID string, sizeGiB int) (int, error) {
 	return sizeGiB, nil
 }
+
+// Capacity pretends to return the capacity of the storage pool.
+func (d *NoopDriver) Capacity() (PoolCapacity, error) {
+	return PoolCapacity{TotalGiB: 1024, AvailableGiB: 1024}, nil
+}
+
+// CheckPool pretends the storage pool can be reached.
+func (d *NoopDriver) CheckPool() error {
+	return nil
+}
+
+// FlattenBlockDevice pretends to copy the data of the parent of a block
+// device into the block device.
+func (d *NoopDriver) FlattenBlockDevice(volumeUUID string) error {
+	return nil
+}
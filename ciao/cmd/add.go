@@ -17,6 +17,7 @@ package cmd
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -39,6 +40,15 @@ func addIPList(name string, IPs []string) error {
 }
 
 func addIPSubnet(name, address string) error {
+	// ranges of addresses are expanded and validated by the controller
+	if strings.Contains(address, "-") {
+		err := c.AddExternalIPRange(name, address)
+		if err != nil {
+			return errors.Wrap(err, "Error adding external IP range")
+		}
+		return nil
+	}
+
 	// verify it's a good subnet address, if not try parsing as regular IP
 	_, network, err := net.ParseCIDR(address)
 	if err == nil {
@@ -59,9 +69,9 @@ func addIPSubnet(name, address string) error {
 }
 
 var addExternalIPCmd = &cobra.Command{
-	Use:   "external-ip POOL SUBNET or IP",
+	Use:   "external-ip POOL SUBNET or RANGE or IP",
 	Short: "Add IP to external IP pool",
-	Long:  `Add an external IP address to a pool. This command takes either a subnet in CIDR format of a list of IPs.`,
+	Long:  `Add an external IP address to a pool. This command takes either a subnet in CIDR format, a range of IPs such as 192.0.2.10-192.0.2.50 or a list of IPs.`,
 	Args:  cobra.MinimumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) > 2 {
@@ -0,0 +1,50 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ciao-project/ciao/ciao-controller/types"
+)
+
+// confirmDryRun shows what a destructive operation would remove and asks
+// the user to confirm it, unless yes is set.
+func confirmDryRun(res types.DryRunResult, yes bool) bool {
+	var keys []string
+	for k := range res.Impact {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("Operation %s on %s will remove:\n", res.Operation, res.Target)
+	for _, k := range keys {
+		fmt.Printf("\t%s: %d\n", strings.Replace(k, "_", " ", -1), res.Impact[k])
+	}
+
+	if yes {
+		return true
+	}
+
+	fmt.Printf("Proceed? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
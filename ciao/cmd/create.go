@@ -15,9 +15,12 @@
 package cmd
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
@@ -50,6 +53,13 @@ var tenantFlags = struct {
 	cidrPrefixSize             int
 	name                       string
 	createPrivilegedContainers bool
+	notifyMode                 string
+	notifyEmail                string
+	notifyDigestMinutes        int
+	maxInstancesPerRequest     int
+	warmPools                  []string
+	networkPolicy              string
+	networkRules               []string
 }{}
 
 var volFlags = struct {
@@ -179,11 +189,23 @@ var tenantCreateCmd = &cobra.Command{
 			return errors.New("Tenant ID must be a UUID")
 		}
 
+		warmPools, err := parseWarmPools(tenantFlags.warmPools)
+		if err != nil {
+			return err
+		}
+
 		config := types.TenantConfig{
-			Name:       tenantFlags.name,
-			SubnetBits: tenantFlags.cidrPrefixSize,
+			Name:                   tenantFlags.name,
+			SubnetBits:             tenantFlags.cidrPrefixSize,
+			MaxInstancesPerRequest: tenantFlags.maxInstancesPerRequest,
+			WarmPools:              warmPools,
 		}
 		config.Permissions.PrivilegedContainers = tenantFlags.createPrivilegedContainers
+		config.Notifications = types.NotificationConfig{
+			Mode:          types.NotificationMode(tenantFlags.notifyMode),
+			Email:         tenantFlags.notifyEmail,
+			DigestMinutes: tenantFlags.notifyDigestMinutes,
+		}
 
 		summary, err := c.CreateTenantConfig(tuuid.String(), config)
 		if err != nil {
@@ -194,6 +216,53 @@ var tenantCreateCmd = &cobra.Command{
 	},
 }
 
+// parseWarmPools parses the workload=size warm pools of a tenant.
+func parseWarmPools(pools []string) (map[string]int, error) {
+	var warmPools map[string]int
+
+	for _, pool := range pools {
+		p := strings.SplitN(pool, "=", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("Invalid warm pool %q", pool)
+		}
+
+		size, err := strconv.Atoi(p[1])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Invalid size of warm pool %q", pool)
+		}
+
+		if warmPools == nil {
+			warmPools = make(map[string]int)
+		}
+		warmPools[p[0]] = size
+	}
+
+	return warmPools, nil
+}
+
+// parseNetworkPolicy builds a network policy from a mode and source:destination
+// rules. Rules without a mode imply the rules mode.
+func parseNetworkPolicy(mode string, rules []string) (types.NetworkPolicy, error) {
+	policy := types.NetworkPolicy{Mode: types.NetworkPolicyMode(mode)}
+	if policy.Mode == "" && len(rules) > 0 {
+		policy.Mode = types.NetworkRules
+	}
+
+	for _, rule := range rules {
+		r := strings.SplitN(rule, ":", 2)
+		if len(r) != 2 || r[0] == "" || r[1] == "" {
+			return policy, fmt.Errorf("Invalid network rule %q", rule)
+		}
+
+		policy.Rules = append(policy.Rules, types.NetworkPolicyRule{
+			Source:      r[0],
+			Destination: r[1],
+		})
+	}
+
+	return policy, nil
+}
+
 var volumeCreateCmd = &cobra.Command{
 	Use:   "volume",
 	Short: "Create a volume in the cluster",
@@ -234,21 +303,25 @@ type disk struct {
 }
 
 type workloadRequirements struct {
-	VCPUs      int    `yaml:"vcpus"`
-	MemMB      int    `yaml:"mem_mb"`
-	NodeID     string `yaml:"node_id,omitempty"`
-	Hostname   string `yaml:"hostname,omitempty"`
-	Privileged bool   `yaml:"privileged,omitempty"`
+	VCPUs       int      `yaml:"vcpus"`
+	MemMB       int      `yaml:"mem_mb"`
+	NodeID      string   `yaml:"node_id,omitempty"`
+	Hostname    string   `yaml:"hostname,omitempty"`
+	Privileged  bool     `yaml:"privileged,omitempty"`
+	CPUModel    string   `yaml:"cpu_model,omitempty"`
+	CPUFeatures []string `yaml:"cpu_features,omitempty"`
 }
 
 type workloadOptions struct {
-	Description     string               `yaml:"description"`
-	VMType          string               `yaml:"vm_type"`
-	FWType          string               `yaml:"fw_type,omitempty"`
-	ImageName       string               `yaml:"image_name,omitempty"`
-	Requirements    workloadRequirements `yaml:"requirements"`
-	CloudConfigFile string               `yaml:"cloud_init,omitempty"`
-	Disks           []disk               `yaml:"disks,omitempty"`
+	Description     string                `yaml:"description"`
+	VMType          string                `yaml:"vm_type"`
+	FWType          string                `yaml:"fw_type,omitempty"`
+	ImageName       string                `yaml:"image_name,omitempty"`
+	Requirements    workloadRequirements  `yaml:"requirements"`
+	CloudConfigFile string                `yaml:"cloud_init,omitempty"`
+	Disks           []disk                `yaml:"disks,omitempty"`
+	StaticNetwork   bool                  `yaml:"static_network,omitempty"`
+	Devices         payloads.DeviceModels `yaml:"devices,omitempty"`
 }
 
 func optToReqStorage(opt workloadOptions) ([]types.StorageResource, error) {
@@ -330,6 +403,10 @@ func optToReq(opt workloadOptions, req *types.Workload) error {
 	req.Requirements.Hostname = opt.Requirements.Hostname
 	req.Requirements.NodeID = opt.Requirements.NodeID
 	req.Requirements.Privileged = opt.Requirements.Privileged
+	req.Requirements.CPUModel = opt.Requirements.CPUModel
+	req.Requirements.CPUFeatures = opt.Requirements.CPUFeatures
+	req.StaticNetwork = opt.StaticNetwork
+	req.Devices = opt.Devices
 
 	return nil
 }
@@ -393,4 +470,9 @@ func init() {
 	tenantCreateCmd.Flags().IntVar(&tenantFlags.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
 	tenantCreateCmd.Flags().BoolVar(&tenantFlags.createPrivilegedContainers, "create-privileged-containers", false, "Whether this tenant can create privileged containers")
 	tenantCreateCmd.Flags().StringVar(&tenantFlags.name, "name", "", "Tenant name")
+	tenantCreateCmd.Flags().StringVar(&tenantFlags.notifyMode, "notify-mode", "", "Email notification of error events: none, immediate or digest")
+	tenantCreateCmd.Flags().StringVar(&tenantFlags.notifyEmail, "notify-email", "", "Address error events are emailed to")
+	tenantCreateCmd.Flags().IntVar(&tenantFlags.notifyDigestMinutes, "notify-digest-minutes", 0, "Interval between digests of error events")
+	tenantCreateCmd.Flags().IntVar(&tenantFlags.maxInstancesPerRequest, "max-instances-per-request", 0, "Maximum number of instances started by a single request, -1 for no limit")
+	tenantCreateCmd.Flags().StringSliceVar(&tenantFlags.warmPools, "warm-pool", nil, "Number of booted instances of a workload kept ready for the tenant, as workload=size")
 }
@@ -41,6 +41,10 @@ var imageDelCmd = &cobra.Command{
 	},
 }
 
+var deleteFlags = struct {
+	yes bool
+}{}
+
 var deleteInstanceFlags = struct {
 	all bool
 }{}
@@ -66,7 +70,16 @@ var poolDelCmd = &cobra.Command{
 	Short: "Delete an external IP pool",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.DeleteExternalIPPool(args[0]), "Error deleting external IP pool")
+		plan, err := c.PlanExternalIPPoolDeletion(args[0])
+		if err != nil {
+			return errors.Wrap(err, "Error planning external IP pool deletion")
+		}
+
+		if !confirmDryRun(plan, deleteFlags.yes) {
+			return nil
+		}
+
+		return errors.Wrap(c.DeleteExternalIPPool(args[0], plan.Token), "Error deleting external IP pool")
 	},
 }
 
@@ -84,7 +97,16 @@ var tenantDelCmd = &cobra.Command{
 	Short: "Delete a tenant",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return errors.Wrap(c.DeleteTenant(args[0]), "Error deleting tenant")
+		plan, err := c.PlanTenantDeletion(args[0])
+		if err != nil {
+			return errors.Wrap(err, "Error planning tenant deletion")
+		}
+
+		if !confirmDryRun(plan, deleteFlags.yes) {
+			return nil
+		}
+
+		return errors.Wrap(c.DeleteTenant(args[0], plan.Token), "Error deleting tenant")
 	},
 }
 
@@ -104,7 +126,9 @@ func init() {
 		deleteCmd.AddCommand(cmd)
 	}
 
-	instanceDelCmd.Flags().BoolVar(&deleteInstanceFlags.all, "all", false, "Delete all instances")
+	instanceDelCmd.Flags().BoolVar(&deleteInstanceFlags.all, "all", false, "Delete all instances not protected against deletion")
+	poolDelCmd.Flags().BoolVar(&deleteFlags.yes, "yes", false, "Delete the pool without asking for confirmation")
+	tenantDelCmd.Flags().BoolVar(&deleteFlags.yes, "yes", false, "Delete the tenant without asking for confirmation")
 
 	rootCmd.AddCommand(deleteCmd)
 }
@@ -24,11 +24,45 @@ var detachCmd = &cobra.Command{
 	Short: "Detach objects from other opbjects.",
 }
 
+var detachIPFlags = struct {
+	instance string
+	pool     string
+	yes      bool
+}{}
+
 var detachIPCmd = &cobra.Command{
-	Use:   "external-ip IP",
-	Short: "Detach an external IP from an instance",
-	Args:  cobra.ExactArgs(1),
+	Use:   "external-ip [IP]",
+	Short: "Detach an external IP, the external IPs of an instance or of a pool",
+	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		given := len(args)
+		if detachIPFlags.instance != "" {
+			given++
+		}
+		if detachIPFlags.pool != "" {
+			given++
+		}
+		if given != 1 {
+			return errors.New("Exactly one of IP, --instance or --pool required")
+		}
+
+		if detachIPFlags.instance != "" {
+			return errors.Wrap(c.UnmapInstanceExternalIPs(detachIPFlags.instance), "Error unmapping external IPs")
+		}
+
+		if detachIPFlags.pool != "" {
+			plan, err := c.PlanExternalIPPoolDrain(detachIPFlags.pool)
+			if err != nil {
+				return errors.Wrap(err, "Error planning external IP pool drain")
+			}
+
+			if !confirmDryRun(plan, detachIPFlags.yes) {
+				return nil
+			}
+
+			return errors.Wrap(c.DrainExternalIPPool(detachIPFlags.pool, plan.Token), "Error draining external IP pool")
+		}
+
 		return errors.Wrap(c.UnmapExternalIP(args[0]), "Error unmapping external IP")
 	},
 }
@@ -43,6 +77,10 @@ var detachVolCmd = &cobra.Command{
 }
 
 func init() {
+	detachIPCmd.Flags().StringVar(&detachIPFlags.instance, "instance", "", "Detach all the external IPs of the instance")
+	detachIPCmd.Flags().StringVar(&detachIPFlags.pool, "pool", "", "Detach all the mapped external IPs of the pool")
+	detachIPCmd.Flags().BoolVar(&detachIPFlags.yes, "yes", false, "Drain the pool without asking for confirmation")
+
 	detachCmd.AddCommand(detachIPCmd)
 	detachCmd.AddCommand(detachVolCmd)
 
@@ -44,6 +44,11 @@ var cnciListCmd = &cobra.Command{
 	Annotations: map[string]string{"default_template": "{{ table .}}"},
 }
 
+var eventListFlags = struct {
+	severity string
+	category string
+}{}
+
 var eventListCmd = &cobra.Command{
 	Use:  "events [TENANT]",
 	Long: `List events for the provided tenant. If no tenant is specified and the user is privileged events for all tenants will be returned otherwise returns the current tenants events.`,
@@ -60,7 +65,17 @@ var eventListCmd = &cobra.Command{
 			}
 		}
 
-		events, err := c.ListEvents(tenantID)
+		severity := types.EventSeverity(eventListFlags.severity)
+		if severity != "" && severity.Level() == -1 {
+			return errors.Errorf("Invalid severity %s", eventListFlags.severity)
+		}
+
+		category := types.EventCategory(eventListFlags.category)
+		if category != "" && !category.Valid() {
+			return errors.Errorf("Invalid category %s", eventListFlags.category)
+		}
+
+		events, err := c.ListEvents(tenantID, severity, category)
 		if err != nil {
 			return errors.Wrap(err, "Error listing events")
 		}
@@ -302,5 +317,8 @@ func init() {
 	nodeListCmd.Flags().BoolVar(&nodeListFlags.computeNodesOnly, "compute-nodes", false, "Only show compute nodes")
 	nodeListCmd.Flags().BoolVar(&nodeListFlags.networkNodesOnly, "network-nodes", false, "Only show network nodes")
 
+	eventListCmd.Flags().StringVar(&eventListFlags.severity, "severity", "", "Only show events at least this severe (debug, info, warning, error, critical)")
+	eventListCmd.Flags().StringVar(&eventListFlags.category, "category", "", "Only show events of this category (instance, network, storage, node, auth)")
+
 	rootCmd.AddCommand(listCmd)
 }
@@ -0,0 +1,74 @@
+// Copyright © 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var protectCmd = &cobra.Command{
+	Use:   "protect",
+	Short: "Protect an object in the cluster against deletion",
+}
+
+var unprotectCmd = &cobra.Command{
+	Use:   "unprotect",
+	Short: "Remove the deletion protection of an object in the cluster",
+}
+
+var instanceProtectCmd = &cobra.Command{
+	Use:   "instance ID",
+	Short: "Protect an instance against deletion",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.ProtectInstance(args[0], true), "Error protecting instance")
+	},
+}
+
+var volumeProtectCmd = &cobra.Command{
+	Use:   "volume ID",
+	Short: "Protect a volume against deletion",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.ProtectVolume(args[0], true), "Error protecting volume")
+	},
+}
+
+var instanceUnprotectCmd = &cobra.Command{
+	Use:   "instance ID",
+	Short: "Allow the deletion of an instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.ProtectInstance(args[0], false), "Error unprotecting instance")
+	},
+}
+
+var volumeUnprotectCmd = &cobra.Command{
+	Use:   "volume ID",
+	Short: "Allow the deletion of a volume",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.Wrap(c.ProtectVolume(args[0], false), "Error unprotecting volume")
+	},
+}
+
+func init() {
+	protectCmd.AddCommand(instanceProtectCmd, volumeProtectCmd)
+	unprotectCmd.AddCommand(instanceUnprotectCmd, volumeUnprotectCmd)
+
+	rootCmd.AddCommand(protectCmd)
+	rootCmd.AddCommand(unprotectCmd)
+}
@@ -153,6 +153,7 @@ Description:	{{ .Description }}
 State:		{{ .State }}
 Size:		{{ .Size }}
 CreateTime:	{{ .CreateTime }}
+Protected:	{{ .Protected }}
 `
 
 var volumeShowCmd = &cobra.Command{
@@ -83,11 +83,29 @@ var tenantUpdateCmd = &cobra.Command{
 			return errors.New("Tenant ID must be a UUID")
 		}
 
+		warmPools, err := parseWarmPools(tenantFlags.warmPools)
+		if err != nil {
+			return err
+		}
+
+		policy, err := parseNetworkPolicy(tenantFlags.networkPolicy, tenantFlags.networkRules)
+		if err != nil {
+			return err
+		}
+
 		config := types.TenantConfig{
-			Name:       tenantFlags.name,
-			SubnetBits: tenantFlags.cidrPrefixSize,
+			Name:                   tenantFlags.name,
+			SubnetBits:             tenantFlags.cidrPrefixSize,
+			MaxInstancesPerRequest: tenantFlags.maxInstancesPerRequest,
+			WarmPools:              warmPools,
+			NetworkPolicy:          policy,
 		}
 		config.Permissions.PrivilegedContainers = tenantFlags.createPrivilegedContainers
+		config.Notifications = types.NotificationConfig{
+			Mode:          types.NotificationMode(tenantFlags.notifyMode),
+			Email:         tenantFlags.notifyEmail,
+			DigestMinutes: tenantFlags.notifyDigestMinutes,
+		}
 
 		return errors.Wrap(c.UpdateTenantConfig(tuuid.String(), config),
 			"Error updating tenant config")
@@ -101,6 +119,13 @@ func init() {
 	tenantUpdateCmd.Flags().IntVar(&tenantFlags.cidrPrefixSize, "cidr-prefix-size", 0, "Number of bits in network mask (12-30)")
 	tenantUpdateCmd.Flags().BoolVar(&tenantFlags.createPrivilegedContainers, "create-privileged-containers", false, "Whether this tenant can create privileged containers")
 	tenantUpdateCmd.Flags().StringVar(&tenantFlags.name, "name", "", "Tenant name")
+	tenantUpdateCmd.Flags().StringVar(&tenantFlags.notifyMode, "notify-mode", "", "Email notification of error events: none, immediate or digest")
+	tenantUpdateCmd.Flags().StringVar(&tenantFlags.notifyEmail, "notify-email", "", "Address error events are emailed to")
+	tenantUpdateCmd.Flags().IntVar(&tenantFlags.notifyDigestMinutes, "notify-digest-minutes", 0, "Interval between digests of error events")
+	tenantUpdateCmd.Flags().IntVar(&tenantFlags.maxInstancesPerRequest, "max-instances-per-request", 0, "Maximum number of instances started by a single request, -1 for no limit")
+	tenantUpdateCmd.Flags().StringSliceVar(&tenantFlags.warmPools, "warm-pool", nil, "Number of booted instances of a workload kept ready for the tenant, as workload=size, 0 to empty the pool")
+	tenantUpdateCmd.Flags().StringVar(&tenantFlags.networkPolicy, "network-policy", "", "Routing between the tenant's subnets: allow_all, deny_all or rules")
+	tenantUpdateCmd.Flags().StringSliceVar(&tenantFlags.networkRules, "network-rule", nil, "Route allowed by the rules policy, as source:destination CIDRs")
 
 	rootCmd.AddCommand(updateCmd)
 }
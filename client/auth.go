@@ -0,0 +1,49 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// ListAuthLockouts retrieves the client addresses and accounts which
+// recently failed to authenticate, and whether they are locked out.
+func (client *Client) ListAuthLockouts() ([]types.AuthLockout, error) {
+	var lockouts types.AuthLockoutListResponse
+
+	if !client.IsPrivileged() {
+		return nil, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("auth/lockouts")
+	err := client.getResource(url, api.AuthV1, nil, &lockouts)
+
+	return lockouts.Lockouts, err
+}
+
+// ClearAuthLockout lifts the lockout of a client address or account.
+func (client *Client) ClearAuthLockout(ID string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("auth/lockouts/%s", ID)
+
+	return client.deleteResource(url, api.AuthV1)
+}
@@ -45,6 +45,10 @@ type Client struct {
 	clientCert *tls.Certificate
 
 	Tenants []string
+
+	// ImpersonateTenant, when set by an admin, performs the requests
+	// on behalf of the tenant with this ID.
+	ImpersonateTenant string
 }
 
 type queryValue struct {
@@ -188,6 +192,10 @@ func (client *Client) sendHTTPRequest(method string, url string, values []queryV
 		req.Header.Set("Accept", "application/json")
 	}
 
+	if client.ImpersonateTenant != "" {
+		req.Header.Set(api.ImpersonateHeader, client.ImpersonateTenant)
+	}
+
 	tlsConfig := &tls.Config{}
 
 	if client.caCertPool != nil {
@@ -300,7 +308,13 @@ func (client *Client) getResource(url string, content string, query []queryValue
 }
 
 func (client *Client) deleteResource(url string, content string) error {
-	resp, err := client.sendHTTPRequest("DELETE", url, nil, nil, content)
+	return client.deleteConfirmedResource(url, content, "")
+}
+
+// deleteConfirmedResource deletes a resource whose deletion must be
+// confirmed with the token returned by its dry run.
+func (client *Client) deleteConfirmedResource(url string, content string, token string) error {
+	resp, err := client.sendHTTPRequest("DELETE", url, confirmQuery(token), nil, content)
 	if err != nil {
 		return errors.Wrapf(err, "Error making HTTP request to %s", url)
 	}
@@ -313,6 +327,20 @@ func (client *Client) deleteResource(url string, content string) error {
 	return nil
 }
 
+func (client *Client) patchResource(url string, patch []byte, format types.PatchFormat) error {
+	resp, err := client.sendHTTPRequest("PATCH", url, nil, bytes.NewReader(patch), string(format))
+	if err != nil {
+		return errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
+	}
+
+	return nil
+}
+
 func (client *Client) putResource(url string, content string, request interface{}) error {
 	b, err := json.Marshal(request)
 	if err != nil {
@@ -333,17 +361,27 @@ func (client *Client) putResource(url string, content string, request interface{
 }
 
 func (client *Client) postResource(url string, content string, request interface{}, result interface{}) error {
+	return client.postConfirmedResource(url, content, "", request, result)
+}
+
+// postConfirmedResource posts a request which must be confirmed with the
+// token returned by its dry run.
+func (client *Client) postConfirmedResource(url string, content string, token string, request interface{}, result interface{}) error {
 	b, err := json.Marshal(request)
 	if err != nil {
 		return errors.Wrap(err, "Error marshalling JSON")
 	}
 
-	resp, err := client.sendHTTPRequest("POST", url, nil, bytes.NewReader(b), content)
+	resp, err := client.sendHTTPRequest("POST", url, confirmQuery(token), bytes.NewReader(b), content)
 	if err != nil {
 		return errors.Wrapf(err, "Error making HTTP request to %s", url)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusPreconditionRequired || resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
+	}
+
 	if result != nil && resp.StatusCode != http.StatusNoContent {
 		err = client.unmarshalHTTPResponse(resp, result)
 		if err != nil {
@@ -354,3 +392,46 @@ func (client *Client) postResource(url string, content string, request interface
 
 	return nil
 }
+
+func confirmQuery(token string) []queryValue {
+	if token == "" {
+		return nil
+	}
+
+	return []queryValue{{name: "confirm", value: token}}
+}
+
+// dryRunResource asks the controller what a destructive request would
+// remove, without carrying it out. The result holds the token with which
+// the request is to be confirmed.
+func (client *Client) dryRunResource(method string, url string, content string, request interface{}) (types.DryRunResult, error) {
+	var result types.DryRunResult
+	var body io.Reader
+
+	if request != nil {
+		b, err := json.Marshal(request)
+		if err != nil {
+			return result, errors.Wrap(err, "Error marshalling JSON")
+		}
+		body = bytes.NewReader(b)
+	}
+
+	query := []queryValue{{name: "dry_run", value: "true"}}
+	resp, err := client.sendHTTPRequest(method, url, query, body, content)
+	if err != nil {
+		return result, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
+	}
+
+	err = client.unmarshalHTTPResponse(resp, &result)
+	if err != nil {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return result, errors.Wrapf(err, "Error parsing HTTP response: %s", data)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// GetConfig retrieves the settings of the cluster configuration.
+func (client *Client) GetConfig() (types.ConfigResponse, error) {
+	var config types.ConfigResponse
+
+	if !client.IsPrivileged() {
+		return config, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("config")
+	err := client.getResource(url, api.ConfigV1, nil, &config)
+
+	return config, err
+}
+
+// UpdateConfigSetting changes a setting of the cluster configuration. An
+// empty value restores the default value of the setting.
+func (client *Client) UpdateConfigSetting(key string, value string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	req := types.ConfigUpdateRequest{Value: value}
+
+	url := client.buildCiaoURL("config/%s", key)
+	return client.putResource(url, api.ConfigV1, &req)
+}
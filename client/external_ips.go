@@ -17,6 +17,9 @@
 package client
 
 import (
+	"fmt"
+	"net/http"
+
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/pkg/errors"
@@ -55,6 +58,13 @@ func (client *Client) getExternalIPRef(address string) (string, error) {
 
 // MapExternalIP maps an IP from the pool to the given instance
 func (client *Client) MapExternalIP(pool string, instanceID string) error {
+	return client.MapExternalIPAddress(pool, "", instanceID)
+}
+
+// MapExternalIPAddress maps a specific IP address to the given instance.
+// The pool is optional and the first free address of the pool is mapped
+// if no address is supplied.
+func (client *Client) MapExternalIPAddress(pool string, address string, instanceID string) error {
 	req := types.MapIPRequest{
 		InstanceID: instanceID,
 	}
@@ -63,6 +73,10 @@ func (client *Client) MapExternalIP(pool string, instanceID string) error {
 		req.PoolName = &pool
 	}
 
+	if address != "" {
+		req.Address = &address
+	}
+
 	url, ver, err := client.getCiaoExternalIPsResource()
 	if err != nil {
 		return errors.Wrap(err, "Error getting external IP resource")
@@ -94,3 +108,25 @@ func (client *Client) UnmapExternalIP(address string) error {
 
 	return client.deleteResource(url, api.ExternalIPsV1)
 }
+
+// UnmapInstanceExternalIPs unmaps all the external IPs mapped to the given
+// instance
+func (client *Client) UnmapInstanceExternalIPs(instanceID string) error {
+	url, ver, err := client.getCiaoExternalIPsResource()
+	if err != nil {
+		return errors.Wrap(err, "Error getting external IP resource")
+	}
+
+	query := []queryValue{{name: "instance", value: instanceID}}
+	resp, err := client.sendHTTPRequest("DELETE", url, query, nil, ver)
+	if err != nil {
+		return errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
+	}
+
+	return nil
+}
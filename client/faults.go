@@ -0,0 +1,49 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// GetFaults retrieves the faults injected by a controller started with
+// fault injection enabled.
+func (client *Client) GetFaults() (types.FaultConfig, error) {
+	var faults types.FaultConfig
+
+	if !client.IsPrivileged() {
+		return faults, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("faults")
+	err := client.getResource(url, api.FaultsV1, nil, &faults)
+
+	return faults, err
+}
+
+// UpdateFaults replaces the faults injected by a controller started with
+// fault injection enabled.
+func (client *Client) UpdateFaults(faults types.FaultConfig) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("faults")
+	return client.putResource(url, api.FaultsV1, &faults)
+}
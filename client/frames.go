@@ -0,0 +1,51 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// ReplayFrames replays a capture of the SSNTP frames received by a
+// controller against a controller running with simulated nodes.
+func (client *Client) ReplayFrames(capture io.Reader) (types.FrameReplayResult, error) {
+	var res types.FrameReplayResult
+
+	if !client.IsPrivileged() {
+		return res, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("frames/replay")
+	resp, err := client.sendHTTPRequest("POST", url, nil, capture, api.FramesV1)
+	if err != nil {
+		return res, errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("Unexpected HTTP response code (%d): %s", resp.StatusCode, resp.Status)
+	}
+
+	err = client.unmarshalHTTPResponse(resp, &res)
+	return res, err
+}
@@ -62,10 +62,19 @@ func (client *Client) uploadTenantImage(tenant, image string, data io.Reader) er
 
 // CreateImage creates and uploads a new image
 func (client *Client) CreateImage(name string, visibility types.Visibility, ID string, data io.Reader) (string, error) {
+	return client.CreateSignedImage(name, visibility, ID, "", nil, data)
+}
+
+// CreateSignedImage creates and uploads a new image signed by a signer
+// trusted by the controller. The signature is verified by the controller
+// once the image data is uploaded.
+func (client *Client) CreateSignedImage(name string, visibility types.Visibility, ID string, signer string, signature []byte, data io.Reader) (string, error) {
 	opts := api.CreateImageRequest{
 		Name:       name,
 		ID:         ID,
 		Visibility: visibility,
+		Signer:     signer,
+		Signature:  signature,
 	}
 
 	var url string
@@ -116,3 +125,64 @@ func (client *Client) DeleteImage(imageID string) error {
 
 	return client.deleteResource(url, api.ImagesV1)
 }
+
+// PatchImage updates the metadata of an image with a json merge patch or
+// a list of json patch operations
+func (client *Client) PatchImage(imageID string, patch []byte, format types.PatchFormat) error {
+	var url string
+	if client.IsPrivileged() && client.TenantID == "admin" {
+		url = client.buildCiaoURL("images/%s", imageID)
+	} else {
+		url = client.buildCiaoURL("%s/images/%s", client.TenantID, imageID)
+	}
+
+	return client.patchResource(url, patch, format)
+}
+
+// ExportImage starts the export of an image to the object storage of the
+// cluster, under the given key or under a key derived from the image ID if
+// key is empty.
+func (client *Client) ExportImage(imageID string, key string) (types.ImageExportStatus, error) {
+	var url string
+	if client.IsPrivileged() && client.TenantID == "admin" {
+		url = client.buildCiaoURL("images/%s/export", imageID)
+	} else {
+		url = client.buildCiaoURL("%s/images/%s/export", client.TenantID, imageID)
+	}
+
+	var export types.ImageExportStatus
+	req := api.ExportImageRequest{Key: key}
+	err := client.postResource(url, api.ImagesV1, &req, &export)
+
+	return export, err
+}
+
+// GetImageExport retrieves the progress of an image export
+func (client *Client) GetImageExport(exportID string) (types.ImageExportStatus, error) {
+	var url string
+	if client.IsPrivileged() && client.TenantID == "admin" {
+		url = client.buildCiaoURL("images/exports/%s", exportID)
+	} else {
+		url = client.buildCiaoURL("%s/images/exports/%s", client.TenantID, exportID)
+	}
+
+	var export types.ImageExportStatus
+	err := client.getResource(url, api.ImagesV1, nil, &export)
+
+	return export, err
+}
+
+// ListImageExports retrieves the recent image exports
+func (client *Client) ListImageExports() ([]types.ImageExportStatus, error) {
+	var url string
+	if client.IsPrivileged() && client.TenantID == "admin" {
+		url = client.buildCiaoURL("images/exports")
+	} else {
+		url = client.buildCiaoURL("%s/images/exports", client.TenantID)
+	}
+
+	var exports types.ImageExportsResponse
+	err := client.getResource(url, api.ImagesV1, nil, &exports)
+
+	return exports.Exports, err
+}
@@ -18,10 +18,13 @@ package client
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
 	"github.com/pkg/errors"
 )
 
@@ -35,6 +38,37 @@ func (client *Client) CreateInstances(request api.CreateServerRequest) (api.Serv
 	return servers, err
 }
 
+// CreateStack launches the groups of instances of a stack, in the order of
+// their dependencies
+func (client *Client) CreateStack(request api.CreateStackRequest) (types.StackStatus, error) {
+	var stack types.StackStatus
+
+	url := client.buildCiaoURL("%s/stacks", client.TenantID)
+	err := client.postResource(url, api.InstancesV1, &request, &stack)
+
+	return stack, err
+}
+
+// GetStack retrieves the progress of the launch of a stack
+func (client *Client) GetStack(stackID string) (types.StackStatus, error) {
+	var stack types.StackStatus
+
+	url := client.buildCiaoURL("%s/stacks/%s", client.TenantID, stackID)
+	err := client.getResource(url, api.InstancesV1, nil, &stack)
+
+	return stack, err
+}
+
+// ListStacks retrieves the recent stacks of the tenant
+func (client *Client) ListStacks() ([]types.StackStatus, error) {
+	var stacks types.StacksResponse
+
+	url := client.buildCiaoURL("%s/stacks", client.TenantID)
+	err := client.getResource(url, api.InstancesV1, nil, &stacks)
+
+	return stacks.Stacks, err
+}
+
 // DeleteInstance deletes the given instance
 func (client *Client) DeleteInstance(instanceID string) error {
 	url := client.buildCiaoURL("%s/instances/%s", client.TenantID, instanceID)
@@ -68,6 +102,51 @@ func (client *Client) StartInstance(instanceID string) error {
 	return client.instanceAction(instanceID, "os-start")
 }
 
+// ProtectInstance protects the given instance against deletion, or
+// removes that protection if protect is false
+func (client *Client) ProtectInstance(instanceID string, protect bool) error {
+	if protect {
+		return client.instanceAction(instanceID, "protect")
+	}
+	return client.instanceAction(instanceID, "unprotect")
+}
+
+// HotAddInstance adds vCPUs and memory, in MiB, to the given running
+// instance
+func (client *Client) HotAddInstance(instanceID string, vcpus int, memMB int) error {
+	req := api.HotAddInstanceRequest{
+		HotAdd: api.HotAddRequest{
+			VCPUs: vcpus,
+			MemMB: memMB,
+		},
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "Error marshalling hot add request")
+	}
+
+	return client.instanceAction(instanceID, string(b))
+}
+
+// SnapshotInstance creates an image from the boot volume of the given
+// instance
+func (client *Client) SnapshotInstance(instanceID string, name string, visibility types.Visibility) (types.Image, error) {
+	var image types.Image
+
+	req := api.CreateInstanceImageRequest{
+		CreateImage: api.CreateImageRequest{
+			Name:       name,
+			Visibility: visibility,
+		},
+	}
+
+	url := client.buildCiaoURL("%s/instances/%s/action", client.TenantID, instanceID)
+	err := client.postResource(url, api.InstancesV1, &req, &image)
+
+	return image, err
+}
+
 // ListInstancesByWorkload provides the list of instances for a given tenant and workloadID.
 func (client *Client) ListInstancesByWorkload(tenantID string, workloadID string) (api.Servers, error) {
 	var servers api.Servers
@@ -88,6 +167,45 @@ func (client *Client) ListInstancesByWorkload(tenantID string, workloadID string
 
 }
 
+// ListInstancesPage provides a page of at most limit instances matching
+// filter, starting after the instance whose ID is marker. A limit of 0
+// returns all the matching instances. The instances of the client's tenant
+// are listed when filter.TenantID is empty.
+func (client *Client) ListInstancesPage(filter types.InstanceFilter, marker string, limit int) (api.Servers, error) {
+	var servers api.Servers
+
+	tenantID := filter.TenantID
+	if tenantID == "" {
+		tenantID = client.TenantID
+	}
+
+	url := client.buildCiaoURL("%s/instances/detail", tenantID)
+
+	values := []queryValue{}
+	for _, v := range []queryValue{
+		{name: "workload", value: filter.WorkloadID},
+		{name: "state", value: filter.State},
+		{name: "node_id", value: filter.NodeID},
+		{name: "name_prefix", value: filter.NamePrefix},
+		{name: "marker", value: marker},
+	} {
+		if v.value != "" {
+			values = append(values, v)
+		}
+	}
+
+	if limit > 0 {
+		values = append(values, queryValue{
+			name:  "limit",
+			value: strconv.Itoa(limit),
+		})
+	}
+
+	err := client.getResource(url, api.InstancesV1, values, &servers)
+
+	return servers, err
+}
+
 // ListInstances gets the set of instances
 func (client *Client) ListInstances() (api.Servers, error) {
 	return client.ListInstancesByWorkload(client.TenantID, "")
@@ -102,3 +220,74 @@ func (client *Client) GetInstance(instanceID string) (api.Server, error) {
 
 	return server, err
 }
+
+// GetInstanceNetworkStats gets the latest network samples of an instance
+func (client *Client) GetInstanceNetworkStats(instanceID string) (types.CiaoServerNetworkStats, error) {
+	var stats types.CiaoServerNetworkStats
+
+	url := client.buildCiaoURL("%s/instances/%s/network-stats", client.TenantID, instanceID)
+	err := client.getResource(url, api.InstancesV1, nil, &stats)
+
+	return stats, err
+}
+
+// GetInstanceDiskStats gets the latest disk I/O samples of an instance
+func (client *Client) GetInstanceDiskStats(instanceID string) (types.CiaoServerDiskStats, error) {
+	var stats types.CiaoServerDiskStats
+
+	url := client.buildCiaoURL("%s/instances/%s/disk-stats", client.TenantID, instanceID)
+	err := client.getResource(url, api.InstancesV1, nil, &stats)
+
+	return stats, err
+}
+
+// ListDeletedInstances returns the records kept of the deleted instances of
+// the tenant, most recently deleted first
+func (client *Client) ListDeletedInstances() ([]types.DeletedInstance, error) {
+	var result types.DeletedInstancesResponse
+
+	url := client.buildCiaoURL("%s/instances/deleted", client.TenantID)
+	err := client.getResource(url, api.InstancesV1, nil, &result)
+
+	return result.Instances, err
+}
+
+// PurgeDeletedInstances removes the records of the deleted instances of the
+// tenant
+func (client *Client) PurgeDeletedInstances() error {
+	url := client.buildCiaoURL("%s/instances/deleted", client.TenantID)
+	return client.deleteResource(url, api.InstancesV1)
+}
+
+// TransferInstance moves an instance, along with its volumes and external
+// IPs, to another tenant. The transfer proceeds in the background and its
+// progress can be retrieved with GetInstanceTransferStatus.
+func (client *Client) TransferInstance(instanceID string, tenantID string) (types.InstanceTransferStatus, error) {
+	var status types.InstanceTransferStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("instances/%s/transfer", instanceID)
+
+	req := types.InstanceTransferRequest{TenantID: tenantID}
+	err := client.postResource(url, api.InstancesV1, &req, &status)
+
+	return status, err
+}
+
+// GetInstanceTransferStatus retrieves the progress of the last transfer of
+// an instance to another tenant
+func (client *Client) GetInstanceTransferStatus(instanceID string) (types.InstanceTransferStatus, error) {
+	var status types.InstanceTransferStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("instances/%s/transfer", instanceID)
+	err := client.getResource(url, api.InstancesV1, nil, &status)
+
+	return status, err
+}
@@ -17,13 +17,32 @@
 package client
 
 import (
+	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
 )
 
-// ListEvents retrieves the events for either all or the desired tenant
-func (client *Client) ListEvents(tenantID string) (types.CiaoEvents, error) {
+// ListEvents retrieves the events for either all or the desired tenant,
+// optionally restricted to the events of a category and at least as
+// severe as severity
+func (client *Client) ListEvents(tenantID string, severity types.EventSeverity, category types.EventCategory) (types.CiaoEvents, error) {
+	filter := types.EventFilter{
+		Severity: severity,
+		Category: category,
+	}
+
+	return client.ListEventsPage(tenantID, filter, "", 0)
+}
+
+// ListEventsPage retrieves a page of at most limit events of either all or
+// the desired tenant matching filter, starting after marker. The marker of
+// the next page is returned in the NextMarker field of the events.
+func (client *Client) ListEventsPage(tenantID string, filter types.EventFilter, marker string, limit int) (types.CiaoEvents, error) {
 	var events types.CiaoEvents
 	var url string
 
@@ -33,7 +52,30 @@ func (client *Client) ListEvents(tenantID string) (types.CiaoEvents, error) {
 		url = client.buildComputeURL("%s/events", tenantID)
 	}
 
-	err := client.getResource(url, "", nil, &events)
+	var values []queryValue
+	if filter.Severity != "" {
+		values = append(values, queryValue{name: "severity", value: string(filter.Severity)})
+	}
+	if filter.Category != "" {
+		values = append(values, queryValue{name: "category", value: string(filter.Category)})
+	}
+	if filter.NodeID != "" {
+		values = append(values, queryValue{name: "node_id", value: filter.NodeID})
+	}
+	if !filter.Since.IsZero() {
+		values = append(values, queryValue{name: "since", value: filter.Since.Format(time.RFC3339)})
+	}
+	if !filter.Until.IsZero() {
+		values = append(values, queryValue{name: "until", value: filter.Until.Format(time.RFC3339)})
+	}
+	if marker != "" {
+		values = append(values, queryValue{name: "marker", value: marker})
+	}
+	if limit > 0 {
+		values = append(values, queryValue{name: "limit", value: strconv.Itoa(limit)})
+	}
+
+	err := client.getResource(url, "", values, &events)
 
 	return events, err
 }
@@ -45,6 +87,24 @@ func (client *Client) DeleteEvents() error {
 	return client.deleteResource(url, "")
 }
 
+// DeleteEventsBefore deletes the events logged before a time
+func (client *Client) DeleteEventsBefore(before time.Time) error {
+	url := client.buildComputeURL("events")
+
+	query := []queryValue{{name: "before", value: before.Format(time.RFC3339)}}
+	resp, err := client.sendHTTPRequest("DELETE", url, query, nil, "")
+	if err != nil {
+		return errors.Wrapf(err, "Error making HTTP request to %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("HTTP response code from %s not as expected: %s", url, resp.Status)
+	}
+
+	return nil
+}
+
 // ListInstancesByNode gets the instances on a given node
 func (client *Client) ListInstancesByNode(nodeID string) (types.CiaoServersStats, error) {
 	var servers types.CiaoServersStats
@@ -166,3 +226,13 @@ func (client *Client) GetTraceData(label string) (types.CiaoTraceData, error) {
 
 	return data, err
 }
+
+// ListTraceInstances returns the instances launched with a trace label
+func (client *Client) ListTraceInstances(label string) (api.Servers, error) {
+	var servers api.Servers
+
+	url := client.buildComputeURL("traces/%s/servers", label)
+	err := client.getResource(url, "", nil, &servers)
+
+	return servers, err
+}
@@ -43,3 +43,194 @@ func (client *Client) ChangeNodeStatus(nodeID string, status types.NodeStatusTyp
 
 	return err
 }
+
+// PlanNodeDeletion returns the instances which draining and removing a
+// node from the cluster would evacuate, and the token with which to
+// confirm the removal.
+func (client *Client) PlanNodeDeletion(nodeID string) (types.DryRunResult, error) {
+	if !client.IsPrivileged() {
+		return types.DryRunResult{}, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return types.DryRunResult{}, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/drain", url, nodeID)
+
+	req := types.NodeDrainRequest{Delete: true}
+	return client.dryRunResource("POST", url, api.NodeV1, &req)
+}
+
+// DrainNode evacuates a node, removing it from the cluster once empty if
+// del is true. The removal of a node hosting instances must be confirmed
+// with the token returned by PlanNodeDeletion. The drain proceeds in the
+// background and its progress can be retrieved with GetNodeDrainStatus.
+func (client *Client) DrainNode(nodeID string, del bool, token string) (types.NodeDrainStatus, error) {
+	var status types.NodeDrainStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/drain", url, nodeID)
+
+	req := types.NodeDrainRequest{Delete: del}
+	err = client.postConfirmedResource(url, api.NodeV1, token, &req, &status)
+
+	return status, err
+}
+
+// GetNodeDrainStatus retrieves the progress of the last drain of a node
+func (client *Client) GetNodeDrainStatus(nodeID string) (types.NodeDrainStatus, error) {
+	var status types.NodeDrainStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/drain", url, nodeID)
+
+	err = client.getResource(url, api.NodeV1, nil, &status)
+
+	return status, err
+}
+
+// RunNodeCommand runs a command, e.g. a self-test, on a node. The command
+// proceeds in the background and its result can be retrieved with
+// GetNodeCommand.
+func (client *Client) RunNodeCommand(nodeID string, command types.NodeCommandType) (types.NodeCommandStatus, error) {
+	var status types.NodeCommandStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/commands", url, nodeID)
+
+	req := types.NodeCommandRequest{Command: command}
+	err = client.postResource(url, api.NodeV1, &req, &status)
+
+	return status, err
+}
+
+// GetNodeCommand retrieves the status of a command run on a node
+func (client *Client) GetNodeCommand(nodeID string, commandID string) (types.NodeCommandStatus, error) {
+	var status types.NodeCommandStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/commands/%s", url, nodeID, commandID)
+
+	err = client.getResource(url, api.NodeV1, nil, &status)
+
+	return status, err
+}
+
+// ListNodeCommands retrieves the commands recently run on a node
+func (client *Client) ListNodeCommands(nodeID string) (types.NodeCommandListResponse, error) {
+	var commands types.NodeCommandListResponse
+
+	if !client.IsPrivileged() {
+		return commands, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return commands, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/commands", url, nodeID)
+
+	err = client.getResource(url, api.NodeV1, nil, &commands)
+
+	return commands, err
+}
+
+// RebalanceNodes plans up to maxMoves migrations evening out the load of
+// the compute nodes and, unless dryRun is true, carries them out. The
+// migrations proceed in the background and their progress can be
+// retrieved with GetRebalanceStatus.
+func (client *Client) RebalanceNodes(dryRun bool, maxMoves int) (types.RebalanceStatus, error) {
+	var status types.RebalanceStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/rebalance", url)
+
+	req := types.RebalanceRequest{DryRun: dryRun, MaxMoves: maxMoves}
+	err = client.postResource(url, api.NodeV1, &req, &status)
+
+	return status, err
+}
+
+// GetRebalanceStatus retrieves the progress of the last rebalancing
+func (client *Client) GetRebalanceStatus() (types.RebalanceStatus, error) {
+	var status types.RebalanceStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/rebalance", url)
+
+	err = client.getResource(url, api.NodeV1, nil, &status)
+
+	return status, err
+}
+
+// ListShadowPlacements returns the placements recorded by the shadow
+// scheduler and how they compare with the scheduler's
+func (client *Client) ListShadowPlacements() (types.ShadowPlacementsResponse, error) {
+	var placements types.ShadowPlacementsResponse
+
+	if !client.IsPrivileged() {
+		return placements, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoResource("node", api.NodeV1)
+	if err != nil {
+		return placements, errors.Wrap(err, "Error getting node resource")
+	}
+
+	url = fmt.Sprintf("%s/shadow-placements", url)
+
+	err = client.getResource(url, api.NodeV1, nil, &placements)
+
+	return placements, err
+}
@@ -110,8 +110,24 @@ func (client *Client) ListExternalIPPools() (types.ListPoolsResponse, error) {
 	return pools, err
 }
 
-// DeleteExternalIPPool deletes the pool of the given name
-func (client *Client) DeleteExternalIPPool(pool string) error {
+// PlanExternalIPPoolDeletion returns what deleting the pool of the given
+// name would remove, and the token with which to confirm the deletion.
+func (client *Client) PlanExternalIPPoolDeletion(pool string) (types.DryRunResult, error) {
+	if !client.IsPrivileged() {
+		return types.DryRunResult{}, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoPoolRef(pool)
+	if err != nil {
+		return types.DryRunResult{}, errors.Wrap(err, "Error getting pool reference")
+	}
+
+	return client.dryRunResource("DELETE", url, api.PoolsV1, nil)
+}
+
+// DeleteExternalIPPool deletes the pool of the given name. The deletion
+// must be confirmed with the token returned by PlanExternalIPPoolDeletion.
+func (client *Client) DeleteExternalIPPool(pool string, token string) error {
 	if !client.IsPrivileged() {
 		return errors.New("This command is only available to admins")
 	}
@@ -121,8 +137,39 @@ func (client *Client) DeleteExternalIPPool(pool string) error {
 		return errors.Wrap(err, "Error getting pool reference")
 	}
 
-	return client.deleteResource(url, api.PoolsV1)
+	return client.deleteConfirmedResource(url, api.PoolsV1, token)
+}
+
+// PlanExternalIPPoolDrain returns the number of addresses draining the
+// pool of the given name would unmap, and the token with which to confirm
+// the drain.
+func (client *Client) PlanExternalIPPoolDrain(pool string) (types.DryRunResult, error) {
+	if !client.IsPrivileged() {
+		return types.DryRunResult{}, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoPoolRef(pool)
+	if err != nil {
+		return types.DryRunResult{}, errors.Wrap(err, "Error getting pool reference")
+	}
+
+	return client.dryRunResource("POST", url+"/drain", api.PoolsV1, nil)
+}
+
+// DrainExternalIPPool unmaps all the mapped addresses of the pool of the
+// given name. The drain must be confirmed with the token returned by
+// PlanExternalIPPoolDrain.
+func (client *Client) DrainExternalIPPool(pool string, token string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
 
+	url, err := client.getCiaoPoolRef(pool)
+	if err != nil {
+		return errors.Wrap(err, "Error getting pool reference")
+	}
+
+	return client.postConfirmedResource(url+"/drain", api.PoolsV1, token, nil, nil)
 }
 
 // AddExternalIPSubnet adds a subnet to the external IP pool
@@ -168,6 +215,25 @@ func (client *Client) AddExternalIPAddresses(pool string, IPs []string) error {
 	return client.postResource(url, api.PoolsV1, &req, nil)
 }
 
+// AddExternalIPRange adds a range of IP addresses, e.g.,
+// 192.0.2.10-192.0.2.50, to the external IP pool
+func (client *Client) AddExternalIPRange(pool string, IPRange string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	var req types.NewAddressRequest
+
+	url, err := client.getCiaoPoolRef(pool)
+	if err != nil {
+		return errors.Wrap(err, "Error getting pool reference")
+	}
+
+	req.IPRange = &IPRange
+
+	return client.postResource(url, api.PoolsV1, &req, nil)
+}
+
 func (client *Client) getSubnetRef(pool types.Pool, cidr string) string {
 	for _, sub := range pool.Subnets {
 		if sub.CIDR == cidr {
@@ -0,0 +1,52 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"github.com/ciao-project/ciao/ciao-controller/api"
+	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
+)
+
+// ListReports retrieves the tenant report schedules and the outcome of
+// their last run.
+func (client *Client) ListReports() ([]types.ReportStatus, error) {
+	var reports types.ReportsResponse
+
+	if !client.IsPrivileged() {
+		return nil, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("reports")
+	err := client.getResource(url, api.ReportsV1, nil, &reports)
+
+	return reports.Reports, err
+}
+
+// RunReport generates a scheduled tenant report straight away.
+func (client *Client) RunReport(name string) (types.ReportStatus, error) {
+	var report types.ReportStatus
+
+	if !client.IsPrivileged() {
+		return report, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("reports/%s", name)
+	err := client.postResource(url, api.ReportsV1, nil, &report)
+
+	return report, err
+}
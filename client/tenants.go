@@ -69,6 +69,183 @@ func (client *Client) ListQuotas(tenantID string) ([]types.QuotaDetails, error)
 	return result.Quotas, err
 }
 
+// ListQuotaProfiles lists the quota profiles that can be assigned to tenants
+func (client *Client) ListQuotaProfiles() ([]types.QuotaProfile, error) {
+	var result types.QuotaProfilesResponse
+
+	url, err := client.getCiaoQuotasResource()
+	if err != nil {
+		return result.Profiles, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/quota-profiles", url)
+	err = client.getResource(url, api.TenantsV1, nil, &result)
+
+	return result.Profiles, err
+}
+
+// UpdateQuotaProfile creates or replaces the named quota profile
+func (client *Client) UpdateQuotaProfile(name string, quotas []types.QuotaDetails) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoQuotasResource()
+	if err != nil {
+		return errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/quota-profiles/%s", url, name)
+	req := types.QuotaUpdateRequest{Quotas: quotas}
+
+	return client.putResource(url, api.TenantsV1, &req)
+}
+
+// DeleteQuotaProfile deletes the named quota profile
+func (client *Client) DeleteQuotaProfile(name string) error {
+	if !client.IsPrivileged() {
+		return errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoQuotasResource()
+	if err != nil {
+		return errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/quota-profiles/%s", url, name)
+
+	return client.deleteResource(url, api.TenantsV1)
+}
+
+// ListTenantSubnets returns the activation status of the CNCI subnets of
+// the current tenant or of the supplied tenant if privileged
+func (client *Client) ListTenantSubnets(tenantID string) ([]types.TenantSubnet, error) {
+	var result types.TenantSubnetsResponse
+
+	url, err := client.getCiaoQuotasResource()
+	if err != nil {
+		return result.Subnets, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	if tenantID != "" {
+		url = fmt.Sprintf("%s/%s/subnets", url, tenantID)
+	} else {
+		url = fmt.Sprintf("%s/subnets", url)
+	}
+
+	err = client.getResource(url, api.TenantsV1, nil, &result)
+
+	return result.Subnets, err
+}
+
+// ResubnetTenant changes the subnet bits of a tenant, moving its instances
+// to new subnets. The change proceeds in the background and its progress
+// can be retrieved with GetTenantResubnetStatus.
+func (client *Client) ResubnetTenant(tenantID string, subnetBits int) (types.TenantResubnetStatus, error) {
+	var status types.TenantResubnetStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantsResource()
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/resubnet", url, tenantID)
+
+	req := types.TenantResubnetRequest{SubnetBits: subnetBits}
+	err = client.postResource(url, api.TenantsV1, &req, &status)
+
+	return status, err
+}
+
+// GetTenantResubnetStatus retrieves the progress of the last change of the
+// subnet bits of a tenant
+func (client *Client) GetTenantResubnetStatus(tenantID string) (types.TenantResubnetStatus, error) {
+	var status types.TenantResubnetStatus
+
+	if !client.IsPrivileged() {
+		return status, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantsResource()
+	if err != nil {
+		return status, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/resubnet", url, tenantID)
+
+	err = client.getResource(url, api.TenantsV1, nil, &status)
+
+	return status, err
+}
+
+// ListTenantIPs returns the IP addresses claimed by a tenant along with the
+// instances holding them
+func (client *Client) ListTenantIPs(tenantID string) ([]types.TenantIP, error) {
+	var result types.TenantIPsResponse
+
+	if !client.IsPrivileged() {
+		return result.IPs, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantsResource()
+	if err != nil {
+		return result.IPs, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/ips", url, tenantID)
+
+	err = client.getResource(url, api.TenantsV1, nil, &result)
+
+	return result.IPs, err
+}
+
+// ReleaseTenantIPs releases the supplied orphaned IP addresses of a tenant,
+// or all of them if none are supplied, and returns the released addresses
+func (client *Client) ReleaseTenantIPs(tenantID string, addresses []string) ([]string, error) {
+	var result types.TenantIPReleaseResponse
+
+	if !client.IsPrivileged() {
+		return result.Released, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantsResource()
+	if err != nil {
+		return result.Released, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/ips/release", url, tenantID)
+
+	req := types.TenantIPReleaseRequest{Addresses: addresses}
+	err = client.postResource(url, api.TenantsV1, &req, &result)
+
+	return result.Released, err
+}
+
+// ListVNIs returns the VXLAN network identifiers allocated to the tenant
+// subnets of the cluster, along with the ones in quarantine
+func (client *Client) ListVNIs() ([]types.VNIAllocation, error) {
+	var result types.VNIsResponse
+
+	if !client.IsPrivileged() {
+		return result.VNIs, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantsResource()
+	if err != nil {
+		return result.VNIs, errors.Wrap(err, "Error getting tenants resource")
+	}
+
+	url = fmt.Sprintf("%s/vnis", url)
+
+	err = client.getResource(url, api.TenantsV1, nil, &result)
+
+	return result.VNIs, err
+}
+
 func (client *Client) getCiaoTenantsResource() (string, error) {
 	url, err := client.getCiaoResource("tenants", api.TenantsV1)
 	return url, err
@@ -149,6 +326,50 @@ func (client *Client) UpdateTenantConfig(ID string, config types.TenantConfig) e
 		config.SubnetBits = oldconfig.SubnetBits
 	}
 
+	if config.QuotaProfile == "" {
+		config.QuotaProfile = oldconfig.QuotaProfile
+	}
+
+	if config.Notifications.Mode == "" {
+		config.Notifications.Mode = oldconfig.Notifications.Mode
+	}
+
+	if config.Notifications.Email == "" {
+		config.Notifications.Email = oldconfig.Notifications.Email
+	}
+
+	if config.Notifications.DigestMinutes == 0 {
+		config.Notifications.DigestMinutes = oldconfig.Notifications.DigestMinutes
+	}
+
+	if config.MaxInstancesPerRequest == 0 {
+		config.MaxInstancesPerRequest = oldconfig.MaxInstancesPerRequest
+	}
+
+	if config.NetworkPolicy.Mode == "" {
+		config.NetworkPolicy = oldconfig.NetworkPolicy
+	}
+
+	if config.MaintenanceWindows == "" {
+		config.MaintenanceWindows = oldconfig.MaintenanceWindows
+	}
+
+	d := config.InstanceDefaults
+	if len(d.Tags) == 0 && d.SSHKey == "" && !d.ForbidPublicWorkloads {
+		config.InstanceDefaults = oldconfig.InstanceDefaults
+	}
+
+	if len(oldconfig.WarmPools) > 0 {
+		pools := make(map[string]int)
+		for workloadID, size := range oldconfig.WarmPools {
+			pools[workloadID] = size
+		}
+		for workloadID, size := range config.WarmPools {
+			pools[workloadID] = size
+		}
+		config.WarmPools = pools
+	}
+
 	b, err := json.Marshal(config)
 	if err != nil {
 		return err
@@ -189,8 +410,24 @@ func (client *Client) CreateTenantConfig(tenantID string, config types.TenantCon
 	return summary, err
 }
 
-// DeleteTenant deletes the given tenant
-func (client *Client) DeleteTenant(tenantID string) error {
+// PlanTenantDeletion returns what deleting the given tenant would remove,
+// and the token with which to confirm the deletion.
+func (client *Client) PlanTenantDeletion(tenantID string) (types.DryRunResult, error) {
+	if !client.IsPrivileged() {
+		return types.DryRunResult{}, errors.New("This command is only available to admins")
+	}
+
+	url, err := client.getCiaoTenantRef(tenantID)
+	if err != nil {
+		return types.DryRunResult{}, err
+	}
+
+	return client.dryRunResource("DELETE", url, api.TenantsV1, nil)
+}
+
+// DeleteTenant deletes the given tenant. The deletion must be confirmed
+// with the token returned by PlanTenantDeletion.
+func (client *Client) DeleteTenant(tenantID string, token string) error {
 	if !client.IsPrivileged() {
 		return errors.New("This command is only available to admins")
 	}
@@ -200,7 +437,7 @@ func (client *Client) DeleteTenant(tenantID string) error {
 		return err
 	}
 
-	return client.deleteResource(url, api.TenantsV1)
+	return client.deleteConfirmedResource(url, api.TenantsV1, token)
 }
 
 // ListTenants returns a list of the tenants
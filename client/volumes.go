@@ -17,8 +17,11 @@
 package client
 
 import (
+	"fmt"
+
 	"github.com/ciao-project/ciao/ciao-controller/api"
 	"github.com/ciao-project/ciao/ciao-controller/types"
+	"github.com/pkg/errors"
 )
 
 // CreateVolume creates a volume from a request
@@ -31,16 +34,71 @@ func (client *Client) CreateVolume(req api.RequestedVolume) (types.Volume, error
 	return vol, err
 }
 
+// CreateVolumeBatch creates a batch of volumes from the same request. The
+// volumes are created in the background, GetVolumeBatch reports the
+// progress of their creation.
+func (client *Client) CreateVolumeBatch(req api.RequestedVolumeBatch) (types.VolumeBatchStatus, error) {
+	var batch types.VolumeBatchStatus
+
+	url := client.buildCiaoURL("%s/volumes/batches", client.TenantID)
+	err := client.postResource(url, api.VolumesV1, &req, &batch)
+
+	return batch, err
+}
+
+// GetVolumeBatch gets the progress of the creation of a batch of volumes
+func (client *Client) GetVolumeBatch(batchID string) (types.VolumeBatchStatus, error) {
+	var batch types.VolumeBatchStatus
+
+	url := client.buildCiaoURL("%s/volumes/batches/%s", client.TenantID, batchID)
+	err := client.getResource(url, api.VolumesV1, nil, &batch)
+
+	return batch, err
+}
+
 // ListVolumes lists the volumes
 func (client *Client) ListVolumes() ([]types.Volume, error) {
+	return client.ListVolumesByLabels(nil, nil)
+}
+
+// ListVolumesByLabels lists the volumes having all the given tags and
+// metadata. Metadata given with an empty value match any value.
+func (client *Client) ListVolumesByLabels(tags []string, metadata map[string]string) ([]types.Volume, error) {
 	var volumes []types.Volume
 
+	values := []queryValue{}
+	for _, t := range tags {
+		values = append(values, queryValue{
+			name:  "tag",
+			value: t,
+		})
+	}
+
+	for k, v := range metadata {
+		m := k
+		if v != "" {
+			m = fmt.Sprintf("%s=%s", k, v)
+		}
+
+		values = append(values, queryValue{
+			name:  "metadata",
+			value: m,
+		})
+	}
+
 	url := client.buildCiaoURL("%s/volumes", client.TenantID)
-	err := client.getResource(url, api.VolumesV1, nil, &volumes)
+	err := client.getResource(url, api.VolumesV1, values, &volumes)
 
 	return volumes, err
 }
 
+// PatchVolume updates the name, description, metadata and tags of a volume
+// with a json merge patch or a list of json patch operations
+func (client *Client) PatchVolume(volumeID string, patch []byte, format types.PatchFormat) error {
+	url := client.buildCiaoURL("%s/volumes/%s", client.TenantID, volumeID)
+	return client.patchResource(url, patch, format)
+}
+
 // GetVolume gets the details of a single volume
 func (client *Client) GetVolume(volumeID string) (types.Volume, error) {
 	var volume types.Volume
@@ -100,3 +158,32 @@ func (client *Client) DetachVolume(volumeID string) error {
 
 	return err
 }
+
+// ProtectVolume protects the given volume against deletion, or removes
+// that protection if protect is false
+func (client *Client) ProtectVolume(volumeID string, protect bool) error {
+	url := client.buildCiaoURL("%s/volumes/%s/action", client.TenantID, volumeID)
+
+	action := "unprotect"
+	if protect {
+		action = "protect"
+	}
+	req := map[string]struct{}{action: {}}
+
+	return client.postResource(url, api.VolumesV1, &req, nil)
+}
+
+// ListStoragePools lists the storage pools in which volumes can be created,
+// along with their capacity.
+func (client *Client) ListStoragePools() (types.StoragePoolsResponse, error) {
+	var pools types.StoragePoolsResponse
+
+	if !client.IsPrivileged() {
+		return pools, errors.New("This command is only available to admins")
+	}
+
+	url := client.buildCiaoURL("storage/pools")
+	err := client.getResource(url, api.StoragePoolsV1, nil, &pools)
+
+	return pools, err
+}
@@ -69,6 +69,19 @@ func (client *Client) DeleteWorkload(workloadID string) error {
 	return client.deleteResource(url, api.WorkloadsV1)
 }
 
+// PatchWorkload updates the given workload with a json merge patch or a
+// list of json patch operations
+func (client *Client) PatchWorkload(workloadID string, patch []byte, format types.PatchFormat) error {
+	url, err := client.getCiaoWorkloadsResource()
+	if err != nil {
+		return errors.Wrap(err, "Error getting workloads resource")
+	}
+
+	url = fmt.Sprintf("%s/%s", url, workloadID)
+
+	return client.patchResource(url, patch, format)
+}
+
 // GetWorkload gets the given workload
 func (client *Client) GetWorkload(workloadID string) (types.Workload, error) {
 	var wl types.Workload
@@ -83,3 +96,28 @@ func (client *Client) GetWorkload(workloadID string) (types.Workload, error) {
 
 	return wl, err
 }
+
+// PreviewLaunch renders the configuration the launcher would receive for
+// an instance of the given workload, without launching it. Admins preview
+// the launch for the tenant forTenantID.
+func (client *Client) PreviewLaunch(workloadID string, forTenantID string, name string) (types.LaunchPreview, error) {
+	var preview types.LaunchPreview
+
+	url, err := client.getCiaoWorkloadsResource()
+	if err != nil {
+		return preview, errors.Wrap(err, "Error getting workloads resource")
+	}
+
+	url = fmt.Sprintf("%s/%s/preview", url, workloadID)
+
+	req := types.LaunchPreviewRequest{
+		Name: name,
+	}
+	if client.IsPrivileged() {
+		req.TenantID = forTenantID
+	}
+
+	err = client.postResource(url, api.WorkloadsV1, &req, &preview)
+
+	return preview, err
+}
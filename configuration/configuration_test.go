@@ -65,6 +65,8 @@ const fullValidConf = `configure:
     cnci_disk: 128
     admin_ssh_key: ""
     client_auth_ca_cert_path: /etc/pki/ciao/auth-CA.pem
+    compress_responses: false
+    disable_http2: false
   launcher:
     compute_net:
     - 192.168.1.0/24
@@ -25,6 +25,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -190,3 +191,30 @@ func GetLoadAvg() int {
 
 	return load
 }
+
+func getCPUFlags(file io.Reader) []string {
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == "flags" {
+			return strings.Fields(fields[1])
+		}
+	}
+
+	return nil
+}
+
+// GetCPUFlags returns the feature flags of the first CPU of the device, as
+// listed in /proc/cpuinfo.  nil is returned if an error occurred.
+func GetCPUFlags() []string {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return nil
+	}
+
+	flags := getCPUFlags(file)
+
+	_ = file.Close()
+
+	return flags
+}
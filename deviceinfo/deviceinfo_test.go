@@ -22,6 +22,7 @@ package deviceinfo
 import (
 	"bytes"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -88,6 +89,18 @@ procs_blocked 0
 softirq 2742553 2 1348123 34687 170653 103600 0 45 0 0 1085443
 `
 
+const cpuInfoContents = `processor	: 0
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 85
+model name	: Intel(R) Xeon(R) Gold 6140 CPU @ 2.30GHz
+flags		: fpu vme de pse sse4_1 sse4_2 avx avx2 avx512f
+bugs		: cpu_meltdown
+
+processor	: 1
+flags		: fpu
+`
+
 // TestGetMemoryInfo tests the code that parses /proc/meminfo
 //
 // We call getMemoryInfo to parse a buffer that contains the contents of
@@ -159,3 +172,19 @@ func TestGetLoadAvg(t *testing.T) {
 		t.Errorf("Expected Load %d , found %d", expectedLoad, load)
 	}
 }
+
+// TestGetCPUFlags tests the code that parses /proc/cpuinfo
+//
+// We call getCPUFlags to parse a buffer that contains the contents of an
+// example cpuinfo file describing two CPUs.
+//
+// The flags of the first CPU should be returned.
+func TestGetCPUFlags(t *testing.T) {
+	buf := bytes.NewBufferString(cpuInfoContents)
+	flags := getCPUFlags(buf)
+	expected := []string{"fpu", "vme", "de", "pse", "sse4_1", "sse4_2",
+		"avx", "avx2", "avx512f"}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected flags %v, found %v", expected, flags)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package deviceinfo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const hwmonDir = "/sys/class/hwmon"
+const edacDir = "/sys/devices/system/edac/mc"
+
+// Temperature is the reading of a temperature sensor of the device.
+type Temperature struct {
+	// Name of the sensor, made of the name of the chip and of the
+	// label of the sensor, e.g., coretemp/Core 0.
+	Sensor string
+
+	// Temperature in degrees Celsius.
+	Celsius int
+}
+
+func readSysfsString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readSysfsInt(path string) (int, error) {
+	s, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(s)
+}
+
+func getTemperatures(dir string) []Temperature {
+	var temps []Temperature
+
+	inputs, _ := filepath.Glob(filepath.Join(dir, "hwmon*", "temp*_input"))
+	for _, input := range inputs {
+		millis, err := readSysfsInt(input)
+		if err != nil {
+			continue
+		}
+
+		chipDir := filepath.Dir(input)
+		chip, err := readSysfsString(filepath.Join(chipDir, "name"))
+		if err != nil {
+			chip = filepath.Base(chipDir)
+		}
+
+		sensor := strings.TrimSuffix(filepath.Base(input), "_input")
+		label, err := readSysfsString(filepath.Join(chipDir, sensor+"_label"))
+		if err == nil && label != "" {
+			sensor = label
+		}
+
+		temps = append(temps, Temperature{
+			Sensor:  chip + "/" + sensor,
+			Celsius: millis / 1000,
+		})
+	}
+
+	return temps
+}
+
+// GetTemperatures returns the readings of the temperature sensors of the
+// device exposed through hwmon, which include those of most CPUs, GPUs and
+// NVMe drives.  nil is returned if the device has no such sensor.
+func GetTemperatures() []Temperature {
+	return getTemperatures(hwmonDir)
+}
+
+func getECCErrors(dir string) (corrected, uncorrected int) {
+	controllers, _ := filepath.Glob(filepath.Join(dir, "mc[0-9]*"))
+	if len(controllers) == 0 {
+		return -1, -1
+	}
+
+	for _, mc := range controllers {
+		ce, err := readSysfsInt(filepath.Join(mc, "ce_count"))
+		if err != nil {
+			return -1, -1
+		}
+
+		ue, err := readSysfsInt(filepath.Join(mc, "ue_count"))
+		if err != nil {
+			return -1, -1
+		}
+
+		corrected += ce
+		uncorrected += ue
+	}
+
+	return
+}
+
+// GetECCErrors returns the number of corrected and uncorrected memory
+// errors counted by the EDAC memory controllers of the device since it
+// booted.  A return value of -1 indicates that the device does not report
+// memory errors.
+func GetECCErrors() (corrected, uncorrected int) {
+	return getECCErrors(edacDir)
+}
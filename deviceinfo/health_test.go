@@ -0,0 +1,107 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package deviceinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSysfsFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestGetTemperatures tests the code that reads the hwmon sensors.
+//
+// The test creates a dummy hwmon directory containing a labelled sensor, an
+// unlabelled sensor and an unreadable sensor.
+//
+// The two readable sensors should be returned, named after their chip and
+// label.
+func TestGetTemperatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deviceinfo_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	writeSysfsFiles(t, dir, map[string]string{
+		"hwmon0/name":        "coretemp\n",
+		"hwmon0/temp1_input": "45000\n",
+		"hwmon0/temp1_label": "Core 0\n",
+		"hwmon1/name":        "amdgpu\n",
+		"hwmon1/temp1_input": "71500\n",
+		"hwmon1/temp2_input": "N/A\n",
+	})
+
+	temps := getTemperatures(dir)
+	if len(temps) != 2 {
+		t.Fatalf("Expected 2 temperatures, found %v", temps)
+	}
+
+	if temps[0] != (Temperature{"coretemp/Core 0", 45}) ||
+		temps[1] != (Temperature{"amdgpu/temp1", 71}) {
+		t.Errorf("Unexpected temperatures %v", temps)
+	}
+
+	if getTemperatures(filepath.Join(dir, "missing")) != nil {
+		t.Errorf("Expected no temperature for a missing hwmon directory")
+	}
+}
+
+// TestGetECCErrors tests the code that reads the EDAC counters.
+//
+// The test creates a dummy EDAC directory with two memory controllers.
+//
+// The counters of both controllers should be summed and -1 should be
+// returned for a device without EDAC.
+func TestGetECCErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deviceinfo_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	writeSysfsFiles(t, dir, map[string]string{
+		"mc0/ce_count": "3\n",
+		"mc0/ue_count": "0\n",
+		"mc1/ce_count": "4\n",
+		"mc1/ue_count": "1\n",
+	})
+
+	corrected, uncorrected := getECCErrors(dir)
+	if corrected != 7 || uncorrected != 1 {
+		t.Errorf("Expected 7 corrected and 1 uncorrected errors, found %d and %d",
+			corrected, uncorrected)
+	}
+
+	corrected, uncorrected = getECCErrors(filepath.Join(dir, "missing"))
+	if corrected != -1 || uncorrected != -1 {
+		t.Errorf("Expected -1 for a device without EDAC, found %d and %d",
+			corrected, uncorrected)
+	}
+}
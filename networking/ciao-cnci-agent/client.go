@@ -49,6 +49,7 @@ var mgmtNet string
 var enableNetwork bool
 var enableNATssh bool
 var agentUUID string
+var trafficInterval time.Duration
 
 func init() {
 	flag.StringVar(&serverURL, "server", "", "URL of SSNTP server, Use auto for auto discovery")
@@ -59,6 +60,7 @@ func init() {
 	flag.BoolVar(&enableNetwork, "network", true, "Enable networking")
 	flag.BoolVar(&enableNATssh, "ssh", true, "Enable NAT and SSH")
 	flag.StringVar(&agentUUID, "uuid", "", "UUID the CNCI Agent should use. Autogenerated otherwise")
+	flag.DurationVar(&trafficInterval, "traffic-interval", time.Minute, "Interval between traffic reports, 0 to disable them")
 }
 
 const (
@@ -232,6 +234,17 @@ func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 			}
 		}(cmd)
 
+	case *payloads.CommandNetworkPolicy:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.Policy
+			glog.Infof("Processing: CiaoCommandNetworkPolicy %v", c)
+			err := setNetworkPolicy(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoCommandNetworkPolicy %+v", err)
+			}
+		}(cmd)
+
 	case *statusConnected:
 		//Block and send this as it does not make sense to send other events
 		//or process commands when we have not yet registered
@@ -291,6 +304,26 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			client.cmdCh <- &cmdWrapper{&releaseIP}
 		}(payload)
 
+	case ssntp.NetworkPolicy:
+		glog.Infof("CMD: ssntp.NetworkPolicy %v", len(payload))
+
+		go func(payload []byte) {
+			var policy payloads.CommandNetworkPolicy
+			err := yaml.Unmarshal(payload, &policy)
+			if err != nil {
+				glog.Warning("Error unmarshalling NetworkPolicy")
+				return
+			}
+			glog.Infof("EVENT: ssntp.NetworkPolicy %v", policy)
+
+			err = dbProcessCommand(client.db, &policy)
+			if err != nil {
+				glog.Errorf("unable to save state %+v", err)
+			}
+
+			client.cmdCh <- &cmdWrapper{&policy}
+		}(payload)
+
 	default:
 		glog.Infof("CMD: %s", cmd)
 	}
@@ -370,6 +403,13 @@ func connectToServer(db *cnciDatabase, doneCh chan struct{}, statusCh chan struc
 
 	dialing := true
 
+	var trafficCh <-chan time.Time
+	if enableNetwork && trafficInterval > 0 {
+		ticker := time.NewTicker(trafficInterval)
+		defer ticker.Stop()
+		trafficCh = ticker.C
+	}
+
 DONE:
 	for {
 		select {
@@ -397,6 +437,16 @@ DONE:
 			}
 			glog.Infof("cmd channel: %v", cmd)
 			processCommand(&client.ssntpConn, cmd)
+		case <-trafficCh:
+			if !client.isConnected() {
+				continue
+			}
+			go func() {
+				err := sendNetworkEvent(&client.ssntpConn, ssntp.TrafficReport, nil)
+				if err != nil {
+					glog.Errorf("Unable to send traffic report : %+v", err)
+				}
+			}()
 		}
 	}
 }
@@ -454,6 +504,8 @@ func rebuildNetworkState(db *cnciDatabase) error {
 	defer db.SubnetMap.Unlock()
 	db.PublicIPMap.Lock()
 	defer db.PublicIPMap.Unlock()
+	db.PolicyMap.Lock()
+	defer db.PolicyMap.Unlock()
 
 	for key, subnet := range db.SubnetMap.m {
 		glog.Infof("Key: %v Subnet: %v", key, subnet)
@@ -473,6 +525,15 @@ func rebuildNetworkState(db *cnciDatabase) error {
 		}
 	}
 
+	for key, policy := range db.PolicyMap.m {
+		glog.Infof("Key: %v Policy: %v", key, policy)
+		err := setNetworkPolicy(policy)
+		if err != nil {
+			lastError = err
+			glog.Errorf("rebuildNetworkState: %v", err)
+		}
+	}
+
 	return errors.Wrapf(lastError, "rebuild network state")
 }
 
@@ -30,11 +30,13 @@ type cnciDatabase struct {
 	database.DbProvider //Database used to persist the CNCI state
 	SubnetMap
 	PublicIPMap
+	PolicyMap
 }
 
 const (
 	tableSubnetMap   = "SubnetMap"
 	tablePublicIPMap = "PublicIPMap"
+	tablePolicyMap   = "PolicyMap"
 )
 
 //dbCfg controls plugin data base attributes
@@ -114,11 +116,44 @@ func (d *PublicIPMap) Add(k string, v interface{}) error {
 	return nil
 }
 
+//PolicyMap maintains the inter-subnet routing policy of the tenants
+//handled by this CNCI
+type PolicyMap struct {
+	sync.Mutex
+	m map[string]*payloads.NetworkPolicyCommand //index: Tenant UUID
+}
+
+//NewTable creates a new map
+func (d *PolicyMap) NewTable() {
+	d.m = make(map[string]*payloads.NetworkPolicyCommand)
+}
+
+//Name provides the name of the map
+func (d *PolicyMap) Name() string {
+	return tablePolicyMap
+}
+
+//NewElement allocates and returns a policy value
+func (d *PolicyMap) NewElement() interface{} {
+	return &payloads.NetworkPolicyCommand{}
+}
+
+//Add adds a value to the map with the specified key
+func (d *PolicyMap) Add(k string, v interface{}) error {
+	val, ok := v.(*payloads.NetworkPolicyCommand)
+	if !ok {
+		return errors.Errorf("Invalid value type %t", v)
+	}
+	d.m[k] = val
+	return nil
+}
+
 func dbInit() (*cnciDatabase, error) {
 	db := &cnciDatabase{}
 	db.DbProvider = database.NewBoltDBProvider()
 	db.SubnetMap.m = make(map[string]*payloads.TenantAddedEvent)
 	db.PublicIPMap.m = make(map[string]*payloads.PublicIPCommand)
+	db.PolicyMap.m = make(map[string]*payloads.NetworkPolicyCommand)
 
 	if err := db.DbInit(dbCfg.DataDir, dbCfg.DbFile); err != nil {
 		return nil, errors.Wrapf(err, "db init: %v, %v", dbCfg.DataDir, dbCfg.DbFile)
@@ -129,6 +164,9 @@ func dbInit() (*cnciDatabase, error) {
 	if err := db.DbTableRebuild(&db.PublicIPMap); err != nil {
 		return nil, errors.Wrapf(err, "publicIPMap")
 	}
+	if err := db.DbTableRebuild(&db.PolicyMap); err != nil {
+		return nil, errors.Wrapf(err, "policyMap")
+	}
 	return db, nil
 }
 
@@ -199,6 +237,20 @@ func dbProcessCommand(db *cnciDatabase, cmd interface{}) error {
 			return errors.Wrapf(err, "delete Public IP from db: %v", c)
 		}
 
+	case *payloads.CommandNetworkPolicy:
+
+		c := &netCmd.Policy
+
+		db.PolicyMap.Lock()
+		defer db.PolicyMap.Unlock()
+
+		key := c.TenantUUID
+		db.PolicyMap.m[key] = c
+
+		if err := db.DbAdd(tablePolicyMap, key, db.PolicyMap.m[key]); err != nil {
+			return errors.Wrapf(err, "add network policy to db: %v", c)
+		}
+
 	default:
 		return errors.Errorf("unknown command: %v", netCmd)
 
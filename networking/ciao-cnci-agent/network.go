@@ -105,13 +105,11 @@ func unmarshallSubnetParams(cmd *payloads.TenantAddedEvent) (*net.IPNet, int, ne
 		return nil, 0, nil, errors.Wrapf(err, "invalid CN IP %s", cmd.ConcentratorIP)
 	}
 
-	//TODO
-	//When we go away from a 1:1 subnet to key map remove this check
-	//Today this ensures the sanity of the YAML and CN
-	key := int(binary.LittleEndian.Uint32(snet.IP))
+	//The subnet key is either derived from the subnet or a VNI
+	//allocated by the controller, which is never 0
 	subnetKey := cmd.SubnetKey
-	if key != subnetKey {
-		return nil, 0, nil, errors.Wrapf(err, "invalid subnet key %s %x", cmd.TenantSubnet, cmd.SubnetKey)
+	if subnetKey == 0 {
+		return nil, 0, nil, errors.Errorf("invalid subnet key %s %x", cmd.TenantSubnet, cmd.SubnetKey)
 	}
 
 	return snet, subnetKey, cIP, nil
@@ -184,6 +182,11 @@ func addRemoteSubnet(cmd *payloads.TenantAddedEvent) error {
 
 	glog.Infof("cnci.AddRemoteSubnet success %s %x %s", rs, tk, rip, err)
 
+	err = gFw.TrafficAccounting(libsnnet.FwEnable, genIPsInSubnet(*rs))
+	if err != nil {
+		return errors.Wrapf(err, "enable traffic accounting %s %x", rs, tk)
+	}
+
 	if enableNATssh && bridge != "" {
 		err = natSSHSubnet(libsnnet.FwEnable, *rs, bridge, gCnci.ComputeLink[0].Attrs().Name)
 		if err != nil {
@@ -212,6 +215,11 @@ func delRemoteSubnet(cmd *payloads.TenantAddedEvent) error {
 	}
 	glog.Infof("cnci.DelRemoteSubnet success %s %x %s", rs, tk, rip, err)
 
+	err = gFw.TrafficAccounting(libsnnet.FwDisable, genIPsInSubnet(*rs))
+	if err != nil {
+		return errors.Wrapf(err, "disable traffic accounting %s %x", rs, tk)
+	}
+
 	/* We do not delete the bridge till reset.
 	if enableNATssh {
 		err = natSshSubnet(libsnnet.FwDisable, *rs, bridge, gCnci.ComputeLink[0].Attrs().Name)
@@ -272,6 +280,42 @@ func publicIPUnassignedMarshal(cmd *payloads.PublicIPCommand) ([]byte, error) {
 	return yaml.Marshal(&publicIPUnassigned)
 }
 
+func trafficReportMarshal(agentUUID string) ([]byte, error) {
+	if gFw == nil {
+		return nil, errors.Errorf("firewall not initialized")
+	}
+
+	counters, err := gFw.TrafficCounters()
+	if err != nil {
+		return nil, errors.Wrapf(err, "traffic counters")
+	}
+
+	var trafficReport payloads.EventTrafficReport
+	evt := &trafficReport.TrafficReport
+
+	evt.ConcentratorUUID = agentUUID
+	evt.TenantUUID = gCnci.Tenant
+
+	//Most IPs of a subnet are unused, only report those with traffic
+	for _, c := range counters {
+		if c.RxPackets == 0 && c.TxPackets == 0 {
+			continue
+		}
+
+		evt.Counters = append(evt.Counters, payloads.TrafficCounter{
+			PrivateIP: c.IP.String(),
+			RxBytes:   c.RxBytes,
+			RxPackets: c.RxPackets,
+			TxBytes:   c.TxBytes,
+			TxPackets: c.TxPackets,
+		})
+	}
+
+	glog.V(1).Infoln("TrafficReport Event ", trafficReport)
+
+	return yaml.Marshal(&trafficReport)
+}
+
 func publicIPFailureMarshal(reason payloads.PublicIPFailureReason, cmd *payloads.PublicIPCommand) ([]byte, error) {
 	var failure payloads.ErrorPublicIPFailure
 
@@ -366,6 +410,8 @@ func generateNetEventPayload(eventType ssntp.Event, eventInfo interface{}, agent
 			return nil, errors.Errorf("invalid eventInfo [%T] %v", eventInfo, eventInfo)
 		}
 		return publicIPUnassignedMarshal(cmd)
+	case ssntp.TrafficReport:
+		return trafficReportMarshal(agentUUID)
 	default:
 		return nil, errors.Errorf("unsupported ssntpEventInfo type: %v", eventType)
 	}
@@ -409,3 +455,42 @@ func releasePubIP(cmd *payloads.PublicIPCommand) error {
 	err = gFw.PublicIPAccess(libsnnet.FwDisable, prIP, puIP, gCnci.ComputeLink[0].Attrs().Name)
 	return errors.Wrapf(err, "release ip")
 }
+
+func unmarshallPolicy(cmd *payloads.NetworkPolicyCommand) (*net.IPNet, []libsnnet.PolicyRule, error) {
+	_, tnet, err := net.ParseCIDR(cmd.TenantNetwork)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid tenant network")
+	}
+
+	var rules []libsnnet.PolicyRule
+	for _, r := range cmd.Rules {
+		_, src, err := net.ParseCIDR(r.Source)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid rule source")
+		}
+
+		_, dst, err := net.ParseCIDR(r.Destination)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid rule destination")
+		}
+
+		rules = append(rules, libsnnet.PolicyRule{Source: *src, Destination: *dst})
+	}
+
+	return tnet, rules, nil
+}
+
+func setNetworkPolicy(cmd *payloads.NetworkPolicyCommand) error {
+
+	tnet, rules, err := unmarshallPolicy(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params %v", cmd)
+	}
+
+	if !enableNetwork {
+		return nil
+	}
+
+	err = gFw.TenantPolicy(*tnet, cmd.AllowAll, rules)
+	return errors.Wrapf(err, "network policy")
+}
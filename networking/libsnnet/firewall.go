@@ -22,6 +22,7 @@ import (
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
@@ -67,7 +68,9 @@ Routing decision                                                  |
 */
 
 const (
-	procIPFwd = "/proc/sys/net/ipv4/ip_forward"
+	procIPFwd         = "/proc/sys/net/ipv4/ip_forward"
+	tenantPolicyChain = "ciao-tenant-policy"
+	accountingChain   = "ciao-accounting"
 )
 
 //FwAction defines firewall action to be performed
@@ -462,6 +465,213 @@ func disablePublicIP(intIP, pubIP string) error {
 	return nil
 }
 
+//PolicyRule allows the traffic from the Source to the Destination subnet
+type PolicyRule struct {
+	Source      net.IPNet
+	Destination net.IPNet
+}
+
+//TenantPolicy replaces the filtering of the traffic routed between the
+//subnets of the tenant network. Unless allowAll is set, only the traffic
+//allowed by the rules is routed, along with its replies
+func (f *Firewall) TenantPolicy(tenantNet net.IPNet, allowAll bool, rules []PolicyRule) error {
+	//iptables -N ciao-tenant-policy or -F if it exists
+	err := f.ClearChain("filter", tenantPolicyChain)
+	if err != nil {
+		return fmt.Errorf("tenant policy chain clear failed: %v", err)
+	}
+
+	//iptables -I FORWARD 1 -j ciao-tenant-policy
+	ok, err := f.Exists("filter", "FORWARD", "-j", tenantPolicyChain)
+	if err != nil {
+		return fmt.Errorf("tenant policy chain lookup failed: %v", err)
+	}
+	if !ok {
+		err = f.Insert("filter", "FORWARD", 1, "-j", tenantPolicyChain)
+		if err != nil {
+			return fmt.Errorf("tenant policy chain insert failed: %v", err)
+		}
+	}
+
+	if allowAll {
+		return nil
+	}
+
+	tn := tenantNet.String()
+
+	//iptables -A ciao-tenant-policy -s $tn -d $tn
+	// -m state --state RELATED,ESTABLISHED -j ACCEPT
+	err = f.Append("filter", tenantPolicyChain,
+		"-s", tn, "-d", tn,
+		"-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+	if err != nil {
+		return fmt.Errorf("tenant policy replies failed: %v", err)
+	}
+
+	for _, r := range rules {
+		//iptables -A ciao-tenant-policy -s $src -d $dst -j ACCEPT
+		err = f.Append("filter", tenantPolicyChain,
+			"-s", r.Source.String(), "-d", r.Destination.String(), "-j", "ACCEPT")
+		if err != nil {
+			return fmt.Errorf("tenant policy rule failed: %v [%s] [%s]",
+				err, r.Source.String(), r.Destination.String())
+		}
+	}
+
+	//iptables -A ciao-tenant-policy -s $tn -d $tn -j DROP
+	err = f.Append("filter", tenantPolicyChain, "-s", tn, "-d", tn, "-j", "DROP")
+	if err != nil {
+		return fmt.Errorf("tenant policy drop failed: %v", err)
+	}
+
+	return nil
+}
+
+//TrafficCounter contains the traffic routed between an instance IP
+//and the external interfaces since accounting was enabled for it
+type TrafficCounter struct {
+	IP        net.IP
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+func accountingRules(ip net.IP, extDevice string) [][]string {
+	host := ip.String() + "/32"
+
+	return [][]string{
+		{"-o", extDevice, "-s", host, "-j", "RETURN"},
+		{"-i", extDevice, "-d", host, "-j", "RETURN"},
+	}
+}
+
+//TrafficAccounting enables or disables the accounting of the traffic
+//routed between the external interfaces and each of the IPs.
+//The accounting rules only count packets, they do not filter them
+func (f *Firewall) TrafficAccounting(action FwAction, ips []net.IP) error {
+	if action == FwEnable {
+		//iptables -N ciao-accounting
+		_ = f.NewChain("filter", accountingChain)
+
+		//iptables -I FORWARD 1 -j ciao-accounting
+		ok, err := f.Exists("filter", "FORWARD", "-j", accountingChain)
+		if err != nil {
+			return fmt.Errorf("accounting chain lookup failed: %v", err)
+		}
+		if !ok {
+			err = f.Insert("filter", "FORWARD", 1, "-j", accountingChain)
+			if err != nil {
+				return fmt.Errorf("accounting chain insert failed: %v", err)
+			}
+		}
+	}
+
+	for _, device := range f.ExtInterfaces {
+		for _, ip := range ips {
+			for _, rule := range accountingRules(ip, device) {
+				var err error
+
+				switch action {
+				case FwEnable:
+					//iptables -A ciao-accounting -o $device -s $ip/32 -j RETURN
+					//iptables -A ciao-accounting -i $device -d $ip/32 -j RETURN
+					err = f.AppendUnique("filter", accountingChain, rule...)
+				case FwDisable:
+					err = f.Delete("filter", accountingChain, rule...)
+					if err != nil {
+						ok, _ := f.Exists("filter", accountingChain, rule...)
+						if !ok {
+							err = nil
+						}
+					}
+				}
+
+				if err != nil {
+					return fmt.Errorf("Unable to %v accounting for %v %v %v",
+						action, device, ip, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+//TrafficCounters returns the traffic accounted for each IP by the
+//rules set up by TrafficAccounting
+func (f *Firewall) TrafficCounters() ([]TrafficCounter, error) {
+	//iptables -t filter -L ciao-accounting -n -v -x
+	out, err := exec.Command("iptables", "-t", "filter", "-L", accountingChain,
+		"-n", "-v", "-x").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list accounting chain %v %s", err, string(out))
+	}
+
+	return parseTrafficCounters(string(out))
+}
+
+//parseTrafficCounters parses the verbose listing of the accounting chain,
+//summing up the counters of the rules of each IP
+func parseTrafficCounters(listing string) ([]TrafficCounter, error) {
+	var counters []TrafficCounter
+	index := make(map[string]int)
+
+	for _, line := range strings.Split(listing, "\n") {
+		//pkts bytes target prot opt in out source destination
+		fields := strings.Fields(line)
+		if len(fields) < 9 || fields[0] == "Chain" || fields[0] == "pkts" {
+			continue
+		}
+
+		pkts, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet count %q", line)
+		}
+
+		bytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte count %q", line)
+		}
+
+		in, out, src, dst := fields[5], fields[6], fields[7], fields[8]
+
+		var host string
+		var tx bool
+		switch {
+		case out != "*":
+			host = src
+			tx = true
+		case in != "*":
+			host = dst
+		default:
+			continue
+		}
+
+		ip := net.ParseIP(strings.TrimSuffix(host, "/32"))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", line)
+		}
+
+		i, ok := index[ip.String()]
+		if !ok {
+			i = len(counters)
+			index[ip.String()] = i
+			counters = append(counters, TrafficCounter{IP: ip})
+		}
+
+		if tx {
+			counters[i].TxBytes += bytes
+			counters[i].TxPackets += pkts
+		} else {
+			counters[i].RxBytes += bytes
+			counters[i].RxPackets += pkts
+		}
+	}
+
+	return counters, nil
+}
+
 //DumpIPTables provides a utility routine that returns
 //the current state of the iptables
 func DumpIPTables() string {
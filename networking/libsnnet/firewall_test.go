@@ -136,6 +136,108 @@ func TestFw_PublicIP(t *testing.T) {
 	}
 }
 
+//Test programming of the tenant inter-subnet policy
+//
+//Test if the tenant policy can be restricted to a set of
+//rules and reset to allow all traffic
+//
+//Test is expected to pass
+func TestFw_TenantPolicy(t *testing.T) {
+	fwinit()
+	fw, err := InitFirewall(fwIf)
+	if err != nil {
+		t.Fatalf("Error: InitFirewall %v %v %v", fwIf, err, fw)
+	}
+
+	_, tenantNet, _ := net.ParseCIDR("172.16.0.0/12")
+	_, src, _ := net.ParseCIDR("172.16.0.0/24")
+	_, dst, _ := net.ParseCIDR("172.16.1.0/24")
+
+	rules := []PolicyRule{{Source: *src, Destination: *dst}}
+
+	err = fw.TenantPolicy(*tenantNet, false, rules)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	err = fw.TenantPolicy(*tenantNet, true, nil)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	err = fw.ShutdownFirewall()
+	if err != nil {
+		t.Errorf("Error: Unable to shutdown firewall %v", err)
+	}
+}
+
+//Tests traffic accounting
+//
+//Test if accounting rules can be added for a set of IPs,
+//their counters read and the rules removed
+//
+//Test is expected to pass
+func TestFw_TrafficAccounting(t *testing.T) {
+	fwinit()
+	fw, err := InitFirewall(fwIf)
+	require.Nil(t, err)
+
+	ips := []net.IP{net.ParseIP("172.16.0.2"), net.ParseIP("172.16.0.3")}
+
+	err = fw.TrafficAccounting(FwEnable, ips)
+	assert.Nil(t, err)
+
+	counters, err := fw.TrafficCounters()
+	assert.Nil(t, err)
+	require.Len(t, counters, 2)
+	assert.True(t, counters[0].IP.Equal(ips[0]))
+	assert.True(t, counters[1].IP.Equal(ips[1]))
+
+	err = fw.TrafficAccounting(FwDisable, ips)
+	assert.Nil(t, err)
+
+	counters, err = fw.TrafficCounters()
+	assert.Nil(t, err)
+	assert.Len(t, counters, 0)
+
+	assert.Nil(t, fw.ShutdownFirewall())
+}
+
+//Tests the parsing of the accounting counters
+//
+//Test if the counters of the inbound and outbound rules
+//of each IP are summed up
+//
+//Test is expected to pass
+func TestFw_ParseTrafficCounters(t *testing.T) {
+	listing := `Chain ciao-accounting (1 references)
+    pkts      bytes target     prot opt in     out     source               destination
+      10     1500 RETURN     all  --  *      eth0    172.16.0.2           0.0.0.0/0
+       4      600 RETURN     all  --  eth0   *       0.0.0.0/0            172.16.0.2
+       1      100 RETURN     all  --  *      eth1    172.16.0.2           0.0.0.0/0
+       0        0 RETURN     all  --  *      eth0    172.16.0.3           0.0.0.0/0
+       2      128 RETURN     all  --  eth0   *       0.0.0.0/0            172.16.0.3
+`
+
+	counters, err := parseTrafficCounters(listing)
+	require.Nil(t, err)
+	require.Len(t, counters, 2)
+
+	assert.True(t, counters[0].IP.Equal(net.ParseIP("172.16.0.2")))
+	assert.Equal(t, uint64(1600), counters[0].TxBytes)
+	assert.Equal(t, uint64(11), counters[0].TxPackets)
+	assert.Equal(t, uint64(600), counters[0].RxBytes)
+	assert.Equal(t, uint64(4), counters[0].RxPackets)
+
+	assert.True(t, counters[1].IP.Equal(net.ParseIP("172.16.0.3")))
+	assert.Equal(t, uint64(0), counters[1].TxBytes)
+	assert.Equal(t, uint64(128), counters[1].RxBytes)
+	assert.Equal(t, uint64(2), counters[1].RxPackets)
+
+	_, err = parseTrafficCounters("    x 1500 RETURN all -- * eth0 172.16.0.2 0.0.0.0/0\n")
+	assert.NotNil(t, err)
+}
+
 //Exercises all valid CNCI Firewall APIs
 //
 //This tests performs the sequence of operations typically
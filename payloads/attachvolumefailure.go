@@ -73,6 +73,10 @@ type ErrorAttachVolumeFailure struct {
 	// Reason provides the reason for the attach failure, e.g.,
 	// AttachVolumehNoInstance.
 	Reason AttachVolumeFailureReason `yaml:"reason"`
+
+	// CommandID is the identifier of the AttachVolume command that
+	// failed, if it had one.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 func (r AttachVolumeFailureReason) String() string {
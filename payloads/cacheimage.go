@@ -0,0 +1,31 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// CacheImageCmd contains the container image a SSNTP Agent is asked to
+// cache on its node.
+type CacheImageCmd struct {
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Image is the name of the container image to download.
+	Image string `yaml:"image"`
+}
+
+// CacheImage represents the SSNTP CacheImage command payload.
+type CacheImage struct {
+	CacheImage CacheImageCmd `yaml:"cache_image"`
+}
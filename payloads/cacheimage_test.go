@@ -0,0 +1,56 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCacheImageMarshal(t *testing.T) {
+	var cmd CacheImage
+	cmd.CacheImage.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.CacheImage.Image = "ubuntu:16.04"
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.CacheImageYaml {
+		t.Errorf("CacheImage marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.CacheImageYaml)
+	}
+}
+
+func TestCacheImageUnmarshal(t *testing.T) {
+	var cmd CacheImage
+	err := yaml.Unmarshal([]byte(testutil.CacheImageYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.CacheImage.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.CacheImage.WorkloadAgentUUID)
+	}
+
+	if cmd.CacheImage.Image != "ubuntu:16.04" {
+		t.Errorf("Wrong image field [%s]", cmd.CacheImage.Image)
+	}
+}
@@ -51,6 +51,8 @@ type ConfigureController struct {
 	CNCIDisk             int    `yaml:"cnci_disk"`
 	AdminSSHKey          string `yaml:"admin_ssh_key"`
 	ClientAuthCACertPath string `yaml:"client_auth_ca_cert_path"`
+	CompressResponses    bool   `yaml:"compress_responses"`
+	DisableHTTP2         bool   `yaml:"disable_http2"`
 }
 
 // ConfigureLauncher contains the unmarshalled configurations for the
@@ -67,6 +69,21 @@ type ConfigureLauncher struct {
 // Ceph storage driver.
 type ConfigureStorage struct {
 	CephID string `yaml:"ceph_id"`
+
+	// Pools lists the named storage pools in which volumes can be
+	// created.  When empty, volumes are created in the default rbd
+	// pool.
+	Pools []StoragePool `yaml:"pools,omitempty"`
+
+	// DefaultPool is the name of the pool in which volumes are created
+	// when no pool is requested, the first of Pools when empty.
+	DefaultPool string `yaml:"default_pool,omitempty"`
+}
+
+// StoragePool is a named storage pool, backed by a ceph pool.
+type StoragePool struct {
+	Name     string `yaml:"name"`
+	CephPool string `yaml:"ceph_pool"`
 }
 
 // ConfigurePayload is a wrapper to read and unmarshall all posible
@@ -48,6 +48,10 @@ type ErrorDeleteFailure struct {
 	// Reason provides the reason for the delete failure, e.g.,
 	// DeleteNoInstance.
 	Reason DeleteFailureReason `yaml:"reason"`
+
+	// CommandID is the identifier of the DELETE command that failed,
+	// if it had one.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 func (r DeleteFailureReason) String() string {
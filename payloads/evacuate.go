@@ -19,6 +19,12 @@ package payloads
 // EvacuateCmd contains the nodeID of a SSNTP Agent.
 type EvacuateCmd struct {
 	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// CommandID is set by the sender of the command and echoed back in
+	// the NodeCommandResult event sent once the node is evacuated, so
+	// that the sender can await the completion of the command.  It may
+	// be empty.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 // Evacuate represents the SSNTP EVACUATE command payload.
@@ -0,0 +1,130 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// HotAddCmd contains the vCPUs and memory to add to a running instance.
+type HotAddCmd struct {
+	// InstanceUUID is the UUID of the instance to which resources are to
+	// be added.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// WorkloadAgentUUID identifies the node on which the instance is
+	// running.  This information is needed by the scheduler to route
+	// the command to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// VCPUs is the number of vCPUs to add to the instance.
+	VCPUs int `yaml:"vcpus,omitempty"`
+
+	// MemMB is the amount of memory, in MiB, to add to the instance.
+	MemMB int `yaml:"mem_mb,omitempty"`
+
+	// CommandID is set by the sender of the command and echoed back in
+	// the HotAddFailure error or the NodeCommandResult event sent in
+	// response, so that the sender can await the completion of the
+	// command.  It may be empty.
+	CommandID string `yaml:"command_id,omitempty"`
+}
+
+// HotAdd represents the unmarshalled version of the contents of a SSNTP
+// HotAdd payload.
+type HotAdd struct {
+	HotAdd HotAddCmd `yaml:"hot_add"`
+}
+
+// HotAddFailureReason denotes the underlying error that prevented
+// an SSNTP HotAdd command from adding resources to an instance.
+type HotAddFailureReason string
+
+const (
+	// HotAddNoInstance indicates that resources could not be added to an
+	// instance as the instance does not exist on the node to which the
+	// HotAdd command was sent.
+	HotAddNoInstance HotAddFailureReason = "no_instance"
+
+	// HotAddInvalidPayload indicates that the payload of the SSNTP
+	// HotAdd command was corrupt and could not be unmarshalled.
+	HotAddInvalidPayload = "invalid_payload"
+
+	// HotAddInvalidData is returned by ciao-launcher if the contents
+	// of the HotAdd payload are incorrect, e.g., the instance_uuid
+	// is missing or no resources are requested.
+	HotAddInvalidData = "invalid_data"
+
+	// HotAddHotAddFailure indicates that the hypervisor failed to add
+	// the requested resources to the instance.
+	HotAddHotAddFailure = "hot_add_failure"
+
+	// HotAddNotSupported indicates that the instance cannot be given
+	// more resources while running, e.g., it's a container or it was
+	// not started with room for additional vCPUs and memory.
+	HotAddNotSupported = "not_supported"
+
+	// HotAddInstanceFailure indicates that resources could not be added
+	// as the instance has failed to start and is being deleted.
+	HotAddInstanceFailure = "instance_failure"
+
+	// HotAddStateFailure indicates that launcher was unable to update
+	// its internal state to register the new resources.
+	HotAddStateFailure = "state_failure"
+)
+
+// ErrorHotAddFailure represents the unmarshalled version of the contents of a
+// SSNTP ERROR frame whose type is set to ssntp.HotAddFailure.
+type ErrorHotAddFailure struct {
+	// NodeUUID is the UUID of the node that generated this error.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// InstanceUUID is the UUID of the instance to which resources could
+	// not be added.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// VCPUs is the number of vCPUs that could not be added.
+	VCPUs int `yaml:"vcpus,omitempty"`
+
+	// MemMB is the amount of memory, in MiB, that could not be added.
+	MemMB int `yaml:"mem_mb,omitempty"`
+
+	// Reason provides the reason for the hot add failure, e.g.,
+	// HotAddNoInstance.
+	Reason HotAddFailureReason `yaml:"reason"`
+
+	// CommandID is the identifier of the HotAdd command that failed,
+	// if it had one.
+	CommandID string `yaml:"command_id,omitempty"`
+}
+
+func (r HotAddFailureReason) String() string {
+	switch r {
+	case HotAddNoInstance:
+		return "Instance does not exist"
+	case HotAddInvalidPayload:
+		return "YAML payload is corrupt"
+	case HotAddInvalidData:
+		return "Command section of YAML payload is corrupt or missing required information"
+	case HotAddHotAddFailure:
+		return "Failed to add resources to instance"
+	case HotAddNotSupported:
+		return "Not Supported"
+	case HotAddInstanceFailure:
+		return "Instance failure"
+	case HotAddStateFailure:
+		return "State failure"
+	}
+
+	return ""
+}
@@ -0,0 +1,127 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestHotAddMarshal(t *testing.T) {
+	var cmd HotAdd
+	cmd.HotAdd.InstanceUUID = testutil.InstanceUUID
+	cmd.HotAdd.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.HotAdd.VCPUs = 2
+	cmd.HotAdd.MemMB = 512
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.HotAddYaml {
+		t.Errorf("HotAdd marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.HotAddYaml)
+	}
+}
+
+func TestHotAddUnmarshal(t *testing.T) {
+	var cmd HotAdd
+	err := yaml.Unmarshal([]byte(testutil.HotAddYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.HotAdd.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong Instance UUID field [%s]", cmd.HotAdd.InstanceUUID)
+	}
+
+	if cmd.HotAdd.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.HotAdd.WorkloadAgentUUID)
+	}
+
+	if cmd.HotAdd.VCPUs != 2 || cmd.HotAdd.MemMB != 512 {
+		t.Errorf("Wrong resources fields [%d] [%d]", cmd.HotAdd.VCPUs, cmd.HotAdd.MemMB)
+	}
+}
+
+func TestHotAddFailureUnmarshal(t *testing.T) {
+	var error ErrorHotAddFailure
+	err := yaml.Unmarshal([]byte(testutil.HotAddFailureYaml), &error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if error.NodeUUID != testutil.AgentUUID {
+		t.Error("Wrong Node UUID field")
+	}
+
+	if error.InstanceUUID != testutil.InstanceUUID {
+		t.Error("Wrong Instance UUID field")
+	}
+
+	if error.VCPUs != 2 || error.MemMB != 512 {
+		t.Error("Wrong resources fields")
+	}
+
+	if error.Reason != HotAddHotAddFailure {
+		t.Error("Wrong Error field")
+	}
+}
+
+func TestHotAddFailureMarshal(t *testing.T) {
+	error := ErrorHotAddFailure{
+		NodeUUID:     testutil.AgentUUID,
+		InstanceUUID: testutil.InstanceUUID,
+		VCPUs:        2,
+		MemMB:        512,
+		Reason:       HotAddHotAddFailure,
+	}
+
+	y, err := yaml.Marshal(&error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.HotAddFailureYaml {
+		t.Errorf("HotAddFailure marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), testutil.HotAddFailureYaml)
+	}
+}
+
+func TestHotAddFailureString(t *testing.T) {
+	var stringTests = []struct {
+		r        HotAddFailureReason
+		expected string
+	}{
+		{HotAddNoInstance, "Instance does not exist"},
+		{HotAddInvalidPayload, "YAML payload is corrupt"},
+		{HotAddInvalidData, "Command section of YAML payload is corrupt or missing required information"},
+		{HotAddHotAddFailure, "Failed to add resources to instance"},
+		{HotAddNotSupported, "Not Supported"},
+		{HotAddInstanceFailure, "Instance failure"},
+		{HotAddStateFailure, "State failure"},
+	}
+	for _, test := range stringTests {
+		s := test.r.String()
+		if s != test.expected {
+			t.Errorf("expected \"%s\", got \"%s\"", test.expected, s)
+		}
+	}
+}
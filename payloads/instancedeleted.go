@@ -20,6 +20,10 @@ package payloads
 // deleted.
 type InstanceDeletedEvent struct {
 	InstanceUUID string `yaml:"instance_uuid"`
+
+	// CommandID is the identifier of the DELETE command that deleted
+	// the instance, if it had one.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 // EventInstanceDeleted represents the unmarshalled version of the contents of
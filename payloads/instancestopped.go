@@ -20,6 +20,10 @@ package payloads
 // deleted from a node for the purposes of migration.
 type InstanceStoppedEvent struct {
 	InstanceUUID string `yaml:"instance_uuid"`
+
+	// CommandID is the identifier of the DELETE command that stopped
+	// the instance, if it had one.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 // EventInstanceStopped represents the unmarshalled version of the contents of
@@ -0,0 +1,45 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// NetworkPolicyRule allows the traffic from the source subnet to the
+// destination subnet, along with the replies to this traffic.
+type NetworkPolicyRule struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+}
+
+// NetworkPolicyCommand contains the routing policy between the subnets of
+// a tenant a CNCI is asked to enforce.
+type NetworkPolicyCommand struct {
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+	TenantUUID       string `yaml:"tenant_uuid"`
+
+	// TenantNetwork is the network the subnets of the tenant are
+	// allocated from.
+	TenantNetwork string `yaml:"tenant_network"`
+
+	// AllowAll routes all the traffic between the subnets of the
+	// tenant. Otherwise only the traffic allowed by Rules is routed.
+	AllowAll bool                `yaml:"allow_all"`
+	Rules    []NetworkPolicyRule `yaml:"rules"`
+}
+
+// CommandNetworkPolicy represents the SSNTP NetworkPolicy command payload.
+type CommandNetworkPolicy struct {
+	Policy NetworkPolicyCommand `yaml:"network_policy"`
+}
@@ -0,0 +1,64 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNetworkPolicyMarshal(t *testing.T) {
+	var cmd CommandNetworkPolicy
+	cmd.Policy.ConcentratorUUID = testutil.CNCIUUID
+	cmd.Policy.TenantUUID = testutil.TenantUUID
+	cmd.Policy.TenantNetwork = "172.16.0.0/12"
+	cmd.Policy.Rules = []NetworkPolicyRule{
+		{Source: "172.16.0.0/24", Destination: "172.16.1.0/24"},
+	}
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.NetworkPolicyYaml {
+		t.Errorf("NetworkPolicy marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.NetworkPolicyYaml)
+	}
+}
+
+func TestNetworkPolicyUnmarshal(t *testing.T) {
+	var cmd CommandNetworkPolicy
+	err := yaml.Unmarshal([]byte(testutil.NetworkPolicyYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.Policy.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", cmd.Policy.ConcentratorUUID)
+	}
+
+	if cmd.Policy.AllowAll {
+		t.Error("Wrong allow all field")
+	}
+
+	if len(cmd.Policy.Rules) != 1 || cmd.Policy.Rules[0].Destination != "172.16.1.0/24" {
+		t.Errorf("Wrong rules field %v", cmd.Policy.Rules)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// NodeCommandCmd identifies a command targeting a compute node agent
+// rather than one of its instances.
+type NodeCommandCmd struct {
+	// CommandID is the identifier the Controller uses to track the
+	// command. It is echoed back in the NodeCommandResult event.
+	CommandID string `yaml:"command_id"`
+
+	// WorkloadAgentUUID identifies the node the command is sent to.
+	// This information is needed by the scheduler to route the command
+	// to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+}
+
+// RestartAgent represents the unmarshalled version of the contents of a SSNTP
+// RestartAgent payload.
+type RestartAgent struct {
+	RestartAgent NodeCommandCmd `yaml:"restart_agent"`
+}
+
+// SelfTest represents the unmarshalled version of the contents of a SSNTP
+// SelfTest payload.
+type SelfTest struct {
+	SelfTest NodeCommandCmd `yaml:"self_test"`
+}
+
+// Reconfigure represents the unmarshalled version of the contents of a SSNTP
+// Reconfigure payload.
+type Reconfigure struct {
+	Reconfigure NodeCommandCmd `yaml:"reconfigure"`
+}
+
+// SelfTestCheck contains the outcome of one of the checks run by an agent
+// in response to a SelfTest command.
+type SelfTestCheck struct {
+	// Name identifies the check, e.g., scheduler.
+	Name string `yaml:"name"`
+
+	// Success is true if the check passed.
+	Success bool `yaml:"success"`
+
+	// Message describes why the check failed, if it did.
+	Message string `yaml:"message,omitempty"`
+}
+
+// NodeCommandResultEvent contains the outcome of a node command.  It also
+// acknowledges the successful completion of any other command carrying a
+// command identifier, e.g., START or AttachVolume.
+type NodeCommandResultEvent struct {
+	// CommandID is the identifier of the command being reported on.
+	CommandID string `yaml:"command_id"`
+
+	// NodeUUID is the UUID of the node that ran the command.
+	NodeUUID string `yaml:"node_uuid"`
+
+	// Error is set if the command failed.
+	Error string `yaml:"error,omitempty"`
+
+	// Checks contains the result of each self-test check.
+	Checks []SelfTestCheck `yaml:"checks,omitempty"`
+}
+
+// EventNodeCommandResult represents the unmarshalled version of the contents
+// of an SSNTP ssntp.NodeCommandResult event. This event is sent by
+// ciao-launcher when it has processed a RestartAgent or a SelfTest command,
+// or successfully completed another command carrying a command identifier.
+type EventNodeCommandResult struct {
+	NodeCommandResult NodeCommandResultEvent `yaml:"node_command_result"`
+}
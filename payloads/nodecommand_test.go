@@ -0,0 +1,95 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSelfTestMarshal(t *testing.T) {
+	var cmd SelfTest
+	cmd.SelfTest.CommandID = testutil.NodeCommandID
+	cmd.SelfTest.WorkloadAgentUUID = testutil.AgentUUID
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.SelfTestYaml {
+		t.Errorf("SelfTest marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.SelfTestYaml)
+	}
+}
+
+func TestRestartAgentUnmarshal(t *testing.T) {
+	var cmd RestartAgent
+	err := yaml.Unmarshal([]byte(testutil.RestartAgentYaml), &cmd)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cmd.RestartAgent.CommandID != testutil.NodeCommandID {
+		t.Errorf("Wrong Command ID field [%s]", cmd.RestartAgent.CommandID)
+	}
+
+	if cmd.RestartAgent.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong Agent UUID field [%s]", cmd.RestartAgent.WorkloadAgentUUID)
+	}
+}
+
+func TestNodeCommandResultMarshal(t *testing.T) {
+	var evt EventNodeCommandResult
+	evt.NodeCommandResult = NodeCommandResultEvent{
+		CommandID: testutil.NodeCommandID,
+		NodeUUID:  testutil.AgentUUID,
+		Error:     "1 of 2 checks failed",
+		Checks: []SelfTestCheck{
+			{Name: "scheduler", Success: true},
+			{Name: "instances", Message: "not writable"},
+		},
+	}
+
+	y, err := yaml.Marshal(&evt)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.NodeCommandResultYaml {
+		t.Errorf("NodeCommandResult marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.NodeCommandResultYaml)
+	}
+}
+
+func TestNodeCommandResultUnmarshal(t *testing.T) {
+	var evt EventNodeCommandResult
+	err := yaml.Unmarshal([]byte(testutil.NodeCommandResultYaml), &evt)
+	if err != nil {
+		t.Error(err)
+	}
+
+	res := evt.NodeCommandResult
+	if res.CommandID != testutil.NodeCommandID || res.NodeUUID != testutil.AgentUUID {
+		t.Errorf("Wrong identifiers [%s] [%s]", res.CommandID, res.NodeUUID)
+	}
+
+	if len(res.Checks) != 2 || !res.Checks[0].Success || res.Checks[1].Success {
+		t.Errorf("Wrong checks %+v", res.Checks)
+	}
+}
@@ -52,6 +52,21 @@ type Ready struct {
 	// Hostname of the CN/NN
 	NodeHostName string `yaml:"hostname"`
 
+	// CPUFlags lists the feature flags of the CPUs of the CN/NN, as named
+	// in /proc/cpuinfo.
+	CPUFlags []string `yaml:"cpu_flags,omitempty"`
+
+	// CPUModels lists the named QEMU CPU models which can be run on the
+	// CN/NN.  The host model is supported by all nodes and is not listed.
+	CPUModels []string `yaml:"cpu_models,omitempty"`
+
+	// StoragePools lists the ceph pools of the cluster configuration
+	// which the CN/NN can reach.
+	StoragePools []string `yaml:"storage_pools,omitempty"`
+
+	// CachedImages lists the container images cached on the CN/NN.
+	CachedImages []string `yaml:"cached_images,omitempty"`
+
 	// Any changes to this struct should be accompanied by a change to
 	// the ciao-scheduler/scheduler.go:updateNodeStat() function
 }
@@ -99,6 +99,51 @@ const (
 	Docker = "docker"
 )
 
+// DiskModel is the device model used to present volumes to a VM.
+type DiskModel string
+
+// NICModel is the device model of the network interface of a VM.
+type NICModel string
+
+// MachineType is the type of machine emulated for a VM.
+type MachineType string
+
+const (
+	// VirtioBlk presents each volume as a virtio-blk PCI device.
+	VirtioBlk DiskModel = "virtio-blk"
+
+	// VirtioSCSI presents the volumes as disks of a virtio-scsi
+	// controller.
+	VirtioSCSI DiskModel = "virtio-scsi"
+)
+
+const (
+	// VirtioNet emulates a virtio-net network interface.
+	VirtioNet NICModel = "virtio-net"
+
+	// E1000 emulates an Intel e1000 network interface, supported by
+	// guests without virtio drivers.
+	E1000 NICModel = "e1000"
+)
+
+const (
+	// MachinePC emulates an i440FX based PC.
+	MachinePC MachineType = "pc"
+
+	// MachineQ35 emulates a Q35 based PC with a PCIe bus.  Volumes can
+	// only be hot plugged to such machines with the virtio-scsi model.
+	MachineQ35 MachineType = "q35"
+)
+
+// DeviceModels contains the device models of a VM.  Empty fields select the
+// default models, i.e., virtio-blk, virtio-net and the default machine type
+// of the hypervisor.
+type DeviceModels struct {
+	Disk    DiskModel   `yaml:"disk,omitempty"`
+	NIC     NICModel    `yaml:"nic,omitempty"`
+	Machine MachineType `yaml:"machine,omitempty"`
+}
+
 // StorageResource represents a requested storage resource for a workload.
 type StorageResource struct {
 	// ID is passed to the Block Driver to operate on the resource
@@ -128,6 +173,10 @@ type StorageResource struct {
 
 	// Size is the requested size for an auto-created storage resource
 	Size int `yaml:"size,omitempty"`
+
+	// Pool is the ceph pool holding the storage resource, the default
+	// rbd pool when empty.
+	Pool string `yaml:"pool,omitempty"`
 }
 
 // RequestedResource is used to specify an individual resource contained within
@@ -193,6 +242,16 @@ type WorkloadRequirements struct {
 	// VCPUs specifies the required number of CPUs for the workload
 	VCPUs int `yaml:"vcpus"`
 
+	// MaxVCPUs is the number of vCPUs up to which an instance of the
+	// workload can be hot added vCPUs while running.  Instances cannot be
+	// hot added vCPUs if it is not greater than VCPUs.
+	MaxVCPUs int `yaml:"max_vcpus,omitempty"`
+
+	// MaxMemMB is the amount of memory, in MiB, up to which an instance of
+	// the workload can be hot added memory while running.  Instances
+	// cannot be hot added memory if it is not greater than MemMB.
+	MaxMemMB int `yaml:"max_mem_mb,omitempty"`
+
 	// NodeID specifies the node that the instance must be scheduled on
 	NodeID string `yaml:"node_id,omitempty"`
 
@@ -205,8 +264,71 @@ type WorkloadRequirements struct {
 	// Privileged indicates that this container workload should be run with increased
 	// permissions
 	Privileged bool `yaml:"privileged,omitempty"`
+
+	// CPUModel is the QEMU CPU model presented to the instance, e.g.,
+	// Skylake-Server.  The instance is only scheduled on nodes able to run
+	// this model.  If empty, or set to CPUModelHost, the CPU of the node is
+	// passed through to the instance.
+	CPUModel string `yaml:"cpu_model,omitempty"`
+
+	// CPUFeatures is a list of CPU features to enable, e.g., +avx512f,
+	// or disable, e.g., -hle, on top of those of CPUModel.  Instances
+	// enabling a feature are only scheduled on nodes whose CPUs support it.
+	CPUFeatures []string `yaml:"cpu_features,omitempty"`
+
+	// StoragePools lists the ceph pools holding the volumes of the
+	// instance.  The instance is only scheduled on nodes which can
+	// reach all these pools.  It is computed by the controller from the
+	// volumes of the instance and is not part of workload definitions.
+	StoragePools []string `yaml:"storage_pools,omitempty" json:"-"`
+}
+
+// HealthCheckProtocol is the protocol used to probe the health of an
+// instance.
+type HealthCheckProtocol string
+
+const (
+	// HealthCheckTCP probes an instance by opening a TCP connection to
+	// a port of the instance.
+	HealthCheckTCP HealthCheckProtocol = "tcp"
+
+	// HealthCheckHTTP probes an instance by sending an HTTP GET request
+	// to a port of the instance.  Any status below 400 is healthy.
+	HealthCheckHTTP HealthCheckProtocol = "http"
+)
+
+// HealthCheck describes the probe periodically run by the agent hosting
+// an instance to check that the instance is healthy.
+type HealthCheck struct {
+	// Protocol used by the probe, tcp or http.
+	Protocol HealthCheckProtocol `yaml:"protocol"`
+
+	// Port of the instance which is probed.
+	Port int `yaml:"port"`
+
+	// Path requested by http probes, / if empty.
+	Path string `yaml:"path,omitempty"`
+
+	// Interval between two probes, in seconds.
+	Interval int `yaml:"interval"`
+
+	// Timeout of a probe, in seconds.
+	Timeout int `yaml:"timeout"`
+
+	// UnhealthyThreshold is the number of consecutive failed probes
+	// after which the instance is unhealthy.
+	UnhealthyThreshold int `yaml:"unhealthy_threshold"`
+
+	// RestartUnhealthy requests the controller to restart the instance
+	// when it becomes unhealthy.  It is not used by the agents.
+	RestartUnhealthy bool `yaml:"restart_unhealthy,omitempty"`
 }
 
+// CPUModelHost is the CPU model which passes the CPU of a node through to
+// its instances.  It gives the best performance but instances using it
+// can only be migrated between nodes with identical CPUs.
+const CPUModelHost = "host"
+
 // StartCmd contains the information needed to start a new instance.
 type StartCmd struct {
 	// TenantUUID is the UUID of the tenant to which the new instance will
@@ -246,6 +368,38 @@ type StartCmd struct {
 	// Restart is set to true if the payload represents a request to
 	// restart an existing instance on a new node.
 	Restart bool
+
+	// NetworkConfig is a cloud-init network configuration, version 2,
+	// assigning the instance its address statically.  Only used for
+	// qemu instances.
+	NetworkConfig string `yaml:"network_config,omitempty"`
+
+	// VendorData is the cloud-init vendor data supplied by the
+	// operator of the cluster.  Only used for qemu instances.
+	VendorData string `yaml:"vendor_data,omitempty"`
+
+	// StaticNetwork is set to true if the network configuration of
+	// the instance, derived from Networking, is to be injected in its
+	// config drive.  Only used for qemu instances.
+	StaticNetwork bool `yaml:"static_network,omitempty"`
+
+	// Devices selects the device models of the instance.  Only used
+	// for qemu instances.
+	Devices DeviceModels `yaml:"devices,omitempty"`
+
+	// ExcludeNodes lists the UUIDs of the nodes the instance must not
+	// be scheduled on, e.g., because they failed to launch it before.
+	ExcludeNodes []string `yaml:"exclude_nodes,omitempty"`
+
+	// HealthCheck is the probe run by the agent to check the health of
+	// the instance.  Nil if the health of the instance is not checked.
+	HealthCheck *HealthCheck `yaml:"health_check,omitempty"`
+
+	// CommandID is set by the sender of the command and echoed back in
+	// the StartFailure error or the NodeCommandResult event sent in
+	// response, so that the sender can await the completion of the
+	// command.  It may be empty.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 // Start represents the unmarshalled version of the contents of a SSNTP START
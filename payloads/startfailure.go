@@ -48,6 +48,21 @@ const (
 	// running in the cluster upon which the instance can be started.
 	NoNetworkNodes = "no_net_cn"
 
+	// NoNodeMemory is returned by the scheduler when none of the nodes
+	// satisfying the placement constraints of the instance has enough
+	// free memory to host it.
+	NoNodeMemory = "no_node_memory"
+
+	// NoNodeDisk is returned by the scheduler when none of the nodes
+	// satisfying the placement constraints of the instance has enough
+	// free disk space to host it.
+	NoNodeDisk = "no_node_disk"
+
+	// UnsatisfiableConstraints is returned by the scheduler when no
+	// node matches the placement constraints of the instance, e.g., its
+	// requested node, hostname, CPU model or storage pools.
+	UnsatisfiableConstraints = "unsatisfiable_constraints"
+
 	// InvalidPayload indicates that the contents of the START payload are
 	// corrupt
 	InvalidPayload = "invalid_payload"
@@ -82,6 +97,14 @@ const (
 	// NetworkFailure indicates that it was not possible to initialise
 	// networking for the instance.
 	NetworkFailure = "network_failure"
+
+	// ImageCorrupt indicates that ciao-launcher found the data of the
+	// image backing the instance's boot volume to be corrupted.
+	ImageCorrupt = "image_corrupt"
+
+	// BootTimeout indicates that ciao-controller gave up waiting for
+	// the instance to be reported running.
+	BootTimeout = "boot_timeout"
 )
 
 // ErrorStartFailure represents the unmarshalled version of the contents of a
@@ -100,6 +123,10 @@ type ErrorStartFailure struct {
 	// Restart is true if the failed start command was attempting to
 	// restart an existing instance.
 	Restart bool
+
+	// CommandID is the identifier of the START command that failed,
+	// if it had one.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 func (r StartFailureReason) String() string {
@@ -114,6 +141,12 @@ func (r StartFailureReason) String() string {
 		return "No compute node available"
 	case NoNetworkNodes:
 		return "No network node available"
+	case NoNodeMemory:
+		return "No node with enough memory"
+	case NoNodeDisk:
+		return "No node with enough disk space"
+	case UnsatisfiableConstraints:
+		return "No node satisfies the placement constraints"
 	case InvalidPayload:
 		return "YAML payload is corrupt"
 	case InvalidData:
@@ -128,6 +161,10 @@ func (r StartFailureReason) String() string {
 		return "Failed to launch instance"
 	case NetworkFailure:
 		return "Failed to create VNIC for instance"
+	case ImageCorrupt:
+		return "Instance image is corrupted"
+	case BootTimeout:
+		return "Instance did not boot in time"
 	}
 
 	return ""
@@ -142,11 +179,16 @@ func (r StartFailureReason) IsFatal() bool {
 		NodeInMaintenance,
 		NoComputeNodes,
 		NoNetworkNodes,
+		NoNodeMemory,
+		NoNodeDisk,
+		UnsatisfiableConstraints,
 		InvalidPayload,
 		InvalidData,
 		ImageFailure,
 		LaunchFailure,
-		NetworkFailure:
+		NetworkFailure,
+		ImageCorrupt,
+		BootTimeout:
 		return true
 
 	case AlreadyRunning,
@@ -157,3 +199,18 @@ func (r StartFailureReason) IsFatal() bool {
 	glog.Errorf("Unexpected StartFailureReason: %s", r)
 	return false
 }
+
+// IsNodeSpecific indicates that the failure is caused by the node chosen
+// to host the instance, so that launching the instance on another node
+// may succeed.
+func (r StartFailureReason) IsNodeSpecific() bool {
+	switch r {
+	case FullComputeNode,
+		NodeInMaintenance,
+		LaunchFailure,
+		NetworkFailure:
+		return true
+	}
+
+	return false
+}
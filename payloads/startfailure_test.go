@@ -71,6 +71,9 @@ func TestStartFailureString(t *testing.T) {
 		{NodeInMaintenance, "Node is undergoing maintenance"},
 		{NoComputeNodes, "No compute node available"},
 		{NoNetworkNodes, "No network node available"},
+		{NoNodeMemory, "No node with enough memory"},
+		{NoNodeDisk, "No node with enough disk space"},
+		{UnsatisfiableConstraints, "No node satisfies the placement constraints"},
 		{InvalidPayload, "YAML payload is corrupt"},
 		{InvalidData, "Command section of YAML payload is corrupt or missing required information"},
 		{AlreadyRunning, "Instance is already running"},
@@ -78,6 +81,8 @@ func TestStartFailureString(t *testing.T) {
 		{ImageFailure, "Failed to create instance image"},
 		{LaunchFailure, "Failed to launch instance"},
 		{NetworkFailure, "Failed to create VNIC for instance"},
+		{ImageCorrupt, "Instance image is corrupted"},
+		{BootTimeout, "Instance did not boot in time"},
 	}
 	error := ErrorStartFailure{
 		InstanceUUID: testutil.InstanceUUID,
@@ -90,3 +95,17 @@ func TestStartFailureString(t *testing.T) {
 		}
 	}
 }
+
+func TestStartFailureIsNodeSpecific(t *testing.T) {
+	for _, r := range []StartFailureReason{FullComputeNode, NodeInMaintenance, LaunchFailure, NetworkFailure} {
+		if !r.IsNodeSpecific() {
+			t.Errorf("%s expected to be node specific", r)
+		}
+	}
+
+	for _, r := range []StartFailureReason{FullCloud, NoComputeNodes, NoNodeMemory, UnsatisfiableConstraints, InvalidPayload, ImageCorrupt} {
+		if r.IsNodeSpecific() {
+			t.Errorf("%s not expected to be node specific", r)
+		}
+	}
+}
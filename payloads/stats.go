@@ -52,6 +52,82 @@ type InstanceStat struct {
 
 	// List of volumes attached to the instance.
 	Volumes []string `yaml:"volumes"`
+
+	// Traffic counters of the network interfaces of the instance.
+	// Empty if the instance has no network interface or if the
+	// counters could not be read.
+	Networks []InstanceNetworkStat `yaml:"networks,omitempty"`
+
+	// Disk I/O counters of the instance.  Nil if the counters could not
+	// be read.
+	DiskIO *InstanceDiskIOStat `yaml:"disk_io,omitempty"`
+
+	// Time at which the agent sampled these statistics, in RFC3339
+	// format with nanoseconds.  Empty if the instance was sampled along
+	// with its node.
+	Timestamp string `yaml:"timestamp,omitempty"`
+
+	// Result of the health checks of the instance.  Nil if the health
+	// of the instance is not checked.
+	Health *InstanceHealthStat `yaml:"health,omitempty"`
+}
+
+// HealthStatus is the health of an instance, as determined by its health
+// checks.
+type HealthStatus string
+
+const (
+	// HealthUnknown is the health of an instance which has neither
+	// passed a health check nor failed enough of them to be unhealthy.
+	HealthUnknown HealthStatus = "unknown"
+
+	// Healthy is the health of an instance whose last health check
+	// passed, or which has not failed enough checks to be unhealthy.
+	Healthy HealthStatus = "healthy"
+
+	// Unhealthy is the health of an instance which failed the number
+	// of consecutive health checks set by the threshold of its check.
+	Unhealthy HealthStatus = "unhealthy"
+)
+
+// InstanceHealthStat contains the result of the health checks of an
+// instance.
+type InstanceHealthStat struct {
+	Status HealthStatus `yaml:"status"`
+
+	// Number of consecutive failed health checks.
+	Failures int `yaml:"failures"`
+
+	// Reason of the last failed health check.  Empty if the last check
+	// passed.
+	Message string `yaml:"message,omitempty"`
+
+	// Time of the last health check, in RFC3339 format.
+	Checked string `yaml:"checked"`
+}
+
+// InstanceNetworkStat contains the traffic counters of a single network
+// interface of an instance. The counters are cumulative since the creation
+// of the interface and are seen from the instance, i.e., RxBytes counts the
+// bytes received by the instance.
+type InstanceNetworkStat struct {
+	// Name of the interface on the node, e.g., the instance VNIC.
+	Interface string `yaml:"interface"`
+
+	RxBytes   uint64 `yaml:"rx_bytes"`
+	TxBytes   uint64 `yaml:"tx_bytes"`
+	RxPackets uint64 `yaml:"rx_packets"`
+	TxPackets uint64 `yaml:"tx_packets"`
+}
+
+// InstanceDiskIOStat contains the disk I/O counters of an instance,
+// covering its root filesystem and its attached volumes.  The counters are
+// cumulative since the instance was started.
+type InstanceDiskIOStat struct {
+	ReadBytes  uint64 `yaml:"read_bytes"`
+	WriteBytes uint64 `yaml:"write_bytes"`
+	ReadOps    uint64 `yaml:"read_ops"`
+	WriteOps   uint64 `yaml:"write_ops"`
 }
 
 // NetworkStat contains information about a single network interface present on
@@ -102,6 +178,53 @@ type Stat struct {
 	// Array containing statistics information for each instance hosted by
 	// the CN/NN
 	Instances []InstanceStat
+
+	// Hardware health of the CN/NN.  Nil if the agent does not report
+	// the health of its node.
+	Health *NodeHealthStat `yaml:"health,omitempty"`
+
+	// Time at which the agent sampled these statistics, in RFC3339
+	// format with nanoseconds.  Empty if the agent does not timestamp
+	// its statistics.
+	Timestamp string `yaml:"timestamp,omitempty"`
+
+	// Container images cached on the CN/NN, which instances can use
+	// without downloading them.
+	CachedImages []string `yaml:"cached_images,omitempty"`
+}
+
+// NodeHealthStat contains the hardware health indicators of a ciao compute
+// or network node.
+type NodeHealthStat struct {
+	// Readings of the temperature sensors of the node, including those
+	// of its GPUs.
+	Temperatures []TemperatureStat `yaml:"temperatures,omitempty"`
+
+	// Number of memory errors corrected by ECC since the node booted.
+	// -1 if the node does not report memory errors.
+	CorrectedECCErrors int `yaml:"corrected_ecc_errors"`
+
+	// Number of memory errors ECC failed to correct since the node
+	// booted.  -1 if the node does not report memory errors.
+	UncorrectedECCErrors int `yaml:"uncorrected_ecc_errors"`
+
+	// SMART summaries of the disks of the node.
+	Disks []DiskHealthStat `yaml:"disks,omitempty"`
+}
+
+// TemperatureStat contains the reading of a temperature sensor.
+type TemperatureStat struct {
+	Sensor  string `yaml:"sensor"`
+	Celsius int    `yaml:"celsius"`
+}
+
+// DiskHealthStat contains the SMART overall health assessment of a disk.
+type DiskHealthStat struct {
+	// Device name of the disk, e.g., sda.
+	Device string `yaml:"device"`
+
+	// False if the disk failed its SMART self-assessment.
+	Healthy bool `yaml:"healthy"`
 }
 
 const (
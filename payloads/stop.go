@@ -30,6 +30,12 @@ type StopCmd struct {
 	// In this case the delete command should only delete the instance from
 	// the node to which it is sent and not the entire cluster.
 	Stop bool
+
+	// CommandID is set by the sender of the command and echoed back in
+	// the InstanceDeleted, InstanceStopped or DeleteFailure sent in
+	// response, so that the sender can await the completion of the
+	// command.  It may be empty.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 // Stop represents the unmarshalled version of the contents of a SSNTP STOP
@@ -30,6 +30,16 @@ type VolumeCmd struct {
 	// running.  This information is needed by the scheduler to route
 	// the command to the correct CN/NN.
 	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// Pool is the ceph pool holding the volume, the default rbd pool
+	// when empty.
+	Pool string `yaml:"pool,omitempty"`
+
+	// CommandID is set by the sender of the command and echoed back in
+	// the AttachVolumeFailure error or the NodeCommandResult event sent
+	// in response, so that the sender can await the completion of the
+	// command.  It may be empty.
+	CommandID string `yaml:"command_id,omitempty"`
 }
 
 // AttachVolume represents the unmarshalled version of the contents of a SSNTP
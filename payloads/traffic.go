@@ -0,0 +1,55 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// TrafficCounter contains the cumulative traffic routed by a CNCI between
+// an instance and the external network.
+type TrafficCounter struct {
+	// PrivateIP is the tenant network IP address of the instance.
+	PrivateIP string `yaml:"private_ip"`
+
+	// RxBytes and RxPackets count the traffic received by the instance
+	// from the external network.
+	RxBytes   uint64 `yaml:"rx_bytes"`
+	RxPackets uint64 `yaml:"rx_packets"`
+
+	// TxBytes and TxPackets count the traffic sent by the instance to the
+	// external network.
+	TxBytes   uint64 `yaml:"tx_bytes"`
+	TxPackets uint64 `yaml:"tx_packets"`
+}
+
+// TrafficReportEvent contains the external traffic counters of the
+// instances of a tenant.
+type TrafficReportEvent struct {
+	// ConcentratorUUID is the UUID of the CNCI reporting the traffic.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// TenantUUID is the UUID of the tenant served by the CNCI.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// Counters contains a counter per instance private IP. Counters are
+	// cumulative and restart from 0 when the CNCI restarts.
+	Counters []TrafficCounter `yaml:"counters"`
+}
+
+// EventTrafficReport represents the unmarshalled version of the contents of
+// an SSNTP ssntp.TrafficReport event. This event is sent periodically by
+// the CNCI agent.
+type EventTrafficReport struct {
+	TrafficReport TrafficReportEvent `yaml:"traffic_report"`
+}
@@ -0,0 +1,79 @@
+/* // Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/ciao-project/ciao/payloads"
+	"github.com/ciao-project/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTrafficReportUnmarshal(t *testing.T) {
+	var report EventTrafficReport
+
+	err := yaml.Unmarshal([]byte(testutil.TrafficReportYaml), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.TrafficReport.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", report.TrafficReport.ConcentratorUUID)
+	}
+
+	if report.TrafficReport.TenantUUID != testutil.TenantUUID {
+		t.Errorf("Wrong tenant UUID field [%s]", report.TrafficReport.TenantUUID)
+	}
+
+	if len(report.TrafficReport.Counters) != 1 {
+		t.Fatalf("Wrong number of counters [%d]", len(report.TrafficReport.Counters))
+	}
+
+	c := report.TrafficReport.Counters[0]
+	if c.PrivateIP != testutil.InstancePrivateIP {
+		t.Errorf("Wrong private IP field [%s]", c.PrivateIP)
+	}
+
+	if c.RxBytes != 2048 || c.RxPackets != 4 || c.TxBytes != 1024 || c.TxPackets != 2 {
+		t.Errorf("Wrong counters %+v", c)
+	}
+}
+
+func TestTrafficReportMarshal(t *testing.T) {
+	var report EventTrafficReport
+
+	report.TrafficReport.ConcentratorUUID = testutil.CNCIUUID
+	report.TrafficReport.TenantUUID = testutil.TenantUUID
+	report.TrafficReport.Counters = []TrafficCounter{
+		{
+			PrivateIP: testutil.InstancePrivateIP,
+			RxBytes:   2048,
+			RxPackets: 4,
+			TxBytes:   1024,
+			TxPackets: 2,
+		},
+	}
+
+	y, err := yaml.Marshal(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.TrafficReportYaml {
+		t.Errorf("TrafficReport marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.TrafficReportYaml)
+	}
+}
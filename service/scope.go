@@ -0,0 +1,100 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScopeUnitPrefix prefixes the organizational units of a client
+// certificate which restrict it to a set of API operations, e.g.
+// "scope:instances:read". Certificates without any such unit are not
+// restricted.
+const ScopeUnitPrefix = "scope:"
+
+// AllResources is the scope resource matching every API resource.
+const AllResources = "*"
+
+// Access levels granted by a scope.
+const (
+	// ReadAccess allows GET and HEAD requests.
+	ReadAccess = "read"
+
+	// WriteAccess allows all requests, including those allowed by
+	// ReadAccess.
+	WriteAccess = "write"
+)
+
+// Scope grants access to one resource of the API, such as instances or
+// volumes.
+type Scope struct {
+	Resource string
+	Access   string
+}
+
+func (s Scope) String() string {
+	return s.Resource + ":" + s.Access
+}
+
+// ParseScope parses a scope of the form resource:access.
+func ParseScope(scope string) (Scope, error) {
+	parts := strings.Split(scope, ":")
+	if len(parts) != 2 || parts[0] == "" {
+		return Scope{}, fmt.Errorf("Invalid scope %q: expected resource:access", scope)
+	}
+
+	if parts[1] != ReadAccess && parts[1] != WriteAccess {
+		return Scope{}, fmt.Errorf("Invalid access %q in scope %q", parts[1], scope)
+	}
+
+	return Scope{Resource: parts[0], Access: parts[1]}, nil
+}
+
+// ScopesFromUnits returns the scopes carried by the organizational units
+// of a certificate. It returns nil if the certificate is not restricted.
+func ScopesFromUnits(units []string) ([]Scope, error) {
+	var scopes []Scope
+
+	for _, u := range units {
+		if !strings.HasPrefix(u, ScopeUnitPrefix) {
+			continue
+		}
+
+		s, err := ParseScope(strings.TrimPrefix(u, ScopeUnitPrefix))
+		if err != nil {
+			return nil, err
+		}
+
+		scopes = append(scopes, s)
+	}
+
+	return scopes, nil
+}
+
+// Permits returns true if one of the scopes grants access to resource.
+func Permits(scopes []Scope, resource string, access string) bool {
+	for _, s := range scopes {
+		if s.Resource != resource && s.Resource != AllResources {
+			continue
+		}
+
+		if s.Access == WriteAccess || s.Access == access {
+			return true
+		}
+	}
+
+	return false
+}
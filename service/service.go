@@ -29,6 +29,10 @@ const PrivKey key = 0
 // tenant id which is being used in the API call
 const TenantIDKey key = 1
 
+// ImpersonatorKey is the index of the context map which holds the
+// identity of the admin impersonating the tenant of the API call
+const ImpersonatorKey key = 2
+
 // GetPrivilege returns the value of PrivKey
 func GetPrivilege(ctx context.Context) bool {
 	privilege, ok := ctx.Value(PrivKey).(bool)
@@ -53,3 +57,15 @@ func GetTenantID(ctx context.Context) (string, error) {
 func SetTenantID(ctx context.Context, tenantID string) context.Context {
 	return context.WithValue(ctx, TenantIDKey, tenantID)
 }
+
+// GetImpersonator returns the value of ImpersonatorKey, or an empty
+// string if the API call is not impersonated
+func GetImpersonator(ctx context.Context) string {
+	admin, _ := ctx.Value(ImpersonatorKey).(string)
+	return admin
+}
+
+// SetImpersonator sets the identity of the admin impersonating the tenant
+func SetImpersonator(ctx context.Context, admin string) context.Context {
+	return context.WithValue(ctx, ImpersonatorKey, admin)
+}
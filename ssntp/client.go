@@ -73,6 +73,7 @@ type Client struct {
 	transport string
 	port      uint32
 	session   *session
+	sequence  *frameSequence
 	status    connectionStatus
 	closed    chan struct{}
 
@@ -84,6 +85,8 @@ type Client struct {
 	trace *TraceConfig
 
 	configuration clusterConfiguration
+
+	features []Feature
 }
 
 func (client *Client) processSSNTPFrame(frame *Frame) {
@@ -154,7 +157,7 @@ func (client *Client) sendConnect() (bool, error) {
 	var connected ConnectedFrame
 	client.log.Infof("Sending CONNECT\n")
 
-	connect := client.session.connectFrame()
+	connect := client.session.connectFrame(client.features)
 	_, err := client.session.Write(connect)
 	if err != nil {
 		return true, err
@@ -185,6 +188,7 @@ func (client *Client) sendConnect() (bool, error) {
 	}
 
 	client.session.setDest(connected.Source[:16])
+	client.session.features = negotiateFeatures(client.features, connected.Features)
 
 	oidFound, err := verifyRole(client.session.conn, connected.Role)
 	if oidFound == false {
@@ -235,6 +239,7 @@ func (client *Client) attemptDial() error {
 				if err == nil {
 					client.log.Infof("Connected\n")
 					session := newSession(&client.uuid, client.role, 0, conn)
+					session.sequence = client.sequence
 					client.session = session
 
 					break URILoop
@@ -314,6 +319,10 @@ func (client *Client) Dial(config *Config, ntf ClientNotifier) error {
 
 	client.status.Unlock()
 
+	if client.sequence == nil {
+		client.sequence = newFrameSequence()
+	}
+
 	client.log = config.log()
 	config.setCerts()
 	role, err := config.role()
@@ -329,6 +338,7 @@ func (client *Client) Dial(config *Config, ntf ClientNotifier) error {
 	client.uris = config.ConfigURIs(client.uris, client.port)
 
 	client.trace = config.Trace
+	client.features = config.enabledFeatures()
 	client.ntf = ntf
 	client.tls = prepareTLSConfig(config, false)
 
@@ -487,6 +497,11 @@ func (client *Client) Role() Role {
 	return client.role
 }
 
+// Features returns the optional SSNTP features negotiated with the server.
+func (client *Client) Features() []Feature {
+	return client.session.features
+}
+
 // UUID exports the SSNTP client Universally Unique ID.
 func (client *Client) UUID() string {
 	return client.uuid.String()
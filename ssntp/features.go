@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Feature is an optional SSNTP protocol feature. SSNTP clients and
+// servers advertise the features they support in their CONNECT and
+// CONNECTED frames and a session only uses the features supported by
+// both of its ends, so that peers running different SSNTP versions can
+// talk to each other during rolling upgrades. Peers predating feature
+// negotiation advertise no feature.
+type Feature string
+
+const (
+	// PayloadCompression compresses the frame payloads larger than
+	// compressionThreshold bytes with gzip.
+	PayloadCompression Feature = "payload-compression"
+)
+
+// supportedFeatures lists the features implemented by this SSNTP version.
+var supportedFeatures = []Feature{
+	PayloadCompression,
+}
+
+// compressionThreshold is the size above which payloads are compressed
+// when PayloadCompression is negotiated.
+const compressionThreshold = 1024
+
+// maxPayloadSize is the largest size a compressed payload may expand to,
+// so that a small frame cannot make its receiver allocate without bound.
+const maxPayloadSize = 64 << 20
+
+// enabledFeatures returns the supported features not disabled by the
+// configuration.
+func (config *Config) enabledFeatures() []Feature {
+	var features []Feature
+	for _, f := range supportedFeatures {
+		if !hasFeature(config.DisabledFeatures, f) {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+func hasFeature(features []Feature, feature Feature) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateFeatures returns the local features also supported by the
+// peer.
+func negotiateFeatures(local []Feature, peer []Feature) []Feature {
+	var features []Feature
+	for _, f := range local {
+		if hasFeature(peer, f) {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+func compressPayload(payload []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func decompressPayload(payload []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(r, maxPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decompressed) > maxPayloadSize {
+		return nil, fmt.Errorf("Payload larger than %d bytes", maxPayloadSize)
+	}
+
+	return decompressed, nil
+}
@@ -72,10 +72,28 @@ type Frame struct {
 	// then only sees a new frame coming but it can not tell
 	// who the frame creator and first sender is. This method
 	// allows to fetch such information from a frame.
-	Origin        uuid.UUID
+	Origin uuid.UUID
+
+	// Epoch identifies the process which created the frame. It
+	// changes when the frame creator restarts, and its Sequence
+	// numbers then start over. A zero Epoch means the frame creator
+	// predates epochs.
+	Epoch uint64
+
+	// Sequence is a number set by the frame creator, increasing
+	// with every frame it sends. Together with Origin and Epoch it
+	// identifies a frame and allows receivers to detect retransmitted
+	// or replayed frames. A zero Sequence means the frame creator does
+	// not number its frames.
+	Sequence      uint64
 	PayloadLength uint32
 	Trace         *FrameTrace
 	Payload       []byte
+
+	// Compressed tells that the payload is gzip compressed, which is
+	// only done when the PayloadCompression feature is negotiated.
+	// SSNTP sessions decompress payloads when reading frames.
+	Compressed bool
 }
 
 // ConnectFrame is the SSNTP connection frame structure.
@@ -87,6 +105,9 @@ type ConnectFrame struct {
 	Role        Role
 	Source      []byte
 	Destination []byte
+
+	// Features lists the optional features supported by the client.
+	Features []Feature
 }
 
 // ConnectedFrame is the SSNTP connected frame structure.
@@ -100,6 +121,9 @@ type ConnectedFrame struct {
 	Destination   []byte
 	PayloadLength uint32
 	Payload       []byte
+
+	// Features lists the optional features supported by the server.
+	Features []Feature
 }
 
 const majorMask = 0x7f
@@ -166,12 +190,12 @@ func (f Frame) String() string {
 			path = path + fmt.Sprintf("\n\t\tNode #%d\n\t\tUUID %s\n", i, node) + ts
 		}
 
-		return fmt.Sprintf("\n\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tOrigin %s\n\tPayload len %d\n\tPath %s\n",
-			f.GetMajor(), f.Minor, t, op, f.Origin, f.PayloadLength, path)
+		return fmt.Sprintf("\n\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tOrigin %s\n\tSequence %d\n\tPayload len %d\n\tPath %s\n",
+			f.GetMajor(), f.Minor, t, op, f.Origin, f.Sequence, f.PayloadLength, path)
 	}
 
-	return fmt.Sprintf("\n\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tOrigin %s\n\tPayload len %d\n",
-		f.GetMajor(), f.Minor, t, op, f.Origin, f.PayloadLength)
+	return fmt.Sprintf("\n\tMajor %d\n\tMinor %d\n\tType %s\n\tOp %s\n\tOrigin %s\n\tSequence %d\n\tPayload len %d\n",
+		f.GetMajor(), f.Minor, t, op, f.Origin, f.Sequence, f.PayloadLength)
 }
 
 func (f ConnectFrame) String() string {
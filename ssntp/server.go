@@ -21,6 +21,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -67,6 +68,7 @@ type Server struct {
 	ntf           ServerNotifier
 	sessionMutex  sync.RWMutex
 	sessions      map[string]*session
+	sequence      *frameSequence
 	listenerMutex sync.Mutex
 	listener      net.Listener
 	stopped       boolFlag
@@ -81,6 +83,33 @@ type Server struct {
 	trace *TraceConfig
 
 	configuration clusterConfiguration
+
+	peerFrameRate  float64
+	peerFrameBurst int
+
+	features []Feature
+}
+
+// PeerStats are the statistics of the connection of an SSNTP client to
+// an SSNTP server.
+type PeerStats struct {
+	UUID      string
+	Role      Role
+	Connected time.Time
+
+	// Features are the optional features negotiated with the client.
+	Features []Feature
+
+	FramesReceived uint64
+	BytesReceived  uint64
+	FramesSent     uint64
+	BytesSent      uint64
+
+	// FramesThrottled is the number of frames from the client whose
+	// handling was delayed because the client exceeded the
+	// PeerFrameRate of the server, and ThrottledTime the total delay.
+	FramesThrottled uint64
+	ThrottledTime   time.Duration
 }
 
 func sendConnectionFailure(conn net.Conn) *session {
@@ -135,10 +164,16 @@ func handleClientConnect(server *Server, conn net.Conn) *session {
 
 	session := newSession(&server.uuid, server.role, connect.Role, conn)
 	session.setDest(connect.Source[:16])
+	session.sequence = server.sequence
+	session.features = negotiateFeatures(server.features, connect.Features)
+
+	if session.destRole.IsAgent() || session.destRole.IsNetAgent() {
+		session.limiter = newFrameLimiter(server.peerFrameRate, server.peerFrameBurst)
+	}
 
 	/* TODO Get the CONFIGURE payload from the config package */
 	server.configuration.RLock()
-	connected := session.connectedFrame(server.role, server.configuration.configuration)
+	connected := session.connectedFrame(server.role, server.configuration.configuration, server.features)
 	server.configuration.RUnlock()
 
 	server.log.Infof("Sending CONNECTED\n")
@@ -166,6 +201,7 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 	server.forwardRules.addForwardDestination(session)
 	server.ntf.ConnectNotify(uuidString, session.destRole)
 
+	throttled := false
 	for {
 		var frame Frame
 		err := session.Read(&frame)
@@ -177,6 +213,16 @@ func handleSSNTPClient(server *Server, conn net.Conn) {
 			break
 		}
 
+		if session.throttle() {
+			if !throttled {
+				server.log.Warningf("Throttling %s %s: more than %v frames per second\n",
+					session.destRole.String(), uuidString, server.peerFrameRate)
+			}
+			throttled = true
+		} else {
+			throttled = false
+		}
+
 		switch frame.Type {
 		case COMMAND:
 			if (Command)(frame.Operand) == CONFIGURE && session.destRole.IsController() {
@@ -259,11 +305,15 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 
 	server.ntf = ntf
 	server.sessions = make(map[string]*session)
+	server.sequence = newFrameSequence()
 	server.forwardRules.init(config.ForwardRules)
 	server.tls = prepareTLSConfig(config, true)
 	server.forwardRules.forwardRules = config.ForwardRules
 	server.trace = config.Trace
 	server.stoppedChan = make(chan struct{})
+	server.peerFrameRate = config.PeerFrameRate
+	server.peerFrameBurst = config.PeerFrameBurst
+	server.features = config.enabledFeatures()
 
 	service := fmt.Sprintf("%s:%d", uri, serverPort)
 	listener, err := tls.Listen(transport, service, server.tls)
@@ -304,6 +354,21 @@ func (server *Server) Serve(config *Config, ntf ServerNotifier) error {
 	return nil
 }
 
+// PeerStats returns the statistics of the connections of the clients
+// currently connected to the server, sorted by client UUID.
+func (server *Server) PeerStats() []PeerStats {
+	server.sessionMutex.RLock()
+	stats := make([]PeerStats, 0, len(server.sessions))
+	for _, session := range server.sessions {
+		stats = append(stats, session.stats())
+	}
+	server.sessionMutex.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].UUID < stats[j].UUID })
+
+	return stats
+}
+
 // ServeThreadSync is a helper that start Serve() in a
 // dedicated go routine and returns synchronously, i.e.
 // when Serve() is ready to accept SSNTP clients or failed.
@@ -448,11 +513,38 @@ func (server *Server) SendTracedError(uuid string, error Error, payload []byte,
 	return server.sendError(uuid, error, payload, trace)
 }
 
+// SendConfiguration sends the current cluster configuration to a client,
+// through a CONFIGURE command frame.
+// The client is specified by its uuid
+func (server *Server) SendConfiguration(uuid string) (int, error) {
+	server.configuration.RLock()
+	payload := server.configuration.configuration
+	server.configuration.RUnlock()
+
+	if payload == nil {
+		return -1, fmt.Errorf("No cluster configuration available")
+	}
+
+	return server.sendCommand(uuid, CONFIGURE, payload, server.trace)
+}
+
 // UUID exports the SSNTP server Universally Unique ID.
 func (server *Server) UUID() string {
 	return server.uuid.String()
 }
 
+// ClientFeatures returns the optional features negotiated with the ssntp
+// session peer with the specified uuid.
+func (server *Server) ClientFeatures(uuid string) ([]Feature, error) {
+	server.sessionMutex.RLock()
+	session := server.sessions[uuid]
+	defer server.sessionMutex.RUnlock()
+	if session == nil {
+		return nil, fmt.Errorf("SSNTP session missing for uuid %s", uuid)
+	}
+	return session.features, nil
+}
+
 // ClientRole returns the role of the ssntp session peer with the specified uuid.
 func (server *Server) ClientRole(uuid string) (Role, error) {
 	server.sessionMutex.RLock()
@@ -17,8 +17,13 @@
 package ssntp
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
+	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/ciao-project/ciao/uuid"
@@ -40,15 +45,153 @@ func clearWriteTimeout(conn net.Conn) {
 	conn.SetWriteDeadline(time.Time{})
 }
 
+// frameSequence generates the sequence numbers of the frames sent by
+// an SSNTP client or server, and the epoch they belong to. The epoch is
+// drawn at random once per process, so that receivers can tell a
+// restarted peer, whose numbers may start lower as the wall clock they
+// are seeded from stepped back, from a replay of its earlier frames. A
+// client keeps its epoch across reconnections.
+type frameSequence struct {
+	last  uint64
+	epoch uint64
+}
+
+func newFrameSequence() *frameSequence {
+	var b [8]byte
+	epoch := uint64(time.Now().UnixNano())
+	if _, err := rand.Read(b[:]); err == nil {
+		epoch = binary.BigEndian.Uint64(b[:])
+	}
+	if epoch == 0 {
+		epoch = 1
+	}
+
+	return &frameSequence{last: uint64(time.Now().UnixNano()), epoch: epoch}
+}
+
+func (s *frameSequence) next() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.AddUint64(&s.last, 1)
+}
+
+func (s *frameSequence) epochID() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return s.epoch
+}
+
+// peerCounters count the frames and bytes exchanged over a session.
+// They are updated atomically.
+type peerCounters struct {
+	framesReceived  uint64
+	bytesReceived   uint64
+	framesSent      uint64
+	bytesSent       uint64
+	framesThrottled uint64
+	throttledTime   int64
+}
+
+type countingReader struct {
+	r     io.Reader
+	count *uint64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(c.count, uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count *uint64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddUint64(c.count, uint64(n))
+	return n, err
+}
+
+// frameLimiter is a token bucket limiting the rate at which the frames
+// of a session are read.
+type frameLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newFrameLimiter returns a limiter allowing rate frames per second, with
+// bursts of up to burst frames, or nil if rate is not positive.  The
+// burst defaults to one second worth of frames.
+func newFrameLimiter(rate float64, burst int) *frameLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	l := &frameLimiter{rate: rate, burst: float64(burst)}
+	if l.burst <= 0 {
+		l.burst = rate
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+
+	return l
+}
+
+// reserve takes a frame from the bucket and returns how long to wait
+// before handling it.
+func (l *frameLimiter) reserve(now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	if l.last.IsZero() {
+		l.tokens = l.burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
 type session struct {
+	// counters come first so that they are 64 bit aligned, as
+	// required by the atomic operations on 32 bit platforms.
+	counters peerCounters
+
 	src      uuid.UUID
 	dest     uuid.UUID
 	srcRole  Role
 	destRole Role
 	conn     net.Conn
+	sequence *frameSequence
 
 	encoder *gob.Encoder
 	decoder *gob.Decoder
+
+	connected time.Time
+	limiter   *frameLimiter
+
+	// features are the optional features negotiated with the peer
+	// when connecting.
+	features []Feature
 }
 
 /*
@@ -65,8 +208,9 @@ func newSession(src *uuid.UUID, srcRole Role, destRole Role, netConn net.Conn) *
 	session.destRole = destRole
 
 	session.conn = netConn
-	session.encoder = gob.NewEncoder(netConn)
-	session.decoder = gob.NewDecoder(netConn)
+	session.encoder = gob.NewEncoder(countingWriter{w: netConn, count: &session.counters.bytesSent})
+	session.decoder = gob.NewDecoder(countingReader{r: netConn, count: &session.counters.bytesReceived})
+	session.connected = time.Now()
 
 	return &session
 }
@@ -75,7 +219,7 @@ func (session *session) setDest(uuid []byte) {
 	copy(session.dest[:], uuid[:16])
 }
 
-func (session *session) connectedFrame(serverRole Role, payload []byte) (f *ConnectedFrame) {
+func (session *session) connectedFrame(serverRole Role, payload []byte, features []Feature) (f *ConnectedFrame) {
 	f = &ConnectedFrame{
 		Major:         Major,
 		Minor:         minor,
@@ -86,12 +230,13 @@ func (session *session) connectedFrame(serverRole Role, payload []byte) (f *Conn
 		Destination:   session.dest[:],
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
+		Features:      features,
 	}
 
 	return
 }
 
-func (session *session) connectFrame() (f *ConnectFrame) {
+func (session *session) connectFrame(features []Feature) (f *ConnectFrame) {
 	f = &ConnectFrame{
 		Major:       Major,
 		Minor:       minor,
@@ -100,6 +245,7 @@ func (session *session) connectFrame() (f *ConnectFrame) {
 		Role:        session.srcRole,
 		Source:      session.src[:],
 		Destination: session.dest[:],
+		Features:    features,
 	}
 
 	return
@@ -112,6 +258,8 @@ func (session *session) commandFrame(cmd Command, payload []byte, trace *TraceCo
 		Type:          COMMAND,
 		Operand:       byte(cmd),
 		Origin:        session.src,
+		Epoch:         session.sequence.epochID(),
+		Sequence:      session.sequence.next(),
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
 	}
@@ -129,6 +277,8 @@ func (session *session) statusFrame(status Status, payload []byte, trace *TraceC
 		Type:          STATUS,
 		Operand:       byte(status),
 		Origin:        session.src,
+		Epoch:         session.sequence.epochID(),
+		Sequence:      session.sequence.next(),
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
 	}
@@ -146,6 +296,8 @@ func (session *session) eventFrame(event Event, payload []byte, trace *TraceConf
 		Type:          EVENT,
 		Operand:       byte(event),
 		Origin:        session.src,
+		Epoch:         session.sequence.epochID(),
+		Sequence:      session.sequence.next(),
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
 	}
@@ -163,6 +315,8 @@ func (session *session) errorFrame(error Error, payload []byte, trace *TraceConf
 		Type:          ERROR,
 		Operand:       byte(error),
 		Origin:        session.src,
+		Epoch:         session.sequence.epochID(),
+		Sequence:      session.sequence.next(),
 		PayloadLength: (uint32)(len(payload)),
 		Payload:       payload,
 	}
@@ -173,28 +327,68 @@ func (session *session) errorFrame(error Error, payload []byte, trace *TraceConf
 	return
 }
 
+// compress returns a copy of a frame with its payload compressed, or
+// the frame itself if compressing it is not worth it or not negotiated.
+// The frame may be forwarded to several sessions, so it is not modified.
+func (session *session) compress(f *Frame) *Frame {
+	if f.Compressed || len(f.Payload) <= compressionThreshold ||
+		!hasFeature(session.features, PayloadCompression) {
+		return f
+	}
+
+	payload, err := compressPayload(f.Payload)
+	if err != nil || len(payload) >= len(f.Payload) {
+		return f
+	}
+
+	compressed := *f
+	compressed.Payload = payload
+	compressed.PayloadLength = uint32(len(payload))
+	compressed.Compressed = true
+
+	return &compressed
+}
+
 func (session *session) Write(frame interface{}) (int, error) {
 	switch f := frame.(type) {
 	case *Frame:
-		if f.PathTrace() == false {
-			break
+		if f.PathTrace() == true {
+			f.Trace.Path[f.Trace.PathLength-1].TxTimestamp = time.Now()
 		}
 
-		f.Trace.Path[f.Trace.PathLength-1].TxTimestamp = time.Now()
+		frame = session.compress(f)
 	}
 
 	setWriteTimeout(session.conn)
 	err := session.encoder.Encode(frame)
 	clearWriteTimeout(session.conn)
 
+	if err == nil {
+		atomic.AddUint64(&session.counters.framesSent, 1)
+	}
+
 	return 0, err
 }
 
 func (session *session) Read(frame interface{}) error {
 	err := session.decoder.Decode(frame)
+	if err == nil {
+		atomic.AddUint64(&session.counters.framesReceived, 1)
+	}
 
 	switch f := frame.(type) {
 	case *Frame:
+		if err == nil && f.Compressed {
+			payload, dErr := decompressPayload(f.Payload)
+			if dErr != nil {
+				return fmt.Errorf("Invalid compressed payload: %v", dErr)
+			}
+
+			f.Payload = payload
+			f.PayloadLength = uint32(len(payload))
+			f.Compressed = false
+		}
+
 		if f.PathTrace() == false {
 			break
 		}
@@ -212,3 +406,34 @@ func (session *session) Read(frame interface{}) error {
 	return err
 
 }
+
+// throttle delays the handling of the frame just read when the peer
+// sends frames faster than its limiter allows, and returns true if it
+// did.
+func (session *session) throttle() bool {
+	delay := session.limiter.reserve(time.Now())
+	if delay <= 0 {
+		return false
+	}
+
+	atomic.AddUint64(&session.counters.framesThrottled, 1)
+	atomic.AddInt64(&session.counters.throttledTime, int64(delay))
+	time.Sleep(delay)
+
+	return true
+}
+
+func (session *session) stats() PeerStats {
+	return PeerStats{
+		UUID:            session.dest.String(),
+		Role:            session.destRole,
+		Connected:       session.connected,
+		Features:        session.features,
+		FramesReceived:  atomic.LoadUint64(&session.counters.framesReceived),
+		BytesReceived:   atomic.LoadUint64(&session.counters.bytesReceived),
+		FramesSent:      atomic.LoadUint64(&session.counters.framesSent),
+		BytesSent:       atomic.LoadUint64(&session.counters.bytesSent),
+		FramesThrottled: atomic.LoadUint64(&session.counters.framesThrottled),
+		ThrottledTime:   time.Duration(atomic.LoadInt64(&session.counters.throttledTime)),
+	}
+}
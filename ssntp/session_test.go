@@ -0,0 +1,253 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package ssntp
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ciao-project/ciao/uuid"
+)
+
+// Test SSNTP frame sequence numbers
+//
+// Test that frames built from sessions sharing a sequence get
+// increasing sequence numbers and the same epoch, that another sequence
+// has another epoch, and that frames built from a session without a
+// sequence are not numbered.
+//
+// Test is expected to pass.
+func TestFrameSequence(t *testing.T) {
+	src := uuid.Generate()
+	sequence := newFrameSequence()
+
+	s1 := newSession(&src, AGENT, SERVER, nil)
+	s1.sequence = sequence
+	s2 := newSession(&src, AGENT, SERVER, nil)
+	s2.sequence = sequence
+
+	frames := []*Frame{
+		s1.commandFrame(STATS, nil, nil),
+		s1.eventFrame(InstanceDeleted, nil, nil),
+		s2.statusFrame(READY, nil, nil),
+		s2.errorFrame(StartFailure, nil, nil),
+	}
+
+	for i, f := range frames {
+		if f.Origin != src {
+			t.Fatalf("Wrong origin %s for frame %d", f.Origin, i)
+		}
+
+		if f.Sequence == 0 {
+			t.Fatalf("Frame %d not numbered", i)
+		}
+
+		if i > 0 && f.Sequence != frames[i-1].Sequence+1 {
+			t.Fatalf("Frame %d sequence %d does not follow %d", i, f.Sequence, frames[i-1].Sequence)
+		}
+
+		if f.Epoch == 0 || f.Epoch != frames[0].Epoch {
+			t.Fatalf("Frame %d has epoch %d, expected %d", i, f.Epoch, frames[0].Epoch)
+		}
+	}
+
+	if newFrameSequence().epochID() == sequence.epochID() {
+		t.Fatalf("Sequences share epoch %d", sequence.epochID())
+	}
+
+	s3 := newSession(&src, AGENT, SERVER, nil)
+	if f := s3.commandFrame(STATS, nil, nil); f.Sequence != 0 || f.Epoch != 0 {
+		t.Fatalf("Unexpected sequence number %d, epoch %d", f.Sequence, f.Epoch)
+	}
+}
+
+// Test the SSNTP session frame limiter
+//
+// Test that a limiter lets a burst of frames through, then delays the
+// following frames according to its rate, and that no limiter is
+// created without a rate.
+//
+// Test is expected to pass.
+func TestFrameLimiter(t *testing.T) {
+	if newFrameLimiter(0, 10) != nil {
+		t.Fatal("Limiter created without a rate")
+	}
+
+	l := newFrameLimiter(10, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if d := l.reserve(now); d != 0 {
+			t.Fatalf("Frame %d of the burst delayed by %v", i, d)
+		}
+	}
+
+	if d := l.reserve(now); d != 100*time.Millisecond {
+		t.Fatalf("Expected a 100ms delay, got %v", d)
+	}
+
+	if d := l.reserve(now); d != 200*time.Millisecond {
+		t.Fatalf("Expected a 200ms delay, got %v", d)
+	}
+
+	now = now.Add(time.Second)
+	if d := l.reserve(now); d != 0 {
+		t.Fatalf("Frame delayed by %v after the bucket refilled", d)
+	}
+}
+
+// Test the SSNTP session counters
+//
+// Test that the frames and bytes written to a session are counted as
+// sent, and as received by the session reading them.
+//
+// Test is expected to pass.
+func TestSessionCounters(t *testing.T) {
+	src := uuid.Generate()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	sender := newSession(&src, AGENT, SERVER, c1)
+	receiver := newSession(nil, SERVER, AGENT, c2)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, _ = sender.Write(sender.commandFrame(STATS, []byte("stats"), nil))
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		var frame Frame
+		if err := receiver.Read(&frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := receiver.stats()
+
+	if received.FramesReceived != 3 || received.BytesReceived == 0 {
+		t.Fatalf("Unexpected received counters %+v", received)
+	}
+
+	// the last write may not have returned yet
+	for i := 0; i < 100 && sender.stats().FramesSent != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := sender.stats()
+
+	if sent.FramesSent != 3 || sent.BytesSent != received.BytesReceived {
+		t.Fatalf("Unexpected sent counters %+v, received %+v", sent, received)
+	}
+}
+
+func TestNegotiateFeatures(t *testing.T) {
+	config := Config{}
+	if !reflect.DeepEqual(config.enabledFeatures(), supportedFeatures) {
+		t.Fatalf("Unexpected default features %v", config.enabledFeatures())
+	}
+
+	config.DisabledFeatures = []Feature{PayloadCompression}
+	if len(config.enabledFeatures()) != 0 {
+		t.Fatalf("Disabled features negotiated %v", config.enabledFeatures())
+	}
+
+	features := negotiateFeatures(supportedFeatures, nil)
+	if len(features) != 0 {
+		t.Fatalf("Features negotiated with a legacy peer %v", features)
+	}
+
+	features = negotiateFeatures(supportedFeatures, []Feature{"unknown", PayloadCompression})
+	if !reflect.DeepEqual(features, []Feature{PayloadCompression}) {
+		t.Fatalf("Unexpected negotiated features %v", features)
+	}
+}
+
+func testPayloadCompression(t *testing.T, features []Feature, compressed bool) {
+	src := uuid.Generate()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	sender := newSession(&src, AGENT, SERVER, c1)
+	sender.features = features
+	receiver := newSession(nil, SERVER, AGENT, c2)
+	receiver.features = features
+
+	payload := bytes.Repeat([]byte("stats "), compressionThreshold)
+	frame := sender.commandFrame(STATS, payload, nil)
+
+	go func() {
+		_, _ = sender.Write(frame)
+	}()
+
+	var received Frame
+	if err := receiver.Read(&received); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received.Payload, payload) ||
+		received.PayloadLength != uint32(len(payload)) || received.Compressed {
+		t.Fatalf("Corrupted payload, length %d", received.PayloadLength)
+	}
+
+	if frame.Compressed || !bytes.Equal(frame.Payload, payload) {
+		t.Fatalf("Sent frame modified")
+	}
+
+	wire := receiver.stats().BytesReceived
+	if compressed != (wire < uint64(len(payload))) {
+		t.Fatalf("Unexpected wire size %d for a %d bytes payload", wire, len(payload))
+	}
+}
+
+func TestPayloadCompression(t *testing.T) {
+	testPayloadCompression(t, []Feature{PayloadCompression}, true)
+}
+
+func TestPayloadNoCompression(t *testing.T) {
+	testPayloadCompression(t, nil, false)
+}
+
+// Test SSNTP payload decompression limit
+//
+// Test that a compressed payload expanding beyond maxPayloadSize is
+// rejected.
+//
+// Test is expected to pass.
+func TestDecompressPayloadLimit(t *testing.T) {
+	payload, err := compressPayload(make([]byte, maxPayloadSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decompressPayload(payload); err != nil {
+		t.Fatalf("Payload of the maximum size rejected: %v", err)
+	}
+
+	payload, err = compressPayload(make([]byte, maxPayloadSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decompressPayload(payload); err == nil {
+		t.Fatal("Oversized payload accepted")
+	}
+}
@@ -228,6 +228,89 @@ const (
 	//	|       |       | (0x0) |  (0x4)  |                 |                             |
 	//	+---------------------------------------------------------------------------------+
 	Restore
+
+	// CacheImage is a command sent by the Controller to ask a specific CIAO agent to
+	// download a container image ahead of the instances using it, so that these
+	// instances start without waiting for the image to be downloaded.
+	//
+	// The CacheImage command payload includes the image name and the UUID of the node.
+	//
+	//                                       SSNTP CacheImage Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xb)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	CacheImage
+
+	// NetworkPolicy is a command sent by the Controller to ask a CNCI to filter
+	// the traffic it routes between the subnets of its tenant.
+	//
+	// The NetworkPolicy command payload includes the CNCI UUID, the tenant network
+	// and the rules allowing the traffic between subnets.
+	//
+	//                                      SSNTP NetworkPolicy Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xc)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	NetworkPolicy
+
+	// HotAdd is a command sent by the Controller to ask a specific CIAO agent to
+	// add vCPUs and memory to a running instance, without restarting it.
+	//
+	// The HotAdd command payload includes the instance UUID, the UUID of the node
+	// running it and the number of vCPUs and MiB of memory to add.
+	//
+	//                                         SSNTP HotAdd Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xd)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	HotAdd
+
+	// RestartAgent is a command sent by the Controller to ask a specific CIAO
+	// agent to restart its service. The agent acknowledges the command with a
+	// NodeCommandResult event before going down. Running instances are not
+	// affected.
+	//
+	// The RestartAgent command payload includes the command identifier and
+	// the UUID of the node running the agent.
+	//
+	//                                     SSNTP RestartAgent Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xe)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	RestartAgent
+
+	// SelfTest is a command sent by the Controller to ask a specific CIAO agent
+	// to run its connectivity self-test. The agent reports the outcome of each
+	// check through a NodeCommandResult event.
+	//
+	// The SelfTest command payload includes the command identifier and the
+	// UUID of the node running the agent.
+	//
+	//                                         SSNTP SelfTest Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xf)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	SelfTest
+
+	// Reconfigure is a command sent by the Controller to ask the Scheduler to
+	// send its current cluster configuration to a specific CIAO agent again.
+	// The Scheduler does not forward this command but replies on its behalf
+	// with a CONFIGURE command sent to the agent.
+	//
+	// The Reconfigure command payload includes the command identifier and the
+	// UUID of the node running the agent.
+	//
+	//                                      SSNTP Reconfigure Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0x10) |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	Reconfigure
 )
 
 const (
@@ -442,6 +525,41 @@ const (
 	//	|       |       | (0x3) |  (0x2)  |                 | instance information  |
 	//	+---------------------------------------------------------------------------+
 	InstanceStopped
+
+	// NodeCommandResult events are sent by workload agents to report the
+	// outcome of a node command, i.e. RestartAgent or SelfTest, back to the
+	// Controller. They also acknowledge the successful completion of the
+	// other commands carrying a command identifier, e.g. START, EVACUATE
+	// or AttachVolume, whose failures are reported by the matching error.
+	// The NodeCommandResult event payload contains the command identifier,
+	// the node UUID, an optional error and, for self-tests, the result of
+	// each check.
+	//
+	//					 SSNTP NodeCommandResult Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xa)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	NodeCommandResult
+
+	// TrafficReport events are sent periodically by Networking concentrator
+	// instances (CNCI) to report the traffic they routed between the tenant
+	// instances and the external network.
+	//
+	// The Scheduler must forward those events to the Controller.
+	//
+	// The TrafficReport event payload contains the CNCI and tenant UUIDs
+	// and, for each instance private IP, the cumulative number of bytes and
+	// packets received from and sent to the external network.
+	//
+	//					 SSNTP TrafficReport Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xb)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	TrafficReport
 )
 
 // SSNTP clients and servers can have one or several roles and are expected to declare their
@@ -527,11 +645,15 @@ const (
 	// UnassignPublicIPFailure is sent by the CNCI when a an external IP
 	// cannot be unassigned.
 	UnassignPublicIPFailure
+
+	// HotAddFailure is sent by launcher agents to report a failure to add
+	// vCPUs or memory to a running instance.
+	HotAddFailure
 )
 
 // Major is the SSNTP protocol major version
 const Major = 0
-const minor = 1
+const minor = 2
 const defaultURL = "localhost"
 const port = 8888
 const readTimeout = 30
@@ -578,6 +700,18 @@ func (command Command) String() string {
 		return "Attach storage volume"
 	case Restore:
 		return "Restore"
+	case CacheImage:
+		return "Cache image"
+	case NetworkPolicy:
+		return "Network policy"
+	case HotAdd:
+		return "Hot add resources"
+	case RestartAgent:
+		return "Restart agent"
+	case SelfTest:
+		return "Self test"
+	case Reconfigure:
+		return "Reconfigure"
 	}
 
 	return ""
@@ -622,6 +756,10 @@ func (status Event) String() string {
 		return "Node Connected"
 	case NodeDisconnected:
 		return "Node Disconnected"
+	case NodeCommandResult:
+		return "Node Command Result"
+	case TrafficReport:
+		return "Traffic Report"
 	}
 
 	return ""
@@ -859,6 +997,23 @@ type Config struct {
 	// used by the underlying TLS session.  If Rand is nil, the default
 	// random number generator for the TLS package will be used.
 	Rand io.Reader
+
+	// PeerFrameRate limits the rate, in frames per second, at which an
+	// SSNTP server handles the frames of each of its AGENT and NETAGENT
+	// clients.  The server stops reading from a client exceeding it
+	// until its rate is back under the limit, pushing back on the
+	// client without delaying the frames of the other clients.
+	// This is optional, the default of 0 does not limit the rate.
+	PeerFrameRate float64
+
+	// PeerFrameBurst is the number of frames a client can send at once
+	// above PeerFrameRate.  It defaults to one second worth of frames.
+	PeerFrameBurst int
+
+	// DisabledFeatures lists the optional SSNTP features the client or
+	// server must not negotiate with its peers, all supported features
+	// being negotiated by default.
+	DisabledFeatures []Feature
 }
 
 // Logger is an interface for SSNTP users to define their own
@@ -1968,6 +1968,87 @@ func TestOriginUUID(t *testing.T) {
 	server.ssntp.Stop()
 }
 
+// Test SSNTP cluster configuration resend
+//
+// Start an SSNTP server, an SSNTP agent and an SSNTP Controller, and
+// verify that the server can not resend a configuration it does not
+// have. Then let the Controller push a configuration to the server and
+// check that the server can send it again to the agent through a
+// CONFIGURE command.
+//
+// Test is expected to pass.
+func TestSendConfiguration(t *testing.T) {
+	var server ssntpServer
+	var controller, agent ssntpClient
+
+	server.t = t
+	serverConfig, err := buildTestConfig(SCHEDULER)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	controller.t = t
+	controllerConfig, err := buildTestConfig(Controller)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+
+	agent.t = t
+	agent.cmdChannel = make(chan string)
+	agentConfig, err := buildTestConfig(AGENT)
+	if err != nil {
+		t.Fatalf("Could not build a test config")
+	}
+	agentConfig.UUID = agentUUID
+
+	err = server.ssntp.ServeThreadSync(serverConfig, &server)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer server.ssntp.Stop()
+
+	err = controller.ssntp.Dial(controllerConfig, &controller)
+	if err != nil {
+		t.Fatalf("Controller failed to connect")
+	}
+	defer controller.ssntp.Close()
+
+	err = agent.ssntp.Dial(agentConfig, &agent)
+	if err != nil {
+		t.Fatalf("Agent failed to connect")
+	}
+	defer agent.ssntp.Close()
+
+	if _, err := server.ssntp.SendConfiguration(agentUUID); err == nil {
+		t.Fatalf("Configuration sent while none is available")
+	}
+
+	payload := []byte{'C', 'O', 'N', 'F', 'I', 'G'}
+	agent.payload = payload
+	controller.ssntp.SendCommand(CONFIGURE, payload)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = server.ssntp.SendConfiguration(agentUUID)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Could not send configuration: %v", err)
+	}
+
+	select {
+	case check := <-agent.cmdChannel:
+		if check != CONFIGURE.String() {
+			t.Fatalf("Did not receive a CONFIGURE command")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive the configuration")
+	}
+}
+
 // Test SSNTP Command forwarder implementation
 //
 // Start an SSNTP server with a set of forwarding rules implemented
@@ -2477,6 +2558,9 @@ func TestCommandStringer(t *testing.T) {
 		{ReleasePublicIP, "Release public IP"},
 		{CONFIGURE, "CONFIGURE"},
 		{AttachVolume, "Attach storage volume"},
+		{RestartAgent, "Restart agent"},
+		{SelfTest, "Self test"},
+		{Reconfigure, "Reconfigure"},
 	}
 
 	for _, test := range stringTests {
@@ -2520,6 +2604,8 @@ func TestEventStringer(t *testing.T) {
 		{TraceReport, "Trace Report"},
 		{NodeConnected, "Node Connected"},
 		{NodeDisconnected, "Node Disconnected"},
+		{NodeCommandResult, "Node Command Result"},
+		{TrafficReport, "Traffic Report"},
 	}
 
 	for _, test := range stringTests {
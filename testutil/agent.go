@@ -341,7 +341,7 @@ func (client *SsntpTestClient) handleStart(payload []byte) Result {
 
 	if client.StartFail == true {
 		result.Err = errors.New(client.StartFailReason.String())
-		client.sendStartFailure(cmd.Start.InstanceUUID, client.StartFailReason)
+		client.sendStartFailure(cmd.Start.InstanceUUID, cmd.Start.CommandID, client.StartFailReason)
 		go client.SendResultAndDelErrorChan(ssntp.StartFailure, result)
 		return result
 	}
@@ -357,6 +357,8 @@ func (client *SsntpTestClient) handleStart(payload []byte) Result {
 	client.instancesLock.Lock()
 	client.instances = append(client.instances, istat)
 	client.instancesLock.Unlock()
+
+	client.sendCommandAck(cmd.Start.CommandID)
 	return result
 }
 
@@ -372,7 +374,7 @@ func (client *SsntpTestClient) handleDelete(payload []byte) Result {
 
 	if client.DeleteFail == true {
 		result.Err = errors.New(client.DeleteFailReason.String())
-		client.sendDeleteFailure(cmd.Delete.InstanceUUID, client.DeleteFailReason)
+		client.sendDeleteFailure(cmd.Delete.InstanceUUID, cmd.Delete.CommandID, client.DeleteFailReason)
 		go client.SendResultAndDelErrorChan(ssntp.DeleteFailure, result)
 		return result
 	}
@@ -402,7 +404,7 @@ func (client *SsntpTestClient) handleAttachVolume(payload []byte) Result {
 
 	if client.AttachFail == true {
 		result.Err = errors.New(client.AttachVolumeFailReason.String())
-		client.sendAttachVolumeFailure(cmd.Attach.InstanceUUID, cmd.Attach.VolumeUUID, client.AttachVolumeFailReason)
+		client.sendAttachVolumeFailure(cmd.Attach.InstanceUUID, cmd.Attach.VolumeUUID, cmd.Attach.CommandID, client.AttachVolumeFailReason)
 		client.SendResultAndDelErrorChan(ssntp.AttachVolumeFailure, result)
 		return result
 	}
@@ -416,6 +418,63 @@ func (client *SsntpTestClient) handleAttachVolume(payload []byte) Result {
 	}
 	client.instancesLock.Unlock()
 
+	client.sendCommandAck(cmd.Attach.CommandID)
+	return result
+}
+
+func (client *SsntpTestClient) handleNodeCommand(command ssntp.Command, payload []byte) Result {
+	var result Result
+	var commandID string
+
+	switch command {
+	case ssntp.RestartAgent:
+		var cmd payloads.RestartAgent
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		commandID = cmd.RestartAgent.CommandID
+	case ssntp.SelfTest:
+		var cmd payloads.SelfTest
+		result.Err = yaml.Unmarshal(payload, &cmd)
+		commandID = cmd.SelfTest.CommandID
+	}
+
+	if result.Err != nil {
+		return result
+	}
+
+	evt := payloads.EventNodeCommandResult{
+		NodeCommandResult: payloads.NodeCommandResultEvent{
+			CommandID: commandID,
+			NodeUUID:  client.UUID,
+		},
+	}
+
+	if command == ssntp.SelfTest {
+		evt.NodeCommandResult.Checks = []payloads.SelfTestCheck{
+			{Name: "scheduler", Success: true},
+		}
+	}
+
+	y, err := yaml.Marshal(&evt)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	_, result.Err = client.Ssntp.SendEvent(ssntp.NodeCommandResult, y)
+	return result
+}
+
+func (client *SsntpTestClient) handleEvacuate(payload []byte) Result {
+	var result Result
+	var cmd payloads.Evacuate
+
+	result.Err = yaml.Unmarshal(payload, &cmd)
+	if result.Err != nil {
+		return result
+	}
+
+	result.NodeUUID = cmd.Evacuate.WorkloadAgentUUID
+	client.sendCommandAck(cmd.Evacuate.CommandID)
 	return result
 }
 
@@ -436,7 +495,6 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	/* FIXME: implement
 	case ssntp.CONNECT:
 	case ssntp.STATS:
-	case ssntp.EVACUATE:
 	case ssntp.AssignPublicIP:
 	case ssntp.ReleasePublicIP:
 	case ssntp.CONFIGURE:
@@ -450,6 +508,14 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	case ssntp.AttachVolume:
 		result = client.handleAttachVolume(payload)
 
+	case ssntp.EVACUATE:
+		result = client.handleEvacuate(payload)
+
+	case ssntp.RestartAgent:
+		fallthrough
+	case ssntp.SelfTest:
+		result = client.handleNodeCommand(command, payload)
+
 	default:
 		fmt.Fprintf(os.Stderr, "client %s unhandled command %s\n", client.Role.String(), command.String())
 	}
@@ -664,6 +730,18 @@ func (client *SsntpTestClient) SendPublicIPUnassignedEvent() {
 	go client.SendResultAndDelEventChan(ssntp.PublicIPUnassigned, result)
 }
 
+// SendTrafficReportEvent allows an SsntpTestClient to push an ssntp.TrafficReport event frame
+func (client *SsntpTestClient) SendTrafficReportEvent() {
+	var result Result
+
+	_, err := client.Ssntp.SendEvent(ssntp.TrafficReport, []byte(TrafficReportYaml))
+	if err != nil {
+		result.Err = err
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.TrafficReport, result)
+}
+
 // SendConcentratorAddedEvent allows an SsntpTestClient to push an ssntp.ConcentratorInstanceAdded event frame
 func (client *SsntpTestClient) SendConcentratorAddedEvent(instanceUUID string, tenantUUID string, ip string, vnicMAC string) {
 	var result Result
@@ -693,10 +771,36 @@ func (client *SsntpTestClient) SendConcentratorAddedEvent(instanceUUID string, t
 	go client.SendResultAndDelEventChan(ssntp.ConcentratorInstanceAdded, result)
 }
 
-func (client *SsntpTestClient) sendStartFailure(instanceUUID string, reason payloads.StartFailureReason) {
+// sendCommandAck acknowledges the successful completion of a command
+// carrying an identifier, as launcher does.
+func (client *SsntpTestClient) sendCommandAck(commandID string) {
+	if commandID == "" {
+		return
+	}
+
+	evt := payloads.EventNodeCommandResult{
+		NodeCommandResult: payloads.NodeCommandResultEvent{
+			CommandID: commandID,
+			NodeUUID:  client.UUID,
+		},
+	}
+
+	y, err := yaml.Marshal(&evt)
+	if err != nil {
+		return
+	}
+
+	_, err = client.Ssntp.SendEvent(ssntp.NodeCommandResult, y)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func (client *SsntpTestClient) sendStartFailure(instanceUUID string, commandID string, reason payloads.StartFailureReason) {
 	e := payloads.ErrorStartFailure{
 		InstanceUUID: instanceUUID,
 		Reason:       reason,
+		CommandID:    commandID,
 	}
 
 	y, err := yaml.Marshal(e)
@@ -710,10 +814,11 @@ func (client *SsntpTestClient) sendStartFailure(instanceUUID string, reason payl
 	}
 }
 
-func (client *SsntpTestClient) sendDeleteFailure(instanceUUID string, reason payloads.DeleteFailureReason) {
+func (client *SsntpTestClient) sendDeleteFailure(instanceUUID string, commandID string, reason payloads.DeleteFailureReason) {
 	e := payloads.ErrorDeleteFailure{
 		InstanceUUID: instanceUUID,
 		Reason:       reason,
+		CommandID:    commandID,
 	}
 
 	y, err := yaml.Marshal(e)
@@ -727,11 +832,12 @@ func (client *SsntpTestClient) sendDeleteFailure(instanceUUID string, reason pay
 	}
 }
 
-func (client *SsntpTestClient) sendAttachVolumeFailure(instanceUUID string, volumeUUID string, reason payloads.AttachVolumeFailureReason) {
+func (client *SsntpTestClient) sendAttachVolumeFailure(instanceUUID string, volumeUUID string, commandID string, reason payloads.AttachVolumeFailureReason) {
 	e := payloads.ErrorAttachVolumeFailure{
 		InstanceUUID: instanceUUID,
 		VolumeUUID:   volumeUUID,
 		Reason:       reason,
+		CommandID:    commandID,
 	}
 
 	y, err := yaml.Marshal(e)
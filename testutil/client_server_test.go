@@ -516,6 +516,22 @@ func TestPublicIPAssigned(t *testing.T) {
 	}
 }
 
+func TestTrafficReport(t *testing.T) {
+	serverCh := server.AddEventChan(ssntp.TrafficReport)
+	controllerCh := controller.AddEventChan(ssntp.TrafficReport)
+
+	go cnciAgent.SendTrafficReportEvent()
+
+	_, err := server.GetEventChanResult(serverCh, ssntp.TrafficReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = controller.GetEventChanResult(controllerCh, ssntp.TrafficReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestMain(m *testing.M) {
 	var err error
 
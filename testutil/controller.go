@@ -323,6 +323,10 @@ func (ctl *SsntpTestController) EventNotify(event ssntp.Event, frame *ssntp.Fram
 		if err != nil {
 			result.Err = err
 		}
+	case ssntp.TrafficReport:
+		var trafficEvent payloads.EventTrafficReport
+
+		result.Err = yaml.Unmarshal(frame.Payload, &trafficEvent)
 	default:
 		fmt.Fprintf(os.Stderr, "controller unhandled event: %s\n", event.String())
 	}
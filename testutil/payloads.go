@@ -227,6 +227,12 @@ const RestoreYaml = `restore:
   workload_agent_uuid: ` + AgentUUID + `
 `
 
+// CacheImageYaml is a sample node CacheImage ssntp.Command payload for test cases
+const CacheImageYaml = `cache_image:
+  workload_agent_uuid: ` + AgentUUID + `
+  image: ubuntu:16.04
+`
+
 // CNCIAddedYaml is a sample ConcentratorInstanceAdded ssntp.Event payload for test cases
 const CNCIAddedYaml = `concentrator_instance_added:
   instance_uuid: ` + CNCIUUID + `
@@ -235,6 +241,17 @@ const CNCIAddedYaml = `concentrator_instance_added:
   concentrator_mac: ` + CNCIMAC + `
 `
 
+// NetworkPolicyYaml is a sample NetworkPolicy ssntp.Command payload for test cases
+const NetworkPolicyYaml = `network_policy:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  tenant_network: 172.16.0.0/12
+  allow_all: false
+  rules:
+  - source: 172.16.0.0/24
+    destination: 172.16.1.0/24
+`
+
 // AssignIPYaml is a sample AssignPublicIP ssntp.Command payload for test cases
 const AssignIPYaml = `assign_public_ip:
   concentrator_uuid: ` + CNCIUUID + `
@@ -312,6 +329,8 @@ const ConfigureYaml = `configure:
     cnci_disk: 0
     admin_ssh_key: ""
     client_auth_ca_cert_path: ""
+    compress_responses: false
+    disable_http2: false
   launcher:
     compute_net:
     - ` + ComputeNet + `
@@ -530,3 +549,66 @@ instance_uuid: ` + InstanceUUID + `
 volume_uuid: ` + VolumeUUID + `
 reason: attach_failure
 `
+
+// HotAddYaml is a sample yaml payload for the ssntp HotAdd command.
+const HotAddYaml = `hot_add:
+  instance_uuid: ` + InstanceUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  vcpus: 2
+  mem_mb: 512
+`
+
+// BadHotAddYaml is a corrupt yaml payload for the ssntp HotAdd command.
+const BadHotAddYaml = `hot_add:
+  vcpus: 2
+`
+
+// HotAddFailureYaml is a sample HotAddFailure ssntp.Error payload for test cases
+const HotAddFailureYaml = `node_uuid: ` + AgentUUID + `
+instance_uuid: ` + InstanceUUID + `
+vcpus: 2
+mem_mb: 512
+reason: hot_add_failure
+`
+
+// NodeCommandID is a node command identifier used in test cases
+const NodeCommandID = "9b6a3d14-54a6-4a9d-9f6c-3c1f0e6b2a7d"
+
+// SelfTestYaml is a sample yaml payload for the ssntp SelfTest command.
+const SelfTestYaml = `self_test:
+  command_id: ` + NodeCommandID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// RestartAgentYaml is a sample yaml payload for the ssntp RestartAgent command.
+const RestartAgentYaml = `restart_agent:
+  command_id: ` + NodeCommandID + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
+// NodeCommandResultYaml is a sample yaml payload for the ssntp
+// NodeCommandResult event.
+const NodeCommandResultYaml = `node_command_result:
+  command_id: ` + NodeCommandID + `
+  node_uuid: ` + AgentUUID + `
+  error: 1 of 2 checks failed
+  checks:
+  - name: scheduler
+    success: true
+  - name: instances
+    success: false
+    message: not writable
+`
+
+// TrafficReportYaml is a sample yaml payload for the ssntp TrafficReport
+// event.
+const TrafficReportYaml = `traffic_report:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  counters:
+  - private_ip: ` + InstancePrivateIP + `
+    rx_bytes: 2048
+    rx_packets: 4
+    tx_bytes: 1024
+    tx_packets: 2
+`
@@ -357,6 +357,16 @@ func getRestoreResults(payload []byte, result *Result) {
 	}
 }
 
+func getCacheImageResults(payload []byte, result *Result) {
+	var cacheCmd payloads.CacheImage
+
+	err := yaml.Unmarshal(payload, &cacheCmd)
+	result.Err = err
+	if err == nil {
+		result.NodeUUID = cacheCmd.CacheImage.WorkloadAgentUUID
+	}
+}
+
 // CommandNotify implements an SSNTP CommandNotify callback for SsntpTestServer
 func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command, frame *ssntp.Frame) {
 	var result Result
@@ -389,6 +399,9 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 	case ssntp.Restore:
 		getRestoreResults(payload, &result)
 
+	case ssntp.CacheImage:
+		getCacheImageResults(payload, &result)
+
 	case ssntp.STATS:
 		var statsCmd payloads.Stat
 
@@ -398,6 +411,15 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 	case ssntp.AttachVolume:
 		getAttachVolumeResult(payload, &result)
 
+	case ssntp.NetworkPolicy:
+		var policyCmd payloads.CommandNetworkPolicy
+
+		err := yaml.Unmarshal(payload, &policyCmd)
+		result.Err = err
+		if err == nil {
+			result.TenantUUID = policyCmd.Policy.TenantUUID
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "server unhandled command %s\n", command.String())
 	}
@@ -438,6 +460,8 @@ func (server *SsntpTestServer) EventNotify(uuid string, event ssntp.Event, frame
 		// forwards to CNCI via server.EventForward()
 	case ssntp.PublicIPAssigned:
 		// forwards from CNCI Controller(s) via server.EventForward()
+	case ssntp.TrafficReport:
+		// forward rule auto-sends to controllers
 	default:
 		fmt.Fprintf(os.Stderr, "server unhandled event %s\n", event.String())
 	}
@@ -576,6 +600,43 @@ func (server *SsntpTestServer) handleAttachVolume(payload []byte) ssntp.ForwardD
 	return dest
 }
 
+func (server *SsntpTestServer) handleNodeCommand(command ssntp.Command, payload []byte) ssntp.ForwardDestination {
+	var dest ssntp.ForwardDestination
+	var agentUUID string
+
+	switch command {
+	case ssntp.RestartAgent:
+		var cmd payloads.RestartAgent
+		if err := yaml.Unmarshal(payload, &cmd); err != nil {
+			return dest
+		}
+		agentUUID = cmd.RestartAgent.WorkloadAgentUUID
+	case ssntp.SelfTest:
+		var cmd payloads.SelfTest
+		if err := yaml.Unmarshal(payload, &cmd); err != nil {
+			return dest
+		}
+		agentUUID = cmd.SelfTest.WorkloadAgentUUID
+	case ssntp.EVACUATE:
+		var cmd payloads.Evacuate
+		if err := yaml.Unmarshal(payload, &cmd); err != nil {
+			return dest
+		}
+		agentUUID = cmd.Evacuate.WorkloadAgentUUID
+	}
+
+	server.clientsLock.Lock()
+	defer server.clientsLock.Unlock()
+
+	for _, c := range server.clients {
+		if c == agentUUID {
+			dest.AddRecipient(c)
+		}
+	}
+
+	return dest
+}
+
 // CommandForward implements an SSNTP CommandForward callback for SsntpTestServer
 func (server *SsntpTestServer) CommandForward(uuid string, command ssntp.Command, frame *ssntp.Frame) (dest ssntp.ForwardDestination) {
 	payload := frame.Payload
@@ -585,8 +646,12 @@ func (server *SsntpTestServer) CommandForward(uuid string, command ssntp.Command
 		dest = server.handleStart(payload)
 	case ssntp.AttachVolume:
 		dest = server.handleAttachVolume(payload)
+	case ssntp.RestartAgent:
+		fallthrough
 	case ssntp.EVACUATE:
 		fallthrough
+	case ssntp.SelfTest:
+		dest = server.handleNodeCommand(command, payload)
 	case ssntp.DELETE:
 		fallthrough
 	default:
@@ -680,6 +745,22 @@ func StartTestServer() *SsntpTestServer {
 				Operand:        ssntp.AttachVolume,
 				CommandForward: server,
 			},
+			{ // all RestartAgent commands are processed by the Command forwarder
+				Operand:        ssntp.RestartAgent,
+				CommandForward: server,
+			},
+			{ // all SelfTest commands are processed by the Command forwarder
+				Operand:        ssntp.SelfTest,
+				CommandForward: server,
+			},
+			{ // all NodeCommandResult events go to all Controllers
+				Operand: ssntp.NodeCommandResult,
+				Dest:    ssntp.Controller,
+			},
+			{ // all TrafficReport events go to all Controllers
+				Operand: ssntp.TrafficReport,
+				Dest:    ssntp.Controller,
+			},
 		},
 	}
 